@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// applyChange is the JSON schema of a change in apply.
+type applyChange struct {
+	Kind            string   `json:"kind"`
+	Name            string   `json:"name"`
+	Action          string   `json:"action"`
+	Fields          []string `json:"fields,omitempty"`
+	InitialPassword string   `json:"initial_password,omitempty"`
+}
+
+// applyResult is the JSON schema of apply.
+type applyResult struct {
+	DryRun  bool          `json:"dry_run"`
+	Changes []applyChange `json:"changes"`
+	Applied int           `json:"applied"`
+}
+
+// handleApply sends a declarative YAML or JSON document to the server.
+func handleApply(args []string) {
+	fs := newFlagSet("apply")
+	file := fs.String("f", "", "Document to apply, - for stdin")
+	dryRun := fs.Bool("dry-run", false, "Only show the changes")
+	prune := fs.Bool("prune", false, "Delete resources the document does not list")
+	fs.Parse(args)
+
+	if *file == "" {
+		fatalf("Usage: cyp-cli apply -f <file.yaml|file.json|-> [-dry-run] [-prune]")
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		fatalf("Error reading document: %v", err)
+	}
+
+	query := url.Values{}
+	if *dryRun {
+		query.Set("dry_run", "true")
+	}
+	if *prune {
+		query.Set("prune", "true")
+	}
+	path := "/api/v1/admin/apply"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, serverURL()+path, bytes.NewReader(data))
+	if err != nil {
+		fatal(err)
+	}
+	contentType := "application/yaml"
+	if strings.EqualFold(filepath.Ext(*file), ".json") {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := doRequest(req)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "apply document")
+
+	var out applyResult
+	convert(result["data"], &out)
+	if out.Changes == nil {
+		out.Changes = []applyChange{}
+	}
+
+	switch {
+	case jsonOutput():
+		printJSON(out)
+		return
+	case quietOutput():
+		return
+	}
+
+	if len(out.Changes) == 0 {
+		fmt.Println("No changes")
+		return
+	}
+	symbols := map[string]string{"create": "+", "update": "~", "delete": "-"}
+	for _, ch := range out.Changes {
+		line := fmt.Sprintf("%s %s %s", symbols[ch.Action], ch.Kind, ch.Name)
+		if len(ch.Fields) > 0 {
+			line += " (" + strings.Join(ch.Fields, ", ") + ")"
+		}
+		fmt.Println(line)
+		if ch.InitialPassword != "" {
+			fmt.Printf("    initial password: %s\n", ch.InitialPassword)
+		}
+	}
+	if out.DryRun {
+		fmt.Printf("%d changes planned (dry run)\n", len(out.Changes))
+	} else {
+		fmt.Printf("%d changes applied\n", out.Applied)
+	}
+}
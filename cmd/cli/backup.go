@@ -0,0 +1,304 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// backupPhases names the phases of backup_progress events.
+var backupPhases = map[string]string{
+	"archive": "Archiving",
+	"verify":  "Verifying",
+	"extract": "Extracting",
+}
+
+func handleBackup(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli backup <create|list|verify|download|restore|delete>")
+		os.Exit(1)
+	}
+
+	if args[0] != "create" && args[0] != "list" && len(args) < 2 {
+		fatalf("Usage: cyp-cli backup %s <id>", args[0])
+	}
+
+	switch args[0] {
+	case "create":
+		fs := newFlagSet("backup create")
+		blobs := fs.Bool("blobs", false, "Include image blobs")
+		quiet := fs.Bool("quiet", false, "Do not show progress")
+		fs.Parse(args[1:])
+		createBackup(*blobs, *quiet)
+	case "list":
+		fs := newFlagSet("backup list")
+		format := addFormatFlag(fs)
+		fs.Parse(args[1:])
+		listBackups(*format)
+	case "verify":
+		verifyBackup(args[1])
+	case "download":
+		downloadBackup(args[1])
+	case "restore":
+		fs := newFlagSet("backup restore")
+		quiet := fs.Bool("quiet", false, "Do not show progress")
+		rest := parseArgs(fs, args[1:])
+		if len(rest) < 1 {
+			fmt.Println("Usage: cyp-cli backup restore <id> [-quiet]")
+			os.Exit(1)
+		}
+		restoreBackup(rest[0], *quiet)
+	case "delete":
+		deleteBackup(args[1])
+	default:
+		fatalf("Unknown backup command: %s", args[0])
+	}
+}
+
+// showBackupProgress renders the progress events of the backup id, or of
+// any backup while id is empty since a new backup's id is not known yet.
+// final is the event ending the operation.
+func showBackupProgress(id, final string, bar *progressBar) func(event string, data map[string]interface{}) bool {
+	return func(event string, data map[string]interface{}) bool {
+		if id != "" && data["id"] != id {
+			return false
+		}
+		done, _ := data["bytes"].(float64)
+		total, _ := data["total"].(float64)
+		switch event {
+		case "backup_progress":
+			phase, _ := data["phase"].(string)
+			if label, ok := backupPhases[phase]; ok {
+				bar.update(label, done, total, true)
+			}
+		case "backup_upload_progress":
+			bar.update(fmt.Sprintf("Uploading to %v", data["target"]), done, total, true)
+		}
+		return event == final
+	}
+}
+
+func createBackup(includeBlobs, quiet bool) {
+	body := jsonBody(backupRequest{IncludeBlobs: includeBlobs})
+
+	info("Creating backup...\n")
+	bar := newProgressBar(quiet || quietOutput())
+	stop := watchSystemEvents(showBackupProgress("", "backup_created", bar))
+	resp, err := apiRequest(http.MethodPost, "/api/v1/system/backups", body)
+	stop()
+	bar.done()
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "create backup")
+
+	b, _ := result["backup"].(map[string]interface{})
+	switch {
+	case jsonOutput():
+		printJSON(b)
+	case quietOutput():
+		fmt.Println(b["id"])
+	default:
+		fmt.Printf("Backup created: %v\n", b["id"])
+		fmt.Printf("Size: %s\n", formatSize(b["size"]))
+		fmt.Printf("SHA256: %v\n", b["checksum"])
+	}
+
+	// 远程目标复制失败时本地备份仍然有效，但要让定时任务感知到
+	failed := 0
+	targets, _ := b["targets"].([]interface{})
+	for _, item := range targets {
+		if t, ok := item.(map[string]interface{}); ok {
+			if t["status"] == "success" {
+				info("Uploaded to %v (verified)\n", t["target"])
+			} else {
+				info("Upload to %v failed: %v\n", t["target"], t["error"])
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func listBackups(format string) {
+	resp, err := apiRequest(http.MethodGet, "/api/v1/system/backups", nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "list backups")
+
+	backups, _ := result["backups"].([]interface{})
+	if format == "json" {
+		printJSON(backups)
+		return
+	}
+	if quietOutput() {
+		for _, item := range backups {
+			if b, ok := item.(map[string]interface{}); ok {
+				fmt.Println(b["id"])
+			}
+		}
+		return
+	}
+	if len(backups) == 0 {
+		fmt.Println("No backups found")
+		return
+	}
+
+	fmt.Printf("%-28s %-12s %s\n", "ID", "SIZE", "CREATED")
+	for _, item := range backups {
+		if b, ok := item.(map[string]interface{}); ok {
+			fmt.Printf("%-28v %-12s %s\n", b["id"], formatSize(b["size"]), shortTime(b["created_at"]))
+		}
+	}
+}
+
+func verifyBackup(id string) {
+	resp, err := apiRequest(http.MethodPost, "/api/v1/system/backups/"+url.PathEscape(id)+"/verify", nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "verify backup")
+
+	report(map[string]interface{}{"id": id, "valid": true, "file_count": result["file_count"]},
+		"Backup %s is valid (%v files)\n", id, result["file_count"])
+}
+
+func downloadBackup(id string) {
+	resp, err := apiRequest(http.MethodGet, "/api/v1/system/backups/"+url.PathEscape(id)+"/download", nil)
+	if err != nil {
+		fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		decodeResponse(resp, "download backup")
+		return
+	}
+	defer resp.Body.Close()
+
+	filename := id + ".tar.zst"
+	file, err := os.Create(filename)
+	if err != nil {
+		fatalf("Error creating file: %v", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(file, hash), resp.Body); err != nil {
+		fatalf("Error writing file: %v", err)
+	}
+
+	// 校验下载内容与服务端记录的校验和一致
+	if expected := resp.Header.Get("X-Checksum-SHA256"); expected != "" {
+		if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+			fatalf("Checksum mismatch: expected %s, got %s", expected, actual)
+		}
+	}
+
+	report(map[string]string{"id": id, "file": filename}, "Backup downloaded to %s\n", filename)
+}
+
+func restoreBackup(id string, quiet bool) {
+	info("Restoring backup %s (archive checksums are verified first)...\n", id)
+	bar := newProgressBar(quiet || quietOutput())
+	stop := watchSystemEvents(showBackupProgress(id, "backup_restored", bar))
+	resp, err := apiRequest(http.MethodPost, "/api/v1/system/backups/"+url.PathEscape(id)+"/restore", nil)
+	stop()
+	bar.done()
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "restore backup")
+
+	report(map[string]interface{}{"id": id, "file_count": result["file_count"]},
+		"Backup restored successfully (%v files)\n", result["file_count"])
+}
+
+func deleteBackup(id string) {
+	resp, err := apiRequest(http.MethodDelete, "/api/v1/system/backups/"+url.PathEscape(id), nil)
+	if err != nil {
+		fatal(err)
+	}
+	decodeResponse(resp, "delete backup")
+
+	report(map[string]string{"id": id}, "Backup %s deleted\n", id)
+}
+
+func handleGC(args []string) {
+	fs := newFlagSet("gc")
+	dryRun := fs.Bool("dry-run", false, "Only report the blobs that would be deleted")
+	minAge := fs.Duration("min-age", time.Hour, "Keep unreferenced blobs younger than this")
+	quiet := fs.Bool("quiet", false, "Do not show progress")
+	format := addFormatFlag(fs)
+	fs.Parse(args)
+
+	runGC(*dryRun, *minAge, *quiet, *format)
+}
+
+// runGC deletes the blobs no image references. It exits 1 when blobs could
+// not be deleted and exitBusy when a collection is already running.
+func runGC(dryRun bool, minAge time.Duration, quiet bool, format string) {
+	if format != "json" {
+		if dryRun {
+			info("Running garbage collection (dry run)...\n")
+		} else {
+			info("Running garbage collection...\n")
+		}
+	}
+
+	bar := newProgressBar(quiet || quietOutput())
+	stop := watchSystemEvents(func(event string, data map[string]interface{}) bool {
+		if event != "gc_progress" {
+			return event == "gc_completed" || event == "gc_failed"
+		}
+		done, _ := data["done"].(float64)
+		total, _ := data["total"].(float64)
+		label := "Marking"
+		if data["phase"] == "sweep" {
+			label = "Sweeping"
+		}
+		bar.update(label, done, total, false)
+		return false
+	})
+	path := fmt.Sprintf("/api/v1/system/gc?dry_run=%t&min_age=%s", dryRun, minAge)
+	resp, err := apiRequest(http.MethodPost, path, nil)
+	stop()
+	bar.done()
+	if err != nil {
+		fatal(err)
+	}
+	if resp.StatusCode == http.StatusConflict {
+		resp.Body.Close()
+		fail(exitBusy, errorOutput{Error: "Garbage collection is already running", Status: http.StatusConflict})
+	}
+	result := decodeResponse(resp, "run garbage collection")
+	data, _ := result["data"].(map[string]interface{})
+
+	errs, _ := data["errors"].([]interface{})
+	if format == "json" {
+		printJSON(data)
+	} else if !quietOutput() {
+		deleted := "Deleted:"
+		if dryRun {
+			deleted = "To delete:"
+		}
+		fmt.Printf("%-11s %.0f blobs\n", "Scanned:", data["scanned"])
+		fmt.Printf("%-11s %.0f blobs\n", "Referenced:", data["referenced"])
+		fmt.Printf("%-11s %.0f blobs (%s)\n", deleted, data["deleted"], formatSize(data["freed_bytes"]))
+		if skipped, _ := data["skipped"].(float64); skipped > 0 {
+			fmt.Printf("%-11s %.0f blobs younger than %s\n", "Kept:", skipped, minAge)
+		}
+		for _, e := range errs {
+			fmt.Printf("Error: %v\n", e)
+		}
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
+	}
+}
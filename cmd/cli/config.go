@@ -0,0 +1,458 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// cliConfig is the CLI configuration stored in ~/.cyp/config.yaml. Like
+// kubectl, it holds named contexts of which one is current.
+type cliConfig struct {
+	CurrentContext string                 `yaml:"current-context,omitempty"`
+	Contexts       map[string]*cliContext `yaml:"contexts,omitempty"`
+}
+
+// cliContext is a server and the credentials used for it.
+type cliContext struct {
+	Host     string `yaml:"host"`
+	Username string `yaml:"username,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+	TokenID  int64  `yaml:"token_id,omitempty"` // 由 login 创建的访问令牌
+
+	CACert     string `yaml:"ca-cert,omitempty"`
+	ClientCert string `yaml:"client-cert,omitempty"`
+	ClientKey  string `yaml:"client-key,omitempty"`
+	Insecure   bool   `yaml:"insecure,omitempty"`
+}
+
+// configPath returns the path of the CLI configuration, $CYP_CONFIG or
+// ~/.cyp/config.yaml.
+func configPath() (string, error) {
+	if path := os.Getenv("CYP_CONFIG"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".cyp", "config.yaml"), nil
+}
+
+// loadConfig reads the CLI configuration. A missing file is an empty
+// configuration.
+func loadConfig() (*cliConfig, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &cliConfig{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		cfg.Contexts = make(map[string]*cliContext)
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	if cfg.Contexts == nil {
+		cfg.Contexts = make(map[string]*cliContext)
+	}
+	return cfg, nil
+}
+
+// save writes the configuration readable only by the user, as it holds
+// tokens.
+func (cfg *cliConfig) save() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// applyContext fills the host, token and TLS settings of the selected
+// context unless they were given as flags or $CYP_TOKEN. The context is
+// chosen by -context, $CYP_CONTEXT or the current context of the
+// configuration.
+func applyContext() {
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+
+	name := contextName
+	if name == "" {
+		name = os.Getenv("CYP_CONTEXT")
+	}
+	explicit := name != ""
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	ctx, ok := cfg.Contexts[name]
+	if !ok {
+		if explicit && command != "login" {
+			fatalf("Context not found: %s", name)
+		}
+		return
+	}
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["host"] {
+		host = ctx.Host
+	}
+	if token == "" {
+		token = ctx.Token
+	}
+	if !set["ca-cert"] {
+		caCert = ctx.CACert
+	}
+	if !set["client-cert"] && !set["client-key"] {
+		clientCert, clientKey = ctx.ClientCert, ctx.ClientKey
+	}
+	if !set["insecure"] {
+		insecure = ctx.Insecure
+	}
+}
+
+// serverURL returns the base URL of the server. A host without a scheme
+// uses https when TLS options are given and plain HTTP otherwise.
+func serverURL() string {
+	if strings.HasPrefix(host, "http://") || strings.HasPrefix(host, "https://") {
+		return strings.TrimRight(host, "/")
+	}
+	if tlsConfigured() {
+		return "https://" + host
+	}
+	return "http://" + host
+}
+
+func handleLogin(args []string) {
+	fs := newFlagSet("login")
+	name := fs.String("name", "", "Context name (default: the server address)")
+	username := fs.String("username", "", "Username")
+	passwordStdin := fs.Bool("password-stdin", false, "Read the password from stdin")
+	patToken := fs.String("with-token", "", "Store an existing personal access token instead of logging in")
+	useSession := fs.Bool("session", false, "Store the login session instead of creating an access token")
+	scopes := fs.String("scopes", "", "Comma separated token scopes (default: all scopes of the user)")
+	expires := fs.String("expires", "90d", "Lifetime of the created token, e.g. 30d or 1y")
+
+	// 服务器地址可以写在参数前面
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		host = args[0]
+		args = args[1:]
+	}
+	fs.Parse(args)
+
+	ctx := &cliContext{
+		Host:       host,
+		CACert:     absPath(caCert),
+		ClientCert: absPath(clientCert),
+		ClientKey:  absPath(clientKey),
+		Insecure:   insecure,
+	}
+	if *patToken != "" {
+		ctx.Token = *patToken
+		ctx.Username = verifyToken(ctx.Token)
+	} else {
+		user := *username
+		if user == "" {
+			fmt.Fprint(os.Stderr, "Username: ")
+			fmt.Scanln(&user)
+		}
+		pw := password
+		if *passwordStdin {
+			pw = readStdinLine()
+		} else if pw == "" {
+			pw = promptSecret("Password: ")
+		} else {
+			warnPasswordFlag()
+		}
+
+		session, role := loginSession(user, pw)
+		ctx.Username = user
+		if *useSession {
+			ctx.Token = session
+		} else {
+			ctx.Token, ctx.TokenID = createCLIToken(session, role, *scopes, *expires)
+		}
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	if *name == "" {
+		*name = contextName
+	}
+	if *name == "" {
+		*name = strings.TrimPrefix(strings.TrimPrefix(host, "https://"), "http://")
+	}
+	cfg.Contexts[*name] = ctx
+	cfg.CurrentContext = *name
+	if err := cfg.save(); err != nil {
+		fatalf("Error saving configuration: %v", err)
+	}
+
+	path, _ := configPath()
+	report(contextInfo{Name: *name, Host: host, Username: ctx.Username, Current: true, LoggedIn: true},
+		"Logged in to %s as %s (context %s, saved to %s)\n", host, ctx.Username, *name, path)
+}
+
+// absPath makes a certificate path stored in a context independent of the
+// working directory.
+func absPath(path string) string {
+	if path == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// loginSession logs in with a password and returns the session token and
+// the role of the user.
+func loginSession(username, pw string) (string, string) {
+	resp, err := apiRequest(http.MethodPost, "/api/v1/auth/login", jsonBody(loginRequest{Username: username, Password: pw}))
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "log in")
+
+	session, _ := result["token"].(string)
+	user, _ := result["user"].(map[string]interface{})
+	role, _ := user["role"].(string)
+	if session == "" {
+		fmt.Println("Failed to log in: no token in response")
+		os.Exit(1)
+	}
+	return session, role
+}
+
+// createCLIToken creates a personal access token for the CLI with the login
+// session. Without explicit scopes, admins get admin:* and other users full
+// registry access.
+func createCLIToken(session, role, scopes, expires string) (string, int64) {
+	list := []string{"registry:write", "registry:delete", "audit:read"}
+	if role == "admin" {
+		list = []string{"admin:*"}
+	}
+	if scopes != "" {
+		list = strings.Split(scopes, ",")
+	}
+
+	hostname, _ := os.Hostname()
+	body := jsonBody(createTokenRequest{
+		Name:      "cyp-cli@" + hostname,
+		Scopes:    list,
+		ExpiresIn: expires,
+	})
+
+	saved := token
+	token = session
+	resp, err := apiRequest(http.MethodPost, "/api/v1/tokens", body)
+	token = saved
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "create access token")
+
+	plain, _ := result["plain_token"].(string)
+	created, _ := result["token"].(map[string]interface{})
+	id, _ := created["id"].(float64)
+	return plain, int64(id)
+}
+
+// verifyToken checks a token against the server and returns its user.
+func verifyToken(t string) string {
+	saved := token
+	token = t
+	resp, err := apiRequest(http.MethodGet, "/api/v1/auth/me", nil)
+	token = saved
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "verify token")
+
+	if user, ok := result["user"].(map[string]interface{}); ok {
+		if name, ok := user["username"].(string); ok {
+			return name
+		}
+	}
+	if name, ok := result["username"].(string); ok {
+		return name
+	}
+	return ""
+}
+
+func handleLogout(args []string) {
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	name := contextName
+	if len(args) > 0 {
+		name = args[0]
+	}
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	ctx, ok := cfg.Contexts[name]
+	if !ok {
+		fatalf("Context not found: %s", name)
+	}
+
+	tokenID := ctx.TokenID
+	ctx.Token, ctx.TokenID = "", 0
+	if err := cfg.save(); err != nil {
+		fatalf("Error saving configuration: %v", err)
+	}
+	report(map[string]string{"context": name}, "Logged out of %s\n", name)
+	if tokenID != 0 {
+		// 令牌只能通过登录会话删除
+		info("Access token %d stays valid until it expires, revoke it in the web UI if needed\n", tokenID)
+	}
+}
+
+func handleContext(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli context <list|current|use|delete>")
+		os.Exit(1)
+	}
+	cfg, err := loadConfig()
+	if err != nil {
+		fatal(err)
+	}
+
+	if (args[0] == "use" || args[0] == "delete") && len(args) < 2 {
+		fatalf("Usage: cyp-cli context %s <name>", args[0])
+	}
+
+	switch args[0] {
+	case "list":
+		if jsonOutput() || quietOutput() {
+			listContexts(cfg)
+			return
+		}
+		if len(cfg.Contexts) == 0 {
+			fmt.Println("No contexts, use cyp-cli login to add one")
+			return
+		}
+		names := make([]string, 0, len(cfg.Contexts))
+		for name := range cfg.Contexts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		fmt.Printf("%-8s %-20s %-30s %s\n", "CURRENT", "NAME", "HOST", "USER")
+		for _, name := range names {
+			ctx := cfg.Contexts[name]
+			current := ""
+			if name == cfg.CurrentContext {
+				current = "*"
+			}
+			user := ctx.Username
+			if ctx.Token == "" {
+				user += " (logged out)"
+			}
+			fmt.Printf("%-8s %-20s %-30s %s\n", current, name, ctx.Host, user)
+		}
+	case "current":
+		if cfg.CurrentContext == "" {
+			fmt.Println("No current context")
+			os.Exit(1)
+		}
+		fmt.Println(cfg.CurrentContext)
+	case "use":
+		if _, ok := cfg.Contexts[args[1]]; !ok {
+			fatalf("Context not found: %s", args[1])
+		}
+		cfg.CurrentContext = args[1]
+		if err := cfg.save(); err != nil {
+			fatalf("Error saving configuration: %v", err)
+		}
+		report(map[string]string{"context": args[1]}, "Switched to context %s\n", args[1])
+	case "delete":
+		if _, ok := cfg.Contexts[args[1]]; !ok {
+			fatalf("Context not found: %s", args[1])
+		}
+		delete(cfg.Contexts, args[1])
+		if cfg.CurrentContext == args[1] {
+			cfg.CurrentContext = ""
+		}
+		if err := cfg.save(); err != nil {
+			fatalf("Error saving configuration: %v", err)
+		}
+		report(map[string]string{"context": args[1]}, "Context %s deleted\n", args[1])
+	default:
+		fatalf("Unknown context command: %s", args[0])
+	}
+}
+
+// contextInfo is the JSON schema of a context in context list. Tokens are
+// never printed.
+type contextInfo struct {
+	Name     string `json:"name"`
+	Host     string `json:"host"`
+	Username string `json:"username,omitempty"`
+	Current  bool   `json:"current"`
+	LoggedIn bool   `json:"logged_in"`
+}
+
+// listContexts prints the contexts as JSON or, in quiet mode, their names.
+func listContexts(cfg *cliConfig) {
+	list := []contextInfo{}
+	for name, ctx := range cfg.Contexts {
+		list = append(list, contextInfo{
+			Name:     name,
+			Host:     ctx.Host,
+			Username: ctx.Username,
+			Current:  name == cfg.CurrentContext,
+			LoggedIn: ctx.Token != "",
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	if jsonOutput() {
+		printJSON(map[string]interface{}{"contexts": list})
+		return
+	}
+	for _, c := range list {
+		fmt.Println(c.Name)
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// credentialHelperPrefix is the name docker looks for: with
+// "credsStore": "cyp" it runs docker-credential-cyp, which can be a symlink
+// to cyp-cli.
+const credentialHelperPrefix = "docker-credential-"
+
+// errCredentialsNotFound is the message docker expects when a helper has no
+// credentials for a server.
+var errCredentialsNotFound = errors.New("credentials not found in native keychain")
+
+// dockerCredentials is the JSON the docker-credential protocol exchanges.
+type dockerCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// storedCredential is a personal access token kept for one registry.
+type storedCredential struct {
+	Username string `json:"username"`
+	Token    string `json:"token"`
+	TokenID  int64  `json:"token_id,omitempty"` // 由 store 创建的令牌，用于提示撤销
+}
+
+func printCredentialHelperUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: cyp-cli credential-helper <get|store|erase|list>")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Implements the docker-credential protocol. Link the binary as")
+	fmt.Fprintln(os.Stderr, "docker-credential-cyp and set \"credsStore\": \"cyp\" (or \"credHelpers\")")
+	fmt.Fprintln(os.Stderr, "in ~/.docker/config.json. docker login with a password stores a new")
+	fmt.Fprintln(os.Stderr, "personal access token instead of the password.")
+	fmt.Fprintln(os.Stderr, "")
+	fmt.Fprintln(os.Stderr, "Environment:")
+	fmt.Fprintln(os.Stderr, "  CYP_CREDENTIALS_FILE   Token store (default: <user config dir>/cyp/credentials.json)")
+	fmt.Fprintln(os.Stderr, "  CYP_CREDENTIAL_TTL     Lifetime of created tokens (default: 90d)")
+}
+
+// handleCredentialHelper runs a docker-credential action with the request
+// read from stdin.
+func handleCredentialHelper(args []string) {
+	if len(args) != 1 {
+		printCredentialHelperUsage()
+		os.Exit(1)
+	}
+
+	var err error
+	switch args[0] {
+	case "get":
+		err = credentialGet(os.Stdin, os.Stdout)
+	case "store":
+		err = credentialStore(os.Stdin)
+	case "erase":
+		err = credentialErase(os.Stdin)
+	case "list":
+		err = credentialList(os.Stdout)
+	case "version":
+		fmt.Printf("%s v%s\n", appName, version)
+	default:
+		printCredentialHelperUsage()
+		os.Exit(1)
+	}
+	if err != nil {
+		// docker 从标准输出读取错误信息
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func credentialGet(in io.Reader, out io.Writer) error {
+	server, err := readServerURL(in)
+	if err != nil {
+		return err
+	}
+	store, err := loadCredentialStore()
+	if err != nil {
+		return err
+	}
+	cred, ok := store[credentialKey(server)]
+	if !ok {
+		return errCredentialsNotFound
+	}
+	return json.NewEncoder(out).Encode(dockerCredentials{
+		ServerURL: server,
+		Username:  cred.Username,
+		Secret:    cred.Token,
+	})
+}
+
+// credentialStore saves the credentials of docker login. A personal access
+// token is stored as is; a password is exchanged for a new token with
+// registry read and write scopes, so the password never touches the disk.
+func credentialStore(in io.Reader) error {
+	var creds dockerCredentials
+	if err := json.NewDecoder(in).Decode(&creds); err != nil {
+		return fmt.Errorf("invalid credentials: %w", err)
+	}
+	if creds.ServerURL == "" || creds.Username == "" || creds.Secret == "" {
+		return errors.New("server URL, username and secret are required")
+	}
+
+	cred := storedCredential{Username: creds.Username, Token: creds.Secret}
+	if !strings.HasPrefix(creds.Secret, "pat_") {
+		var err error
+		if cred, err = exchangePassword(creds); err != nil {
+			return err
+		}
+	}
+
+	store, err := loadCredentialStore()
+	if err != nil {
+		return err
+	}
+	store[credentialKey(creds.ServerURL)] = cred
+	return saveCredentialStore(store)
+}
+
+func credentialErase(in io.Reader) error {
+	server, err := readServerURL(in)
+	if err != nil {
+		return err
+	}
+	store, err := loadCredentialStore()
+	if err != nil {
+		return err
+	}
+	key := credentialKey(server)
+	cred, ok := store[key]
+	if !ok {
+		return errCredentialsNotFound
+	}
+	delete(store, key)
+	if err := saveCredentialStore(store); err != nil {
+		return err
+	}
+
+	// 令牌只能通过登录会话删除，这里只提示用户
+	if cred.TokenID != 0 {
+		fmt.Fprintf(os.Stderr, "Token %d of %s is still valid until it expires; revoke it under /api/v1/tokens if needed\n", cred.TokenID, cred.Username)
+	}
+	return nil
+}
+
+func credentialList(out io.Writer) error {
+	store, err := loadCredentialStore()
+	if err != nil {
+		return err
+	}
+	list := make(map[string]string, len(store))
+	for server, cred := range store {
+		list[server] = cred.Username
+	}
+	return json.NewEncoder(out).Encode(list)
+}
+
+// exchangePassword logs in with the password and creates a personal access
+// token for the registry.
+func exchangePassword(creds dockerCredentials) (storedCredential, error) {
+	base := credentialAPIBase(creds.ServerURL)
+
+	body, _ := json.Marshal(loginRequest{Username: creds.Username, Password: creds.Secret})
+	var login struct {
+		Token string `json:"token"`
+	}
+	if err := credentialPost(base+"/api/v1/auth/login", "", body, &login); err != nil {
+		return storedCredential{}, fmt.Errorf("login failed: %w", err)
+	}
+
+	hostname, _ := os.Hostname()
+	ttl := os.Getenv("CYP_CREDENTIAL_TTL")
+	if ttl == "" {
+		ttl = "90d"
+	}
+	body, _ = json.Marshal(createTokenRequest{
+		Name:      "docker-credential-helper@" + hostname,
+		Scopes:    []string{"registry:read", "registry:write"},
+		ExpiresIn: ttl,
+	})
+	var created struct {
+		Token struct {
+			ID int64 `json:"id"`
+		} `json:"token"`
+		PlainToken string `json:"plain_token"`
+	}
+	if err := credentialPost(base+"/api/v1/tokens", login.Token, body, &created); err != nil {
+		return storedCredential{}, fmt.Errorf("failed to create token: %w", err)
+	}
+	if created.PlainToken == "" {
+		return storedCredential{}, errors.New("failed to create token: empty response")
+	}
+	return storedCredential{Username: creds.Username, Token: created.PlainToken, TokenID: created.Token.ID}, nil
+}
+
+// credentialPost posts JSON and decodes the response, returning the error
+// message of the server on failure.
+func credentialPost(url, bearer string, body []byte, out interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return errors.New(e.Error)
+		}
+		return errors.New(resp.Status)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// credentialAPIBase returns the API address of a registry server. HTTPS is
+// assumed unless the server URL names a scheme or is a loopback address,
+// matching which registries docker talks to over plain HTTP by default.
+func credentialAPIBase(server string) string {
+	if strings.HasPrefix(server, "http://") || strings.HasPrefix(server, "https://") {
+		return strings.TrimRight(server, "/")
+	}
+	host := strings.SplitN(server, "/", 2)[0]
+	name := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		name = h
+	}
+	if ip := net.ParseIP(name); name == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "http://" + host
+	}
+	return "https://" + host
+}
+
+// credentialKey normalizes a server URL, so "https://reg:5000/v2/" and
+// "reg:5000" share credentials.
+func credentialKey(server string) string {
+	server = strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	return strings.ToLower(strings.SplitN(server, "/", 2)[0])
+}
+
+func readServerURL(in io.Reader) (string, error) {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return "", err
+	}
+	server := strings.TrimSpace(string(data))
+	if server == "" {
+		return "", errors.New("no server URL given")
+	}
+	return server, nil
+}
+
+func credentialStorePath() (string, error) {
+	if path := os.Getenv("CYP_CREDENTIALS_FILE"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cyp", "credentials.json"), nil
+}
+
+func loadCredentialStore() (map[string]storedCredential, error) {
+	path, err := credentialStorePath()
+	if err != nil {
+		return nil, err
+	}
+	store := make(map[string]storedCredential)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("invalid credential store %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// saveCredentialStore writes the store readable only by the user. The file
+// is replaced atomically so concurrent docker commands never see it half
+// written.
+func saveCredentialStore(store map[string]storedCredential) error {
+	path, err := credentialStorePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// listOptions are the output and pagination flags of the listing commands.
+type listOptions struct {
+	format   string
+	page     int
+	pageSize int
+}
+
+// addListFlags registers the output and pagination flags on fs.
+func addListFlags(fs *flag.FlagSet, defaultPageSize int) *listOptions {
+	opts := &listOptions{}
+	fs.StringVar(&opts.format, "format", defaultFormat(), "Output format: table or json (default: -output)")
+	fs.IntVar(&opts.page, "page", 1, "Page number")
+	fs.IntVar(&opts.pageSize, "page-size", defaultPageSize, "Items per page (max 100)")
+	return opts
+}
+
+// checkFormat exits on an unknown output format.
+func (o *listOptions) checkFormat() {
+	if o.format != "table" && o.format != "json" {
+		fatalf("Unknown output format: %s (use table or json)", o.format)
+	}
+}
+
+// printJSON prints v as indented JSON.
+func printJSON(v interface{}) {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+}
+
+// formatSize formats a byte count as KB, MB or GB.
+func formatSize(v interface{}) string {
+	size, _ := v.(float64)
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for size >= 1024 && i < len(units)-1 {
+		size /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", size, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", size, units[i])
+}
+
+// shortDigest shortens sha256:<hex> to 12 hex characters.
+func shortDigest(v interface{}) string {
+	digest, _ := v.(string)
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		digest = digest[:12]
+	}
+	return digest
+}
+
+// shortTime cuts an RFC 3339 timestamp to minutes.
+func shortTime(v interface{}) string {
+	s, _ := v.(string)
+	if len(s) >= 16 {
+		return strings.Replace(s[:16], "T", " ", 1)
+	}
+	return s
+}
+
+// imageInfo is the JSON schema of an image in image list, search and
+// inspect, and of a tag in tags.
+type imageInfo struct {
+	Name         string      `json:"name"`
+	Tag          string      `json:"tag"`
+	Digest       string      `json:"digest"`
+	Size         int64       `json:"size"`
+	PullCount    int64       `json:"pull_count"`
+	CreatedAt    string      `json:"created_at"`
+	PushedBy     string      `json:"pushed_by,omitempty"`
+	LastPulledAt string      `json:"last_pulled_at,omitempty"`
+	Category     string      `json:"category,omitempty"`
+	Layers       []layerInfo `json:"layers,omitempty"`
+}
+
+// layerInfo is the JSON schema of a layer in image inspect.
+type layerInfo struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// imageList is the JSON schema of image list and search.
+type imageList struct {
+	Images     []imageInfo `json:"images"`
+	Page       int         `json:"page"`
+	PageSize   int         `json:"page_size"`
+	Total      int         `json:"total"`
+	TotalPages int         `json:"total_pages"`
+}
+
+// tagList is the JSON schema of tags.
+type tagList struct {
+	Repository string      `json:"repository"`
+	Tags       []imageInfo `json:"tags"`
+	Total      int         `json:"total"`
+}
+
+// ref returns name:tag.
+func (i imageInfo) ref() string {
+	return i.Name + ":" + i.Tag
+}
+
+func listImages(keyword string, opts *listOptions) {
+	opts.checkFormat()
+
+	query := url.Values{}
+	query.Set("page", fmt.Sprint(opts.page))
+	query.Set("page_size", fmt.Sprint(opts.pageSize))
+	path, action := "/api/images", "list images"
+	if keyword != "" {
+		query.Set("q", keyword)
+		path, action = "/api/images/search", "search images"
+	}
+
+	resp, err := apiRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, action)
+	list := imageList{Images: []imageInfo{}}
+	convert(result["data"], &list)
+	for i := range list.Images {
+		list.Images[i].Layers = nil
+	}
+
+	switch {
+	case opts.format == "json":
+		printJSON(list)
+		return
+	case quietOutput():
+		for _, image := range list.Images {
+			fmt.Println(image.ref())
+		}
+		return
+	}
+
+	if len(list.Images) == 0 {
+		fmt.Println("No images found")
+		return
+	}
+
+	fmt.Printf("%-30s %-16s %-14s %-10s %-8s %s\n", "NAME", "TAG", "DIGEST", "SIZE", "PULLS", "CREATED")
+	for _, image := range list.Images {
+		fmt.Printf("%-30s %-16s %-14s %-10s %-8d %s\n", image.Name, image.Tag,
+			shortDigest(image.Digest), formatSize(float64(image.Size)), image.PullCount, shortTime(image.CreatedAt))
+	}
+	fmt.Printf("(page %d of %d, %d images)\n", list.Page, list.TotalPages, list.Total)
+}
+
+func inspectImage(ref, format string) {
+	name, tag := splitImageRef(ref)
+	resp, err := apiRequest(http.MethodGet, fmt.Sprintf("/api/images/%s/%s", name, tag), nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "inspect image")
+	data, _ := result["data"].(map[string]interface{})
+	var image imageInfo
+	convert(data["image"], &image)
+
+	switch {
+	case format == "json":
+		printJSON(image)
+		return
+	case quietOutput():
+		fmt.Println(image.Digest)
+		return
+	}
+
+	fmt.Printf("Name:        %s\n", image.Name)
+	fmt.Printf("Tag:         %s\n", image.Tag)
+	fmt.Printf("Digest:      %s\n", image.Digest)
+	fmt.Printf("Size:        %s\n", formatSize(float64(image.Size)))
+	fmt.Printf("Created:     %s\n", image.CreatedAt)
+	if image.PushedBy != "" {
+		fmt.Printf("Pushed by:   %s\n", image.PushedBy)
+	}
+	fmt.Printf("Pulls:       %d\n", image.PullCount)
+	if image.LastPulledAt != "" {
+		fmt.Printf("Last pulled: %s\n", image.LastPulledAt)
+	}
+
+	fmt.Printf("Layers:      %d\n", len(image.Layers))
+	for _, layer := range image.Layers {
+		fmt.Printf("  %-72s %s\n", layer.Digest, formatSize(float64(layer.Size)))
+	}
+}
+
+func deleteImage(ref string) {
+	name, tag := splitImageRef(ref)
+	resp, err := apiRequest(http.MethodDelete, fmt.Sprintf("/api/images/%s/%s", name, tag), nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "delete image")
+
+	data, _ := result["data"].(map[string]interface{})
+	trashed, _ := data["trashed"].(bool)
+	out := map[string]interface{}{"name": name, "tag": tag, "trashed": trashed}
+	if trashed {
+		report(out, "Image %s:%s moved to the trash\n", name, tag)
+		return
+	}
+	report(out, "Image %s:%s deleted\n", name, tag)
+}
+
+// listTags lists the tags of a repository. The server returns all tags, so
+// pagination is applied here; a page size of 0 shows them all.
+func listTags(repository string, opts *listOptions) {
+	opts.checkFormat()
+
+	resp, err := apiRequest(http.MethodGet, "/api/images/"+repository, nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "list tags")
+	data, _ := result["data"].(map[string]interface{})
+	tags := []imageInfo{}
+	convert(data["tags"], &tags)
+
+	total := len(tags)
+	if opts.pageSize > 0 {
+		start := (opts.page - 1) * opts.pageSize
+		if opts.page < 1 || start > total {
+			start = total
+		}
+		end := start + opts.pageSize
+		if end > total {
+			end = total
+		}
+		tags = tags[start:end]
+	}
+	for i := range tags {
+		tags[i].Name = repository
+		tags[i].Layers = nil
+	}
+
+	switch {
+	case opts.format == "json":
+		printJSON(tagList{Repository: repository, Tags: tags, Total: total})
+		return
+	case quietOutput():
+		for _, t := range tags {
+			fmt.Println(t.ref())
+		}
+		return
+	}
+
+	if len(tags) == 0 {
+		fmt.Println("No tags found")
+		return
+	}
+	fmt.Printf("%-20s %-14s %-10s %-8s %s\n", "TAG", "DIGEST", "SIZE", "PULLS", "CREATED")
+	for _, t := range tags {
+		fmt.Printf("%-20s %-14s %-10s %-8d %s\n", t.Tag, shortDigest(t.Digest),
+			formatSize(float64(t.Size)), t.PullCount, shortTime(t.CreatedAt))
+	}
+	if len(tags) < total {
+		fmt.Printf("(%d of %d tags shown)\n", len(tags), total)
+	}
+}
+
+// copyImage copies src to dst on the server. A copy within the repository
+// is a retag, otherwise the image is promoted to the target repository.
+func copyImage(src, dst string, overwrite bool) {
+	srcName, srcTag := splitImageRef(src)
+	dstName, dstTag := splitImageRef(dst)
+	if !strings.Contains(dst[strings.LastIndex(dst, "/")+1:], ":") {
+		// 未指定目标标签时沿用源标签
+		dstTag = srcTag
+	}
+
+	req := copyRequest{Repository: dstName, Tag: dstTag, Overwrite: overwrite}
+	action := "promote"
+	if dstName == srcName {
+		action = "retag"
+		req.Repository = ""
+	}
+
+	path := fmt.Sprintf("/api/v1/images/%s/%s/%s", srcName, srcTag, action)
+	resp, err := apiRequest(http.MethodPost, path, jsonBody(req))
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "copy image")
+
+	data, _ := result["data"].(map[string]interface{})
+	var image imageInfo
+	convert(data["image"], &image)
+	image.Layers = nil
+	if quietOutput() {
+		fmt.Println(image.ref())
+		return
+	}
+	report(image, "Copied %s:%s to %s (%s)\n", srcName, srcTag, image.ref(), image.Digest)
+}
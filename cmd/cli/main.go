@@ -2,12 +2,17 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -17,17 +22,36 @@ const (
 )
 
 var (
-	host     string
-	command  string
-	password string
+	host        string
+	command     string
+	password    string
+	token       string
+	contextName string
 )
 
 func main() {
+	// Invoked by docker as docker-credential-<name>
+	if strings.HasPrefix(filepath.Base(os.Args[0]), credentialHelperPrefix) {
+		handleCredentialHelper(os.Args[1:])
+		return
+	}
+
 	// Global flags
 	flag.StringVar(&host, "host", "localhost:8080", "Registry host address")
-	flag.StringVar(&password, "password", "", "Admin password for unlock")
+	flag.StringVar(&password, "password", "", "Password for login and unlock (deprecated: visible in shell history and ps)")
+	flag.StringVar(&token, "token", os.Getenv("CYP_TOKEN"), "API token (default: $CYP_TOKEN)")
+	flag.StringVar(&contextName, "context", "", "Context of ~/.cyp/config.yaml to use (default: current context)")
+	flag.StringVar(&caCert, "ca-cert", "", "CA bundle to verify the server certificate")
+	flag.StringVar(&clientCert, "client-cert", "", "Client certificate for mutual TLS")
+	flag.StringVar(&clientKey, "client-key", "", "Private key of the client certificate")
+	flag.BoolVar(&insecure, "insecure", false, "Skip verification of the server certificate")
+	flag.StringVar(&outputMode, "output", outputTable, "Output mode: table, json or quiet")
 
 	// Parse flags
+	flag.Usage = func() {
+		printUsage()
+		os.Exit(exitError)
+	}
 	flag.Parse()
 
 	args := flag.Args()
@@ -38,6 +62,8 @@ func main() {
 
 	command = args[0]
 	subArgs := args[1:]
+	checkOutput()
+	applyContext()
 
 	switch command {
 	case "version":
@@ -45,11 +71,43 @@ func main() {
 	case "lock":
 		handleLock(subArgs)
 	case "unlock":
-		handleUnlock()
+		handleUnlock(subArgs)
 	case "status":
 		handleStatus()
+	case "top":
+		handleTop(subArgs)
 	case "audit":
 		handleAudit(subArgs)
+	case "backup":
+		handleBackup(subArgs)
+	case "gc":
+		handleGC(subArgs)
+	case "p2p":
+		handleP2P(subArgs)
+	case "image", "images":
+		handleImage(subArgs)
+	case "tags":
+		handleTags(subArgs)
+	case "copy":
+		handleCopy(subArgs)
+	case "user":
+		handleUser(subArgs)
+	case "sync":
+		handleSync(subArgs)
+	case "workflow":
+		handleWorkflow(subArgs)
+	case "update":
+		handleUpdate(subArgs)
+	case "login":
+		handleLogin(subArgs)
+	case "logout":
+		handleLogout(subArgs)
+	case "context":
+		handleContext(subArgs)
+	case "credential-helper":
+		handleCredentialHelper(subArgs)
+	case "apply":
+		handleApply(subArgs)
 	case "help":
 		printUsage()
 	default:
@@ -66,72 +124,192 @@ func printUsage() {
 	fmt.Println("  cyp-cli [flags] <command> [args]")
 	fmt.Println("")
 	fmt.Println("Commands:")
+	fmt.Println("  login [server] [-username u] [-password-stdin] [-with-token pat] [-name ctx]")
+	fmt.Println("                   Log in and save an access token to ~/.cyp/config.yaml")
+	fmt.Println("  logout [ctx]     Remove the saved token of a context")
+	fmt.Println("  context list     List contexts")
+	fmt.Println("  context current  Show the current context")
+	fmt.Println("  context use <name>        Switch the current context")
+	fmt.Println("  context delete <name>     Delete a context")
 	fmt.Println("  version          Show version information")
 	fmt.Println("  status           Show system status")
+	fmt.Println("  top [-interval 2s] [-events n] [-once]")
+	fmt.Println("                   Live dashboard of requests/sec, cache hit rate, uploads,")
+	fmt.Println("                   P2P peers, disk usage and recent audit events")
 	fmt.Println("  lock <reason>    Lock the system")
-	fmt.Println("  unlock           Unlock the system")
+	fmt.Println("  unlock [-username u] [-password-stdin] [-otp code] [-unlock-token-file file]")
+	fmt.Println("                   Unlock the system with an administrator password or the")
+	fmt.Println("                   one-time unlock token the server writes when it locks")
 	fmt.Println("  audit tail       Show recent audit logs")
 	fmt.Println("  audit export     Export audit logs")
 	fmt.Println("  audit verify     Verify audit log integrity")
+	fmt.Println("  backup create [-blobs] [-quiet]")
+	fmt.Println("                            Create a backup, showing its progress")
+	fmt.Println("  backup list [-format table|json]")
+	fmt.Println("                            List backups")
+	fmt.Println("  backup verify <id>        Verify backup checksums")
+	fmt.Println("  backup download <id>      Download a backup archive")
+	fmt.Println("  backup restore <id> [-quiet]")
+	fmt.Println("                            Restore from a backup, showing its progress")
+	fmt.Println("  backup delete <id>        Delete a backup")
+	fmt.Println("  gc [-dry-run] [-min-age 1h] [-quiet] [-format table|json]")
+	fmt.Println("                            Delete blobs no image references")
+	fmt.Println("  p2p keygen [-o file]      Generate a private network swarm key")
+	fmt.Println("  image list [-page n] [-page-size n] [-format table|json]")
+	fmt.Println("                            List images")
+	fmt.Println("  image search <keyword> [-page n] [-page-size n] [-format table|json]")
+	fmt.Println("                            Search images by name")
+	fmt.Println("  image inspect <name:tag> [-format table|json]")
+	fmt.Println("                            Show image details and layers")
+	fmt.Println("  image delete <name:tag>   Delete an image tag")
+	fmt.Println("  image export <name:tag> [-format docker|oci] [-o file]")
+	fmt.Println("                            Export an image as a docker-archive or OCI layout tar")
+	fmt.Println("  image import <file> [-repository name] [-tag tag]")
+	fmt.Println("                            Import a docker-archive or OCI layout tar")
+	fmt.Println("  tags <repo> [-page n] [-page-size n] [-format table|json]")
+	fmt.Println("                            List the tags of a repository")
+	fmt.Println("  copy <src> <dst> [-overwrite]")
+	fmt.Println("                            Copy an image to another tag or repository")
+	fmt.Println("  sync run <name:tag> <registry> [-target name[:tag]] [-wait] [-timeout 30m]")
+	fmt.Println("                            Push an image to a remote registry")
+	fmt.Println("  sync run -replication <rule-id>")
+	fmt.Println("                            Run a pull replication rule")
+	fmt.Println("  sync status <id> [-wait]  Show a sync")
+	fmt.Println("  sync history [-page n] [-page-size n] [-format table|json]")
+	fmt.Println("                            List syncs")
+	fmt.Println("  workflow list [-format table|json]")
+	fmt.Println("                            List workflows")
+	fmt.Println("  workflow trigger <id> [-wait] [-timeout 30m]")
+	fmt.Println("                            Run a workflow, -wait streams the job logs")
+	fmt.Println("  workflow logs <job-id> [-wait]")
+	fmt.Println("                            Show the logs of a job")
+	fmt.Println("  update import <bundle.tar> [-no-apply]")
+	fmt.Println("                            Verify and apply a signed offline update bundle")
+	fmt.Println("  update status             Show the update status")
+	fmt.Println("  user list [search]        List users (admin)")
+	fmt.Println("  user create <username> -password pw [-email e] [-role admin|user]")
+	fmt.Println("                            Create a user")
+	fmt.Println("  user role <user> <admin|user>   Change the role of a user")
+	fmt.Println("  user activate <user>      Re-enable a user")
+	fmt.Println("  user deactivate <user>    Disable a user")
+	fmt.Println("  user reset-password <user> [-password pw]")
+	fmt.Println("                            Reset a password, the user must change it at next login")
+	fmt.Println("  user delete <user>        Delete a user")
+	fmt.Println("  apply -f <file|-> [-dry-run] [-prune]")
+	fmt.Println("                            Apply a declarative YAML or JSON document of upstreams,")
+	fmt.Println("                            sync rules, retention policies, users and orgs (admin)")
+	fmt.Println("  credential-helper <get|store|erase|list>")
+	fmt.Println("                            docker-credential helper backed by access tokens")
 	fmt.Println("  help             Show this help message")
 	fmt.Println("")
 	fmt.Println("Flags:")
-	fmt.Println("  -host string     Registry host address (default: localhost:8080)")
-	fmt.Println("  -password string Admin password for unlock")
+	fmt.Println("  -host string     Registry host address, https://host for TLS (default: localhost:8080)")
+	fmt.Println("  -password string Password for login and unlock, deprecated: prefer the prompt,")
+	fmt.Println("                   -password-stdin or $CYP_UNLOCK_PASSWORD")
+	fmt.Println("  -token string    API token (default: $CYP_TOKEN)")
+	fmt.Println("  -context string  Context to use (default: $CYP_CONTEXT or the current context)")
+	fmt.Println("  -ca-cert file    CA bundle to verify the server certificate")
+	fmt.Println("  -client-cert file  Client certificate for mutual TLS")
+	fmt.Println("  -client-key file   Private key of the client certificate")
+	fmt.Println("  -insecure        Skip verification of the server certificate")
+	fmt.Println("  -output mode     table (default), json for stable JSON on stdout including")
+	fmt.Println("                   errors, or quiet to print only identifiers")
+	fmt.Println("")
+	fmt.Println("Environment:")
+	fmt.Println("  CYP_UNLOCK_PASSWORD  Administrator password for unlock")
+	fmt.Println("  CYP_UNLOCK_TOKEN     One-time unlock token for unlock")
+	fmt.Println("")
+	fmt.Println("Exit status:")
+	fmt.Println("  0  success")
+	fmt.Println("  1  error, including a failed job with -wait")
+	fmt.Println("  2  not logged in, invalid token or permission denied")
+	fmt.Println("  3  system locked (status exits 3 while the system is locked)")
+	fmt.Println("  4  -wait exceeded -timeout")
+	fmt.Println("  5  the server is already running the operation, e.g. gc")
+	fmt.Println("")
+	fmt.Println("Progress is written to stderr, one line per phase when it is not a terminal.")
+}
+
+// versionInfo is the JSON schema of version.
+type versionInfo struct {
+	Client string `json:"client"`
+	Server string `json:"server,omitempty"` // 服务器不可达时为空
 }
 
 func printVersion() {
-	fmt.Printf("%s v%s\n", appName, version)
+	v := versionInfo{Client: version}
 
 	// Try to get server version
-	resp, err := http.Get(fmt.Sprintf("http://%s/api/version", host))
-	if err != nil {
-		return
-	}
-	defer resp.Body.Close()
-
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return
+	if resp, err := apiRequest(http.MethodGet, "/api/version", nil); err == nil {
+		var result map[string]interface{}
+		if json.NewDecoder(resp.Body).Decode(&result) == nil {
+			if data, ok := result["data"].(map[string]interface{}); ok {
+				v.Server, _ = data["version"].(string)
+			}
+		}
+		resp.Body.Close()
 	}
 
-	if data, ok := result["data"].(map[string]interface{}); ok {
-		if v, ok := data["version"].(string); ok {
-			fmt.Printf("Server version: %s\n", v)
+	switch {
+	case jsonOutput():
+		printJSON(v)
+	case quietOutput():
+		fmt.Println(v.Client)
+	default:
+		fmt.Printf("%s v%s\n", appName, v.Client)
+		if v.Server != "" {
+			fmt.Printf("Server version: %s\n", v.Server)
 		}
 	}
 }
 
+// statusInfo is the JSON schema of status.
+type statusInfo struct {
+	Locked       bool   `json:"locked"`
+	LockType     string `json:"lock_type,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	LockedAt     string `json:"locked_at,omitempty"`
+	LockedByIP   string `json:"locked_by_ip,omitempty"`
+	LockedByUser string `json:"locked_by_user,omitempty"`
+}
+
+// handleStatus shows the lock status and exits with exitLocked while the
+// system is locked.
 func handleStatus() {
-	resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/system/lock/status", host))
+	resp, err := apiRequest(http.MethodGet, "/api/v1/system/lock/status", nil)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatal(err)
 	}
-	defer resp.Body.Close()
-
-	var status map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
-		fmt.Printf("Error parsing response: %v\n", err)
-		os.Exit(1)
+	result := decodeResponse(resp, "get system status")
+
+	var status statusInfo
+	status.Locked, _ = result["is_locked"].(bool)
+	if status.Locked {
+		status.LockType, _ = result["lock_type"].(string)
+		status.Reason, _ = result["lock_reason"].(string)
+		status.LockedAt, _ = result["locked_at"].(string)
+		status.LockedByIP, _ = result["locked_by_ip"].(string)
+		status.LockedByUser, _ = result["locked_by_user"].(string)
 	}
 
-	fmt.Println("System Status:")
-	fmt.Println("==============")
-
-	if isLocked, ok := status["is_locked"].(bool); ok && isLocked {
-		fmt.Println("Status: LOCKED")
-		if reason, ok := status["lock_reason"].(string); ok {
-			fmt.Printf("Reason: %s\n", reason)
-		}
-		if lockedAt, ok := status["locked_at"].(string); ok {
-			fmt.Printf("Locked at: %s\n", lockedAt)
-		}
-		if ip, ok := status["locked_by_ip"].(string); ok {
-			fmt.Printf("Locked by IP: %s\n", ip)
+	switch {
+	case jsonOutput():
+		printJSON(status)
+	case quietOutput():
+	default:
+		fmt.Println("System Status:")
+		fmt.Println("==============")
+		if status.Locked {
+			fmt.Println("Status: LOCKED")
+			fmt.Printf("Reason: %s\n", status.Reason)
+			fmt.Printf("Locked at: %s\n", status.LockedAt)
+			fmt.Printf("Locked by IP: %s\n", status.LockedByIP)
+		} else {
+			fmt.Println("Status: UNLOCKED")
 		}
-	} else {
-		fmt.Println("Status: UNLOCKED")
+	}
+	if status.Locked {
+		os.Exit(exitLocked)
 	}
 }
 
@@ -141,52 +319,81 @@ func handleLock(args []string) {
 		reason = strings.Join(args, " ")
 	}
 
-	body := fmt.Sprintf(`{"reason": "%s"}`, reason)
-	resp, err := http.Post(
-		fmt.Sprintf("http://%s/api/v1/system/lock/lock", host),
-		"application/json",
-		strings.NewReader(body),
-	)
+	resp, err := apiRequest(http.MethodPost, "/api/v1/system/lock/lock", jsonBody(lockRequest{Reason: reason}))
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatal(err)
 	}
-	defer resp.Body.Close()
+	decodeResponse(resp, "lock system")
 
-	if resp.StatusCode == http.StatusOK {
-		fmt.Println("System locked successfully")
-	} else {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Failed to lock system: %s\n", string(body))
-		os.Exit(1)
+	report(statusInfo{Locked: true, Reason: reason}, "System locked successfully\n")
+}
+
+func handleUnlock(args []string) {
+	fs := newFlagSet("unlock")
+	username := fs.String("username", "", "Administrator username (default: admin)")
+	passwordStdin := fs.Bool("password-stdin", false, "Read the password from stdin")
+	otp := fs.String("otp", "", "TOTP code when the server requires two-factor unlock")
+	tokenFile := fs.String("unlock-token-file", "", "Unlock with the one-time token in this file instead of a password")
+	fs.Parse(args)
+
+	var req unlockRequest
+	switch {
+	case *tokenFile != "":
+		data, err := os.ReadFile(*tokenFile)
+		if err != nil {
+			fatalf("Error reading unlock token: %v", err)
+		}
+		req.UnlockToken = strings.TrimSpace(string(data))
+	case os.Getenv("CYP_UNLOCK_TOKEN") != "":
+		req.UnlockToken = os.Getenv("CYP_UNLOCK_TOKEN")
+	default:
+		req.Password = unlockPassword(*passwordStdin)
+		req.Username = *username
+		req.OTP = *otp
+	}
+
+	result, status := sendUnlock(req)
+	// 服务器要求动态码时在终端上询问
+	if result["code"] == "otp_required" && req.OTP == "" && !*passwordStdin && stdinIsTerminal() {
+		fmt.Fprint(os.Stderr, "OTP code: ")
+		req.OTP = readStdinLine()
+		result, status = sendUnlock(req)
 	}
+
+	if status != http.StatusOK {
+		failResponse("unlock system", status, result)
+	}
+	report(statusInfo{Locked: false}, "System unlocked successfully\n")
 }
 
-func handleUnlock() {
-	if password == "" {
-		fmt.Print("Enter admin password: ")
-		fmt.Scanln(&password)
+// unlockPassword returns the unlock password from stdin, the deprecated
+// -password flag, $CYP_UNLOCK_PASSWORD or a prompt without echo, in that
+// order.
+func unlockPassword(fromStdin bool) string {
+	switch {
+	case fromStdin:
+		return readStdinLine()
+	case password != "":
+		warnPasswordFlag()
+		return password
+	case os.Getenv("CYP_UNLOCK_PASSWORD") != "":
+		return os.Getenv("CYP_UNLOCK_PASSWORD")
 	}
+	return promptSecret("Enter admin password: ")
+}
 
-	body := fmt.Sprintf(`{"password": "%s"}`, password)
-	resp, err := http.Post(
-		fmt.Sprintf("http://%s/api/v1/system/lock/unlock", host),
-		"application/json",
-		strings.NewReader(body),
-	)
+// sendUnlock posts an unlock request and returns the decoded response and
+// its status.
+func sendUnlock(req unlockRequest) (map[string]interface{}, int) {
+	resp, err := apiRequest(http.MethodPost, "/api/v1/system/lock/unlock", jsonBody(req))
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatal(err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusOK {
-		fmt.Println("System unlocked successfully")
-	} else {
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Printf("Failed to unlock system: %s\n", string(body))
-		os.Exit(1)
-	}
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	return result, resp.StatusCode
 }
 
 func handleAudit(args []string) {
@@ -207,68 +414,81 @@ func handleAudit(args []string) {
 	case "verify":
 		verifyAuditLogs()
 	default:
-		fmt.Printf("Unknown audit command: %s\n", args[0])
-		os.Exit(1)
+		fatalf("Unknown audit command: %s", args[0])
 	}
 }
 
+// auditEntry is the JSON schema of an audit log entry in audit tail.
+type auditEntry struct {
+	ID        int64                  `json:"id"`
+	Timestamp string                 `json:"timestamp"`
+	Level     string                 `json:"level"`
+	Event     string                 `json:"event"`
+	Username  string                 `json:"username,omitempty"`
+	IPAddress string                 `json:"ip_address"`
+	Resource  string                 `json:"resource,omitempty"`
+	Action    string                 `json:"action,omitempty"`
+	Status    string                 `json:"status"`
+	RequestID string                 `json:"request_id,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
 func showAuditLogs(n int) {
-	resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/audit/logs?page_size=%d", host, n))
+	resp, err := apiRequest(http.MethodGet, fmt.Sprintf("/api/v1/audit/logs?page_size=%d", n), nil)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatal(err)
 	}
-	defer resp.Body.Close()
+	result := decodeResponse(resp, "read audit logs")
 
-	var result map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		fmt.Printf("Error parsing response: %v\n", err)
-		os.Exit(1)
+	logs := []auditEntry{}
+	convert(result["logs"], &logs)
+
+	switch {
+	case jsonOutput():
+		printJSON(map[string]interface{}{"logs": logs})
+		return
+	case quietOutput():
+		for _, l := range logs {
+			fmt.Println(l.ID)
+		}
+		return
 	}
 
-	logs, ok := result["logs"].([]interface{})
-	if !ok {
+	if len(logs) == 0 {
 		fmt.Println("No logs found")
 		return
 	}
-
 	fmt.Printf("Recent %d audit logs:\n", len(logs))
 	fmt.Println("==================")
-
-	for _, log := range logs {
-		if l, ok := log.(map[string]interface{}); ok {
-			timestamp := l["timestamp"]
-			event := l["event"]
-			ip := l["ip_address"]
-			status := l["status"]
-			fmt.Printf("[%v] %v from %v - %v\n", timestamp, event, ip, status)
-		}
+	for _, l := range logs {
+		fmt.Printf("[%s] %s from %s - %s\n", l.Timestamp, l.Event, l.IPAddress, l.Status)
 	}
 }
 
 func exportAuditLogs() {
-	resp, err := http.Get(fmt.Sprintf("http://%s/api/v1/audit/logs/export", host))
+	resp, err := apiRequest(http.MethodGet, "/api/v1/audit/logs/export", nil)
 	if err != nil {
-		fmt.Printf("Error: %v\n", err)
-		os.Exit(1)
+		fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		decodeResponse(resp, "export audit logs")
+		return
 	}
 	defer resp.Body.Close()
 
 	filename := "audit-logs.json"
 	file, err := os.Create(filename)
 	if err != nil {
-		fmt.Printf("Error creating file: %v\n", err)
-		os.Exit(1)
+		fatalf("Error creating file: %v", err)
 	}
 	defer file.Close()
 
 	_, err = io.Copy(file, resp.Body)
 	if err != nil {
-		fmt.Printf("Error writing file: %v\n", err)
-		os.Exit(1)
+		fatalf("Error writing file: %v", err)
 	}
 
-	fmt.Printf("Audit logs exported to %s\n", filename)
+	report(map[string]string{"file": filename}, "Audit logs exported to %s\n", filename)
 }
 
 func verifyAuditLogs() {
@@ -276,3 +496,417 @@ func verifyAuditLogs() {
 	// TODO: Implement blockchain hash verification
 	fmt.Println("Verification complete: All logs are intact")
 }
+
+// apiRequest sends an authenticated request to the server.
+func apiRequest(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, serverURL()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return doRequest(req)
+}
+
+// decodeResponse decodes a JSON response, exiting on non-2xx status.
+func decodeResponse(resp *http.Response, action string) map[string]interface{} {
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		failResponse(action, resp.StatusCode, result)
+	}
+	return result
+}
+
+func handleP2P(args []string) {
+	if len(args) == 0 || args[0] != "keygen" {
+		fmt.Println("Usage: cyp-cli p2p keygen [-o file]")
+		os.Exit(1)
+	}
+
+	fs := newFlagSet("p2p keygen")
+	output := fs.String("o", "", "Write the key to a file instead of stdout")
+	fs.Parse(args[1:])
+
+	generateSwarmKey(*output)
+}
+
+// generateSwarmKey writes a libp2p v1 pre-shared key, the same format as IPFS swarm.key.
+func generateSwarmKey(output string) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		fatalf("Failed to generate key: %v", err)
+	}
+	content := fmt.Sprintf("/key/swarm/psk/1.0.0/\n/base16/\n%s\n", hex.EncodeToString(key))
+
+	if output == "" {
+		fmt.Print(content)
+		return
+	}
+
+	file, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fatalf("Failed to create %s: %v", output, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		fatalf("Failed to write %s: %v", output, err)
+	}
+	fmt.Printf("Swarm key written to %s\n", output)
+	fmt.Println("Copy it to every node and set p2p.swarm_key_path in the config.")
+}
+
+func handleImage(args []string) {
+	if len(args) == 0 || (args[0] != "list" && len(args) < 2) {
+		fmt.Println("Usage: cyp-cli image list [-page n] [-page-size n] [-format table|json]")
+		fmt.Println("       cyp-cli image search <keyword> [-page n] [-page-size n] [-format table|json]")
+		fmt.Println("       cyp-cli image inspect <name:tag> [-format table|json]")
+		fmt.Println("       cyp-cli image delete <name:tag>")
+		fmt.Println("       cyp-cli image export <name:tag> [-format docker|oci] [-o file]")
+		fmt.Println("       cyp-cli image import <file> [-repository name] [-tag tag]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := newFlagSet("image list")
+		opts := addListFlags(fs, 20)
+		fs.Parse(args[1:])
+		listImages("", opts)
+	case "search":
+		fs := newFlagSet("image search")
+		opts := addListFlags(fs, 20)
+		fs.Parse(args[2:])
+		listImages(args[1], opts)
+	case "inspect":
+		fs := newFlagSet("image inspect")
+		format := addFormatFlag(fs)
+		fs.Parse(args[2:])
+		inspectImage(args[1], *format)
+	case "delete":
+		deleteImage(args[1])
+	case "export":
+		fs := newFlagSet("image export")
+		format := fs.String("format", "docker", "Archive format: docker or oci")
+		output := fs.String("o", "", "Output file (default: <name>_<tag>.tar)")
+		fs.Parse(args[2:])
+		exportImage(args[1], *format, *output)
+	case "import":
+		fs := newFlagSet("image import")
+		repository := fs.String("repository", "", "Repository name overriding the archive")
+		tag := fs.String("tag", "", "Tag overriding the archive (single-image archives only)")
+		fs.Parse(args[2:])
+		importImage(args[1], *repository, *tag)
+	default:
+		fatalf("Unknown image command: %s", args[0])
+	}
+}
+
+func handleTags(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli tags <repository> [-page n] [-page-size n] [-format table|json]")
+		os.Exit(1)
+	}
+	fs := newFlagSet("tags")
+	opts := addListFlags(fs, 0)
+	fs.Parse(args[1:])
+	listTags(args[0], opts)
+}
+
+func handleCopy(args []string) {
+	if len(args) < 2 {
+		fmt.Println("Usage: cyp-cli copy <name:tag> <name[:tag]> [-overwrite]")
+		os.Exit(1)
+	}
+	fs := newFlagSet("copy")
+	overwrite := fs.Bool("overwrite", false, "Replace an existing target tag")
+	fs.Parse(args[2:])
+	copyImage(args[0], args[1], *overwrite)
+}
+
+// splitImageRef splits name:tag, defaulting the tag to latest.
+func splitImageRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, "latest"
+}
+
+func exportImage(ref, format, output string) {
+	name, tag := splitImageRef(ref)
+	path := fmt.Sprintf("/api/v1/images/%s/%s/export?format=%s", name, tag, url.QueryEscape(format))
+	resp, err := apiRequest(http.MethodGet, path, nil)
+	if err != nil {
+		fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		decodeResponse(resp, "export image")
+		return
+	}
+	defer resp.Body.Close()
+
+	if output == "" {
+		output = strings.ReplaceAll(name, "/", "_") + "_" + tag + ".tar"
+	}
+	file, err := os.Create(output)
+	if err != nil {
+		fatalf("Error creating file: %v", err)
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, resp.Body)
+	if err != nil {
+		fatalf("Error writing file: %v", err)
+	}
+	report(map[string]interface{}{"name": name, "tag": tag, "file": output, "size": n},
+		"Exported %s:%s to %s (%d bytes)\n", name, tag, output, n)
+}
+
+func importImage(filename, repository, tag string) {
+	file, err := os.Open(filename)
+	if err != nil {
+		fatalf("Error opening file: %v", err)
+	}
+	defer file.Close()
+
+	query := url.Values{}
+	if repository != "" {
+		query.Set("repository", repository)
+	}
+	if tag != "" {
+		query.Set("tag", tag)
+	}
+	path := "/api/v1/images/import"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	resp, err := apiRequest(http.MethodPost, path, file)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "import image")
+
+	data, _ := result["data"].(map[string]interface{})
+	images := []imageInfo{}
+	convert(data["images"], &images)
+	if jsonOutput() {
+		printJSON(map[string]interface{}{"images": images})
+		return
+	}
+	for _, image := range images {
+		if quietOutput() {
+			fmt.Println(image.ref())
+			continue
+		}
+		fmt.Printf("Imported %s (%s)\n", image.ref(), image.Digest)
+	}
+}
+
+func handleUser(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli user <list|create|role|activate|deactivate|reset-password|delete>")
+		os.Exit(1)
+	}
+
+	if args[0] != "list" && len(args) < 2 {
+		fatalf("Usage: cyp-cli user %s <user>", args[0])
+	}
+
+	switch args[0] {
+	case "list":
+		search := ""
+		if len(args) > 1 {
+			search = args[1]
+		}
+		listUsers(search)
+	case "create":
+		fs := newFlagSet("user create")
+		pw := fs.String("password", "", "Initial password")
+		email := fs.String("email", "", "Email address")
+		role := fs.String("role", "user", "Role: admin or user")
+		fs.Parse(args[2:])
+		createUser(args[1], *pw, *email, *role)
+	case "role":
+		if len(args) < 3 {
+			fmt.Println("Usage: cyp-cli user role <user> <admin|user>")
+			os.Exit(1)
+		}
+		setUserRole(args[1], args[2])
+	case "activate":
+		userAction(args[1], "activate", "activate user", "User %s activated\n")
+	case "deactivate":
+		userAction(args[1], "deactivate", "deactivate user", "User %s deactivated\n")
+	case "reset-password":
+		fs := newFlagSet("user reset-password")
+		pw := fs.String("password", "", "New password (default: generate one)")
+		fs.Parse(args[2:])
+		resetUserPassword(args[1], *pw)
+	case "delete":
+		deleteUser(args[1])
+	default:
+		fatalf("Unknown user command: %s", args[0])
+	}
+}
+
+func listUsers(search string) {
+	path := "/api/v1/admin/users?page_size=100"
+	if search != "" {
+		path += "&search=" + url.QueryEscape(search)
+	}
+	resp, err := apiRequest(http.MethodGet, path, nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "list users")
+
+	users := []userInfo{}
+	convert(result["users"], &users)
+	total, _ := result["total"].(float64)
+
+	switch {
+	case jsonOutput():
+		printJSON(map[string]interface{}{"users": users, "total": int(total)})
+		return
+	case quietOutput():
+		for _, u := range users {
+			fmt.Println(u.Username)
+		}
+		return
+	}
+
+	if len(users) == 0 {
+		fmt.Println("No users found")
+		return
+	}
+
+	fmt.Printf("%-6s %-20s %-8s %-8s %s\n", "ID", "USERNAME", "ROLE", "ACTIVE", "EMAIL")
+	for _, u := range users {
+		fmt.Printf("%-6d %-20s %-8s %-8t %s\n", u.ID, u.Username, u.Role, u.IsActive, u.Email)
+	}
+	if int(total) > len(users) {
+		fmt.Printf("(%d of %.0f users shown)\n", len(users), total)
+	}
+}
+
+// userInfo is the JSON schema of a user in user list and create.
+type userInfo struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
+	Role     string `json:"role"`
+	IsActive bool   `json:"is_active"`
+}
+
+// resolveUserID accepts a user ID or an exact username.
+func resolveUserID(user string) string {
+	if _, err := strconv.ParseInt(user, 10, 64); err == nil {
+		return user
+	}
+
+	resp, err := apiRequest(http.MethodGet, "/api/v1/admin/users?page_size=100&search="+url.QueryEscape(user), nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "look up user")
+
+	users, _ := result["users"].([]interface{})
+	for _, item := range users {
+		if u, ok := item.(map[string]interface{}); ok && u["username"] == user {
+			return fmt.Sprintf("%.0f", u["id"])
+		}
+	}
+	fatalf("User not found: %s", user)
+	return ""
+}
+
+func createUser(username, pw, email, role string) {
+	if pw == "" {
+		fmt.Println("Usage: cyp-cli user create <username> -password pw [-email e] [-role admin|user]")
+		os.Exit(1)
+	}
+	body := jsonBody(createUserRequest{
+		Username: username,
+		Password: pw,
+		Email:    email,
+		Role:     role,
+	})
+	resp, err := apiRequest(http.MethodPost, "/api/v1/admin/users", body)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "create user")
+
+	var u userInfo
+	convert(result["user"], &u)
+	report(u, "User %s created (id %d, role %s)\n", u.Username, u.ID, u.Role)
+}
+
+func setUserRole(user, role string) {
+	id := resolveUserID(user)
+	resp, err := apiRequest(http.MethodPut, "/api/v1/admin/users/"+id+"/role", jsonBody(roleRequest{Role: role}))
+	if err != nil {
+		fatal(err)
+	}
+	decodeResponse(resp, "change role")
+
+	report(map[string]string{"user": user, "role": role}, "User %s is now %s\n", user, role)
+}
+
+func userAction(user, action, desc, done string) {
+	id := resolveUserID(user)
+	resp, err := apiRequest(http.MethodPost, "/api/v1/admin/users/"+id+"/"+action, nil)
+	if err != nil {
+		fatal(err)
+	}
+	decodeResponse(resp, desc)
+
+	report(map[string]string{"user": user, "action": action}, done, user)
+}
+
+func resetUserPassword(user, pw string) {
+	id := resolveUserID(user)
+	var body io.Reader
+	if pw != "" {
+		body = jsonBody(passwordRequest{Password: pw})
+	}
+	resp, err := apiRequest(http.MethodPost, "/api/v1/admin/users/"+id+"/reset-password", body)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "reset password")
+
+	temp, _ := result["temporary_password"].(string)
+	switch {
+	case jsonOutput():
+		printJSON(map[string]string{"user": user, "temporary_password": temp})
+	case quietOutput():
+		if temp != "" {
+			fmt.Println(temp)
+		}
+	default:
+		fmt.Printf("Password of %s reset, it must be changed at the next login\n", user)
+		if temp != "" {
+			fmt.Printf("Temporary password: %s\n", temp)
+		}
+	}
+}
+
+func deleteUser(user string) {
+	id := resolveUserID(user)
+	resp, err := apiRequest(http.MethodDelete, "/api/v1/admin/users/"+id, nil)
+	if err != nil {
+		fatal(err)
+	}
+	decodeResponse(resp, "delete user")
+
+	report(map[string]string{"user": user}, "User %s deleted\n", user)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Output modes of -output.
+const (
+	outputTable = "table" // 供人阅读的表格和提示
+	outputJSON  = "json"  // 结构稳定的 JSON，错误也输出为 JSON
+	outputQuiet = "quiet" // 只输出标识符（如 name:tag），错误写到 stderr
+)
+
+// outputMode is the -output flag.
+var outputMode string
+
+// Exit statuses, the same for every command so scripts can branch on them.
+const (
+	exitOK      = 0
+	exitError   = 1 // 请求失败或参数错误
+	exitAuth    = 2 // 未登录、令牌无效或权限不足
+	exitLocked  = 3 // 系统已锁定
+	exitTimeout = 4 // -wait 超过 -timeout
+	exitBusy    = 5 // 服务器正在执行同类操作，如垃圾回收
+)
+
+// errorOutput is the JSON schema of an error in json mode.
+type errorOutput struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"` // 服务器返回的错误码
+	Status    int    `json:"status,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	ExitCode  int    `json:"exit_code"`
+}
+
+// checkOutput exits on an unknown -output value.
+func checkOutput() {
+	switch outputMode {
+	case outputTable, outputJSON, outputQuiet:
+	default:
+		fmt.Printf("Unknown output mode: %s (use json, table or quiet)\n", outputMode)
+		os.Exit(exitError)
+	}
+}
+
+func isTableOutput() bool { return outputMode == outputTable }
+func jsonOutput() bool    { return outputMode == outputJSON }
+func quietOutput() bool   { return outputMode == outputQuiet }
+
+// newFlagSet creates the flag set of a command. Invalid flags exit with
+// exitError instead of the flag package's 2, which means exitAuth here.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", name)
+		fs.PrintDefaults()
+		os.Exit(exitError)
+	}
+	return fs
+}
+
+// defaultFormat is the default of the -format flags: json under -output
+// json, table otherwise.
+func defaultFormat() string {
+	if jsonOutput() {
+		return outputJSON
+	}
+	return outputTable
+}
+
+// addFormatFlag registers the -format flag of a command.
+func addFormatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", defaultFormat(), "Output format: table or json (default: -output)")
+}
+
+// info prints a progress or status line in table mode only, keeping json
+// and quiet output parseable.
+func info(format string, args ...interface{}) {
+	if isTableOutput() {
+		fmt.Printf(format, args...)
+	}
+}
+
+// report prints the outcome of a command: the message in table mode, v in
+// json mode and nothing in quiet mode.
+func report(v interface{}, format string, args ...interface{}) {
+	switch outputMode {
+	case outputJSON:
+		printJSON(v)
+	case outputQuiet:
+	default:
+		fmt.Printf(format, args...)
+	}
+}
+
+// fail prints an error in the output mode and exits with exitCode.
+func fail(exitCode int, e errorOutput) {
+	e.ExitCode = exitCode
+	switch outputMode {
+	case outputJSON:
+		printJSON(e)
+	case outputQuiet:
+		fmt.Fprintln(os.Stderr, e.Error)
+	default:
+		fmt.Println(e.Error)
+	}
+	os.Exit(exitCode)
+}
+
+// fatal exits after a request could not be sent.
+func fatal(err error) {
+	fail(exitError, errorOutput{Error: "Error: " + err.Error()})
+}
+
+// fatalf exits with exitError and a formatted message.
+func fatalf(format string, args ...interface{}) {
+	fail(exitError, errorOutput{Error: fmt.Sprintf(format, args...)})
+}
+
+// failResponse exits after a non-2xx response, choosing the exit status by
+// the error code and status of the response.
+func failResponse(action string, status int, result map[string]interface{}) {
+	e := errorOutput{Status: status}
+	msg := http.StatusText(status)
+	if m, ok := result["error"].(string); ok {
+		msg = m
+	}
+	e.Code, _ = result["code"].(string)
+	e.RequestID, _ = result["request_id"].(string)
+	if retry, ok := result["retry_after"].(float64); ok && retry > 0 {
+		msg += fmt.Sprintf(" (retry after %.0fs)", retry)
+	}
+	if e.RequestID != "" {
+		msg += " (request id: " + e.RequestID + ")"
+	}
+	e.Error = fmt.Sprintf("Failed to %s: %s", action, msg)
+	fail(exitCodeFor(status, e.Code), e)
+}
+
+// exitCodeFor maps an error response to an exit status.
+func exitCodeFor(status int, code string) int {
+	switch {
+	case code == "system_locked" || code == "manual_unlock_disabled":
+		return exitLocked
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return exitAuth
+	}
+	return exitError
+}
+
+// convert copies a decoded JSON value into the stable output type out.
+func convert(v interface{}, out interface{}) {
+	data, _ := json.Marshal(v)
+	json.Unmarshal(data, out)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// readStdinLine reads one line from stdin, for -password-stdin.
+func readStdinLine() string {
+	reader := bufio.NewReader(os.Stdin)
+	line, _ := reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+// stdinIsTerminal reports whether stdin is an interactive terminal.
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// promptSecret prompts on stderr and reads a line without echoing it when
+// stdin is a terminal.
+func promptSecret(prompt string) string {
+	fmt.Fprint(os.Stderr, prompt)
+	if !stdinIsTerminal() {
+		line := readStdinLine()
+		fmt.Fprintln(os.Stderr)
+		return line
+	}
+
+	restore, err := disableEcho()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nCannot hide input: %v\n", err)
+		os.Exit(1)
+	}
+	// Ctrl-C 时恢复回显再退出
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		if _, ok := <-interrupted; ok {
+			restore()
+			fmt.Fprintln(os.Stderr)
+			os.Exit(130)
+		}
+	}()
+
+	line := readStdinLine()
+	signal.Stop(interrupted)
+	close(interrupted)
+	restore()
+	fmt.Fprintln(os.Stderr)
+	return line
+}
+
+// warnPasswordFlag warns that -password leaks through shell history and
+// the process list.
+func warnPasswordFlag() {
+	if password != "" {
+		fmt.Fprintln(os.Stderr, "Warning: -password is visible in shell history and the process list,")
+		fmt.Fprintln(os.Stderr, "use the prompt, -password-stdin or an environment variable instead.")
+	}
+}
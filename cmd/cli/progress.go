@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// barWidth is the width of the progress bar in characters.
+const barWidth = 30
+
+// progressBar renders progress on stderr. On a terminal the bar is redrawn
+// in place; otherwise, e.g. under cron, only phase changes are printed so
+// logs stay readable.
+type progressBar struct {
+	quiet    bool
+	terminal bool
+	label    string
+	drawn    bool
+}
+
+func newProgressBar(quiet bool) *progressBar {
+	fi, err := os.Stderr.Stat()
+	return &progressBar{
+		quiet:    quiet,
+		terminal: err == nil && fi.Mode()&os.ModeCharDevice != 0,
+	}
+}
+
+// update shows done out of total for label. bytes selects size or count
+// formatting.
+func (b *progressBar) update(label string, done, total float64, bytes bool) {
+	if b.quiet {
+		return
+	}
+	if !b.terminal {
+		if label != b.label {
+			fmt.Fprintf(os.Stderr, "%s...\n", label)
+			b.label = label
+		}
+		return
+	}
+	if label != b.label && b.drawn {
+		// 新阶段换行，保留上一阶段的最终状态
+		fmt.Fprintln(os.Stderr)
+	}
+	b.label = label
+
+	ratio := 0.0
+	if total > 0 {
+		ratio = min(done/total, 1)
+	}
+	filled := int(ratio * barWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+
+	count := fmt.Sprintf("%.0f/%.0f", done, total)
+	if bytes {
+		count = formatSize(done) + " / " + formatSize(total)
+	}
+	fmt.Fprintf(os.Stderr, "\r%-12s [%s] %3.0f%% %s\033[K", label, bar, ratio*100, count)
+	b.drawn = true
+}
+
+// done ends the current line of the bar.
+func (b *progressBar) done() {
+	if b.drawn {
+		fmt.Fprintln(os.Stderr)
+		b.drawn = false
+	}
+}
+
+// eventDrainTimeout bounds how long stop waits for the final event, which
+// may arrive after the HTTP response.
+const eventDrainTimeout = 2 * time.Second
+
+// watchSystemEvents subscribes to the system topic over WebSocket and calls
+// handle for every event until the returned stop function is called. It
+// returns once the subscription is confirmed, so no event of an operation
+// started afterwards is missed. handle returns true on the final event of
+// the operation, which stop waits for so the bar ends complete. Progress is
+// cosmetic: when the connection fails, handle is simply never called.
+func watchSystemEvents(handle func(event string, data map[string]interface{}) bool) (stop func()) {
+	u, err := url.Parse(serverURL() + "/api/v1/ws?topics=system")
+	if err != nil {
+		return func() {}
+	}
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+	}
+	if transport, ok := getHTTPClient().Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return func() {}
+	}
+
+	type wsMessage struct {
+		Type  string                 `json:"type"`
+		Event string                 `json:"event"`
+		Data  map[string]interface{} `json:"data"`
+	}
+
+	// ready is closed once subscribed, or when the connection ends first
+	ready := make(chan struct{})
+	var readyOnce sync.Once
+	events := make(chan wsMessage, 64)
+	go func() {
+		defer close(events)
+		defer readyOnce.Do(func() { close(ready) })
+		for {
+			var msg wsMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Type == "subscribed" {
+				readyOnce.Do(func() { close(ready) })
+				continue
+			}
+			if msg.Type == "system" {
+				events <- msg
+			}
+		}
+	}()
+
+	select {
+	case <-ready:
+	case <-time.After(5 * time.Second):
+	}
+
+	finished := make(chan struct{})
+	final := make(chan struct{})
+	go func() {
+		defer close(finished)
+		closed := false
+		for msg := range events {
+			if handle(msg.Event, msg.Data) && !closed {
+				close(final)
+				closed = true
+			}
+		}
+	}()
+
+	return func() {
+		select {
+		case <-final:
+		case <-finished:
+		case <-time.After(eventDrainTimeout):
+		}
+		conn.Close()
+		<-finished
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Request bodies of the API. Building them as structs keeps quotes,
+// backslashes and control characters in user input from breaking the JSON.
+
+type lockRequest struct {
+	Reason string `json:"reason"`
+}
+
+type unlockRequest struct {
+	Username    string `json:"username,omitempty"`
+	Password    string `json:"password,omitempty"`
+	OTP         string `json:"otp,omitempty"`
+	UnlockToken string `json:"unlock_token,omitempty"`
+}
+
+type loginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type createTokenRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn string   `json:"expires_in"`
+}
+
+type createUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+type roleRequest struct {
+	Role string `json:"role"`
+}
+
+type passwordRequest struct {
+	Password string `json:"password"`
+}
+
+type backupRequest struct {
+	IncludeBlobs bool `json:"include_blobs"`
+}
+
+// copyRequest is the body of retag (same repository) and promote.
+type copyRequest struct {
+	Repository string `json:"repository,omitempty"`
+	Tag        string `json:"tag"`
+	Overwrite  bool   `json:"overwrite"`
+}
+
+type syncRequest struct {
+	ImageName      string `json:"image_name"`
+	ImageTag       string `json:"image_tag"`
+	TargetRegistry string `json:"target_registry"`
+	TargetImage    string `json:"target_image,omitempty"`
+	TargetTag      string `json:"target_tag,omitempty"`
+}
+
+// jsonBody encodes a request body for apiRequest.
+func jsonBody(v interface{}) io.Reader {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fatalf("Error encoding request: %v", err)
+	}
+	return bytes.NewReader(data)
+}
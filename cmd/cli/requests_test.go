@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// awkwardInputs are values that broke the hand-built JSON bodies.
+var awkwardInputs = []string{
+	`maintenance "window"`,
+	`C:\registry\data`,
+	"line one\nline two\r\n\ttabbed",
+	"维护中：升级存储",
+	"emoji ✓ and nul \x00",
+	`"}, "role": "admin`,
+}
+
+// decodeBody reads a jsonBody result back into out, failing on invalid JSON.
+func decodeBody(t *testing.T, body io.Reader, out interface{}) {
+	t.Helper()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+	if !json.Valid(data) {
+		t.Fatalf("invalid JSON: %s", data)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+}
+
+func TestJSONBodyLockReason(t *testing.T) {
+	for _, reason := range awkwardInputs {
+		var got lockRequest
+		decodeBody(t, jsonBody(lockRequest{Reason: reason}), &got)
+		if got.Reason != reason {
+			t.Errorf("reason = %q, want %q", got.Reason, reason)
+		}
+	}
+}
+
+func TestJSONBodyNames(t *testing.T) {
+	for _, name := range awkwardInputs {
+		tests := []interface{}{
+			&loginRequest{Username: name, Password: name},
+			&unlockRequest{Username: name, Password: name, OTP: name, UnlockToken: name},
+			&createTokenRequest{Name: name, Scopes: []string{name}, ExpiresIn: "30d"},
+			&createUserRequest{Username: name, Password: name, Email: name, Role: "user"},
+			&copyRequest{Repository: name, Tag: name},
+			&syncRequest{ImageName: name, ImageTag: name, TargetRegistry: name},
+		}
+		for _, want := range tests {
+			got := reflect.New(reflect.TypeOf(want).Elem()).Interface()
+			decodeBody(t, jsonBody(want), got)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%T: got %+v, want %+v", want, got, want)
+			}
+		}
+	}
+}
+
+func TestJSONBodyNoExtraFields(t *testing.T) {
+	// A name crafted to close the string must stay a single field
+	var fields map[string]interface{}
+	decodeBody(t, jsonBody(createUserRequest{Username: `x", "role": "admin`, Role: "user"}), &fields)
+	if fields["role"] != "user" {
+		t.Errorf("role = %v, want user", fields["role"])
+	}
+	if len(fields) != 4 {
+		t.Errorf("fields = %v, want 4 fields", fields)
+	}
+}
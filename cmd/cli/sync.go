@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+func handleSync(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli sync run <name:tag> <registry> [-target name[:tag]] [-wait] [-timeout 30m]")
+		fmt.Println("       cyp-cli sync run -replication <rule-id>")
+		fmt.Println("       cyp-cli sync status <id> [-wait] [-timeout 30m] [-format table|json]")
+		fmt.Println("       cyp-cli sync history [-page n] [-page-size n] [-format table|json]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "run":
+		fs := newFlagSet("sync run")
+		target := fs.String("target", "", "Target image name[:tag] (default: the source)")
+		replication := fs.String("replication", "", "Run a pull replication rule instead of pushing an image")
+		wait := addWaitFlags(fs)
+		rest := parseArgs(fs, args[1:])
+		if *replication != "" {
+			runReplication(*replication)
+			return
+		}
+		if len(rest) < 2 {
+			fmt.Println("Usage: cyp-cli sync run <name:tag> <registry> [-target name[:tag]] [-wait] [-timeout 30m]")
+			os.Exit(1)
+		}
+		runSync(rest[0], rest[1], *target, wait)
+	case "status":
+		fs := newFlagSet("sync status")
+		format := addFormatFlag(fs)
+		wait := addWaitFlags(fs)
+		rest := parseArgs(fs, args[1:])
+		if len(rest) < 1 {
+			fmt.Println("Usage: cyp-cli sync status <id> [-wait] [-timeout 30m] [-format table|json]")
+			os.Exit(1)
+		}
+		if wait.wait {
+			waitForSync(rest[0], wait)
+		}
+		showSyncRecord(getSyncRecord(rest[0]), *format)
+	case "history":
+		fs := newFlagSet("sync history")
+		opts := addListFlags(fs, 20)
+		fs.Parse(args[1:])
+		listSyncHistory(opts)
+	default:
+		fatalf("Unknown sync command: %s", args[0])
+	}
+}
+
+// syncFinished reports whether a sync record reached a final status.
+func syncFinished(status string) bool {
+	return status == "completed" || status == "failed" || status == "conflict"
+}
+
+func runSync(ref, registry, target string, wait *waitOptions) {
+	name, tag := splitImageRef(ref)
+	req := syncRequest{
+		ImageName:      name,
+		ImageTag:       tag,
+		TargetRegistry: registry,
+	}
+	if target != "" {
+		req.TargetImage, req.TargetTag = splitImageRef(target)
+		if !strings.Contains(target[strings.LastIndex(target, "/")+1:], ":") {
+			req.TargetTag = tag
+		}
+	}
+
+	resp, err := apiRequest(http.MethodPost, "/api/sync", jsonBody(req))
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "start sync")
+
+	data, _ := result["data"].(map[string]interface{})
+	record, _ := data["record"].(map[string]interface{})
+	id, _ := record["id"].(string)
+	if !wait.wait {
+		if quietOutput() {
+			fmt.Println(id)
+			return
+		}
+		report(record, "Sync %s started: %s:%s -> %s\n", id, name, tag, registry)
+		return
+	}
+
+	info("Sync %s started: %s:%s -> %s\n", id, name, tag, registry)
+	waitForSync(id, wait)
+	showSyncRecord(getSyncRecord(id), defaultFormat())
+}
+
+// waitForSync polls a sync until it finishes, printing its progress, and
+// exits 1 unless it completed.
+func waitForSync(id string, wait *waitOptions) {
+	lastLine := ""
+	var record map[string]interface{}
+	wait.poll("sync "+id, func() bool {
+		record = getSyncRecord(id)
+		status, _ := record["status"].(string)
+		line := status
+		if status == "running" {
+			if progress := getSyncProgress(id); progress != nil {
+				total, _ := progress["total_bytes"].(float64)
+				pushed, _ := progress["pushed_bytes"].(float64)
+				if total > 0 {
+					line = fmt.Sprintf("running %.0f%% (%s / %s)", pushed*100/total, formatSize(pushed), formatSize(total))
+				}
+			}
+		}
+		if line != lastLine {
+			info("Sync %s: %s\n", id, line)
+			lastLine = line
+		}
+		return syncFinished(status)
+	})
+
+	if record["status"] != "completed" {
+		showSyncRecord(record, defaultFormat())
+		os.Exit(1)
+	}
+}
+
+func getSyncRecord(id string) map[string]interface{} {
+	resp, err := apiRequest(http.MethodGet, "/api/sync/history/"+url.PathEscape(id), nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "get sync status")
+	record, _ := result["data"].(map[string]interface{})
+	return record
+}
+
+// getSyncProgress returns the live progress of a running sync, or nil once
+// it is no longer tracked.
+func getSyncProgress(id string) map[string]interface{} {
+	resp, err := apiRequest(http.MethodGet, "/api/v1/sync/"+url.PathEscape(id)+"/progress", nil)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil
+	}
+	progress, _ := result["data"].(map[string]interface{})
+	return progress
+}
+
+func showSyncRecord(record map[string]interface{}, format string) {
+	switch {
+	case format == "json":
+		printJSON(record)
+		return
+	case quietOutput():
+		fmt.Println(record["status"])
+		return
+	}
+	fmt.Printf("ID:        %v\n", record["id"])
+	fmt.Printf("Image:     %v:%v\n", record["image_name"], record["image_tag"])
+	fmt.Printf("Target:    %v/%v:%v\n", record["target_registry"], record["target_image"], record["target_tag"])
+	fmt.Printf("Status:    %v\n", record["status"])
+	fmt.Printf("Started:   %v\n", record["started_at"])
+	if completed, ok := record["completed_at"].(string); ok {
+		fmt.Printf("Completed: %s\n", completed)
+	}
+	fmt.Printf("Synced:    %s\n", formatSize(record["bytes_synced"]))
+	if msg, ok := record["error_message"].(string); ok && msg != "" {
+		fmt.Printf("Error:     %s\n", msg)
+	}
+}
+
+func listSyncHistory(opts *listOptions) {
+	opts.checkFormat()
+
+	path := fmt.Sprintf("/api/sync/history?page=%d&page_size=%d", opts.page, opts.pageSize)
+	resp, err := apiRequest(http.MethodGet, path, nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "list sync history")
+	data, _ := result["data"].(map[string]interface{})
+
+	if opts.format == "json" {
+		printJSON(data)
+		return
+	}
+
+	records, _ := data["records"].([]interface{})
+	if quietOutput() {
+		for _, item := range records {
+			if r, ok := item.(map[string]interface{}); ok {
+				fmt.Println(r["id"])
+			}
+		}
+		return
+	}
+	if len(records) == 0 {
+		fmt.Println("No sync records found")
+		return
+	}
+	fmt.Printf("%-38s %-30s %-24s %-10s %s\n", "ID", "IMAGE", "TARGET", "STATUS", "STARTED")
+	for _, item := range records {
+		if r, ok := item.(map[string]interface{}); ok {
+			image := fmt.Sprintf("%v:%v", r["image_name"], r["image_tag"])
+			fmt.Printf("%-38v %-30s %-24v %-10v %s\n", r["id"], image, r["target_registry"], r["status"], shortTime(r["started_at"]))
+		}
+	}
+	fmt.Printf("(page %.0f of %.0f, %.0f records)\n", data["page"], data["total_pages"], data["total"])
+}
+
+// runReplication runs a pull replication rule. The server replies when the
+// run is done, so there is nothing to wait for.
+func runReplication(id string) {
+	info("Running replication rule %s...\n", id)
+	resp, err := apiRequest(http.MethodPost, "/api/sync/replication/"+url.PathEscape(id)+"/run", nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "run replication")
+
+	data, _ := result["data"].(map[string]interface{})
+	records, _ := data["records"].([]interface{})
+	failed := 0
+	for _, item := range records {
+		if r, ok := item.(map[string]interface{}); ok {
+			info("%-10v %v:%v\n", r["status"], r["image_name"], r["image_tag"])
+			if r["status"] == "failed" {
+				failed++
+			}
+		}
+	}
+	report(data, "Replication finished: %d images, %d failed\n", len(records), failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
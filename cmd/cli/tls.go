@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// TLS settings of the global flags or the selected context.
+var (
+	caCert     string
+	clientCert string
+	clientKey  string
+	insecure   bool
+)
+
+// httpClient is built on first use from the TLS settings.
+var httpClient *http.Client
+
+// tlsConfigured reports whether any TLS option was given, which makes
+// hosts without a scheme default to https.
+func tlsConfigured() bool {
+	return caCert != "" || clientCert != "" || clientKey != "" || insecure
+}
+
+// getHTTPClient returns the client for API requests, exiting when the
+// certificates cannot be loaded.
+func getHTTPClient() *http.Client {
+	if httpClient != nil {
+		return httpClient
+	}
+	if !tlsConfigured() {
+		httpClient = http.DefaultClient
+		return httpClient
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		pem, err := os.ReadFile(caCert)
+		if err != nil {
+			fatalf("Error reading CA certificate: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			fatalf("Error: no PEM certificates found in %s", caCert)
+		}
+		config.RootCAs = pool
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			fmt.Println("Error: -client-cert and -client-key must be given together")
+			os.Exit(1)
+		}
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			fatalf("Error loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if insecure {
+		fmt.Fprintln(os.Stderr, "Warning: TLS certificate verification is disabled (-insecure)")
+		config.InsecureSkipVerify = true
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	httpClient = &http.Client{Transport: transport}
+	return httpClient
+}
+
+// doRequest sends a request and explains the common TLS and scheme
+// mistakes.
+func doRequest(req *http.Request) (*http.Response, error) {
+	resp, err := getHTTPClient().Do(req)
+	if err != nil {
+		return nil, explainTLSError(req, err)
+	}
+
+	// Go 的 HTTPS 服务器对明文请求返回 400 和固定的提示
+	if req.URL.Scheme == "http" && resp.StatusCode == http.StatusBadRequest {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if bytes.Contains(body, []byte("HTTP request to an HTTPS server")) {
+			return nil, fmt.Errorf("%s expects HTTPS, use https://%s as host", req.URL.Host, req.URL.Host)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
+
+// explainTLSError adds a hint to errors caused by the scheme or the
+// certificates.
+func explainTLSError(req *http.Request, err error) error {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidCert x509.CertificateInvalidError
+	var recordErr tls.RecordHeaderError
+
+	switch {
+	case errors.As(err, &recordErr) || strings.Contains(err.Error(), "server gave HTTP response to HTTPS client"):
+		return fmt.Errorf("%s does not use TLS, use http://%s as host", req.URL.Host, req.URL.Host)
+	case errors.As(err, &unknownAuthority):
+		return fmt.Errorf("%w (pass the CA bundle with -ca-cert, or -insecure to skip verification)", err)
+	case errors.As(err, &hostnameErr):
+		return fmt.Errorf("%w (connect with a name in the certificate, or -insecure to skip verification)", err)
+	case errors.As(err, &invalidCert):
+		return fmt.Errorf("%w (renew the server certificate, or -insecure to skip verification)", err)
+	case strings.Contains(err.Error(), "certificate required") || strings.Contains(err.Error(), "bad certificate"):
+		return fmt.Errorf("%w (the server requires a client certificate, pass -client-cert and -client-key)", err)
+	case req.URL.Scheme == "http" && (errors.Is(err, io.EOF) || errors.Is(err, syscall.ECONNRESET)):
+		// TLS 服务器通常直接断开明文连接
+		return fmt.Errorf("%w (the server may expect HTTPS, use https://%s as host)", err, req.URL.Host)
+	}
+	return err
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Metrics of /metrics shown by top.
+const (
+	metricRequests      = "cyp_http_requests_total"
+	metricCacheHits     = `cyp_accelerator_cache_requests_total{result="hit"}`
+	metricCacheMisses   = `cyp_accelerator_cache_requests_total{result="miss"}`
+	metricActiveUploads = "cyp_registry_active_uploads"
+	metricP2PPeers      = "cyp_p2p_connected_peers"
+	metricDiskTotal     = "cyp_storage_disk_total_bytes"
+	metricDiskFree      = "cyp_storage_disk_free_bytes"
+	metricReadOnly      = "cyp_storage_read_only"
+)
+
+// topSnapshot is the JSON schema of top. Values the server does not export,
+// e.g. P2P peers with P2P disabled, are omitted.
+type topSnapshot struct {
+	Time           string       `json:"time"`
+	Interval       float64      `json:"interval_seconds"` // 速率的统计区间
+	RequestsPerSec float64      `json:"requests_per_sec"`
+	CacheHitRate   *float64     `json:"cache_hit_rate,omitempty"` // 区间内的命中率，区间内无请求时为累计命中率
+	ActiveUploads  *int64       `json:"active_uploads,omitempty"`
+	P2PPeers       *int64       `json:"p2p_peers,omitempty"`
+	DiskTotal      *int64       `json:"disk_total_bytes,omitempty"`
+	DiskFree       *int64       `json:"disk_free_bytes,omitempty"`
+	ReadOnly       bool         `json:"read_only"`
+	Events         []auditEntry `json:"events"`
+	EventsError    string       `json:"events_error,omitempty"` // 例如令牌不是管理员
+}
+
+// metricsSample is one scrape of /metrics.
+type metricsSample struct {
+	at     time.Time
+	values map[string]float64
+}
+
+func handleTop(args []string) {
+	fs := newFlagSet("top")
+	interval := fs.Duration("interval", 2*time.Second, "Refresh interval")
+	once := fs.Bool("once", false, "Print one snapshot and exit")
+	events := fs.Int("events", 10, "Number of recent audit events to show")
+	fs.Parse(args)
+
+	if *interval < 500*time.Millisecond {
+		fatalf("-interval must be at least 500ms")
+	}
+	if *events < 0 || *events > 100 {
+		fatalf("-events must be between 0 and 100")
+	}
+
+	// 速率需要两次采样
+	prev, err := scrapeMetrics()
+	if err != nil {
+		fatal(err)
+	}
+	time.Sleep(*interval)
+
+	if *once || !isTableOutput() {
+		cur, err := scrapeMetrics()
+		if err != nil {
+			fatal(err)
+		}
+		snap := takeSnapshot(prev, cur, *events)
+		switch {
+		case jsonOutput():
+			printJSON(snap)
+		case isTableOutput():
+			renderTop(snap, "")
+		}
+		return
+	}
+
+	terminal := stdoutIsTerminal()
+	if terminal {
+		// 隐藏光标，退出时恢复
+		fmt.Print("\033[?25l")
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			fmt.Print("\033[?25h\n")
+			os.Exit(exitOK)
+		}()
+	}
+
+	for {
+		var snap *topSnapshot
+		var problem string
+		cur, err := scrapeMetrics()
+		if err != nil {
+			// 服务器暂时不可达时继续刷新，恢复后自动显示
+			problem = err.Error()
+		} else {
+			snap = takeSnapshot(prev, cur, *events)
+			prev = cur
+		}
+
+		if terminal {
+			fmt.Print("\033[H\033[2J")
+		} else {
+			fmt.Println()
+		}
+		renderTop(snap, problem)
+		time.Sleep(*interval)
+	}
+}
+
+// scrapeMetrics reads the Prometheus text format of /metrics.
+func scrapeMetrics() (*metricsSample, error) {
+	resp, err := apiRequest(http.MethodGet, "/metrics", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET /metrics: %s", resp.Status)
+	}
+
+	sample := &metricsSample{at: time.Now(), values: map[string]float64{}}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			continue
+		}
+		sample.values[line[:i]] = v
+	}
+	return sample, scanner.Err()
+}
+
+// gauge returns a metric of the sample, nil when it is not exported.
+func (s *metricsSample) gauge(name string) *int64 {
+	v, ok := s.values[name]
+	if !ok {
+		return nil
+	}
+	n := int64(v)
+	return &n
+}
+
+// takeSnapshot computes the rates between two scrapes and reads the recent
+// audit events.
+func takeSnapshot(prev, cur *metricsSample, events int) *topSnapshot {
+	elapsed := cur.at.Sub(prev.at).Seconds()
+	snap := &topSnapshot{
+		Time:          cur.at.Format(time.RFC3339),
+		Interval:      elapsed,
+		ActiveUploads: cur.gauge(metricActiveUploads),
+		P2PPeers:      cur.gauge(metricP2PPeers),
+		DiskTotal:     cur.gauge(metricDiskTotal),
+		DiskFree:      cur.gauge(metricDiskFree),
+		ReadOnly:      cur.values[metricReadOnly] == 1,
+		Events:        []auditEntry{},
+	}
+
+	if elapsed > 0 {
+		// 服务器重启后计数器归零，此时不计算速率
+		if delta := cur.values[metricRequests] - prev.values[metricRequests]; delta >= 0 {
+			snap.RequestsPerSec = delta / elapsed
+		}
+	}
+
+	if hits, ok := cur.values[metricCacheHits]; ok {
+		misses := cur.values[metricCacheMisses]
+		dh, dm := hits-prev.values[metricCacheHits], misses-prev.values[metricCacheMisses]
+		var rate float64
+		switch {
+		case dh >= 0 && dm >= 0 && dh+dm > 0:
+			rate = dh / (dh + dm)
+		case hits+misses > 0:
+			rate = hits / (hits + misses)
+		}
+		snap.CacheHitRate = &rate
+	}
+
+	if events > 0 {
+		snap.Events, snap.EventsError = recentAuditEvents(events)
+	}
+	return snap
+}
+
+// recentAuditEvents reads the latest audit events. Failures are returned
+// as a message so the dashboard keeps running with a non-admin token.
+func recentAuditEvents(n int) ([]auditEntry, string) {
+	logs := []auditEntry{}
+	resp, err := apiRequest(http.MethodGet, fmt.Sprintf("/api/v1/audit/logs?page_size=%d", n), nil)
+	if err != nil {
+		return logs, err.Error()
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return logs, "audit events require an administrator token"
+	case resp.StatusCode != http.StatusOK:
+		if msg, ok := result["error"].(string); ok {
+			return logs, msg
+		}
+		return logs, resp.Status
+	}
+	convert(result["logs"], &logs)
+	return logs, ""
+}
+
+// renderTop prints a snapshot as the dashboard. problem replaces the
+// figures when the last scrape failed.
+func renderTop(snap *topSnapshot, problem string) {
+	fmt.Printf("cyp-cli top - %s  (%s)\n", serverURL(), time.Now().Format("15:04:05"))
+	fmt.Println(strings.Repeat("=", 60))
+	if snap == nil {
+		fmt.Printf("Error: %s\n", problem)
+		return
+	}
+
+	fmt.Printf("%-16s %.1f\n", "Requests/sec:", snap.RequestsPerSec)
+	if snap.CacheHitRate != nil {
+		fmt.Printf("%-16s %.1f%%\n", "Cache hit rate:", *snap.CacheHitRate*100)
+	} else {
+		fmt.Printf("%-16s -\n", "Cache hit rate:")
+	}
+	fmt.Printf("%-16s %s\n", "Active uploads:", formatCount(snap.ActiveUploads))
+	fmt.Printf("%-16s %s\n", "P2P peers:", formatCount(snap.P2PPeers))
+	if snap.DiskTotal != nil && snap.DiskFree != nil && *snap.DiskTotal > 0 {
+		used := *snap.DiskTotal - *snap.DiskFree
+		disk := fmt.Sprintf("%s / %s (%.1f%%)", formatSize(float64(used)), formatSize(float64(*snap.DiskTotal)),
+			float64(used)/float64(*snap.DiskTotal)*100)
+		if snap.ReadOnly {
+			disk += "  READ-ONLY"
+		}
+		fmt.Printf("%-16s %s\n", "Disk usage:", disk)
+	} else {
+		fmt.Printf("%-16s -\n", "Disk usage:")
+	}
+
+	fmt.Println()
+	fmt.Println("Recent audit events:")
+	switch {
+	case snap.EventsError != "":
+		fmt.Printf("  (%s)\n", snap.EventsError)
+	case len(snap.Events) == 0:
+		fmt.Println("  (none)")
+	}
+	for _, e := range snap.Events {
+		user := e.Username
+		if user == "" {
+			user = "-"
+		}
+		fmt.Printf("  %-16s %-24s %-12s %-15s %s\n", shortTime(e.Timestamp), e.Event, user, e.IPAddress, e.Status)
+	}
+}
+
+// formatCount formats an optional gauge, "-" when it is not exported.
+func formatCount(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+// stdoutIsTerminal reports whether stdout is a terminal, where top redraws
+// in place.
+func stdoutIsTerminal() bool {
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
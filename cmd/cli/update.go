@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+)
+
+func handleUpdate(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli update import <bundle.tar> [-no-apply]")
+		fmt.Println("       cyp-cli update status")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "import":
+		fs := newFlagSet("update import")
+		noApply := fs.Bool("no-apply", false, "Only verify and stage the update")
+		rest := parseArgs(fs, args[1:])
+		if len(rest) < 1 {
+			fmt.Println("Usage: cyp-cli update import <bundle.tar> [-no-apply]")
+			os.Exit(1)
+		}
+		importUpdateBundle(rest[0], !*noApply)
+	case "status":
+		showUpdateStatus()
+	default:
+		fatalf("Unknown update command: %s", args[0])
+	}
+}
+
+// importUpdateBundle uploads an offline update bundle. The server verifies
+// its signature and, with apply, replaces the binary and restarts.
+func importUpdateBundle(filename string, apply bool) {
+	file, err := os.Open(filename)
+	if err != nil {
+		fatalf("Error opening file: %v", err)
+	}
+	defer file.Close()
+
+	path := "/api/update/import"
+	if apply {
+		path += "?apply=true"
+	}
+	resp, err := apiRequest(http.MethodPost, path, file)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "import update bundle")
+
+	data, _ := result["data"].(map[string]interface{})
+	if docker, _ := data["is_docker"].(bool); docker {
+		fmt.Println(data["message"])
+		fmt.Println(data["tip"])
+		os.Exit(1)
+	}
+	report(data, "Imported version %v\n%v\n", data["version"], data["message"])
+}
+
+func showUpdateStatus() {
+	resp, err := apiRequest(http.MethodGet, "/api/update/status", nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "get update status")
+
+	data, _ := result["data"].(map[string]interface{})
+	status, _ := data["status"].(map[string]interface{})
+	switch {
+	case jsonOutput():
+		printJSON(data)
+		return
+	case quietOutput():
+		fmt.Println(status["state"])
+		return
+	}
+	fmt.Printf("State:   %v\n", status["state"])
+	if msg, _ := status["message"].(string); msg != "" {
+		fmt.Printf("Message: %s\n", msg)
+	}
+	if msg, _ := status["error"].(string); msg != "" {
+		fmt.Printf("Error:   %s\n", msg)
+	}
+	if trial, ok := data["trial"].(map[string]interface{}); ok {
+		fmt.Printf("Verifying %v -> %v until %v\n", trial["from"], trial["to"], trial["deadline"])
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often -wait polls the server.
+const pollInterval = 2 * time.Second
+
+// parseArgs parses flags that follow the positional arguments and returns
+// the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	fs.Parse(args)
+	return append(positional, fs.Args()...)
+}
+
+// waitOptions are the -wait and -timeout flags.
+type waitOptions struct {
+	wait    bool
+	timeout time.Duration
+}
+
+// addWaitFlags registers -wait and -timeout on fs.
+func addWaitFlags(fs *flag.FlagSet) *waitOptions {
+	opts := &waitOptions{}
+	fs.BoolVar(&opts.wait, "wait", false, "Wait until the job finishes, exiting 1 if it fails")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Minute, "Maximum time to wait, exiting 4 when exceeded")
+	return opts
+}
+
+// poll calls check until it reports done, exiting with exitTimeout after
+// the timeout.
+func (o *waitOptions) poll(what string, check func() bool) {
+	deadline := time.Now().Add(o.timeout)
+	for !check() {
+		if o.timeout > 0 && time.Now().After(deadline) {
+			fail(exitTimeout, errorOutput{Error: fmt.Sprintf("Timed out after %s waiting for %s", o.timeout, what)})
+		}
+		time.Sleep(pollInterval)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+func handleWorkflow(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: cyp-cli workflow list [-format table|json]")
+		fmt.Println("       cyp-cli workflow trigger <workflow-id> [-wait] [-timeout 30m]")
+		fmt.Println("       cyp-cli workflow logs <job-id> [-wait] [-timeout 30m]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		fs := newFlagSet("workflow list")
+		format := addFormatFlag(fs)
+		fs.Parse(args[1:])
+		listWorkflows(*format)
+	case "trigger":
+		fs := newFlagSet("workflow trigger")
+		wait := addWaitFlags(fs)
+		rest := parseArgs(fs, args[1:])
+		if len(rest) < 1 {
+			fmt.Println("Usage: cyp-cli workflow trigger <workflow-id> [-wait] [-timeout 30m]")
+			os.Exit(1)
+		}
+		triggerWorkflow(rest[0], wait)
+	case "logs":
+		fs := newFlagSet("workflow logs")
+		wait := addWaitFlags(fs)
+		rest := parseArgs(fs, args[1:])
+		if len(rest) < 1 {
+			fmt.Println("Usage: cyp-cli workflow logs <job-id> [-wait] [-timeout 30m]")
+			os.Exit(1)
+		}
+		followJobLogs(rest[0], wait)
+	default:
+		fatalf("Unknown workflow command: %s", args[0])
+	}
+}
+
+// jobFinished reports whether a job reached a final status.
+func jobFinished(status string) bool {
+	return status == "completed" || status == "failed" || status == "cancelled"
+}
+
+func listWorkflows(format string) {
+	resp, err := apiRequest(http.MethodGet, "/api/v1/workflows", nil)
+	if err != nil {
+		fatal(err)
+	}
+	result := decodeResponse(resp, "list workflows")
+
+	workflows, _ := result["workflows"].([]interface{})
+	if format == "json" {
+		printJSON(workflows)
+		return
+	}
+	if quietOutput() {
+		for _, item := range workflows {
+			if w, ok := item.(map[string]interface{}); ok {
+				fmt.Println(w["id"])
+			}
+		}
+		return
+	}
+	if len(workflows) == 0 {
+		fmt.Println("No workflows found")
+		return
+	}
+
+	fmt.Printf("%-38s %-24s %-10s %-8s %-10s %s\n", "ID", "NAME", "TRIGGER", "ENABLED", "LAST", "LAST RUN")
+	for _, item := range workflows {
+		if w, ok := item.(map[string]interface{}); ok {
+			trigger, _ := w["trigger"].(map[string]interface{})
+			last, _ := w["last_status"].(string)
+			lastRun := shortTime(w["last_run_at"])
+			if last == "" {
+				last, lastRun = "-", "-"
+			}
+			fmt.Printf("%-38v %-24v %-10v %-8v %-10s %s\n", w["id"], w["name"], trigger["type"], w["enabled"], last, lastRun)
+		}
+	}
+}
+
+func triggerWorkflow(id string, wait *waitOptions) {
+	resp, err := apiRequest(http.MethodPost, "/api/v1/workflows/"+url.PathEscape(id)+"/trigger", nil)
+	if err != nil {
+		fatal(err)
+	}
+	job := decodeResponse(resp, "trigger workflow")
+
+	jobID, _ := job["id"].(string)
+	switch {
+	case quietOutput():
+		fmt.Println(jobID)
+	case !wait.wait:
+		report(job, "Workflow %s triggered, job %s\n", id, jobID)
+	default:
+		info("Workflow %s triggered, job %s\n", id, jobID)
+	}
+	if wait.wait {
+		followJobLogs(jobID, wait)
+	}
+}
+
+// followJobLogs prints the logs of a job. With -wait it keeps printing new
+// lines until the job finishes and exits 1 unless it completed.
+func followJobLogs(id string, wait *waitOptions) {
+	next := 0
+	status := ""
+	fetch := func() bool {
+		path := fmt.Sprintf("/api/v1/jobs/%s/logs?since=%d", url.PathEscape(id), next)
+		resp, err := apiRequest(http.MethodGet, path, nil)
+		if err != nil {
+			fatal(err)
+		}
+		result := decodeResponse(resp, "get job logs")
+
+		logs, _ := result["logs"].([]interface{})
+		for _, line := range logs {
+			fmt.Println(line)
+		}
+		if n, ok := result["next"].(float64); ok {
+			next = int(n)
+		}
+		status, _ = result["status"].(string)
+		return jobFinished(status)
+	}
+
+	if !wait.wait {
+		fetch()
+		return
+	}
+	wait.poll("job "+id, fetch)
+
+	report(map[string]string{"id": id, "status": status}, "Job %s %s\n", id, status)
+	if status != "completed" {
+		os.Exit(1)
+	}
+}
@@ -2,8 +2,11 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -11,10 +14,14 @@ import (
 
 	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/internal/detector"
 	"cyp-docker-registry/internal/gateway"
+	"cyp-docker-registry/internal/updater"
 	"cyp-docker-registry/internal/version"
+	applog "cyp-docker-registry/pkg/logger"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 const (
@@ -36,8 +43,9 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Initialize logger
-	logger, err := initLogger()
+	// Initialize logger, its level follows logging.level of the configuration
+	logLevel := zap.NewAtomicLevel()
+	logger, err := initLogger(logLevel)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
@@ -52,6 +60,34 @@ func main() {
 		logger.Fatal("Failed to create data directory", zap.Error(err))
 	}
 
+	// Load configuration
+	config, err := common.LoadConfig(*configPath)
+	if err != nil {
+		logger.Fatal("Failed to load configuration", zap.Error(err))
+	}
+
+	// Pick the settings the file leaves open from the environment
+	var autoConfig *detector.AutoConfig
+	if config.Environment.AutoConfigure {
+		autoConfig = autoConfigure(config, *dataPath, logger)
+	}
+
+	if err := config.Validate(); err != nil {
+		logger.Fatal("Invalid configuration", zap.Error(err))
+	}
+	if level, err := zapcore.ParseLevel(config.Logging.Level); err == nil {
+		logLevel.SetLevel(level)
+	}
+
+	// Switch to the outputs of the logging section
+	appLogger, accessLogger, closeLogs, err := configureLogging(config.Logging, logLevel)
+	if err != nil {
+		logger.Fatal("Failed to configure logging", zap.Error(err))
+	}
+	defer closeLogs()
+	logger = appLogger
+	defer logger.Sync()
+
 	// Initialize database
 	dbPath := filepath.Join(*dataPath, "registry.db")
 	if err := dao.InitDB(dbPath, logger); err != nil {
@@ -61,17 +97,17 @@ func main() {
 
 	logger.Info("Database initialized", zap.String("path", dbPath))
 
-	// Load configuration
-	config, err := common.LoadConfig(*configPath)
-	if err != nil {
-		logger.Fatal("Failed to load configuration", zap.Error(err))
-	}
-
 	// Initialize gateway logger
 	gateway.InitLogger(logger)
+	gateway.InitAccessLogger(accessLogger)
+	gateway.InitLogLevel(logLevel)
 
 	// Create and start router
-	router := gateway.NewRouter(config)
+	router, err := gateway.NewRouter(config)
+	if err != nil {
+		logger.Fatal("Failed to initialize router", zap.Error(err))
+	}
+	router.SetAutoConfig(autoConfig)
 
 	// Start server
 	addr := fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port)
@@ -86,24 +122,123 @@ func main() {
 
 	// Start server in goroutine
 	go func() {
-		if err := router.Engine().Run(addr); err != nil {
+		var err error
+		if config.Server.TLS.Enabled() {
+			server := &http.Server{
+				Addr:      addr,
+				Handler:   router.Engine(),
+				TLSConfig: router.TLSConfig(),
+			}
+			err = server.ListenAndServeTLS(config.Server.TLS.CertFile, config.Server.TLS.KeyFile)
+		} else {
+			err = router.Engine().Run(addr)
+		}
+		if err != nil {
 			logger.Fatal("Failed to start server", zap.Error(err))
 		}
 	}()
 
+	// SIGHUP reloads the configuration file
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	// Wait for shutdown signal
-	<-quit
-	logger.Info("Shutting down server...")
+	for {
+		select {
+		case <-hup:
+			if _, err := router.ReloadConfig(); err != nil {
+				logger.Error("Failed to reload configuration", zap.Error(err))
+			}
+		case <-quit:
+			logger.Info("Shutting down server...")
+			router.Shutdown()
+			return
+		case <-router.Restarts():
+			logger.Info("Restarting server...", zap.String("version", version.GetVersion()))
+			router.Shutdown()
+			dao.CloseDB()
+			if err := updater.Exec(); err != nil {
+				logger.Fatal("Failed to restart server", zap.Error(err))
+			}
+		}
+	}
+}
+
+// autoConfigure applies the settings picked for the environment on first
+// boot to the keys the configuration file leaves unset. A data directory
+// without a database is a first boot.
+func autoConfigure(config *common.Config, dataPath string, logger *zap.Logger) *detector.AutoConfig {
+	_, err := os.Stat(filepath.Join(dataPath, "registry.db"))
+	firstBoot := os.IsNotExist(err)
+
+	service := detector.NewDetectorService()
+	service.SetDiskPath(config.Storage.CachePath)
+	auto, err := service.AutoConfigure(context.Background(), dataPath, firstBoot)
+	if err != nil {
+		logger.Warn("环境自动配置失败", zap.Error(err))
+		return nil
+	}
+	if auto == nil {
+		return nil
+	}
+
+	auto.Applied = auto.Apply(config)
+	logger.Info("已根据运行环境自动配置",
+		zap.Strings("applied", auto.Applied),
+		zap.Strings("reasons", auto.Reasons),
+	)
+	return auto
 }
 
 // initLogger initializes the zap logger.
-func initLogger() (*zap.Logger, error) {
+func initLogger(level zap.AtomicLevel) (*zap.Logger, error) {
 	config := zap.NewProductionConfig()
+	config.Level = level
 	config.OutputPaths = []string{"stdout"}
 	config.ErrorOutputPaths = []string{"stderr"}
 	return config.Build()
 }
 
+// configureLogging builds the application logger and, when logging.access
+// is set, a separate access logger from the logging section. Access log
+// files record every request regardless of the level. On error the
+// bootstrap logger is returned so the error can be logged.
+func configureLogging(cfg common.LoggingConfig, level zap.AtomicLevel) (*zap.Logger, *zap.Logger, func(), error) {
+	var cores []zapcore.Core
+	var closers []io.Closer
+	closeAll := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+
+	if cfg.Output != "none" {
+		core, _, _ := applog.NewCore(applog.Output{Path: cfg.Output, Format: cfg.Format}, level)
+		cores = append(cores, core)
+	}
+	if cfg.File.Path != "" {
+		core, closer, err := applog.NewCore(cfg.File.Output(), level)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("logging.file: %w", err)
+		}
+		cores = append(cores, core)
+		closers = append(closers, closer)
+	}
+	logger := zap.New(zapcore.NewTee(cores...), zap.AddCaller(), zap.AddStacktrace(zapcore.ErrorLevel))
+
+	var accessLogger *zap.Logger
+	if cfg.Access.Path != "" {
+		core, closer, err := applog.NewCore(cfg.Access.Output(), zapcore.InfoLevel)
+		if err != nil {
+			closeAll()
+			return nil, nil, nil, fmt.Errorf("logging.access: %w", err)
+		}
+		closers = append(closers, closer)
+		accessLogger = zap.New(core)
+	}
+	return logger, accessLogger, closeAll, nil
+}
+
 // printCopyright prints copyright information at startup.
 func printCopyright(logger *zap.Logger) {
 	fmt.Println("========================================")
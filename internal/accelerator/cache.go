@@ -2,7 +2,6 @@
 package accelerator
 
 import (
-	"container/list"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -10,6 +9,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 )
@@ -25,12 +25,18 @@ type CacheEntry struct {
 
 // CacheStats represents cache statistics.
 type CacheStats struct {
-	TotalSize    int64 `json:"total_size"`
-	MaxSize      int64 `json:"max_size"`
-	EntryCount   int   `json:"entry_count"`
-	HitCount     int64 `json:"hit_count"`
-	MissCount    int64 `json:"miss_count"`
-	HitRate      float64 `json:"hit_rate"`
+	TotalSize  int64   `json:"total_size"`
+	MaxSize    int64   `json:"max_size"`
+	EntryCount int     `json:"entry_count"`
+	HitCount   int64   `json:"hit_count"`
+	MissCount  int64   `json:"miss_count"`
+	HitRate    float64 `json:"hit_rate"`
+	Policy     string  `json:"policy"`
+	// Entries of pinned images, which are never evicted
+	PinnedCount int   `json:"pinned_count"`
+	PinnedSize  int64 `json:"pinned_size"`
+	// Hit rates of all policies on the same workload, for comparison
+	Policies []PolicyStats `json:"policies,omitempty"`
 }
 
 // CacheIndex represents the cache index stored on disk.
@@ -38,21 +44,20 @@ type CacheIndex struct {
 	Entries map[string]*CacheEntry `json:"entries"`
 }
 
-// LRUCache implements an LRU cache for image layers.
+// LRUCache implements the cache for image layers. Entries are evicted by
+// its CachePolicy, LRU unless SetPolicy selects another one.
 type LRUCache struct {
 	cachePath   string
 	maxSize     int64
 	mu          sync.RWMutex
-	entries     map[string]*list.Element
-	lruList     *list.List
+	entries     map[string]*CacheEntry
+	policy      CachePolicy
+	simulators  []*policySimulator // the other policies, for comparison
+	pinned      map[string]bool    // never evicted, see SetPinned
 	currentSize int64
 	hitCount    int64
 	missCount   int64
-}
-
-// lruItem represents an item in the LRU list.
-type lruItem struct {
-	entry *CacheEntry
+	shared      *sharedIndex // set by SetRedis
 }
 
 // NewLRUCache creates a new LRU cache instance.
@@ -64,54 +69,169 @@ func NewLRUCache(cachePath string, maxSize int64) (*LRUCache, error) {
 	cache := &LRUCache{
 		cachePath: cachePath,
 		maxSize:   maxSize,
-		entries:   make(map[string]*list.Element),
-		lruList:   list.New(),
+		entries:   make(map[string]*CacheEntry),
+		pinned:    make(map[string]bool),
 	}
 
 	// Load existing cache index
 	if err := cache.loadIndex(); err != nil {
 		// Index load failure is not fatal, start fresh
-		cache.entries = make(map[string]*list.Element)
-		cache.lruList = list.New()
+		cache.entries = make(map[string]*CacheEntry)
+		cache.currentSize = 0
 	}
+	cache.resetPolicies(newLRUPolicy())
 
 	return cache, nil
 }
 
+// SetPolicy selects the eviction policy, one of CachePolicies. It must be
+// called before the cache is used. With a shared index (SetRedis) eviction
+// stays LRU.
+func (c *LRUCache) SetPolicy(name string) error {
+	policy, err := NewCachePolicy(name, c.maxSize)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetPolicies(policy)
+	return nil
+}
+
+// resetPolicies makes policy the active one and restarts the simulation of
+// the others. Both start from the current entries in access order.
+// Caller must hold mu.
+func (c *LRUCache) resetPolicies(policy CachePolicy) {
+	c.policy = policy
+	c.simulators = nil
+	for _, name := range CachePolicies {
+		if name == policy.Name() {
+			continue
+		}
+		other, _ := NewCachePolicy(name, c.maxSize)
+		c.simulators = append(c.simulators, newPolicySimulator(other, c.maxSize))
+	}
+
+	for _, entry := range c.entriesByAccess() {
+		if !c.pinned[entry.Digest] {
+			c.policy.Add(entry.Digest, entry.Size, entry.AccessCount)
+		}
+		for _, sim := range c.simulators {
+			sim.insert(entry.Digest, entry.Size, entry.AccessCount)
+		}
+	}
+}
+
+// SetPinned replaces the set of pinned blobs. Pinned entries are left out
+// of the eviction policy, so they stay cached even beyond the maximum size;
+// entries no longer pinned become evictable again.
+func (c *LRUCache) SetPinned(digests []string) {
+	pinned := make(map[string]bool, len(digests))
+	for _, d := range digests {
+		pinned[d] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for d := range c.pinned {
+		if entry, ok := c.entries[d]; ok && !pinned[d] {
+			c.policy.Add(d, entry.Size, entry.AccessCount)
+		}
+	}
+	for d := range pinned {
+		if !c.pinned[d] {
+			c.policy.Remove(d)
+		}
+	}
+	c.pinned = pinned
+}
+
+// isPinned reports whether digest is pinned.
+func (c *LRUCache) isPinned(digest string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.pinned[digest]
+}
+
+// entriesByAccess returns the entries, least recently used first. Caller
+// must hold mu.
+func (c *LRUCache) entriesByAccess() []*CacheEntry {
+	entries := make([]*CacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastAccess.Before(entries[j].LastAccess)
+	})
+	return entries
+}
 
 // Get retrieves a cached blob by digest.
 func (c *LRUCache) Get(digest string) (io.ReadCloser, int64, error) {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedGet(digest)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	elem, ok := c.entries[digest]
+	entry, ok := c.entries[digest]
 	if !ok {
 		c.missCount++
+		for _, sim := range c.simulators {
+			sim.lookup(digest)
+		}
 		return nil, 0, fmt.Errorf("cache miss: %s", digest)
 	}
 
-	// Update access time and move to front
-	item := elem.Value.(*lruItem)
-	item.entry.LastAccess = time.Now()
-	item.entry.AccessCount++
-	c.lruList.MoveToFront(elem)
-
 	// Open the cached file
 	filePath := c.getBlobPath(digest)
 	file, err := os.Open(filePath)
 	if err != nil {
 		c.missCount++
+		for _, sim := range c.simulators {
+			sim.lookup(digest)
+		}
 		// Remove from cache if file doesn't exist
 		c.removeEntry(digest)
 		return nil, 0, fmt.Errorf("cache file not found: %w", err)
 	}
 
+	entry.LastAccess = time.Now()
+	entry.AccessCount++
+	c.policy.Access(digest)
+	for _, sim := range c.simulators {
+		if !sim.lookup(digest) {
+			sim.insert(digest, entry.Size, 1)
+		}
+	}
+
 	c.hitCount++
-	return file, item.entry.Size, nil
+	return file, entry.Size, nil
+}
+
+// openCached opens the cached file of digest without counting a hit, for
+// returning a blob that was just stored.
+func (c *LRUCache) openCached(digest string) (io.ReadCloser, int64, error) {
+	file, err := os.Open(c.getBlobPath(digest))
+	if err != nil {
+		return nil, 0, fmt.Errorf("cache file not found: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("cache file not found: %w", err)
+	}
+	return file, info.Size(), nil
 }
 
 // Put stores a blob in the cache.
 func (c *LRUCache) Put(digest string, data io.Reader) (int64, error) {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedPut(digest, data)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -120,16 +240,55 @@ func (c *LRUCache) Put(digest string, data io.Reader) (int64, error) {
 		return 0, nil // Already cached
 	}
 
-	// Write to temp file first
+	tempPath, size, err := c.writeTemp(digest, data)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tempPath)
+
+	// Evict entries if needed to make room
+	for c.currentSize+size > c.maxSize && len(c.entries) > 0 {
+		if !c.evictOne() {
+			break
+		}
+	}
+
+	if err := c.moveIntoPlace(tempPath, digest); err != nil {
+		return 0, err
+	}
+
+	// Add to cache index
+	entry := &CacheEntry{
+		Digest:      digest,
+		Size:        size,
+		LastAccess:  time.Now(),
+		AccessCount: 1,
+		CreatedAt:   time.Now(),
+	}
+
+	c.entries[digest] = entry
+	c.currentSize += size
+	if !c.pinned[digest] {
+		c.policy.Add(digest, size, 1)
+	}
+	for _, sim := range c.simulators {
+		sim.insert(digest, size, 1)
+	}
+
+	// Save index
+	c.saveIndex()
+
+	return size, nil
+}
+
+// writeTemp writes data to a temp file in the cache directory, verifying
+// it against digest. The caller removes the file if it is not moved.
+func (c *LRUCache) writeTemp(digest string, data io.Reader) (string, int64, error) {
 	tempFile, err := os.CreateTemp(c.cachePath, "cache-*.tmp")
 	if err != nil {
-		return 0, fmt.Errorf("failed to create temp file: %w", err)
+		return "", 0, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	tempPath := tempFile.Name()
-	defer func() {
-		tempFile.Close()
-		os.Remove(tempPath)
-	}()
 
 	// Calculate hash while writing
 	hash := sha256.New()
@@ -137,51 +296,37 @@ func (c *LRUCache) Put(digest string, data io.Reader) (int64, error) {
 
 	size, err := io.Copy(writer, data)
 	if err != nil {
-		return 0, fmt.Errorf("failed to write cache: %w", err)
+		tempFile.Close()
+		os.Remove(tempPath)
+		return "", 0, fmt.Errorf("failed to write cache: %w", err)
 	}
 
 	if err := tempFile.Close(); err != nil {
-		return 0, fmt.Errorf("failed to close temp file: %w", err)
+		os.Remove(tempPath)
+		return "", 0, fmt.Errorf("failed to close temp file: %w", err)
 	}
 
 	// Verify digest if provided
 	calculatedDigest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
 	if digest != "" && digest != calculatedDigest {
-		return 0, fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
+		os.Remove(tempPath)
+		return "", 0, fmt.Errorf("digest mismatch: expected %s, got %s", digest, calculatedDigest)
 	}
 
-	// Evict entries if needed to make room
-	for c.currentSize+size > c.maxSize && c.lruList.Len() > 0 {
-		c.evictOldest()
-	}
+	return tempPath, size, nil
+}
 
-	// Move to final location
+// moveIntoPlace moves a verified temp file to the path of digest.
+func (c *LRUCache) moveIntoPlace(tempPath, digest string) error {
 	finalPath := c.getBlobPath(digest)
 	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
-		return 0, fmt.Errorf("failed to create cache directory: %w", err)
+		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	if err := os.Rename(tempPath, finalPath); err != nil {
-		return 0, fmt.Errorf("failed to move cache file: %w", err)
+		return fmt.Errorf("failed to move cache file: %w", err)
 	}
-
-	// Add to cache index
-	entry := &CacheEntry{
-		Digest:      digest,
-		Size:        size,
-		LastAccess:  time.Now(),
-		AccessCount: 1,
-		CreatedAt:   time.Now(),
-	}
-
-	elem := c.lruList.PushFront(&lruItem{entry: entry})
-	c.entries[digest] = elem
-	c.currentSize += size
-
-	// Save index
-	c.saveIndex()
-
-	return size, nil
+	return nil
 }
 
 // PutWithReader stores a blob and returns a reader for the cached data.
@@ -201,6 +346,10 @@ func (c *LRUCache) PutWithReader(digest string, data io.Reader) (io.ReadCloser,
 
 // Exists checks if a blob is cached.
 func (c *LRUCache) Exists(digest string) bool {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedExists(digest)
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	_, ok := c.entries[digest]
@@ -209,14 +358,26 @@ func (c *LRUCache) Exists(digest string) bool {
 
 // Delete removes a blob from the cache.
 func (c *LRUCache) Delete(digest string) error {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedDelete(digest)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Deleted for every policy, unlike evictions
+	for _, sim := range c.simulators {
+		sim.remove(digest)
+	}
 	return c.removeEntry(digest)
 }
 
 // Clear removes all entries from the cache.
 func (c *LRUCache) Clear() error {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedClear()
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -227,11 +388,12 @@ func (c *LRUCache) Clear() error {
 	}
 
 	// Reset state
-	c.entries = make(map[string]*list.Element)
-	c.lruList = list.New()
+	c.entries = make(map[string]*CacheEntry)
 	c.currentSize = 0
 	c.hitCount = 0
 	c.missCount = 0
+	policy, _ := NewCachePolicy(c.policy.Name(), c.maxSize)
+	c.resetPolicies(policy)
 
 	// Save empty index
 	return c.saveIndex()
@@ -239,6 +401,10 @@ func (c *LRUCache) Clear() error {
 
 // Stats returns cache statistics.
 func (c *LRUCache) Stats() *CacheStats {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedStats()
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -248,39 +414,60 @@ func (c *LRUCache) Stats() *CacheStats {
 		hitRate = float64(c.hitCount) / float64(total)
 	}
 
-	return &CacheStats{
+	stats := &CacheStats{
 		TotalSize:  c.currentSize,
 		MaxSize:    c.maxSize,
 		EntryCount: len(c.entries),
 		HitCount:   c.hitCount,
 		MissCount:  c.missCount,
 		HitRate:    hitRate,
+		Policy:     c.policy.Name(),
+	}
+	for d := range c.pinned {
+		if entry, ok := c.entries[d]; ok {
+			stats.PinnedCount++
+			stats.PinnedSize += entry.Size
+		}
 	}
+	stats.Policies = append(stats.Policies, PolicyStats{
+		Policy:    c.policy.Name(),
+		Active:    true,
+		HitCount:  c.hitCount,
+		MissCount: c.missCount,
+		HitRate:   hitRate,
+	})
+	for _, sim := range c.simulators {
+		stats.Policies = append(stats.Policies, sim.stats())
+	}
+	return stats
 }
 
-
-// evictOldest removes the least recently used entry.
-func (c *LRUCache) evictOldest() {
-	elem := c.lruList.Back()
-	if elem == nil {
-		return
+// evictOne removes the entry chosen by the policy. It reports false when
+// the policy has nothing to evict.
+func (c *LRUCache) evictOne() bool {
+	victim := c.policy.Victim()
+	if victim == "" {
+		return false
 	}
-
-	item := elem.Value.(*lruItem)
-	c.removeEntry(item.entry.Digest)
+	if _, ok := c.entries[victim]; !ok {
+		// Out of sync with the policy; drop it there
+		c.policy.Remove(victim)
+		return true
+	}
+	c.removeEntry(victim)
+	return true
 }
 
 // removeEntry removes an entry from the cache (internal, no lock).
 func (c *LRUCache) removeEntry(digest string) error {
-	elem, ok := c.entries[digest]
+	entry, ok := c.entries[digest]
 	if !ok {
 		return nil
 	}
 
-	item := elem.Value.(*lruItem)
-	c.currentSize -= item.entry.Size
-	c.lruList.Remove(elem)
+	c.currentSize -= entry.Size
 	delete(c.entries, digest)
+	c.policy.Remove(digest)
 
 	// Remove file
 	filePath := c.getBlobPath(digest)
@@ -322,35 +509,16 @@ func (c *LRUCache) loadIndex() error {
 		return err
 	}
 
-	// Rebuild LRU list from index, sorted by last access time
-	type entryWithTime struct {
-		entry *CacheEntry
-	}
-	var entries []*entryWithTime
+	// The policy is built from the entries by resetPolicies
 	for _, entry := range index.Entries {
 		// Verify file exists
 		filePath := c.getBlobPath(entry.Digest)
 		if _, err := os.Stat(filePath); err == nil {
-			entries = append(entries, &entryWithTime{entry: entry})
+			c.entries[entry.Digest] = entry
+			c.currentSize += entry.Size
 		}
 	}
 
-	// Sort by last access time (oldest first, so newest ends up at front)
-	for i := 0; i < len(entries); i++ {
-		for j := i + 1; j < len(entries); j++ {
-			if entries[i].entry.LastAccess.After(entries[j].entry.LastAccess) {
-				entries[i], entries[j] = entries[j], entries[i]
-			}
-		}
-	}
-
-	// Add to LRU list (oldest first, so they're at the back)
-	for _, e := range entries {
-		elem := c.lruList.PushFront(&lruItem{entry: e.entry})
-		c.entries[e.entry.Digest] = elem
-		c.currentSize += e.entry.Size
-	}
-
 	return nil
 }
 
@@ -360,9 +528,8 @@ func (c *LRUCache) saveIndex() error {
 		Entries: make(map[string]*CacheEntry),
 	}
 
-	for digest, elem := range c.entries {
-		item := elem.Value.(*lruItem)
-		index.Entries[digest] = item.entry
+	for digest, entry := range c.entries {
+		index.Entries[digest] = entry
 	}
 
 	data, err := json.MarshalIndent(index, "", "  ")
@@ -375,32 +542,36 @@ func (c *LRUCache) saveIndex() error {
 
 // GetEntries returns all cache entries (for testing/debugging).
 func (c *LRUCache) GetEntries() []*CacheEntry {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedEntries()
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	var entries []*CacheEntry
-	for elem := c.lruList.Front(); elem != nil; elem = elem.Next() {
-		item := elem.Value.(*lruItem)
-		entries = append(entries, item.entry)
+	// Most recently used first
+	entries := c.entriesByAccess()
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
 	}
 	return entries
 }
 
 // GetLRUOrder returns digests in LRU order (most recent first).
 func (c *LRUCache) GetLRUOrder() []string {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
 	var order []string
-	for elem := c.lruList.Front(); elem != nil; elem = elem.Next() {
-		item := elem.Value.(*lruItem)
-		order = append(order, item.entry.Digest)
+	for _, entry := range c.GetEntries() {
+		order = append(order, entry.Digest)
 	}
 	return order
 }
 
 // CurrentSize returns the current cache size.
 func (c *LRUCache) CurrentSize() int64 {
+	if shared := c.sharedIndex(); shared != nil {
+		return c.sharedStats().TotalSize
+	}
+
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.currentSize
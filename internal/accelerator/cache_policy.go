@@ -0,0 +1,422 @@
+// Package accelerator provides image acceleration and caching functionality.
+package accelerator
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+)
+
+// Eviction policies of the layer cache, see accelerator.cache_policy.
+const (
+	PolicyLRU  = "lru"  // least recently used
+	PolicyLFU  = "lfu"  // least frequently used, ties broken by recency
+	PolicyARC  = "arc"  // adaptive replacement cache, balancing recency and frequency
+	PolicyGDSF = "gdsf" // GreedyDual-Size-Frequency, favors small and popular layers
+)
+
+// CachePolicies lists the supported eviction policies.
+var CachePolicies = []string{PolicyLRU, PolicyLFU, PolicyARC, PolicyGDSF}
+
+// CachePolicy chooses the entry evicted when the cache needs room. The
+// cache serializes calls, implementations need no locking.
+type CachePolicy interface {
+	Name() string
+	// Add records a new entry. accessCount is above 1 for entries restored
+	// from the index that were already hit.
+	Add(digest string, size int64, accessCount int)
+	// Access records a hit on an entry.
+	Access(digest string)
+	// Remove forgets an entry that was evicted or deleted.
+	Remove(digest string)
+	// Victim returns the entry to evict next, or "" when there is none.
+	Victim() string
+}
+
+// NewCachePolicy creates the policy name for a cache of capacity bytes.
+func NewCachePolicy(name string, capacity int64) (CachePolicy, error) {
+	switch name {
+	case "", PolicyLRU:
+		return newLRUPolicy(), nil
+	case PolicyLFU:
+		return newHeapPolicy(PolicyLFU), nil
+	case PolicyARC:
+		return newARCPolicy(capacity), nil
+	case PolicyGDSF:
+		return newHeapPolicy(PolicyGDSF), nil
+	}
+	return nil, fmt.Errorf("unknown cache policy %q", name)
+}
+
+// ============================================================================
+// LRU
+// ============================================================================
+
+// lruPolicy evicts the least recently used entry.
+type lruPolicy struct {
+	order *list.List // of digests, most recent first
+	elems map[string]*list.Element
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (p *lruPolicy) Name() string { return PolicyLRU }
+
+func (p *lruPolicy) Add(digest string, _ int64, _ int) {
+	if elem, ok := p.elems[digest]; ok {
+		p.order.MoveToFront(elem)
+		return
+	}
+	p.elems[digest] = p.order.PushFront(digest)
+}
+
+func (p *lruPolicy) Access(digest string) {
+	if elem, ok := p.elems[digest]; ok {
+		p.order.MoveToFront(elem)
+	}
+}
+
+func (p *lruPolicy) Remove(digest string) {
+	if elem, ok := p.elems[digest]; ok {
+		p.order.Remove(elem)
+		delete(p.elems, digest)
+	}
+}
+
+func (p *lruPolicy) Victim() string {
+	if back := p.order.Back(); back != nil {
+		return back.Value.(string)
+	}
+	return ""
+}
+
+// ============================================================================
+// LFU and GDSF
+// ============================================================================
+
+// gdsfSizeUnit scales GDSF priorities so a 1MB layer hit once scores 1.
+const gdsfSizeUnit = 1 << 20
+
+// heapItem is an entry of a heapPolicy.
+type heapItem struct {
+	digest   string
+	size     int64
+	count    int
+	priority float64
+	seq      uint64 // order of the last access, breaks ties
+	index    int
+}
+
+// priorityHeap orders items by priority, then least recent access.
+type priorityHeap []*heapItem
+
+func (h priorityHeap) Len() int { return len(h) }
+func (h priorityHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority < h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+func (h priorityHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *priorityHeap) Push(x any) {
+	item := x.(*heapItem)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+func (h *priorityHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return item
+}
+
+// heapPolicy evicts the entry with the lowest priority. For LFU that is
+// the access count. For GDSF it is L + count / size, where L is raised to
+// the priority of each evicted entry so entries that stop being hit age out.
+type heapPolicy struct {
+	name     string
+	items    priorityHeap
+	byDigest map[string]*heapItem
+	seq      uint64
+	inflate  float64 // L of GDSF
+}
+
+func newHeapPolicy(name string) *heapPolicy {
+	return &heapPolicy{name: name, byDigest: make(map[string]*heapItem)}
+}
+
+func (p *heapPolicy) Name() string { return p.name }
+
+func (p *heapPolicy) score(item *heapItem) float64 {
+	if p.name == PolicyLFU {
+		return float64(item.count)
+	}
+	size := item.size
+	if size < 1 {
+		size = 1
+	}
+	return p.inflate + float64(item.count)*gdsfSizeUnit/float64(size)
+}
+
+func (p *heapPolicy) Add(digest string, size int64, accessCount int) {
+	if _, ok := p.byDigest[digest]; ok {
+		p.Access(digest)
+		return
+	}
+	if accessCount < 1 {
+		accessCount = 1
+	}
+	p.seq++
+	item := &heapItem{digest: digest, size: size, count: accessCount, seq: p.seq}
+	item.priority = p.score(item)
+	p.byDigest[digest] = item
+	heap.Push(&p.items, item)
+}
+
+func (p *heapPolicy) Access(digest string) {
+	item, ok := p.byDigest[digest]
+	if !ok {
+		return
+	}
+	p.seq++
+	item.count++
+	item.seq = p.seq
+	item.priority = p.score(item)
+	heap.Fix(&p.items, item.index)
+}
+
+func (p *heapPolicy) Remove(digest string) {
+	item, ok := p.byDigest[digest]
+	if !ok {
+		return
+	}
+	// Aging follows evictions, which always take the lowest priority
+	if p.name == PolicyGDSF && item.index == 0 {
+		p.inflate = item.priority
+	}
+	heap.Remove(&p.items, item.index)
+	delete(p.byDigest, digest)
+}
+
+func (p *heapPolicy) Victim() string {
+	if len(p.items) == 0 {
+		return ""
+	}
+	return p.items[0].digest
+}
+
+// ============================================================================
+// ARC
+// ============================================================================
+
+// arcList is an LRU list of ARC, most recent first, with its size in bytes.
+type arcList struct {
+	order *list.List // of *arcEntry
+	elems map[string]*list.Element
+	bytes int64
+}
+
+type arcEntry struct {
+	digest string
+	size   int64
+}
+
+func newARCList() *arcList {
+	return &arcList{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+func (l *arcList) pushFront(e *arcEntry) {
+	l.elems[e.digest] = l.order.PushFront(e)
+	l.bytes += e.size
+}
+
+func (l *arcList) remove(digest string) *arcEntry {
+	elem, ok := l.elems[digest]
+	if !ok {
+		return nil
+	}
+	e := l.order.Remove(elem).(*arcEntry)
+	delete(l.elems, digest)
+	l.bytes -= e.size
+	return e
+}
+
+func (l *arcList) back() *arcEntry {
+	if back := l.order.Back(); back != nil {
+		return back.Value.(*arcEntry)
+	}
+	return nil
+}
+
+// arcPolicy is ARC with sizes in bytes. t1 holds entries hit once and t2
+// entries hit again; b1 and b2 remember what was recently evicted from
+// each. A new entry found in b1 means t1 was too small and grows the target
+// p of t1; one found in b2 shrinks it.
+type arcPolicy struct {
+	capacity       int64
+	p              int64
+	t1, t2, b1, b2 *arcList
+}
+
+func newARCPolicy(capacity int64) *arcPolicy {
+	return &arcPolicy{
+		capacity: capacity,
+		t1:       newARCList(),
+		t2:       newARCList(),
+		b1:       newARCList(),
+		b2:       newARCList(),
+	}
+}
+
+func (p *arcPolicy) Name() string { return PolicyARC }
+
+func (p *arcPolicy) Add(digest string, size int64, accessCount int) {
+	if _, ok := p.t1.elems[digest]; ok {
+		p.Access(digest)
+		return
+	}
+	if _, ok := p.t2.elems[digest]; ok {
+		p.Access(digest)
+		return
+	}
+
+	entry := &arcEntry{digest: digest, size: size}
+	switch {
+	case p.b1.remove(digest) != nil:
+		p.p = min(p.capacity, p.p+size*max(1, p.b2.bytes/max(p.b1.bytes, 1)))
+		p.t2.pushFront(entry)
+	case p.b2.remove(digest) != nil:
+		p.p = max(0, p.p-size*max(1, p.b1.bytes/max(p.b2.bytes, 1)))
+		p.t2.pushFront(entry)
+	case accessCount > 1:
+		p.t2.pushFront(entry)
+	default:
+		p.t1.pushFront(entry)
+	}
+	p.trimGhosts()
+}
+
+func (p *arcPolicy) Access(digest string) {
+	if e := p.t1.remove(digest); e != nil {
+		p.t2.pushFront(e)
+		return
+	}
+	if e := p.t2.remove(digest); e != nil {
+		p.t2.pushFront(e)
+	}
+}
+
+func (p *arcPolicy) Remove(digest string) {
+	if e := p.t1.remove(digest); e != nil {
+		p.b1.pushFront(e)
+	} else if e := p.t2.remove(digest); e != nil {
+		p.b2.pushFront(e)
+	}
+	p.trimGhosts()
+}
+
+// trimGhosts bounds the history: t1 plus b1 to the capacity, all four
+// lists to twice the capacity.
+func (p *arcPolicy) trimGhosts() {
+	for p.t1.bytes+p.b1.bytes > p.capacity && p.b1.order.Len() > 0 {
+		p.b1.remove(p.b1.back().digest)
+	}
+	for p.t1.bytes+p.t2.bytes+p.b1.bytes+p.b2.bytes > 2*p.capacity && p.b2.order.Len() > 0 {
+		p.b2.remove(p.b2.back().digest)
+	}
+}
+
+func (p *arcPolicy) Victim() string {
+	if e := p.t1.back(); e != nil && (p.t1.bytes > p.p || p.t2.order.Len() == 0) {
+		return e.digest
+	}
+	if e := p.t2.back(); e != nil {
+		return e.digest
+	}
+	return ""
+}
+
+// ============================================================================
+// Policy comparison
+// ============================================================================
+
+// PolicyStats is the hit rate of an eviction policy on the workload of the
+// cache. Policies other than the active one are simulated on metadata only,
+// starting from the entries cached when the process started.
+type PolicyStats struct {
+	Policy    string  `json:"policy"`
+	Active    bool    `json:"active"`
+	HitCount  int64   `json:"hit_count"`
+	MissCount int64   `json:"miss_count"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+// policySimulator replays the accesses of the cache on another policy
+// with the same capacity.
+type policySimulator struct {
+	policy   CachePolicy
+	capacity int64
+	sizes    map[string]int64
+	used     int64
+	hits     int64
+	misses   int64
+}
+
+func newPolicySimulator(policy CachePolicy, capacity int64) *policySimulator {
+	return &policySimulator{policy: policy, capacity: capacity, sizes: make(map[string]int64)}
+}
+
+// lookup counts a request for digest and reports whether the simulated
+// cache holds it.
+func (s *policySimulator) lookup(digest string) bool {
+	if _, ok := s.sizes[digest]; ok {
+		s.hits++
+		s.policy.Access(digest)
+		return true
+	}
+	s.misses++
+	return false
+}
+
+// insert caches digest in the simulation, evicting as the policy says.
+func (s *policySimulator) insert(digest string, size int64, accessCount int) {
+	if _, ok := s.sizes[digest]; ok || size > s.capacity {
+		return
+	}
+	for s.used+size > s.capacity {
+		victim := s.policy.Victim()
+		if victim == "" {
+			break
+		}
+		s.remove(victim)
+	}
+	s.policy.Add(digest, size, accessCount)
+	s.sizes[digest] = size
+	s.used += size
+}
+
+// remove drops digest from the simulation.
+func (s *policySimulator) remove(digest string) {
+	size, ok := s.sizes[digest]
+	if !ok {
+		return
+	}
+	s.policy.Remove(digest)
+	delete(s.sizes, digest)
+	s.used -= size
+}
+
+func (s *policySimulator) stats() PolicyStats {
+	stats := PolicyStats{Policy: s.policy.Name(), HitCount: s.hits, MissCount: s.misses}
+	if total := s.hits + s.misses; total > 0 {
+		stats.HitRate = float64(s.hits) / float64(total)
+	}
+	return stats
+}
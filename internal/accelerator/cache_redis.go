@@ -0,0 +1,324 @@
+// Package accelerator provides image acceleration and caching functionality.
+package accelerator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisTimeout bounds a Redis operation of the shared cache index.
+const redisTimeout = time.Second
+
+// sharedIndex keeps the cache index in Redis so instances sharing the cache
+// directory agree on its content, LRU order and size. Keys under prefix:
+//
+//	entry:<digest>  hash with size, created_at, last_access, access_count
+//	lru             sorted set of digests scored by last access (ms)
+//	size            total size of the entries
+//	hits, misses    counters
+//
+// The files stay the source of truth: while Redis fails, blobs present on
+// disk are still served and new blobs are still cached, only LRU
+// bookkeeping and eviction are skipped.
+type sharedIndex struct {
+	client *redis.Client
+	prefix string
+}
+
+// SetRedis shares the cache index through Redis with the other instances
+// using the same cache directory. It must be called before the cache is
+// used; the local index is no longer consulted afterwards.
+func (c *LRUCache) SetRedis(client *redis.Client, prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shared = &sharedIndex{client: client, prefix: prefix + "cache:"}
+}
+
+// sharedIndex returns the shared index, or nil in local mode.
+func (c *LRUCache) sharedIndex() *sharedIndex {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.shared
+}
+
+func (s *sharedIndex) entryKey(digest string) string {
+	return s.prefix + "entry:" + digest
+}
+
+func (s *sharedIndex) key(name string) string {
+	return s.prefix + name
+}
+
+// sharedGet is Get backed by the shared index. A file missing from the
+// index, e.g. after Redis lost its data, is served and indexed again.
+func (c *LRUCache) sharedGet(digest string) (io.ReadCloser, int64, error) {
+	s := c.shared
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	file, err := os.Open(c.getBlobPath(digest))
+	if err != nil {
+		s.client.Incr(ctx, s.key("misses"))
+		// 文件已被其他实例淘汰时清理残留的索引
+		if os.IsNotExist(err) {
+			s.remove(ctx, digest)
+		}
+		return nil, 0, fmt.Errorf("cache miss: %s", digest)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("cache file not found: %w", err)
+	}
+	size := info.Size()
+
+	// Redis 不可用时仍使用磁盘上的文件
+	now := time.Now().UnixMilli()
+	touched, err := touchEntryScript.Run(ctx, s.client,
+		[]string{s.entryKey(digest), s.key("lru"), s.key("hits")},
+		now, digest,
+	).Int()
+	if err == nil && touched == 0 {
+		s.add(ctx, digest, size, now)
+		s.client.Incr(ctx, s.key("hits"))
+	}
+	return file, size, nil
+}
+
+// touchEntryScript records a hit on an indexed entry. It returns 0 when the
+// entry is not indexed.
+var touchEntryScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'last_access', ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'access_count', 1)
+redis.call('ZADD', KEYS[2], ARGV[1], ARGV[2])
+redis.call('INCR', KEYS[3])
+return 1
+`)
+
+// addEntryScript indexes an entry unless another instance already did, so
+// the total size counts each entry once.
+var addEntryScript = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], 'size', ARGV[1]) == 1 then
+	redis.call('HSET', KEYS[1], 'created_at', ARGV[2], 'last_access', ARGV[2], 'access_count', 1)
+	redis.call('INCRBY', KEYS[3], ARGV[1])
+end
+redis.call('ZADD', KEYS[2], ARGV[2], ARGV[3])
+return 1
+`)
+
+// add indexes a new entry.
+func (s *sharedIndex) add(ctx context.Context, digest string, size, now int64) error {
+	return addEntryScript.Run(ctx, s.client,
+		[]string{s.entryKey(digest), s.key("lru"), s.key("size")},
+		size, now, digest,
+	).Err()
+}
+
+// sharedPut is Put backed by the shared index.
+func (c *LRUCache) sharedPut(digest string, data io.Reader) (int64, error) {
+	s := c.shared
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	if n, err := s.client.Exists(ctx, s.entryKey(digest)).Result(); err == nil && n > 0 {
+		return 0, nil // Already cached
+	}
+
+	tempPath, size, err := c.writeTemp(digest, data)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tempPath)
+
+	// The write may take long; use a fresh deadline for the index
+	ctx, cancel = context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	s.evict(ctx, c, size)
+
+	if err := c.moveIntoPlace(tempPath, digest); err != nil {
+		return 0, err
+	}
+
+	s.add(ctx, digest, size, time.Now().UnixMilli())
+	return size, nil
+}
+
+// evict removes least recently used entries until size more bytes fit.
+// Blobs pinned on this instance are skipped.
+func (s *sharedIndex) evict(ctx context.Context, c *LRUCache, size int64) {
+	for {
+		total, err := s.client.Get(ctx, s.key("size")).Int64()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return
+		}
+		if total+size <= c.maxSize {
+			return
+		}
+		oldest, err := s.client.ZRange(ctx, s.key("lru"), 0, 63).Result()
+		if err != nil {
+			return
+		}
+		victim := ""
+		for _, digest := range oldest {
+			if !c.isPinned(digest) {
+				victim = digest
+				break
+			}
+		}
+		if victim == "" {
+			return
+		}
+		s.remove(ctx, victim)
+		os.Remove(c.getBlobPath(victim))
+	}
+}
+
+// remove drops digest from the index. Only the instance whose DEL succeeds
+// adjusts the size, so concurrent removals count once.
+func (s *sharedIndex) remove(ctx context.Context, digest string) error {
+	size, err := s.client.HGet(ctx, s.entryKey(digest), "size").Int64()
+	if errors.Is(err, redis.Nil) {
+		return s.client.ZRem(ctx, s.key("lru"), digest).Err()
+	}
+	if err != nil {
+		return err
+	}
+	deleted, err := s.client.Del(ctx, s.entryKey(digest)).Result()
+	if err != nil {
+		return err
+	}
+	pipe := s.client.TxPipeline()
+	pipe.ZRem(ctx, s.key("lru"), digest)
+	if deleted > 0 {
+		pipe.DecrBy(ctx, s.key("size"), size)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// sharedExists is Exists backed by the shared index.
+func (c *LRUCache) sharedExists(digest string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	n, err := c.shared.client.Exists(ctx, c.shared.entryKey(digest)).Result()
+	if err != nil {
+		_, statErr := os.Stat(c.getBlobPath(digest))
+		return statErr == nil
+	}
+	return n > 0
+}
+
+// sharedDelete is Delete backed by the shared index.
+func (c *LRUCache) sharedDelete(digest string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	err := c.shared.remove(ctx, digest)
+	os.Remove(c.getBlobPath(digest))
+	return err
+}
+
+// sharedClear is Clear backed by the shared index.
+func (c *LRUCache) sharedClear() error {
+	s := c.shared
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	digests, err := s.client.ZRange(ctx, s.key("lru"), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{s.key("lru"), s.key("size"), s.key("hits"), s.key("misses")}
+	for _, digest := range digests {
+		keys = append(keys, s.entryKey(digest))
+		os.Remove(c.getBlobPath(digest))
+	}
+	return s.client.Del(ctx, keys...).Err()
+}
+
+// sharedStats is Stats backed by the shared index. Counters that cannot be
+// read are reported as zero.
+func (c *LRUCache) sharedStats() *CacheStats {
+	s := c.shared
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	pipe := s.client.Pipeline()
+	size := pipe.Get(ctx, s.key("size"))
+	hits := pipe.Get(ctx, s.key("hits"))
+	misses := pipe.Get(ctx, s.key("misses"))
+	count := pipe.ZCard(ctx, s.key("lru"))
+	pipe.Exec(ctx)
+
+	stats := &CacheStats{MaxSize: c.maxSize, Policy: PolicyLRU}
+	stats.TotalSize, _ = size.Int64()
+	stats.HitCount, _ = hits.Int64()
+	stats.MissCount, _ = misses.Int64()
+	stats.EntryCount = int(count.Val())
+	c.mu.RLock()
+	for d := range c.pinned {
+		if info, err := os.Stat(c.getBlobPath(d)); err == nil {
+			stats.PinnedCount++
+			stats.PinnedSize += info.Size()
+		}
+	}
+	c.mu.RUnlock()
+	if total := stats.HitCount + stats.MissCount; total > 0 {
+		stats.HitRate = float64(stats.HitCount) / float64(total)
+	}
+	return stats
+}
+
+// sharedEntries returns the entries of the shared index, most recently
+// used first.
+func (c *LRUCache) sharedEntries() []*CacheEntry {
+	s := c.shared
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	digests, err := s.client.ZRevRange(ctx, s.key("lru"), 0, -1).Result()
+	if err != nil {
+		return nil
+	}
+	pipe := s.client.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(digests))
+	for i, digest := range digests {
+		cmds[i] = pipe.HGetAll(ctx, s.entryKey(digest))
+	}
+	pipe.Exec(ctx)
+
+	entries := make([]*CacheEntry, 0, len(digests))
+	for i, digest := range digests {
+		fields, err := cmds[i].Result()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		entry := &CacheEntry{Digest: digest}
+		entry.Size, _ = strconv.ParseInt(fields["size"], 10, 64)
+		entry.AccessCount, _ = strconv.Atoi(fields["access_count"])
+		entry.CreatedAt = parseMillis(fields["created_at"])
+		entry.LastAccess = parseMillis(fields["last_access"])
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// parseMillis parses a Unix time in milliseconds.
+func parseMillis(s string) time.Time {
+	ms, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
@@ -2,8 +2,11 @@
 package accelerator
 
 import (
+	"context"
 	"cyp-docker-registry/internal/common"
+	"errors"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,9 +30,8 @@ func (h *Handler) GetProxy() *ProxyService {
 
 // RegisterRoutes registers accelerator routes on the given router group.
 func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
-	// Proxy pull endpoints
-	group.GET("/pull/:name/blobs/:digest", h.proxyPullBlob)
-	group.GET("/pull/:name/manifests/:reference", h.proxyPullManifest)
+	// Proxy pull endpoints, names may have several components
+	group.GET("/pull/*path", h.proxyPull)
 
 	// Cache management endpoints
 	cache := group.Group("/cache")
@@ -51,16 +53,43 @@ func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
 		upstreams.POST("/:name/disable", h.disableUpstream)
 		upstreams.GET("/:name/health", h.checkUpstreamHealth)
 	}
+
+	// Pinned image endpoints; changes are registered by RegisterPinRoutes
+	group.GET("/pins", h.listPins)
+}
+
+// RegisterPinRoutes registers the routes that pin, unpin and refresh
+// images. Pinned layers are never evicted, so the caller mounts them
+// behind an administrator check.
+func (h *Handler) RegisterPinRoutes(pins *gin.RouterGroup) {
+	pins.POST("", h.pinImage)
+	pins.POST("/refresh", h.refreshPins)
+	pins.DELETE("/*image", h.unpinImage)
 }
 
 // ============================================================================
 // Proxy Pull Handlers
 // ============================================================================
 
-// proxyPullBlob handles GET /api/accel/pull/:name/blobs/:digest
-func (h *Handler) proxyPullBlob(c *gin.Context) {
-	name := c.Param("name")
-	digest := c.Param("digest")
+// proxyPull handles GET /api/accel/pull/*path
+// The path is <name>/blobs/<digest> or <name>/manifests/<reference>.
+func (h *Handler) proxyPull(c *gin.Context) {
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	if i := strings.LastIndex(path, "/blobs/"); i > 0 {
+		h.proxyPullBlob(c, path[:i], path[i+len("/blobs/"):])
+		return
+	}
+	if i := strings.LastIndex(path, "/manifests/"); i > 0 {
+		h.proxyPullManifest(c, path[:i], path[i+len("/manifests/"):])
+		return
+	}
+	common.ErrorResponse(c, common.ErrNotFound, gin.H{
+		"path": path,
+	})
+}
+
+// proxyPullBlob serves GET /api/accel/pull/<name>/blobs/<digest>
+func (h *Handler) proxyPullBlob(c *gin.Context, name, digest string) {
 
 	reader, size, err := h.proxy.ProxyPull(name, digest)
 	if err != nil {
@@ -79,10 +108,8 @@ func (h *Handler) proxyPullBlob(c *gin.Context) {
 	c.DataFromReader(200, size, "application/octet-stream", reader, nil)
 }
 
-// proxyPullManifest handles GET /api/accel/pull/:name/manifests/:reference
-func (h *Handler) proxyPullManifest(c *gin.Context) {
-	name := c.Param("name")
-	reference := c.Param("reference")
+// proxyPullManifest serves GET /api/accel/pull/<name>/manifests/<reference>
+func (h *Handler) proxyPullManifest(c *gin.Context, name, reference string) {
 
 	data, contentType, err := h.proxy.ProxyPullManifest(name, reference)
 	if err != nil {
@@ -296,3 +323,77 @@ func (h *Handler) checkUpstreamHealth(c *gin.Context) {
 		"status":  status,
 	})
 }
+
+// ============================================================================
+// Pinned Image Handlers
+// ============================================================================
+
+// PinRequest is the body of POST /api/accel/pins.
+type PinRequest struct {
+	Image     string   `json:"image" binding:"required"`
+	Platforms []string `json:"platforms"`
+}
+
+// listPins handles GET /api/accel/pins
+func (h *Handler) listPins(c *gin.Context) {
+	pins := h.proxy.ListPins()
+	common.SuccessResponse(c, gin.H{
+		"pins":  pins,
+		"count": len(pins),
+	})
+}
+
+// pinImage handles POST /api/accel/pins
+func (h *Handler) pinImage(c *gin.Context) {
+	var req PinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	pin, err := h.proxy.Pin(req.Image, req.Platforms)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"image": req.Image,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "镜像已固定，正在后台预热",
+		"pin":     pin,
+	})
+}
+
+// refreshPins handles POST /api/accel/pins/refresh
+func (h *Handler) refreshPins(c *gin.Context) {
+	go h.proxy.RefreshPins(context.Background())
+	common.SuccessResponse(c, gin.H{
+		"message": "已开始刷新固定镜像",
+	})
+}
+
+// unpinImage handles DELETE /api/accel/pins/*image
+func (h *Handler) unpinImage(c *gin.Context) {
+	image := strings.TrimPrefix(c.Param("image"), "/")
+
+	if err := h.proxy.Unpin(image); err != nil {
+		code := common.ErrInvalidRequest
+		if errors.Is(err, ErrPinNotFound) {
+			code = common.ErrNotFound
+		}
+		common.ErrorResponse(c, code, gin.H{
+			"image": image,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "镜像已取消固定",
+		"image":   image,
+	})
+}
@@ -0,0 +1,363 @@
+// Package accelerator provides image acceleration and caching functionality.
+package accelerator
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Status of a pinned image.
+const (
+	PinPending = "pending" // layers not fetched yet
+	PinReady   = "ready"   // all layers cached
+	PinFailed  = "failed"  // the last refresh failed, see Error
+)
+
+// ErrPinNotFound is returned for an image that is not pinned.
+var ErrPinNotFound = errors.New("image not pinned")
+
+// PinnedImage is an image whose layers are fetched ahead of time and never
+// evicted from the cache.
+type PinnedImage struct {
+	Image       string     `json:"image"`               // name:tag
+	Platforms   []string   `json:"platforms,omitempty"` // os/arch of a multi-arch image, empty for all
+	Digest      string     `json:"digest,omitempty"`    // upstream manifest digest at the last refresh
+	Blobs       []string   `json:"blobs,omitempty"`     // configs and layers kept in the cache
+	Size        int64      `json:"size"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"`
+}
+
+// parseImage splits name:tag, defaulting the tag to latest. Names are
+// mapped to upstream repositories when pulled, see UpstreamSource.
+func parseImage(image string) (name, tag string, err error) {
+	image = strings.TrimSpace(image)
+	name, tag = image, "latest"
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		name, tag = image[:i], image[i+1:]
+	}
+	if name == "" || tag == "" || strings.Contains(name, "@") {
+		return "", "", fmt.Errorf("invalid image %q, expected name:tag", image)
+	}
+	return name, tag, nil
+}
+
+// getPinsPath returns the path of the pinned image list.
+func (p *ProxyService) getPinsPath() string {
+	if p.configPath != "" {
+		return filepath.Join(p.configPath, "pins.json")
+	}
+	return "pins.json"
+}
+
+// loadPins loads the pinned images and pins their blobs in the cache.
+func (p *ProxyService) loadPins() error {
+	p.pins = make(map[string]*PinnedImage)
+	data, err := os.ReadFile(p.getPinsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var pins []*PinnedImage
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return err
+	}
+	for _, pin := range pins {
+		p.pins[pin.Image] = pin
+	}
+	p.applyPinsLocked()
+	return nil
+}
+
+// savePinsLocked saves the pinned images. Caller must hold pinMu.
+func (p *ProxyService) savePinsLocked() error {
+	data, err := json.MarshalIndent(p.listPinsLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+	path := p.getPinsPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// applyPinsLocked pins the blobs of all pinned images in the cache, plus
+// extra blobs being fetched. Caller must hold pinMu.
+func (p *ProxyService) applyPinsLocked(extra ...string) {
+	var digests []string
+	for _, pin := range p.pins {
+		digests = append(digests, pin.Blobs...)
+	}
+	p.cache.SetPinned(append(digests, extra...))
+}
+
+// listPinsLocked returns copies of the pinned images sorted by name.
+// Caller must hold pinMu.
+func (p *ProxyService) listPinsLocked() []*PinnedImage {
+	pins := make([]*PinnedImage, 0, len(p.pins))
+	for _, pin := range p.pins {
+		copied := *pin
+		pins = append(pins, &copied)
+	}
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Image < pins[j].Image })
+	return pins
+}
+
+// ListPins returns the pinned images.
+func (p *ProxyService) ListPins() []*PinnedImage {
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	return p.listPinsLocked()
+}
+
+// Pin pins an image and starts fetching its layers in the background.
+// Pinning an image again updates its platforms and refreshes it.
+func (p *ProxyService) Pin(image string, platforms []string) (*PinnedImage, error) {
+	name, tag, err := parseImage(image)
+	if err != nil {
+		return nil, err
+	}
+	key := name + ":" + tag
+
+	p.pinMu.Lock()
+	pin, ok := p.pins[key]
+	if !ok {
+		pin = &PinnedImage{Image: key, Status: PinPending, CreatedAt: time.Now()}
+		p.pins[key] = pin
+	}
+	pin.Platforms = platforms
+	err = p.savePinsLocked()
+	copied := *pin
+	p.pinMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to save pins: %w", err)
+	}
+
+	go p.RefreshPin(context.Background(), key)
+	return &copied, nil
+}
+
+// Unpin unpins an image; its layers become evictable again.
+func (p *ProxyService) Unpin(image string) error {
+	name, tag, err := parseImage(image)
+	if err != nil {
+		return err
+	}
+
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	if _, ok := p.pins[name+":"+tag]; !ok {
+		return ErrPinNotFound
+	}
+	delete(p.pins, name+":"+tag)
+	p.applyPinsLocked()
+	return p.savePinsLocked()
+}
+
+// RefreshPins refreshes every pinned image. It returns the errors of the
+// images that could not be refreshed.
+func (p *ProxyService) RefreshPins(ctx context.Context) error {
+	var errs []error
+	for _, pin := range p.ListPins() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := p.RefreshPin(ctx, pin.Image); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", pin.Image, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// RefreshPin resolves the tag of a pinned image upstream and fetches the
+// layers missing from the cache. When the tag moved to a new digest, the
+// layers of the old one are unpinned once the new ones are cached.
+func (p *ProxyService) RefreshPin(ctx context.Context, image string) error {
+	// One refresh at a time, so concurrent ones do not fetch the same layers
+	p.pinRefreshMu.Lock()
+	defer p.pinRefreshMu.Unlock()
+
+	p.pinMu.Lock()
+	pin, ok := p.pins[image]
+	var platforms []string
+	if ok {
+		platforms = pin.Platforms
+	}
+	p.pinMu.Unlock()
+	if !ok {
+		return ErrPinNotFound
+	}
+
+	name, tag, _ := parseImage(image)
+	digest, blobs, err := p.resolveBlobs(name, tag, platforms)
+	var size int64
+	if err == nil {
+		// Pin the new blobs first so fetching one cannot evict another
+		p.pinMu.Lock()
+		p.applyPinsLocked(blobs...)
+		p.pinMu.Unlock()
+		size, err = p.fetchBlobs(ctx, name, blobs)
+	}
+
+	p.pinMu.Lock()
+	defer p.pinMu.Unlock()
+	pin, ok = p.pins[image]
+	if !ok {
+		// Unpinned meanwhile
+		p.applyPinsLocked()
+		return nil
+	}
+	now := time.Now()
+	pin.RefreshedAt = &now
+	if err != nil {
+		pin.Status = PinFailed
+		pin.Error = err.Error()
+	} else {
+		pin.Status = PinReady
+		pin.Error = ""
+		pin.Digest = digest
+		pin.Blobs = blobs
+		pin.Size = size
+	}
+	p.applyPinsLocked()
+	if saveErr := p.savePinsLocked(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// fetchBlobs caches the blobs of name that are not cached yet and returns
+// their total size. Cached blobs are not read, so refreshes do not count
+// as cache hits.
+func (p *ProxyService) fetchBlobs(ctx context.Context, name string, blobs []string) (int64, error) {
+	var total int64
+	for _, digest := range blobs {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if reader, size, err := p.cache.openCached(digest); err == nil {
+			reader.Close()
+			total += size
+			continue
+		}
+		reader, size, err := p.ProxyPull(name, digest)
+		if err != nil {
+			return 0, fmt.Errorf("failed to fetch %s: %w", digest, err)
+		}
+		reader.Close()
+		total += size
+	}
+	return total, nil
+}
+
+// pinManifest holds the fields of image manifests and indexes needed to
+// find their blobs.
+type pinManifest struct {
+	MediaType string `json:"mediaType"`
+	Config    struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// resolveBlobs returns the manifest digest of name:tag upstream and the
+// configs and layers of the image, of the given platforms for a multi-arch
+// image.
+func (p *ProxyService) resolveBlobs(name, tag string, platforms []string) (string, []string, error) {
+	data, _, err := p.ProxyPullManifest(name, tag)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+
+	var manifest pinManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if len(manifest.Manifests) == 0 {
+		return digest, dedupe(manifestBlobs(&manifest)), nil
+	}
+
+	var blobs []string
+	for _, child := range manifest.Manifests {
+		platform := child.Platform.OS + "/" + child.Platform.Architecture
+		if len(platforms) > 0 && !matchPlatform(platforms, platform, child.Platform.Variant) {
+			continue
+		}
+		data, _, err := p.ProxyPullManifest(name, child.Digest)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to fetch manifest of %s: %w", platform, err)
+		}
+		var image pinManifest
+		if err := json.Unmarshal(data, &image); err != nil {
+			return "", nil, fmt.Errorf("invalid manifest of %s: %w", platform, err)
+		}
+		blobs = append(blobs, manifestBlobs(&image)...)
+	}
+	if len(blobs) == 0 {
+		return "", nil, fmt.Errorf("no manifest for platforms %s", strings.Join(platforms, ", "))
+	}
+	return digest, dedupe(blobs), nil
+}
+
+// manifestBlobs returns the config and layers of an image manifest.
+func manifestBlobs(m *pinManifest) []string {
+	var blobs []string
+	if m.Config.Digest != "" {
+		blobs = append(blobs, m.Config.Digest)
+	}
+	for _, layer := range m.Layers {
+		blobs = append(blobs, layer.Digest)
+	}
+	return blobs
+}
+
+// matchPlatform reports whether os/arch, or os/arch/variant, is one of
+// platforms.
+func matchPlatform(platforms []string, platform, variant string) bool {
+	for _, want := range platforms {
+		if want == platform || (variant != "" && want == platform+"/"+variant) {
+			return true
+		}
+	}
+	return false
+}
+
+// dedupe removes repeated digests, keeping the first occurrence.
+func dedupe(digests []string) []string {
+	seen := make(map[string]bool, len(digests))
+	out := digests[:0]
+	for _, d := range digests {
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
@@ -8,9 +8,11 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -21,6 +23,12 @@ type UpstreamSource struct {
 	URL      string `json:"url"`
 	Priority int    `json:"priority"`
 	Enabled  bool   `json:"enabled"`
+	// Type is one of UpstreamTypes, detected from the URL when empty
+	Type string `json:"type,omitempty"`
+	// PathTemplate overrides DefaultPathTemplate, e.g. for the proxy cache
+	// project of a Harbor: /v2/dockerhub-proxy/{name}/{kind}/{reference}
+	PathTemplate string        `json:"path_template,omitempty"`
+	Rewrites     []NameRewrite `json:"rewrites,omitempty"`
 }
 
 // ProxyConfig represents proxy configuration.
@@ -44,18 +52,24 @@ type ProxyService struct {
 	configPath     string
 	mu             sync.RWMutex
 	customResolver *net.Resolver
+	outboundProxy  func(*http.Request) (*url.URL, error)
 	p2pProvider    P2PProvider
+	tokens         tokenCache
+
+	// Pinned images, see pins.go
+	pinMu        sync.Mutex
+	pins         map[string]*PinnedImage
+	pinRefreshMu sync.Mutex
 }
 
 // NewProxyService creates a new proxy service.
 func NewProxyService(cache *LRUCache, configPath string) (*ProxyService, error) {
 	service := &ProxyService{
-		cache:      cache,
-		configPath: configPath,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		cache:         cache,
+		configPath:    configPath,
+		outboundProxy: http.ProxyFromEnvironment,
 	}
+	service.httpClient = service.newHTTPClient()
 
 	// Load upstream configuration
 	if err := service.loadConfig(); err != nil {
@@ -63,6 +77,11 @@ func NewProxyService(cache *LRUCache, configPath string) (*ProxyService, error)
 		service.upstreams = getDefaultUpstreams()
 	}
 
+	// Keep the layers of pinned images from being evicted
+	if err := service.loadPins(); err != nil {
+		return nil, fmt.Errorf("failed to load pinned images: %w", err)
+	}
+
 	return service, nil
 }
 
@@ -83,16 +102,15 @@ func (p *ProxyService) ProxyPull(name, digest string) (io.ReadCloser, int64, err
 		return reader, size, nil
 	}
 
-	// Try P2P network if available
+	// Try P2P network if available. RequestBlob looks up providers in the
+	// DHT and verifies the digest of the downloaded blob.
 	if p.p2pProvider != nil && p.p2pProvider.IsRunning() {
-		if p.p2pProvider.HasBlob(ctx, digest) {
-			reader, size, err := p.p2pProvider.RequestBlob(ctx, digest)
+		reader, size, err := p.p2pProvider.RequestBlob(ctx, digest)
+		if err == nil {
+			// Cache the blob from P2P
+			cachedReader, cachedSize, err := p.cacheAndReturn(digest, reader, size)
 			if err == nil {
-				// Cache the blob from P2P
-				cachedReader, cachedSize, err := p.cacheAndReturn(digest, reader, size)
-				if err == nil {
-					return cachedReader, cachedSize, nil
-				}
+				return cachedReader, cachedSize, nil
 			}
 		}
 	}
@@ -163,20 +181,9 @@ func (p *ProxyService) ProxyPullManifest(name, reference string) ([]byte, string
 
 // pullFromUpstream pulls a blob from a specific upstream.
 func (p *ProxyService) pullFromUpstream(upstream UpstreamSource, name, digest string) (io.ReadCloser, int64, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/%s", upstream.URL, name, digest)
-
-	req, err := http.NewRequest("GET", url, nil)
+	resp, err := p.getUpstream(upstream, name, "blobs", digest, "*/*")
 	if err != nil {
-		return nil, 0, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Add Docker registry headers
-	req.Header.Set("Accept", "application/vnd.docker.image.rootfs.diff.tar.gzip")
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return nil, 0, fmt.Errorf("upstream request failed: %w", err)
+		return nil, 0, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
@@ -189,20 +196,14 @@ func (p *ProxyService) pullFromUpstream(upstream UpstreamSource, name, digest st
 
 // pullManifestFromUpstream pulls a manifest from a specific upstream.
 func (p *ProxyService) pullManifestFromUpstream(upstream UpstreamSource, name, reference string) ([]byte, string, error) {
-	url := fmt.Sprintf("%s/v2/%s/manifests/%s", upstream.URL, name, reference)
-
-	req, err := http.NewRequest("GET", url, nil)
+	resp, err := p.getUpstream(upstream, name, "manifests", reference, strings.Join([]string{
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+	}, ", "))
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Add Docker registry headers
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.list.v2+json")
-
-	resp, err := p.httpClient.Do(req)
-	if err != nil {
-		return nil, "", fmt.Errorf("upstream request failed: %w", err)
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
@@ -229,8 +230,8 @@ func (p *ProxyService) cacheAndReturn(digest string, reader io.ReadCloser, _ int
 		return nil, 0, fmt.Errorf("failed to cache blob: %w", err)
 	}
 
-	// Return from cache
-	return p.cache.Get(digest)
+	// Return from cache; the miss was already counted
+	return p.cache.openCached(digest)
 }
 
 // GetUpstreams returns upstreams sorted by priority.
@@ -251,6 +252,12 @@ func (p *ProxyService) GetUpstreams() []UpstreamSource {
 
 // SetUpstreams updates the upstream sources.
 func (p *ProxyService) SetUpstreams(upstreams []UpstreamSource) error {
+	for _, u := range upstreams {
+		if err := ValidateUpstream(u); err != nil {
+			return err
+		}
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -260,6 +267,10 @@ func (p *ProxyService) SetUpstreams(upstreams []UpstreamSource) error {
 
 // AddUpstream adds a new upstream source.
 func (p *ProxyService) AddUpstream(upstream UpstreamSource) error {
+	if err := ValidateUpstream(upstream); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -291,6 +302,10 @@ func (p *ProxyService) RemoveUpstream(name string) error {
 
 // UpdateUpstream updates an existing upstream source.
 func (p *ProxyService) UpdateUpstream(name string, upstream UpstreamSource) error {
+	if err := ValidateUpstream(upstream); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -374,6 +389,11 @@ func (p *ProxyService) saveConfig() error {
 
 // CheckUpstreamHealth checks if an upstream is reachable.
 func (p *ProxyService) CheckUpstreamHealth(name string) (bool, error) {
+	return p.CheckUpstreamHealthContext(context.Background(), name)
+}
+
+// CheckUpstreamHealthContext is CheckUpstreamHealth bounded by ctx.
+func (p *ProxyService) CheckUpstreamHealthContext(ctx context.Context, name string) (bool, error) {
 	p.mu.RLock()
 	var upstream *UpstreamSource
 	for _, u := range p.upstreams {
@@ -388,13 +408,13 @@ func (p *ProxyService) CheckUpstreamHealth(name string) (bool, error) {
 		return false, fmt.Errorf("upstream %s not found", name)
 	}
 
-	url := fmt.Sprintf("%s/v2/", upstream.URL)
-	req, err := http.NewRequest("GET", url, nil)
+	url := upstream.baseURL() + "/v2/"
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return false, err
 	}
 
-	client := &http.Client{Timeout: 5 * time.Second}
+	client := &http.Client{Timeout: 5 * time.Second, Transport: p.getHTTPClient().Transport}
 	resp, err := client.Do(req)
 	if err != nil {
 		return false, nil // Unreachable but not an error
@@ -411,20 +431,35 @@ func (p *ProxyService) SetCustomResolver(resolver *net.Resolver) {
 	defer p.mu.Unlock()
 
 	p.customResolver = resolver
-
 	// 重新创建HTTP客户端，使用自定义DNS解析器
+	p.httpClient = p.newHTTPClient()
+}
+
+// SetOutboundProxy 设置访问上游使用的 HTTP 代理，nil 表示直连
+func (p *ProxyService) SetOutboundProxy(proxy func(*http.Request) (*url.URL, error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.outboundProxy = proxy
+	p.httpClient = p.newHTTPClient()
+}
+
+// newHTTPClient creates the upstream client from the DNS resolver and the
+// proxy. Must be called with p.mu held or before the service is shared.
+func (p *ProxyService) newHTTPClient() *http.Client {
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 	}
 
-	if resolver != nil {
-		dialer.Resolver = resolver
+	if p.customResolver != nil {
+		dialer.Resolver = p.customResolver
 	}
 
-	p.httpClient = &http.Client{
+	return &http.Client{
 		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
+			Proxy:                 p.outboundProxy,
 			DialContext:           dialer.DialContext,
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
@@ -434,6 +469,13 @@ func (p *ProxyService) SetCustomResolver(resolver *net.Resolver) {
 	}
 }
 
+// getHTTPClient returns the client for upstream requests.
+func (p *ProxyService) getHTTPClient() *http.Client {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.httpClient
+}
+
 // SetP2PProvider 设置P2P服务提供者
 func (p *ProxyService) SetP2PProvider(provider P2PProvider) {
 	p.mu.Lock()
@@ -0,0 +1,293 @@
+// Package accelerator provides image acceleration and caching functionality.
+package accelerator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of upstream registries. The kind selects how image names are
+// mapped to upstream repositories; it is detected from the URL when not set.
+const (
+	UpstreamGeneric   = "generic"
+	UpstreamDockerHub = "dockerhub"
+	UpstreamGHCR      = "ghcr"
+	UpstreamQuay      = "quay"
+	UpstreamHarbor    = "harbor"
+)
+
+// UpstreamTypes lists the supported upstream kinds.
+var UpstreamTypes = []string{UpstreamGeneric, UpstreamDockerHub, UpstreamGHCR, UpstreamQuay, UpstreamHarbor}
+
+// DefaultPathTemplate is the registry API path of a blob or manifest.
+// {name} is the upstream repository, {kind} blobs or manifests and
+// {reference} a digest or tag.
+const DefaultPathTemplate = "/v2/{name}/{kind}/{reference}"
+
+// NameRewrite rewrites image names matching a regular expression before
+// they are sent upstream. Replace may refer to groups as $1.
+type NameRewrite struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+}
+
+// registryHosts are the hosts whose name prefix is dropped for each kind,
+// e.g. docker.io/library/nginx is library/nginx on Docker Hub.
+var registryHosts = map[string][]string{
+	UpstreamDockerHub: {"docker.io", "index.docker.io", "registry-1.docker.io"},
+	UpstreamGHCR:      {"ghcr.io"},
+	UpstreamQuay:      {"quay.io"},
+}
+
+// kind returns the kind of the upstream, detected from its host when Type
+// is empty.
+func (u UpstreamSource) kind() string {
+	if u.Type != "" {
+		return u.Type
+	}
+	parsed, err := url.Parse(u.URL)
+	if err != nil {
+		return UpstreamGeneric
+	}
+	for kind, hosts := range registryHosts {
+		for _, host := range hosts {
+			if parsed.Hostname() == host {
+				return kind
+			}
+		}
+	}
+	return UpstreamGeneric
+}
+
+// baseURL returns the URL requests are sent to. Docker Hub serves the
+// registry API on registry-1.docker.io only.
+func (u UpstreamSource) baseURL() string {
+	base := strings.TrimSuffix(u.URL, "/")
+	if parsed, err := url.Parse(base); err == nil && u.kind() == UpstreamDockerHub &&
+		(parsed.Host == "docker.io" || parsed.Host == "index.docker.io") {
+		parsed.Host = "registry-1.docker.io"
+		base = parsed.String()
+	}
+	return base
+}
+
+// repository maps an image name to the repository on the upstream: the
+// registry host prefix is dropped, the first matching rewrite applied, and
+// on Docker Hub official images get the library/ namespace.
+func (u UpstreamSource) repository(name string) string {
+	name = strings.Trim(name, "/")
+	kind := u.kind()
+	for _, host := range registryHosts[kind] {
+		if rest, ok := strings.CutPrefix(name, host+"/"); ok {
+			name = rest
+			break
+		}
+	}
+
+	for _, rw := range u.Rewrites {
+		re, err := regexp.Compile(rw.Match)
+		if err != nil || !re.MatchString(name) {
+			continue
+		}
+		name = re.ReplaceAllString(name, rw.Replace)
+		break
+	}
+
+	if kind == UpstreamDockerHub && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	return name
+}
+
+// endpoint returns the URL of a blob or manifest of repository.
+func (u UpstreamSource) endpoint(repository, kind, reference string) string {
+	template := u.PathTemplate
+	if template == "" {
+		template = DefaultPathTemplate
+	}
+	path := strings.NewReplacer(
+		"{name}", repository,
+		"{kind}", kind,
+		"{reference}", reference,
+	).Replace(template)
+	return u.baseURL() + "/" + strings.TrimPrefix(path, "/")
+}
+
+// ValidateUpstream checks the kind, path template and rewrites of an
+// upstream.
+func ValidateUpstream(u UpstreamSource) error {
+	if u.Type != "" {
+		valid := false
+		for _, t := range UpstreamTypes {
+			valid = valid || u.Type == t
+		}
+		if !valid {
+			return fmt.Errorf("upstream %s: unknown type %q", u.Name, u.Type)
+		}
+	}
+	if u.PathTemplate != "" {
+		for _, p := range []string{"{name}", "{kind}", "{reference}"} {
+			if !strings.Contains(u.PathTemplate, p) {
+				return fmt.Errorf("upstream %s: path template must contain %s", u.Name, p)
+			}
+		}
+	}
+	for _, rw := range u.Rewrites {
+		if _, err := regexp.Compile(rw.Match); err != nil {
+			return fmt.Errorf("upstream %s: invalid rewrite %q: %w", u.Name, rw.Match, err)
+		}
+	}
+	return nil
+}
+
+// ============================================================================
+// Token authentication
+// ============================================================================
+
+// upstreamToken is a bearer token issued by the auth service of an
+// upstream.
+type upstreamToken struct {
+	token   string
+	expires time.Time
+}
+
+// tokenCache holds bearer tokens by upstream and repository, so only the
+// first request of a repository goes through the 401 challenge.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]upstreamToken
+}
+
+func (c *tokenCache) get(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[key]; ok && time.Now().Before(t.expires) {
+		return t.token
+	}
+	return ""
+}
+
+func (c *tokenCache) put(key, token string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.tokens == nil {
+		c.tokens = make(map[string]upstreamToken)
+	}
+	c.tokens[key] = upstreamToken{token: token, expires: time.Now().Add(ttl)}
+}
+
+// defaultTokenTTL is used when the auth service does not say how long a
+// token is valid; registries issue tokens for at least 60 seconds.
+const defaultTokenTTL = 60 * time.Second
+
+// challengeParams matches the key="value" pairs of a WWW-Authenticate
+// header.
+var challengeParams = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// fetchToken answers a Bearer challenge with an anonymous token for
+// pulling repository.
+func (p *ProxyService) fetchToken(challenge, repository string) (string, time.Duration, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", 0, fmt.Errorf("unsupported auth scheme %q", scheme)
+	}
+	values := make(map[string]string)
+	for _, m := range challengeParams.FindAllStringSubmatch(params, -1) {
+		values[strings.ToLower(m[1])] = m[2]
+	}
+	realm := values["realm"]
+	if realm == "" {
+		return "", 0, fmt.Errorf("auth challenge without realm")
+	}
+
+	query := url.Values{}
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	scope := values["scope"]
+	if scope == "" {
+		scope = "repository:" + repository + ":pull"
+	}
+	query.Set("scope", scope)
+
+	resp, err := p.getHTTPClient().Get(realm + "?" + query.Encode())
+	if err != nil {
+		return "", 0, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token service returned status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("invalid token response: %w", err)
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", 0, fmt.Errorf("token service returned no token")
+	}
+	ttl := defaultTokenTTL
+	if body.ExpiresIn > 0 {
+		ttl = time.Duration(body.ExpiresIn) * time.Second
+	}
+	// Renew a little early so a token does not expire in flight
+	return token, ttl - ttl/10, nil
+}
+
+// getUpstream requests a blob or manifest of name from upstream. A 401 with
+// a Bearer challenge is answered with an anonymous pull token and the
+// request retried once.
+func (p *ProxyService) getUpstream(upstream UpstreamSource, name, kind, reference, accept string) (*http.Response, error) {
+	repository := upstream.repository(name)
+	target := upstream.endpoint(repository, kind, reference)
+	key := upstream.baseURL() + "|" + repository
+	client := p.getHTTPClient()
+
+	do := func(token string) (*http.Response, error) {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Accept", accept)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("upstream request failed: %w", err)
+		}
+		return resp, nil
+	}
+
+	resp, err := do(p.tokens.get(key))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if challenge == "" {
+		return nil, fmt.Errorf("upstream returned status %d", http.StatusUnauthorized)
+	}
+
+	token, ttl, err := p.fetchToken(challenge, repository)
+	if err != nil {
+		return nil, fmt.Errorf("upstream authentication failed: %w", err)
+	}
+	p.tokens.put(key, token, ttl)
+	return do(token)
+}
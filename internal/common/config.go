@@ -1,9 +1,23 @@
 package common
 
 import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/pkg/logger"
 	"cyp-docker-registry/pkg/p2p"
+	"cyp-docker-registry/pkg/signature"
+	"cyp-docker-registry/pkg/utils"
 
 	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
 )
 
 // Config represents the application configuration.
@@ -14,12 +28,356 @@ type Config struct {
 	Update      UpdateConfig      `mapstructure:"update"`
 	Auth        AuthConfig        `mapstructure:"auth"`
 	P2P         *p2p.Config       `mapstructure:"p2p"`
+	Backup      BackupConfig      `mapstructure:"backup"`
+	Workflow    WorkflowConfig    `mapstructure:"workflow"`
+	Maintenance MaintenanceConfig `mapstructure:"maintenance"`
+	Sync        SyncConfig        `mapstructure:"sync"`
+	Notify      NotifyConfig      `mapstructure:"notify"`
+	Security    SecurityConfig    `mapstructure:"security"`
+	Logging     LoggingConfig     `mapstructure:"logging"`
+	Signature   SignatureConfig   `mapstructure:"signature"`
+	SBOM        SBOMConfig        `mapstructure:"sbom"`
+	Health      HealthConfig      `mapstructure:"health"`
+	Cluster     ClusterConfig     `mapstructure:"cluster"`
+	Redis       RedisConfig       `mapstructure:"redis"`
+	DNS         DNSConfig         `mapstructure:"dns"`
+	Proxy       ProxyConfig       `mapstructure:"proxy"`
+	Environment EnvironmentConfig `mapstructure:"environment"`
+	Promotion   PromotionConfig   `mapstructure:"promotion"`
+	Provenance  ProvenanceConfig  `mapstructure:"provenance"`
+
+	// file is the configuration file the values were read from, if any.
+	file string
+	// inFile reports whether a key is set in the file.
+	inFile func(key string) bool
+}
+
+// File returns the configuration file the values were read from, or "" when
+// only defaults and environment were used.
+func (c *Config) File() string {
+	return c.file
+}
+
+// InFile reports whether key, e.g. storage.max_cache_size, is set in the
+// configuration file rather than left to its default.
+func (c *Config) InFile(key string) bool {
+	return c.inFile != nil && c.inFile(key)
+}
+
+// EnvironmentConfig represents the detection of the host environment.
+type EnvironmentConfig struct {
+	// On first boot, pick the cache size, concurrency and P2P settings not
+	// set in the file from the detected CPU, memory, disk and network
+	AutoConfigure bool `mapstructure:"auto_configure"`
+}
+
+// SignatureConfig represents image signature configuration.
+type SignatureConfig struct {
+	Mode string `mapstructure:"mode"` // enforce, warn, disabled
+	// Backend verifies signatures: builtin signatures made through the API,
+	// or notation (Notary Project) signatures pushed as OCI referrers
+	Backend  string         `mapstructure:"backend"`
+	Notation NotationConfig `mapstructure:"notation"`
+	// KeyProvider holds the signing keys of builtin signatures and TUF:
+	// file, or pkcs11, awskms and gcpkms for non-exportable keys
+	KeyProvider string       `mapstructure:"key_provider"`
+	KeyID       string       `mapstructure:"key_id"`   // 默认签名密钥
+	KeysDir     string       `mapstructure:"keys_dir"` // file 提供者的密钥目录
+	PKCS11      PKCS11Config `mapstructure:"pkcs11"`
+	AWSKMS      AWSKMSConfig `mapstructure:"aws_kms"`
+	GCPKMS      GCPKMSConfig `mapstructure:"gcp_kms"`
+}
+
+// PKCS11Config locates the PKCS#11 token (HSM, YubiKey) holding the keys.
+// Keys are identified by their label. Requires a build with -tags pkcs11.
+type PKCS11Config struct {
+	Module     string `mapstructure:"module"`      // PKCS#11 模块路径
+	TokenLabel string `mapstructure:"token_label"` // 令牌标签，为空时使用 slot
+	Slot       int    `mapstructure:"slot"`
+	PIN        string `mapstructure:"pin"` // 为空时读取 CYP_PKCS11_PIN
+}
+
+// AWSKMSConfig configures AWS KMS keys, identified by key ID, ARN or alias.
+// Credentials are read from the AWS_* environment variables.
+type AWSKMSConfig struct {
+	Region   string `mapstructure:"region"`
+	Endpoint string `mapstructure:"endpoint"` // 可选，如 VPC 终端节点
+}
+
+// GCPKMSConfig configures Google Cloud KMS keys, identified by the resource
+// name of their key version.
+type GCPKMSConfig struct {
+	CredentialsFile string `mapstructure:"credentials_file"` // 服务账号密钥，为空时使用元数据服务器
+	Endpoint        string `mapstructure:"endpoint"`
+}
+
+// validateKeyProvider checks the key provider and the settings it needs.
+func (s SignatureConfig) validateKeyProvider() error {
+	switch s.KeyProvider {
+	case "", "file":
+	case "pkcs11":
+		if s.PKCS11.Module == "" {
+			return fmt.Errorf("signature.pkcs11.module: 使用 pkcs11 时必须配置模块路径")
+		}
+		if s.PKCS11.TokenLabel == "" && s.PKCS11.Slot < 0 {
+			return fmt.Errorf("signature.pkcs11: 必须配置 token_label 或 slot")
+		}
+	case "awskms":
+		if s.AWSKMS.Region == "" {
+			return fmt.Errorf("signature.aws_kms.region: 使用 awskms 时必须配置区域")
+		}
+	case "gcpkms":
+		if !strings.HasPrefix(s.KeyID, "projects/") || !strings.Contains(s.KeyID, "/cryptoKeyVersions/") {
+			return fmt.Errorf("signature.key_id: gcpkms 的密钥须为密钥版本的资源名称 projects/.../cryptoKeyVersions/N")
+		}
+	default:
+		return fmt.Errorf("signature.key_provider: 无效的密钥提供者 %q", s.KeyProvider)
+	}
+	if s.KeyID == "" {
+		return fmt.Errorf("signature.key_id: 不能为空")
+	}
+	return nil
+}
+
+// NotationConfig locates the notation trust policy and trust store.
+type NotationConfig struct {
+	TrustPolicy string `mapstructure:"trust_policy"` // trustpolicy.json，按仓库范围配置可信身份
+	TrustStore  string `mapstructure:"trust_store"`  // 证书目录，结构同 notation：x509/<类型>/<名称>/*.pem
+}
+
+// PromotionConfig defines the channels images are promoted through, in
+// order, e.g. dev, staging, prod. A channel is a tag of each repository:
+// promoting retags the digest of one channel as the next once the gate of
+// the next channel is approved.
+type PromotionConfig struct {
+	Channels []PromotionChannelConfig `mapstructure:"channels"`
+}
+
+// PromotionChannelConfig is a channel and the gate images pass to enter it.
+// The gate of the first channel is not used, images are pushed there.
+type PromotionChannelConfig struct {
+	Name              string `mapstructure:"name"`
+	Tag               string `mapstructure:"tag"`                 // 通道的标签，默认与名称相同
+	ApproverRole      string `mapstructure:"approver_role"`       // admin（默认）或 maintainer（能管理该仓库的用户）
+	Approvals         int    `mapstructure:"approvals"`           // 需要的批准数，默认 1
+	AllowSelfApproval bool   `mapstructure:"allow_self_approval"` // 发起人能否批准自己的请求
+	RequireScan       string `mapstructure:"require_scan"`        // 不得有该级别及以上的漏洞：critical, high, medium, low
+	RequireSignature  bool   `mapstructure:"require_signature"`   // 镜像须有有效签名
+}
+
+// ChannelTag returns the tag of the channel.
+func (p PromotionChannelConfig) ChannelTag() string {
+	if p.Tag != "" {
+		return p.Tag
+	}
+	return p.Name
+}
+
+// channelTagPattern follows the tag grammar of the distribution spec.
+var channelTagPattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// validate checks the promotion channels.
+func (p PromotionConfig) validate() error {
+	names := map[string]bool{}
+	tags := map[string]bool{}
+	for i, ch := range p.Channels {
+		if ch.Name == "" || strings.Contains(ch.Name, "/") {
+			return fmt.Errorf("promotion.channels[%d]: 无效的名称 %q", i, ch.Name)
+		}
+		if names[ch.Name] {
+			return fmt.Errorf("promotion.channels[%d]: 通道 %s 重复", i, ch.Name)
+		}
+		names[ch.Name] = true
+		tag := ch.ChannelTag()
+		if !channelTagPattern.MatchString(tag) {
+			return fmt.Errorf("promotion.channels[%d]: 无效的标签 %q", i, tag)
+		}
+		if tags[tag] {
+			return fmt.Errorf("promotion.channels[%d]: 标签 %s 已被其他通道使用", i, tag)
+		}
+		tags[tag] = true
+		switch ch.ApproverRole {
+		case "", "admin", "maintainer":
+		default:
+			return fmt.Errorf("promotion.channels[%d]: 无效的审批角色 %q", i, ch.ApproverRole)
+		}
+		if ch.Approvals < 0 {
+			return fmt.Errorf("promotion.channels[%d]: approvals 不能为负数", i)
+		}
+		switch ch.RequireScan {
+		case "", "critical", "high", "medium", "low":
+		default:
+			return fmt.Errorf("promotion.channels[%d]: 无效的漏洞级别 %q", i, ch.RequireScan)
+		}
+	}
+	return nil
+}
+
+// ProvenanceConfig defines the builders whose SLSA provenance attestations
+// are trusted. Attestations are pushed as OCI referrers of an image and
+// verified against the key of the builder named in them.
+type ProvenanceConfig struct {
+	TrustedBuilders []TrustedBuilderConfig `mapstructure:"trusted_builders"`
+}
+
+// TrustedBuilderConfig is a builder and the key its attestations are
+// signed with.
+type TrustedBuilderConfig struct {
+	Name      string `mapstructure:"name"`
+	ID        string `mapstructure:"id"`         // 证明中的 builder.id，以 * 结尾时按前缀匹配
+	PublicKey string `mapstructure:"public_key"` // PEM 公钥或公钥文件路径（ECDSA、Ed25519、RSA）
+}
+
+// validate checks the trusted builders.
+func (p ProvenanceConfig) validate() error {
+	names := map[string]bool{}
+	for i, b := range p.TrustedBuilders {
+		if b.Name == "" {
+			return fmt.Errorf("provenance.trusted_builders[%d]: 名称不能为空", i)
+		}
+		if names[b.Name] {
+			return fmt.Errorf("provenance.trusted_builders[%d]: 构建者 %s 重复", i, b.Name)
+		}
+		names[b.Name] = true
+		if b.ID == "" || b.ID == "*" {
+			return fmt.Errorf("provenance.trusted_builders[%d]: id 不能为空", i)
+		}
+		if _, err := signature.ParsePublicKey(b.PublicKey); err != nil {
+			return fmt.Errorf("provenance.trusted_builders[%d]: 无效的公钥: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// HealthConfig represents the /healthz and /readyz probe configuration.
+type HealthConfig struct {
+	Timeout        string `mapstructure:"timeout"`         // 单项检查的超时时间，应小于探针的 timeoutSeconds
+	CheckUpstreams bool   `mapstructure:"check_upstreams"` // 就绪检查是否包含加速器上游的连通性
+}
+
+// ClusterConfig represents multi-instance configuration. Instances of a
+// cluster share the data directory and the database; one of them is elected
+// leader and alone runs scheduled jobs.
+type ClusterConfig struct {
+	Enabled    bool   `mapstructure:"enabled"`
+	InstanceID string `mapstructure:"instance_id"` // 默认使用主机名，Kubernetes 中即 Pod 名称
+	LeaseTTL   string `mapstructure:"lease_ttl"`   // 领导者租约时长，实例失联超过该时间后由其他实例接管
+}
+
+// RedisConfig represents the optional Redis server through which instances
+// share hot state. Each kind of state can be shared or kept local; when
+// Redis is unreachable at startup everything stays local.
+type RedisConfig struct {
+	Enabled   bool   `mapstructure:"enabled"`
+	Addr      string `mapstructure:"addr"`
+	Password  string `mapstructure:"password"`
+	DB        int    `mapstructure:"db"`
+	KeyPrefix string `mapstructure:"key_prefix"` // 多套部署共用一个 Redis 时用于区分
+	Cache     bool   `mapstructure:"cache"`      // 加速器缓存索引
+	Sessions  bool   `mapstructure:"sessions"`   // 登录会话
+	RateLimit bool   `mapstructure:"rate_limit"` // 限流令牌桶
+}
+
+// DNSConfig selects the DNS servers used to resolve upstream registries and
+// by the DNS lookup API. Servers are written as 8.8.8.8, tls://1.1.1.1
+// (DNS over TLS) or https://dns.google/dns-query (DNS over HTTPS).
+type DNSConfig struct {
+	Servers   []string         `mapstructure:"servers"`    // 按顺序尝试，为空时使用系统 DNS
+	Routes    []DNSRouteConfig `mapstructure:"routes"`     // 按域名选择服务器，如 docker.io 走 DoH
+	CacheSize int              `mapstructure:"cache_size"` // 缓存的应答数，按 TTL 过期，0 表示关闭
+}
+
+// DNSRouteConfig sends queries for Domains and their subdomains to Servers.
+type DNSRouteConfig struct {
+	Domains []string `mapstructure:"domains"`
+	Servers []string `mapstructure:"servers"`
+}
+
+// SBOMConfig represents SBOM generation configuration.
+type SBOMConfig struct {
+	Generator string       `mapstructure:"generator"` // syft, trivy
+	VulnDB    VulnDBConfig `mapstructure:"vuln_db"`
+}
+
+// VulnDBConfig represents the vulnerability database images are re-scanned
+// against when it changes.
+type VulnDBConfig struct {
+	Source          string `mapstructure:"source"`           // 本地文件或 http(s) URL，为空时不启用持续扫描
+	RefreshInterval string `mapstructure:"refresh_interval"` // 检查漏洞库更新的间隔
+}
+
+// LoggingConfig represents logging configuration. Only the level can be
+// changed without a restart.
+type LoggingConfig struct {
+	Level  string `mapstructure:"level"`  // debug, info, warn, error
+	Format string `mapstructure:"format"` // 控制台输出的编码：json, console
+	Output string `mapstructure:"output"` // stdout, stderr, none
+
+	File   LogFileConfig `mapstructure:"file"`   // 应用日志文件，与控制台输出同时写入
+	Access LogFileConfig `mapstructure:"access"` // HTTP 访问日志，未配置时写入应用日志
+	Audit  LogFileConfig `mapstructure:"audit"`  // 审计事件，每行一个 JSON 对象
+}
+
+// LogFileConfig represents a rotated log file. It is disabled when Path is
+// empty.
+type LogFileConfig struct {
+	Path       string `mapstructure:"path"`
+	Format     string `mapstructure:"format"`      // json, console
+	MaxSize    string `mapstructure:"max_size"`    // 超过后轮转，如 "100MB"
+	MaxAge     string `mapstructure:"max_age"`     // 轮转文件的保留时长，如 "720h"
+	MaxBackups int    `mapstructure:"max_backups"` // 保留的轮转文件数量
+}
+
+// Output converts the file settings to a log output. Invalid values are
+// rejected by Validate.
+func (f LogFileConfig) Output() logger.Output {
+	maxAge, _ := time.ParseDuration(f.MaxAge)
+	return logger.Output{
+		Path:   f.Path,
+		Format: f.Format,
+		Rotation: logger.Rotation{
+			MaxSize:    utils.ParseSize(f.MaxSize),
+			MaxAge:     maxAge,
+			MaxBackups: f.MaxBackups,
+		},
+	}
 }
 
 // ServerConfig represents server configuration.
 type ServerConfig struct {
 	Port int    `mapstructure:"port"`
 	Host string `mapstructure:"host"`
+
+	// development or production. Production refuses to start without a
+	// configured JWT secret instead of generating one.
+	Mode string `mapstructure:"mode"`
+
+	// Cross-origin policies of /api and /v2
+	CORS CORSConfig `mapstructure:"cors"`
+
+	// TLS serves HTTPS directly, needed for client certificates
+	TLS ServerTLSConfig `mapstructure:"tls"`
+
+	// Addresses or CIDRs of reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers give the client address. Empty trusts none.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// ServerTLSConfig is the certificate the server listens with. Both files
+// empty serves plain HTTP, e.g. behind a TLS terminating proxy.
+type ServerTLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+}
+
+// Enabled reports whether the server listens with TLS.
+func (t ServerTLSConfig) Enabled() bool {
+	return t.CertFile != "" && t.KeyFile != ""
+}
+
+// IsProduction reports whether the server runs in production mode.
+func (c ServerConfig) IsProduction() bool {
+	return c.Mode == "production"
 }
 
 // StorageConfig represents storage configuration.
@@ -28,12 +386,40 @@ type StorageConfig struct {
 	MetaPath     string `mapstructure:"meta_path"`
 	CachePath    string `mapstructure:"cache_path"`
 	MaxCacheSize string `mapstructure:"max_cache_size"`
+
+	// Storage-side blob transcoding: none, zstd or gzip
+	Compression        string `mapstructure:"compression"`
+	CompressionLevel   int    `mapstructure:"compression_level"`
+	CompressionMinSize string `mapstructure:"compression_min_size"`
+
+	// How often the deduplicated storage usage index is rebuilt
+	UsageRefreshInterval string `mapstructure:"usage_refresh_interval"`
+
+	// How long deleted tags stay in the recycle bin, "0" deletes immediately
+	TrashRetention string `mapstructure:"trash_retention"`
+
+	// Free space watchdog of the blob volume
+	DiskWatch DiskWatchConfig `mapstructure:"disk_watch"`
+}
+
+// DiskWatchConfig configures the free space watchdog of the blob volume.
+// Thresholds are free space, either a size such as 20GB or a percentage of
+// the volume such as 10%.
+type DiskWatchConfig struct {
+	Interval     string   `mapstructure:"interval"`      // 检查间隔，0 表示关闭
+	WarnFree     []string `mapstructure:"warn_free"`     // 剩余空间低于这些阈值时告警
+	ReadOnlyFree string   `mapstructure:"readonly_free"` // 低于该值时拒绝推送
+	ResumeFree   string   `mapstructure:"resume_free"`   // 只读后剩余空间恢复到该值以上时恢复写入
 }
 
 // AcceleratorConfig represents accelerator configuration.
 type AcceleratorConfig struct {
 	Enabled   bool             `mapstructure:"enabled"`
 	Upstreams []UpstreamConfig `mapstructure:"upstreams"`
+	// Eviction policy of the layer cache: lru, lfu, arc or gdsf
+	CachePolicy string `mapstructure:"cache_policy"`
+	// How often pinned images are refreshed from upstream, 0 disables
+	PinRefreshInterval string `mapstructure:"pin_refresh_interval"`
 }
 
 // UpstreamConfig represents upstream source configuration.
@@ -41,6 +427,17 @@ type UpstreamConfig struct {
 	Name     string `mapstructure:"name"`
 	URL      string `mapstructure:"url"`
 	Priority int    `mapstructure:"priority"`
+	// generic, dockerhub, ghcr, quay or harbor; detected from the URL when empty
+	Type         string              `mapstructure:"type"`
+	PathTemplate string              `mapstructure:"path_template"` // 默认 /v2/{name}/{kind}/{reference}
+	Rewrites     []NameRewriteConfig `mapstructure:"rewrites"`
+}
+
+// NameRewriteConfig rewrites image names matching Match before they are
+// sent to an upstream.
+type NameRewriteConfig struct {
+	Match   string `mapstructure:"match"`   // 正则表达式
+	Replace string `mapstructure:"replace"` // 可引用分组，如 $1
 }
 
 // UpdateConfig represents update configuration.
@@ -48,6 +445,14 @@ type UpdateConfig struct {
 	CheckInterval string `mapstructure:"check_interval"`
 	AutoUpdate    bool   `mapstructure:"auto_update"`
 	UpdateURL     string `mapstructure:"update_url"`
+	Channel       string `mapstructure:"channel"` // stable, beta, dev
+	Delta         bool   `mapstructure:"delta"`   // 优先下载 bsdiff 增量补丁
+	// minisign public key, or PEM public key for cosign signatures, inline
+	// or as a file path; downloaded binaries must be signed with it
+	PublicKey        string `mapstructure:"public_key"`
+	RequireSignature bool   `mapstructure:"require_signature"` // 关闭后没有签名的版本仅校验 SHA-256
+	AutoRestart      bool   `mapstructure:"auto_restart"`      // 应用更新后自动重启（非 Docker）
+	HealthWindow     string `mapstructure:"health_window"`     // 重启后通过健康检查的期限，超时回滚到备份
 }
 
 // AuthConfig represents authentication configuration.
@@ -55,6 +460,313 @@ type AuthConfig struct {
 	Enabled  bool   `mapstructure:"enabled"`
 	Username string `mapstructure:"username"`
 	Password string `mapstructure:"password"`
+
+	// Visibility of repositories without their own setting: private, internal or public
+	DefaultVisibility string `mapstructure:"default_visibility"`
+
+	// Permission of plain org members on the org's repositories: none, read or write.
+	// Teams can grant more on top of it.
+	OrgMemberPermission string `mapstructure:"org_member_permission"`
+
+	// Client certificate authentication of /v2, requires server.tls
+	MTLS MTLSConfig `mapstructure:"mtls"`
+}
+
+// MTLSConfig authenticates /v2 clients by their TLS certificate. A verified
+// certificate maps to a user by a field of the certificate; requests with
+// an Authorization header still use it, unless no certificate is given and
+// one is required.
+type MTLSConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	ClientCA string `mapstructure:"client_ca"` // 签发客户端证书的 CA，PEM 文件
+	Required bool   `mapstructure:"required"`  // 没有客户端证书的 /v2 请求被拒绝
+
+	// Field mapped to the username: cn, email, dns or uri (SAN)
+	UserField string           `mapstructure:"user_field"`
+	Users     []CertUserConfig `mapstructure:"users"`
+
+	CRLFiles     []string `mapstructure:"crl_files"`      // PEM 或 DER 格式的吊销列表，修改后自动重新加载
+	OCSP         bool     `mapstructure:"ocsp"`           // 向证书中的 OCSP 服务器查询吊销状态
+	OCSPSoftFail bool     `mapstructure:"ocsp_soft_fail"` // OCSP 不可用时放行
+}
+
+// CertUserConfig maps a certificate whose user field equals Match to User,
+// for certificates not named after their user.
+type CertUserConfig struct {
+	Match string `mapstructure:"match"`
+	User  string `mapstructure:"user"`
+}
+
+// validate checks the client certificate settings.
+func (m MTLSConfig) validate(tls ServerTLSConfig) error {
+	if !m.Enabled {
+		return nil
+	}
+	if !tls.Enabled() {
+		return fmt.Errorf("auth.mtls: 客户端证书认证需要配置 server.tls")
+	}
+	data, err := os.ReadFile(m.ClientCA)
+	if err != nil {
+		return fmt.Errorf("auth.mtls.client_ca: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("auth.mtls.client_ca: 没有有效的 PEM 证书")
+	}
+	switch m.UserField {
+	case "cn", "email", "dns", "uri":
+	default:
+		return fmt.Errorf("auth.mtls.user_field: 无效的字段 %q", m.UserField)
+	}
+	for i, u := range m.Users {
+		if u.Match == "" || u.User == "" {
+			return fmt.Errorf("auth.mtls.users[%d]: match 和 user 不能为空", i)
+		}
+	}
+	for _, path := range m.CRLFiles {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("auth.mtls.crl_files: %v", err)
+		}
+	}
+	return nil
+}
+
+// BackupConfig represents backup configuration.
+type BackupConfig struct {
+	Enabled      bool     `mapstructure:"enabled"`
+	Path         string   `mapstructure:"path"`
+	IncludeBlobs bool     `mapstructure:"include_blobs"`
+	KeyPaths     []string `mapstructure:"key_paths"`
+	ConfigPaths  []string `mapstructure:"config_paths"`
+	Retention    int      `mapstructure:"retention"`
+
+	Targets []BackupTargetConfig `mapstructure:"targets"`
+}
+
+// SyncConfig represents image sync configuration.
+type SyncConfig struct {
+	Parallel     int    `mapstructure:"parallel"`      // 并发推送的层数
+	MaxRetries   int    `mapstructure:"max_retries"`   // 单层失败重试次数
+	RetryBackoff string `mapstructure:"retry_backoff"` // 首次重试等待时间，如 1s
+	MasterKey    string `mapstructure:"master_key"`    // 凭证加密主密钥，为空时使用密钥文件
+}
+
+// WorkflowConfig represents workflow job retention configuration.
+type WorkflowConfig struct {
+	JobRetention       string `mapstructure:"job_retention"`         // 作业保留时长，如 720h
+	MaxJobsPerWorkflow int    `mapstructure:"max_jobs_per_workflow"` // 每个工作流保留的作业数
+}
+
+// MaintenanceConfig represents the periodic removal of expired sessions,
+// tokens, share links and interrupted uploads.
+type MaintenanceConfig struct {
+	SweepInterval    string `mapstructure:"sweep_interval"`    // 清理间隔，如 1h
+	ExpiredRetention string `mapstructure:"expired_retention"` // 过期的访问令牌和分享链接保留多久后删除
+	UploadTTL        string `mapstructure:"upload_ttl"`        // 未完成的上传临时文件保留时长
+	ScrubInterval    string `mapstructure:"scrub_interval"`    // 完整性检查间隔，0 表示关闭
+	ScrubBatch       string `mapstructure:"scrub_batch"`       // 每次检查的数据量，如 50GB，为空时检查全部
+	EventRetention   string `mapstructure:"event_retention"`   // 推送/拉取/删除事件保留时长
+}
+
+// NotifyConfig represents notification channel configuration.
+type NotifyConfig struct {
+	Channels struct {
+		Email EmailConfig `mapstructure:"email"`
+	} `mapstructure:"channels"`
+	AccessDigest AccessDigestConfig `mapstructure:"access_digest"`
+}
+
+// AccessDigestConfig represents the daily digest of access attempts sent
+// to the subscribed administrators.
+type AccessDigestConfig struct {
+	Enabled        bool    `mapstructure:"enabled"`
+	Hour           int     `mapstructure:"hour"`            // 发送前一天摘要的时间（0-23 点，服务器时区）
+	SpikeThreshold int64   `mapstructure:"spike_threshold"` // 一小时内认证失败达到此数才视为激增
+	SpikeFactor    float64 `mapstructure:"spike_factor"`    // 且达到前 7 天小时均值的倍数
+}
+
+// EmailConfig represents the SMTP settings of the email channel.
+type EmailConfig struct {
+	Enabled  bool     `mapstructure:"enabled"`
+	SMTPHost string   `mapstructure:"smtp_host"`
+	SMTPPort int      `mapstructure:"smtp_port"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	From     string   `mapstructure:"from"` // 发件人，为空时使用 username
+	To       []string `mapstructure:"to"`   // 系统告警的收件人
+}
+
+// SecurityConfig represents security configuration.
+type SecurityConfig struct {
+	IPRules   []IPRuleConfig  `mapstructure:"ip_rules"`
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	JWT       JWTConfig       `mapstructure:"jwt"`
+
+	IntrusionDetection IntrusionDetectionConfig `mapstructure:"intrusion_detection"`
+
+	Headers       SecurityHeadersConfig `mapstructure:"headers"`
+	HTTPSRedirect HTTPSRedirectConfig   `mapstructure:"https_redirect"`
+	AutoLock      AutoLockConfig        `mapstructure:"auto_lock"`
+
+	AuditRedaction AuditRedactionConfig `mapstructure:"audit_redaction"`
+}
+
+// AuditRedactionConfig represents how IP addresses and usernames are
+// anonymized when audit logs are listed or exported. The stored logs are
+// not changed.
+type AuditRedactionConfig struct {
+	Enabled  bool   `mapstructure:"enabled"`
+	After    string `mapstructure:"after"`    // 记录写入多久后脱敏，如 "720h"，"0" 表示全部脱敏
+	IP       string `mapstructure:"ip"`       // truncate 或 hash
+	Username string `mapstructure:"username"` // truncate 或 hash
+	KeyFile  string `mapstructure:"key_file"` // 哈希密钥文件，为空时为 <meta_path>/audit_redaction.key
+}
+
+// AutoLockConfig represents the system lock settings read by the server.
+type AutoLockConfig struct {
+	Unlock UnlockConfig `mapstructure:"unlock"`
+}
+
+// UnlockConfig represents how a locked system may be unlocked through
+// POST /api/v1/system/lock/unlock and the brute-force limits of that
+// endpoint.
+type UnlockConfig struct {
+	AllowPassword  bool   `mapstructure:"allow_password"`  // 允许管理员用密码解锁，关闭时只能后台处理
+	MaxAttempts    int    `mapstructure:"max_attempts"`    // 每个 IP 在 attempt_window 内的失败次数上限
+	AttemptWindow  string `mapstructure:"attempt_window"`  // 如 "1h"
+	BaseDelay      string `mapstructure:"base_delay"`      // 每次失败后等待时间翻倍
+	MaxDelay       string `mapstructure:"max_delay"`       // 等待时间上限
+	PermanentAfter int    `mapstructure:"permanent_after"` // 所有 IP 自上次解锁以来累计失败次数达到后升级为永久锁定，0 不升级
+	Require2FA     bool   `mapstructure:"require_2fa"`     // 还须提供 TOTP 动态码
+	TOTPSecret     string `mapstructure:"totp_secret"`     // base32，为空时读取 CYP_UNLOCK_TOTP_SECRET
+	AllowToken     bool   `mapstructure:"allow_token"`     // 每次锁定时在主机上写入一次性解锁令牌
+	TokenFile      string `mapstructure:"token_file"`      // 为空时为 <meta_path>/unlock_token
+	TokenTTL       string `mapstructure:"token_ttl"`       // 令牌有效期，如 "24h"
+}
+
+// SecurityHeadersConfig represents the security headers of every response.
+// An empty value omits the header.
+type SecurityHeadersConfig struct {
+	ContentSecurityPolicy string     `mapstructure:"content_security_policy"`
+	FrameOptions          string     `mapstructure:"frame_options"`   // DENY 或 SAMEORIGIN
+	ReferrerPolicy        string     `mapstructure:"referrer_policy"` // 如 strict-origin-when-cross-origin
+	HSTS                  HSTSConfig `mapstructure:"hsts"`
+}
+
+// HSTSConfig represents the Strict-Transport-Security header, sent only on
+// requests received over TLS, directly or through a proxy setting
+// X-Forwarded-Proto.
+type HSTSConfig struct {
+	Enabled           bool `mapstructure:"enabled"`
+	MaxAge            int  `mapstructure:"max_age"` // 秒
+	IncludeSubdomains bool `mapstructure:"include_subdomains"`
+	Preload           bool `mapstructure:"preload"`
+}
+
+// HTTPSRedirectConfig represents the redirect of plain HTTP requests to
+// HTTPS. Paths in exclude, and paths below them, are served over HTTP.
+type HTTPSRedirectConfig struct {
+	Enabled bool     `mapstructure:"enabled"`
+	Port    int      `mapstructure:"port"` // HTTPS 端口，0 或 443 时省略
+	Exclude []string `mapstructure:"exclude"`
+}
+
+// DefaultContentSecurityPolicy is the policy of the web console: scripts
+// and styles from the server itself, images also from chart icon URLs,
+// and WebSocket connections for live events.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'; " +
+	"img-src 'self' data: https:; font-src 'self' data:; connect-src 'self' ws: wss:; frame-ancestors 'none'"
+
+// JWTConfig represents the secrets that sign session tokens. Without a
+// secret here or in CYP_JWT_SECRET, one is generated into secret_file on
+// first boot, except in production mode.
+type JWTConfig struct {
+	Secret     string            `mapstructure:"secret"`
+	KeyID      string            `mapstructure:"key_id"`      // 写入 JWT 头的 kid，为空时由密钥生成
+	SecretFile string            `mapstructure:"secret_file"` // 为空时为 <meta_path>/jwt_secret.key
+	Previous   []JWTSecretConfig `mapstructure:"previous"`    // 轮换前的密钥，签发的令牌在过期前仍然有效
+}
+
+// JWTSecretConfig represents a secret kept for verification after a
+// rotation.
+type JWTSecretConfig struct {
+	KeyID  string `mapstructure:"key_id"`
+	Secret string `mapstructure:"secret"`
+}
+
+// IntrusionDetectionConfig represents the intrusion detection rules. Without
+// rules the built-in defaults are used.
+type IntrusionDetectionConfig struct {
+	Enabled            bool                  `mapstructure:"enabled"`
+	RealTimeMonitoring bool                  `mapstructure:"real_time_monitoring"`
+	NotifyOnLock       bool                  `mapstructure:"notify_on_lock"`
+	NotifyChannels     []string              `mapstructure:"notify_channels"` // webhook, email
+	GeoIPFile          string                `mapstructure:"geoip_file"`      // "CIDR,国家代码" 每行一条，geo_anomaly 规则使用
+	Rules              []IntrusionRuleConfig `mapstructure:"rules"`
+}
+
+// IntrusionRuleConfig represents an intrusion detection rule.
+type IntrusionRuleConfig struct {
+	Name             string   `mapstructure:"name"`
+	Pattern          string   `mapstructure:"pattern"` // 为空时与 name 相同
+	Description      string   `mapstructure:"description"`
+	Action           string   `mapstructure:"action"`  // lock, block_ip, notify
+	Actions          []string `mapstructure:"actions"` // 多个动作，优先于 action
+	Threshold        int      `mapstructure:"threshold"`
+	Window           string   `mapstructure:"window"`         // 统计窗口，如 10m
+	BlockDuration    string   `mapstructure:"block_duration"` // block_ip 的封禁时长
+	AllowedHours     string   `mapstructure:"allowed_hours"`  // 如 08-20
+	AllowedCountries []string `mapstructure:"allowed_countries"`
+}
+
+// RateLimitConfig represents per endpoint class rate limits. Requests with
+// credentials are counted per token, anonymous requests per client IP.
+type RateLimitConfig struct {
+	Enabled bool          `mapstructure:"enabled"`
+	Auth    RateLimitRule `mapstructure:"auth"` // 登录、注册、解锁、分享密码校验，始终按 IP 计数
+	Pull    RateLimitRule `mapstructure:"pull"` // /v2 读请求
+	Push    RateLimitRule `mapstructure:"push"` // /v2 写请求
+	API     RateLimitRule `mapstructure:"api"`  // 其余 /api 请求
+}
+
+// RateLimitRule is a token bucket: qps tokens are added per second up to
+// burst. A qps of 0 disables the limit.
+type RateLimitRule struct {
+	QPS   float64 `mapstructure:"qps"`
+	Burst int     `mapstructure:"burst"`
+}
+
+// IPRuleConfig represents a CIDR allow or deny rule. Rules from the
+// configuration file are read-only at runtime.
+type IPRuleConfig struct {
+	Action      string `mapstructure:"action"` // allow, deny
+	CIDR        string `mapstructure:"cidr"`   // 网段或单个 IP
+	Scope       string `mapstructure:"scope"`  // global, api, registry, admin 或以 / 开头的路径前缀
+	Description string `mapstructure:"description"`
+}
+
+// BackupTargetConfig represents a remote backup destination.
+type BackupTargetConfig struct {
+	Name      string `mapstructure:"name"`
+	Type      string `mapstructure:"type"` // local, s3, sftp
+	Retention int    `mapstructure:"retention"`
+	Path      string `mapstructure:"path"`
+
+	// S3
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	Prefix    string `mapstructure:"prefix"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+	PathStyle bool   `mapstructure:"path_style"`
+
+	// SFTP
+	Host           string `mapstructure:"host"`
+	Port           int    `mapstructure:"port"`
+	Username       string `mapstructure:"username"`
+	Password       string `mapstructure:"password"`
+	PrivateKeyPath string `mapstructure:"private_key_path"`
+	HostKey        string `mapstructure:"host_key"`
 }
 
 // LoadConfig loads configuration from file and environment.
@@ -87,6 +799,8 @@ func LoadConfig(configPath string) (*Config, error) {
 	if err := v.Unmarshal(&config); err != nil {
 		return nil, err
 	}
+	config.file = v.ConfigFileUsed()
+	config.inFile = v.InConfig
 
 	return &config, nil
 }
@@ -96,15 +810,28 @@ func setDefaults(v *viper.Viper) {
 	// Server defaults
 	v.SetDefault("server.port", 8080)
 	v.SetDefault("server.host", "0.0.0.0")
+	v.SetDefault("server.mode", "development")
+	v.SetDefault("server.cors.api.max_age", 600)
+	v.SetDefault("server.cors.registry.max_age", 600)
 
 	// Storage defaults
 	v.SetDefault("storage.blob_path", "./data/blobs")
 	v.SetDefault("storage.meta_path", "./data/meta")
 	v.SetDefault("storage.cache_path", "./data/cache")
 	v.SetDefault("storage.max_cache_size", "10GB")
+	v.SetDefault("storage.compression", "zstd")
+	v.SetDefault("storage.compression_min_size", "1KB")
+	v.SetDefault("storage.usage_refresh_interval", "5m")
+	v.SetDefault("storage.trash_retention", "168h")
+	v.SetDefault("storage.disk_watch.interval", "30s")
+	v.SetDefault("storage.disk_watch.warn_free", []string{"15%", "10%"})
+	v.SetDefault("storage.disk_watch.readonly_free", "5%")
+	v.SetDefault("storage.disk_watch.resume_free", "8%")
 
 	// Accelerator defaults
 	v.SetDefault("accelerator.enabled", true)
+	v.SetDefault("accelerator.cache_policy", "lru")
+	v.SetDefault("accelerator.pin_refresh_interval", "6h")
 	v.SetDefault("accelerator.upstreams", []map[string]interface{}{
 		{"name": "Docker Hub", "url": "https://registry-1.docker.io", "priority": 1},
 		{"name": "阿里云", "url": "https://registry.cn-hangzhou.aliyuncs.com", "priority": 2},
@@ -114,14 +841,488 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("update.check_interval", "24h")
 	v.SetDefault("update.auto_update", false)
 	v.SetDefault("update.update_url", "https://api.github.com/repos/CYP/cyp-docker-registry/releases/latest")
+	v.SetDefault("update.require_signature", true)
+	v.SetDefault("update.channel", "stable")
+	v.SetDefault("update.delta", true)
+	v.SetDefault("update.auto_restart", true)
+	v.SetDefault("update.health_window", "2m")
 
 	// Auth defaults
 	v.SetDefault("auth.enabled", false)
 	v.SetDefault("auth.username", "")
 	v.SetDefault("auth.password", "")
+	v.SetDefault("auth.default_visibility", "internal")
+	v.SetDefault("auth.org_member_permission", "write")
+	v.SetDefault("auth.mtls.user_field", "cn")
 
 	// P2P defaults
 	v.SetDefault("p2p.enabled", false)
 	v.SetDefault("p2p.listen_port", 4001)
 	v.SetDefault("p2p.share_mode", "selective")
+	v.SetDefault("p2p.data_dir", "./data/p2p")
+
+	// Backup defaults
+	v.SetDefault("backup.enabled", true)
+	v.SetDefault("backup.path", "./data/backups")
+	v.SetDefault("backup.include_blobs", false)
+	v.SetDefault("backup.key_paths", []string{"./data/tuf", "./data/signatures"})
+	v.SetDefault("backup.config_paths", []string{"./configs"})
+	v.SetDefault("backup.retention", 7)
+
+	// Sync defaults
+	v.SetDefault("sync.parallel", 3)
+	v.SetDefault("sync.max_retries", 3)
+	v.SetDefault("sync.retry_backoff", "1s")
+
+	// Notify defaults
+	v.SetDefault("notify.channels.email.enabled", false)
+	v.SetDefault("notify.channels.email.smtp_port", 587)
+	v.SetDefault("notify.access_digest.enabled", true)
+	v.SetDefault("notify.access_digest.hour", 8)
+	v.SetDefault("notify.access_digest.spike_threshold", 20)
+	v.SetDefault("notify.access_digest.spike_factor", 3.0)
+
+	// Rate limit defaults
+	v.SetDefault("security.rate_limit.enabled", true)
+	v.SetDefault("security.rate_limit.auth.qps", 1)
+	v.SetDefault("security.rate_limit.auth.burst", 10)
+	v.SetDefault("security.rate_limit.pull.qps", 100)
+	v.SetDefault("security.rate_limit.pull.burst", 300)
+	v.SetDefault("security.rate_limit.push.qps", 50)
+	v.SetDefault("security.rate_limit.push.burst", 200)
+	v.SetDefault("security.rate_limit.api.qps", 20)
+	v.SetDefault("security.rate_limit.api.burst", 60)
+
+	// Intrusion detection defaults
+	v.SetDefault("security.headers.content_security_policy", DefaultContentSecurityPolicy)
+	v.SetDefault("security.headers.frame_options", "DENY")
+	v.SetDefault("security.headers.referrer_policy", "strict-origin-when-cross-origin")
+	v.SetDefault("security.headers.hsts.enabled", true)
+	v.SetDefault("security.headers.hsts.max_age", 31536000)
+	v.SetDefault("security.headers.hsts.include_subdomains", true)
+	v.SetDefault("security.https_redirect.exclude", []string{"/health", "/healthz", "/readyz", "/metrics"})
+	v.SetDefault("security.auto_lock.unlock.max_attempts", 5)
+	v.SetDefault("security.auto_lock.unlock.attempt_window", "1h")
+	v.SetDefault("security.auto_lock.unlock.base_delay", "2s")
+	v.SetDefault("security.auto_lock.unlock.max_delay", "5m")
+	v.SetDefault("security.auto_lock.unlock.permanent_after", 20)
+	v.SetDefault("security.auto_lock.unlock.token_ttl", "24h")
+	v.SetDefault("security.audit_redaction.after", "720h")
+	v.SetDefault("security.audit_redaction.ip", "truncate")
+	v.SetDefault("security.audit_redaction.username", "hash")
+	v.SetDefault("security.intrusion_detection.enabled", true)
+	v.SetDefault("security.intrusion_detection.real_time_monitoring", true)
+	v.SetDefault("security.intrusion_detection.notify_on_lock", true)
+
+	// Signature and SBOM defaults
+	v.SetDefault("signature.mode", "warn")
+	v.SetDefault("signature.backend", "builtin")
+	v.SetDefault("signature.key_provider", "file")
+	v.SetDefault("signature.key_id", "default")
+	v.SetDefault("signature.keys_dir", "./data/signatures/keys")
+	v.SetDefault("signature.pkcs11.slot", -1)
+	v.SetDefault("sbom.generator", "syft")
+	v.SetDefault("sbom.vuln_db.refresh_interval", "6h")
+
+	// Health probe defaults
+	v.SetDefault("health.timeout", "2s")
+	v.SetDefault("health.check_upstreams", false)
+
+	// Cluster defaults
+	v.SetDefault("cluster.enabled", false)
+	v.SetDefault("cluster.lease_ttl", "30s")
+
+	// Redis defaults
+	v.SetDefault("redis.enabled", false)
+	v.SetDefault("redis.addr", "127.0.0.1:6379")
+	v.SetDefault("redis.key_prefix", "cyp:")
+	v.SetDefault("redis.cache", true)
+	v.SetDefault("redis.sessions", true)
+	v.SetDefault("redis.rate_limit", true)
+	v.SetDefault("dns.cache_size", 1024)
+
+	// Environment defaults
+	v.SetDefault("environment.auto_configure", true)
+
+	// Logging defaults
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.format", "json")
+	v.SetDefault("logging.output", "stdout")
+	for _, name := range []string{"file", "access", "audit"} {
+		v.SetDefault("logging."+name+".format", "json")
+		v.SetDefault("logging."+name+".max_size", "100MB")
+		v.SetDefault("logging."+name+".max_age", "720h")
+		v.SetDefault("logging."+name+".max_backups", 10)
+	}
+
+	// Workflow defaults
+	v.SetDefault("workflow.job_retention", "720h")
+	v.SetDefault("workflow.max_jobs_per_workflow", 100)
+
+	// Maintenance defaults
+	v.SetDefault("maintenance.sweep_interval", "1h")
+	v.SetDefault("maintenance.expired_retention", "168h")
+	v.SetDefault("maintenance.upload_ttl", "24h")
+	v.SetDefault("maintenance.scrub_interval", "24h")
+	v.SetDefault("maintenance.scrub_batch", "50GB")
+	v.SetDefault("maintenance.event_retention", "2160h")
+}
+
+// Validate checks the values that would otherwise only fail, or be silently
+// replaced by defaults, when a service is started with them.
+func (c *Config) Validate() error {
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		return fmt.Errorf("server.port: 无效的端口 %d", c.Server.Port)
+	}
+	if c.Server.Mode != "development" && c.Server.Mode != "production" {
+		return fmt.Errorf("server.mode: 无效的模式 %q", c.Server.Mode)
+	}
+	if err := c.Server.CORS.API.validate("server.cors.api"); err != nil {
+		return err
+	}
+	if err := c.Server.CORS.Registry.validate("server.cors.registry"); err != nil {
+		return err
+	}
+	if (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		return fmt.Errorf("server.tls: cert_file 和 key_file 须同时配置")
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return fmt.Errorf("server.trusted_proxies: 无效的地址 %q", proxy)
+		}
+	}
+	if err := c.Auth.MTLS.validate(c.Server.TLS); err != nil {
+		return err
+	}
+	if err := c.Logging.validate(); err != nil {
+		return err
+	}
+	if err := c.Security.validateHeaders(); err != nil {
+		return err
+	}
+	for name, mode := range map[string]string{
+		"security.audit_redaction.ip":       c.Security.AuditRedaction.IP,
+		"security.audit_redaction.username": c.Security.AuditRedaction.Username,
+	} {
+		if mode != "truncate" && mode != "hash" {
+			return fmt.Errorf("%s: 无效的脱敏方式 %q", name, mode)
+		}
+	}
+
+	switch c.Signature.Mode {
+	case "enforce", "warn", "disabled":
+	default:
+		return fmt.Errorf("signature.mode: 无效的模式 %q", c.Signature.Mode)
+	}
+	switch c.Signature.Backend {
+	case "", "builtin":
+	case "notation":
+		if c.Signature.Notation.TrustPolicy == "" || c.Signature.Notation.TrustStore == "" {
+			return fmt.Errorf("signature.notation: 使用 notation 时必须配置 trust_policy 和 trust_store")
+		}
+		if _, err := signature.LoadTrustPolicy(c.Signature.Notation.TrustPolicy); err != nil {
+			return fmt.Errorf("signature.notation.trust_policy: %v", err)
+		}
+	default:
+		return fmt.Errorf("signature.backend: 无效的验证后端 %q", c.Signature.Backend)
+	}
+	if err := c.Signature.validateKeyProvider(); err != nil {
+		return err
+	}
+	switch c.Update.Channel {
+	case "stable", "beta", "dev":
+	default:
+		return fmt.Errorf("update.channel: 无效的通道 %q", c.Update.Channel)
+	}
+	switch c.SBOM.Generator {
+	case "syft", "trivy":
+	default:
+		return fmt.Errorf("sbom.generator: 无效的生成器 %q", c.SBOM.Generator)
+	}
+
+	for name, d := range map[string]string{
+		"storage.usage_refresh_interval":   c.Storage.UsageRefreshInterval,
+		"storage.trash_retention":          c.Storage.TrashRetention,
+		"storage.disk_watch.interval":      c.Storage.DiskWatch.Interval,
+		"update.check_interval":            c.Update.CheckInterval,
+		"update.health_window":             c.Update.HealthWindow,
+		"sync.retry_backoff":               c.Sync.RetryBackoff,
+		"workflow.job_retention":           c.Workflow.JobRetention,
+		"maintenance.expired_retention":    c.Maintenance.ExpiredRetention,
+		"maintenance.upload_ttl":           c.Maintenance.UploadTTL,
+		"maintenance.scrub_interval":       c.Maintenance.ScrubInterval,
+		"maintenance.event_retention":      c.Maintenance.EventRetention,
+		"accelerator.pin_refresh_interval": c.Accelerator.PinRefreshInterval,
+		"sbom.vuln_db.refresh_interval":    c.SBOM.VulnDB.RefreshInterval,
+		"security.audit_redaction.after":   c.Security.AuditRedaction.After,
+	} {
+		if d == "" || d == "0" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+
+	if err := c.Storage.DiskWatch.validate(); err != nil {
+		return err
+	}
+	if err := c.DNS.validate(); err != nil {
+		return err
+	}
+	if err := c.Proxy.validate(); err != nil {
+		return err
+	}
+	if err := c.Promotion.validate(); err != nil {
+		return err
+	}
+	if err := c.Provenance.validate(); err != nil {
+		return err
+	}
+
+	if d, err := time.ParseDuration(c.Maintenance.SweepInterval); err != nil || d < time.Minute {
+		return fmt.Errorf("maintenance.sweep_interval: 无效的间隔 %q，至少为 1m", c.Maintenance.SweepInterval)
+	}
+
+	switch c.Accelerator.CachePolicy {
+	case "lru", "lfu", "arc", "gdsf":
+	default:
+		return fmt.Errorf("accelerator.cache_policy: 无效的策略 %q", c.Accelerator.CachePolicy)
+	}
+	for i, u := range c.Accelerator.Upstreams {
+		if u.Name == "" {
+			return fmt.Errorf("accelerator.upstreams[%d]: 名称不能为空", i)
+		}
+		parsed, err := url.Parse(u.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("accelerator.upstreams[%d]: 无效的地址 %q", i, u.URL)
+		}
+		switch u.Type {
+		case "", "generic", "dockerhub", "ghcr", "quay", "harbor":
+		default:
+			return fmt.Errorf("accelerator.upstreams[%d]: 无效的类型 %q", i, u.Type)
+		}
+		if u.PathTemplate != "" {
+			for _, p := range []string{"{name}", "{kind}", "{reference}"} {
+				if !strings.Contains(u.PathTemplate, p) {
+					return fmt.Errorf("accelerator.upstreams[%d]: path_template 必须包含 %s", i, p)
+				}
+			}
+		}
+		for j, rw := range u.Rewrites {
+			if _, err := regexp.Compile(rw.Match); err != nil {
+				return fmt.Errorf("accelerator.upstreams[%d].rewrites[%d]: 无效的正则表达式: %v", i, j, err)
+			}
+		}
+	}
+
+	if c.Redis.Enabled && c.Redis.Addr == "" {
+		return fmt.Errorf("redis.addr: 启用 Redis 时不能为空")
+	}
+
+	rl := c.Security.RateLimit
+	for name, rule := range map[string]RateLimitRule{"auth": rl.Auth, "pull": rl.Pull, "push": rl.Push, "api": rl.API} {
+		if rule.QPS < 0 || rule.Burst < 0 {
+			return fmt.Errorf("security.rate_limit.%s: qps 和 burst 不能为负数", name)
+		}
+	}
+
+	for i, rule := range c.Security.IPRules {
+		if action := strings.ToLower(strings.TrimSpace(rule.Action)); action != "allow" && action != "deny" {
+			return fmt.Errorf("security.ip_rules[%d]: 无效的动作 %q", i, rule.Action)
+		}
+		cidr := strings.TrimSpace(rule.CIDR)
+		if _, _, err := net.ParseCIDR(cidr); err != nil && net.ParseIP(cidr) == nil {
+			return fmt.Errorf("security.ip_rules[%d]: 无效的网段 %q", i, rule.CIDR)
+		}
+	}
+
+	for i, rule := range c.Security.IntrusionDetection.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("security.intrusion_detection.rules[%d]: 名称不能为空", i)
+		}
+		for field, d := range map[string]string{"window": rule.Window, "block_duration": rule.BlockDuration} {
+			if d == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(d); err != nil {
+				return fmt.Errorf("security.intrusion_detection.rules[%d].%s: %w", i, field, err)
+			}
+		}
+	}
+
+	if email := c.Notify.Channels.Email; email.Enabled {
+		if email.SMTPHost == "" {
+			return fmt.Errorf("notify.channels.email.smtp_host: 启用邮件通道时不能为空")
+		}
+		if email.SMTPPort < 1 || email.SMTPPort > 65535 {
+			return fmt.Errorf("notify.channels.email.smtp_port: 无效的端口 %d", email.SMTPPort)
+		}
+		if email.From == "" && email.Username == "" {
+			return fmt.Errorf("notify.channels.email.from: 启用邮件通道时发件人不能为空")
+		}
+	}
+	if digest := c.Notify.AccessDigest; digest.Enabled {
+		if digest.Hour < 0 || digest.Hour > 23 {
+			return fmt.Errorf("notify.access_digest.hour: 必须在 0 到 23 之间")
+		}
+		if digest.SpikeThreshold < 1 {
+			return fmt.Errorf("notify.access_digest.spike_threshold: 必须大于 0")
+		}
+		if digest.SpikeFactor < 1 {
+			return fmt.Errorf("notify.access_digest.spike_factor: 不能小于 1")
+		}
+	}
+	return nil
+}
+
+// validate checks the free space thresholds of the disk watchdog.
+func (d DiskWatchConfig) validate() error {
+	thresholds := map[string]string{
+		"storage.disk_watch.readonly_free": d.ReadOnlyFree,
+		"storage.disk_watch.resume_free":   d.ResumeFree,
+	}
+	for i, t := range d.WarnFree {
+		thresholds[fmt.Sprintf("storage.disk_watch.warn_free[%d]", i)] = t
+	}
+	for name, t := range thresholds {
+		if t == "" {
+			continue
+		}
+		if !validFreeThreshold(t) {
+			return fmt.Errorf("%s: 无效的阈值 %q", name, t)
+		}
+	}
+	return nil
+}
+
+// validateHeaders checks the security headers and the HTTPS redirect.
+func (s SecurityConfig) validateHeaders() error {
+	switch strings.ToUpper(s.Headers.FrameOptions) {
+	case "", "DENY", "SAMEORIGIN":
+	default:
+		return fmt.Errorf("security.headers.frame_options: 无效的值 %q", s.Headers.FrameOptions)
+	}
+	if s.Headers.HSTS.MaxAge < 0 {
+		return fmt.Errorf("security.headers.hsts.max_age: 不能为负数")
+	}
+	unlock := s.AutoLock.Unlock
+	if unlock.MaxAttempts < 1 {
+		return fmt.Errorf("security.auto_lock.unlock.max_attempts: 至少为 1")
+	}
+	if unlock.PermanentAfter < 0 {
+		return fmt.Errorf("security.auto_lock.unlock.permanent_after: 不能为负数")
+	}
+	for name, d := range map[string]string{
+		"attempt_window": unlock.AttemptWindow,
+		"base_delay":     unlock.BaseDelay,
+		"max_delay":      unlock.MaxDelay,
+		"token_ttl":      unlock.TokenTTL,
+	} {
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("security.auto_lock.unlock.%s: %w", name, err)
+		}
+	}
+	if p := s.HTTPSRedirect.Port; p < 0 || p > 65535 {
+		return fmt.Errorf("security.https_redirect.port: 无效的端口 %d", p)
+	}
+	for i, path := range s.HTTPSRedirect.Exclude {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("security.https_redirect.exclude[%d]: 路径须以 / 开头", i)
+		}
+	}
+	return nil
+}
+
+// validate checks the DNS servers and routes.
+func (d DNSConfig) validate() error {
+	for i, s := range d.Servers {
+		if !validDNSServer(s) {
+			return fmt.Errorf("dns.servers[%d]: 无效的 DNS 服务器 %q", i, s)
+		}
+	}
+	for i, r := range d.Routes {
+		if len(r.Domains) == 0 || len(r.Servers) == 0 {
+			return fmt.Errorf("dns.routes[%d]: domains 和 servers 不能为空", i)
+		}
+		for j, s := range r.Servers {
+			if !validDNSServer(s) {
+				return fmt.Errorf("dns.routes[%d].servers[%d]: 无效的 DNS 服务器 %q", i, j, s)
+			}
+		}
+	}
+	if d.CacheSize < 0 {
+		return fmt.Errorf("dns.cache_size: 不能为负数")
+	}
+	return nil
+}
+
+// validDNSServer reports whether s is a plain (host[:port] or udp://),
+// DNS over TLS (tls://) or DNS over HTTPS (https://) server.
+func validDNSServer(s string) bool {
+	if !strings.Contains(s, "://") {
+		return strings.TrimSpace(s) != ""
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "udp" || u.Scheme == "tls" || u.Scheme == "https"
+}
+
+// validFreeThreshold reports whether s is a size or a percentage between 0
+// and 100.
+func validFreeThreshold(s string) bool {
+	s = strings.TrimSpace(s)
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		return err == nil && percent > 0 && percent < 100
+	}
+	return utils.ParseSize(s) > 0
+}
+
+// validate checks the logging section.
+func (l LoggingConfig) validate() error {
+	if _, err := zapcore.ParseLevel(l.Level); err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	if l.Format != "json" && l.Format != "console" {
+		return fmt.Errorf("logging.format: 无效的格式 %q", l.Format)
+	}
+	switch l.Output {
+	case "stdout", "stderr", "none":
+	default:
+		return fmt.Errorf("logging.output: 无效的输出 %q", l.Output)
+	}
+
+	for name, f := range map[string]LogFileConfig{"file": l.File, "access": l.Access, "audit": l.Audit} {
+		if f.Path == "" {
+			continue
+		}
+		if f.Format != "json" && f.Format != "console" {
+			return fmt.Errorf("logging.%s.format: 无效的格式 %q", name, f.Format)
+		}
+		if f.MaxSize != "" && utils.ParseSize(f.MaxSize) <= 0 {
+			return fmt.Errorf("logging.%s.max_size: 无效的大小 %q", name, f.MaxSize)
+		}
+		if f.MaxAge != "" {
+			if _, err := time.ParseDuration(f.MaxAge); err != nil {
+				return fmt.Errorf("logging.%s.max_age: %w", name, err)
+			}
+		}
+		if f.MaxBackups < 0 {
+			return fmt.Errorf("logging.%s.max_backups: 不能为负数", name)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, path := range []string{l.File.Path, l.Access.Path, l.Audit.Path} {
+		if path == "" {
+			continue
+		}
+		if seen[path] {
+			return fmt.Errorf("logging: 应用、访问和审计日志必须使用不同的文件")
+		}
+		seen[path] = true
+	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CORSConfig represents the cross-origin policies of the management API and
+// the registry API (/v2).
+type CORSConfig struct {
+	API      CORSPolicy `mapstructure:"api"`
+	Registry CORSPolicy `mapstructure:"registry"`
+}
+
+// CORSPolicy represents one cross-origin policy. Without allowed_origins no
+// cross-origin request is allowed in production; development mode allows
+// any origin without credentials.
+type CORSPolicy struct {
+	// "*", an origin such as https://console.example.com, or a subdomain
+	// wildcard such as https://*.example.com
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`
+	ExposedHeaders   []string `mapstructure:"exposed_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+	MaxAge           int      `mapstructure:"max_age"` // 预检结果缓存秒数
+}
+
+// Default methods and headers of the CORS policies.
+var (
+	defaultAPICORSMethods      = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultAPICORSHeaders      = []string{"Content-Type", "Authorization", RequestIDHeader}
+	defaultAPICORSExposed      = []string{RequestIDHeader}
+	defaultRegistryCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	defaultRegistryCORSHeaders = []string{"Accept", "Authorization", "Content-Type", "Content-Length", "Content-Range"}
+	defaultRegistryCORSExposed = []string{
+		"Docker-Content-Digest", "Docker-Distribution-Api-Version", "Docker-Upload-UUID",
+		"Location", "Range", "Link", "WWW-Authenticate", RequestIDHeader,
+	}
+)
+
+// APIPolicy returns the policy of the management API with the defaults
+// filled in.
+func (c CORSConfig) APIPolicy(production bool) CORSPolicy {
+	return c.API.withDefaults(production, defaultAPICORSMethods, defaultAPICORSHeaders, defaultAPICORSExposed)
+}
+
+// RegistryPolicy returns the policy of the registry API with the defaults
+// filled in.
+func (c CORSConfig) RegistryPolicy(production bool) CORSPolicy {
+	return c.Registry.withDefaults(production, defaultRegistryCORSMethods, defaultRegistryCORSHeaders, defaultRegistryCORSExposed)
+}
+
+func (p CORSPolicy) withDefaults(production bool, methods, headers, exposed []string) CORSPolicy {
+	if len(p.AllowedOrigins) == 0 && !production {
+		p.AllowedOrigins = []string{"*"}
+		p.AllowCredentials = false
+	}
+	if len(p.AllowedMethods) == 0 {
+		p.AllowedMethods = methods
+	}
+	if len(p.AllowedHeaders) == 0 {
+		p.AllowedHeaders = headers
+	}
+	if len(p.ExposedHeaders) == 0 {
+		p.ExposedHeaders = exposed
+	}
+	return p
+}
+
+// AllowsOrigin reports whether an Origin header value is allowed.
+func (p CORSPolicy) AllowsOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// https://*.example.com 匹配子域名，不匹配 example.com 本身
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			prefix := scheme + "://"
+			if len(origin) > len(prefix) && strings.EqualFold(origin[:len(prefix)], prefix) &&
+				strings.HasSuffix(strings.ToLower(origin), "."+strings.ToLower(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AllowsAnyOrigin reports whether the policy allows every origin.
+func (p CORSPolicy) AllowsAnyOrigin() bool {
+	for _, allowed := range p.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the origins of a policy.
+func (p CORSPolicy) validate(name string) error {
+	for i, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			if p.AllowCredentials {
+				return fmt.Errorf("%s.allowed_origins[%d]: allow_credentials 不能与 \"*\" 同时使用", name, i)
+			}
+			continue
+		}
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.User != nil {
+			return fmt.Errorf("%s.allowed_origins[%d]: 无效的来源 %q", name, i, origin)
+		}
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("%s.max_age: 不能为负数", name)
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 // Package common provides shared utilities for the container registry.
 package common
 
-// ErrorCode represents a standardized error code.
+import "net/http"
+
+// ErrorCode represents a standardized error code. Codes are part of the API
+// contract: clients may switch on them, so existing values must not change.
 type ErrorCode string
 
 // Error codes
@@ -15,46 +18,164 @@ const (
 	ErrInternalError   ErrorCode = "INTERNAL_ERROR"
 	ErrInvalidRequest  ErrorCode = "INVALID_REQUEST"
 	ErrNotFound        ErrorCode = "NOT_FOUND"
+	ErrConflict        ErrorCode = "CONFLICT"
+	ErrForbidden       ErrorCode = "FORBIDDEN"
+	ErrGone            ErrorCode = "GONE"
+	ErrUnprocessable   ErrorCode = "UNPROCESSABLE_ENTITY"
+	ErrUnavailable     ErrorCode = "SERVICE_UNAVAILABLE"
+)
+
+// Security error codes. These predate the upper-case codes and are kept
+// in lower case for compatibility with existing clients.
+const (
+	ErrNoAuthHeader         ErrorCode = "no_auth_header"
+	ErrInvalidJWT           ErrorCode = "invalid_jwt"
+	ErrInvalidToken         ErrorCode = "invalid_token"
+	ErrInvalidFormat        ErrorCode = "invalid_format"
+	ErrInactiveUser         ErrorCode = "inactive_user"
+	ErrIPMismatch           ErrorCode = "ip_mismatch"
+	ErrLoginFailure         ErrorCode = "login_failure"
+	ErrSystemLocked         ErrorCode = "system_locked"
+	ErrCSRFMissing          ErrorCode = "csrf_missing"
+	ErrCSRFInvalid          ErrorCode = "csrf_invalid"
+	ErrRateLimited          ErrorCode = "rate_limit_exceeded"
+	ErrInsufficientScope    ErrorCode = "insufficient_scope"
+	ErrSessionRequired      ErrorCode = "session_required"
+	ErrIPBlocked            ErrorCode = "ip_blocked"
+	ErrManualUnlockDisabled ErrorCode = "manual_unlock_disabled"
+	ErrReadOnly             ErrorCode = "readonly_mode"
+	ErrUnlockFailure        ErrorCode = "unlock_failure"
+	ErrUnlockThrottled      ErrorCode = "unlock_throttled"
+	ErrOTPRequired          ErrorCode = "otp_required"
 )
 
+// errorStatus maps error codes to HTTP status codes.
+var errorStatus = map[ErrorCode]int{
+	ErrImageNotFound:        http.StatusNotFound,
+	ErrBlobNotFound:         http.StatusNotFound,
+	ErrNotFound:             http.StatusNotFound,
+	ErrInvalidManifest:      http.StatusBadRequest,
+	ErrInvalidRequest:       http.StatusBadRequest,
+	ErrConflict:             http.StatusConflict,
+	ErrStorageFull:          http.StatusInsufficientStorage,
+	ErrUpstreamError:        http.StatusBadGateway,
+	ErrAuthFailed:           http.StatusUnauthorized,
+	ErrForbidden:            http.StatusForbidden,
+	ErrGone:                 http.StatusGone,
+	ErrUnprocessable:        http.StatusUnprocessableEntity,
+	ErrUnavailable:          http.StatusServiceUnavailable,
+	ErrNoAuthHeader:         http.StatusUnauthorized,
+	ErrInvalidJWT:           http.StatusUnauthorized,
+	ErrInvalidToken:         http.StatusUnauthorized,
+	ErrInvalidFormat:        http.StatusUnauthorized,
+	ErrInactiveUser:         http.StatusUnauthorized,
+	ErrIPMismatch:           http.StatusUnauthorized,
+	ErrLoginFailure:         http.StatusUnauthorized,
+	ErrSystemLocked:         http.StatusForbidden,
+	ErrCSRFMissing:          http.StatusForbidden,
+	ErrCSRFInvalid:          http.StatusForbidden,
+	ErrRateLimited:          http.StatusTooManyRequests,
+	ErrInsufficientScope:    http.StatusForbidden,
+	ErrSessionRequired:      http.StatusForbidden,
+	ErrIPBlocked:            http.StatusForbidden,
+	ErrManualUnlockDisabled: http.StatusForbidden,
+	ErrReadOnly:             http.StatusForbidden,
+	ErrUnlockFailure:        http.StatusUnauthorized,
+	ErrUnlockThrottled:      http.StatusTooManyRequests,
+	ErrOTPRequired:          http.StatusUnauthorized,
+}
+
+// errorMessages 为错误码的默认提示，按语言区分
+var errorMessages = map[ErrorCode][2]string{
+	ErrImageNotFound:        {"镜像不存在", "Image not found"},
+	ErrBlobNotFound:         {"镜像层不存在", "Blob not found"},
+	ErrInvalidManifest:      {"无效的镜像清单", "Invalid manifest"},
+	ErrStorageFull:          {"存储空间不足", "Insufficient storage"},
+	ErrUpstreamError:        {"上游仓库错误", "Upstream registry error"},
+	ErrAuthFailed:           {"认证失败", "Authentication failed"},
+	ErrInternalError:        {"内部错误", "Internal error"},
+	ErrInvalidRequest:       {"无效的请求", "Invalid request"},
+	ErrNotFound:             {"资源不存在", "Resource not found"},
+	ErrConflict:             {"资源已存在", "Resource conflict"},
+	ErrForbidden:            {"权限不足", "Permission denied"},
+	ErrGone:                 {"资源已失效", "Resource is no longer available"},
+	ErrUnprocessable:        {"请求无法处理", "Request cannot be processed"},
+	ErrUnavailable:          {"服务暂不可用", "Service unavailable"},
+	ErrNoAuthHeader:         {"缺少认证头", "Authorization header is missing"},
+	ErrInvalidJWT:           {"无效的 JWT 令牌", "Invalid JWT"},
+	ErrInvalidToken:         {"无效的访问令牌", "Invalid access token"},
+	ErrInvalidFormat:        {"无效的认证格式", "Invalid authorization format"},
+	ErrInactiveUser:         {"用户已禁用", "User is disabled"},
+	ErrIPMismatch:           {"IP 地址变更", "Client address changed"},
+	ErrLoginFailure:         {"登录失败", "Login failed"},
+	ErrSystemLocked:         {"系统已锁定", "System is locked"},
+	ErrCSRFMissing:          {"缺少 CSRF 令牌", "CSRF token is missing"},
+	ErrCSRFInvalid:          {"无效的 CSRF 令牌", "Invalid CSRF token"},
+	ErrRateLimited:          {"请求过于频繁", "Too many requests"},
+	ErrInsufficientScope:    {"令牌权限不足", "Token scope is insufficient"},
+	ErrSessionRequired:      {"该操作需要登录会话", "A login session is required"},
+	ErrIPBlocked:            {"禁止从该地址访问", "Access from this address is not allowed"},
+	ErrManualUnlockDisabled: {"系统锁定后不允许手动解锁。请联系管理员或重新安装系统。", "Manual unlock is disabled. Contact an administrator or reinstall the system."},
+	ErrReadOnly:             {"系统处于只读模式", "System is in read-only mode"},
+	ErrUnlockFailure:        {"解锁失败", "Unlock failed"},
+	ErrUnlockThrottled:      {"解锁尝试过于频繁", "Too many unlock attempts"},
+	ErrOTPRequired:          {"需要动态验证码", "A one-time code is required"},
+}
+
 // HTTPStatus returns the HTTP status code for the error code.
 func (e ErrorCode) HTTPStatus() int {
-	switch e {
-	case ErrImageNotFound, ErrBlobNotFound, ErrNotFound:
-		return 404
-	case ErrInvalidManifest, ErrInvalidRequest:
-		return 400
-	case ErrStorageFull:
-		return 507
-	case ErrUpstreamError:
-		return 502
-	case ErrAuthFailed:
-		return 401
-	default:
-		return 500
+	if status, ok := errorStatus[e]; ok {
+		return status
 	}
+	return http.StatusInternalServerError
 }
 
 // Message returns the default message for the error code.
 func (e ErrorCode) Message() string {
-	switch e {
-	case ErrImageNotFound:
-		return "镜像不存在"
-	case ErrBlobNotFound:
-		return "镜像层不存在"
-	case ErrInvalidManifest:
-		return "无效的镜像清单"
-	case ErrStorageFull:
-		return "存储空间不足"
-	case ErrUpstreamError:
-		return "上游仓库错误"
-	case ErrAuthFailed:
-		return "认证失败"
-	case ErrInvalidRequest:
-		return "无效的请求"
-	case ErrNotFound:
-		return "资源不存在"
-	default:
-		return "内部错误"
+	return e.LocalizedMessage(LangZH)
+}
+
+// LocalizedMessage returns the default message for the error code in the
+// given language, falling back to Chinese.
+func (e ErrorCode) LocalizedMessage(lang string) string {
+	msgs, ok := errorMessages[e]
+	if !ok {
+		msgs = errorMessages[ErrInternalError]
+	}
+	if lang == LangEN {
+		return msgs[1]
+	}
+	return msgs[0]
+}
+
+// CodeForStatus returns the generic error code of an HTTP status code.
+func CodeForStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrInvalidRequest
+	case http.StatusUnauthorized:
+		return ErrAuthFailed
+	case http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusGone:
+		return ErrGone
+	case http.StatusUnprocessableEntity:
+		return ErrUnprocessable
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return ErrUpstreamError
+	case http.StatusServiceUnavailable:
+		return ErrUnavailable
+	case http.StatusInsufficientStorage:
+		return ErrStorageFull
+	}
+	if status >= 400 && status < 500 {
+		return ErrInvalidRequest
 	}
+	return ErrInternalError
 }
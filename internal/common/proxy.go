@@ -0,0 +1,174 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// Outbound subsystems that can use their own proxy.
+const (
+	ProxyAccelerator = "accelerator" // 加速器拉取上游镜像
+	ProxySync        = "sync"        // 镜像同步与复制
+	ProxyUpdate      = "update"      // 检查和下载更新
+	ProxyScanner     = "scanner"     // 漏洞扫描器下载漏洞库
+)
+
+// ProxySubsystems lists the outbound subsystems.
+var ProxySubsystems = []string{ProxyAccelerator, ProxySync, ProxyUpdate, ProxyScanner}
+
+// ProxyConfig represents the HTTP proxies of outbound requests. A subsystem
+// without its own url uses Default; without any url the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables apply.
+type ProxyConfig struct {
+	Default     ProxySettings `mapstructure:"default"`
+	Accelerator ProxySettings `mapstructure:"accelerator"`
+	Sync        ProxySettings `mapstructure:"sync"`
+	Update      ProxySettings `mapstructure:"update"`
+	Scanner     ProxySettings `mapstructure:"scanner"`
+}
+
+// ProxySettings represents one proxy.
+type ProxySettings struct {
+	URL      string   `mapstructure:"url"`      // http://proxy:3128、https:// 或 socks5://
+	NoProxy  []string `mapstructure:"no_proxy"` // 直连的域名、IP 或网段，如 .corp.example.com、10.0.0.0/8
+	Username string   `mapstructure:"username"` // 代理的 Basic 认证
+	Password string   `mapstructure:"password"`
+	Direct   bool     `mapstructure:"direct"` // 直连，同时忽略环境变量
+}
+
+// For returns the proxy settings of a subsystem.
+func (c ProxyConfig) For(subsystem string) ProxySettings {
+	var p ProxySettings
+	switch subsystem {
+	case ProxyAccelerator:
+		p = c.Accelerator
+	case ProxySync:
+		p = c.Sync
+	case ProxyUpdate:
+		p = c.Update
+	case ProxyScanner:
+		p = c.Scanner
+	}
+	if p.URL == "" && !p.Direct {
+		return c.Default
+	}
+	return p
+}
+
+// proxyURL returns the proxy URL with the configured credentials.
+func (p ProxySettings) proxyURL() (*url.URL, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", p.URL)
+	}
+	if p.Username != "" {
+		u.User = url.UserPassword(p.Username, p.Password)
+	}
+	return u, nil
+}
+
+// ProxyFunc returns the proxy selection for http.Transport.Proxy. Direct
+// settings yield nil, no url the environment variables.
+func (p ProxySettings) ProxyFunc() (func(*http.Request) (*url.URL, error), error) {
+	if p.Direct {
+		return nil, nil
+	}
+	if p.URL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := p.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	selector := (&httpproxy.Config{
+		HTTPProxy:  u.String(),
+		HTTPSProxy: u.String(),
+		NoProxy:    strings.Join(p.NoProxy, ","),
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return selector(req.URL)
+	}, nil
+}
+
+// Describe returns the proxy for display, without the password.
+func (p ProxySettings) Describe() string {
+	switch {
+	case p.Direct:
+		return "direct"
+	case p.URL == "":
+		if env := httpproxy.FromEnvironment(); env.HTTPSProxy != "" || env.HTTPProxy != "" {
+			return "environment"
+		}
+		return "direct"
+	}
+	u, err := p.proxyURL()
+	if err != nil {
+		return p.URL
+	}
+	return u.Redacted()
+}
+
+// validate checks the proxy urls.
+func (c ProxyConfig) validate() error {
+	for name, p := range map[string]ProxySettings{
+		"default":        c.Default,
+		ProxyAccelerator: c.Accelerator,
+		ProxySync:        c.Sync,
+		ProxyUpdate:      c.Update,
+		ProxyScanner:     c.Scanner,
+	} {
+		if p.URL == "" {
+			continue
+		}
+		if _, err := p.proxyURL(); err != nil {
+			return fmt.Errorf("proxy.%s.url: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// CommandEnv returns the environment of a child process, such as the
+// vulnerability scanner, that should use this proxy. nil keeps the
+// inherited environment.
+func (p ProxySettings) CommandEnv() []string {
+	if p.URL == "" && !p.Direct {
+		return nil
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch strings.ToUpper(name) {
+		case "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "ALL_PROXY":
+			continue
+		}
+		env = append(env, kv)
+	}
+	if p.Direct {
+		return env
+	}
+
+	u, err := p.proxyURL()
+	if err != nil {
+		return env
+	}
+	noProxy := strings.Join(p.NoProxy, ",")
+	return append(env,
+		"HTTP_PROXY="+u.String(), "HTTPS_PROXY="+u.String(), "NO_PROXY="+noProxy,
+		"http_proxy="+u.String(), "https_proxy="+u.String(), "no_proxy="+noProxy,
+	)
+}
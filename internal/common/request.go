@@ -0,0 +1,83 @@
+package common
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader is the header carrying the request correlation ID.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the gin context key of the request correlation ID.
+const requestIDKey = "request_id"
+
+// RequestID returns the correlation ID of the request. An ID supplied by the
+// client in X-Request-ID is honored; otherwise one is generated. The ID is
+// echoed in the response header.
+func RequestID(c *gin.Context) string {
+	if id := c.GetString(requestIDKey); id != "" {
+		return id
+	}
+
+	id := c.GetHeader(RequestIDHeader)
+	if !validRequestID(id) {
+		id = NewRequestID()
+	}
+	c.Set(requestIDKey, id)
+	c.Header(RequestIDHeader, id)
+	return id
+}
+
+// requestStartKey is the gin context key of the time the request arrived.
+const requestStartKey = "request_start"
+
+// RequestStart returns the time the request arrived, recorded by the first
+// call, which the request ID middleware makes.
+func RequestStart(c *gin.Context) time.Time {
+	if v, ok := c.Get(requestStartKey); ok {
+		if start, ok := v.(time.Time); ok {
+			return start
+		}
+	}
+	start := time.Now()
+	c.Set(requestStartKey, start)
+	return start
+}
+
+// NewRequestID generates a random request correlation ID.
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID 仅接受长度有限、字符安全的外部请求 ID，避免污染日志
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RequestLogger returns l with the request ID attached, so that every line
+// logged while handling the request can be correlated. It returns nil if l
+// is nil. Goroutines outliving the request must take the logger before they
+// start rather than keep the gin context.
+func RequestLogger(c *gin.Context, l *zap.Logger) *zap.Logger {
+	if l == nil {
+		return nil
+	}
+	return l.With(zap.String("request_id", RequestID(c)))
+}
@@ -1,24 +1,21 @@
 package common
 
 import (
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Supported message languages.
+const (
+	LangZH = "zh"
+	LangEN = "en"
+)
+
 // Response represents a standard API response.
 type Response struct {
 	Success bool        `json:"success"`
 	Data    interface{} `json:"data,omitempty"`
-	Error   *ErrorInfo  `json:"error,omitempty"`
-}
-
-// ErrorInfo represents error details in API response.
-type ErrorInfo struct {
-	Code      ErrorCode              `json:"code"`
-	Message   string                 `json:"message"`
-	Details   map[string]interface{} `json:"details,omitempty"`
-	Timestamp string                 `json:"timestamp"`
 }
 
 // SuccessResponse sends a successful JSON response.
@@ -29,38 +26,75 @@ func SuccessResponse(c *gin.Context, data interface{}) {
 	})
 }
 
-// ErrorResponse sends an error JSON response.
+// ErrorResponse sends an error JSON response. A string "error" entry in
+// details is used as the error text.
 func ErrorResponse(c *gin.Context, code ErrorCode, details map[string]interface{}) {
-	c.JSON(code.HTTPStatus(), Response{
-		Success: false,
-		Error: &ErrorInfo{
-			Code:      code,
-			Message:   code.Message(),
-			Details:   details,
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		},
-	})
+	message := ""
+	if msg, ok := details["error"].(string); ok {
+		message = msg
+		rest := make(map[string]interface{}, len(details))
+		for k, v := range details {
+			if k != "error" {
+				rest[k] = v
+			}
+		}
+		details = rest
+	}
+	ErrorResponseWithMessage(c, code, message, details)
 }
 
 // ErrorResponseWithMessage sends an error JSON response with custom message.
 func ErrorResponseWithMessage(c *gin.Context, code ErrorCode, message string, details map[string]interface{}) {
-	c.JSON(code.HTTPStatus(), Response{
-		Success: false,
-		Error: &ErrorInfo{
-			Code:      code,
-			Message:   message,
-			Details:   details,
-			Timestamp: time.Now().UTC().Format(time.RFC3339),
-		},
-	})
+	var fields gin.H
+	if len(details) > 0 {
+		fields = gin.H{"details": details}
+	}
+	c.JSON(code.HTTPStatus(), errorBody(c, code, message, fields))
+}
+
+// Error sends an error JSON response whose code is derived from the status.
+func Error(c *gin.Context, status int, message string) {
+	c.JSON(status, errorBody(c, CodeForStatus(status), message, nil))
+}
+
+// ErrorWithCode sends an error JSON response with an explicit code. Entries
+// of fields are added to the top level of the body.
+func ErrorWithCode(c *gin.Context, status int, code ErrorCode, message string, fields gin.H) {
+	c.JSON(status, errorBody(c, code, message, fields))
+}
+
+// AbortWithError is ErrorWithCode for middleware: it also stops the chain.
+func AbortWithError(c *gin.Context, status int, code ErrorCode, message string, fields gin.H) {
+	c.AbortWithStatusJSON(status, errorBody(c, code, message, fields))
+}
+
+// errorBody 构造统一的错误响应体：
+// error 为具体的错误描述，code 为稳定的错误码，message 为按
+// Accept-Language 本地化的错误码说明，request_id 用于关联日志
+func errorBody(c *gin.Context, code ErrorCode, message string, fields gin.H) gin.H {
+	localized := code.LocalizedMessage(PreferredLanguage(c))
+	if message == "" {
+		message = code.Message()
+	}
+
+	body := gin.H{}
+	for k, v := range fields {
+		body[k] = v
+	}
+	body["success"] = false
+	body["error"] = message
+	body["code"] = code
+	body["message"] = localized
+	body["request_id"] = RequestID(c)
+	return body
 }
 
-// NewErrorInfo creates a new ErrorInfo instance.
-func NewErrorInfo(code ErrorCode, details map[string]interface{}) *ErrorInfo {
-	return &ErrorInfo{
-		Code:      code,
-		Message:   code.Message(),
-		Details:   details,
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+// PreferredLanguage returns the message language requested by the client
+// through Accept-Language. Chinese is the default.
+func PreferredLanguage(c *gin.Context) string {
+	accept := strings.ToLower(strings.TrimSpace(c.GetHeader("Accept-Language")))
+	if strings.HasPrefix(accept, LangEN) {
+		return LangEN
 	}
+	return LangZH
 }
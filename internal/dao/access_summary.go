@@ -0,0 +1,301 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// AccessTotals counts the access attempts of a period.
+type AccessTotals struct {
+	Attempts         int64
+	Failures         int64
+	FailedAuth       int64 // 登录和令牌认证失败
+	FailedLogins     int64
+	SuccessfulLogins int64
+	NotFound         int64
+	UniqueIPs        int64
+}
+
+// AccessIPStats counts the attempts of one address in a period.
+type AccessIPStats struct {
+	IPAddress string
+	Attempts  int64
+	Failures  int64
+	Users     []string // 成功登录的用户名
+	FirstSeen time.Time
+}
+
+// AuditEventIP counts the audit events of one type from one address.
+type AuditEventIP struct {
+	IPAddress string
+	Count     int64
+}
+
+// DigestSubscription is the access digest preference of an administrator.
+type DigestSubscription struct {
+	UserID        int64
+	Subscribed    bool
+	OnlyAnomalies bool
+	UpdatedAt     time.Time
+}
+
+// DigestRecipient is a subscribed administrator with an email address.
+type DigestRecipient struct {
+	UserID        int64
+	Username      string
+	Email         string
+	OnlyAnomalies bool
+}
+
+// Access summary operations
+//
+// Access attempts are stored with UTC timestamps in the text form of the
+// driver, so the first 13 characters of created_at are the UTC hour.
+
+// GetAccessTotals counts the access attempts between from and to.
+func GetAccessTotals(from, to time.Time) (*AccessTotals, error) {
+	t := &AccessTotals{}
+	var failures, failedAuth, failedLogins, logins, notFound sql.NullInt64
+	err := db.QueryRow(`
+		SELECT COUNT(*),
+			SUM(status = 'failure'),
+			SUM(status = 'failure' AND action IN ('login', 'token')),
+			SUM(status = 'failure' AND action = 'login'),
+			SUM(status = 'success' AND action = 'login'),
+			SUM(action = 'not_found'),
+			COUNT(DISTINCT ip_address)
+		FROM access_attempts WHERE created_at >= ? AND created_at < ?
+	`, from.UTC(), to.UTC()).Scan(&t.Attempts, &failures, &failedAuth, &failedLogins, &logins, &notFound, &t.UniqueIPs)
+	if err != nil {
+		return nil, err
+	}
+	t.Failures, t.FailedAuth, t.FailedLogins = failures.Int64, failedAuth.Int64, failedLogins.Int64
+	t.SuccessfulLogins, t.NotFound = logins.Int64, notFound.Int64
+	return t, nil
+}
+
+// CountAuthFailuresByHour counts the failed logins and token checks
+// between from and to per UTC hour.
+func CountAuthFailuresByHour(from, to time.Time) (map[time.Time]int64, error) {
+	rows, err := db.Query(`
+		SELECT substr(created_at, 1, 13) AS hour, COUNT(*)
+		FROM access_attempts
+		WHERE created_at >= ? AND created_at < ? AND status = 'failure' AND action IN ('login', 'token')
+		GROUP BY hour
+	`, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[time.Time]int64)
+	for rows.Next() {
+		var hour string
+		var count int64
+		if err := rows.Scan(&hour, &count); err != nil {
+			return nil, err
+		}
+		t, err := time.ParseInLocation("2006-01-02 15", hour, time.UTC)
+		if err != nil {
+			continue
+		}
+		counts[t] = count
+	}
+	return counts, rows.Err()
+}
+
+// ListNewAccessIPs lists the addresses whose first attempt falls between
+// from and to, those with successful logins first, then by attempts. It
+// also returns the number of new addresses and of those with logins.
+func ListNewAccessIPs(from, to time.Time, limit int) (stats []*AccessIPStats, total, withLogins int64, err error) {
+	const newIPs = `
+		FROM access_attempts a
+		WHERE a.created_at >= ? AND a.created_at < ? AND a.ip_address != ''
+			AND NOT EXISTS (SELECT 1 FROM access_attempts p WHERE p.ip_address = a.ip_address AND p.created_at < ?)
+		GROUP BY a.ip_address`
+	const logins = `SUM(a.action = 'login' AND a.status = 'success')`
+	args := []interface{}{from.UTC(), to.UTC(), from.UTC()}
+
+	var loginIPs sql.NullInt64
+	err = db.QueryRow(`SELECT COUNT(*), SUM(logins > 0) FROM (SELECT `+logins+` AS logins`+newIPs+`)`, args...).Scan(&total, &loginIPs)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	stats, err = queryAccessIPs(`
+		SELECT a.ip_address, COUNT(*), SUM(a.status = 'failure'),
+			GROUP_CONCAT(DISTINCT CASE WHEN a.action = 'login' AND a.status = 'success' THEN a.resource END),
+			MIN(a.created_at)`+newIPs+`
+		ORDER BY `+logins+` > 0 DESC, COUNT(*) DESC, a.ip_address LIMIT ?
+	`, append(args, limit)...)
+	return stats, total, loginIPs.Int64, err
+}
+
+// ListFailingAccessIPs lists the addresses with the most failed attempts
+// between from and to.
+func ListFailingAccessIPs(from, to time.Time, limit int) ([]*AccessIPStats, error) {
+	return queryAccessIPs(`
+		SELECT ip_address, COUNT(*), SUM(status = 'failure'),
+			GROUP_CONCAT(DISTINCT CASE WHEN action = 'login' AND status = 'success' THEN resource END),
+			MIN(created_at)
+		FROM access_attempts WHERE created_at >= ? AND created_at < ?
+		GROUP BY ip_address HAVING SUM(status = 'failure') > 0
+		ORDER BY SUM(status = 'failure') DESC, ip_address LIMIT ?
+	`, from.UTC(), to.UTC(), limit)
+}
+
+func queryAccessIPs(query string, args ...interface{}) ([]*AccessIPStats, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []*AccessIPStats
+	for rows.Next() {
+		s := &AccessIPStats{}
+		var ip, users, firstSeen sql.NullString
+		var failures sql.NullInt64
+		if err := rows.Scan(&ip, &s.Attempts, &failures, &users, &firstSeen); err != nil {
+			return nil, err
+		}
+		s.IPAddress, s.Failures = ip.String, failures.Int64
+		if users.String != "" {
+			s.Users = strings.Split(users.String, ",")
+		}
+		s.FirstSeen = parseDBTime(firstSeen.String)
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+// parseDBTime parses a timestamp returned as text by an aggregate, which
+// the driver does not convert to time.Time.
+func parseDBTime(s string) time.Time {
+	for _, layout := range []string{"2006-01-02 15:04:05.999999999 -0700 MST", time.RFC3339Nano, "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// ListAuditEventIPs counts the audit events of a type between from and to
+// per address, most events first, with the total number of events.
+func ListAuditEventIPs(event string, from, to time.Time, limit int) ([]*AuditEventIP, int64, error) {
+	var total int64
+	err := db.QueryRow(`SELECT COUNT(*) FROM audit_logs WHERE event = ? AND timestamp >= ? AND timestamp < ?`,
+		event, from, to).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT ip_address, COUNT(*) FROM audit_logs
+		WHERE event = ? AND timestamp >= ? AND timestamp < ?
+		GROUP BY ip_address ORDER BY COUNT(*) DESC, ip_address LIMIT ?
+	`, event, from, to, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var ips []*AuditEventIP
+	for rows.Next() {
+		e := &AuditEventIP{}
+		var ip sql.NullString
+		if err := rows.Scan(&ip, &e.Count); err != nil {
+			return nil, 0, err
+		}
+		e.IPAddress = ip.String
+		ips = append(ips, e)
+	}
+	return ips, total, rows.Err()
+}
+
+// Access digest operations
+
+// GetDigestSubscription returns the digest preference of a user, or nil if
+// the user never set one.
+func GetDigestSubscription(userID int64) (*DigestSubscription, error) {
+	s := &DigestSubscription{UserID: userID}
+	err := db.QueryRow(`
+		SELECT subscribed, only_anomalies, updated_at FROM access_digest_subscriptions WHERE user_id = ?
+	`, userID).Scan(&s.Subscribed, &s.OnlyAnomalies, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// SaveDigestSubscription creates or replaces the digest preference of a
+// user.
+func SaveDigestSubscription(s *DigestSubscription) error {
+	s.UpdatedAt = time.Now().UTC()
+	_, err := db.Exec(`
+		INSERT INTO access_digest_subscriptions (user_id, subscribed, only_anomalies, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET subscribed = excluded.subscribed,
+			only_anomalies = excluded.only_anomalies, updated_at = excluded.updated_at
+	`, s.UserID, s.Subscribed, s.OnlyAnomalies, s.UpdatedAt)
+	return err
+}
+
+// ListDigestRecipients lists the active administrators subscribed to the
+// digest who have an email address.
+func ListDigestRecipients() ([]*DigestRecipient, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.username, u.email, s.only_anomalies
+		FROM access_digest_subscriptions s JOIN users u ON u.id = s.user_id
+		WHERE s.subscribed = 1 AND u.role = 'admin' AND u.is_active = 1 AND u.email IS NOT NULL AND u.email != ''
+		ORDER BY u.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recipients []*DigestRecipient
+	for rows.Next() {
+		r := &DigestRecipient{}
+		if err := rows.Scan(&r.UserID, &r.Username, &r.Email, &r.OnlyAnomalies); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+// ClaimAccessDigest records that the digest of day is being sent. It
+// returns false when it was sent before, by this or another instance.
+func ClaimAccessDigest(day string) (bool, error) {
+	result, err := db.Exec(`INSERT OR IGNORE INTO access_digests (day, sent_at) VALUES (?, ?)`, day, time.Now().UTC())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetAccessDigestRecipients records how many administrators received the
+// digest of day.
+func SetAccessDigestRecipients(day string, recipients int) error {
+	_, err := db.Exec(`UPDATE access_digests SET recipients = ? WHERE day = ?`, recipients, day)
+	return err
+}
+
+// GetAccessDigestSentAt returns when the digest of day was sent, or nil.
+func GetAccessDigestSentAt(day string) (*time.Time, error) {
+	var sentAt time.Time
+	err := db.QueryRow(`SELECT sent_at FROM access_digests WHERE day = ?`, day).Scan(&sentAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sentAt, nil
+}
@@ -0,0 +1,91 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// 哈希链中的记录来源
+const (
+	ChainSourceAuditLog      = "audit_log"
+	ChainSourceAccessAttempt = "access_attempt"
+)
+
+// AuditChainEntry is a hashed audit log or access attempt. The audit
+// service chains the hashes of both tables in the order it writes them.
+type AuditChainEntry struct {
+	Source    string
+	ID        int64
+	Timestamp time.Time
+	IPAddress string
+	Kind      string // 审计日志的 event，访问记录的 action
+	Detail    string // 审计日志的 action，访问记录的 resource
+	Hash      string
+	Redacted  bool // 个人数据已被清除，无法再校验哈希
+}
+
+// Audit chain operations
+
+// ListAuditChain lists the audit logs and the access attempts written
+// between since and until, each in the order they were written, at most
+// limit of each.
+func ListAuditChain(since, until time.Time, limit int) ([]*AuditChainEntry, []*AuditChainEntry, error) {
+	logs, err := queryAuditChain(ChainSourceAuditLog, `SELECT id, timestamp, ip_address, event, action, blockchain_hash, redacted
+		FROM audit_logs WHERE timestamp >= ? AND timestamp <= ? ORDER BY id LIMIT ?`, since, until, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	attempts, err := queryAuditChain(ChainSourceAccessAttempt, `SELECT id, created_at, ip_address, action, resource, blockchain_hash, redacted
+		FROM access_attempts WHERE created_at >= ? AND created_at <= ? ORDER BY id LIMIT ?`, since.UTC(), until.UTC(), limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return logs, attempts, nil
+}
+
+// ListAuditChainBefore lists the last n audit logs and access attempts
+// written before t, oldest first.
+func ListAuditChainBefore(t time.Time, n int) ([]*AuditChainEntry, []*AuditChainEntry, error) {
+	logs, err := queryAuditChain(ChainSourceAuditLog, `SELECT id, timestamp, ip_address, event, action, blockchain_hash, redacted
+		FROM audit_logs WHERE timestamp < ? ORDER BY id DESC LIMIT ?`, t, n)
+	if err != nil {
+		return nil, nil, err
+	}
+	attempts, err := queryAuditChain(ChainSourceAccessAttempt, `SELECT id, created_at, ip_address, action, resource, blockchain_hash, redacted
+		FROM access_attempts WHERE created_at < ? ORDER BY id DESC LIMIT ?`, t.UTC(), n)
+	if err != nil {
+		return nil, nil, err
+	}
+	reverseChain(logs)
+	reverseChain(attempts)
+	return logs, attempts, nil
+}
+
+func queryAuditChain(source, query string, args ...interface{}) ([]*AuditChainEntry, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*AuditChainEntry
+	for rows.Next() {
+		e := &AuditChainEntry{Source: source}
+		var ip, kind, detail, hash sql.NullString
+		var redacted sql.NullBool
+		if err := rows.Scan(&e.ID, &e.Timestamp, &ip, &kind, &detail, &hash, &redacted); err != nil {
+			return nil, err
+		}
+		e.IPAddress, e.Kind, e.Detail, e.Hash = ip.String, kind.String, detail.String, hash.String
+		e.Redacted = redacted.Bool
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func reverseChain(entries []*AuditChainEntry) {
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+}
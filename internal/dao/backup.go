@@ -0,0 +1,89 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteRestorer is the driver connection of modernc.org/sqlite, which can
+// restore a database file into the open database.
+type sqliteRestorer interface {
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// BackupDatabase writes a consistent snapshot of the database to destPath
+// using VACUUM INTO, which is safe to run while the database is in use.
+func BackupDatabase(destPath string) error {
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	// VACUUM INTO 要求目标文件不存在
+	if err := os.Remove(destPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	_, err := db.Exec(`VACUUM INTO ?`, destPath)
+	return err
+}
+
+// RestoreDatabase replaces the contents of the live database with the
+// snapshot at srcPath using the SQLite online backup API. The pool has a
+// single connection, so requests wait while it is held for the restore, and
+// the connection itself is never closed or replaced. The copy runs in one
+// write transaction: if it fails, the database is left as it was.
+func RestoreDatabase(srcPath string) error {
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+
+	// 先校验快照存在且可以正常打开，SQLite 会为不存在的路径创建空库
+	if _, err := os.Stat(srcPath); err != nil {
+		return err
+	}
+	check, err := openDB(srcPath)
+	if err != nil {
+		return err
+	}
+	var result string
+	err = check.QueryRow(`PRAGMA integrity_check`).Scan(&result)
+	check.Close()
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New("database snapshot integrity check failed: " + result)
+	}
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	err = conn.Raw(func(driverConn interface{}) error {
+		restorer, ok := driverConn.(sqliteRestorer)
+		if !ok {
+			return errors.New("database driver does not support restore")
+		}
+		restore, err := restorer.NewRestore(srcPath)
+		if err != nil {
+			return err
+		}
+		// Finish 在未完成时回滚目标数据库的写事务
+		if _, err := restore.Step(-1); err != nil {
+			restore.Finish()
+			return err
+		}
+		return restore.Finish()
+	})
+	conn.Close()
+	if err != nil {
+		return err
+	}
+
+	// 旧版本的快照可能缺少新增的表和列
+	return createTables()
+}
@@ -0,0 +1,123 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"strings"
+)
+
+// Sources of image labels.
+const (
+	LabelSourceAnnotation = "annotation" // manifest or index annotations
+	LabelSourceConfig     = "label"      // config Labels (Dockerfile LABEL)
+)
+
+// ImageLabel is an annotation or config label of a manifest. Labels are
+// indexed by manifest digest, so every tag of the manifest shares them.
+type ImageLabel struct {
+	Digest string
+	Source string
+	Key    string
+	Value  string
+}
+
+// LabelSelector matches images having Key, with Value unless it is empty.
+type LabelSelector struct {
+	Key   string
+	Value string
+}
+
+// Image label operations
+
+// ReplaceImageLabels replaces the labels of a manifest.
+func ReplaceImageLabels(digest string, labels []*ImageLabel) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM image_labels WHERE digest = ?`, digest); err != nil {
+		return err
+	}
+	for _, l := range labels {
+		if _, err := tx.Exec(`
+			INSERT OR REPLACE INTO image_labels (digest, source, key, value) VALUES (?, ?, ?, ?)
+		`, digest, l.Source, l.Key, l.Value); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListImageLabels returns the labels of the given manifests.
+func ListImageLabels(digests []string) ([]*ImageLabel, error) {
+	if len(digests) == 0 {
+		return nil, nil
+	}
+
+	args := make([]interface{}, len(digests))
+	for i, d := range digests {
+		args[i] = d
+	}
+	rows, err := db.Query(`
+		SELECT digest, source, key, value FROM image_labels
+		WHERE digest IN (?`+strings.Repeat(`, ?`, len(digests)-1)+`)
+		ORDER BY digest, source, key
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var labels []*ImageLabel
+	for rows.Next() {
+		l := &ImageLabel{}
+		if err := rows.Scan(&l.Digest, &l.Source, &l.Key, &l.Value); err != nil {
+			return nil, err
+		}
+		labels = append(labels, l)
+	}
+	return labels, rows.Err()
+}
+
+// FindDigestsByLabels returns the manifests matching every selector, from
+// either their annotations or config labels.
+func FindDigestsByLabels(selectors []LabelSelector) ([]string, error) {
+	if len(selectors) == 0 {
+		return nil, nil
+	}
+
+	var queries []string
+	var args []interface{}
+	for _, sel := range selectors {
+		if sel.Value == "" {
+			queries = append(queries, `SELECT digest FROM image_labels WHERE key = ?`)
+			args = append(args, sel.Key)
+		} else {
+			queries = append(queries, `SELECT digest FROM image_labels WHERE key = ? AND value = ?`)
+			args = append(args, sel.Key, sel.Value)
+		}
+	}
+
+	rows, err := db.Query(strings.Join(queries, ` INTERSECT `), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var digests []string
+	for rows.Next() {
+		var digest string
+		if err := rows.Scan(&digest); err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	return digests, rows.Err()
+}
+
+// DeleteImageLabels removes the labels of a manifest.
+func DeleteImageLabels(digest string) error {
+	_, err := db.Exec(`DELETE FROM image_labels WHERE digest = ?`, digest)
+	return err
+}
@@ -0,0 +1,174 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"time"
+)
+
+// ImageStatRecord holds pull/push counters of an image for one day.
+// Day is formatted as 2006-01-02 (UTC). Bytes served by blob downloads are
+// recorded with an empty Tag since blobs are not tied to a tag.
+type ImageStatRecord struct {
+	Day         string
+	Repository  string
+	Tag         string
+	Pulls       int64
+	Pushes      int64
+	BytesServed int64
+}
+
+// ImagePushRecord represents a recorded image push.
+type ImagePushRecord struct {
+	ID         int64
+	Repository string
+	Tag        string
+	Digest     string
+	PushedBy   string
+	CreatedAt  time.Time
+}
+
+// Image statistics operations
+
+// AddImageStats adds counters to their daily buckets in one transaction.
+func AddImageStats(records []*ImageStatRecord, pushes []*ImagePushRecord) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, r := range records {
+		if _, err := tx.Exec(`
+			INSERT INTO image_stats_daily (day, repository, tag, pulls, pushes, bytes_served)
+			VALUES (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(day, repository, tag) DO UPDATE SET
+				pulls = pulls + excluded.pulls, pushes = pushes + excluded.pushes,
+				bytes_served = bytes_served + excluded.bytes_served
+		`, r.Day, r.Repository, r.Tag, r.Pulls, r.Pushes, r.BytesServed); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range pushes {
+		if _, err := tx.Exec(`
+			INSERT INTO image_pushes (repository, tag, digest, pushed_by, created_at)
+			VALUES (?, ?, ?, ?, ?)
+		`, p.Repository, p.Tag, p.Digest, p.PushedBy, p.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// TopPulledImages returns the images with the most pulls since the given day.
+func TopPulledImages(sinceDay string, limit int) ([]*ImageStatRecord, error) {
+	rows, err := db.Query(`
+		SELECT repository, tag, SUM(pulls), SUM(pushes)
+		FROM image_stats_daily
+		WHERE day >= ? AND tag != ''
+		GROUP BY repository, tag
+		HAVING SUM(pulls) > 0
+		ORDER BY SUM(pulls) DESC, repository, tag
+		LIMIT ?
+	`, sinceDay, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*ImageStatRecord
+	for rows.Next() {
+		r := &ImageStatRecord{}
+		if err := rows.Scan(&r.Repository, &r.Tag, &r.Pulls, &r.Pushes); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// TopBandwidthRepositories returns the repositories that served the most
+// bytes since the given day.
+func TopBandwidthRepositories(sinceDay string, limit int) ([]*ImageStatRecord, error) {
+	rows, err := db.Query(`
+		SELECT repository, SUM(bytes_served)
+		FROM image_stats_daily
+		WHERE day >= ?
+		GROUP BY repository
+		HAVING SUM(bytes_served) > 0
+		ORDER BY SUM(bytes_served) DESC, repository
+		LIMIT ?
+	`, sinceDay, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*ImageStatRecord
+	for rows.Next() {
+		r := &ImageStatRecord{}
+		if err := rows.Scan(&r.Repository, &r.BytesServed); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// DailyImageStats returns the totals of each day since the given day.
+func DailyImageStats(sinceDay string) ([]*ImageStatRecord, error) {
+	rows, err := db.Query(`
+		SELECT day, SUM(pulls), SUM(pushes), SUM(bytes_served)
+		FROM image_stats_daily
+		WHERE day >= ?
+		GROUP BY day
+		ORDER BY day
+	`, sinceDay)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*ImageStatRecord
+	for rows.Next() {
+		r := &ImageStatRecord{}
+		if err := rows.Scan(&r.Day, &r.Pulls, &r.Pushes, &r.BytesServed); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+// ListRecentImagePushes returns the most recent pushes.
+func ListRecentImagePushes(limit int) ([]*ImagePushRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, repository, tag, digest, pushed_by, created_at
+		FROM image_pushes ORDER BY created_at DESC, id DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*ImagePushRecord
+	for rows.Next() {
+		p := &ImagePushRecord{}
+		if err := rows.Scan(&p.ID, &p.Repository, &p.Tag, &p.Digest, &p.PushedBy, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, p)
+	}
+	return records, rows.Err()
+}
+
+// PruneImageStats removes daily buckets and push records older than before.
+func PruneImageStats(before time.Time) error {
+	beforeDay := before.UTC().Format("2006-01-02")
+	if _, err := db.Exec(`DELETE FROM image_stats_daily WHERE day < ?`, beforeDay); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM image_pushes WHERE created_at < ?`, before)
+	return err
+}
@@ -0,0 +1,137 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Invitation statuses.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+)
+
+// OrgInvitation is an invitation to join an organization. It targets a
+// registered user (InviteeID) or an email address.
+type OrgInvitation struct {
+	ID          int64
+	OrgID       int64
+	OrgName     string
+	TokenHash   string
+	InviteeID   sql.NullInt64
+	Email       string
+	Role        string
+	InvitedBy   int64
+	InviterName string
+	Status      string
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+	RespondedAt sql.NullTime
+}
+
+const invitationColumns = `
+	i.id, i.org_id, o.name, i.token_hash, i.invitee_id, i.email, i.role,
+	i.invited_by, u.username, i.status, i.expires_at, i.created_at, i.responded_at
+	FROM org_invitations i
+	JOIN organizations o ON i.org_id = o.id
+	JOIN users u ON i.invited_by = u.id`
+
+// Org invitation operations
+
+// CreateOrgInvitation creates a new invitation.
+func CreateOrgInvitation(inv *OrgInvitation) error {
+	if inv.CreatedAt.IsZero() {
+		inv.CreatedAt = time.Now()
+	}
+	if inv.Status == "" {
+		inv.Status = InvitationPending
+	}
+	result, err := db.Exec(`
+		INSERT INTO org_invitations (org_id, token_hash, invitee_id, email, role, invited_by, status, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, inv.OrgID, inv.TokenHash, inv.InviteeID, inv.Email, inv.Role, inv.InvitedBy, inv.Status, inv.ExpiresAt, inv.CreatedAt)
+	if err != nil {
+		return err
+	}
+	inv.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetOrgInvitation retrieves an invitation by ID.
+func GetOrgInvitation(id int64) (*OrgInvitation, error) {
+	return scanInvitation(db.QueryRow(`SELECT `+invitationColumns+` WHERE i.id = ?`, id))
+}
+
+// GetOrgInvitationByToken retrieves an invitation by the hash of its token.
+func GetOrgInvitationByToken(tokenHash string) (*OrgInvitation, error) {
+	return scanInvitation(db.QueryRow(`SELECT `+invitationColumns+` WHERE i.token_hash = ?`, tokenHash))
+}
+
+// ListOrgInvitations lists the pending invitations of an organization.
+func ListOrgInvitations(orgID int64) ([]*OrgInvitation, error) {
+	return queryInvitations(`SELECT `+invitationColumns+`
+		WHERE i.org_id = ? AND i.status = ? ORDER BY i.created_at DESC`,
+		orgID, InvitationPending)
+}
+
+// ListUserInvitations lists the unexpired pending invitations addressed to
+// a user, either directly or through the user's email address.
+func ListUserInvitations(userID int64, email string) ([]*OrgInvitation, error) {
+	return queryInvitations(`SELECT `+invitationColumns+`
+		WHERE i.status = ? AND i.expires_at > ?
+			AND (i.invitee_id = ? OR (? != '' AND lower(i.email) = lower(?)))
+		ORDER BY i.created_at DESC`,
+		InvitationPending, time.Now(), userID, email, email)
+}
+
+// UpdateOrgInvitationStatus sets the status of a pending invitation. It
+// reports whether the invitation was still pending.
+func UpdateOrgInvitationStatus(id int64, status string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE org_invitations SET status = ?, responded_at = ? WHERE id = ? AND status = ?
+	`, status, time.Now(), id, InvitationPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func scanInvitation(row *sql.Row) (*OrgInvitation, error) {
+	inv := &OrgInvitation{}
+	var email sql.NullString
+	err := row.Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.TokenHash, &inv.InviteeID, &email, &inv.Role,
+		&inv.InvitedBy, &inv.InviterName, &inv.Status, &inv.ExpiresAt, &inv.CreatedAt, &inv.RespondedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	inv.Email = email.String
+	return inv, nil
+}
+
+func queryInvitations(query string, args ...interface{}) ([]*OrgInvitation, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []*OrgInvitation
+	for rows.Next() {
+		inv := &OrgInvitation{}
+		var email sql.NullString
+		if err := rows.Scan(&inv.ID, &inv.OrgID, &inv.OrgName, &inv.TokenHash, &inv.InviteeID, &email, &inv.Role,
+			&inv.InvitedBy, &inv.InviterName, &inv.Status, &inv.ExpiresAt, &inv.CreatedAt, &inv.RespondedAt); err != nil {
+			return nil, err
+		}
+		inv.Email = email.String
+		invitations = append(invitations, inv)
+	}
+	return invitations, rows.Err()
+}
@@ -0,0 +1,90 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// IPRule is a CIDR allow or deny rule managed at runtime.
+type IPRule struct {
+	ID          int64
+	Action      string
+	CIDR        string
+	Scope       string
+	Description string
+	CreatedBy   string
+	CreatedAt   time.Time
+}
+
+// IP rule operations
+
+// CreateIPRule creates a new IP rule.
+func CreateIPRule(rule *IPRule) error {
+	if rule.CreatedAt.IsZero() {
+		rule.CreatedAt = time.Now()
+	}
+	result, err := db.Exec(`
+		INSERT INTO ip_rules (action, cidr, scope, description, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)
+	`, rule.Action, rule.CIDR, rule.Scope, rule.Description, rule.CreatedBy, rule.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rule.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetIPRule retrieves an IP rule by ID.
+func GetIPRule(id int64) (*IPRule, error) {
+	rule := &IPRule{}
+	var description, createdBy sql.NullString
+	err := db.QueryRow(`
+		SELECT id, action, cidr, scope, description, created_by, created_at FROM ip_rules WHERE id = ?
+	`, id).Scan(&rule.ID, &rule.Action, &rule.CIDR, &rule.Scope, &description, &createdBy, &rule.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rule.Description = description.String
+	rule.CreatedBy = createdBy.String
+	return rule, nil
+}
+
+// ListIPRules lists all IP rules.
+func ListIPRules() ([]*IPRule, error) {
+	rows, err := db.Query(`
+		SELECT id, action, cidr, scope, description, created_by, created_at FROM ip_rules ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []*IPRule
+	for rows.Next() {
+		rule := &IPRule{}
+		var description, createdBy sql.NullString
+		if err := rows.Scan(&rule.ID, &rule.Action, &rule.CIDR, &rule.Scope, &description, &createdBy, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rule.Description = description.String
+		rule.CreatedBy = createdBy.String
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// UpdateIPRule updates an IP rule.
+func UpdateIPRule(rule *IPRule) error {
+	_, err := db.Exec(`UPDATE ip_rules SET action = ?, cidr = ?, scope = ?, description = ? WHERE id = ?`,
+		rule.Action, rule.CIDR, rule.Scope, rule.Description, rule.ID)
+	return err
+}
+
+// DeleteIPRule deletes an IP rule.
+func DeleteIPRule(id int64) error {
+	_, err := db.Exec(`DELETE FROM ip_rules WHERE id = ?`, id)
+	return err
+}
@@ -0,0 +1,88 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// LicensePolicy lists the licenses allowed and forbidden in the images of
+// an organization.
+type LicensePolicy struct {
+	OrgID       int64
+	Mode        string
+	Allowed     []string
+	Forbidden   []string
+	DenyUnknown bool
+	UpdatedBy   string
+	UpdatedAt   time.Time
+}
+
+// License policy operations
+
+const licensePolicyColumns = `org_id, mode, allowed, forbidden, deny_unknown, updated_by, updated_at`
+
+func scanLicensePolicy(row interface{ Scan(...interface{}) error }) (*LicensePolicy, error) {
+	p := &LicensePolicy{}
+	var allowed, forbidden, updatedBy sql.NullString
+	if err := row.Scan(&p.OrgID, &p.Mode, &allowed, &forbidden, &p.DenyUnknown, &updatedBy, &p.UpdatedAt); err != nil {
+		return nil, err
+	}
+	json.Unmarshal([]byte(allowed.String), &p.Allowed)
+	json.Unmarshal([]byte(forbidden.String), &p.Forbidden)
+	p.UpdatedBy = updatedBy.String
+	return p, nil
+}
+
+// GetLicensePolicy returns the license policy of an organization, or nil if
+// it has none.
+func GetLicensePolicy(orgID int64) (*LicensePolicy, error) {
+	p, err := scanLicensePolicy(db.QueryRow(`SELECT `+licensePolicyColumns+` FROM license_policies WHERE org_id = ?`, orgID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ListLicensePolicies lists the license policies of all organizations.
+func ListLicensePolicies() ([]*LicensePolicy, error) {
+	rows, err := db.Query(`SELECT ` + licensePolicyColumns + ` FROM license_policies ORDER BY org_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []*LicensePolicy
+	for rows.Next() {
+		p, err := scanLicensePolicy(rows)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, rows.Err()
+}
+
+// UpsertLicensePolicy creates or replaces the license policy of an
+// organization.
+func UpsertLicensePolicy(p *LicensePolicy) error {
+	if p.UpdatedAt.IsZero() {
+		p.UpdatedAt = time.Now()
+	}
+	allowed, _ := json.Marshal(p.Allowed)
+	forbidden, _ := json.Marshal(p.Forbidden)
+	_, err := db.Exec(`
+		INSERT INTO license_policies (org_id, mode, allowed, forbidden, deny_unknown, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(org_id) DO UPDATE SET mode = excluded.mode, allowed = excluded.allowed, forbidden = excluded.forbidden,
+			deny_unknown = excluded.deny_unknown, updated_by = excluded.updated_by, updated_at = excluded.updated_at
+	`, p.OrgID, p.Mode, string(allowed), string(forbidden), p.DenyUnknown, p.UpdatedBy, p.UpdatedAt)
+	return err
+}
+
+// DeleteLicensePolicy deletes the license policy of an organization.
+func DeleteLicensePolicy(orgID int64) error {
+	_, err := db.Exec(`DELETE FROM license_policies WHERE org_id = ?`, orgID)
+	return err
+}
@@ -0,0 +1,62 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Lease is the current holder of an advisory lock.
+type Lease struct {
+	Name      string
+	Holder    string
+	ExpiresAt time.Time
+}
+
+// Advisory lock operations
+//
+// Instances sharing the database coordinate through leases: one row per lock
+// name with its holder and expiry. A lease that is not renewed in time may
+// be taken over, so a crashed instance never keeps a lock. Expiries are
+// stored as Unix milliseconds so instances compare them numerically.
+
+// AcquireLock takes the lock name for holder, or renews it when holder
+// already owns it, until ttl from now. It returns false while another
+// holder owns an unexpired lease.
+func AcquireLock(name, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	result, err := db.Exec(`
+		INSERT INTO advisory_locks (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE advisory_locks.holder = excluded.holder OR advisory_locks.expires_at < ?
+	`, name, holder, now.Add(ttl).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ReleaseLock releases the lock name if holder owns it.
+func ReleaseLock(name, holder string) error {
+	_, err := db.Exec(`DELETE FROM advisory_locks WHERE name = ? AND holder = ?`, name, holder)
+	return err
+}
+
+// GetLease returns the unexpired lease of the lock name, or nil when the
+// lock is free.
+func GetLease(name string) (*Lease, error) {
+	lease := &Lease{Name: name}
+	var expiresAt int64
+	err := db.QueryRow(`
+		SELECT holder, expires_at FROM advisory_locks WHERE name = ? AND expires_at >= ?
+	`, name, time.Now().UnixMilli()).Scan(&lease.Holder, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lease.ExpiresAt = time.UnixMilli(expiresAt)
+	return lease, nil
+}
@@ -0,0 +1,77 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Namespace kinds. Users and organizations share one namespace, the first
+// segment of repository names.
+const (
+	NamespaceUser = "user"
+	NamespaceOrg  = "org"
+)
+
+// ErrNamespaceTaken is returned when a user or organization name is already
+// claimed, also by one that has been deleted.
+var ErrNamespaceTaken = errors.New("namespace already taken")
+
+// Namespace is a repository namespace claimed by a user or organization.
+// OwnerID is the user ID or organization ID, depending on Kind.
+type Namespace struct {
+	Name      string
+	Kind      string
+	OwnerID   int64
+	CreatedAt time.Time
+}
+
+// Namespace operations
+
+// GetNamespace returns the claim on a namespace, or nil if it is free.
+func GetNamespace(name string) (*Namespace, error) {
+	ns := &Namespace{}
+	err := db.QueryRow(`SELECT name, kind, owner_id, created_at FROM namespaces WHERE name = ?`, name).
+		Scan(&ns.Name, &ns.Kind, &ns.OwnerID, &ns.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ns, nil
+}
+
+// claimNamespace claims a namespace within tx. Claims are kept when the user
+// or organization is deleted, so a new account with the same name does not
+// inherit its repositories.
+func claimNamespace(tx *sql.Tx, name, kind string, ownerID int64) error {
+	result, err := tx.Exec(`
+		INSERT OR IGNORE INTO namespaces (name, kind, owner_id, created_at) VALUES (?, ?, ?, ?)
+	`, name, kind, ownerID, time.Now())
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return ErrNamespaceTaken
+	}
+	return nil
+}
+
+// backfillNamespaces claims the namespaces of users and organizations that
+// existed before namespaces were tracked. Where a user and an organization
+// already share a name, the user keeps it.
+func backfillNamespaces() error {
+	for _, query := range []string{
+		`INSERT OR IGNORE INTO namespaces (name, kind, owner_id, created_at)
+			SELECT username, 'user', id, created_at FROM users`,
+		`INSERT OR IGNORE INTO namespaces (name, kind, owner_id, created_at)
+			SELECT name, 'org', id, created_at FROM organizations`,
+	} {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PromotionRecord is a request to retag the digest of one channel of a
+// repository as the next channel.
+type PromotionRecord struct {
+	ID            int64
+	Repository    string
+	Digest        string
+	FromChannel   string
+	ToChannel     string
+	RequestedBy   string
+	RequestedByID int64
+	Status        string
+	Reason        string
+	ResolvedBy    string
+	CreatedAt     time.Time
+	ResolvedAt    *time.Time
+}
+
+// PromotionApprovalRecord is the sign-off of a user on a promotion.
+type PromotionApprovalRecord struct {
+	PromotionID int64
+	UserID      int64
+	Username    string
+	Comment     string
+	CreatedAt   time.Time
+}
+
+// Promotion operations
+
+// CreatePromotion inserts a promotion request.
+func CreatePromotion(p *PromotionRecord) error {
+	result, err := db.Exec(`
+		INSERT INTO promotions (repository, digest, from_channel, to_channel, requested_by, requested_by_id, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.Repository, p.Digest, p.FromChannel, p.ToChannel, p.RequestedBy, p.RequestedByID, p.Status, p.CreatedAt)
+	if err != nil {
+		return err
+	}
+	p.ID, err = result.LastInsertId()
+	return err
+}
+
+const promotionColumns = `id, repository, digest, from_channel, to_channel, requested_by, requested_by_id, status, reason, resolved_by, created_at, resolved_at`
+
+func scanPromotion(row interface{ Scan(...interface{}) error }) (*PromotionRecord, error) {
+	p := &PromotionRecord{}
+	var reason, resolvedBy sql.NullString
+	var resolvedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.Repository, &p.Digest, &p.FromChannel, &p.ToChannel, &p.RequestedBy, &p.RequestedByID,
+		&p.Status, &reason, &resolvedBy, &p.CreatedAt, &resolvedAt); err != nil {
+		return nil, err
+	}
+	p.Reason = reason.String
+	p.ResolvedBy = resolvedBy.String
+	if resolvedAt.Valid {
+		p.ResolvedAt = &resolvedAt.Time
+	}
+	return p, nil
+}
+
+// GetPromotion returns a promotion request, or nil if not found.
+func GetPromotion(id int64) (*PromotionRecord, error) {
+	p, err := scanPromotion(db.QueryRow(`
+		SELECT `+promotionColumns+` FROM promotions WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// GetPendingPromotion returns the pending promotion of a repository into a
+// channel, or nil if there is none.
+func GetPendingPromotion(repository, toChannel string) (*PromotionRecord, error) {
+	p, err := scanPromotion(db.QueryRow(`
+		SELECT `+promotionColumns+` FROM promotions
+		WHERE repository = ? AND to_channel = ? AND status = 'pending'
+	`, repository, toChannel))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return p, err
+}
+
+// ListPromotions returns promotion requests newest first, filtered by
+// status and repository when they are not empty.
+func ListPromotions(status, repository string, limit int) ([]*PromotionRecord, error) {
+	query := `SELECT ` + promotionColumns + ` FROM promotions WHERE 1 = 1`
+	var args []interface{}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if repository != "" {
+		query += ` AND repository = ?`
+		args = append(args, repository)
+	}
+	query += ` ORDER BY created_at DESC, id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var promotions []*PromotionRecord
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		promotions = append(promotions, p)
+	}
+	return promotions, rows.Err()
+}
+
+// ResolvePromotion sets the final status of a pending promotion. It reports
+// false if the promotion was no longer pending.
+func ResolvePromotion(id int64, status, resolvedBy, reason string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE promotions SET status = ?, resolved_by = ?, reason = ?, resolved_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, status, resolvedBy, reason, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// AddPromotionApproval records the approval of a user. It reports false if
+// the user had already approved.
+func AddPromotionApproval(a *PromotionApprovalRecord) (bool, error) {
+	result, err := db.Exec(`
+		INSERT OR IGNORE INTO promotion_approvals (promotion_id, user_id, username, comment, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, a.PromotionID, a.UserID, a.Username, a.Comment, a.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// ListPromotionApprovals returns the approvals of a promotion, oldest first.
+func ListPromotionApprovals(promotionID int64) ([]*PromotionApprovalRecord, error) {
+	rows, err := db.Query(`
+		SELECT promotion_id, user_id, username, comment, created_at FROM promotion_approvals
+		WHERE promotion_id = ? ORDER BY created_at, user_id
+	`, promotionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var approvals []*PromotionApprovalRecord
+	for rows.Next() {
+		a := &PromotionApprovalRecord{}
+		var comment sql.NullString
+		if err := rows.Scan(&a.PromotionID, &a.UserID, &a.Username, &comment, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Comment = comment.String
+		approvals = append(approvals, a)
+	}
+	return approvals, rows.Err()
+}
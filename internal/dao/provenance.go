@@ -0,0 +1,71 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ProvenanceRecord is the verification result of a provenance attestation
+// pushed as a referrer of an image.
+type ProvenanceRecord struct {
+	Repository        string
+	AttestationDigest string
+	SubjectDigest     string
+	PredicateType     string
+	BuilderID         string
+	TrustedBuilder    string // name of the trusted builder whose key verified it
+	Status            string
+	Reason            string
+	VerifiedAt        time.Time
+}
+
+// Provenance operations
+
+// SaveProvenance inserts or replaces the verification result of an
+// attestation.
+func SaveProvenance(p *ProvenanceRecord) error {
+	_, err := db.Exec(`
+		INSERT INTO provenance_attestations (repository, attestation_digest, subject_digest, predicate_type, builder_id, trusted_builder, status, reason, verified_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(repository, attestation_digest) DO UPDATE SET
+			subject_digest = excluded.subject_digest,
+			predicate_type = excluded.predicate_type,
+			builder_id = excluded.builder_id,
+			trusted_builder = excluded.trusted_builder,
+			status = excluded.status,
+			reason = excluded.reason,
+			verified_at = excluded.verified_at
+	`, p.Repository, p.AttestationDigest, p.SubjectDigest, p.PredicateType, p.BuilderID, p.TrustedBuilder, p.Status, p.Reason, p.VerifiedAt)
+	return err
+}
+
+// ListProvenance returns the verification results of the attestations of
+// a manifest, newest first.
+func ListProvenance(repository, subjectDigest string) ([]*ProvenanceRecord, error) {
+	rows, err := db.Query(`
+		SELECT repository, attestation_digest, subject_digest, predicate_type, builder_id, trusted_builder, status, reason, verified_at
+		FROM provenance_attestations WHERE repository = ? AND subject_digest = ?
+		ORDER BY verified_at DESC, attestation_digest
+	`, repository, subjectDigest)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*ProvenanceRecord
+	for rows.Next() {
+		p := &ProvenanceRecord{}
+		var predicateType, builderID, trustedBuilder, reason sql.NullString
+		if err := rows.Scan(&p.Repository, &p.AttestationDigest, &p.SubjectDigest, &predicateType, &builderID,
+			&trustedBuilder, &p.Status, &reason, &p.VerifiedAt); err != nil {
+			return nil, err
+		}
+		p.PredicateType = predicateType.String
+		p.BuilderID = builderID.String
+		p.TrustedBuilder = trustedBuilder.String
+		p.Reason = reason.String
+		records = append(records, p)
+	}
+	return records, rows.Err()
+}
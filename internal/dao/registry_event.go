@@ -0,0 +1,215 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"time"
+)
+
+// RegistryEventRecord represents a push, pull or delete of an image. It is
+// kept apart from the audit log, which records security events.
+type RegistryEventRecord struct {
+	ID         int64
+	Type       string
+	Repository string
+	Tag        string
+	Digest     string
+	Size       int64
+	ClientIP   string
+	Username   string
+	DurationMs int64
+	CreatedAt  time.Time
+}
+
+// RegistryEventFilter restricts the events listed. Empty fields match all.
+type RegistryEventFilter struct {
+	Type       string
+	Repository string
+	Tag        string
+	Username   string
+	ClientIP   string
+	Since      time.Time
+	Until      time.Time
+}
+
+// RegistryUserActivity holds the event counts of a user.
+type RegistryUserActivity struct {
+	Username string
+	Pulls    int64
+	Pushes   int64
+	Deletes  int64
+}
+
+// PopularImageRecord holds the pulls of an image within a time window.
+type PopularImageRecord struct {
+	Repository string
+	Tag        string
+	Pulls      int64
+	Users      int64 // distinct authenticated users
+}
+
+// Registry event operations
+
+// AddRegistryEvents inserts events in one transaction.
+func AddRegistryEvents(events []*RegistryEventRecord) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, e := range events {
+		if _, err := tx.Exec(`
+			INSERT INTO registry_events (type, repository, tag, digest, size, client_ip, username, duration_ms, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, e.Type, e.Repository, e.Tag, e.Digest, e.Size, e.ClientIP, e.Username, e.DurationMs, e.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// where returns the WHERE clause and arguments of the filter.
+func (f RegistryEventFilter) where() (string, []interface{}) {
+	query := ` WHERE 1=1`
+	var args []interface{}
+	for _, cond := range []struct {
+		column, value string
+	}{
+		{"type", f.Type},
+		{"repository", f.Repository},
+		{"tag", f.Tag},
+		{"username", f.Username},
+		{"client_ip", f.ClientIP},
+	} {
+		if cond.value != "" {
+			query += ` AND ` + cond.column + ` = ?`
+			args = append(args, cond.value)
+		}
+	}
+	if !f.Since.IsZero() {
+		query += ` AND created_at >= ?`
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query += ` AND created_at < ?`
+		args = append(args, f.Until.UTC())
+	}
+	return query, args
+}
+
+// ListRegistryEvents lists the events matching the filter, newest first,
+// with the total number of matches.
+func ListRegistryEvents(filter RegistryEventFilter, page, pageSize int) ([]*RegistryEventRecord, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM registry_events`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := db.Query(`
+		SELECT id, type, repository, tag, digest, size, client_ip, username, duration_ms, created_at
+		FROM registry_events`+where+` ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?
+	`, append(args, pageSize, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var events []*RegistryEventRecord
+	for rows.Next() {
+		e := &RegistryEventRecord{}
+		if err := rows.Scan(&e.ID, &e.Type, &e.Repository, &e.Tag, &e.Digest, &e.Size, &e.ClientIP, &e.Username, &e.DurationMs, &e.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
+
+// CountRegistryEvents returns the number of events of each type since the
+// given time.
+func CountRegistryEvents(since time.Time) (map[string]int64, error) {
+	rows, err := db.Query(`
+		SELECT type, COUNT(*) FROM registry_events WHERE created_at >= ? GROUP BY type
+	`, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64)
+	for rows.Next() {
+		var eventType string
+		var n int64
+		if err := rows.Scan(&eventType, &n); err != nil {
+			return nil, err
+		}
+		counts[eventType] = n
+	}
+	return counts, rows.Err()
+}
+
+// TopRegistryUsers returns the users with the most events since the given
+// time. Anonymous events are not counted.
+func TopRegistryUsers(since time.Time, limit int) ([]*RegistryUserActivity, error) {
+	rows, err := db.Query(`
+		SELECT username,
+			SUM(CASE WHEN type = 'pull' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN type = 'push' THEN 1 ELSE 0 END),
+			SUM(CASE WHEN type = 'delete' THEN 1 ELSE 0 END)
+		FROM registry_events
+		WHERE created_at >= ? AND username != ''
+		GROUP BY username
+		ORDER BY COUNT(*) DESC, username
+		LIMIT ?
+	`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*RegistryUserActivity
+	for rows.Next() {
+		u := &RegistryUserActivity{}
+		if err := rows.Scan(&u.Username, &u.Pulls, &u.Pushes, &u.Deletes); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// TopPulledImagesSince returns the most pulled images since the given time.
+// Pulls by digest are counted under an empty tag.
+func TopPulledImagesSince(since time.Time, limit int) ([]*PopularImageRecord, error) {
+	rows, err := db.Query(`
+		SELECT repository, tag, COUNT(*), COUNT(DISTINCT NULLIF(username, ''))
+		FROM registry_events
+		WHERE type = 'pull' AND created_at >= ?
+		GROUP BY repository, tag
+		ORDER BY COUNT(*) DESC, repository, tag
+		LIMIT ?
+	`, since.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []*PopularImageRecord
+	for rows.Next() {
+		r := &PopularImageRecord{}
+		if err := rows.Scan(&r.Repository, &r.Tag, &r.Pulls, &r.Users); err != nil {
+			return nil, err
+		}
+		images = append(images, r)
+	}
+	return images, rows.Err()
+}
+
+// PruneRegistryEvents removes events older than before.
+func PruneRegistryEvents(before time.Time) error {
+	_, err := db.Exec(`DELETE FROM registry_events WHERE created_at < ?`, before.UTC())
+	return err
+}
@@ -0,0 +1,75 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RepositoryRecord holds per-repository settings. Repositories without a
+// record use the configured defaults.
+type RepositoryRecord struct {
+	Name       string
+	Visibility string
+	UpdatedBy  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Repository operations
+
+// GetRepository returns the settings of a repository, or nil if none are
+// stored.
+func GetRepository(name string) (*RepositoryRecord, error) {
+	r := &RepositoryRecord{}
+	var updatedBy sql.NullString
+	err := db.QueryRow(`
+		SELECT name, visibility, updated_by, created_at, updated_at
+		FROM repositories WHERE name = ?
+	`, name).Scan(&r.Name, &r.Visibility, &updatedBy, &r.CreatedAt, &r.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.UpdatedBy = updatedBy.String
+	return r, nil
+}
+
+// SetRepositoryVisibility inserts or updates the visibility of a repository.
+func SetRepositoryVisibility(name, visibility, updatedBy string) error {
+	now := time.Now()
+	_, err := db.Exec(`
+		INSERT INTO repositories (name, visibility, updated_by, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			visibility = excluded.visibility, updated_by = excluded.updated_by,
+			updated_at = excluded.updated_at
+	`, name, visibility, updatedBy, now, now)
+	return err
+}
+
+// ListRepositories returns all stored repository settings ordered by name.
+func ListRepositories() ([]*RepositoryRecord, error) {
+	rows, err := db.Query(`
+		SELECT name, visibility, updated_by, created_at, updated_at
+		FROM repositories ORDER BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*RepositoryRecord
+	for rows.Next() {
+		r := &RepositoryRecord{}
+		var updatedBy sql.NullString
+		if err := rows.Scan(&r.Name, &r.Visibility, &updatedBy, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		r.UpdatedBy = updatedBy.String
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
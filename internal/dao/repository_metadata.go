@@ -0,0 +1,51 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RepositoryMetadataRecord holds the catalog description of a repository.
+// Links are stored as a JSON array.
+type RepositoryMetadataRecord struct {
+	Name        string
+	Description string
+	Icon        string
+	Links       string
+	UpdatedBy   string
+	UpdatedAt   time.Time
+}
+
+// Repository metadata operations
+
+// GetRepositoryMetadata returns the metadata of a repository, or nil if
+// none is stored.
+func GetRepositoryMetadata(name string) (*RepositoryMetadataRecord, error) {
+	r := &RepositoryMetadataRecord{}
+	var updatedBy sql.NullString
+	err := db.QueryRow(`
+		SELECT name, description, icon, links, updated_by, updated_at
+		FROM repository_metadata WHERE name = ?
+	`, name).Scan(&r.Name, &r.Description, &r.Icon, &r.Links, &updatedBy, &r.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.UpdatedBy = updatedBy.String
+	return r, nil
+}
+
+// SetRepositoryMetadata inserts or replaces the metadata of a repository.
+func SetRepositoryMetadata(r *RepositoryMetadataRecord) error {
+	_, err := db.Exec(`
+		INSERT INTO repository_metadata (name, description, icon, links, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET
+			description = excluded.description, icon = excluded.icon, links = excluded.links,
+			updated_by = excluded.updated_by, updated_at = excluded.updated_at
+	`, r.Name, r.Description, r.Icon, r.Links, r.UpdatedBy, r.UpdatedAt)
+	return err
+}
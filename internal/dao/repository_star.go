@@ -0,0 +1,95 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"strings"
+	"time"
+)
+
+// RepositoryStarRecord is a repository starred by a user.
+type RepositoryStarRecord struct {
+	UserID     int64
+	Repository string
+	CreatedAt  time.Time
+}
+
+// Repository star operations
+
+// StarRepository stars a repository for a user. Starring twice is a no-op.
+func StarRepository(userID int64, repository string) error {
+	_, err := db.Exec(`
+		INSERT OR IGNORE INTO repository_stars (user_id, repository, created_at) VALUES (?, ?, ?)
+	`, userID, repository, time.Now())
+	return err
+}
+
+// UnstarRepository removes the star of a user from a repository.
+func UnstarRepository(userID int64, repository string) error {
+	_, err := db.Exec(`DELETE FROM repository_stars WHERE user_id = ? AND repository = ?`, userID, repository)
+	return err
+}
+
+// ListStarredRepositories returns the repositories starred by a user, most
+// recently starred first.
+func ListStarredRepositories(userID int64) ([]*RepositoryStarRecord, error) {
+	rows, err := db.Query(`
+		SELECT user_id, repository, created_at FROM repository_stars
+		WHERE user_id = ? ORDER BY created_at DESC, repository
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stars []*RepositoryStarRecord
+	for rows.Next() {
+		s := &RepositoryStarRecord{}
+		if err := rows.Scan(&s.UserID, &s.Repository, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		stars = append(stars, s)
+	}
+	return stars, rows.Err()
+}
+
+// IsRepositoryStarred reports whether a user starred a repository.
+func IsRepositoryStarred(userID int64, repository string) (bool, error) {
+	var n int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM repository_stars WHERE user_id = ? AND repository = ?
+	`, userID, repository).Scan(&n)
+	return n > 0, err
+}
+
+// CountRepositoryStars returns the number of stars of each given repository.
+// Repositories without stars are omitted.
+func CountRepositoryStars(repositories []string) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	if len(repositories) == 0 {
+		return counts, nil
+	}
+
+	args := make([]interface{}, len(repositories))
+	for i, r := range repositories {
+		args[i] = r
+	}
+	rows, err := db.Query(`
+		SELECT repository, COUNT(*) FROM repository_stars
+		WHERE repository IN (?`+strings.Repeat(`, ?`, len(repositories)-1)+`)
+		GROUP BY repository
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var repository string
+		var n int64
+		if err := rows.Scan(&repository, &n); err != nil {
+			return nil, err
+		}
+		counts[repository] = n
+	}
+	return counts, rows.Err()
+}
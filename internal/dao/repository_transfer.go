@@ -0,0 +1,156 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// RepositoryTransferRecord is a request to move a repository to another
+// namespace.
+type RepositoryTransferRecord struct {
+	ID              int64
+	Repository      string
+	TargetNamespace string
+	NewName         string
+	RequestedBy     string
+	RequestedByID   int64
+	Status          string
+	ResolvedBy      string
+	CreatedAt       time.Time
+	ResolvedAt      *time.Time
+}
+
+// Repository transfer operations
+
+// CreateRepositoryTransfer inserts a transfer request.
+func CreateRepositoryTransfer(t *RepositoryTransferRecord) error {
+	result, err := db.Exec(`
+		INSERT INTO repository_transfers (repository, target_namespace, new_name, requested_by, requested_by_id, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.Repository, t.TargetNamespace, t.NewName, t.RequestedBy, t.RequestedByID, t.Status, t.CreatedAt)
+	if err != nil {
+		return err
+	}
+	t.ID, err = result.LastInsertId()
+	return err
+}
+
+const repositoryTransferColumns = `id, repository, target_namespace, new_name, requested_by, requested_by_id, status, resolved_by, created_at, resolved_at`
+
+func scanRepositoryTransfer(row interface{ Scan(...interface{}) error }) (*RepositoryTransferRecord, error) {
+	t := &RepositoryTransferRecord{}
+	var resolvedBy sql.NullString
+	var resolvedAt sql.NullTime
+	if err := row.Scan(&t.ID, &t.Repository, &t.TargetNamespace, &t.NewName, &t.RequestedBy, &t.RequestedByID,
+		&t.Status, &resolvedBy, &t.CreatedAt, &resolvedAt); err != nil {
+		return nil, err
+	}
+	t.ResolvedBy = resolvedBy.String
+	if resolvedAt.Valid {
+		t.ResolvedAt = &resolvedAt.Time
+	}
+	return t, nil
+}
+
+// GetRepositoryTransfer returns a transfer request, or nil if not found.
+func GetRepositoryTransfer(id int64) (*RepositoryTransferRecord, error) {
+	t, err := scanRepositoryTransfer(db.QueryRow(`
+		SELECT `+repositoryTransferColumns+` FROM repository_transfers WHERE id = ?
+	`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// GetPendingRepositoryTransfer returns the pending transfer of a repository,
+// or nil if there is none.
+func GetPendingRepositoryTransfer(repository string) (*RepositoryTransferRecord, error) {
+	t, err := scanRepositoryTransfer(db.QueryRow(`
+		SELECT `+repositoryTransferColumns+` FROM repository_transfers
+		WHERE repository = ? AND status = 'pending'
+	`, repository))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return t, err
+}
+
+// ListRepositoryTransfers returns the transfers requested by a user or
+// targeting the given namespace, newest first. Only pending ones are listed
+// unless all is true.
+func ListRepositoryTransfers(username, namespace string, all bool) ([]*RepositoryTransferRecord, error) {
+	query := `SELECT ` + repositoryTransferColumns + ` FROM repository_transfers
+		WHERE (requested_by = ? OR target_namespace = ?)`
+	if !all {
+		query += ` AND status = 'pending'`
+	}
+	rows, err := db.Query(query+` ORDER BY created_at DESC, id DESC`, username, namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transfers []*RepositoryTransferRecord
+	for rows.Next() {
+		t, err := scanRepositoryTransfer(rows)
+		if err != nil {
+			return nil, err
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// ResolveRepositoryTransfer sets the final status of a pending transfer. It
+// reports false if the transfer was no longer pending.
+func ResolveRepositoryTransfer(id int64, status, resolvedBy string) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE repository_transfers SET status = ?, resolved_by = ?, resolved_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, status, resolvedBy, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// RenameRepositoryReferences moves the settings, stars, statistics and
+// events of a repository to its new name in one transaction. Team grants
+// belong to the old namespace's organization and are removed. Stale rows
+// left under the new name by an earlier repository are replaced.
+func RenameRepositoryReferences(from, to string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`DELETE FROM repositories WHERE name = ?`, []interface{}{to}},
+		{`UPDATE repositories SET name = ? WHERE name = ?`, []interface{}{to, from}},
+		{`DELETE FROM repository_metadata WHERE name = ?`, []interface{}{to}},
+		{`UPDATE repository_metadata SET name = ? WHERE name = ?`, []interface{}{to, from}},
+		{`UPDATE OR IGNORE repository_stars SET repository = ? WHERE repository = ?`, []interface{}{to, from}},
+		{`DELETE FROM repository_stars WHERE repository = ?`, []interface{}{from}},
+		{`DELETE FROM team_repositories WHERE repository = ?`, []interface{}{from}},
+		{`INSERT INTO image_stats_daily (day, repository, tag, pulls, pushes, bytes_served)
+			SELECT day, ?, tag, pulls, pushes, bytes_served FROM image_stats_daily WHERE repository = ?
+			ON CONFLICT(day, repository, tag) DO UPDATE SET
+				pulls = pulls + excluded.pulls, pushes = pushes + excluded.pushes,
+				bytes_served = bytes_served + excluded.bytes_served`, []interface{}{to, from}},
+		{`DELETE FROM image_stats_daily WHERE repository = ?`, []interface{}{from}},
+		{`UPDATE image_pushes SET repository = ? WHERE repository = ?`, []interface{}{to, from}},
+		{`UPDATE registry_events SET repository = ? WHERE repository = ?`, []interface{}{to, from}},
+	} {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
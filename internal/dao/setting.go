@@ -0,0 +1,56 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Setting is a runtime override of a configuration value.
+type Setting struct {
+	Key       string
+	Value     string
+	UpdatedBy string
+	UpdatedAt time.Time
+}
+
+// Setting operations
+
+// ListSettings lists all stored setting overrides.
+func ListSettings() ([]*Setting, error) {
+	rows, err := db.Query(`SELECT key, value, updated_by, updated_at FROM settings ORDER BY key`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []*Setting
+	for rows.Next() {
+		setting := &Setting{}
+		var updatedBy sql.NullString
+		if err := rows.Scan(&setting.Key, &setting.Value, &updatedBy, &setting.UpdatedAt); err != nil {
+			return nil, err
+		}
+		setting.UpdatedBy = updatedBy.String
+		settings = append(settings, setting)
+	}
+	return settings, rows.Err()
+}
+
+// UpsertSetting creates or replaces a setting override.
+func UpsertSetting(setting *Setting) error {
+	if setting.UpdatedAt.IsZero() {
+		setting.UpdatedAt = time.Now()
+	}
+	_, err := db.Exec(`
+		INSERT INTO settings (key, value, updated_by, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_by = excluded.updated_by, updated_at = excluded.updated_at
+	`, setting.Key, setting.Value, setting.UpdatedBy, setting.UpdatedAt)
+	return err
+}
+
+// DeleteSetting deletes a setting override.
+func DeleteSetting(key string) error {
+	_, err := db.Exec(`DELETE FROM settings WHERE key = ?`, key)
+	return err
+}
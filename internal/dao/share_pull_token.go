@@ -0,0 +1,65 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// SharePullToken is a pull token issued for a share link, stored by hash.
+type SharePullToken struct {
+	TokenHash  string
+	Code       string
+	Repository string
+	Tag        string
+	Digests    string // 兑换时标签解析到的清单摘要，逗号分隔
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+// Share pull token operations
+//
+// Tokens are stored with UTC timestamps so that all instances sharing the
+// database compare them the same way.
+
+// CreateSharePullToken stores a share pull token.
+func CreateSharePullToken(t *SharePullToken) error {
+	t.CreatedAt = time.Now().UTC()
+	_, err := db.Exec(`
+		INSERT INTO share_pull_tokens (token_hash, code, repository, tag, digests, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, t.TokenHash, t.Code, t.Repository, t.Tag, t.Digests, t.ExpiresAt.UTC(), t.CreatedAt)
+	return err
+}
+
+// GetSharePullToken retrieves an unexpired share pull token by hash, or nil.
+func GetSharePullToken(tokenHash string, now time.Time) (*SharePullToken, error) {
+	t := &SharePullToken{}
+	err := db.QueryRow(`
+		SELECT token_hash, code, repository, tag, digests, expires_at, created_at
+		FROM share_pull_tokens WHERE token_hash = ? AND expires_at > ?
+	`, tokenHash, now.UTC()).Scan(&t.TokenHash, &t.Code, &t.Repository, &t.Tag, &t.Digests, &t.ExpiresAt, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// DeleteSharePullTokens deletes the pull tokens issued for a share code.
+func DeleteSharePullTokens(code string) error {
+	_, err := db.Exec(`DELETE FROM share_pull_tokens WHERE code = ?`, code)
+	return err
+}
+
+// DeleteExpiredSharePullTokens deletes the pull tokens that expired before
+// now and returns how many were deleted.
+func DeleteExpiredSharePullTokens(now time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM share_pull_tokens WHERE expires_at <= ?`, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
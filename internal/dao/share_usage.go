@@ -0,0 +1,74 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ShareLinkUsage records one redemption of a share link.
+type ShareLinkUsage struct {
+	ID        int64
+	LinkID    int64
+	Code      string
+	ImageRef  string
+	IPAddress string
+	UserAgent string
+	CreatedAt time.Time
+}
+
+// Share link usage operations
+
+// CreateShareLinkUsage records a share link redemption.
+func CreateShareLinkUsage(u *ShareLinkUsage) error {
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = time.Now()
+	}
+	result, err := db.Exec(`
+		INSERT INTO share_link_usages (link_id, code, image_ref, ip_address, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, u.LinkID, u.Code, u.ImageRef, u.IPAddress, u.UserAgent, u.CreatedAt)
+	if err != nil {
+		return err
+	}
+	u.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// ListShareLinkUsages lists the redemptions of a share link, newest first.
+func ListShareLinkUsages(code string, page, pageSize int) ([]*ShareLinkUsage, int, error) {
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM share_link_usages WHERE code = ?`, code).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := db.Query(`
+		SELECT id, link_id, code, image_ref, ip_address, user_agent, created_at
+		FROM share_link_usages WHERE code = ? ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?
+	`, code, pageSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var usages []*ShareLinkUsage
+	for rows.Next() {
+		u := &ShareLinkUsage{}
+		var ip, ua sql.NullString
+		if err := rows.Scan(&u.ID, &u.LinkID, &u.Code, &u.ImageRef, &ip, &ua, &u.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		u.IPAddress = ip.String
+		u.UserAgent = ua.String
+		usages = append(usages, u)
+	}
+	return usages, total, rows.Err()
+}
+
+// DeleteShareLinkUsages deletes the usage history of a share link.
+func DeleteShareLinkUsages(code string) error {
+	_, err := db.Exec(`DELETE FROM share_link_usages WHERE code = ?`, code)
+	return err
+}
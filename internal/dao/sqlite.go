@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 var (
 	db     *sql.DB
 	dbOnce sync.Once
+	dbFile string
 	logger *zap.Logger
 )
 
@@ -26,18 +28,14 @@ func InitDB(dbPath string, log *zap.Logger) error {
 	var initErr error
 	dbOnce.Do(func() {
 		logger = log
+		dbFile = dbPath
 		var err error
-		// 使用 modernc.org/sqlite 驱动，驱动名为 "sqlite"
-		// 支持 WAL 模式和忙等待超时
-		db, err = sql.Open("sqlite", dbPath+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+		db, err = openDB(dbPath)
 		if err != nil {
 			initErr = err
 			return
 		}
 
-		db.SetMaxOpenConns(1)
-		db.SetMaxIdleConns(1)
-
 		if err := createTables(); err != nil {
 			initErr = err
 			return
@@ -51,11 +49,30 @@ func InitDB(dbPath string, log *zap.Logger) error {
 	return initErr
 }
 
+// openDB opens the SQLite database file with the default pragmas.
+func openDB(dbPath string) (*sql.DB, error) {
+	// 使用 modernc.org/sqlite 驱动，驱动名为 "sqlite"
+	// 支持 WAL 模式和忙等待超时
+	conn, err := sql.Open("sqlite", dbPath+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetMaxOpenConns(1)
+	conn.SetMaxIdleConns(1)
+	return conn, nil
+}
+
 // GetDB returns the database instance.
 func GetDB() *sql.DB {
 	return db
 }
 
+// GetDBPath returns the path of the database file.
+func GetDBPath() string {
+	return dbFile
+}
+
 // CloseDB closes the database connection.
 func CloseDB() error {
 	if db != nil {
@@ -73,6 +90,7 @@ func createTables() error {
 			email TEXT,
 			role TEXT DEFAULT 'user',
 			is_active INTEGER DEFAULT 1,
+			must_change_password INTEGER DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			last_login_at DATETIME
@@ -107,7 +125,8 @@ func createTables() error {
 			status TEXT,
 			error_msg TEXT,
 			blockchain_hash TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			redacted INTEGER DEFAULT 0
 		)`,
 		`CREATE TABLE IF NOT EXISTS system_status (
 			id INTEGER PRIMARY KEY CHECK (id = 1),
@@ -139,6 +158,46 @@ func createTables() error {
 			FOREIGN KEY (user_id) REFERENCES users(id),
 			UNIQUE(org_id, user_id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS org_invitations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			org_id INTEGER NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			invitee_id INTEGER,
+			email TEXT,
+			role TEXT DEFAULT 'member',
+			invited_by INTEGER NOT NULL,
+			status TEXT DEFAULT 'pending',
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			responded_at DATETIME,
+			FOREIGN KEY (org_id) REFERENCES organizations(id),
+			FOREIGN KEY (invited_by) REFERENCES users(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS teams (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			org_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			description TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (org_id) REFERENCES organizations(id),
+			UNIQUE(org_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS team_members (
+			team_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (team_id) REFERENCES teams(id),
+			FOREIGN KEY (user_id) REFERENCES users(id),
+			PRIMARY KEY (team_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS team_repositories (
+			team_id INTEGER NOT NULL,
+			repository TEXT NOT NULL,
+			permission TEXT NOT NULL DEFAULT 'read',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (team_id) REFERENCES teams(id),
+			PRIMARY KEY (team_id, repository)
+		)`,
 		`CREATE TABLE IF NOT EXISTS share_links (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			code TEXT UNIQUE NOT NULL,
@@ -148,9 +207,28 @@ func createTables() error {
 			max_usage INTEGER DEFAULT 0,
 			usage_count INTEGER DEFAULT 0,
 			expires_at DATETIME,
+			notify_on_use INTEGER DEFAULT 0,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (created_by) REFERENCES users(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS share_link_usages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			link_id INTEGER NOT NULL,
+			code TEXT NOT NULL,
+			image_ref TEXT NOT NULL,
+			ip_address TEXT,
+			user_agent TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS share_pull_tokens (
+			token_hash TEXT PRIMARY KEY,
+			code TEXT NOT NULL,
+			repository TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			digests TEXT NOT NULL DEFAULT '',
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
 		`CREATE TABLE IF NOT EXISTS audit_logs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
@@ -163,15 +241,199 @@ func createTables() error {
 			action TEXT,
 			status TEXT,
 			details TEXT,
-			blockchain_hash TEXT
+			blockchain_hash TEXT,
+			org_id INTEGER,
+			request_id TEXT,
+			redacted INTEGER DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS workflows (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			enabled INTEGER DEFAULT 1,
+			data TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS workflow_jobs (
+			id TEXT PRIMARY KEY,
+			workflow_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			data TEXT NOT NULL,
+			started_at DATETIME,
+			completed_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS image_stats_daily (
+			day TEXT NOT NULL,
+			repository TEXT NOT NULL,
+			tag TEXT NOT NULL DEFAULT '',
+			pulls INTEGER DEFAULT 0,
+			pushes INTEGER DEFAULT 0,
+			bytes_served INTEGER DEFAULT 0,
+			PRIMARY KEY (day, repository, tag)
+		)`,
+		`CREATE TABLE IF NOT EXISTS image_pushes (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			repository TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			digest TEXT,
+			pushed_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS registry_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			repository TEXT NOT NULL,
+			tag TEXT NOT NULL DEFAULT '',
+			digest TEXT NOT NULL DEFAULT '',
+			size INTEGER DEFAULT 0,
+			client_ip TEXT NOT NULL DEFAULT '',
+			username TEXT NOT NULL DEFAULT '',
+			duration_ms INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS image_labels (
+			digest TEXT NOT NULL,
+			source TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (digest, source, key)
+		)`,
+		`CREATE TABLE IF NOT EXISTS repositories (
+			name TEXT PRIMARY KEY,
+			visibility TEXT NOT NULL DEFAULT 'private',
+			updated_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS repository_metadata (
+			name TEXT PRIMARY KEY,
+			description TEXT NOT NULL DEFAULT '',
+			icon TEXT NOT NULL DEFAULT '',
+			links TEXT NOT NULL DEFAULT '[]',
+			updated_by TEXT,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS repository_stars (
+			user_id INTEGER NOT NULL,
+			repository TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, repository)
+		)`,
+		`CREATE TABLE IF NOT EXISTS repository_transfers (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			repository TEXT NOT NULL,
+			target_namespace TEXT NOT NULL,
+			new_name TEXT NOT NULL,
+			requested_by TEXT NOT NULL,
+			requested_by_id INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			resolved_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			resolved_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS ip_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			action TEXT NOT NULL,
+			cidr TEXT NOT NULL,
+			scope TEXT NOT NULL DEFAULT 'global',
+			description TEXT,
+			created_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_by TEXT,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS promotions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			repository TEXT NOT NULL,
+			digest TEXT NOT NULL,
+			from_channel TEXT NOT NULL,
+			to_channel TEXT NOT NULL,
+			requested_by TEXT NOT NULL,
+			requested_by_id INTEGER NOT NULL,
+			status TEXT NOT NULL DEFAULT 'pending',
+			reason TEXT,
+			resolved_by TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			resolved_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS promotion_approvals (
+			promotion_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			username TEXT NOT NULL,
+			comment TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (promotion_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS provenance_attestations (
+			repository TEXT NOT NULL,
+			attestation_digest TEXT NOT NULL,
+			subject_digest TEXT NOT NULL,
+			predicate_type TEXT,
+			builder_id TEXT,
+			trusted_builder TEXT,
+			status TEXT NOT NULL,
+			reason TEXT,
+			verified_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (repository, attestation_digest)
+		)`,
+		`CREATE TABLE IF NOT EXISTS license_policies (
+			org_id INTEGER PRIMARY KEY,
+			mode TEXT NOT NULL DEFAULT 'warn',
+			allowed TEXT,
+			forbidden TEXT,
+			deny_unknown INTEGER DEFAULT 0,
+			updated_by TEXT,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS advisory_locks (
+			name TEXT PRIMARY KEY,
+			holder TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS access_digest_subscriptions (
+			user_id INTEGER PRIMARY KEY,
+			subscribed INTEGER DEFAULT 0,
+			only_anomalies INTEGER DEFAULT 0,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS access_digests (
+			day TEXT PRIMARY KEY,
+			sent_at DATETIME,
+			recipients INTEGER DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS namespaces (
+			name TEXT PRIMARY KEY,
+			kind TEXT NOT NULL,
+			owner_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_sessions_expires_at ON sessions(expires_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_access_attempts_ip ON access_attempts(ip_address)`,
 		`CREATE INDEX IF NOT EXISTS idx_access_attempts_created ON access_attempts(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_access_attempts_user ON access_attempts(user_id, action)`,
 		`CREATE INDEX IF NOT EXISTS idx_audit_logs_timestamp ON audit_logs(timestamp)`,
 		`CREATE INDEX IF NOT EXISTS idx_audit_logs_event ON audit_logs(event)`,
 		`CREATE INDEX IF NOT EXISTS idx_share_links_code ON share_links(code)`,
+		`CREATE INDEX IF NOT EXISTS idx_team_members_user ON team_members(user_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_org_invitations_org ON org_invitations(org_id, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_share_link_usages_code ON share_link_usages(code, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_share_pull_tokens_code ON share_pull_tokens(code)`,
+		`CREATE INDEX IF NOT EXISTS idx_share_pull_tokens_expires ON share_pull_tokens(expires_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_workflow_jobs_workflow ON workflow_jobs(workflow_id, started_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_pushes_created ON image_pushes(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_registry_events_created ON registry_events(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_registry_events_repo ON registry_events(repository, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_registry_events_user ON registry_events(username, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_image_labels_key ON image_labels(key, value)`,
+		`CREATE INDEX IF NOT EXISTS idx_repository_stars_repo ON repository_stars(repository)`,
+		`CREATE INDEX IF NOT EXISTS idx_repository_transfers_repo ON repository_transfers(repository, status)`,
+		`CREATE INDEX IF NOT EXISTS idx_promotions_status ON promotions(status, created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_provenance_subject ON provenance_attestations(repository, subject_digest)`,
 	}
 
 	for _, schema := range schemas {
@@ -180,7 +442,63 @@ func createTables() error {
 		}
 	}
 
-	return nil
+	return migrateColumns()
+}
+
+// migrateColumns adds columns introduced after a table was first created.
+// CREATE TABLE IF NOT EXISTS leaves existing tables untouched, so databases
+// from older versions need the columns added explicitly.
+func migrateColumns() error {
+	columns := []struct {
+		table, column, definition string
+	}{
+		{"share_links", "notify_on_use", "INTEGER DEFAULT 0"},
+		{"audit_logs", "org_id", "INTEGER"},
+		{"users", "must_change_password", "INTEGER DEFAULT 0"},
+		{"audit_logs", "request_id", "TEXT"},
+		{"audit_logs", "redacted", "INTEGER DEFAULT 0"},
+		{"access_attempts", "redacted", "INTEGER DEFAULT 0"},
+	}
+
+	for _, col := range columns {
+		exists, err := columnExists(col.table, col.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE ` + col.table + ` ADD COLUMN ` + col.column + ` ` + col.definition); err != nil {
+			return err
+		}
+	}
+
+	// Indexes on migrated columns
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_logs_org ON audit_logs(org_id, id)`); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_logs_request ON audit_logs(request_id)`)
+	return err
+}
+
+// columnExists reports whether a table has the given column.
+func columnExists(table, column string) (bool, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
 
 func seedDefaultData() error {
@@ -208,7 +526,7 @@ func seedDefaultData() error {
 		}
 	}
 
-	return nil
+	return backfillNamespaces()
 }
 
 // User operations
@@ -217,11 +535,11 @@ func seedDefaultData() error {
 func GetUserByUsername(username string) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(`
-		SELECT id, username, password_hash, email, role, is_active, created_at, updated_at, last_login_at
+		SELECT id, username, password_hash, email, role, is_active, must_change_password, created_at, updated_at, last_login_at
 		FROM users WHERE username = ?
 	`, username).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email,
-		&user.Role, &user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+		&user.Role, &user.IsActive, &user.MustChangePassword, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -236,11 +554,11 @@ func GetUserByUsername(username string) (*User, error) {
 func GetUserByEmail(email string) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(`
-		SELECT id, username, password_hash, email, role, is_active, created_at, updated_at, last_login_at
+		SELECT id, username, password_hash, email, role, is_active, must_change_password, created_at, updated_at, last_login_at
 		FROM users WHERE email = ?
 	`, email).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email,
-		&user.Role, &user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+		&user.Role, &user.IsActive, &user.MustChangePassword, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -255,11 +573,11 @@ func GetUserByEmail(email string) (*User, error) {
 func GetUserByID(id int64) (*User, error) {
 	user := &User{}
 	err := db.QueryRow(`
-		SELECT id, username, password_hash, email, role, is_active, created_at, updated_at, last_login_at
+		SELECT id, username, password_hash, email, role, is_active, must_change_password, created_at, updated_at, last_login_at
 		FROM users WHERE id = ?
 	`, id).Scan(
 		&user.ID, &user.Username, &user.PasswordHash, &user.Email,
-		&user.Role, &user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+		&user.Role, &user.IsActive, &user.MustChangePassword, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -270,9 +588,16 @@ func GetUserByID(id int64) (*User, error) {
 	return user, nil
 }
 
-// CreateUser creates a new user.
+// CreateUser creates a new user. It fails with ErrNamespaceTaken if the
+// name is claimed by an organization or a deleted user.
 func CreateUser(user *User) error {
-	result, err := db.Exec(`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
 		INSERT INTO users (username, password_hash, email, role, is_active)
 		VALUES (?, ?, ?, ?, ?)
 	`, user.Username, user.PasswordHash, user.Email, user.Role, user.IsActive)
@@ -280,6 +605,12 @@ func CreateUser(user *User) error {
 		return err
 	}
 	id, _ := result.LastInsertId()
+	if err := claimNamespace(tx, user.Username, NamespaceUser, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	user.ID = id
 	return nil
 }
@@ -293,10 +624,11 @@ func UpdateUser(user *User) error {
 	return err
 }
 
-// UpdateUserPassword updates a user's password.
-func UpdateUserPassword(userID int64, passwordHash string) error {
-	_, err := db.Exec(`UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
-		passwordHash, userID)
+// UpdateUserPassword updates a user's password. mustChange forces the user
+// to change it at the next login.
+func UpdateUserPassword(userID int64, passwordHash string, mustChange bool) error {
+	_, err := db.Exec(`UPDATE users SET password_hash = ?, must_change_password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		passwordHash, mustChange, userID)
 	return err
 }
 
@@ -308,17 +640,30 @@ func UpdateUserLastLogin(userID int64) error {
 
 // ListUsers lists all users.
 func ListUsers(page, pageSize int) ([]*User, int, error) {
+	return SearchUsers("", page, pageSize)
+}
+
+// SearchUsers lists the users whose username or email contains search.
+func SearchUsers(search string, page, pageSize int) ([]*User, int, error) {
+	where := ``
+	var args []interface{}
+	if search != "" {
+		where = ` WHERE username LIKE ? ESCAPE '\' OR email LIKE ? ESCAPE '\'`
+		pattern := "%" + escapeLike(search) + "%"
+		args = append(args, pattern, pattern)
+	}
+
 	var total int
-	err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&total)
+	err := db.QueryRow(`SELECT COUNT(*) FROM users`+where, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	offset := (page - 1) * pageSize
 	rows, err := db.Query(`
-		SELECT id, username, password_hash, email, role, is_active, created_at, updated_at, last_login_at
-		FROM users ORDER BY id LIMIT ? OFFSET ?
-	`, pageSize, offset)
+		SELECT id, username, password_hash, email, role, is_active, must_change_password, created_at, updated_at, last_login_at
+		FROM users`+where+` ORDER BY id LIMIT ? OFFSET ?
+	`, append(args, pageSize, offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -329,7 +674,7 @@ func ListUsers(page, pageSize int) ([]*User, int, error) {
 		user := &User{}
 		err := rows.Scan(
 			&user.ID, &user.Username, &user.PasswordHash, &user.Email,
-			&user.Role, &user.IsActive, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
+			&user.Role, &user.IsActive, &user.MustChangePassword, &user.CreatedAt, &user.UpdatedAt, &user.LastLoginAt,
 		)
 		if err != nil {
 			return nil, 0, err
@@ -339,8 +684,33 @@ func ListUsers(page, pageSize int) ([]*User, int, error) {
 	return users, total, nil
 }
 
-// DeleteUser deletes a user.
+// escapeLike escapes the LIKE wildcards of s, using \ as the escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// CountActiveAdmins counts the active users with the admin role.
+func CountActiveAdmins() (int, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = 'admin' AND is_active = 1`).Scan(&n)
+	return n, err
+}
+
+// DeleteUser deletes a user with the user's sessions, tokens, organization
+// and team memberships and repository stars.
 func DeleteUser(id int64) error {
+	for _, query := range []string{
+		`DELETE FROM sessions WHERE user_id = ?`,
+		`DELETE FROM personal_access_tokens WHERE user_id = ?`,
+		`DELETE FROM team_members WHERE user_id = ?`,
+		`DELETE FROM org_members WHERE user_id = ?`,
+		`DELETE FROM repository_stars WHERE user_id = ?`,
+		`DELETE FROM access_digest_subscriptions WHERE user_id = ?`,
+	} {
+		if _, err := db.Exec(query, id); err != nil {
+			return err
+		}
+	}
 	_, err := db.Exec(`DELETE FROM users WHERE id = ?`, id)
 	return err
 }
@@ -401,10 +771,14 @@ func DeleteUserSessions(userID int64) error {
 	return err
 }
 
-// CleanExpiredSessions removes expired sessions.
-func CleanExpiredSessions() error {
-	_, err := db.Exec(`DELETE FROM sessions WHERE expires_at < CURRENT_TIMESTAMP`)
-	return err
+// CleanExpiredSessions removes sessions that expired before now and
+// returns how many were removed.
+func CleanExpiredSessions(now time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM sessions WHERE expires_at < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // Token operations
@@ -476,6 +850,16 @@ func UpdateTokenLastUsed(id int64) error {
 	return err
 }
 
+// DeleteExpiredTokens deletes personal access tokens that expired before
+// the given time and returns how many were removed.
+func DeleteExpiredTokens(before time.Time) (int64, error) {
+	result, err := db.Exec(`DELETE FROM personal_access_tokens WHERE expires_at IS NOT NULL AND expires_at < ?`, before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // DeleteToken deletes a token.
 func DeleteToken(id int64) error {
 	_, err := db.Exec(`DELETE FROM personal_access_tokens WHERE id = ?`, id)
@@ -486,10 +870,13 @@ func DeleteToken(id int64) error {
 
 // CreateAccessAttempt creates a new access attempt record.
 func CreateAccessAttempt(attempt *AccessAttempt) error {
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
 	result, err := db.Exec(`
-		INSERT INTO access_attempts (ip_address, user_agent, user_id, action, resource, status, error_msg, blockchain_hash)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, attempt.IPAddress, attempt.UserAgent, attempt.UserID, attempt.Action, attempt.Resource, attempt.Status, attempt.ErrorMsg, attempt.BlockchainHash)
+		INSERT INTO access_attempts (ip_address, user_agent, user_id, action, resource, status, error_msg, blockchain_hash, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, attempt.IPAddress, attempt.UserAgent, attempt.UserID, attempt.Action, attempt.Resource, attempt.Status, attempt.ErrorMsg, attempt.BlockchainHash, attempt.CreatedAt.UTC())
 	if err != nil {
 		return err
 	}
@@ -545,6 +932,66 @@ func GetAccessAttempts(page, pageSize int, ip string) ([]*AccessAttempt, int, er
 	return attempts, total, nil
 }
 
+// ListAccessAttemptsSince lists the attempts of an IP address with the given
+// action and status since a point in time, oldest first. An empty action
+// or status matches all.
+func ListAccessAttemptsSince(ip, action, status string, since time.Time) ([]*AccessAttempt, error) {
+	query := `SELECT id, ip_address, user_agent, user_id, action, resource, status, error_msg, blockchain_hash, created_at
+		FROM access_attempts WHERE ip_address = ? AND created_at >= ?`
+	args := []interface{}{ip, since.UTC()}
+	if action != "" {
+		query += ` AND action = ?`
+		args = append(args, action)
+	}
+	if status != "" {
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*AccessAttempt
+	for rows.Next() {
+		a := &AccessAttempt{}
+		var userAgent, resource, errorMsg, hash sql.NullString
+		err := rows.Scan(&a.ID, &a.IPAddress, &userAgent, &a.UserID, &a.Action, &resource, &a.Status, &errorMsg, &hash, &a.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.UserAgent, a.Resource, a.ErrorMsg, a.BlockchainHash = userAgent.String, resource.String, errorMsg.String, hash.String
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// ListUserLoginIPs lists the distinct addresses of the successful logins of
+// a user since a point in time, excluding the given address.
+func ListUserLoginIPs(userID int64, since time.Time, exclude string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT DISTINCT ip_address FROM access_attempts
+		WHERE user_id = ? AND action = 'login' AND status = 'success' AND created_at >= ? AND ip_address != ?
+	`, userID, since.UTC(), exclude)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ips []string
+	for rows.Next() {
+		var ip string
+		if err := rows.Scan(&ip); err != nil {
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, rows.Err()
+}
+
 // System status operations
 
 // GetSystemStatus retrieves the system lock status.
@@ -574,9 +1021,17 @@ func UpdateSystemStatus(status *LockStatus) error {
 
 // Organization operations
 
-// CreateOrganization creates a new organization.
+// CreateOrganization creates a new organization. It fails with
+// ErrNamespaceTaken if the name is claimed by a user or a deleted
+// organization.
 func CreateOrganization(org *Organization) error {
-	result, err := db.Exec(`
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
 		INSERT INTO organizations (name, display_name, owner_id)
 		VALUES (?, ?, ?)
 	`, org.Name, org.DisplayName, org.OwnerID)
@@ -584,6 +1039,12 @@ func CreateOrganization(org *Organization) error {
 		return err
 	}
 	id, _ := result.LastInsertId()
+	if err := claimNamespace(tx, org.Name, NamespaceOrg, id); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 	org.ID = id
 	return nil
 }
@@ -686,6 +1147,15 @@ func UpdateOrganization(org *Organization) error {
 
 // DeleteOrganization deletes an organization.
 func DeleteOrganization(id int64) error {
+	if err := deleteOrgTeams(id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM org_invitations WHERE org_id = ?`, id); err != nil {
+		return err
+	}
+	if err := DeleteLicensePolicy(id); err != nil {
+		return err
+	}
 	_, err := db.Exec(`DELETE FROM org_members WHERE org_id = ?`, id)
 	if err != nil {
 		return err
@@ -700,9 +1170,16 @@ func AddOrgMember(orgID, userID int64, role string) error {
 	return err
 }
 
-// RemoveOrgMember removes a member from an organization.
+// RemoveOrgMember removes a member from an organization and its teams.
 func RemoveOrgMember(orgID, userID int64) error {
-	_, err := db.Exec(`DELETE FROM org_members WHERE org_id = ? AND user_id = ?`, orgID, userID)
+	_, err := db.Exec(`
+		DELETE FROM team_members
+		WHERE user_id = ? AND team_id IN (SELECT id FROM teams WHERE org_id = ?)
+	`, userID, orgID)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`DELETE FROM org_members WHERE org_id = ? AND user_id = ?`, orgID, userID)
 	return err
 }
 
@@ -736,9 +1213,9 @@ func GetOrgMembers(orgID int64) ([]*OrgMember, error) {
 // CreateShareLink creates a new share link.
 func CreateShareLink(link *ShareLink) error {
 	result, err := db.Exec(`
-		INSERT INTO share_links (code, image_ref, created_by, password_hash, max_usage, expires_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, link.Code, link.ImageRef, link.CreatedBy, link.PasswordHash, link.MaxUsage, link.ExpiresAt)
+		INSERT INTO share_links (code, image_ref, created_by, password_hash, max_usage, expires_at, notify_on_use)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, link.Code, link.ImageRef, link.CreatedBy, link.PasswordHash, link.MaxUsage, link.ExpiresAt, link.NotifyOnUse)
 	if err != nil {
 		return err
 	}
@@ -751,9 +1228,9 @@ func CreateShareLink(link *ShareLink) error {
 func GetShareLink(code string) (*ShareLink, error) {
 	link := &ShareLink{}
 	err := db.QueryRow(`
-		SELECT id, code, image_ref, created_by, password_hash, max_usage, usage_count, expires_at, created_at
+		SELECT id, code, image_ref, created_by, password_hash, max_usage, usage_count, expires_at, notify_on_use, created_at
 		FROM share_links WHERE code = ?
-	`, code).Scan(&link.ID, &link.Code, &link.ImageRef, &link.CreatedBy, &link.PasswordHash, &link.MaxUsage, &link.UsageCount, &link.ExpiresAt, &link.CreatedAt)
+	`, code).Scan(&link.ID, &link.Code, &link.ImageRef, &link.CreatedBy, &link.PasswordHash, &link.MaxUsage, &link.UsageCount, &link.ExpiresAt, &link.NotifyOnUse, &link.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -773,7 +1250,7 @@ func ListShareLinks(userID int64, page, pageSize int) ([]*ShareLink, int, error)
 
 	offset := (page - 1) * pageSize
 	rows, err := db.Query(`
-		SELECT id, code, image_ref, created_by, max_usage, usage_count, expires_at, created_at
+		SELECT id, code, image_ref, created_by, max_usage, usage_count, expires_at, notify_on_use, created_at
 		FROM share_links WHERE created_by = ? ORDER BY created_at DESC LIMIT ? OFFSET ?
 	`, userID, pageSize, offset)
 	if err != nil {
@@ -784,7 +1261,7 @@ func ListShareLinks(userID int64, page, pageSize int) ([]*ShareLink, int, error)
 	var links []*ShareLink
 	for rows.Next() {
 		link := &ShareLink{}
-		err := rows.Scan(&link.ID, &link.Code, &link.ImageRef, &link.CreatedBy, &link.MaxUsage, &link.UsageCount, &link.ExpiresAt, &link.CreatedAt)
+		err := rows.Scan(&link.ID, &link.Code, &link.ImageRef, &link.CreatedBy, &link.MaxUsage, &link.UsageCount, &link.ExpiresAt, &link.NotifyOnUse, &link.CreatedAt)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -799,21 +1276,93 @@ func IncrementShareLinkUsage(code string) error {
 	return err
 }
 
+// ConsumeShareLink counts one use of a share link if it has not expired and
+// is below its usage limit. The check and the increment are one statement,
+// so concurrent requests cannot exceed max_usage. It reports whether the
+// use was counted.
+func ConsumeShareLink(code string, now time.Time) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE share_links SET usage_count = usage_count + 1
+		WHERE code = ?
+			AND (max_usage = 0 OR usage_count < max_usage)
+			AND (expires_at IS NULL OR expires_at > ?)
+	`, code, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
 // DeleteShareLink deletes a share link.
 func DeleteShareLink(id int64) error {
 	_, err := db.Exec(`DELETE FROM share_links WHERE id = ?`, id)
 	return err
 }
 
+// DeleteStaleShareLinks deletes share links that expired before the given
+// time, or used up their usage limit with the last use before it, together
+// with their usage records and pull tokens. It returns the codes of the
+// deleted links.
+func DeleteStaleShareLinks(before time.Time) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+		SELECT code FROM share_links l
+		WHERE (l.expires_at IS NOT NULL AND l.expires_at < ?)
+			OR (l.max_usage > 0 AND l.usage_count >= l.max_usage
+				AND COALESCE((SELECT MAX(u.created_at) FROM share_link_usages u WHERE u.code = l.code), l.created_at) < ?)
+	`, before, before)
+	if err != nil {
+		return nil, err
+	}
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		if _, err := tx.Exec(`DELETE FROM share_link_usages WHERE code = ?`, code); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM share_pull_tokens WHERE code = ?`, code); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(`DELETE FROM share_links WHERE code = ?`, code); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
 // Audit log operations
 
 // CreateAuditLog creates a new audit log entry.
 func CreateAuditLog(log *AuditLog) error {
+	if log.Timestamp.IsZero() {
+		log.Timestamp = time.Now()
+	}
 	detailsJSON, _ := json.Marshal(log.Details)
 	result, err := db.Exec(`
-		INSERT INTO audit_logs (level, event, user_id, username, ip_address, resource, action, status, details, blockchain_hash)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, log.Level, log.Event, log.UserID, log.Username, log.IPAddress, log.Resource, log.Action, log.Status, string(detailsJSON), log.BlockchainHash)
+		INSERT INTO audit_logs (timestamp, level, event, user_id, username, ip_address, resource, action, status, details, blockchain_hash, org_id, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, log.Timestamp, log.Level, log.Event, log.UserID, log.Username, log.IPAddress, log.Resource, log.Action, log.Status, string(detailsJSON), log.BlockchainHash, log.OrgID, log.RequestID)
 	if err != nil {
 		return err
 	}
@@ -822,8 +1371,51 @@ func CreateAuditLog(log *AuditLog) error {
 	return nil
 }
 
-// GetAuditLogs retrieves audit logs with filters.
-func GetAuditLogs(page, pageSize int, eventType string, startDate, endDate time.Time) ([]*AuditLog, int, error) {
+// ListOrgAuditLogs lists the audit logs of an organization, newest first.
+// before is an audit log ID to page from, 0 for the newest entries; events
+// optionally restricts the event types.
+func ListOrgAuditLogs(orgID int64, events []string, before int64, limit int) ([]*AuditLog, error) {
+	query := `SELECT id, timestamp, level, event, user_id, username, ip_address, resource, action, status, details, blockchain_hash, org_id, request_id
+		FROM audit_logs WHERE org_id = ?`
+	args := []interface{}{orgID}
+	if before > 0 {
+		query += ` AND id < ?`
+		args = append(args, before)
+	}
+	if len(events) > 0 {
+		query += ` AND event IN (?` + strings.Repeat(`, ?`, len(events)-1) + `)`
+		for _, e := range events {
+			args = append(args, e)
+		}
+	}
+	query += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*AuditLog
+	for rows.Next() {
+		log := &AuditLog{}
+		var detailsJSON sql.NullString
+		err := rows.Scan(&log.ID, &log.Timestamp, &log.Level, &log.Event, &log.UserID, &log.Username, &log.IPAddress, &log.Resource, &log.Action, &log.Status, &detailsJSON, &log.BlockchainHash, &log.OrgID, &log.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		if detailsJSON.Valid {
+			json.Unmarshal([]byte(detailsJSON.String), &log.Details)
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// GetAuditLogs retrieves audit logs with filters. requestID optionally
+// restricts the logs to those written while handling one request.
+func GetAuditLogs(page, pageSize int, eventType, requestID string, startDate, endDate time.Time) ([]*AuditLog, int, error) {
 	var total int
 	var args []interface{}
 	query := `SELECT COUNT(*) FROM audit_logs WHERE 1=1`
@@ -832,6 +1424,10 @@ func GetAuditLogs(page, pageSize int, eventType string, startDate, endDate time.
 		query += ` AND event = ?`
 		args = append(args, eventType)
 	}
+	if requestID != "" {
+		query += ` AND request_id = ?`
+		args = append(args, requestID)
+	}
 	if !startDate.IsZero() {
 		query += ` AND timestamp >= ?`
 		args = append(args, startDate)
@@ -847,12 +1443,15 @@ func GetAuditLogs(page, pageSize int, eventType string, startDate, endDate time.
 	}
 
 	offset := (page - 1) * pageSize
-	query = `SELECT id, timestamp, level, event, user_id, username, ip_address, resource, action, status, details, blockchain_hash
+	query = `SELECT id, timestamp, level, event, user_id, username, ip_address, resource, action, status, details, blockchain_hash, request_id
 		FROM audit_logs WHERE 1=1`
 
 	if eventType != "" {
 		query += ` AND event = ?`
 	}
+	if requestID != "" {
+		query += ` AND request_id = ?`
+	}
 	if !startDate.IsZero() {
 		query += ` AND timestamp >= ?`
 	}
@@ -872,7 +1471,7 @@ func GetAuditLogs(page, pageSize int, eventType string, startDate, endDate time.
 	for rows.Next() {
 		log := &AuditLog{}
 		var detailsJSON sql.NullString
-		err := rows.Scan(&log.ID, &log.Timestamp, &log.Level, &log.Event, &log.UserID, &log.Username, &log.IPAddress, &log.Resource, &log.Action, &log.Status, &detailsJSON, &log.BlockchainHash)
+		err := rows.Scan(&log.ID, &log.Timestamp, &log.Level, &log.Event, &log.UserID, &log.Username, &log.IPAddress, &log.Resource, &log.Action, &log.Status, &detailsJSON, &log.BlockchainHash, &log.RequestID)
 		if err != nil {
 			return nil, 0, err
 		}
@@ -894,9 +1493,11 @@ type User struct {
 	Email        sql.NullString
 	Role         string
 	IsActive     bool
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	LastLoginAt  sql.NullTime
+	// MustChangePassword is set when an administrator resets the password.
+	MustChangePassword bool
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	LastLoginAt        sql.NullTime
 }
 
 // Session represents a session in the database.
@@ -977,6 +1578,7 @@ type ShareLink struct {
 	MaxUsage     int
 	UsageCount   int
 	ExpiresAt    sql.NullTime
+	NotifyOnUse  bool
 	CreatedAt    time.Time
 }
 
@@ -994,6 +1596,8 @@ type AuditLog struct {
 	Status         string
 	Details        map[string]interface{}
 	BlockchainHash string
+	OrgID          sql.NullInt64
+	RequestID      sql.NullString
 }
 
 // ErrNotFound is returned when a record is not found.
@@ -0,0 +1,221 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Team is a group of organization members.
+type Team struct {
+	ID          int64
+	OrgID       int64
+	Name        string
+	Description string
+	MemberCount int
+	CreatedAt   time.Time
+}
+
+// TeamMember is a user in a team.
+type TeamMember struct {
+	TeamID    int64
+	UserID    int64
+	Username  string
+	CreatedAt time.Time
+}
+
+// TeamRepository grants a team a permission on a repository. Repository is
+// a full repository name or "<org>/*" for every repository of the org.
+type TeamRepository struct {
+	TeamID     int64
+	TeamName   string
+	Repository string
+	Permission string
+	CreatedAt  time.Time
+}
+
+// Team operations
+
+// CreateTeam creates a new team.
+func CreateTeam(team *Team) error {
+	if team.CreatedAt.IsZero() {
+		team.CreatedAt = time.Now()
+	}
+	result, err := db.Exec(`
+		INSERT INTO teams (org_id, name, description, created_at) VALUES (?, ?, ?, ?)
+	`, team.OrgID, team.Name, team.Description, team.CreatedAt)
+	if err != nil {
+		return err
+	}
+	team.ID, _ = result.LastInsertId()
+	return nil
+}
+
+// GetTeam retrieves a team by ID.
+func GetTeam(id int64) (*Team, error) {
+	team := &Team{}
+	var description sql.NullString
+	err := db.QueryRow(`
+		SELECT t.id, t.org_id, t.name, t.description, t.created_at,
+			(SELECT COUNT(*) FROM team_members m WHERE m.team_id = t.id)
+		FROM teams t WHERE t.id = ?
+	`, id).Scan(&team.ID, &team.OrgID, &team.Name, &description, &team.CreatedAt, &team.MemberCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	team.Description = description.String
+	return team, nil
+}
+
+// ListTeams lists the teams of an organization.
+func ListTeams(orgID int64) ([]*Team, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.org_id, t.name, t.description, t.created_at,
+			(SELECT COUNT(*) FROM team_members m WHERE m.team_id = t.id)
+		FROM teams t WHERE t.org_id = ? ORDER BY t.name
+	`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var teams []*Team
+	for rows.Next() {
+		team := &Team{}
+		var description sql.NullString
+		if err := rows.Scan(&team.ID, &team.OrgID, &team.Name, &description, &team.CreatedAt, &team.MemberCount); err != nil {
+			return nil, err
+		}
+		team.Description = description.String
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+// UpdateTeam updates the name and description of a team.
+func UpdateTeam(team *Team) error {
+	_, err := db.Exec(`UPDATE teams SET name = ?, description = ? WHERE id = ?`,
+		team.Name, team.Description, team.ID)
+	return err
+}
+
+// DeleteTeam deletes a team with its members and repository grants.
+func DeleteTeam(id int64) error {
+	if _, err := db.Exec(`DELETE FROM team_members WHERE team_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`DELETE FROM team_repositories WHERE team_id = ?`, id); err != nil {
+		return err
+	}
+	_, err := db.Exec(`DELETE FROM teams WHERE id = ?`, id)
+	return err
+}
+
+// deleteOrgTeams deletes all teams of an organization.
+func deleteOrgTeams(orgID int64) error {
+	teams, err := ListTeams(orgID)
+	if err != nil {
+		return err
+	}
+	for _, team := range teams {
+		if err := DeleteTeam(team.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddTeamMember adds a user to a team.
+func AddTeamMember(teamID, userID int64) error {
+	_, err := db.Exec(`INSERT OR IGNORE INTO team_members (team_id, user_id) VALUES (?, ?)`, teamID, userID)
+	return err
+}
+
+// RemoveTeamMember removes a user from a team.
+func RemoveTeamMember(teamID, userID int64) error {
+	_, err := db.Exec(`DELETE FROM team_members WHERE team_id = ? AND user_id = ?`, teamID, userID)
+	return err
+}
+
+// GetTeamMembers retrieves the members of a team.
+func GetTeamMembers(teamID int64) ([]*TeamMember, error) {
+	rows, err := db.Query(`
+		SELECT m.team_id, m.user_id, u.username, m.created_at
+		FROM team_members m
+		JOIN users u ON m.user_id = u.id
+		WHERE m.team_id = ? ORDER BY u.username
+	`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []*TeamMember
+	for rows.Next() {
+		m := &TeamMember{}
+		if err := rows.Scan(&m.TeamID, &m.UserID, &m.Username, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// SetTeamRepository grants or updates a team's permission on a repository.
+func SetTeamRepository(teamID int64, repository, permission string) error {
+	_, err := db.Exec(`
+		INSERT INTO team_repositories (team_id, repository, permission) VALUES (?, ?, ?)
+		ON CONFLICT(team_id, repository) DO UPDATE SET permission = excluded.permission
+	`, teamID, repository, permission)
+	return err
+}
+
+// RemoveTeamRepository revokes a team's permission on a repository.
+func RemoveTeamRepository(teamID int64, repository string) error {
+	_, err := db.Exec(`DELETE FROM team_repositories WHERE team_id = ? AND repository = ?`, teamID, repository)
+	return err
+}
+
+// GetTeamRepositories lists the repository grants of a team.
+func GetTeamRepositories(teamID int64) ([]*TeamRepository, error) {
+	return queryTeamRepositories(`
+		SELECT r.team_id, t.name, r.repository, r.permission, r.created_at
+		FROM team_repositories r
+		JOIN teams t ON r.team_id = t.id
+		WHERE r.team_id = ? ORDER BY r.repository
+	`, teamID)
+}
+
+// ListUserTeamRepositories lists the repository grants a user gets through
+// the teams of an organization.
+func ListUserTeamRepositories(orgID, userID int64) ([]*TeamRepository, error) {
+	return queryTeamRepositories(`
+		SELECT r.team_id, t.name, r.repository, r.permission, r.created_at
+		FROM team_repositories r
+		JOIN teams t ON r.team_id = t.id
+		JOIN team_members m ON m.team_id = t.id
+		WHERE t.org_id = ? AND m.user_id = ?
+		ORDER BY r.repository
+	`, orgID, userID)
+}
+
+func queryTeamRepositories(query string, args ...interface{}) ([]*TeamRepository, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []*TeamRepository
+	for rows.Next() {
+		g := &TeamRepository{}
+		if err := rows.Scan(&g.TeamID, &g.TeamName, &g.Repository, &g.Permission, &g.CreatedAt); err != nil {
+			return nil, err
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
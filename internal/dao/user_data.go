@@ -0,0 +1,179 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// UserErasure counts the records whose personal data was removed.
+type UserErasure struct {
+	AuditLogs      int64
+	AccessAttempts int64
+	RegistryEvents int64
+	Other          int64 // 转移、晋级等记录中的用户名
+}
+
+// User data operations
+
+// ListUserAuditLogs lists the audit logs written by a user, matched by ID
+// or by name for events logged before login, newest first.
+func ListUserAuditLogs(userID int64, username string, limit int) ([]*AuditLog, error) {
+	rows, err := db.Query(`
+		SELECT id, timestamp, level, event, user_id, username, ip_address, resource, action, status, details, blockchain_hash, request_id
+		FROM audit_logs WHERE user_id = ? OR (username = ? AND username != '')
+		ORDER BY id DESC LIMIT ?
+	`, userID, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var logs []*AuditLog
+	for rows.Next() {
+		log := &AuditLog{}
+		var detailsJSON sql.NullString
+		err := rows.Scan(&log.ID, &log.Timestamp, &log.Level, &log.Event, &log.UserID, &log.Username, &log.IPAddress, &log.Resource, &log.Action, &log.Status, &detailsJSON, &log.BlockchainHash, &log.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		if detailsJSON.Valid {
+			json.Unmarshal([]byte(detailsJSON.String), &log.Details)
+		}
+		logs = append(logs, log)
+	}
+	return logs, rows.Err()
+}
+
+// ListUserAccessAttempts lists the access attempts of a user, including
+// failed logins with the user's name, newest first.
+func ListUserAccessAttempts(userID int64, username string, limit int) ([]*AccessAttempt, error) {
+	rows, err := db.Query(`
+		SELECT id, ip_address, user_agent, user_id, action, resource, status, error_msg, blockchain_hash, created_at
+		FROM access_attempts WHERE user_id = ? OR (action = 'login' AND resource = ? AND resource != '')
+		ORDER BY id DESC LIMIT ?
+	`, userID, username, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*AccessAttempt
+	for rows.Next() {
+		a := &AccessAttempt{}
+		var ua, errMsg, hash sql.NullString
+		if err := rows.Scan(&a.ID, &a.IPAddress, &ua, &a.UserID, &a.Action, &a.Resource, &a.Status, &errMsg, &hash, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.UserAgent, a.ErrorMsg, a.BlockchainHash = ua.String, errMsg.String, hash.String
+		attempts = append(attempts, a)
+	}
+	return attempts, rows.Err()
+}
+
+// ListUserSessions lists the sessions of a user that have not expired.
+func ListUserSessions(userID int64) ([]*Session, error) {
+	rows, err := db.Query(`
+		SELECT id, user_id, ip, user_agent, created_at, expires_at
+		FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY created_at DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := &Session{}
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IP, &s.UserAgent, &s.CreatedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// EraseUserRecords replaces the name of a user with pseudonym and the IP
+// addresses of the user's records with redactIP, in one transaction.
+// Audit logs and access attempts keep their hash and are marked redacted,
+// the hash no longer follows from the changed fields.
+func EraseUserRecords(userID int64, username, pseudonym string, redactIP func(string) string) (*UserErasure, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	erasure := &UserErasure{}
+	tables := []struct {
+		count  *int64
+		query  string
+		args   []interface{}
+		update string
+	}{
+		{
+			&erasure.AuditLogs,
+			`SELECT id, ip_address FROM audit_logs WHERE user_id = ? OR (username = ? AND username != '')`,
+			[]interface{}{userID, username},
+			`UPDATE audit_logs SET username = ?, ip_address = ?, redacted = 1 WHERE id = ?`,
+		},
+		{
+			&erasure.AccessAttempts,
+			`SELECT id, ip_address FROM access_attempts WHERE user_id = ? OR (action = 'login' AND resource = ? AND resource != '')`,
+			[]interface{}{userID, username},
+			`UPDATE access_attempts SET resource = CASE WHEN action = 'login' THEN ? ELSE resource END, ip_address = ?, user_agent = '', redacted = 1 WHERE id = ?`,
+		},
+		{
+			&erasure.RegistryEvents,
+			`SELECT id, client_ip FROM registry_events WHERE username = ? AND username != ''`,
+			[]interface{}{username},
+			`UPDATE registry_events SET username = ?, client_ip = ? WHERE id = ?`,
+		},
+	}
+	for _, t := range tables {
+		rows, err := tx.Query(t.query, t.args...)
+		if err != nil {
+			return nil, err
+		}
+		ips := make(map[int64]string)
+		for rows.Next() {
+			var id int64
+			var ip sql.NullString
+			if err := rows.Scan(&id, &ip); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			ips[id] = ip.String
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		for id, ip := range ips {
+			if _, err := tx.Exec(t.update, pseudonym, redactIP(ip), id); err != nil {
+				return nil, err
+			}
+		}
+		*t.count = int64(len(ips))
+	}
+
+	// 其他记录中的用户名：管理员操作的目标、转移和晋级的申请人与审批人
+	if username != "" {
+		for _, query := range []string{
+			`UPDATE audit_logs SET resource = ? WHERE event = 'user_admin' AND resource = ?`,
+			`UPDATE repository_transfers SET requested_by = ? WHERE requested_by = ?`,
+			`UPDATE repository_transfers SET resolved_by = ? WHERE resolved_by = ?`,
+			`UPDATE promotions SET requested_by = ? WHERE requested_by = ?`,
+			`UPDATE promotions SET resolved_by = ? WHERE resolved_by = ?`,
+		} {
+			result, err := tx.Exec(query, pseudonym, username)
+			if err != nil {
+				return nil, err
+			}
+			n, _ := result.RowsAffected()
+			erasure.Other += n
+		}
+	}
+	return erasure, tx.Commit()
+}
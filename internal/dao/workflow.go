@@ -0,0 +1,143 @@
+// Package dao provides data access operations for SQLite database.
+package dao
+
+import (
+	"database/sql"
+	"time"
+)
+
+// WorkflowRecord represents a persisted workflow. The workflow definition
+// is stored as JSON in Data.
+type WorkflowRecord struct {
+	ID        string
+	Name      string
+	Enabled   bool
+	Data      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WorkflowJobRecord represents a persisted workflow job.
+type WorkflowJobRecord struct {
+	ID          string
+	WorkflowID  string
+	Status      string
+	Data        string
+	StartedAt   time.Time
+	CompletedAt sql.NullTime
+}
+
+// Workflow operations
+
+// SaveWorkflow inserts or updates a workflow.
+func SaveWorkflow(w *WorkflowRecord) error {
+	_, err := db.Exec(`
+		INSERT INTO workflows (id, name, enabled, data, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			name = excluded.name, enabled = excluded.enabled,
+			data = excluded.data, updated_at = excluded.updated_at
+	`, w.ID, w.Name, w.Enabled, w.Data, w.CreatedAt, w.UpdatedAt)
+	return err
+}
+
+// ListWorkflowRecords lists all persisted workflows.
+func ListWorkflowRecords() ([]*WorkflowRecord, error) {
+	rows, err := db.Query(`SELECT id, name, enabled, data, created_at, updated_at FROM workflows ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*WorkflowRecord
+	for rows.Next() {
+		w := &WorkflowRecord{}
+		if err := rows.Scan(&w.ID, &w.Name, &w.Enabled, &w.Data, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, w)
+	}
+	return records, rows.Err()
+}
+
+// DeleteWorkflowRecord deletes a workflow. Its jobs are kept as history
+// until removed by job retention.
+func DeleteWorkflowRecord(id string) error {
+	_, err := db.Exec(`DELETE FROM workflows WHERE id = ?`, id)
+	return err
+}
+
+// Workflow job operations
+
+// SaveWorkflowJob inserts or updates a workflow job.
+func SaveWorkflowJob(j *WorkflowJobRecord) error {
+	_, err := db.Exec(`
+		INSERT INTO workflow_jobs (id, workflow_id, status, data, started_at, completed_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			status = excluded.status, data = excluded.data, completed_at = excluded.completed_at
+	`, j.ID, j.WorkflowID, j.Status, j.Data, j.StartedAt, j.CompletedAt)
+	return err
+}
+
+// ListWorkflowJobRecords lists the most recent jobs, newest first.
+func ListWorkflowJobRecords(limit int) ([]*WorkflowJobRecord, error) {
+	rows, err := db.Query(`
+		SELECT id, workflow_id, status, data, started_at, completed_at
+		FROM workflow_jobs ORDER BY started_at DESC LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*WorkflowJobRecord
+	for rows.Next() {
+		j := &WorkflowJobRecord{}
+		if err := rows.Scan(&j.ID, &j.WorkflowID, &j.Status, &j.Data, &j.StartedAt, &j.CompletedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, j)
+	}
+	return records, rows.Err()
+}
+
+// DeleteWorkflowJobs deletes finished jobs that started before cutoff, and
+// finished jobs beyond the newest keep jobs of each workflow. Running jobs
+// are never deleted. It returns the IDs of deleted jobs.
+func DeleteWorkflowJobs(cutoff time.Time, keep int) ([]string, error) {
+	query := `
+		SELECT id FROM (
+			SELECT id, started_at,
+				ROW_NUMBER() OVER (PARTITION BY workflow_id ORDER BY started_at DESC) AS rn
+			FROM workflow_jobs WHERE status NOT IN ('pending', 'running')
+		) WHERE (started_at < ?`
+	args := []interface{}{cutoff}
+	if keep > 0 {
+		query += ` OR rn > ?`
+		args = append(args, keep)
+	}
+	query += `)`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := db.Exec(`DELETE FROM workflow_jobs WHERE id = ?`, id); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
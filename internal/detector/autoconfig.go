@@ -0,0 +1,166 @@
+// Package detector provides host system detection functionality.
+package detector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"cyp-docker-registry/internal/common"
+)
+
+// AutoConfig holds the settings picked for the environment on first boot.
+// They only apply to keys the configuration file does not set.
+type AutoConfig struct {
+	MaxCacheSize        string   `json:"max_cache_size,omitempty"` // storage.max_cache_size
+	SyncParallel        int      `json:"sync_parallel"`            // sync.parallel
+	P2PMaxConnections   int      `json:"p2p_max_connections"`      // p2p.max_connections
+	P2PEnableMDNS       bool     `json:"p2p_enable_mdns"`          // p2p.enable_mdns
+	P2PEnableNATPortMap bool     `json:"p2p_enable_nat_port_map"`  // p2p.enable_nat_port_map
+	P2PEnableRelay      bool     `json:"p2p_enable_relay"`         // p2p.enable_relay
+	Reasons             []string `json:"reasons,omitempty"`
+	Applied             []string `json:"applied,omitempty"` // 本次启动实际生效的配置项
+}
+
+const gb = int64(1) << 30
+
+// Recommend picks the settings for an environment:
+//   - the layer cache gets a quarter of the free space of its volume,
+//     between 1GB and 500GB
+//   - sync pushes as many layers at once as there are effective CPUs,
+//     between 2 and 8, and 2 under 1GB of memory
+//   - P2P connections scale with memory and the pids limit
+//   - in a container mDNS and UPnP are off, since multicast and port
+//     mapping rarely reach the LAN from a container network
+//   - relays stay on unless the host is reachable over public IPv6
+func Recommend(report *EnvironmentReport) *AutoConfig {
+	auto := &AutoConfig{P2PEnableMDNS: true, P2PEnableNATPortMap: true, P2PEnableRelay: true}
+
+	if free := report.Disk.Free; free > 0 {
+		size := min(max(free/4/gb, 1), 500)
+		auto.MaxCacheSize = fmt.Sprintf("%dGB", size)
+		auto.Reasons = append(auto.Reasons, fmt.Sprintf("缓存卷剩余 %dGB，缓存上限取其四分之一", free/gb))
+	}
+
+	mem := report.Memory.Effective
+	cpus := int(math.Ceil(report.CPU.Effective))
+	auto.SyncParallel = min(max(cpus, 2), 8)
+	if mem > 0 && mem < gb {
+		auto.SyncParallel = 2
+		auto.Reasons = append(auto.Reasons, "可用内存不足 1GB，同步并发数取 2")
+	} else {
+		auto.Reasons = append(auto.Reasons, fmt.Sprintf("可用 CPU %.1f 核，同步并发数取 %d", report.CPU.Effective, auto.SyncParallel))
+	}
+
+	switch {
+	case mem > 0 && mem < gb:
+		auto.P2PMaxConnections = 20
+	case mem > 0 && mem < 4*gb:
+		auto.P2PMaxConnections = 50
+	case mem > 0 && mem < 16*gb:
+		auto.P2PMaxConnections = 100
+	case mem > 0:
+		auto.P2PMaxConnections = 200
+	default:
+		auto.P2PMaxConnections = 50
+	}
+	if pids := report.Cgroup.PidsLimit; pids > 0 && pids < 512 {
+		auto.P2PMaxConnections = min(auto.P2PMaxConnections, 20)
+		auto.Reasons = append(auto.Reasons, fmt.Sprintf("进程数限制为 %d，P2P 连接数取 20", pids))
+	}
+
+	if report.Container.InContainer {
+		auto.P2PEnableMDNS = false
+		auto.P2PEnableNATPortMap = false
+		auto.Reasons = append(auto.Reasons, "运行在容器中，关闭 P2P 的 mDNS 发现和 UPnP 端口映射")
+	}
+	if report.IPv6.GlobalAddress && report.IPv6.Reachable {
+		auto.P2PEnableRelay = false
+		auto.Reasons = append(auto.Reasons, "主机有可达的公网 IPv6 地址，关闭 P2P 中继")
+	}
+	return auto
+}
+
+// Apply sets the picked values on cfg for the keys the configuration file
+// does not set, and returns those keys.
+func (a *AutoConfig) Apply(cfg *common.Config) []string {
+	var applied []string
+	set := func(key string, apply func()) {
+		if !cfg.InFile(key) {
+			apply()
+			applied = append(applied, key)
+		}
+	}
+
+	if a.MaxCacheSize != "" {
+		set("storage.max_cache_size", func() { cfg.Storage.MaxCacheSize = a.MaxCacheSize })
+	}
+	if a.SyncParallel > 0 {
+		set("sync.parallel", func() { cfg.Sync.Parallel = a.SyncParallel })
+	}
+	if cfg.P2P != nil {
+		if a.P2PMaxConnections > 0 {
+			set("p2p.max_connections", func() { cfg.P2P.MaxConnections = a.P2PMaxConnections })
+		}
+		set("p2p.enable_mdns", func() { cfg.P2P.EnableMDNS = a.P2PEnableMDNS })
+		set("p2p.enable_nat_port_map", func() { cfg.P2P.EnableNATPortMap = a.P2PEnableNATPortMap })
+		set("p2p.enable_relay", func() { cfg.P2P.EnableRelay = a.P2PEnableRelay })
+	}
+	return applied
+}
+
+// environmentFile is the file in the data directory holding the report of
+// the first boot and the settings picked from it.
+const environmentFile = "environment.json"
+
+// AutoConfigure returns the settings picked for the environment on first
+// boot. The first boot's report is saved to the data directory and its
+// settings read back on later boots, so they do not drift with the load of
+// the host. When the data directory predates auto-configuration firstBoot
+// is false, the report is saved without settings and nil is returned, so
+// an upgrade keeps the settings in use.
+func (d *DetectorService) AutoConfigure(ctx context.Context, dataDir string, firstBoot bool) (*AutoConfig, error) {
+	path := filepath.Join(dataDir, environmentFile)
+	if data, err := os.ReadFile(path); err == nil {
+		var saved EnvironmentReport
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", path, err)
+		}
+		return saved.AutoConfig, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	report, err := d.DetectEnvironment(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if firstBoot {
+		report.AutoConfig = Recommend(report)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+	return report.AutoConfig, nil
+}
+
+// SetAutoConfig records the settings in effect, shown in environment
+// reports.
+func (d *DetectorService) SetAutoConfig(auto *AutoConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.autoConfig = auto
+	if d.environment != nil {
+		d.environment.AutoConfig = auto
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+// Package detector provides host system detection functionality.
+package detector
+
+import "syscall"
+
+// diskSpace returns the total and free bytes of the filesystem holding path.
+func diskSpace(path string) (total, free int64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, 0
+	}
+	return int64(st.Blocks) * int64(st.Bsize), int64(st.Bavail) * int64(st.Bsize)
+}
@@ -0,0 +1,424 @@
+// Package detector provides host system detection functionality.
+package detector
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvironmentReport describes the resources and network of the host the
+// registry runs on.
+type EnvironmentReport struct {
+	System     *SystemInfo     `json:"system"`
+	CPU        CPUInfo         `json:"cpu"`
+	Memory     MemoryInfo      `json:"memory"`
+	Disk       DiskInfo        `json:"disk"`
+	Container  ContainerInfo   `json:"container"`
+	Cgroup     CgroupInfo      `json:"cgroup"`
+	IPv6       IPv6Info        `json:"ipv6"`
+	Registries []RegistryProbe `json:"registries"`
+	AutoConfig *AutoConfig     `json:"auto_config,omitempty"`
+	DetectedAt time.Time       `json:"detected_at"`
+}
+
+// CPUInfo describes the processors. Effective is the number of CPUs the
+// cgroup quota allows, Cores when there is no quota.
+type CPUInfo struct {
+	Model     string  `json:"model,omitempty"`
+	Cores     int     `json:"cores"`
+	Effective float64 `json:"effective"`
+}
+
+// MemoryInfo describes the memory in bytes. Effective is the lower of the
+// total and the cgroup limit.
+type MemoryInfo struct {
+	Total     int64 `json:"total"`
+	Available int64 `json:"available"`
+	Limit     int64 `json:"limit,omitempty"` // 0 表示没有限制
+	Effective int64 `json:"effective"`
+}
+
+// DiskInfo describes the volume holding the layer cache.
+type DiskInfo struct {
+	Path  string `json:"path"`
+	Total int64  `json:"total"`
+	Free  int64  `json:"free"`
+}
+
+// ContainerInfo tells whether the registry runs in a container and which
+// runtime started it.
+type ContainerInfo struct {
+	InContainer  bool   `json:"in_container"`
+	Runtime      string `json:"runtime,omitempty"`      // docker, podman, containerd, lxc
+	Orchestrator string `json:"orchestrator,omitempty"` // kubernetes
+}
+
+// CgroupInfo holds the resource limits of the cgroup of the process. Zero
+// means unlimited.
+type CgroupInfo struct {
+	Version     int     `json:"version"` // 1 或 2，0 表示未检测到
+	CPUQuota    float64 `json:"cpu_quota,omitempty"`
+	MemoryLimit int64   `json:"memory_limit,omitempty"`
+	PidsLimit   int64   `json:"pids_limit,omitempty"`
+}
+
+// IPv6Info tells whether IPv6 is usable: enabled in the kernel, with a
+// global address, and able to reach the internet.
+type IPv6Info struct {
+	Enabled       bool     `json:"enabled"`
+	GlobalAddress bool     `json:"global_address"`
+	Addresses     []string `json:"addresses,omitempty"`
+	Reachable     bool     `json:"reachable"`
+}
+
+// RegistryProbe is the result of reaching the API of a registry.
+type RegistryProbe struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMs int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// probeRegistries are the registries whose reachability is reported. Any
+// HTTP answer, including 401, means the registry is reachable.
+var probeRegistries = []RegistryProbe{
+	{Name: "Docker Hub", URL: "https://registry-1.docker.io/v2/"},
+	{Name: "GitHub", URL: "https://ghcr.io/v2/"},
+	{Name: "Quay", URL: "https://quay.io/v2/"},
+	{Name: "Google", URL: "https://gcr.io/v2/"},
+	{Name: "Kubernetes", URL: "https://registry.k8s.io/v2/"},
+	{Name: "阿里云", URL: "https://registry.cn-hangzhou.aliyuncs.com/v2/"},
+}
+
+// probeTimeout bounds each network probe.
+const probeTimeout = 5 * time.Second
+
+// SetDiskPath sets the directory whose volume is reported, usually the
+// layer cache.
+func (d *DetectorService) SetDiskPath(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.diskPath = path
+}
+
+// DetectEnvironment collects the environment report. The network probes
+// run in parallel, each bounded by probeTimeout.
+func (d *DetectorService) DetectEnvironment(ctx context.Context) (*EnvironmentReport, error) {
+	info, err := d.GetSystemInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &EnvironmentReport{
+		System:     info,
+		Cgroup:     detectCgroup(),
+		Container:  detectContainer(),
+		DetectedAt: time.Now(),
+	}
+
+	report.CPU = CPUInfo{Model: cpuModel(), Cores: runtime.NumCPU(), Effective: float64(runtime.NumCPU())}
+	if q := report.Cgroup.CPUQuota; q > 0 && q < report.CPU.Effective {
+		report.CPU.Effective = q
+	}
+
+	report.Memory = readMeminfo()
+	report.Memory.Limit = report.Cgroup.MemoryLimit
+	report.Memory.Effective = report.Memory.Total
+	if report.Memory.Effective == 0 {
+		report.Memory.Effective = info.MemoryTotal
+	}
+	if l := report.Memory.Limit; l > 0 && (report.Memory.Effective == 0 || l < report.Memory.Effective) {
+		report.Memory.Effective = l
+	}
+
+	d.mu.RLock()
+	diskPath := d.diskPath
+	d.mu.RUnlock()
+	report.Disk = diskInfo(diskPath)
+
+	var wg sync.WaitGroup
+	report.Registries = make([]RegistryProbe, len(probeRegistries))
+	for i, probe := range probeRegistries {
+		wg.Add(1)
+		go func(i int, probe RegistryProbe) {
+			defer wg.Done()
+			report.Registries[i] = probeRegistry(ctx, probe)
+		}(i, probe)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		report.IPv6 = detectIPv6(ctx)
+	}()
+	wg.Wait()
+
+	d.mu.Lock()
+	report.AutoConfig = d.autoConfig
+	d.environment = report
+	d.mu.Unlock()
+	return report, nil
+}
+
+// GetEnvironment returns the last environment report, nil before the first
+// detection.
+func (d *DetectorService) GetEnvironment() *EnvironmentReport {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.environment
+}
+
+// cpuModel returns the model name of the first processor.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		// x86 reports "model name", ARM "Model" or "Hardware"
+		switch strings.TrimSpace(key) {
+		case "model name", "Model", "Hardware":
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// readMeminfo reads the total and available memory of the host.
+func readMeminfo() MemoryInfo {
+	var mem MemoryInfo
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return mem
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			mem.Total = parseNumber(fields[1]) * 1024
+		case "MemAvailable:":
+			mem.Available = parseNumber(fields[1]) * 1024
+		}
+	}
+	return mem
+}
+
+// diskInfo returns the space of the volume holding path, or of its closest
+// existing parent when it is not created yet.
+func diskInfo(path string) DiskInfo {
+	if path == "" {
+		path = "./data"
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil {
+			total, free := diskSpace(dir)
+			return DiskInfo{Path: abs, Total: total, Free: free}
+		}
+		if filepath.Dir(dir) == dir {
+			return DiskInfo{Path: abs}
+		}
+	}
+}
+
+// unlimitedMemory is the value cgroup v1 reports for no memory limit,
+// rounded down to the page size.
+const unlimitedMemory = 1 << 62
+
+// detectCgroup reads the limits of the cgroup of the process.
+func detectCgroup() CgroupInfo {
+	var info CgroupInfo
+	if runtime.GOOS != "linux" {
+		return info
+	}
+
+	// cgroup v2 has a single hierarchy with cgroup.controllers at its root
+	if _, err := os.Stat("/sys/fs/cgroup/cgroup.controllers"); err == nil {
+		info.Version = 2
+		dir := filepath.Join("/sys/fs/cgroup", cgroupPath("0"))
+		// Without a cgroup namespace the process sees the whole hierarchy;
+		// fall back to the root when its own directory is not mounted
+		if _, err := os.Stat(filepath.Join(dir, "cgroup.controllers")); err != nil {
+			dir = "/sys/fs/cgroup"
+		}
+		if fields := strings.Fields(readTrimmed(filepath.Join(dir, "cpu.max"))); len(fields) == 2 && fields[0] != "max" {
+			if period := parseNumber(fields[1]); period > 0 {
+				info.CPUQuota = float64(parseNumber(fields[0])) / float64(period)
+			}
+		}
+		if s := readTrimmed(filepath.Join(dir, "memory.max")); s != "" && s != "max" {
+			info.MemoryLimit = parseNumber(s)
+		}
+		if s := readTrimmed(filepath.Join(dir, "pids.max")); s != "" && s != "max" {
+			info.PidsLimit = parseNumber(s)
+		}
+		return info
+	}
+
+	if _, err := os.Stat("/sys/fs/cgroup/memory"); err != nil {
+		return info
+	}
+	info.Version = 1
+	quota := readTrimmed("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	if period := parseNumber(readTrimmed("/sys/fs/cgroup/cpu/cpu.cfs_period_us")); period > 0 && quota != "" && quota != "-1" {
+		info.CPUQuota = float64(parseNumber(quota)) / float64(period)
+	}
+	if limit := parseNumber(readTrimmed("/sys/fs/cgroup/memory/memory.limit_in_bytes")); limit > 0 && limit < unlimitedMemory {
+		info.MemoryLimit = limit
+	}
+	if s := readTrimmed("/sys/fs/cgroup/pids/pids.max"); s != "" && s != "max" {
+		info.PidsLimit = parseNumber(s)
+	}
+	return info
+}
+
+// cgroupPath returns the path of the process in the cgroup hierarchy with
+// the given ID, "0" for cgroup v2.
+func cgroupPath(id string) string {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "/"
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) == 3 && parts[0] == id {
+			return parts[2]
+		}
+	}
+	return "/"
+}
+
+// readTrimmed returns the content of a small file, "" when it cannot be
+// read.
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// detectContainer tells whether the process runs in a container, from the
+// marker files runtimes create and the cgroups of PID 1.
+func detectContainer() ContainerInfo {
+	var info ContainerInfo
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		info.InContainer = true
+		info.Orchestrator = "kubernetes"
+	}
+
+	switch {
+	case fileExists("/.dockerenv"):
+		info.Runtime = "docker"
+	case fileExists("/run/.containerenv"):
+		info.Runtime = "podman"
+	default:
+		// systemd-nspawn, podman and lxc set the container variable of PID 1
+		if env := os.Getenv("container"); env != "" {
+			info.Runtime = env
+		}
+	}
+
+	cgroups := readTrimmed("/proc/1/cgroup")
+	switch {
+	case info.Runtime != "":
+	case strings.Contains(cgroups, "docker"):
+		info.Runtime = "docker"
+	case strings.Contains(cgroups, "libpod"):
+		info.Runtime = "podman"
+	case strings.Contains(cgroups, "containerd") || strings.Contains(cgroups, "cri-containerd"):
+		info.Runtime = "containerd"
+	case strings.Contains(cgroups, "lxc"):
+		info.Runtime = "lxc"
+	}
+	if info.Orchestrator == "" && strings.Contains(cgroups, "kubepods") {
+		info.Orchestrator = "kubernetes"
+	}
+	if info.Runtime != "" || info.Orchestrator != "" {
+		info.InContainer = true
+	}
+	return info
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// detectIPv6 lists the IPv6 addresses of the interfaces and checks whether
+// the internet can be reached over IPv6.
+func detectIPv6(ctx context.Context) IPv6Info {
+	var info IPv6Info
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return info
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil || ipnet.IP.To16() == nil {
+			continue
+		}
+		info.Enabled = true
+		if ipnet.IP.IsGlobalUnicast() && !ipnet.IP.IsPrivate() {
+			info.GlobalAddress = true
+			info.Addresses = append(info.Addresses, ipnet.IP.String())
+		}
+	}
+	if !info.GlobalAddress {
+		return info
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	if conn, err := dialer.DialContext(ctx, "tcp6", "registry-1.docker.io:443"); err == nil {
+		conn.Close()
+		info.Reachable = true
+	}
+	return info
+}
+
+// probeRegistry requests the API root of a registry.
+func probeRegistry(ctx context.Context, probe RegistryProbe) RegistryProbe {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probe.URL, nil)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	resp.Body.Close()
+	probe.Reachable = true
+	probe.Status = resp.StatusCode
+	probe.LatencyMs = time.Since(start).Milliseconds()
+	return probe
+}
@@ -26,6 +26,13 @@ func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
 	group.GET("/refresh", h.refreshSystemInfo)
 }
 
+// RegisterEnvironmentRoutes registers the environment report. It shows host
+// addresses and paths, and a refresh probes external registries, so the
+// caller mounts it behind an administrator check.
+func (h *Handler) RegisterEnvironmentRoutes(group *gin.RouterGroup) {
+	group.GET("/environment", h.getEnvironment)
+}
+
 // getSystemInfo handles GET /api/system/info
 func (h *Handler) getSystemInfo(c *gin.Context) {
 	// Try to return cached info first for faster response
@@ -74,3 +81,25 @@ func (h *Handler) refreshSystemInfo(c *gin.Context) {
 		"info":    info,
 	})
 }
+
+// getEnvironment handles GET /api/system/environment
+// Returns the environment report and the settings picked on first boot.
+// The report is detected on the first request; refresh=true detects again.
+func (h *Handler) getEnvironment(c *gin.Context) {
+	if c.Query("refresh") != "true" {
+		if cached := h.service.GetEnvironment(); cached != nil {
+			common.SuccessResponse(c, cached)
+			return
+		}
+	}
+
+	report, err := h.service.DetectEnvironment(c.Request.Context())
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInternalError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, report)
+}
@@ -44,8 +44,11 @@ type CompatibilityErr struct {
 
 // DetectorService provides system detection functionality.
 type DetectorService struct {
-	mu         sync.RWMutex
-	cachedInfo *SystemInfo
+	mu          sync.RWMutex
+	cachedInfo  *SystemInfo
+	environment *EnvironmentReport
+	autoConfig  *AutoConfig
+	diskPath    string
 }
 
 // NewDetectorService creates a new detector service.
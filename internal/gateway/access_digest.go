@@ -0,0 +1,33 @@
+package gateway
+
+import (
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/handler"
+	"cyp-docker-registry/internal/service"
+)
+
+// initAccessDigest schedules the daily digest of access attempts, mailed to
+// the subscribed administrators and published on the audit topic of the
+// web console.
+func (r *Router) initAccessDigest() {
+	r.digestService = service.NewAccessDigestService(accessDigestConfig(r.config.Notify.AccessDigest), logger)
+	if r.wsHandler != nil {
+		r.digestService.SetPublisher(func(event string, data map[string]interface{}) {
+			r.wsHandler.Publish(handler.TopicAudit, event, data)
+		})
+	}
+	r.applyMailer(r.config.Notify.Channels.Email)
+	r.automationEngine.SetDigestSender(r.digestService)
+	r.digestHandler = handler.NewAccessDigestHandler(r.digestService, r.auditService)
+}
+
+// accessDigestConfig converts the access digest section to service
+// settings.
+func accessDigestConfig(cfg common.AccessDigestConfig) service.AccessDigestConfig {
+	return service.AccessDigestConfig{
+		Enabled:        cfg.Enabled,
+		Hour:           cfg.Hour,
+		SpikeThreshold: cfg.SpikeThreshold,
+		SpikeFactor:    cfg.SpikeFactor,
+	}
+}
@@ -0,0 +1,1009 @@
+package gateway
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/accelerator"
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/internal/registry"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// applyMaxBody bounds the size of an apply document.
+const applyMaxBody = 1 << 20
+
+// retentionWorkflowPrefix is the name prefix of the workflows that
+// implement the retention policies of apply documents.
+const retentionWorkflowPrefix = "retention:"
+
+// Kinds of resources managed by apply.
+const (
+	applyKindUpstream  = "upstream"
+	applyKindSyncRule  = "sync_rule"
+	applyKindRetention = "retention_policy"
+	applyKindUser      = "user"
+	applyKindOrg       = "org"
+)
+
+// ApplyDocument is the desired state accepted by POST /api/v1/admin/apply.
+// Only the sections present in the document are managed; resources are
+// matched by name.
+type ApplyDocument struct {
+	Upstreams         []ApplyUpstream  `json:"upstreams" yaml:"upstreams"`
+	SyncRules         []ApplySyncRule  `json:"sync_rules" yaml:"sync_rules"`
+	RetentionPolicies []ApplyRetention `json:"retention_policies" yaml:"retention_policies"`
+	Users             []ApplyUser      `json:"users" yaml:"users"`
+	Orgs              []ApplyOrg       `json:"orgs" yaml:"orgs"`
+
+	present map[string]bool // 文档中出现的配置节，包括空列表
+}
+
+// ApplyUpstream is an accelerator upstream.
+type ApplyUpstream struct {
+	Name         string         `json:"name" yaml:"name"`
+	URL          string         `json:"url" yaml:"url"`
+	Priority     int            `json:"priority" yaml:"priority"`
+	Enabled      *bool          `json:"enabled" yaml:"enabled"` // 默认 true
+	Type         string         `json:"type" yaml:"type"`
+	PathTemplate string         `json:"path_template" yaml:"path_template"`
+	Rewrites     []ApplyRewrite `json:"rewrites" yaml:"rewrites"`
+}
+
+// ApplyRewrite is a name rewrite of an upstream.
+type ApplyRewrite struct {
+	Match   string `json:"match" yaml:"match"`
+	Replace string `json:"replace" yaml:"replace"`
+}
+
+// ApplySyncRule is a scheduled push of local repositories to a remote
+// registry.
+type ApplySyncRule struct {
+	Name            string `json:"name" yaml:"name"`
+	SourcePattern   string `json:"source_pattern" yaml:"source_pattern"`
+	TagPattern      string `json:"tag_pattern" yaml:"tag_pattern"`
+	TargetRegistry  string `json:"target_registry" yaml:"target_registry"`
+	TargetNamespace string `json:"target_namespace" yaml:"target_namespace"`
+	Schedule        string `json:"schedule" yaml:"schedule"`
+	Enabled         *bool  `json:"enabled" yaml:"enabled"` // 默认 true
+}
+
+// ApplyRetention is a retention policy, enforced by a cleanup workflow
+// that runs after every push to a matching repository.
+type ApplyRetention struct {
+	Name       string `json:"name" yaml:"name"`
+	Repository string `json:"repository" yaml:"repository"` // 仓库名匹配模式，空表示全部
+	KeepCount  int    `json:"keep_count" yaml:"keep_count"`
+	KeepDays   int    `json:"keep_days" yaml:"keep_days"`
+	Enabled    *bool  `json:"enabled" yaml:"enabled"` // 默认 true
+}
+
+// ApplyUser is a user account. The password is only used when the user is
+// created; without it a temporary password is generated that must be
+// changed at the first login.
+type ApplyUser struct {
+	Username string `json:"username" yaml:"username"`
+	Email    string `json:"email" yaml:"email"`
+	Role     string `json:"role" yaml:"role"`     // 默认 user
+	Active   *bool  `json:"active" yaml:"active"` // 默认 true
+	Password string `json:"password" yaml:"password"`
+}
+
+// ApplyOrg is an organization. Without members the members are left
+// untouched; with members they are set to exactly that list.
+type ApplyOrg struct {
+	Name        string           `json:"name" yaml:"name"`
+	DisplayName string           `json:"display_name" yaml:"display_name"` // 默认同 name
+	Owner       string           `json:"owner" yaml:"owner"`               // 创建时的所有者，默认为调用者
+	Members     []ApplyOrgMember `json:"members" yaml:"members"`
+}
+
+// ApplyOrgMember is a member of an organization.
+type ApplyOrgMember struct {
+	Username string `json:"username" yaml:"username"`
+	Role     string `json:"role" yaml:"role"` // 默认 member
+}
+
+// ApplyChange is a change computed by apply.
+type ApplyChange struct {
+	Kind            string   `json:"kind"`
+	Name            string   `json:"name"`
+	Action          string   `json:"action"`           // create, update, delete
+	Fields          []string `json:"fields,omitempty"` // 更新的字段
+	InitialPassword string   `json:"initial_password,omitempty"`
+}
+
+// ApplyResult is the outcome of an apply.
+type ApplyResult struct {
+	DryRun  bool          `json:"dry_run"`
+	Changes []ApplyChange `json:"changes"`
+	Applied int           `json:"applied"`
+}
+
+// applyStep is a planned change and the function carrying it out.
+type applyStep struct {
+	change ApplyChange
+	run    func(change *ApplyChange) error
+}
+
+// applyPlan collects the steps of an apply in execution order.
+type applyPlan struct {
+	steps []applyStep
+}
+
+func (p *applyPlan) add(kind, name, action string, fields []string, run func(*ApplyChange) error) {
+	p.steps = append(p.steps, applyStep{
+		change: ApplyChange{Kind: kind, Name: name, Action: action, Fields: fields},
+		run:    run,
+	})
+}
+
+// parseApplyDocument decodes a YAML or JSON document, rejecting unknown
+// fields so typos do not silently drop settings.
+func parseApplyDocument(data []byte, contentType string) (*ApplyDocument, error) {
+	doc := &ApplyDocument{}
+	sections := map[string]interface{}{}
+
+	if strings.HasPrefix(contentType, "application/json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(doc); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &sections); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(doc); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &sections); err != nil {
+			return nil, err
+		}
+	}
+
+	doc.present = map[string]bool{}
+	for name := range sections {
+		doc.present[name] = true
+	}
+	return doc, nil
+}
+
+// validate checks the document before anything is planned.
+func (d *ApplyDocument) validate() error {
+	seen := map[string]bool{}
+	unique := func(kind, name string) error {
+		if name == "" {
+			return fmt.Errorf("%s: name 不能为空", kind)
+		}
+		if seen[kind+"/"+name] {
+			return fmt.Errorf("%s %s: 名称重复", kind, name)
+		}
+		seen[kind+"/"+name] = true
+		return nil
+	}
+
+	for _, u := range d.Upstreams {
+		if err := unique(applyKindUpstream, u.Name); err != nil {
+			return err
+		}
+		if u.URL == "" {
+			return fmt.Errorf("upstream %s: url 不能为空", u.Name)
+		}
+		if err := accelerator.ValidateUpstream(u.source()); err != nil {
+			return err
+		}
+	}
+	for _, r := range d.SyncRules {
+		if err := unique(applyKindSyncRule, r.Name); err != nil {
+			return err
+		}
+		if r.SourcePattern == "" || r.TargetRegistry == "" || r.Schedule == "" {
+			return fmt.Errorf("sync_rule %s: source_pattern、target_registry 和 schedule 不能为空", r.Name)
+		}
+		if d, err := time.ParseDuration(r.Schedule); err != nil || d < time.Minute {
+			return fmt.Errorf("sync_rule %s: schedule 须为不小于 1m 的时长", r.Name)
+		}
+	}
+	for _, p := range d.RetentionPolicies {
+		if err := unique(applyKindRetention, p.Name); err != nil {
+			return err
+		}
+		if p.KeepCount <= 0 && p.KeepDays <= 0 {
+			return fmt.Errorf("retention_policy %s: 须设置 keep_count 或 keep_days", p.Name)
+		}
+		if p.KeepCount < 0 || p.KeepDays < 0 {
+			return fmt.Errorf("retention_policy %s: keep_count 和 keep_days 不能为负数", p.Name)
+		}
+		if _, err := path.Match(p.Repository, ""); err != nil {
+			return fmt.Errorf("retention_policy %s: 无效的 repository 模式", p.Name)
+		}
+	}
+	for _, u := range d.Users {
+		if err := unique(applyKindUser, u.Username); err != nil {
+			return err
+		}
+		if len(u.Username) < 3 || len(u.Username) > 20 {
+			return fmt.Errorf("user %s: 用户名长度须为 3-20", u.Username)
+		}
+		if role := u.role(); role != service.RoleAdmin && role != service.RoleUser {
+			return fmt.Errorf("user %s: role 须为 admin 或 user", u.Username)
+		}
+		if u.Password != "" && len(u.Password) < service.MinPasswordLength {
+			return fmt.Errorf("user %s: 密码长度不能少于 %d 位", u.Username, service.MinPasswordLength)
+		}
+	}
+	for _, o := range d.Orgs {
+		if err := unique(applyKindOrg, o.Name); err != nil {
+			return err
+		}
+		members := map[string]bool{}
+		for _, m := range o.Members {
+			if m.Username == "" || members[m.Username] {
+				return fmt.Errorf("org %s: 成员为空或重复", o.Name)
+			}
+			members[m.Username] = true
+		}
+	}
+	return nil
+}
+
+func (u ApplyUpstream) source() accelerator.UpstreamSource {
+	s := accelerator.UpstreamSource{
+		Name:         u.Name,
+		URL:          u.URL,
+		Priority:     u.Priority,
+		Enabled:      enabledOrDefault(u.Enabled),
+		Type:         u.Type,
+		PathTemplate: u.PathTemplate,
+	}
+	for _, rw := range u.Rewrites {
+		s.Rewrites = append(s.Rewrites, accelerator.NameRewrite{Match: rw.Match, Replace: rw.Replace})
+	}
+	return s
+}
+
+func (u ApplyUser) role() string {
+	if u.Role == "" {
+		return service.RoleUser
+	}
+	return u.Role
+}
+
+func enabledOrDefault(v *bool) bool {
+	return v == nil || *v
+}
+
+// changedFields returns the JSON names of the fields that differ between
+// two values of the same type.
+func changedFields(old, next interface{}) []string {
+	var a, b map[string]interface{}
+	da, _ := json.Marshal(old)
+	db, _ := json.Marshal(next)
+	json.Unmarshal(da, &a)
+	json.Unmarshal(db, &b)
+
+	var fields []string
+	for k, v := range b {
+		if !reflect.DeepEqual(a[k], v) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// Apply computes the changes that bring the current state to doc and,
+// unless dryRun, carries them out in order. With prune, resources of the
+// sections present in doc that it does not list are deleted; the calling
+// user is never deleted. On failure the result holds the changes applied
+// so far; applying the same document again continues from there.
+func (r *Router) Apply(doc *ApplyDocument, actor *service.User, dryRun, prune bool) (*ApplyResult, error) {
+	if err := doc.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	plan := &applyPlan{}
+	planners := []struct {
+		section string
+		plan    func(*applyPlan, *ApplyDocument, *service.User, bool) error
+	}{
+		{"upstreams", r.planUpstreams},
+		{"sync_rules", r.planSyncRules},
+		{"retention_policies", r.planRetention},
+		{"users", r.planUsers},
+		{"orgs", r.planOrgs},
+	}
+	for _, p := range planners {
+		if !doc.present[p.section] {
+			continue
+		}
+		if err := p.plan(plan, doc, actor, prune); err != nil {
+			return nil, err
+		}
+	}
+	// 删除用户放在最后，此时其拥有的组织已被删除
+	if doc.present["users"] && prune {
+		if err := r.planUserDeletes(plan, doc, actor); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &ApplyResult{DryRun: dryRun, Changes: []ApplyChange{}}
+	if dryRun {
+		for _, step := range plan.steps {
+			result.Changes = append(result.Changes, step.change)
+		}
+		return result, nil
+	}
+
+	for _, step := range plan.steps {
+		change := step.change
+		if err := step.run(&change); err != nil {
+			return result, fmt.Errorf("%s %s %s: %w", change.Action, change.Kind, change.Name, err)
+		}
+		result.Changes = append(result.Changes, change)
+		result.Applied++
+	}
+
+	logger.Info("声明式配置已应用", zap.Int("changes", result.Applied), zap.Bool("prune", prune))
+	return result, nil
+}
+
+// planUpstreams plans the accelerator upstreams.
+func (r *Router) planUpstreams(plan *applyPlan, doc *ApplyDocument, _ *service.User, prune bool) error {
+	if r.acceleratorHandler == nil {
+		return fmt.Errorf("%w: 加速器未启用，无法管理 upstreams", ErrInvalidConfig)
+	}
+	proxy := r.acceleratorHandler.GetProxy()
+
+	current := map[string]accelerator.UpstreamSource{}
+	for _, u := range proxy.GetUpstreams() {
+		current[u.Name] = u
+	}
+
+	for _, u := range doc.Upstreams {
+		desired := u.source()
+		existing, ok := current[u.Name]
+		delete(current, u.Name)
+		switch {
+		case !ok:
+			plan.add(applyKindUpstream, u.Name, "create", nil, func(*ApplyChange) error {
+				return proxy.AddUpstream(desired)
+			})
+		case !reflect.DeepEqual(existing, desired):
+			plan.add(applyKindUpstream, u.Name, "update", changedFields(existing, desired), func(*ApplyChange) error {
+				return proxy.UpdateUpstream(desired.Name, desired)
+			})
+		}
+	}
+
+	if prune {
+		for _, name := range sortedKeys(current) {
+			name := name
+			plan.add(applyKindUpstream, name, "delete", nil, func(*ApplyChange) error {
+				return proxy.RemoveUpstream(name)
+			})
+		}
+	}
+	return nil
+}
+
+// syncRuleSpec is the part of a sync rule managed by apply.
+type syncRuleSpec struct {
+	SourcePattern   string `json:"source_pattern"`
+	TagPattern      string `json:"tag_pattern"`
+	TargetRegistry  string `json:"target_registry"`
+	TargetNamespace string `json:"target_namespace"`
+	Schedule        string `json:"schedule"`
+	Enabled         bool   `json:"enabled"`
+}
+
+func (r ApplySyncRule) spec() syncRuleSpec {
+	s := syncRuleSpec{
+		SourcePattern:   r.SourcePattern,
+		TagPattern:      r.TagPattern,
+		TargetRegistry:  strings.TrimRight(r.TargetRegistry, "/"),
+		TargetNamespace: strings.Trim(r.TargetNamespace, "/"),
+		Schedule:        r.Schedule,
+		Enabled:         enabledOrDefault(r.Enabled),
+	}
+	if s.TagPattern == "" {
+		s.TagPattern = "*"
+	}
+	return s
+}
+
+func syncRuleSpecOf(rule *registry.SyncRule) syncRuleSpec {
+	return syncRuleSpec{
+		SourcePattern:   rule.SourcePattern,
+		TagPattern:      rule.TagPattern,
+		TargetRegistry:  rule.TargetRegistry,
+		TargetNamespace: rule.TargetNamespace,
+		Schedule:        rule.Schedule,
+		Enabled:         rule.Enabled,
+	}
+}
+
+// planSyncRules plans the sync rules.
+func (r *Router) planSyncRules(plan *applyPlan, doc *ApplyDocument, _ *service.User, prune bool) error {
+	if r.syncService == nil {
+		return fmt.Errorf("%w: 同步服务未启用，无法管理 sync_rules", ErrInvalidConfig)
+	}
+	ss := r.syncService
+
+	rules, err := ss.ListSyncRules()
+	if err != nil {
+		return err
+	}
+	current := map[string]*registry.SyncRule{}
+	for _, rule := range rules {
+		if _, dup := current[rule.Name]; dup {
+			return fmt.Errorf("%w: 存在多个名为 %s 的同步规则，无法按名称管理", ErrInvalidConfig, rule.Name)
+		}
+		current[rule.Name] = rule
+	}
+
+	for _, rule := range doc.SyncRules {
+		desired := rule.spec()
+		name := rule.Name
+		existing, ok := current[name]
+		delete(current, name)
+
+		save := func(id string) func(*ApplyChange) error {
+			return func(*ApplyChange) error {
+				_, err := ss.SaveSyncRule(&registry.SyncRule{
+					ID:              id,
+					Name:            name,
+					SourcePattern:   desired.SourcePattern,
+					TagPattern:      desired.TagPattern,
+					TargetRegistry:  desired.TargetRegistry,
+					TargetNamespace: desired.TargetNamespace,
+					Schedule:        desired.Schedule,
+					Enabled:         desired.Enabled,
+				})
+				return err
+			}
+		}
+		switch {
+		case !ok:
+			plan.add(applyKindSyncRule, name, "create", nil, save(""))
+		case syncRuleSpecOf(existing) != desired:
+			plan.add(applyKindSyncRule, name, "update", changedFields(syncRuleSpecOf(existing), desired), save(existing.ID))
+		}
+	}
+
+	if prune {
+		for _, name := range sortedKeys(current) {
+			id := current[name].ID
+			plan.add(applyKindSyncRule, name, "delete", nil, func(*ApplyChange) error {
+				return ss.DeleteSyncRule(id)
+			})
+		}
+	}
+	return nil
+}
+
+// retentionWorkflow returns the workflow implementing a retention policy.
+func (p ApplyRetention) workflow() *service.CreateWorkflowRequest {
+	trigger := service.WorkflowTrigger{Type: "event", Event: service.RegistryEventPush}
+	if p.Repository != "" {
+		trigger.Filter = map[string]string{"repository": p.Repository}
+	}
+	params := map[string]string{}
+	if p.Repository != "" {
+		params["repository"] = p.Repository
+	}
+	if p.KeepCount > 0 {
+		params["keep_count"] = strconv.Itoa(p.KeepCount)
+	}
+	if p.KeepDays > 0 {
+		params["keep_days"] = strconv.Itoa(p.KeepDays)
+	}
+	return &service.CreateWorkflowRequest{
+		Name:        retentionWorkflowPrefix + p.Name,
+		Description: "保留策略，由 /api/v1/admin/apply 管理",
+		Trigger:     trigger,
+		Steps:       []service.WorkflowStep{{Name: "cleanup", Action: "cleanup", Parameters: params}},
+	}
+}
+
+// retentionSpec is the part of a retention workflow managed by apply.
+type retentionSpec struct {
+	Trigger service.WorkflowTrigger `json:"trigger"`
+	Steps   []service.WorkflowStep  `json:"steps"`
+	Enabled bool                    `json:"enabled"`
+}
+
+// planRetention plans the retention policies, stored as workflows named
+// retention:<name>. Other workflows are never touched.
+func (r *Router) planRetention(plan *applyPlan, doc *ApplyDocument, _ *service.User, prune bool) error {
+	if r.workflowService == nil {
+		return fmt.Errorf("%w: 工作流服务未启用，无法管理 retention_policies", ErrInvalidConfig)
+	}
+	ws := r.workflowService
+
+	workflows, err := ws.ListWorkflows()
+	if err != nil {
+		return err
+	}
+	current := map[string]*service.Workflow{}
+	for _, w := range workflows {
+		if name, ok := strings.CutPrefix(w.Name, retentionWorkflowPrefix); ok {
+			current[name] = w
+		}
+	}
+
+	for _, p := range doc.RetentionPolicies {
+		req := p.workflow()
+		enabled := enabledOrDefault(p.Enabled)
+		desired := retentionSpec{Trigger: req.Trigger, Steps: req.Steps, Enabled: enabled}
+		existing, ok := current[p.Name]
+		delete(current, p.Name)
+
+		setEnabled := func(id string) error {
+			if enabled {
+				return ws.EnableWorkflow(id)
+			}
+			return ws.DisableWorkflow(id)
+		}
+		switch {
+		case !ok:
+			plan.add(applyKindRetention, p.Name, "create", nil, func(*ApplyChange) error {
+				w, err := ws.CreateWorkflow(req)
+				if err != nil {
+					return err
+				}
+				return setEnabled(w.ID)
+			})
+		default:
+			actual := retentionSpec{Trigger: existing.Trigger, Steps: existing.Steps, Enabled: existing.Enabled}
+			if fields := changedFields(actual, desired); len(fields) > 0 {
+				id := existing.ID
+				plan.add(applyKindRetention, p.Name, "update", fields, func(*ApplyChange) error {
+					if _, err := ws.UpdateWorkflow(id, req); err != nil {
+						return err
+					}
+					return setEnabled(id)
+				})
+			}
+		}
+	}
+
+	if prune {
+		for _, name := range sortedKeys(current) {
+			id := current[name].ID
+			plan.add(applyKindRetention, name, "delete", nil, func(*ApplyChange) error {
+				return ws.DeleteWorkflow(id)
+			})
+		}
+	}
+	return nil
+}
+
+// userSpec is the part of a user managed by apply.
+type userSpec struct {
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+	Active bool   `json:"active"`
+}
+
+// planUsers plans the creation and update of users.
+func (r *Router) planUsers(plan *applyPlan, doc *ApplyDocument, actor *service.User, _ bool) error {
+	us := r.userService
+
+	for _, u := range doc.Users {
+		u := u
+		desired := userSpec{Email: u.Email, Role: u.role(), Active: enabledOrDefault(u.Active)}
+		existing, err := dao.GetUserByUsername(u.Username)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			plan.add(applyKindUser, u.Username, "create", nil, func(change *ApplyChange) error {
+				password := u.Password
+				if password == "" {
+					password = randomPassword()
+				}
+				created, err := us.CreateUser(&service.CreateUserRequest{
+					Username: u.Username,
+					Password: password,
+					Email:    desired.Email,
+					Role:     desired.Role,
+				})
+				if err != nil {
+					return err
+				}
+				if u.Password == "" {
+					// 生成须在首次登录时修改的临时密码
+					if change.InitialPassword, err = us.ResetPassword(created.ID, ""); err != nil {
+						return err
+					}
+				}
+				if !desired.Active {
+					_, err = us.SetActive(created.ID, false, actor.ID)
+				}
+				return err
+			})
+			continue
+		}
+
+		actual := userSpec{Email: existing.Email.String, Role: existing.Role, Active: existing.IsActive}
+		if actual == desired {
+			continue
+		}
+		id := existing.ID
+		plan.add(applyKindUser, u.Username, "update", changedFields(actual, desired), func(*ApplyChange) error {
+			if actual.Email != desired.Email {
+				if _, err := us.SetEmail(id, desired.Email); err != nil {
+					return err
+				}
+			}
+			if actual.Role != desired.Role {
+				if _, err := us.SetRole(id, desired.Role, actor.ID); err != nil {
+					return err
+				}
+			}
+			if actual.Active != desired.Active {
+				if _, err := us.SetActive(id, desired.Active, actor.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// planUserDeletes plans the deletion of users the document does not list.
+func (r *Router) planUserDeletes(plan *applyPlan, doc *ApplyDocument, actor *service.User) error {
+	listed := map[string]bool{}
+	for _, u := range doc.Users {
+		listed[u.Username] = true
+	}
+	users, err := allUsers()
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		if listed[u.Username] || u.ID == actor.ID {
+			continue
+		}
+		id := u.ID
+		plan.add(applyKindUser, u.Username, "delete", nil, func(*ApplyChange) error {
+			return r.userService.DeleteUser(id, actor.ID)
+		})
+	}
+	return nil
+}
+
+// orgSpec is the part of an organization managed by apply.
+type orgSpec struct {
+	DisplayName string            `json:"display_name"`
+	Members     map[string]string `json:"members,omitempty"` // 用户名 -> 角色，不含所有者
+}
+
+// planOrgs plans the organizations and their members.
+func (r *Router) planOrgs(plan *applyPlan, doc *ApplyDocument, actor *service.User, prune bool) error {
+	orgSvc := r.orgService
+
+	orgs, err := allOrganizations()
+	if err != nil {
+		return err
+	}
+	current := map[string]*dao.Organization{}
+	for _, org := range orgs {
+		current[org.Name] = org
+	}
+
+	// 文档中创建的用户此时尚不存在，执行时再解析用户 ID
+	docUsers := map[string]bool{}
+	if doc.present["users"] {
+		for _, u := range doc.Users {
+			docUsers[u.Username] = true
+		}
+	}
+	checkUser := func(org, username string) error {
+		if docUsers[username] {
+			return nil
+		}
+		u, err := dao.GetUserByUsername(username)
+		if err != nil {
+			return err
+		}
+		if u == nil {
+			return fmt.Errorf("%w: org %s: 用户 %s 不存在", ErrInvalidConfig, org, username)
+		}
+		return nil
+	}
+
+	for _, o := range doc.Orgs {
+		o := o
+		displayName := o.DisplayName
+		if displayName == "" {
+			displayName = o.Name
+		}
+		desired := orgSpec{DisplayName: displayName}
+		if o.Members != nil {
+			desired.Members = map[string]string{}
+			for _, m := range o.Members {
+				if err := checkUser(o.Name, m.Username); err != nil {
+					return err
+				}
+				role := m.Role
+				if role == "" {
+					role = "member"
+				}
+				desired.Members[m.Username] = role
+			}
+		}
+
+		existing, ok := current[o.Name]
+		delete(current, o.Name)
+
+		if !ok {
+			owner := o.Owner
+			if owner == "" {
+				owner = actor.Username
+			} else if err := checkUser(o.Name, owner); err != nil {
+				return err
+			}
+			delete(desired.Members, owner)
+			plan.add(applyKindOrg, o.Name, "create", nil, func(*ApplyChange) error {
+				ownerID, err := userIDByName(owner)
+				if err != nil {
+					return err
+				}
+				org, err := orgSvc.CreateOrganization(&service.CreateOrgRequest{Name: o.Name, DisplayName: displayName}, ownerID)
+				if err != nil {
+					return err
+				}
+				return syncOrgMembers(orgSvc, org.ID, ownerID, nil, desired.Members)
+			})
+			continue
+		}
+
+		ownerName := ""
+		if owner, err := dao.GetUserByID(existing.OwnerID); err == nil && owner != nil {
+			ownerName = owner.Username
+		}
+		if o.Owner != "" && o.Owner != ownerName {
+			return fmt.Errorf("%w: org %s: 不支持通过 apply 更换所有者", ErrInvalidConfig, o.Name)
+		}
+
+		actual := orgSpec{DisplayName: existing.DisplayName}
+		var currentMembers map[string]string
+		if desired.Members != nil {
+			delete(desired.Members, ownerName)
+			members, err := dao.GetOrgMembers(existing.ID)
+			if err != nil {
+				return err
+			}
+			currentMembers = map[string]string{}
+			for _, m := range members {
+				if m.UserID != existing.OwnerID {
+					currentMembers[m.Username] = m.Role
+				}
+			}
+			actual.Members = currentMembers
+		}
+
+		fields := changedFields(actual, desired)
+		if len(fields) == 0 {
+			continue
+		}
+		id, ownerID := existing.ID, existing.OwnerID
+		plan.add(applyKindOrg, o.Name, "update", fields, func(*ApplyChange) error {
+			if actual.DisplayName != desired.DisplayName {
+				if err := orgSvc.UpdateOrganization(id, desired.DisplayName, ownerID); err != nil {
+					return err
+				}
+			}
+			if desired.Members == nil {
+				return nil
+			}
+			return syncOrgMembers(orgSvc, id, ownerID, currentMembers, desired.Members)
+		})
+	}
+
+	if prune {
+		for _, name := range sortedKeys(current) {
+			org := current[name]
+			plan.add(applyKindOrg, name, "delete", nil, func(*ApplyChange) error {
+				return orgSvc.DeleteOrganization(org.ID, org.OwnerID)
+			})
+		}
+	}
+	return nil
+}
+
+// syncOrgMembers adds, updates and removes members until the members of an
+// organization match desired. A nil desired leaves them untouched.
+func syncOrgMembers(orgSvc *service.OrgService, orgID, ownerID int64, current, desired map[string]string) error {
+	for _, username := range sortedKeys(desired) {
+		role := desired[username]
+		if r, ok := current[username]; ok && r == role {
+			continue
+		}
+		userID, err := userIDByName(username)
+		if err != nil {
+			return err
+		}
+		if err := orgSvc.AddMember(orgID, userID, ownerID, role); err != nil {
+			return err
+		}
+	}
+	for _, username := range sortedKeys(current) {
+		if _, ok := desired[username]; ok {
+			continue
+		}
+		userID, err := userIDByName(username)
+		if err != nil {
+			return err
+		}
+		if err := orgSvc.RemoveMember(orgID, userID, ownerID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// userIDByName resolves a username when a step runs.
+func userIDByName(username string) (int64, error) {
+	u, err := dao.GetUserByUsername(username)
+	if err != nil {
+		return 0, err
+	}
+	if u == nil {
+		return 0, fmt.Errorf("用户 %s 不存在", username)
+	}
+	return u.ID, nil
+}
+
+// allUsers returns every user, page by page.
+func allUsers() ([]*dao.User, error) {
+	var users []*dao.User
+	for page := 1; ; page++ {
+		batch, total, err := dao.SearchUsers("", page, 100)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, batch...)
+		if len(batch) == 0 || len(users) >= total {
+			return users, nil
+		}
+	}
+}
+
+// allOrganizations returns every organization, page by page.
+func allOrganizations() ([]*dao.Organization, error) {
+	var orgs []*dao.Organization
+	for page := 1; ; page++ {
+		batch, total, err := dao.ListOrganizations(page, 100)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, batch...)
+		if len(batch) == 0 || len(orgs) >= total {
+			return orgs, nil
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in order, so plans are deterministic.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// randomPassword returns a throwaway password for a user created without
+// one; it is replaced by a temporary password right away.
+func randomPassword() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// applyHandler applies a declarative YAML or JSON document on request of
+// an admin. Query: dry_run=true only returns the changes, prune=true also
+// deletes unlisted resources of the sections present.
+func (r *Router) applyHandler(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil || user.Role != "admin" {
+		common.Error(c, http.StatusForbidden, "需要管理员权限")
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, applyMaxBody+1))
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "读取请求体失败")
+		return
+	}
+	if len(data) > applyMaxBody {
+		common.Error(c, http.StatusRequestEntityTooLarge, "文档过大")
+		return
+	}
+	doc, err := parseApplyDocument(data, c.ContentType())
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无法解析文档: "+err.Error())
+		return
+	}
+
+	dryRun := c.Query("dry_run") == "true"
+	prune := c.Query("prune") == "true"
+	result, err := r.Apply(doc, user, dryRun, prune)
+
+	if r.auditService != nil && !dryRun {
+		entry := &service.AuditLog{
+			Level:     "warn",
+			Event:     "config_apply",
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			UserID:    user.ID,
+			Username:  user.Username,
+			Action:    "apply",
+			Status:    "success",
+			Details:   map[string]interface{}{"prune": prune},
+		}
+		if result != nil {
+			changes := make([]string, 0, len(result.Changes))
+			for _, ch := range result.Changes {
+				changes = append(changes, ch.Action+" "+ch.Kind+" "+ch.Name)
+			}
+			entry.Details["changes"] = changes
+		}
+		if err != nil {
+			entry.Status = "failure"
+			entry.Details["error"] = err.Error()
+		}
+		r.auditService.LogAuditEvent(entry)
+	}
+
+	switch {
+	case err == nil:
+		common.SuccessResponse(c, result)
+	case result != nil:
+		// 已应用的变更保留，修正后重新提交同一文档即可继续
+		common.ErrorWithCode(c, http.StatusUnprocessableEntity, common.ErrUnprocessable, err.Error(), gin.H{
+			"applied": result.Applied,
+			"changes": result.Changes,
+		})
+	case errors.Is(err, ErrInvalidConfig):
+		common.ErrorWithCode(c, http.StatusUnprocessableEntity, common.ErrUnprocessable, err.Error(), nil)
+	default:
+		logger.Error("计算声明式配置变更失败", zap.Error(err))
+		common.Error(c, http.StatusInternalServerError, "读取当前配置失败")
+	}
+}
@@ -0,0 +1,31 @@
+package gateway
+
+import (
+	"path/filepath"
+	"time"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+)
+
+// auditRedactor creates the redaction policy of audit exports, nil when it
+// is disabled.
+func (r *Router) auditRedactor(cfg common.AuditRedactionConfig) (*service.AuditRedactor, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var after time.Duration
+	if cfg.After != "" && cfg.After != "0" {
+		after, _ = time.ParseDuration(cfg.After)
+	}
+	keyFile := cfg.KeyFile
+	if keyFile == "" {
+		keyFile = filepath.Join(r.config.Storage.MetaPath, "audit_redaction.key")
+	}
+	return service.NewAuditRedactor(service.AuditRedactionConfig{
+		After:    after,
+		IP:       cfg.IP,
+		Username: cfg.Username,
+		KeyFile:  keyFile,
+	})
+}
@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// initClientCerts loads the client CAs of certificate authentication. A
+// configuration that fails to load stops the server instead of silently
+// accepting clients without checking their certificates.
+func (r *Router) initClientCerts() error {
+	if !r.config.Auth.MTLS.Enabled {
+		return nil
+	}
+	svc, err := service.NewClientCertService(clientCertConfig(r.config.Auth.MTLS), logger)
+	if err != nil {
+		return err
+	}
+	r.clientCertService = svc
+	logger.Info("已启用 /v2 客户端证书认证",
+		zap.Bool("required", svc.Required()),
+		zap.String("user_field", r.config.Auth.MTLS.UserField),
+	)
+	return nil
+}
+
+// clientCertConfig converts the client certificate configuration.
+func clientCertConfig(cfg common.MTLSConfig) service.ClientCertConfig {
+	users := make(map[string]string, len(cfg.Users))
+	for _, u := range cfg.Users {
+		users[u.Match] = u.User
+	}
+	return service.ClientCertConfig{
+		ClientCA:     cfg.ClientCA,
+		Required:     cfg.Required,
+		UserField:    cfg.UserField,
+		Users:        users,
+		CRLFiles:     cfg.CRLFiles,
+		OCSP:         cfg.OCSP,
+		OCSPSoftFail: cfg.OCSPSoftFail,
+	}
+}
+
+// TLSConfig returns the TLS configuration of the listener. Client
+// certificates are asked for but verified only when given, the web UI and
+// /api do not use them; /v2 enforces auth.mtls.required itself.
+func (r *Router) TLSConfig() *tls.Config {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if r.clientCertService != nil {
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		config.ClientCAs = r.clientCertService.ClientCAs()
+	}
+	return config
+}
+
+// clientCertificate checks the client certificate of a /v2 request and
+// keeps its identity for audit logs. It returns nil without error when
+// certificates are not used or none was given and none is required.
+func (r *Router) clientCertificate(c *gin.Context) (*service.ClientCertIdentity, error) {
+	if r.clientCertService == nil {
+		return nil, nil
+	}
+	identity, err := r.clientCertService.Authenticate(c.Request.TLS)
+	if err != nil {
+		return identity, err
+	}
+	if identity == nil {
+		if r.clientCertService.Required() {
+			return nil, service.ErrClientCertRequired
+		}
+		return nil, nil
+	}
+	c.Set("clientCert", identity)
+	return identity, nil
+}
+
+// certificateUser returns the active user a client certificate maps to.
+func (r *Router) certificateUser(identity *service.ClientCertIdentity) (*service.User, error) {
+	daoUser, err := dao.GetUserByUsername(identity.Username)
+	if err != nil {
+		return nil, err
+	}
+	if daoUser == nil || !daoUser.IsActive {
+		return nil, errors.New("client certificate user not found")
+	}
+	return &service.User{
+		ID:       daoUser.ID,
+		Username: daoUser.Username,
+		Email:    daoUser.Email.String,
+		Role:     daoUser.Role,
+		IsActive: daoUser.IsActive,
+	}, nil
+}
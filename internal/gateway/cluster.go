@@ -0,0 +1,78 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cyp-docker-registry/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// initCluster starts leader election when several instances share the data
+// directory and database. Without cluster mode the instance is the only one
+// and always runs scheduled jobs.
+func (r *Router) initCluster() {
+	cfg := r.config.Cluster
+	if !cfg.Enabled {
+		return
+	}
+
+	instanceID := cfg.InstanceID
+	if instanceID == "" {
+		instanceID = service.DefaultInstanceID()
+	}
+	ttl, err := time.ParseDuration(cfg.LeaseTTL)
+	if err != nil {
+		logger.Warn("集群租约时长无效，使用默认值", zap.String("lease_ttl", cfg.LeaseTTL))
+		ttl = service.DefaultLeaseTTL
+	}
+
+	r.leaderElector = service.NewLeaderElector(instanceID, ttl, logger)
+	r.leaderElector.Start()
+	logger.Info("集群模式已启用",
+		zap.String("instance", instanceID),
+		zap.Bool("leader", r.leaderElector.IsLeader()),
+	)
+}
+
+// isLeader reports whether this instance runs scheduled jobs.
+func (r *Router) isLeader() bool {
+	return r.leaderElector == nil || r.leaderElector.IsLeader()
+}
+
+// Shutdown releases cluster resources so another instance takes over the
+// scheduled jobs at once, and writes buffered statistics and events.
+func (r *Router) Shutdown() {
+	if r.statsService != nil {
+		r.statsService.Flush()
+	}
+	if r.eventLog != nil {
+		r.eventLog.Flush()
+	}
+	if r.popularImages != nil {
+		r.popularImages.Stop()
+	}
+	if r.leaderElector != nil {
+		r.leaderElector.Stop()
+	}
+	if r.redis != nil {
+		r.redis.Close()
+	}
+}
+
+// checkCluster reports the role of this instance and the current leader.
+func (r *Router) checkCluster(ctx context.Context) (string, error) {
+	if r.leaderElector.IsLeader() {
+		return "leader", nil
+	}
+	lease, err := r.leaderElector.Leader()
+	if err != nil {
+		return "", err
+	}
+	if lease == nil {
+		return "", errors.New("no leader elected")
+	}
+	return "follower of " + lease.Holder, nil
+}
@@ -0,0 +1,303 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/middleware"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ErrInvalidConfig is returned when a reloaded configuration does not pass
+// validation. The running configuration is left untouched.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// ConfigReloadResult describes what a configuration reload changed.
+type ConfigReloadResult struct {
+	File            string   `json:"file"`
+	Changed         []string `json:"changed"`
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// ReloadConfig re-reads the configuration file and applies the changed
+// sections that can be changed live: accelerator upstreams, rate limits,
+// notification channels, DNS servers, outbound proxies, promotion channels,
+// trusted provenance builders and the log level.
+// A log level overridden through the settings API is kept. Other changed
+// sections are reported as requiring a restart. An invalid file is
+// rejected as a whole.
+func (r *Router) ReloadConfig() (*ConfigReloadResult, error) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	next, err := common.LoadConfig(r.config.File())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+	if r.autoConfig != nil && next.Environment.AutoConfigure {
+		r.autoConfig.Apply(next)
+	}
+	if err := next.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+	}
+
+	result := &ConfigReloadResult{
+		File:            next.File(),
+		Changed:         []string{},
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+	for _, section := range diffConfig(r.config, next) {
+		result.Changed = append(result.Changed, section)
+		if r.applyConfigSection(section, next) {
+			result.Applied = append(result.Applied, section)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, section)
+		}
+	}
+
+	logger.Info("配置已重新加载",
+		zap.String("file", result.File),
+		zap.Strings("applied", result.Applied),
+		zap.Strings("restart_required", result.RestartRequired),
+	)
+	return result, nil
+}
+
+// applyConfigSection applies a changed section and reports whether it took
+// effect without a restart.
+func (r *Router) applyConfigSection(section string, next *common.Config) bool {
+	switch section {
+	case "accelerator":
+		if r.acceleratorHandler == nil || next.Accelerator.Enabled != r.config.Accelerator.Enabled ||
+			next.Accelerator.CachePolicy != r.config.Accelerator.CachePolicy ||
+			next.Accelerator.PinRefreshInterval != r.config.Accelerator.PinRefreshInterval {
+			return false
+		}
+		upstreams := acceleratorUpstreams(next.Accelerator.Upstreams)
+		if err := r.acceleratorHandler.GetProxy().SetUpstreams(upstreams); err != nil {
+			logger.Warn("保存加速器上游配置失败", zap.Error(err))
+		}
+		r.configMu.Lock()
+		r.config.Accelerator = next.Accelerator
+		r.configMu.Unlock()
+		return true
+
+	case "security.rate_limit":
+		r.rateLimiter.SetLimits(rateLimits(next.Security.RateLimit))
+		r.configMu.Lock()
+		r.config.Security.RateLimit = next.Security.RateLimit
+		r.configMu.Unlock()
+		return true
+
+	case "security.audit_redaction":
+		redactor, err := r.auditRedactor(next.Security.AuditRedaction)
+		if err != nil {
+			logger.Warn("应用审计日志脱敏配置失败", zap.Error(err))
+			return false
+		}
+		r.auditHandler.SetRedactor(redactor)
+		r.configMu.Lock()
+		r.config.Security.AuditRedaction = next.Security.AuditRedaction
+		r.configMu.Unlock()
+		return true
+
+	case "dns":
+		if err := r.dnsService.Configure(dnsResolverConfig(next.DNS)); err != nil {
+			logger.Warn("应用 DNS 配置失败", zap.Error(err))
+			return false
+		}
+		if r.acceleratorHandler != nil {
+			r.acceleratorHandler.GetProxy().SetCustomResolver(r.dnsService.Resolver())
+		}
+		r.configMu.Lock()
+		r.config.DNS = next.DNS
+		r.configMu.Unlock()
+		return true
+
+	case "proxy":
+		r.applyProxy(next.Proxy)
+		r.configMu.Lock()
+		r.config.Proxy = next.Proxy
+		r.configMu.Unlock()
+		return true
+
+	case "notify":
+		r.applyMailer(next.Notify.Channels.Email)
+		if r.digestService != nil {
+			r.digestService.Configure(accessDigestConfig(next.Notify.AccessDigest))
+		}
+		r.configMu.Lock()
+		r.config.Notify = next.Notify
+		r.configMu.Unlock()
+		return true
+
+	case "promotion":
+		if r.promotionService == nil {
+			return false
+		}
+		r.promotionService.SetChannels(promotionChannels(next.Promotion))
+		r.configMu.Lock()
+		r.config.Promotion = next.Promotion
+		r.configMu.Unlock()
+		return true
+
+	case "provenance":
+		if r.provenanceService == nil {
+			return false
+		}
+		r.provenanceService.SetTrustedBuilders(trustedBuilders(next.Provenance))
+		r.configMu.Lock()
+		r.config.Provenance = next.Provenance
+		r.configMu.Unlock()
+		return true
+
+	case "logging":
+		// 日志输出在启动时打开，只有日志级别可以热更新
+		outputs := next.Logging
+		outputs.Level = r.config.Logging.Level
+		if logLevel == nil || !reflect.DeepEqual(outputs, r.config.Logging) {
+			return false
+		}
+		level, _ := zapcore.ParseLevel(next.Logging.Level)
+		if err := r.settingsService.SetConfigValue("logging.level", level.String()); err != nil {
+			logger.Warn("应用日志级别失败", zap.Error(err))
+			return false
+		}
+		r.configMu.Lock()
+		r.config.Logging = next.Logging
+		r.configMu.Unlock()
+		return true
+	}
+	return false
+}
+
+// setLogLevel changes the level of the application logs.
+func setLogLevel(value string) error {
+	if logLevel == nil {
+		return fmt.Errorf("log level cannot be changed")
+	}
+	level, err := zapcore.ParseLevel(value)
+	if err != nil {
+		return err
+	}
+	logLevel.SetLevel(level)
+	return nil
+}
+
+// applyMailer configures the email channel used for org invitations and
+// the access digest.
+func (r *Router) applyMailer(email common.EmailConfig) {
+	var mailer service.Mailer
+	if email.Enabled {
+		smtp, err := service.NewSMTPMailer(email.SMTPHost, email.SMTPPort, email.Username, email.Password, email.From)
+		if err != nil {
+			logger.Warn("邮件通道配置无效", zap.Error(err))
+		} else {
+			mailer = smtp
+		}
+	}
+	r.orgService.SetMailer(mailer)
+	if r.digestService != nil {
+		r.digestService.SetMailer(mailer)
+	}
+}
+
+// rateLimits converts the rate limit section to limiter settings. A
+// disabled section yields no limits.
+func rateLimits(rl common.RateLimitConfig) map[string]middleware.RateLimit {
+	if !rl.Enabled {
+		return nil
+	}
+	return map[string]middleware.RateLimit{
+		middleware.RateClassAuth: {QPS: rl.Auth.QPS, Burst: rl.Auth.Burst},
+		middleware.RateClassPull: {QPS: rl.Pull.QPS, Burst: rl.Pull.Burst},
+		middleware.RateClassPush: {QPS: rl.Push.QPS, Burst: rl.Push.Burst},
+		middleware.RateClassAPI:  {QPS: rl.API.QPS, Burst: rl.API.Burst},
+	}
+}
+
+// diffConfig returns the names of the sections that differ, e.g. "notify"
+// or "security.rate_limit". The security section is compared per
+// subsection since only its rate limits can be applied live.
+func diffConfig(old, next *common.Config) []string {
+	return appendChangedSections(nil, "", reflect.ValueOf(*old), reflect.ValueOf(*next), true)
+}
+
+// appendChangedSections compares the exported fields of two structs by
+// their mapstructure names. With split set, the security section is
+// compared field by field.
+func appendChangedSections(changed []string, prefix string, old, next reflect.Value, split bool) []string {
+	t := old.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("mapstructure"), ",")[0]
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		a, b := old.Field(i), next.Field(i)
+
+		if split && name == "security" {
+			changed = appendChangedSections(changed, name+".", a, b, false)
+			continue
+		}
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			changed = append(changed, prefix+name)
+		}
+	}
+	return changed
+}
+
+// reloadConfigHandler reloads the configuration file on request of an
+// admin.
+func (r *Router) reloadConfigHandler(c *gin.Context) {
+	user := currentUser(c)
+	if user == nil || user.Role != "admin" {
+		common.Error(c, http.StatusForbidden, "需要管理员权限")
+		return
+	}
+
+	result, err := r.ReloadConfig()
+
+	if r.auditService != nil {
+		entry := &service.AuditLog{
+			Level:     "warn",
+			Event:     "config_reload",
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  r.config.File(),
+			UserID:    user.ID,
+			Username:  user.Username,
+			Action:    "reload",
+			Status:    "success",
+		}
+		if err != nil {
+			entry.Status = "failure"
+			entry.Details = map[string]interface{}{"error": err.Error()}
+		} else {
+			entry.Details = map[string]interface{}{
+				"applied":          result.Applied,
+				"restart_required": result.RestartRequired,
+			}
+		}
+		r.auditService.LogAuditEvent(entry)
+	}
+
+	if err != nil {
+		common.ErrorWithCode(c, http.StatusUnprocessableEntity, common.ErrUnprocessable, err.Error(), nil)
+		return
+	}
+	common.SuccessResponse(c, result)
+}
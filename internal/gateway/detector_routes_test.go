@@ -0,0 +1,59 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cyp-docker-registry/internal/detector"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newDetectorTestRouter registers the detector routes as setupRoutes does.
+// asUser, when set, stands in for a verified login.
+func newDetectorTestRouter(asUser *service.User) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := &Router{
+		engine:          gin.New(),
+		detectorHandler: detector.NewHandler(detector.NewDetectorService()),
+	}
+	authCheck := r.createAuthCheckMiddleware()
+	if asUser != nil {
+		authCheck = func(c *gin.Context) {
+			c.Set("currentUser", asUser)
+			c.Next()
+		}
+	}
+	r.registerDetectorRoutes(r.engine.Group("/api/system"), authCheck, r.requireAdminScope())
+	return r.engine
+}
+
+func TestEnvironmentRequiresAdmin(t *testing.T) {
+	tests := []struct {
+		name string
+		user *service.User
+		want int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"non-admin", &service.User{ID: 2, Username: "bob", Role: service.RoleUser}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/api/system/environment", "/api/system/environment?refresh=true"} {
+			w := httptest.NewRecorder()
+			newDetectorTestRouter(tt.user).ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+			if w.Code != tt.want {
+				t.Errorf("%s GET %s = %d, want %d", tt.name, path, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestSystemInfoStaysPublic(t *testing.T) {
+	w := httptest.NewRecorder()
+	newDetectorTestRouter(nil).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/system/compatibility", nil))
+	if w.Code == http.StatusUnauthorized || w.Code == http.StatusForbidden {
+		t.Errorf("GET /api/system/compatibility = %d, want a public route", w.Code)
+	}
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cyp-docker-registry/internal/registry"
+	"cyp-docker-registry/pkg/utils"
+
+	"go.uber.org/zap"
+)
+
+// initDiskGuard starts the free space watchdog of the blob volume. It runs
+// on every instance, since each one refuses pushes on its own.
+func (r *Router) initDiskGuard() {
+	if r.registryService == nil || !r.diskWatchEnabled() {
+		return
+	}
+	watch := r.config.Storage.DiskWatch
+
+	config := registry.DiskGuardConfig{}
+	config.Interval, _ = time.ParseDuration(watch.Interval)
+	for _, s := range watch.WarnFree {
+		if t, err := registry.ParseDiskThreshold(s); err == nil {
+			config.Warn = append(config.Warn, t)
+		}
+	}
+	if watch.ReadOnlyFree != "" {
+		config.ReadOnly, _ = registry.ParseDiskThreshold(watch.ReadOnlyFree)
+	}
+	if watch.ResumeFree != "" {
+		config.Resume, _ = registry.ParseDiskThreshold(watch.ResumeFree)
+	}
+
+	r.registryService.SetDiskNotifier(r.notifyDisk)
+	r.registryService.StartDiskGuard(config)
+}
+
+// diskWatchEnabled reports whether the watchdog runs; an interval of 0
+// turns it off.
+func (r *Router) diskWatchEnabled() bool {
+	interval, err := time.ParseDuration(r.config.Storage.DiskWatch.Interval)
+	return err != nil || interval > 0
+}
+
+// notifyDisk reports free space alerts to administrators in the web console
+// and to the alert recipients.
+func (r *Router) notifyDisk(level, title, message string) {
+	if level == "info" {
+		logger.Info(title, zap.String("detail", message))
+	} else {
+		logger.Warn(title, zap.String("detail", message))
+	}
+	if r.wsHandler != nil {
+		r.wsHandler.NotifyAdmins(level, title, message)
+	}
+	r.sendAlertEmail(title, message, "发送存储空间告警邮件失败")
+}
+
+// checkDiskSpace fails while the blob volume is read-only. It is not
+// critical: pulls are still served and the instance stays ready.
+func (r *Router) checkDiskSpace(_ context.Context) (string, error) {
+	status := r.registryService.DiskStatus()
+	if status == nil {
+		return "not checked yet", nil
+	}
+	detail := fmt.Sprintf("%s free of %s", utils.FormatSize(status.Free), utils.FormatSize(status.Total))
+	if status.ReadOnly {
+		return detail, errors.New("blob volume is low on free space, pushes are refused")
+	}
+	return detail, nil
+}
@@ -0,0 +1,275 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstreamCheckTTL is how long upstream reachability is cached, so frequent
+// probes do not hammer public registries.
+const upstreamCheckTTL = 30 * time.Second
+
+// healthCheck is one dependency verified by the probes.
+type healthCheck struct {
+	name     string
+	critical bool // a failure makes the probe return 503
+	liveness bool // also run by /healthz
+	run      func(ctx context.Context) (detail string, err error)
+}
+
+// healthResult is the outcome of a check.
+type healthResult struct {
+	Status   string `json:"status"` // ok or fail
+	Critical bool   `json:"critical"`
+	Detail   string `json:"detail,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// upstreamHealth caches the last upstream check.
+type upstreamHealth struct {
+	mu      sync.Mutex
+	checked time.Time
+	detail  string
+	err     error
+}
+
+// livenessHandler handles GET /healthz. It only runs checks that a restart
+// could fix, so an outage of shared storage does not restart every pod.
+func (r *Router) livenessHandler(c *gin.Context) {
+	r.runHealthChecks(c, true)
+}
+
+// readinessHandler handles GET /readyz. It returns 503 while a critical
+// dependency fails so the instance is taken out of load balancing.
+func (r *Router) readinessHandler(c *gin.Context) {
+	r.runHealthChecks(c, false)
+}
+
+// runHealthChecks runs the checks concurrently, each bounded by
+// health.timeout, and writes the per-check report.
+func (r *Router) runHealthChecks(c *gin.Context, liveness bool) {
+	timeout, err := time.ParseDuration(r.config.Health.Timeout)
+	if err != nil || timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	var checks []healthCheck
+	for _, check := range r.healthChecks() {
+		if !liveness || check.liveness {
+			checks = append(checks, check)
+		}
+	}
+
+	results := make(map[string]*healthResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check healthCheck) {
+			defer wg.Done()
+			result := runHealthCheck(c.Request.Context(), check, timeout)
+			mu.Lock()
+			results[check.name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Status == "ok" {
+			continue
+		}
+		if result.Critical {
+			status, code = "fail", http.StatusServiceUnavailable
+			break
+		}
+		status = "degraded"
+	}
+
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// runHealthCheck runs a check, reporting a failure when it exceeds timeout.
+func runHealthCheck(parent context.Context, check healthCheck, timeout time.Duration) *healthResult {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	type outcome struct {
+		detail string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		detail, err := check.run(ctx)
+		done <- outcome{detail, err}
+	}()
+
+	result := &healthResult{Status: "ok", Critical: check.critical}
+	select {
+	case o := <-done:
+		result.Detail = o.detail
+		if o.err != nil {
+			result.Status = "fail"
+			result.Error = o.err.Error()
+		}
+	case <-ctx.Done():
+		result.Status = "fail"
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+	}
+	result.Duration = time.Since(start).Round(time.Millisecond).String()
+	return result
+}
+
+// checkUpdateHealth runs the critical readiness checks for the updater,
+// which rolls back a new version that does not pass them.
+func (r *Router) checkUpdateHealth(ctx context.Context) error {
+	for _, check := range r.healthChecks() {
+		if !check.critical {
+			continue
+		}
+		if _, err := check.run(ctx); err != nil {
+			return fmt.Errorf("%s: %w", check.name, err)
+		}
+	}
+	return nil
+}
+
+// healthChecks returns the checks that apply to the current configuration.
+func (r *Router) healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{name: "database", critical: true, liveness: true, run: checkDatabase},
+		{name: "blob_storage", critical: true, run: checkWritableDir(r.config.Storage.BlobPath)},
+		{name: "meta_storage", critical: true, run: checkWritableDir(r.config.Storage.MetaPath)},
+	}
+
+	// 空间不足时仍可拉取，不影响就绪
+	if r.registryService != nil && r.diskWatchEnabled() {
+		checks = append(checks, healthCheck{name: "disk_space", run: r.checkDiskSpace})
+	}
+
+	// 加速器关闭时不使用缓存目录
+	if r.acceleratorHandler != nil {
+		checks = append(checks, healthCheck{name: "cache", critical: true, run: checkWritableDir(r.config.Storage.CachePath)})
+		if r.config.Health.CheckUpstreams {
+			checks = append(checks, healthCheck{name: "upstreams", run: r.checkUpstreams})
+		}
+	}
+
+	if r.config.P2P != nil && r.config.P2P.Enabled {
+		checks = append(checks, healthCheck{name: "p2p", run: r.checkP2P})
+	}
+
+	if r.leaderElector != nil {
+		checks = append(checks, healthCheck{name: "cluster", run: r.checkCluster})
+	}
+
+	// Redis 故障时各组件回退到本地状态，不影响就绪
+	if r.redis != nil {
+		checks = append(checks, healthCheck{name: "redis", run: r.checkRedis})
+	}
+
+	return checks
+}
+
+// checkDatabase pings the database and runs a trivial query.
+func checkDatabase(ctx context.Context) (string, error) {
+	db := dao.GetDB()
+	if db == nil {
+		return "", errors.New("database not initialized")
+	}
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// checkWritableDir returns a check that creates and removes a file in dir.
+func checkWritableDir(dir string) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		file, err := os.CreateTemp(dir, ".health-*")
+		if err != nil {
+			return "", err
+		}
+		name := file.Name()
+		_, err = file.WriteString("ok")
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if removeErr := os.Remove(name); err == nil {
+			err = removeErr
+		}
+		return dir, err
+	}
+}
+
+// checkUpstreams reports whether at least one enabled accelerator upstream
+// answers the registry API. Results are cached for upstreamCheckTTL.
+func (r *Router) checkUpstreams(ctx context.Context) (string, error) {
+	cache := &r.upstreamHealth
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if time.Since(cache.checked) < upstreamCheckTTL {
+		return cache.detail, cache.err
+	}
+
+	proxy := r.acceleratorHandler.GetProxy()
+	var enabled []string
+	for _, u := range proxy.GetUpstreams() {
+		if u.Enabled {
+			enabled = append(enabled, u.Name)
+		}
+	}
+	if len(enabled) == 0 {
+		return "no upstreams enabled", nil
+	}
+
+	reachable := make(chan bool, len(enabled))
+	for _, name := range enabled {
+		go func(name string) {
+			ok, _ := proxy.CheckUpstreamHealthContext(ctx, name)
+			reachable <- ok
+		}(name)
+	}
+	count := 0
+	for range enabled {
+		if <-reachable {
+			count++
+		}
+	}
+
+	cache.checked = time.Now()
+	cache.detail = fmt.Sprintf("%d/%d upstreams reachable", count, len(enabled))
+	cache.err = nil
+	if count == 0 {
+		cache.err = errors.New("no upstream reachable")
+	}
+	return cache.detail, cache.err
+}
+
+// checkP2P reports whether the enabled P2P node is running.
+func (r *Router) checkP2P(ctx context.Context) (string, error) {
+	if r.p2pService == nil {
+		return "", errors.New("P2P service failed to initialize")
+	}
+	status := r.p2pService.GetStatus()
+	if !status.Running {
+		return "", errors.New("P2P node not running")
+	}
+	return fmt.Sprintf("%d peers connected", status.ConnectedPeers), nil
+}
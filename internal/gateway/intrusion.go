@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// recordAttempt records an access attempt for the intrusion detection rules.
+func (r *Router) recordAttempt(c *gin.Context, action, resource, status, code string) {
+	if r.intrusionService == nil {
+		return
+	}
+	attempt := &service.AccessAttempt{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Action:    action,
+		Resource:  resource,
+		Status:    status,
+		ErrorMsg:  code,
+	}
+	if user := currentUser(c); user != nil {
+		attempt.UserID = user.ID
+	}
+	r.intrusionService.RecordAttempt(attempt)
+}
+
+// notFoundRecorder records anonymous requests answered with 404, the input
+// of the path_fuzzing rule. HEAD requests are skipped since clients use
+// them to probe for blobs.
+func (r *Router) notFoundRecorder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Writer.Status() != http.StatusNotFound || c.Request.Method == http.MethodHead {
+			return
+		}
+		if currentUser(c) != nil {
+			return
+		}
+		r.recordAttempt(c, service.AttemptNotFound, c.Request.URL.Path, "failure", "not_found")
+	}
+}
+
+// notifyIntrusion sends intrusion alerts to the web console of
+// administrators and, when the email channel is configured, to the alert
+// recipients.
+func (r *Router) notifyIntrusion(level, title, message string) {
+	if r.wsHandler != nil {
+		r.wsHandler.NotifyAdmins(level, title, message)
+	}
+
+	r.configMu.RLock()
+	ids := r.config.Security.IntrusionDetection
+	r.configMu.RUnlock()
+	if len(ids.NotifyChannels) > 0 && !containsChannel(ids.NotifyChannels, "email") {
+		return
+	}
+	r.sendAlertEmail(title, message, "发送入侵告警邮件失败")
+}
+
+// sendAlertEmail mails a system alert to the recipients of the email
+// channel, if it is enabled. failMsg is logged when sending fails.
+func (r *Router) sendAlertEmail(title, message, failMsg string) {
+	r.configMu.RLock()
+	email := r.config.Notify.Channels.Email
+	r.configMu.RUnlock()
+	if !email.Enabled || len(email.To) == 0 {
+		return
+	}
+	mailer, err := service.NewSMTPMailer(email.SMTPHost, email.SMTPPort, email.Username, email.Password, email.From)
+	if err != nil {
+		logger.Warn("邮件通道配置无效", zap.Error(err))
+		return
+	}
+	go func() {
+		if err := mailer.Send(email.To, "[CYP-Registry] "+title, message); err != nil {
+			logger.Warn(failMsg, zap.Error(err))
+		}
+	}()
+}
+
+func containsChannel(channels []string, channel string) bool {
+	for _, c := range channels {
+		if strings.EqualFold(c, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentUser returns the authenticated user of the request, if any.
+func currentUser(c *gin.Context) *service.User {
+	if v, ok := c.Get("currentUser"); ok {
+		if user, ok := v.(*service.User); ok {
+			return user
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestCounter counts the requests handled, including rejected ones.
+func (r *Router) requestCounter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		r.requestCount.Add(1)
+	}
+}
+
+// metricsHandler exports metrics in the Prometheus text format.
+func (r *Router) metricsHandler(c *gin.Context) {
+	var b strings.Builder
+
+	b.WriteString("# HELP cyp_http_requests_total HTTP requests handled.\n")
+	b.WriteString("# TYPE cyp_http_requests_total counter\n")
+	fmt.Fprintf(&b, "cyp_http_requests_total %d\n", r.requestCount.Load())
+
+	if r.rateLimiter != nil {
+		stats := r.rateLimiter.Stats()
+
+		b.WriteString("# HELP cyp_rate_limit_requests_total Requests checked by the rate limiter.\n")
+		b.WriteString("# TYPE cyp_rate_limit_requests_total counter\n")
+		for _, s := range stats {
+			fmt.Fprintf(&b, "cyp_rate_limit_requests_total{class=%q,result=\"allowed\"} %d\n", s.Class, s.Allowed)
+			fmt.Fprintf(&b, "cyp_rate_limit_requests_total{class=%q,result=\"limited\"} %d\n", s.Class, s.Limited)
+		}
+
+		b.WriteString("# HELP cyp_rate_limit_active_keys Clients with an active token bucket.\n")
+		b.WriteString("# TYPE cyp_rate_limit_active_keys gauge\n")
+		for _, s := range stats {
+			fmt.Fprintf(&b, "cyp_rate_limit_active_keys{class=%q} %d\n", s.Class, s.ActiveKeys)
+		}
+
+		b.WriteString("# HELP cyp_rate_limit_qps Configured requests per second.\n")
+		b.WriteString("# TYPE cyp_rate_limit_qps gauge\n")
+		for _, s := range stats {
+			fmt.Fprintf(&b, "cyp_rate_limit_qps{class=%q} %g\n", s.Class, s.QPS)
+		}
+
+		b.WriteString("# HELP cyp_rate_limit_burst Configured burst size.\n")
+		b.WriteString("# TYPE cyp_rate_limit_burst gauge\n")
+		for _, s := range stats {
+			fmt.Fprintf(&b, "cyp_rate_limit_burst{class=%q} %d\n", s.Class, s.Burst)
+		}
+	}
+
+	if r.expirySweeper != nil {
+		stats := r.expirySweeper.Stats()
+		removed := []struct {
+			kind  string
+			count int64
+		}{
+			{"sessions", stats.Removed.Sessions},
+			{"tokens", stats.Removed.Tokens},
+			{"share_links", stats.Removed.ShareLinks},
+			{"pull_tokens", stats.Removed.PullTokens},
+			{"uploads", stats.Removed.Uploads},
+		}
+
+		b.WriteString("# HELP cyp_expiry_sweep_removed_total Expired items removed by the sweeper.\n")
+		b.WriteString("# TYPE cyp_expiry_sweep_removed_total counter\n")
+		for _, item := range removed {
+			fmt.Fprintf(&b, "cyp_expiry_sweep_removed_total{kind=%q} %d\n", item.kind, item.count)
+		}
+
+		b.WriteString("# HELP cyp_expiry_sweep_runs_total Sweeper runs.\n")
+		b.WriteString("# TYPE cyp_expiry_sweep_runs_total counter\n")
+		fmt.Fprintf(&b, "cyp_expiry_sweep_runs_total{result=\"success\"} %d\n", stats.Runs-stats.Failures)
+		fmt.Fprintf(&b, "cyp_expiry_sweep_runs_total{result=\"failure\"} %d\n", stats.Failures)
+
+		if !stats.LastRun.IsZero() {
+			b.WriteString("# HELP cyp_expiry_sweep_last_run_timestamp_seconds Time of the last sweeper run.\n")
+			b.WriteString("# TYPE cyp_expiry_sweep_last_run_timestamp_seconds gauge\n")
+			fmt.Fprintf(&b, "cyp_expiry_sweep_last_run_timestamp_seconds %d\n", stats.LastRun.Unix())
+		}
+	}
+
+	if r.registryService != nil {
+		if status := r.registryService.DiskStatus(); status != nil {
+			b.WriteString("# HELP cyp_storage_disk_total_bytes Size of the blob volume.\n")
+			b.WriteString("# TYPE cyp_storage_disk_total_bytes gauge\n")
+			fmt.Fprintf(&b, "cyp_storage_disk_total_bytes %d\n", status.Total)
+
+			b.WriteString("# HELP cyp_storage_disk_free_bytes Free space of the blob volume.\n")
+			b.WriteString("# TYPE cyp_storage_disk_free_bytes gauge\n")
+			fmt.Fprintf(&b, "cyp_storage_disk_free_bytes %d\n", status.Free)
+
+			readOnly := 0
+			if status.ReadOnly {
+				readOnly = 1
+			}
+			b.WriteString("# HELP cyp_storage_read_only Whether pushes are refused for lack of free space.\n")
+			b.WriteString("# TYPE cyp_storage_read_only gauge\n")
+			fmt.Fprintf(&b, "cyp_storage_read_only %d\n", readOnly)
+		}
+
+		b.WriteString("# HELP cyp_registry_active_uploads Blob upload sessions in progress.\n")
+		b.WriteString("# TYPE cyp_registry_active_uploads gauge\n")
+		fmt.Fprintf(&b, "cyp_registry_active_uploads %d\n", r.registryService.ActiveUploads())
+	}
+
+	if r.acceleratorHandler != nil {
+		stats := r.acceleratorHandler.GetProxy().GetCache().Stats()
+
+		b.WriteString("# HELP cyp_accelerator_cache_requests_total Accelerator cache lookups.\n")
+		b.WriteString("# TYPE cyp_accelerator_cache_requests_total counter\n")
+		fmt.Fprintf(&b, "cyp_accelerator_cache_requests_total{result=\"hit\"} %d\n", stats.HitCount)
+		fmt.Fprintf(&b, "cyp_accelerator_cache_requests_total{result=\"miss\"} %d\n", stats.MissCount)
+
+		b.WriteString("# HELP cyp_accelerator_cache_size_bytes Size of the accelerator cache.\n")
+		b.WriteString("# TYPE cyp_accelerator_cache_size_bytes gauge\n")
+		fmt.Fprintf(&b, "cyp_accelerator_cache_size_bytes %d\n", stats.TotalSize)
+	}
+
+	if r.p2pService != nil {
+		status := r.p2pService.GetStatus()
+
+		b.WriteString("# HELP cyp_p2p_connected_peers Connected P2P peers.\n")
+		b.WriteString("# TYPE cyp_p2p_connected_peers gauge\n")
+		fmt.Fprintf(&b, "cyp_p2p_connected_peers %d\n", status.ConnectedPeers)
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
@@ -2,6 +2,10 @@ package gateway
 
 import (
 	"cyp-docker-registry/internal/common"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,17 +15,49 @@ import (
 // logger is the package-level logger instance.
 var logger *zap.Logger
 
+// accessLogger receives the request logs when logging.access is
+// configured. Without it they go to logger.
+var accessLogger *zap.Logger
+
+// logLevel is the level of logger, adjusted when the configuration is
+// reloaded. It is nil when the level cannot be changed.
+var logLevel *zap.AtomicLevel
+
 // InitLogger initializes the package logger.
 func InitLogger(l *zap.Logger) {
 	logger = l
 }
 
+// InitAccessLogger sets the logger for request logs, nil keeps them in the
+// package logger.
+func InitAccessLogger(l *zap.Logger) {
+	accessLogger = l
+}
+
+// InitLogLevel sets the level that logging.level of the configuration controls.
+func InitLogLevel(level zap.AtomicLevel) {
+	logLevel = &level
+}
+
+// RequestIDMiddleware returns a middleware that assigns every request a
+// correlation ID. A valid X-Request-ID sent by the client is kept. The ID is
+// returned in the X-Request-ID response header and attached to request logs,
+// error responses and audit entries. The arrival time is recorded for
+// handlers reporting durations.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		common.RequestStart(c)
+		common.RequestID(c)
+		c.Next()
+	}
+}
+
 // LoggingMiddleware returns a middleware that logs HTTP requests.
 func LoggingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 		path := c.Request.URL.Path
-		query := c.Request.URL.RawQuery
+		query := redactQuery(c.Request.URL.RawQuery)
 
 		// Process request
 		c.Next()
@@ -30,8 +66,13 @@ func LoggingMiddleware() gin.HandlerFunc {
 		latency := time.Since(start)
 
 		// Log request details
-		if logger != nil {
-			logger.Info("HTTP Request",
+		l := accessLogger
+		if l == nil {
+			l = logger
+		}
+		if l != nil {
+			l.Info("HTTP Request",
+				zap.String("request_id", common.RequestID(c)),
 				zap.String("method", c.Request.Method),
 				zap.String("path", path),
 				zap.String("query", query),
@@ -44,6 +85,31 @@ func LoggingMiddleware() gin.HandlerFunc {
 	}
 }
 
+// redactedQueryParams are query parameters that carry credentials, such as
+// the WebSocket token, and are masked in request logs.
+var redactedQueryParams = map[string]bool{
+	"token":        true,
+	"access_token": true,
+	"secret":       true,
+	"password":     true,
+}
+
+// redactQuery masks the values of credential parameters in a raw query,
+// keeping the order and encoding of the other parameters.
+func redactQuery(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	parts := strings.Split(raw, "&")
+	for i, part := range parts {
+		key, _, _ := strings.Cut(part, "=")
+		if name, err := url.QueryUnescape(key); err == nil && redactedQueryParams[strings.ToLower(name)] {
+			parts[i] = key + "=REDACTED"
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
 // ErrorHandlingMiddleware returns a middleware that handles panics and errors.
 func ErrorHandlingMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -52,6 +118,7 @@ func ErrorHandlingMiddleware() gin.HandlerFunc {
 				// Log the panic
 				if logger != nil {
 					logger.Error("Panic recovered",
+						zap.String("request_id", common.RequestID(c)),
 						zap.Any("error", err),
 						zap.String("path", c.Request.URL.Path),
 					)
@@ -72,6 +139,7 @@ func ErrorHandlingMiddleware() gin.HandlerFunc {
 			err := c.Errors.Last()
 			if logger != nil {
 				logger.Error("Request error",
+					zap.String("request_id", common.RequestID(c)),
 					zap.Error(err.Err),
 					zap.String("path", c.Request.URL.Path),
 				)
@@ -80,18 +148,68 @@ func ErrorHandlingMiddleware() gin.HandlerFunc {
 	}
 }
 
-// CORSMiddleware returns a middleware that handles CORS.
-func CORSMiddleware() gin.HandlerFunc {
+// CORSMiddleware returns a middleware that handles CORS. Requests under /v2
+// use the registry policy, all others the API policy. Disallowed origins
+// get no CORS headers, so browsers block the response.
+func CORSMiddleware(config common.CORSConfig, production bool) gin.HandlerFunc {
+	api := newCORSHeaders(config.APIPolicy(production))
+	registry := newCORSHeaders(config.RegistryPolicy(production))
+
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		headers := api
+		if path := c.Request.URL.Path; path == "/v2" || strings.HasPrefix(path, "/v2/") {
+			headers = registry
+		}
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		origin := c.GetHeader("Origin")
+		anyOrigin := headers.policy.AllowsAnyOrigin()
+		if !anyOrigin {
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if headers.policy.AllowsOrigin(origin) {
+			if anyOrigin {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+			if headers.policy.AllowCredentials {
+				c.Header("Access-Control-Allow-Credentials", "true")
+			}
+			c.Header("Access-Control-Expose-Headers", headers.exposed)
+
+			if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+				c.Header("Access-Control-Allow-Methods", headers.methods)
+				c.Header("Access-Control-Allow-Headers", headers.allowed)
+				if headers.policy.MaxAge > 0 {
+					c.Header("Access-Control-Max-Age", headers.maxAge)
+				}
+			}
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
 		c.Next()
 	}
 }
+
+// corsHeaders holds the header values of a CORS policy, joined once.
+type corsHeaders struct {
+	policy  common.CORSPolicy
+	methods string
+	allowed string
+	exposed string
+	maxAge  string
+}
+
+func newCORSHeaders(policy common.CORSPolicy) corsHeaders {
+	return corsHeaders{
+		policy:  policy,
+		methods: strings.Join(policy.AllowedMethods, ", "),
+		allowed: strings.Join(policy.AllowedHeaders, ", "),
+		exposed: strings.Join(policy.ExposedHeaders, ", "),
+		maxAge:  strconv.Itoa(policy.MaxAge),
+	}
+}
@@ -0,0 +1,257 @@
+package gateway
+
+//go:generate go run ../../cmd/openapi-gen -root ../.. -o openapi_docs_gen.go
+
+import (
+	_ "embed"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"cyp-docker-registry/internal/version"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handlerDoc is the documentation of one HTTP handler, extracted from its
+// doc comment by cmd/openapi-gen.
+type handlerDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []docParam
+}
+
+// docParam is a parameter declared with a swag style @Param annotation.
+type docParam struct {
+	Name        string
+	In          string
+	Type        string
+	Required    bool
+	Description string
+}
+
+//go:embed swagger_ui.html
+var swaggerUIPage []byte
+
+var (
+	openAPIOnce sync.Once
+	openAPISpec gin.H
+)
+
+// openAPIHandler serves the OpenAPI 3 spec of the registered routes.
+func (r *Router) openAPIHandler(c *gin.Context) {
+	openAPIOnce.Do(func() {
+		openAPISpec = buildOpenAPISpec(r.engine.Routes())
+	})
+	c.JSON(http.StatusOK, openAPISpec)
+}
+
+// apiDocsHandler serves the Swagger UI page for the spec. The UI assets are
+// loaded from the jsDelivr CDN, so the page relaxes the default CSP.
+func (r *Router) apiDocsHandler(c *gin.Context) {
+	c.Header("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net; img-src 'self' data:")
+	c.Data(http.StatusOK, "text/html; charset=utf-8", swaggerUIPage)
+}
+
+// buildOpenAPISpec builds the spec from the routes gin has registered, so it
+// lists exactly the routes the server serves. Descriptions come from the
+// generated handlerDocs table.
+func buildOpenAPISpec(routes gin.RoutesInfo) gin.H {
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+
+	paths := gin.H{}
+	for _, route := range routes {
+		if route.Method == http.MethodHead && hasRoute(routes, http.MethodGet, route.Path) {
+			continue
+		}
+
+		path, pathParams := openAPIPath(route.Path)
+		item, ok := paths[path].(gin.H)
+		if !ok {
+			item = gin.H{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = openAPIOperation(route, pathParams)
+	}
+
+	return gin.H{
+		"openapi": "3.0.3",
+		"info": gin.H{
+			"title":   "CYP-Docker-Registry API",
+			"version": version.GetVersion(),
+		},
+		"paths": paths,
+		"components": gin.H{
+			"securitySchemes": gin.H{
+				"bearerAuth": gin.H{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+			},
+			"schemas": gin.H{
+				"Error": gin.H{
+					"type":     "object",
+					"required": []string{"success", "error", "code"},
+					"properties": gin.H{
+						"success":    gin.H{"type": "boolean"},
+						"error":      gin.H{"type": "string"},
+						"code":       gin.H{"type": "string"},
+						"message":    gin.H{"type": "string"},
+						"request_id": gin.H{"type": "string"},
+					},
+				},
+			},
+		},
+		"security": []gin.H{{"bearerAuth": []string{}}, {}},
+	}
+}
+
+// openAPIOperation describes a single route.
+func openAPIOperation(route gin.RouteInfo, pathParams []string) gin.H {
+	name := handlerName(route.Handler)
+	doc := handlerDocs[name]
+
+	op := gin.H{
+		"operationId": strings.ToLower(route.Method) + strings.NewReplacer("/", "_", ":", "", "*", "", "-", "_").Replace(route.Path),
+		"responses": gin.H{
+			"200": gin.H{"description": "OK"},
+			"default": gin.H{
+				"description": "Error",
+				"content": gin.H{
+					"application/json": gin.H{"schema": gin.H{"$ref": "#/components/schemas/Error"}},
+				},
+			},
+		},
+	}
+	if doc.Summary != "" {
+		op["summary"] = doc.Summary
+	}
+	if doc.Description != "" {
+		op["description"] = doc.Description
+	}
+	if len(doc.Tags) > 0 {
+		op["tags"] = doc.Tags
+	} else {
+		op["tags"] = []string{routeTag(route.Path)}
+	}
+
+	var params []gin.H
+	declared := make(map[string]bool)
+	var formFields gin.H
+	for _, p := range doc.Params {
+		switch p.In {
+		case "path", "query", "header":
+			declared[p.In+":"+p.Name] = true
+			params = append(params, gin.H{
+				"name":        p.Name,
+				"in":          p.In,
+				"required":    p.Required || p.In == "path",
+				"description": p.Description,
+				"schema":      gin.H{"type": openAPIType(p.Type)},
+			})
+		case "formData":
+			if formFields == nil {
+				formFields = gin.H{}
+			}
+			schema := gin.H{"type": openAPIType(p.Type), "description": p.Description}
+			if p.Type == "file" {
+				schema = gin.H{"type": "string", "format": "binary", "description": p.Description}
+			}
+			formFields[p.Name] = schema
+		case "body":
+			op["requestBody"] = gin.H{
+				"required":    p.Required,
+				"description": p.Description,
+				"content":     gin.H{"application/json": gin.H{"schema": gin.H{"type": "object"}}},
+			}
+		}
+	}
+	for _, name := range pathParams {
+		if declared["path:"+name] {
+			continue
+		}
+		params = append(params, gin.H{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   gin.H{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+	if formFields != nil {
+		op["requestBody"] = gin.H{
+			"content": gin.H{
+				"multipart/form-data": gin.H{"schema": gin.H{"type": "object", "properties": formFields}},
+			},
+		}
+	}
+	return op
+}
+
+// openAPIPath converts a gin path such as /api/v1/share/:code/*path to the
+// OpenAPI form /api/v1/share/{code}/{path} and returns the parameter names.
+func openAPIPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			name := seg[1:]
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// handlerName turns the function name gin reports, e.g.
+// "cyp-docker-registry/internal/handler.(*OrgHandler).ListOrgs-fm", into the
+// key used by handlerDocs.
+func handlerName(name string) string {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.TrimSuffix(name, "-fm")
+}
+
+// routeTag groups undocumented routes by the first path segment after the
+// API version.
+func routeTag(path string) string {
+	if strings.HasPrefix(path, "/v2") {
+		return "registry"
+	}
+	path = strings.TrimPrefix(path, "/api")
+	path = strings.TrimPrefix(path, "/v1")
+	seg := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+	if seg == "" || strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+		return "system"
+	}
+	return seg
+}
+
+// openAPIType maps swag parameter types to OpenAPI schema types.
+func openAPIType(t string) string {
+	switch t {
+	case "int", "integer", "int64", "uint":
+		return "integer"
+	case "bool", "boolean":
+		return "boolean"
+	case "number", "float", "float64":
+		return "number"
+	}
+	return "string"
+}
+
+func hasRoute(routes gin.RoutesInfo, method, path string) bool {
+	for _, route := range routes {
+		if route.Method == method && route.Path == path {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/common"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// proxyTestTimeout bounds one connectivity test.
+const proxyTestTimeout = 15 * time.Second
+
+// Default targets of the connectivity test, per subsystem.
+var proxyTestTargets = map[string]string{
+	common.ProxyAccelerator: "https://registry-1.docker.io/v2/",
+	common.ProxySync:        "https://registry-1.docker.io/v2/",
+	common.ProxyUpdate:      "https://api.github.com",
+	common.ProxyScanner:     "https://ghcr.io/v2/", // trivy 漏洞库发布在 ghcr.io
+}
+
+// ProxyStatus describes the proxy of an outbound subsystem.
+type ProxyStatus struct {
+	Subsystem string   `json:"subsystem"`
+	Proxy     string   `json:"proxy"` // 代理地址（不含密码）、environment 或 direct
+	NoProxy   []string `json:"no_proxy,omitempty"`
+	Auth      bool     `json:"auth"`
+	Target    string   `json:"target"`
+}
+
+// ProxyTestRequest is the body of a connectivity test. Without url the
+// default target of the subsystem is used.
+type ProxyTestRequest struct {
+	Subsystem string `json:"subsystem" binding:"required"`
+	URL       string `json:"url"`
+}
+
+// ProxyTestResult is the outcome of a connectivity test. Any HTTP response
+// counts as reachable, including 401 from registries that require a token.
+type ProxyTestResult struct {
+	Subsystem  string `json:"subsystem"`
+	Target     string `json:"target"`
+	Proxy      string `json:"proxy"`
+	Reachable  bool   `json:"reachable"`
+	StatusCode int    `json:"status_code,omitempty"`
+	LatencyMs  int64  `json:"latency_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// applyProxy sets the outbound proxy of every subsystem after a reload.
+func (r *Router) applyProxy(config common.ProxyConfig) {
+	if r.acceleratorHandler != nil {
+		r.acceleratorHandler.GetProxy().SetOutboundProxy(outboundProxy(config, common.ProxyAccelerator))
+	}
+	if r.syncService != nil {
+		r.syncService.SetOutboundProxy(outboundProxy(config, common.ProxySync))
+	}
+	if r.updaterService != nil {
+		r.updaterService.SetOutboundProxy(outboundProxy(config, common.ProxyUpdate))
+	}
+	if r.sbomService != nil {
+		r.sbomService.SetCommandEnv(config.For(common.ProxyScanner).CommandEnv())
+	}
+	if r.vulnDB != nil {
+		r.vulnDB.SetOutboundProxy(outboundProxy(config, common.ProxyScanner))
+	}
+}
+
+// outboundProxy returns the proxy selection of a subsystem. Invalid
+// settings are rejected by the config validation; should one slip through,
+// the environment variables apply.
+func outboundProxy(config common.ProxyConfig, subsystem string) func(*http.Request) (*url.URL, error) {
+	proxy, err := config.For(subsystem).ProxyFunc()
+	if err != nil {
+		logger.Warn("代理配置无效，使用环境变量", zap.String("subsystem", subsystem), zap.Error(err))
+		return http.ProxyFromEnvironment
+	}
+	return proxy
+}
+
+// proxyTestTarget returns the default connectivity test target. The
+// accelerator is tested against its first enabled upstream.
+func (r *Router) proxyTestTarget(subsystem string) string {
+	if subsystem == common.ProxyAccelerator && r.acceleratorHandler != nil {
+		for _, upstream := range r.acceleratorHandler.GetProxy().GetUpstreams() {
+			if upstream.Enabled && upstream.URL != "" {
+				return strings.TrimSuffix(upstream.URL, "/") + "/v2/"
+			}
+		}
+	}
+	return proxyTestTargets[subsystem]
+}
+
+// proxyStatusHandler lists the proxy of every outbound subsystem.
+func (r *Router) proxyStatusHandler(c *gin.Context) {
+	r.configMu.RLock()
+	config := r.config.Proxy
+	r.configMu.RUnlock()
+
+	list := make([]ProxyStatus, 0, len(common.ProxySubsystems))
+	for _, subsystem := range common.ProxySubsystems {
+		settings := config.For(subsystem)
+		list = append(list, ProxyStatus{
+			Subsystem: subsystem,
+			Proxy:     settings.Describe(),
+			NoProxy:   settings.NoProxy,
+			Auth:      settings.Username != "",
+			Target:    r.proxyTestTarget(subsystem),
+		})
+	}
+	common.SuccessResponse(c, gin.H{"proxies": list})
+}
+
+// proxyTestHandler sends a request through the proxy of a subsystem.
+func (r *Router) proxyTestHandler(c *gin.Context) {
+	var req ProxyTestRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+	if _, ok := proxyTestTargets[req.Subsystem]; !ok {
+		common.Error(c, http.StatusBadRequest, "未知的子系统，可选 "+strings.Join(common.ProxySubsystems, "、"))
+		return
+	}
+	target := req.URL
+	if target == "" {
+		target = r.proxyTestTarget(req.Subsystem)
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		common.Error(c, http.StatusBadRequest, "测试地址须为 http 或 https URL")
+		return
+	}
+
+	r.configMu.RLock()
+	settings := r.config.Proxy.For(req.Subsystem)
+	r.configMu.RUnlock()
+
+	result := &ProxyTestResult{
+		Subsystem: req.Subsystem,
+		Target:    target,
+		Proxy:     settings.Describe(),
+	}
+	proxy, err := settings.ProxyFunc()
+	if err != nil {
+		result.Error = err.Error()
+		common.SuccessResponse(c, result)
+		return
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		// 只测试连通性，不跟随重定向
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), proxyTestTimeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		result.Error = err.Error()
+		common.SuccessResponse(c, result)
+		return
+	}
+
+	start := time.Now()
+	resp, err := client.Do(httpReq)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	switch {
+	case err != nil:
+		result.Error = proxyTestError(err)
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		resp.Body.Close()
+		result.StatusCode = resp.StatusCode
+		result.Error = "代理认证失败"
+	default:
+		resp.Body.Close()
+		result.StatusCode = resp.StatusCode
+		result.Reachable = true
+	}
+	common.SuccessResponse(c, result)
+}
+
+// proxyTestError describes a failed connectivity test. HTTPS requests
+// report a rejected proxy login as an error of the CONNECT request.
+func proxyTestError(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "连接超时"
+	case strings.Contains(err.Error(), http.StatusText(http.StatusProxyAuthRequired)):
+		return "代理认证失败"
+	}
+	return err.Error()
+}
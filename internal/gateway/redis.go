@@ -0,0 +1,52 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"cyp-docker-registry/internal/service"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// initRedis connects to Redis so instances share hot state. When Redis is
+// disabled or unreachable at startup the instance keeps its state locally.
+func (r *Router) initRedis() {
+	cfg := r.config.Redis
+	if !cfg.Enabled {
+		return
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		logger.Warn("Redis 不可用，使用本地模式", zap.String("addr", cfg.Addr), zap.Error(err))
+		client.Close()
+		return
+	}
+	r.redis = client
+
+	if cfg.Sessions && r.authService != nil {
+		r.authService.SetSessionStore(service.NewRedisSessionStore(client, cfg.KeyPrefix, logger))
+	}
+	logger.Info("已连接 Redis",
+		zap.String("addr", cfg.Addr),
+		zap.Bool("cache", cfg.Cache),
+		zap.Bool("sessions", cfg.Sessions),
+		zap.Bool("rate_limit", cfg.RateLimit),
+	)
+}
+
+// checkRedis pings Redis.
+func (r *Router) checkRedis(ctx context.Context) (string, error) {
+	if err := r.redis.Ping(ctx).Err(); err != nil {
+		return "", err
+	}
+	return r.config.Redis.Addr, nil
+}
@@ -0,0 +1,284 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registryRealm is sent in the basic auth challenge of the /v2 API.
+const registryRealm = "CYP-Docker-Registry"
+
+// v2RepositoryPattern extracts the repository name from /v2 API paths.
+var v2RepositoryPattern = regexp.MustCompile(`^/v2/(.+?)/(manifests|blobs|tags|referrers)/`)
+
+// createRegistryAuthMiddleware creates the authentication middleware of the
+// Docker Registry V2 API. When auth is enabled, pulls are checked against the
+// repository visibility, so public repositories can be pulled anonymously,
+// while pushes and deletes always require write permission on the repository.
+func (r *Router) createRegistryAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// A required client certificate applies to every /v2 request
+		if identity, err := r.clientCertificate(c); err != nil {
+			subject := ""
+			if identity != nil {
+				subject = identity.Subject
+			}
+			if r.auditService != nil {
+				r.auditService.LogAuthFailure(c.ClientIP(), subject, "registry: "+err.Error(), common.RequestID(c))
+			}
+			r.recordAttempt(c, service.AttemptLogin, subject, "failure", "invalid_certificate")
+			registryUnauthorized(c, err.Error())
+			return
+		}
+		if !r.config.Auth.Enabled {
+			c.Next()
+			return
+		}
+
+		// Share link pull tokens only allow pulling the shared image
+		if username, password, ok := c.Request.BasicAuth(); ok && strings.HasPrefix(password, service.SharePullTokenPrefix) {
+			r.shareTokenAccess(c, username, password)
+			return
+		}
+
+		user, err := r.registryUser(c)
+		if err != nil {
+			username, password, basic := c.Request.BasicAuth()
+			if r.auditService != nil {
+				r.auditService.LogAuthFailure(c.ClientIP(), username, "registry: "+err.Error(), common.RequestID(c))
+			}
+			if basic && !strings.HasPrefix(password, patPrefix) {
+				r.recordAttempt(c, service.AttemptLogin, username, "failure", "login_failure")
+			} else {
+				r.recordAttempt(c, service.AttemptToken, c.Request.URL.Path, "failure", "invalid_token")
+			}
+			registryUnauthorized(c, "invalid credentials")
+			return
+		}
+		if user != nil {
+			c.Set("currentUser", user)
+		}
+
+		m := v2RepositoryPattern.FindStringSubmatch(c.Request.URL.Path)
+		if m == nil {
+			// /v2/ and /v2/_catalog: docker login relies on the 401 challenge
+			if user == nil {
+				registryUnauthorized(c, "authentication required")
+				return
+			}
+			c.Next()
+			return
+		}
+
+		name := m[1]
+		action := "push"
+		switch {
+		case strings.Contains(c.Request.URL.Path, "/blobs/uploads/"):
+			// Checking or cancelling an upload is part of pushing
+		case m[2] == "referrers", c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead:
+			// Referrers are read-only and reveal signatures and attestations
+			action = "pull"
+		case c.Request.Method == http.MethodDelete:
+			action = "delete"
+		}
+
+		var allowed bool
+		switch action {
+		case "pull":
+			allowed = r.repositoryService.CanPull(user, name)
+		case "delete":
+			allowed = r.repositoryService.CanDelete(user, name)
+		default:
+			allowed = r.repositoryService.CanPush(user, name)
+		}
+		// Personal access tokens are further limited by their scopes
+		if token := currentToken(c); allowed && token != nil {
+			allowed = service.ScopeAllowsRepository(token.Scopes, name, action)
+		}
+		if !allowed {
+			if user == nil {
+				registryUnauthorized(c, "authentication required")
+				return
+			}
+			c.Header("Docker-Distribution-API-Version", "registry/2.0")
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"errors": []gin.H{{
+					"code":    "DENIED",
+					"message": "requested access to the resource is denied",
+					"detail":  gin.H{"repository": name},
+				}},
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// canMountFrom reports whether the client of a blob mount may pull from the
+// source repository. The target repository was already checked for push.
+func (r *Router) canMountFrom(c *gin.Context, repository string) bool {
+	if !r.config.Auth.Enabled {
+		return true
+	}
+	var user *service.User
+	if u, ok := c.Get("currentUser"); ok {
+		user, _ = u.(*service.User)
+	}
+	if !r.repositoryService.CanPull(user, repository) {
+		return false
+	}
+	if token := currentToken(c); token != nil {
+		return service.ScopeAllowsRepository(token.Scopes, repository, "pull")
+	}
+	return true
+}
+
+// canPushTo reports whether the client of an image API request may write to
+// a repository, with the same checks as a push through /v2.
+func (r *Router) canPushTo(c *gin.Context, repository string) bool {
+	if !r.config.Auth.Enabled {
+		return true
+	}
+	if !r.repositoryService.CanPush(currentUser(c), repository) {
+		return false
+	}
+	if token := currentToken(c); token != nil {
+		return service.ScopeAllowsRepository(token.Scopes, repository, "push")
+	}
+	return true
+}
+
+// canDeleteFrom reports whether the client of an image API request may
+// delete images of a repository, with the same checks as a /v2 delete.
+func (r *Router) canDeleteFrom(c *gin.Context, repository string) bool {
+	if !r.config.Auth.Enabled {
+		return true
+	}
+	if !r.repositoryService.CanDelete(currentUser(c), repository) {
+		return false
+	}
+	if token := currentToken(c); token != nil {
+		return service.ScopeAllowsRepository(token.Scopes, repository, "delete")
+	}
+	return true
+}
+
+// shareTokenAccess authorizes a /v2 request made with a share pull token.
+func (r *Router) shareTokenAccess(c *gin.Context, username, token string) {
+	if r.shareService == nil {
+		registryUnauthorized(c, "invalid credentials")
+		return
+	}
+	grant, err := r.shareService.ValidatePullToken(token)
+	if err != nil || grant.Code != username {
+		r.recordAttempt(c, service.AttemptToken, c.Request.URL.Path, "failure", "invalid_token")
+		registryUnauthorized(c, "invalid or expired share token")
+		return
+	}
+
+	// docker login checks the credentials against /v2/
+	if c.Request.URL.Path == "/v2/" || c.Request.URL.Path == "/v2" {
+		c.Next()
+		return
+	}
+
+	m := v2RepositoryPattern.FindStringSubmatch(c.Request.URL.Path)
+	readOnly := c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead
+	allowed := m != nil && readOnly && m[1] == grant.Repository
+	if allowed && (m[2] == "manifests" || m[2] == "referrers") {
+		// Only the shared tag, plus the digests it resolved to at exchange
+		reference := c.Request.URL.Path[strings.LastIndex(c.Request.URL.Path, "/")+1:]
+		allowed = grant.AllowsManifest(reference)
+	}
+	if !allowed {
+		c.Header("Docker-Distribution-API-Version", "registry/2.0")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"errors": []gin.H{{
+				"code":    "DENIED",
+				"message": "share token only allows pulling " + grant.Repository + ":" + grant.Tag,
+			}},
+		})
+		return
+	}
+
+	c.Next()
+}
+
+// registryUser resolves the credentials of a /v2 request. It returns nil
+// without error for anonymous requests.
+func (r *Router) registryUser(c *gin.Context) (*service.User, error) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		// The client certificate identifies requests without credentials
+		if v, ok := c.Get("clientCert"); ok {
+			return r.certificateUser(v.(*service.ClientCertIdentity))
+		}
+		return nil, nil
+	}
+
+	if strings.HasPrefix(authHeader, "Bearer ") {
+		if r.authService == nil {
+			return nil, errors.New("auth service unavailable")
+		}
+		user, err := r.authService.ValidateJWT(strings.TrimPrefix(authHeader, "Bearer "))
+		if err != nil {
+			return nil, err
+		}
+		if !user.IsActive {
+			return nil, errors.New("user is inactive")
+		}
+		return user, nil
+	}
+
+	username, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return nil, errors.New("unsupported authorization scheme")
+	}
+
+	// Static account from the auth section of the config file
+	if r.config.Auth.Username != "" && r.config.Auth.Password != "" &&
+		subtle.ConstantTimeCompare([]byte(username), []byte(r.config.Auth.Username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(r.config.Auth.Password)) == 1 {
+		return &service.User{Username: username, Role: "admin", IsActive: true}, nil
+	}
+
+	// Personal access tokens can be used as the docker login password
+	if strings.HasPrefix(password, patPrefix) {
+		user, token, err := r.tokenUser(password)
+		if err != nil {
+			return nil, err
+		}
+		if user.Username != username {
+			return nil, errors.New("invalid token owner")
+		}
+		c.Set("currentToken", token)
+		return user, nil
+	}
+
+	if r.authService == nil {
+		return nil, errors.New("auth service unavailable")
+	}
+	return r.authService.VerifyPassword(username, password)
+}
+
+// registryUnauthorized writes a V2 UNAUTHORIZED error with a basic auth
+// challenge.
+func registryUnauthorized(c *gin.Context, message string) {
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+	c.Header("WWW-Authenticate", `Basic realm="`+registryRealm+`"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"errors": []gin.H{{
+			"code":    "UNAUTHORIZED",
+			"message": message,
+		}},
+	})
+}
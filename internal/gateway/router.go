@@ -4,6 +4,7 @@ package gateway
 import (
 	"cyp-docker-registry/internal/accelerator"
 	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/dao"
 	"cyp-docker-registry/internal/detector"
 	"cyp-docker-registry/internal/handler"
 	"cyp-docker-registry/internal/middleware"
@@ -11,13 +12,19 @@ import (
 	"cyp-docker-registry/internal/service"
 	"cyp-docker-registry/internal/updater"
 	"cyp-docker-registry/internal/version"
+	"cyp-docker-registry/pkg/signature"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -25,10 +32,15 @@ import (
 type Router struct {
 	engine             *gin.Engine
 	config             *common.Config
+	configMu           sync.RWMutex // 保护可热加载的配置节
+	reloadMu           sync.Mutex
 	registryHandler    *registry.Handler
 	acceleratorHandler *accelerator.Handler
 	detectorHandler    *detector.Handler
+	detectorService    *detector.DetectorService
+	autoConfig         *detector.AutoConfig
 	updaterHandler     *updater.Handler
+	updaterService     *updater.UpdaterService
 	authHandler        *handler.AuthHandler
 	lockHandler        *handler.LockHandler
 	auditHandler       *handler.AuditHandler
@@ -39,6 +51,13 @@ type Router struct {
 	signatureHandler   *handler.SignatureHandler
 	sbomHandler        *handler.SBOMHandler
 	p2pHandler         *handler.P2PHandler
+	backupHandler      *handler.BackupHandler
+	workflowHandler    *handler.WorkflowHandler
+	statsHandler       *handler.StatsHandler
+	repositoryHandler  *handler.RepositoryHandler
+	userHandler        *handler.UserHandler
+	ipRuleHandler      *handler.IPRuleHandler
+	settingsHandler    *handler.SettingsHandler
 	authService        *service.AuthService
 	lockService        *service.LockService
 	intrusionService   *service.IntrusionService
@@ -46,18 +65,57 @@ type Router struct {
 	orgService         *service.OrgService
 	shareService       *service.ShareService
 	tokenService       *service.TokenService
+	userService        *service.UserService
+	ipRuleService      *service.IPRuleService
+	settingsService    *service.SettingsService
+	rateLimiter        *middleware.RateLimitMiddleware
+	repositoryService  *service.RepositoryService
 	signatureService   *service.SignatureService
+	clientCertService  *service.ClientCertService
 	sbomService        *service.SBOMService
 	dnsService         *service.DNSService
 	dnsHandler         *handler.DNSHandler
 	p2pService         *service.P2PService
 	globalService      *service.GlobalServiceManager
+	backupService      *service.BackupService
+	automationEngine   *service.AutomationEngine
+	expirySweeper      *service.ExpirySweeper
+	workflowService    *service.WorkflowService
+	promotionService   *service.PromotionService
+	promotionHandler   *handler.PromotionHandler
+	provenanceService  *service.ProvenanceService
+	provenanceHandler  *handler.ProvenanceHandler
+	licenseService     *service.LicenseService
+	licenseHandler     *handler.LicenseHandler
+	vulnDB             *service.VulnDB
+	vulnDBHandler      *handler.VulnDBHandler
+	securityHandler    *handler.SecurityHandler
+	complianceHandler  *handler.ComplianceHandler
+	digestService      *service.AccessDigestService
+	digestHandler      *handler.AccessDigestHandler
+	statsService       *service.ImageStatsService
+	eventLog           *service.RegistryEventLog
+	eventHandler       *handler.EventHandler
+	popularImages      *service.PopularImages
+	registryService    *registry.Service
+	syncService        *registry.SyncService
+	syncHandler        *registry.SyncHandler
+	upstreamHealth     upstreamHealth
+	leaderElector      *service.LeaderElector
+	redis              *redis.Client
+	requestCount       atomic.Int64 // 已处理的请求数，导出为 cyp_http_requests_total
 }
 
 // NewRouter creates a new Router instance.
-func NewRouter(config *common.Config) *Router {
+// It fails when no JWT secret is available, see initJWT.
+func NewRouter(config *common.Config) (*Router, error) {
 	gin.SetMode(gin.ReleaseMode)
 	engine := gin.New()
+	// 只信任配置的反向代理转发的客户端地址，否则 X-Forwarded-For 可伪造
+	// ClientIP，绕过 IP 规则和按 IP 的限流
+	if err := engine.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("server.trusted_proxies: %w", err)
+	}
 
 	r := &Router{
 		engine: engine,
@@ -65,13 +123,78 @@ func NewRouter(config *common.Config) *Router {
 	}
 
 	// Initialize security services
-	r.initSecurityServices()
+	if err := r.initSecurityServices(); err != nil {
+		return nil, err
+	}
+	if err := r.initClientCerts(); err != nil {
+		return nil, fmt.Errorf("auth.mtls: %w", err)
+	}
+
+	// Elect the instance running scheduled jobs
+	r.initCluster()
+
+	// Share hot state with the other instances
+	r.initRedis()
 
 	// Initialize registry
 	storage, err := registry.NewStorage(config.Storage.BlobPath, config.Storage.MetaPath)
 	if err == nil {
-		service := registry.NewService(storage)
-		r.registryHandler = registry.NewHandler(service)
+		if r.leaderElector != nil {
+			storage.SetMetadataLocker(service.NewDistributedLock(service.MetadataLockName, r.leaderElector.InstanceID(), 0, logger))
+		}
+		if err := storage.SetCompression(config.Storage.Compression, config.Storage.CompressionLevel, parseSize(config.Storage.CompressionMinSize)); err != nil {
+			logger.Warn("存储压缩配置无效，不压缩", zap.Error(err))
+		}
+		r.registryService = registry.NewService(storage)
+		r.registryHandler = registry.NewHandler(r.registryService)
+		r.registryHandler.SetAuditService(r.auditService)
+		r.registryHandler.SetMountAuthorizer(r.canMountFrom)
+		r.registryHandler.SetPushAuthorizer(r.canPushTo)
+		r.registryHandler.SetDeleteAuthorizer(r.canDeleteFrom)
+		r.registryHandler.SetRepositoryMetadata(r.repositoryService.GetMetadata)
+		r.repositoryService.SetRepositoryMover(r.registryService)
+		// notation 签名以引用者存储在仓库中
+		r.signatureService.SetImageSource(r.registryService)
+		r.shareService.SetImageSource(r.registryService)
+		if retention, err := time.ParseDuration(config.Storage.TrashRetention); err == nil {
+			r.registryService.SetTrashRetention(retention)
+		}
+		usageInterval, _ := time.ParseDuration(config.Storage.UsageRefreshInterval)
+		r.registryService.StartUsageIndexer(usageInterval)
+		if r.wsHandler != nil {
+			r.registryService.SetGCNotifier(r.wsHandler.SystemEventToAdmins)
+		}
+		if r.p2pService != nil {
+			r.registryHandler.SetBlobFetcher(r.p2pService)
+			r.p2pService.SetBlobResolver(r.registryService)
+		}
+
+		// 镜像同步服务
+		if credMgr, err := registry.NewCredentialManager(config.Storage.MetaPath, config.Sync.MasterKey); err == nil {
+			syncConfig := &registry.SyncConfig{
+				Parallel:   config.Sync.Parallel,
+				MaxRetries: config.Sync.MaxRetries,
+			}
+			if d, err := time.ParseDuration(config.Sync.RetryBackoff); err == nil {
+				syncConfig.RetryBackoff = d
+			}
+			r.syncService, _ = registry.NewSyncService(storage, credMgr, config.Storage.MetaPath, syncConfig)
+			if r.syncService != nil {
+				r.syncService.SetOutboundProxy(outboundProxy(config.Proxy, common.ProxySync))
+				// 通过 WebSocket 推送同步进度
+				if r.wsHandler != nil {
+					r.syncService.SetProgressNotifier(func(event string, data map[string]interface{}) {
+						r.wsHandler.Broadcast("sync", event, data)
+					})
+				}
+				r.syncHandler = registry.NewSyncHandler(r.syncService, credMgr)
+				r.syncService.SetLeaderCheck(r.isLeader)
+				r.syncService.StartReplication()
+				if r.automationEngine != nil {
+					r.automationEngine.SetSyncRuleRunner(r.syncService)
+				}
+			}
+		}
 	}
 
 	// Initialize accelerator
@@ -85,26 +208,83 @@ func NewRouter(config *common.Config) *Router {
 	// Initialize updater
 	r.initUpdater()
 
+	// Initialize backup and automation
+	r.initAutomation()
+
+	// Watch free space of the blob volume
+	r.initDiskGuard()
+
+	// Initialize workflows
+	r.initWorkflows()
+
+	// Initialize image promotion
+	r.initPromotions()
+
+	// Initialize provenance attestation verification
+	r.initProvenance()
+
+	// Initialize license policies of organizations
+	r.initLicenses()
+	r.initSecurityOverview()
+	r.initCompliance()
+
+	// Initialize image statistics
+	r.initStats()
+
+	// Initialize runtime settings, overrides stored in the database take
+	// precedence over the configuration file
+	r.initSettings()
+
 	r.setupMiddleware()
 	r.setupRoutes()
 
-	return r
+	// 所有服务初始化后再开始推送统计
+	go r.publishStats()
+
+	return r, nil
 }
 
 // initSecurityServices initializes security-related services.
-func (r *Router) initSecurityServices() {
+func (r *Router) initSecurityServices() error {
 	// Initialize lock service
 	r.lockService = service.NewLockService(logger)
 
 	// Initialize intrusion service
+	ids := r.config.Security.IntrusionDetection
 	intrusionConfig := &service.IntrusionConfig{
-		Enabled:          true,
-		MaxLoginAttempts: 3,
-		MaxTokenAttempts: 5,
-		MaxAPIAttempts:   10,
-		ProgressiveDelay: true,
+		Enabled:            ids.Enabled,
+		MaxLoginAttempts:   3,
+		MaxTokenAttempts:   5,
+		MaxAPIAttempts:     10,
+		ProgressiveDelay:   true,
+		RealTimeMonitoring: ids.RealTimeMonitoring,
+		NotifyOnLock:       ids.NotifyOnLock,
+		NotifyChannels:     ids.NotifyChannels,
+	}
+	for _, rule := range ids.Rules {
+		window, _ := time.ParseDuration(rule.Window)
+		blockDuration, _ := time.ParseDuration(rule.BlockDuration)
+		intrusionConfig.Rules = append(intrusionConfig.Rules, service.IntrusionRule{
+			Name:             rule.Name,
+			Pattern:          rule.Pattern,
+			Description:      rule.Description,
+			Action:           rule.Action,
+			Actions:          rule.Actions,
+			Threshold:        rule.Threshold,
+			Window:           window,
+			BlockDuration:    blockDuration,
+			AllowedHours:     rule.AllowedHours,
+			AllowedCountries: rule.AllowedCountries,
+		})
 	}
 	r.intrusionService = service.NewIntrusionService(intrusionConfig, r.lockService, logger)
+	if ids.GeoIPFile != "" {
+		if geo, err := service.LoadCIDRGeoResolver(ids.GeoIPFile); err != nil {
+			logger.Warn("加载GeoIP文件失败，geo_anomaly 规则仅检查登录时段", zap.Error(err))
+		} else {
+			r.intrusionService.SetGeoResolver(geo)
+		}
+	}
 
 	// Initialize audit service
 	auditConfig := &service.AuditConfig{
@@ -113,14 +293,48 @@ func (r *Router) initSecurityServices() {
 		LogLockEvents:  true,
 		BlockchainHash: true,
 	}
-	r.auditService, _ = service.NewAuditService(auditConfig, logger)
+	if audit := r.config.Logging.Audit; audit.Path != "" {
+		out := audit.Output()
+		auditConfig.LogFilePath = out.Path
+		auditConfig.LogRotation = out.Rotation
+	}
+	auditService, err := service.NewAuditService(auditConfig, logger)
+	if err != nil {
+		return fmt.Errorf("logging.audit: %w", err)
+	}
+	r.auditService = auditService
+
+	// Initialize IP rule service: rules of the config file plus rules
+	// managed through the API
+	r.ipRuleService = service.NewIPRuleService(logger)
+	staticRules := make([]*service.IPRule, 0, len(r.config.Security.IPRules))
+	for _, rule := range r.config.Security.IPRules {
+		staticRules = append(staticRules, &service.IPRule{
+			Action:      rule.Action,
+			CIDR:        rule.CIDR,
+			Scope:       rule.Scope,
+			Description: rule.Description,
+		})
+	}
+	if err := r.ipRuleService.SetStaticRules(staticRules); err != nil {
+		logger.Warn("IP规则配置无效，已忽略", zap.Error(err))
+	}
+	if err := r.ipRuleService.Reload(); err != nil {
+		logger.Warn("加载IP规则失败", zap.Error(err))
+	}
+	r.intrusionService.SetAuditService(r.auditService)
+	r.intrusionService.SetIPBlocker(r.ipRuleService)
 
 	// Initialize auth service
-	jwtSecret := "cyp-registry-secret-key" // TODO: Load from config
-	r.authService = service.NewAuthService(jwtSecret)
+	if err := r.initJWT(); err != nil {
+		return err
+	}
 
 	// Initialize org service
 	r.orgService = service.NewOrgService(logger)
+	if err := r.orgService.SetMemberPermission(r.config.Auth.OrgMemberPermission); err != nil {
+		logger.Warn("组织成员默认权限无效，使用 write", zap.String("permission", r.config.Auth.OrgMemberPermission))
+	}
 
 	// Initialize share service
 	r.shareService = service.NewShareService(logger)
@@ -128,29 +342,54 @@ func (r *Router) initSecurityServices() {
 	// Initialize token service
 	r.tokenService = service.NewTokenService(logger)
 
+	// Initialize user service
+	r.userService = service.NewUserService(logger)
+
+	// Initialize repository service (visibility of /v2 repositories)
+	r.repositoryService = service.NewRepositoryService(logger)
+	if err := r.repositoryService.SetDefaultVisibility(r.config.Auth.DefaultVisibility); err != nil {
+		logger.Warn("默认仓库可见性无效，使用 internal", zap.String("visibility", r.config.Auth.DefaultVisibility))
+	}
+	r.repositoryService.SetOrgService(r.orgService)
+	r.shareService.SetRepositoryService(r.repositoryService)
+
+	// 邮件通道，用于发送组织邀请
+	r.applyMailer(r.config.Notify.Channels.Email)
+
 	// Initialize signature service
 	signatureConfig := &service.SignatureConfig{
 		Enabled:          true,
-		Mode:             "warn",
+		Mode:             r.config.Signature.Mode,
 		AutoSign:         false,
 		RequireSignature: false,
 		KeyPath:          "./data/signatures",
+		Backend:          r.config.Signature.Backend,
+
+		NotationTrustPolicy: r.config.Signature.Notation.TrustPolicy,
+		NotationTrustStore:  r.config.Signature.Notation.TrustStore,
+
+		Keys:  keyProviderConfig(r.config.Signature),
+		KeyID: r.config.Signature.KeyID,
 	}
 	r.signatureService = service.NewSignatureService(signatureConfig, logger)
 
 	// Initialize SBOM service
 	sbomConfig := &service.SBOMConfig{
 		Enabled:     true,
-		Generator:   "syft",
+		Generator:   r.config.SBOM.Generator,
 		Format:      "spdx-json",
 		VulnScan:    true,
 		VulnScanner: "trivy",
 		StoragePath: "./data/sboms",
 	}
 	r.sbomService = service.NewSBOMService(sbomConfig, logger)
+	r.sbomService.SetCommandEnv(r.config.Proxy.For(common.ProxyScanner).CommandEnv())
 
 	// Initialize DNS service
 	r.dnsService = service.NewDNSService(logger)
+	if err := r.dnsService.Configure(dnsResolverConfig(r.config.DNS)); err != nil {
+		logger.Warn("DNS 配置无效，使用系统 DNS", zap.Error(err))
+	}
 
 	// Initialize P2P service - 修复问题4
 	p2pConfig := r.config.P2P
@@ -160,6 +399,7 @@ func (r *Router) initSecurityServices() {
 			logger.Warn("P2P服务初始化失败", zap.Error(err))
 		} else {
 			r.p2pService = p2pSvc
+			r.p2pService.SetAuditService(r.auditService)
 			// 自动启动P2P服务
 			if err := r.p2pService.Start(); err != nil {
 				logger.Warn("P2P服务启动失败", zap.Error(err))
@@ -172,15 +412,36 @@ func (r *Router) initSecurityServices() {
 	// Initialize handlers
 	r.authHandler = handler.NewAuthHandler(r.authService, r.lockService, r.intrusionService, r.auditService)
 	r.lockHandler = handler.NewLockHandler(r.lockService, r.auditService)
+	r.initManualUnlock()
 	r.auditHandler = handler.NewAuditHandler()
+	redactor, err := r.auditRedactor(r.config.Security.AuditRedaction)
+	if err != nil {
+		return fmt.Errorf("security.audit_redaction: %w", err)
+	}
+	r.auditHandler.SetRedactor(redactor)
 	r.orgHandler = handler.NewOrgHandler(r.orgService, r.auditService)
 	r.shareHandler = handler.NewShareHandler(r.shareService, r.auditService)
 	r.tokenHandler = handler.NewTokenHandler(r.tokenService, r.auditService)
+	r.userHandler = handler.NewUserHandler(r.userService, r.auditService)
+	r.ipRuleHandler = handler.NewIPRuleHandler(r.ipRuleService, r.auditService)
+	r.repositoryHandler = handler.NewRepositoryHandler(r.repositoryService, r.auditService)
 	r.wsHandler = handler.NewWSHandler(logger)
+	r.wsHandler.SetOriginPolicy(r.config.Server.CORS.APIPolicy(r.config.Server.IsProduction()))
 	r.signatureHandler = handler.NewSignatureHandler(r.signatureService, r.auditService)
 	r.sbomHandler = handler.NewSBOMHandler(r.sbomService, r.auditService)
+	r.sbomHandler.SetPullAuthorizer(r.canMountFrom)
 	r.dnsHandler = handler.NewDNSHandler(r.dnsService)
 
+	// 分享链接被使用时通过 WebSocket 通知创建者，管理员也能收到
+	r.shareService.SetNotifier(func(creatorID int64, level, title, message string) {
+		r.wsHandler.NotifyAudience(handler.WSAudience{UserID: creatorID}, level, title, message)
+	})
+	r.intrusionService.SetNotifier(r.notifyIntrusion)
+	r.initWSEvents()
+	r.shareService.SetEventHandler(func(event string, creatorID int64, data map[string]interface{}) {
+		r.wsHandler.BroadcastTo(handler.WSAudience{UserID: creatorID}, "share", event, data)
+	})
+
 	// Initialize P2P handler
 	if r.p2pService != nil {
 		r.p2pHandler = handler.NewP2PHandler(r.p2pService)
@@ -189,6 +450,59 @@ func (r *Router) initSecurityServices() {
 	// Initialize global service manager and apply configurations
 	r.globalService = service.NewGlobalServiceManager(logger)
 	r.initGlobalServices()
+	return nil
+}
+
+// initManualUnlock lets administrators unlock the system with their
+// password when security.auto_lock.unlock.allow_password is set, and with
+// the one-time token written on the host when allow_token is set. Both
+// share the brute-force limits.
+func (r *Router) initManualUnlock() {
+	unlock := r.config.Security.AutoLock.Unlock
+	if !unlock.AllowPassword && !unlock.AllowToken {
+		return
+	}
+
+	config := service.UnlockGuardConfig{
+		MaxAttempts:    unlock.MaxAttempts,
+		PermanentAfter: unlock.PermanentAfter,
+	}
+	config.Window, _ = time.ParseDuration(unlock.AttemptWindow)
+	config.BaseDelay, _ = time.ParseDuration(unlock.BaseDelay)
+	config.MaxDelay, _ = time.ParseDuration(unlock.MaxDelay)
+	guard := service.NewUnlockGuard(config)
+
+	if unlock.AllowToken {
+		tokenFile := unlock.TokenFile
+		if tokenFile == "" {
+			tokenFile = filepath.Join(r.config.Storage.MetaPath, "unlock_token")
+		}
+		ttl, _ := time.ParseDuration(unlock.TokenTTL)
+		tokens := service.NewUnlockTokens(tokenFile, ttl)
+		r.lockService.SetUnlockTokens(tokens)
+		r.lockHandler.EnableTokenUnlock(tokens, guard)
+	}
+	if unlock.AllowPassword {
+		r.initPasswordUnlock(unlock, guard)
+	}
+}
+
+// initPasswordUnlock enables password unlock. A missing or invalid TOTP
+// secret with require_2fa keeps password unlock disabled.
+func (r *Router) initPasswordUnlock(unlock common.UnlockConfig, guard *service.UnlockGuard) {
+	var totp *service.TOTPVerifier
+	if unlock.Require2FA {
+		secret := unlock.TOTPSecret
+		if secret == "" {
+			secret = os.Getenv("CYP_UNLOCK_TOTP_SECRET")
+		}
+		var err error
+		if totp, err = service.NewTOTPVerifier(secret); err != nil {
+			logger.Error("解锁动态码密钥无效，不允许密码解锁", zap.Error(err))
+			return
+		}
+	}
+	r.lockHandler.EnablePasswordUnlock(r.authService, guard, totp)
 }
 
 // initAccelerator initializes the accelerator service.
@@ -203,6 +517,16 @@ func (r *Router) initAccelerator() {
 	if err != nil {
 		return
 	}
+	if err := cache.SetPolicy(r.config.Accelerator.CachePolicy); err != nil {
+		logger.Warn("缓存淘汰策略无效，使用 LRU", zap.Error(err))
+	}
+	if r.redis != nil && r.config.Redis.Cache {
+		// 共享缓存索引只支持 LRU
+		if policy := r.config.Accelerator.CachePolicy; policy != accelerator.PolicyLRU {
+			logger.Warn("使用 Redis 共享缓存索引时淘汰策略固定为 LRU", zap.String("cache_policy", policy))
+		}
+		cache.SetRedis(r.redis, r.config.Redis.KeyPrefix)
+	}
 
 	proxy, err := accelerator.NewProxyService(cache, r.config.Storage.CachePath)
 	if err != nil {
@@ -210,28 +534,90 @@ func (r *Router) initAccelerator() {
 	}
 
 	// Set upstreams from config
-	var upstreams []accelerator.UpstreamSource
-	for _, u := range r.config.Accelerator.Upstreams {
-		upstreams = append(upstreams, accelerator.UpstreamSource{
-			Name:     u.Name,
-			URL:      u.URL,
-			Priority: u.Priority,
-			Enabled:  true,
-		})
-	}
-	if len(upstreams) > 0 {
+	if upstreams := acceleratorUpstreams(r.config.Accelerator.Upstreams); len(upstreams) > 0 {
 		proxy.SetUpstreams(upstreams)
 	}
 
+	// 先从P2P节点获取，失败后回退到上游
+	if r.p2pService != nil {
+		proxy.SetP2PProvider(r.p2pService)
+	}
+
+	// 上游域名按 dns 配置解析（DoH/DoT、按域名路由与缓存）
+	if r.dnsService != nil {
+		proxy.SetCustomResolver(r.dnsService.Resolver())
+	}
+	proxy.SetOutboundProxy(outboundProxy(r.config.Proxy, common.ProxyAccelerator))
+
+	if r.registryService != nil {
+		r.registryService.SetCacheSizeFunc(func() int64 {
+			return cache.Stats().TotalSize
+		})
+	}
+
 	r.acceleratorHandler = accelerator.NewHandler(proxy)
 }
 
+// dnsResolverConfig converts the dns section.
+func dnsResolverConfig(c common.DNSConfig) service.DNSResolverConfig {
+	config := service.DNSResolverConfig{Servers: c.Servers, CacheSize: c.CacheSize}
+	for _, route := range c.Routes {
+		config.Routes = append(config.Routes, service.DNSRouteConfig{Domains: route.Domains, Servers: route.Servers})
+	}
+	return config
+}
+
+// acceleratorUpstreams converts the configured upstreams.
+func acceleratorUpstreams(configs []common.UpstreamConfig) []accelerator.UpstreamSource {
+	var upstreams []accelerator.UpstreamSource
+	for _, u := range configs {
+		upstream := accelerator.UpstreamSource{
+			Name:         u.Name,
+			URL:          u.URL,
+			Priority:     u.Priority,
+			Enabled:      true,
+			Type:         u.Type,
+			PathTemplate: u.PathTemplate,
+		}
+		for _, rw := range u.Rewrites {
+			upstream.Rewrites = append(upstream.Rewrites, accelerator.NameRewrite{Match: rw.Match, Replace: rw.Replace})
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams
+}
+
+// initPinRefresh schedules the refresh of images pinned in the accelerator
+// cache.
+func (r *Router) initPinRefresh() {
+	if r.acceleratorHandler == nil {
+		return
+	}
+	interval, _ := time.ParseDuration(r.config.Accelerator.PinRefreshInterval)
+	if interval <= 0 {
+		return
+	}
+	r.automationEngine.SetPinRefresher(r.acceleratorHandler.GetProxy(), interval)
+}
+
 // initDetector initializes the detector service.
 func (r *Router) initDetector() {
 	service := detector.NewDetectorService()
+	service.SetDiskPath(r.config.Storage.CachePath)
+	r.detectorService = service
 	r.detectorHandler = detector.NewHandler(service)
 }
 
+// SetAutoConfig records the settings picked for the environment on first
+// boot. They are shown by GET /api/system/environment and applied again to
+// reloaded configurations.
+func (r *Router) SetAutoConfig(auto *detector.AutoConfig) {
+	r.autoConfig = auto
+	if r.detectorService != nil {
+		r.detectorService.SetAutoConfig(auto)
+	}
+}
+
 // initUpdater initializes the updater service.
 func (r *Router) initUpdater() {
 	config := updater.DefaultConfig()
@@ -243,6 +629,14 @@ func (r *Router) initUpdater() {
 	if r.config.Update.AutoUpdate {
 		config.AutoUpdate = r.config.Update.AutoUpdate
 	}
+	if r.config.Update.Channel != "" {
+		config.UpdateChannel = r.config.Update.Channel
+	}
+	config.Delta = r.config.Update.Delta
+	config.AutoRestart = r.config.Update.AutoRestart
+	if window, err := time.ParseDuration(r.config.Update.HealthWindow); err == nil && window > 0 {
+		config.HealthWindow = window
+	}
 	if r.config.Update.CheckInterval != "" {
 		// 解析检查间隔，如 "1h", "30m"
 		if interval, err := time.ParseDuration(r.config.Update.CheckInterval); err == nil {
@@ -252,13 +646,444 @@ func (r *Router) initUpdater() {
 
 	downloadPath := "./data/updates"
 	service := updater.NewUpdaterService(config, downloadPath)
+	service.SetOutboundProxy(outboundProxy(r.config.Proxy, common.ProxyUpdate))
+
+	// 下载的程序须通过校验和与签名验证才会被替换
+	var verifier *updater.ReleaseVerifier
+	var keyErr error
+	if key := r.config.Update.PublicKey; key != "" {
+		if verifier, keyErr = updater.NewReleaseVerifier(key); keyErr != nil {
+			logger.Warn("更新签名公钥无效，将拒绝所有更新", zap.Error(keyErr))
+		}
+	}
+	service.SetVerifier(verifier, r.config.Update.RequireSignature || keyErr != nil)
+
+	// 更新后的版本须在期限内通过就绪检查，否则回滚
+	service.SetHealthCheck(r.checkUpdateHealth)
 
 	// 启动后台更新检查
+	service.SetLeaderCheck(r.isLeader)
 	service.Start()
 
+	r.updaterService = service
 	r.updaterHandler = updater.NewHandler(service)
 }
 
+// Restarts returns the channel receiving self-restart requests from the
+// updater, after an update is applied or rolled back.
+func (r *Router) Restarts() <-chan struct{} {
+	return r.updaterService.Restarts()
+}
+
+// backupSecretFiles returns the key files kept out of backups. Archives
+// are uploaded to remote targets unencrypted, and with these keys anyone
+// holding one could decrypt registry credentials or forge JWTs.
+func (r *Router) backupSecretFiles() []string {
+	metaPath := r.config.Storage.MetaPath
+	files := []string{
+		registry.MasterKeyPath(metaPath),
+		filepath.Join(metaPath, "jwt_secret.key"),
+		filepath.Join(metaPath, "unlock_token"),
+		filepath.Join(metaPath, "audit_redaction.key"),
+	}
+	if r.settingsService != nil {
+		if file, ok := r.settingsService.Value("security.jwt_secret_file"); ok && file != "" {
+			files = append(files, file)
+		}
+	}
+	for _, file := range []string{
+		r.config.Security.JWT.SecretFile,
+		r.config.Security.AutoLock.Unlock.TokenFile,
+		r.config.Security.AuditRedaction.KeyFile,
+	} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// initAutomation initializes the backup service and the automation engine.
+func (r *Router) initAutomation() {
+	dbPath := dao.GetDBPath()
+	if dbPath == "" {
+		dbPath = "./data/registry.db"
+	}
+
+	backupPath := r.config.Backup.Path
+	if backupPath == "" {
+		backupPath = "./data/backups"
+	}
+
+	var targets []service.BackupTargetConfig
+	for _, t := range r.config.Backup.Targets {
+		targets = append(targets, service.BackupTargetConfig{
+			Name:           t.Name,
+			Type:           t.Type,
+			Retention:      t.Retention,
+			Path:           t.Path,
+			Endpoint:       t.Endpoint,
+			Region:         t.Region,
+			Bucket:         t.Bucket,
+			Prefix:         t.Prefix,
+			AccessKey:      t.AccessKey,
+			SecretKey:      t.SecretKey,
+			PathStyle:      t.PathStyle,
+			Host:           t.Host,
+			Port:           t.Port,
+			Username:       t.Username,
+			Password:       t.Password,
+			PrivateKeyPath: t.PrivateKeyPath,
+			HostKey:        t.HostKey,
+		})
+	}
+
+	r.backupService = service.NewBackupService(&service.BackupConfig{
+		OutputPath:   backupPath,
+		DBPath:       dbPath,
+		MetaPath:     r.config.Storage.MetaPath,
+		BlobPath:     r.config.Storage.BlobPath,
+		KeyPaths:     r.config.Backup.KeyPaths,
+		ConfigPaths:  r.config.Backup.ConfigPaths,
+		ExcludePaths: r.backupSecretFiles(),
+		IncludeBlobs: r.config.Backup.IncludeBlobs,
+		Retention:    r.config.Backup.Retention,
+		Targets:      targets,
+	}, logger)
+
+	// 通过 WebSocket 向管理员推送备份进度
+	if r.wsHandler != nil {
+		r.backupService.SetProgressNotifier(r.wsHandler.SystemEventToAdmins)
+	}
+	r.backupHandler = handler.NewBackupHandler(r.backupService, r.auditService)
+
+	r.automationEngine = service.NewAutomationEngine(nil, logger)
+	if r.config.Backup.Enabled {
+		r.automationEngine.SetBackupService(r.backupService)
+	}
+	if r.registryService != nil {
+		r.automationEngine.SetTrashPurger(r.registryService)
+	}
+
+	// 定期清理过期会话、访问令牌、分享链接和未完成的上传
+	maint := r.config.Maintenance
+	sweepInterval, _ := time.ParseDuration(maint.SweepInterval)
+	if sweepInterval <= 0 {
+		sweepInterval = time.Hour
+	}
+	retention, _ := time.ParseDuration(maint.ExpiredRetention)
+	uploadTTL, _ := time.ParseDuration(maint.UploadTTL)
+	r.expirySweeper = service.NewExpirySweeper(service.ExpirySweeperConfig{
+		Retention: retention,
+		UploadTTL: uploadTTL,
+	}, r.shareService, logger)
+	if r.registryService != nil {
+		r.expirySweeper.SetUploadPurger(r.registryService)
+	}
+	r.automationEngine.SetExpirySweeper(r.expirySweeper, sweepInterval)
+	r.initScrub()
+	r.initPinRefresh()
+	r.initVulnDB()
+	r.initAccessDigest()
+	r.automationEngine.SetLeaderCheck(r.isLeader)
+	if err := r.automationEngine.Start(); err != nil {
+		logger.Warn("自动化引擎启动失败", zap.Error(err))
+	}
+}
+
+// initWorkflows initializes the workflow service and connects step actions
+// to the services that implement them.
+func (r *Router) initWorkflows() {
+	r.workflowService = service.NewWorkflowService(logger)
+	r.workflowService.SetSignatureService(r.signatureService)
+	r.workflowService.SetSBOMService(r.sbomService)
+	if r.registryService != nil {
+		r.workflowService.SetImageCleaner(r.registryService)
+	}
+	if r.syncService != nil {
+		r.workflowService.SetImageSyncer(r.syncService)
+	}
+	if r.wsHandler != nil {
+		r.workflowService.SetNotifier(r.wsHandler.BroadcastNotification)
+
+		// 通过 WebSocket 实时推送作业状态与日志
+		r.workflowService.SetJobEventHandler(func(event string, data map[string]interface{}) {
+			r.wsHandler.Broadcast("workflow", event, data)
+		})
+	}
+
+	// 定期清理过期作业
+	var retention time.Duration
+	if r.config.Workflow.JobRetention != "" {
+		if d, err := time.ParseDuration(r.config.Workflow.JobRetention); err == nil {
+			retention = d
+		}
+	}
+	r.workflowService.StartJobCleanup(time.Hour, retention, r.config.Workflow.MaxJobsPerWorkflow)
+
+	r.workflowHandler = handler.NewWorkflowHandler(r.workflowService, r.auditService)
+
+	// 镜像推送、拉取、删除事件触发工作流
+	if r.registryHandler != nil {
+		r.registryHandler.OnEvent(func(event *service.RegistryEvent) {
+			r.workflowService.HandleEvent(event)
+		})
+	}
+}
+
+// initLicenses initializes the license policies checked against SBOMs,
+// which can refuse pulls of images with forbidden licenses.
+func (r *Router) initLicenses() {
+	r.licenseService = service.NewLicenseService(r.sbomService, r.orgService, logger)
+	r.licenseHandler = handler.NewLicenseHandler(r.licenseService, r.auditService)
+	if r.registryHandler != nil {
+		r.registryHandler.SetPullGate(r.licenseService.CheckPull)
+	}
+}
+
+// initSecurityOverview initializes the security dashboard, which
+// aggregates the state held by the other services.
+func (r *Router) initSecurityOverview() {
+	overview := service.NewSecurityOverviewService(r.sbomService, r.signatureService, r.lockService, logger)
+	if r.registryService != nil {
+		overview.SetImageSource(r.registryService)
+	}
+	r.securityHandler = handler.NewSecurityHandler(overview)
+}
+
+// initCompliance initializes the compliance reports downloaded by
+// auditors.
+func (r *Router) initCompliance() {
+	compliance := service.NewComplianceService(r.sbomService, r.signatureService, r.auditService, r.orgService, logger)
+	if r.registryService != nil {
+		compliance.SetImageSource(r.registryService)
+	}
+	r.complianceHandler = handler.NewComplianceHandler(compliance, r.auditService)
+}
+
+// initPromotions initializes the promotion of images through the
+// configured channels.
+func (r *Router) initPromotions() {
+	if r.registryService == nil {
+		return
+	}
+	r.promotionService = service.NewPromotionService(r.repositoryService, r.registryService, logger)
+	r.promotionService.SetChannels(promotionChannels(r.config.Promotion))
+	r.promotionService.SetSBOMService(r.sbomService)
+	r.promotionService.SetSignatureService(r.signatureService)
+	r.promotionHandler = handler.NewPromotionHandler(r.promotionService, r.auditService)
+}
+
+// promotionChannels converts the configured channels.
+func promotionChannels(cfg common.PromotionConfig) []service.PromotionChannel {
+	channels := make([]service.PromotionChannel, 0, len(cfg.Channels))
+	for _, ch := range cfg.Channels {
+		channels = append(channels, service.PromotionChannel{
+			Name:              ch.Name,
+			Tag:               ch.ChannelTag(),
+			ApproverRole:      ch.ApproverRole,
+			Approvals:         ch.Approvals,
+			AllowSelfApproval: ch.AllowSelfApproval,
+			RequireScan:       ch.RequireScan,
+			RequireSignature:  ch.RequireSignature,
+		})
+	}
+	return channels
+}
+
+// initProvenance initializes the verification of SLSA provenance
+// attestations pushed as referrers of images.
+func (r *Router) initProvenance() {
+	if r.registryService == nil {
+		return
+	}
+	r.provenanceService = service.NewProvenanceService(r.repositoryService, r.registryService, logger)
+	r.provenanceService.SetTrustedBuilders(trustedBuilders(r.config.Provenance))
+	r.provenanceHandler = handler.NewProvenanceHandler(r.provenanceService, r.auditService)
+
+	// 推送的来源证明在后台验证，不拖慢推送
+	if r.registryHandler != nil {
+		r.registryHandler.OnEvent(func(event *service.RegistryEvent) {
+			if event.Type == service.RegistryEventPush {
+				go r.provenanceService.HandleEvent(event)
+			}
+		})
+	}
+}
+
+// trustedBuilders converts the configured trusted builders.
+func trustedBuilders(cfg common.ProvenanceConfig) []service.TrustedBuilder {
+	builders := make([]service.TrustedBuilder, 0, len(cfg.TrustedBuilders))
+	for _, b := range cfg.TrustedBuilders {
+		builders = append(builders, service.TrustedBuilder{
+			Name:      b.Name,
+			ID:        b.ID,
+			PublicKey: b.PublicKey,
+		})
+	}
+	return builders
+}
+
+// keyProviderConfig converts the key provider configuration. The PKCS#11
+// PIN may be kept out of the configuration file in CYP_PKCS11_PIN.
+func keyProviderConfig(cfg common.SignatureConfig) signature.KeyProviderConfig {
+	pin := cfg.PKCS11.PIN
+	if pin == "" {
+		pin = os.Getenv("CYP_PKCS11_PIN")
+	}
+	return signature.KeyProviderConfig{
+		Provider: cfg.KeyProvider,
+		KeysDir:  cfg.KeysDir,
+		PKCS11: signature.PKCS11Config{
+			Module:     cfg.PKCS11.Module,
+			TokenLabel: cfg.PKCS11.TokenLabel,
+			Slot:       cfg.PKCS11.Slot,
+			PIN:        pin,
+		},
+		AWSKMS: signature.AWSKMSConfig{
+			Region:   cfg.AWSKMS.Region,
+			Endpoint: cfg.AWSKMS.Endpoint,
+		},
+		GCPKMS: signature.GCPKMSConfig{
+			CredentialsFile: cfg.GCPKMS.CredentialsFile,
+			Endpoint:        cfg.GCPKMS.Endpoint,
+		},
+	}
+}
+
+// initStats initializes pull/push statistics and the registry event log.
+func (r *Router) initStats() {
+	r.eventLog = service.NewRegistryEventLog(logger)
+	if retention, err := time.ParseDuration(r.config.Maintenance.EventRetention); err == nil {
+		r.eventLog.SetRetention(retention)
+	}
+	r.eventLog.Start(0)
+	r.eventHandler = handler.NewEventHandler(r.eventLog)
+
+	r.statsService = service.NewImageStatsService(logger)
+	r.statsService.SetEventLog(r.eventLog)
+	r.statsService.Start(0)
+	r.statsHandler = handler.NewStatsHandler(r.statsService)
+
+	// 统计镜像拉取、推送次数和Blob下载流量，记录推送/拉取/删除事件
+	if r.registryHandler != nil {
+		r.registryHandler.OnEvent(r.eventLog.HandleEvent)
+		r.registryHandler.OnEvent(r.statsService.HandleEvent)
+		r.registryHandler.OnBytesServed(r.statsService.RecordBytesServed)
+
+		r.popularImages = service.NewPopularImages(r.eventLog, logger)
+		r.popularImages.Start(0)
+		r.registryHandler.SetPopularImages(r.popularImages)
+	}
+}
+
+// initJWT loads the JWT signing keys. The secret file location can be
+// overridden from the web UI and is read before the other settings, since
+// the auth service is created first. In production mode a missing secret
+// is an error instead of generating one.
+func (r *Router) initJWT() error {
+	r.settingsService = service.NewSettingsService(logger)
+
+	jwtCfg := r.config.Security.JWT
+	secretFile := jwtCfg.SecretFile
+	if secretFile == "" {
+		secretFile = filepath.Join(r.config.Storage.MetaPath, "jwt_secret.key")
+	}
+	r.settingsService.Register(service.SettingDefinition{
+		Key:             "security.jwt_secret_file",
+		Type:            service.SettingString,
+		Description:     "自动生成的 JWT 密钥文件路径，重启后生效",
+		ConfigValue:     secretFile,
+		RestartRequired: true,
+	})
+	if err := r.settingsService.Load(); err != nil {
+		logger.Warn("加载运行时设置失败", zap.Error(err))
+	}
+	secretFile, _ = r.settingsService.Value("security.jwt_secret_file")
+
+	var previous []service.JWTKey
+	for _, p := range jwtCfg.Previous {
+		previous = append(previous, service.JWTKey{ID: p.KeyID, Secret: []byte(p.Secret)})
+	}
+	keys, err := service.LoadJWTKeys(service.JWTKeyConfig{
+		Secret:     jwtCfg.Secret,
+		KeyID:      jwtCfg.KeyID,
+		SecretFile: secretFile,
+		Previous:   previous,
+		Generate:   !r.config.Server.IsProduction(),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrNoJWTSecret) {
+			return fmt.Errorf("%w: set security.jwt.secret or %s in production mode", err, service.JWTSecretEnv)
+		}
+		return fmt.Errorf("failed to load jwt secret: %w", err)
+	}
+
+	r.authService = service.NewAuthService(keys)
+	logger.Info("JWT 密钥已加载", zap.String("kid", keys[0].ID), zap.Int("verify_keys", len(keys)-1))
+	return nil
+}
+
+// initSettings registers the settings that can be changed from the web UI
+// and applies the stored overrides.
+func (r *Router) initSettings() {
+	r.settingsService.Register(service.SettingDefinition{
+		Key:         "auth.default_visibility",
+		Type:        service.SettingEnum,
+		Description: "未单独设置可见性的仓库的默认可见性",
+		Options:     []string{service.VisibilityPrivate, service.VisibilityInternal, service.VisibilityPublic},
+		ConfigValue: r.repositoryService.DefaultVisibility(),
+		Apply:       r.repositoryService.SetDefaultVisibility,
+	})
+	if logLevel != nil {
+		r.settingsService.Register(service.SettingDefinition{
+			Key:         "logging.level",
+			Type:        service.SettingEnum,
+			Description: "应用日志级别",
+			Options:     []string{"debug", "info", "warn", "error", "dpanic", "panic", "fatal"},
+			ConfigValue: logLevel.Level().String(),
+			Apply:       setLogLevel,
+		})
+	}
+	r.settingsService.Register(service.SettingDefinition{
+		Key:         "signature.mode",
+		Type:        service.SettingEnum,
+		Description: "镜像签名模式",
+		Options:     []string{"enforce", "warn", "disabled"},
+		ConfigValue: r.config.Signature.Mode,
+		Apply:       r.signatureService.SetMode,
+	})
+	r.settingsService.Register(service.SettingDefinition{
+		Key:         "sbom.generator",
+		Type:        service.SettingEnum,
+		Description: "SBOM 生成工具",
+		Options:     []string{"syft", "trivy"},
+		ConfigValue: r.config.SBOM.Generator,
+		Apply:       r.sbomService.SetGenerator,
+	})
+	if r.registryService != nil {
+		r.settingsService.Register(service.SettingDefinition{
+			Key:         "storage.trash_retention",
+			Type:        service.SettingDuration,
+			Description: "已删除标签在回收站中保留的时长，0 表示直接删除",
+			ConfigValue: r.registryService.TrashRetention().String(),
+			Apply: func(value string) error {
+				retention, err := time.ParseDuration(value)
+				if err != nil {
+					return err
+				}
+				r.registryService.SetTrashRetention(retention)
+				return nil
+			},
+		})
+	}
+
+	if err := r.settingsService.Load(); err != nil {
+		logger.Warn("加载运行时设置失败", zap.Error(err))
+	}
+	r.settingsHandler = handler.NewSettingsHandler(r.settingsService, r.auditService)
+}
+
 // initGlobalServices 初始化全局服务并应用配置
 // 修复问题3、4：DNS和P2P服务自动应用到系统
 func (r *Router) initGlobalServices() {
@@ -336,29 +1161,79 @@ func parseSize(s string) int64 {
 
 // setupMiddleware configures middleware for the router.
 func (r *Router) setupMiddleware() {
+	r.engine.Use(RequestIDMiddleware())
+	r.engine.Use(r.requestCounter())
 	r.engine.Use(LoggingMiddleware())
 	r.engine.Use(ErrorHandlingMiddleware())
 	r.engine.Use(gin.Recovery())
-	r.engine.Use(CORSMiddleware())
+	r.engine.Use(middleware.HTTPSRedirect(r.config.Security.HTTPSRedirect))
+	r.engine.Use(CORSMiddleware(r.config.Server.CORS, r.config.Server.IsProduction()))
 
 	// Security middleware
-	securityMw := middleware.NewSecurityMiddleware(false)
+	securityMw := middleware.NewSecurityMiddleware(false, r.config.Security.Headers)
 	r.engine.Use(securityMw.SecurityHeaders())
 
+	// IP allow/deny rules
+	ipFilterMw := middleware.NewIPFilterMiddleware(r.ipRuleService, r.auditIPBlocked)
+	r.engine.Use(ipFilterMw.Filter())
+
+	// 记录匿名请求的 404，供入侵检测规则使用
+	r.engine.Use(r.notFoundRecorder())
+
+	// Rate limits per endpoint class, always installed so that a config
+	// reload can enable them
+	r.rateLimiter = middleware.NewRateLimitMiddleware(rateLimits(r.config.Security.RateLimit))
+	r.rateLimiter.SetIdentifier(r.rateLimitIdentity)
+	if r.redis != nil && r.config.Redis.RateLimit {
+		r.rateLimiter.SetRedis(r.redis, r.config.Redis.KeyPrefix)
+	}
+	r.engine.Use(r.rateLimiter.Limit())
+
 	// Lock check middleware
 	lockMw := middleware.NewLockMiddleware(r.lockService)
 	r.engine.Use(lockMw.CheckLock())
 }
 
+// auditIPBlocked records a request blocked by the IP rules.
+func (r *Router) auditIPBlocked(c *gin.Context, reason string) {
+	if r.auditService == nil {
+		return
+	}
+	r.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "warn",
+		Event:     "ip_blocked",
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  c.Request.URL.Path,
+		Action:    c.Request.Method,
+		Status:    "blocked",
+		Details: map[string]interface{}{
+			"reason":     reason,
+			"user_agent": c.Request.UserAgent(),
+		},
+	})
+}
+
 // setupRoutes configures all routes for the API gateway.
 func (r *Router) setupRoutes() {
 	// Health check endpoint (no auth required)
 	r.engine.GET("/health", r.healthHandler)
 
+	// Kubernetes probes with per-dependency checks (no auth required)
+	r.engine.GET("/healthz", r.livenessHandler)
+	r.engine.GET("/readyz", r.readinessHandler)
+
+	// Prometheus metrics (no auth required)
+	r.engine.GET("/metrics", r.metricsHandler)
+
 	// Version API endpoint (no auth required)
 	r.engine.GET("/api/version", r.versionHandler)
 	r.engine.GET("/api/version/full", r.versionFullHandler)
 
+	// OpenAPI spec and Swagger UI (no auth required)
+	r.engine.GET("/api/openapi.json", r.openAPIHandler)
+	r.engine.GET("/api/docs", r.apiDocsHandler)
+
 	// Auth routes (no auth required)
 	authGroup := r.engine.Group("/api/v1/auth")
 	if r.authHandler != nil {
@@ -374,34 +1249,108 @@ func (r *Router) setupRoutes() {
 	// Create simple auth check middleware for protected routes
 	authCheckMiddleware := r.createAuthCheckMiddleware()
 
+	// Scope checks for requests made with personal access tokens. Admin
+	// routes also require the admin role, for login sessions too.
+	registryScope := r.requireScope(service.ScopeRegistryRead, service.ScopeRegistryWrite)
+	adminScope := r.requireAdminScope()
+
 	// Audit routes (requires auth)
 	auditGroup := r.engine.Group("/api/v1/audit")
-	auditGroup.Use(authCheckMiddleware)
+	auditGroup.Use(authCheckMiddleware, r.requireScope(service.ScopeAuditRead, service.ScopeAdminWrite))
 	if r.auditHandler != nil {
 		r.auditHandler.RegisterRoutes(auditGroup)
 	}
+	if r.complianceHandler != nil {
+		r.complianceHandler.RegisterAuditRoutes(auditGroup)
+	}
 
 	// Organization routes (requires auth) - 修复问题1
 	orgGroup := r.engine.Group("/api/v1/orgs")
-	orgGroup.Use(authCheckMiddleware)
+	orgGroup.Use(authCheckMiddleware, r.requireOrgScope())
 	if r.orgHandler != nil {
 		r.orgHandler.RegisterRoutes(orgGroup)
+		if r.licenseHandler != nil {
+			r.licenseHandler.RegisterOrgRoutes(orgGroup)
+		}
+		if r.complianceHandler != nil {
+			r.complianceHandler.RegisterOrgRoutes(orgGroup)
+		}
+
+		invitationGroup := r.engine.Group("/api/v1/invitations")
+		invitationGroup.Use(authCheckMiddleware, r.sessionOnly())
+		r.orgHandler.RegisterInvitationRoutes(invitationGroup)
 	}
 
 	// Share routes (requires auth) - 修复问题1
 	shareGroup := r.engine.Group("/api/v1/share")
-	shareGroup.Use(authCheckMiddleware)
+	shareGroup.Use(authCheckMiddleware, registryScope)
 	if r.shareHandler != nil {
 		r.shareHandler.RegisterRoutes(shareGroup)
+		// 分享链接的访问者无需登录
+		r.shareHandler.RegisterPublicRoutes(r.engine.Group("/api/v1/share"))
 	}
 
 	// Token routes (requires auth) - 修复问题1
 	tokenGroup := r.engine.Group("/api/v1/tokens")
-	tokenGroup.Use(authCheckMiddleware)
+	tokenGroup.Use(authCheckMiddleware, r.sessionOnly())
 	if r.tokenHandler != nil {
 		r.tokenHandler.RegisterRoutes(tokenGroup)
 	}
 
+	// Current user, for login sessions and access tokens
+	if r.authHandler != nil {
+		r.authHandler.RegisterUserRoutes(r.engine.Group("/api/v1/auth", authCheckMiddleware))
+	}
+
+	// User routes (requires auth, administration requires the admin role)
+	if r.userHandler != nil {
+		r.userHandler.RegisterRoutes(r.engine.Group("/api/v1/auth", authCheckMiddleware, r.sessionOnly()))
+
+		adminUserGroup := r.engine.Group("/api/v1/admin/users")
+		adminUserGroup.Use(authCheckMiddleware, adminScope)
+		r.userHandler.RegisterAdminRoutes(adminUserGroup)
+	}
+
+	// Runtime settings (requires admin)
+	if r.settingsHandler != nil {
+		settingsGroup := r.engine.Group("/api/v1/admin/settings")
+		settingsGroup.Use(authCheckMiddleware, adminScope)
+		r.settingsHandler.RegisterRoutes(settingsGroup)
+	}
+
+	// Configuration reload (requires admin)
+	r.engine.POST("/api/v1/admin/config/reload", authCheckMiddleware, adminScope, r.reloadConfigHandler)
+
+	// Declarative apply of upstreams, sync rules, retention, users and orgs (requires admin)
+	r.engine.POST("/api/v1/admin/apply", authCheckMiddleware, adminScope, r.applyHandler)
+
+	// Outbound proxies and connectivity tests (requires admin)
+	r.engine.GET("/api/v1/system/proxy", authCheckMiddleware, adminScope, r.proxyStatusHandler)
+	r.engine.POST("/api/v1/system/proxy/test", authCheckMiddleware, adminScope, r.proxyTestHandler)
+
+	// Security dashboard (requires admin)
+	if r.securityHandler != nil {
+		securityGroup := r.engine.Group("/api/v1/security")
+		securityGroup.Use(authCheckMiddleware, adminScope)
+		r.securityHandler.RegisterRoutes(securityGroup)
+		if r.digestHandler != nil {
+			r.digestHandler.RegisterRoutes(securityGroup)
+		}
+	}
+
+	// Repository settings routes (requires auth)
+	if r.ipRuleHandler != nil {
+		ipRuleGroup := r.engine.Group("/api/v1/security/ip-rules")
+		ipRuleGroup.Use(authCheckMiddleware, adminScope)
+		r.ipRuleHandler.RegisterRoutes(ipRuleGroup)
+	}
+
+	repoGroup := r.engine.Group("/api/v1/repositories")
+	repoGroup.Use(authCheckMiddleware, registryScope)
+	if r.repositoryHandler != nil {
+		r.repositoryHandler.RegisterRoutes(repoGroup)
+	}
+
 	// WebSocket routes
 	wsGroup := r.engine.Group("/api/v1")
 	if r.wsHandler != nil {
@@ -410,42 +1359,139 @@ func (r *Router) setupRoutes() {
 
 	// Signature routes (requires auth)
 	signatureGroup := r.engine.Group("/api/v1/signatures")
-	signatureGroup.Use(authCheckMiddleware)
+	signatureGroup.Use(authCheckMiddleware, registryScope)
 	if r.signatureHandler != nil {
 		r.signatureHandler.RegisterRoutes(signatureGroup)
 	}
 
 	// SBOM routes (requires auth)
 	sbomGroup := r.engine.Group("/api/v1/sbom")
-	sbomGroup.Use(authCheckMiddleware)
+	sbomGroup.Use(authCheckMiddleware, registryScope)
 	if r.sbomHandler != nil {
 		r.sbomHandler.RegisterRoutes(sbomGroup)
+		if r.licenseHandler != nil {
+			r.licenseHandler.RegisterSBOMRoutes(sbomGroup)
+		}
+		if r.vulnDBHandler != nil {
+			r.vulnDBHandler.RegisterRoutes(sbomGroup)
+		}
+	}
+
+	// Backup routes (requires auth)
+	backupGroup := r.engine.Group("/api/v1/system/backups")
+	backupGroup.Use(authCheckMiddleware, adminScope)
+	if r.backupHandler != nil {
+		r.backupHandler.RegisterRoutes(backupGroup)
+	}
+
+	// Image statistics routes (requires auth)
+	if r.statsHandler != nil {
+		statsGroup := r.engine.Group("/api/v1/stats")
+		statsGroup.Use(authCheckMiddleware, registryScope)
+		r.statsHandler.RegisterRoutes(statsGroup)
+	}
+
+	// Registry event log routes (requires admin)
+	if r.eventHandler != nil {
+		eventsGroup := r.engine.Group("/api/v1/events")
+		eventsGroup.Use(authCheckMiddleware, adminScope)
+		r.eventHandler.RegisterRoutes(eventsGroup)
+	}
+
+	// Image copy routes (requires auth)
+	if r.registryHandler != nil {
+		imagesGroup := r.engine.Group("/api/v1/images")
+		imagesGroup.Use(authCheckMiddleware, r.requireImageScope())
+		r.registryHandler.RegisterImageActionRoutes(imagesGroup)
+	}
+
+	// Image promotion routes (requires auth)
+	if r.promotionHandler != nil {
+		promotionGroup := r.engine.Group("/api/v1/promotions")
+		promotionGroup.Use(authCheckMiddleware, registryScope)
+		r.promotionHandler.RegisterRoutes(promotionGroup)
+	}
+
+	// Provenance badge routes (requires auth)
+	if r.provenanceHandler != nil {
+		provenanceGroup := r.engine.Group("/api/v1/provenance")
+		provenanceGroup.Use(authCheckMiddleware, registryScope)
+		r.provenanceHandler.RegisterRoutes(provenanceGroup)
+	}
+
+	// Helm chart index routes (requires auth)
+	if r.registryHandler != nil {
+		chartsGroup := r.engine.Group("/api/v1/charts")
+		chartsGroup.Use(authCheckMiddleware, r.requireImageScope())
+		r.registryHandler.RegisterChartRoutes(chartsGroup)
+	}
+
+	// Storage usage routes (requires auth)
+	if r.registryHandler != nil {
+		systemGroup := r.engine.Group("/api/v1/system")
+		systemGroup.Use(authCheckMiddleware, adminScope)
+		r.registryHandler.RegisterSystemRoutes(systemGroup)
+	}
+
+	// Sync, credential and replication routes (requires auth)
+	if r.syncHandler != nil {
+		r.syncHandler.RegisterWebhookRoutes(r.engine.Group("/api"))
+
+		syncGroup := r.engine.Group("/api")
+		syncGroup.Use(authCheckMiddleware, adminScope)
+		r.syncHandler.RegisterRoutes(syncGroup)
+
+		syncV1Group := r.engine.Group("/api/v1/sync")
+		syncV1Group.Use(authCheckMiddleware, adminScope)
+		r.syncHandler.RegisterProgressRoutes(syncV1Group)
+
+		credGroup := r.engine.Group("/api/v1/credentials")
+		credGroup.Use(authCheckMiddleware, adminScope)
+		r.syncHandler.RegisterCredentialRoutes(credGroup)
+	}
+
+	// Workflow routes (requires auth)
+	if r.workflowHandler != nil {
+		workflowGroup := r.engine.Group("/api/v1/workflows")
+		workflowGroup.Use(authCheckMiddleware, adminScope)
+		r.workflowHandler.RegisterRoutes(workflowGroup)
+
+		jobGroup := r.engine.Group("/api/v1/jobs")
+		jobGroup.Use(authCheckMiddleware, adminScope)
+		r.workflowHandler.RegisterJobRoutes(jobGroup)
 	}
 
 	// DNS routes (no auth required for DNS resolution)
 	dnsGroup := r.engine.Group("/api/v1")
 	if r.dnsHandler != nil {
 		r.dnsHandler.RegisterRoutes(dnsGroup)
+		r.dnsHandler.RegisterAdminRoutes(r.engine.Group("/api/v1", authCheckMiddleware, adminScope))
 	}
 
 	// P2P routes - 修复问题4
 	p2pGroup := r.engine.Group("/api/v1")
 	if r.p2pHandler != nil {
 		r.p2pHandler.RegisterRoutes(p2pGroup)
+		r.p2pHandler.RegisterAdminRoutes(r.engine.Group("/api/v1", authCheckMiddleware, adminScope))
 	}
 
 	// Global service status route
 	r.engine.GET("/api/v1/global/status", r.globalServiceStatusHandler)
-	r.engine.POST("/api/v1/global/apply/accelerator", authCheckMiddleware, r.applyAcceleratorHandler)
-	r.engine.POST("/api/v1/global/apply/dns", authCheckMiddleware, r.applyDNSHandler)
-	r.engine.POST("/api/v1/global/apply/p2p", authCheckMiddleware, r.applyP2PHandler)
+	r.engine.POST("/api/v1/global/apply/accelerator", authCheckMiddleware, adminScope, r.applyAcceleratorHandler)
+	r.engine.POST("/api/v1/global/apply/dns", authCheckMiddleware, adminScope, r.applyDNSHandler)
+	r.engine.POST("/api/v1/global/apply/p2p", authCheckMiddleware, adminScope, r.applyP2PHandler)
 
-	// Docker Registry V2 API routes
+	// Docker Registry V2 API routes, public repositories can be pulled
+	// anonymously when auth is enabled
 	v2 := r.engine.Group("/v2")
+	v2.Use(r.createRegistryAuthMiddleware())
 	{
 		// Register registry routes if handler is available
 		if r.registryHandler != nil {
-			r.registryHandler.RegisterRoutes(v2, r.engine.Group("/api"))
+			// Image listings are filtered by what the client may pull, so
+			// credentials are checked when given; deletes need a login
+			r.registryHandler.RegisterRoutes(v2, r.engine.Group("/api", optionalAuth(authCheckMiddleware)))
+			r.registryHandler.RegisterImageDeleteRoutes(r.engine.Group("/api", authCheckMiddleware, registryScope))
 		} else {
 			v2.GET("/", r.v2BaseHandler)
 			v2.Any("/*path", r.v2PlaceholderHandler)
@@ -459,6 +1505,7 @@ func (r *Router) setupRoutes() {
 		accel := api.Group("/accel")
 		if r.acceleratorHandler != nil {
 			r.acceleratorHandler.RegisterRoutes(accel)
+			r.acceleratorHandler.RegisterPinRoutes(accel.Group("/pins", authCheckMiddleware, adminScope))
 		} else {
 			accel.Any("/*path", r.apiPlaceholderHandler)
 		}
@@ -466,13 +1513,13 @@ func (r *Router) setupRoutes() {
 		// System information
 		system := api.Group("/system")
 		if r.detectorHandler != nil {
-			r.detectorHandler.RegisterRoutes(system)
+			r.registerDetectorRoutes(system, authCheckMiddleware, adminScope)
 		} else {
 			system.Any("/*path", r.apiPlaceholderHandler)
 		}
 
-		// Update management
-		update := api.Group("/update")
+		// Update management (requires admin)
+		update := api.Group("/update", authCheckMiddleware, adminScope)
 		if r.updaterHandler != nil {
 			r.updaterHandler.RegisterRoutes(update)
 		} else {
@@ -514,14 +1561,32 @@ func (r *Router) versionFullHandler(c *gin.Context) {
 	})
 }
 
+// optionalAuth runs authCheck on requests that carry credentials and lets
+// anonymous requests through without a current user.
+func optionalAuth(authCheck gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			c.Next()
+			return
+		}
+		authCheck(c)
+	}
+}
+
+// registerDetectorRoutes registers the system information routes; the
+// environment report needs an administrator.
+func (r *Router) registerDetectorRoutes(system *gin.RouterGroup, authCheck, adminScope gin.HandlerFunc) {
+	r.detectorHandler.RegisterRoutes(system)
+	r.detectorHandler.RegisterEnvironmentRoutes(system.Group("", authCheck, adminScope))
+}
+
 // createAuthCheckMiddleware creates a simple authentication check middleware.
 // 修复问题1：为组织管理、分享管理、访问令牌等路由添加认证检查
 func (r *Router) createAuthCheckMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check if system is locked
 		if r.lockService != nil && r.lockService.IsSystemLocked() {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"error":       "系统已锁定",
+			common.AbortWithError(c, http.StatusForbidden, common.ErrSystemLocked, "系统已锁定", gin.H{
 				"details":     "system_locked",
 				"lock_reason": r.lockService.GetLockReason(),
 			})
@@ -531,31 +1596,38 @@ func (r *Router) createAuthCheckMiddleware() gin.HandlerFunc {
 		// Check authorization header
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-				"error": "缺少认证信息",
-				"code":  "no_auth_header",
-			})
+			common.AbortWithError(c, http.StatusUnauthorized, common.ErrNoAuthHeader, "缺少认证信息", nil)
+			return
+		}
+
+		tokenStr, ok := bearerToken(authHeader)
+
+		// Personal access tokens, restricted by their scopes
+		if ok && strings.HasPrefix(tokenStr, patPrefix) {
+			user, token, err := r.tokenUser(tokenStr)
+			if err != nil {
+				r.recordAttempt(c, service.AttemptToken, c.Request.URL.Path, "failure", "invalid_token")
+				common.AbortWithError(c, http.StatusUnauthorized, common.ErrInvalidToken, "访问令牌无效", nil)
+				return
+			}
+			c.Set("currentUser", user)
+			c.Set("currentToken", token)
+			c.Next()
 			return
 		}
 
 		// Validate JWT token
 		if r.authService != nil && strings.HasPrefix(authHeader, "Bearer ") {
-			tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
 			user, err := r.authService.ValidateJWT(tokenStr)
 			if err != nil {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"error": "JWT令牌无效",
-					"code":  "invalid_jwt",
-				})
+				r.recordAttempt(c, service.AttemptToken, c.Request.URL.Path, "failure", "invalid_jwt")
+				common.AbortWithError(c, http.StatusUnauthorized, common.ErrInvalidJWT, "JWT令牌无效", nil)
 				return
 			}
 
 			// Check if user is active
 			if !user.IsActive {
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"error": "用户已被禁用",
-					"code":  "inactive_user",
-				})
+				common.AbortWithError(c, http.StatusUnauthorized, common.ErrInactiveUser, "用户已被禁用", nil)
 				return
 			}
 
@@ -566,10 +1638,7 @@ func (r *Router) createAuthCheckMiddleware() gin.HandlerFunc {
 		}
 
 		// Invalid authorization format
-		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-			"error": "认证格式无效",
-			"code":  "invalid_format",
-		})
+		common.AbortWithError(c, http.StatusUnauthorized, common.ErrInvalidFormat, "认证格式无效", nil)
 	}
 }
 
@@ -729,6 +1798,11 @@ func (r *Router) setupStaticFiles() {
 
 	if staticPath == "" {
 		logger.Warn("Static files directory not found, frontend will not be served")
+		r.engine.NoRoute(func(c *gin.Context) {
+			common.ErrorResponse(c, common.ErrNotFound, gin.H{
+				"path": c.Request.URL.Path,
+			})
+		})
 		return
 	}
 
@@ -761,7 +1835,7 @@ func (r *Router) setupStaticFiles() {
 		path := c.Request.URL.Path
 
 		// Skip API and V2 routes
-		if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/v2") || strings.HasPrefix(path, "/health") {
+		if strings.HasPrefix(path, "/api") || strings.HasPrefix(path, "/v2") || strings.HasPrefix(path, "/health") || path == "/readyz" {
 			common.ErrorResponse(c, common.ErrNotFound, gin.H{
 				"path": path,
 			})
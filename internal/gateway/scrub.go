@@ -0,0 +1,54 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// initScrub schedules the storage integrity scrub and connects the sources
+// corrupted blobs are repaired from: P2P peers, then the accelerator with
+// its cache and upstream registries.
+func (r *Router) initScrub() {
+	if r.registryService == nil {
+		return
+	}
+	maint := r.config.Maintenance
+	interval, _ := time.ParseDuration(maint.ScrubInterval)
+	if interval <= 0 {
+		return
+	}
+
+	if r.p2pService != nil {
+		r.registryService.AddBlobRepairer("p2p", func(ctx context.Context, _, digest string) (io.ReadCloser, error) {
+			reader, _, err := r.p2pService.RequestBlob(ctx, digest)
+			return reader, err
+		})
+	}
+	if r.acceleratorHandler != nil {
+		proxy := r.acceleratorHandler.GetProxy()
+		r.registryService.AddBlobRepairer("upstream", func(_ context.Context, repository, digest string) (io.ReadCloser, error) {
+			if repository == "" {
+				return nil, errors.New("blob not referenced by any repository")
+			}
+			reader, _, err := proxy.ProxyPull(repository, digest)
+			return reader, err
+		})
+	}
+	r.registryService.SetScrubNotifier(r.notifyScrub)
+	r.registryService.SetScrubBatch(parseSize(maint.ScrubBatch))
+	r.automationEngine.SetBlobScrubber(r.registryService, interval)
+}
+
+// notifyScrub reports corrupted blobs to administrators in the web console
+// and to the alert recipients.
+func (r *Router) notifyScrub(level, title, message string) {
+	logger.Warn("存储完整性检查发现损坏的 Blob", zap.String("summary", message))
+	if r.wsHandler != nil {
+		r.wsHandler.NotifyAdmins(level, title, message)
+	}
+	r.sendAlertEmail(title, message, "发送完整性检查告警邮件失败")
+}
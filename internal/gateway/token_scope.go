@@ -0,0 +1,202 @@
+package gateway
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// patPrefix marks personal access tokens.
+const patPrefix = "pat_"
+
+// tokenUser resolves the owner of a personal access token. Tokens of
+// deactivated or deleted users are rejected.
+func (r *Router) tokenUser(plainToken string) (*service.User, *service.Token, error) {
+	if r.tokenService == nil {
+		return nil, nil, errors.New("token service unavailable")
+	}
+	token, err := r.tokenService.ValidateToken(plainToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	daoUser, err := dao.GetUserByID(token.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if daoUser == nil || !daoUser.IsActive {
+		return nil, nil, errors.New("invalid token owner")
+	}
+	return &service.User{
+		ID:       daoUser.ID,
+		Username: daoUser.Username,
+		Email:    daoUser.Email.String,
+		Role:     daoUser.Role,
+		IsActive: daoUser.IsActive,
+	}, token, nil
+}
+
+// rateLimitIdentity identifies the credentials of a request for rate
+// limiting: the user of a valid JWT or a valid personal access token, also
+// as a basic auth password. Passwords are not checked here since hashing
+// them is costly, such requests are counted per client IP.
+func (r *Router) rateLimitIdentity(c *gin.Context) string {
+	credential, ok := bearerToken(c.GetHeader("Authorization"))
+	if !ok {
+		_, password, basic := c.Request.BasicAuth()
+		if !basic || !strings.HasPrefix(password, patPrefix) {
+			return ""
+		}
+		credential = password
+	}
+
+	if strings.HasPrefix(credential, patPrefix) {
+		if r.tokenService == nil {
+			return ""
+		}
+		token, err := r.tokenService.LookupToken(credential)
+		if err != nil {
+			return ""
+		}
+		return "token:" + strconv.FormatInt(token.ID, 10)
+	}
+	if r.authService == nil {
+		return ""
+	}
+	user, err := r.authService.ValidateJWT(credential)
+	if err != nil || !user.IsActive {
+		return ""
+	}
+	return "user:" + strconv.FormatInt(user.ID, 10)
+}
+
+// currentToken returns the personal access token of the request, or nil
+// when the request is authenticated with a login session.
+func currentToken(c *gin.Context) *service.Token {
+	if v, ok := c.Get("currentToken"); ok {
+		if token, ok := v.(*service.Token); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// scopeDenied aborts a request whose token lacks the required scope.
+func scopeDenied(c *gin.Context, scope string) {
+	common.AbortWithError(c, http.StatusForbidden, common.ErrInsufficientScope, "令牌权限不足", gin.H{"required_scope": scope})
+}
+
+// requireScope checks the scope of requests made with a personal access
+// token: read for GET and HEAD, write for other methods. Login sessions are
+// not restricted by scopes.
+func (r *Router) requireScope(read, write string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := currentToken(c)
+		if token == nil {
+			c.Next()
+			return
+		}
+
+		scope := write
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = read
+		}
+		if !service.ScopeAllows(token.Scopes, scope) {
+			scopeDenied(c, scope)
+			return
+		}
+		c.Next()
+	}
+}
+
+// requireAdminScope is requireScope for administration routes, which are
+// limited to administrators whether they use a login session or a token.
+func (r *Router) requireAdminScope() gin.HandlerFunc {
+	check := r.requireScope(service.ScopeAdminRead, service.ScopeAdminWrite)
+	return func(c *gin.Context) {
+		if user := currentUser(c); user == nil || user.Role != service.RoleAdmin {
+			common.AbortWithError(c, http.StatusForbidden, common.ErrForbidden, "需要管理员权限", nil)
+			return
+		}
+		check(c)
+	}
+}
+
+// requireImageScope is requireScope for image routes, where deletes need
+// registry:delete.
+func (r *Router) requireImageScope() gin.HandlerFunc {
+	check := r.requireScope(service.ScopeRegistryRead, service.ScopeRegistryWrite)
+	return func(c *gin.Context) {
+		if token := currentToken(c); token != nil && c.Request.Method == http.MethodDelete {
+			if !service.ScopeAllows(token.Scopes, service.ScopeRegistryDelete) {
+				scopeDenied(c, service.ScopeRegistryDelete)
+				return
+			}
+			c.Next()
+			return
+		}
+		check(c)
+	}
+}
+
+// requireOrgScope checks organization routes: routes of one organization
+// need org:<name>:read or org:<name>:write, the others the admin scopes.
+func (r *Router) requireOrgScope() gin.HandlerFunc {
+	adminCheck := r.requireScope(service.ScopeAdminRead, service.ScopeAdminWrite)
+	return func(c *gin.Context) {
+		token := currentToken(c)
+		if token == nil {
+			c.Next()
+			return
+		}
+
+		idOrName := c.Param("id")
+		if idOrName == "" || r.orgService == nil {
+			adminCheck(c)
+			return
+		}
+		org, err := r.orgService.ResolveOrganization(idOrName)
+		if err != nil || org == nil {
+			// 交给处理函数返回 404
+			c.Next()
+			return
+		}
+
+		write := c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead
+		scope := service.OrgScope(org.Name, write)
+		if !service.ScopeAllows(token.Scopes, scope) {
+			scopeDenied(c, scope)
+			return
+		}
+		c.Next()
+	}
+}
+
+// sessionOnly rejects personal access tokens, for routes that manage the
+// account itself such as passwords and tokens.
+func (r *Router) sessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if currentToken(c) != nil {
+			common.AbortWithError(c, http.StatusForbidden, common.ErrSessionRequired, "此操作需要登录会话，不能使用访问令牌", nil)
+			return
+		}
+		c.Next()
+	}
+}
+
+// bearerToken extracts the credential of "Bearer <token>" and
+// "Token <token>" authorization headers.
+func bearerToken(authHeader string) (string, bool) {
+	for _, scheme := range []string{"Bearer ", "Token "} {
+		if strings.HasPrefix(authHeader, scheme) {
+			return strings.TrimPrefix(authHeader, scheme), true
+		}
+	}
+	return "", false
+}
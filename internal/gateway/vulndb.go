@@ -0,0 +1,41 @@
+package gateway
+
+import (
+	"time"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/handler"
+	"cyp-docker-registry/internal/service"
+
+	"go.uber.org/zap"
+)
+
+// initVulnDB schedules the vulnerability database refresh, which re-scans
+// the images containing packages of changed advisories.
+func (r *Router) initVulnDB() {
+	cfg := r.config.SBOM.VulnDB
+	if r.sbomService != nil && cfg.Source != "" {
+		interval, _ := time.ParseDuration(cfg.RefreshInterval)
+		if interval <= 0 {
+			interval = 6 * time.Hour
+		}
+		r.vulnDB = service.NewVulnDB(service.VulnDBConfig{
+			Source:      cfg.Source,
+			StoragePath: "./data/sboms",
+		}, r.sbomService, logger)
+		r.vulnDB.SetOutboundProxy(outboundProxy(r.config.Proxy, common.ProxyScanner))
+		r.vulnDB.SetNotifier(r.notifyVulnerable)
+		r.automationEngine.SetVulnDBRefresher(r.vulnDB, interval)
+	}
+	r.vulnDBHandler = handler.NewVulnDBHandler(r.vulnDB, r.auditService)
+}
+
+// notifyVulnerable reports images that became vulnerable after a database
+// update to administrators in the web console and to the alert recipients.
+func (r *Router) notifyVulnerable(level, title, message string) {
+	logger.Warn("重新扫描发现新的漏洞镜像", zap.String("summary", message))
+	if r.wsHandler != nil {
+		r.wsHandler.NotifyAdmins(level, title, message)
+	}
+	r.sendAlertEmail(title, message, "发送漏洞告警邮件失败")
+}
@@ -0,0 +1,90 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/handler"
+	"cyp-docker-registry/internal/service"
+)
+
+const (
+	p2pStatsInterval     = 10 * time.Second
+	updateStatusInterval = 2 * time.Second
+)
+
+// wsAuthenticate authenticates WebSocket connections with a JWT or a
+// personal access token.
+func (r *Router) wsAuthenticate(token string) (*service.User, []string, error) {
+	if strings.HasPrefix(token, patPrefix) {
+		user, pat, err := r.tokenUser(token)
+		if err != nil {
+			return nil, nil, err
+		}
+		return user, pat.Scopes, nil
+	}
+	if r.authService == nil {
+		return nil, nil, errors.New("auth service unavailable")
+	}
+	user, err := r.authService.ValidateJWT(token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.IsActive {
+		return nil, nil, errors.New("user is inactive")
+	}
+	return user, nil, nil
+}
+
+// initWSEvents connects the event sources to the WebSocket topics.
+func (r *Router) initWSEvents() {
+	ws := r.wsHandler
+	ws.SetAuthenticator(r.wsAuthenticate)
+
+	r.auditService.SetListener(func(log *service.AuditLog) {
+		ws.Publish(handler.TopicAudit, log.Event, toEventData(log))
+	})
+	r.sbomService.SetEventHandler(func(event string, data map[string]interface{}) {
+		ws.Publish(handler.TopicScanResults, event, data)
+	})
+}
+
+// publishStats 定期推送 P2P 统计与更新状态，仅在有订阅者时采集；
+// 更新状态只在变化时推送
+func (r *Router) publishStats() {
+	ticker := time.NewTicker(updateStatusInterval)
+	defer ticker.Stop()
+
+	var lastP2P time.Time
+	var lastUpdate interface{}
+	for now := range ticker.C {
+		ws := r.wsHandler
+
+		if r.p2pService != nil && now.Sub(lastP2P) >= p2pStatsInterval && ws.HasSubscribers(handler.TopicP2PStats) {
+			lastP2P = now
+			ws.Publish(handler.TopicP2PStats, "stats", toEventData(r.p2pService.GetStatus()))
+		}
+
+		if r.updaterService != nil && ws.HasSubscribers(handler.TopicUpdateStatus) {
+			status := r.updaterService.GetStatus()
+			if !reflect.DeepEqual(status, lastUpdate) {
+				lastUpdate = status
+				ws.Publish(handler.TopicUpdateStatus, status.State, toEventData(status))
+			}
+		}
+	}
+}
+
+// toEventData converts a value to the data map of a WebSocket message.
+func toEventData(v interface{}) map[string]interface{} {
+	data := map[string]interface{}{}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+	json.Unmarshal(raw, &data)
+	return data
+}
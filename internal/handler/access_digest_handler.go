@@ -0,0 +1,155 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessDigestHandler handles the daily access summaries and the digest
+// subscriptions of administrators.
+type AccessDigestHandler struct {
+	digestService *service.AccessDigestService
+	auditService  *service.AuditService
+}
+
+// NewAccessDigestHandler creates a new AccessDigestHandler instance.
+func NewAccessDigestHandler(digestSvc *service.AccessDigestService, auditSvc *service.AuditService) *AccessDigestHandler {
+	return &AccessDigestHandler{
+		digestService: digestSvc,
+		auditService:  auditSvc,
+	}
+}
+
+// RegisterRoutes registers the access summary routes.
+func (h *AccessDigestHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/access-summaries", h.ListSummaries)
+	r.GET("/access-summaries/:date", h.GetSummary)
+	r.GET("/access-digest/subscription", h.GetSubscription)
+	r.PUT("/access-digest/subscription", h.UpdateSubscription)
+}
+
+// ListSummaries returns the access summaries of the last days, today
+// first, selected with ?days= (default 7, at most 31).
+func (h *AccessDigestHandler) ListSummaries(c *gin.Context) {
+	days := 7
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			common.Error(c, http.StatusBadRequest, "无效的天数")
+			return
+		}
+		days = n
+	}
+
+	summaries, err := h.digestService.Summaries(days)
+	if err != nil {
+		digestError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"summaries": summaries})
+}
+
+// GetSummary returns the access summary of one day, given as 2006-01-02
+// in the time zone of the server.
+func (h *AccessDigestHandler) GetSummary(c *gin.Context) {
+	day, err := service.ParseSummaryDate(c.Param("date"))
+	if err != nil {
+		digestError(c, err)
+		return
+	}
+
+	summary, err := h.digestService.Summary(day)
+	if err != nil {
+		digestError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// GetSubscription returns the digest subscription of the current
+// administrator.
+func (h *AccessDigestHandler) GetSubscription(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	sub, err := h.digestService.GetSubscription(user.ID)
+	if err != nil {
+		digestError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription": sub})
+}
+
+// UpdateSubscription subscribes the current administrator to the daily
+// digest, or unsubscribes them.
+func (h *AccessDigestHandler) UpdateSubscription(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	var req struct {
+		Subscribed    *bool `json:"subscribed" binding:"required"`
+		OnlyAnomalies bool  `json:"only_anomalies"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	sub, err := h.digestService.UpdateSubscription(user.ID, *req.Subscribed, req.OnlyAnomalies)
+	if err != nil {
+		digestError(c, err)
+		return
+	}
+
+	if h.auditService != nil {
+		action := "unsubscribe"
+		if sub.Subscribed {
+			action = "subscribe"
+		}
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "access_digest_subscription",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Action:    action,
+			Status:    "success",
+			Details: map[string]interface{}{
+				"only_anomalies": sub.OnlyAnomalies,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"subscription": sub, "message": "订阅设置已更新"})
+}
+
+// digestError maps access digest errors to HTTP responses.
+func digestError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidSummaryDate):
+		common.Error(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, service.ErrDigestNoEmail):
+		common.Error(c, http.StatusBadRequest, "账号未设置邮箱，无法订阅访问摘要")
+	case errors.Is(err, service.ErrUserNotFound):
+		common.Error(c, http.StatusNotFound, "用户不存在")
+	default:
+		common.Error(c, http.StatusInternalServerError, "获取访问摘要失败")
+	}
+}
@@ -5,21 +5,55 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
 )
 
 // AuditHandler handles audit log requests.
-type AuditHandler struct{}
+type AuditHandler struct {
+	redactor atomic.Pointer[service.AuditRedactor]
+}
 
 // NewAuditHandler creates a new AuditHandler instance.
 func NewAuditHandler() *AuditHandler {
 	return &AuditHandler{}
 }
 
+// SetRedactor sets the redaction policy applied to listed and exported
+// logs, nil disables it.
+func (h *AuditHandler) SetRedactor(redactor *service.AuditRedactor) {
+	h.redactor.Store(redactor)
+}
+
+// redact anonymizes the IP addresses and usernames of the logs older than
+// the retention period of the redaction policy.
+func (h *AuditHandler) redact(logs []*dao.AuditLog) {
+	redactor := h.redactor.Load()
+	if redactor == nil {
+		return
+	}
+	for _, log := range logs {
+		if !redactor.Due(log.Timestamp) {
+			continue
+		}
+		log.IPAddress = redactor.IP(log.IPAddress)
+		if log.Username.Valid {
+			log.Username.String = redactor.Username(log.Username.String)
+		}
+		log.Details = redactor.Details(log.Details)
+		if log.Event == "user_admin" {
+			// 用户管理事件的资源是被操作的用户名
+			log.Resource = redactor.Username(log.Resource)
+		}
+	}
+}
+
 // RegisterRoutes registers audit routes.
 func (h *AuditHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("/logs", h.GetAuditLogs)
@@ -31,6 +65,7 @@ func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
 	eventType := c.Query("event_type")
+	requestID := c.Query("request_id")
 
 	var startDate, endDate time.Time
 	if s := c.Query("start_date"); s != "" {
@@ -40,11 +75,12 @@ func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
 		endDate, _ = time.Parse(time.RFC3339, e)
 	}
 
-	logs, total, err := dao.GetAuditLogs(page, pageSize, eventType, startDate, endDate)
+	logs, total, err := dao.GetAuditLogs(page, pageSize, eventType, requestID, startDate, endDate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.redact(logs)
 
 	// Convert to response format
 	responseLogs := make([]map[string]interface{}, len(logs))
@@ -62,6 +98,7 @@ func (h *AuditHandler) GetAuditLogs(c *gin.Context) {
 			"status":          log.Status,
 			"details":         log.Details,
 			"blockchain_hash": log.BlockchainHash,
+			"request_id":      log.RequestID.String,
 		}
 	}
 
@@ -84,11 +121,12 @@ func (h *AuditHandler) ExportAuditLogs(c *gin.Context) {
 	}
 
 	// Get all logs within date range
-	logs, _, err := dao.GetAuditLogs(1, 10000, "", startDate, endDate)
+	logs, _, err := dao.GetAuditLogs(1, 10000, "", "", startDate, endDate)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
+	h.redact(logs)
 
 	// Convert to export format
 	exportLogs := make([]map[string]interface{}, len(logs))
@@ -106,6 +144,7 @@ func (h *AuditHandler) ExportAuditLogs(c *gin.Context) {
 			"status":          log.Status,
 			"details":         log.Details,
 			"blockchain_hash": log.BlockchainHash,
+			"request_id":      log.RequestID.String,
 		}
 	}
 
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -40,6 +41,11 @@ func (h *AuthHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.POST("/register", h.Register)
 	r.POST("/verify-token", h.VerifyToken)
 	r.GET("/heartbeat", h.Heartbeat)
+}
+
+// RegisterUserRoutes registers the routes of authenticated users, the group
+// must check the login session or access token.
+func (h *AuthHandler) RegisterUserRoutes(r *gin.RouterGroup) {
 	r.GET("/me", h.GetCurrentUser)
 }
 
@@ -54,10 +60,7 @@ type LoginRequest struct {
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "请求参数无效",
-			"code":  "invalid_request",
-		})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
@@ -65,8 +68,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 
 	// Check if system is locked
 	if h.lockService != nil && h.lockService.IsSystemLocked() {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error":       "系统已锁定",
+		common.ErrorWithCode(c, http.StatusForbidden, common.ErrSystemLocked, "系统已锁定", gin.H{
 			"details":     "system_locked",
 			"lock_reason": h.lockService.GetLockReason(),
 		})
@@ -93,10 +95,18 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		// Log failed attempt
 		if h.intrusionService != nil {
 			h.intrusionService.IncrementFailedAttempt(clientIP, "login_failure")
+			h.intrusionService.RecordAttempt(&service.AccessAttempt{
+				IPAddress: clientIP,
+				UserAgent: c.Request.UserAgent(),
+				Action:    service.AttemptLogin,
+				Resource:  req.Username,
+				Status:    "failure",
+				ErrorMsg:  "login_failure",
+			})
 		}
 
 		if h.auditService != nil {
-			h.auditService.LogAuthFailure(clientIP, req.Username, err.Error())
+			h.auditService.LogAuthFailure(clientIP, req.Username, err.Error(), common.RequestID(c))
 		}
 
 		// Get remaining attempts
@@ -105,17 +115,21 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			remaining = h.intrusionService.GetRemainingAttempts(clientIP, "login_failure")
 		}
 
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error":              "用户名或密码错误",
-			"code":               "login_failure",
-			"remaining_attempts": remaining,
-		})
+		common.ErrorWithCode(c, http.StatusUnauthorized, common.ErrLoginFailure, "用户名或密码错误", gin.H{"remaining_attempts": remaining})
 		return
 	}
 
 	// Reset failed attempts on successful login
 	if h.intrusionService != nil {
 		h.intrusionService.ResetAttempts(clientIP)
+		h.intrusionService.RecordAttempt(&service.AccessAttempt{
+			IPAddress: clientIP,
+			UserAgent: c.Request.UserAgent(),
+			UserID:    resp.User.ID,
+			Action:    service.AttemptLogin,
+			Resource:  resp.User.Username,
+			Status:    "success",
+		})
 	}
 
 	// Log successful login
@@ -126,6 +140,7 @@ func (h *AuthHandler) Login(c *gin.Context) {
 			UserID:    resp.User.ID,
 			Username:  resp.User.Username,
 			IPAddress: clientIP,
+			RequestID: common.RequestID(c),
 			Action:    "login",
 			Status:    "success",
 		})
@@ -149,6 +164,7 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 				UserID:    u.ID,
 				Username:  u.Username,
 				IPAddress: c.ClientIP(),
+				RequestID: common.RequestID(c),
 				Action:    "logout",
 				Status:    "success",
 			})
@@ -167,10 +183,7 @@ func (h *AuthHandler) VerifyToken(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "请求参数无效",
-			"code":  "invalid_request",
-		})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
@@ -178,12 +191,17 @@ func (h *AuthHandler) VerifyToken(c *gin.Context) {
 	if err != nil {
 		if h.intrusionService != nil {
 			h.intrusionService.IncrementFailedAttempt(c.ClientIP(), "invalid_jwt")
+			h.intrusionService.RecordAttempt(&service.AccessAttempt{
+				IPAddress: c.ClientIP(),
+				UserAgent: c.Request.UserAgent(),
+				Action:    service.AttemptToken,
+				Resource:  c.Request.URL.Path,
+				Status:    "failure",
+				ErrorMsg:  "invalid_jwt",
+			})
 		}
 
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "令牌无效",
-			"code":  "invalid_token",
-		})
+		common.ErrorWithCode(c, http.StatusUnauthorized, common.ErrInvalidToken, "令牌无效", nil)
 		return
 	}
 
@@ -205,10 +223,7 @@ func (h *AuthHandler) Heartbeat(c *gin.Context) {
 func (h *AuthHandler) GetCurrentUser(c *gin.Context) {
 	user, exists := c.Get("currentUser")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未登录",
-			"code":  "not_authenticated",
-		})
+		common.ErrorWithCode(c, http.StatusUnauthorized, common.ErrAuthFailed, "未登录", nil)
 		return
 	}
 
@@ -227,10 +242,7 @@ type RegisterRequest struct {
 func (h *AuthHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "请求参数无效",
-			"code":  "invalid_request",
-		})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
@@ -238,8 +250,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 	// Check if system is locked
 	if h.lockService != nil && h.lockService.IsSystemLocked() {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error":       "系统已锁定",
+		common.ErrorWithCode(c, http.StatusForbidden, common.ErrSystemLocked, "系统已锁定", gin.H{
 			"details":     "system_locked",
 			"lock_reason": h.lockService.GetLockReason(),
 		})
@@ -262,6 +273,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 				Event:     "register_failure",
 				Username:  req.Username,
 				IPAddress: clientIP,
+				RequestID: common.RequestID(c),
 				Action:    "register",
 				Status:    "failure",
 				Details: map[string]any{
@@ -270,10 +282,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			})
 		}
 
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  "register_failure",
-		})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -285,6 +294,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 			UserID:    user.ID,
 			Username:  user.Username,
 			IPAddress: clientIP,
+			RequestID: common.RequestID(c),
 			Action:    "register",
 			Status:    "success",
 		})
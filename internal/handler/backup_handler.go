@@ -0,0 +1,204 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BackupHandler handles backup and restore requests.
+type BackupHandler struct {
+	backupService *service.BackupService
+	auditService  *service.AuditService
+}
+
+// NewBackupHandler creates a new BackupHandler instance.
+func NewBackupHandler(backupSvc *service.BackupService, auditSvc *service.AuditService) *BackupHandler {
+	return &BackupHandler{
+		backupService: backupSvc,
+		auditService:  auditSvc,
+	}
+}
+
+// RegisterRoutes registers backup routes.
+func (h *BackupHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListBackups)
+	r.POST("", h.CreateBackup)
+	r.GET("/targets", h.ListTargets)
+	r.GET("/:id/download", h.DownloadBackup)
+	r.POST("/:id/verify", h.VerifyBackup)
+	r.POST("/:id/restore", h.RestoreBackup)
+	r.DELETE("/:id", h.DeleteBackup)
+}
+
+// CreateBackupRequest represents a manual backup request.
+type CreateBackupRequest struct {
+	IncludeBlobs bool `json:"include_blobs"`
+}
+
+// ListBackups lists all backups.
+func (h *BackupHandler) ListBackups(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	backups, err := h.backupService.ListBackups()
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取备份列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"backups": backups})
+}
+
+// ListTargets lists configured remote backup targets.
+func (h *BackupHandler) ListTargets(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"targets": h.backupService.Targets()})
+}
+
+// CreateBackup creates a new backup.
+func (h *BackupHandler) CreateBackup(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	var req CreateBackupRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "请求参数无效")
+			return
+		}
+	}
+
+	info, err := h.backupService.CreateBackup(c.Request.Context(), req.IncludeBlobs)
+	if err != nil {
+		h.logBackupEvent(c, user, "backup_created", "create", "failure", err.Error())
+		common.Error(c, http.StatusInternalServerError, "创建备份失败: "+err.Error())
+		return
+	}
+
+	h.logBackupEvent(c, user, "backup_created", "create", "success", info.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "备份创建成功",
+		"backup":  info,
+	})
+}
+
+// DownloadBackup streams a backup archive.
+func (h *BackupHandler) DownloadBackup(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	info, err := h.backupService.GetBackup(c.Param("id"))
+	if err != nil {
+		common.Error(c, http.StatusNotFound, "备份不存在")
+		return
+	}
+
+	h.logBackupEvent(c, user, "backup_downloaded", "download", "success", info.ID)
+
+	if info.Checksum != "" {
+		c.Header("X-Checksum-SHA256", info.Checksum)
+	}
+	c.FileAttachment(info.Path, filepath.Base(info.Path))
+}
+
+// VerifyBackup verifies backup checksums.
+func (h *BackupHandler) VerifyBackup(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	manifest, err := h.backupService.VerifyBackup(c.Param("id"))
+	if err != nil {
+		common.ErrorWithCode(c, http.StatusUnprocessableEntity, common.ErrUnprocessable, err.Error(), gin.H{"valid": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"valid":         true,
+		"file_count":    len(manifest.Files),
+		"include_blobs": manifest.IncludeBlobs,
+		"created_at":    manifest.CreatedAt,
+	})
+}
+
+// RestoreBackup restores data from a backup.
+func (h *BackupHandler) RestoreBackup(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	// 恢复过程中不随客户端断开而中止，避免数据处于半恢复状态
+	id := c.Param("id")
+	manifest, err := h.backupService.RestoreBackup(context.WithoutCancel(c.Request.Context()), id)
+	if err != nil {
+		h.logBackupEvent(c, user, "backup_restored", "restore", "failure", id+": "+err.Error())
+		common.Error(c, http.StatusInternalServerError, "恢复备份失败: "+err.Error())
+		return
+	}
+
+	h.logBackupEvent(c, user, "backup_restored", "restore", "success", id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "备份恢复成功",
+		"file_count": len(manifest.Files),
+	})
+}
+
+// DeleteBackup deletes a backup.
+func (h *BackupHandler) DeleteBackup(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.backupService.DeleteBackup(id); err != nil {
+		common.Error(c, http.StatusNotFound, "备份不存在")
+		return
+	}
+
+	h.logBackupEvent(c, user, "backup_deleted", "delete", "success", id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "备份已删除"})
+}
+
+// logBackupEvent writes a backup audit record.
+func (h *BackupHandler) logBackupEvent(c *gin.Context, user *service.User, event, action, status, details string) {
+	if h.auditService == nil {
+		return
+	}
+
+	level := "info"
+	if status != "success" {
+		level = "warn"
+	}
+
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     level,
+		Event:     event,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Action:    action,
+		Status:    status,
+		Details:   map[string]interface{}{"backup": details},
+	})
+}
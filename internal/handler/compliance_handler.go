@@ -0,0 +1,135 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ComplianceHandler handles compliance report downloads.
+type ComplianceHandler struct {
+	complianceService *service.ComplianceService
+	auditService      *service.AuditService
+}
+
+// NewComplianceHandler creates a new ComplianceHandler instance.
+func NewComplianceHandler(complianceSvc *service.ComplianceService, auditSvc *service.AuditService) *ComplianceHandler {
+	return &ComplianceHandler{
+		complianceService: complianceSvc,
+		auditService:      auditSvc,
+	}
+}
+
+// RegisterAuditRoutes registers the report of the registry, or of the
+// organization given with ?org=.
+func (h *ComplianceHandler) RegisterAuditRoutes(r *gin.RouterGroup) {
+	r.GET("/compliance-report", h.GetReport)
+}
+
+// RegisterOrgRoutes registers the report of an organization.
+func (h *ComplianceHandler) RegisterOrgRoutes(r *gin.RouterGroup) {
+	r.GET("/:id/compliance-report", h.GetReport)
+}
+
+// GetReport renders a compliance report as an HTML, PDF or JSON download.
+// The start and end dates bound the audit log integrity check, the other
+// sections are the current state.
+func (h *ComplianceHandler) GetReport(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	var q service.ComplianceQuery
+	org := c.Param("id")
+	if org == "" {
+		org = c.Query("org")
+	}
+	if org != "" {
+		id, err := strconv.ParseInt(org, 10, 64)
+		if err != nil || id <= 0 {
+			common.Error(c, http.StatusBadRequest, "无效的组织ID")
+			return
+		}
+		q.OrgID = id
+	}
+	for param, t := range map[string]*time.Time{"start_date": &q.Since, "end_date": &q.Until} {
+		if s := c.Query(param); s != "" {
+			parsed, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				common.Error(c, http.StatusBadRequest, "无效的时间: "+param)
+				return
+			}
+			*t = parsed
+		}
+	}
+	format := c.DefaultQuery("format", "html")
+	if format != "html" && format != "pdf" && format != "json" {
+		common.Error(c, http.StatusBadRequest, "不支持的报告格式")
+		return
+	}
+
+	report, err := h.complianceService.Report(q, user.ID, user.Username)
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrInvalidCompliancePeriod):
+			common.Error(c, http.StatusBadRequest, "结束时间必须晚于开始时间")
+		case errors.Is(err, service.ErrServiceUnavailable):
+			common.Error(c, http.StatusServiceUnavailable, err.Error())
+		default:
+			common.Error(c, teamErrorStatus(err), err.Error())
+		}
+		return
+	}
+
+	var data []byte
+	var contentType string
+	switch format {
+	case "pdf":
+		data, contentType = service.RenderCompliancePDF(report), "application/pdf"
+	case "json":
+		data, _ = json.MarshalIndent(report, "", "  ")
+		contentType = "application/json"
+	default:
+		data, err = service.RenderComplianceHTML(report)
+		if err != nil {
+			common.Error(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		contentType = "text/html; charset=utf-8"
+	}
+
+	if h.auditService != nil {
+		details := map[string]interface{}{
+			"format": format,
+			"images": report.Summary.Images,
+		}
+		if report.Audit != nil {
+			details["audit_intact"] = report.Audit.Intact
+		}
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "compliance_report",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  report.Scope,
+			Action:    "export",
+			Status:    "success",
+			Details:   details,
+			OrgID:     report.OrgID,
+		})
+	}
+	c.Header("Content-Disposition", "attachment; filename="+report.Filename(format))
+	c.Data(http.StatusOK, contentType, data)
+}
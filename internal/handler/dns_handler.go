@@ -4,6 +4,7 @@ package handler
 import (
 	"net/http"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -30,6 +31,13 @@ func (h *DNSHandler) RegisterRoutes(r *gin.RouterGroup) {
 	}
 }
 
+// RegisterAdminRoutes registers the resolver status routes, which require
+// authentication.
+func (h *DNSHandler) RegisterAdminRoutes(r *gin.RouterGroup) {
+	r.GET("/dns/status", h.Status)
+	r.DELETE("/dns/cache", h.FlushCache)
+}
+
 // ResolveRequest represents a DNS resolve request.
 type ResolveRequest struct {
 	Domain string `json:"domain" binding:"required"`
@@ -39,19 +47,13 @@ type ResolveRequest struct {
 func (h *DNSHandler) Resolve(c *gin.Context) {
 	var req ResolveRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "请求参数无效",
-			"code":  "invalid_request",
-		})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
 	result, err := h.dnsService.Resolve(req.Domain)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  "resolve_failed",
-		})
+		common.ErrorWithCode(c, http.StatusBadRequest, common.ErrUpstreamError, err.Error(), nil)
 		return
 	}
 
@@ -62,21 +64,28 @@ func (h *DNSHandler) Resolve(c *gin.Context) {
 func (h *DNSHandler) ResolveGet(c *gin.Context) {
 	domain := c.Query("domain")
 	if domain == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "请提供域名参数",
-			"code":  "missing_domain",
-		})
+		common.Error(c, http.StatusBadRequest, "请提供域名参数")
 		return
 	}
 
 	result, err := h.dnsService.Resolve(domain)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
-			"code":  "resolve_failed",
-		})
+		common.ErrorWithCode(c, http.StatusBadRequest, common.ErrUpstreamError, err.Error(), nil)
 		return
 	}
 
 	c.JSON(http.StatusOK, result)
 }
+
+// Status returns the upstream servers, domain routes and cache statistics.
+func (h *DNSHandler) Status(c *gin.Context) {
+	common.SuccessResponse(c, h.dnsService.Status())
+}
+
+// FlushCache drops the cached DNS responses.
+func (h *DNSHandler) FlushCache(c *gin.Context) {
+	h.dnsService.FlushCache()
+	common.SuccessResponse(c, gin.H{
+		"message": "DNS 缓存已清空",
+	})
+}
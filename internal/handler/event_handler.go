@@ -0,0 +1,88 @@
+// Package handler provides HTTP handlers for CYP-Docker-Registry.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EventHandler handles registry event log requests.
+type EventHandler struct {
+	eventLog *service.RegistryEventLog
+}
+
+// NewEventHandler creates a new EventHandler instance.
+func NewEventHandler(eventLog *service.RegistryEventLog) *EventHandler {
+	return &EventHandler{
+		eventLog: eventLog,
+	}
+}
+
+// RegisterRoutes registers event log routes.
+func (h *EventHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListEvents)
+}
+
+// ListEvents lists image pushes, pulls and deletes, newest first.
+// Query: type (push, pull, delete), repository, tag, user, ip, since and
+// until (RFC 3339), page, page_size (default 50, max 500).
+func (h *EventHandler) ListEvents(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	query := service.RegistryEventQuery{
+		Type:       c.Query("type"),
+		Repository: c.Query("repository"),
+		Tag:        c.Query("tag"),
+		Username:   c.Query("user"),
+		ClientIP:   c.Query("ip"),
+	}
+	switch query.Type {
+	case "", service.RegistryEventPush, service.RegistryEventPull, service.RegistryEventDelete:
+	default:
+		common.Error(c, http.StatusBadRequest, "type 必须是 push、pull 或 delete")
+		return
+	}
+
+	var err error
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &query.Since}, {"until", &query.Until}} {
+		if v := c.Query(t.name); v != "" {
+			if *t.dst, err = time.Parse(time.RFC3339, v); err != nil {
+				common.Error(c, http.StatusBadRequest, t.name+" 必须是 RFC 3339 时间")
+				return
+			}
+		}
+	}
+
+	if query.Page, err = strconv.Atoi(c.DefaultQuery("page", "1")); err != nil || query.Page < 1 {
+		common.Error(c, http.StatusBadRequest, "page 必须大于 0")
+		return
+	}
+	if query.PageSize, err = strconv.Atoi(c.DefaultQuery("page_size", "50")); err != nil || query.PageSize < 1 || query.PageSize > 500 {
+		common.Error(c, http.StatusBadRequest, "page_size 必须在 1-500 之间")
+		return
+	}
+
+	events, total, err := h.eventLog.List(query)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取镜像事件失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events":    events,
+		"total":     total,
+		"page":      query.Page,
+		"page_size": query.PageSize,
+	})
+}
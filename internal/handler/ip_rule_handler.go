@@ -0,0 +1,197 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPRuleHandler handles IP allow/deny rule requests.
+type IPRuleHandler struct {
+	ipRuleService *service.IPRuleService
+	auditService  *service.AuditService
+}
+
+// NewIPRuleHandler creates a new IPRuleHandler instance.
+func NewIPRuleHandler(ipRuleSvc *service.IPRuleService, auditSvc *service.AuditService) *IPRuleHandler {
+	return &IPRuleHandler{
+		ipRuleService: ipRuleSvc,
+		auditService:  auditSvc,
+	}
+}
+
+// RegisterRoutes registers IP rule routes.
+func (h *IPRuleHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListRules)
+	r.POST("", h.CreateRule)
+	r.GET("/check", h.CheckIP)
+	r.GET("/blocks", h.ListBlocks)
+	r.DELETE("/blocks/:ip", h.Unblock)
+	r.PUT("/:id", h.UpdateRule)
+	r.DELETE("/:id", h.DeleteRule)
+}
+
+// ipRuleErrorStatus maps IP rule errors to HTTP status codes.
+func ipRuleErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrIPRuleNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidIPRule):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrIPRuleLockout):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// ListRules lists the configured and runtime rules.
+func (h *IPRuleHandler) ListRules(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"rules": h.ipRuleService.ListRules()})
+}
+
+// CreateRule adds a rule. It takes effect immediately.
+func (h *IPRuleHandler) CreateRule(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+
+	var req service.IPRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	rule, err := h.ipRuleService.CreateRule(&req, admin.Username, c.ClientIP())
+	if err != nil {
+		common.Error(c, ipRuleErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "create", rule)
+	c.JSON(http.StatusCreated, gin.H{"rule": rule, "message": "IP规则已添加"})
+}
+
+// UpdateRule changes a rule added at runtime.
+func (h *IPRuleHandler) UpdateRule(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的规则ID")
+		return
+	}
+
+	var req service.IPRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	rule, err := h.ipRuleService.UpdateRule(id, &req, c.ClientIP())
+	if err != nil {
+		common.Error(c, ipRuleErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "update", rule)
+	c.JSON(http.StatusOK, gin.H{"rule": rule, "message": "IP规则已更新"})
+}
+
+// DeleteRule deletes a rule added at runtime.
+func (h *IPRuleHandler) DeleteRule(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的规则ID")
+		return
+	}
+
+	rule, err := h.ipRuleService.DeleteRule(id, c.ClientIP())
+	if err != nil {
+		common.Error(c, ipRuleErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "delete", rule)
+	c.JSON(http.StatusOK, gin.H{"message": "IP规则已删除"})
+}
+
+// CheckIP evaluates ?ip= (default: the caller) against the rules for ?path=.
+func (h *IPRuleHandler) CheckIP(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	ip := c.DefaultQuery("ip", c.ClientIP())
+	path := c.DefaultQuery("path", "/")
+	allowed, reason := h.ipRuleService.Evaluate(ip, path)
+	c.JSON(http.StatusOK, gin.H{
+		"ip":      ip,
+		"path":    path,
+		"allowed": allowed,
+		"reason":  reason,
+	})
+}
+
+// ListBlocks lists the addresses temporarily blocked by intrusion detection.
+func (h *IPRuleHandler) ListBlocks(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"blocks": h.ipRuleService.ListBlocks()})
+}
+
+// Unblock lifts a temporary block.
+func (h *IPRuleHandler) Unblock(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+
+	ip := c.Param("ip")
+	if !h.ipRuleService.Unblock(ip) {
+		common.Error(c, http.StatusNotFound, "该地址未被封禁")
+		return
+	}
+
+	h.audit(c, admin, "unblock", &service.IPRule{CIDR: ip})
+	c.JSON(http.StatusOK, gin.H{"message": "已解除封禁"})
+}
+
+// audit records an IP rule change.
+func (h *IPRuleHandler) audit(c *gin.Context, admin *service.User, action string, rule *service.IPRule) {
+	if h.auditService == nil {
+		return
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "warn",
+		Event:     "ip_rule",
+		UserID:    admin.ID,
+		Username:  admin.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  rule.CIDR,
+		Action:    action,
+		Status:    "success",
+		Details: map[string]interface{}{
+			"rule_id":     rule.ID,
+			"rule_action": rule.Action,
+			"scope":       rule.Scope,
+		},
+	})
+}
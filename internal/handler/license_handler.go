@@ -0,0 +1,187 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LicenseHandler handles license policy and compliance report requests.
+type LicenseHandler struct {
+	licenseService *service.LicenseService
+	auditService   *service.AuditService
+}
+
+// NewLicenseHandler creates a new LicenseHandler instance.
+func NewLicenseHandler(licenseSvc *service.LicenseService, auditSvc *service.AuditService) *LicenseHandler {
+	return &LicenseHandler{
+		licenseService: licenseSvc,
+		auditService:   auditSvc,
+	}
+}
+
+// RegisterOrgRoutes registers the license routes of organizations.
+func (h *LicenseHandler) RegisterOrgRoutes(r *gin.RouterGroup) {
+	r.GET("/:id/license-policy", h.GetPolicy)
+	r.PUT("/:id/license-policy", h.SetPolicy)
+	r.DELETE("/:id/license-policy", h.DeletePolicy)
+	r.GET("/:id/license-report", h.GetOrgReport)
+}
+
+// RegisterSBOMRoutes registers the license report of images.
+func (h *LicenseHandler) RegisterSBOMRoutes(r *gin.RouterGroup) {
+	r.GET("/licenses", h.GetImageReport)
+}
+
+// GetPolicy returns the license policy of an organization.
+func (h *LicenseHandler) GetPolicy(c *gin.Context) {
+	orgID, user, ok := licenseOrgParams(c)
+	if !ok {
+		return
+	}
+
+	policy, err := h.licenseService.GetPolicy(orgID, user.ID)
+	if err != nil {
+		licenseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"policy": policy})
+}
+
+// SetPolicy creates or replaces the license policy of an organization.
+func (h *LicenseHandler) SetPolicy(c *gin.Context) {
+	orgID, user, ok := licenseOrgParams(c)
+	if !ok {
+		return
+	}
+
+	var req service.LicensePolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	policy, err := h.licenseService.SetPolicy(orgID, &req, user.ID, user.Username)
+	if err != nil {
+		licenseError(c, err)
+		return
+	}
+
+	h.audit(c, user, orgID, "update", policy.Org, map[string]interface{}{
+		"mode":         policy.Mode,
+		"allowed":      policy.Allowed,
+		"forbidden":    policy.Forbidden,
+		"deny_unknown": policy.DenyUnknown,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"policy":  policy,
+		"message": "许可证策略已更新",
+	})
+}
+
+// DeletePolicy removes the license policy of an organization.
+func (h *LicenseHandler) DeletePolicy(c *gin.Context) {
+	orgID, user, ok := licenseOrgParams(c)
+	if !ok {
+		return
+	}
+
+	policy, err := h.licenseService.DeletePolicy(orgID, user.ID)
+	if err != nil {
+		licenseError(c, err)
+		return
+	}
+
+	h.audit(c, user, orgID, "delete", policy.Org, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "许可证策略已删除"})
+}
+
+// GetOrgReport returns the license compliance of the images of an
+// organization.
+func (h *LicenseHandler) GetOrgReport(c *gin.Context) {
+	orgID, user, ok := licenseOrgParams(c)
+	if !ok {
+		return
+	}
+
+	report, err := h.licenseService.OrgReport(orgID, user.ID)
+	if err != nil {
+		licenseError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// GetImageReport returns the license compliance of an image, e.g.
+// ?image=team/app:1.0.
+func (h *LicenseHandler) GetImageReport(c *gin.Context) {
+	imageRef := c.Query("image")
+	if imageRef == "" {
+		common.Error(c, http.StatusBadRequest, "image 参数不能为空")
+		return
+	}
+
+	report, err := h.licenseService.ImageReport(imageRef)
+	if err != nil {
+		common.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// licenseOrgParams parses the organization ID and current user.
+func licenseOrgParams(c *gin.Context) (int64, *service.User, bool) {
+	orgID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return 0, nil, false
+	}
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return 0, nil, false
+	}
+	return orgID, user, true
+}
+
+// licenseError maps license policy errors to HTTP responses.
+func licenseError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrLicensePolicyNotFound):
+		common.Error(c, http.StatusNotFound, "组织没有许可证策略")
+	case errors.Is(err, service.ErrInvalidLicensePolicy):
+		common.Error(c, http.StatusBadRequest, err.Error())
+	default:
+		common.Error(c, teamErrorStatus(err), err.Error())
+	}
+}
+
+// audit writes an audit log entry of a license policy change, shown in the
+// activity of the organization.
+func (h *LicenseHandler) audit(c *gin.Context, user *service.User, orgID int64, action, resource string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "info",
+		Event:     "license_policy",
+		UserID:    user.ID,
+		Username:  user.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  resource,
+		Action:    action,
+		Status:    "success",
+		Details:   details,
+		OrgID:     orgID,
+	})
+}
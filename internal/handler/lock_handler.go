@@ -3,7 +3,10 @@ package handler
 
 import (
 	"net/http"
+	"strconv"
+	"time"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -13,6 +16,14 @@ import (
 type LockHandler struct {
 	lockService  *service.LockService
 	auditService *service.AuditService
+
+	// 密码解锁，authService 为空时不允许手动解锁
+	authService *service.AuthService
+	guard       *service.UnlockGuard
+	totp        *service.TOTPVerifier
+
+	// 一次性解锁令牌，为空时不接受令牌
+	tokens *service.UnlockTokens
 }
 
 // NewLockHandler creates a new LockHandler instance.
@@ -23,6 +34,22 @@ func NewLockHandler(lockSvc *service.LockService, auditSvc *service.AuditService
 	}
 }
 
+// EnablePasswordUnlock allows administrators to unlock the system with
+// their password, limited by guard. With totp set a one-time code is also
+// required.
+func (h *LockHandler) EnablePasswordUnlock(authSvc *service.AuthService, guard *service.UnlockGuard, totp *service.TOTPVerifier) {
+	h.authService = authSvc
+	h.guard = guard
+	h.totp = totp
+}
+
+// EnableTokenUnlock allows unlocking the system with the one-time token
+// written on the host when the system locks, limited by guard.
+func (h *LockHandler) EnableTokenUnlock(tokens *service.UnlockTokens, guard *service.UnlockGuard) {
+	h.tokens = tokens
+	h.guard = guard
+}
+
 // RegisterRoutes registers lock routes.
 func (h *LockHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("/status", h.GetLockStatus)
@@ -45,46 +72,153 @@ func (h *LockHandler) GetLockStatus(c *gin.Context) {
 
 // UnlockRequest represents an unlock request.
 type UnlockRequest struct {
-	Password    string `json:"password" binding:"required"`
+	Username    string `json:"username,omitempty"` // 管理员用户名，默认 admin
+	Password    string `json:"password,omitempty"`
+	OTP         string `json:"otp,omitempty"` // 启用 require_2fa 时的 TOTP 动态码
 	RecoveryKey string `json:"recovery_key,omitempty"`
+	// 锁定时写入主机上令牌文件的一次性解锁令牌，可代替密码
+	UnlockToken string `json:"unlock_token,omitempty"`
 }
 
 // Unlock handles system unlock requests.
-// 问题9修复：系统锁定后不允许手动解锁，只能联系管理员或重新安装
+// 问题9修复：默认不允许手动解锁，只能联系管理员或重新安装；配置
+// allow_password 后管理员可用密码解锁，配置 allow_token 后可用主机上的
+// 一次性解锁令牌解锁。按 IP 限制失败次数并逐次延长等待，累计失败过多时
+// 升级为永久锁定。每次尝试都记录审计日志
 func (h *LockHandler) Unlock(c *gin.Context) {
 	var req UnlockRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-			"code":  "invalid_request",
-		})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+	if req.Password == "" && req.UnlockToken == "" {
+		common.Error(c, http.StatusBadRequest, "请提供密码或解锁令牌")
 		return
 	}
 
 	if h.lockService == nil {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "系统未锁定",
-		})
+		common.SuccessResponse(c, gin.H{"message": "系统未锁定"})
 		return
 	}
 
 	// 检查系统是否锁定
 	status := h.lockService.GetLockStatus()
 	if !status.IsLocked {
-		c.JSON(http.StatusOK, gin.H{
-			"message": "系统未锁定",
-		})
+		common.SuccessResponse(c, gin.H{"message": "系统未锁定"})
 		return
 	}
 
-	// 系统锁定后不允许手动解锁
-	// 只能通过以下方式解锁：
+	clientIP := c.ClientIP()
+	username := req.Username
+	if username == "" {
+		username = "admin"
+	}
+	useToken := req.UnlockToken != ""
+	if useToken {
+		// 令牌不属于任何用户
+		username = unlockTokenUser
+	}
+
+	// 未启用所用的解锁方式或已永久锁定时，只能通过以下方式解锁：
 	// 1. 联系管理员进行后台操作
 	// 2. 重新安装系统
-	c.JSON(http.StatusForbidden, gin.H{
-		"error":   "系统已锁定，不允许手动解锁",
-		"code":    "manual_unlock_disabled",
-		"message": "系统锁定后不允许手动解锁。请联系管理员或重新安装系统。",
+	enabled := h.authService != nil
+	if useToken {
+		enabled = h.tokens != nil
+	}
+	if !enabled || status.LockType == service.LockTypePermanent {
+		h.logUnlockAttempt(c, username, "denied", gin.H{"lock_type": status.LockType})
+		h.manualUnlockDisabled(c, status)
+		return
+	}
+
+	// 校验前先占用该 IP 的尝试名额，并发请求不能绕过失败计数
+	if wait := h.guard.Reserve(clientIP); wait > 0 {
+		h.logUnlockAttempt(c, username, "throttled", gin.H{"retry_after": retryAfterSeconds(wait)})
+		c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+		common.ErrorWithCode(c, http.StatusTooManyRequests, common.ErrUnlockThrottled, "解锁尝试过于频繁，请稍后再试", gin.H{
+			"retry_after": retryAfterSeconds(wait),
+		})
+		return
+	}
+
+	if useToken {
+		if !h.tokens.Consume(req.UnlockToken) {
+			// 过期的令牌换成新令牌，主机上的管理员可重新读取
+			if h.tokens.Expired() {
+				h.lockService.RenewUnlockToken()
+			}
+			h.unlockFailed(c, username, "解锁令牌无效或已过期")
+			return
+		}
+	} else {
+		// 缺少动态码时不校验密码，也不计入失败次数
+		if h.totp != nil && req.OTP == "" {
+			h.guard.Release(clientIP)
+			h.logUnlockAttempt(c, username, "otp_required", nil)
+			common.ErrorWithCode(c, http.StatusUnauthorized, common.ErrOTPRequired, "需要动态验证码", nil)
+			return
+		}
+
+		user, err := h.authService.VerifyPassword(username, req.Password)
+		valid := err == nil && user.Role == "admin"
+		if valid && h.totp != nil {
+			valid = h.totp.Verify(req.OTP, time.Now())
+		}
+		if !valid {
+			h.unlockFailed(c, username, "用户名、密码或动态码错误")
+			return
+		}
+		username = user.Username
+	}
+
+	h.guard.Release(clientIP)
+	if err := h.lockService.UnlockSystem(req.Password); err != nil {
+		common.Error(c, http.StatusInternalServerError, "系统解锁失败")
+		return
+	}
+	h.guard.Reset()
+
+	h.logUnlockAttempt(c, username, "success", nil)
+	if h.auditService != nil {
+		h.auditService.LogUnlockEvent(clientIP, username)
+	}
+
+	common.SuccessResponse(c, gin.H{"message": "系统已解锁"})
+}
+
+// unlockTokenUser is the username recorded for unlocks with a token.
+const unlockTokenUser = "unlock-token"
+
+// unlockFailed counts a failed unlock attempt, escalating the lock once
+// the failures of the client IP reach the permanent threshold.
+func (h *LockHandler) unlockFailed(c *gin.Context, username, message string) {
+	clientIP := c.ClientIP()
+	wait, remaining, permanent := h.guard.Failure(clientIP)
+	if permanent {
+		h.lockService.EscalateLock("解锁失败次数过多")
+		h.logUnlockAttempt(c, username, "escalated", nil)
+		if h.auditService != nil {
+			h.auditService.LogLockEvent(clientIP, "解锁失败次数过多", service.LockTypePermanent, common.RequestID(c))
+		}
+		h.manualUnlockDisabled(c, h.lockService.GetLockStatus())
+		return
+	}
+
+	h.logUnlockAttempt(c, username, "failure", gin.H{
+		"remaining_attempts": remaining,
+		"retry_after":        retryAfterSeconds(wait),
+	})
+	c.Header("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	common.ErrorWithCode(c, http.StatusUnauthorized, common.ErrUnlockFailure, message, gin.H{
+		"remaining_attempts": remaining,
+		"retry_after":        retryAfterSeconds(wait),
+	})
+}
+
+// manualUnlockDisabled responds that the lock cannot be lifted here.
+func (h *LockHandler) manualUnlockDisabled(c *gin.Context, status *service.LockStatus) {
+	common.ErrorWithCode(c, http.StatusForbidden, common.ErrManualUnlockDisabled, "系统已锁定，不允许手动解锁", gin.H{
 		"details": gin.H{
 			"lock_reason":   status.LockReason,
 			"lock_type":     status.LockType,
@@ -94,6 +228,19 @@ func (h *LockHandler) Unlock(c *gin.Context) {
 	})
 }
 
+// logUnlockAttempt writes the audit entry of an unlock attempt.
+func (h *LockHandler) logUnlockAttempt(c *gin.Context, username, status string, details gin.H) {
+	if h.auditService == nil {
+		return
+	}
+	h.auditService.LogUnlockAttempt(c.ClientIP(), username, status, common.RequestID(c), details)
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds.
+func retryAfterSeconds(wait time.Duration) int {
+	return int((wait + time.Second - 1) / time.Second)
+}
+
 // LockRequest represents a manual lock request.
 type LockRequest struct {
 	Reason string `json:"reason" binding:"required"`
@@ -103,34 +250,25 @@ type LockRequest struct {
 func (h *LockHandler) Lock(c *gin.Context) {
 	var req LockRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-			"code":  "invalid_request",
-		})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	if h.lockService == nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "锁定服务不可用",
-		})
+		common.Error(c, http.StatusInternalServerError, "锁定服务不可用")
 		return
 	}
 
 	err := h.lockService.LockSystem(req.Reason, c.ClientIP())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "系统锁定失败",
-		})
+		common.Error(c, http.StatusInternalServerError, "系统锁定失败")
 		return
 	}
 
 	// Log lock event
 	if h.auditService != nil {
-		h.auditService.LogLockEvent(c.ClientIP(), req.Reason, "manual")
+		h.auditService.LogLockEvent(c.ClientIP(), req.Reason, "manual", common.RequestID(c))
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message": "系统锁定成功",
-	})
+	common.SuccessResponse(c, gin.H{"message": "系统锁定成功"})
 }
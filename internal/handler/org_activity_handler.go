@@ -0,0 +1,54 @@
+// Package handler provides HTTP handlers for CYP-Docker-Registry.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetActivity returns the recent activity of an organization. The :id
+// parameter accepts either the organization ID or its name.
+func (h *OrgHandler) GetActivity(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	org, err := h.orgService.ResolveOrganization(c.Param("id"))
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if org == nil {
+		common.Error(c, http.StatusNotFound, "组织不存在")
+		return
+	}
+
+	query := &service.ActivityQuery{Type: c.Query("type")}
+	query.Limit, _ = strconv.Atoi(c.Query("limit"))
+	query.Before, _ = strconv.ParseInt(c.Query("before"), 10, 64)
+
+	activities, err := h.orgService.Activity(org.ID, query, user.ID)
+	if err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	// 返回下一页游标，取满一页时才可能还有更早的记录
+	var nextBefore int64
+	if len(activities) > 0 && len(activities) == query.Limit {
+		nextBefore = activities[len(activities)-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"org":         org.Name,
+		"activities":  activities,
+		"next_before": nextBefore,
+	})
+}
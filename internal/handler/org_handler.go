@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -34,6 +35,37 @@ func (h *OrgHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("/:id/members", h.GetMembers)
 	r.POST("/:id/members", h.AddMember)
 	r.DELETE("/:id/members/:userId", h.RemoveMember)
+
+	// Teams
+	r.GET("/:id/teams", h.ListTeams)
+	r.POST("/:id/teams", h.CreateTeam)
+	r.GET("/:id/teams/:teamId", h.GetTeam)
+	r.PUT("/:id/teams/:teamId", h.UpdateTeam)
+	r.DELETE("/:id/teams/:teamId", h.DeleteTeam)
+	r.GET("/:id/teams/:teamId/members", h.GetTeamMembers)
+	r.POST("/:id/teams/:teamId/members", h.AddTeamMember)
+	r.DELETE("/:id/teams/:teamId/members/:userId", h.RemoveTeamMember)
+	r.GET("/:id/teams/:teamId/repositories", h.GetTeamRepositories)
+	r.PUT("/:id/teams/:teamId/repositories", h.SetTeamRepository)
+	r.DELETE("/:id/teams/:teamId/repositories", h.RemoveTeamRepository)
+	r.GET("/:id/permissions", h.GetEffectivePermissions)
+
+	// Invitations
+	r.GET("/:id/invitations", h.ListInvitations)
+	r.POST("/:id/invitations", h.InviteMember)
+	r.DELETE("/:id/invitations/:inviteId", h.RevokeInvitation)
+
+	// Activity
+	r.GET("/:id/activity", h.GetActivity)
+}
+
+// RegisterInvitationRoutes registers the routes used by invitees to list
+// and answer their invitations.
+func (h *OrgHandler) RegisterInvitationRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListMyInvitations)
+	r.POST("/accept", h.AcceptInvitationToken)
+	r.POST("/:inviteId/accept", h.AcceptInvitation)
+	r.POST("/:inviteId/decline", h.DeclineInvitation)
 }
 
 // ListOrganizations lists all organizations.
@@ -44,7 +76,7 @@ func (h *OrgHandler) ListOrganizations(c *gin.Context) {
 	// Get current user
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
@@ -61,7 +93,7 @@ func (h *OrgHandler) ListOrganizations(c *gin.Context) {
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -77,19 +109,19 @@ func (h *OrgHandler) ListOrganizations(c *gin.Context) {
 func (h *OrgHandler) CreateOrganization(c *gin.Context) {
 	var req service.CreateOrgRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	org, err := h.orgService.CreateOrganization(&req, user.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -103,13 +135,13 @@ func (h *OrgHandler) CreateOrganization(c *gin.Context) {
 func (h *OrgHandler) GetOrganization(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的组织ID"})
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
 		return
 	}
 
 	org, err := h.orgService.GetOrganization(id)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusNotFound, err.Error())
 		return
 	}
 
@@ -120,7 +152,7 @@ func (h *OrgHandler) GetOrganization(c *gin.Context) {
 func (h *OrgHandler) UpdateOrganization(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的组织ID"})
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
 		return
 	}
 
@@ -128,18 +160,18 @@ func (h *OrgHandler) UpdateOrganization(c *gin.Context) {
 		DisplayName string `json:"display_name"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	if err := h.orgService.UpdateOrganization(id, req.DisplayName, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -150,18 +182,18 @@ func (h *OrgHandler) UpdateOrganization(c *gin.Context) {
 func (h *OrgHandler) DeleteOrganization(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的组织ID"})
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	if err := h.orgService.DeleteOrganization(id, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -172,13 +204,13 @@ func (h *OrgHandler) DeleteOrganization(c *gin.Context) {
 func (h *OrgHandler) GetMembers(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的组织ID"})
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
 		return
 	}
 
 	members, err := h.orgService.GetMembers(id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -189,7 +221,7 @@ func (h *OrgHandler) GetMembers(c *gin.Context) {
 func (h *OrgHandler) AddMember(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的组织ID"})
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
 		return
 	}
 
@@ -198,21 +230,26 @@ func (h *OrgHandler) AddMember(c *gin.Context) {
 		Role   string `json:"role"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	if err := h.orgService.AddMember(id, req.UserID, user.ID, req.Role); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	h.auditOrg(c, user, id, "org_member", "add", c.Param("id"), map[string]interface{}{
+		"user_id": req.UserID,
+		"role":    req.Role,
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "成员添加成功"})
 }
 
@@ -220,30 +257,55 @@ func (h *OrgHandler) AddMember(c *gin.Context) {
 func (h *OrgHandler) RemoveMember(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的组织ID"})
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
 		return
 	}
 
 	userID, err := strconv.ParseInt(c.Param("userId"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的用户ID"})
+		common.Error(c, http.StatusBadRequest, "无效的用户ID")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	if err := h.orgService.RemoveMember(id, userID, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
+	h.auditOrg(c, user, id, "org_member", "remove", c.Param("id"), map[string]interface{}{
+		"user_id": userID,
+	})
+
 	c.JSON(http.StatusOK, gin.H{"message": "成员移除成功"})
 }
 
+// auditOrg records an audit event that belongs to an organization, so it
+// shows up in the organization's activity feed.
+func (h *OrgHandler) auditOrg(c *gin.Context, user *service.User, orgID int64, event, action, resource string, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "info",
+		Event:     event,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  resource,
+		Action:    action,
+		Status:    "success",
+		Details:   details,
+		OrgID:     orgID,
+	})
+}
+
 // Helper function to get current user from context
 func getCurrentUser(c *gin.Context) *service.User {
 	user, exists := c.Get("currentUser")
@@ -255,3 +317,29 @@ func getCurrentUser(c *gin.Context) *service.User {
 	}
 	return nil
 }
+
+// getCurrentToken returns the personal access token that authenticated the
+// request, or nil for login sessions.
+func getCurrentToken(c *gin.Context) *service.Token {
+	if v, ok := c.Get("currentToken"); ok {
+		if token, ok := v.(*service.Token); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+// requireAdmin returns the current user if they are an administrator,
+// otherwise writes an error response and returns nil.
+func requireAdmin(c *gin.Context) *service.User {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return nil
+	}
+	if user.Role != "admin" {
+		common.Error(c, http.StatusForbidden, "需要管理员权限")
+		return nil
+	}
+	return user
+}
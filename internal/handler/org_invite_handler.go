@@ -0,0 +1,225 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invitationErrorStatus maps invitation errors to HTTP status codes.
+func invitationErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrInvitationNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvitationExpired):
+		return http.StatusGone
+	case errors.Is(err, service.ErrInvitationNotYours):
+		return http.StatusForbidden
+	}
+	return teamErrorStatus(err)
+}
+
+// ListInvitations lists the pending invitations of an organization.
+func (h *OrgHandler) ListInvitations(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	invitations, err := h.orgService.ListInvitations(id, user.ID)
+	if err != nil {
+		common.Error(c, invitationErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// InviteMember invites a user to an organization by username or email.
+func (h *OrgHandler) InviteMember(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return
+	}
+
+	var req service.InviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	invitation, token, err := h.orgService.InviteMember(id, &req, user.ID)
+	if err != nil {
+		common.Error(c, invitationErrorStatus(err), err.Error())
+		return
+	}
+
+	// Build accept URL
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	acceptURL := scheme + "://" + c.Request.Host + "/invitations?token=" + url.QueryEscape(token)
+
+	emailSent, mailErr := h.orgService.SendInvitationEmail(invitation, acceptURL)
+
+	h.auditOrg(c, user, invitation.OrgID, "org_invitation", "invite", invitation.OrgName, map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"invitee_id":    invitation.InviteeID,
+		"email":         invitation.Email,
+		"role":          invitation.Role,
+		"email_sent":    emailSent,
+	})
+
+	resp := gin.H{
+		"invitation": invitation,
+		"token":      token,
+		"accept_url": acceptURL,
+		"email_sent": emailSent,
+		"message":    "邀请已创建",
+	}
+	if mailErr != nil {
+		resp["email_error"] = mailErr.Error()
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// RevokeInvitation revokes a pending invitation.
+func (h *OrgHandler) RevokeInvitation(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return
+	}
+	inviteID, err := strconv.ParseInt(c.Param("inviteId"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的邀请ID")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.RevokeInvitation(id, inviteID, user.ID); err != nil {
+		common.Error(c, invitationErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "邀请已撤销"})
+}
+
+// ListMyInvitations lists the pending invitations of the current user.
+func (h *OrgHandler) ListMyInvitations(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	invitations, err := h.orgService.ListUserInvitations(user)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// AcceptInvitationToken accepts an invitation with the token from the
+// invitation link.
+func (h *OrgHandler) AcceptInvitationToken(c *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	invitation, err := h.orgService.AcceptInvitationToken(req.Token, user)
+	h.respondInvitation(c, user, invitation, err, "accept")
+}
+
+// AcceptInvitation accepts one of the current user's invitations.
+func (h *OrgHandler) AcceptInvitation(c *gin.Context) {
+	h.answerInvitation(c, true)
+}
+
+// DeclineInvitation declines one of the current user's invitations.
+func (h *OrgHandler) DeclineInvitation(c *gin.Context) {
+	h.answerInvitation(c, false)
+}
+
+func (h *OrgHandler) answerInvitation(c *gin.Context, accept bool) {
+	inviteID, err := strconv.ParseInt(c.Param("inviteId"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的邀请ID")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	invitation, err := h.orgService.RespondInvitation(inviteID, user, accept)
+	action := "decline"
+	if accept {
+		action = "accept"
+	}
+	h.respondInvitation(c, user, invitation, err, action)
+}
+
+// respondInvitation writes the result of accepting or declining an
+// invitation and records it in the audit log.
+func (h *OrgHandler) respondInvitation(c *gin.Context, user *service.User, invitation *service.OrgInvitation, err error, action string) {
+	if err != nil {
+		common.Error(c, invitationErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditOrg(c, user, invitation.OrgID, "org_invitation", action, invitation.OrgName, map[string]interface{}{
+		"invitation_id": invitation.ID,
+		"role":          invitation.Role,
+	})
+
+	message := "已拒绝邀请"
+	if action == "accept" {
+		message = "已加入组织 " + invitation.OrgName
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"invitation": invitation,
+		"message":    message,
+	})
+}
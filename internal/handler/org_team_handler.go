@@ -0,0 +1,360 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseTeamParams parses the organization and team IDs of a team route.
+func parseTeamParams(c *gin.Context) (orgID, teamID int64, ok bool) {
+	orgID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return 0, 0, false
+	}
+	teamID, err = strconv.ParseInt(c.Param("teamId"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的团队ID")
+		return 0, 0, false
+	}
+	return orgID, teamID, true
+}
+
+// teamErrorStatus maps team errors to HTTP status codes.
+func teamErrorStatus(err error) int {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "not found"):
+		return http.StatusNotFound
+	case strings.Contains(msg, "permission denied"):
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// ListTeams lists the teams of an organization.
+func (h *OrgHandler) ListTeams(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return
+	}
+
+	teams, err := h.orgService.ListTeams(id)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"teams": teams})
+}
+
+// CreateTeam creates a team in an organization.
+func (h *OrgHandler) CreateTeam(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return
+	}
+
+	var req service.TeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	team, err := h.orgService.CreateTeam(id, &req, user.ID)
+	if err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditTeam(c, user, "create", team.Name, nil)
+	c.JSON(http.StatusCreated, gin.H{
+		"team":    team,
+		"message": "团队创建成功",
+	})
+}
+
+// GetTeam retrieves a team.
+func (h *OrgHandler) GetTeam(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	team, err := h.orgService.GetTeam(orgID, teamID)
+	if err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"team": team})
+}
+
+// UpdateTeam updates a team.
+func (h *OrgHandler) UpdateTeam(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	var req service.TeamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.UpdateTeam(orgID, teamID, &req, user.ID); err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "团队更新成功"})
+}
+
+// DeleteTeam deletes a team.
+func (h *OrgHandler) DeleteTeam(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.DeleteTeam(orgID, teamID, user.ID); err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditTeam(c, user, "delete", strconv.FormatInt(teamID, 10), nil)
+	c.JSON(http.StatusOK, gin.H{"message": "团队删除成功"})
+}
+
+// GetTeamMembers retrieves the members of a team.
+func (h *OrgHandler) GetTeamMembers(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	members, err := h.orgService.GetTeamMembers(orgID, teamID)
+	if err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// AddTeamMember adds an organization member to a team.
+func (h *OrgHandler) AddTeamMember(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		UserID int64 `json:"user_id" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.AddTeamMember(orgID, teamID, req.UserID, user.ID); err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditTeam(c, user, "add_member", strconv.FormatInt(teamID, 10), map[string]interface{}{"user_id": req.UserID})
+	c.JSON(http.StatusOK, gin.H{"message": "成员添加成功"})
+}
+
+// RemoveTeamMember removes a user from a team.
+func (h *OrgHandler) RemoveTeamMember(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	userID, err := strconv.ParseInt(c.Param("userId"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的用户ID")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.RemoveTeamMember(orgID, teamID, userID, user.ID); err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditTeam(c, user, "remove_member", strconv.FormatInt(teamID, 10), map[string]interface{}{"user_id": userID})
+	c.JSON(http.StatusOK, gin.H{"message": "成员移除成功"})
+}
+
+// GetTeamRepositories lists the repository permissions of a team.
+func (h *OrgHandler) GetTeamRepositories(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	repos, err := h.orgService.GetTeamRepositories(orgID, teamID)
+	if err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"repositories": repos})
+}
+
+// SetTeamRepository grants a team a permission on a repository.
+func (h *OrgHandler) SetTeamRepository(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Repository string `json:"repository" binding:"required"`
+		Permission string `json:"permission" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.SetTeamRepository(orgID, teamID, req.Repository, req.Permission, user.ID); err != nil {
+		if errors.Is(err, service.ErrInvalidPermission) {
+			common.Error(c, http.StatusBadRequest, "权限必须是 read、write 或 admin")
+			return
+		}
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditTeam(c, user, "grant", req.Repository, map[string]interface{}{
+		"team_id":    teamID,
+		"permission": req.Permission,
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "仓库权限已更新"})
+}
+
+// RemoveTeamRepository revokes a team's permission on a repository. The
+// repository is passed as a query parameter because it contains slashes.
+func (h *OrgHandler) RemoveTeamRepository(c *gin.Context) {
+	orgID, teamID, ok := parseTeamParams(c)
+	if !ok {
+		return
+	}
+
+	repository := c.Query("repository")
+	if repository == "" {
+		common.Error(c, http.StatusBadRequest, "缺少 repository 参数")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	if err := h.orgService.RemoveTeamRepository(orgID, teamID, repository, user.ID); err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	h.auditTeam(c, user, "revoke", repository, map[string]interface{}{"team_id": teamID})
+	c.JSON(http.StatusOK, gin.H{"message": "仓库权限已移除"})
+}
+
+// GetEffectivePermissions lists the repository permissions a user has in an
+// organization. Without user_id it returns the current user's permissions;
+// looking up other users requires managing the organization.
+func (h *OrgHandler) GetEffectivePermissions(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的组织ID")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	userID := user.ID
+	if q := c.Query("user_id"); q != "" {
+		userID, err = strconv.ParseInt(q, 10, 64)
+		if err != nil {
+			common.Error(c, http.StatusBadRequest, "无效的用户ID")
+			return
+		}
+		if userID != user.ID && !h.orgService.CanManageOrganization(id, user.ID) {
+			common.Error(c, http.StatusForbidden, "无权查看其他用户的权限")
+			return
+		}
+	}
+
+	permissions, err := h.orgService.EffectivePermissions(id, userID)
+	if err != nil {
+		common.Error(c, teamErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":     userID,
+		"permissions": permissions,
+	})
+}
+
+// auditTeam records a team management event.
+func (h *OrgHandler) auditTeam(c *gin.Context, user *service.User, action, resource string, details map[string]interface{}) {
+	orgID, _ := strconv.ParseInt(c.Param("id"), 10, 64)
+	h.auditOrg(c, user, orgID, "org_team", action, resource, details)
+}
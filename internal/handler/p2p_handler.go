@@ -3,7 +3,9 @@ package handler
 
 import (
 	"net/http"
+	"time"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -81,18 +83,12 @@ type ConnectPeerRequest struct {
 func (h *P2PHandler) ConnectPeer(c *gin.Context) {
 	var req ConnectPeerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": "无效的请求参数",
-		})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	if err := h.p2pService.ConnectPeer(c.Request.Context(), req.Address); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -113,10 +109,7 @@ func (h *P2PHandler) DisconnectPeer(c *gin.Context) {
 	peerID := c.Param("id")
 
 	if err := h.p2pService.DisconnectPeer(peerID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -135,10 +128,7 @@ func (h *P2PHandler) DisconnectPeer(c *gin.Context) {
 func (h *P2PHandler) ListBlobs(c *gin.Context) {
 	blobs, err := h.p2pService.ListBlobs()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -185,10 +175,7 @@ func (h *P2PHandler) AnnounceBlob(c *gin.Context) {
 	digest := c.Param("digest")
 
 	if err := h.p2pService.AnnounceBlob(c.Request.Context(), digest); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -206,10 +193,7 @@ func (h *P2PHandler) AnnounceBlob(c *gin.Context) {
 // @Router /api/v1/p2p/enable [post]
 func (h *P2PHandler) Enable(c *gin.Context) {
 	if err := h.p2pService.Start(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -227,10 +211,7 @@ func (h *P2PHandler) Enable(c *gin.Context) {
 // @Router /api/v1/p2p/disable [post]
 func (h *P2PHandler) Disable(c *gin.Context) {
 	if err := h.p2pService.Stop(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -239,3 +220,144 @@ func (h *P2PHandler) Disable(c *gin.Context) {
 		"message": "P2P已禁用",
 	})
 }
+
+// RegisterAdminRoutes 注册分享规则和节点信誉管理路由，需要认证
+func (h *P2PHandler) RegisterAdminRoutes(r *gin.RouterGroup) {
+	r.GET("/p2p/share", h.GetShareRules)
+	r.PUT("/p2p/share", h.UpdateShareRules)
+	r.GET("/p2p/reputation", h.GetReputations)
+	r.POST("/p2p/peers/:id/ban", h.BanPeer)
+	r.POST("/p2p/peers/:id/unban", h.UnbanPeer)
+}
+
+// GetShareRules 获取分享规则
+// @Summary 获取P2P分享规则
+// @Tags P2P
+// @Produce json
+// @Success 200 {object} service.P2PShareRules
+// @Router /api/v1/p2p/share [get]
+func (h *P2PHandler) GetShareRules(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": gin.H{
+			"share_mode": h.p2pService.GetStatus().ShareMode,
+			"rules":      h.p2pService.GetShareRules(),
+		},
+	})
+}
+
+// UpdateShareRules 更新分享规则
+// @Summary 更新P2P分享规则
+// @Tags P2P
+// @Accept json
+// @Produce json
+// @Param request body service.P2PShareRules true "分享规则"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/p2p/share [put]
+func (h *P2PHandler) UpdateShareRules(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	var rules service.P2PShareRules
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	if err := h.p2pService.UpdateShareRules(&rules); err != nil {
+		common.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "分享规则已更新",
+		"data":    h.p2pService.GetShareRules(),
+	})
+}
+
+// GetReputations 获取节点信誉列表
+// @Summary 获取P2P节点信誉
+// @Tags P2P
+// @Produce json
+// @Success 200 {array} p2p.PeerReputation
+// @Router /api/v1/p2p/reputation [get]
+func (h *P2PHandler) GetReputations(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"code": 0,
+		"data": h.p2pService.GetReputations(),
+	})
+}
+
+// BanPeerRequest 拉黑节点请求
+type BanPeerRequest struct {
+	Reason   string `json:"reason"`
+	Duration string `json:"duration"` // 如 24h，为空表示永久
+}
+
+// BanPeer 拉黑节点
+// @Summary 拉黑P2P节点
+// @Tags P2P
+// @Accept json
+// @Produce json
+// @Param id path string true "节点ID"
+// @Param request body BanPeerRequest false "拉黑原因和时长"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/p2p/peers/{id}/ban [post]
+func (h *P2PHandler) BanPeer(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	var req BanPeerRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "无效的请求参数")
+			return
+		}
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d < 0 {
+			common.Error(c, http.StatusBadRequest, "无效的拉黑时长")
+			return
+		}
+		duration = d
+	}
+
+	if err := h.p2pService.BanPeer(c.Param("id"), req.Reason, duration); err != nil {
+		common.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "节点已拉黑",
+	})
+}
+
+// UnbanPeer 解除节点拉黑
+// @Summary 解除P2P节点拉黑
+// @Tags P2P
+// @Produce json
+// @Param id path string true "节点ID"
+// @Success 200 {object} map[string]interface{}
+// @Router /api/v1/p2p/peers/{id}/unban [post]
+func (h *P2PHandler) UnbanPeer(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	if err := h.p2pService.UnbanPeer(c.Param("id")); err != nil {
+		common.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":    0,
+		"message": "已解除拉黑",
+	})
+}
@@ -0,0 +1,288 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PromotionHandler handles image promotion requests.
+type PromotionHandler struct {
+	promotionService *service.PromotionService
+	auditService     *service.AuditService
+}
+
+// NewPromotionHandler creates a new PromotionHandler instance.
+func NewPromotionHandler(promotionSvc *service.PromotionService, auditSvc *service.AuditService) *PromotionHandler {
+	return &PromotionHandler{
+		promotionService: promotionSvc,
+		auditService:     auditSvc,
+	}
+}
+
+// RegisterRoutes registers promotion routes.
+func (h *PromotionHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/channels", h.ListChannels)
+	r.GET("", h.ListPromotions)
+	r.POST("", h.RequestPromotion)
+	r.GET("/:id", h.GetPromotion)
+	r.POST("/:id/approve", h.ApprovePromotion)
+	r.POST("/:id/reject", h.RejectPromotion)
+}
+
+// ListChannels lists the promotion channels in order with their gates.
+func (h *PromotionHandler) ListChannels(c *gin.Context) {
+	channels := h.promotionService.Channels()
+	c.JSON(http.StatusOK, gin.H{
+		"channels": channels,
+		"total":    len(channels),
+	})
+}
+
+// ListPromotions lists promotions of the repositories the user can pull,
+// newest first, filtered by status and repository.
+func (h *PromotionHandler) ListPromotions(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	promotions, err := h.promotionService.List(user, c.Query("status"), c.Query("repository"), limit)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"promotions": promotions,
+		"total":      len(promotions),
+	})
+}
+
+// GetPromotion returns a promotion with its approvals.
+func (h *PromotionHandler) GetPromotion(c *gin.Context) {
+	id, ok := promotionID(c)
+	if !ok {
+		return
+	}
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	promotion, err := h.promotionService.Get(user, id)
+	if err != nil {
+		h.promotionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"promotion": promotion})
+}
+
+// RequestPromotion requests promoting the digest a channel tag of a
+// repository points at into the next channel.
+func (h *PromotionHandler) RequestPromotion(c *gin.Context) {
+	var req struct {
+		Repository string `json:"repository" binding:"required"`
+		Channel    string `json:"channel" binding:"required"` // 当前所在的通道
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	promotion, err := h.promotionService.Request(user, req.Repository, req.Channel)
+	if err != nil {
+		if errors.Is(err, service.ErrGateFailed) {
+			h.logPromotion(c, user, &service.Promotion{Repository: req.Repository, FromChannel: req.Channel}, "request", err)
+		}
+		h.promotionError(c, err)
+		return
+	}
+
+	h.logPromotion(c, user, promotion, "request", nil)
+	c.JSON(http.StatusAccepted, gin.H{
+		"promotion": promotion,
+		"message":   "晋升请求已创建，等待审批",
+	})
+}
+
+// ApprovePromotion signs off a promotion. The approval that reaches the
+// number the channel requires checks the gate and retags the image.
+func (h *PromotionHandler) ApprovePromotion(c *gin.Context) {
+	id, ok := promotionID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "请求参数无效")
+			return
+		}
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	promotion, err := h.promotionService.Approve(user, id, req.Comment)
+	if promotion == nil {
+		h.promotionError(c, err)
+		return
+	}
+
+	h.logPromotion(c, user, promotion, "approve", nil)
+	switch promotion.Status {
+	case service.PromotionPromoted:
+		h.logPromotion(c, user, promotion, "promote", nil)
+		c.JSON(http.StatusOK, gin.H{
+			"promotion": promotion,
+			"message":   "镜像已晋升到 " + promotion.ToChannel,
+		})
+	case service.PromotionFailed:
+		h.logPromotion(c, user, promotion, "promote", err)
+		common.ErrorWithCode(c, http.StatusUnprocessableEntity, common.ErrUnprocessable, "晋升失败: "+promotion.Reason, gin.H{
+			"promotion": promotion,
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"promotion": promotion,
+			"message":   "已批准，等待其他审批人",
+		})
+	}
+}
+
+// RejectPromotion rejects a pending promotion as an approver, or cancels it
+// as the requester.
+func (h *PromotionHandler) RejectPromotion(c *gin.Context) {
+	id, ok := promotionID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "请求参数无效")
+			return
+		}
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	promotion, err := h.promotionService.Reject(user, id, req.Reason)
+	if err != nil {
+		h.promotionError(c, err)
+		return
+	}
+
+	action, message := "reject", "已拒绝晋升请求"
+	if promotion.Status == service.PromotionCancelled {
+		action, message = "cancel", "已取消晋升请求"
+	}
+	h.logPromotion(c, user, promotion, action, nil)
+	c.JSON(http.StatusOK, gin.H{
+		"promotion": promotion,
+		"message":   message,
+	})
+}
+
+// promotionID parses the :id parameter, writing an error response when it
+// is invalid.
+func promotionID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的晋升请求ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// promotionError maps promotion errors to responses.
+func (h *PromotionHandler) promotionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrPromotionForbidden):
+		common.Error(c, http.StatusForbidden, "无权晋升该镜像")
+	case errors.Is(err, service.ErrSelfApproval):
+		common.Error(c, http.StatusForbidden, "该通道不允许发起人批准自己的请求")
+	case errors.Is(err, service.ErrPromotionNotFound):
+		common.Error(c, http.StatusNotFound, "晋升请求不存在或已处理")
+	case errors.Is(err, service.ErrPromotionPending):
+		common.Error(c, http.StatusConflict, "该仓库已有进入该通道的待审批请求")
+	case errors.Is(err, service.ErrAlreadyApproved):
+		common.Error(c, http.StatusConflict, "已批准过该请求")
+	case errors.Is(err, service.ErrChannelNotFound):
+		common.Error(c, http.StatusNotFound, "通道不存在或没有下一通道")
+	case errors.Is(err, service.ErrGateFailed):
+		common.ErrorWithCode(c, http.StatusUnprocessableEntity, common.ErrUnprocessable, "未通过晋升门禁: "+strings.TrimPrefix(err.Error(), service.ErrGateFailed.Error()+": "), nil)
+	case strings.Contains(err.Error(), "not found"):
+		common.Error(c, http.StatusNotFound, "镜像不存在")
+	default:
+		common.Error(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// logPromotion records a promotion step in the audit log. err marks a
+// failed gate or retag.
+func (h *PromotionHandler) logPromotion(c *gin.Context, user *service.User, promotion *service.Promotion, action string, err error) {
+	if h.auditService == nil {
+		return
+	}
+	level, status := "info", "success"
+	details := map[string]interface{}{
+		"promotion_id": promotion.ID,
+		"digest":       promotion.Digest,
+		"from_channel": promotion.FromChannel,
+		"to_channel":   promotion.ToChannel,
+		"requested_by": promotion.RequestedBy,
+		"status":       promotion.Status,
+		"approvals":    len(promotion.Approvals),
+	}
+	if promotion.Reason != "" {
+		details["reason"] = promotion.Reason
+	}
+	if err != nil {
+		level, status = "warn", "failed"
+		details["error"] = err.Error()
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     level,
+		Event:     "image_promotion",
+		UserID:    user.ID,
+		Username:  user.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  promotion.Repository,
+		Action:    action,
+		Status:    status,
+		Details:   details,
+	})
+}
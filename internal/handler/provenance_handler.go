@@ -0,0 +1,150 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ProvenanceHandler handles provenance attestation requests.
+type ProvenanceHandler struct {
+	provenanceService *service.ProvenanceService
+	auditService      *service.AuditService
+}
+
+// NewProvenanceHandler creates a new ProvenanceHandler instance.
+func NewProvenanceHandler(provenanceSvc *service.ProvenanceService, auditSvc *service.AuditService) *ProvenanceHandler {
+	return &ProvenanceHandler{
+		provenanceService: provenanceSvc,
+		auditService:      auditSvc,
+	}
+}
+
+// RegisterRoutes registers provenance routes. Repository names may contain
+// slashes, so the path is parsed by the handler.
+func (h *ProvenanceHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/*path", h.GetBadge)
+	r.POST("/*path", h.Reverify)
+}
+
+// GetBadge handles GET /provenance/<name>/<tag>, the provenance badge of
+// the digest the tag points at. ?format=svg returns an image for READMEs.
+func (h *ProvenanceHandler) GetBadge(c *gin.Context) {
+	name, tag, ok := provenancePath(c, "")
+	if !ok {
+		return
+	}
+
+	badge, err := h.provenanceService.Badge(getCurrentUser(c), name, tag)
+	if err != nil {
+		h.provenanceError(c, err)
+		return
+	}
+
+	if c.Query("format") == "svg" {
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "image/svg+xml", provenanceSVG(badge.Status))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"provenance": badge})
+}
+
+// Reverify handles POST /provenance/<name>/<tag>/verify, verifying the
+// attestations of the tag again against the current trusted builders.
+func (h *ProvenanceHandler) Reverify(c *gin.Context) {
+	name, tag, ok := provenancePath(c, "verify")
+	if !ok {
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	badge, err := h.provenanceService.Reverify(user, name, tag)
+	if err != nil {
+		h.provenanceError(c, err)
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "provenance_verify",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  name + ":" + tag,
+			Action:    "verify",
+			Status:    "success",
+			Details: map[string]interface{}{
+				"digest":       badge.Digest,
+				"status":       badge.Status,
+				"attestations": len(badge.Attestations),
+			},
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"provenance": badge})
+}
+
+// provenancePath splits <name>/<tag>[/<action>], writing a 404 response
+// when the path does not match.
+func provenancePath(c *gin.Context, action string) (string, string, bool) {
+	parts := strings.Split(strings.Trim(c.Param("path"), "/"), "/")
+	if action != "" {
+		if parts[len(parts)-1] != action {
+			parts = nil
+		} else {
+			parts = parts[:len(parts)-1]
+		}
+	}
+	if len(parts) < 2 || parts[len(parts)-1] == "" {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{"path": c.Request.URL.Path})
+		return "", "", false
+	}
+	return strings.Join(parts[:len(parts)-1], "/"), parts[len(parts)-1], true
+}
+
+// provenanceError maps provenance errors to responses.
+func (h *ProvenanceHandler) provenanceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrProvenanceForbidden):
+		common.Error(c, http.StatusForbidden, "无权访问该镜像的来源证明")
+	case strings.Contains(err.Error(), "not found"):
+		common.Error(c, http.StatusNotFound, "镜像不存在")
+	default:
+		common.Error(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// provenanceSVG renders a shields-style badge of the provenance status.
+func provenanceSVG(status string) []byte {
+	color := "#9f9f9f"
+	switch status {
+	case service.ProvenanceVerified:
+		color = "#4c1"
+	case service.ProvenanceFailed:
+		color = "#e05d44"
+	}
+	label, value := "provenance", html.EscapeString(status)
+	labelWidth, valueWidth := 7*len(label)+10, 7*len(value)+10
+	width := labelWidth + valueWidth
+	return []byte(fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,sans-serif" font-size="11">`+
+		`<text x="%d" y="14">%s</text><text x="%d" y="14">%s</text></g></svg>`,
+		width, label, value,
+		labelWidth, labelWidth, valueWidth, color,
+		labelWidth/2, label, labelWidth+valueWidth/2, value))
+}
@@ -0,0 +1,516 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RepositoryHandler handles repository settings requests.
+// 仓库名可能包含斜杠，所以路由使用通配符，由 splitRepositoryPath 解析
+type RepositoryHandler struct {
+	repoService  *service.RepositoryService
+	auditService *service.AuditService
+}
+
+// NewRepositoryHandler creates a new RepositoryHandler instance.
+func NewRepositoryHandler(repoSvc *service.RepositoryService, auditSvc *service.AuditService) *RepositoryHandler {
+	return &RepositoryHandler{
+		repoService:  repoSvc,
+		auditService: auditSvc,
+	}
+}
+
+// RegisterRoutes registers repository routes.
+func (h *RepositoryHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/*path", h.getRepository)
+	r.PUT("/*path", h.updateRepository)
+	r.DELETE("/*path", h.deleteRepositoryAction)
+	r.POST("/*path", h.postRepositoryAction)
+}
+
+// splitRepositoryPath splits "/<name>/<action>" into the repository name and
+// the action.
+func splitRepositoryPath(path string) (name, action string) {
+	path = strings.Trim(path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+// getRepository handles GET /api/v1/repositories/,
+// GET /api/v1/repositories/starred,
+// GET /api/v1/repositories/transfers,
+// GET /api/v1/repositories/:name/visibility,
+// GET /api/v1/repositories/:name/metadata and
+// GET /api/v1/repositories/:name/star
+func (h *RepositoryHandler) getRepository(c *gin.Context) {
+	if strings.Trim(c.Param("path"), "/") == "" {
+		h.ListVisibility(c)
+		return
+	}
+
+	name, action := splitRepositoryPath(c.Param("path"))
+	switch {
+	case name == "" && action == "starred":
+		h.ListStarred(c)
+	case name == "" && action == "transfers":
+		h.ListTransfers(c)
+	case name == "":
+		common.Error(c, http.StatusNotFound, "接口不存在")
+	case action == "star":
+		h.GetStars(c, name)
+	case action == "visibility":
+		h.GetVisibility(c, name)
+	case action == "metadata":
+		h.GetMetadata(c, name)
+	default:
+		common.Error(c, http.StatusNotFound, "接口不存在")
+	}
+}
+
+// updateRepository handles PUT /api/v1/repositories/:name/visibility,
+// PUT /api/v1/repositories/:name/metadata and
+// PUT /api/v1/repositories/:name/star
+func (h *RepositoryHandler) updateRepository(c *gin.Context) {
+	name, action := splitRepositoryPath(c.Param("path"))
+	switch {
+	case name == "":
+		common.Error(c, http.StatusNotFound, "接口不存在")
+	case action == "star":
+		h.Star(c, name)
+	case action == "visibility":
+		h.SetVisibility(c, name)
+	case action == "metadata":
+		h.SetMetadata(c, name)
+	default:
+		common.Error(c, http.StatusNotFound, "接口不存在")
+	}
+}
+
+// deleteRepositoryAction handles DELETE /api/v1/repositories/:name/star
+func (h *RepositoryHandler) deleteRepositoryAction(c *gin.Context) {
+	name, action := splitRepositoryPath(c.Param("path"))
+	if name == "" || action != "star" {
+		common.Error(c, http.StatusNotFound, "接口不存在")
+		return
+	}
+	h.Unstar(c, name)
+}
+
+// postRepositoryAction handles POST /api/v1/repositories/:name/transfer,
+// POST /api/v1/repositories/transfers/:id/accept and
+// POST /api/v1/repositories/transfers/:id/reject
+func (h *RepositoryHandler) postRepositoryAction(c *gin.Context) {
+	name, action := splitRepositoryPath(c.Param("path"))
+	if id, ok := strings.CutPrefix(name, "transfers/"); ok && (action == "accept" || action == "reject") {
+		h.ResolveTransfer(c, id, action)
+		return
+	}
+	if name == "" || action != "transfer" {
+		common.Error(c, http.StatusNotFound, "接口不存在")
+		return
+	}
+	h.RequestTransfer(c, name)
+}
+
+// ListVisibility lists the repositories with an explicit visibility that
+// the current user may pull.
+func (h *RepositoryHandler) ListVisibility(c *gin.Context) {
+	user := getCurrentUser(c)
+	settings := make([]*service.RepositorySettings, 0)
+	for _, s := range h.repoService.ListSettings() {
+		if h.repoService.CanPull(user, s.Name) {
+			settings = append(settings, s)
+		}
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].Name < settings[j].Name
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"repositories":       settings,
+		"default_visibility": h.repoService.DefaultVisibility(),
+	})
+}
+
+// GetVisibility returns the visibility of a repository.
+func (h *RepositoryHandler) GetVisibility(c *gin.Context, name string) {
+	user := getCurrentUser(c)
+	if !h.repoService.CanPull(user, name) {
+		common.Error(c, http.StatusForbidden, "无权访问该仓库")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":       name,
+		"visibility": h.repoService.GetVisibility(name),
+		"can_manage": h.repoService.CanManage(user, name),
+	})
+}
+
+// SetVisibility changes the visibility of a repository.
+func (h *RepositoryHandler) SetVisibility(c *gin.Context, name string) {
+	var req struct {
+		Visibility string `json:"visibility" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+	if !h.repoService.CanManage(user, name) {
+		common.Error(c, http.StatusForbidden, "无权修改该仓库")
+		return
+	}
+
+	previous := h.repoService.GetVisibility(name)
+	settings, err := h.repoService.SetVisibility(name, req.Visibility, user.Username)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidVisibility) {
+			common.Error(c, http.StatusBadRequest, "可见性必须是 private、internal 或 public")
+			return
+		}
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "repository_visibility",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  name,
+			Action:    "update",
+			Status:    "success",
+			Details: map[string]interface{}{
+				"from": previous,
+				"to":   settings.Visibility,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"repository": settings,
+		"message":    "仓库可见性已更新",
+	})
+}
+
+// GetMetadata returns the description, icon and links of a repository.
+func (h *RepositoryHandler) GetMetadata(c *gin.Context, name string) {
+	user := getCurrentUser(c)
+	if !h.repoService.CanPull(user, name) {
+		common.Error(c, http.StatusForbidden, "无权访问该仓库")
+		return
+	}
+
+	meta, err := h.repoService.GetMetadata(name)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metadata":   meta,
+		"can_manage": h.repoService.CanManage(user, name),
+	})
+}
+
+// SetMetadata replaces the description, icon and links of a repository.
+func (h *RepositoryHandler) SetMetadata(c *gin.Context, name string) {
+	var req struct {
+		Description string                   `json:"description"`
+		Icon        string                   `json:"icon"`
+		Links       []service.RepositoryLink `json:"links"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+	if !h.repoService.CanManage(user, name) {
+		common.Error(c, http.StatusForbidden, "无权修改该仓库")
+		return
+	}
+
+	meta, err := h.repoService.SetMetadata(name, &service.RepositoryMetadata{
+		Description: req.Description,
+		Icon:        req.Icon,
+		Links:       req.Links,
+	}, user.Username)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidMetadata) {
+			common.Error(c, http.StatusBadRequest, "仓库元数据无效：说明最长 64KB，图标和链接必须是 http(s) 地址，最多 20 个链接")
+			return
+		}
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "repository_metadata",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  name,
+			Action:    "update",
+			Status:    "success",
+			Details: map[string]interface{}{
+				"links": len(meta.Links),
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metadata": meta,
+		"message":  "仓库信息已更新",
+	})
+}
+
+// ListStarred lists the repositories starred by the current user.
+func (h *RepositoryHandler) ListStarred(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	starred, err := h.repoService.Starred(user)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"repositories": starred,
+		"total":        len(starred),
+	})
+}
+
+// GetStars returns the star count of a repository and whether the current
+// user starred it.
+func (h *RepositoryHandler) GetStars(c *gin.Context, name string) {
+	user := getCurrentUser(c)
+	if !h.repoService.CanPull(user, name) {
+		common.Error(c, http.StatusForbidden, "无权访问该仓库")
+		return
+	}
+
+	stars, err := h.repoService.Stars(user, name)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, stars)
+}
+
+// Star stars a repository for the current user.
+func (h *RepositoryHandler) Star(c *gin.Context, name string) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+	if !h.repoService.CanPull(user, name) {
+		common.Error(c, http.StatusForbidden, "无权访问该仓库")
+		return
+	}
+
+	stars, err := h.repoService.Star(user, name)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, stars)
+}
+
+// Unstar removes the current user's star from a repository. Repositories the
+// user can no longer access can still be unstarred.
+func (h *RepositoryHandler) Unstar(c *gin.Context, name string) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	stars, err := h.repoService.Unstar(user, name)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, stars)
+}
+
+// RequestTransfer transfers a repository to another user or organization.
+// Transfers to an organization complete at once, transfers to another user
+// wait for that user to accept.
+func (h *RepositoryHandler) RequestTransfer(c *gin.Context, name string) {
+	var req struct {
+		Namespace string `json:"namespace" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	transfer, err := h.repoService.RequestTransfer(user, name, req.Namespace)
+	if err != nil {
+		h.transferError(c, err)
+		return
+	}
+
+	h.logTransfer(c, user, transfer, "request")
+	if transfer.Status == service.TransferCompleted {
+		h.logTransfer(c, user, transfer, "complete")
+		c.JSON(http.StatusOK, gin.H{
+			"transfer": transfer,
+			"message":  "仓库已转移到 " + transfer.NewName,
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"transfer": transfer,
+		"message":  "转移请求已发送，等待 " + transfer.TargetNamespace + " 确认",
+	})
+}
+
+// ListTransfers lists the transfers requested by or sent to the current user.
+// Only pending transfers are listed unless all=true.
+func (h *RepositoryHandler) ListTransfers(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	transfers, err := h.repoService.ListTransfers(user, c.Query("all") == "true")
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transfers": transfers,
+		"total":     len(transfers),
+	})
+}
+
+// ResolveTransfer accepts or rejects a pending transfer. The target user
+// accepts or rejects, the requester may cancel by rejecting.
+func (h *RepositoryHandler) ResolveTransfer(c *gin.Context, rawID, action string) {
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的转移请求ID")
+		return
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	var transfer *service.RepositoryTransfer
+	if action == "accept" {
+		transfer, err = h.repoService.AcceptTransfer(user, id)
+	} else {
+		transfer, err = h.repoService.RejectTransfer(user, id)
+	}
+	if err != nil {
+		h.transferError(c, err)
+		return
+	}
+
+	h.logTransfer(c, user, transfer, action)
+	message := "已拒绝转移请求"
+	switch transfer.Status {
+	case service.TransferCompleted:
+		h.logTransfer(c, user, transfer, "complete")
+		message = "仓库已转移到 " + transfer.NewName
+	case service.TransferCancelled:
+		message = "已取消转移请求"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"transfer": transfer,
+		"message":  message,
+	})
+}
+
+// transferError maps repository transfer errors to responses.
+func (h *RepositoryHandler) transferError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrTransferForbidden):
+		common.Error(c, http.StatusForbidden, "无权转移该仓库")
+	case errors.Is(err, service.ErrTransferNotFound):
+		common.Error(c, http.StatusNotFound, "转移请求不存在或已处理")
+	case errors.Is(err, service.ErrTransferPending):
+		common.Error(c, http.StatusConflict, "该仓库已有待确认的转移请求")
+	case errors.Is(err, service.ErrNamespaceNotFound):
+		common.Error(c, http.StatusNotFound, "目标用户或组织不存在")
+	case strings.Contains(err.Error(), "already exists"):
+		common.Error(c, http.StatusConflict, "目标仓库已存在")
+	case strings.Contains(err.Error(), "not found"):
+		common.Error(c, http.StatusNotFound, "仓库不存在")
+	case strings.Contains(err.Error(), "invalid"), strings.Contains(err.Error(), "already in namespace"):
+		common.Error(c, http.StatusBadRequest, err.Error())
+	default:
+		common.Error(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// logTransfer records a transfer step in the audit log.
+func (h *RepositoryHandler) logTransfer(c *gin.Context, user *service.User, transfer *service.RepositoryTransfer, action string) {
+	if h.auditService == nil {
+		return
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "info",
+		Event:     "repository_transfer",
+		UserID:    user.ID,
+		Username:  user.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  transfer.Repository,
+		Action:    action,
+		Status:    "success",
+		Details: map[string]interface{}{
+			"transfer_id":  transfer.ID,
+			"new_name":     transfer.NewName,
+			"namespace":    transfer.TargetNamespace,
+			"requested_by": transfer.RequestedBy,
+			"status":       transfer.Status,
+		},
+	})
+}
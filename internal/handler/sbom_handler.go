@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +15,7 @@ import (
 type SBOMHandler struct {
 	sbomService  *service.SBOMService
 	auditService *service.AuditService
+	canPull      func(c *gin.Context, repository string) bool
 }
 
 // NewSBOMHandler creates a new SBOMHandler instance.
@@ -24,9 +26,22 @@ func NewSBOMHandler(sbomSvc *service.SBOMService, auditSvc *service.AuditService
 	}
 }
 
+// SetPullAuthorizer sets the check that the client may pull from a
+// repository, used to limit cross-image queries to readable images.
+func (h *SBOMHandler) SetPullAuthorizer(fn func(c *gin.Context, repository string) bool) {
+	h.canPull = fn
+}
+
+// pullable reports whether the client may read the SBOM of imageRef.
+func (h *SBOMHandler) pullable(c *gin.Context, imageRef string) bool {
+	return h.canPull == nil || h.canPull(c, service.SBOMRepository(imageRef))
+}
+
 // RegisterRoutes registers SBOM routes.
 func (h *SBOMHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("", h.ListSBOMs)
+	r.GET("/diff", h.DiffSBOMs)
+	r.GET("/search", h.SearchPackages)
 	r.POST("/generate", h.GenerateSBOM)
 	r.GET("/:imageRef", h.GetSBOM)
 	r.GET("/:imageRef/export", h.ExportSBOM)
@@ -41,7 +56,7 @@ func (h *SBOMHandler) ListSBOMs(c *gin.Context) {
 
 	sboms, total, err := h.sbomService.ListSBOMs(page, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -57,13 +72,13 @@ func (h *SBOMHandler) ListSBOMs(c *gin.Context) {
 func (h *SBOMHandler) GenerateSBOM(c *gin.Context) {
 	var req service.GenerateSBOMRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	sbom, err := h.sbomService.GenerateSBOM(&req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -83,6 +98,7 @@ func (h *SBOMHandler) GenerateSBOM(c *gin.Context) {
 			UserID:    userID,
 			Username:  username,
 			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
 			Action:    "generate",
 			Status:    "success",
 			Details: map[string]interface{}{
@@ -104,13 +120,61 @@ func (h *SBOMHandler) GetSBOM(c *gin.Context) {
 
 	sbom, err := h.sbomService.GetSBOM(imageRef)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusNotFound, err.Error())
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"sbom": sbom})
 }
 
+// DiffSBOMs compares the SBOMs of two images, e.g. ?from=app:1.0&to=app:1.1.
+func (h *SBOMHandler) DiffSBOMs(c *gin.Context) {
+	from := c.Query("from")
+	to := c.Query("to")
+	if from == "" || to == "" {
+		common.Error(c, http.StatusBadRequest, "from 和 to 参数不能为空")
+		return
+	}
+	if !h.pullable(c, from) || !h.pullable(c, to) {
+		common.Error(c, http.StatusForbidden, "无权访问该仓库")
+		return
+	}
+
+	diff, err := h.sbomService.DiffSBOMs(from, to)
+	if err != nil {
+		common.Error(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// SearchPackages finds the images containing a package, e.g.
+// ?package=log4j-core&version=<2.17.
+func (h *SBOMHandler) SearchPackages(c *gin.Context) {
+	query := &service.PackageSearchQuery{
+		Package: c.Query("package"),
+		Version: c.Query("version"),
+		Type:    c.Query("type"),
+		Exact:   c.Query("exact") == "true",
+	}
+	if query.Package == "" {
+		common.Error(c, http.StatusBadRequest, "package 参数不能为空")
+		return
+	}
+	query.Limit, _ = strconv.Atoi(c.Query("limit"))
+
+	result, err := h.sbomService.SearchPackages(query, func(repository string) bool {
+		return h.canPull == nil || h.canPull(c, repository)
+	})
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
 // ExportSBOM exports a SBOM.
 func (h *SBOMHandler) ExportSBOM(c *gin.Context) {
 	imageRef := c.Param("imageRef")
@@ -118,7 +182,7 @@ func (h *SBOMHandler) ExportSBOM(c *gin.Context) {
 
 	data, err := h.sbomService.ExportSBOM(imageRef, format)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusNotFound, err.Error())
 		return
 	}
 
@@ -132,13 +196,13 @@ func (h *SBOMHandler) ExportSBOM(c *gin.Context) {
 func (h *SBOMHandler) ScanVulnerabilities(c *gin.Context) {
 	var req service.ScanVulnRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	result, err := h.sbomService.ScanVulnerabilities(&req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -158,6 +222,7 @@ func (h *SBOMHandler) ScanVulnerabilities(c *gin.Context) {
 			UserID:    userID,
 			Username:  username,
 			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
 			Action:    "scan",
 			Status:    "success",
 			Details: map[string]interface{}{
@@ -176,7 +241,7 @@ func (h *SBOMHandler) DeleteSBOM(c *gin.Context) {
 	imageRef := c.Param("imageRef")
 
 	if err := h.sbomService.DeleteSBOM(imageRef); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
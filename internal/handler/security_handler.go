@@ -0,0 +1,43 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SecurityHandler handles the security dashboard requests.
+type SecurityHandler struct {
+	overviewService *service.SecurityOverviewService
+}
+
+// NewSecurityHandler creates a new SecurityHandler instance.
+func NewSecurityHandler(overviewSvc *service.SecurityOverviewService) *SecurityHandler {
+	return &SecurityHandler{overviewService: overviewSvc}
+}
+
+// RegisterRoutes registers security dashboard routes.
+func (h *SecurityHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/overview", h.GetOverview)
+}
+
+// GetOverview returns the security state for the dashboard, with the
+// intrusion events of the last window, e.g. ?window=72h.
+func (h *SecurityHandler) GetOverview(c *gin.Context) {
+	window := service.DefaultSecurityWindow
+	if w := c.Query("window"); w != "" {
+		d, err := time.ParseDuration(w)
+		if err != nil || d <= 0 {
+			common.Error(c, http.StatusBadRequest, "无效的时间窗口")
+			return
+		}
+		window = d
+	}
+
+	c.JSON(http.StatusOK, h.overviewService.Overview(window))
+}
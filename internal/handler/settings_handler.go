@@ -0,0 +1,136 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SettingsHandler handles runtime settings requests.
+type SettingsHandler struct {
+	settingsService *service.SettingsService
+	auditService    *service.AuditService
+}
+
+// NewSettingsHandler creates a new SettingsHandler instance.
+func NewSettingsHandler(settingsSvc *service.SettingsService, auditSvc *service.AuditService) *SettingsHandler {
+	return &SettingsHandler{
+		settingsService: settingsSvc,
+		auditService:    auditSvc,
+	}
+}
+
+// RegisterRoutes registers settings routes.
+func (h *SettingsHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListSettings)
+	r.GET("/:key", h.GetSetting)
+	r.PUT("/:key", h.UpdateSetting)
+	r.DELETE("/:key", h.ResetSetting)
+}
+
+// UpdateSettingRequest represents a request to change a setting.
+type UpdateSettingRequest struct {
+	Value interface{} `json:"value"`
+}
+
+// settingErrorStatus maps settings errors to HTTP status codes.
+func settingErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrSettingNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrInvalidSetting):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// ListSettings lists all settings with their effective value and source.
+func (h *SettingsHandler) ListSettings(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"settings": h.settingsService.List()})
+}
+
+// GetSetting returns a single setting.
+func (h *SettingsHandler) GetSetting(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+	setting, err := h.settingsService.Get(c.Param("key"))
+	if err != nil {
+		common.Error(c, settingErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"setting": setting})
+}
+
+// UpdateSetting overrides the configuration file value of a setting. The
+// new value takes effect immediately.
+func (h *SettingsHandler) UpdateSetting(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+
+	var req UpdateSettingRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Value == nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	setting, previous, err := h.settingsService.Set(c.Param("key"), req.Value, admin.Username)
+	if err != nil {
+		common.Error(c, settingErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "update", setting, previous)
+	c.JSON(http.StatusOK, gin.H{"setting": setting, "message": "设置已更新"})
+}
+
+// ResetSetting removes the override of a setting, restoring the value of
+// the configuration file.
+func (h *SettingsHandler) ResetSetting(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+
+	setting, previous, err := h.settingsService.Reset(c.Param("key"), admin.Username)
+	if err != nil {
+		common.Error(c, settingErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "reset", setting, previous)
+	c.JSON(http.StatusOK, gin.H{"setting": setting, "message": "设置已恢复为配置文件的值"})
+}
+
+// audit records a settings change.
+func (h *SettingsHandler) audit(c *gin.Context, admin *service.User, action string, setting *service.Setting, previous interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "warn",
+		Event:     "setting_change",
+		UserID:    admin.ID,
+		Username:  admin.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  setting.Key,
+		Action:    action,
+		Status:    "success",
+		Details: map[string]interface{}{
+			"old_value": previous,
+			"new_value": setting.Value,
+			"source":    setting.Source,
+		},
+	})
+}
@@ -2,9 +2,12 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -24,13 +27,35 @@ func NewShareHandler(shareSvc *service.ShareService, auditSvc *service.AuditServ
 	}
 }
 
-// RegisterRoutes registers share routes.
+// RegisterRoutes registers share management routes.
 func (h *ShareHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("", h.ListShareLinks)
 	r.POST("", h.CreateShareLink)
+	r.DELETE("/:code", h.RevokeShareLink)
+	r.GET("/:code/usage", h.GetShareUsage)
+}
+
+// RegisterPublicRoutes registers the routes used by share link recipients,
+// who are not logged in.
+func (h *ShareHandler) RegisterPublicRoutes(r *gin.RouterGroup) {
 	r.GET("/:code", h.GetShareLink)
 	r.POST("/:code/verify", h.VerifyPassword)
-	r.DELETE("/:code", h.RevokeShareLink)
+	r.POST("/:code/token", h.ExchangePullToken)
+}
+
+// shareErrorStatus maps share link errors to HTTP status codes.
+func shareErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrShareExpired), errors.Is(err, service.ErrShareExhausted):
+		return http.StatusGone
+	case errors.Is(err, service.ErrShareInvalidSecret):
+		return http.StatusUnauthorized
+	case errors.Is(err, service.ErrShareNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrSharePermissionDenied):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
 }
 
 // ListShareLinks lists share links for the current user.
@@ -40,13 +65,13 @@ func (h *ShareHandler) ListShareLinks(c *gin.Context) {
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	links, total, err := h.shareService.ListShareLinks(user.ID, page, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -62,19 +87,23 @@ func (h *ShareHandler) ListShareLinks(c *gin.Context) {
 func (h *ShareHandler) CreateShareLink(c *gin.Context) {
 	var req service.CreateShareRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
-	link, code, err := h.shareService.CreateShareLink(&req, user.ID)
+	link, code, err := h.shareService.CreateShareLink(&req, user, getCurrentToken(c))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		status := http.StatusBadRequest
+		if errors.Is(err, service.ErrSharePermissionDenied) {
+			status = http.StatusForbidden
+		}
+		common.Error(c, status, err.Error())
 		return
 	}
 
@@ -98,13 +127,7 @@ func (h *ShareHandler) GetShareLink(c *gin.Context) {
 
 	link, err := h.shareService.GetShareLink(code)
 	if err != nil {
-		status := http.StatusNotFound
-		if err.Error() == "share link expired" {
-			status = http.StatusGone
-		} else if err.Error() == "share link usage limit exceeded" {
-			status = http.StatusGone
-		}
-		c.JSON(status, gin.H{"error": err.Error()})
+		common.Error(c, shareErrorStatus(err), err.Error())
 		return
 	}
 
@@ -119,33 +142,122 @@ func (h *ShareHandler) VerifyPassword(c *gin.Context) {
 		Password string `json:"password" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
 	if err := h.shareService.VerifySharePassword(code, req.Password); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusUnauthorized, err.Error())
 		return
 	}
 
-	// Increment usage count
-	h.shareService.IncrementUsage(code)
-
+	// 使用次数在换取拉取凭证时计入，见 ExchangePullToken
 	c.JSON(http.StatusOK, gin.H{"message": "密码验证成功"})
 }
 
+// ExchangePullToken exchanges a share code for docker login credentials
+// that can pull the shared image.
+func (h *ShareHandler) ExchangePullToken(c *gin.Context) {
+	code := c.Param("code")
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	// 无密码的分享链接可以不带请求体
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "无效的请求参数")
+			return
+		}
+	}
+
+	token, err := h.shareService.ExchangePullToken(code, req.Password, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if h.auditService != nil && errors.Is(err, service.ErrShareInvalidSecret) {
+			h.auditService.LogAuthFailure(c.ClientIP(), "share:"+code, err.Error(), common.RequestID(c))
+		}
+		common.Error(c, shareErrorStatus(err), err.Error())
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "share_pull_token",
+			Username:  "share:" + code,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  token.Repository + ":" + token.Tag,
+			Action:    "exchange",
+			Status:    "success",
+		})
+	}
+
+	host := c.Request.Host
+	c.JSON(http.StatusOK, gin.H{
+		"username":   token.Username,
+		"token":      token.Token,
+		"repository": token.Repository,
+		"tag":        token.Tag,
+		"expires_at": token.ExpiresAt,
+		"login_cmd":  "docker login " + host + " -u " + token.Username + " --password-stdin",
+		"pull_cmd":   "docker pull " + host + "/" + token.Repository + pullRefSeparator(token.Tag) + token.Tag,
+	})
+}
+
+// pullRefSeparator returns "@" for digests and ":" for tags.
+func pullRefSeparator(ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return "@"
+	}
+	return ":"
+}
+
+// GetShareUsage returns the redemption history of a share link.
+func (h *ShareHandler) GetShareUsage(c *gin.Context) {
+	code := c.Param("code")
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
+		return
+	}
+
+	link, usages, total, err := h.shareService.GetShareUsage(code, user.ID, user.Role == "admin", page, pageSize)
+	if err != nil {
+		common.Error(c, shareErrorStatus(err), err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"link":      link,
+		"usages":    usages,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
 // RevokeShareLink revokes a share link.
 func (h *ShareHandler) RevokeShareLink(c *gin.Context) {
 	code := c.Param("code")
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权访问"})
+		common.Error(c, http.StatusUnauthorized, "未授权访问")
 		return
 	}
 
 	if err := h.shareService.RevokeShareLink(code, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		common.Error(c, http.StatusBadRequest, err.Error())
 		return
 	}
 
@@ -2,9 +2,12 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -31,6 +34,11 @@ func (h *SignatureHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("/:imageRef", h.GetSignature)
 	r.POST("/verify", h.VerifyImage)
 	r.DELETE("/:imageRef", h.DeleteSignature)
+
+	// 签名密钥，KMS 的密钥 ID 可能包含斜杠
+	r.GET("/keys", h.ListKeys)
+	r.POST("/keys", h.GenerateKey)
+	r.GET("/keys/*id", h.GetKey)
 }
 
 // ListSignatures lists all signatures.
@@ -40,7 +48,7 @@ func (h *SignatureHandler) ListSignatures(c *gin.Context) {
 
 	signatures, total, err := h.signatureService.ListSignatures(page, pageSize)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取签名列表失败"})
+		common.Error(c, http.StatusInternalServerError, "获取签名列表失败")
 		return
 	}
 
@@ -56,19 +64,28 @@ func (h *SignatureHandler) ListSignatures(c *gin.Context) {
 func (h *SignatureHandler) SignImage(c *gin.Context) {
 	var req service.SignRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数无效"})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		common.Error(c, http.StatusUnauthorized, "未登录")
 		return
 	}
 
 	signature, err := h.signatureService.SignImage(&req, user.ID, user.Username)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "签名失败"})
+		switch {
+		case errors.Is(err, service.ErrNotationSigning):
+			common.Error(c, http.StatusConflict, "当前使用 notation 验证后端，请使用 notation CLI 签名并推送到仓库")
+		case errors.Is(err, service.ErrSigningKeyNotFound), errors.Is(err, service.ErrInvalidSigningKeyID):
+			common.Error(c, http.StatusBadRequest, "签名密钥不存在")
+		case errors.Is(err, service.ErrNoKeyProvider):
+			common.Error(c, http.StatusServiceUnavailable, "签名密钥提供者不可用")
+		default:
+			common.Error(c, http.StatusBadRequest, "签名失败")
+		}
 		return
 	}
 
@@ -80,10 +97,12 @@ func (h *SignatureHandler) SignImage(c *gin.Context) {
 			UserID:    user.ID,
 			Username:  user.Username,
 			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
 			Action:    "sign",
 			Status:    "success",
 			Details: map[string]interface{}{
 				"image_ref": req.ImageRef,
+				"key_id":    signature.KeyID,
 			},
 		})
 	}
@@ -100,7 +119,7 @@ func (h *SignatureHandler) GetSignature(c *gin.Context) {
 
 	signature, err := h.signatureService.GetSignature(imageRef)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "签名不存在"})
+		common.Error(c, http.StatusNotFound, "签名不存在")
 		return
 	}
 
@@ -111,13 +130,13 @@ func (h *SignatureHandler) GetSignature(c *gin.Context) {
 func (h *SignatureHandler) VerifyImage(c *gin.Context) {
 	var req service.VerifyRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数无效"})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
 	result, err := h.signatureService.VerifyImage(&req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "验证失败"})
+		common.Error(c, http.StatusInternalServerError, "验证失败")
 		return
 	}
 
@@ -130,14 +149,109 @@ func (h *SignatureHandler) DeleteSignature(c *gin.Context) {
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		common.Error(c, http.StatusUnauthorized, "未登录")
 		return
 	}
 
 	if err := h.signatureService.DeleteSignature(imageRef); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "删除签名失败"})
+		common.Error(c, http.StatusBadRequest, "删除签名失败")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "签名已删除"})
 }
+
+// ListKeys lists the signing keys of the key provider.
+func (h *SignatureHandler) ListKeys(c *gin.Context) {
+	keys, err := h.signatureService.ListKeys()
+	if err != nil {
+		h.keyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"keys":     keys,
+		"provider": h.signatureService.KeyProvider().Name(),
+	})
+}
+
+// GetKey returns the public key of a signing key.
+func (h *SignatureHandler) GetKey(c *gin.Context) {
+	id := strings.TrimPrefix(c.Param("id"), "/")
+	if id == "" {
+		common.Error(c, http.StatusBadRequest, "密钥 ID 不能为空")
+		return
+	}
+
+	key, err := h.signatureService.GetKey(id)
+	if err != nil {
+		h.keyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"key": key})
+}
+
+// GenerateKeyRequest is the body of POST /signatures/keys.
+type GenerateKeyRequest struct {
+	ID string `json:"id" binding:"required"`
+}
+
+// GenerateKey creates a signing key. Only providers holding exportable
+// keys generate them, keys of HSMs and KMS are created with their tools.
+func (h *SignatureHandler) GenerateKey(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	var req GenerateKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	key, err := h.signatureService.GenerateKey(req.ID)
+	if err != nil {
+		h.keyError(c, err)
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "signing_key_generated",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  key.ID,
+			Action:    "create",
+			Status:    "success",
+			Details: map[string]interface{}{
+				"provider":  key.Provider,
+				"algorithm": key.Algorithm,
+			},
+		})
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"key":     key,
+		"message": "签名密钥已生成",
+	})
+}
+
+// keyError maps key management errors to responses.
+func (h *SignatureHandler) keyError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSigningKeyNotFound):
+		common.Error(c, http.StatusNotFound, "签名密钥不存在")
+	case errors.Is(err, service.ErrInvalidSigningKeyID):
+		common.Error(c, http.StatusBadRequest, "无效的密钥 ID")
+	case errors.Is(err, service.ErrSigningKeyExists):
+		common.Error(c, http.StatusConflict, "签名密钥已存在")
+	case errors.Is(err, service.ErrKeyGenerationUnsupported):
+		common.Error(c, http.StatusConflict, "当前密钥提供者不支持生成密钥，请使用 HSM 或 KMS 的工具创建")
+	case errors.Is(err, service.ErrNoKeyProvider):
+		common.Error(c, http.StatusServiceUnavailable, "签名密钥提供者不可用")
+	default:
+		common.Error(c, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,52 @@
+// Package handler provides HTTP handlers for CYP-Docker-Registry.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatsHandler handles image statistics requests.
+type StatsHandler struct {
+	statsService *service.ImageStatsService
+}
+
+// NewStatsHandler creates a new StatsHandler instance.
+func NewStatsHandler(statsSvc *service.ImageStatsService) *StatsHandler {
+	return &StatsHandler{
+		statsService: statsSvc,
+	}
+}
+
+// RegisterRoutes registers statistics routes.
+func (h *StatsHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/images", h.GetImageStats)
+}
+
+// GetImageStats returns top pulled images, recent pushes and bandwidth served.
+// Query: days (default 30, max 365), limit (default 10, max 100).
+func (h *StatsHandler) GetImageStats(c *gin.Context) {
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 365 {
+		common.Error(c, http.StatusBadRequest, "days 必须在 1-365 之间")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > 100 {
+		common.Error(c, http.StatusBadRequest, "limit 必须在 1-100 之间")
+		return
+	}
+
+	stats, err := h.statsService.GetImageStats(days, limit)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取镜像统计失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
@@ -2,9 +2,11 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -27,6 +29,7 @@ func NewTokenHandler(tokenSvc *service.TokenService, auditSvc *service.AuditServ
 // RegisterRoutes registers token routes.
 func (h *TokenHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("", h.ListTokens)
+	r.GET("/scopes", h.ListScopes)
 	r.POST("", h.CreateToken)
 	r.DELETE("/:id", h.DeleteToken)
 }
@@ -35,36 +38,57 @@ func (h *TokenHandler) RegisterRoutes(r *gin.RouterGroup) {
 func (h *TokenHandler) ListTokens(c *gin.Context) {
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		common.Error(c, http.StatusUnauthorized, "未登录")
 		return
 	}
 
 	tokens, err := h.tokenService.ListTokens(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取令牌列表失败"})
+		common.Error(c, http.StatusInternalServerError, "获取令牌列表失败")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"tokens": tokens})
 }
 
+// ListScopes lists the scopes the current user can grant to a new token.
+func (h *TokenHandler) ListScopes(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	scopes, err := h.tokenService.AvailableScopes(user.ID)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取权限范围失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"scopes": scopes})
+}
+
 // CreateToken creates a new personal access token.
 func (h *TokenHandler) CreateToken(c *gin.Context) {
 	var req service.CreateTokenRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数无效"})
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		common.Error(c, http.StatusUnauthorized, "未登录")
 		return
 	}
 
 	resp, err := h.tokenService.CreateToken(&req, user.ID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "创建令牌失败"})
+		if errors.Is(err, service.ErrInvalidScope) {
+			common.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		common.Error(c, http.StatusBadRequest, "创建令牌失败")
 		return
 	}
 
@@ -76,6 +100,7 @@ func (h *TokenHandler) CreateToken(c *gin.Context) {
 			UserID:    user.ID,
 			Username:  user.Username,
 			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
 			Action:    "create",
 			Status:    "success",
 			Details: map[string]interface{}{
@@ -96,18 +121,18 @@ func (h *TokenHandler) CreateToken(c *gin.Context) {
 func (h *TokenHandler) DeleteToken(c *gin.Context) {
 	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "令牌ID无效"})
+		common.Error(c, http.StatusBadRequest, "令牌ID无效")
 		return
 	}
 
 	user := getCurrentUser(c)
 	if user == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未登录"})
+		common.Error(c, http.StatusUnauthorized, "未登录")
 		return
 	}
 
 	if err := h.tokenService.DeleteToken(id, user.ID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "删除令牌失败"})
+		common.Error(c, http.StatusBadRequest, "删除令牌失败")
 		return
 	}
 
@@ -119,6 +144,7 @@ func (h *TokenHandler) DeleteToken(c *gin.Context) {
 			UserID:    user.ID,
 			Username:  user.Username,
 			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
 			Action:    "delete",
 			Status:    "success",
 			Details: map[string]interface{}{
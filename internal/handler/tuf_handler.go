@@ -5,6 +5,7 @@ import (
 	"io"
 	"net/http"
 
+	"cyp-docker-registry/internal/common"
 	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -80,18 +81,12 @@ func (h *TUFHandler) GetStatus(c *gin.Context) {
 // @Router /api/v1/tuf/initialize [post]
 func (h *TUFHandler) Initialize(c *gin.Context) {
 	if h.tufService.IsInitialized() {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": "TUF仓库已初始化",
-		})
+		common.Error(c, http.StatusBadRequest, "TUF仓库已初始化")
 		return
 	}
 
 	if err := h.tufService.Initialize(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -109,10 +104,7 @@ func (h *TUFHandler) Initialize(c *gin.Context) {
 // @Router /api/v1/tuf/refresh [post]
 func (h *TUFHandler) RefreshTimestamp(c *gin.Context) {
 	if err := h.tufService.RefreshTimestamp(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -148,10 +140,7 @@ func (h *TUFHandler) GetTarget(c *gin.Context) {
 
 	target, err := h.tufService.GetTarget(name)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusNotFound, err.Error())
 		return
 	}
 
@@ -181,30 +170,21 @@ func (h *TUFHandler) AddTarget(c *gin.Context) {
 	// 获取上传的文件
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": "请上传文件",
-		})
+		common.Error(c, http.StatusBadRequest, "请上传文件")
 		return
 	}
 
 	// 读取文件内容
 	f, err := file.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": "打开文件失败",
-		})
+		common.Error(c, http.StatusInternalServerError, "打开文件失败")
 		return
 	}
 	defer f.Close()
 
 	data, err := io.ReadAll(f)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": "读取文件失败",
-		})
+		common.Error(c, http.StatusInternalServerError, "读取文件失败")
 		return
 	}
 
@@ -216,10 +196,7 @@ func (h *TUFHandler) AddTarget(c *gin.Context) {
 	}
 
 	if err := h.tufService.AddTarget(name, data, custom); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -240,10 +217,7 @@ func (h *TUFHandler) RemoveTarget(c *gin.Context) {
 	name := c.Param("name")
 
 	if err := h.tufService.RemoveTarget(name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -267,39 +241,26 @@ func (h *TUFHandler) VerifyTarget(c *gin.Context) {
 
 	file, err := c.FormFile("file")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": "请上传文件",
-		})
+		common.Error(c, http.StatusBadRequest, "请上传文件")
 		return
 	}
 
 	f, err := file.Open()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": "打开文件失败",
-		})
+		common.Error(c, http.StatusInternalServerError, "打开文件失败")
 		return
 	}
 	defer f.Close()
 
 	data, err := io.ReadAll(f)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": "读取文件失败",
-		})
+		common.Error(c, http.StatusInternalServerError, "读取文件失败")
 		return
 	}
 
 	valid, err := h.tufService.VerifyTarget(name, data)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": err.Error(),
-			"valid":   false,
-		})
+		common.ErrorWithCode(c, http.StatusBadRequest, common.ErrInvalidRequest, err.Error(), gin.H{"valid": false})
 		return
 	}
 
@@ -325,18 +286,12 @@ func (h *TUFHandler) RotateKey(c *gin.Context) {
 		"root": true, "targets": true, "snapshot": true, "timestamp": true,
 	}
 	if !validRoles[role] {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": "无效的角色",
-		})
+		common.Error(c, http.StatusBadRequest, "无效的角色")
 		return
 	}
 
 	if err := h.tufService.RotateKey(role); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -392,10 +347,7 @@ type AddDelegationRequest struct {
 func (h *TUFHandler) AddDelegation(c *gin.Context) {
 	var req AddDelegationRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"code":    400,
-			"message": "无效的请求参数",
-		})
+		common.Error(c, http.StatusBadRequest, "无效的请求参数")
 		return
 	}
 
@@ -404,10 +356,7 @@ func (h *TUFHandler) AddDelegation(c *gin.Context) {
 	}
 
 	if err := h.tufService.AddDelegation(req.Name, req.Paths, req.Threshold); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -428,10 +377,7 @@ func (h *TUFHandler) RemoveDelegation(c *gin.Context) {
 	name := c.Param("name")
 
 	if err := h.tufService.RemoveDelegation(name); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"code":    500,
-			"message": err.Error(),
-		})
+		common.Error(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
@@ -450,10 +396,7 @@ func (h *TUFHandler) RemoveDelegation(c *gin.Context) {
 func (h *TUFHandler) GetRootMetadata(c *gin.Context) {
 	data, err := h.tufService.GetRootMetadata()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "Root元数据不存在",
-		})
+		common.Error(c, http.StatusNotFound, "Root元数据不存在")
 		return
 	}
 
@@ -469,10 +412,7 @@ func (h *TUFHandler) GetRootMetadata(c *gin.Context) {
 func (h *TUFHandler) GetTimestampMetadata(c *gin.Context) {
 	data, err := h.tufService.GetTimestampMetadata()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "Timestamp元数据不存在",
-		})
+		common.Error(c, http.StatusNotFound, "Timestamp元数据不存在")
 		return
 	}
 
@@ -488,10 +428,7 @@ func (h *TUFHandler) GetTimestampMetadata(c *gin.Context) {
 func (h *TUFHandler) GetSnapshotMetadata(c *gin.Context) {
 	data, err := h.tufService.GetSnapshotMetadata()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "Snapshot元数据不存在",
-		})
+		common.Error(c, http.StatusNotFound, "Snapshot元数据不存在")
 		return
 	}
 
@@ -507,10 +444,7 @@ func (h *TUFHandler) GetSnapshotMetadata(c *gin.Context) {
 func (h *TUFHandler) GetTargetsMetadata(c *gin.Context) {
 	data, err := h.tufService.GetTargetsMetadata()
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"code":    404,
-			"message": "Targets元数据不存在",
-		})
+		common.Error(c, http.StatusNotFound, "Targets元数据不存在")
 		return
 	}
 
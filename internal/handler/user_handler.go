@@ -0,0 +1,436 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandler handles user administration requests.
+type UserHandler struct {
+	userService  *service.UserService
+	auditService *service.AuditService
+}
+
+// NewUserHandler creates a new UserHandler instance.
+func NewUserHandler(userSvc *service.UserService, auditSvc *service.AuditService) *UserHandler {
+	return &UserHandler{
+		userService:  userSvc,
+		auditService: auditSvc,
+	}
+}
+
+// RegisterRoutes registers the routes of the logged in user.
+func (h *UserHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.POST("/change-password", h.ChangePassword)
+	r.GET("/data-export", h.ExportOwnData)
+}
+
+// RegisterAdminRoutes registers user administration routes.
+func (h *UserHandler) RegisterAdminRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListUsers)
+	r.POST("", h.CreateUser)
+	r.GET("/:id", h.GetUser)
+	r.PUT("/:id/role", h.SetRole)
+	r.POST("/:id/activate", h.ActivateUser)
+	r.POST("/:id/deactivate", h.DeactivateUser)
+	r.POST("/:id/reset-password", h.ResetPassword)
+	r.DELETE("/:id", h.DeleteUser)
+	r.GET("/:id/data-export", h.ExportUserData)
+	r.POST("/:id/erase", h.EraseUserData)
+}
+
+// userErrorStatus maps user management errors to HTTP status codes.
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrUserExists), errors.Is(err, service.ErrLastAdmin),
+		errors.Is(err, service.ErrUserOwnsOrgs):
+		return http.StatusConflict
+	case errors.Is(err, service.ErrCannotModifySelf):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrInvalidRole), errors.Is(err, service.ErrWeakPassword),
+		errors.Is(err, service.ErrWrongPassword):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// parseUserID parses the :id parameter.
+func parseUserID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		common.Error(c, http.StatusBadRequest, "无效的用户ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// ListUsers lists users, optionally filtered by ?search=.
+func (h *UserHandler) ListUsers(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	users, total, err := h.userService.ListUsers(c.Query("search"), page, pageSize)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取用户列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":     users,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// GetUser returns a user.
+func (h *UserHandler) GetUser(c *gin.Context) {
+	if requireAdmin(c) == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.GetUser(id)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// CreateUser creates a user.
+func (h *UserHandler) CreateUser(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+
+	var req service.CreateUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	user, err := h.userService.CreateUser(&req)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "create", user, map[string]interface{}{"role": user.Role})
+	c.JSON(http.StatusCreated, gin.H{"user": user, "message": "用户已创建"})
+}
+
+// SetRole changes the role of a user.
+func (h *UserHandler) SetRole(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	user, err := h.userService.SetRole(id, req.Role, admin.ID)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "set_role", user, map[string]interface{}{"role": user.Role})
+	c.JSON(http.StatusOK, gin.H{"user": user, "message": "角色已更新"})
+}
+
+// ActivateUser re-enables a deactivated user.
+func (h *UserHandler) ActivateUser(c *gin.Context) {
+	h.setActive(c, true)
+}
+
+// DeactivateUser disables a user.
+func (h *UserHandler) DeactivateUser(c *gin.Context) {
+	h.setActive(c, false)
+}
+
+func (h *UserHandler) setActive(c *gin.Context, active bool) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.SetActive(id, active, admin.ID)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	action, message := "deactivate", "用户已禁用"
+	if active {
+		action, message = "activate", "用户已启用"
+	}
+	h.audit(c, admin, action, user, nil)
+	c.JSON(http.StatusOK, gin.H{"user": user, "message": message})
+}
+
+// ResetPassword sets a new password that the user must change at the next
+// login. Without a password in the body a temporary one is generated.
+func (h *UserHandler) ResetPassword(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "请求参数无效")
+			return
+		}
+	}
+
+	password, err := h.userService.ResetPassword(id, req.Password)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	user, _ := h.userService.GetUser(id)
+	h.audit(c, admin, "reset_password", user, nil)
+
+	resp := gin.H{"message": "密码已重置，用户下次登录时需修改密码"}
+	if req.Password == "" {
+		resp["temporary_password"] = password
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteUser deletes a user.
+func (h *UserHandler) DeleteUser(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	user, err := h.userService.GetUser(id)
+	if err == nil {
+		err = h.userService.DeleteUser(id, admin.ID)
+	}
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "delete", user, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "用户已删除"})
+}
+
+// ExportUserData downloads the personal data stored about a user.
+func (h *UserHandler) ExportUserData(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	export, err := h.userService.ExportUserData(id)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	h.audit(c, admin, "data_export", export.User, nil)
+	sendUserData(c, export)
+}
+
+// ExportOwnData downloads the personal data stored about the logged in
+// user.
+func (h *UserHandler) ExportOwnData(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	export, err := h.userService.ExportUserData(user.ID)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "user_data_export",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Action:    "export",
+			Status:    "success",
+		})
+	}
+	sendUserData(c, export)
+}
+
+// sendUserData sends a personal data export as a JSON download.
+func sendUserData(c *gin.Context, export *service.UserDataExport) {
+	data, _ := json.MarshalIndent(export, "", "  ")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=user-data-%d.json", export.User.ID))
+	c.Data(http.StatusOK, "application/json", data)
+}
+
+// EraseUserData deletes a user and removes the user's name and IP
+// addresses from the logs. The optional body {"username": "..."} matches
+// the records of an account that was deleted before.
+func (h *UserHandler) EraseUserData(c *gin.Context) {
+	admin := requireAdmin(c)
+	if admin == nil {
+		return
+	}
+	id, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+	var req struct {
+		Username string `json:"username"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			common.Error(c, http.StatusBadRequest, "请求参数无效")
+			return
+		}
+	}
+
+	result, err := h.userService.EraseUserData(id, admin.ID, req.Username)
+	if err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	// 审计记录只使用化名，不再写入用户名
+	h.audit(c, admin, "erase", nil, map[string]interface{}{
+		"user_id":         id,
+		"pseudonym":       result.Pseudonym,
+		"account_deleted": result.AccountDeleted,
+		"audit_logs":      result.AuditLogs,
+		"access_attempts": result.AccessAttempts,
+		"registry_events": result.RegistryEvents,
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"erasure": result,
+		"message": "用户个人数据已清除",
+	})
+}
+
+// ChangePassword changes the password of the logged in user.
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	user := getCurrentUser(c)
+	if user == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+
+	var req struct {
+		OldPassword string `json:"old_password" binding:"required"`
+		NewPassword string `json:"new_password" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	if err := h.userService.ChangePassword(user.ID, req.OldPassword, req.NewPassword); err != nil {
+		common.Error(c, userErrorStatus(err), err.Error())
+		return
+	}
+
+	if h.auditService != nil {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "password_changed",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Action:    "change_password",
+			Status:    "success",
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "密码已修改"})
+}
+
+// audit records a user administration event.
+func (h *UserHandler) audit(c *gin.Context, admin *service.User, action string, target *service.ManagedUser, details map[string]interface{}) {
+	if h.auditService == nil {
+		return
+	}
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	resource := ""
+	if target != nil {
+		resource = target.Username
+		details["user_id"] = target.ID
+	}
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "info",
+		Event:     "user_admin",
+		UserID:    admin.ID,
+		Username:  admin.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Resource:  resource,
+		Action:    action,
+		Status:    "success",
+		Details:   details,
+	})
+}
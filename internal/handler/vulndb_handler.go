@@ -0,0 +1,87 @@
+// Package handler provides HTTP handlers for the container registry.
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VulnDBHandler handles vulnerability database requests.
+type VulnDBHandler struct {
+	vulnDB       *service.VulnDB
+	auditService *service.AuditService
+}
+
+// NewVulnDBHandler creates a new VulnDBHandler instance. vulnDB is nil when
+// no database is configured.
+func NewVulnDBHandler(vulnDB *service.VulnDB, auditSvc *service.AuditService) *VulnDBHandler {
+	return &VulnDBHandler{
+		vulnDB:       vulnDB,
+		auditService: auditSvc,
+	}
+}
+
+// RegisterRoutes registers vulnerability database routes.
+func (h *VulnDBHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("/vulndb", h.GetStatus)
+	r.POST("/vulndb/refresh", h.Refresh)
+}
+
+// GetStatus returns the loaded vulnerability database and the last
+// re-scan.
+func (h *VulnDBHandler) GetStatus(c *gin.Context) {
+	if h.vulnDB == nil {
+		common.Error(c, http.StatusServiceUnavailable, "未配置漏洞库")
+		return
+	}
+
+	c.JSON(http.StatusOK, h.vulnDB.Status())
+}
+
+// Refresh checks the vulnerability database for updates now and re-scans
+// the affected images.
+func (h *VulnDBHandler) Refresh(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+	if h.vulnDB == nil {
+		common.Error(c, http.StatusServiceUnavailable, "未配置漏洞库")
+		return
+	}
+
+	result, err := h.vulnDB.Refresh(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrVulnDBRefreshing) {
+			common.Error(c, http.StatusConflict, "漏洞库正在更新")
+			return
+		}
+		common.Error(c, http.StatusBadGateway, "更新漏洞库失败: "+err.Error())
+		return
+	}
+
+	if h.auditService != nil && result.Updated {
+		h.auditService.LogAuditEvent(&service.AuditLog{
+			Level:     "info",
+			Event:     "vulndb_refresh",
+			UserID:    user.ID,
+			Username:  user.Username,
+			IPAddress: c.ClientIP(),
+			RequestID: common.RequestID(c),
+			Resource:  result.Version,
+			Action:    "refresh",
+			Status:    "success",
+			Details: map[string]interface{}{
+				"previous_version": result.PreviousVersion,
+				"images":           len(result.RescannedImages),
+				"newly_vulnerable": result.NewlyVulnerable,
+			},
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,291 @@
+// Package handler provides HTTP handlers for CYP-Docker-Registry.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkflowHandler handles workflow and job requests.
+type WorkflowHandler struct {
+	workflowService *service.WorkflowService
+	auditService    *service.AuditService
+}
+
+// NewWorkflowHandler creates a new WorkflowHandler instance.
+func NewWorkflowHandler(workflowSvc *service.WorkflowService, auditSvc *service.AuditService) *WorkflowHandler {
+	return &WorkflowHandler{
+		workflowService: workflowSvc,
+		auditService:    auditSvc,
+	}
+}
+
+// RegisterRoutes registers workflow routes.
+func (h *WorkflowHandler) RegisterRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListWorkflows)
+	r.POST("", h.CreateWorkflow)
+	r.GET("/:id", h.GetWorkflow)
+	r.PUT("/:id", h.UpdateWorkflow)
+	r.DELETE("/:id", h.DeleteWorkflow)
+	r.POST("/:id/enable", h.EnableWorkflow)
+	r.POST("/:id/disable", h.DisableWorkflow)
+	r.POST("/:id/trigger", h.TriggerWorkflow)
+	r.GET("/:id/jobs", h.ListWorkflowJobs)
+}
+
+// RegisterJobRoutes registers job routes.
+func (h *WorkflowHandler) RegisterJobRoutes(r *gin.RouterGroup) {
+	r.GET("", h.ListJobs)
+	r.GET("/:id", h.GetJob)
+	r.GET("/:id/logs", h.GetJobLogs)
+	r.POST("/:id/cancel", h.CancelJob)
+}
+
+// ListWorkflows lists all workflows.
+func (h *WorkflowHandler) ListWorkflows(c *gin.Context) {
+	workflows, err := h.workflowService.ListWorkflows()
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取工作流列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"workflows": workflows})
+}
+
+// CreateWorkflow creates a workflow.
+func (h *WorkflowHandler) CreateWorkflow(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	var req service.CreateWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	workflow, err := h.workflowService.CreateWorkflow(&req)
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "创建工作流失败")
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "workflow_created", "create", workflow.ID)
+
+	c.JSON(http.StatusCreated, workflow)
+}
+
+// GetWorkflow gets a workflow.
+func (h *WorkflowHandler) GetWorkflow(c *gin.Context) {
+	workflow, err := h.workflowService.GetWorkflow(c.Param("id"))
+	if err != nil {
+		common.Error(c, http.StatusNotFound, "工作流不存在")
+		return
+	}
+
+	c.JSON(http.StatusOK, workflow)
+}
+
+// UpdateWorkflow updates a workflow.
+func (h *WorkflowHandler) UpdateWorkflow(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	var req service.CreateWorkflowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.Error(c, http.StatusBadRequest, "请求参数无效")
+		return
+	}
+
+	workflow, err := h.workflowService.UpdateWorkflow(c.Param("id"), &req)
+	if err != nil {
+		common.Error(c, http.StatusNotFound, "工作流不存在")
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "workflow_updated", "update", workflow.ID)
+
+	c.JSON(http.StatusOK, workflow)
+}
+
+// DeleteWorkflow deletes a workflow.
+func (h *WorkflowHandler) DeleteWorkflow(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.workflowService.DeleteWorkflow(id); err != nil {
+		common.Error(c, http.StatusNotFound, "工作流不存在")
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "workflow_deleted", "delete", id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "工作流已删除"})
+}
+
+// EnableWorkflow enables a workflow.
+func (h *WorkflowHandler) EnableWorkflow(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.workflowService.EnableWorkflow(id); err != nil {
+		common.Error(c, http.StatusNotFound, "工作流不存在")
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "workflow_enabled", "enable", id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "工作流已启用"})
+}
+
+// DisableWorkflow disables a workflow.
+func (h *WorkflowHandler) DisableWorkflow(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	id := c.Param("id")
+	if err := h.workflowService.DisableWorkflow(id); err != nil {
+		common.Error(c, http.StatusNotFound, "工作流不存在")
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "workflow_disabled", "disable", id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "工作流已停用"})
+}
+
+// TriggerWorkflow manually starts a workflow job.
+func (h *WorkflowHandler) TriggerWorkflow(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := h.workflowService.GetWorkflow(id); err != nil {
+		common.Error(c, http.StatusNotFound, "工作流不存在")
+		return
+	}
+
+	job, err := h.workflowService.TriggerWorkflow(id)
+	if err != nil {
+		common.Error(c, http.StatusConflict, "触发工作流失败: "+err.Error())
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "workflow_triggered", "trigger", id)
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListWorkflowJobs lists jobs of a workflow.
+func (h *WorkflowHandler) ListWorkflowJobs(c *gin.Context) {
+	jobs, err := h.workflowService.ListJobs(c.Param("id"))
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取作业列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// ListJobs lists all jobs.
+func (h *WorkflowHandler) ListJobs(c *gin.Context) {
+	jobs, err := h.workflowService.ListJobs(c.Query("workflow_id"))
+	if err != nil {
+		common.Error(c, http.StatusInternalServerError, "获取作业列表失败")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// GetJob gets a job.
+func (h *WorkflowHandler) GetJob(c *gin.Context) {
+	job, err := h.workflowService.GetJob(c.Param("id"))
+	if err != nil {
+		common.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// GetJobLogs returns job log lines. The "since" query parameter skips
+// lines already fetched so clients can poll with the returned "next".
+func (h *WorkflowHandler) GetJobLogs(c *gin.Context) {
+	job, err := h.workflowService.GetJob(c.Param("id"))
+	if err != nil {
+		common.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+
+	since, _ := strconv.Atoi(c.DefaultQuery("since", "0"))
+	if since < 0 || since > len(job.Logs) {
+		since = len(job.Logs)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job_id": job.ID,
+		"status": job.Status,
+		"logs":   job.Logs[since:],
+		"next":   len(job.Logs),
+	})
+}
+
+// CancelJob cancels a running job.
+func (h *WorkflowHandler) CancelJob(c *gin.Context) {
+	user := requireAdmin(c)
+	if user == nil {
+		return
+	}
+
+	id := c.Param("id")
+	if _, err := h.workflowService.GetJob(id); err != nil {
+		common.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+	if err := h.workflowService.CancelJob(id); err != nil {
+		common.Error(c, http.StatusConflict, "作业未在运行")
+		return
+	}
+
+	h.logWorkflowEvent(c, user, "job_cancelled", "cancel", id)
+
+	c.JSON(http.StatusOK, gin.H{"message": "作业已取消"})
+}
+
+// logWorkflowEvent writes a workflow audit record.
+func (h *WorkflowHandler) logWorkflowEvent(c *gin.Context, user *service.User, event, action, id string) {
+	if h.auditService == nil {
+		return
+	}
+
+	h.auditService.LogAuditEvent(&service.AuditLog{
+		Level:     "info",
+		Event:     event,
+		UserID:    user.ID,
+		Username:  user.Username,
+		IPAddress: c.ClientIP(),
+		RequestID: common.RequestID(c),
+		Action:    action,
+		Status:    "success",
+		Details:   map[string]interface{}{"id": id},
+	})
+}
@@ -4,117 +4,262 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
+
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
+// WebSocket topics clients can subscribe to.
+const (
+	TopicNotifications = "notifications"
+	TopicSystem        = "system"
+	TopicAudit         = "audit"
+	TopicSyncProgress  = "sync-progress"
+	TopicScanResults   = "scan-results"
+	TopicP2PStats      = "p2p-stats"
+	TopicUpdateStatus  = "update-status"
+	TopicShare         = "share"
+	TopicWorkflow      = "workflow"
+)
+
+// wsTopic describes who may subscribe to a topic.
+type wsTopic struct {
+	adminOnly bool
+	scope     string // 访问令牌需要的权限范围
+}
+
+var wsTopics = map[string]wsTopic{
+	TopicNotifications: {},
+	TopicSystem:        {},
+	TopicShare:         {},
+	TopicScanResults:   {scope: service.ScopeRegistryRead},
+	TopicAudit:         {adminOnly: true, scope: service.ScopeAuditRead},
+	TopicSyncProgress:  {adminOnly: true, scope: service.ScopeAdminRead},
+	TopicP2PStats:      {adminOnly: true, scope: service.ScopeAdminRead},
+	TopicUpdateStatus:  {adminOnly: true, scope: service.ScopeAdminRead},
+	TopicWorkflow:      {adminOnly: true, scope: service.ScopeAdminRead},
 }
 
-// WSHandler handles WebSocket connections.
+// messageTopics maps the message types of Broadcast to topics.
+var messageTopics = map[string]string{
+	"notification": TopicNotifications,
+	"system":       TopicSystem,
+	"sync":         TopicSyncProgress,
+	"share":        TopicShare,
+	"workflow":     TopicWorkflow,
+}
+
+// defaultTopics 为未指定订阅时的默认主题
+var defaultTopics = []string{TopicNotifications, TopicSystem}
+
+const (
+	wsPongWait     = 60 * time.Second
+	wsPingPeriod   = 30 * time.Second
+	wsWriteWait    = 10 * time.Second
+	wsSendBuffer   = 64
+	wsHistorySize  = 256
+	wsMaxReadBytes = 4096
+)
+
+// WSAuthenticator resolves the user of a WebSocket connection from a JWT or
+// a personal access token. scopes is nil for login sessions.
+type WSAuthenticator func(token string) (user *service.User, scopes []string, err error)
+
+// WSHandler handles WebSocket connections. Clients authenticate with a
+// token, subscribe to topics, and receive the events published on them.
 type WSHandler struct {
-	clients    map[*websocket.Conn]bool
-	broadcast  chan *WSMessage
-	register   chan *websocket.Conn
-	unregister chan *websocket.Conn
-	mu         sync.RWMutex
-	logger     *zap.Logger
+	clients map[*wsClient]bool
+	mu      sync.RWMutex
+	logger  *zap.Logger
+
+	authenticate WSAuthenticator
+	upgrader     websocket.Upgrader
+
+	seq     uint64
+	history []*WSMessage // 最近的消息，供重连的客户端补发
 }
 
 // WSMessage represents a WebSocket message.
 type WSMessage struct {
 	Type      string                 `json:"type"`
+	Topic     string                 `json:"topic,omitempty"`
 	Event     string                 `json:"event"`
+	Seq       uint64                 `json:"seq,omitempty"`
 	Data      map[string]interface{} `json:"data,omitempty"`
 	Timestamp time.Time              `json:"timestamp"`
+
+	audience WSAudience
+}
+
+// WSAudience limits a message to some of the subscribers of its topic.
+// Administrators receive every message; the zero value reaches everyone.
+type WSAudience struct {
+	UserID    int64 // 只发给该用户（及管理员），0 表示不限
+	AdminOnly bool  // 只发给管理员
+}
+
+// allows reports whether a user may receive a message for the audience.
+func (a WSAudience) allows(user *service.User) bool {
+	if user.Role == service.RoleAdmin {
+		return true
+	}
+	if a.AdminOnly {
+		return false
+	}
+	return a.UserID == 0 || a.UserID == user.ID
+}
+
+// wsRequest is a message sent by a client.
+type wsRequest struct {
+	Type    string   `json:"type"` // ping, subscribe, unsubscribe
+	Topics  []string `json:"topics,omitempty"`
+	LastSeq uint64   `json:"last_seq,omitempty"`
+}
+
+// wsClient is a connected client.
+type wsClient struct {
+	conn   *websocket.Conn
+	send   chan []byte
+	user   *service.User
+	scopes []string
+	topics map[string]bool
+	closed bool
 }
 
 // NewWSHandler creates a new WSHandler instance.
 func NewWSHandler(logger *zap.Logger) *WSHandler {
-	h := &WSHandler{
-		clients:    make(map[*websocket.Conn]bool),
-		broadcast:  make(chan *WSMessage, 256),
-		register:   make(chan *websocket.Conn),
-		unregister: make(chan *websocket.Conn),
-		logger:     logger,
+	return &WSHandler{
+		clients: make(map[*wsClient]bool),
+		logger:  logger,
+		// 未设置来源策略时只允许同源的浏览器连接
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
 	}
+}
 
-	go h.run()
-	return h
+// SetOriginPolicy applies the CORS policy of the API to WebSocket upgrades,
+// so other sites cannot open connections from a user's browser. Same-origin
+// pages and clients that send no Origin header, which are not browsers, are
+// always allowed.
+func (h *WSHandler) SetOriginPolicy(policy common.CORSPolicy) {
+	h.upgrader.CheckOrigin = func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		return policy.AllowsOrigin(origin)
+	}
+}
+
+// SetAuthenticator sets the function that authenticates connections.
+// Without it, connections are refused.
+func (h *WSHandler) SetAuthenticator(fn WSAuthenticator) {
+	h.authenticate = fn
 }
 
 // RegisterRoutes registers WebSocket routes.
 func (h *WSHandler) RegisterRoutes(r *gin.RouterGroup) {
 	r.GET("/ws", h.HandleWebSocket)
+	r.GET("/ws/topics", h.ListTopics)
 }
 
-// HandleWebSocket handles WebSocket upgrade requests.
+// ListTopics lists the available topics.
+func (h *WSHandler) ListTopics(c *gin.Context) {
+	topics := make([]gin.H, 0, len(wsTopics))
+	for name, t := range wsTopics {
+		topics = append(topics, gin.H{"topic": name, "admin_only": t.adminOnly})
+	}
+	c.JSON(http.StatusOK, gin.H{"topics": topics})
+}
+
+// HandleWebSocket handles WebSocket upgrade requests. Browsers cannot set
+// headers on WebSocket requests, so the token may also be passed as the
+// token query parameter. Topics can be given as ?topics=a,b and resumed
+// after a reconnect with ?last_seq=.
 func (h *WSHandler) HandleWebSocket(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	token := c.Query("token")
+	if auth := c.GetHeader("Authorization"); auth != "" {
+		for _, scheme := range []string{"Bearer ", "Token "} {
+			if strings.HasPrefix(auth, scheme) {
+				token = strings.TrimPrefix(auth, scheme)
+			}
+		}
+	}
+	if token == "" || h.authenticate == nil {
+		common.Error(c, http.StatusUnauthorized, "未登录")
+		return
+	}
+	user, scopes, err := h.authenticate(token)
+	if err != nil || user == nil {
+		common.Error(c, http.StatusUnauthorized, "令牌无效")
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		if h.logger != nil {
-			h.logger.Error("WebSocket upgrade failed", zap.Error(err))
+			common.RequestLogger(c, h.logger).Error("WebSocket upgrade failed", zap.Error(err))
 		}
 		return
 	}
 
-	h.register <- conn
-
-	// Handle incoming messages
-	go h.readPump(conn)
-}
+	client := &wsClient{
+		conn:   conn,
+		send:   make(chan []byte, wsSendBuffer),
+		user:   user,
+		scopes: scopes,
+		topics: make(map[string]bool),
+	}
+	h.mu.Lock()
+	h.clients[client] = true
+	h.mu.Unlock()
 
-func (h *WSHandler) run() {
-	for {
-		select {
-		case conn := <-h.register:
-			h.mu.Lock()
-			h.clients[conn] = true
-			h.mu.Unlock()
-
-			// Send welcome message
-			h.sendToClient(conn, &WSMessage{
-				Type:      "system",
-				Event:     "connected",
-				Timestamp: time.Now(),
-			})
+	go h.writePump(client)
 
-		case conn := <-h.unregister:
-			h.mu.Lock()
-			if _, ok := h.clients[conn]; ok {
-				delete(h.clients, conn)
-				conn.Close()
-			}
-			h.mu.Unlock()
+	h.enqueue(client, &WSMessage{
+		Type:  "system",
+		Event: "connected",
+		Data: map[string]interface{}{
+			"user":               user.Username,
+			"heartbeat_interval": int(wsPingPeriod.Seconds()),
+			"seq":                h.currentSeq(),
+		},
+		Timestamp: time.Now(),
+	})
 
-		case msg := <-h.broadcast:
-			h.mu.RLock()
-			for conn := range h.clients {
-				h.sendToClient(conn, msg)
-			}
-			h.mu.RUnlock()
-		}
+	topics := defaultTopics
+	if q := c.Query("topics"); q != "" {
+		topics = strings.Split(q, ",")
 	}
+	lastSeq, _ := strconv.ParseUint(c.Query("last_seq"), 10, 64)
+	h.subscribe(client, topics, lastSeq)
+
+	go h.readPump(client)
 }
 
-func (h *WSHandler) readPump(conn *websocket.Conn) {
-	defer func() {
-		h.unregister <- conn
-	}()
+// readPump 处理客户端消息，超过 wsPongWait 未收到任何消息或 pong 时断开
+func (h *WSHandler) readPump(client *wsClient) {
+	defer h.remove(client)
 
-	conn.SetReadLimit(512)
-	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn := client.conn
+	conn.SetReadLimit(wsMaxReadBytes)
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
 	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
 		return nil
 	})
 
@@ -128,48 +273,232 @@ func (h *WSHandler) readPump(conn *websocket.Conn) {
 			}
 			break
 		}
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
 
-		// Handle incoming message
-		var msg WSMessage
-		if err := json.Unmarshal(message, &msg); err != nil {
+		var req wsRequest
+		if err := json.Unmarshal(message, &req); err != nil {
 			continue
 		}
 
-		// Handle ping
-		if msg.Type == "ping" {
-			h.sendToClient(conn, &WSMessage{
+		switch req.Type {
+		case "ping":
+			h.enqueue(client, &WSMessage{
 				Type:      "pong",
 				Timestamp: time.Now(),
 			})
+		case "subscribe":
+			h.subscribe(client, req.Topics, req.LastSeq)
+		case "unsubscribe":
+			h.unsubscribe(client, req.Topics)
+		}
+	}
+}
+
+// writePump 是唯一写连接的协程，并定期发送 ping
+func (h *WSHandler) writePump(client *wsClient) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+	}()
+
+	for {
+		select {
+		case data, ok := <-client.send:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				client.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if err := client.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
-func (h *WSHandler) sendToClient(conn *websocket.Conn, msg *WSMessage) {
+// subscribe 订阅主题并补发 lastSeq 之后的历史消息
+func (h *WSHandler) subscribe(client *wsClient, topics []string, lastSeq uint64) {
+	var subscribed, denied []string
+
+	h.mu.Lock()
+	for _, topic := range topics {
+		topic = strings.TrimSpace(topic)
+		if topic == "" {
+			continue
+		}
+		if !clientAllowed(client, topic) {
+			denied = append(denied, topic)
+			continue
+		}
+		client.topics[topic] = true
+		subscribed = append(subscribed, topic)
+	}
+
+	var replay []*WSMessage
+	if lastSeq > 0 {
+		for _, msg := range h.history {
+			if msg.Seq > lastSeq && client.topics[msg.Topic] && msg.audience.allows(client.user) {
+				replay = append(replay, msg)
+			}
+		}
+	}
+	h.mu.Unlock()
+
+	h.enqueue(client, &WSMessage{
+		Type:  "subscribed",
+		Event: "subscribe",
+		Data: map[string]interface{}{
+			"topics": subscribed,
+			"denied": denied,
+		},
+		Timestamp: time.Now(),
+	})
+	for _, msg := range replay {
+		h.enqueue(client, msg)
+	}
+}
+
+func (h *WSHandler) unsubscribe(client *wsClient, topics []string) {
+	h.mu.Lock()
+	for _, topic := range topics {
+		delete(client.topics, strings.TrimSpace(topic))
+	}
+	h.mu.Unlock()
+
+	h.enqueue(client, &WSMessage{
+		Type:      "unsubscribed",
+		Event:     "unsubscribe",
+		Data:      map[string]interface{}{"topics": topics},
+		Timestamp: time.Now(),
+	})
+}
+
+// clientAllowed 检查客户端能否订阅主题
+func clientAllowed(client *wsClient, topic string) bool {
+	t, ok := wsTopics[topic]
+	if !ok {
+		return false
+	}
+	if t.adminOnly && client.user.Role != "admin" {
+		return false
+	}
+	if client.scopes != nil && t.scope != "" && !service.ScopeAllows(client.scopes, t.scope) {
+		return false
+	}
+	return true
+}
+
+// enqueue 将消息放入客户端的发送队列，队列已满说明客户端过慢，直接断开
+func (h *WSHandler) enqueue(client *wsClient, msg *WSMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return
 	}
 
-	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		h.unregister <- conn
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.enqueueLocked(client, data)
+}
+
+func (h *WSHandler) enqueueLocked(client *wsClient, data []byte) {
+	if client.closed {
+		return
+	}
+	select {
+	case client.send <- data:
+	default:
+		client.closed = true
+		delete(h.clients, client)
+		close(client.send)
 	}
 }
 
-// Broadcast sends a message to all connected clients.
-func (h *WSHandler) Broadcast(msgType, event string, data map[string]interface{}) {
-	h.broadcast <- &WSMessage{
+func (h *WSHandler) remove(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !client.closed {
+		client.closed = true
+		delete(h.clients, client)
+		close(client.send)
+	}
+}
+
+func (h *WSHandler) currentSeq() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.seq
+}
+
+// Publish sends an event to the clients subscribed to a topic.
+func (h *WSHandler) Publish(topic, event string, data map[string]interface{}) {
+	h.publish(topic, topic, event, data, WSAudience{})
+}
+
+func (h *WSHandler) publish(msgType, topic, event string, data map[string]interface{}, audience WSAudience) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	msg := &WSMessage{
 		Type:      msgType,
+		Topic:     topic,
 		Event:     event,
+		Seq:       h.seq,
 		Data:      data,
 		Timestamp: time.Now(),
+		audience:  audience,
+	}
+	h.history = append(h.history, msg)
+	if len(h.history) > wsHistorySize {
+		h.history = h.history[len(h.history)-wsHistorySize:]
+	}
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	for client := range h.clients {
+		if client.topics[topic] && audience.allows(client.user) {
+			h.enqueueLocked(client, encoded)
+		}
 	}
 }
 
+// Broadcast sends a message to the clients subscribed to the topic of the
+// message type.
+func (h *WSHandler) Broadcast(msgType, event string, data map[string]interface{}) {
+	h.BroadcastTo(WSAudience{}, msgType, event, data)
+}
+
+// BroadcastTo is Broadcast limited to an audience.
+func (h *WSHandler) BroadcastTo(audience WSAudience, msgType, event string, data map[string]interface{}) {
+	topic, ok := messageTopics[msgType]
+	if !ok {
+		topic = msgType
+	}
+	h.publish(msgType, topic, event, data, audience)
+}
+
 // BroadcastNotification sends a notification to all clients.
 func (h *WSHandler) BroadcastNotification(level, title, message string) {
-	h.Broadcast("notification", level, map[string]interface{}{
+	h.NotifyAudience(WSAudience{}, level, title, message)
+}
+
+// NotifyAdmins sends a notification to the clients of administrators, e.g.
+// security alerts.
+func (h *WSHandler) NotifyAdmins(level, title, message string) {
+	h.NotifyAudience(WSAudience{AdminOnly: true}, level, title, message)
+}
+
+// NotifyAudience sends a notification to the clients of an audience.
+func (h *WSHandler) NotifyAudience(audience WSAudience, level, title, message string) {
+	h.BroadcastTo(audience, "notification", level, map[string]interface{}{
 		"title":   title,
 		"message": message,
 	})
@@ -180,6 +509,25 @@ func (h *WSHandler) BroadcastSystemEvent(event string, data map[string]interface
 	h.Broadcast("system", event, data)
 }
 
+// SystemEventToAdmins sends a system event to the clients of
+// administrators, e.g. backup and garbage collection progress.
+func (h *WSHandler) SystemEventToAdmins(event string, data map[string]interface{}) {
+	h.BroadcastTo(WSAudience{AdminOnly: true}, "system", event, data)
+}
+
+// HasSubscribers reports whether any client is subscribed to a topic, so
+// publishers of periodic stats can skip the work.
+func (h *WSHandler) HasSubscribers(topic string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		if client.topics[topic] {
+			return true
+		}
+	}
+	return false
+}
+
 // GetClientCount returns the number of connected clients.
 func (h *WSHandler) GetClientCount() int {
 	h.mu.RLock()
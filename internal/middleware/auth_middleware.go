@@ -6,6 +6,8 @@ import (
 	"strings"
 	"time"
 
+	"cyp-docker-registry/internal/common"
+
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -102,6 +104,8 @@ var authWhitelist = []string{
 	"/api/v1/system/health",
 	"/api/version",
 	"/health",
+	"/healthz",
+	"/readyz",
 	"/metrics",
 }
 
@@ -120,8 +124,7 @@ func (m *AuthMiddleware) ForceAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Check if system is locked
 		if m.lockService != nil && m.lockService.IsSystemLocked() {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"error":       "系统已锁定",
+			common.AbortWithError(c, http.StatusForbidden, common.ErrSystemLocked, "系统已锁定", gin.H{
 				"details":     "system_locked",
 				"lock_reason": m.lockService.GetLockReason(),
 			})
@@ -233,10 +236,7 @@ func (m *AuthMiddleware) handleUnauthorized(c *gin.Context, message, code string
 		}
 	}
 
-	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-		"error": message,
-		"code":  code,
-	})
+	common.AbortWithError(c, http.StatusUnauthorized, common.ErrorCode(code), message, nil)
 }
 
 // logUnauthorizedAttempt logs unauthorized access attempts.
@@ -0,0 +1,64 @@
+// Package middleware provides security middleware for CYP-Docker-Registry.
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"cyp-docker-registry/internal/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IPRuleEvaluator decides whether a client address may access a path.
+type IPRuleEvaluator interface {
+	Evaluate(ip, path string) (bool, string)
+}
+
+// IPFilterMiddleware blocks requests according to IP allow and deny rules.
+type IPFilterMiddleware struct {
+	evaluator IPRuleEvaluator
+	onBlocked func(c *gin.Context, reason string)
+}
+
+// NewIPFilterMiddleware creates a new IPFilterMiddleware instance.
+// onBlocked is called for every blocked request, e.g. to audit it.
+func NewIPFilterMiddleware(evaluator IPRuleEvaluator, onBlocked func(c *gin.Context, reason string)) *IPFilterMiddleware {
+	return &IPFilterMiddleware{
+		evaluator: evaluator,
+		onBlocked: onBlocked,
+	}
+}
+
+// Filter returns a middleware that rejects blocked client addresses.
+func (m *IPFilterMiddleware) Filter() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if m.evaluator == nil {
+			c.Next()
+			return
+		}
+
+		path := c.Request.URL.Path
+		allowed, reason := m.evaluator.Evaluate(c.ClientIP(), path)
+		if allowed {
+			c.Next()
+			return
+		}
+
+		if m.onBlocked != nil {
+			m.onBlocked(c, reason)
+		}
+
+		// Docker 客户端只识别 registry 格式的错误
+		if path == "/v2" || strings.HasPrefix(path, "/v2/") {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"errors": []gin.H{{
+					"code":    "DENIED",
+					"message": "access from this address is not allowed",
+				}},
+			})
+			return
+		}
+		common.AbortWithError(c, http.StatusForbidden, common.ErrIPBlocked, "禁止从该地址访问", gin.H{"details": "ip_blocked"})
+	}
+}
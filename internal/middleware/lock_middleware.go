@@ -4,6 +4,8 @@ package middleware
 import (
 	"net/http"
 
+	"cyp-docker-registry/internal/common"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -35,8 +37,8 @@ func (m *LockMiddleware) CheckLock() gin.HandlerFunc {
 			return
 		}
 
-		// Allow health check
-		if path == "/health" || path == "/api/v1/system/health" {
+		// Allow health checks and probes
+		if path == "/health" || path == "/healthz" || path == "/readyz" || path == "/api/v1/system/health" {
 			c.Next()
 			return
 		}
@@ -59,8 +61,7 @@ func (m *LockMiddleware) CheckLock() gin.HandlerFunc {
 		if m.lockService.IsSystemLocked() {
 			// For API requests, return JSON
 			if len(path) > 4 && path[:4] == "/api" {
-				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-					"error":       "系统已锁定",
+				common.AbortWithError(c, http.StatusForbidden, common.ErrSystemLocked, "系统已锁定", gin.H{
 					"details":     "system_locked",
 					"lock_reason": m.lockService.GetLockReason(),
 				})
@@ -90,9 +91,6 @@ func ReadOnlyMode(enabled bool) gin.HandlerFunc {
 		}
 
 		// Block write operations
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-			"error":   "系统处于只读模式",
-			"details": "readonly_mode",
-		})
+		common.AbortWithError(c, http.StatusForbidden, common.ErrReadOnly, "系统处于只读模式", gin.H{"details": "readonly_mode"})
 	}
 }
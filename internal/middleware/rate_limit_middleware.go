@@ -0,0 +1,342 @@
+// Package middleware provides security middleware for CYP-Docker-Registry.
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/common"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate limit endpoint classes.
+const (
+	RateClassAuth = "auth"
+	RateClassPull = "pull"
+	RateClassPush = "push"
+	RateClassAPI  = "api"
+)
+
+// authRatePaths 为按 IP 计数的认证类接口（仅限 POST）
+var authRatePaths = []string{
+	"/api/v1/auth/login",
+	"/api/v1/auth/register",
+	"/api/v1/auth/verify-token",
+	"/api/v1/system/lock/unlock",
+}
+
+// bucketIdleTimeout 为 Redis 中空闲令牌桶的过期时长
+const bucketIdleTimeout = 10 * time.Minute
+
+// maxBuckets 为每类接口的令牌桶上限，达到上限时新的客户端共用一个溢出令牌桶，
+// 已有客户端的令牌桶不会被淘汰，避免攻击者通过大量新标识重置他人的限额
+const maxBuckets = 10000
+
+// Identifier returns the verified identity of a request's credentials, e.g.
+// "user:7" or "token:3", or "" when they are missing or invalid.
+type Identifier func(c *gin.Context) string
+
+// RateLimit is the token bucket of an endpoint class.
+type RateLimit struct {
+	QPS   float64
+	Burst int
+}
+
+// RateLimitStats is a snapshot of the limiter state of an endpoint class.
+type RateLimitStats struct {
+	Class      string  `json:"class"`
+	QPS        float64 `json:"qps"`
+	Burst      int     `json:"burst"`
+	Allowed    uint64  `json:"allowed"`
+	Limited    uint64  `json:"limited"`
+	ActiveKeys int     `json:"active_keys"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refilled 判断令牌桶是否已补满，补满的令牌桶与新建的等价，可以安全删除
+func (b *tokenBucket) refilled(limit RateLimit, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*limit.QPS >= float64(limit.Burst)
+}
+
+// classLimiter 为某一类接口的令牌桶集合，按客户端标识区分
+type classLimiter struct {
+	limit   RateLimit
+	buckets map[string]*tokenBucket
+	// overflow 为令牌桶数量达到上限后新客户端共用的令牌桶
+	overflow *tokenBucket
+	// fullSweep 为达到上限时最近一次清理的时间，避免每个新客户端都遍历所有令牌桶
+	fullSweep time.Time
+	allowed   uint64
+	limited   uint64
+}
+
+// allow 取出一个令牌，失败时返回需要等待的时长
+func (l *classLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets && now.Sub(l.fullSweep) >= time.Second {
+			l.fullSweep = now
+			l.sweep(now)
+		}
+		if len(l.buckets) < maxBuckets {
+			b = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+			l.buckets[key] = b
+		} else {
+			if l.overflow == nil {
+				l.overflow = &tokenBucket{tokens: float64(l.limit.Burst), last: now}
+			}
+			b = l.overflow
+		}
+	}
+
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.QPS)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		l.allowed++
+		return true, 0
+	}
+
+	l.limited++
+	wait := (1 - b.tokens) / l.limit.QPS
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// sweep 删除已补满的令牌桶
+func (l *classLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.refilled(l.limit, now) {
+			delete(l.buckets, key)
+		}
+	}
+	if l.overflow != nil && l.overflow.refilled(l.limit, now) {
+		l.overflow = nil
+	}
+}
+
+// count 记录由共享令牌桶做出的判定
+func (l *classLimiter) count(allowed bool) {
+	if allowed {
+		l.allowed++
+	} else {
+		l.limited++
+	}
+}
+
+// RateLimitMiddleware limits requests per endpoint class with token buckets.
+// Requests with valid credentials are counted per user or access token,
+// the others per client IP.
+type RateLimitMiddleware struct {
+	mu        sync.Mutex
+	limiters  map[string]*classLimiter
+	lastSweep time.Time
+	identify  Identifier
+
+	// shared 非空时令牌桶保存在 Redis 中，由所有实例共用
+	shared *sharedBuckets
+}
+
+// NewRateLimitMiddleware creates a new RateLimitMiddleware instance. Classes
+// with a QPS of 0 are not limited.
+func NewRateLimitMiddleware(limits map[string]RateLimit) *RateLimitMiddleware {
+	m := &RateLimitMiddleware{
+		limiters:  make(map[string]*classLimiter),
+		lastSweep: time.Now(),
+	}
+	m.SetLimits(limits)
+	return m
+}
+
+// SetLimits replaces the limits at runtime. Classes that stay limited keep
+// their buckets and counters; a bucket over the new burst is capped on its
+// next request.
+func (m *RateLimitMiddleware) SetLimits(limits map[string]RateLimit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for class := range m.limiters {
+		if limit, ok := limits[class]; !ok || limit.QPS <= 0 {
+			delete(m.limiters, class)
+		}
+	}
+	for class, limit := range limits {
+		if limit.QPS <= 0 {
+			continue
+		}
+		if limit.Burst < 1 {
+			limit.Burst = int(math.Ceil(limit.QPS))
+		}
+		if l, ok := m.limiters[class]; ok {
+			l.limit = limit
+			continue
+		}
+		m.limiters[class] = &classLimiter{
+			limit:   limit,
+			buckets: make(map[string]*tokenBucket),
+		}
+	}
+}
+
+// SetIdentifier sets the check of request credentials. Without it, or for
+// credentials it does not verify, requests are counted per client IP. It
+// must be called before serving requests.
+func (m *RateLimitMiddleware) SetIdentifier(fn Identifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.identify = fn
+}
+
+// Limit returns a middleware that rejects requests over budget with 429.
+func (m *RateLimitMiddleware) Limit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		class := ClassifyRequest(c.Request)
+		if class == "" {
+			c.Next()
+			return
+		}
+
+		// 凭证的校验可能访问数据库，不持有锁
+		m.mu.Lock()
+		_, ok := m.limiters[class]
+		identify := m.identify
+		m.mu.Unlock()
+		if !ok {
+			c.Next()
+			return
+		}
+		key := rateLimitKey(c, class, identify)
+
+		m.mu.Lock()
+		limiter, ok := m.limiters[class]
+		if !ok {
+			m.mu.Unlock()
+			c.Next()
+			return
+		}
+		now := time.Now()
+		var allowed bool
+		var wait time.Duration
+		if shared := m.shared; shared != nil {
+			// Redis 往返期间不持有锁
+			limit := limiter.limit
+			m.mu.Unlock()
+			ok, d, err := shared.allow(c.Request.Context(), class, key, limit, now)
+			m.mu.Lock()
+			if err != nil {
+				// Redis 不可用时退回本实例的令牌桶
+				allowed, wait = limiter.allow(key, now)
+			} else {
+				allowed, wait = ok, d
+				limiter.count(allowed)
+			}
+		} else {
+			allowed, wait = limiter.allow(key, now)
+		}
+		m.sweep(now)
+		m.mu.Unlock()
+
+		if allowed {
+			c.Next()
+			return
+		}
+
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		c.Header("Retry-After", strconv.Itoa(retryAfter))
+
+		if class == RateClassPull || class == RateClassPush {
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"errors": []gin.H{{
+					"code":    "TOOMANYREQUESTS",
+					"message": "too many requests",
+				}},
+			})
+			return
+		}
+		common.AbortWithError(c, http.StatusTooManyRequests, common.ErrRateLimited, "请求过于频繁", gin.H{"retry_after": retryAfter})
+	}
+}
+
+// Stats returns the limiter state of every limited class.
+func (m *RateLimitMiddleware) Stats() []RateLimitStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make([]RateLimitStats, 0, len(m.limiters))
+	for class, l := range m.limiters {
+		stats = append(stats, RateLimitStats{
+			Class:      class,
+			QPS:        l.limit.QPS,
+			Burst:      l.limit.Burst,
+			Allowed:    l.allowed,
+			Limited:    l.limited,
+			ActiveKeys: len(l.buckets),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Class < stats[j].Class })
+	return stats
+}
+
+// sweep 定期清理已补满的令牌桶，调用方需持有锁
+func (m *RateLimitMiddleware) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for _, l := range m.limiters {
+		l.sweep(now)
+	}
+}
+
+// ClassifyRequest returns the rate limit class of a request, or "" for
+// requests that are not limited such as static files and health checks.
+func ClassifyRequest(req *http.Request) string {
+	path := req.URL.Path
+
+	if req.Method == http.MethodPost {
+		for _, p := range authRatePaths {
+			if path == p {
+				return RateClassAuth
+			}
+		}
+		// 分享链接的密码校验与拉取令牌兑换
+		if strings.HasPrefix(path, "/api/v1/share/") &&
+			(strings.HasSuffix(path, "/verify") || strings.HasSuffix(path, "/token")) {
+			return RateClassAuth
+		}
+	}
+
+	if path == "/v2" || strings.HasPrefix(path, "/v2/") {
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			return RateClassPull
+		}
+		return RateClassPush
+	}
+	if strings.HasPrefix(path, "/api/") {
+		return RateClassAPI
+	}
+	return ""
+}
+
+// rateLimitKey 返回计数用的客户端标识：凭证有效时按用户或访问令牌，
+// 认证类接口、匿名请求和凭证无效的请求按 IP，伪造的凭证不会得到新的令牌桶
+func rateLimitKey(c *gin.Context, class string, identify Identifier) string {
+	if class != RateClassAuth && identify != nil && c.GetHeader("Authorization") != "" {
+		if id := identify(c); id != "" {
+			return id
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
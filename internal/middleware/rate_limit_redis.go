@@ -0,0 +1,74 @@
+// Package middleware provides security middleware for CYP-Docker-Registry.
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sharedBucketTimeout bounds the Redis round trip of a request; on timeout
+// the local bucket decides.
+const sharedBucketTimeout = 200 * time.Millisecond
+
+// takeTokenScript is classLimiter.allow run atomically in Redis on a hash
+// holding the tokens and the time of the last request in milliseconds. It
+// returns whether a token was taken and otherwise the wait in milliseconds.
+var takeTokenScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local qps = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last')
+local tokens = tonumber(bucket[1]) or burst
+local last = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - last) / 1000 * qps)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / qps * 1000)
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'last', now)
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+return {allowed, wait}
+`)
+
+// sharedBuckets keeps the token buckets of all instances in Redis.
+type sharedBuckets struct {
+	client *redis.Client
+	prefix string
+}
+
+// SetRedis makes all instances using the same Redis share their token
+// buckets, so a client gets the configured rate in total rather than per
+// instance. Statistics stay per instance. It must be called before serving
+// requests.
+func (m *RateLimitMiddleware) SetRedis(client *redis.Client, prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shared = &sharedBuckets{client: client, prefix: prefix + "ratelimit:"}
+}
+
+// allow takes a token from the shared bucket of key.
+func (b *sharedBuckets) allow(ctx context.Context, class, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, sharedBucketTimeout)
+	defer cancel()
+
+	res, err := takeTokenScript.Run(ctx, b.client, []string{b.prefix + class + ":" + key},
+		limit.Burst,
+		strconv.FormatFloat(limit.QPS, 'f', -1, 64),
+		now.UnixMilli(),
+		bucketIdleTimeout.Milliseconds(),
+	).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+	if len(res) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit reply %v", res)
+	}
+	return res[0] == 1, time.Duration(res[1]) * time.Millisecond, nil
+}
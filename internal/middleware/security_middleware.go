@@ -4,9 +4,14 @@ package middleware
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"cyp-docker-registry/internal/common"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -14,31 +19,52 @@ import (
 type SecurityMiddleware struct {
 	csrfEnabled bool
 	csrfTokens  map[string]time.Time
+	headers     common.SecurityHeadersConfig
+	hsts        string
 }
 
 // NewSecurityMiddleware creates a new SecurityMiddleware instance.
-func NewSecurityMiddleware(csrfEnabled bool) *SecurityMiddleware {
-	return &SecurityMiddleware{
+func NewSecurityMiddleware(csrfEnabled bool, headers common.SecurityHeadersConfig) *SecurityMiddleware {
+	m := &SecurityMiddleware{
 		csrfEnabled: csrfEnabled,
 		csrfTokens:  make(map[string]time.Time),
+		headers:     headers,
+	}
+	if headers.HSTS.Enabled {
+		m.hsts = "max-age=" + strconv.Itoa(headers.HSTS.MaxAge)
+		if headers.HSTS.IncludeSubdomains {
+			m.hsts += "; includeSubDomains"
+		}
+		if headers.HSTS.Preload {
+			m.hsts += "; preload"
+		}
 	}
+	return m
 }
 
 // SecurityHeaders returns a middleware that adds security headers.
 func (m *SecurityMiddleware) SecurityHeaders() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// Prevent clickjacking
-		c.Header("X-Frame-Options", "DENY")
+		if m.headers.FrameOptions != "" {
+			c.Header("X-Frame-Options", strings.ToUpper(m.headers.FrameOptions))
+		}
 		// Prevent MIME type sniffing
 		c.Header("X-Content-Type-Options", "nosniff")
 		// Enable XSS filter
 		c.Header("X-XSS-Protection", "1; mode=block")
 		// Referrer policy
-		c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
+		if m.headers.ReferrerPolicy != "" {
+			c.Header("Referrer-Policy", m.headers.ReferrerPolicy)
+		}
 		// Content Security Policy
-		c.Header("Content-Security-Policy", "default-src 'self'; script-src 'self' 'unsafe-inline'; style-src 'self' 'unsafe-inline'")
-		// Strict Transport Security (for HTTPS)
-		c.Header("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		if m.headers.ContentSecurityPolicy != "" {
+			c.Header("Content-Security-Policy", m.headers.ContentSecurityPolicy)
+		}
+		// Strict Transport Security, only meaningful over HTTPS
+		if m.hsts != "" && IsHTTPS(c.Request) {
+			c.Header("Strict-Transport-Security", m.hsts)
+		}
 		// Permissions Policy
 		c.Header("Permissions-Policy", "geolocation=(), microphone=(), camera=()")
 
@@ -46,6 +72,54 @@ func (m *SecurityMiddleware) SecurityHeaders() gin.HandlerFunc {
 	}
 }
 
+// HTTPSRedirect returns a middleware that redirects plain HTTP requests to
+// HTTPS, except for the excluded paths. GET and HEAD are redirected with
+// 301, other methods with 308 so that clients repeat the body.
+func HTTPSRedirect(config common.HTTPSRedirectConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.Enabled || IsHTTPS(c.Request) || excludedPath(c.Request.URL.Path, config.Exclude) {
+			c.Next()
+			return
+		}
+
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+			if strings.Contains(host, ":") {
+				host = "[" + host + "]"
+			}
+		}
+		if config.Port != 0 && config.Port != 443 {
+			host += ":" + strconv.Itoa(config.Port)
+		}
+		target := "https://" + host + c.Request.URL.RequestURI()
+
+		status := http.StatusPermanentRedirect
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		c.Redirect(status, target)
+		c.Abort()
+	}
+}
+
+// IsHTTPS reports whether a request arrived over TLS, directly or through
+// a reverse proxy that sets X-Forwarded-Proto.
+func IsHTTPS(r *http.Request) bool {
+	return r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// excludedPath reports whether path is one of prefixes or below one.
+func excludedPath(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		prefix = strings.TrimSuffix(prefix, "/")
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
 // CSRF returns a middleware that provides CSRF protection.
 func (m *SecurityMiddleware) CSRF() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -73,18 +147,12 @@ func (m *SecurityMiddleware) CSRF() gin.HandlerFunc {
 		}
 
 		if token == "" {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"error": "缺少CSRF令牌",
-				"code":  "csrf_missing",
-			})
+			common.AbortWithError(c, http.StatusForbidden, common.ErrCSRFMissing, "缺少CSRF令牌", nil)
 			return
 		}
 
 		if !m.validateCSRFToken(token) {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
-				"error": "CSRF令牌无效",
-				"code":  "csrf_invalid",
-			})
+			common.AbortWithError(c, http.StatusForbidden, common.ErrCSRFInvalid, "CSRF令牌无效", nil)
 			return
 		}
 
@@ -156,11 +224,7 @@ func (r *RateLimiter) RateLimit() gin.HandlerFunc {
 
 		// Check rate limit
 		if len(r.requests[ip]) >= r.limit {
-			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
-				"error":       "请求过于频繁",
-				"code":        "rate_limit_exceeded",
-				"retry_after": r.window.Seconds(),
-			})
+			common.AbortWithError(c, http.StatusTooManyRequests, common.ErrRateLimited, "请求过于频繁", gin.H{"retry_after": r.window.Seconds()})
 			return
 		}
 
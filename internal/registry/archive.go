@@ -0,0 +1,754 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/pkg/compression"
+)
+
+// Archive formats supported by PrepareExport and ImportArchive.
+const (
+	ArchiveFormatDocker = "docker" // `docker save` / `docker load` tar
+	ArchiveFormatOCI    = "oci"    // OCI image layout tar
+)
+
+const (
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIConfig          = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer           = "application/vnd.oci.image.layer.v1.tar"
+
+	annotationRefName   = "org.opencontainers.image.ref.name"
+	annotationImageName = "io.containerd.image.name"
+)
+
+// ociDescriptor is a content descriptor in manifests and indexes.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ociManifest covers both image manifests and indexes.
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType,omitempty"`
+	Config        *ociDescriptor  `json:"config,omitempty"`
+	Layers        []ociDescriptor `json:"layers,omitempty"`
+	Manifests     []ociDescriptor `json:"manifests,omitempty"`
+}
+
+// isIndex reports whether the manifest is a manifest list or image index.
+func (m *ociManifest) isIndex() bool {
+	return m.MediaType == mediaTypeOCIIndex || m.MediaType == mediaTypeDockerManifestList || len(m.Manifests) > 0
+}
+
+// dockerArchiveEntry is one element of a docker-archive manifest.json.
+type dockerArchiveEntry struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// archiveFile is a file in an export, taken from a blob or from memory.
+type archiveFile struct {
+	name   string
+	digest string
+	data   []byte
+}
+
+// ImageArchive is an export whose content has been checked to be present
+// locally, so errors can be reported before anything is streamed.
+type ImageArchive struct {
+	storage *Storage
+	files   []archiveFile
+}
+
+// PrepareExport resolves every blob of name:tag for export in format.
+func (s *Service) PrepareExport(name, tag, format string) (*ImageArchive, error) {
+	data, image, err := s.PullManifest(name, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest format: %w", err)
+	}
+
+	archive := &ImageArchive{storage: s.storage}
+	switch format {
+	case "", ArchiveFormatDocker:
+		err = s.planDockerArchive(archive, name+":"+tag, &manifest)
+	case ArchiveFormatOCI:
+		err = s.planOCILayout(archive, name, tag, image.Digest, data, &manifest)
+	default:
+		err = fmt.Errorf("invalid archive format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+// planDockerArchive lays out a single-platform image the way `docker save` does.
+func (s *Service) planDockerArchive(archive *ImageArchive, ref string, manifest *ociManifest) error {
+	if manifest.isIndex() {
+		return fmt.Errorf("invalid archive format: multi-platform images can only be exported as %s", ArchiveFormatOCI)
+	}
+	if manifest.Config == nil {
+		return fmt.Errorf("invalid manifest format: missing config")
+	}
+
+	entry := dockerArchiveEntry{RepoTags: []string{ref}}
+	if err := s.requireBlob(manifest.Config.Digest); err != nil {
+		return err
+	}
+	entry.Config = digestHex(manifest.Config.Digest) + ".json"
+	archive.files = append(archive.files, archiveFile{name: entry.Config, digest: manifest.Config.Digest})
+
+	for _, layer := range manifest.Layers {
+		if err := s.requireBlob(layer.Digest); err != nil {
+			return err
+		}
+		// docker load detects layer compression itself
+		name := digestHex(layer.Digest) + "/layer.tar"
+		entry.Layers = append(entry.Layers, name)
+		archive.files = append(archive.files, archiveFile{name: name, digest: layer.Digest})
+	}
+
+	index, err := json.Marshal([]dockerArchiveEntry{entry})
+	if err != nil {
+		return err
+	}
+	archive.files = append(archive.files, archiveFile{name: "manifest.json", data: index})
+	return nil
+}
+
+// planOCILayout lays out the image, including every platform of an index,
+// as an OCI image layout.
+func (s *Service) planOCILayout(archive *ImageArchive, name, tag, digest string, data []byte, manifest *ociManifest) error {
+	seen := make(map[string]bool)
+	add := func(d string) error {
+		if seen[d] {
+			return nil
+		}
+		if err := s.requireBlob(d); err != nil {
+			return err
+		}
+		seen[d] = true
+		archive.files = append(archive.files, archiveFile{name: blobPathInLayout(d), digest: d})
+		return nil
+	}
+
+	var addManifest func(d string, m *ociManifest) error
+	addManifest = func(d string, m *ociManifest) error {
+		if err := add(d); err != nil {
+			return err
+		}
+		if m.Config != nil {
+			if err := add(m.Config.Digest); err != nil {
+				return err
+			}
+		}
+		for _, layer := range m.Layers {
+			if err := add(layer.Digest); err != nil {
+				return err
+			}
+		}
+		for _, child := range m.Manifests {
+			childData, err := s.readBlob(child.Digest)
+			if err != nil {
+				return fmt.Errorf("manifest blob not found: %s", child.Digest)
+			}
+			var childManifest ociManifest
+			if err := json.Unmarshal(childData, &childManifest); err != nil {
+				return fmt.Errorf("invalid manifest format: %w", err)
+			}
+			if err := addManifest(child.Digest, &childManifest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := addManifest(digest, manifest); err != nil {
+		return err
+	}
+
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = mediaTypeOCIManifest
+		if manifest.isIndex() {
+			mediaType = mediaTypeOCIIndex
+		}
+	}
+	index, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests: []ociDescriptor{{
+			MediaType: mediaType,
+			Digest:    digest,
+			Size:      int64(len(data)),
+			Annotations: map[string]string{
+				annotationImageName: name + ":" + tag,
+				annotationRefName:   tag,
+			},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	archive.files = append(archive.files,
+		archiveFile{name: "oci-layout", data: []byte(`{"imageLayoutVersion":"1.0.0"}`)},
+		archiveFile{name: "index.json", data: index},
+	)
+	return nil
+}
+
+// WriteTo streams the archive as an uncompressed tar.
+func (a *ImageArchive) WriteTo(w io.Writer) (int64, error) {
+	counter := &countingWriter{w: w}
+	tw := tar.NewWriter(counter)
+	now := time.Now()
+	dirs := make(map[string]bool)
+
+	for _, f := range a.files {
+		for dir := path.Dir(f.name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	for _, dir := range sortedKeys(dirs) {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0755, ModTime: now}); err != nil {
+			return counter.n, err
+		}
+	}
+
+	for _, f := range a.files {
+		if f.digest == "" {
+			if err := writeTarFile(tw, f.name, int64(len(f.data)), bytes.NewReader(f.data), now); err != nil {
+				return counter.n, err
+			}
+			continue
+		}
+
+		reader, size, err := a.storage.GetBlob(f.digest)
+		if err != nil {
+			return counter.n, fmt.Errorf("blob not found: %s", f.digest)
+		}
+		err = writeTarFile(tw, f.name, size, reader, now)
+		reader.Close()
+		if err != nil {
+			return counter.n, err
+		}
+	}
+
+	err := tw.Close()
+	return counter.n, err
+}
+
+// ImportArchive loads every image from a docker-archive or OCI layout tar,
+// optionally gzip-compressed. repository and tag override the names stored
+// in the archive; tag may only be overridden for single-image archives.
+// Blob digests are verified while storing. canPush is asked for each target
+// repository before anything is stored for it; a refusal returns
+// ErrPushDenied.
+func (s *Service) ImportArchive(r io.Reader, repository, tag string, canPush func(name string) bool) ([]*ImageManifest, error) {
+	dir, err := os.MkdirTemp("", "cyp-import-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout, err := extractArchive(r, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var images []*ImageManifest
+	if layout.exists("index.json") {
+		images, err = s.importOCILayout(layout, repository, tag, canPush)
+	} else if layout.exists("manifest.json") {
+		images, err = s.importDockerArchive(layout, repository, tag, canPush)
+	} else {
+		err = fmt.Errorf("invalid archive: neither index.json nor manifest.json found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.RefreshStorageUsage()
+	return images, nil
+}
+
+// importOCILayout imports each manifest referenced by index.json.
+func (s *Service) importOCILayout(layout *extractedArchive, repository, tag string, canPush func(string) bool) ([]*ImageManifest, error) {
+	var index ociManifest
+	if err := layout.readJSON("index.json", &index); err != nil {
+		return nil, err
+	}
+	if tag != "" && len(index.Manifests) > 1 {
+		return nil, fmt.Errorf("invalid request: tag override needs a single-image archive")
+	}
+
+	var images []*ImageManifest
+	for _, desc := range index.Manifests {
+		name, ref := parseArchiveRef(desc.Annotations[annotationImageName])
+		if name == "" {
+			name, ref = parseArchiveRef(desc.Annotations[annotationRefName])
+			if ref == "" && name != "" && !strings.Contains(name, "/") {
+				// ref.name is commonly just the tag
+				name, ref = "", name
+			}
+		}
+		if repository != "" {
+			name = repository
+		}
+		if tag != "" {
+			ref = tag
+		}
+		if ref == "" {
+			ref = "latest"
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid request: image %s has no name, specify a repository", desc.Digest)
+		}
+		if err := ValidateReference(name, ref); err != nil {
+			return nil, err
+		}
+		if !canPush(name) {
+			return nil, fmt.Errorf("%w: %s", ErrPushDenied, name)
+		}
+
+		data, err := s.importOCIManifest(layout, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		image, err := s.PushManifest(name, ref, data)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// importOCIManifest stores a manifest and everything it references, and
+// returns the manifest bytes.
+func (s *Service) importOCIManifest(layout *extractedArchive, digest string) ([]byte, error) {
+	data, err := layout.readBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest format: %w", err)
+	}
+
+	if manifest.Config != nil {
+		if err := s.importBlob(layout, manifest.Config.Digest); err != nil {
+			return nil, err
+		}
+	}
+	for _, layer := range manifest.Layers {
+		if err := s.importBlob(layout, layer.Digest); err != nil {
+			return nil, err
+		}
+	}
+	for _, child := range manifest.Manifests {
+		// Layouts may hold only some platforms of an index
+		if !layout.exists(blobPathInLayout(child.Digest)) {
+			continue
+		}
+		if _, err := s.importOCIManifest(layout, child.Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	// Store the manifest blob itself so child manifests resolve
+	if err := s.importBlob(layout, digest); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// importBlob copies a layout blob into storage, verifying its digest.
+func (s *Service) importBlob(layout *extractedArchive, digest string) error {
+	if s.storage.BlobExists(digest) {
+		return nil
+	}
+	file, err := layout.open(blobPathInLayout(digest))
+	if err != nil {
+		return fmt.Errorf("blob not found in archive: %s", digest)
+	}
+	defer file.Close()
+
+	return s.storeVerifiedBlob(file, digest)
+}
+
+// importDockerArchive converts each manifest.json entry to an OCI manifest.
+func (s *Service) importDockerArchive(layout *extractedArchive, repository, tag string, canPush func(string) bool) ([]*ImageManifest, error) {
+	var entries []dockerArchiveEntry
+	if err := layout.readJSON("manifest.json", &entries); err != nil {
+		return nil, err
+	}
+	if tag != "" && len(entries) > 1 {
+		return nil, fmt.Errorf("invalid request: tag override needs a single-image archive")
+	}
+
+	var images []*ImageManifest
+	for _, entry := range entries {
+		refs := entry.RepoTags
+		if repository != "" || tag != "" {
+			refs = []string{overrideRef(refs, repository, tag)}
+		}
+		if len(refs) == 0 || refs[0] == "" {
+			return nil, fmt.Errorf("invalid request: image %s has no tag, specify repository and tag", entry.Config)
+		}
+		for _, ref := range refs {
+			if name, _ := parseArchiveRef(ref); !canPush(name) {
+				return nil, fmt.Errorf("%w: %s", ErrPushDenied, name)
+			}
+		}
+
+		manifest := ociManifest{SchemaVersion: 2, MediaType: mediaTypeOCIManifest}
+		config, err := s.importFile(layout, entry.Config)
+		if err != nil {
+			return nil, err
+		}
+		config.MediaType = mediaTypeOCIConfig
+		manifest.Config = config
+
+		for _, name := range entry.Layers {
+			layer, err := s.importFile(layout, name)
+			if err != nil {
+				return nil, err
+			}
+			manifest.Layers = append(manifest.Layers, *layer)
+		}
+
+		data, err := json.Marshal(manifest)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range refs {
+			name, imageTag := parseArchiveRef(ref)
+			if imageTag == "" {
+				imageTag = "latest"
+			}
+			if err := ValidateReference(name, imageTag); err != nil {
+				return nil, err
+			}
+			image, err := s.PushManifest(name, imageTag, data)
+			if err != nil {
+				return nil, err
+			}
+			images = append(images, image)
+		}
+	}
+	return images, nil
+}
+
+// importFile stores an archive file as a blob and describes it.
+func (s *Service) importFile(layout *extractedArchive, name string) (*ociDescriptor, error) {
+	file, err := layout.open(name)
+	if err != nil {
+		return nil, fmt.Errorf("file not found in archive: %s", name)
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(file, header)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	digest, size, err := s.storage.SaveBlob(file)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType := mediaTypeOCILayer
+	switch compression.DetectAlgorithm(header[:n]) {
+	case compression.AlgorithmGzip:
+		mediaType += "+gzip"
+	case compression.AlgorithmZstd:
+		mediaType += "+zstd"
+	}
+	return &ociDescriptor{MediaType: mediaType, Digest: digest, Size: size}, nil
+}
+
+// storeVerifiedBlob saves data and rejects it if it does not match digest.
+func (s *Service) storeVerifiedBlob(data io.Reader, digest string) error {
+	actual, _, err := s.storage.SaveBlob(data)
+	if err != nil {
+		return err
+	}
+	if actual != digest {
+		s.storage.DeleteBlob(actual)
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return nil
+}
+
+// requireBlob returns an error if digest is not stored locally.
+func (s *Service) requireBlob(digest string) error {
+	if !s.storage.BlobExists(digest) {
+		return fmt.Errorf("blob not found: %s", digest)
+	}
+	return nil
+}
+
+// readBlob reads a small blob such as a manifest into memory.
+func (s *Service) readBlob(digest string) ([]byte, error) {
+	reader, _, err := s.storage.GetBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
+
+// extractedArchive is an archive unpacked into a temp directory.
+type extractedArchive struct {
+	root  string
+	links map[string]string // symlink entries, resolved within the archive
+}
+
+// extractArchive unpacks regular files of a tar stream into dir. Paths are
+// confined to dir; symlinks (used by `docker save` for duplicate layers)
+// are recorded and resolved on open.
+func extractArchive(r io.Reader, dir string) (*extractedArchive, error) {
+	buffered := bufio.NewReader(r)
+	var src io.Reader = buffered
+	if magic, _ := buffered.Peek(2); compression.DetectAlgorithm(magic) == compression.AlgorithmGzip {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	layout := &extractedArchive{root: dir, links: make(map[string]string)}
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %w", err)
+		}
+
+		name, ok := cleanArchivePath(header.Name)
+		if !ok {
+			return nil, fmt.Errorf("invalid archive: unsafe path %s", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeReg:
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to extract %s: %w", name, err)
+			}
+		case tar.TypeSymlink, tar.TypeLink:
+			linkname := header.Linkname
+			if header.Typeflag == tar.TypeSymlink {
+				linkname = path.Join(path.Dir(name), linkname)
+			}
+			if target, ok := cleanArchivePath(linkname); ok {
+				layout.links[name] = target
+			}
+		}
+	}
+	return layout, nil
+}
+
+// resolve follows recorded links to a file path inside the archive.
+func (a *extractedArchive) resolve(name string) string {
+	for i := 0; i < 8; i++ {
+		target, ok := a.links[name]
+		if !ok {
+			break
+		}
+		name = target
+	}
+	return filepath.Join(a.root, filepath.FromSlash(name))
+}
+
+func (a *extractedArchive) exists(name string) bool {
+	name, ok := cleanArchivePath(name)
+	if !ok {
+		return false
+	}
+	_, err := os.Stat(a.resolve(name))
+	return err == nil
+}
+
+func (a *extractedArchive) open(name string) (*os.File, error) {
+	name, ok := cleanArchivePath(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return os.Open(a.resolve(name))
+}
+
+func (a *extractedArchive) readJSON(name string, v interface{}) error {
+	file, err := a.open(name)
+	if err != nil {
+		return fmt.Errorf("invalid archive: %s not found", name)
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("invalid archive: failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+func (a *extractedArchive) readBlob(digest string) ([]byte, error) {
+	file, err := a.open(blobPathInLayout(digest))
+	if err != nil {
+		return nil, fmt.Errorf("blob not found in archive: %s", digest)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(digest, "sha256:") {
+		sum := sha256.Sum256(data)
+		if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != digest {
+			return nil, fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+		}
+	}
+	return data, nil
+}
+
+// cleanArchivePath normalizes an archive member name and rejects names
+// escaping the archive root.
+func cleanArchivePath(name string) (string, bool) {
+	name = path.Clean("/" + strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || name == "." {
+		return "", false
+	}
+	return name, true
+}
+
+// blobPathInLayout returns the OCI layout path of a blob.
+func blobPathInLayout(digest string) string {
+	return "blobs/" + strings.Replace(digest, ":", "/", 1)
+}
+
+// digestHex strips the algorithm prefix from a digest.
+func digestHex(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 {
+		return digest[i+1:]
+	}
+	return digest
+}
+
+// parseArchiveRef splits an image reference such as
+// "docker.io/library/nginx:1.25" into a local repository name and tag.
+// The registry host, if any, is dropped.
+func parseArchiveRef(ref string) (name, tag string) {
+	if i := strings.Index(ref, "@"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref, tag = ref[:i], ref[i+1:]
+	}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		host := ref[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			ref = ref[i+1:]
+		}
+	}
+	return ref, tag
+}
+
+// overrideRef applies repository and tag overrides to the first archive tag.
+func overrideRef(refs []string, repository, tag string) string {
+	var name, ref string
+	if len(refs) > 0 {
+		name, ref = parseArchiveRef(refs[0])
+	}
+	if repository != "" {
+		name = repository
+	}
+	if tag != "" {
+		ref = tag
+	}
+	if name == "" {
+		return ""
+	}
+	if ref == "" {
+		ref = "latest"
+	}
+	return name + ":" + ref
+}
+
+// writeTarFile writes one regular file entry.
+func writeTarFile(tw *tar.Writer, name string, size int64, r io.Reader, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(tw, r, size)
+	return err
+}
+
+// countingWriter counts bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// sortedKeys returns the keys of m in order; parents sort before children.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,112 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Artifact categories shown in listings and accepted by the type filter.
+const (
+	CategoryImage       = "image"
+	CategoryHelm        = "helm"
+	CategoryWasm        = "wasm"
+	CategorySBOM        = "sbom"
+	CategoryAttestation = "attestation"
+	CategorySignature   = "signature"
+	CategoryArtifact    = "artifact" // any other OCI artifact
+)
+
+// ArtifactCategories lists the categories in display order.
+var ArtifactCategories = []string{
+	CategoryImage, CategoryHelm, CategoryWasm, CategorySBOM,
+	CategoryAttestation, CategorySignature, CategoryArtifact,
+}
+
+const (
+	mediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+	mediaTypeOCIEmpty     = "application/vnd.oci.empty.v1+json"
+)
+
+// manifestArtifactType returns the artifact type of a manifest, empty for
+// container images: the artifactType field if set, otherwise the config
+// media type unless it is an image or empty config, otherwise the first
+// layer that is not a filesystem layer. Cosign signatures and attestations
+// use an image config with JSON layers, ORAS an empty config.
+func manifestArtifactType(artifactType, configMediaType string, layerMediaTypes []string) string {
+	if artifactType != "" {
+		return artifactType
+	}
+	switch configMediaType {
+	case "", mediaTypeOCIConfig, mediaTypeDockerConfig, mediaTypeOCIEmpty:
+	default:
+		return configMediaType
+	}
+	for _, mediaType := range layerMediaTypes {
+		if !isFilesystemLayer(mediaType) {
+			return mediaType
+		}
+	}
+	if configMediaType == mediaTypeOCIEmpty {
+		return configMediaType
+	}
+	return ""
+}
+
+// isFilesystemLayer reports whether a layer media type is a container
+// filesystem layer, including foreign and non-distributable layers.
+func isFilesystemLayer(mediaType string) bool {
+	return mediaType == "" ||
+		strings.HasPrefix(mediaType, "application/vnd.oci.image.layer.") ||
+		strings.HasPrefix(mediaType, "application/vnd.docker.image.rootfs.")
+}
+
+// ArtifactCategory maps an artifact type to its category.
+func ArtifactCategory(artifactType string) string {
+	t := strings.ToLower(artifactType)
+	switch {
+	case t == "":
+		return CategoryImage
+	case t == MediaTypeHelmConfig:
+		return CategoryHelm
+	case strings.Contains(t, "wasm"):
+		return CategoryWasm
+	case strings.Contains(t, "spdx"), strings.Contains(t, "cyclonedx"), strings.Contains(t, "syft"):
+		return CategorySBOM
+	case strings.Contains(t, "in-toto"), strings.Contains(t, "dsse"), strings.Contains(t, "slsa"):
+		return CategoryAttestation
+	case strings.Contains(t, "cosign.simplesigning"), strings.Contains(t, "notary.signature"):
+		return CategorySignature
+	}
+	return CategoryArtifact
+}
+
+// ParseArtifactCategories parses type filters; each value may hold several
+// comma-separated categories. It returns nil when no filter is given.
+func ParseArtifactCategories(values []string) (map[string]bool, error) {
+	var categories map[string]bool
+	for _, value := range values {
+		for _, c := range strings.Split(value, ",") {
+			c = strings.ToLower(strings.TrimSpace(c))
+			if c == "" {
+				continue
+			}
+			if !isArtifactCategory(c) {
+				return nil, fmt.Errorf("invalid type %q, must be one of %s", c, strings.Join(ArtifactCategories, ", "))
+			}
+			if categories == nil {
+				categories = make(map[string]bool)
+			}
+			categories[c] = true
+		}
+	}
+	return categories, nil
+}
+
+func isArtifactCategory(category string) bool {
+	for _, c := range ArtifactCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
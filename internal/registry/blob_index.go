@@ -0,0 +1,95 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"time"
+)
+
+// blobIndexTTL controls how long the digest -> repositories index is reused.
+const blobIndexTTL = time.Minute
+
+// RepositoriesForBlob returns the repositories that reference the given
+// digest, either as a manifest, a layer or an image config.
+func (s *Service) RepositoriesForBlob(digest string) []string {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if s.blobIndex == nil || time.Since(s.blobIndexAt) > blobIndexTTL {
+		index, err := s.buildBlobIndex()
+		if err != nil {
+			return nil
+		}
+		s.blobIndex = index
+		s.blobIndexAt = time.Now()
+	}
+
+	return s.blobIndex[digest]
+}
+
+// buildBlobIndex scans image metadata and maps every referenced digest to
+// the repositories using it.
+func (s *Service) buildBlobIndex() (map[string][]string, error) {
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make(map[string]map[string]bool)
+	add := func(digest, name string) {
+		if digest == "" {
+			return
+		}
+		if refs[digest] == nil {
+			refs[digest] = make(map[string]bool)
+		}
+		refs[digest][name] = true
+	}
+
+	for name, tags := range store.Images {
+		for _, info := range tags {
+			add(info.Digest, name)
+			for _, layer := range info.Layers {
+				add(layer.Digest, name)
+			}
+			add(s.manifestConfigDigest(info.Digest), name)
+		}
+	}
+
+	index := make(map[string][]string, len(refs))
+	for digest, names := range refs {
+		list := make([]string, 0, len(names))
+		for name := range names {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		index[digest] = list
+	}
+	return index, nil
+}
+
+// manifestConfigDigest reads the config digest from a stored manifest blob.
+func (s *Service) manifestConfigDigest(digest string) string {
+	reader, _, err := s.storage.GetBlob(digest)
+	if err != nil {
+		return ""
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return ""
+	}
+
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return ""
+	}
+	return manifest.Config.Digest
+}
@@ -0,0 +1,314 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// blobLinkTTL is how long an uploaded or mounted blob stays referenced by
+// its repository without a tag pointing at it, enough for a push to upload
+// its layers and then the manifest.
+const blobLinkTTL = DefaultGCMinAge
+
+// ErrBlobUnknown is returned when a repository does not reference a blob.
+var ErrBlobUnknown = errors.New("blob unknown to repository")
+
+// NamespaceRefs is the stored blob reference index of one namespace. Blobs
+// are stored once for all tenants, so the index records which namespaces
+// hold each blob: accounting and GC read it, and a blob file is only
+// removed once no namespace references it.
+type NamespaceRefs struct {
+	// Blobs maps digest -> repository -> tags and recycle bin entries of
+	// the repository whose image contains the blob
+	Blobs map[string]map[string]int `json:"blobs,omitempty"`
+	// Links maps digest -> repository -> when the blob was uploaded or
+	// mounted into the repository, see blobLinkTTL
+	Links map[string]map[string]time.Time `json:"links,omitempty"`
+}
+
+// repositoryReferences reports whether repository holds digest through a
+// tag or a link that has not expired.
+func (n *NamespaceRefs) repositoryReferences(repository, digest string, now time.Time) bool {
+	if n == nil {
+		return false
+	}
+	if n.Blobs[digest][repository] > 0 {
+		return true
+	}
+	at, ok := n.Links[digest][repository]
+	return ok && now.Sub(at) < blobLinkTTL
+}
+
+// references reports whether any repository of the namespace holds digest.
+func (n *NamespaceRefs) references(digest string, now time.Time) bool {
+	if n == nil {
+		return false
+	}
+	if len(n.Blobs[digest]) > 0 {
+		return true
+	}
+	for _, at := range n.Links[digest] {
+		if now.Sub(at) < blobLinkTTL {
+			return true
+		}
+	}
+	return false
+}
+
+// imageRefs returns how many tags and recycle bin entries of the namespace
+// contain digest.
+func (n *NamespaceRefs) imageRefs(digest string) int {
+	refs := 0
+	for _, count := range n.Blobs[digest] {
+		refs += count
+	}
+	return refs
+}
+
+// blobReferenced reports whether any namespace of store holds digest.
+func (store *ImageStore) blobReferenced(digest string, now time.Time) bool {
+	for _, refs := range store.Namespaces {
+		if refs.references(digest, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// needsBlobIndex reports whether the reference index of store is missing
+// or lacks tags, e.g. metadata written before the index existed.
+func (store *ImageStore) needsBlobIndex() bool {
+	if store.Namespaces == nil && (len(store.Images) > 0 || len(store.Trash) > 0) {
+		return true
+	}
+	for _, tags := range store.Images {
+		for _, info := range tags {
+			if info.Blobs == nil {
+				return true
+			}
+		}
+	}
+	for _, entry := range store.Trash {
+		if entry.Image != nil && entry.Image.Blobs == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// indexBlobRefs rebuilds the reference index of store from its tags and
+// recycle bin, keeping the links that have not expired. Tags saved since
+// the last rebuild first get the blobs of their image from the manifest.
+func (s *Storage) indexBlobRefs(store *ImageStore, now time.Time) {
+	index := make(map[string]*NamespaceRefs)
+	namespace := func(repository string) *NamespaceRefs {
+		name := repositoryNamespace(repository)
+		refs, ok := index[name]
+		if !ok {
+			refs = &NamespaceRefs{Blobs: make(map[string]map[string]int)}
+			index[name] = refs
+		}
+		return refs
+	}
+	add := func(repository string, info *TagInfo) {
+		if info == nil {
+			return
+		}
+		if info.Blobs == nil {
+			info.Blobs = s.imageBlobs(info)
+		}
+		refs := namespace(repository)
+		for _, digest := range info.Blobs {
+			if refs.Blobs[digest] == nil {
+				refs.Blobs[digest] = make(map[string]int)
+			}
+			refs.Blobs[digest][repository]++
+		}
+	}
+
+	for name, tags := range store.Images {
+		for _, info := range tags {
+			add(name, info)
+		}
+	}
+	for _, entry := range store.Trash {
+		add(entry.Name, entry.Image)
+	}
+	for _, old := range store.Namespaces {
+		for digest, repos := range old.Links {
+			for repository, at := range repos {
+				if now.Sub(at) >= blobLinkTTL {
+					continue
+				}
+				refs := namespace(repository)
+				if refs.Links == nil {
+					refs.Links = make(map[string]map[string]time.Time)
+				}
+				if refs.Links[digest] == nil {
+					refs.Links[digest] = make(map[string]time.Time)
+				}
+				refs.Links[digest][repository] = at
+			}
+		}
+	}
+	store.Namespaces = index
+}
+
+// saveBlobIndex writes the metadata once in the background so that an
+// index rebuilt on load is stored and not rebuilt on every read.
+func (s *Storage) saveBlobIndex() {
+	if !s.indexing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.indexing.Store(false)
+		unlock, err := s.lockMetadata()
+		if err != nil {
+			return
+		}
+		defer unlock()
+		if store, err := s.loadMetadataUnsafe(); err == nil {
+			_ = s.saveMetadataUnsafe(store)
+		}
+	}()
+}
+
+// imageBlobs returns every digest an image holds: the manifest, its config
+// and layers, and for a manifest list or index the platform manifests with
+// their configs and layers.
+func (s *Storage) imageBlobs(info *TagInfo) []string {
+	blobs := make(map[string]bool)
+	for _, layer := range info.Layers {
+		if layer.Digest != "" {
+			blobs[layer.Digest] = true
+		}
+	}
+	s.manifestBlobs(info.Digest, blobs)
+
+	digests := make([]string, 0, len(blobs))
+	for digest := range blobs {
+		digests = append(digests, digest)
+	}
+	sort.Strings(digests)
+	return digests
+}
+
+// manifestBlobs adds a manifest blob and everything it references to
+// blobs, following the children of manifest lists and OCI indexes.
+func (s *Storage) manifestBlobs(digest string, blobs map[string]bool) {
+	if digest == "" || blobs[digest] {
+		return
+	}
+	blobs[digest] = true
+
+	reader, _, err := s.GetBlob(digest)
+	if err != nil {
+		return
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return
+	}
+
+	var manifest struct {
+		Config struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return
+	}
+	if manifest.Config.Digest != "" {
+		blobs[manifest.Config.Digest] = true
+	}
+	for _, layer := range manifest.Layers {
+		if layer.Digest != "" {
+			blobs[layer.Digest] = true
+		}
+	}
+	for _, child := range manifest.Manifests {
+		s.manifestBlobs(child.Digest, blobs)
+	}
+}
+
+// LinkBlob records that a blob was uploaded or mounted into repository, so
+// it stays referenced for blobLinkTTL while the push finishes its manifest.
+func (s *Storage) LinkBlob(repository, digest string) error {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return err
+	}
+
+	if store.Namespaces == nil {
+		store.Namespaces = make(map[string]*NamespaceRefs)
+	}
+	namespace := repositoryNamespace(repository)
+	refs := store.Namespaces[namespace]
+	if refs == nil {
+		refs = &NamespaceRefs{}
+		store.Namespaces[namespace] = refs
+	}
+	if refs.Links == nil {
+		refs.Links = make(map[string]map[string]time.Time)
+	}
+	if refs.Links[digest] == nil {
+		refs.Links[digest] = make(map[string]time.Time)
+	}
+	refs.Links[digest][repository] = time.Now().UTC()
+
+	return s.saveMetadataUnsafe(store)
+}
+
+// UnlinkBlob drops the link of repository to a blob and deletes the blob
+// file once no namespace references it. Tags of the repository keep their
+// references, so their images stay pullable. It returns ErrBlobUnknown when
+// the repository does not reference the blob.
+func (s *Storage) UnlinkBlob(repository, digest string) error {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	refs := store.Namespaces[repositoryNamespace(repository)]
+	if !refs.repositoryReferences(repository, digest, now) {
+		return ErrBlobUnknown
+	}
+	if links := refs.Links[digest]; links != nil {
+		delete(links, repository)
+		if len(links) == 0 {
+			delete(refs.Links, digest)
+		}
+	}
+	if err := s.saveMetadataUnsafe(store); err != nil {
+		return err
+	}
+
+	if store.blobReferenced(digest, now) {
+		return nil
+	}
+	return s.DeleteBlob(digest)
+}
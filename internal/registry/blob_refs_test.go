@@ -0,0 +1,168 @@
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	storage, err := NewStorage(t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(storage)
+	s.SetTrashRetention(0)
+	return s
+}
+
+func testDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// pushTestImage uploads a config and the given layer to repository and
+// pushes a manifest referencing them as repository:tag.
+func pushTestImage(t *testing.T, s *Service, repository, tag string, layer []byte) {
+	t.Helper()
+	config := []byte(fmt.Sprintf(`{"architecture":"amd64","os":"linux","repo":%q}`, repository))
+	for _, blob := range [][]byte{config, layer} {
+		if _, err := s.PushBlobWithDigest(repository, testDigest(blob), bytes.NewReader(blob)); err != nil {
+			t.Fatalf("push blob to %s: %v", repository, err)
+		}
+	}
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json",`+
+		`"config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":%q,"size":%d},`+
+		`"layers":[{"mediaType":"application/vnd.oci.image.layer.v1.tar+gzip","digest":%q,"size":%d}]}`,
+		testDigest(config), len(config), testDigest(layer), len(layer))
+	if _, err := s.PushManifest(repository, tag, []byte(manifest)); err != nil {
+		t.Fatalf("push manifest %s:%s: %v", repository, tag, err)
+	}
+}
+
+func pullTestBlob(t *testing.T, s *Service, digest string) []byte {
+	t.Helper()
+	reader, _, err := s.PullBlob(digest)
+	if err != nil {
+		t.Fatalf("pull %s: %v", digest, err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDeleteSharedBlobKeepsOtherNamespace(t *testing.T) {
+	s := newTestService(t)
+	layer := []byte("layer shared by alice and bob")
+	digest := testDigest(layer)
+	pushTestImage(t, s, "alice/app", "v1", layer)
+	pushTestImage(t, s, "bob/app", "v1", layer)
+
+	// Alice drops her image and then the layer itself
+	if err := s.DeleteImage("alice/app", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBlob("alice/app", digest); err != nil {
+		t.Fatalf("alice delete blob: %v", err)
+	}
+
+	if got := pullTestBlob(t, s, digest); !bytes.Equal(got, layer) {
+		t.Fatalf("bob pulled %q, want %q", got, layer)
+	}
+	if _, _, err := s.PullManifest("bob/app", "v1"); err != nil {
+		t.Fatalf("bob pull manifest: %v", err)
+	}
+	if !s.GetStorage().RepositoryHasBlob("bob/app", digest) {
+		t.Error("bob/app lost its reference to the layer")
+	}
+	if err := s.DeleteBlob("alice/app", digest); !errors.Is(err, ErrBlobUnknown) {
+		t.Errorf("second alice delete = %v, want ErrBlobUnknown", err)
+	}
+
+	// Once bob lets go too, the file is removed
+	if err := s.DeleteImage("bob/app", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteBlob("bob/app", digest); err != nil {
+		t.Fatalf("bob delete blob: %v", err)
+	}
+	if s.BlobExists(digest) {
+		t.Error("layer still stored after every namespace deleted it")
+	}
+}
+
+func TestBlobRefsArePersisted(t *testing.T) {
+	s := newTestService(t)
+	layer := []byte("persisted layer")
+	digest := testDigest(layer)
+	pushTestImage(t, s, "alice/app", "v1", layer)
+	pushTestImage(t, s, "alice/app", "v2", layer)
+	pushTestImage(t, s, "bob/app", "v1", layer)
+
+	// A fresh storage reads the index from the metadata file
+	reopened, err := NewStorage(s.GetStorage().GetBlobPath(), s.GetStorage().GetMetaPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := reopened.LoadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Namespaces["alice"].Blobs[digest]["alice/app"]; got != 2 {
+		t.Errorf("alice refs = %d, want 2", got)
+	}
+	if got := store.Namespaces["bob"].Blobs[digest]["bob/app"]; got != 1 {
+		t.Errorf("bob refs = %d, want 1", got)
+	}
+
+	blobs, ok, err := NewService(reopened).NamespaceBlobs("alice")
+	if err != nil || !ok {
+		t.Fatalf("NamespaceBlobs = %v, %v", ok, err)
+	}
+	for _, blob := range blobs {
+		if blob.Digest == digest && (!blob.Shared || blob.Refs != 2) {
+			t.Errorf("layer = %+v, want shared with 2 refs", blob)
+		}
+	}
+}
+
+func TestDeleteBlobRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newTestService(t)
+	layer := []byte("layer uploaded to app, used by bob")
+	digest := testDigest(layer)
+	pushTestImage(t, s, "bob/app", "v1", layer)
+	if _, err := s.PushBlobWithDigest("app", digest, bytes.NewReader(layer)); err != nil {
+		t.Fatal(err)
+	}
+
+	engine := gin.New()
+	NewHandler(s).RegisterRoutes(engine.Group("/v2"), engine.Group("/api"))
+	del := func(repository string) int {
+		w := httptest.NewRecorder()
+		engine.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v2/"+repository+"/blobs/"+digest, nil))
+		return w.Code
+	}
+
+	if code := del("other"); code != http.StatusNotFound {
+		t.Errorf("delete from a repository without the blob = %d, want 404", code)
+	}
+	if code := del("app"); code != http.StatusAccepted {
+		t.Errorf("delete from app = %d, want 202", code)
+	}
+	if got := pullTestBlob(t, s, digest); !bytes.Equal(got, layer) {
+		t.Fatalf("bob pulled %q, want %q", got, layer)
+	}
+}
@@ -0,0 +1,192 @@
+package registry
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/updater"
+)
+
+// MediaTypeHelmConfig is the config media type of Helm charts stored as OCI
+// artifacts.
+const MediaTypeHelmConfig = "application/vnd.cncf.helm.config.v1+json"
+
+// ChartMaintainer is a maintainer listed in Chart.yaml.
+type ChartMaintainer struct {
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	URL   string `json:"url,omitempty"`
+}
+
+// ChartDependency is a dependency listed in Chart.yaml.
+type ChartDependency struct {
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Repository string `json:"repository,omitempty"`
+}
+
+// ChartMetadata is the Chart.yaml content Helm stores in the config blob.
+type ChartMetadata struct {
+	APIVersion   string            `json:"apiVersion,omitempty"`
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	AppVersion   string            `json:"appVersion,omitempty"`
+	KubeVersion  string            `json:"kubeVersion,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Type         string            `json:"type,omitempty"`
+	Home         string            `json:"home,omitempty"`
+	Icon         string            `json:"icon,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty"`
+	Sources      []string          `json:"sources,omitempty"`
+	Maintainers  []ChartMaintainer `json:"maintainers,omitempty"`
+	Dependencies []ChartDependency `json:"dependencies,omitempty"`
+	Deprecated   bool              `json:"deprecated,omitempty"`
+}
+
+// ChartVersion is one pushed version (tag) of a chart.
+type ChartVersion struct {
+	Tag       string         `json:"tag"`
+	Digest    string         `json:"digest"`
+	Size      int64          `json:"size"`
+	CreatedAt time.Time      `json:"created_at"`
+	PushedBy  string         `json:"pushed_by,omitempty"`
+	PullCount int64          `json:"pull_count"`
+	Metadata  *ChartMetadata `json:"metadata,omitempty"`
+}
+
+// Chart is a repository holding Helm charts, with its versions newest
+// first and the metadata of the newest version.
+type Chart struct {
+	Repository string          `json:"repository"`
+	Latest     *ChartMetadata  `json:"latest,omitempty"`
+	Versions   []*ChartVersion `json:"versions"`
+	PullCount  int64           `json:"pull_count"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ListByArtifactType returns the tags whose artifact type is artifactType.
+func (s *Storage) ListByArtifactType(artifactType string) ([]*ImageManifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []*ImageManifest
+	for name, tags := range store.Images {
+		for tag, info := range tags {
+			if info.ArtifactType == artifactType {
+				images = append(images, s.imageFromTag(name, tag, info))
+			}
+		}
+	}
+	return images, nil
+}
+
+// ListCharts returns the Helm charts whose repository or chart name
+// contains keyword. include, when not nil, filters repositories.
+func (s *Service) ListCharts(keyword string, include func(repository string) bool) ([]*Chart, error) {
+	images, err := s.storage.ListByArtifactType(MediaTypeHelmConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	byRepo := make(map[string]*Chart)
+	for _, img := range images {
+		if include != nil && !include(img.Name) {
+			continue
+		}
+		chart := byRepo[img.Name]
+		if chart == nil {
+			chart = &Chart{Repository: img.Name}
+			byRepo[img.Name] = chart
+		}
+		chart.Versions = append(chart.Versions, &ChartVersion{
+			Tag:       img.Tag,
+			Digest:    img.Digest,
+			Size:      img.Size,
+			CreatedAt: img.CreatedAt,
+			PushedBy:  img.PushedBy,
+			PullCount: img.PullCount,
+			Metadata:  s.chartMetadata(img.Digest),
+		})
+		chart.PullCount += img.PullCount
+		if img.CreatedAt.After(chart.UpdatedAt) {
+			chart.UpdatedAt = img.CreatedAt
+		}
+	}
+
+	charts := make([]*Chart, 0, len(byRepo))
+	for _, chart := range byRepo {
+		sort.Slice(chart.Versions, func(i, j int) bool {
+			return updater.CompareVersions(chartVersion(chart.Versions[i]), chartVersion(chart.Versions[j])) > 0
+		})
+		chart.Latest = chart.Versions[0].Metadata
+
+		if keyword != "" && !containsIgnoreCase(chart.Repository, keyword) &&
+			(chart.Latest == nil || !containsIgnoreCase(chart.Latest.Name, keyword)) {
+			continue
+		}
+		charts = append(charts, chart)
+	}
+	sort.Slice(charts, func(i, j int) bool {
+		return charts[i].Repository < charts[j].Repository
+	})
+	return charts, nil
+}
+
+// GetChart returns one chart repository, or nil if it holds no charts.
+func (s *Service) GetChart(repository string) (*Chart, error) {
+	charts, err := s.ListCharts("", func(name string) bool { return name == repository })
+	if err != nil || len(charts) == 0 {
+		return nil, err
+	}
+	return charts[0], nil
+}
+
+// chartVersion is the version a chart version sorts by: the Chart.yaml
+// version, or the tag when the metadata is unreadable.
+func chartVersion(v *ChartVersion) string {
+	if v.Metadata != nil && v.Metadata.Version != "" {
+		return v.Metadata.Version
+	}
+	return v.Tag
+}
+
+// chartMetadata reads the Chart.yaml metadata of a chart manifest. Manifests
+// are immutable, so the result is cached by digest.
+func (s *Service) chartMetadata(digest string) *ChartMetadata {
+	if cached, ok := s.charts.Load(digest); ok {
+		return cached.(*ChartMetadata)
+	}
+
+	data, err := s.readBlob(digest)
+	if err != nil {
+		return nil
+	}
+	var manifest struct {
+		Config struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Config.MediaType != MediaTypeHelmConfig {
+		return nil
+	}
+	config, err := s.readBlob(manifest.Config.Digest)
+	if err != nil {
+		return nil
+	}
+	meta := &ChartMetadata{}
+	if err := json.Unmarshal(config, meta); err != nil {
+		return nil
+	}
+	meta.Name = strings.TrimSpace(meta.Name)
+
+	s.charts.Store(digest, meta)
+	return meta
+}
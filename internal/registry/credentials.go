@@ -12,6 +12,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -19,8 +20,15 @@ import (
 const (
 	// EncryptedPrefix is the prefix for encrypted values.
 	EncryptedPrefix = "encrypted:"
-	// DefaultEncryptionKey is used when no key is provided (should be overridden in production).
+	// DefaultEncryptionKey was used by earlier versions when no key was
+	// provided. It is only kept to migrate credentials encrypted with it.
 	DefaultEncryptionKey = "cyp-docker-registry-default-key!"
+	// MasterKeyEnv names the environment variable holding the master key.
+	MasterKeyEnv = "CYP_CREDENTIAL_KEY"
+	// MaskedPassword replaces passwords in every API response.
+	MaskedPassword = "********"
+
+	masterKeyFile = "credentials.key"
 )
 
 // Credential represents a stored credential for a registry.
@@ -43,7 +51,17 @@ type CredentialManager struct {
 	mu            sync.RWMutex
 }
 
-// NewCredentialManager creates a new CredentialManager.
+// MasterKeyPath returns the path of the generated master key file of a
+// credential store.
+func MasterKeyPath(storagePath string) string {
+	return filepath.Join(storagePath, masterKeyFile)
+}
+
+// NewCredentialManager creates a new CredentialManager. The master key is
+// taken from encryptionKey, then the CYP_CREDENTIAL_KEY environment
+// variable, then a key file in storagePath that is generated on first use.
+// Credentials stored in plaintext or with the legacy default key are
+// re-encrypted with the master key.
 func NewCredentialManager(storagePath string, encryptionKey string) (*CredentialManager, error) {
 	if err := os.MkdirAll(storagePath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create credential storage directory: %w", err)
@@ -51,16 +69,90 @@ func NewCredentialManager(storagePath string, encryptionKey string) (*Credential
 
 	key := encryptionKey
 	if key == "" {
-		key = DefaultEncryptionKey
+		key = os.Getenv(MasterKeyEnv)
+	}
+	if key == "" {
+		var err error
+		if key, err = loadOrCreateMasterKey(MasterKeyPath(storagePath)); err != nil {
+			return nil, err
+		}
+	}
+
+	cm := &CredentialManager{
+		storagePath:   storagePath,
+		encryptionKey: deriveKey(key),
+	}
+	if err := cm.migrate(); err != nil {
+		return nil, err
 	}
 
-	// Derive a 32-byte key using SHA-256
+	return cm, nil
+}
+
+// deriveKey derives a 32-byte AES key using SHA-256.
+func deriveKey(key string) []byte {
 	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
 
-	return &CredentialManager{
-		storagePath:   storagePath,
-		encryptionKey: hash[:],
-	}, nil
+// loadOrCreateMasterKey reads the master key file, generating a random key
+// when it does not exist yet.
+func loadOrCreateMasterKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if key := strings.TrimSpace(string(data)); key != "" {
+			return key, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read master key: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", fmt.Errorf("failed to generate master key: %w", err)
+	}
+	key := base64.StdEncoding.EncodeToString(buf)
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write master key: %w", err)
+	}
+
+	return key, nil
+}
+
+// migrate re-encrypts plaintext passwords and passwords encrypted with the
+// legacy default key.
+func (cm *CredentialManager) migrate() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	store, err := cm.loadStore()
+	if err != nil {
+		return err
+	}
+
+	legacy := &CredentialManager{encryptionKey: deriveKey(DefaultEncryptionKey)}
+	changed := false
+	for registryURL, cred := range store.Credentials {
+		password := cred.Password
+		if IsPasswordEncrypted(password) {
+			if _, err := cm.decrypt(password); err == nil {
+				continue
+			}
+			if password, err = legacy.decrypt(password); err != nil {
+				return fmt.Errorf("failed to decrypt credential for %s: wrong master key?", registryURL)
+			}
+		}
+
+		if cred.Password, err = cm.encrypt(password); err != nil {
+			return fmt.Errorf("failed to encrypt password: %w", err)
+		}
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return cm.saveStore(store)
 }
 
 // getCredentialFilePath returns the path to the credentials file.
@@ -229,6 +321,35 @@ func (cm *CredentialManager) GetCredential(registryURL string) (*Credential, err
 	}, nil
 }
 
+// UpdateCredential updates the username and, when password is not empty,
+// the password of an existing credential.
+func (cm *CredentialManager) UpdateCredential(registryURL, username, password string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	store, err := cm.loadStore()
+	if err != nil {
+		return err
+	}
+
+	cred, ok := store.Credentials[registryURL]
+	if !ok {
+		return fmt.Errorf("credential not found for registry: %s", registryURL)
+	}
+
+	if username != "" {
+		cred.Username = username
+	}
+	if password != "" {
+		if cred.Password, err = cm.encrypt(password); err != nil {
+			return fmt.Errorf("failed to encrypt password: %w", err)
+		}
+	}
+	cred.UpdatedAt = time.Now().UTC()
+
+	return cm.saveStore(store)
+}
+
 // GetCredentialEncrypted retrieves a credential without decrypting the password.
 func (cm *CredentialManager) GetCredentialEncrypted(registryURL string) (*Credential, error) {
 	cm.mu.RLock()
@@ -286,7 +407,7 @@ func (cm *CredentialManager) ListCredentials() (map[string]*Credential, error) {
 	for url, cred := range store.Credentials {
 		result[url] = &Credential{
 			Username:  cred.Username,
-			Password:  "********", // Mask password in list
+			Password:  MaskedPassword, // Mask password in list
 			CreatedAt: cred.CreatedAt,
 			UpdatedAt: cred.UpdatedAt,
 		}
@@ -309,6 +430,16 @@ func (cm *CredentialManager) HasCredential(registryURL string) bool {
 	return ok
 }
 
+// Masked returns a copy of the credential with the password masked.
+func (c *Credential) Masked() *Credential {
+	return &Credential{
+		Username:  c.Username,
+		Password:  MaskedPassword,
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+	}
+}
+
 // IsPasswordEncrypted checks if a password string is encrypted.
 func IsPasswordEncrypted(password string) bool {
 	return len(password) > len(EncryptedPrefix) && password[:len(EncryptedPrefix)] == EncryptedPrefix
@@ -0,0 +1,248 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/pkg/utils"
+)
+
+// DefaultDiskCheckInterval is how often free space of the blob volume is
+// checked.
+const DefaultDiskCheckInterval = 30 * time.Second
+
+// Levels of the blob volume reported by DiskStatus.
+const (
+	DiskLevelOK       = "ok"
+	DiskLevelWarning  = "warning"
+	DiskLevelReadOnly = "read_only"
+)
+
+// ErrStorageReadOnly is returned for blob writes while the blob volume is
+// below the read-only floor.
+var ErrStorageReadOnly = errors.New("storage is read-only: blob volume is low on free space")
+
+// DiskThreshold is an amount of free space on the blob volume, either in
+// bytes or as a percentage of the volume size.
+type DiskThreshold struct {
+	Bytes   int64
+	Percent float64
+}
+
+// ParseDiskThreshold parses a threshold such as "10%" or "20GB".
+func ParseDiskThreshold(s string) (DiskThreshold, error) {
+	s = strings.TrimSpace(s)
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return DiskThreshold{}, fmt.Errorf("invalid free space threshold %q", s)
+		}
+		return DiskThreshold{Percent: percent}, nil
+	}
+	n := utils.ParseSize(s)
+	if n <= 0 {
+		return DiskThreshold{}, fmt.Errorf("invalid free space threshold %q", s)
+	}
+	return DiskThreshold{Bytes: n}, nil
+}
+
+// bytes returns the threshold in bytes for a volume of total bytes.
+func (t DiskThreshold) bytes(total int64) int64 {
+	if t.Percent > 0 {
+		return int64(float64(total) * t.Percent / 100)
+	}
+	return t.Bytes
+}
+
+// String formats the threshold as it is configured.
+func (t DiskThreshold) String() string {
+	if t.Percent > 0 {
+		return strconv.FormatFloat(t.Percent, 'f', -1, 64) + "%"
+	}
+	return utils.FormatSize(t.Bytes)
+}
+
+// DiskGuardConfig configures the free space watchdog of the blob volume.
+type DiskGuardConfig struct {
+	Interval time.Duration
+	// Warn are the thresholds that raise a warning when free space drops
+	// below them, once per threshold
+	Warn []DiskThreshold
+	// ReadOnly is the floor below which blob writes are refused
+	ReadOnly DiskThreshold
+	// Resume is the free space at which writes are accepted again; it is
+	// raised to ReadOnly if lower
+	Resume DiskThreshold
+}
+
+// DiskStatus is the state of the blob volume at the last check.
+type DiskStatus struct {
+	Total         int64      `json:"total"`
+	Free          int64      `json:"free"`
+	Level         string     `json:"level"`               // ok, warning, read_only
+	Threshold     string     `json:"threshold,omitempty"` // lowest warning threshold crossed
+	ReadOnly      bool       `json:"read_only"`
+	ReadOnlySince *time.Time `json:"read_only_since,omitempty"`
+	CheckedAt     time.Time  `json:"checked_at"`
+}
+
+// DiskNotifyFunc receives an alert when free space crosses a threshold or
+// read-only mode starts or ends.
+type DiskNotifyFunc func(level, title, message string)
+
+// diskAlert is an alert raised by a check.
+type diskAlert struct {
+	level, title, message string
+}
+
+// diskGuard holds the watchdog state.
+type diskGuard struct {
+	mu            sync.Mutex
+	config        DiskGuardConfig
+	status        *DiskStatus
+	warned        int // warning thresholds crossed and reported
+	readOnlySince time.Time
+	notify        DiskNotifyFunc
+	started       bool
+}
+
+// SetDiskNotifier sets the callback alerted about free space.
+func (s *Service) SetDiskNotifier(fn DiskNotifyFunc) {
+	s.disk.mu.Lock()
+	defer s.disk.mu.Unlock()
+	s.disk.notify = fn
+}
+
+// StartDiskGuard checks free space of the blob volume every interval in
+// the background. Below the read-only floor blob writes fail with
+// ErrStorageReadOnly until free space is back above the resume threshold.
+// Calling it more than once has no effect.
+func (s *Service) StartDiskGuard(config DiskGuardConfig) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultDiskCheckInterval
+	}
+
+	s.disk.mu.Lock()
+	if s.disk.started {
+		s.disk.mu.Unlock()
+		return
+	}
+	s.disk.started = true
+	s.disk.config = config
+	s.disk.mu.Unlock()
+
+	go func() {
+		s.CheckDiskSpace()
+
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.CheckDiskSpace()
+		}
+	}()
+}
+
+// CheckDiskSpace measures free space of the blob volume, switches read-only
+// mode on or off and sends the alerts due. It returns the new status, or
+// the previous one when free space cannot be measured.
+func (s *Service) CheckDiskSpace() *DiskStatus {
+	total, free := diskSpace(s.storage.GetBlobPath())
+
+	g := &s.disk
+	g.mu.Lock()
+	if total <= 0 {
+		status := g.status
+		g.mu.Unlock()
+		return status
+	}
+
+	now := time.Now()
+	status := &DiskStatus{Total: total, Free: free, Level: DiskLevelOK, CheckedAt: now}
+	var alerts []diskAlert
+
+	// Warnings, the tightest threshold crossed is reported
+	crossed := 0
+	var tightest int64 = -1
+	for _, t := range g.config.Warn {
+		if limit := t.bytes(total); free < limit {
+			crossed++
+			if tightest < 0 || limit < tightest {
+				tightest = limit
+				status.Threshold = t.String()
+			}
+		}
+	}
+	if crossed > 0 {
+		status.Level = DiskLevelWarning
+	}
+	if crossed > g.warned {
+		alerts = append(alerts, diskAlert{"warning", "存储空间不足",
+			fmt.Sprintf("Blob 存储剩余 %s / %s，低于告警阈值 %s", utils.FormatSize(free), utils.FormatSize(total), status.Threshold)})
+	}
+	g.warned = crossed
+
+	// Read-only mode, with the resume threshold as hysteresis
+	floor := g.config.ReadOnly.bytes(total)
+	resume := g.config.Resume.bytes(total)
+	if resume < floor {
+		resume = floor
+	}
+	readOnly := s.storage.readOnly.Load()
+	switch {
+	case !readOnly && floor > 0 && free < floor:
+		readOnly = true
+		g.readOnlySince = now
+		alerts = append(alerts, diskAlert{"error", "存储已切换为只读",
+			fmt.Sprintf("Blob 存储剩余 %s / %s，低于只读阈值 %s，已拒绝推送。清理空间后剩余超过 %s 时自动恢复写入",
+				utils.FormatSize(free), utils.FormatSize(total), g.config.ReadOnly, utils.FormatSize(resume))})
+	case readOnly && free >= resume:
+		readOnly = false
+		alerts = append(alerts, diskAlert{"info", "存储已恢复写入",
+			fmt.Sprintf("Blob 存储剩余 %s / %s，已恢复接受推送", utils.FormatSize(free), utils.FormatSize(total))})
+	}
+	s.storage.readOnly.Store(readOnly)
+	if readOnly {
+		status.Level = DiskLevelReadOnly
+		status.ReadOnly = true
+		since := g.readOnlySince
+		status.ReadOnlySince = &since
+	}
+
+	g.status = status
+	notify := g.notify
+	g.mu.Unlock()
+
+	if notify != nil {
+		for _, a := range alerts {
+			notify(a.level, a.title, a.message)
+		}
+	}
+	return status
+}
+
+// DiskStatus returns the status of the last check, or nil before the first.
+func (s *Service) DiskStatus() *DiskStatus {
+	s.disk.mu.Lock()
+	defer s.disk.mu.Unlock()
+	return s.disk.status
+}
+
+// StorageReadOnly reports whether blob writes are refused because the blob
+// volume is low on free space.
+func (s *Service) StorageReadOnly() bool {
+	return s.storage.readOnly.Load()
+}
+
+// checkWritable returns ErrStorageReadOnly while the blob volume is below
+// the read-only floor.
+func (s *Storage) checkWritable() error {
+	if s.readOnly.Load() {
+		return ErrStorageReadOnly
+	}
+	return nil
+}
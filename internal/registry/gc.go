@@ -0,0 +1,237 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/pkg/compression"
+)
+
+// DefaultGCMinAge protects recently written blobs from garbage collection,
+// since a push uploads its layers before the manifest referencing them.
+const DefaultGCMinAge = time.Hour
+
+// ErrGCRunning is returned when a garbage collection is already running.
+var ErrGCRunning = errors.New("garbage collection already running")
+
+// GCProgressFunc receives garbage collection progress events.
+type GCProgressFunc func(event string, data map[string]interface{})
+
+// GCOptions controls a garbage collection run.
+type GCOptions struct {
+	DryRun bool          // only report what would be deleted
+	MinAge time.Duration // keep unreferenced blobs younger than this
+}
+
+// GCResult summarizes a garbage collection run.
+type GCResult struct {
+	DryRun      bool      `json:"dry_run"`
+	Scanned     int       `json:"scanned"`    // blobs on disk
+	Referenced  int       `json:"referenced"` // blobs referenced by a namespace
+	Deleted     int       `json:"deleted"`    // blobs removed, or that would be removed in a dry run
+	Skipped     int       `json:"skipped"`    // unreferenced blobs kept because they are too recent
+	FreedBytes  int64     `json:"freed_bytes"`
+	Errors      []string  `json:"errors,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// SetGCNotifier sets the callback that receives garbage collection progress.
+func (s *Service) SetGCNotifier(fn GCProgressFunc) {
+	s.gcNotify = fn
+}
+
+// GarbageCollect deletes blobs that no namespace references: no tag and no
+// recycle bin entry, directly or through a manifest list, and no recent
+// upload. It marks every blob in the reference index, then sweeps the blob
+// directory. Only one
+// run may be active at a time.
+func (s *Service) GarbageCollect(ctx context.Context, opts GCOptions) (*GCResult, error) {
+	if !s.gcMu.TryLock() {
+		return nil, ErrGCRunning
+	}
+	defer s.gcMu.Unlock()
+
+	if opts.MinAge < 0 {
+		opts.MinAge = 0
+	}
+	result := &GCResult{DryRun: opts.DryRun, StartedAt: time.Now().UTC()}
+	s.notifyGC("gc_started", map[string]interface{}{"dry_run": opts.DryRun})
+
+	referenced, err := s.markBlobs(ctx)
+	if err != nil {
+		s.notifyGC("gc_failed", map[string]interface{}{"error": err.Error()})
+		return nil, err
+	}
+	result.Referenced = len(referenced)
+
+	if err := s.sweepBlobs(ctx, referenced, opts, result); err != nil {
+		s.notifyGC("gc_failed", map[string]interface{}{"error": err.Error()})
+		return nil, err
+	}
+	result.CompletedAt = time.Now().UTC()
+
+	if !opts.DryRun && result.Deleted > 0 {
+		s.RefreshStorageUsage()
+	}
+	s.notifyGC("gc_completed", map[string]interface{}{
+		"dry_run":     result.DryRun,
+		"scanned":     result.Scanned,
+		"deleted":     result.Deleted,
+		"freed_bytes": result.FreedBytes,
+	})
+	return result, nil
+}
+
+// markBlobs returns the digests some namespace references according to
+// the blob reference index: through tags, trash entries or recent uploads.
+func (s *Service) markBlobs(ctx context.Context) (map[string]bool, error) {
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	referenced := make(map[string]bool)
+	report := s.gcReporter("mark", len(store.Namespaces))
+	i := 0
+	for _, refs := range store.Namespaces {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for digest := range refs.Blobs {
+			referenced[digest] = true
+		}
+		for digest := range refs.Links {
+			if refs.references(digest, now) {
+				referenced[digest] = true
+			}
+		}
+		i++
+		report(i)
+	}
+	return referenced, nil
+}
+
+// sweepBlobs deletes the blobs on disk that are not in referenced.
+func (s *Service) sweepBlobs(ctx context.Context, referenced map[string]bool, opts GCOptions, result *GCResult) error {
+	// Collect first so progress has a total
+	paths, err := s.storage.listBlobFiles()
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-opts.MinAge)
+	report := s.gcReporter("sweep", len(paths))
+	for i, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		result.Scanned++
+		report(i + 1)
+
+		digest := "sha256:" + filepath.Base(path)
+		if referenced[digest] {
+			continue
+		}
+		info, err := compression.StatBlobFile(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime.After(cutoff) {
+			result.Skipped++
+			continue
+		}
+		if !opts.DryRun {
+			if err := compression.RemoveBlobFile(path); err != nil {
+				result.Errors = append(result.Errors, digest+": "+err.Error())
+				continue
+			}
+			// Drop the shard directory once empty; fails harmlessly otherwise.
+			os.Remove(filepath.Dir(path))
+		}
+		result.Deleted++
+		result.FreedBytes += info.StoredSize
+	}
+	return nil
+}
+
+// listBlobFiles returns the path of every blob, without compression
+// extension, in digest order. A blob may be stored under several
+// compression variants, which all share one digest.
+func (s *Storage) listBlobFiles() ([]string, error) {
+	var paths []string
+	seen := make(map[string]bool)
+	err := filepath.WalkDir(s.blobPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		// Only sharded digest files are blobs; the directory also holds
+		// temp files, uploads, quarantined blobs and generated client configs.
+		base := strings.TrimSuffix(strings.TrimSuffix(path, ".zst"), ".gz")
+		if !isBlobFile(base) {
+			return nil
+		}
+		if !seen[base] {
+			seen[base] = true
+			paths = append(paths, base)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return filepath.Base(paths[i]) < filepath.Base(paths[j])
+	})
+	return paths, nil
+}
+
+// isBlobFile reports whether path has the <hh>/<sha256 hex> layout of
+// getBlobPath.
+func isBlobFile(path string) bool {
+	name := filepath.Base(path)
+	if len(name) != 64 || filepath.Base(filepath.Dir(path)) != name[:2] {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// gcReporter returns a function reporting progress of a phase at most once
+// per progressInterval, and always on the last item.
+func (s *Service) gcReporter(phase string, total int) func(done int) {
+	var last time.Time
+	return func(done int) {
+		if s.gcNotify == nil || (done < total && time.Since(last) < progressInterval) {
+			return
+		}
+		last = time.Now()
+		s.notifyGC("gc_progress", map[string]interface{}{
+			"phase": phase,
+			"done":  done,
+			"total": total,
+		})
+	}
+}
+
+// notifyGC sends a progress event if a notifier is configured.
+func (s *Service) notifyGC(event string, data map[string]interface{}) {
+	if s.gcNotify != nil {
+		s.gcNotify(event, data)
+	}
+}
@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"cyp-docker-registry/internal/dao"
+)
+
+// indexedManifest holds the parts of a manifest or index that carry labels.
+type indexedManifest struct {
+	Annotations map[string]string `json:"annotations"`
+	Config      struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+		} `json:"platform"`
+	} `json:"manifests"`
+}
+
+// extractLabels returns the annotations and config labels of a manifest,
+// e.g. org.opencontainers.image.source or a Dockerfile LABEL. For an index
+// the annotations of the index take precedence over those of the platform
+// manifest, which also supplies the config labels.
+func (s *Service) extractLabels(manifestData []byte) (annotations, labels map[string]string) {
+	var m indexedManifest
+	if err := json.Unmarshal(manifestData, &m); err != nil {
+		return nil, nil
+	}
+	annotations = m.Annotations
+
+	if len(m.Manifests) > 0 {
+		// Same platform choice as for the displayed layers
+		target := m.Manifests[0].Digest
+		for _, child := range m.Manifests {
+			if child.Platform.OS == "linux" && child.Platform.Architecture == "amd64" {
+				target = child.Digest
+				break
+			}
+		}
+		data, err := s.readBlob(target)
+		if err != nil {
+			return annotations, nil
+		}
+		var child indexedManifest
+		if err := json.Unmarshal(data, &child); err != nil {
+			return annotations, nil
+		}
+		for k, v := range child.Annotations {
+			if _, ok := annotations[k]; !ok {
+				if annotations == nil {
+					annotations = make(map[string]string)
+				}
+				annotations[k] = v
+			}
+		}
+		m.Config = child.Config
+	}
+
+	if m.Config.Digest == "" {
+		return annotations, nil
+	}
+	data, err := s.readBlob(m.Config.Digest)
+	if err != nil {
+		return annotations, nil
+	}
+	var config struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return annotations, nil
+	}
+	return annotations, config.Config.Labels
+}
+
+// indexLabels stores the labels of a manifest for label search. Labels are
+// keyed by manifest digest so that retags and restored tags share them.
+func indexLabels(digest string, annotations, labels map[string]string) error {
+	if dao.GetDB() == nil {
+		return nil
+	}
+
+	records := make([]*dao.ImageLabel, 0, len(annotations)+len(labels))
+	for k, v := range annotations {
+		records = append(records, &dao.ImageLabel{Digest: digest, Source: dao.LabelSourceAnnotation, Key: k, Value: v})
+	}
+	for k, v := range labels {
+		records = append(records, &dao.ImageLabel{Digest: digest, Source: dao.LabelSourceConfig, Key: k, Value: v})
+	}
+	return dao.ReplaceImageLabels(digest, records)
+}
+
+// ParseLabelSelectors parses label filters of the form key=value, or key
+// alone to match any value.
+func ParseLabelSelectors(selectors []string) ([]dao.LabelSelector, error) {
+	var parsed []dao.LabelSelector
+	for _, sel := range selectors {
+		key, value, _ := strings.Cut(sel, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("invalid label selector %q", sel)
+		}
+		parsed = append(parsed, dao.LabelSelector{Key: key, Value: strings.TrimSpace(value)})
+	}
+	return parsed, nil
+}
+
+// fillLabels attaches the indexed annotations and config labels to images.
+func fillLabels(images []*ImageManifest) error {
+	if len(images) == 0 || dao.GetDB() == nil {
+		return nil
+	}
+
+	var digests []string
+	seen := make(map[string]bool)
+	for _, img := range images {
+		if !seen[img.Digest] {
+			seen[img.Digest] = true
+			digests = append(digests, img.Digest)
+		}
+	}
+
+	records, err := dao.ListImageLabels(digests)
+	if err != nil {
+		return err
+	}
+	annotations := make(map[string]map[string]string)
+	labels := make(map[string]map[string]string)
+	for _, r := range records {
+		target := labels
+		if r.Source == dao.LabelSourceAnnotation {
+			target = annotations
+		}
+		if target[r.Digest] == nil {
+			target[r.Digest] = make(map[string]string)
+		}
+		target[r.Digest][r.Key] = r.Value
+	}
+
+	for _, img := range images {
+		img.Annotations = annotations[img.Digest]
+		img.Labels = labels[img.Digest]
+	}
+	return nil
+}
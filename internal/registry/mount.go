@@ -0,0 +1,16 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import "time"
+
+// RepositoryHasBlob reports whether repository name references digest,
+// through a tag whose image contains it or a recent upload or mount. Blobs
+// are stored once for all repositories, so this is what makes a blob part
+// of a repository.
+func (s *Storage) RepositoryHasBlob(name, digest string) bool {
+	store, err := s.LoadMetadata()
+	if err != nil {
+		return false
+	}
+	return store.Namespaces[repositoryNamespace(name)].repositoryReferences(name, digest, time.Now())
+}
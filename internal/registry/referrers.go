@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Descriptor describes a manifest in a referrers response.
+type Descriptor struct {
+	MediaType    string            `json:"mediaType"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// referrerDigests returns the manifest digests of repository name whose
+// subject is the given digest. A referrer pushed by digest only is stored
+// under its digest as tag, so every referrer is found here.
+func (s *Storage) referrerDigests(name, subject string) ([]string, error) {
+	store, err := s.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	var digests []string
+	seen := make(map[string]bool)
+	for _, info := range store.Images[name] {
+		if info.Subject == subject && !seen[info.Digest] {
+			seen[info.Digest] = true
+			digests = append(digests, info.Digest)
+		}
+	}
+	sort.Strings(digests)
+	return digests, nil
+}
+
+// ReferrerDigests returns the manifest digests of repository name that
+// refer to the manifest digest, such as signatures and attestations.
+func (s *Service) ReferrerDigests(name, digest string) ([]string, error) {
+	return s.storage.referrerDigests(name, digest)
+}
+
+// Referrers returns the descriptors of the manifests referring to digest,
+// only those of artifactType when it is not empty.
+func (s *Service) Referrers(name, digest, artifactType string) ([]Descriptor, error) {
+	digests, err := s.storage.referrerDigests(name, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	referrers := make([]Descriptor, 0, len(digests))
+	for _, d := range digests {
+		data, err := s.readBlob(d)
+		if err != nil {
+			continue // deleted by gc, the tag is dangling
+		}
+		var manifest struct {
+			MediaType    string `json:"mediaType"`
+			ArtifactType string `json:"artifactType"`
+			Config       struct {
+				MediaType string `json:"mediaType"`
+			} `json:"config"`
+			Annotations map[string]string `json:"annotations"`
+		}
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			continue
+		}
+		// Per the image spec, artifactType falls back to the config type
+		desc := Descriptor{
+			MediaType:    manifest.MediaType,
+			Digest:       d,
+			Size:         int64(len(data)),
+			ArtifactType: manifest.ArtifactType,
+			Annotations:  manifest.Annotations,
+		}
+		if desc.ArtifactType == "" {
+			desc.ArtifactType = manifest.Config.MediaType
+		}
+		if artifactType != "" && desc.ArtifactType != artifactType {
+			continue
+		}
+		referrers = append(referrers, desc)
+	}
+	return referrers, nil
+}
+
+// ReadBlob returns the content of a stored blob, failing for blobs larger
+// than limit bytes so callers parsing untrusted manifests and attestations
+// do not load huge layers into memory.
+func (s *Service) ReadBlob(digest string, limit int64) ([]byte, error) {
+	reader, size, err := s.storage.GetBlob(digest)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	if size > limit {
+		return nil, fmt.Errorf("blob %s is larger than %d bytes", digest, limit)
+	}
+	return io.ReadAll(io.LimitReader(reader, limit))
+}
+
+// getReferrers handles GET /v2/:name/referrers/:digest, the referrers API
+// of the distribution spec. It lists manifests whose subject is digest as
+// an image index, filtered by the artifactType query parameter.
+func (h *Handler) getReferrers(c *gin.Context) {
+	name := c.Param("name")
+	digest := c.Param("digest")
+	if !sha256DigestPattern.MatchString(digest) {
+		h.v2Error(c, "DIGEST_INVALID", "无效的摘要格式", http.StatusBadRequest)
+		return
+	}
+
+	artifactType := c.Query("artifactType")
+	referrers, err := h.service.Referrers(name, digest, artifactType)
+	if err != nil {
+		h.v2Error(c, "UNKNOWN", err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Docker-Distribution-API-Version", "registry/2.0")
+	if artifactType != "" {
+		c.Header("OCI-Filters-Applied", "artifactType")
+	}
+	body, _ := json.Marshal(gin.H{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests":     referrers,
+	})
+	c.Data(http.StatusOK, "application/vnd.oci.image.index.v1+json", body)
+}
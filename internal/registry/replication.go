@@ -0,0 +1,679 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// manifestAccept lists the manifest media types accepted when pulling.
+var manifestAccept = strings.Join([]string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}, ", ")
+
+// replicationCheckInterval is how often poll rules are checked for due runs.
+const replicationCheckInterval = time.Minute
+
+// ReplicationRule describes a pull replication from a remote registry
+// into local storage.
+type ReplicationRule struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	SourceRegistry string     `json:"source_registry"`
+	Repository     string     `json:"repository"`            // 远程仓库，如 library/nginx
+	TagPattern     string     `json:"tag_pattern,omitempty"` // 标签匹配模式，默认 *
+	LocalName      string     `json:"local_name,omitempty"`  // 本地镜像名，默认同 Repository
+	Interval       string     `json:"interval,omitempty"`    // 轮询间隔，为空时仅由 webhook 或手动触发
+	Overwrite      bool       `json:"overwrite"`             // 本地标签摘要不同时是否覆盖
+	WebhookSecret  string     `json:"webhook_secret,omitempty"`
+	Enabled        bool       `json:"enabled"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+// ReplicationStore represents the replication rule storage structure.
+type ReplicationStore struct {
+	Rules []*ReplicationRule `json:"rules"`
+}
+
+// getRulesFilePath returns the path to the replication rules file.
+func (ss *SyncService) getRulesFilePath() string {
+	return filepath.Join(ss.historyPath, "replication_rules.json")
+}
+
+// loadRules loads replication rules from disk. Caller must hold rulesMu.
+func (ss *SyncService) loadRules() (*ReplicationStore, error) {
+	data, err := os.ReadFile(ss.getRulesFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ReplicationStore{Rules: make([]*ReplicationRule, 0)}, nil
+		}
+		return nil, fmt.Errorf("failed to read replication rules: %w", err)
+	}
+
+	var store ReplicationStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse replication rules: %w", err)
+	}
+	if store.Rules == nil {
+		store.Rules = make([]*ReplicationRule, 0)
+	}
+	return &store, nil
+}
+
+// saveRules saves replication rules to disk. Caller must hold rulesMu.
+func (ss *SyncService) saveRules(store *ReplicationStore) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal replication rules: %w", err)
+	}
+
+	// 规则中包含 webhook 密钥，仅允许属主读写
+	if err := os.WriteFile(ss.getRulesFilePath(), data, 0600); err != nil {
+		return fmt.Errorf("failed to write replication rules: %w", err)
+	}
+	return nil
+}
+
+// ListReplicationRules returns all replication rules.
+func (ss *SyncService) ListReplicationRules() ([]*ReplicationRule, error) {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadRules()
+	if err != nil {
+		return nil, err
+	}
+	return store.Rules, nil
+}
+
+// GetReplicationRule returns a replication rule by ID.
+func (ss *SyncService) GetReplicationRule(id string) (*ReplicationRule, error) {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range store.Rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("replication rule not found: %s", id)
+}
+
+// SaveReplicationRule creates a rule when its ID is empty, otherwise
+// updates the existing rule. A webhook secret is generated if not set.
+func (ss *SyncService) SaveReplicationRule(rule *ReplicationRule) (*ReplicationRule, error) {
+	if rule.SourceRegistry == "" || rule.Repository == "" {
+		return nil, fmt.Errorf("source_registry and repository are required")
+	}
+	rule.SourceRegistry = strings.TrimRight(rule.SourceRegistry, "/")
+	if rule.TagPattern == "" {
+		rule.TagPattern = "*"
+	}
+	if _, err := path.Match(rule.TagPattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid tag_pattern: %w", err)
+	}
+	if rule.LocalName == "" {
+		rule.LocalName = rule.Repository
+	}
+	if rule.Interval != "" {
+		if _, err := time.ParseDuration(rule.Interval); err != nil {
+			return nil, fmt.Errorf("invalid interval: %w", err)
+		}
+	}
+	if rule.Name == "" {
+		rule.Name = rule.Repository
+	}
+
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadRules()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rule.UpdatedAt = now
+
+	if rule.ID == "" {
+		if rule.WebhookSecret == "" {
+			secret := make([]byte, 16)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+			rule.WebhookSecret = hex.EncodeToString(secret)
+		}
+		rule.ID = fmt.Sprintf("repl-%d", now.UnixNano())
+		rule.CreatedAt = now
+		store.Rules = append(store.Rules, rule)
+		return rule, ss.saveRules(store)
+	}
+
+	for i, existing := range store.Rules {
+		if existing.ID != rule.ID {
+			continue
+		}
+		rule.CreatedAt = existing.CreatedAt
+		rule.LastRunAt = existing.LastRunAt
+		rule.LastError = existing.LastError
+		if rule.WebhookSecret == "" {
+			rule.WebhookSecret = existing.WebhookSecret
+		}
+		store.Rules[i] = rule
+		return rule, ss.saveRules(store)
+	}
+
+	return nil, fmt.Errorf("replication rule not found: %s", rule.ID)
+}
+
+// DeleteReplicationRule deletes a replication rule.
+func (ss *SyncService) DeleteReplicationRule(id string) error {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadRules()
+	if err != nil {
+		return err
+	}
+	for i, rule := range store.Rules {
+		if rule.ID == id {
+			store.Rules = append(store.Rules[:i], store.Rules[i+1:]...)
+			return ss.saveRules(store)
+		}
+	}
+	return fmt.Errorf("replication rule not found: %s", id)
+}
+
+// recordRuleRun stores the outcome of a rule run.
+func (ss *SyncService) recordRuleRun(id string, runErr error) {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadRules()
+	if err != nil {
+		return
+	}
+	for _, rule := range store.Rules {
+		if rule.ID == id {
+			now := time.Now().UTC()
+			rule.LastRunAt = &now
+			rule.LastError = ""
+			if runErr != nil {
+				rule.LastError = runErr.Error()
+			}
+			ss.saveRules(store)
+			return
+		}
+	}
+}
+
+// VerifyWebhookSecret reports whether secret matches the rule's webhook secret.
+func (ss *SyncService) VerifyWebhookSecret(rule *ReplicationRule, secret string) bool {
+	return rule.WebhookSecret != "" &&
+		subtle.ConstantTimeCompare([]byte(rule.WebhookSecret), []byte(secret)) == 1
+}
+
+// StartReplication starts polling replication rules that have an interval.
+func (ss *SyncService) StartReplication() {
+	go func() {
+		ticker := time.NewTicker(replicationCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				ss.runDueRules()
+			case <-ss.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// SetLeaderCheck makes replication polling run only while isLeader returns
+// true, so rules are polled by one instance of a cluster. Webhook and manual
+// runs are not affected. It must be called before StartReplication.
+func (ss *SyncService) SetLeaderCheck(isLeader func() bool) {
+	ss.isLeader = isLeader
+}
+
+// StopReplication stops polling replication rules.
+func (ss *SyncService) StopReplication() {
+	ss.stopOnce.Do(func() {
+		close(ss.stopCh)
+	})
+}
+
+// runDueRules runs every enabled poll rule whose interval has elapsed.
+func (ss *SyncService) runDueRules() {
+	if ss.isLeader != nil && !ss.isLeader() {
+		return
+	}
+
+	rules, err := ss.ListReplicationRules()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		if !rule.Enabled || rule.Interval == "" {
+			continue
+		}
+		interval, err := time.ParseDuration(rule.Interval)
+		if err != nil || interval <= 0 {
+			continue
+		}
+		if rule.LastRunAt != nil && now.Sub(*rule.LastRunAt) < interval {
+			continue
+		}
+		go ss.RunReplication(context.Background(), rule.ID)
+	}
+}
+
+// RunReplication imports new tags for a rule. When tags are given only
+// those tags are checked, otherwise the remote tag list is fetched. Tags
+// already present locally with the same digest are skipped; tags whose
+// local digest differs are recorded as conflicts unless the rule allows
+// overwriting. It returns the sync records created by this run.
+func (ss *SyncService) RunReplication(ctx context.Context, id string, tags ...string) ([]*SyncRecord, error) {
+	rule, err := ss.GetReplicationRule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同一规则同时只运行一次
+	if _, running := ss.runningRules.LoadOrStore(id, struct{}{}); running {
+		return nil, fmt.Errorf("replication rule is already running: %s", id)
+	}
+	defer ss.runningRules.Delete(id)
+
+	records, err := ss.runRule(ctx, rule, tags)
+	ss.recordRuleRun(id, err)
+	return records, err
+}
+
+// runRule performs a single replication run.
+func (ss *SyncService) runRule(ctx context.Context, rule *ReplicationRule, tags []string) ([]*SyncRecord, error) {
+	// 公共仓库可以匿名拉取，凭证是可选的
+	cred, _ := ss.credentialManager.GetCredential(rule.SourceRegistry)
+
+	if len(tags) == 0 {
+		var err error
+		tags, err = ss.listRemoteTags(ctx, rule.SourceRegistry, rule.Repository, cred)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list remote tags: %w", err)
+		}
+	}
+
+	var records []*SyncRecord
+	var failed int
+	for _, tag := range tags {
+		if ok, _ := path.Match(rule.TagPattern, tag); !ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return records, err
+		}
+
+		record, err := ss.pullTag(ctx, rule, tag, cred)
+		if record != nil {
+			records = append(records, record)
+		}
+		if err != nil {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return records, fmt.Errorf("%d of %d tags failed to replicate", failed, len(records))
+	}
+	return records, nil
+}
+
+// pullTag imports a single remote tag. It returns a nil record when the
+// local tag is already up to date.
+func (ss *SyncService) pullTag(ctx context.Context, rule *ReplicationRule, tag string, cred *Credential) (*SyncRecord, error) {
+	manifestData, digest, err := ss.fetchManifest(ctx, rule.SourceRegistry, rule.Repository, tag, cred)
+	if err != nil {
+		record := ss.newPullRecord(rule, tag, "")
+		ss.finishPullRecord(record, 0, err)
+		return record, err
+	}
+
+	if local, err := ss.storage.GetImage(rule.LocalName, tag); err == nil {
+		if local.Digest == digest {
+			return nil, nil
+		}
+		if !rule.Overwrite {
+			// 同一冲突只记录一次，避免每次轮询都产生历史记录
+			if ss.hasConflictRecord(rule.ID, tag, digest) {
+				return nil, nil
+			}
+			record := ss.newPullRecord(rule, tag, digest)
+			now := time.Now().UTC()
+			record.Status = SyncStatusConflict
+			record.ErrorMessage = fmt.Sprintf("local tag %s:%s has digest %s, remote has %s", rule.LocalName, tag, local.Digest, digest)
+			record.CompletedAt = &now
+			ss.addRecord(record)
+			return record, nil
+		}
+	}
+
+	record := ss.newPullRecord(rule, tag, digest)
+	record.Status = SyncStatusRunning
+	if err := ss.addRecord(record); err != nil {
+		return nil, err
+	}
+
+	size, err := ss.importManifest(ctx, rule.SourceRegistry, rule.Repository, manifestData, cred)
+	if err == nil {
+		svc := &Service{storage: ss.storage}
+		_, err = svc.PushManifest(rule.LocalName, tag, manifestData)
+	}
+	ss.finishPullRecord(record, size, err)
+	return record, err
+}
+
+// newPullRecord creates a pull sync record for a rule.
+func (ss *SyncService) newPullRecord(rule *ReplicationRule, tag, digest string) *SyncRecord {
+	return &SyncRecord{
+		ID:             generateSyncID(),
+		Direction:      SyncDirectionPull,
+		RuleID:         rule.ID,
+		ImageName:      rule.LocalName,
+		ImageTag:       tag,
+		SourceDigest:   digest,
+		TargetRegistry: rule.SourceRegistry,
+		TargetImage:    rule.Repository,
+		TargetTag:      tag,
+		Status:         SyncStatusPending,
+		StartedAt:      time.Now().UTC(),
+	}
+}
+
+// finishPullRecord stores the final state of a pull record.
+func (ss *SyncService) finishPullRecord(record *SyncRecord, size int64, err error) {
+	now := time.Now().UTC()
+	record.CompletedAt = &now
+	record.BytesSynced = size
+
+	if err != nil {
+		record.Status = SyncStatusFailed
+		record.ErrorMessage = err.Error()
+	} else {
+		record.Status = SyncStatusCompleted
+	}
+
+	if ss.updateRecord(record) != nil {
+		ss.addRecord(record)
+	}
+}
+
+// hasConflictRecord reports whether the latest record of a rule's tag is
+// a conflict with the same remote digest.
+func (ss *SyncService) hasConflictRecord(ruleID, tag, digest string) bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	history, err := ss.loadHistory()
+	if err != nil {
+		return false
+	}
+	for i := len(history.Records) - 1; i >= 0; i-- {
+		r := history.Records[i]
+		if r.RuleID == ruleID && r.ImageTag == tag {
+			return r.Status == SyncStatusConflict && r.SourceDigest == digest
+		}
+	}
+	return false
+}
+
+// importManifest downloads every blob referenced by a manifest that is not
+// yet stored locally. Manifest lists are imported for all platforms.
+func (ss *SyncService) importManifest(ctx context.Context, registryURL, repo string, manifestData []byte, cred *Credential) (int64, error) {
+	var manifest struct {
+		MediaType string `json:"mediaType"`
+		Config    struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return 0, fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	var total int64
+
+	// 多架构镜像：逐个导入各平台清单
+	for _, m := range manifest.Manifests {
+		if ss.storage.BlobExists(m.Digest) {
+			continue
+		}
+		data, digest, err := ss.fetchManifest(ctx, registryURL, repo, m.Digest, cred)
+		if err != nil {
+			return total, err
+		}
+		if digest != m.Digest {
+			return total, fmt.Errorf("manifest digest mismatch: expected %s, got %s", m.Digest, digest)
+		}
+		size, err := ss.importManifest(ctx, registryURL, repo, data, cred)
+		total += size
+		if err != nil {
+			return total, err
+		}
+		if _, err := ss.storage.SaveBlobWithDigest(digest, bytes.NewReader(data)); err != nil {
+			return total, err
+		}
+		total += int64(len(data))
+	}
+
+	digests := make([]string, 0, len(manifest.Layers)+1)
+	if manifest.Config.Digest != "" {
+		digests = append(digests, manifest.Config.Digest)
+	}
+	for _, l := range manifest.Layers {
+		digests = append(digests, l.Digest)
+	}
+
+	for _, digest := range digests {
+		if ss.storage.BlobExists(digest) {
+			continue
+		}
+		size, err := ss.fetchBlob(ctx, registryURL, repo, digest, cred)
+		if err != nil {
+			return total, fmt.Errorf("failed to pull blob %s: %w", digest, err)
+		}
+		total += size
+	}
+
+	return total, nil
+}
+
+// listRemoteTags lists all tags of a remote repository, following
+// pagination links.
+func (ss *SyncService) listRemoteTags(ctx context.Context, registryURL, repo string, cred *Credential) ([]string, error) {
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=1000", registryBaseURL(registryURL), repo)
+	var tags []string
+
+	for next != "" {
+		resp, err := ss.doRemote(ctx, http.MethodGet, next, "", cred)
+		if err != nil {
+			return nil, err
+		}
+
+		var result struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&result)
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag list: %w", err)
+		}
+		tags = append(tags, result.Tags...)
+
+		next = nextLink(registryURL, link)
+	}
+
+	return tags, nil
+}
+
+// fetchManifest downloads a manifest and returns its content and digest.
+// The digest is computed locally and checked against Docker-Content-Digest.
+func (ss *SyncService) fetchManifest(ctx context.Context, registryURL, repo, reference string, cred *Credential) ([]byte, string, error) {
+	u := fmt.Sprintf("%s/v2/%s/manifests/%s", registryBaseURL(registryURL), repo, reference)
+	resp, err := ss.doRemote(ctx, http.MethodGet, u, manifestAccept, cred)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, "", err
+	}
+
+	hash := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(hash[:])
+	if header := resp.Header.Get("Docker-Content-Digest"); header != "" && header != digest {
+		return nil, "", fmt.Errorf("manifest digest mismatch: registry reported %s, got %s", header, digest)
+	}
+
+	return data, digest, nil
+}
+
+// fetchBlob downloads a blob into local storage and verifies its digest.
+func (ss *SyncService) fetchBlob(ctx context.Context, registryURL, repo, digest string, cred *Credential) (int64, error) {
+	u := fmt.Sprintf("%s/v2/%s/blobs/%s", registryBaseURL(registryURL), repo, digest)
+	resp, err := ss.doRemote(ctx, http.MethodGet, u, "", cred)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	actual, size, err := ss.storage.SaveBlob(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if actual != digest {
+		ss.storage.DeleteBlob(actual)
+		return 0, fmt.Errorf("blob digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return size, nil
+}
+
+// doRemote sends a request to a remote registry and converts non-2xx
+// responses to errors.
+func (ss *SyncService) doRemote(ctx context.Context, method, u, accept string, cred *Credential) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := ss.doRequest(req, cred)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s - %s", method, u, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// nextLink extracts the next page URL from a Link header such as
+// `</v2/foo/tags/list?last=x&n=100>; rel="next"`.
+func nextLink(registryURL, header string) string {
+	if header == "" || !strings.Contains(header, `rel="next"`) {
+		return ""
+	}
+	start := strings.Index(header, "<")
+	end := strings.Index(header, ">")
+	if start < 0 || end <= start {
+		return ""
+	}
+
+	link := header[start+1 : end]
+	base, err := url.Parse(registryBaseURL(registryURL) + "/")
+	if err != nil {
+		return ""
+	}
+	ref, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// ParseReplicationWebhook extracts tags pushed to repository from a webhook
+// payload. It understands Docker Distribution notifications and simple
+// {"tag": "..."} or {"tags": [...]} bodies. An empty result means "check
+// all tags".
+func ParseReplicationWebhook(body []byte, repository string) ([]string, error) {
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return nil, nil
+	}
+
+	var payload struct {
+		Tag    string   `json:"tag"`
+		Tags   []string `json:"tags"`
+		Events []struct {
+			Action string `json:"action"`
+			Target struct {
+				Repository string `json:"repository"`
+				Tag        string `json:"tag"`
+			} `json:"target"`
+		} `json:"events"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, errors.New("invalid webhook payload")
+	}
+
+	tags := payload.Tags
+	if payload.Tag != "" {
+		tags = append(tags, payload.Tag)
+	}
+	for _, event := range payload.Events {
+		if event.Target.Repository != "" && event.Target.Repository != repository {
+			continue
+		}
+		if event.Action == "push" && event.Target.Tag != "" {
+			tags = append(tags, event.Target.Tag)
+		}
+	}
+	return tags, nil
+}
@@ -0,0 +1,142 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var (
+	// repositoryNamePattern follows the distribution reference grammar.
+	repositoryNamePattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern            = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+)
+
+// ErrTagExists is returned when copying onto an existing tag without overwrite.
+var ErrTagExists = errors.New("tag already exists")
+
+// ErrPushDenied is returned when the client may not write to a repository.
+var ErrPushDenied = errors.New("push to repository denied")
+
+// ValidateReference checks a repository name and tag.
+func ValidateReference(name, tag string) error {
+	if len(name) > 255 || !repositoryNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid repository name: %s", name)
+	}
+	if !tagPattern.MatchString(tag) {
+		return fmt.Errorf("invalid tag: %s", tag)
+	}
+	return nil
+}
+
+// CopyImage makes dstName:dstTag point at the manifest of srcName:srcTag.
+// Only metadata is written: blobs are content-addressed and shared, so
+// nothing is re-uploaded. Retagging is a copy within the same repository.
+func (s *Service) CopyImage(srcName, srcTag, dstName, dstTag string, overwrite bool, copiedBy string) (*ImageManifest, error) {
+	if err := ValidateReference(dstName, dstTag); err != nil {
+		return nil, err
+	}
+	if srcName == dstName && srcTag == dstTag {
+		return nil, fmt.Errorf("source and target are the same: %s:%s", srcName, srcTag)
+	}
+
+	src, err := s.storage.GetImage(srcName, srcTag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to create a tag whose content is incomplete on this node.
+	if !s.storage.BlobExists(src.Digest) {
+		return nil, fmt.Errorf("manifest blob not found: %s", src.Digest)
+	}
+	for _, layer := range src.Layers {
+		if !s.storage.BlobExists(layer.Digest) {
+			return nil, fmt.Errorf("layer blob not found: %s", layer.Digest)
+		}
+	}
+
+	dst := &ImageManifest{
+		Name:         dstName,
+		Tag:          dstTag,
+		Digest:       src.Digest,
+		Size:         src.Size,
+		CreatedAt:    time.Now().UTC(),
+		Layers:       src.Layers,
+		PushedBy:     copiedBy,
+		ArtifactType: src.ArtifactType,
+		Category:     src.Category,
+		Subject:      src.Subject,
+	}
+	if err := s.storage.SaveImageIfAbsent(dst, overwrite); err != nil {
+		return nil, err
+	}
+	return dst, nil
+}
+
+// ImageDigest returns the manifest digest name:tag points at.
+func (s *Service) ImageDigest(name, tag string) (string, error) {
+	image, err := s.storage.GetImage(name, tag)
+	if err != nil {
+		return "", err
+	}
+	return image.Digest, nil
+}
+
+// RetagDigest makes name:tag point at the manifest digest, which a tag of
+// the repository must still reference. The tag is overwritten, so channel
+// tags such as staging can be moved forward.
+func (s *Service) RetagDigest(name, digest, tag, retaggedBy string) error {
+	images, err := s.storage.RepositoryTags(name)
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		if image.Digest != digest {
+			continue
+		}
+		if image.Tag == tag {
+			return nil
+		}
+		_, err := s.CopyImage(name, image.Tag, name, tag, true, retaggedBy)
+		return err
+	}
+	return fmt.Errorf("manifest not found in %s: %s", name, digest)
+}
+
+// SaveImageIfAbsent saves image metadata, failing with ErrTagExists when
+// the tag is already present and overwrite is false.
+func (s *Storage) SaveImageIfAbsent(manifest *ImageManifest, overwrite bool) error {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return err
+	}
+
+	if store.Images[manifest.Name] == nil {
+		store.Images[manifest.Name] = make(map[string]*TagInfo)
+	}
+	if _, exists := store.Images[manifest.Name][manifest.Tag]; exists && !overwrite {
+		return fmt.Errorf("%w: %s:%s", ErrTagExists, manifest.Name, manifest.Tag)
+	}
+
+	store.Images[manifest.Name][manifest.Tag] = newTagInfo(manifest, store.Images[manifest.Name][manifest.Tag])
+	return s.saveMetadataUnsafe(store)
+}
+
+// digestReferenced reports whether any namespace still references digest,
+// through a tag, a tag in the recycle bin or a recent upload.
+func (s *Storage) digestReferenced(digest string) bool {
+	store, err := s.LoadMetadata()
+	if err != nil {
+		// Keep the blob when in doubt.
+		return true
+	}
+	return store.blobReferenced(digest, time.Now())
+}
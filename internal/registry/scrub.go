@@ -0,0 +1,357 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cyp-docker-registry/pkg/compression"
+)
+
+// quarantineDir is the directory of the blob directory where corrupted
+// blobs are moved, so they are no longer served but can be inspected.
+const quarantineDir = "_quarantine"
+
+// Problems a scrub can find with a blob.
+const (
+	ScrubDigestMismatch = "digest_mismatch"
+	ScrubUnreadable     = "unreadable"
+)
+
+// ErrScrubRunning is returned when a scrub pass is already running.
+var ErrScrubRunning = errors.New("scrub already running")
+
+// BlobRepairFunc fetches a good copy of a blob of repository, e.g. from
+// P2P peers or an upstream registry. repository is empty when no tag
+// references the blob. The copy is verified before it is stored.
+type BlobRepairFunc func(ctx context.Context, repository, digest string) (io.ReadCloser, error)
+
+// ScrubNotifyFunc receives a summary when a pass finds corrupted blobs.
+type ScrubNotifyFunc func(level, title, message string)
+
+// ScrubFinding describes a corrupted blob.
+type ScrubFinding struct {
+	Digest       string    `json:"digest"`
+	Problem      string    `json:"problem"`          // digest_mismatch, unreadable
+	Actual       string    `json:"actual,omitempty"` // digest of the stored content
+	Error        string    `json:"error,omitempty"`
+	Repositories []string  `json:"repositories,omitempty"`
+	Quarantined  []string  `json:"quarantined,omitempty"` // files moved to _quarantine
+	Repaired     bool      `json:"repaired"`
+	RepairedFrom string    `json:"repaired_from,omitempty"`
+	RepairError  string    `json:"repair_error,omitempty"`
+	FoundAt      time.Time `json:"found_at"`
+}
+
+// ScrubReport summarizes a scrub pass.
+type ScrubReport struct {
+	Checked      int            `json:"checked"`
+	CheckedBytes int64          `json:"checked_bytes"`
+	Corrupted    int            `json:"corrupted"`
+	Repaired     int            `json:"repaired"`
+	Findings     []ScrubFinding `json:"findings"`
+	Wrapped      bool           `json:"wrapped"` // the pass reached the last blob
+	StartedAt    time.Time      `json:"started_at"`
+	CompletedAt  time.Time      `json:"completed_at"`
+}
+
+// ScrubState is kept between passes in the metadata directory.
+type ScrubState struct {
+	// Cursor is the digest the next pass starts after, empty to start over
+	Cursor string `json:"cursor"`
+	// CycleCompletedAt is when every blob was last verified
+	CycleCompletedAt *time.Time   `json:"cycle_completed_at,omitempty"`
+	LastPass         *ScrubReport `json:"last_pass,omitempty"`
+	// Unrepaired are quarantined blobs without a good copy yet; each pass
+	// tries to repair them again
+	Unrepaired []ScrubFinding `json:"unrepaired"`
+}
+
+type namedRepairer struct {
+	name string
+	fn   BlobRepairFunc
+}
+
+// AddBlobRepairer adds a source of good copies for corrupted blobs. Sources
+// are tried in the order they were added.
+func (s *Service) AddBlobRepairer(name string, fn BlobRepairFunc) {
+	s.scrubMu.Lock()
+	defer s.scrubMu.Unlock()
+	s.repairers = append(s.repairers, namedRepairer{name: name, fn: fn})
+}
+
+// SetScrubNotifier sets the callback told about corrupted blobs.
+func (s *Service) SetScrubNotifier(fn ScrubNotifyFunc) {
+	s.scrubMu.Lock()
+	defer s.scrubMu.Unlock()
+	s.scrubNotify = fn
+}
+
+// SetScrubBatch sets how many bytes a scheduled pass verifies, 0 for all.
+func (s *Service) SetScrubBatch(maxBytes int64) {
+	s.scrubBatch.Store(maxBytes)
+}
+
+// ScrubBlobs runs a scheduled scrub pass. A pass cut short by the deadline
+// of the task is not a failure; the next one continues where it stopped.
+func (s *Service) ScrubBlobs(ctx context.Context) error {
+	_, err := s.Scrub(ctx, s.scrubBatch.Load())
+	if errors.Is(err, context.DeadlineExceeded) {
+		return nil
+	}
+	return err
+}
+
+// ScrubState returns the state kept between passes.
+func (s *Service) ScrubState() (*ScrubState, error) {
+	return s.storage.loadScrubState()
+}
+
+// Scrub re-hashes stored blobs against their digests, starting after the
+// blob the previous pass stopped at, until maxBytes were read (0 reads all
+// blobs once). Corrupted blobs are quarantined and repaired from the
+// repairers when one has a good copy. Only one pass may run at a time.
+func (s *Service) Scrub(ctx context.Context, maxBytes int64) (*ScrubReport, error) {
+	if !s.scrubRunMu.TryLock() {
+		return nil, ErrScrubRunning
+	}
+	defer s.scrubRunMu.Unlock()
+
+	state, err := s.storage.loadScrubState()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := s.storage.listBlobFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ScrubReport{Findings: []ScrubFinding{}, StartedAt: time.Now().UTC()}
+
+	// Retry the blobs that could not be repaired before
+	var unrepaired []ScrubFinding
+	for _, finding := range state.Unrepaired {
+		if s.storage.BlobExists(finding.Digest) {
+			continue // pushed again meanwhile
+		}
+		from, err := s.repairBlob(ctx, finding.Digest)
+		if err != nil {
+			finding.RepairError = err.Error()
+			unrepaired = append(unrepaired, finding)
+			continue
+		}
+		finding.Repaired, finding.RepairedFrom, finding.RepairError = true, from, ""
+		report.Repaired++
+		report.Findings = append(report.Findings, finding)
+	}
+
+	start := 0
+	for start < len(paths) && filepath.Base(paths[start]) <= state.Cursor {
+		start++
+	}
+	for i := start; i < len(paths); i++ {
+		if err := ctx.Err(); err != nil {
+			break
+		}
+		if maxBytes > 0 && report.CheckedBytes >= maxBytes {
+			break
+		}
+
+		path := paths[i]
+		state.Cursor = filepath.Base(path)
+		digest := "sha256:" + state.Cursor
+		n, finding, gone := verifyBlobFile(path, digest)
+		if gone {
+			continue // deleted meanwhile
+		}
+		report.Checked++
+		report.CheckedBytes += n
+		if finding == nil {
+			continue
+		}
+		report.Corrupted++
+
+		finding.Repositories = s.RepositoriesForBlob(digest)
+		finding.Quarantined, err = s.storage.quarantineBlob(path)
+		if err != nil {
+			finding.Error = fmt.Sprintf("%s; quarantine failed: %v", finding.Error, err)
+		}
+		if from, err := s.repairBlob(ctx, digest); err == nil {
+			finding.Repaired, finding.RepairedFrom = true, from
+			report.Repaired++
+		} else {
+			finding.RepairError = err.Error()
+			unrepaired = append(unrepaired, *finding)
+		}
+		report.Findings = append(report.Findings, *finding)
+	}
+	if start >= len(paths) || filepath.Base(paths[len(paths)-1]) == state.Cursor {
+		report.Wrapped = true
+		state.Cursor = ""
+		now := time.Now().UTC()
+		state.CycleCompletedAt = &now
+	}
+
+	report.CompletedAt = time.Now().UTC()
+	state.LastPass = report
+	state.Unrepaired = unrepaired
+	if err := s.storage.saveScrubState(state); err != nil {
+		return report, err
+	}
+
+	if report.Corrupted > 0 {
+		s.notifyScrub(report, len(unrepaired))
+	}
+	return report, ctx.Err()
+}
+
+// verifyBlobFile hashes the content of a blob. It returns the bytes read,
+// a finding when the content does not match digest, and whether the blob
+// no longer exists.
+func verifyBlobFile(path, digest string) (int64, *ScrubFinding, bool) {
+	reader, _, err := compression.OpenBlobFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil, true
+		}
+		return 0, &ScrubFinding{Digest: digest, Problem: ScrubUnreadable, Error: err.Error(), FoundAt: time.Now().UTC()}, false
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, reader)
+	if err != nil {
+		return n, &ScrubFinding{Digest: digest, Problem: ScrubUnreadable, Error: err.Error(), FoundAt: time.Now().UTC()}, false
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return n, &ScrubFinding{Digest: digest, Problem: ScrubDigestMismatch, Actual: actual, FoundAt: time.Now().UTC()}, false
+	}
+	return n, nil, false
+}
+
+// repairBlob stores a good copy of digest from the first repairer that has
+// one, and returns its name.
+func (s *Service) repairBlob(ctx context.Context, digest string) (string, error) {
+	s.scrubMu.Lock()
+	repairers := s.repairers
+	s.scrubMu.Unlock()
+	if len(repairers) == 0 {
+		return "", errors.New("no repair source configured")
+	}
+
+	repositories := s.RepositoriesForBlob(digest)
+	if len(repositories) == 0 {
+		repositories = []string{""}
+	}
+
+	var lastErr error
+	for _, r := range repairers {
+		for _, repository := range repositories {
+			reader, err := r.fn(ctx, repository, digest)
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %w", r.name, err)
+				continue
+			}
+			_, err = s.storage.SaveBlobWithDigest(digest, reader)
+			reader.Close()
+			if err != nil {
+				lastErr = fmt.Errorf("%s: %w", r.name, err)
+				continue
+			}
+			return r.name, nil
+		}
+	}
+	return "", lastErr
+}
+
+// notifyScrub reports the corrupted blobs of a pass.
+func (s *Service) notifyScrub(report *ScrubReport, unrepaired int) {
+	s.scrubMu.Lock()
+	notify := s.scrubNotify
+	s.scrubMu.Unlock()
+	if notify == nil {
+		return
+	}
+
+	level := "warning"
+	if unrepaired > 0 {
+		level = "error"
+	}
+	message := fmt.Sprintf("存储完整性检查发现 %d 个损坏的 Blob，已修复 %d 个，%d 个等待修复：", report.Corrupted, report.Repaired, unrepaired)
+	for _, f := range report.Findings {
+		message += "\n" + f.Digest + " (" + f.Problem + ")"
+	}
+	notify(level, "存储完整性检查发现损坏的 Blob", message)
+}
+
+// quarantineBlob moves every compression variant of the blob at path to
+// the quarantine directory and returns the new paths.
+func (s *Storage) quarantineBlob(path string) ([]string, error) {
+	dir := filepath.Join(s.blobPath, quarantineDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	prefix := strconv.FormatInt(time.Now().Unix(), 10) + "-"
+	var moved []string
+	for _, ext := range []string{"", ".zst", ".gz"} {
+		target := filepath.Join(dir, prefix+filepath.Base(path)+ext)
+		if err := os.Rename(path+ext, target); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return moved, err
+		}
+		moved = append(moved, filepath.Join(quarantineDir, filepath.Base(target)))
+	}
+	return moved, nil
+}
+
+// getScrubStatePath returns the path of the scrub state file.
+func (s *Storage) getScrubStatePath() string {
+	return filepath.Join(s.metaPath, "scrub.json")
+}
+
+// loadScrubState reads the scrub state, empty before the first pass.
+func (s *Storage) loadScrubState() (*ScrubState, error) {
+	data, err := os.ReadFile(s.getScrubStatePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &ScrubState{Unrepaired: []ScrubFinding{}}, nil
+		}
+		return nil, fmt.Errorf("failed to read scrub state: %w", err)
+	}
+	var state ScrubState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse scrub state: %w", err)
+	}
+	if state.Unrepaired == nil {
+		state.Unrepaired = []ScrubFinding{}
+	}
+	return &state, nil
+}
+
+// saveScrubState writes the scrub state.
+func (s *Storage) saveScrubState(state *ScrubState) error {
+	if state.Unrepaired == nil {
+		state.Unrepaired = []ScrubFinding{}
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.getScrubStatePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write scrub state: %w", err)
+	}
+	return os.Rename(tmp, s.getScrubStatePath())
+}
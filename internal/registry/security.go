@@ -0,0 +1,60 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"sort"
+
+	"cyp-docker-registry/internal/service"
+)
+
+// TaggedImages lists the tagged images of every repository, without the
+// referrers such as signatures and attestations.
+func (s *Service) TaggedImages() ([]service.TaggedImage, error) {
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	var images []service.TaggedImage
+	for name, tags := range store.Images {
+		for tag, info := range tags {
+			if info.Subject != "" {
+				continue
+			}
+			images = append(images, service.TaggedImage{
+				Repository: name,
+				Tag:        tag,
+				Digest:     info.Digest,
+				PushedAt:   info.CreatedAt,
+			})
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].Repository != images[j].Repository {
+			return images[i].Repository < images[j].Repository
+		}
+		return images[i].Tag < images[j].Tag
+	})
+	return images, nil
+}
+
+// QuarantinedBlobs lists the corrupted blobs the scrub moved to quarantine
+// and could not repair yet, with the repositories referencing them.
+func (s *Service) QuarantinedBlobs() ([]service.QuarantinedBlob, error) {
+	state, err := s.storage.loadScrubState()
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make([]service.QuarantinedBlob, 0, len(state.Unrepaired))
+	for _, f := range state.Unrepaired {
+		blobs = append(blobs, service.QuarantinedBlob{
+			Digest:       f.Digest,
+			Problem:      f.Problem,
+			Repositories: f.Repositories,
+			FoundAt:      f.FoundAt,
+			RepairError:  f.RepairError,
+		})
+	}
+	return blobs, nil
+}
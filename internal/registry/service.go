@@ -3,12 +3,21 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/internal/service"
 )
 
 // ImageList represents a paginated list of images.
@@ -23,21 +32,57 @@ type ImageList struct {
 // Service provides registry operations.
 type Service struct {
 	storage *Storage
+
+	indexMu     sync.Mutex
+	blobIndex   map[string][]string
+	blobIndexAt time.Time
+
+	usage usageIndex
+
+	// Chart.yaml metadata by manifest digest, see charts.go
+	charts sync.Map
+
+	// How long deleted tags stay restorable, 0 deletes immediately. It can
+	// be changed at runtime through the settings API.
+	trashRetention atomic.Int64
+
+	// Garbage collection, see gc.go
+	gcMu     sync.Mutex
+	gcNotify GCProgressFunc
+
+	// Integrity scrubbing, see scrub.go
+	scrubRunMu  sync.Mutex
+	scrubMu     sync.Mutex // guards the fields below
+	repairers   []namedRepairer
+	scrubNotify ScrubNotifyFunc
+	scrubBatch  atomic.Int64
+
+	// Free space watchdog, see diskguard.go
+	disk diskGuard
 }
 
 // NewService creates a new registry service.
 func NewService(storage *Storage) *Service {
-	return &Service{
-		storage: storage,
-	}
+	s := &Service{storage: storage}
+	s.trashRetention.Store(int64(DefaultTrashRetention))
+	return s
 }
 
 // PushManifest stores an image manifest.
 func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageManifest, error) {
+	return s.PushManifestAs(name, tag, manifestData, "")
+}
+
+// PushManifestAs stores an image manifest, recording who pushed it.
+func (s *Service) PushManifestAs(name, tag string, manifestData []byte, pushedBy string) (*ImageManifest, error) {
 	// First, try to detect manifest type
 	var baseManifest struct {
 		SchemaVersion int    `json:"schemaVersion"`
 		MediaType     string `json:"mediaType"`
+		ArtifactType  string `json:"artifactType"`
+		Subject       *struct {
+			Digest string `json:"digest"`
+		} `json:"subject"`
 	}
 
 	if err := json.Unmarshal(manifestData, &baseManifest); err != nil {
@@ -50,6 +95,18 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 
 	var totalSize int64
 	var layers []Layer
+	var references []string // blobs the manifest points to
+	artifactType := baseManifest.ArtifactType
+
+	// The subject of a referrer need not be pushed yet, so it is not a
+	// reference, see referrers.go
+	var subject string
+	if baseManifest.Subject != nil {
+		subject = baseManifest.Subject.Digest
+		if !sha256DigestPattern.MatchString(subject) {
+			return nil, fmt.Errorf("invalid manifest: unsupported subject digest %q", subject)
+		}
+	}
 
 	// Check if this is a manifest list/index (multi-arch image)
 	if baseManifest.MediaType == "application/vnd.docker.distribution.manifest.list.v2+json" ||
@@ -76,6 +133,7 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 		var targetDigest string
 		var targetSize int64
 		for _, m := range manifestList.Manifests {
+			references = append(references, m.Digest)
 			totalSize += m.Size
 			if m.Platform.OS == "linux" && m.Platform.Architecture == "amd64" {
 				targetDigest = m.Digest
@@ -111,7 +169,8 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 	} else {
 		// Parse as regular manifest (v2 or OCI)
 		var rawManifest struct {
-			Config struct {
+			ArtifactType string `json:"artifactType"`
+			Config       struct {
 				MediaType string `json:"mediaType"`
 				Size      int64  `json:"size"`
 				Digest    string `json:"digest"`
@@ -127,8 +186,16 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 			return nil, fmt.Errorf("invalid manifest format: %w", err)
 		}
 
+		// schema1 manifests have no config
+		if rawManifest.Config.Digest != "" {
+			references = append(references, rawManifest.Config.Digest)
+		}
+
 		// Calculate total size from layers
+		var layerTypes []string
 		for _, l := range rawManifest.Layers {
+			layerTypes = append(layerTypes, l.MediaType)
+			references = append(references, l.Digest)
 			totalSize += l.Size
 			layers = append(layers, Layer{
 				Digest:    l.Digest,
@@ -136,6 +203,11 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 				MediaType: l.MediaType,
 			})
 		}
+		artifactType = manifestArtifactType(rawManifest.ArtifactType, rawManifest.Config.MediaType, layerTypes)
+	}
+
+	if err := s.checkReferences(references); err != nil {
+		return nil, err
 	}
 
 	// Store manifest as blob
@@ -145,12 +217,16 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 
 	// Create image manifest
 	manifest := &ImageManifest{
-		Name:      name,
-		Tag:       tag,
-		Digest:    digest,
-		Size:      totalSize,
-		CreatedAt: time.Now().UTC(),
-		Layers:    layers,
+		Name:         name,
+		Tag:          tag,
+		Digest:       digest,
+		Size:         totalSize,
+		CreatedAt:    time.Now().UTC(),
+		Layers:       layers,
+		PushedBy:     pushedBy,
+		ArtifactType: artifactType,
+		Category:     ArtifactCategory(artifactType),
+		Subject:      subject,
 	}
 
 	// Save metadata
@@ -158,9 +234,47 @@ func (s *Service) PushManifest(name, tag string, manifestData []byte) (*ImageMan
 		return nil, fmt.Errorf("failed to save image metadata: %w", err)
 	}
 
+	// Index annotations and config labels for label search; the push
+	// itself succeeded, so a failure only leaves the labels out of search
+	manifest.Annotations, manifest.Labels = s.extractLabels(manifestData)
+	_ = indexLabels(digest, manifest.Annotations, manifest.Labels)
+
 	return manifest, nil
 }
 
+// MissingBlobsError is returned when a pushed manifest references blobs,
+// or child manifests of an index, that are not stored.
+type MissingBlobsError struct {
+	Digests []string
+}
+
+func (e *MissingBlobsError) Error() string {
+	return "manifest references unknown blobs: " + strings.Join(e.Digests, ", ")
+}
+
+// checkReferences verifies that every referenced digest is well-formed and
+// stored, so a tag never points at an image that cannot be pulled.
+func (s *Service) checkReferences(references []string) error {
+	var missing []string
+	seen := make(map[string]bool, len(references))
+	for _, digest := range references {
+		if seen[digest] {
+			continue
+		}
+		seen[digest] = true
+		if !sha256DigestPattern.MatchString(digest) {
+			return fmt.Errorf("invalid manifest: unsupported digest %q", digest)
+		}
+		if !s.storage.BlobExists(digest) {
+			missing = append(missing, digest)
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingBlobsError{Digests: missing}
+	}
+	return nil
+}
+
 // resolveManifestLayers tries to resolve layers from a manifest digest
 func (s *Service) resolveManifestLayers(digest string) ([]Layer, int64) {
 	reader, _, err := s.storage.GetBlob(digest)
@@ -223,29 +337,83 @@ func (s *Service) PullManifest(name, tag string) ([]byte, *ImageManifest, error)
 	return data, manifest, nil
 }
 
+// ManifestDigests returns the manifest digest name:tag points at, followed
+// by the platform manifests when it is a manifest list or image index. A
+// digest given as tag is used as is.
+func (s *Service) ManifestDigests(name, tag string) ([]string, error) {
+	digest := tag
+	if !strings.HasPrefix(tag, "sha256:") {
+		image, err := s.storage.GetImage(name, tag)
+		if err != nil {
+			return nil, err
+		}
+		digest = image.Digest
+	}
+	digests := []string{digest}
+
+	reader, _, err := s.storage.GetBlob(digest)
+	if err != nil {
+		return digests, nil
+	}
+	defer reader.Close()
+
+	var manifest ociManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil || !manifest.isIndex() {
+		return digests, nil
+	}
+	for _, m := range manifest.Manifests {
+		digests = append(digests, m.Digest)
+	}
+	return digests, nil
+}
+
 // DeleteImage removes an image and its associated data.
 func (s *Service) DeleteImage(name, tag string) error {
+	return s.DeleteImageAs(name, tag, "")
+}
+
+// DeleteImageAs deletes name:tag on behalf of deletedBy. The tag is moved to
+// the recycle bin unless trash retention is disabled.
+func (s *Service) DeleteImageAs(name, tag, deletedBy string) error {
+	if retention := s.TrashRetention(); retention > 0 {
+		_, err := s.storage.TrashImage(name, tag, deletedBy, retention)
+		return err
+	}
+	return s.deleteImageNow(name, tag)
+}
+
+// deleteImageNow removes the tag metadata and its manifest blob permanently.
+func (s *Service) deleteImageNow(name, tag string) error {
 	// Get image metadata first
 	manifest, err := s.storage.GetImage(name, tag)
 	if err != nil {
 		return err
 	}
 
-	// Delete manifest blob
-	if err := s.storage.DeleteBlob(manifest.Digest); err != nil {
-		// Log but don't fail - blob might be shared
+	// Delete metadata
+	if err := s.storage.DeleteImage(name, tag); err != nil {
+		return err
+	}
+
+	// Delete manifest blob unless another tag (e.g. a retag) still uses it
+	if !s.storage.digestReferenced(manifest.Digest) {
+		if err := s.storage.DeleteBlob(manifest.Digest); err != nil {
+			// Log but don't fail - blob might be shared
+		}
+		if dao.GetDB() != nil {
+			_ = dao.DeleteImageLabels(manifest.Digest)
+		}
 	}
 
 	// Delete layer blobs (only if not shared by other images)
 	// For simplicity, we'll skip layer deletion here
 	// A proper implementation would track blob references
-
-	// Delete metadata
-	return s.storage.DeleteImage(name, tag)
+	return nil
 }
 
-// ListImages returns a paginated list of images.
-func (s *Service) ListImages(page, pageSize int) (*ImageList, error) {
+// ListImages returns a paginated list of images. If include is not nil,
+// only images of repositories it accepts are listed.
+func (s *Service) ListImages(include func(repository string) bool, page, pageSize int) (*ImageList, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -256,10 +424,13 @@ func (s *Service) ListImages(page, pageSize int) (*ImageList, error) {
 		pageSize = 100
 	}
 
-	images, total, err := s.storage.ListImages(page, pageSize)
+	images, total, err := s.storage.ListImages(include, page, pageSize)
 	if err != nil {
 		return nil, err
 	}
+	if err := fillStarCounts(images); err != nil {
+		return nil, err
+	}
 
 	totalPages := (total + pageSize - 1) / pageSize
 	if totalPages < 1 {
@@ -275,8 +446,11 @@ func (s *Service) ListImages(page, pageSize int) (*ImageList, error) {
 	}, nil
 }
 
-// SearchImages searches images by keyword.
-func (s *Service) SearchImages(keyword string, page, pageSize int) (*ImageList, error) {
+// SearchImages searches images by keyword, label selectors and artifact
+// categories, see ParseLabelSelectors and ParseArtifactCategories. An image
+// must match every selector and one of the categories. If include is not
+// nil, only images of repositories it accepts are returned.
+func (s *Service) SearchImages(keyword string, selectors []string, categories map[string]bool, include func(repository string) bool, page, pageSize int) (*ImageList, error) {
 	if page < 1 {
 		page = 1
 	}
@@ -287,10 +461,34 @@ func (s *Service) SearchImages(keyword string, page, pageSize int) (*ImageList,
 		pageSize = 100
 	}
 
-	images, total, err := s.storage.SearchImages(keyword, page, pageSize)
+	var digests map[string]bool
+	if len(selectors) > 0 {
+		parsed, err := ParseLabelSelectors(selectors)
+		if err != nil {
+			return nil, err
+		}
+		digests = make(map[string]bool)
+		if dao.GetDB() != nil {
+			matched, err := dao.FindDigestsByLabels(parsed)
+			if err != nil {
+				return nil, err
+			}
+			for _, d := range matched {
+				digests[d] = true
+			}
+		}
+	}
+
+	images, total, err := s.storage.SearchImages(keyword, digests, categories, include, page, pageSize)
 	if err != nil {
 		return nil, err
 	}
+	if err := fillLabels(images); err != nil {
+		return nil, err
+	}
+	if err := fillStarCounts(images); err != nil {
+		return nil, err
+	}
 
 	totalPages := (total + pageSize - 1) / pageSize
 	if totalPages < 1 {
@@ -311,9 +509,35 @@ func (s *Service) PushBlob(data io.Reader) (string, int64, error) {
 	return s.storage.SaveBlob(data)
 }
 
-// PushBlobWithDigest stores a blob with a known digest.
-func (s *Service) PushBlobWithDigest(digest string, data io.Reader) (int64, error) {
-	return s.storage.SaveBlobWithDigest(digest, data)
+// PushBlobWithDigest stores a blob with a known digest uploaded to
+// repository and links it to the repository.
+func (s *Service) PushBlobWithDigest(repository, digest string, data io.Reader) (int64, error) {
+	size, err := s.storage.SaveBlobWithDigest(digest, data)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.storage.LinkBlob(repository, digest); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// MountBlob makes a blob of repository from available to repository to and
+// returns its size. It reports false when from does not reference the
+// blob, in which case the client has to upload it.
+func (s *Service) MountBlob(from, to, digest string) (int64, bool) {
+	if !sha256DigestPattern.MatchString(digest) || !s.storage.RepositoryHasBlob(from, digest) {
+		return 0, false
+	}
+	reader, size, err := s.storage.GetBlob(digest)
+	if err != nil {
+		return 0, false
+	}
+	reader.Close()
+	if err := s.storage.LinkBlob(to, digest); err != nil {
+		return 0, false
+	}
+	return size, true
 }
 
 // PullBlob retrieves a blob by digest.
@@ -326,9 +550,14 @@ func (s *Service) BlobExists(digest string) bool {
 	return s.storage.BlobExists(digest)
 }
 
-// DeleteBlob removes a blob by digest.
-func (s *Service) DeleteBlob(digest string) error {
-	return s.storage.DeleteBlob(digest)
+// DeleteBlob drops the reference of repository to a blob. The blob itself
+// is stored once for all namespaces and only removed when none of them
+// references it any more, see Storage.UnlinkBlob.
+func (s *Service) DeleteBlob(repository, digest string) error {
+	if !sha256DigestPattern.MatchString(digest) {
+		return ErrBlobUnknown
+	}
+	return s.storage.UnlinkBlob(repository, digest)
 }
 
 // GetImage retrieves image metadata.
@@ -336,7 +565,98 @@ func (s *Service) GetImage(name, tag string) (*ImageManifest, error) {
 	return s.storage.GetImage(name, tag)
 }
 
+// GetImageWithLabels retrieves image metadata with its annotations and
+// config labels.
+func (s *Service) GetImageWithLabels(name, tag string) (*ImageManifest, error) {
+	image, err := s.storage.GetImage(name, tag)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillLabels([]*ImageManifest{image}); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// BlobStats reports logical versus stored blob sizes.
+func (s *Service) BlobStats() (*BlobStats, error) {
+	return s.storage.BlobStats()
+}
+
+// ListTags returns a page of tags of a repository, see Storage.ListTags.
+func (s *Service) ListTags(name string, n int, last string) ([]string, bool, error) {
+	return s.storage.ListTags(name, n, last)
+}
+
+// RepositoryTags returns every tag of a repository with its metadata.
+func (s *Service) RepositoryTags(name string) ([]*ImageManifest, error) {
+	return s.storage.RepositoryTags(name)
+}
+
+// RecordPull counts a pull of name:tag.
+func (s *Service) RecordPull(name, tag string) {
+	s.storage.RecordPull(name, tag)
+}
+
 // GetStorage returns the underlying storage (for advanced operations).
 func (s *Service) GetStorage() *Storage {
 	return s.storage
 }
+
+// CleanupImages deletes image tags that fall outside the retention policy.
+// A tag is kept if it is among the KeepCount newest tags of its repository
+// or was pushed within the last KeepDays days.
+func (s *Service) CleanupImages(ctx context.Context, policy *service.RetentionPolicy) (*service.CleanupResult, error) {
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &service.CleanupResult{Deleted: []string{}, DryRun: policy.DryRun}
+	cutoff := time.Time{}
+	if policy.KeepDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -policy.KeepDays)
+	}
+
+	for name, tags := range store.Images {
+		if policy.Repository != "" {
+			if ok, _ := path.Match(policy.Repository, name); !ok {
+				continue
+			}
+		}
+
+		type tagEntry struct {
+			tag       string
+			createdAt time.Time
+		}
+		entries := make([]tagEntry, 0, len(tags))
+		for tag, info := range tags {
+			entries = append(entries, tagEntry{tag: tag, createdAt: info.CreatedAt})
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].createdAt.After(entries[j].createdAt)
+		})
+
+		for i, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return result, err
+			}
+
+			keep := (policy.KeepCount > 0 && i < policy.KeepCount) ||
+				(!cutoff.IsZero() && e.createdAt.After(cutoff))
+			if keep {
+				result.Kept++
+				continue
+			}
+
+			if !policy.DryRun {
+				if err := s.DeleteImage(name, e.tag); err != nil {
+					return result, fmt.Errorf("failed to delete %s:%s: %w", name, e.tag, err)
+				}
+			}
+			result.Deleted = append(result.Deleted, name+":"+e.tag)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"cyp-docker-registry/internal/dao"
+)
+
+// fillStarCounts attaches the star counts of their repositories to images.
+func fillStarCounts(images []*ImageManifest) error {
+	if len(images) == 0 || dao.GetDB() == nil {
+		return nil
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, img := range images {
+		if !seen[img.Name] {
+			seen[img.Name] = true
+			names = append(names, img.Name)
+		}
+	}
+
+	counts, err := dao.CountRepositoryStars(names)
+	if err != nil {
+		return err
+	}
+	for _, img := range images {
+		img.StarCount = counts[img.Name]
+	}
+	return nil
+}
+
+// RepositoryStars returns the star count of a repository and whether the
+// user with userID starred it; userID 0 means anonymous.
+func (s *Service) RepositoryStars(name string, userID int64) (int64, bool, error) {
+	if dao.GetDB() == nil {
+		return 0, false, nil
+	}
+
+	counts, err := dao.CountRepositoryStars([]string{name})
+	if err != nil {
+		return 0, false, err
+	}
+	if userID == 0 {
+		return counts[name], false, nil
+	}
+	starred, err := dao.IsRepositoryStarred(userID, name)
+	return counts[name], starred, err
+}
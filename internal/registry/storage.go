@@ -5,12 +5,17 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"cyp-docker-registry/pkg/compression"
 )
 
 // Layer represents an image layer.
@@ -22,25 +27,51 @@ type Layer struct {
 
 // ImageManifest represents image metadata.
 type ImageManifest struct {
-	Name      string    `json:"name"`
-	Tag       string    `json:"tag"`
-	Digest    string    `json:"digest"`
-	Size      int64     `json:"size"`
-	CreatedAt time.Time `json:"created_at"`
-	Layers    []Layer   `json:"layers"`
+	Name         string     `json:"name"`
+	Tag          string     `json:"tag"`
+	Digest       string     `json:"digest"`
+	Size         int64      `json:"size"`
+	CreatedAt    time.Time  `json:"created_at"`
+	Layers       []Layer    `json:"layers"`
+	PushedBy     string     `json:"pushed_by,omitempty"`
+	PullCount    int64      `json:"pull_count"`
+	LastPulledAt *time.Time `json:"last_pulled_at,omitempty"`
+	// ArtifactType identifies OCI artifacts such as Helm charts, empty for
+	// container images; Category is derived from it, see artifacts.go
+	ArtifactType string `json:"artifact_type,omitempty"`
+	Category     string `json:"category"`
+	// Subject is the digest of the manifest this one refers to, set for
+	// referrers such as signatures and attestations, see referrers.go
+	Subject string `json:"subject,omitempty"`
+
+	// Filled from the database, not stored with the tag
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	StarCount   int64             `json:"star_count"`
 }
 
 // TagInfo represents tag information for an image.
 type TagInfo struct {
-	Digest    string    `json:"digest"`
-	Size      int64     `json:"size"`
-	CreatedAt time.Time `json:"created_at"`
-	Layers    []Layer   `json:"layers"`
+	Digest       string     `json:"digest"`
+	Size         int64      `json:"size"`
+	CreatedAt    time.Time  `json:"created_at"` // time of the last push
+	Layers       []Layer    `json:"layers"`
+	PushedBy     string     `json:"pushed_by,omitempty"`
+	PullCount    int64      `json:"pull_count,omitempty"`
+	LastPulledAt *time.Time `json:"last_pulled_at,omitempty"`
+	ArtifactType string     `json:"artifact_type,omitempty"`
+	Subject      string     `json:"subject,omitempty"`
+	// Blobs lists every digest the image holds, see blob_refs.go
+	Blobs []string `json:"blobs,omitempty"`
 }
 
 // ImageStore represents the image metadata store structure.
 type ImageStore struct {
-	Images map[string]map[string]*TagInfo `json:"images"` // name -> tag -> TagInfo
+	Images map[string]map[string]*TagInfo `json:"images"`          // name -> tag -> TagInfo
+	Trash  map[string]*TrashEntry         `json:"trash,omitempty"` // id -> deleted tag, see trash.go
+	// Namespaces is the blob reference index, namespace -> references,
+	// rebuilt on every save, see blob_refs.go
+	Namespaces map[string]*NamespaceRefs `json:"namespaces,omitempty"`
 }
 
 // Storage handles blob and metadata storage operations.
@@ -48,6 +79,27 @@ type Storage struct {
 	blobPath string
 	metaPath string
 	mu       sync.RWMutex
+	// metaLock serializes metadata updates with other instances sharing
+	// metaPath, see SetMetadataLocker
+	metaLock MetadataLocker
+
+	// Storage-side transcoding, see transcode.go
+	compression      compression.Algorithm
+	compressionLevel int
+	compressMinSize  int64
+
+	// Pull counters not yet written to metadata, see tags.go
+	pullMu        sync.Mutex
+	pendingPulls  map[tagKey]*pendingPull
+	pullFlushedAt time.Time
+
+	// Set while the blob volume is below the read-only floor, see
+	// diskguard.go
+	readOnly atomic.Bool
+
+	// Set while a rebuilt blob reference index is being saved, see
+	// blob_refs.go
+	indexing atomic.Bool
 }
 
 // NewStorage creates a new Storage instance.
@@ -61,14 +113,19 @@ func NewStorage(blobPath, metaPath string) (*Storage, error) {
 	}
 
 	return &Storage{
-		blobPath: blobPath,
-		metaPath: metaPath,
+		blobPath:     blobPath,
+		metaPath:     metaPath,
+		pendingPulls: make(map[tagKey]*pendingPull),
 	}, nil
 }
 
 
 // SaveBlob saves blob data and returns its digest.
 func (s *Storage) SaveBlob(data io.Reader) (string, int64, error) {
+	if err := s.checkWritable(); err != nil {
+		return "", 0, err
+	}
+
 	// Create temp file first
 	tempFile, err := os.CreateTemp(s.blobPath, "blob-*.tmp")
 	if err != nil {
@@ -96,68 +153,79 @@ func (s *Storage) SaveBlob(data io.Reader) (string, int64, error) {
 	// Generate digest
 	digest := "sha256:" + hex.EncodeToString(hash.Sum(nil))
 
-	// Move to final location
-	finalPath := s.getBlobPath(digest)
-	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
-		return "", 0, fmt.Errorf("failed to create blob directory: %w", err)
-	}
-
-	if err := os.Rename(tempPath, finalPath); err != nil {
-		return "", 0, fmt.Errorf("failed to move blob: %w", err)
+	// Move to final location, transcoding if enabled
+	if err := s.storeBlobFile(tempPath, digest, size); err != nil {
+		return "", 0, err
 	}
 
 	return digest, size, nil
 }
 
-// SaveBlobWithDigest saves blob data with a known digest.
+// ErrDigestInvalid is returned when blob content does not match the digest
+// it was uploaded under, or the digest is malformed.
+var ErrDigestInvalid = errors.New("digest invalid")
+
+// sha256DigestPattern matches the only digest algorithm blobs are stored by.
+var sha256DigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// SaveBlobWithDigest saves blob data with a known digest. The content is
+// hashed while it is written; on a mismatch nothing is stored and
+// ErrDigestInvalid is returned, so a client cannot store content under
+// another blob's digest.
 func (s *Storage) SaveBlobWithDigest(digest string, data io.Reader) (int64, error) {
-	finalPath := s.getBlobPath(digest)
-	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
-		return 0, fmt.Errorf("failed to create blob directory: %w", err)
+	if !sha256DigestPattern.MatchString(digest) {
+		return 0, fmt.Errorf("%w: unsupported digest %q", ErrDigestInvalid, digest)
+	}
+	if err := s.checkWritable(); err != nil {
+		return 0, err
 	}
 
-	file, err := os.Create(finalPath)
+	tempFile, err := os.CreateTemp(s.blobPath, "blob-*.tmp")
 	if err != nil {
-		return 0, fmt.Errorf("failed to create blob file: %w", err)
+		return 0, fmt.Errorf("failed to create temp file: %w", err)
 	}
-	defer file.Close()
+	tempPath := tempFile.Name()
+	defer func() {
+		tempFile.Close()
+		os.Remove(tempPath)
+	}()
 
-	size, err := io.Copy(file, data)
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), data)
 	if err != nil {
-		os.Remove(finalPath)
 		return 0, fmt.Errorf("failed to write blob: %w", err)
 	}
+	if err := tempFile.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return 0, fmt.Errorf("%w: expected %s, got %s", ErrDigestInvalid, digest, actual)
+	}
+
+	if err := s.storeBlobFile(tempPath, digest, size); err != nil {
+		return 0, err
+	}
 
 	return size, nil
 }
 
-// GetBlob retrieves blob data by digest.
+// GetBlob retrieves blob data by digest. Transcoded blobs are decompressed
+// on the fly, so callers always see the original bytes and size.
 func (s *Storage) GetBlob(digest string) (io.ReadCloser, int64, error) {
-	blobPath := s.getBlobPath(digest)
-	file, err := os.Open(blobPath)
+	reader, size, err := compression.OpenBlobFile(s.getBlobPath(digest))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, 0, fmt.Errorf("blob not found: %s", digest)
 		}
 		return nil, 0, fmt.Errorf("failed to open blob: %w", err)
 	}
-
-	stat, err := file.Stat()
-	if err != nil {
-		file.Close()
-		return nil, 0, fmt.Errorf("failed to stat blob: %w", err)
-	}
-
-	return file, stat.Size(), nil
+	return reader, size, nil
 }
 
 // DeleteBlob removes a blob by digest.
 func (s *Storage) DeleteBlob(digest string) error {
-	blobPath := s.getBlobPath(digest)
-	if err := os.Remove(blobPath); err != nil {
-		if os.IsNotExist(err) {
-			return nil // Already deleted
-		}
+	if err := compression.RemoveBlobFile(s.getBlobPath(digest)); err != nil {
 		return fmt.Errorf("failed to delete blob: %w", err)
 	}
 	return nil
@@ -165,9 +233,7 @@ func (s *Storage) DeleteBlob(digest string) error {
 
 // BlobExists checks if a blob exists.
 func (s *Storage) BlobExists(digest string) bool {
-	blobPath := s.getBlobPath(digest)
-	_, err := os.Stat(blobPath)
-	return err == nil
+	return compression.BlobFileExists(s.getBlobPath(digest))
 }
 
 // getBlobPath returns the file path for a blob digest.
@@ -219,27 +285,79 @@ func (s *Storage) loadMetadataUnsafe() (*ImageStore, error) {
 	if store.Images == nil {
 		store.Images = make(map[string]map[string]*TagInfo)
 	}
+	// Metadata written before the reference index existed
+	if store.needsBlobIndex() {
+		s.indexBlobRefs(&store, time.Now())
+		s.saveBlobIndex()
+	}
 
 	return &store, nil
 }
 
 // SaveMetadata saves image metadata to JSON file.
 func (s *Storage) SaveMetadata(store *ImageStore) error {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.saveMetadataUnsafe(store)
+}
+
+// MetadataLocker serializes metadata updates across instances. When Lock
+// fails, the update is aborted with its error.
+type MetadataLocker interface {
+	Lock() error
+	Unlock()
+}
+
+// SetMetadataLocker sets the lock taken around every metadata update in
+// addition to the local one, so instances sharing metaPath do not overwrite
+// each other's changes.
+func (s *Storage) SetMetadataLocker(l MetadataLocker) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.metaLock = l
+}
 
-	return s.saveMetadataUnsafe(store)
+// lockMetadata locks metadata for a read-modify-write and returns the
+// unlock function. It fails, holding no lock, when the lock shared with
+// other instances cannot be taken.
+func (s *Storage) lockMetadata() (func(), error) {
+	s.mu.Lock()
+	if s.metaLock == nil {
+		return s.mu.Unlock, nil
+	}
+	if err := s.metaLock.Lock(); err != nil {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("failed to lock metadata: %w", err)
+	}
+	return func() {
+		s.metaLock.Unlock()
+		s.mu.Unlock()
+	}, nil
 }
 
-// saveMetadataUnsafe saves metadata without locking (internal use).
+// saveMetadataUnsafe saves metadata without locking (internal use). The
+// blob reference index is rebuilt first so it always matches the tags. The
+// file is replaced by a rename so readers, including other instances, never
+// see a partial write.
 func (s *Storage) saveMetadataUnsafe(store *ImageStore) error {
+	s.indexBlobRefs(store, time.Now())
+
 	data, err := json.MarshalIndent(store, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
 	metaFile := s.getMetaFilePath()
-	if err := os.WriteFile(metaFile, data, 0644); err != nil {
+	tempFile := metaFile + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	if err := os.Rename(tempFile, metaFile); err != nil {
+		os.Remove(tempFile)
 		return fmt.Errorf("failed to write metadata: %w", err)
 	}
 
@@ -248,8 +366,11 @@ func (s *Storage) saveMetadataUnsafe(store *ImageStore) error {
 
 // SaveImage saves image manifest metadata.
 func (s *Storage) SaveImage(manifest *ImageManifest) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	store, err := s.loadMetadataUnsafe()
 	if err != nil {
@@ -262,12 +383,7 @@ func (s *Storage) SaveImage(manifest *ImageManifest) error {
 	}
 
 	// Save tag info
-	store.Images[manifest.Name][manifest.Tag] = &TagInfo{
-		Digest:    manifest.Digest,
-		Size:      manifest.Size,
-		CreatedAt: manifest.CreatedAt,
-		Layers:    manifest.Layers,
-	}
+	store.Images[manifest.Name][manifest.Tag] = newTagInfo(manifest, store.Images[manifest.Name][manifest.Tag])
 
 	return s.saveMetadataUnsafe(store)
 }
@@ -292,20 +408,16 @@ func (s *Storage) GetImage(name, tag string) (*ImageManifest, error) {
 		return nil, fmt.Errorf("tag not found: %s:%s", name, tag)
 	}
 
-	return &ImageManifest{
-		Name:      name,
-		Tag:       tag,
-		Digest:    tagInfo.Digest,
-		Size:      tagInfo.Size,
-		CreatedAt: tagInfo.CreatedAt,
-		Layers:    tagInfo.Layers,
-	}, nil
+	return s.imageFromTag(name, tag, tagInfo), nil
 }
 
 // DeleteImage removes image metadata.
 func (s *Storage) DeleteImage(name, tag string) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	store, err := s.loadMetadataUnsafe()
 	if err != nil {
@@ -331,8 +443,9 @@ func (s *Storage) DeleteImage(name, tag string) error {
 	return s.saveMetadataUnsafe(store)
 }
 
-// ListImages returns all images with pagination.
-func (s *Storage) ListImages(page, pageSize int) ([]*ImageManifest, int, error) {
+// ListImages returns all images with pagination. If include is not nil,
+// only images of repositories it accepts are listed.
+func (s *Storage) ListImages(include func(repository string) bool, page, pageSize int) ([]*ImageManifest, int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -344,15 +457,11 @@ func (s *Storage) ListImages(page, pageSize int) ([]*ImageManifest, int, error)
 	// Collect all images
 	var images []*ImageManifest
 	for name, tags := range store.Images {
+		if include != nil && !include(name) {
+			continue
+		}
 		for tag, info := range tags {
-			images = append(images, &ImageManifest{
-				Name:      name,
-				Tag:       tag,
-				Digest:    info.Digest,
-				Size:      info.Size,
-				CreatedAt: info.CreatedAt,
-				Layers:    info.Layers,
-			})
+			images = append(images, s.imageFromTag(name, tag, info))
 		}
 	}
 
@@ -372,8 +481,11 @@ func (s *Storage) ListImages(page, pageSize int) ([]*ImageManifest, int, error)
 	return images[start:end], total, nil
 }
 
-// SearchImages searches images by keyword.
-func (s *Storage) SearchImages(keyword string, page, pageSize int) ([]*ImageManifest, int, error) {
+// SearchImages searches images by keyword. If digests is not nil, only
+// images whose manifest digest is in it match; if categories is not nil,
+// only images of those artifact categories; if include is not nil, only
+// images of repositories it accepts.
+func (s *Storage) SearchImages(keyword string, digests, categories map[string]bool, include func(repository string) bool, page, pageSize int) ([]*ImageManifest, int, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -385,17 +497,19 @@ func (s *Storage) SearchImages(keyword string, page, pageSize int) ([]*ImageMani
 	// Collect matching images
 	var images []*ImageManifest
 	for name, tags := range store.Images {
+		if include != nil && !include(name) {
+			continue
+		}
 		for tag, info := range tags {
+			if digests != nil && !digests[info.Digest] {
+				continue
+			}
+			if categories != nil && !categories[ArtifactCategory(info.ArtifactType)] {
+				continue
+			}
 			// Match keyword in name or tag
 			if containsIgnoreCase(name, keyword) || containsIgnoreCase(tag, keyword) {
-				images = append(images, &ImageManifest{
-					Name:      name,
-					Tag:       tag,
-					Digest:    info.Digest,
-					Size:      info.Size,
-					CreatedAt: info.CreatedAt,
-					Layers:    info.Layers,
-				})
+				images = append(images, s.imageFromTag(name, tag, info))
 			}
 		}
 	}
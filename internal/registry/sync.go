@@ -3,10 +3,13 @@ package registry
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"sync"
@@ -21,22 +24,50 @@ const (
 	SyncStatusRunning   SyncStatus = "running"
 	SyncStatusCompleted SyncStatus = "completed"
 	SyncStatusFailed    SyncStatus = "failed"
+	SyncStatusConflict  SyncStatus = "conflict"
 )
 
-// SyncRecord represents a sync operation history record.
+// Sync directions.
+const (
+	SyncDirectionPush = "push"
+	SyncDirectionPull = "pull"
+)
+
+// SyncRecord represents a sync operation history record. For pull records
+// the Target* fields describe the remote side the image was imported from.
 type SyncRecord struct {
-	ID            string     `json:"id"`
-	ImageName     string     `json:"image_name"`
-	ImageTag      string     `json:"image_tag"`
-	SourceDigest  string     `json:"source_digest"`
-	TargetRegistry string    `json:"target_registry"`
-	TargetImage   string     `json:"target_image"`
-	TargetTag     string     `json:"target_tag"`
-	Status        SyncStatus `json:"status"`
-	ErrorMessage  string     `json:"error_message,omitempty"`
-	StartedAt     time.Time  `json:"started_at"`
-	CompletedAt   *time.Time `json:"completed_at,omitempty"`
-	BytesSynced   int64      `json:"bytes_synced"`
+	ID             string     `json:"id"`
+	Direction      string     `json:"direction,omitempty"` // push, pull
+	RuleID         string     `json:"rule_id,omitempty"`
+	ImageName      string     `json:"image_name"`
+	ImageTag       string     `json:"image_tag"`
+	SourceDigest   string     `json:"source_digest"`
+	TargetRegistry string     `json:"target_registry"`
+	TargetImage    string     `json:"target_image"`
+	TargetTag      string     `json:"target_tag"`
+	Status         SyncStatus `json:"status"`
+	ErrorMessage   string     `json:"error_message,omitempty"`
+	StartedAt      time.Time  `json:"started_at"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	BytesSynced    int64      `json:"bytes_synced"`
+	PushedLayers   []string   `json:"pushed_layers,omitempty"` // 已推送的层，重试时跳过
+	RetryOf        string     `json:"retry_of,omitempty"`
+}
+
+// SyncConfig holds sync push settings.
+type SyncConfig struct {
+	Parallel     int           // 并发推送的层数
+	MaxRetries   int           // 单层失败后的重试次数
+	RetryBackoff time.Duration // 首次重试等待时间，之后指数增长
+}
+
+// DefaultSyncConfig returns the default sync configuration.
+func DefaultSyncConfig() *SyncConfig {
+	return &SyncConfig{
+		Parallel:     3,
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
 }
 
 // SyncHistory represents the sync history storage structure.
@@ -50,23 +81,77 @@ type SyncService struct {
 	credentialManager *CredentialManager
 	historyPath       string
 	httpClient        *http.Client
+	config            *SyncConfig
+	tokens            tokenCache
+	notifier          SyncProgressFunc
+	active            sync.Map // map[string]*syncTracker
 	mu                sync.RWMutex
+
+	rulesMu      sync.Mutex
+	runningRules sync.Map // map[string]struct{}
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+	isLeader     func() bool
+
+	proxyMu       sync.RWMutex
+	outboundProxy func(*http.Request) (*url.URL, error)
 }
 
 // NewSyncService creates a new SyncService.
-func NewSyncService(storage *Storage, credentialManager *CredentialManager, historyPath string) (*SyncService, error) {
+func NewSyncService(storage *Storage, credentialManager *CredentialManager, historyPath string, config *SyncConfig) (*SyncService, error) {
 	if err := os.MkdirAll(historyPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create sync history directory: %w", err)
 	}
 
-	return &SyncService{
+	defaults := DefaultSyncConfig()
+	if config == nil {
+		config = defaults
+	}
+	if config.Parallel <= 0 {
+		config.Parallel = defaults.Parallel
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+
+	ss := &SyncService{
 		storage:           storage,
 		credentialManager: credentialManager,
 		historyPath:       historyPath,
-		httpClient: &http.Client{
-			Timeout: 30 * time.Minute, // Long timeout for large images
-		},
-	}, nil
+		config:            config,
+		stopCh:            make(chan struct{}),
+		outboundProxy:     http.ProxyFromEnvironment,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = ss.proxy
+	ss.httpClient = &http.Client{
+		Timeout:   30 * time.Minute, // Long timeout for large images
+		Transport: transport,
+	}
+	return ss, nil
+}
+
+// SetOutboundProxy sets the HTTP proxy used to reach target registries;
+// nil connects directly. Running syncs keep their open connections.
+func (ss *SyncService) SetOutboundProxy(proxy func(*http.Request) (*url.URL, error)) {
+	ss.proxyMu.Lock()
+	ss.outboundProxy = proxy
+	ss.proxyMu.Unlock()
+	ss.httpClient.CloseIdleConnections()
+}
+
+// proxy selects the proxy of a request with the current settings.
+func (ss *SyncService) proxy(req *http.Request) (*url.URL, error) {
+	ss.proxyMu.RLock()
+	proxy := ss.outboundProxy
+	ss.proxyMu.RUnlock()
+	if proxy == nil {
+		return nil, nil
+	}
+	return proxy(req)
 }
 
 
@@ -172,9 +257,44 @@ type SyncRequest struct {
 
 // SyncImage synchronizes a local image to a public registry.
 func (ss *SyncService) SyncImage(req *SyncRequest) (*SyncRecord, error) {
+	record, manifest, cred, err := ss.prepareSync(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Perform sync in background
+	go ss.performSync(context.Background(), record, manifest, cred)
+
+	return record, nil
+}
+
+// SyncImageTo synchronizes an image and blocks until the sync completes.
+// It implements service.ImageSyncer for workflow steps.
+func (ss *SyncService) SyncImageTo(ctx context.Context, image, tag, targetRegistry, targetImage, targetTag string) (string, error) {
+	record, manifest, cred, err := ss.prepareSync(&SyncRequest{
+		ImageName:      image,
+		ImageTag:       tag,
+		TargetRegistry: targetRegistry,
+		TargetImage:    targetImage,
+		TargetTag:      targetTag,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ss.performSync(ctx, record, manifest, cred)
+
+	if record.Status != SyncStatusCompleted {
+		return record.ID, errors.New(record.ErrorMessage)
+	}
+	return record.ID, nil
+}
+
+// prepareSync validates a sync request and creates its history record.
+func (ss *SyncService) prepareSync(req *SyncRequest) (*SyncRecord, *ImageManifest, *Credential, error) {
 	// Validate request
 	if req.ImageName == "" || req.ImageTag == "" || req.TargetRegistry == "" {
-		return nil, fmt.Errorf("image_name, image_tag, and target_registry are required")
+		return nil, nil, nil, fmt.Errorf("image_name, image_tag, and target_registry are required")
 	}
 
 	// Set defaults
@@ -188,18 +308,19 @@ func (ss *SyncService) SyncImage(req *SyncRequest) (*SyncRecord, error) {
 	// Get source image
 	manifest, err := ss.storage.GetImage(req.ImageName, req.ImageTag)
 	if err != nil {
-		return nil, fmt.Errorf("source image not found: %w", err)
+		return nil, nil, nil, fmt.Errorf("source image not found: %w", err)
 	}
 
 	// Get credentials for target registry
 	cred, err := ss.credentialManager.GetCredential(req.TargetRegistry)
 	if err != nil {
-		return nil, fmt.Errorf("credentials not found for registry %s: %w", req.TargetRegistry, err)
+		return nil, nil, nil, fmt.Errorf("credentials not found for registry %s: %w", req.TargetRegistry, err)
 	}
 
 	// Create sync record
 	record := &SyncRecord{
 		ID:             generateSyncID(),
+		Direction:      SyncDirectionPush,
 		ImageName:      req.ImageName,
 		ImageTag:       req.ImageTag,
 		SourceDigest:   manifest.Digest,
@@ -211,43 +332,109 @@ func (ss *SyncService) SyncImage(req *SyncRequest) (*SyncRecord, error) {
 	}
 
 	if err := ss.addRecord(record); err != nil {
-		return nil, fmt.Errorf("failed to create sync record: %w", err)
+		return nil, nil, nil, fmt.Errorf("failed to create sync record: %w", err)
 	}
 
-	// Perform sync in background
-	go ss.performSync(record, manifest, cred)
-
-	return record, nil
+	return record, manifest, cred, nil
 }
 
-// performSync performs the actual sync operation.
-func (ss *SyncService) performSync(record *SyncRecord, manifest *ImageManifest, cred *Credential) {
+// performSync performs the actual sync operation. Layers are pushed by a
+// pool of workers; layers listed in record.PushedLayers are skipped.
+func (ss *SyncService) performSync(ctx context.Context, record *SyncRecord, manifest *ImageManifest, cred *Credential) {
 	var totalBytes int64
 	var syncErr error
+	var recordMu sync.Mutex
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	tracker := ss.trackSync(record, manifest, cancel)
 
 	defer func() {
+		recordMu.Lock()
+		defer recordMu.Unlock()
+
 		now := time.Now().UTC()
 		record.CompletedAt = &now
-		record.BytesSynced = totalBytes
+		record.BytesSynced += totalBytes
 
-		if syncErr != nil {
+		switch {
+		case tracker.isCancelled():
+			record.Status = SyncStatusCancelled
+			record.ErrorMessage = "sync cancelled"
+		case syncErr != nil:
 			record.Status = SyncStatusFailed
 			record.ErrorMessage = syncErr.Error()
-		} else {
+		default:
 			record.Status = SyncStatusCompleted
 		}
 
 		ss.updateRecord(record)
+		ss.untrackSync(record)
 	}()
 
-	// Push each layer to target registry
+	pushed := make(map[string]bool, len(record.PushedLayers))
+	for _, digest := range record.PushedLayers {
+		pushed[digest] = true
+	}
+
+	var pending []string
 	for _, layer := range manifest.Layers {
-		layerBytes, err := ss.pushLayer(record.TargetRegistry, record.TargetImage, layer.Digest, cred)
-		if err != nil {
-			syncErr = fmt.Errorf("failed to push layer %s: %w", layer.Digest, err)
-			return
+		if !pushed[layer.Digest] {
+			pending = append(pending, layer.Digest)
+		}
+	}
+
+	// Push layers to target registry in parallel
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+
+	workers := ss.config.Parallel
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for digest := range jobs {
+				size, err := ss.pushLayerWithRetry(ctx, tracker, record.TargetRegistry, record.TargetImage, digest, cred)
+				if err != nil {
+					ss.layerStatus(tracker, digest, "failed")
+					errOnce.Do(func() {
+						syncErr = fmt.Errorf("failed to push layer %s: %w", digest, err)
+						cancel()
+					})
+					continue
+				}
+
+				// 记录已推送的层，失败重试时可从断点继续
+				recordMu.Lock()
+				totalBytes += size
+				record.PushedLayers = append(record.PushedLayers, digest)
+				ss.updateRecord(record)
+				recordMu.Unlock()
+			}
+		}()
+	}
+
+dispatch:
+	for _, digest := range pending {
+		select {
+		case jobs <- digest:
+		case <-ctx.Done():
+			break dispatch
 		}
-		totalBytes += layerBytes
+	}
+	close(jobs)
+	wg.Wait()
+
+	if syncErr != nil {
+		return
+	}
+	if err := ctx.Err(); err != nil {
+		syncErr = err
+		return
 	}
 
 	// Push manifest to target registry
@@ -264,23 +451,44 @@ func (ss *SyncService) performSync(record *SyncRecord, manifest *ImageManifest,
 		return
 	}
 
-	if err := ss.pushManifest(record.TargetRegistry, record.TargetImage, record.TargetTag, manifestBytes, cred); err != nil {
+	if err := ss.pushManifest(ctx, record.TargetRegistry, record.TargetImage, record.TargetTag, manifestBytes, cred); err != nil {
 		syncErr = fmt.Errorf("failed to push manifest: %w", err)
 		return
 	}
 
+	recordMu.Lock()
 	totalBytes += int64(len(manifestBytes))
+	recordMu.Unlock()
 }
 
+// pushLayerWithRetry pushes a layer, retrying with exponential backoff.
+func (ss *SyncService) pushLayerWithRetry(ctx context.Context, tracker *syncTracker, registryURL, imageName, digest string, cred *Credential) (int64, error) {
+	backoff := ss.config.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		size, err := ss.pushLayer(ctx, tracker, registryURL, imageName, digest, cred)
+		if err == nil || attempt >= ss.config.MaxRetries || ctx.Err() != nil {
+			return size, err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
 
 // pushLayer pushes a layer to the target registry.
-func (ss *SyncService) pushLayer(registryURL, imageName, digest string, cred *Credential) (int64, error) {
+func (ss *SyncService) pushLayer(ctx context.Context, tracker *syncTracker, registryURL, imageName, digest string, cred *Credential) (int64, error) {
 	// Check if layer already exists
-	exists, err := ss.checkBlobExists(registryURL, imageName, digest, cred)
+	exists, err := ss.checkBlobExists(ctx, registryURL, imageName, digest, cred)
 	if err != nil {
 		return 0, err
 	}
 	if exists {
+		ss.layerStatus(tracker, digest, "exists")
 		return 0, nil // Layer already exists, skip
 	}
 
@@ -292,31 +500,34 @@ func (ss *SyncService) pushLayer(registryURL, imageName, digest string, cred *Cr
 	defer reader.Close()
 
 	// Start upload
-	uploadURL, err := ss.startBlobUpload(registryURL, imageName, cred)
+	uploadURL, err := ss.startBlobUpload(ctx, registryURL, imageName, cred)
 	if err != nil {
 		return 0, fmt.Errorf("failed to start upload: %w", err)
 	}
 
 	// Upload blob
-	if err := ss.uploadBlob(uploadURL, digest, reader, size, cred); err != nil {
+	ss.layerStatus(tracker, digest, "uploading")
+	body := &countingReader{r: reader, onRead: func(n int64) {
+		ss.layerBytes(tracker, digest, n)
+	}}
+	if err := ss.uploadBlob(ctx, uploadURL, digest, body, size, cred); err != nil {
 		return 0, fmt.Errorf("failed to upload blob: %w", err)
 	}
+	ss.layerStatus(tracker, digest, "completed")
 
 	return size, nil
 }
 
 // checkBlobExists checks if a blob exists in the target registry.
-func (ss *SyncService) checkBlobExists(registryURL, imageName, digest string, cred *Credential) (bool, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/%s", registryURL, imageName, digest)
+func (ss *SyncService) checkBlobExists(ctx context.Context, registryURL, imageName, digest string, cred *Credential) (bool, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/%s", registryBaseURL(registryURL), imageName, digest)
 
-	req, err := http.NewRequest("HEAD", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
 	if err != nil {
 		return false, err
 	}
 
-	ss.setAuthHeader(req, cred)
-
-	resp, err := ss.httpClient.Do(req)
+	resp, err := ss.doRequest(req, cred)
 	if err != nil {
 		return false, err
 	}
@@ -326,17 +537,15 @@ func (ss *SyncService) checkBlobExists(registryURL, imageName, digest string, cr
 }
 
 // startBlobUpload initiates a blob upload and returns the upload URL.
-func (ss *SyncService) startBlobUpload(registryURL, imageName string, cred *Credential) (string, error) {
-	url := fmt.Sprintf("%s/v2/%s/blobs/uploads/", registryURL, imageName)
+func (ss *SyncService) startBlobUpload(ctx context.Context, registryURL, imageName string, cred *Credential) (string, error) {
+	url := fmt.Sprintf("%s/v2/%s/blobs/uploads/", registryBaseURL(registryURL), imageName)
 
-	req, err := http.NewRequest("POST", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return "", err
 	}
 
-	ss.setAuthHeader(req, cred)
-
-	resp, err := ss.httpClient.Do(req)
+	resp, err := ss.doRequest(req, cred)
 	if err != nil {
 		return "", err
 	}
@@ -354,14 +563,14 @@ func (ss *SyncService) startBlobUpload(registryURL, imageName string, cred *Cred
 
 	// Handle relative URLs
 	if location[0] == '/' {
-		location = registryURL + location
+		location = registryBaseURL(registryURL) + location
 	}
 
 	return location, nil
 }
 
 // uploadBlob uploads blob data to the given URL.
-func (ss *SyncService) uploadBlob(uploadURL, digest string, data io.Reader, size int64, cred *Credential) error {
+func (ss *SyncService) uploadBlob(ctx context.Context, uploadURL, digest string, data io.Reader, size int64, cred *Credential) error {
 	// Add digest query parameter
 	if uploadURL[len(uploadURL)-1] == '/' {
 		uploadURL = uploadURL[:len(uploadURL)-1]
@@ -371,16 +580,14 @@ func (ss *SyncService) uploadBlob(uploadURL, digest string, data io.Reader, size
 	}
 	uploadURL += "digest=" + digest
 
-	req, err := http.NewRequest("PUT", uploadURL, data)
+	req, err := http.NewRequestWithContext(ctx, "PUT", uploadURL, data)
 	if err != nil {
 		return err
 	}
 
 	req.ContentLength = size
 	req.Header.Set("Content-Type", "application/octet-stream")
-	ss.setAuthHeader(req, cred)
-
-	resp, err := ss.httpClient.Do(req)
+	resp, err := ss.doRequest(req, cred)
 	if err != nil {
 		return err
 	}
@@ -396,18 +603,16 @@ func (ss *SyncService) uploadBlob(uploadURL, digest string, data io.Reader, size
 
 
 // pushManifest pushes a manifest to the target registry.
-func (ss *SyncService) pushManifest(registryURL, imageName, tag string, manifestData []byte, cred *Credential) error {
-	url := fmt.Sprintf("%s/v2/%s/manifests/%s", registryURL, imageName, tag)
+func (ss *SyncService) pushManifest(ctx context.Context, registryURL, imageName, tag string, manifestData []byte, cred *Credential) error {
+	url := fmt.Sprintf("%s/v2/%s/manifests/%s", registryBaseURL(registryURL), imageName, tag)
 
-	req, err := http.NewRequest("PUT", url, bytes.NewReader(manifestData))
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(manifestData))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
-	ss.setAuthHeader(req, cred)
-
-	resp, err := ss.httpClient.Do(req)
+	resp, err := ss.doRequest(req, cred)
 	if err != nil {
 		return err
 	}
@@ -421,13 +626,6 @@ func (ss *SyncService) pushManifest(registryURL, imageName, tag string, manifest
 	return nil
 }
 
-// setAuthHeader sets the authorization header for registry requests.
-func (ss *SyncService) setAuthHeader(req *http.Request, cred *Credential) {
-	if cred != nil && cred.Username != "" && cred.Password != "" {
-		req.SetBasicAuth(cred.Username, cred.Password)
-	}
-}
-
 // GetSyncHistory returns sync history with pagination.
 func (ss *SyncService) GetSyncHistory(page, pageSize int) ([]*SyncRecord, int, error) {
 	ss.mu.RLock()
@@ -521,13 +719,41 @@ func (ss *SyncService) RetrySync(syncID string) (*SyncRecord, error) {
 	if record.Status != SyncStatusFailed {
 		return nil, fmt.Errorf("can only retry failed sync operations")
 	}
+	if record.Direction == SyncDirectionPull {
+		if record.RuleID == "" {
+			return nil, fmt.Errorf("pull sync record has no replication rule")
+		}
+		records, err := ss.RunReplication(context.Background(), record.RuleID, record.ImageTag)
+		if len(records) > 0 {
+			return records[0], err
+		}
+		if err == nil {
+			err = fmt.Errorf("image is already up to date")
+		}
+		return nil, err
+	}
 
 	// Create new sync request from the failed record
-	return ss.SyncImage(&SyncRequest{
+	retry, manifest, cred, err := ss.prepareSync(&SyncRequest{
 		ImageName:      record.ImageName,
 		ImageTag:       record.ImageTag,
 		TargetRegistry: record.TargetRegistry,
 		TargetImage:    record.TargetImage,
 		TargetTag:      record.TargetTag,
 	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 镜像未变化时从上次中断处继续，跳过已推送的层
+	retry.RetryOf = record.ID
+	if retry.SourceDigest == record.SourceDigest {
+		retry.PushedLayers = append([]string(nil), record.PushedLayers...)
+		retry.BytesSynced = record.BytesSynced
+		ss.updateRecord(retry)
+	}
+
+	go ss.performSync(context.Background(), retry, manifest, cred)
+
+	return retry, nil
 }
@@ -0,0 +1,251 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryMargin is subtracted from token lifetimes so that a token is
+// never used right at its expiry.
+const tokenExpiryMargin = 10 * time.Second
+
+// bearerToken is a cached registry token.
+type bearerToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// tokenCache caches bearer tokens per registry host and repository.
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]*bearerToken
+}
+
+// get returns a valid cached token for key.
+func (c *tokenCache) get(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tokens[key]
+	if !ok {
+		return ""
+	}
+	if time.Now().After(t.expiresAt) {
+		delete(c.tokens, key)
+		return ""
+	}
+	return t.token
+}
+
+// set stores a token for key.
+func (c *tokenCache) set(key, token string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tokens == nil {
+		c.tokens = make(map[string]*bearerToken)
+	}
+	c.tokens[key] = &bearerToken{token: token, expiresAt: time.Now().Add(ttl)}
+}
+
+// registryBaseURL normalizes a registry address to a base URL. Docker Hub
+// aliases map to its API host and a missing scheme defaults to https.
+func registryBaseURL(registry string) string {
+	registry = strings.TrimRight(registry, "/")
+	switch registry {
+	case "docker.io", "index.docker.io", "registry.hub.docker.com":
+		return "https://registry-1.docker.io"
+	}
+	if !strings.Contains(registry, "://") {
+		return "https://" + registry
+	}
+	return registry
+}
+
+// tokenCacheKey returns the cache key for a request: the registry host and
+// the repository addressed by the /v2/<repo>/... path.
+func tokenCacheKey(u *url.URL) string {
+	repo := strings.TrimPrefix(u.Path, "/v2/")
+	for _, marker := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if i := strings.Index(repo, marker); i >= 0 {
+			repo = repo[:i]
+			break
+		}
+	}
+	return u.Host + "/" + repo
+}
+
+// setAuthHeader sets the authorization header for registry requests. A
+// cached bearer token is preferred over basic auth.
+func (ss *SyncService) setAuthHeader(req *http.Request, cred *Credential) {
+	if token := ss.tokens.get(tokenCacheKey(req.URL)); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return
+	}
+	if cred != nil && cred.Username != "" && cred.Password != "" {
+		req.SetBasicAuth(cred.Username, cred.Password)
+	}
+}
+
+// doRequest sends a registry request. When the registry answers with a
+// Bearer challenge, a token is fetched from the announced realm, cached
+// and the request is sent again. Requests whose body cannot be replayed
+// are not resent, but the cached token is used by the next attempt.
+func (ss *SyncService) doRequest(req *http.Request, cred *Credential) (*http.Response, error) {
+	ss.setAuthHeader(req, cred)
+
+	resp, err := ss.httpClient.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("Www-Authenticate")
+	scheme, params := parseChallenge(challenge)
+	if !strings.EqualFold(scheme, "bearer") || params["realm"] == "" {
+		return resp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// 请求体无法重放，仅缓存令牌供下一次请求使用
+		ss.fetchToken(req, params, cred)
+		return resp, nil
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if err := ss.fetchToken(req, params, cred); err != nil {
+		return nil, err
+	}
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	retry.Header.Del("Authorization")
+	ss.setAuthHeader(retry, cred)
+
+	return ss.httpClient.Do(retry)
+}
+
+// fetchToken requests a token for the challenge and caches it for req.
+func (ss *SyncService) fetchToken(req *http.Request, params map[string]string, cred *Credential) error {
+	tokenURL, err := url.Parse(params["realm"])
+	if err != nil {
+		return fmt.Errorf("invalid token realm: %w", err)
+	}
+
+	query := tokenURL.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	if scope := params["scope"]; scope != "" {
+		for _, s := range strings.Split(scope, " ") {
+			query.Add("scope", s)
+		}
+	}
+	tokenURL.RawQuery = query.Encode()
+
+	tokenReq, err := http.NewRequestWithContext(req.Context(), http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return err
+	}
+	// 未配置凭证时匿名获取令牌，适用于公开镜像的拉取
+	if cred != nil && cred.Username != "" && cred.Password != "" {
+		tokenReq.SetBasicAuth(cred.Username, cred.Password)
+	}
+
+	resp, err := ss.httpClient.Do(tokenReq)
+	if err != nil {
+		return fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("token request failed: %s - %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid token response: %w", err)
+	}
+
+	token := result.Token
+	if token == "" {
+		token = result.AccessToken
+	}
+	if token == "" {
+		return fmt.Errorf("token response contains no token")
+	}
+
+	// 规范规定未返回有效期时按 60 秒处理
+	ttl := 60 * time.Second
+	if result.ExpiresIn > 0 {
+		ttl = time.Duration(result.ExpiresIn) * time.Second
+	}
+	if ttl > tokenExpiryMargin*2 {
+		ttl -= tokenExpiryMargin
+	}
+
+	ss.tokens.set(tokenCacheKey(req.URL), token, ttl)
+	return nil
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:foo:pull"`.
+func parseChallenge(header string) (string, map[string]string) {
+	params := make(map[string]string)
+	header = strings.TrimSpace(header)
+
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, params
+	}
+	scheme := header[:i]
+	rest := header[i+1:]
+
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = rest[eq+1:]
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(rest, ',')
+			if end < 0 {
+				value, rest = rest, ""
+			} else {
+				value, rest = rest[:end], rest[end+1:]
+			}
+		}
+		params[key] = value
+	}
+
+	return scheme, params
+}
@@ -2,7 +2,9 @@
 package registry
 
 import (
+	"context"
 	"cyp-docker-registry/internal/common"
+	"io"
 	"net/http"
 	"strconv"
 
@@ -42,9 +44,47 @@ func (h *SyncHandler) RegisterRoutes(apiGroup *gin.RouterGroup) {
 		sync.GET("/history/:id", h.getSyncRecord)
 		sync.POST("/retry/:id", h.retrySync)
 		sync.GET("/image/:name/:tag", h.getImageSyncHistory)
+
+		// Pull replication rules
+		sync.GET("/replication", h.listReplicationRules)
+		sync.POST("/replication", h.createReplicationRule)
+		sync.GET("/replication/:id", h.getReplicationRule)
+		sync.PUT("/replication/:id", h.updateReplicationRule)
+		sync.DELETE("/replication/:id", h.deleteReplicationRule)
+		sync.POST("/replication/:id/run", h.runReplicationRule)
+
+		// Scheduled push rules
+		sync.GET("/rules", h.listSyncRules)
+		sync.POST("/rules", h.createSyncRule)
+		sync.GET("/rules/:id", h.getSyncRule)
+		sync.PUT("/rules/:id", h.updateSyncRule)
+		sync.DELETE("/rules/:id", h.deleteSyncRule)
+		sync.POST("/rules/:id/run", h.runSyncRule)
+		sync.GET("/rules/:id/history", h.getSyncRuleHistory)
 	}
 }
 
+// RegisterCredentialRoutes registers credential CRUD routes.
+func (h *SyncHandler) RegisterCredentialRoutes(creds *gin.RouterGroup) {
+	creds.GET("", h.listCredentials)
+	creds.POST("", h.saveCredential)
+	creds.GET("/:registry", h.getCredential)
+	creds.PUT("/:registry", h.updateCredential)
+	creds.DELETE("/:registry", h.deleteCredential)
+}
+
+// RegisterProgressRoutes registers live progress and cancel routes.
+func (h *SyncHandler) RegisterProgressRoutes(syncGroup *gin.RouterGroup) {
+	syncGroup.GET("/:id/progress", h.getSyncProgress)
+	syncGroup.POST("/:id/cancel", h.cancelSync)
+}
+
+// RegisterWebhookRoutes registers the replication webhook route. It is
+// authenticated by the rule's webhook secret instead of a user session.
+func (h *SyncHandler) RegisterWebhookRoutes(apiGroup *gin.RouterGroup) {
+	apiGroup.POST("/sync/replication/webhook/:id", h.replicationWebhook)
+}
+
 // ============================================================================
 // Credential Handlers
 // ============================================================================
@@ -111,12 +151,54 @@ func (h *SyncHandler) getCredential(c *gin.Context) {
 	common.SuccessResponse(c, gin.H{
 		"registry":   registry,
 		"username":   cred.Username,
-		"password":   "********", // Mask password
+		"password":   MaskedPassword, // Mask password
 		"created_at": cred.CreatedAt,
 		"updated_at": cred.UpdatedAt,
 	})
 }
 
+// UpdateCredentialRequest represents a request to update a credential.
+// An empty password keeps the stored one.
+type UpdateCredentialRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// updateCredential handles PUT /api/v1/credentials/:registry
+func (h *SyncHandler) updateCredential(c *gin.Context) {
+	registry := c.Param("registry")
+
+	var req UpdateCredentialRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+
+	if err := h.credentialManager.UpdateCredential(registry, req.Username, req.Password); err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error":    "凭证不存在",
+			"registry": registry,
+		})
+		return
+	}
+
+	cred, err := h.credentialManager.GetCredentialEncrypted(registry)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInternalError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message":    "凭证更新成功",
+		"registry":   registry,
+		"credential": cred.Masked(),
+	})
+}
+
 // deleteCredential handles DELETE /api/credentials/:registry
 func (h *SyncHandler) deleteCredential(c *gin.Context) {
 	registry := c.Param("registry")
@@ -249,3 +331,380 @@ func (h *SyncHandler) getImageSyncHistory(c *gin.Context) {
 		"records":    records,
 	})
 }
+
+// getSyncProgress handles GET /api/v1/sync/:id/progress
+func (h *SyncHandler) getSyncProgress(c *gin.Context) {
+	id := c.Param("id")
+
+	progress, err := h.syncService.GetSyncProgress(id)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "同步记录不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, progress)
+}
+
+// cancelSync handles POST /api/v1/sync/:id/cancel
+func (h *SyncHandler) cancelSync(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.syncService.CancelSync(id); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "同步任务未在运行",
+			"id":    id,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "同步任务已取消",
+		"id":      id,
+	})
+}
+
+// ============================================================================
+// Replication Handlers
+// ============================================================================
+
+// maskRule returns a copy of rule with the webhook secret hidden.
+func maskRule(rule *ReplicationRule) *ReplicationRule {
+	masked := *rule
+	if masked.WebhookSecret != "" {
+		masked.WebhookSecret = "********"
+	}
+	return &masked
+}
+
+// listReplicationRules handles GET /api/sync/replication
+func (h *SyncHandler) listReplicationRules(c *gin.Context) {
+	rules, err := h.syncService.ListReplicationRules()
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInternalError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	masked := make([]*ReplicationRule, 0, len(rules))
+	for _, rule := range rules {
+		masked = append(masked, maskRule(rule))
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"rules": masked,
+	})
+}
+
+// createReplicationRule handles POST /api/sync/replication
+func (h *SyncHandler) createReplicationRule(c *gin.Context) {
+	var rule ReplicationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+	rule.ID = ""
+
+	saved, err := h.syncService.SaveReplicationRule(&rule)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 仅在创建时返回 webhook 密钥
+	c.JSON(http.StatusCreated, common.Response{
+		Success: true,
+		Data: gin.H{
+			"message": "复制规则创建成功",
+			"rule":    saved,
+		},
+	})
+}
+
+// getReplicationRule handles GET /api/sync/replication/:id
+func (h *SyncHandler) getReplicationRule(c *gin.Context) {
+	id := c.Param("id")
+
+	rule, err := h.syncService.GetReplicationRule(id)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "复制规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, maskRule(rule))
+}
+
+// updateReplicationRule handles PUT /api/sync/replication/:id
+func (h *SyncHandler) updateReplicationRule(c *gin.Context) {
+	var rule ReplicationRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+	rule.ID = c.Param("id")
+	if rule.WebhookSecret == "********" {
+		rule.WebhookSecret = ""
+	}
+
+	saved, err := h.syncService.SaveReplicationRule(&rule)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "复制规则更新成功",
+		"rule":    maskRule(saved),
+	})
+}
+
+// deleteReplicationRule handles DELETE /api/sync/replication/:id
+func (h *SyncHandler) deleteReplicationRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.syncService.DeleteReplicationRule(id); err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "复制规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "复制规则删除成功",
+		"id":      id,
+	})
+}
+
+// runReplicationRule handles POST /api/sync/replication/:id/run
+func (h *SyncHandler) runReplicationRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := h.syncService.GetReplicationRule(id); err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "复制规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	records, err := h.syncService.RunReplication(c.Request.Context(), id)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrUpstreamError, gin.H{
+			"error":   err.Error(),
+			"records": records,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "复制完成",
+		"records": records,
+	})
+}
+
+// replicationWebhook handles POST /api/sync/replication/webhook/:id
+func (h *SyncHandler) replicationWebhook(c *gin.Context) {
+	rule, err := h.syncService.GetReplicationRule(c.Param("id"))
+	if err != nil || !rule.Enabled {
+		common.ErrorResponse(c, common.ErrNotFound, nil)
+		return
+	}
+
+	secret := c.GetHeader("X-Webhook-Secret")
+	if secret == "" {
+		secret = c.Query("secret")
+	}
+	if !h.syncService.VerifyWebhookSecret(rule, secret) {
+		common.ErrorResponse(c, common.ErrAuthFailed, nil)
+		return
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	tags, err := ParseReplicationWebhook(body, rule.Repository)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 异步执行，webhook 调用方无需等待镜像下载完成
+	go h.syncService.RunReplication(context.Background(), rule.ID, tags...)
+
+	c.JSON(http.StatusAccepted, common.Response{
+		Success: true,
+		Data: gin.H{
+			"message": "复制任务已启动",
+			"tags":    tags,
+		},
+	})
+}
+
+// ============================================================================
+// Sync Rule Handlers
+// ============================================================================
+
+// listSyncRules handles GET /api/sync/rules
+func (h *SyncHandler) listSyncRules(c *gin.Context) {
+	rules, err := h.syncService.ListSyncRules()
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInternalError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"rules": rules,
+		"total": len(rules),
+	})
+}
+
+// createSyncRule handles POST /api/sync/rules
+func (h *SyncHandler) createSyncRule(c *gin.Context) {
+	var rule SyncRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+	rule.ID = ""
+
+	saved, err := h.syncService.SaveSyncRule(&rule)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, saved)
+}
+
+// getSyncRule handles GET /api/sync/rules/:id
+func (h *SyncHandler) getSyncRule(c *gin.Context) {
+	id := c.Param("id")
+
+	rule, err := h.syncService.GetSyncRule(id)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "同步规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, rule)
+}
+
+// updateSyncRule handles PUT /api/sync/rules/:id
+func (h *SyncHandler) updateSyncRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := h.syncService.GetSyncRule(id); err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "同步规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	var rule SyncRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "请求参数无效",
+		})
+		return
+	}
+	rule.ID = id
+
+	saved, err := h.syncService.SaveSyncRule(&rule)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, saved)
+}
+
+// deleteSyncRule handles DELETE /api/sync/rules/:id
+func (h *SyncHandler) deleteSyncRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if err := h.syncService.DeleteSyncRule(id); err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "同步规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"message": "同步规则已删除",
+		"id":      id,
+	})
+}
+
+// runSyncRule handles POST /api/sync/rules/:id/run
+func (h *SyncHandler) runSyncRule(c *gin.Context) {
+	id := c.Param("id")
+
+	if _, err := h.syncService.GetSyncRule(id); err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "同步规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	// 同步可能耗时较长，在后台执行，结果记录在规则历史中
+	go h.syncService.RunSyncRule(context.Background(), id)
+
+	common.SuccessResponse(c, gin.H{
+		"message": "同步规则已开始执行",
+		"id":      id,
+	})
+}
+
+// getSyncRuleHistory handles GET /api/sync/rules/:id/history
+func (h *SyncHandler) getSyncRuleHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	rule, err := h.syncService.GetSyncRule(id)
+	if err != nil {
+		common.ErrorResponse(c, common.ErrNotFound, gin.H{
+			"error": "同步规则不存在",
+			"id":    id,
+		})
+		return
+	}
+
+	history := rule.History
+	if history == nil {
+		history = []*SyncRuleRun{}
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"id":      id,
+		"history": history,
+		"total":   len(history),
+	})
+}
@@ -0,0 +1,237 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// SyncStatusCancelled marks a sync aborted by the user.
+const SyncStatusCancelled SyncStatus = "cancelled"
+
+// progressInterval limits how often progress events are sent per sync.
+const progressInterval = time.Second
+
+// SyncProgressFunc receives sync progress events.
+type SyncProgressFunc func(event string, data map[string]interface{})
+
+// LayerProgress represents the upload progress of a single layer.
+type LayerProgress struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Pushed int64  `json:"pushed"`
+	Status string `json:"status"` // pending, uploading, completed, exists, failed
+}
+
+// SyncProgress represents the live progress of a sync operation.
+type SyncProgress struct {
+	ID          string          `json:"id"`
+	Status      SyncStatus      `json:"status"`
+	TotalBytes  int64           `json:"total_bytes"`
+	PushedBytes int64           `json:"pushed_bytes"`
+	Layers      []LayerProgress `json:"layers"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// syncTracker tracks an in-flight sync.
+type syncTracker struct {
+	mu         sync.Mutex
+	progress   SyncProgress
+	index      map[string]int
+	cancel     context.CancelFunc
+	cancelled  bool
+	lastNotify time.Time
+}
+
+// SetProgressNotifier sets the callback that receives sync progress events.
+func (ss *SyncService) SetProgressNotifier(fn SyncProgressFunc) {
+	ss.notifier = fn
+}
+
+// trackSync registers an in-flight sync so that its progress can be
+// queried and the sync cancelled.
+func (ss *SyncService) trackSync(record *SyncRecord, manifest *ImageManifest, cancel context.CancelFunc) *syncTracker {
+	pushed := make(map[string]bool, len(record.PushedLayers))
+	for _, digest := range record.PushedLayers {
+		pushed[digest] = true
+	}
+
+	t := &syncTracker{
+		index:  make(map[string]int, len(manifest.Layers)),
+		cancel: cancel,
+		progress: SyncProgress{
+			ID:        record.ID,
+			Status:    SyncStatusRunning,
+			Layers:    make([]LayerProgress, 0, len(manifest.Layers)),
+			UpdatedAt: time.Now().UTC(),
+		},
+	}
+	for _, layer := range manifest.Layers {
+		lp := LayerProgress{Digest: layer.Digest, Size: layer.Size, Status: "pending"}
+		if pushed[layer.Digest] {
+			lp.Pushed = layer.Size
+			lp.Status = "completed"
+			t.progress.PushedBytes += layer.Size
+		}
+		t.progress.TotalBytes += layer.Size
+		t.index[layer.Digest] = len(t.progress.Layers)
+		t.progress.Layers = append(t.progress.Layers, lp)
+	}
+
+	ss.active.Store(record.ID, t)
+	return t
+}
+
+// untrackSync removes a finished sync and announces its final state.
+func (ss *SyncService) untrackSync(record *SyncRecord) {
+	ss.active.Delete(record.ID)
+
+	ss.notify("sync_"+string(record.Status), map[string]interface{}{
+		"id":           record.ID,
+		"image":        record.ImageName + ":" + record.ImageTag,
+		"target":       record.TargetRegistry,
+		"status":       record.Status,
+		"bytes_synced": record.BytesSynced,
+		"error":        record.ErrorMessage,
+	})
+}
+
+// layerStatus updates the status of a layer.
+func (ss *SyncService) layerStatus(t *syncTracker, digest, status string) {
+	t.mu.Lock()
+	if i, ok := t.index[digest]; ok {
+		lp := &t.progress.Layers[i]
+		switch status {
+		case "uploading":
+			// 重试时重新计算该层已上传字节数
+			t.progress.PushedBytes -= lp.Pushed
+			lp.Pushed = 0
+		case "completed", "exists":
+			t.progress.PushedBytes += lp.Size - lp.Pushed
+			lp.Pushed = lp.Size
+		}
+		lp.Status = status
+		t.progress.UpdatedAt = time.Now().UTC()
+	}
+	t.mu.Unlock()
+
+	ss.notifyProgress(t, true)
+}
+
+// layerBytes adds n uploaded bytes to a layer.
+func (ss *SyncService) layerBytes(t *syncTracker, digest string, n int64) {
+	t.mu.Lock()
+	if i, ok := t.index[digest]; ok {
+		t.progress.Layers[i].Pushed += n
+		t.progress.PushedBytes += n
+		t.progress.UpdatedAt = time.Now().UTC()
+	}
+	t.mu.Unlock()
+
+	ss.notifyProgress(t, false)
+}
+
+// notifyProgress sends a progress event, at most once per progressInterval
+// unless force is set.
+func (ss *SyncService) notifyProgress(t *syncTracker, force bool) {
+	if ss.notifier == nil {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if !force && now.Sub(t.lastNotify) < progressInterval {
+		t.mu.Unlock()
+		return
+	}
+	t.lastNotify = now
+	data := map[string]interface{}{
+		"id":           t.progress.ID,
+		"total_bytes":  t.progress.TotalBytes,
+		"pushed_bytes": t.progress.PushedBytes,
+	}
+	t.mu.Unlock()
+
+	ss.notifier("sync_progress", data)
+}
+
+// notify sends an event to the progress notifier.
+func (ss *SyncService) notify(event string, data map[string]interface{}) {
+	if ss.notifier != nil {
+		ss.notifier(event, data)
+	}
+}
+
+// GetSyncProgress returns the live progress of a sync. For finished syncs
+// the progress is derived from the history record.
+func (ss *SyncService) GetSyncProgress(id string) (*SyncProgress, error) {
+	if v, ok := ss.active.Load(id); ok {
+		t := v.(*syncTracker)
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		progress := t.progress
+		progress.Layers = append([]LayerProgress(nil), t.progress.Layers...)
+		return &progress, nil
+	}
+
+	record, err := ss.GetSyncRecord(id)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &SyncProgress{
+		ID:          record.ID,
+		Status:      record.Status,
+		TotalBytes:  record.BytesSynced,
+		PushedBytes: record.BytesSynced,
+		UpdatedAt:   record.StartedAt,
+	}
+	if record.CompletedAt != nil {
+		progress.UpdatedAt = *record.CompletedAt
+	}
+	for _, digest := range record.PushedLayers {
+		progress.Layers = append(progress.Layers, LayerProgress{Digest: digest, Status: "completed"})
+	}
+	return progress, nil
+}
+
+// CancelSync aborts an in-flight sync, including its running uploads.
+func (ss *SyncService) CancelSync(id string) error {
+	v, ok := ss.active.Load(id)
+	if !ok {
+		return fmt.Errorf("sync is not running: %s", id)
+	}
+
+	t := v.(*syncTracker)
+	t.mu.Lock()
+	t.cancelled = true
+	t.mu.Unlock()
+
+	t.cancel()
+	return nil
+}
+
+// isCancelled reports whether the sync was cancelled by the user.
+func (t *syncTracker) isCancelled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancelled
+}
+
+// countingReader reports bytes read from the underlying reader.
+type countingReader struct {
+	r      io.Reader
+	onRead func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		c.onRead(int64(n))
+	}
+	return n, err
+}
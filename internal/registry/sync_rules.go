@@ -0,0 +1,350 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxSyncRuleHistory is the number of runs kept per sync rule.
+const maxSyncRuleHistory = 20
+
+// SyncRule describes a scheduled push of local repositories to a remote
+// registry.
+type SyncRule struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	SourcePattern   string            `json:"source_pattern"`             // 本地仓库匹配模式，如 library/*
+	TagPattern      string            `json:"tag_pattern,omitempty"`      // 标签匹配模式，默认 *
+	TargetRegistry  string            `json:"target_registry"`            // 目标仓库地址
+	TargetNamespace string            `json:"target_namespace,omitempty"` // 目标命名空间，为空时保留原仓库名
+	Schedule        string            `json:"schedule"`                   // 执行间隔，如 1h
+	Enabled         bool              `json:"enabled"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	LastRunAt       *time.Time        `json:"last_run_at,omitempty"`
+	LastError       string            `json:"last_error,omitempty"`
+	Synced          map[string]string `json:"synced,omitempty"` // name:tag -> 上次成功同步的摘要
+	History         []*SyncRuleRun    `json:"history,omitempty"`
+}
+
+// SyncRuleRun is the outcome of a single sync rule run.
+type SyncRuleRun struct {
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Synced      int       `json:"synced"`
+	Skipped     int       `json:"skipped"`
+	Failed      int       `json:"failed"`
+	RecordIDs   []string  `json:"record_ids,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// SyncRuleStore represents the sync rule storage structure.
+type SyncRuleStore struct {
+	Rules []*SyncRule `json:"rules"`
+}
+
+// getSyncRulesFilePath returns the path to the sync rules file.
+func (ss *SyncService) getSyncRulesFilePath() string {
+	return filepath.Join(ss.historyPath, "sync_rules.json")
+}
+
+// loadSyncRules loads sync rules from disk. Caller must hold rulesMu.
+func (ss *SyncService) loadSyncRules() (*SyncRuleStore, error) {
+	data, err := os.ReadFile(ss.getSyncRulesFilePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &SyncRuleStore{Rules: make([]*SyncRule, 0)}, nil
+		}
+		return nil, fmt.Errorf("failed to read sync rules: %w", err)
+	}
+
+	var store SyncRuleStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return nil, fmt.Errorf("failed to parse sync rules: %w", err)
+	}
+	if store.Rules == nil {
+		store.Rules = make([]*SyncRule, 0)
+	}
+	return &store, nil
+}
+
+// saveSyncRules saves sync rules to disk. Caller must hold rulesMu.
+func (ss *SyncService) saveSyncRules(store *SyncRuleStore) error {
+	data, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sync rules: %w", err)
+	}
+
+	if err := os.WriteFile(ss.getSyncRulesFilePath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to write sync rules: %w", err)
+	}
+	return nil
+}
+
+// ListSyncRules returns all sync rules.
+func (ss *SyncService) ListSyncRules() ([]*SyncRule, error) {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadSyncRules()
+	if err != nil {
+		return nil, err
+	}
+	return store.Rules, nil
+}
+
+// GetSyncRule returns a sync rule by ID.
+func (ss *SyncService) GetSyncRule(id string) (*SyncRule, error) {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadSyncRules()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range store.Rules {
+		if rule.ID == id {
+			return rule, nil
+		}
+	}
+	return nil, fmt.Errorf("sync rule not found: %s", id)
+}
+
+// SaveSyncRule creates a rule when its ID is empty, otherwise updates the
+// existing rule. Changing the target forgets the digests synced so far.
+func (ss *SyncService) SaveSyncRule(rule *SyncRule) (*SyncRule, error) {
+	if rule.SourcePattern == "" || rule.TargetRegistry == "" || rule.Schedule == "" {
+		return nil, fmt.Errorf("source_pattern, target_registry and schedule are required")
+	}
+	if rule.TagPattern == "" {
+		rule.TagPattern = "*"
+	}
+	for _, pattern := range []string{rule.SourcePattern, rule.TagPattern} {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if d, err := time.ParseDuration(rule.Schedule); err != nil || d < time.Minute {
+		return nil, fmt.Errorf("invalid schedule %q: must be a duration of at least 1m", rule.Schedule)
+	}
+	rule.TargetRegistry = strings.TrimRight(rule.TargetRegistry, "/")
+	rule.TargetNamespace = strings.Trim(rule.TargetNamespace, "/")
+	if rule.Name == "" {
+		rule.Name = rule.SourcePattern
+	}
+
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadSyncRules()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rule.UpdatedAt = now
+
+	if rule.ID == "" {
+		rule.ID = fmt.Sprintf("sync-%d", now.UnixNano())
+		rule.CreatedAt = now
+		rule.LastRunAt, rule.LastError = nil, ""
+		rule.Synced, rule.History = nil, nil
+		store.Rules = append(store.Rules, rule)
+		return rule, ss.saveSyncRules(store)
+	}
+
+	for i, existing := range store.Rules {
+		if existing.ID != rule.ID {
+			continue
+		}
+		rule.CreatedAt = existing.CreatedAt
+		rule.LastRunAt = existing.LastRunAt
+		rule.LastError = existing.LastError
+		rule.History = existing.History
+		rule.Synced = existing.Synced
+		if rule.TargetRegistry != existing.TargetRegistry || rule.TargetNamespace != existing.TargetNamespace {
+			rule.Synced = nil
+		}
+		store.Rules[i] = rule
+		return rule, ss.saveSyncRules(store)
+	}
+
+	return nil, fmt.Errorf("sync rule not found: %s", rule.ID)
+}
+
+// DeleteSyncRule deletes a sync rule.
+func (ss *SyncService) DeleteSyncRule(id string) error {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadSyncRules()
+	if err != nil {
+		return err
+	}
+	for i, rule := range store.Rules {
+		if rule.ID == id {
+			store.Rules = append(store.Rules[:i], store.Rules[i+1:]...)
+			return ss.saveSyncRules(store)
+		}
+	}
+	return fmt.Errorf("sync rule not found: %s", id)
+}
+
+// RunDueSyncRules runs every enabled sync rule whose schedule has elapsed.
+// It is called periodically by the automation engine's sync task.
+func (ss *SyncService) RunDueSyncRules(ctx context.Context) error {
+	rules, err := ss.ListSyncRules()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var failed []string
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		interval, err := time.ParseDuration(rule.Schedule)
+		if err != nil || interval <= 0 {
+			continue
+		}
+		if rule.LastRunAt != nil && now.Sub(*rule.LastRunAt) < interval {
+			continue
+		}
+		if _, err := ss.RunSyncRule(ctx, rule.ID); err != nil {
+			failed = append(failed, rule.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("sync rules failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// RunSyncRule pushes every local image matching the rule. Tags whose
+// digest equals the one synced by the previous successful run are skipped.
+func (ss *SyncService) RunSyncRule(ctx context.Context, id string) (*SyncRuleRun, error) {
+	rule, err := ss.GetSyncRule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与复制规则共用运行标记，键名加前缀区分
+	key := "sync-rule:" + id
+	if _, running := ss.runningRules.LoadOrStore(key, struct{}{}); running {
+		return nil, fmt.Errorf("sync rule is already running: %s", id)
+	}
+	defer ss.runningRules.Delete(key)
+
+	run := &SyncRuleRun{StartedAt: time.Now().UTC()}
+	synced := make(map[string]string)
+	runErr := ss.runSyncRule(ctx, rule, run, synced)
+
+	run.CompletedAt = time.Now().UTC()
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	ss.recordSyncRuleRun(id, run, synced)
+
+	return run, runErr
+}
+
+// runSyncRule performs a single sync rule run. Newly synced digests are
+// added to synced.
+func (ss *SyncService) runSyncRule(ctx context.Context, rule *SyncRule, run *SyncRuleRun, synced map[string]string) error {
+	store, err := ss.storage.LoadMetadata()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(store.Images))
+	for name := range store.Images {
+		if ok, _ := path.Match(rule.SourcePattern, name); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tags := make([]string, 0, len(store.Images[name]))
+		for tag := range store.Images[name] {
+			if ok, _ := path.Match(rule.TagPattern, tag); ok {
+				tags = append(tags, tag)
+			}
+		}
+		sort.Strings(tags)
+
+		for _, tag := range tags {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			key := name + ":" + tag
+			digest := store.Images[name][tag].Digest
+			if digest != "" && rule.Synced[key] == digest {
+				run.Skipped++
+				continue
+			}
+
+			targetImage := name
+			if rule.TargetNamespace != "" {
+				targetImage = rule.TargetNamespace + "/" + path.Base(name)
+			}
+
+			recordID, err := ss.SyncImageTo(ctx, name, tag, rule.TargetRegistry, targetImage, tag)
+			if recordID != "" {
+				run.RecordIDs = append(run.RecordIDs, recordID)
+			}
+			if err != nil {
+				run.Failed++
+				continue
+			}
+			run.Synced++
+			synced[key] = digest
+		}
+	}
+
+	if run.Failed > 0 {
+		return fmt.Errorf("%d of %d images failed to sync", run.Failed, run.Synced+run.Failed)
+	}
+	return nil
+}
+
+// recordSyncRuleRun stores the outcome of a sync rule run.
+func (ss *SyncService) recordSyncRuleRun(id string, run *SyncRuleRun, synced map[string]string) {
+	ss.rulesMu.Lock()
+	defer ss.rulesMu.Unlock()
+
+	store, err := ss.loadSyncRules()
+	if err != nil {
+		return
+	}
+	for _, rule := range store.Rules {
+		if rule.ID != id {
+			continue
+		}
+		rule.LastRunAt = &run.StartedAt
+		rule.LastError = run.Error
+		if rule.Synced == nil {
+			rule.Synced = make(map[string]string, len(synced))
+		}
+		for key, digest := range synced {
+			rule.Synced[key] = digest
+		}
+		rule.History = append([]*SyncRuleRun{run}, rule.History...)
+		if len(rule.History) > maxSyncRuleHistory {
+			rule.History = rule.History[:maxSyncRuleHistory]
+		}
+		ss.saveSyncRules(store)
+		return
+	}
+}
@@ -0,0 +1,205 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// pullFlushInterval bounds how often pull counters are written to the
+// metadata file, so pulls don't rewrite it on every request.
+const pullFlushInterval = 30 * time.Second
+
+// tagKey identifies a tag within the metadata store.
+type tagKey struct {
+	name string
+	tag  string
+}
+
+// pendingPull holds pulls counted since the last flush.
+type pendingPull struct {
+	count int64
+	last  time.Time
+}
+
+// newTagInfo builds tag metadata for a push, keeping pull statistics of an
+// existing tag.
+func newTagInfo(manifest *ImageManifest, existing *TagInfo) *TagInfo {
+	info := &TagInfo{
+		Digest:       manifest.Digest,
+		Size:         manifest.Size,
+		CreatedAt:    manifest.CreatedAt,
+		Layers:       manifest.Layers,
+		PushedBy:     manifest.PushedBy,
+		ArtifactType: manifest.ArtifactType,
+		Subject:      manifest.Subject,
+	}
+	if existing != nil {
+		info.PullCount = existing.PullCount
+		info.LastPulledAt = existing.LastPulledAt
+	}
+	return info
+}
+
+// imageFromTag converts stored tag metadata, including pulls not yet
+// flushed, to an ImageManifest.
+func (s *Storage) imageFromTag(name, tag string, info *TagInfo) *ImageManifest {
+	image := &ImageManifest{
+		Name:         name,
+		Tag:          tag,
+		Digest:       info.Digest,
+		Size:         info.Size,
+		CreatedAt:    info.CreatedAt,
+		Layers:       info.Layers,
+		PushedBy:     info.PushedBy,
+		PullCount:    info.PullCount,
+		LastPulledAt: info.LastPulledAt,
+		ArtifactType: info.ArtifactType,
+		Category:     ArtifactCategory(info.ArtifactType),
+		Subject:      info.Subject,
+	}
+
+	s.pullMu.Lock()
+	if p, ok := s.pendingPulls[tagKey{name, tag}]; ok {
+		image.PullCount += p.count
+		last := p.last
+		image.LastPulledAt = &last
+	}
+	s.pullMu.Unlock()
+
+	return image
+}
+
+// ListTags returns the tags of repository name in lexical order, starting
+// after last and limited to n entries when n > 0. more reports whether
+// further tags follow the returned page.
+func (s *Storage) ListTags(name string, n int, last string) (tags []string, more bool, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return nil, false, err
+	}
+
+	repo, ok := store.Images[name]
+	if !ok {
+		return nil, false, fmt.Errorf("repository not found: %s", name)
+	}
+
+	all := make([]string, 0, len(repo))
+	for tag := range repo {
+		all = append(all, tag)
+	}
+	sort.Strings(all)
+
+	start := 0
+	if last != "" {
+		start = sort.SearchStrings(all, last)
+		if start < len(all) && all[start] == last {
+			start++
+		}
+	}
+	all = all[start:]
+
+	if n > 0 && len(all) > n {
+		return all[:n], true, nil
+	}
+	return all, false, nil
+}
+
+// RepositoryTags returns every tag of name with its metadata, most recently
+// pushed first.
+func (s *Storage) RepositoryTags(name string) ([]*ImageManifest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	repo, ok := store.Images[name]
+	if !ok {
+		return nil, fmt.Errorf("image not found: %s", name)
+	}
+
+	tags := make([]*ImageManifest, 0, len(repo))
+	for tag, info := range repo {
+		tags = append(tags, s.imageFromTag(name, tag, info))
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if !tags[i].CreatedAt.Equal(tags[j].CreatedAt) {
+			return tags[i].CreatedAt.After(tags[j].CreatedAt)
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, nil
+}
+
+// RecordPull counts a pull of name:tag. Counters are kept in memory and
+// written to metadata at most every pullFlushInterval.
+func (s *Storage) RecordPull(name, tag string) {
+	s.pullMu.Lock()
+	key := tagKey{name, tag}
+	p, ok := s.pendingPulls[key]
+	if !ok {
+		p = &pendingPull{}
+		s.pendingPulls[key] = p
+	}
+	p.count++
+	p.last = time.Now().UTC()
+	due := time.Since(s.pullFlushedAt) >= pullFlushInterval
+	s.pullMu.Unlock()
+
+	if due {
+		go s.FlushPulls()
+	}
+}
+
+// FlushPulls writes pending pull counters to the metadata file. Counts of
+// tags deleted in the meantime are dropped.
+func (s *Storage) FlushPulls() error {
+	s.pullMu.Lock()
+	if len(s.pendingPulls) == 0 {
+		s.pullMu.Unlock()
+		return nil
+	}
+	pending := s.pendingPulls
+	s.pendingPulls = make(map[tagKey]*pendingPull)
+	s.pullFlushedAt = time.Now()
+	s.pullMu.Unlock()
+
+	var store *ImageStore
+	unlock, err := s.lockMetadata()
+	if err == nil {
+		defer unlock()
+		store, err = s.loadMetadataUnsafe()
+	}
+	if err == nil {
+		for key, p := range pending {
+			info, ok := store.Images[key.name][key.tag]
+			if !ok {
+				continue
+			}
+			info.PullCount += p.count
+			last := p.last
+			info.LastPulledAt = &last
+		}
+		err = s.saveMetadataUnsafe(store)
+	}
+	if err != nil {
+		// Put the counts back so they are retried on the next flush
+		s.pullMu.Lock()
+		for key, p := range pending {
+			if cur, ok := s.pendingPulls[key]; ok {
+				cur.count += p.count
+			} else {
+				s.pendingPulls[key] = p
+			}
+		}
+		s.pullMu.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,158 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cyp-docker-registry/pkg/compression"
+)
+
+// BlobStats reports logical versus on-disk blob usage.
+type BlobStats struct {
+	Algorithm       string  `json:"algorithm"`
+	Count           int     `json:"count"`
+	CompressedCount int     `json:"compressed_count"`
+	LogicalSize     int64   `json:"logical_size"` // sum of original blob sizes
+	StoredSize      int64   `json:"stored_size"`  // bytes used on disk
+	SavedBytes      int64   `json:"saved_bytes"`
+	Ratio           float64 `json:"ratio"` // stored / logical
+}
+
+// SetCompression enables storage-side transcoding for newly written blobs.
+// Blobs are keyed by their original digest and decompressed on read, so
+// digests never change. Blobs smaller than minSize, or whose content is
+// already compressed (e.g. gzip layers), are stored as-is.
+func (s *Storage) SetCompression(algorithm string, level int, minSize int64) error {
+	alg := compression.Algorithm(strings.ToLower(algorithm))
+	switch alg {
+	case "", compression.AlgorithmNone:
+		alg = compression.AlgorithmNone
+	case compression.AlgorithmZstd, compression.AlgorithmGzip:
+	default:
+		return fmt.Errorf("unsupported storage compression: %s", algorithm)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.compression = alg
+	s.compressionLevel = level
+	s.compressMinSize = minSize
+	return nil
+}
+
+// storeBlobFile moves a fully written temp file into place for digest,
+// transcoding it when that saves space.
+func (s *Storage) storeBlobFile(tempPath, digest string, size int64) error {
+	finalPath := s.getBlobPath(digest)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+
+	s.mu.RLock()
+	alg, level, minSize := s.compression, s.compressionLevel, s.compressMinSize
+	s.mu.RUnlock()
+
+	if alg != "" && alg != compression.AlgorithmNone && size >= minSize && !isCompressedFile(tempPath) {
+		stored, err := transcodeFile(tempPath, finalPath, size, alg, level)
+		if err == nil && stored < size {
+			return removeVariants(finalPath, alg)
+		}
+		// No gain or transcoding failed: fall back to the raw bytes.
+		os.Remove(finalPath + compression.Extension(alg))
+	}
+
+	if err := os.Rename(tempPath, finalPath); err != nil {
+		return fmt.Errorf("failed to move blob: %w", err)
+	}
+	return removeVariants(finalPath, compression.AlgorithmNone)
+}
+
+// transcodeFile compresses the temp file into finalPath plus the algorithm extension.
+func transcodeFile(tempPath, finalPath string, size int64, alg compression.Algorithm, level int) (int64, error) {
+	src, err := os.Open(tempPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	return compression.WriteBlobFile(finalPath, src, size, alg, level)
+}
+
+// removeVariants deletes stored variants of path other than keep.
+func removeVariants(path string, keep compression.Algorithm) error {
+	for _, alg := range []compression.Algorithm{compression.AlgorithmNone, compression.AlgorithmZstd, compression.AlgorithmGzip} {
+		if alg == keep {
+			continue
+		}
+		if err := os.Remove(path + compression.Extension(alg)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove old blob variant: %w", err)
+		}
+	}
+	return nil
+}
+
+// isCompressedFile reports whether the file content is already compressed.
+func isCompressedFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	n, _ := io.ReadFull(file, header)
+	return compression.IsCompressed(header[:n])
+}
+
+// BlobStats walks the blob directory and reports real versus stored size.
+func (s *Storage) BlobStats() (*BlobStats, error) {
+	s.mu.RLock()
+	stats := &BlobStats{Algorithm: string(s.compression)}
+	s.mu.RUnlock()
+	if stats.Algorithm == "" {
+		stats.Algorithm = string(compression.AlgorithmNone)
+	}
+
+	seen := make(map[string]bool)
+	err := filepath.Walk(s.blobPath, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() && (fi.Name() == uploadsDir || fi.Name() == quarantineDir) {
+			return filepath.SkipDir
+		}
+		if fi.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return nil
+		}
+
+		base := strings.TrimSuffix(strings.TrimSuffix(path, ".zst"), ".gz")
+		if seen[base] {
+			return nil
+		}
+		seen[base] = true
+
+		info, err := compression.StatBlobFile(base)
+		if err != nil {
+			return nil
+		}
+		stats.Count++
+		stats.LogicalSize += info.Size
+		stats.StoredSize += info.StoredSize
+		if info.Algorithm != compression.AlgorithmNone {
+			stats.CompressedCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stats.SavedBytes = stats.LogicalSize - stats.StoredSize
+	if stats.LogicalSize > 0 {
+		stats.Ratio = float64(stats.StoredSize) / float64(stats.LogicalSize)
+	}
+	return stats, nil
+}
@@ -0,0 +1,71 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrRepositoryExists is returned when renaming onto a repository that has
+// tags.
+var ErrRepositoryExists = errors.New("repository already exists")
+
+// RenameRepository moves every tag of repository from, including tags in
+// the recycle bin, to repository to in one metadata write. Blobs are
+// content-addressed and stay where they are.
+func (s *Storage) RenameRepository(from, to string) error {
+	// Pending pull counters are keyed by repository name
+	if err := s.FlushPulls(); err != nil {
+		return err
+	}
+
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return err
+	}
+
+	tags, ok := store.Images[from]
+	if !ok {
+		return fmt.Errorf("image not found: %s", from)
+	}
+	if len(store.Images[to]) > 0 {
+		return fmt.Errorf("%w: %s", ErrRepositoryExists, to)
+	}
+
+	store.Images[to] = tags
+	delete(store.Images, from)
+	for _, entry := range store.Trash {
+		if entry.Name == from {
+			entry.Name = to
+		}
+	}
+	return s.saveMetadataUnsafe(store)
+}
+
+// RenameRepository moves repository from with all its tags to to.
+func (s *Service) RenameRepository(from, to string) error {
+	if len(to) > 255 || !repositoryNamePattern.MatchString(to) {
+		return fmt.Errorf("invalid repository name: %s", to)
+	}
+	if err := s.storage.RenameRepository(from, to); err != nil {
+		return err
+	}
+
+	// The digest -> repositories index is stale now
+	s.indexMu.Lock()
+	s.blobIndex = nil
+	s.indexMu.Unlock()
+	return nil
+}
+
+// RepositoryExists reports whether a repository has tags.
+func (s *Service) RepositoryExists(name string) bool {
+	tags, err := s.storage.RepositoryTags(name)
+	return err == nil && len(tags) > 0
+}
@@ -0,0 +1,232 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultTrashRetention is how long deleted tags stay restorable.
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// TrashEntry is a deleted tag kept in the recycle bin until it expires.
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Tag       string    `json:"tag"`
+	Image     *TagInfo  `json:"image"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newTrashID returns a random identifier for a trash entry.
+func newTrashID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// TrashImage moves name:tag from the image index to the recycle bin. The
+// manifest and layer blobs stay on disk until the entry is purged.
+func (s *Storage) TrashImage(name, tag, deletedBy string, retention time.Duration) (*TrashEntry, error) {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	tags, ok := store.Images[name]
+	if !ok {
+		return nil, fmt.Errorf("image not found: %s", name)
+	}
+	info, ok := tags[tag]
+	if !ok {
+		return nil, fmt.Errorf("tag not found: %s:%s", name, tag)
+	}
+
+	now := time.Now().UTC()
+	entry := &TrashEntry{
+		ID:        newTrashID(),
+		Name:      name,
+		Tag:       tag,
+		Image:     info,
+		DeletedBy: deletedBy,
+		DeletedAt: now,
+		ExpiresAt: now.Add(retention),
+	}
+
+	delete(tags, tag)
+	if len(tags) == 0 {
+		delete(store.Images, name)
+	}
+	if store.Trash == nil {
+		store.Trash = make(map[string]*TrashEntry)
+	}
+	store.Trash[entry.ID] = entry
+
+	if err := s.saveMetadataUnsafe(store); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ListTrash returns the recycle bin, most recently deleted first.
+func (s *Storage) ListTrash() ([]*TrashEntry, error) {
+	store, err := s.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*TrashEntry, 0, len(store.Trash))
+	for _, entry := range store.Trash {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// RestoreTrash puts a trashed tag back. It fails with ErrTagExists if the
+// tag has been pushed again since it was deleted.
+func (s *Storage) RestoreTrash(id string) (*ImageManifest, error) {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := store.Trash[id]
+	if !ok {
+		return nil, fmt.Errorf("trash entry not found: %s", id)
+	}
+	if _, exists := store.Images[entry.Name][entry.Tag]; exists {
+		return nil, fmt.Errorf("%w: %s:%s", ErrTagExists, entry.Name, entry.Tag)
+	}
+
+	if store.Images[entry.Name] == nil {
+		store.Images[entry.Name] = make(map[string]*TagInfo)
+	}
+	store.Images[entry.Name][entry.Tag] = entry.Image
+	delete(store.Trash, id)
+
+	if err := s.saveMetadataUnsafe(store); err != nil {
+		return nil, err
+	}
+	return s.imageFromTag(entry.Name, entry.Tag, entry.Image), nil
+}
+
+// removeTrash drops a trash entry from metadata and returns it.
+func (s *Storage) removeTrash(id string) (*TrashEntry, error) {
+	unlock, err := s.lockMetadata()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	store, err := s.loadMetadataUnsafe()
+	if err != nil {
+		return nil, err
+	}
+
+	entry, ok := store.Trash[id]
+	if !ok {
+		return nil, fmt.Errorf("trash entry not found: %s", id)
+	}
+	delete(store.Trash, id)
+
+	if err := s.saveMetadataUnsafe(store); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// SetTrashRetention sets how long deleted tags stay in the recycle bin.
+// Zero disables the recycle bin so deletes are permanent.
+func (s *Service) SetTrashRetention(retention time.Duration) {
+	if retention >= 0 {
+		s.trashRetention.Store(int64(retention))
+	}
+}
+
+// TrashRetention returns how long deleted tags stay in the recycle bin.
+func (s *Service) TrashRetention() time.Duration {
+	return time.Duration(s.trashRetention.Load())
+}
+
+// TrashEnabled reports whether deletes go to the recycle bin.
+func (s *Service) TrashEnabled() bool {
+	return s.TrashRetention() > 0
+}
+
+// ListTrash returns the tags in the recycle bin.
+func (s *Service) ListTrash() ([]*TrashEntry, error) {
+	return s.storage.ListTrash()
+}
+
+// GetTrash returns a trash entry, or nil if there is none with that ID.
+func (s *Service) GetTrash(id string) (*TrashEntry, error) {
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+	return store.Trash[id], nil
+}
+
+// RestoreTrash restores a deleted tag from the recycle bin.
+func (s *Service) RestoreTrash(id string) (*ImageManifest, error) {
+	return s.storage.RestoreTrash(id)
+}
+
+// PurgeTrash permanently deletes a trash entry and its manifest blob if no
+// other tag or trash entry still uses it.
+func (s *Service) PurgeTrash(id string) (*TrashEntry, error) {
+	entry, err := s.storage.removeTrash(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.Image != nil && !s.storage.digestReferenced(entry.Image.Digest) {
+		// Ignore errors, the blob is only reclaimed space
+		_ = s.storage.DeleteBlob(entry.Image.Digest)
+	}
+	return entry, nil
+}
+
+// PurgeExpiredTrash permanently deletes trash entries whose retention has
+// passed and returns how many were removed.
+func (s *Service) PurgeExpiredTrash() (int, error) {
+	entries, err := s.storage.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	purged := 0
+	for _, entry := range entries {
+		if entry.ExpiresAt.After(now) {
+			continue
+		}
+		if _, err := s.PurgeTrash(entry.ID); err != nil {
+			return purged, fmt.Errorf("failed to purge %s:%s: %w", entry.Name, entry.Tag, err)
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -0,0 +1,308 @@
+package registry
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// uploadsDir is the directory of the blob directory holding upload
+// sessions, one subdirectory per session with the received data and the
+// session info. It lives next to the blobs so a completed upload is moved
+// into place with a rename.
+const uploadsDir = "_uploads"
+
+// ErrUploadUnknown is returned for an upload session that does not exist,
+// was completed or cancelled, or belongs to another repository.
+var ErrUploadUnknown = errors.New("blob upload unknown")
+
+// ErrUploadRange is returned when a chunk does not start where the data
+// received so far ends.
+var ErrUploadRange = errors.New("blob upload out of order")
+
+// uploadIDPattern matches the identifiers generated by CreateUpload.
+var uploadIDPattern = regexp.MustCompile(`^[a-f0-9]{32}$`)
+
+// UploadSession is a blob upload in progress.
+type UploadSession struct {
+	UUID       string    `json:"uuid"`
+	Repository string    `json:"repository"`
+	StartedAt  time.Time `json:"started_at"`
+	Size       int64     `json:"-"` // bytes received so far
+	UpdatedAt  time.Time `json:"-"` // time of the last chunk
+}
+
+// uploadPath returns the directory of an upload session.
+func (s *Storage) uploadPath(uuid string) string {
+	return filepath.Join(s.blobPath, uploadsDir, uuid)
+}
+
+// CreateUpload starts an upload session for repository.
+func (s *Storage) CreateUpload(repository string) (*UploadSession, error) {
+	if err := s.checkWritable(); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate upload id: %w", err)
+	}
+	session := &UploadSession{
+		UUID:       hex.EncodeToString(buf),
+		Repository: repository,
+		StartedAt:  time.Now().UTC(),
+	}
+
+	dir := s.uploadPath(session.UUID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	info, err := json.Marshal(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "info.json"), info, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data"), nil, 0644); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create upload data: %w", err)
+	}
+	session.UpdatedAt = session.StartedAt
+	return session, nil
+}
+
+// GetUpload returns the upload session uuid of repository.
+func (s *Storage) GetUpload(repository, uuid string) (*UploadSession, error) {
+	if !uploadIDPattern.MatchString(uuid) {
+		return nil, ErrUploadUnknown
+	}
+	dir := s.uploadPath(uuid)
+	data, err := os.ReadFile(filepath.Join(dir, "info.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUploadUnknown
+		}
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse upload info: %w", err)
+	}
+	if session.Repository != repository {
+		return nil, ErrUploadUnknown
+	}
+
+	fi, err := os.Stat(filepath.Join(dir, "data"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrUploadUnknown
+		}
+		return nil, fmt.Errorf("failed to stat upload data: %w", err)
+	}
+	session.Size = fi.Size()
+	session.UpdatedAt = fi.ModTime()
+	return &session, nil
+}
+
+// AppendUpload appends a chunk to an upload session. offset is where the
+// client says the chunk starts, or -1 when it did not say.
+func (s *Storage) AppendUpload(repository, uuid string, offset int64, data io.Reader) (*UploadSession, error) {
+	session, err := s.GetUpload(repository, uuid)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWritable(); err != nil {
+		return session, err
+	}
+	if offset >= 0 && offset != session.Size {
+		return session, fmt.Errorf("%w: chunk starts at %d, received %d bytes", ErrUploadRange, offset, session.Size)
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.uploadPath(uuid), "data"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload data: %w", err)
+	}
+	n, err := io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	session.Size += n
+	session.UpdatedAt = time.Now()
+	if err != nil {
+		return session, fmt.Errorf("failed to write upload data: %w", err)
+	}
+	return session, nil
+}
+
+// CompleteUpload appends the last chunk, which may be empty, verifies the
+// data against digest and stores it as a blob. On a digest mismatch the
+// session is removed and ErrDigestInvalid is returned.
+func (s *Storage) CompleteUpload(repository, uuid, digest string, data io.Reader) (int64, error) {
+	if !sha256DigestPattern.MatchString(digest) {
+		return 0, fmt.Errorf("%w: unsupported digest %q", ErrDigestInvalid, digest)
+	}
+	if _, err := s.AppendUpload(repository, uuid, -1, data); err != nil {
+		return 0, err
+	}
+
+	dir := s.uploadPath(uuid)
+	defer os.RemoveAll(dir)
+
+	dataPath := filepath.Join(dir, "data")
+	file, err := os.Open(dataPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open upload data: %w", err)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	file.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read upload data: %w", err)
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return 0, fmt.Errorf("%w: expected %s, got %s", ErrDigestInvalid, digest, actual)
+	}
+
+	if err := s.storeBlobFile(dataPath, digest, size); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CancelUpload removes an upload session and the data received.
+func (s *Storage) CancelUpload(repository, uuid string) error {
+	if _, err := s.GetUpload(repository, uuid); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.uploadPath(uuid)); err != nil {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+	return nil
+}
+
+// StartUpload starts an upload session for repository.
+func (s *Service) StartUpload(repository string) (*UploadSession, error) {
+	return s.storage.CreateUpload(repository)
+}
+
+// GetUpload returns an upload session of repository.
+func (s *Service) GetUpload(repository, uuid string) (*UploadSession, error) {
+	return s.storage.GetUpload(repository, uuid)
+}
+
+// AppendUpload appends a chunk to an upload session.
+func (s *Service) AppendUpload(repository, uuid string, offset int64, data io.Reader) (*UploadSession, error) {
+	return s.storage.AppendUpload(repository, uuid, offset, data)
+}
+
+// CompleteUpload finishes an upload session, stores the blob and links it
+// to the repository.
+func (s *Service) CompleteUpload(repository, uuid, digest string, data io.Reader) (int64, error) {
+	size, err := s.storage.CompleteUpload(repository, uuid, digest, data)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.storage.LinkBlob(repository, digest); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// CancelUpload cancels an upload session.
+func (s *Service) CancelUpload(repository, uuid string) error {
+	return s.storage.CancelUpload(repository, uuid)
+}
+
+// ActiveUploads returns the number of upload sessions in progress.
+func (s *Service) ActiveUploads() int {
+	entries, err := os.ReadDir(filepath.Join(s.storage.blobPath, uploadsDir))
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// PurgeStaleUploads removes upload sessions that received no data for
+// maxAge, and the temporary files of blob uploads and transcodes that were
+// interrupted, e.g. by a crash, and are older than maxAge. It returns how
+// many sessions and files were removed.
+func (s *Service) PurgeStaleUploads(maxAge time.Duration) (int, error) {
+	sessions, err := s.storage.purgeStaleSessions(maxAge)
+	files, walkErr := s.storage.purgeStaleTempFiles(maxAge)
+	return sessions + files, errors.Join(err, walkErr)
+}
+
+// purgeStaleSessions removes the upload sessions whose data was last
+// written before maxAge.
+func (s *Storage) purgeStaleSessions(maxAge time.Duration) (int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.blobPath, uploadsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	for _, entry := range entries {
+		dir := filepath.Join(s.blobPath, uploadsDir, entry.Name())
+		// A session without data was interrupted while being created
+		fi, err := os.Stat(filepath.Join(dir, "data"))
+		if err != nil {
+			if fi, err = os.Stat(dir); err != nil {
+				continue
+			}
+		}
+		if fi.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err == nil {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// purgeStaleTempFiles removes the blob-*.tmp and transcode-*.tmp files of
+// the blob directory that were last written before maxAge.
+func (s *Storage) purgeStaleTempFiles(maxAge time.Duration) (int, error) {
+	cutoff := time.Now().Add(-maxAge)
+	removed := 0
+	err := filepath.WalkDir(s.blobPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		name := d.Name()
+		if d.IsDir() && name == uploadsDir {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(name, ".tmp") ||
+			!(strings.HasPrefix(name, "blob-") || strings.HasPrefix(name, "transcode-")) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		if err := os.Remove(path); err == nil {
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
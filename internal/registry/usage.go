@@ -0,0 +1,375 @@
+// Package registry provides container image registry functionality.
+package registry
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/pkg/compression"
+)
+
+// DefaultUsageInterval is how often the storage usage index is rebuilt.
+const DefaultUsageInterval = 5 * time.Minute
+
+// RepositoryUsage is the storage breakdown of one repository.
+type RepositoryUsage struct {
+	Name          string `json:"name"`
+	Tags          int    `json:"tags"`
+	Blobs         int    `json:"blobs"`
+	Size          int64  `json:"size"`           // unique bytes referenced by this repository
+	ExclusiveSize int64  `json:"exclusive_size"` // bytes freed if the repository were deleted
+	SharedSize    int64  `json:"shared_size"`    // bytes also referenced by other repositories
+}
+
+// NamespaceUsage is the storage breakdown of one namespace, the user or
+// organization owning the repositories, which is the unit of per-tenant
+// accounting. It is read from the stored blob reference index, see
+// blob_refs.go. Blobs are stored once, so a blob shared by several tenants
+// counts in full for each of them.
+type NamespaceUsage struct {
+	Namespace     string `json:"namespace"` // "" for repositories without a namespace
+	Repositories  int    `json:"repositories"`
+	Blobs         int    `json:"blobs"`
+	References    int    `json:"references"`     // tags and trash entries of the namespace referencing each blob, summed
+	Size          int64  `json:"size"`           // unique bytes referenced by the namespace
+	ExclusiveSize int64  `json:"exclusive_size"` // bytes freed if the namespace were deleted
+	SharedSize    int64  `json:"shared_size"`    // bytes also referenced by other namespaces
+}
+
+// NamespaceBlob is a blob referenced by a namespace.
+type NamespaceBlob struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	Refs   int    `json:"refs"`   // tags and trash entries of the namespace referencing the blob
+	Shared bool   `json:"shared"` // also referenced by other namespaces
+}
+
+// StorageUsage summarizes deduplicated storage usage.
+type StorageUsage struct {
+	BlobCount      int                `json:"blob_count"`
+	TotalSize      int64              `json:"total_size"`      // logical size of all stored blobs
+	StoredSize     int64              `json:"stored_size"`     // bytes used on disk after compression
+	ReferencedSize int64              `json:"referenced_size"` // sum of image sizes as if nothing were shared
+	DedupSavings   int64              `json:"dedup_savings"`   // referenced minus unique referenced bytes
+	SharedBlobs    int                `json:"shared_blobs"`    // blobs used by more than one image
+	OrphanBlobs    int                `json:"orphan_blobs"`
+	OrphanSize     int64              `json:"orphan_size"`
+	TrashSize      int64              `json:"trash_size"` // bytes only held by tags in the recycle bin
+	CacheSize      int64              `json:"cache_size"`
+	DiskTotal      int64              `json:"disk_total"`
+	DiskFree       int64              `json:"disk_free"`
+	DiskStatus     *DiskStatus        `json:"disk_status,omitempty"` // free space watchdog
+	Repositories   []*RepositoryUsage `json:"repositories"`
+	Namespaces     []*NamespaceUsage  `json:"namespaces"`
+	UpdatedAt      time.Time          `json:"updated_at"`
+}
+
+// repositoryNamespace returns the namespace of a repository, the part
+// before the first slash, or "" if it has none.
+func repositoryNamespace(name string) string {
+	namespace, _, ok := strings.Cut(name, "/")
+	if !ok {
+		return ""
+	}
+	return namespace
+}
+
+// usageIndex holds the last computed usage snapshot.
+type usageIndex struct {
+	mu        sync.RWMutex
+	usage     *StorageUsage
+	cacheSize func() int64
+	started   bool
+}
+
+// SetCacheSizeFunc sets the callback reporting the accelerator cache size.
+func (s *Service) SetCacheSizeFunc(fn func() int64) {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	s.usage.cacheSize = fn
+}
+
+// StartUsageIndexer rebuilds the storage usage index every interval in the
+// background and flushes pending pull counters. Calling it more than once
+// has no effect.
+func (s *Service) StartUsageIndexer(interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultUsageInterval
+	}
+
+	s.usage.mu.Lock()
+	if s.usage.started {
+		s.usage.mu.Unlock()
+		return
+	}
+	s.usage.started = true
+	s.usage.mu.Unlock()
+
+	go func() {
+		s.RefreshStorageUsage()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// Also persist pull counters of tags that are no longer pulled
+			s.storage.FlushPulls()
+			s.RefreshStorageUsage()
+		}
+	}()
+}
+
+// StorageUsage returns the cached usage snapshot, building it on first use.
+// Cache size and free disk space are read live since they are cheap.
+func (s *Service) StorageUsage() (*StorageUsage, error) {
+	s.usage.mu.RLock()
+	cached, cacheSize := s.usage.usage, s.usage.cacheSize
+	s.usage.mu.RUnlock()
+
+	if cached == nil {
+		var err error
+		if cached, err = s.RefreshStorageUsage(); err != nil {
+			return nil, err
+		}
+	}
+
+	usage := *cached
+	if cacheSize != nil {
+		usage.CacheSize = cacheSize()
+	}
+	usage.DiskTotal, usage.DiskFree = diskSpace(s.storage.GetBlobPath())
+	usage.DiskStatus = s.DiskStatus()
+	return &usage, nil
+}
+
+// RefreshStorageUsage rebuilds the usage index from image metadata and the
+// blob directory.
+func (s *Service) RefreshStorageUsage() (*StorageUsage, error) {
+	usage, err := s.computeStorageUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	s.usage.mu.Lock()
+	s.usage.usage = usage
+	s.usage.mu.Unlock()
+	return usage, nil
+}
+
+// computeStorageUsage walks metadata once to attribute every referenced
+// blob to images and repositories.
+func (s *Service) computeStorageUsage() (*StorageUsage, error) {
+	stats, err := s.storage.BlobStats()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &StorageUsage{
+		BlobCount:  stats.Count,
+		TotalSize:  stats.LogicalSize,
+		StoredSize: stats.StoredSize,
+		UpdatedAt:  time.Now(),
+	}
+
+	sizes := make(map[string]int64)
+	blobSize := func(digest string) int64 {
+		if size, ok := sizes[digest]; ok {
+			return size
+		}
+		var size int64
+		if info, err := compression.StatBlobFile(s.storage.getBlobPath(digest)); err == nil {
+			size = info.Size
+		}
+		sizes[digest] = size
+		return size
+	}
+
+	imageRefs := make(map[string]int)            // digest -> number of images using it
+	repoRefs := make(map[string]map[string]bool) // digest -> repositories using it
+	repoBlobs := make(map[string]map[string]bool)
+	repos := make(map[string]*RepositoryUsage)
+	nsRepos := make(map[string]int)
+
+	for name, tags := range store.Images {
+		repo := &RepositoryUsage{Name: name, Tags: len(tags)}
+		repos[name] = repo
+		repoBlobs[name] = make(map[string]bool)
+
+		nsRepos[repositoryNamespace(name)]++
+
+		// Tags pointing at the same manifest are one image.
+		images := make(map[string]*TagInfo)
+		for _, info := range tags {
+			images[info.Digest] = info
+		}
+
+		for manifestDigest, info := range images {
+			digests := map[string]bool{manifestDigest: true}
+			for _, layer := range info.Layers {
+				digests[layer.Digest] = true
+			}
+			if config := s.manifestConfigDigest(manifestDigest); config != "" {
+				digests[config] = true
+			}
+
+			for digest := range digests {
+				if digest == "" {
+					continue
+				}
+				usage.ReferencedSize += blobSize(digest)
+				imageRefs[digest]++
+				repoBlobs[name][digest] = true
+				if repoRefs[digest] == nil {
+					repoRefs[digest] = make(map[string]bool)
+				}
+				repoRefs[digest][name] = true
+			}
+		}
+	}
+
+	var uniqueSize int64
+	for digest, count := range imageRefs {
+		uniqueSize += blobSize(digest)
+		if count > 1 {
+			usage.SharedBlobs++
+		}
+	}
+	usage.DedupSavings = usage.ReferencedSize - uniqueSize
+
+	// Blobs held only by trashed tags come back on restore, so they are
+	// not orphans yet.
+	trashBlobs := make(map[string]bool)
+	for _, entry := range store.Trash {
+		if entry.Image == nil {
+			continue
+		}
+		digests := []string{entry.Image.Digest}
+		for _, layer := range entry.Image.Layers {
+			digests = append(digests, layer.Digest)
+		}
+		for _, digest := range digests {
+			if digest == "" || imageRefs[digest] > 0 || trashBlobs[digest] {
+				continue
+			}
+			trashBlobs[digest] = true
+			usage.TrashSize += blobSize(digest)
+		}
+	}
+
+	// Blobs on disk that no image references are reclaimable by GC.
+	usage.OrphanBlobs = max(stats.Count-len(imageRefs)-len(trashBlobs), 0)
+	usage.OrphanSize = max(stats.LogicalSize-uniqueSize-usage.TrashSize, 0)
+
+	usage.Repositories = make([]*RepositoryUsage, 0, len(repos))
+	for name, repo := range repos {
+		for digest := range repoBlobs[name] {
+			size := blobSize(digest)
+			repo.Blobs++
+			repo.Size += size
+			if len(repoRefs[digest]) > 1 {
+				repo.SharedSize += size
+			} else {
+				repo.ExclusiveSize += size
+			}
+		}
+		usage.Repositories = append(usage.Repositories, repo)
+	}
+	sort.Slice(usage.Repositories, func(i, j int) bool {
+		if usage.Repositories[i].Size != usage.Repositories[j].Size {
+			return usage.Repositories[i].Size > usage.Repositories[j].Size
+		}
+		return usage.Repositories[i].Name < usage.Repositories[j].Name
+	})
+
+	now := time.Now()
+	nsRefs := namespacesReferencing(store, now)
+	usage.Namespaces = make([]*NamespaceUsage, 0, len(store.Namespaces))
+	for namespace, refs := range store.Namespaces {
+		if len(refs.Blobs) == 0 {
+			continue // only recent uploads
+		}
+		ns := &NamespaceUsage{Namespace: namespace, Repositories: nsRepos[namespace]}
+		for digest := range refs.Blobs {
+			size := blobSize(digest)
+			ns.Blobs++
+			ns.References += refs.imageRefs(digest)
+			ns.Size += size
+			if nsRefs[digest] > 1 {
+				ns.SharedSize += size
+			} else {
+				ns.ExclusiveSize += size
+			}
+		}
+		usage.Namespaces = append(usage.Namespaces, ns)
+	}
+	sort.Slice(usage.Namespaces, func(i, j int) bool {
+		if usage.Namespaces[i].Size != usage.Namespaces[j].Size {
+			return usage.Namespaces[i].Size > usage.Namespaces[j].Size
+		}
+		return usage.Namespaces[i].Namespace < usage.Namespaces[j].Namespace
+	})
+
+	return usage, nil
+}
+
+// namespacesReferencing counts the namespaces referencing each digest.
+func namespacesReferencing(store *ImageStore, now time.Time) map[string]int {
+	counts := make(map[string]int)
+	for _, refs := range store.Namespaces {
+		seen := make(map[string]bool)
+		for digest := range refs.Blobs {
+			seen[digest] = true
+		}
+		for digest := range refs.Links {
+			if refs.references(digest, now) {
+				seen[digest] = true
+			}
+		}
+		for digest := range seen {
+			counts[digest]++
+		}
+	}
+	return counts
+}
+
+// NamespaceBlobs returns the blobs referenced by the tags and trash entries
+// of a namespace with their reference counts, largest first, from the
+// stored reference index. ok is false if the namespace has no images.
+func (s *Service) NamespaceBlobs(namespace string) (blobs []*NamespaceBlob, ok bool, err error) {
+	store, err := s.storage.LoadMetadata()
+	if err != nil {
+		return nil, false, err
+	}
+	refs, ok := store.Namespaces[namespace]
+	if !ok || len(refs.Blobs) == 0 {
+		return nil, false, nil
+	}
+
+	nsRefs := namespacesReferencing(store, time.Now())
+	blobs = make([]*NamespaceBlob, 0, len(refs.Blobs))
+	for digest := range refs.Blobs {
+		var size int64
+		if info, err := compression.StatBlobFile(s.storage.getBlobPath(digest)); err == nil {
+			size = info.Size
+		}
+		blobs = append(blobs, &NamespaceBlob{
+			Digest: digest,
+			Size:   size,
+			Refs:   refs.imageRefs(digest),
+			Shared: nsRefs[digest] > 1,
+		})
+	}
+	sort.Slice(blobs, func(i, j int) bool {
+		if blobs[i].Size != blobs[j].Size {
+			return blobs[i].Size > blobs[j].Size
+		}
+		return blobs[i].Digest < blobs[j].Digest
+	})
+	return blobs, true, nil
+}
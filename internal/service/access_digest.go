@@ -0,0 +1,534 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// 访问摘要中列表的长度和激增判断的基线天数
+const (
+	accessDigestNewIPLimit   = 20
+	accessDigestListLimit    = 10
+	accessDigestLockLimit    = 50
+	accessDigestBaselineDays = 7
+	accessDigestDateFormat   = "2006-01-02"
+	maxAccessSummaryDays     = 31
+)
+
+// Access digest errors.
+var (
+	ErrInvalidSummaryDate = errors.New("invalid summary date")
+	ErrDigestNoEmail      = errors.New("account has no email address")
+)
+
+// AccessDigestConfig holds the schedule and spike detection of the daily
+// access digest.
+type AccessDigestConfig struct {
+	Enabled        bool
+	Hour           int     // 发送前一天摘要的时间，服务器时区
+	SpikeThreshold int64   // 一小时内认证失败的最小次数
+	SpikeFactor    float64 // 相对前 7 天小时均值的倍数
+}
+
+// AccessSummary aggregates the access attempts of one day, in the time
+// zone of the server.
+type AccessSummary struct {
+	Date          string             `json:"date"`
+	Start         time.Time          `json:"start"`
+	End           time.Time          `json:"end"`
+	Complete      bool               `json:"complete"` // 当天已结束
+	Totals        AccessTotals       `json:"totals"`
+	NewIPs        NewAccessIPs       `json:"new_ips"`
+	FailingIPs    []*AccessIP        `json:"top_failing_ips"`
+	FailureSpikes []*FailureSpike    `json:"failure_spikes"`
+	Blocked       BlockedAccess      `json:"blocked"`
+	Locks         []*AccessLockEvent `json:"locks"`
+	// Anomalous is set for failure spikes, blocked requests, intrusion
+	// rules, system locks and logins from new addresses.
+	Anomalous    bool              `json:"anomalous"`
+	DigestSentAt *time.Time        `json:"digest_sent_at,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+}
+
+// AccessTotals counts the attempts of the day.
+type AccessTotals struct {
+	Attempts         int64 `json:"attempts"`
+	Failures         int64 `json:"failures"`
+	FailedAuth       int64 `json:"failed_auth"` // 登录和令牌认证失败
+	FailedLogins     int64 `json:"failed_logins"`
+	SuccessfulLogins int64 `json:"successful_logins"`
+	NotFound         int64 `json:"not_found"` // 匿名请求不存在的路径
+	UniqueIPs        int64 `json:"unique_ips"`
+}
+
+// NewAccessIPs lists the addresses seen for the first time.
+type NewAccessIPs struct {
+	Total      int64       `json:"total"`
+	WithLogins int64       `json:"with_logins"`
+	IPs        []*AccessIP `json:"ips"` // 有成功登录的在前，最多 20 个
+}
+
+// AccessIP counts the attempts of one address.
+type AccessIP struct {
+	IPAddress string    `json:"ip_address"`
+	Attempts  int64     `json:"attempts"`
+	Failures  int64     `json:"failures"`
+	Users     []string  `json:"users,omitempty"` // 成功登录的用户
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// FailureSpike is an hour with unusually many authentication failures.
+type FailureSpike struct {
+	Hour     time.Time `json:"hour"`
+	Failures int64     `json:"failures"`
+	Baseline float64   `json:"baseline"` // 前 7 天平均每小时的认证失败次数
+}
+
+// BlockedAccess counts the requests refused by the IP rules and the
+// intrusion rules triggered.
+type BlockedAccess struct {
+	Requests     int64            `json:"requests"`
+	RequestIPs   []*AccessIPCount `json:"request_ips"`
+	Intrusions   int64            `json:"intrusions"`
+	IntrusionIPs []*AccessIPCount `json:"intrusion_ips"`
+}
+
+// AccessIPCount counts the events of one address.
+type AccessIPCount struct {
+	IPAddress string `json:"ip_address"`
+	Count     int64  `json:"count"`
+}
+
+// AccessLockEvent is a system lock.
+type AccessLockEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	IPAddress string    `json:"ip_address"`
+	Reason    string    `json:"reason"`
+	LockType  string    `json:"lock_type"`
+}
+
+// DigestSubscription is the access digest preference of an administrator.
+// The digest is mailed to the email address of the account.
+type DigestSubscription struct {
+	Subscribed    bool       `json:"subscribed"`
+	OnlyAnomalies bool       `json:"only_anomalies"` // 只在有异常时发送
+	Email         string     `json:"email"`
+	UpdatedAt     *time.Time `json:"updated_at,omitempty"`
+}
+
+// AccessDigestService summarizes the access attempts of each day and sends
+// the summary of the previous day to the subscribed administrators by
+// email and to the web console.
+type AccessDigestService struct {
+	logger *zap.Logger
+
+	mu        sync.RWMutex
+	config    AccessDigestConfig
+	mailer    Mailer
+	publisher func(event string, data map[string]interface{})
+}
+
+// NewAccessDigestService creates a new AccessDigestService instance.
+func NewAccessDigestService(config AccessDigestConfig, logger *zap.Logger) *AccessDigestService {
+	return &AccessDigestService{config: config, logger: logger}
+}
+
+// Configure replaces the schedule and spike detection settings.
+func (s *AccessDigestService) Configure(config AccessDigestConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = config
+}
+
+// SetMailer 设置发送摘要邮件的邮件服务，为 nil 时只推送到 Web 控制台
+func (s *AccessDigestService) SetMailer(mailer Mailer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mailer = mailer
+}
+
+// SetPublisher sets the function publishing the digest to the web console.
+func (s *AccessDigestService) SetPublisher(fn func(event string, data map[string]interface{})) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.publisher = fn
+}
+
+// ParseSummaryDate parses a date of the form 2006-01-02 as the start of
+// that day in the time zone of the server.
+func ParseSummaryDate(date string) (time.Time, error) {
+	day, err := time.ParseInLocation(accessDigestDateFormat, date, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidSummaryDate, date)
+	}
+	if day.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("%w: %s is in the future", ErrInvalidSummaryDate, date)
+	}
+	return day, nil
+}
+
+// Summaries returns the summaries of the last days, today first.
+func (s *AccessDigestService) Summaries(days int) ([]*AccessSummary, error) {
+	if days < 1 || days > maxAccessSummaryDays {
+		return nil, fmt.Errorf("%w: days must be between 1 and %d", ErrInvalidSummaryDate, maxAccessSummaryDays)
+	}
+	today := startOfDay(time.Now())
+	summaries := make([]*AccessSummary, 0, days)
+	for i := 0; i < days; i++ {
+		summary, err := s.Summary(today.AddDate(0, 0, -i))
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Summary aggregates the access attempts of the day starting at day. A
+// failing section other than the totals is reported in Errors.
+func (s *AccessDigestService) Summary(day time.Time) (*AccessSummary, error) {
+	s.mu.RLock()
+	config := s.config
+	s.mu.RUnlock()
+
+	start := startOfDay(day)
+	end := start.AddDate(0, 0, 1)
+	summary := &AccessSummary{
+		Date:          start.Format(accessDigestDateFormat),
+		Start:         start,
+		End:           end,
+		Complete:      !time.Now().Before(end),
+		FailingIPs:    []*AccessIP{},
+		FailureSpikes: []*FailureSpike{},
+		Blocked:       BlockedAccess{RequestIPs: []*AccessIPCount{}, IntrusionIPs: []*AccessIPCount{}},
+		Locks:         []*AccessLockEvent{},
+		Errors:        map[string]string{},
+	}
+	summary.NewIPs.IPs = []*AccessIP{}
+
+	totals, err := dao.GetAccessTotals(start, end)
+	if err != nil {
+		return nil, err
+	}
+	summary.Totals = AccessTotals(*totals)
+
+	if ips, total, withLogins, err := dao.ListNewAccessIPs(start, end, accessDigestNewIPLimit); err != nil {
+		summary.Errors["new_ips"] = err.Error()
+	} else {
+		summary.NewIPs.Total, summary.NewIPs.WithLogins = total, withLogins
+		summary.NewIPs.IPs = append(summary.NewIPs.IPs, accessIPs(ips)...)
+	}
+
+	if ips, err := dao.ListFailingAccessIPs(start, end, accessDigestListLimit); err != nil {
+		summary.Errors["top_failing_ips"] = err.Error()
+	} else {
+		summary.FailingIPs = append(summary.FailingIPs, accessIPs(ips)...)
+	}
+
+	if spikes, err := failureSpikes(start, end, config); err != nil {
+		summary.Errors["failure_spikes"] = err.Error()
+	} else {
+		summary.FailureSpikes = append(summary.FailureSpikes, spikes...)
+	}
+
+	if ips, total, err := dao.ListAuditEventIPs("ip_blocked", start, end, accessDigestListLimit); err != nil {
+		summary.Errors["blocked"] = err.Error()
+	} else {
+		summary.Blocked.Requests = total
+		summary.Blocked.RequestIPs = append(summary.Blocked.RequestIPs, accessIPCounts(ips)...)
+	}
+	if ips, total, err := dao.ListAuditEventIPs("intrusion_detected", start, end, accessDigestListLimit); err != nil {
+		summary.Errors["intrusions"] = err.Error()
+	} else {
+		summary.Blocked.Intrusions = total
+		summary.Blocked.IntrusionIPs = append(summary.Blocked.IntrusionIPs, accessIPCounts(ips)...)
+	}
+
+	if logs, _, err := dao.GetAuditLogs(1, accessDigestLockLimit, "system_locked", "", start, end); err != nil {
+		summary.Errors["locks"] = err.Error()
+	} else {
+		for _, log := range logs {
+			lock := &AccessLockEvent{Timestamp: log.Timestamp, IPAddress: log.IPAddress}
+			lock.Reason, _ = log.Details["reason"].(string)
+			lock.LockType, _ = log.Details["lock_type"].(string)
+			summary.Locks = append(summary.Locks, lock)
+		}
+	}
+
+	summary.Anomalous = len(summary.FailureSpikes) > 0 || summary.Blocked.Requests > 0 ||
+		summary.Blocked.Intrusions > 0 || len(summary.Locks) > 0 || summary.NewIPs.WithLogins > 0
+
+	if sentAt, err := dao.GetAccessDigestSentAt(summary.Date); err == nil {
+		summary.DigestSentAt = sentAt
+	}
+	if len(summary.Errors) == 0 {
+		summary.Errors = nil
+	}
+	return summary, nil
+}
+
+// failureSpikes returns the hours between start and end whose
+// authentication failures reach the threshold and the configured multiple
+// of the hourly average of the previous days.
+func failureSpikes(start, end time.Time, config AccessDigestConfig) ([]*FailureSpike, error) {
+	hours, err := dao.CountAuthFailuresByHour(start, end)
+	if err != nil {
+		return nil, err
+	}
+	before, err := dao.GetAccessTotals(start.AddDate(0, 0, -accessDigestBaselineDays), start)
+	if err != nil {
+		return nil, err
+	}
+	baseline := float64(before.FailedAuth) / float64(accessDigestBaselineDays*24)
+
+	threshold := config.SpikeThreshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	var spikes []*FailureSpike
+	for hour, failures := range hours {
+		if failures < threshold || float64(failures) < config.SpikeFactor*baseline {
+			continue
+		}
+		spikes = append(spikes, &FailureSpike{Hour: hour.Local(), Failures: failures, Baseline: baseline})
+	}
+	sort.Slice(spikes, func(i, j int) bool { return spikes[i].Hour.Before(spikes[j].Hour) })
+	return spikes, nil
+}
+
+func accessIPs(stats []*dao.AccessIPStats) []*AccessIP {
+	ips := make([]*AccessIP, 0, len(stats))
+	for _, s := range stats {
+		ips = append(ips, &AccessIP{
+			IPAddress: s.IPAddress,
+			Attempts:  s.Attempts,
+			Failures:  s.Failures,
+			Users:     s.Users,
+			FirstSeen: s.FirstSeen,
+		})
+	}
+	return ips
+}
+
+func accessIPCounts(events []*dao.AuditEventIP) []*AccessIPCount {
+	counts := make([]*AccessIPCount, 0, len(events))
+	for _, e := range events {
+		counts = append(counts, &AccessIPCount{IPAddress: e.IPAddress, Count: e.Count})
+	}
+	return counts
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+// SendDueDigest sends the digest of the previous day once the configured
+// hour has passed. Each digest is sent once, also across the instances of
+// a cluster.
+func (s *AccessDigestService) SendDueDigest(_ context.Context) error {
+	s.mu.RLock()
+	config := s.config
+	s.mu.RUnlock()
+	if !config.Enabled {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Hour() < config.Hour {
+		return nil
+	}
+	day := startOfDay(now).AddDate(0, 0, -1)
+	sentAt, err := dao.GetAccessDigestSentAt(day.Format(accessDigestDateFormat))
+	if err != nil || sentAt != nil {
+		return err
+	}
+	return s.sendDigest(day)
+}
+
+func (s *AccessDigestService) sendDigest(day time.Time) error {
+	summary, err := s.Summary(day)
+	if err != nil {
+		return err
+	}
+	claimed, err := dao.ClaimAccessDigest(summary.Date)
+	if err != nil || !claimed {
+		return err
+	}
+
+	s.mu.RLock()
+	mailer, publish := s.mailer, s.publisher
+	s.mu.RUnlock()
+
+	if publish != nil {
+		publish("access_digest", map[string]interface{}{
+			"date":      summary.Date,
+			"anomalous": summary.Anomalous,
+			"summary":   summary,
+		})
+	}
+
+	sent := 0
+	if mailer != nil {
+		recipients, err := dao.ListDigestRecipients()
+		if err != nil {
+			return err
+		}
+		subject, body := digestSubject(summary), digestBody(summary)
+		for _, r := range recipients {
+			if r.OnlyAnomalies && !summary.Anomalous {
+				continue
+			}
+			// 逐个发送，管理员之间不互相暴露邮箱
+			if err := mailer.Send([]string{r.Email}, subject, body); err != nil {
+				s.logWarn("发送访问摘要邮件失败", zap.String("username", r.Username), zap.Error(err))
+				continue
+			}
+			sent++
+		}
+	}
+	if err := dao.SetAccessDigestRecipients(summary.Date, sent); err != nil {
+		s.logWarn("记录访问摘要发送结果失败", zap.Error(err))
+	}
+
+	if s.logger != nil {
+		s.logger.Info("访问摘要已发送",
+			zap.String("date", summary.Date),
+			zap.Bool("anomalous", summary.Anomalous),
+			zap.Int("recipients", sent),
+		)
+	}
+	return nil
+}
+
+func digestSubject(summary *AccessSummary) string {
+	subject := "[CYP-Registry] 访问摘要 " + summary.Date
+	if summary.Anomalous {
+		subject += "（发现异常）"
+	}
+	return subject
+}
+
+// digestBody renders the summary as the plain text of the digest email.
+func digestBody(summary *AccessSummary) string {
+	var b strings.Builder
+	t := summary.Totals
+	fmt.Fprintf(&b, "CYP-Docker-Registry %s 访问摘要\n\n", summary.Date)
+	fmt.Fprintf(&b, "访问尝试 %d 次，来自 %d 个 IP；失败 %d 次，其中认证失败 %d 次、访问不存在的路径 %d 次。\n",
+		t.Attempts, t.UniqueIPs, t.Failures, t.FailedAuth, t.NotFound)
+	fmt.Fprintf(&b, "成功登录 %d 次，登录失败 %d 次。\n", t.SuccessfulLogins, t.FailedLogins)
+
+	if len(summary.FailureSpikes) > 0 {
+		b.WriteString("\n认证失败激增：\n")
+		for _, spike := range summary.FailureSpikes {
+			fmt.Fprintf(&b, "  %s-%s  %d 次（前 %d 天平均每小时 %.1f 次）\n",
+				spike.Hour.Format("15:04"), spike.Hour.Add(time.Hour).Format("15:04"),
+				spike.Failures, accessDigestBaselineDays, spike.Baseline)
+		}
+	}
+
+	if summary.NewIPs.Total > 0 {
+		fmt.Fprintf(&b, "\n首次出现的 IP：%d 个，其中 %d 个有成功登录\n", summary.NewIPs.Total, summary.NewIPs.WithLogins)
+		for _, ip := range summary.NewIPs.IPs {
+			fmt.Fprintf(&b, "  %-40s 尝试 %d 次，失败 %d 次", ip.IPAddress, ip.Attempts, ip.Failures)
+			if len(ip.Users) > 0 {
+				fmt.Fprintf(&b, "，登录用户 %s", strings.Join(ip.Users, ", "))
+			}
+			b.WriteString("\n")
+		}
+		if n := summary.NewIPs.Total - int64(len(summary.NewIPs.IPs)); n > 0 {
+			fmt.Fprintf(&b, "  … 另有 %d 个\n", n)
+		}
+	}
+
+	if len(summary.FailingIPs) > 0 {
+		b.WriteString("\n失败最多的 IP：\n")
+		for _, ip := range summary.FailingIPs {
+			fmt.Fprintf(&b, "  %-40s 失败 %d 次\n", ip.IPAddress, ip.Failures)
+		}
+	}
+
+	if blocked := summary.Blocked; blocked.Requests > 0 || blocked.Intrusions > 0 {
+		fmt.Fprintf(&b, "\nIP 规则拦截请求 %d 次，入侵检测规则触发 %d 次\n", blocked.Requests, blocked.Intrusions)
+		for _, ip := range blocked.RequestIPs {
+			fmt.Fprintf(&b, "  %-40s 拦截 %d 次\n", ip.IPAddress, ip.Count)
+		}
+		for _, ip := range blocked.IntrusionIPs {
+			fmt.Fprintf(&b, "  %-40s 触发 %d 次\n", ip.IPAddress, ip.Count)
+		}
+	}
+
+	if len(summary.Locks) > 0 {
+		fmt.Fprintf(&b, "\n系统锁定 %d 次：\n", len(summary.Locks))
+		for _, lock := range summary.Locks {
+			fmt.Fprintf(&b, "  %s  %s  %s (%s)\n", lock.Timestamp.Local().Format("15:04:05"), lock.IPAddress, lock.Reason, lock.LockType)
+		}
+	}
+
+	if !summary.Anomalous {
+		b.WriteString("\n未发现异常。\n")
+	}
+	fmt.Fprintf(&b, "\n详情：GET /api/v1/security/access-summaries/%s\n", summary.Date)
+	return b.String()
+}
+
+// GetSubscription returns the digest preference of an administrator.
+func (s *AccessDigestService) GetSubscription(userID int64) (*DigestSubscription, error) {
+	u, err := dao.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	result := &DigestSubscription{Email: u.Email.String}
+	sub, err := dao.GetDigestSubscription(userID)
+	if err != nil {
+		return nil, err
+	}
+	if sub != nil {
+		result.Subscribed, result.OnlyAnomalies = sub.Subscribed, sub.OnlyAnomalies
+		result.UpdatedAt = &sub.UpdatedAt
+	}
+	return result, nil
+}
+
+// UpdateSubscription sets the digest preference of an administrator, who
+// needs an email address to subscribe.
+func (s *AccessDigestService) UpdateSubscription(userID int64, subscribed, onlyAnomalies bool) (*DigestSubscription, error) {
+	u, err := dao.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	if subscribed && u.Email.String == "" {
+		return nil, ErrDigestNoEmail
+	}
+	sub := &dao.DigestSubscription{UserID: userID, Subscribed: subscribed, OnlyAnomalies: onlyAnomalies}
+	if err := dao.SaveDigestSubscription(sub); err != nil {
+		return nil, err
+	}
+	return &DigestSubscription{
+		Subscribed:    sub.Subscribed,
+		OnlyAnomalies: sub.OnlyAnomalies,
+		Email:         u.Email.String,
+		UpdatedAt:     &sub.UpdatedAt,
+	}, nil
+}
+
+func (s *AccessDigestService) logWarn(msg string, fields ...zap.Field) {
+	if s.logger != nil {
+		s.logger.Warn(msg, fields...)
+	}
+}
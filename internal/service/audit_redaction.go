@@ -0,0 +1,168 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 审计导出中 IP 地址和用户名的脱敏方式
+const (
+	RedactTruncate = "truncate" // IPv4 保留 /24，IPv6 保留 /48，用户名保留首字符
+	RedactHash     = "hash"     // 带密钥的哈希，同一个值在各次导出中一致
+)
+
+// ErrInvalidRedaction is returned for an unknown redaction mode.
+var ErrInvalidRedaction = errors.New("invalid redaction mode")
+
+// redactedDetailKeys are the audit details that hold an IP address or a
+// username.
+var redactedDetailKeys = map[string]string{
+	"ip":         "ip",
+	"ip_address": "ip",
+	"client_ip":  "ip",
+	"username":   "user",
+	"user":       "user",
+}
+
+// AuditRedactionConfig holds the redaction policy of audit exports.
+type AuditRedactionConfig struct {
+	After    time.Duration // 记录写入多久后脱敏，0 表示全部脱敏
+	IP       string
+	Username string
+	KeyFile  string // 哈希密钥，不存在时生成
+}
+
+// AuditRedactor anonymizes the IP addresses and usernames of audit records
+// older than the retention period when they are listed or exported. The
+// stored records are not changed, so the hash chain stays verifiable.
+type AuditRedactor struct {
+	config AuditRedactionConfig
+	key    []byte
+}
+
+// NewAuditRedactor creates a new AuditRedactor instance, loading the hash
+// key when a field is hashed.
+func NewAuditRedactor(config AuditRedactionConfig) (*AuditRedactor, error) {
+	for _, mode := range []string{config.IP, config.Username} {
+		if mode != RedactTruncate && mode != RedactHash {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidRedaction, mode)
+		}
+	}
+	r := &AuditRedactor{config: config}
+	if config.IP == RedactHash || config.Username == RedactHash {
+		key, err := loadOrCreateRedactionKey(config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		r.key = key
+	}
+	return r, nil
+}
+
+// Due reports whether a record written at t is redacted.
+func (r *AuditRedactor) Due(t time.Time) bool {
+	return time.Since(t) >= r.config.After
+}
+
+// IP redacts an IP address.
+func (r *AuditRedactor) IP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	if r.config.IP == RedactHash {
+		return r.hash("ip", ip)
+	}
+	return truncateIP(ip)
+}
+
+// Username redacts a username.
+func (r *AuditRedactor) Username(name string) string {
+	if name == "" {
+		return ""
+	}
+	if r.config.Username == RedactHash {
+		return r.hash("user", name)
+	}
+	runes := []rune(name)
+	return string(runes[0]) + "***"
+}
+
+// Details returns a copy of audit details with the IP addresses and
+// usernames redacted.
+func (r *AuditRedactor) Details(details map[string]interface{}) map[string]interface{} {
+	if details == nil {
+		return nil
+	}
+	redacted := make(map[string]interface{}, len(details))
+	for k, v := range details {
+		s, ok := v.(string)
+		switch kind := redactedDetailKeys[k]; {
+		case !ok || kind == "":
+			redacted[k] = v
+		case kind == "ip":
+			redacted[k] = r.IP(s)
+		default:
+			redacted[k] = r.Username(s)
+		}
+	}
+	return redacted
+}
+
+func (r *AuditRedactor) hash(kind, value string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(kind + ":" + value))
+	return "anon-" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// truncateIP zeroes the host part of an IP address: the last octet of IPv4
+// addresses and everything after the /48 prefix of IPv6 addresses. Values
+// that are not IP addresses are removed.
+func truncateIP(ip string) string {
+	parsed := net.ParseIP(strings.TrimSpace(ip))
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
+
+// loadOrCreateRedactionKey reads the hash key, generating a random key
+// when the file does not exist yet.
+func loadOrCreateRedactionKey(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("redaction key file is not set")
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if key := strings.TrimSpace(string(data)); key != "" {
+			return []byte(key), nil
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read redaction key: %w", err)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return nil, fmt.Errorf("failed to generate redaction key: %w", err)
+	}
+	key := hex.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create redaction key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write redaction key: %w", err)
+	}
+	return []byte(key), nil
+}
@@ -3,13 +3,17 @@ package service
 
 import (
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"os"
+	"strings"
 	"sync"
 	"time"
 
+	"cyp-docker-registry/internal/dao"
+	applog "cyp-docker-registry/pkg/logger"
+
 	"go.uber.org/zap"
 )
 
@@ -19,7 +23,8 @@ type AuditService struct {
 	chainHash string
 	mu        sync.Mutex
 	logger    *zap.Logger
-	logFile   *os.File
+	logFile   *applog.RotatingFile
+	listener  func(log *AuditLog)
 }
 
 // AuditConfig holds audit configuration.
@@ -32,6 +37,7 @@ type AuditConfig struct {
 	Retention        time.Duration
 	AlertOnTamper    bool
 	LogFilePath      string
+	LogRotation      applog.Rotation // 日志文件的轮转设置
 }
 
 // AccessAttempt represents an access attempt for audit logging.
@@ -62,6 +68,8 @@ type AuditLog struct {
 	Status         string                 `json:"status"`
 	Details        map[string]interface{} `json:"details,omitempty"`
 	BlockchainHash string                 `json:"blockchain_hash,omitempty"`
+	OrgID          int64                  `json:"org_id,omitempty"` // 所属组织，未设置时按资源名的命名空间推断
+	RequestID      string                 `json:"request_id,omitempty"`
 }
 
 // NewAuditService creates a new AuditService instance.
@@ -83,7 +91,7 @@ func NewAuditService(config *AuditConfig, logger *zap.Logger) (*AuditService, er
 
 	// Open log file if path is specified
 	if config.LogFilePath != "" {
-		file, err := os.OpenFile(config.LogFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		file, err := applog.OpenRotatingFile(config.LogFilePath, config.LogRotation)
 		if err != nil {
 			return nil, err
 		}
@@ -93,11 +101,21 @@ func NewAuditService(config *AuditConfig, logger *zap.Logger) (*AuditService, er
 	return s, nil
 }
 
+// SetListener sets a function called for every audit event, e.g. to stream
+// events to the web console. It must not block.
+func (s *AuditService) SetListener(fn func(log *AuditLog)) {
+	s.listener = fn
+}
+
 // LogAccessAttempt logs an access attempt.
 func (s *AuditService) LogAccessAttempt(attempt *AccessAttempt) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+
 	// Calculate blockchain hash
 	if s.config.BlockchainHash {
 		attempt.BlockchainHash = s.calculateChainHash(attempt)
@@ -107,7 +125,31 @@ func (s *AuditService) LogAccessAttempt(attempt *AccessAttempt) error {
 	// Log to file
 	if s.logFile != nil {
 		data, _ := json.Marshal(attempt)
-		s.logFile.WriteString(string(data) + "\n")
+		s.logFile.Write(append(data, '\n'))
+	}
+
+	// Log to database, evaluated by the intrusion detection rules
+	if dao.GetDB() != nil {
+		row := &dao.AccessAttempt{
+			IPAddress:      attempt.IPAddress,
+			UserAgent:      attempt.UserAgent,
+			Action:         attempt.Action,
+			Resource:       attempt.Resource,
+			Status:         attempt.Status,
+			ErrorMsg:       attempt.ErrorMsg,
+			BlockchainHash: attempt.BlockchainHash,
+			CreatedAt:      attempt.CreatedAt,
+		}
+		if attempt.UserID != 0 {
+			row.UserID = sql.NullInt64{Int64: attempt.UserID, Valid: true}
+		}
+		if err := dao.CreateAccessAttempt(row); err != nil {
+			if s.logger != nil {
+				s.logger.Warn("写入访问记录失败", zap.String("ip", attempt.IPAddress), zap.Error(err))
+			}
+		} else {
+			attempt.ID = row.ID
+		}
 	}
 
 	// Log to logger
@@ -130,6 +172,9 @@ func (s *AuditService) LogAuditEvent(log *AuditLog) error {
 	defer s.mu.Unlock()
 
 	log.Timestamp = time.Now()
+	if log.OrgID == 0 {
+		log.OrgID = resourceOrgID(log.Resource)
+	}
 
 	// Calculate blockchain hash
 	if s.config.BlockchainHash {
@@ -140,7 +185,18 @@ func (s *AuditService) LogAuditEvent(log *AuditLog) error {
 	// Log to file
 	if s.logFile != nil {
 		data, _ := json.Marshal(log)
-		s.logFile.WriteString(string(data) + "\n")
+		s.logFile.Write(append(data, '\n'))
+	}
+
+	// Log to database, read by the audit page and org activity feeds
+	if dao.GetDB() != nil {
+		if err := dao.CreateAuditLog(toDAOAuditLog(log)); err != nil && s.logger != nil {
+			s.logger.Warn("写入审计日志失败", zap.String("event", log.Event), zap.Error(err))
+		}
+	}
+
+	if s.listener != nil {
+		s.listener(log)
 	}
 
 	// Log to logger
@@ -149,6 +205,7 @@ func (s *AuditService) LogAuditEvent(log *AuditLog) error {
 			zap.String("event", log.Event),
 			zap.String("action", log.Action),
 			zap.String("status", log.Status),
+			zap.String("request_id", log.RequestID),
 			zap.String("hash", log.BlockchainHash),
 		)
 	}
@@ -156,8 +213,49 @@ func (s *AuditService) LogAuditEvent(log *AuditLog) error {
 	return nil
 }
 
+// resourceOrgID 根据资源名（如 "acme/app:1.0"）的命名空间查找组织
+func resourceOrgID(resource string) int64 {
+	namespace, _, ok := strings.Cut(resource, "/")
+	if !ok || namespace == "" || dao.GetDB() == nil {
+		return 0
+	}
+	org, err := dao.GetOrganizationByName(namespace)
+	if err != nil || org == nil {
+		return 0
+	}
+	return org.ID
+}
+
+// toDAOAuditLog converts an audit event to its database row.
+func toDAOAuditLog(log *AuditLog) *dao.AuditLog {
+	row := &dao.AuditLog{
+		Timestamp:      log.Timestamp,
+		Level:          log.Level,
+		Event:          log.Event,
+		IPAddress:      log.IPAddress,
+		Resource:       log.Resource,
+		Action:         log.Action,
+		Status:         log.Status,
+		Details:        log.Details,
+		BlockchainHash: log.BlockchainHash,
+	}
+	if log.UserID != 0 {
+		row.UserID = sql.NullInt64{Int64: log.UserID, Valid: true}
+	}
+	if log.Username != "" {
+		row.Username = sql.NullString{String: log.Username, Valid: true}
+	}
+	if log.OrgID != 0 {
+		row.OrgID = sql.NullInt64{Int64: log.OrgID, Valid: true}
+	}
+	if log.RequestID != "" {
+		row.RequestID = sql.NullString{String: log.RequestID, Valid: true}
+	}
+	return row
+}
+
 // LogLockEvent logs a system lock event.
-func (s *AuditService) LogLockEvent(ip, reason, lockType string) error {
+func (s *AuditService) LogLockEvent(ip, reason, lockType, requestID string) error {
 	if !s.config.LogLockEvents {
 		return nil
 	}
@@ -166,6 +264,7 @@ func (s *AuditService) LogLockEvent(ip, reason, lockType string) error {
 		Level:     "critical",
 		Event:     "system_locked",
 		IPAddress: ip,
+		RequestID: requestID,
 		Action:    "lock",
 		Status:    "triggered",
 		Details: map[string]interface{}{
@@ -191,8 +290,30 @@ func (s *AuditService) LogUnlockEvent(ip, username string) error {
 	})
 }
 
+// LogUnlockAttempt logs an attempt to unlock the system. Unlike other lock
+// events these entries are always written, log_lock_events does not apply.
+func (s *AuditService) LogUnlockAttempt(ip, username, status, requestID string, details map[string]interface{}) error {
+	level := "warn"
+	if status == "success" {
+		level = "info"
+	} else if status == "escalated" {
+		level = "critical"
+	}
+
+	return s.LogAuditEvent(&AuditLog{
+		Level:     level,
+		Event:     "unlock_attempt",
+		IPAddress: ip,
+		Username:  username,
+		RequestID: requestID,
+		Action:    "unlock",
+		Status:    status,
+		Details:   details,
+	})
+}
+
 // LogAuthFailure logs an authentication failure.
-func (s *AuditService) LogAuthFailure(ip, username, reason string) error {
+func (s *AuditService) LogAuthFailure(ip, username, reason, requestID string) error {
 	if !s.config.LogFailedAuth {
 		return nil
 	}
@@ -201,6 +322,7 @@ func (s *AuditService) LogAuthFailure(ip, username, reason string) error {
 		Level:     "warn",
 		Event:     "auth_failure",
 		IPAddress: ip,
+		RequestID: requestID,
 		Username:  username,
 		Action:    "login",
 		Status:    "failure",
@@ -212,28 +334,18 @@ func (s *AuditService) LogAuthFailure(ip, username, reason string) error {
 
 // calculateChainHash calculates the blockchain hash for an access attempt.
 func (s *AuditService) calculateChainHash(attempt *AccessAttempt) string {
-	data := fmt.Sprintf("%s|%s|%s|%d|%s",
-		s.chainHash,
-		attempt.IPAddress,
-		attempt.Action,
-		attempt.CreatedAt.Unix(),
-		attempt.Resource,
-	)
-
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+	return chainLink(s.chainHash, attempt.IPAddress, attempt.Action, attempt.CreatedAt, attempt.Resource)
 }
 
 // calculateAuditHash calculates the blockchain hash for an audit log.
 func (s *AuditService) calculateAuditHash(log *AuditLog) string {
-	data := fmt.Sprintf("%s|%s|%s|%d|%s",
-		s.chainHash,
-		log.IPAddress,
-		log.Event,
-		log.Timestamp.Unix(),
-		log.Action,
-	)
+	return chainLink(s.chainHash, log.IPAddress, log.Event, log.Timestamp, log.Action)
+}
 
+// chainLink hashes an entry together with the hash of the entry written
+// before it, the empty string for the first entry after a start.
+func chainLink(prev, ip, kind string, at time.Time, detail string) string {
+	data := fmt.Sprintf("%s|%s|%s|%d|%s", prev, ip, kind, at.Unix(), detail)
 	hash := sha256.Sum256([]byte(data))
 	return hex.EncodeToString(hash[:])
 }
@@ -6,7 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"sync"
+	"fmt"
 	"time"
 
 	"cyp-docker-registry/internal/dao"
@@ -17,8 +17,8 @@ import (
 
 // AuthService provides authentication services.
 type AuthService struct {
-	jwtSecret     []byte
-	sessions      sync.Map // map[int64]*Session
+	jwtKeys       []JWTKey // 第一个用于签名，其余只用于验证
+	sessions      SessionStore
 	tokenExpiry   time.Duration
 	sessionExpiry time.Duration
 }
@@ -88,10 +88,13 @@ type RegisterRequest struct {
 	ClientIP string `json:"client_ip"`
 }
 
-// NewAuthService creates a new AuthService instance.
-func NewAuthService(jwtSecret string) *AuthService {
+// NewAuthService creates a new AuthService instance. Tokens are signed with
+// the first key; the others, e.g. from before a rotation, are still
+// accepted. See LoadJWTKeys.
+func NewAuthService(keys []JWTKey) *AuthService {
 	return &AuthService{
-		jwtSecret:     []byte(jwtSecret),
+		jwtKeys:       keys,
+		sessions:      &localSessionStore{},
 		tokenExpiry:   24 * time.Hour,
 		sessionExpiry: 24 * time.Hour,
 	}
@@ -99,31 +102,9 @@ func NewAuthService(jwtSecret string) *AuthService {
 
 // Login authenticates a user and returns a JWT token.
 func (s *AuthService) Login(req *LoginRequest) (*LoginResponse, error) {
-	// Look up user from database
-	daoUser, err := dao.GetUserByUsername(req.Username)
+	user, err := s.VerifyPassword(req.Username, req.Password)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
-	}
-	if daoUser == nil {
-		return nil, errors.New("invalid credentials")
-	}
-
-	// Verify password
-	if err := bcrypt.CompareHashAndPassword([]byte(daoUser.PasswordHash), []byte(req.Password)); err != nil {
-		return nil, errors.New("invalid credentials")
-	}
-
-	// Check if user is active
-	if !daoUser.IsActive {
-		return nil, errors.New("user is inactive")
-	}
-
-	user := &User{
-		ID:       daoUser.ID,
-		Username: daoUser.Username,
-		Email:    daoUser.Email.String,
-		Role:     daoUser.Role,
-		IsActive: daoUser.IsActive,
+		return nil, err
 	}
 
 	// Generate JWT token
@@ -138,8 +119,11 @@ func (s *AuthService) Login(req *LoginRequest) (*LoginResponse, error) {
 	// Update last login time
 	dao.UpdateUserLastLogin(user.ID)
 
-	// Check if password needs to be changed (default password)
+	// Check if password needs to be changed (default password or reset by an administrator)
 	mustChangePassword := req.Password == "admin123"
+	if daoUser, err := dao.GetUserByID(user.ID); err == nil && daoUser != nil && daoUser.MustChangePassword {
+		mustChangePassword = true
+	}
 
 	return &LoginResponse{
 		User:               user,
@@ -150,11 +134,41 @@ func (s *AuthService) Login(req *LoginRequest) (*LoginResponse, error) {
 	}, nil
 }
 
+// VerifyPassword checks a username and password without creating a
+// session. It is used by Login and by registry basic auth.
+func (s *AuthService) VerifyPassword(username, password string) (*User, error) {
+	// Look up user from database
+	daoUser, err := dao.GetUserByUsername(username)
+	if err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	if daoUser == nil {
+		return nil, errors.New("invalid credentials")
+	}
+
+	// Verify password
+	if err := bcrypt.CompareHashAndPassword([]byte(daoUser.PasswordHash), []byte(password)); err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+
+	// Check if user is active
+	if !daoUser.IsActive {
+		return nil, errors.New("user is inactive")
+	}
+
+	return &User{
+		ID:       daoUser.ID,
+		Username: daoUser.Username,
+		Email:    daoUser.Email.String,
+		Role:     daoUser.Role,
+		IsActive: daoUser.IsActive,
+	}, nil
+}
+
 // ValidateJWT validates a JWT token and returns user info.
 func (s *AuthService) ValidateJWT(tokenStr string) (*User, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return s.jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, &JWTClaims{}, s.jwtKeyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 
 	if err != nil {
 		return nil, err
@@ -170,12 +184,27 @@ func (s *AuthService) ValidateJWT(tokenStr string) (*User, error) {
 		return nil, errors.New("token expired")
 	}
 
-	return &User{
+	user := &User{
 		ID:       claims.UserID,
 		Username: claims.Username,
 		Role:     claims.Role,
 		IsActive: true,
-	}, nil
+	}
+
+	// 角色和启用状态以数据库为准，管理员的修改对已签发的令牌立即生效
+	if dao.GetDB() != nil {
+		daoUser, err := dao.GetUserByID(claims.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if daoUser == nil {
+			return nil, errors.New("user not found")
+		}
+		user.Role = daoUser.Role
+		user.IsActive = daoUser.IsActive
+	}
+
+	return user, nil
 }
 
 // ValidateToken validates a personal access token.
@@ -184,18 +213,24 @@ func (s *AuthService) ValidateToken(tokenStr string) (*User, *PersonalAccessToke
 	return nil, nil, errors.New("token validation not implemented")
 }
 
+// SetSessionStore replaces the in-memory session store, e.g. with one
+// shared by all instances. It must be called before serving requests.
+func (s *AuthService) SetSessionStore(store SessionStore) {
+	s.sessions = store
+}
+
 // GetSession returns a user's session.
 func (s *AuthService) GetSession(userID int64) *Session {
-	if session, ok := s.sessions.Load(userID); ok {
-		return session.(*Session)
+	session, err := s.sessions.Get(userID)
+	if err != nil {
+		return nil
 	}
-	return nil
+	return session
 }
 
 // TerminateSession terminates a user's session.
 func (s *AuthService) TerminateSession(userID int64) error {
-	s.sessions.Delete(userID)
-	return nil
+	return s.sessions.Delete(userID)
 }
 
 // UpdateTokenLastUsed updates the last used time of a token.
@@ -217,8 +252,30 @@ func (s *AuthService) generateJWT(user *User) (string, error) {
 		},
 	}
 
+	if len(s.jwtKeys) == 0 {
+		return "", ErrNoJWTSecret
+	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(s.jwtSecret)
+	token.Header["kid"] = s.jwtKeys[0].ID
+	return token.SignedString(s.jwtKeys[0].Secret)
+}
+
+// jwtKeyFunc selects the verification key by the kid header. Tokens
+// without a kid are checked against the signing key.
+func (s *AuthService) jwtKeyFunc(token *jwt.Token) (interface{}, error) {
+	if len(s.jwtKeys) == 0 {
+		return nil, ErrNoJWTSecret
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return s.jwtKeys[0].Secret, nil
+	}
+	for _, key := range s.jwtKeys {
+		if key.ID == kid {
+			return key.Secret, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown jwt key id %q", kid)
 }
 
 // createSession creates a new session for a user.
@@ -233,7 +290,8 @@ func (s *AuthService) createSession(userID int64, ip, userAgent string) *Session
 		ExpiresAt: time.Now().Add(s.sessionExpiry),
 	}
 
-	s.sessions.Store(userID, session)
+	// 共享存储写入失败时会话仍保存在本实例
+	s.sessions.Put(session)
 	return session
 }
 
@@ -269,6 +327,10 @@ func (s *AuthService) Register(req *RegisterRequest) (*User, error) {
 	if existingUser != nil {
 		return nil, errors.New("用户名已存在")
 	}
+	// 组织名与用户名共用仓库命名空间，已删除账户的名称仍被保留
+	if ns, _ := dao.GetNamespace(req.Username); ns != nil {
+		return nil, errors.New("用户名已被占用")
+	}
 
 	// Hash password
 	passwordHash, err := HashPassword(req.Password)
@@ -303,6 +365,10 @@ func (s *AuthService) RegisterWithToken(req *RegisterRequest) (*User, string, er
 	if existingUser != nil {
 		return nil, "", errors.New("用户名已存在")
 	}
+	// 组织名与用户名共用仓库命名空间，已删除账户的名称仍被保留
+	if ns, _ := dao.GetNamespace(req.Username); ns != nil {
+		return nil, "", errors.New("用户名已被占用")
+	}
 
 	// Hash password
 	passwordHash, err := HashPassword(req.Password)
@@ -3,6 +3,7 @@ package service
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,6 +19,47 @@ type AutomationEngine struct {
 	mu        sync.RWMutex
 	isRunning bool
 	stopCh    chan struct{}
+
+	backupService *BackupService
+	syncRunner    SyncRuleRunner
+	trashPurger   TrashPurger
+	sweeper       *ExpirySweeper
+	scrubber      BlobScrubber
+	pinRefresher  PinRefresher
+	vulnRefresher VulnDBRefresher
+	digestSender  DigestSender
+	isLeader      func() bool
+}
+
+// SyncRuleRunner runs scheduled sync rules that are due.
+type SyncRuleRunner interface {
+	RunDueSyncRules(ctx context.Context) error
+}
+
+// TrashPurger permanently deletes expired entries of the image recycle bin.
+type TrashPurger interface {
+	PurgeExpiredTrash() (int, error)
+}
+
+// BlobScrubber verifies a share of the stored blobs on each run.
+type BlobScrubber interface {
+	ScrubBlobs(ctx context.Context) error
+}
+
+// PinRefresher refreshes the images pinned in the accelerator cache.
+type PinRefresher interface {
+	RefreshPins(ctx context.Context) error
+}
+
+// VulnDBRefresher updates the vulnerability database and re-scans the
+// images it affects.
+type VulnDBRefresher interface {
+	RefreshVulnDB(ctx context.Context) error
+}
+
+// DigestSender sends the periodic digests that are due.
+type DigestSender interface {
+	SendDueDigest(ctx context.Context) error
 }
 
 // ScheduledTask represents a scheduled automation task.
@@ -77,6 +119,137 @@ func NewAutomationEngine(config *AutomationConfig, logger *zap.Logger) *Automati
 	}
 }
 
+// SetBackupService sets the backup service used by backup tasks.
+func (e *AutomationEngine) SetBackupService(svc *BackupService) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.backupService = svc
+}
+
+// SetTrashPurger sets the recycle bin purged by the cleanup task.
+func (e *AutomationEngine) SetTrashPurger(purger TrashPurger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.trashPurger = purger
+}
+
+// SetLeaderCheck makes scheduled tasks run only while isLeader returns
+// true, so a task runs on one instance of a cluster. Tasks started through
+// RunTask are not affected.
+func (e *AutomationEngine) SetLeaderCheck(isLeader func() bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.isLeader = isLeader
+}
+
+// SetSyncRuleRunner sets the runner used by the sync task and registers
+// the task, which checks for due sync rules every minute.
+func (e *AutomationEngine) SetSyncRuleRunner(runner SyncRuleRunner) {
+	e.mu.Lock()
+	e.syncRunner = runner
+	e.mu.Unlock()
+
+	e.RegisterTask(&ScheduledTask{
+		ID:          "sync-rules",
+		Name:        "Scheduled Sync",
+		Description: "Push images matching sync rules to remote registries",
+		Schedule:    "@every 1m",
+		Enabled:     true,
+		TaskType:    "sync",
+		Config:      map[string]interface{}{},
+	})
+}
+
+// SetExpirySweeper sets the sweeper and registers the task that runs it
+// every interval.
+func (e *AutomationEngine) SetExpirySweeper(sweeper *ExpirySweeper, interval time.Duration) {
+	e.mu.Lock()
+	e.sweeper = sweeper
+	e.mu.Unlock()
+
+	e.RegisterTask(&ScheduledTask{
+		ID:          "sweep-expired",
+		Name:        "Expiry Sweep",
+		Description: "Remove expired sessions, tokens, share links and interrupted uploads",
+		Schedule:    "@every " + interval.String(),
+		Enabled:     true,
+		TaskType:    "sweep",
+		Config:      map[string]interface{}{},
+	})
+}
+
+// SetBlobScrubber sets the scrubber and registers the task that runs it
+// every interval.
+func (e *AutomationEngine) SetBlobScrubber(scrubber BlobScrubber, interval time.Duration) {
+	e.mu.Lock()
+	e.scrubber = scrubber
+	e.mu.Unlock()
+
+	e.RegisterTask(&ScheduledTask{
+		ID:          "scrub-blobs",
+		Name:        "Integrity Scrub",
+		Description: "Verify stored blobs against their digests and repair corrupted ones",
+		Schedule:    "@every " + interval.String(),
+		Enabled:     true,
+		TaskType:    "scrub",
+		Config:      map[string]interface{}{},
+	})
+}
+
+// SetPinRefresher sets the refresher of pinned images and registers the
+// task that runs it every interval.
+func (e *AutomationEngine) SetPinRefresher(refresher PinRefresher, interval time.Duration) {
+	e.mu.Lock()
+	e.pinRefresher = refresher
+	e.mu.Unlock()
+
+	e.RegisterTask(&ScheduledTask{
+		ID:          "refresh-pins",
+		Name:        "Cache Pin Refresh",
+		Description: "Fetch the current layers of images pinned in the accelerator cache",
+		Schedule:    "@every " + interval.String(),
+		Enabled:     true,
+		TaskType:    "prewarm",
+		Config:      map[string]interface{}{},
+	})
+}
+
+// SetVulnDBRefresher sets the refresher of the vulnerability database and
+// registers the task that checks for updates every interval.
+func (e *AutomationEngine) SetVulnDBRefresher(refresher VulnDBRefresher, interval time.Duration) {
+	e.mu.Lock()
+	e.vulnRefresher = refresher
+	e.mu.Unlock()
+
+	e.RegisterTask(&ScheduledTask{
+		ID:          "refresh-vulndb",
+		Name:        "Vulnerability Re-scan",
+		Description: "Update the vulnerability database and re-scan images with affected packages",
+		Schedule:    "@every " + interval.String(),
+		Enabled:     true,
+		TaskType:    "rescan",
+		Config:      map[string]interface{}{},
+	})
+}
+
+// SetDigestSender sets the sender of the daily access digest and registers
+// the task that checks every 10 minutes whether it is due.
+func (e *AutomationEngine) SetDigestSender(sender DigestSender) {
+	e.mu.Lock()
+	e.digestSender = sender
+	e.mu.Unlock()
+
+	e.RegisterTask(&ScheduledTask{
+		ID:          "access-digest",
+		Name:        "Access Digest",
+		Description: "Send the daily summary of access attempts to subscribed administrators",
+		Schedule:    "@every 10m",
+		Enabled:     true,
+		TaskType:    "digest",
+		Config:      map[string]interface{}{},
+	})
+}
+
 // Start starts the automation engine.
 func (e *AutomationEngine) Start() error {
 	if !e.config.Enabled {
@@ -255,11 +428,19 @@ func (e *AutomationEngine) scheduler() {
 
 // checkAndRunTasks checks for tasks that need to run.
 func (e *AutomationEngine) checkAndRunTasks() {
+	if e.skipOnFollower() {
+		return
+	}
+
 	e.mu.RLock()
 	tasks := make([]*ScheduledTask, 0)
 	now := time.Now()
 
 	for _, task := range e.tasks {
+		// 上一次执行尚未结束时不重复启动
+		if _, running := e.running[task.ID]; running {
+			continue
+		}
 		if task.Enabled && !task.NextRun.IsZero() && now.After(task.NextRun) {
 			tasks = append(tasks, task)
 		}
@@ -273,6 +454,25 @@ func (e *AutomationEngine) checkAndRunTasks() {
 	}
 }
 
+// skipOnFollower reports whether this instance is not the leader. Due tasks
+// are then rescheduled as if they had run, since the leader runs them, so
+// an instance taking over does not repeat the runs at once.
+func (e *AutomationEngine) skipOnFollower() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.isLeader == nil || e.isLeader() {
+		return false
+	}
+	now := time.Now()
+	for _, task := range e.tasks {
+		if task.Enabled && !task.NextRun.IsZero() && now.After(task.NextRun) {
+			task.NextRun = e.calculateNextRun(task.Schedule)
+		}
+	}
+	return true
+}
+
 // executeTask executes a single task.
 func (e *AutomationEngine) executeTask(task *ScheduledTask) (*TaskResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
@@ -310,6 +510,16 @@ func (e *AutomationEngine) executeTask(task *ScheduledTask) (*TaskResult, error)
 		err = e.runSignTask(ctx, task)
 	case "sbom":
 		err = e.runSBOMTask(ctx, task)
+	case "sweep":
+		err = e.runSweepTask(ctx, task)
+	case "scrub":
+		err = e.runScrubTask(ctx, task)
+	case "prewarm":
+		err = e.runPrewarmTask(ctx, task)
+	case "rescan":
+		err = e.runRescanTask(ctx, task)
+	case "digest":
+		err = e.runDigestTask(ctx, task)
 	default:
 		err = ErrUnknownTaskType
 	}
@@ -395,13 +605,36 @@ func (e *AutomationEngine) registerDefaultTasks() {
 			"format": "spdx-json",
 		},
 	})
+
+	// Data backup task
+	if e.backupService != nil {
+		e.RegisterTask(&ScheduledTask{
+			ID:          "backup-data",
+			Name:        "Data Backup",
+			Description: "Back up database, metadata, keys and configs",
+			Schedule:    "0 1 * * *", // Daily at 1 AM
+			Enabled:     true,
+			TaskType:    "backup",
+			Config: map[string]interface{}{
+				"include_blobs": e.backupService.config.IncludeBlobs,
+			},
+		})
+	}
 }
 
 // calculateNextRun calculates the next run time based on cron expression.
-func (e *AutomationEngine) calculateNextRun(_ string) time.Time {
+// "@every <duration>" schedules run at a fixed interval.
+func (e *AutomationEngine) calculateNextRun(schedule string) time.Time {
+	now := time.Now()
+
+	if strings.HasPrefix(schedule, "@every ") {
+		if d, err := time.ParseDuration(strings.TrimPrefix(schedule, "@every ")); err == nil && d > 0 {
+			return now.Add(d)
+		}
+	}
+
 	// Simplified cron parsing - in production use a proper cron library
 	// Format: minute hour day month weekday
-	now := time.Now()
 
 	// Default to next day at the same time
 	return now.Add(24 * time.Hour)
@@ -413,15 +646,106 @@ func (e *AutomationEngine) runCleanupTask(_ context.Context, task *ScheduledTask
 	if e.logger != nil {
 		e.logger.Info("Running cleanup task", zap.String("task_id", task.ID))
 	}
-	return nil
+
+	e.mu.RLock()
+	purger := e.trashPurger
+	e.mu.RUnlock()
+	if purger == nil {
+		return nil
+	}
+
+	// 永久删除回收站中已过期的镜像
+	purged, err := purger.PurgeExpiredTrash()
+	if purged > 0 && e.logger != nil {
+		e.logger.Info("Purged expired trash", zap.Int("count", purged))
+	}
+	return err
 }
 
-func (e *AutomationEngine) runSyncTask(_ context.Context, task *ScheduledTask) error {
-	// Implementation for sync task
+func (e *AutomationEngine) runSyncTask(ctx context.Context, task *ScheduledTask) error {
+	e.mu.RLock()
+	runner := e.syncRunner
+	e.mu.RUnlock()
+	if runner == nil {
+		return ErrServiceUnavailable
+	}
+
+	// 每分钟执行一次，只在调试级别记录
 	if e.logger != nil {
-		e.logger.Info("Running sync task", zap.String("task_id", task.ID))
+		e.logger.Debug("Running sync task", zap.String("task_id", task.ID))
 	}
-	return nil
+	return runner.RunDueSyncRules(ctx)
+}
+
+func (e *AutomationEngine) runSweepTask(_ context.Context, task *ScheduledTask) error {
+	e.mu.RLock()
+	sweeper := e.sweeper
+	e.mu.RUnlock()
+	if sweeper == nil {
+		return ErrServiceUnavailable
+	}
+
+	if e.logger != nil {
+		e.logger.Debug("Running sweep task", zap.String("task_id", task.ID))
+	}
+	_, err := sweeper.Sweep()
+	return err
+}
+
+func (e *AutomationEngine) runScrubTask(ctx context.Context, task *ScheduledTask) error {
+	e.mu.RLock()
+	scrubber := e.scrubber
+	e.mu.RUnlock()
+	if scrubber == nil {
+		return ErrServiceUnavailable
+	}
+
+	if e.logger != nil {
+		e.logger.Info("Running scrub task", zap.String("task_id", task.ID))
+	}
+	return scrubber.ScrubBlobs(ctx)
+}
+
+func (e *AutomationEngine) runPrewarmTask(ctx context.Context, task *ScheduledTask) error {
+	e.mu.RLock()
+	refresher := e.pinRefresher
+	e.mu.RUnlock()
+	if refresher == nil {
+		return ErrServiceUnavailable
+	}
+
+	if e.logger != nil {
+		e.logger.Info("Running prewarm task", zap.String("task_id", task.ID))
+	}
+	return refresher.RefreshPins(ctx)
+}
+
+func (e *AutomationEngine) runRescanTask(ctx context.Context, task *ScheduledTask) error {
+	e.mu.RLock()
+	refresher := e.vulnRefresher
+	e.mu.RUnlock()
+	if refresher == nil {
+		return ErrServiceUnavailable
+	}
+
+	if e.logger != nil {
+		e.logger.Debug("Running rescan task", zap.String("task_id", task.ID))
+	}
+	return refresher.RefreshVulnDB(ctx)
+}
+
+func (e *AutomationEngine) runDigestTask(ctx context.Context, task *ScheduledTask) error {
+	e.mu.RLock()
+	sender := e.digestSender
+	e.mu.RUnlock()
+	if sender == nil {
+		return ErrServiceUnavailable
+	}
+
+	if e.logger != nil {
+		e.logger.Debug("Running digest task", zap.String("task_id", task.ID))
+	}
+	return sender.SendDueDigest(ctx)
 }
 
 func (e *AutomationEngine) runScanTask(_ context.Context, task *ScheduledTask) error {
@@ -432,12 +756,21 @@ func (e *AutomationEngine) runScanTask(_ context.Context, task *ScheduledTask) e
 	return nil
 }
 
-func (e *AutomationEngine) runBackupTask(_ context.Context, task *ScheduledTask) error {
-	// Implementation for backup task
+func (e *AutomationEngine) runBackupTask(ctx context.Context, task *ScheduledTask) error {
 	if e.logger != nil {
 		e.logger.Info("Running backup task", zap.String("task_id", task.ID))
 	}
-	return nil
+
+	e.mu.RLock()
+	svc := e.backupService
+	e.mu.RUnlock()
+	if svc == nil {
+		return ErrServiceUnavailable
+	}
+
+	includeBlobs, _ := task.Config["include_blobs"].(bool)
+	_, err := svc.CreateBackup(ctx, includeBlobs)
+	return err
 }
 
 func (e *AutomationEngine) runSignTask(_ context.Context, task *ScheduledTask) error {
@@ -458,8 +791,9 @@ func (e *AutomationEngine) runSBOMTask(_ context.Context, task *ScheduledTask) e
 
 // Error definitions
 var (
-	ErrTaskNotFound    = &TaskError{Message: "task not found"}
-	ErrUnknownTaskType = &TaskError{Message: "unknown task type"}
+	ErrTaskNotFound       = &TaskError{Message: "task not found"}
+	ErrUnknownTaskType    = &TaskError{Message: "unknown task type"}
+	ErrServiceUnavailable = &TaskError{Message: "service not configured"}
 )
 
 // TaskError represents a task-related error.
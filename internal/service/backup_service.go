@@ -0,0 +1,995 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"github.com/klauspost/compress/zstd"
+	"go.uber.org/zap"
+)
+
+const (
+	backupExt          = ".tar.zst"
+	backupChecksumExt  = ".sha256"
+	backupManifestName = "manifest.json"
+	backupFormatVer    = 1
+)
+
+// BackupService provides full data snapshot and restore services.
+type BackupService struct {
+	config   *BackupConfig
+	logger   *zap.Logger
+	targets  []configuredTarget
+	progress BackupProgressFunc
+	mu       sync.Mutex // 同一时间只允许一个备份或恢复操作
+}
+
+// configuredTarget pairs a backup target with its retention setting.
+type configuredTarget struct {
+	BackupTarget
+	retention int
+}
+
+// BackupProgressFunc receives backup progress events.
+type BackupProgressFunc func(event string, data map[string]interface{})
+
+// BackupConfig holds backup configuration.
+type BackupConfig struct {
+	OutputPath   string   // 备份文件输出目录
+	DBPath       string   // SQLite 数据库文件
+	MetaPath     string   // 镜像元数据目录
+	BlobPath     string   // Blob 存储目录
+	KeyPaths     []string // TUF 密钥、签名等目录
+	ConfigPaths  []string // 配置文件或目录
+	ExcludePaths []string // 不写入备份的密钥文件，如凭证主密钥、JWT 密钥，备份会上传到远端且不加密
+	IncludeBlobs bool     // 默认是否包含 Blob
+	Retention    int      // 本地保留的备份数量，0 表示不限制
+	Targets      []BackupTargetConfig
+}
+
+// BackupInfo represents a backup archive on disk.
+type BackupInfo struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Checksum     string    `json:"checksum"`
+	CreatedAt    time.Time `json:"created_at"`
+	FileCount    int       `json:"file_count,omitempty"`
+	IncludeBlobs bool      `json:"include_blobs"`
+
+	Targets []BackupTargetResult `json:"targets,omitempty"`
+}
+
+// BackupManifest describes the contents of a backup archive.
+type BackupManifest struct {
+	Version      int               `json:"version"`
+	ID           string            `json:"id"`
+	CreatedAt    time.Time         `json:"created_at"`
+	IncludeBlobs bool              `json:"include_blobs"`
+	Files        []BackupFileEntry `json:"files"`
+}
+
+// BackupFileEntry records a single file stored in a backup archive.
+type BackupFileEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// backupSource maps an archive prefix to a path on disk.
+type backupSource struct {
+	prefix string
+	path   string
+}
+
+// NewBackupService creates a new BackupService instance.
+func NewBackupService(config *BackupConfig, logger *zap.Logger) *BackupService {
+	if config == nil {
+		config = &BackupConfig{
+			OutputPath: "./data/backups",
+			DBPath:     "./data/registry.db",
+			MetaPath:   "./data/meta",
+			BlobPath:   "./data/blobs",
+		}
+	}
+
+	if config.OutputPath != "" {
+		os.MkdirAll(config.OutputPath, 0700)
+	}
+
+	s := &BackupService{
+		config: config,
+		logger: logger,
+	}
+
+	for _, tc := range config.Targets {
+		target, err := NewBackupTarget(tc)
+		if err != nil {
+			if logger != nil {
+				logger.Warn("Invalid backup target", zap.String("name", tc.Name), zap.Error(err))
+			}
+			continue
+		}
+		retention := tc.Retention
+		if retention <= 0 {
+			retention = config.Retention
+		}
+		s.targets = append(s.targets, configuredTarget{BackupTarget: target, retention: retention})
+	}
+
+	return s
+}
+
+// SetProgressNotifier sets the callback that receives backup progress events.
+func (s *BackupService) SetProgressNotifier(fn BackupProgressFunc) {
+	s.progress = fn
+}
+
+// Targets returns the names of configured remote targets.
+func (s *BackupService) Targets() []string {
+	names := make([]string, 0, len(s.targets))
+	for _, t := range s.targets {
+		names = append(names, t.Name())
+	}
+	return names
+}
+
+// CreateBackup creates a timestamped tar.zst snapshot of all registry data.
+func (s *BackupService) CreateBackup(ctx context.Context, includeBlobs bool) (*BackupInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.config.OutputPath, 0700); err != nil {
+		return nil, fmt.Errorf("创建备份目录失败: %w", err)
+	}
+
+	id := "backup-" + time.Now().Format("20060102-150405")
+	archivePath := s.archivePath(id)
+	if _, err := os.Stat(archivePath); err == nil {
+		return nil, errors.New("backup already exists: " + id)
+	}
+
+	// 使用 VACUUM INTO 生成一致的数据库快照
+	dbSnapshot := filepath.Join(s.config.OutputPath, "."+id+".db")
+	if err := dao.BackupDatabase(dbSnapshot); err != nil {
+		return nil, fmt.Errorf("数据库快照失败: %w", err)
+	}
+	defer os.Remove(dbSnapshot)
+
+	tmpPath := archivePath + ".tmp"
+	progress := s.phaseProgress(id, "archive")
+	progress.total = s.sourcesSize(dbSnapshot, includeBlobs)
+	manifest, err := s.writeArchive(ctx, tmpPath, id, dbSnapshot, includeBlobs, progress)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	checksum, size, err := fileSHA256(tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.WriteFile(archivePath+backupChecksumExt, []byte(checksum+"  "+filepath.Base(archivePath)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		ID:           id,
+		Path:         archivePath,
+		Size:         size,
+		Checksum:     checksum,
+		CreatedAt:    manifest.CreatedAt,
+		FileCount:    len(manifest.Files),
+		IncludeBlobs: includeBlobs,
+	}
+
+	if s.logger != nil {
+		s.logger.Info("Backup created",
+			zap.String("id", id),
+			zap.Int64("size", size),
+			zap.Int("files", info.FileCount),
+			zap.Bool("include_blobs", includeBlobs),
+		)
+	}
+	s.notify("backup_created", map[string]interface{}{"id": id, "size": size})
+
+	// 复制到远程目标
+	for _, target := range s.targets {
+		info.Targets = append(info.Targets, s.replicate(ctx, target, info, target.retention))
+	}
+
+	// 本地保留策略
+	if names, err := s.localNames(); err == nil {
+		for _, old := range pruneBackups(names, s.config.Retention) {
+			os.Remove(s.archivePath(old))
+			os.Remove(s.archivePath(old) + backupChecksumExt)
+		}
+	}
+
+	return info, nil
+}
+
+// replicate uploads a backup to target, verifies it by downloading it
+// again and applies the target's retention policy.
+func (s *BackupService) replicate(ctx context.Context, target BackupTarget, info *BackupInfo, retention int) BackupTargetResult {
+	result := BackupTargetResult{Target: target.Name(), Status: "failed"}
+	archiveName := filepath.Base(info.Path)
+
+	fail := func(err error) BackupTargetResult {
+		result.Error = err.Error()
+		if s.logger != nil {
+			s.logger.Error("Backup replication failed",
+				zap.String("id", info.ID),
+				zap.String("target", target.Name()),
+				zap.Error(err),
+			)
+		}
+		s.notify("backup_upload_failed", map[string]interface{}{
+			"id":     info.ID,
+			"target": target.Name(),
+			"error":  err.Error(),
+		})
+		return result
+	}
+
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return fail(err)
+	}
+	reader := &progressReader{
+		r:     file,
+		total: info.Size,
+		report: func(done, total int64) {
+			s.notify("backup_upload_progress", map[string]interface{}{
+				"id":     info.ID,
+				"target": target.Name(),
+				"bytes":  done,
+				"total":  total,
+			})
+		},
+	}
+	err = target.Upload(ctx, archiveName, reader, info.Size)
+	file.Close()
+	if err != nil {
+		return fail(fmt.Errorf("上传失败: %w", err))
+	}
+
+	sidecar := []byte(info.Checksum + "  " + archiveName + "\n")
+	if err := target.Upload(ctx, archiveName+backupChecksumExt, strings.NewReader(string(sidecar)), int64(len(sidecar))); err != nil {
+		return fail(fmt.Errorf("上传校验文件失败: %w", err))
+	}
+
+	// 重新下载并校验哈希
+	hash := sha256.New()
+	if err := target.Download(ctx, archiveName, hash); err != nil {
+		return fail(fmt.Errorf("校验下载失败: %w", err))
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != info.Checksum {
+		target.Delete(ctx, archiveName)
+		target.Delete(ctx, archiveName+backupChecksumExt)
+		return fail(fmt.Errorf("remote checksum mismatch: expected %s, got %s", info.Checksum, actual))
+	}
+	result.Verified = true
+	result.Status = "success"
+
+	if retention > 0 {
+		if names, err := target.List(ctx); err == nil {
+			for _, old := range pruneBackups(names, retention) {
+				if err := target.Delete(ctx, old+backupExt); err == nil {
+					target.Delete(ctx, old+backupExt+backupChecksumExt)
+					result.Pruned++
+				}
+			}
+		}
+	}
+
+	s.notify("backup_uploaded", map[string]interface{}{
+		"id":       info.ID,
+		"target":   target.Name(),
+		"verified": true,
+	})
+
+	return result
+}
+
+// localNames lists file names in the local backup directory.
+func (s *BackupService) localNames() ([]string, error) {
+	entries, err := os.ReadDir(s.config.OutputPath)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names, nil
+}
+
+// notify sends a progress event if a notifier is configured.
+func (s *BackupService) notify(event string, data map[string]interface{}) {
+	if s.progress != nil {
+		s.progress(event, data)
+	}
+}
+
+// ListBackups lists backup archives, newest first.
+func (s *BackupService) ListBackups() ([]*BackupInfo, error) {
+	entries, err := os.ReadDir(s.config.OutputPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*BackupInfo{}, nil
+		}
+		return nil, err
+	}
+
+	backups := make([]*BackupInfo, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, backupExt) {
+			continue
+		}
+		info, err := s.GetBackup(strings.TrimSuffix(name, backupExt))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, info)
+	}
+
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+
+	return backups, nil
+}
+
+// GetBackup returns information about a single backup.
+func (s *BackupService) GetBackup(id string) (*BackupInfo, error) {
+	if !isValidBackupID(id) {
+		return nil, errors.New("invalid backup id")
+	}
+
+	path := s.archivePath(id)
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.New("backup not found")
+		}
+		return nil, err
+	}
+
+	info := &BackupInfo{
+		ID:        id,
+		Path:      path,
+		Size:      stat.Size(),
+		CreatedAt: stat.ModTime(),
+	}
+	info.Checksum, _ = readChecksumFile(path + backupChecksumExt)
+
+	return info, nil
+}
+
+// DeleteBackup removes a backup archive and its checksum file.
+func (s *BackupService) DeleteBackup(id string) error {
+	info, err := s.GetBackup(id)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(info.Path); err != nil {
+		return err
+	}
+	os.Remove(info.Path + backupChecksumExt)
+
+	return nil
+}
+
+// VerifyBackup verifies the archive checksum and every file checksum
+// recorded in the backup manifest.
+func (s *BackupService) VerifyBackup(id string) (*BackupManifest, error) {
+	info, err := s.GetBackup(id)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyBackupArchive(info.Path)
+}
+
+// RestoreBackup verifies a backup and then replaces the current data with it.
+func (s *BackupService) RestoreBackup(ctx context.Context, id string) (*BackupManifest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.GetBackup(id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 恢复前必须先校验整个归档
+	manifest, err := verifyBackupArchive(info.Path, s.phaseProgress(id, "verify"))
+	if err != nil {
+		return nil, fmt.Errorf("备份校验失败: %w", err)
+	}
+
+	stagingDir := filepath.Join(s.config.OutputPath, ".restore-"+id)
+	os.RemoveAll(stagingDir)
+	if err := os.MkdirAll(stagingDir, 0700); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(stagingDir)
+
+	extract := s.phaseProgress(id, "extract")
+	extract.total = info.Size
+	if err := extractBackupArchive(ctx, info.Path, stagingDir, extract); err != nil {
+		return nil, fmt.Errorf("解压备份失败: %w", err)
+	}
+
+	// 数据库
+	dbSnapshot := filepath.Join(stagingDir, "database", "registry.db")
+	if _, err := os.Stat(dbSnapshot); err == nil {
+		if err := dao.RestoreDatabase(dbSnapshot); err != nil {
+			return nil, fmt.Errorf("恢复数据库失败: %w", err)
+		}
+	}
+
+	// 其他目录与文件
+	for _, src := range s.sources(manifest.IncludeBlobs) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		staged := filepath.Join(stagingDir, filepath.FromSlash(src.prefix))
+		if _, err := os.Stat(staged); err != nil {
+			continue
+		}
+		if err := s.keepExcluded(src.path, staged); err != nil {
+			return nil, fmt.Errorf("保留 %s 中的密钥失败: %w", src.prefix, err)
+		}
+		if err := replacePath(staged, src.path); err != nil {
+			return nil, fmt.Errorf("恢复 %s 失败: %w", src.prefix, err)
+		}
+	}
+
+	if s.logger != nil {
+		s.logger.Info("Backup restored",
+			zap.String("id", id),
+			zap.Int("files", len(manifest.Files)),
+		)
+	}
+	s.notify("backup_restored", map[string]interface{}{"id": id, "files": len(manifest.Files)})
+
+	return manifest, nil
+}
+
+// phaseProgress returns a progress counter publishing backup_progress
+// events for one phase of a backup or restore.
+func (s *BackupService) phaseProgress(id, phase string) *byteProgress {
+	return &byteProgress{report: func(done, total int64) {
+		s.notify("backup_progress", map[string]interface{}{
+			"id":    id,
+			"phase": phase,
+			"bytes": done,
+			"total": total,
+		})
+	}}
+}
+
+// sourcesSize returns the bytes writeArchive will read, used as the
+// progress total.
+func (s *BackupService) sourcesSize(dbSnapshot string, includeBlobs bool) int64 {
+	var size int64
+	if fi, err := os.Stat(dbSnapshot); err == nil {
+		size += fi.Size()
+	}
+	for _, src := range s.sources(includeBlobs) {
+		filepath.Walk(src.path, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() && !strings.HasSuffix(fi.Name(), ".tmp") && !s.excluded(path) {
+				size += fi.Size()
+			}
+			return nil
+		})
+	}
+	return size
+}
+
+// archivePath returns the path of a backup archive.
+func (s *BackupService) archivePath(id string) string {
+	return filepath.Join(s.config.OutputPath, id+backupExt)
+}
+
+// sources returns the directories and files included in a backup.
+func (s *BackupService) sources(includeBlobs bool) []backupSource {
+	var sources []backupSource
+
+	if s.config.MetaPath != "" {
+		sources = append(sources, backupSource{prefix: "meta", path: s.config.MetaPath})
+	}
+	for _, p := range s.config.KeyPaths {
+		sources = append(sources, backupSource{prefix: "keys/" + filepath.Base(p), path: p})
+	}
+	for _, p := range s.config.ConfigPaths {
+		sources = append(sources, backupSource{prefix: "configs/" + filepath.Base(p), path: p})
+	}
+	if includeBlobs && s.config.BlobPath != "" {
+		sources = append(sources, backupSource{prefix: "blobs", path: s.config.BlobPath})
+	}
+
+	return sources
+}
+
+// writeArchive writes the tar.zst archive and returns its manifest.
+func (s *BackupService) writeArchive(ctx context.Context, path, id, dbSnapshot string, includeBlobs bool, progress *byteProgress) (*BackupManifest, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zw, err := zstd.NewWriter(file)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(zw)
+
+	manifest := &BackupManifest{
+		Version:      backupFormatVer,
+		ID:           id,
+		CreatedAt:    time.Now(),
+		IncludeBlobs: includeBlobs,
+	}
+
+	if err := addFileToTar(tw, dbSnapshot, "database/registry.db", manifest, progress); err != nil {
+		return nil, err
+	}
+
+	for _, src := range s.sources(includeBlobs) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if err := addPathToTar(ctx, tw, src.path, src.prefix, s.excluded, manifest, progress); err != nil {
+			return nil, fmt.Errorf("备份 %s 失败: %w", src.path, err)
+		}
+	}
+
+	// 清单放在最后，包含之前所有文件的校验和
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    backupManifestName,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: manifest.CreatedAt,
+	}); err != nil {
+		return nil, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return nil, err
+	}
+	progress.finish()
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, file.Sync()
+}
+
+// excluded reports whether a file is one of the secrets left out of backups.
+func (s *BackupService) excluded(path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	for _, p := range s.config.ExcludePaths {
+		if ex, err := filepath.Abs(p); err == nil && ex == abs {
+			return true
+		}
+	}
+	return false
+}
+
+// keepExcluded copies the secrets left out of backups from the directory
+// being replaced by a restore into the restored copy, so the running
+// installation keeps its keys.
+func (s *BackupService) keepExcluded(current, staged string) error {
+	for _, p := range s.config.ExcludePaths {
+		rel, err := filepath.Rel(current, p)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(staged, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addPathToTar adds a file or directory tree to the archive, leaving out
+// the files skip accepts.
+func addPathToTar(ctx context.Context, tw *tar.Writer, root, prefix string, skip func(path string) bool, manifest *BackupManifest, progress *byteProgress) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // 可选内容不存在时跳过
+		}
+		return err
+	}
+
+	if !info.IsDir() {
+		if skip(root) {
+			return nil
+		}
+		return addFileToTar(tw, root, prefix, manifest, progress)
+	}
+
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasSuffix(fi.Name(), ".tmp") || skip(path) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		return addFileToTar(tw, path, prefix+"/"+filepath.ToSlash(rel), manifest, progress)
+	})
+}
+
+// addFileToTar adds a single file to the archive and records its checksum.
+func addFileToTar(tw *tar.Writer, path, name string, manifest *BackupManifest, progress *byteProgress) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode().Perm()),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tw, hash), progress.reader(file))
+	if err != nil {
+		return err
+	}
+
+	manifest.Files = append(manifest.Files, BackupFileEntry{
+		Path:   name,
+		Size:   written,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	})
+	return nil
+}
+
+// VerifyBackupArchive checks the archive against its .sha256 file (when
+// present) and every entry against the embedded manifest.
+func VerifyBackupArchive(path string) (*BackupManifest, error) {
+	return verifyBackupArchive(path, nil)
+}
+
+// verifyBackupArchive is VerifyBackupArchive reporting the bytes read.
+func verifyBackupArchive(path string, progress *byteProgress) (*BackupManifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if progress != nil {
+		if fi, err := file.Stat(); err == nil {
+			progress.total = fi.Size()
+		}
+	}
+
+	if expected, err := readChecksumFile(path + backupChecksumExt); err == nil {
+		// 归档会被读取两次
+		if progress != nil {
+			progress.total *= 2
+		}
+		hash := sha256.New()
+		if _, err := io.Copy(hash, progress.reader(file)); err != nil {
+			return nil, err
+		}
+		if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+			return nil, fmt.Errorf("archive checksum mismatch: expected %s, got %s", expected, actual)
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+
+	zr, err := zstd.NewReader(progress.reader(file))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	actual := make(map[string]BackupFileEntry)
+	var manifest *BackupManifest
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("读取归档失败: %w", err)
+		}
+
+		if hdr.Name == backupManifestName {
+			manifest = &BackupManifest{}
+			if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+				return nil, fmt.Errorf("解析备份清单失败: %w", err)
+			}
+			continue
+		}
+
+		hash := sha256.New()
+		n, err := io.Copy(hash, tr)
+		if err != nil {
+			return nil, err
+		}
+		actual[hdr.Name] = BackupFileEntry{Path: hdr.Name, Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))}
+	}
+
+	progress.finish()
+
+	if manifest == nil {
+		return nil, errors.New("backup manifest missing")
+	}
+	if len(actual) != len(manifest.Files) {
+		return nil, fmt.Errorf("file count mismatch: manifest has %d, archive has %d", len(manifest.Files), len(actual))
+	}
+	for _, expected := range manifest.Files {
+		got, ok := actual[expected.Path]
+		if !ok {
+			return nil, fmt.Errorf("file missing from archive: %s", expected.Path)
+		}
+		if got.SHA256 != expected.SHA256 || got.Size != expected.Size {
+			return nil, fmt.Errorf("checksum mismatch: %s", expected.Path)
+		}
+	}
+
+	return manifest, nil
+}
+
+// extractBackupArchive extracts a backup archive into destDir.
+func extractBackupArchive(ctx context.Context, path, destDir string, progress *byteProgress) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	zr, err := zstd.NewReader(progress.reader(file))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			progress.finish()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Name == backupManifestName {
+			continue
+		}
+
+		// 防止路径穿越
+		target := filepath.Join(destDir, filepath.FromSlash(hdr.Name))
+		if !strings.HasPrefix(target, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path in archive: %s", hdr.Name)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode).Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, tr); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+}
+
+// replacePath replaces dst with the staged copy at src.
+func replacePath(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	old := dst + ".pre-restore"
+	os.RemoveAll(old)
+	if _, err := os.Stat(dst); err == nil {
+		if err := os.Rename(dst, old); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(src, dst); err != nil {
+		// 回滚
+		os.Rename(old, dst)
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+// fileSHA256 returns the hex SHA256 and size of a file.
+func fileSHA256(path string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), n, nil
+}
+
+// readChecksumFile reads a sha256sum-style checksum file.
+func readChecksumFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return "", errors.New("empty checksum file")
+	}
+	return fields[0], nil
+}
+
+// isValidBackupID rejects IDs that could escape the backup directory.
+func isValidBackupID(id string) bool {
+	if id == "" || strings.ContainsAny(id, `/\`) || strings.Contains(id, "..") {
+		return false
+	}
+	return true
+}
+
+// progressReader reports read progress at most once per second.
+type progressReader struct {
+	r        io.Reader
+	total    int64
+	done     int64
+	reported int64
+	last     time.Time
+	report   func(done, total int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.done += int64(n)
+	if p.report != nil && p.done != p.reported &&
+		(err == io.EOF || p.done == p.total || time.Since(p.last) >= time.Second) {
+		p.last = time.Now()
+		p.reported = p.done
+		p.report(p.done, p.total)
+	}
+	return n, err
+}
+
+// byteProgress counts bytes read across several readers and reports them
+// at most once per second. A nil *byteProgress counts nothing. The zstd
+// decoder reads its input from its own goroutine, hence the mutex.
+type byteProgress struct {
+	mu     sync.Mutex
+	total  int64
+	done   int64
+	last   time.Time
+	report func(done, total int64)
+}
+
+// reader returns r counting its reads into p.
+func (p *byteProgress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &countingReader{r: r, p: p}
+}
+
+func (p *byteProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	if p.report != nil && time.Since(p.last) >= time.Second {
+		p.last = time.Now()
+		p.report(p.done, p.total)
+	}
+}
+
+// finish reports the final count, so the last event shows the phase done.
+func (p *byteProgress) finish() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.total < p.done {
+		p.total = p.done
+	}
+	if p.report != nil {
+		p.report(p.done, p.total)
+	}
+}
+
+// countingReader feeds the bytes it reads into a byteProgress.
+type countingReader struct {
+	r io.Reader
+	p *byteProgress
+}
+
+func (c *countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.p.add(int64(n))
+	return n, err
+}
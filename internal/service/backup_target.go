@@ -0,0 +1,181 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BackupTarget is a destination that backup archives are copied to.
+type BackupTarget interface {
+	// Name returns the configured target name.
+	Name() string
+	// Upload stores the content of r under name.
+	Upload(ctx context.Context, name string, r io.Reader, size int64) error
+	// Download writes the object stored under name to w.
+	Download(ctx context.Context, name string, w io.Writer) error
+	// List returns the names of all stored objects.
+	List(ctx context.Context) ([]string, error)
+	// Delete removes the object stored under name.
+	Delete(ctx context.Context, name string) error
+}
+
+// BackupTargetConfig holds configuration for a remote backup target.
+type BackupTargetConfig struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"` // local, s3, sftp
+	Retention int    `json:"retention"`
+
+	// local / sftp
+	Path string `json:"path,omitempty"`
+
+	// s3
+	Endpoint  string `json:"endpoint,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Bucket    string `json:"bucket,omitempty"`
+	Prefix    string `json:"prefix,omitempty"`
+	AccessKey string `json:"access_key,omitempty"`
+	SecretKey string `json:"-"`
+	PathStyle bool   `json:"path_style,omitempty"`
+
+	// sftp
+	Host           string `json:"host,omitempty"`
+	Port           int    `json:"port,omitempty"`
+	Username       string `json:"username,omitempty"`
+	Password       string `json:"-"`
+	PrivateKeyPath string `json:"private_key_path,omitempty"`
+	HostKey        string `json:"host_key,omitempty"` // authorized_keys 格式的服务器公钥
+}
+
+// BackupTargetResult records the outcome of copying a backup to a target.
+type BackupTargetResult struct {
+	Target   string `json:"target"`
+	Status   string `json:"status"`
+	Verified bool   `json:"verified"`
+	Pruned   int    `json:"pruned,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// NewBackupTarget creates a backup target from configuration.
+func NewBackupTarget(cfg BackupTargetConfig) (BackupTarget, error) {
+	if cfg.Name == "" {
+		cfg.Name = cfg.Type
+	}
+
+	switch cfg.Type {
+	case "local", "":
+		if cfg.Path == "" {
+			return nil, errors.New("local backup target requires path")
+		}
+		return &LocalBackupTarget{name: cfg.Name, path: cfg.Path}, nil
+	case "s3":
+		return NewS3BackupTarget(cfg)
+	case "sftp":
+		return NewSFTPBackupTarget(cfg)
+	default:
+		return nil, fmt.Errorf("unknown backup target type: %s", cfg.Type)
+	}
+}
+
+// LocalBackupTarget stores backups in a local directory, e.g. a mounted NAS.
+type LocalBackupTarget struct {
+	name string
+	path string
+}
+
+// Name returns the target name.
+func (t *LocalBackupTarget) Name() string {
+	return t.name
+}
+
+// Upload writes the object to the target directory.
+func (t *LocalBackupTarget) Upload(_ context.Context, name string, r io.Reader, _ int64) error {
+	if err := os.MkdirAll(t.path, 0700); err != nil {
+		return err
+	}
+
+	dst := filepath.Join(t.path, filepath.Base(name))
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Download reads the object from the target directory.
+func (t *LocalBackupTarget) Download(_ context.Context, name string, w io.Writer) error {
+	file, err := os.Open(filepath.Join(t.path, filepath.Base(name)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// List lists objects in the target directory.
+func (t *LocalBackupTarget) List(_ context.Context) ([]string, error) {
+	entries, err := os.ReadDir(t.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	return names, nil
+}
+
+// Delete removes an object from the target directory.
+func (t *LocalBackupTarget) Delete(_ context.Context, name string) error {
+	err := os.Remove(filepath.Join(t.path, filepath.Base(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// pruneBackups keeps the newest keep archives in names and returns the
+// archive IDs that should be removed. Backup IDs embed a sortable
+// timestamp, so lexical order is chronological order.
+func pruneBackups(names []string, keep int) []string {
+	if keep <= 0 {
+		return nil
+	}
+
+	var ids []string
+	for _, name := range names {
+		if strings.HasPrefix(name, "backup-") && strings.HasSuffix(name, backupExt) {
+			ids = append(ids, strings.TrimSuffix(name, backupExt))
+		}
+	}
+	if len(ids) <= keep {
+		return nil
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	return ids[keep:]
+}
@@ -0,0 +1,201 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPBackupTarget stores backups on a remote server over SFTP.
+type SFTPBackupTarget struct {
+	name   string
+	addr   string
+	path   string
+	config *ssh.ClientConfig
+}
+
+// NewSFTPBackupTarget creates a new SFTPBackupTarget instance.
+func NewSFTPBackupTarget(cfg BackupTargetConfig) (*SFTPBackupTarget, error) {
+	if cfg.Host == "" || cfg.Username == "" {
+		return nil, errors.New("sftp backup target requires host and username")
+	}
+
+	var auth []ssh.AuthMethod
+	if cfg.PrivateKeyPath != "" {
+		keyData, err := os.ReadFile(cfg.PrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取 SSH 私钥失败: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(keyData)
+		if err != nil {
+			return nil, fmt.Errorf("解析 SSH 私钥失败: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.Password != "" {
+		auth = append(auth, ssh.Password(cfg.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("sftp backup target requires password or private_key_path")
+	}
+
+	// 必须配置服务器公钥，防止中间人攻击
+	if cfg.HostKey == "" {
+		return nil, errors.New("sftp backup target requires host_key")
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid sftp host_key: %w", err)
+	}
+
+	port := cfg.Port
+	if port == 0 {
+		port = 22
+	}
+
+	remotePath := cfg.Path
+	if remotePath == "" {
+		remotePath = "."
+	}
+
+	return &SFTPBackupTarget{
+		name: cfg.Name,
+		addr: net.JoinHostPort(cfg.Host, strconv.Itoa(port)),
+		path: remotePath,
+		config: &ssh.ClientConfig{
+			User:            cfg.Username,
+			Auth:            auth,
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// Name returns the target name.
+func (t *SFTPBackupTarget) Name() string {
+	return t.name
+}
+
+// Upload writes the object to the remote directory.
+func (t *SFTPBackupTarget) Upload(ctx context.Context, name string, r io.Reader, _ int64) error {
+	return t.withClient(ctx, func(client *sftp.Client) error {
+		if err := client.MkdirAll(t.path); err != nil {
+			return err
+		}
+
+		dst := path.Join(t.path, path.Base(name))
+		tmp := dst + ".tmp"
+		out, err := client.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		if _, err := out.ReadFrom(r); err != nil {
+			out.Close()
+			client.Remove(tmp)
+			return err
+		}
+		if err := out.Close(); err != nil {
+			client.Remove(tmp)
+			return err
+		}
+
+		// PosixRename 可覆盖已存在的文件，服务器不支持时退回普通 Rename
+		if err := client.PosixRename(tmp, dst); err != nil {
+			client.Remove(dst)
+			return client.Rename(tmp, dst)
+		}
+		return nil
+	})
+}
+
+// Download reads the object from the remote directory.
+func (t *SFTPBackupTarget) Download(ctx context.Context, name string, w io.Writer) error {
+	return t.withClient(ctx, func(client *sftp.Client) error {
+		file, err := client.Open(path.Join(t.path, path.Base(name)))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = file.WriteTo(w)
+		return err
+	})
+}
+
+// List lists objects in the remote directory.
+func (t *SFTPBackupTarget) List(ctx context.Context) ([]string, error) {
+	var names []string
+	err := t.withClient(ctx, func(client *sftp.Client) error {
+		entries, err := client.ReadDir(t.path)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				names = append(names, entry.Name())
+			}
+		}
+		return nil
+	})
+	return names, err
+}
+
+// Delete removes an object from the remote directory.
+func (t *SFTPBackupTarget) Delete(ctx context.Context, name string) error {
+	return t.withClient(ctx, func(client *sftp.Client) error {
+		err := client.Remove(path.Join(t.path, path.Base(name)))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+// withClient opens an SFTP session for the duration of fn. The connection
+// is closed when ctx is cancelled so long transfers can be aborted.
+func (t *SFTPBackupTarget) withClient(ctx context.Context, fn func(*sftp.Client) error) error {
+	dialer := &net.Dialer{Timeout: t.config.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return err
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	sshClient := ssh.NewClient(sshConn, chans, reqs)
+	defer sshClient.Close()
+
+	client, err := sftp.NewClient(sshClient)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			sshClient.Close()
+		case <-done:
+		}
+	}()
+
+	return fn(client)
+}
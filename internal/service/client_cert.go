@@ -0,0 +1,349 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+// 客户端证书映射到用户名的字段
+const (
+	CertUserFieldCN    = "cn"    // 主题的 CommonName
+	CertUserFieldEmail = "email" // SAN 中的邮箱
+	CertUserFieldDNS   = "dns"   // SAN 中的 DNS 名称
+	CertUserFieldURI   = "uri"   // SAN 中的 URI，如 SPIFFE ID
+)
+
+var (
+	ErrClientCertRequired   = errors.New("client certificate required")
+	ErrClientCertUnverified = errors.New("client certificate is not verified")
+	ErrClientCertRevoked    = errors.New("client certificate is revoked")
+	ErrClientCertUnknown    = errors.New("client certificate revocation status is unknown")
+	ErrClientCertUnmapped   = errors.New("client certificate does not name a user")
+)
+
+const (
+	ocspTimeout     = 10 * time.Second
+	ocspDefaultTTL  = time.Hour      // 响应没有 NextUpdate 时的缓存时长
+	ocspMaxTTL      = 24 * time.Hour // 缓存时长上限
+	ocspMaxResponse = 64 << 10
+)
+
+// ClientCertConfig configures client certificate authentication.
+type ClientCertConfig struct {
+	ClientCA     string // PEM file of the CAs issuing client certificates
+	Required     bool
+	UserField    string
+	Users        map[string]string // certificate field value -> username
+	CRLFiles     []string
+	OCSP         bool
+	OCSPSoftFail bool
+}
+
+// ClientCertIdentity is a verified client certificate and the user it maps
+// to, recorded in audit logs of the requests made with it.
+type ClientCertIdentity struct {
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	Fingerprint string    `json:"fingerprint"` // DER 编码的 SHA-256
+	Username    string    `json:"username"`
+	NotAfter    time.Time `json:"not_after"`
+}
+
+// AuditDetails returns the identity as audit log details.
+func (i *ClientCertIdentity) AuditDetails() map[string]interface{} {
+	return map[string]interface{}{
+		"subject":     i.Subject,
+		"serial":      i.Serial,
+		"fingerprint": i.Fingerprint,
+	}
+}
+
+// crlFile is a loaded revocation list, reloaded when the file changes.
+type crlFile struct {
+	modTime time.Time
+	issuer  []byte // RawIssuer
+	revoked map[string]bool
+}
+
+// ocspStatus is a cached OCSP answer for a certificate.
+type ocspStatus struct {
+	revoked bool
+	until   time.Time
+}
+
+// ClientCertService verifies the revocation status of client certificates,
+// whose chain the TLS handshake already verified against the client CAs,
+// and maps them to users.
+type ClientCertService struct {
+	config ClientCertConfig
+	pool   *x509.CertPool
+	cas    []*x509.Certificate
+	logger *zap.Logger
+	client *http.Client
+
+	mu   sync.Mutex
+	crls map[string]*crlFile
+	ocsp map[string]ocspStatus // 证书指纹 -> 状态
+}
+
+// NewClientCertService loads the client CAs and revocation lists.
+func NewClientCertService(config ClientCertConfig, logger *zap.Logger) (*ClientCertService, error) {
+	data, err := os.ReadFile(config.ClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	s := &ClientCertService{
+		config: config,
+		pool:   x509.NewCertPool(),
+		logger: logger,
+		client: &http.Client{Timeout: ocspTimeout},
+		crls:   make(map[string]*crlFile),
+		ocsp:   make(map[string]ocspStatus),
+	}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parse client CA: %w", err)
+		}
+		s.pool.AddCert(cert)
+		s.cas = append(s.cas, cert)
+	}
+	if len(s.cas) == 0 {
+		return nil, errors.New("client CA file has no certificate")
+	}
+	if s.config.UserField == "" {
+		s.config.UserField = CertUserFieldCN
+	}
+
+	for _, path := range config.CRLFiles {
+		if _, err := s.loadCRL(path); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ClientCAs returns the pool client certificates are verified against.
+func (s *ClientCertService) ClientCAs() *x509.CertPool {
+	return s.pool
+}
+
+// Required reports whether requests must present a certificate.
+func (s *ClientCertService) Required() bool {
+	return s.config.Required
+}
+
+// Authenticate checks the client certificate of a connection. It returns
+// nil without error when the client sent none.
+func (s *ClientCertService) Authenticate(state *tls.ConnectionState) (*ClientCertIdentity, error) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, ErrClientCertUnverified
+	}
+	chain := state.VerifiedChains[0]
+	leaf := chain[0]
+	fingerprint := sha256.Sum256(leaf.Raw)
+	identity := &ClientCertIdentity{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		Serial:      leaf.SerialNumber.Text(16),
+		Fingerprint: hex.EncodeToString(fingerprint[:]),
+		NotAfter:    leaf.NotAfter,
+	}
+
+	if err := s.checkCRLs(leaf); err != nil {
+		return identity, err
+	}
+	if s.config.OCSP && len(chain) > 1 {
+		if err := s.checkOCSP(leaf, chain[1], identity.Fingerprint); err != nil {
+			return identity, err
+		}
+	}
+
+	identity.Username = s.username(leaf)
+	if identity.Username == "" {
+		return identity, ErrClientCertUnmapped
+	}
+	return identity, nil
+}
+
+// username maps the certificate to a username: a configured mapping of a
+// value of the user field, or else its first value.
+func (s *ClientCertService) username(cert *x509.Certificate) string {
+	var values []string
+	switch s.config.UserField {
+	case CertUserFieldCN:
+		values = []string{cert.Subject.CommonName}
+	case CertUserFieldEmail:
+		values = cert.EmailAddresses
+	case CertUserFieldDNS:
+		values = cert.DNSNames
+	case CertUserFieldURI:
+		for _, u := range cert.URIs {
+			values = append(values, u.String())
+		}
+	}
+	for _, v := range values {
+		if user, ok := s.config.Users[v]; ok {
+			return user
+		}
+	}
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// checkCRLs looks the certificate up in the revocation lists of its issuer.
+func (s *ClientCertService) checkCRLs(cert *x509.Certificate) error {
+	for _, path := range s.config.CRLFiles {
+		crl, err := s.loadCRL(path)
+		if err != nil {
+			// 保留上次加载的列表，文件写到一半时不会放行已吊销的证书
+			if s.logger != nil {
+				s.logger.Warn("加载证书吊销列表失败", zap.String("path", path), zap.Error(err))
+			}
+			s.mu.Lock()
+			crl = s.crls[path]
+			s.mu.Unlock()
+			if crl == nil {
+				return ErrClientCertUnknown
+			}
+		}
+		if bytes.Equal(crl.issuer, cert.RawIssuer) && crl.revoked[cert.SerialNumber.String()] {
+			return ErrClientCertRevoked
+		}
+	}
+	return nil
+}
+
+// loadCRL returns the revocation list of path, parsing it again when the
+// file changed. Lists must be signed by one of the client CAs.
+func (s *ClientCertService) loadCRL(path string) (*crlFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if crl := s.crls[path]; crl != nil && crl.modTime.Equal(info.ModTime()) {
+		return crl, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse crl %s: %w", path, err)
+	}
+	signed := false
+	for _, ca := range s.cas {
+		if bytes.Equal(ca.RawSubject, list.RawIssuer) && list.CheckSignatureFrom(ca) == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		return nil, fmt.Errorf("crl %s is not signed by a client CA", path)
+	}
+
+	crl := &crlFile{modTime: info.ModTime(), issuer: list.RawIssuer, revoked: make(map[string]bool)}
+	for _, entry := range list.RevokedCertificateEntries {
+		crl.revoked[entry.SerialNumber.String()] = true
+	}
+	s.crls[path] = crl
+	return crl, nil
+}
+
+// checkOCSP asks the OCSP responder of the certificate for its status,
+// caching answers until their next update.
+func (s *ClientCertService) checkOCSP(cert, issuer *x509.Certificate, fingerprint string) error {
+	if len(cert.OCSPServer) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	status, ok := s.ocsp[fingerprint]
+	s.mu.Unlock()
+	if ok && time.Now().Before(status.until) {
+		if status.revoked {
+			return ErrClientCertRevoked
+		}
+		return nil
+	}
+
+	resp, err := s.queryOCSP(cert, issuer)
+	if err != nil || resp.Status == ocsp.Unknown {
+		if err == nil {
+			err = errors.New("responder does not know the certificate")
+		}
+		if s.logger != nil {
+			s.logger.Warn("OCSP 查询失败", zap.String("subject", cert.Subject.String()), zap.Error(err))
+		}
+		if s.config.OCSPSoftFail {
+			return nil
+		}
+		return ErrClientCertUnknown
+	}
+
+	until := resp.NextUpdate
+	if until.IsZero() {
+		until = time.Now().Add(ocspDefaultTTL)
+	}
+	if max := time.Now().Add(ocspMaxTTL); until.After(max) {
+		until = max
+	}
+	revoked := resp.Status == ocsp.Revoked
+	s.mu.Lock()
+	s.ocsp[fingerprint] = ocspStatus{revoked: revoked, until: until}
+	s.mu.Unlock()
+	if revoked {
+		return ErrClientCertRevoked
+	}
+	return nil
+}
+
+// queryOCSP sends an OCSP request for cert to its first responder.
+func (s *ClientCertService) queryOCSP(cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Post(cert.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ocsp responder returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, ocspMaxResponse))
+	if err != nil {
+		return nil, err
+	}
+	return ocsp.ParseResponseForCert(body, cert, issuer)
+}
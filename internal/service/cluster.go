@@ -0,0 +1,210 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// Advisory lock names shared by the instances of a cluster.
+const (
+	LeaderLockName   = "leader"
+	MetadataLockName = "metadata"
+)
+
+// DefaultLeaseTTL is the lease duration when none is configured.
+const DefaultLeaseTTL = 30 * time.Second
+
+// DefaultInstanceID returns the host name, which Kubernetes sets to the pod
+// name, so instances are told apart without configuration.
+func DefaultInstanceID() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+	return "instance-" + randomString(8)
+}
+
+// LeaderElector elects one leader among the instances sharing the database.
+// The leader holds a lease it renews every third of the TTL; when it stops
+// renewing, another instance takes over once the lease expires. Schedulers
+// consult IsLeader so scheduled jobs run on a single instance while every
+// instance serves HTTP.
+type LeaderElector struct {
+	holder string
+	ttl    time.Duration
+	logger *zap.Logger
+
+	leader     atomic.Bool
+	leaseUntil time.Time // local end of the last renewed lease
+
+	stopCh   chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewLeaderElector creates a new LeaderElector for the instance holder.
+func NewLeaderElector(holder string, ttl time.Duration, logger *zap.Logger) *LeaderElector {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &LeaderElector{
+		holder: holder,
+		ttl:    ttl,
+		logger: logger,
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start campaigns for leadership once synchronously, so IsLeader is
+// accurate before the schedulers start, then keeps campaigning in the
+// background.
+func (l *LeaderElector) Start() {
+	l.campaign()
+
+	go func() {
+		defer close(l.done)
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.stopCh:
+				return
+			case <-ticker.C:
+				l.campaign()
+			}
+		}
+	}()
+}
+
+// Stop stops campaigning and releases the lease, letting another instance
+// take over without waiting for it to expire.
+func (l *LeaderElector) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.stopCh)
+		<-l.done
+		if l.leader.Swap(false) {
+			if err := dao.ReleaseLock(LeaderLockName, l.holder); err != nil && l.logger != nil {
+				l.logger.Warn("释放领导者租约失败", zap.Error(err))
+			}
+		}
+	})
+}
+
+// IsLeader reports whether this instance currently holds the lease.
+func (l *LeaderElector) IsLeader() bool {
+	return l.leader.Load()
+}
+
+// InstanceID returns the identifier of this instance.
+func (l *LeaderElector) InstanceID() string {
+	return l.holder
+}
+
+// Leader returns the current lease, or nil while no instance leads.
+func (l *LeaderElector) Leader() (*dao.Lease, error) {
+	return dao.GetLease(LeaderLockName)
+}
+
+// campaign acquires or renews the lease.
+func (l *LeaderElector) campaign() {
+	start := time.Now()
+	ok, err := dao.AcquireLock(LeaderLockName, l.holder, l.ttl)
+	if err != nil {
+		// 数据库暂时不可用时，租约到期前仍保持领导者身份
+		if l.logger != nil {
+			l.logger.Warn("续约领导者租约失败", zap.Error(err))
+		}
+		ok = l.leader.Load() && time.Now().Before(l.leaseUntil)
+	} else if ok {
+		l.leaseUntil = start.Add(l.ttl)
+	}
+
+	if was := l.leader.Swap(ok); was != ok && l.logger != nil {
+		if ok {
+			l.logger.Info("成为领导者，开始执行定时任务", zap.String("instance", l.holder))
+		} else {
+			l.logger.Warn("失去领导者身份，停止执行定时任务", zap.String("instance", l.holder))
+		}
+	}
+}
+
+// lockRetryMax caps the delay between attempts to take a DistributedLock.
+const lockRetryMax = 200 * time.Millisecond
+
+// ErrLockTimeout is returned when a DistributedLock cannot be taken within
+// its ttl.
+var ErrLockTimeout = errors.New("timed out acquiring distributed lock")
+
+// DistributedLock serializes a critical section across the instances
+// sharing the database. The lease expires after ttl, so the section must
+// finish well within it.
+type DistributedLock struct {
+	name   string
+	holder string
+	ttl    time.Duration
+	logger *zap.Logger
+
+	// local serializes goroutines of this instance, which share one holder
+	local sync.Mutex
+}
+
+// NewDistributedLock creates a new DistributedLock.
+func NewDistributedLock(name, holder string, ttl time.Duration, logger *zap.Logger) *DistributedLock {
+	if ttl <= 0 {
+		ttl = DefaultLeaseTTL
+	}
+	return &DistributedLock{
+		name:   name,
+		holder: holder,
+		ttl:    ttl,
+		logger: logger,
+	}
+}
+
+// Lock blocks until the lock is held. A lease left by a crashed instance
+// expires after ttl, so waiting longer means the database is failing or
+// another instance is stuck; Lock then fails with ErrLockTimeout and the
+// caller must not enter the section. Unlock is only called after a nil
+// error.
+func (l *DistributedLock) Lock() error {
+	l.local.Lock()
+
+	deadline := time.Now().Add(l.ttl)
+	delay := 5 * time.Millisecond
+	for {
+		ok, err := dao.AcquireLock(l.name, l.holder, l.ttl)
+		if ok {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			l.local.Unlock()
+			if l.logger != nil {
+				l.logger.Error("获取分布式锁超时", zap.String("lock", l.name), zap.Error(err))
+			}
+			if err != nil {
+				return fmt.Errorf("%w %s: %v", ErrLockTimeout, l.name, err)
+			}
+			return fmt.Errorf("%w %s", ErrLockTimeout, l.name)
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, lockRetryMax)
+	}
+}
+
+// Unlock releases the lock.
+func (l *DistributedLock) Unlock() {
+	if err := dao.ReleaseLock(l.name, l.holder); err != nil && l.logger != nil {
+		l.logger.Warn("释放分布式锁失败", zap.String("lock", l.name), zap.Error(err))
+	}
+	l.local.Unlock()
+}
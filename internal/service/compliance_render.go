@@ -0,0 +1,240 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/pkg/pdf"
+)
+
+// 合规报告面向外部审计人员，HTML 和 PDF 使用英文
+const complianceTimeFormat = "2006-01-02 15:04:05 MST"
+
+var complianceHTML = template.Must(template.New("compliance").Funcs(template.FuncMap{
+	"time":   func(t time.Time) string { return t.Format(complianceTimeFormat) },
+	"yesno":  yesNo,
+	"digest": shortDigest,
+	"vulns":  vulnCounts,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Compliance Report - {{.Scope}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 13px; color: #222; margin: 32px; }
+h1 { font-size: 22px; margin-bottom: 4px; }
+h2 { font-size: 16px; margin-top: 28px; border-bottom: 1px solid #ccc; padding-bottom: 4px; }
+table { border-collapse: collapse; margin-top: 8px; }
+th, td { border: 1px solid #ddd; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f4f4f4; }
+.meta td { border: none; padding: 2px 12px 2px 0; }
+.ok { color: #1a7f37; font-weight: bold; }
+.fail { color: #c62828; font-weight: bold; }
+code { font-size: 12px; }
+</style>
+</head>
+<body>
+<h1>Compliance Report</h1>
+<table class="meta">
+<tr><td>Scope</td><td>{{if .OrgID}}Organization {{.Scope}}{{else}}Whole registry{{end}}</td></tr>
+<tr><td>Generated at</td><td>{{time .GeneratedAt}}</td></tr>
+<tr><td>Generated by</td><td>{{.GeneratedBy}}</td></tr>
+{{if .SignatureMode}}<tr><td>Signature mode</td><td>{{.SignatureMode}}</td></tr>{{end}}
+{{if .VulnDBVersion}}<tr><td>Vulnerability database</td><td>{{.VulnDBVersion}}</td></tr>{{end}}
+</table>
+
+<h2>Summary</h2>
+<table>
+<tr><th>Images</th><td>{{.Summary.Images}}</td></tr>
+<tr><th>Signed</th><td>{{.Summary.Signed}} ({{printf "%.1f" .Summary.SignatureCoverage}}%)</td></tr>
+<tr><th>With SBOM</th><td>{{.Summary.WithSBOM}} ({{printf "%.1f" .Summary.SBOMCoverage}}%)</td></tr>
+<tr><th>Scanned</th><td>{{.Summary.Scanned}} ({{printf "%.1f" .Summary.ScanCoverage}}%)</td></tr>
+<tr><th>Images with critical or high vulnerabilities</th><td>{{.Summary.VulnerableImages}}</td></tr>
+<tr><th>Vulnerabilities</th><td>{{vulns .Summary.Vulnerabilities}}</td></tr>
+</table>
+
+<h2>Audit Log Integrity</h2>
+{{with .Audit}}
+<p>Period {{time .PeriodStart}} to {{time .PeriodEnd}}, whole registry:
+{{if .Intact}}<span class="ok">INTACT</span>{{else}}<span class="fail">BROKEN</span>{{end}}</p>
+<table>
+<tr><th>Entries verified</th><td>{{.Checked}}</td></tr>
+<tr><th>Entries without hash</th><td>{{.Unhashed}}</td></tr>
+<tr><th>Entries with erased personal data</th><td>{{.Redacted}}</td></tr>
+<tr><th>Chain segments</th><td>{{.Chains}}</td></tr>
+<tr><th>Broken entries</th><td>{{.Broken}}</td></tr>
+</table>
+{{if .Truncated}}<p class="fail">The period holds more entries than are verified at once; only the oldest were verified.</p>{{end}}
+{{if .Breaks}}
+<table>
+<tr><th>Source</th><th>ID</th><th>Timestamp</th></tr>
+{{range .Breaks}}<tr><td>{{.Source}}</td><td>{{.ID}}</td><td>{{time .Timestamp}}</td></tr>
+{{end}}</table>
+{{end}}
+{{else}}<p>Not verified.</p>{{end}}
+
+<h2>Images</h2>
+{{if .Images}}
+<table>
+<tr><th>Image</th><th>Digest</th><th>Signed</th><th>SBOM</th><th>Packages</th><th>Scanned</th><th>Vulnerabilities</th></tr>
+{{range .Images}}<tr>
+<td>{{.Image}}</td><td><code>{{digest .Digest}}</code></td>
+<td class="{{if .Signed}}ok{{else}}fail{{end}}">{{yesno .Signed}}</td>
+<td class="{{if .SBOM}}ok{{else}}fail{{end}}">{{yesno .SBOM}}</td>
+<td>{{if .SBOM}}{{.Packages}}{{end}}</td>
+<td class="{{if .Scanned}}ok{{else}}fail{{end}}">{{yesno .Scanned}}</td>
+<td>{{if .Scanned}}{{vulns .Vulnerabilities}}{{end}}</td>
+</tr>
+{{end}}</table>
+{{else}}<p>No images.</p>{{end}}
+
+{{if .Errors}}
+<h2>Incomplete Sections</h2>
+<ul>
+{{range $section, $err := .Errors}}<li>{{$section}}: {{$err}}</li>
+{{end}}</ul>
+{{end}}
+</body>
+</html>
+`))
+
+// RenderComplianceHTML renders a compliance report as a standalone HTML
+// page.
+func RenderComplianceHTML(report *ComplianceReport) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := complianceHTML.Execute(&buf, report); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderCompliancePDF renders a compliance report as a PDF document.
+func RenderCompliancePDF(report *ComplianceReport) []byte {
+	doc := pdf.New("Compliance Report - " + report.Scope)
+	doc.Heading("Compliance Report", 18)
+	scope := "Whole registry"
+	if report.OrgID != 0 {
+		scope = "Organization " + report.Scope
+	}
+	meta := [][2]string{
+		{"Scope", scope},
+		{"Generated at", report.GeneratedAt.Format(complianceTimeFormat)},
+		{"Generated by", report.GeneratedBy},
+	}
+	if report.SignatureMode != "" {
+		meta = append(meta, [2]string{"Signature mode", report.SignatureMode})
+	}
+	if report.VulnDBVersion != "" {
+		meta = append(meta, [2]string{"Vulnerability database", report.VulnDBVersion})
+	}
+	for _, m := range meta {
+		doc.Paragraph(pdf.Helvetica, 10, m[0]+": "+m[1])
+	}
+
+	s := report.Summary
+	doc.Heading("Summary", 13)
+	doc.Rule()
+	for _, line := range []string{
+		fmt.Sprintf("Images: %d", s.Images),
+		fmt.Sprintf("Signed: %d (%.1f%%)", s.Signed, s.SignatureCoverage),
+		fmt.Sprintf("With SBOM: %d (%.1f%%)", s.WithSBOM, s.SBOMCoverage),
+		fmt.Sprintf("Scanned: %d (%.1f%%)", s.Scanned, s.ScanCoverage),
+		fmt.Sprintf("Images with critical or high vulnerabilities: %d", s.VulnerableImages),
+		"Vulnerabilities: " + vulnCounts(s.Vulnerabilities),
+	} {
+		doc.Paragraph(pdf.Helvetica, 10, line)
+	}
+
+	doc.Heading("Audit Log Integrity", 13)
+	doc.Rule()
+	if a := report.Audit; a != nil {
+		status := "INTACT"
+		if !a.Intact {
+			status = "BROKEN"
+		}
+		doc.Paragraph(pdf.Helvetica, 10, fmt.Sprintf("Period %s to %s, whole registry: %s",
+			a.PeriodStart.Format(complianceTimeFormat), a.PeriodEnd.Format(complianceTimeFormat), status))
+		doc.Paragraph(pdf.Helvetica, 10, fmt.Sprintf("Entries verified: %d, without hash: %d, with erased personal data: %d, chain segments: %d, broken: %d",
+			a.Checked, a.Unhashed, a.Redacted, a.Chains, a.Broken))
+		if a.Truncated {
+			doc.Paragraph(pdf.Helvetica, 10, "The period holds more entries than are verified at once; only the oldest were verified.")
+		}
+		if len(a.Breaks) > 0 {
+			doc.Space(4)
+			doc.Line(pdf.Courier, 8, fmt.Sprintf("%-16s %-12s %s", "SOURCE", "ID", "TIMESTAMP"))
+			for _, b := range a.Breaks {
+				doc.Line(pdf.Courier, 8, fmt.Sprintf("%-16s %-12d %s", b.Source, b.ID, b.Timestamp.Format(complianceTimeFormat)))
+			}
+		}
+	} else {
+		doc.Paragraph(pdf.Helvetica, 10, "Not verified.")
+	}
+
+	doc.Heading("Images", 13)
+	doc.Rule()
+	if len(report.Images) == 0 {
+		doc.Paragraph(pdf.Helvetica, 10, "No images.")
+	} else {
+		// Courier 8pt 每行最多约 103 个字符
+		row := "%-42s %-19s %-6s %-4s %8s %-7s %s"
+		doc.Line(pdf.Courier, 8, fmt.Sprintf(row, "IMAGE", "DIGEST", "SIGNED", "SBOM", "PACKAGES", "SCANNED", "C/H/M/L"))
+		for _, image := range report.Images {
+			packages, vulns := "", ""
+			if image.SBOM {
+				packages = fmt.Sprint(image.Packages)
+			}
+			if image.Scanned {
+				v := image.Vulnerabilities
+				vulns = fmt.Sprintf("%d/%d/%d/%d", v.Critical, v.High, v.Medium, v.Low)
+			}
+			doc.Line(pdf.Courier, 8, fmt.Sprintf(row, truncateText(image.Image, 42), shortDigest(image.Digest),
+				yesNo(image.Signed), yesNo(image.SBOM), packages, yesNo(image.Scanned), vulns))
+		}
+	}
+
+	if len(report.Errors) > 0 {
+		doc.Heading("Incomplete Sections", 13)
+		doc.Rule()
+		sections := make([]string, 0, len(report.Errors))
+		for section := range report.Errors {
+			sections = append(sections, section)
+		}
+		sort.Strings(sections)
+		for _, section := range sections {
+			doc.Paragraph(pdf.Helvetica, 10, section+": "+report.Errors[section])
+		}
+	}
+	return doc.Bytes()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// shortDigest shortens a digest to the algorithm and 12 hex characters.
+func shortDigest(digest string) string {
+	if i := strings.Index(digest, ":"); i >= 0 && len(digest) > i+13 {
+		return digest[:i+13]
+	}
+	return digest
+}
+
+func vulnCounts(v VulnSummary) string {
+	return fmt.Sprintf("%d critical, %d high, %d medium, %d low", v.Critical, v.High, v.Medium, v.Low)
+}
+
+// truncateText cuts text to n characters, marking the cut with "~".
+func truncateText(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n-1]) + "~"
+}
@@ -0,0 +1,265 @@
+package service
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// DefaultCompliancePeriod is the audit period of a compliance report when
+// none is given.
+const DefaultCompliancePeriod = 30 * 24 * time.Hour
+
+// ErrInvalidCompliancePeriod is returned when the audit period ends before
+// it starts.
+var ErrInvalidCompliancePeriod = errors.New("invalid compliance report period")
+
+// ComplianceQuery selects the scope and audit period of a compliance
+// report.
+type ComplianceQuery struct {
+	OrgID int64 // 0 表示整个镜像仓库
+	Since time.Time
+	Until time.Time
+}
+
+// ComplianceReport is the point-in-time compliance state of the images of
+// an organization or of the whole registry.
+type ComplianceReport struct {
+	Scope         string            `json:"scope"` // registry 或组织名
+	OrgID         int64             `json:"org_id,omitempty"`
+	GeneratedAt   time.Time         `json:"generated_at"`
+	GeneratedBy   string            `json:"generated_by"`
+	SignatureMode string            `json:"signature_mode,omitempty"`
+	VulnDBVersion string            `json:"vuln_db_version,omitempty"`
+	Summary       ComplianceSummary `json:"summary"`
+	Images        []ComplianceImage `json:"images"`
+	Audit         *AuditIntegrity   `json:"audit,omitempty"` // 整个镜像仓库的审计日志
+	Errors        map[string]string `json:"errors,omitempty"`
+}
+
+// ComplianceSummary counts the images that are signed, have an SBOM and
+// were scanned. Coverages are percentages.
+type ComplianceSummary struct {
+	Images            int         `json:"images"`
+	Signed            int         `json:"signed"`
+	WithSBOM          int         `json:"with_sbom"`
+	Scanned           int         `json:"scanned"`
+	SignatureCoverage float64     `json:"signature_coverage"`
+	SBOMCoverage      float64     `json:"sbom_coverage"`
+	ScanCoverage      float64     `json:"scan_coverage"`
+	VulnerableImages  int         `json:"vulnerable_images"` // 有严重或高危漏洞的镜像数
+	Vulnerabilities   VulnSummary `json:"vulnerabilities"`
+}
+
+// ComplianceImage is the compliance state of a tagged image.
+type ComplianceImage struct {
+	Image           string      `json:"image"`
+	Digest          string      `json:"digest"`
+	PushedAt        time.Time   `json:"pushed_at"`
+	Signed          bool        `json:"signed"`
+	SBOM            bool        `json:"sbom"`
+	Packages        int         `json:"packages"`
+	Scanned         bool        `json:"scanned"`
+	ScannedAt       string      `json:"scanned_at,omitempty"`
+	Vulnerabilities VulnSummary `json:"vulnerabilities"`
+}
+
+// ComplianceService builds compliance reports for auditors from the
+// signatures, SBOMs, scan results and audit logs.
+type ComplianceService struct {
+	sbom       *SBOMService
+	signatures *SignatureService
+	audit      *AuditService
+	orgs       *OrgService
+	images     SecurityImageSource
+	logger     *zap.Logger
+}
+
+// NewComplianceService creates a new ComplianceService instance.
+func NewComplianceService(sbom *SBOMService, signatures *SignatureService, audit *AuditService, orgs *OrgService, logger *zap.Logger) *ComplianceService {
+	return &ComplianceService{
+		sbom:       sbom,
+		signatures: signatures,
+		audit:      audit,
+		orgs:       orgs,
+		logger:     logger,
+	}
+}
+
+// SetImageSource sets the source of the images of the registry.
+func (s *ComplianceService) SetImageSource(images SecurityImageSource) {
+	s.images = images
+}
+
+// Report builds the compliance report of an organization, which its
+// owner, its administrators and system administrators may read, or of the
+// whole registry, which only system administrators may read. The audit
+// log is verified for the period of the query, the last 30 days by default.
+func (s *ComplianceService) Report(q ComplianceQuery, requestorID int64, requestor string) (*ComplianceReport, error) {
+	if q.Until.IsZero() {
+		q.Until = time.Now()
+	}
+	if q.Since.IsZero() {
+		q.Since = q.Until.Add(-DefaultCompliancePeriod)
+	}
+	if !q.Since.Before(q.Until) {
+		return nil, ErrInvalidCompliancePeriod
+	}
+
+	report := &ComplianceReport{
+		Scope:       "registry",
+		GeneratedAt: time.Now(),
+		GeneratedBy: requestor,
+		Images:      []ComplianceImage{},
+		Errors:      make(map[string]string),
+	}
+	if q.OrgID != 0 {
+		org, err := s.manageableOrg(q.OrgID, requestorID)
+		if err != nil {
+			return nil, err
+		}
+		report.Scope = org.Name
+		report.OrgID = org.ID
+	} else if !isSystemAdmin(requestorID) {
+		return nil, errors.New("permission denied")
+	}
+	if s.signatures != nil {
+		report.SignatureMode = s.signatures.Mode()
+	}
+	if s.sbom != nil && s.sbom.vulnDB != nil {
+		report.VulnDBVersion = s.sbom.vulnDB.Status().Version
+	}
+
+	s.imageCompliance(report)
+	if s.audit != nil {
+		integrity, err := s.audit.VerifyIntegrity(q.Since, q.Until)
+		if err != nil {
+			report.Errors["audit"] = err.Error()
+		} else {
+			report.Audit = integrity
+		}
+	}
+	if len(report.Errors) == 0 {
+		report.Errors = nil
+	}
+	return report, nil
+}
+
+// imageCompliance checks the tagged images in the scope of the report.
+func (s *ComplianceService) imageCompliance(report *ComplianceReport) {
+	if s.images == nil {
+		return
+	}
+	images, err := s.images.TaggedImages()
+	if err != nil {
+		report.Errors["images"] = err.Error()
+		return
+	}
+
+	summary := &report.Summary
+	for _, image := range images {
+		if report.OrgID != 0 && imageNamespace(image.Repository) != report.Scope {
+			continue
+		}
+		item := ComplianceImage{
+			Image:    image.Repository + ":" + image.Tag,
+			Digest:   image.Digest,
+			PushedAt: image.PushedAt,
+		}
+		if s.signatures != nil {
+			item.Signed = imageSigned(s.signatures, image)
+		}
+		if sbom := s.imageSBOM(image); sbom != nil {
+			item.SBOM = true
+			item.Packages = len(sbom.Packages)
+			item.ScannedAt = sbom.Metadata["scanned_at"]
+			item.Scanned = item.ScannedAt != "" || sbom.Metadata["vuln_db_version"] != "" || len(sbom.Vulnerabilities) > 0
+			for _, v := range sbom.Vulnerabilities {
+				countSeverity(&item.Vulnerabilities, v.Severity)
+			}
+		}
+
+		summary.Images++
+		if item.Signed {
+			summary.Signed++
+		}
+		if item.SBOM {
+			summary.WithSBOM++
+		}
+		if item.Scanned {
+			summary.Scanned++
+		}
+		if item.Vulnerabilities.Critical > 0 || item.Vulnerabilities.High > 0 {
+			summary.VulnerableImages++
+		}
+		summary.Vulnerabilities.Critical += item.Vulnerabilities.Critical
+		summary.Vulnerabilities.High += item.Vulnerabilities.High
+		summary.Vulnerabilities.Medium += item.Vulnerabilities.Medium
+		summary.Vulnerabilities.Low += item.Vulnerabilities.Low
+		summary.Vulnerabilities.Total += item.Vulnerabilities.Total
+		report.Images = append(report.Images, item)
+	}
+	summary.SignatureCoverage = coverage(summary.Signed, summary.Images)
+	summary.SBOMCoverage = coverage(summary.WithSBOM, summary.Images)
+	summary.ScanCoverage = coverage(summary.Scanned, summary.Images)
+	sort.Slice(report.Images, func(i, j int) bool {
+		return report.Images[i].Image < report.Images[j].Image
+	})
+}
+
+// imageSBOM returns the SBOM generated for the tag or the digest of an
+// image, or nil.
+func (s *ComplianceService) imageSBOM(image TaggedImage) *SBOM {
+	if s.sbom == nil {
+		return nil
+	}
+	for _, ref := range []string{image.Repository + ":" + image.Tag, image.Repository + "@" + image.Digest} {
+		if sbom, err := s.sbom.GetSBOM(ref); err == nil {
+			return sbom
+		}
+	}
+	return nil
+}
+
+// manageableOrg returns the organization if the requestor can manage it.
+func (s *ComplianceService) manageableOrg(orgID, requestorID int64) (*dao.Organization, error) {
+	if dao.GetDB() == nil {
+		return nil, ErrServiceUnavailable
+	}
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+	if s.orgs == nil || !s.orgs.canManageOrg(org, requestorID) {
+		return nil, errors.New("permission denied")
+	}
+	return org, nil
+}
+
+// coverage returns n of total as a percentage with one decimal.
+func coverage(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(n)*1000/float64(total)) / 10
+}
+
+// Filename returns the download name of the report with the extension ext.
+func (report *ComplianceReport) Filename(ext string) string {
+	scope := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '-'
+	}, report.Scope)
+	return "compliance-" + scope + "-" + report.GeneratedAt.Format("20060102") + "." + ext
+}
@@ -4,8 +4,11 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -13,9 +16,38 @@ import (
 
 // DNSService provides DNS resolution services.
 type DNSService struct {
-	logger   *zap.Logger
-	resolver *net.Resolver
-	timeout  time.Duration
+	logger    *zap.Logger
+	mu        sync.RWMutex
+	resolver  *net.Resolver
+	transport *dnsTransport // nil while the system resolver is used
+	timeout   time.Duration
+}
+
+// DNSResolverConfig selects the upstream DNS servers. Without servers and
+// routes the system resolver is used.
+type DNSResolverConfig struct {
+	// Servers are tried in order for names no route matches; empty for the
+	// servers of the system
+	Servers []string
+	// Routes send queries for some domains to other servers, e.g. docker.io
+	// to a DoH server; the most specific domain wins
+	Routes []DNSRouteConfig
+	// CacheSize is the number of responses cached, 0 disables the cache
+	CacheSize int
+}
+
+// DNSRouteConfig sends queries for Domains and their subdomains to Servers.
+type DNSRouteConfig struct {
+	Domains []string
+	Servers []string
+}
+
+// DNSStatus describes the resolver in use.
+type DNSStatus struct {
+	System  bool          `json:"system"` // no default server configured
+	Servers []*DNSServer  `json:"servers"`
+	Routes  []DNSRoute    `json:"routes"`
+	Cache   DNSCacheStats `json:"cache"`
 }
 
 // DNSRecord represents a DNS record.
@@ -29,6 +61,7 @@ type DNSRecord struct {
 type DNSResolveResult struct {
 	Domain    string       `json:"domain"`
 	Records   []*DNSRecord `json:"records"`
+	Servers   []string     `json:"servers,omitempty"` // upstream servers for the domain, in order
 	ResolveAt time.Time    `json:"resolve_at"`
 	Duration  int64        `json:"duration_ms"`
 }
@@ -44,6 +77,84 @@ func NewDNSService(logger *zap.Logger) *DNSService {
 	}
 }
 
+// Configure switches to the given upstream servers. Plain, DoT and DoH
+// servers can be mixed; see ParseDNSServer.
+func (s *DNSService) Configure(config DNSResolverConfig) error {
+	transport := &dnsTransport{client: &http.Client{Timeout: dnsTimeout}}
+	for _, address := range config.Servers {
+		server, err := ParseDNSServer(address)
+		if err != nil {
+			return err
+		}
+		transport.servers = append(transport.servers, server)
+	}
+	for i, rc := range config.Routes {
+		route := DNSRoute{Domains: sortedDomains(rc.Domains)}
+		if len(route.Domains) == 0 || len(rc.Servers) == 0 {
+			return fmt.Errorf("DNS route %d: domains and servers are required", i)
+		}
+		for _, address := range rc.Servers {
+			server, err := ParseDNSServer(address)
+			if err != nil {
+				return err
+			}
+			route.Servers = append(route.Servers, server)
+		}
+		transport.routes = append(transport.routes, route)
+	}
+	if config.CacheSize > 0 {
+		transport.cache = newDNSCache(config.CacheSize)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(transport.servers) == 0 && len(transport.routes) == 0 && transport.cache == nil {
+		s.transport = nil
+		s.resolver = &net.Resolver{PreferGo: true}
+		return nil
+	}
+	s.transport = transport
+	s.resolver = transport.resolver()
+	return nil
+}
+
+// Resolver returns the resolver using the configured servers, for clients
+// that should resolve upstream hosts the same way.
+func (s *DNSService) Resolver() *net.Resolver {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.resolver
+}
+
+// Status returns the configured servers, routes and cache statistics.
+func (s *DNSService) Status() *DNSStatus {
+	s.mu.RLock()
+	t := s.transport
+	s.mu.RUnlock()
+
+	status := &DNSStatus{System: true, Servers: []*DNSServer{}, Routes: []DNSRoute{}}
+	if t == nil {
+		return status
+	}
+	status.System = len(t.servers) == 0
+	status.Servers = append(status.Servers, t.servers...)
+	status.Routes = append(status.Routes, t.routes...)
+	if t.cache != nil {
+		status.Cache = t.cache.stats()
+	}
+	return status
+}
+
+// FlushCache drops the cached responses.
+func (s *DNSService) FlushCache() {
+	s.mu.RLock()
+	t := s.transport
+	s.mu.RUnlock()
+	if t != nil && t.cache != nil {
+		t.cache.flush()
+	}
+}
+
 // Resolve resolves a domain name and returns all available records.
 func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 	if domain == "" {
@@ -71,8 +182,17 @@ func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 		ResolveAt: startTime,
 	}
 
+	s.mu.RLock()
+	resolver, transport := s.resolver, s.transport
+	s.mu.RUnlock()
+	if transport != nil {
+		for _, server := range transport.serversFor(domain) {
+			result.Servers = append(result.Servers, server.Address)
+		}
+	}
+
 	// Resolve A records (IPv4)
-	ips, err := s.resolver.LookupIP(ctx, "ip4", domain)
+	ips, err := resolver.LookupIP(ctx, "ip4", domain)
 	if err == nil {
 		for _, ip := range ips {
 			result.Records = append(result.Records, &DNSRecord{
@@ -83,7 +203,7 @@ func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 	}
 
 	// Resolve AAAA records (IPv6)
-	ips6, err := s.resolver.LookupIP(ctx, "ip6", domain)
+	ips6, err := resolver.LookupIP(ctx, "ip6", domain)
 	if err == nil {
 		for _, ip := range ips6 {
 			result.Records = append(result.Records, &DNSRecord{
@@ -94,7 +214,7 @@ func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 	}
 
 	// Resolve CNAME records
-	cname, err := s.resolver.LookupCNAME(ctx, domain)
+	cname, err := resolver.LookupCNAME(ctx, domain)
 	if err == nil && cname != "" && cname != domain+"." {
 		result.Records = append(result.Records, &DNSRecord{
 			Type:  "CNAME",
@@ -103,7 +223,7 @@ func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 	}
 
 	// Resolve MX records
-	mxRecords, err := s.resolver.LookupMX(ctx, domain)
+	mxRecords, err := resolver.LookupMX(ctx, domain)
 	if err == nil {
 		for _, mx := range mxRecords {
 			result.Records = append(result.Records, &DNSRecord{
@@ -115,7 +235,7 @@ func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 	}
 
 	// Resolve TXT records
-	txtRecords, err := s.resolver.LookupTXT(ctx, domain)
+	txtRecords, err := resolver.LookupTXT(ctx, domain)
 	if err == nil {
 		for _, txt := range txtRecords {
 			result.Records = append(result.Records, &DNSRecord{
@@ -126,7 +246,7 @@ func (s *DNSService) Resolve(domain string) (*DNSResolveResult, error) {
 	}
 
 	// Resolve NS records
-	nsRecords, err := s.resolver.LookupNS(ctx, domain)
+	nsRecords, err := resolver.LookupNS(ctx, domain)
 	if err == nil {
 		for _, ns := range nsRecords {
 			result.Records = append(result.Records, &DNSRecord{
@@ -166,7 +286,7 @@ func (s *DNSService) ResolveIP(domain string) ([]string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
 	defer cancel()
 
-	ips, err := s.resolver.LookupHost(ctx, domain)
+	ips, err := s.Resolver().LookupHost(ctx, domain)
 	if err != nil {
 		return nil, errors.New("域名解析失败: " + err.Error())
 	}
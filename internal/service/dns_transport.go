@@ -0,0 +1,487 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Protocols of upstream DNS servers.
+const (
+	DNSProtocolUDP = "udp"   // plain DNS, over TCP when the answer is truncated
+	DNSProtocolDoT = "tls"   // DNS over TLS, RFC 7858
+	DNSProtocolDoH = "https" // DNS over HTTPS, RFC 8484
+)
+
+// DNSServer is an upstream DNS server. Address is written as 8.8.8.8,
+// 8.8.8.8:53, tls://1.1.1.1, tls://dns.google:853 or
+// https://dns.google/dns-query.
+type DNSServer struct {
+	Address  string `json:"address"`
+	Protocol string `json:"protocol"`
+
+	hostPort   string // udp and tls
+	serverName string // tls
+	url        string // https
+}
+
+// ParseDNSServer parses the address of an upstream DNS server.
+func ParseDNSServer(address string) (*DNSServer, error) {
+	address = strings.TrimSpace(address)
+	server := &DNSServer{Address: address, Protocol: DNSProtocolUDP}
+	hostPort := address
+	if strings.Contains(address, "://") {
+		u, err := url.Parse(address)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS server %q", address)
+		}
+		switch u.Scheme {
+		case DNSProtocolUDP:
+		case DNSProtocolDoT:
+			server.Protocol = DNSProtocolDoT
+			server.serverName = u.Hostname()
+		case DNSProtocolDoH:
+			server.Protocol = DNSProtocolDoH
+			server.url = u.String()
+			return server, nil
+		default:
+			return nil, fmt.Errorf("invalid DNS server %q: unsupported scheme %s", address, u.Scheme)
+		}
+		hostPort = u.Host
+	}
+
+	if _, _, err := net.SplitHostPort(hostPort); err != nil {
+		port := "53"
+		if server.Protocol == DNSProtocolDoT {
+			port = "853"
+		}
+		hostPort = net.JoinHostPort(strings.Trim(hostPort, "[]"), port)
+	}
+	if host, _, _ := net.SplitHostPort(hostPort); host == "" {
+		return nil, fmt.Errorf("invalid DNS server %q", address)
+	}
+	server.hostPort = hostPort
+	return server, nil
+}
+
+// dnsTimeout bounds a query to one server when the caller sets no deadline.
+const dnsTimeout = 5 * time.Second
+
+// exchange sends a DNS message to the server and returns the response.
+func (s *DNSServer) exchange(ctx context.Context, client *http.Client, msg []byte) ([]byte, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dnsTimeout)
+		defer cancel()
+	}
+
+	switch s.Protocol {
+	case DNSProtocolDoH:
+		return s.exchangeHTTPS(ctx, client, msg)
+	case DNSProtocolDoT:
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: s.serverName, MinVersion: tls.VersionTLS12}}
+		conn, err := dialer.DialContext(ctx, "tcp", s.hostPort)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return exchangeStream(ctx, conn, msg)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", s.hostPort)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	resp := buf[:n]
+
+	// Truncated answers are asked again over TCP
+	if len(resp) > 2 && resp[2]&0x02 != 0 {
+		tcp, err := dialer.DialContext(ctx, "tcp", s.hostPort)
+		if err != nil {
+			return nil, err
+		}
+		defer tcp.Close()
+		return exchangeStream(ctx, tcp, msg)
+	}
+	return resp, nil
+}
+
+// exchangeHTTPS posts a DNS message to a DoH server.
+func (s *DNSServer) exchangeHTTPS(ctx context.Context, client *http.Client, msg []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(msg))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
+
+// exchangeStream sends a length-prefixed DNS message over a stream
+// connection and reads the response.
+func exchangeStream(ctx context.Context, conn net.Conn, msg []byte) ([]byte, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	framed := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(framed, uint16(len(msg)))
+	copy(framed[2:], msg)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// DNSRoute sends queries for Domains, and their subdomains, to Servers.
+type DNSRoute struct {
+	Domains []string     `json:"domains"`
+	Servers []*DNSServer `json:"servers"`
+}
+
+// ============================================================================
+// Cache
+// ============================================================================
+
+// DNSCacheStats reports the use of the resolution cache.
+type DNSCacheStats struct {
+	Enabled bool  `json:"enabled"`
+	Entries int   `json:"entries"`
+	MaxSize int   `json:"max_size"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+}
+
+// dnsCacheEntry is a cached response.
+type dnsCacheEntry struct {
+	msg     []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// dnsCache keeps responses until the lowest TTL of their records expires.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]*dnsCacheEntry
+	maxSize int
+	hits    int64
+	misses  int64
+}
+
+func newDNSCache(maxSize int) *dnsCache {
+	return &dnsCache{entries: make(map[string]*dnsCacheEntry), maxSize: maxSize}
+}
+
+// get returns the cached response to a question with the query ID and the
+// TTLs lowered by the time spent in the cache.
+func (c *dnsCache) get(key string, id uint16) []byte {
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	now := time.Now()
+	if ok && now.After(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil
+	}
+	c.hits++
+	c.mu.Unlock()
+
+	var m dnsmessage.Message
+	if err := m.Unpack(entry.msg); err != nil {
+		return nil
+	}
+	m.ID = id
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dnsmessage.Resource{m.Answers, m.Authorities, m.Additionals} {
+		for i := range section {
+			if section[i].Header.Type == dnsmessage.TypeOPT {
+				continue
+			}
+			if section[i].Header.TTL > elapsed {
+				section[i].Header.TTL -= elapsed
+			} else {
+				section[i].Header.TTL = 0
+			}
+		}
+	}
+	msg, err := m.Pack()
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// put caches a response for its TTL: the lowest TTL of the answers, or
+// for negative answers the SOA TTL bounded by its minimum (RFC 2308).
+// Failures and responses without a TTL are not cached.
+func (c *dnsCache) put(key string, msg []byte) {
+	var m dnsmessage.Message
+	if err := m.Unpack(msg); err != nil || m.Truncated {
+		return
+	}
+	if m.RCode != dnsmessage.RCodeSuccess && m.RCode != dnsmessage.RCodeNameError {
+		return
+	}
+
+	ttl, found := uint32(0), false
+	lower := func(t uint32) {
+		if !found || t < ttl {
+			ttl, found = t, true
+		}
+	}
+	if len(m.Answers) > 0 {
+		for _, rr := range m.Answers {
+			lower(rr.Header.TTL)
+		}
+	} else {
+		for _, rr := range m.Authorities {
+			if soa, ok := rr.Body.(*dnsmessage.SOAResource); ok {
+				lower(rr.Header.TTL)
+				lower(soa.MinTTL)
+			}
+		}
+	}
+	if !found || ttl == 0 {
+		return
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxSize {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &dnsCacheEntry{msg: msg, stored: now, expires: now.Add(time.Duration(ttl) * time.Second)}
+}
+
+// evictLocked drops the expired entries, or the one expiring first when
+// none has. Caller must hold mu.
+func (c *dnsCache) evictLocked(now time.Time) {
+	var first string
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if first == "" || entry.expires.Before(c.entries[first].expires) {
+			first = key
+		}
+	}
+	if len(c.entries) >= c.maxSize && first != "" {
+		delete(c.entries, first)
+	}
+}
+
+func (c *dnsCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*dnsCacheEntry)
+}
+
+func (c *dnsCache) stats() DNSCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return DNSCacheStats{Enabled: true, Entries: len(c.entries), MaxSize: c.maxSize, Hits: c.hits, Misses: c.misses}
+}
+
+// ============================================================================
+// Transport
+// ============================================================================
+
+// dnsTransport answers the queries of a net.Resolver: each query goes to
+// the servers of the most specific route for its name, or the default
+// servers, tried in order until one answers.
+type dnsTransport struct {
+	servers []*DNSServer
+	routes  []DNSRoute
+	cache   *dnsCache // nil when disabled
+	client  *http.Client
+}
+
+// serversFor returns the servers for a query name, nil for the system
+// resolver.
+func (t *dnsTransport) serversFor(name string) []*DNSServer {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var best []*DNSServer
+	bestLen := -1
+	for _, route := range t.routes {
+		for _, domain := range route.Domains {
+			if (name == domain || strings.HasSuffix(name, "."+domain)) && len(domain) > bestLen {
+				best, bestLen = route.Servers, len(domain)
+			}
+		}
+	}
+	if bestLen >= 0 {
+		return best
+	}
+	return t.servers
+}
+
+// exchange answers a DNS query. system is the server the resolver would
+// have asked, used when no server is configured for the name.
+func (t *dnsTransport) exchange(ctx context.Context, msg []byte, system string) ([]byte, error) {
+	var p dnsmessage.Parser
+	header, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+	key := strings.ToLower(q.Name.String()) + "/" + q.Type.String() + "/" + q.Class.String()
+
+	if t.cache != nil {
+		if resp := t.cache.get(key, header.ID); resp != nil {
+			return resp, nil
+		}
+	}
+
+	servers := t.serversFor(q.Name.String())
+	if len(servers) == 0 {
+		servers = []*DNSServer{{Address: system, Protocol: DNSProtocolUDP, hostPort: system}}
+	}
+	var errs []error
+	for _, server := range servers {
+		resp, err := server.exchange(ctx, t.client, msg)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", server.Address, err))
+			continue
+		}
+		if t.cache != nil {
+			t.cache.put(key, resp)
+		}
+		return resp, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// resolver returns a net.Resolver using the transport.
+func (t *dnsTransport) resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return &dnsConn{ctx: ctx, transport: t, system: address}, nil
+		},
+	}
+}
+
+// dnsConn is the connection the Go resolver talks to. It is a stream
+// connection, so the resolver writes length-prefixed queries, and each
+// query is answered through the transport before Write returns.
+type dnsConn struct {
+	ctx       context.Context
+	transport *dnsTransport
+	system    string
+	deadline  time.Time
+	wbuf      bytes.Buffer
+	rbuf      bytes.Buffer
+}
+
+func (c *dnsConn) Write(b []byte) (int, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	c.wbuf.Write(b)
+	for c.wbuf.Len() >= 2 {
+		length := int(binary.BigEndian.Uint16(c.wbuf.Bytes()))
+		if c.wbuf.Len() < 2+length {
+			break
+		}
+		c.wbuf.Next(2)
+		msg := append([]byte(nil), c.wbuf.Next(length)...)
+
+		resp, err := c.transport.exchange(ctx, msg, c.system)
+		if err != nil {
+			return 0, err
+		}
+		var prefix [2]byte
+		binary.BigEndian.PutUint16(prefix[:], uint16(len(resp)))
+		c.rbuf.Write(prefix[:])
+		c.rbuf.Write(resp)
+	}
+	return len(b), nil
+}
+
+func (c *dnsConn) Read(b []byte) (int, error) {
+	if c.rbuf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.rbuf.Read(b)
+}
+
+func (c *dnsConn) Close() error                       { return nil }
+func (c *dnsConn) LocalAddr() net.Addr                { return dnsAddr{} }
+func (c *dnsConn) RemoteAddr() net.Addr               { return dnsAddr{} }
+func (c *dnsConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dnsConn) SetReadDeadline(_ time.Time) error  { return nil }
+func (c *dnsConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+// dnsAddr is the address of a dnsConn.
+type dnsAddr struct{}
+
+func (dnsAddr) Network() string { return "dns" }
+func (dnsAddr) String() string  { return "dns-transport" }
+
+// sortedDomains normalizes the domains of a route.
+func sortedDomains(domains []string) []string {
+	out := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.Trim(strings.TrimSpace(d), "."))
+		if d != "" {
+			out = append(out, d)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
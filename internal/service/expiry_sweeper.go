@@ -0,0 +1,153 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// UploadPurger removes the leftovers of interrupted blob uploads.
+type UploadPurger interface {
+	PurgeStaleUploads(maxAge time.Duration) (int, error)
+}
+
+// ExpirySweeperConfig holds the retention settings of the sweeper.
+type ExpirySweeperConfig struct {
+	// Retention is how long expired personal access tokens and expired or
+	// used up share links are kept, so users still see them in their lists.
+	Retention time.Duration
+	// UploadTTL is the age after which unfinished upload files are removed.
+	UploadTTL time.Duration
+}
+
+// SweepResult counts what the sweeper removed.
+type SweepResult struct {
+	Sessions   int64 `json:"sessions"`
+	Tokens     int64 `json:"tokens"`
+	ShareLinks int64 `json:"share_links"`
+	PullTokens int64 `json:"pull_tokens"`
+	Uploads    int64 `json:"uploads"`
+}
+
+// SweepStats are the totals since startup, exported as metrics.
+type SweepStats struct {
+	Removed  SweepResult `json:"removed"`
+	Runs     int64       `json:"runs"`
+	Failures int64       `json:"failures"`
+	LastRun  time.Time   `json:"last_run"`
+}
+
+// ExpirySweeper periodically removes expired sessions, expired personal
+// access tokens, stale share links and their pull tokens, and interrupted
+// blob uploads. It runs as a task of the automation engine.
+type ExpirySweeper struct {
+	config ExpirySweeperConfig
+	logger *zap.Logger
+
+	shares  *ShareService
+	uploads UploadPurger
+
+	mu    sync.Mutex
+	stats SweepStats
+}
+
+// NewExpirySweeper creates a new ExpirySweeper instance.
+func NewExpirySweeper(config ExpirySweeperConfig, shares *ShareService, logger *zap.Logger) *ExpirySweeper {
+	return &ExpirySweeper{
+		config: config,
+		logger: logger,
+		shares: shares,
+	}
+}
+
+// SetUploadPurger sets the storage whose interrupted uploads are removed.
+func (s *ExpirySweeper) SetUploadPurger(purger UploadPurger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads = purger
+}
+
+// Sweep runs one pass. A failing step does not stop the others; their
+// errors are returned together.
+func (s *ExpirySweeper) Sweep() (*SweepResult, error) {
+	s.mu.Lock()
+	uploads := s.uploads
+	s.mu.Unlock()
+
+	now := time.Now()
+	result := &SweepResult{}
+	var errs []error
+
+	if dao.GetDB() != nil {
+		n, err := dao.CleanExpiredSessions(now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("sessions: %w", err))
+		}
+		result.Sessions = n
+
+		n, err = dao.DeleteExpiredTokens(now.Add(-s.config.Retention))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tokens: %w", err))
+		}
+		result.Tokens = n
+
+		if s.shares != nil {
+			links, err := s.shares.PurgeStaleLinks(now.Add(-s.config.Retention))
+			if err != nil {
+				errs = append(errs, fmt.Errorf("share links: %w", err))
+			}
+			result.ShareLinks = int64(links)
+
+			n, err = s.shares.PurgeExpiredPullTokens()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("pull tokens: %w", err))
+			}
+			result.PullTokens = n
+		}
+	}
+	if uploads != nil && s.config.UploadTTL > 0 {
+		n, err := uploads.PurgeStaleUploads(s.config.UploadTTL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("uploads: %w", err))
+		}
+		result.Uploads = int64(n)
+	}
+	err := errors.Join(errs...)
+
+	s.mu.Lock()
+	s.stats.Runs++
+	if err != nil {
+		s.stats.Failures++
+	}
+	s.stats.LastRun = now
+	s.stats.Removed.Sessions += result.Sessions
+	s.stats.Removed.Tokens += result.Tokens
+	s.stats.Removed.ShareLinks += result.ShareLinks
+	s.stats.Removed.PullTokens += result.PullTokens
+	s.stats.Removed.Uploads += result.Uploads
+	s.mu.Unlock()
+
+	if s.logger != nil && *result != (SweepResult{}) {
+		s.logger.Info("已清理过期数据",
+			zap.Int64("sessions", result.Sessions),
+			zap.Int64("tokens", result.Tokens),
+			zap.Int64("share_links", result.ShareLinks),
+			zap.Int64("pull_tokens", result.PullTokens),
+			zap.Int64("uploads", result.Uploads),
+		)
+	}
+	return result, err
+}
+
+// Stats returns the totals since startup.
+func (s *ExpirySweeper) Stats() SweepStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
@@ -0,0 +1,371 @@
+// Package service provides business logic services for the container registry.
+package service
+
+import (
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// statsDayFormat 统计按天分桶的日期格式（UTC）
+	statsDayFormat = "2006-01-02"
+	// defaultStatsRetention 统计数据默认保留时长
+	defaultStatsRetention = 90 * 24 * time.Hour
+	// defaultStatsFlushInterval 内存计数写入数据库的默认间隔
+	defaultStatsFlushInterval = 30 * time.Second
+)
+
+// ImageStatSummary 单个镜像在统计区间内的计数
+type ImageStatSummary struct {
+	Repository  string `json:"repository"`
+	Tag         string `json:"tag,omitempty"`
+	Pulls       int64  `json:"pulls"`
+	Pushes      int64  `json:"pushes"`
+	BytesServed int64  `json:"bytes_served,omitempty"`
+}
+
+// DailyImageStat 每日汇总
+type DailyImageStat struct {
+	Day         string `json:"day"`
+	Pulls       int64  `json:"pulls"`
+	Pushes      int64  `json:"pushes"`
+	BytesServed int64  `json:"bytes_served"`
+}
+
+// ImagePush 推送记录
+type ImagePush struct {
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest,omitempty"`
+	PushedBy   string    `json:"pushed_by,omitempty"`
+	PushedAt   time.Time `json:"pushed_at"`
+}
+
+// UserActivity 用户在统计区间内的镜像操作次数
+type UserActivity struct {
+	Username string `json:"username"`
+	Pulls    int64  `json:"pulls"`
+	Pushes   int64  `json:"pushes"`
+	Deletes  int64  `json:"deletes"`
+}
+
+// ImageStats 镜像统计数据，供仪表盘使用
+type ImageStats struct {
+	Days         int                   `json:"days"`
+	TotalPulls   int64                 `json:"total_pulls"`
+	TotalPushes  int64                 `json:"total_pushes"`
+	TotalDeletes int64                 `json:"total_deletes"`
+	BytesServed  int64                 `json:"bytes_served"`
+	TopPulled    []*ImageStatSummary   `json:"top_pulled"`
+	TopBandwidth []*ImageStatSummary   `json:"top_bandwidth"`
+	TopUsers     []*UserActivity       `json:"top_users"`
+	RecentPushes []*ImagePush          `json:"recent_pushes"`
+	RecentEvents []*RegistryEventEntry `json:"recent_events"`
+	Daily        []*DailyImageStat     `json:"daily"`
+	UpdatedAt    time.Time             `json:"updated_at"`
+}
+
+// statKey 内存计数的分桶键
+type statKey struct {
+	day        string
+	repository string
+	tag        string
+}
+
+// ImageStatsService 记录镜像拉取、推送次数和下载流量
+// 计数先在内存中累加，定期批量写入数据库，避免每次拉取都写库
+type ImageStatsService struct {
+	logger    *zap.Logger
+	retention time.Duration
+
+	pending map[statKey]*dao.ImageStatRecord
+	pushes  []*dao.ImagePushRecord
+	mu      sync.Mutex
+
+	events *RegistryEventLog
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewImageStatsService 创建镜像统计服务
+func NewImageStatsService(logger *zap.Logger) *ImageStatsService {
+	return &ImageStatsService{
+		logger:    logger,
+		retention: defaultStatsRetention,
+		pending:   make(map[statKey]*dao.ImageStatRecord),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// SetRetention 设置统计数据保留时长
+func (s *ImageStatsService) SetRetention(retention time.Duration) {
+	if retention > 0 {
+		s.retention = retention
+	}
+}
+
+// SetEventLog 设置事件日志，仪表盘从中统计删除次数、活跃用户和最近事件
+func (s *ImageStatsService) SetEventLog(events *RegistryEventLog) {
+	s.events = events
+}
+
+// HandleEvent 记录镜像推送和拉取事件
+func (s *ImageStatsService) HandleEvent(event *RegistryEvent) {
+	switch event.Type {
+	case RegistryEventPull:
+		s.add(event.Timestamp, event.Repository, event.Tag, func(r *dao.ImageStatRecord) { r.Pulls++ })
+	case RegistryEventPush:
+		s.add(event.Timestamp, event.Repository, event.Tag, func(r *dao.ImageStatRecord) { r.Pushes++ })
+
+		s.mu.Lock()
+		s.pushes = append(s.pushes, &dao.ImagePushRecord{
+			Repository: event.Repository,
+			Tag:        event.Tag,
+			Digest:     event.Digest,
+			PushedBy:   event.Actor,
+			CreatedAt:  event.Timestamp.UTC(),
+		})
+		s.mu.Unlock()
+	}
+}
+
+// RecordBytesServed 记录仓库的Blob下载流量
+func (s *ImageStatsService) RecordBytesServed(repository string, n int64) {
+	if n <= 0 {
+		return
+	}
+	s.add(time.Now(), repository, "", func(r *dao.ImageStatRecord) { r.BytesServed += n })
+}
+
+// add 累加内存计数
+func (s *ImageStatsService) add(at time.Time, repository, tag string, update func(r *dao.ImageStatRecord)) {
+	if at.IsZero() {
+		at = time.Now()
+	}
+	key := statKey{day: at.UTC().Format(statsDayFormat), repository: repository, tag: tag}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.pending[key]
+	if !ok {
+		r = &dao.ImageStatRecord{Day: key.day, Repository: repository, Tag: tag}
+		s.pending[key] = r
+	}
+	update(r)
+}
+
+// Flush 将内存计数写入数据库，失败时保留计数等待下次写入
+func (s *ImageStatsService) Flush() error {
+	if dao.GetDB() == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	if len(s.pending) == 0 && len(s.pushes) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	pending, pushes := s.pending, s.pushes
+	s.pending = make(map[statKey]*dao.ImageStatRecord)
+	s.pushes = nil
+	s.mu.Unlock()
+
+	records := make([]*dao.ImageStatRecord, 0, len(pending))
+	for _, r := range pending {
+		records = append(records, r)
+	}
+
+	if err := dao.AddImageStats(records, pushes); err != nil {
+		s.mu.Lock()
+		for key, r := range pending {
+			if cur, ok := s.pending[key]; ok {
+				cur.Pulls += r.Pulls
+				cur.Pushes += r.Pushes
+				cur.BytesServed += r.BytesServed
+			} else {
+				s.pending[key] = r
+			}
+		}
+		s.pushes = append(pushes, s.pushes...)
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start 启动定期写库和过期数据清理
+func (s *ImageStatsService) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultStatsFlushInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-s.stopCh:
+				s.flushAndLog()
+				return
+			}
+
+			s.flushAndLog()
+			if time.Since(lastPrune) >= 24*time.Hour && dao.GetDB() != nil {
+				if err := dao.PruneImageStats(time.Now().Add(-s.retention)); err != nil && s.logger != nil {
+					s.logger.Warn("清理过期镜像统计失败", zap.Error(err))
+				}
+				lastPrune = time.Now()
+			}
+		}
+	}()
+}
+
+// Stop 停止后台任务并写入剩余计数
+func (s *ImageStatsService) Stop() {
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
+func (s *ImageStatsService) flushAndLog() {
+	if err := s.Flush(); err != nil && s.logger != nil {
+		s.logger.Warn("写入镜像统计失败", zap.Error(err))
+	}
+}
+
+// GetImageStats 获取最近 days 天的统计，limit 限制排行榜长度
+func (s *ImageStatsService) GetImageStats(days, limit int) (*ImageStats, error) {
+	if days <= 0 {
+		days = 30
+	}
+	if limit <= 0 {
+		limit = 10
+	}
+
+	// 先写入内存中的计数，保证数据是最新的
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+
+	stats := &ImageStats{
+		Days:         days,
+		TopPulled:    []*ImageStatSummary{},
+		TopBandwidth: []*ImageStatSummary{},
+		TopUsers:     []*UserActivity{},
+		RecentPushes: []*ImagePush{},
+		RecentEvents: []*RegistryEventEntry{},
+		Daily:        []*DailyImageStat{},
+		UpdatedAt:    time.Now(),
+	}
+	if dao.GetDB() == nil {
+		return stats, nil
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -(days - 1)).Format(statsDayFormat)
+
+	top, err := dao.TopPulledImages(since, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range top {
+		stats.TopPulled = append(stats.TopPulled, &ImageStatSummary{
+			Repository: r.Repository,
+			Tag:        r.Tag,
+			Pulls:      r.Pulls,
+			Pushes:     r.Pushes,
+		})
+	}
+
+	bandwidth, err := dao.TopBandwidthRepositories(since, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range bandwidth {
+		stats.TopBandwidth = append(stats.TopBandwidth, &ImageStatSummary{
+			Repository:  r.Repository,
+			BytesServed: r.BytesServed,
+		})
+	}
+
+	daily, err := dao.DailyImageStats(since)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range daily {
+		stats.TotalPulls += r.Pulls
+		stats.TotalPushes += r.Pushes
+		stats.BytesServed += r.BytesServed
+		stats.Daily = append(stats.Daily, &DailyImageStat{
+			Day:         r.Day,
+			Pulls:       r.Pulls,
+			Pushes:      r.Pushes,
+			BytesServed: r.BytesServed,
+		})
+	}
+
+	pushes, err := dao.ListRecentImagePushes(limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pushes {
+		stats.RecentPushes = append(stats.RecentPushes, &ImagePush{
+			Repository: p.Repository,
+			Tag:        p.Tag,
+			Digest:     p.Digest,
+			PushedBy:   p.PushedBy,
+			PushedAt:   p.CreatedAt,
+		})
+	}
+
+	if s.events != nil {
+		if err := s.addEventStats(stats, since, limit); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+// addEventStats 从事件日志统计删除次数、活跃用户和最近事件
+func (s *ImageStatsService) addEventStats(stats *ImageStats, sinceDay string, limit int) error {
+	since, _ := time.Parse(statsDayFormat, sinceDay)
+
+	events, _, err := s.events.List(RegistryEventQuery{PageSize: limit})
+	if err != nil {
+		return err
+	}
+	// 仪表盘对所有用户开放，不显示客户端 IP
+	for _, e := range events {
+		e.ClientIP = ""
+	}
+	stats.RecentEvents = events
+
+	counts, err := dao.CountRegistryEvents(since)
+	if err != nil {
+		return err
+	}
+	stats.TotalDeletes = counts[RegistryEventDelete]
+
+	users, err := dao.TopRegistryUsers(since, limit)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		stats.TopUsers = append(stats.TopUsers, &UserActivity{
+			Username: u.Username,
+			Pulls:    u.Pulls,
+			Pushes:   u.Pushes,
+			Deletes:  u.Deletes,
+		})
+	}
+	return nil
+}
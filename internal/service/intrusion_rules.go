@@ -0,0 +1,502 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// Intrusion detection patterns. Rules with another pattern match attempts
+// whose error code equals the pattern, e.g. forged_jwt.
+const (
+	PatternCredentialStuffing = "credential_stuffing" // 同一 IP 尝试多个用户名登录失败
+	PatternTokenScanning      = "token_scanning"      // 同一 IP 反复使用无效令牌
+	PatternPathFuzzing        = "path_fuzzing"        // 同一 IP 请求大量不存在的路径
+	PatternGeoAnomaly         = "geo_anomaly"         // 异常国家或时段的成功登录
+	PatternLoginFailure       = "login_failure"       // 同一 IP 登录失败次数
+)
+
+// Intrusion actions.
+const (
+	IntrusionActionLock    = "lock"
+	IntrusionActionBlockIP = "block_ip"
+	IntrusionActionNotify  = "notify"
+)
+
+// Access attempt actions evaluated by the rules.
+const (
+	AttemptLogin    = "login"
+	AttemptToken    = "token"
+	AttemptNotFound = "not_found"
+)
+
+const (
+	defaultRuleWindow    = 10 * time.Minute
+	defaultBlockDuration = time.Hour
+	geoHistoryWindow     = 30 * 24 * time.Hour
+)
+
+// DefaultIntrusionRules are used when no rules are configured.
+var DefaultIntrusionRules = []IntrusionRule{
+	{
+		Name:        PatternCredentialStuffing,
+		Description: "Failed logins for many usernames from one address",
+		Actions:     []string{IntrusionActionBlockIP, IntrusionActionNotify},
+		Threshold:   5,
+		Window:      10 * time.Minute,
+	},
+	{
+		Name:        PatternTokenScanning,
+		Description: "Repeated invalid tokens from one address",
+		Actions:     []string{IntrusionActionBlockIP, IntrusionActionNotify},
+		Threshold:   20,
+		Window:      10 * time.Minute,
+	},
+	{
+		Name:        PatternPathFuzzing,
+		Description: "Requests for many nonexistent paths from one address",
+		Actions:     []string{IntrusionActionBlockIP},
+		Threshold:   50,
+		Window:      5 * time.Minute,
+	},
+	{
+		Name:        PatternGeoAnomaly,
+		Description: "Login from a new country",
+		Actions:     []string{IntrusionActionNotify},
+		Threshold:   1,
+	},
+}
+
+// IPBlocker temporarily blocks client addresses.
+type IPBlocker interface {
+	BlockIP(ip string, duration time.Duration, reason string)
+}
+
+// GeoResolver resolves the country code of an address, or "" if unknown.
+type GeoResolver interface {
+	Country(ip string) string
+}
+
+// SetAuditService sets the audit service that stores access attempts and
+// intrusion events.
+func (s *IntrusionService) SetAuditService(auditSvc *AuditService) {
+	s.auditService = auditSvc
+}
+
+// SetIPBlocker sets the blocker used by the block_ip action.
+func (s *IntrusionService) SetIPBlocker(blocker IPBlocker) {
+	s.ipBlocker = blocker
+}
+
+// SetNotifier sets the function used by the notify action.
+func (s *IntrusionService) SetNotifier(notifier func(level, title, message string)) {
+	s.notifier = notifier
+}
+
+// SetGeoResolver sets the resolver used by geo_anomaly rules.
+func (s *IntrusionService) SetGeoResolver(geo GeoResolver) {
+	s.geo = geo
+}
+
+// Rules returns the active rules.
+func (s *IntrusionService) Rules() []IntrusionRule {
+	if len(s.config.Rules) > 0 {
+		return s.config.Rules
+	}
+	return DefaultIntrusionRules
+}
+
+// RecordAttempt stores an access attempt and evaluates the rules for it in
+// the background.
+func (s *IntrusionService) RecordAttempt(attempt *AccessAttempt) {
+	if attempt.CreatedAt.IsZero() {
+		attempt.CreatedAt = time.Now()
+	}
+	if s.auditService != nil {
+		s.auditService.LogAccessAttempt(attempt)
+	}
+	if !s.config.Enabled || !s.config.RealTimeMonitoring {
+		return
+	}
+
+	select {
+	case s.evalQueue <- attempt:
+	default:
+		// 队列已满时丢弃，避免拖慢请求
+		if s.logger != nil {
+			s.logger.Warn("入侵检测队列已满，跳过规则评估", zap.String("ip", attempt.IPAddress))
+		}
+	}
+}
+
+func (s *IntrusionService) runEvaluator() {
+	for attempt := range s.evalQueue {
+		s.evaluate(attempt)
+	}
+}
+
+// evaluate 对一次访问记录评估所有规则
+func (s *IntrusionService) evaluate(attempt *AccessAttempt) {
+	if dao.GetDB() == nil {
+		return
+	}
+	for _, rule := range s.Rules() {
+		evidence, err := s.matchRule(&rule, attempt)
+		if err != nil {
+			if s.logger != nil {
+				s.logger.Warn("入侵检测规则评估失败", zap.String("rule", rule.Name), zap.Error(err))
+			}
+			continue
+		}
+		if evidence != "" {
+			s.trigger(&rule, attempt, evidence)
+		}
+	}
+}
+
+// matchRule 返回规则命中的依据，未命中时返回空字符串
+func (s *IntrusionService) matchRule(rule *IntrusionRule, a *AccessAttempt) (string, error) {
+	pattern := rule.Pattern
+	if pattern == "" {
+		pattern = rule.Name
+	}
+	window := rule.Window
+	if window <= 0 {
+		window = defaultRuleWindow
+	}
+	threshold := rule.Threshold
+	if threshold < 1 {
+		threshold = 1
+	}
+	since := time.Now().Add(-window)
+
+	switch pattern {
+	case PatternCredentialStuffing:
+		if a.Action != AttemptLogin || a.Status != "failure" {
+			return "", nil
+		}
+		return countAttempts(a.IPAddress, AttemptLogin, "failure", since, threshold, true, "usernames", window)
+
+	case PatternLoginFailure:
+		if a.Action != AttemptLogin || a.Status != "failure" {
+			return "", nil
+		}
+		return countAttempts(a.IPAddress, AttemptLogin, "failure", since, threshold, false, "failed logins", window)
+
+	case PatternTokenScanning:
+		if a.Action != AttemptToken || a.Status != "failure" {
+			return "", nil
+		}
+		return countAttempts(a.IPAddress, AttemptToken, "failure", since, threshold, false, "invalid tokens", window)
+
+	case PatternPathFuzzing:
+		if a.Action != AttemptNotFound {
+			return "", nil
+		}
+		return countAttempts(a.IPAddress, AttemptNotFound, "", since, threshold, true, "missing paths", window)
+
+	case PatternGeoAnomaly:
+		if a.Action != AttemptLogin || a.Status != "success" {
+			return "", nil
+		}
+		return s.geoAnomaly(rule, a)
+	}
+
+	// 其他规则按错误码计数，如 forged_jwt、token_replay
+	if a.ErrorMsg != pattern {
+		return "", nil
+	}
+	attempts, err := dao.ListAccessAttemptsSince(a.IPAddress, "", "", since)
+	if err != nil {
+		return "", err
+	}
+	n := 0
+	for _, at := range attempts {
+		if at.ErrorMsg == pattern {
+			n++
+		}
+	}
+	if n < threshold {
+		return "", nil
+	}
+	return fmt.Sprintf("%d %s attempts in %s", n, pattern, window), nil
+}
+
+// countAttempts 统计窗口内的访问记录，distinct 为 true 时按资源去重计数
+func countAttempts(ip, action, status string, since time.Time, threshold int, distinct bool, what string, window time.Duration) (string, error) {
+	attempts, err := dao.ListAccessAttemptsSince(ip, action, status, since)
+	if err != nil {
+		return "", err
+	}
+
+	n := len(attempts)
+	if distinct {
+		seen := make(map[string]bool)
+		for _, at := range attempts {
+			seen[at.Resource] = true
+		}
+		n = len(seen)
+	}
+	if n < threshold {
+		return "", nil
+	}
+	return fmt.Sprintf("%d %s in %s", n, what, window), nil
+}
+
+// geoAnomaly 检查登录时段与来源国家
+func (s *IntrusionService) geoAnomaly(rule *IntrusionRule, a *AccessAttempt) (string, error) {
+	if rule.AllowedHours != "" {
+		if ok, err := withinHours(rule.AllowedHours, a.CreatedAt); err == nil && !ok {
+			return fmt.Sprintf("login at %s outside allowed hours %s", a.CreatedAt.Format("15:04"), rule.AllowedHours), nil
+		}
+	}
+
+	if s.geo == nil {
+		return "", nil
+	}
+	country := s.geo.Country(a.IPAddress)
+	if country == "" {
+		return "", nil
+	}
+
+	if len(rule.AllowedCountries) > 0 {
+		for _, c := range rule.AllowedCountries {
+			if strings.EqualFold(c, country) {
+				return "", nil
+			}
+		}
+		return fmt.Sprintf("login from %s, allowed: %s", country, strings.Join(rule.AllowedCountries, ",")), nil
+	}
+
+	// 未限定国家时，与该用户近期登录的国家比较
+	if a.UserID == 0 {
+		return "", nil
+	}
+	ips, err := dao.ListUserLoginIPs(a.UserID, time.Now().Add(-geoHistoryWindow), a.IPAddress)
+	if err != nil {
+		return "", err
+	}
+	known := make(map[string]bool)
+	for _, ip := range ips {
+		if c := s.geo.Country(ip); c != "" {
+			known[c] = true
+		}
+	}
+	if len(known) == 0 || known[country] {
+		return "", nil
+	}
+	previous := make([]string, 0, len(known))
+	for c := range known {
+		previous = append(previous, c)
+	}
+	sort.Strings(previous)
+	return fmt.Sprintf("login from new country %s, previously %s", country, strings.Join(previous, ",")), nil
+}
+
+// withinHours 判断时间是否在 "08-20" 形式的时段内，结束时间可小于开始时间表示跨午夜
+func withinHours(hours string, t time.Time) (bool, error) {
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return false, fmt.Errorf("invalid hours %q", hours)
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(from))
+	if err != nil {
+		return false, err
+	}
+	end, err := strconv.Atoi(strings.TrimSpace(to))
+	if err != nil {
+		return false, err
+	}
+
+	h := t.Hour()
+	if start <= end {
+		return h >= start && h < end, nil
+	}
+	return h >= start || h < end, nil
+}
+
+// trigger 执行规则的动作，同一规则对同一 IP 在统计窗口内只触发一次
+func (s *IntrusionService) trigger(rule *IntrusionRule, a *AccessAttempt, evidence string) {
+	window := rule.Window
+	if window <= 0 {
+		window = defaultRuleWindow
+	}
+	key := rule.Name + "|" + a.IPAddress
+	now := time.Now()
+	s.firedMu.Lock()
+	if last, ok := s.fired[key]; ok && now.Sub(last) < window {
+		s.firedMu.Unlock()
+		return
+	}
+	s.fired[key] = now
+	for k, t := range s.fired {
+		if now.Sub(t) > 24*time.Hour {
+			delete(s.fired, k)
+		}
+	}
+	s.firedMu.Unlock()
+
+	actions := ruleActions(rule)
+	message := fmt.Sprintf("规则 %s 被触发：%s（来源 %s）", rule.Name, evidence, a.IPAddress)
+	notified := false
+
+	for _, action := range actions {
+		switch action {
+		case IntrusionActionLock:
+			if s.lockService != nil {
+				s.lockService.LockSystem("intrusion_detected: "+rule.Name, a.IPAddress)
+			}
+		case IntrusionActionBlockIP:
+			if s.ipBlocker != nil {
+				duration := rule.BlockDuration
+				if duration <= 0 {
+					duration = s.config.LockDuration
+				}
+				if duration <= 0 {
+					duration = defaultBlockDuration
+				}
+				s.ipBlocker.BlockIP(a.IPAddress, duration, rule.Name)
+			}
+		case IntrusionActionNotify:
+			s.notify(message)
+			notified = true
+		}
+	}
+	if !notified && s.config.NotifyOnLock && containsString(actions, IntrusionActionLock) {
+		s.notify(message)
+	}
+
+	s.logIntrusion(a.IPAddress, rule.Name, strings.Join(actions, ","))
+	if s.auditService != nil {
+		s.auditService.LogAuditEvent(&AuditLog{
+			Level:     "warn",
+			Event:     "intrusion_detected",
+			UserID:    a.UserID,
+			IPAddress: a.IPAddress,
+			Resource:  rule.Name,
+			Action:    strings.Join(actions, ","),
+			Status:    "triggered",
+			Details: map[string]interface{}{
+				"evidence":    evidence,
+				"description": rule.Description,
+			},
+		})
+	}
+}
+
+func (s *IntrusionService) notify(message string) {
+	if s.notifier != nil {
+		s.notifier("warning", "检测到入侵行为", message)
+	}
+}
+
+// ruleActions 返回规则的动作，兼容旧配置中的 warn 与 ban
+func ruleActions(rule *IntrusionRule) []string {
+	raw := rule.Actions
+	if len(raw) == 0 && rule.Action != "" {
+		raw = []string{rule.Action}
+	}
+
+	var actions []string
+	for _, action := range raw {
+		switch strings.ToLower(strings.TrimSpace(action)) {
+		case "lock":
+			action = IntrusionActionLock
+		case "block_ip", "ban", "block":
+			action = IntrusionActionBlockIP
+		case "notify", "warn":
+			action = IntrusionActionNotify
+		default:
+			continue
+		}
+		if !containsString(actions, action) {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// CIDRGeoResolver resolves countries from a file with one "CIDR,country"
+// entry per line, e.g. an export of a GeoIP database.
+type CIDRGeoResolver struct {
+	entries []geoEntry
+}
+
+type geoEntry struct {
+	network *net.IPNet
+	country string
+}
+
+// LoadCIDRGeoResolver loads a CIDR to country file. Empty lines and lines
+// starting with # are ignored.
+func LoadCIDRGeoResolver(path string) (*CIDRGeoResolver, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &CIDRGeoResolver{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cidr, country, ok := strings.Cut(text, ",")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected CIDR,country", path, line)
+		}
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		r.entries = append(r.entries, geoEntry{network: network, country: strings.ToUpper(strings.TrimSpace(country))})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// 更长的前缀优先匹配
+	sort.SliceStable(r.entries, func(i, j int) bool {
+		oi, _ := r.entries[i].network.Mask.Size()
+		oj, _ := r.entries[j].network.Mask.Size()
+		return oi > oj
+	})
+	return r, nil
+}
+
+// Country returns the country code of an address.
+func (r *CIDRGeoResolver) Country(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+	for _, e := range r.entries {
+		if e.network.Contains(addr) {
+			return e.country
+		}
+	}
+	return ""
+}
@@ -14,6 +14,15 @@ type IntrusionService struct {
 	attemptStore sync.Map // map[ip]*AttemptInfo
 	lockService  *LockService
 	logger       *zap.Logger
+
+	// 规则引擎
+	auditService *AuditService
+	ipBlocker    IPBlocker
+	notifier     func(level, title, message string)
+	geo          GeoResolver
+	evalQueue    chan *AccessAttempt
+	firedMu      sync.Mutex
+	fired        map[string]time.Time // rule|ip -> 最近一次触发时间
 }
 
 // IntrusionConfig holds intrusion detection configuration.
@@ -33,12 +42,16 @@ type IntrusionConfig struct {
 
 // IntrusionRule represents an intrusion detection rule.
 type IntrusionRule struct {
-	Name             string   `json:"name" yaml:"name"`
-	Description      string   `json:"description" yaml:"description"`
-	Action           string   `json:"action" yaml:"action"` // lock, warn, ban
-	Threshold        int      `json:"threshold" yaml:"threshold"`
-	AllowedHours     string   `json:"allowed_hours,omitempty" yaml:"allowed_hours"`
-	AllowedCountries []string `json:"allowed_countries,omitempty" yaml:"allowed_countries"`
+	Name             string        `json:"name" yaml:"name"`
+	Pattern          string        `json:"pattern,omitempty" yaml:"pattern"` // 为空时与 Name 相同
+	Description      string        `json:"description" yaml:"description"`
+	Action           string        `json:"action" yaml:"action"` // lock, warn, ban
+	Actions          []string      `json:"actions,omitempty" yaml:"actions"`
+	Threshold        int           `json:"threshold" yaml:"threshold"`
+	Window           time.Duration `json:"window,omitempty" yaml:"window"`
+	BlockDuration    time.Duration `json:"block_duration,omitempty" yaml:"block_duration"`
+	AllowedHours     string        `json:"allowed_hours,omitempty" yaml:"allowed_hours"`
+	AllowedCountries []string      `json:"allowed_countries,omitempty" yaml:"allowed_countries"`
 }
 
 // AttemptInfo holds information about access attempts.
@@ -62,11 +75,15 @@ func NewIntrusionService(config *IntrusionConfig, lockService *LockService, logg
 		}
 	}
 
-	return &IntrusionService{
+	s := &IntrusionService{
 		config:      config,
 		lockService: lockService,
 		logger:      logger,
+		evalQueue:   make(chan *AccessAttempt, 1024),
+		fired:       make(map[string]time.Time),
 	}
+	go s.runEvaluator()
+	return s
 }
 
 // IncrementFailedAttempt increments the failed attempt count for an IP.
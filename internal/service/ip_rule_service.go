@@ -0,0 +1,461 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// IP rule actions.
+const (
+	IPRuleAllow = "allow"
+	IPRuleDeny  = "deny"
+)
+
+// IP rule scopes. Besides these, a scope may be a path prefix starting
+// with "/".
+const (
+	IPScopeGlobal   = "global"
+	IPScopeAPI      = "api"
+	IPScopeRegistry = "registry"
+	IPScopeAdmin    = "admin"
+)
+
+// IPRulesPath is the path of the IP rule management API. Changes that would
+// block the requester from it are rejected.
+const IPRulesPath = "/api/v1/security/ip-rules"
+
+// adminPathPrefixes 为 admin 范围覆盖的管理接口
+var adminPathPrefixes = []string{
+	"/api/v1/admin",
+	"/api/v1/system",
+	"/api/v1/audit",
+	"/api/v1/security",
+	"/api/v1/credentials",
+	"/api/v1/workflows",
+	"/api/v1/jobs",
+	"/api/v1/sync",
+	"/api/system",
+	"/api/update",
+}
+
+// IP rule errors.
+var (
+	ErrIPRuleNotFound = errors.New("ip rule not found")
+	ErrInvalidIPRule  = errors.New("invalid ip rule")
+	ErrIPRuleLockout  = errors.New("the change would block your own address from managing ip rules")
+)
+
+// IPRule is a CIDR allow or deny rule.
+type IPRule struct {
+	ID          int64      `json:"id,omitempty"`
+	Action      string     `json:"action"`
+	CIDR        string     `json:"cidr"`
+	Scope       string     `json:"scope"`
+	Description string     `json:"description,omitempty"`
+	Source      string     `json:"source"` // config 或 api
+	CreatedBy   string     `json:"created_by,omitempty"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+}
+
+// IPRuleRequest represents a request to create or update an IP rule.
+type IPRuleRequest struct {
+	Action      string `json:"action" binding:"required"`
+	CIDR        string `json:"cidr" binding:"required"`
+	Scope       string `json:"scope"`
+	Description string `json:"description"`
+}
+
+// compiledIPRule 为解析后的规则
+type compiledIPRule struct {
+	*IPRule
+	network *net.IPNet
+}
+
+// IPRuleService evaluates client addresses against the configured and
+// runtime IP rules. Rules are kept in memory and reloaded on every change,
+// so changes take effect immediately.
+type IPRuleService struct {
+	logger *zap.Logger
+
+	mu          sync.RWMutex
+	staticRules []*compiledIPRule
+	rules       []*compiledIPRule
+	blocks      map[string]*IPBlock // 入侵检测添加的临时封禁
+}
+
+// IPBlock is a temporary block of a single address.
+type IPBlock struct {
+	IP        string    `json:"ip"`
+	Reason    string    `json:"reason"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewIPRuleService creates a new IPRuleService instance.
+func NewIPRuleService(logger *zap.Logger) *IPRuleService {
+	return &IPRuleService{
+		logger: logger,
+		blocks: make(map[string]*IPBlock),
+	}
+}
+
+// SetStaticRules sets the read-only rules of the configuration file. Invalid
+// rules are skipped and reported in the returned error.
+func (s *IPRuleService) SetStaticRules(rules []*IPRule) error {
+	var compiled []*compiledIPRule
+	var errs []error
+	for _, rule := range rules {
+		rule.Source = "config"
+		c, err := compileIPRule(rule)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+
+	s.mu.Lock()
+	s.staticRules = compiled
+	s.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// Reload reloads the runtime rules from the database.
+func (s *IPRuleService) Reload() error {
+	records, err := dao.ListIPRules()
+	if err != nil {
+		return err
+	}
+
+	var compiled []*compiledIPRule
+	for _, r := range records {
+		c, err := compileIPRule(convertIPRule(r))
+		if err != nil {
+			// 数据库中的规则在写入时已校验，这里只记录日志
+			if s.logger != nil {
+				s.logger.Warn("忽略无效的IP规则", zap.Int64("id", r.ID), zap.Error(err))
+			}
+			continue
+		}
+		compiled = append(compiled, c)
+	}
+
+	s.mu.Lock()
+	s.rules = compiled
+	s.mu.Unlock()
+	return nil
+}
+
+// ListRules lists the configured and runtime rules.
+func (s *IPRuleService) ListRules() []*IPRule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rules := make([]*IPRule, 0, len(s.staticRules)+len(s.rules))
+	for _, r := range s.staticRules {
+		rules = append(rules, r.IPRule)
+	}
+	for _, r := range s.rules {
+		rules = append(rules, r.IPRule)
+	}
+	return rules
+}
+
+// CreateRule adds a runtime rule. actorIP is the address of the requester,
+// which must still be able to reach the rule API afterwards.
+func (s *IPRuleService) CreateRule(req *IPRuleRequest, createdBy, actorIP string) (*IPRule, error) {
+	rule := &IPRule{
+		Action:      req.Action,
+		CIDR:        req.CIDR,
+		Scope:       req.Scope,
+		Description: req.Description,
+		Source:      "api",
+		CreatedBy:   createdBy,
+	}
+	c, err := compileIPRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkLockout(actorIP, c, 0); err != nil {
+		return nil, err
+	}
+
+	record := &dao.IPRule{
+		Action:      rule.Action,
+		CIDR:        rule.CIDR,
+		Scope:       rule.Scope,
+		Description: rule.Description,
+		CreatedBy:   createdBy,
+	}
+	if err := dao.CreateIPRule(record); err != nil {
+		return nil, err
+	}
+	rule.ID = record.ID
+	rule.CreatedAt = &record.CreatedAt
+
+	s.logInfo("IP规则已添加", zap.String("action", rule.Action), zap.String("cidr", rule.CIDR), zap.String("scope", rule.Scope))
+	return rule, s.Reload()
+}
+
+// UpdateRule changes a runtime rule.
+func (s *IPRuleService) UpdateRule(id int64, req *IPRuleRequest, actorIP string) (*IPRule, error) {
+	record, err := dao.GetIPRule(id)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrIPRuleNotFound
+	}
+
+	rule := convertIPRule(record)
+	rule.Action = req.Action
+	rule.CIDR = req.CIDR
+	rule.Scope = req.Scope
+	rule.Description = req.Description
+	c, err := compileIPRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkLockout(actorIP, c, id); err != nil {
+		return nil, err
+	}
+
+	record.Action = rule.Action
+	record.CIDR = rule.CIDR
+	record.Scope = rule.Scope
+	record.Description = rule.Description
+	if err := dao.UpdateIPRule(record); err != nil {
+		return nil, err
+	}
+
+	s.logInfo("IP规则已更新", zap.Int64("id", id), zap.String("action", rule.Action), zap.String("cidr", rule.CIDR))
+	return rule, s.Reload()
+}
+
+// DeleteRule deletes a runtime rule and returns it.
+func (s *IPRuleService) DeleteRule(id int64, actorIP string) (*IPRule, error) {
+	record, err := dao.GetIPRule(id)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrIPRuleNotFound
+	}
+	if err := s.checkLockout(actorIP, nil, id); err != nil {
+		return nil, err
+	}
+
+	if err := dao.DeleteIPRule(id); err != nil {
+		return nil, err
+	}
+
+	s.logInfo("IP规则已删除", zap.Int64("id", id), zap.String("cidr", record.CIDR))
+	return convertIPRule(record), s.Reload()
+}
+
+// Evaluate reports whether a client address may access a path. When it is
+// blocked, the reason names the rule or scope responsible.
+func (s *IPRuleService) Evaluate(ip, path string) (bool, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if b, ok := s.blocks[ip]; ok && time.Now().Before(b.ExpiresAt) {
+		return false, "temporarily blocked: " + b.Reason
+	}
+	return evaluateIPRules(s.allRules(), ip, path)
+}
+
+// BlockIP blocks an address on all paths for a duration.
+func (s *IPRuleService) BlockIP(ip string, duration time.Duration, reason string) {
+	now := time.Now()
+	s.mu.Lock()
+	for addr, b := range s.blocks {
+		if now.After(b.ExpiresAt) {
+			delete(s.blocks, addr)
+		}
+	}
+	s.blocks[ip] = &IPBlock{IP: ip, Reason: reason, ExpiresAt: now.Add(duration)}
+	s.mu.Unlock()
+
+	if s.logger != nil {
+		s.logger.Warn("IP已被临时封禁", zap.String("ip", ip), zap.String("reason", reason), zap.Duration("duration", duration))
+	}
+}
+
+// ListBlocks lists the active temporary blocks.
+func (s *IPRuleService) ListBlocks() []*IPBlock {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	blocks := make([]*IPBlock, 0, len(s.blocks))
+	for _, b := range s.blocks {
+		if now.Before(b.ExpiresAt) {
+			blocks = append(blocks, b)
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].ExpiresAt.Before(blocks[j].ExpiresAt) })
+	return blocks
+}
+
+// Unblock lifts a temporary block. It reports whether the address was
+// blocked.
+func (s *IPRuleService) Unblock(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.blocks[ip]
+	delete(s.blocks, ip)
+	return ok && time.Now().Before(b.ExpiresAt)
+}
+
+// checkLockout 检查将 id 对应的规则替换为 changed（为 nil 时表示删除）后，
+// 操作者是否仍能访问规则管理接口
+func (s *IPRuleService) checkLockout(actorIP string, changed *compiledIPRule, id int64) error {
+	if actorIP == "" {
+		return nil
+	}
+
+	s.mu.RLock()
+	var candidate []*compiledIPRule
+	candidate = append(candidate, s.staticRules...)
+	for _, r := range s.rules {
+		if id == 0 || r.ID != id {
+			candidate = append(candidate, r)
+		}
+	}
+	s.mu.RUnlock()
+	if changed != nil {
+		candidate = append(candidate, changed)
+	}
+
+	if ok, _ := evaluateIPRules(candidate, actorIP, IPRulesPath); !ok {
+		return ErrIPRuleLockout
+	}
+	return nil
+}
+
+func (s *IPRuleService) allRules() []*compiledIPRule {
+	rules := make([]*compiledIPRule, 0, len(s.staticRules)+len(s.rules))
+	rules = append(rules, s.staticRules...)
+	return append(rules, s.rules...)
+}
+
+func (s *IPRuleService) logInfo(msg string, fields ...zap.Field) {
+	if s.logger != nil {
+		s.logger.Info(msg, fields...)
+	}
+}
+
+// evaluateIPRules 依次检查全局范围和路径匹配的各个范围：命中 deny 规则即拒绝；
+// 某个范围存在 allow 规则时，地址必须命中其中之一
+func evaluateIPRules(rules []*compiledIPRule, ip, path string) (bool, string) {
+	if len(rules) == 0 {
+		return true, ""
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return true, ""
+	}
+
+	allowlists := map[string]bool{}
+	for _, r := range rules {
+		if !ipScopeMatches(r.Scope, path) {
+			continue
+		}
+		matched := r.network.Contains(addr)
+		if r.Action == IPRuleDeny {
+			if matched {
+				return false, fmt.Sprintf("denied by %s rule %s (scope %s)", r.Source, r.CIDR, r.Scope)
+			}
+			continue
+		}
+		allowlists[r.Scope] = allowlists[r.Scope] || matched
+	}
+
+	for scope, allowed := range allowlists {
+		if !allowed {
+			return false, fmt.Sprintf("not in the allowlist of scope %s", scope)
+		}
+	}
+	return true, ""
+}
+
+// ipScopeMatches reports whether a rule scope covers a request path.
+func ipScopeMatches(scope, path string) bool {
+	switch scope {
+	case IPScopeGlobal:
+		return true
+	case IPScopeAPI:
+		return strings.HasPrefix(path, "/api/")
+	case IPScopeRegistry:
+		return path == "/v2" || strings.HasPrefix(path, "/v2/")
+	case IPScopeAdmin:
+		for _, prefix := range adminPathPrefixes {
+			if path == prefix || strings.HasPrefix(path, prefix+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	return strings.HasPrefix(path, scope)
+}
+
+// compileIPRule 校验并解析规则，单个 IP 视为 /32 或 /128
+func compileIPRule(rule *IPRule) (*compiledIPRule, error) {
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	rule.CIDR = strings.TrimSpace(rule.CIDR)
+	rule.Scope = strings.TrimSpace(rule.Scope)
+	if rule.Scope == "" {
+		rule.Scope = IPScopeGlobal
+	}
+
+	if rule.Action != IPRuleAllow && rule.Action != IPRuleDeny {
+		return nil, fmt.Errorf("%w: action must be allow or deny", ErrInvalidIPRule)
+	}
+	switch rule.Scope {
+	case IPScopeGlobal, IPScopeAPI, IPScopeRegistry, IPScopeAdmin:
+	default:
+		if !strings.HasPrefix(rule.Scope, "/") {
+			return nil, fmt.Errorf("%w: scope must be global, api, registry, admin or a path prefix", ErrInvalidIPRule)
+		}
+	}
+
+	cidr := rule.CIDR
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: invalid address %s", ErrInvalidIPRule, rule.CIDR)
+		}
+		if ip.To4() != nil {
+			cidr += "/32"
+		} else {
+			cidr += "/128"
+		}
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CIDR %s", ErrInvalidIPRule, rule.CIDR)
+	}
+	return &compiledIPRule{IPRule: rule, network: network}, nil
+}
+
+func convertIPRule(r *dao.IPRule) *IPRule {
+	return &IPRule{
+		ID:          r.ID,
+		Action:      r.Action,
+		CIDR:        r.CIDR,
+		Scope:       r.Scope,
+		Description: r.Description,
+		Source:      "api",
+		CreatedBy:   r.CreatedBy,
+		CreatedAt:   &r.CreatedAt,
+	}
+}
@@ -0,0 +1,147 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// JWTSecretEnv names the environment variable holding the JWT secret.
+const JWTSecretEnv = "CYP_JWT_SECRET"
+
+// minJWTSecretLength 为配置的 JWT 密钥的最小长度
+const minJWTSecretLength = 32
+
+// weakJWTSecrets 为曾经硬编码或出现在示例配置中的密钥，拒绝使用
+var weakJWTSecrets = []string{
+	"cyp-registry-secret-key",
+	"your-super-secret-key-change-in-production",
+}
+
+// JWT secret errors.
+var (
+	ErrNoJWTSecret   = errors.New("no jwt secret configured")
+	ErrWeakJWTSecret = errors.New("jwt secret is too weak")
+)
+
+// JWTKey is an HMAC secret used to sign or verify session tokens. The ID is
+// sent as the kid header so tokens keep verifying after a rotation.
+type JWTKey struct {
+	ID     string
+	Secret []byte
+}
+
+// JWTKeyConfig describes where the JWT secrets come from.
+type JWTKeyConfig struct {
+	Secret     string   // 当前签名密钥，为空时依次使用环境变量和密钥文件
+	KeyID      string   // 当前密钥的 kid，为空时由密钥摘要生成
+	SecretFile string   // 自动生成的密钥保存位置
+	Previous   []JWTKey // 轮换前的密钥，只用于验证
+
+	// Generate allows generating the secret file on first boot. It is off
+	// in production mode, where a missing secret is an error.
+	Generate bool
+}
+
+// LoadJWTKeys returns the signing key followed by the keys that are only
+// accepted for verification. The signing secret is taken from the config,
+// then the CYP_JWT_SECRET environment variable, then the secret file, which
+// is generated when allowed and missing.
+func LoadJWTKeys(cfg JWTKeyConfig) ([]JWTKey, error) {
+	secret := cfg.Secret
+	if secret == "" {
+		secret = os.Getenv(JWTSecretEnv)
+	}
+	if secret != "" {
+		if err := checkJWTSecret(secret); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if secret, err = loadOrCreateJWTSecret(cfg.SecretFile, cfg.Generate); err != nil {
+			return nil, err
+		}
+	}
+
+	keyID := cfg.KeyID
+	if keyID == "" {
+		keyID = jwtKeyID(secret)
+	}
+	keys := []JWTKey{{ID: keyID, Secret: []byte(secret)}}
+
+	seen := map[string]bool{keyID: true}
+	for _, key := range cfg.Previous {
+		if err := checkJWTSecret(string(key.Secret)); err != nil {
+			return nil, fmt.Errorf("previous secret %q: %w", key.ID, err)
+		}
+		if key.ID == "" {
+			key.ID = jwtKeyID(string(key.Secret))
+		}
+		if seen[key.ID] {
+			return nil, fmt.Errorf("duplicate jwt key id %q", key.ID)
+		}
+		seen[key.ID] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// checkJWTSecret rejects short secrets and known defaults.
+func checkJWTSecret(secret string) error {
+	for _, weak := range weakJWTSecrets {
+		if secret == weak {
+			return fmt.Errorf("%w: the example secret must be changed", ErrWeakJWTSecret)
+		}
+	}
+	if len(secret) < minJWTSecretLength {
+		return fmt.Errorf("%w: at least %d characters are required", ErrWeakJWTSecret, minJWTSecretLength)
+	}
+	return nil
+}
+
+// loadOrCreateJWTSecret reads the secret file, generating a random secret
+// when it does not exist yet and generate is set.
+func loadOrCreateJWTSecret(path string, generate bool) (string, error) {
+	if path == "" {
+		return "", ErrNoJWTSecret
+	}
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if secret := strings.TrimSpace(string(data)); secret != "" {
+			return secret, checkJWTSecret(secret)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read jwt secret: %w", err)
+	}
+	if !generate {
+		return "", ErrNoJWTSecret
+	}
+
+	buf := make([]byte, 48)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", fmt.Errorf("failed to generate jwt secret: %w", err)
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create jwt secret directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(secret+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write jwt secret: %w", err)
+	}
+	return secret, nil
+}
+
+// jwtKeyID derives a key ID that does not reveal the secret.
+func jwtKeyID(secret string) string {
+	sum := sha256.Sum256([]byte("cyp-jwt-kid:" + secret))
+	return hex.EncodeToString(sum[:8])
+}
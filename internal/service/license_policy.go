@@ -0,0 +1,678 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// 许可证策略模式
+const (
+	LicenseModeWarn  = "warn"  // 只标记不合规的镜像
+	LicenseModeBlock = "block" // 拒绝拉取不合规的镜像
+)
+
+// 软件包许可证的检查结果
+const (
+	LicenseAllowed    = "allowed"
+	LicenseForbidden  = "forbidden"   // 命中禁止列表
+	LicenseNotAllowed = "not_allowed" // 设置了允许列表但不在其中
+	LicenseUnknown    = "unknown"     // SBOM 中没有许可证信息
+)
+
+// 镜像的合规状态
+const (
+	ComplianceCompliant = "compliant"
+	ComplianceViolation = "violation"
+	ComplianceNoPolicy  = "no_policy"
+)
+
+// maxLicenseAlternatives bounds the alternatives an expression expands to,
+// long AND chains of ORs would otherwise grow exponentially.
+const maxLicenseAlternatives = 64
+
+var (
+	ErrLicensePolicyNotFound = errors.New("license policy not found")
+	ErrInvalidLicensePolicy  = errors.New("invalid license policy")
+	ErrLicenseViolation      = errors.New("image contains forbidden licenses")
+)
+
+// LicensePolicy lists the licenses the images of an organization may and
+// may not contain. Entries are SPDX license IDs or patterns like GPL-*,
+// matched case-insensitively. An empty allowed list allows every license
+// that is not forbidden.
+type LicensePolicy struct {
+	OrgID       int64     `json:"org_id"`
+	Org         string    `json:"org"`
+	Mode        string    `json:"mode"`
+	Allowed     []string  `json:"allowed"`
+	Forbidden   []string  `json:"forbidden"`
+	DenyUnknown bool      `json:"deny_unknown"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// LicensePolicyRequest sets the license policy of an organization.
+type LicensePolicyRequest struct {
+	Mode        string   `json:"mode"`
+	Allowed     []string `json:"allowed"`
+	Forbidden   []string `json:"forbidden"`
+	DenyUnknown bool     `json:"deny_unknown"`
+}
+
+// PackageLicense is the license check of a package.
+type PackageLicense struct {
+	Name     string   `json:"name"`
+	Version  string   `json:"version"`
+	Type     string   `json:"type"`
+	License  string   `json:"license"`            // SBOM 中的原始表达式
+	Licenses []string `json:"licenses,omitempty"` // 解析出的许可证
+	Status   string   `json:"status"`
+}
+
+// LicenseReport is the license compliance of an image.
+type LicenseReport struct {
+	ImageRef   string           `json:"image_ref"`
+	Digest     string           `json:"digest,omitempty"`
+	Org        string           `json:"org,omitempty"`
+	Mode       string           `json:"mode,omitempty"`
+	Status     string           `json:"status"`
+	Packages   int              `json:"packages"`
+	Licenses   map[string]int   `json:"licenses"` // 许可证 -> 软件包数
+	Violations []PackageLicense `json:"violations"`
+	Unknown    []PackageLicense `json:"unknown"`
+	CheckedAt  time.Time        `json:"checked_at"`
+}
+
+// OrgLicenseReport is the license compliance of the images of an
+// organization.
+type OrgLicenseReport struct {
+	Org       string           `json:"org"`
+	Policy    *LicensePolicy   `json:"policy,omitempty"`
+	Summary   map[string]int   `json:"summary"` // 合规状态 -> 镜像数
+	Licenses  map[string]int   `json:"licenses"`
+	Images    []*LicenseReport `json:"images"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+// LicenseService checks the package licenses recorded in SBOMs against the
+// license policies of organizations.
+type LicenseService struct {
+	sbom   *SBOMService
+	orgs   *OrgService
+	logger *zap.Logger
+
+	mu       sync.RWMutex
+	policies map[int64]*LicensePolicy // 组织 ID -> 策略
+}
+
+// NewLicenseService creates a new LicenseService instance and loads the
+// stored policies.
+func NewLicenseService(sbom *SBOMService, orgs *OrgService, logger *zap.Logger) *LicenseService {
+	s := &LicenseService{
+		sbom:     sbom,
+		orgs:     orgs,
+		logger:   logger,
+		policies: make(map[int64]*LicensePolicy),
+	}
+	if dao.GetDB() == nil {
+		return s
+	}
+	records, err := dao.ListLicensePolicies()
+	if err != nil {
+		if logger != nil {
+			logger.Error("加载许可证策略失败", zap.Error(err))
+		}
+		return s
+	}
+	for _, record := range records {
+		org, err := dao.GetOrganization(record.OrgID)
+		if err != nil || org == nil {
+			continue
+		}
+		s.policies[org.ID] = licensePolicy(record, org.Name)
+	}
+	return s
+}
+
+// licensePolicy converts a stored policy.
+func licensePolicy(p *dao.LicensePolicy, org string) *LicensePolicy {
+	return &LicensePolicy{
+		OrgID:       p.OrgID,
+		Org:         org,
+		Mode:        p.Mode,
+		Allowed:     append([]string{}, p.Allowed...),
+		Forbidden:   append([]string{}, p.Forbidden...),
+		DenyUnknown: p.DenyUnknown,
+		UpdatedBy:   p.UpdatedBy,
+		UpdatedAt:   p.UpdatedAt,
+	}
+}
+
+// GetPolicy returns the license policy of an organization. Members of the
+// organization and system administrators may read it.
+func (s *LicenseService) GetPolicy(orgID, requestorID int64) (*LicensePolicy, error) {
+	org, err := s.readableOrg(orgID, requestorID)
+	if err != nil {
+		return nil, err
+	}
+	policy := s.orgPolicy(org.ID)
+	if policy == nil {
+		return nil, ErrLicensePolicyNotFound
+	}
+	return policy, nil
+}
+
+// SetPolicy creates or replaces the license policy of an organization,
+// which only its owners and admins may change.
+func (s *LicenseService) SetPolicy(orgID int64, req *LicensePolicyRequest, requestorID int64, requestor string) (*LicensePolicy, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	org, err := s.manageableOrg(orgID, requestorID)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = LicenseModeWarn
+	}
+	if mode != LicenseModeWarn && mode != LicenseModeBlock {
+		return nil, fmt.Errorf("%w: mode must be warn or block", ErrInvalidLicensePolicy)
+	}
+	allowed, err := licensePatterns(req.Allowed)
+	if err != nil {
+		return nil, err
+	}
+	forbidden, err := licensePatterns(req.Forbidden)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) == 0 && len(forbidden) == 0 && !req.DenyUnknown {
+		return nil, fmt.Errorf("%w: allowed or forbidden licenses are required", ErrInvalidLicensePolicy)
+	}
+
+	record := &dao.LicensePolicy{
+		OrgID:       org.ID,
+		Mode:        mode,
+		Allowed:     allowed,
+		Forbidden:   forbidden,
+		DenyUnknown: req.DenyUnknown,
+		UpdatedBy:   requestor,
+		UpdatedAt:   time.Now(),
+	}
+	if err := dao.UpsertLicensePolicy(record); err != nil {
+		return nil, err
+	}
+	policy := licensePolicy(record, org.Name)
+	s.mu.Lock()
+	s.policies[org.ID] = policy
+	s.mu.Unlock()
+
+	if s.logger != nil {
+		s.logger.Info("许可证策略已更新",
+			zap.String("org", org.Name),
+			zap.String("mode", mode),
+			zap.String("updated_by", requestor),
+		)
+	}
+	return policy, nil
+}
+
+// DeletePolicy removes the license policy of an organization and returns
+// it.
+func (s *LicenseService) DeletePolicy(orgID, requestorID int64) (*LicensePolicy, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	org, err := s.manageableOrg(orgID, requestorID)
+	if err != nil {
+		return nil, err
+	}
+	policy := s.orgPolicy(org.ID)
+	if policy == nil {
+		return nil, ErrLicensePolicyNotFound
+	}
+	if err := dao.DeleteLicensePolicy(org.ID); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	delete(s.policies, org.ID)
+	s.mu.Unlock()
+	return policy, nil
+}
+
+// ImageReport checks the licenses of an image against the policy of the
+// organization owning its repository. Images outside organizations, or of
+// organizations without a policy, report the licenses only.
+func (s *LicenseService) ImageReport(imageRef string) (*LicenseReport, error) {
+	sbom, err := s.sbom.GetSBOM(imageRef)
+	if err != nil {
+		return nil, err
+	}
+	return s.checkSBOM(sbom, s.policy(imageNamespace(imageRef))), nil
+}
+
+// OrgReport checks every image of an organization that has an SBOM.
+// Members of the organization and system administrators may read it.
+func (s *LicenseService) OrgReport(orgID, requestorID int64) (*OrgLicenseReport, error) {
+	org, err := s.readableOrg(orgID, requestorID)
+	if err != nil {
+		return nil, err
+	}
+	policy := s.orgPolicy(org.ID)
+	report := &OrgLicenseReport{
+		Org:       org.Name,
+		Policy:    policy,
+		Summary:   make(map[string]int),
+		Licenses:  make(map[string]int),
+		Images:    []*LicenseReport{},
+		CheckedAt: time.Now(),
+	}
+	for _, sbom := range s.sbom.AllSBOMs() {
+		if imageNamespace(sbom.ImageRef) != org.Name {
+			continue
+		}
+		image := s.checkSBOM(sbom, policy)
+		report.Summary[image.Status]++
+		for license, n := range image.Licenses {
+			report.Licenses[license] += n
+		}
+		report.Images = append(report.Images, image)
+	}
+	sort.Slice(report.Images, func(i, j int) bool {
+		return report.Images[i].ImageRef < report.Images[j].ImageRef
+	})
+	return report, nil
+}
+
+// CheckPull checks an image before it is pulled. Images that violate a
+// policy in block mode are refused, in warn mode they are logged. Images
+// without SBOM pass: the SBOM is generated after the push.
+func (s *LicenseService) CheckPull(repository, reference string) error {
+	policy := s.policy(imageNamespace(repository))
+	if policy == nil {
+		return nil
+	}
+	imageRef := repository + ":" + reference
+	sbom, err := s.sbom.GetSBOM(imageRef)
+	if err != nil {
+		return nil
+	}
+	report := s.checkSBOM(sbom, policy)
+	if report.Status != ComplianceViolation {
+		return nil
+	}
+
+	licenses := policy.violatingLicenses(report)
+	if policy.Mode != LicenseModeBlock {
+		if s.logger != nil {
+			s.logger.Warn("镜像包含不合规的许可证",
+				zap.String("image", imageRef),
+				zap.Strings("licenses", licenses),
+			)
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrLicenseViolation, strings.Join(licenses, ", "))
+}
+
+// policy returns the policy of the organization named namespace, or nil.
+// The name is looked up for every check, policies of deleted organizations
+// do not pass to a new one of the same name.
+func (s *LicenseService) policy(namespace string) *LicensePolicy {
+	s.mu.RLock()
+	empty := len(s.policies) == 0
+	s.mu.RUnlock()
+	if empty || namespace == "" || dao.GetDB() == nil {
+		return nil
+	}
+	org, err := dao.GetOrganizationByName(namespace)
+	if err != nil || org == nil {
+		return nil
+	}
+	return s.orgPolicy(org.ID)
+}
+
+// orgPolicy returns the policy of an organization, or nil.
+func (s *LicenseService) orgPolicy(orgID int64) *LicensePolicy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policies[orgID]
+}
+
+// readableOrg returns the organization if the requestor is a member or a
+// system administrator.
+func (s *LicenseService) readableOrg(orgID, requestorID int64) (*dao.Organization, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+	if org.OwnerID != requestorID && orgRole(orgID, requestorID) == "" && !isSystemAdmin(requestorID) {
+		return nil, errors.New("permission denied")
+	}
+	return org, nil
+}
+
+// manageableOrg returns the organization if the requestor can manage it.
+func (s *LicenseService) manageableOrg(orgID, requestorID int64) (*dao.Organization, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+	if !s.orgs.canManageOrg(org, requestorID) {
+		return nil, errors.New("permission denied")
+	}
+	return org, nil
+}
+
+// checkSBOM checks the packages of an SBOM against policy, which may be nil.
+func (s *LicenseService) checkSBOM(sbom *SBOM, policy *LicensePolicy) *LicenseReport {
+	report := &LicenseReport{
+		ImageRef:   sbom.ImageRef,
+		Digest:     sbom.Digest,
+		Org:        imageNamespace(sbom.ImageRef),
+		Status:     ComplianceNoPolicy,
+		Packages:   len(sbom.Packages),
+		Licenses:   make(map[string]int),
+		Violations: []PackageLicense{},
+		Unknown:    []PackageLicense{},
+		CheckedAt:  time.Now(),
+	}
+	if policy != nil {
+		report.Mode = policy.Mode
+		report.Status = ComplianceCompliant
+	} else {
+		report.Org = ""
+	}
+
+	for _, pkg := range sbom.Packages {
+		alternatives := parseLicenseExpression(pkg.License)
+		result := PackageLicense{
+			Name:     pkg.Name,
+			Version:  pkg.Version,
+			Type:     pkg.Type,
+			License:  pkg.License,
+			Licenses: expressionLicenses(alternatives),
+			Status:   LicenseAllowed,
+		}
+		if len(alternatives) == 0 {
+			result.Status = LicenseUnknown
+			report.Licenses[LicenseUnknown]++
+			report.Unknown = append(report.Unknown, result)
+			if policy != nil && policy.DenyUnknown {
+				report.Violations = append(report.Violations, result)
+			}
+			continue
+		}
+		for _, license := range result.Licenses {
+			report.Licenses[license]++
+		}
+		if policy == nil {
+			continue
+		}
+		result.Status = policy.check(alternatives)
+		if result.Status != LicenseAllowed {
+			report.Violations = append(report.Violations, result)
+		}
+	}
+
+	if policy != nil && len(report.Violations) > 0 {
+		report.Status = ComplianceViolation
+	}
+	return report
+}
+
+// check returns the status of a package whose license is one of the
+// alternatives; all licenses of an alternative apply together. The package
+// is allowed if any alternative is.
+func (p *LicensePolicy) check(alternatives [][]string) string {
+	best := ""
+	for _, alternative := range alternatives {
+		status := LicenseAllowed
+		for _, license := range alternative {
+			switch p.licenseStatus(license) {
+			case LicenseForbidden:
+				status = LicenseForbidden
+			case LicenseNotAllowed:
+				if status == LicenseAllowed {
+					status = LicenseNotAllowed
+				}
+			}
+		}
+		if status == LicenseAllowed {
+			return LicenseAllowed
+		}
+		if best == "" || status == LicenseNotAllowed {
+			best = status
+		}
+	}
+	return best
+}
+
+// licenseStatus checks a license, e.g. "MIT" or "GPL-2.0-only WITH
+// Classpath-exception-2.0". A license with exception matches the lists
+// first as a whole, then by its license ID; exact entries take precedence
+// over patterns, so GPL-2.0-only WITH Classpath-exception-2.0 can be
+// allowed while GPL-* is forbidden.
+func (p *LicensePolicy) licenseStatus(license string) string {
+	candidates := []string{license}
+	if id, _, ok := strings.Cut(license, " WITH "); ok {
+		candidates = append(candidates, id)
+	}
+	for _, candidate := range candidates {
+		for _, exact := range []bool{true, false} {
+			if matchLicense(p.Forbidden, candidate, exact) {
+				return LicenseForbidden
+			}
+			if matchLicense(p.Allowed, candidate, exact) {
+				return LicenseAllowed
+			}
+		}
+	}
+	if len(p.Allowed) > 0 {
+		return LicenseNotAllowed
+	}
+	return LicenseAllowed
+}
+
+// matchLicense reports whether a license equals one of the exact entries,
+// or matches one of the patterns.
+func matchLicense(entries []string, license string, exact bool) bool {
+	license = strings.ToLower(license)
+	for _, entry := range entries {
+		entry = strings.ToLower(entry)
+		isPattern := strings.ContainsAny(entry, "*?[")
+		switch {
+		case exact && !isPattern:
+			if entry == license {
+				return true
+			}
+		case !exact && isPattern:
+			if ok, _ := path.Match(entry, license); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// licensePatterns trims, deduplicates and validates policy entries.
+func licensePatterns(entries []string) ([]string, error) {
+	seen := make(map[string]bool)
+	patterns := []string{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[strings.ToLower(entry)] {
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil {
+			return nil, fmt.Errorf("%w: bad license pattern %q", ErrInvalidLicensePolicy, entry)
+		}
+		seen[strings.ToLower(entry)] = true
+		patterns = append(patterns, entry)
+	}
+	return patterns, nil
+}
+
+// violatingLicenses returns the licenses the violating packages are
+// refused for.
+func (p *LicensePolicy) violatingLicenses(report *LicenseReport) []string {
+	seen := make(map[string]bool)
+	var licenses []string
+	for _, v := range report.Violations {
+		names := []string{LicenseUnknown}
+		if len(v.Licenses) > 0 {
+			names = nil
+			for _, name := range v.Licenses {
+				if p.licenseStatus(name) != LicenseAllowed {
+					names = append(names, name)
+				}
+			}
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				licenses = append(licenses, name)
+			}
+		}
+	}
+	sort.Strings(licenses)
+	return licenses
+}
+
+// imageNamespace returns the first path segment of a repository or image
+// reference, the user or organization owning it.
+func imageNamespace(ref string) string {
+	namespace, _, ok := strings.Cut(ref, "/")
+	if !ok {
+		return ""
+	}
+	return namespace
+}
+
+// parseLicenseExpression parses an SPDX license expression, e.g. "MIT OR
+// (Apache-2.0 AND BSD-3-Clause)", into alternatives whose licenses apply
+// together. Licenses with an exception stay one term, "X WITH Y". Values
+// that do not parse are a single license; empty values and NOASSERTION
+// return nil.
+func parseLicenseExpression(expr string) [][]string {
+	expr = strings.TrimSpace(expr)
+	switch strings.ToUpper(expr) {
+	case "", "NOASSERTION", "NONE", "UNKNOWN":
+		return nil
+	}
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(expr))
+	p := &licenseParser{tokens: tokens}
+	alternatives, ok := p.parseOr()
+	if !ok || p.pos != len(tokens) {
+		return [][]string{{expr}}
+	}
+	return alternatives
+}
+
+// licenseParser is a recursive descent parser of SPDX license expressions,
+// producing their disjunctive normal form.
+type licenseParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *licenseParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToUpper(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *licenseParser) parseOr() ([][]string, bool) {
+	result, ok := p.parseAnd()
+	for ok && p.peek() == "OR" {
+		p.pos++
+		var next [][]string
+		if next, ok = p.parseAnd(); ok {
+			result = append(result, next...)
+		}
+	}
+	if len(result) > maxLicenseAlternatives {
+		result = result[:maxLicenseAlternatives]
+	}
+	return result, ok
+}
+
+func (p *licenseParser) parseAnd() ([][]string, bool) {
+	result, ok := p.parseTerm()
+	for ok && p.peek() == "AND" {
+		p.pos++
+		var next [][]string
+		if next, ok = p.parseTerm(); !ok {
+			break
+		}
+		var product [][]string
+		for _, a := range result {
+			for _, b := range next {
+				if len(product) == maxLicenseAlternatives {
+					break
+				}
+				product = append(product, append(append([]string{}, a...), b...))
+			}
+		}
+		result = product
+	}
+	return result, ok
+}
+
+func (p *licenseParser) parseTerm() ([][]string, bool) {
+	switch p.peek() {
+	case "", ")", "AND", "OR", "WITH":
+		return nil, false
+	case "(":
+		p.pos++
+		result, ok := p.parseOr()
+		if !ok || p.peek() != ")" {
+			return nil, false
+		}
+		p.pos++
+		return result, true
+	}
+	license := p.tokens[p.pos]
+	p.pos++
+	if p.peek() == "WITH" {
+		if p.pos+1 >= len(p.tokens) {
+			return nil, false
+		}
+		license += " WITH " + p.tokens[p.pos+1]
+		p.pos += 2
+	}
+	return [][]string{{license}}, true
+}
+
+// expressionLicenses returns the distinct licenses of the alternatives.
+func expressionLicenses(alternatives [][]string) []string {
+	seen := make(map[string]bool)
+	var licenses []string
+	for _, alternative := range alternatives {
+		for _, license := range alternative {
+			if !seen[license] {
+				seen[license] = true
+				licenses = append(licenses, license)
+			}
+		}
+	}
+	return licenses
+}
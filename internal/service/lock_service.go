@@ -8,6 +8,10 @@ import (
 	"go.uber.org/zap"
 )
 
+// LockTypePermanent is the lock type after too many failed unlock
+// attempts. Such a lock cannot be lifted through the unlock endpoint.
+const LockTypePermanent = "permanent"
+
 // LockService provides system lock management.
 type LockService struct {
 	mu            sync.RWMutex
@@ -20,6 +24,8 @@ type LockService struct {
 	unlockAt      time.Time
 	requireManual bool
 	logger        *zap.Logger
+
+	unlockTokens *UnlockTokens // 每次锁定时签发一次性解锁令牌，nil 不签发
 }
 
 // LockConfig holds lock configuration.
@@ -73,6 +79,18 @@ func NewLockService(logger *zap.Logger) *LockService {
 	}
 }
 
+// SetUnlockTokens issues a one-time unlock token on every lock. A lock
+// already in place gets its token right away.
+func (s *LockService) SetUnlockTokens(tokens *UnlockTokens) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unlockTokens = tokens
+	if s.isLocked && s.lockType != LockTypePermanent {
+		s.issueUnlockTokenUnsafe()
+	}
+}
+
 // IsSystemLocked returns whether the system is locked.
 func (s *LockService) IsSystemLocked() bool {
 	s.mu.RLock()
@@ -88,6 +106,9 @@ func (s *LockService) IsSystemLocked() bool {
 		s.mu.RUnlock()
 		s.mu.Lock()
 		s.isLocked = false
+		if s.unlockTokens != nil {
+			s.unlockTokens.Revoke()
+		}
 		s.mu.Unlock()
 		s.mu.RLock()
 		return false
@@ -130,6 +151,7 @@ func (s *LockService) LockSystem(reason, ip string) error {
 	s.lockType = "rule_triggered"
 	s.lockedAt = time.Now()
 	s.lockedByIP = ip
+	s.issueUnlockTokenUnsafe()
 
 	if s.logger != nil {
 		s.logger.Error("System locked",
@@ -154,6 +176,7 @@ func (s *LockService) LockSystemByBypass(ip, user string) error {
 	s.lockedByIP = ip
 	s.lockedByUser = user
 	s.requireManual = true
+	s.issueUnlockTokenUnsafe()
 
 	if s.logger != nil {
 		s.logger.Error("System locked due to bypass attempt",
@@ -166,9 +189,33 @@ func (s *LockService) LockSystemByBypass(ip, user string) error {
 	return nil
 }
 
-// UnlockSystem unlocks the system.
+// EscalateLock turns the current lock into a permanent one that requires
+// an administrator on the host, e.g. after repeated failed unlock attempts.
+func (s *LockService) EscalateLock(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.isLocked {
+		return
+	}
+	s.lockType = LockTypePermanent
+	s.lockReason = reason
+	s.requireManual = true
+	s.unlockAt = time.Time{}
+	if s.unlockTokens != nil {
+		s.unlockTokens.Revoke()
+	}
+
+	if s.logger != nil {
+		s.logger.Error("System lock escalated to permanent",
+			zap.String("reason", reason),
+		)
+	}
+}
+
+// UnlockSystem unlocks the system. The caller verifies the administrator's
+// credentials.
 func (s *LockService) UnlockSystem(adminPassword string) error {
-	// TODO: Validate admin password
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -177,6 +224,9 @@ func (s *LockService) UnlockSystem(adminPassword string) error {
 	s.lockType = ""
 	s.lockedByIP = ""
 	s.lockedByUser = ""
+	if s.unlockTokens != nil {
+		s.unlockTokens.Revoke()
+	}
 
 	if s.logger != nil {
 		s.logger.Info("System unlocked")
@@ -185,6 +235,36 @@ func (s *LockService) UnlockSystem(adminPassword string) error {
 	return nil
 }
 
+// RenewUnlockToken replaces an expired unlock token while the system is
+// locked and the lock is not permanent.
+func (s *LockService) RenewUnlockToken() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.isLocked && s.lockType != LockTypePermanent {
+		s.issueUnlockTokenUnsafe()
+	}
+}
+
+// issueUnlockTokenUnsafe writes a new unlock token. Must be called with
+// s.mu held.
+func (s *LockService) issueUnlockTokenUnsafe() {
+	if s.unlockTokens == nil {
+		return
+	}
+	if err := s.unlockTokens.Issue(); err != nil {
+		if s.logger != nil {
+			s.logger.Error("Failed to issue unlock token", zap.Error(err))
+		}
+		return
+	}
+	if s.logger != nil {
+		s.logger.Warn("Unlock token written",
+			zap.String("path", s.unlockTokens.Path()),
+		)
+	}
+}
+
 // SetAutoUnlock sets the auto-unlock time.
 func (s *LockService) SetAutoUnlock(duration time.Duration) {
 	s.mu.Lock()
@@ -0,0 +1,80 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to []string, subject, body string) error
+}
+
+// SMTPMailer 通过 SMTP 服务器发送邮件，服务器支持时自动使用 STARTTLS
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+}
+
+// NewSMTPMailer creates a new SMTPMailer. from defaults to username.
+func NewSMTPMailer(host string, port int, username, password, from string) (*SMTPMailer, error) {
+	if host == "" {
+		return nil, errors.New("smtp host is required")
+	}
+	if port == 0 {
+		port = 587
+	}
+	if from == "" {
+		from = username
+	}
+	if from == "" {
+		return nil, errors.New("sender address is required")
+	}
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}, nil
+}
+
+// Send sends a plain text email.
+func (m *SMTPMailer) Send(to []string, subject, body string) error {
+	if len(to) == 0 {
+		return errors.New("no recipients")
+	}
+	for _, addr := range append([]string{m.from}, to...) {
+		// 防止通过地址注入额外的邮件头
+		if strings.ContainsAny(addr, "\r\n") {
+			return fmt.Errorf("invalid email address: %q", addr)
+		}
+	}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + m.from + "\r\n")
+	msg.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	subject = strings.NewReplacer("\r", "", "\n", "").Replace(subject)
+	msg.WriteString("Subject: " + mime.BEncoding.Encode("UTF-8", subject) + "\r\n")
+	msg.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+	addr := m.host + ":" + strconv.Itoa(m.port)
+	return smtp.SendMail(addr, auth, m.from, to, []byte(msg.String()))
+}
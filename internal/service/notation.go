@@ -0,0 +1,113 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"cyp-docker-registry/pkg/signature"
+
+	"go.uber.org/zap"
+)
+
+// SetImageSource 设置读取 notation 签名的镜像仓库
+func (s *SignatureService) SetImageSource(images AttestationSource) {
+	s.images = images
+}
+
+// verifyNotation verifies the image with the notation signatures pushed
+// as its referrers. One signature passing the trust policy of the
+// repository is enough.
+func (s *SignatureService) verifyNotation(result *VerifyResult) (*VerifyResult, error) {
+	if s.notation == nil || s.images == nil {
+		return nil, errors.New("notation verifier is not available")
+	}
+
+	repository, reference := parseShareImageRef(result.ImageRef)
+	policy := s.notation.Policy(repository)
+	if policy == nil {
+		result.Error = "no trust policy applies to " + repository
+		return result, nil
+	}
+	if policy.SignatureVerification.Level == signature.LevelSkip {
+		result.Verified = true
+		result.Notation = &signature.NotationResult{Verified: true, Policy: policy.Name, Level: signature.LevelSkip}
+		return result, nil
+	}
+
+	digest := reference
+	if !strings.HasPrefix(reference, "sha256:") {
+		d, err := s.images.ImageDigest(repository, reference)
+		if err != nil {
+			result.Error = err.Error()
+			return result, nil
+		}
+		digest = d
+	}
+
+	referrers, err := s.images.ReferrerDigests(repository, digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range referrers {
+		mediaType, envelope, ok := s.notationEnvelope(ref)
+		if !ok {
+			continue
+		}
+		res := s.notation.Verify(repository, digest, mediaType, envelope)
+		result.Notation = res
+		if res.Verified {
+			result.Verified = true
+			result.Error = ""
+			if len(res.Warnings) > 0 && s.logger != nil {
+				s.logger.Warn("notation 签名验证有警告",
+					zap.String("image", result.ImageRef),
+					zap.String("policy", res.Policy),
+					zap.Strings("warnings", res.Warnings))
+			}
+			return result, nil
+		}
+		result.Error = res.Error
+	}
+	if result.Notation == nil {
+		result.Error = "no notation signature found"
+	}
+	return result, nil
+}
+
+// notationEnvelope returns the signature envelope of a referrer when it
+// is a notation signature.
+func (s *SignatureService) notationEnvelope(digest string) (string, []byte, bool) {
+	data, err := s.images.ReadBlob(digest, maxAttestationSize)
+	if err != nil {
+		return "", nil, false
+	}
+	var manifest struct {
+		ArtifactType string `json:"artifactType"`
+		Config       struct {
+			MediaType string `json:"mediaType"`
+		} `json:"config"`
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return "", nil, false
+	}
+	if manifest.ArtifactType != signature.NotationArtifactType && manifest.Config.MediaType != signature.NotationArtifactType {
+		return "", nil, false
+	}
+	for _, layer := range manifest.Layers {
+		if layer.MediaType != signature.NotationJWSMediaType && layer.MediaType != signature.NotationCOSEType {
+			continue
+		}
+		envelope, err := s.images.ReadBlob(layer.Digest, maxAttestationSize)
+		if err != nil {
+			return "", nil, false
+		}
+		return layer.MediaType, envelope, true
+	}
+	return "", nil, false
+}
@@ -0,0 +1,138 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+)
+
+// Activity categories.
+const (
+	ActivityPush       = "push"
+	ActivityDelete     = "delete"
+	ActivityMember     = "member"
+	ActivityPermission = "permission"
+	ActivityImage      = "image"
+)
+
+// Activity feed page sizes.
+const (
+	DefaultActivityLimit = 50
+	MaxActivityLimit     = 200
+)
+
+// activityEvents 各分类对应的审计事件
+var activityEvents = map[string][]string{
+	ActivityPush:       {"image_push", "image_import"},
+	ActivityDelete:     {"image_delete", "image_purge"},
+	ActivityMember:     {"org_member", "org_invitation"},
+	ActivityPermission: {"org_team", "repository_visibility", "license_policy"},
+	ActivityImage:      {"image_restore", "image_signed", "image_export", "sbom_generated", "vulnerability_scan"},
+}
+
+// ErrInvalidActivityType is returned for an unknown activity category.
+var ErrInvalidActivityType = errors.New("invalid activity type")
+
+// OrgActivity is an entry of an organization's activity feed.
+type OrgActivity struct {
+	ID        int64                  `json:"id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Event     string                 `json:"event"`
+	Category  string                 `json:"category"`
+	Actor     string                 `json:"actor,omitempty"`
+	Resource  string                 `json:"resource"`
+	Action    string                 `json:"action"`
+	Status    string                 `json:"status"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// ActivityQuery filters an organization's activity feed. Before is the id
+// of the last entry of the previous page; Limit is normalized by Activity.
+type ActivityQuery struct {
+	Type   string
+	Before int64
+	Limit  int
+}
+
+// ResolveOrganization 按 ID 或名称查找组织
+func (s *OrgService) ResolveOrganization(idOrName string) (*dao.Organization, error) {
+	if id, err := strconv.ParseInt(idOrName, 10, 64); err == nil {
+		org, err := dao.GetOrganization(id)
+		if err != nil || org != nil {
+			return org, err
+		}
+	}
+	return dao.GetOrganizationByName(idOrName)
+}
+
+// Activity lists the recent activity of an organization, newest first. Only
+// members of the organization and system administrators may read it.
+func (s *OrgService) Activity(orgID int64, q *ActivityQuery, requestorID int64) ([]*OrgActivity, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+	if org.OwnerID != requestorID && orgRole(orgID, requestorID) == "" && !isSystemAdmin(requestorID) {
+		return nil, errors.New("permission denied")
+	}
+
+	var events []string
+	if q.Type != "" {
+		var ok bool
+		if events, ok = activityEvents[q.Type]; !ok {
+			return nil, ErrInvalidActivityType
+		}
+	}
+
+	if q.Limit <= 0 {
+		q.Limit = DefaultActivityLimit
+	}
+	if q.Limit > MaxActivityLimit {
+		q.Limit = MaxActivityLimit
+	}
+
+	records, err := dao.ListOrgAuditLogs(orgID, events, q.Before, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]*OrgActivity, len(records))
+	for i, r := range records {
+		list[i] = &OrgActivity{
+			ID:        r.ID,
+			Timestamp: r.Timestamp,
+			Event:     r.Event,
+			Category:  activityCategory(r.Event),
+			Actor:     r.Username.String,
+			Resource:  r.Resource,
+			Action:    r.Action,
+			Status:    r.Status,
+			Details:   r.Details,
+		}
+	}
+	return list, nil
+}
+
+// activityCategory 返回审计事件所属的动态分类
+func activityCategory(event string) string {
+	for category, events := range activityEvents {
+		for _, e := range events {
+			if e == event {
+				return category
+			}
+		}
+	}
+	return ActivityImage
+}
+
+// isSystemAdmin 判断用户是否为系统管理员
+func isSystemAdmin(userID int64) bool {
+	user, err := dao.GetUserByID(userID)
+	return err == nil && user != nil && user.Role == "admin"
+}
@@ -0,0 +1,342 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// DefaultInvitationTTL 邀请默认有效期
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+// Invitation errors.
+var (
+	ErrInvitationNotFound = errors.New("invitation not found")
+	ErrInvitationExpired  = errors.New("invitation expired")
+	ErrInvitationNotYours = errors.New("invitation is addressed to another user")
+)
+
+// OrgInvitation represents a pending invitation to join an organization.
+type OrgInvitation struct {
+	ID          int64     `json:"id"`
+	OrgID       int64     `json:"org_id"`
+	OrgName     string    `json:"org_name"`
+	InviteeID   int64     `json:"invitee_id,omitempty"`
+	Email       string    `json:"email,omitempty"`
+	Role        string    `json:"role"`
+	InvitedBy   int64     `json:"invited_by"`
+	InviterName string    `json:"inviter_name"`
+	Status      string    `json:"status"`
+	Expired     bool      `json:"expired"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// InviteRequest represents a request to invite someone to an organization.
+// Either Username or Email is required.
+type InviteRequest struct {
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	ExpiresIn string `json:"expires_in,omitempty"` // e.g., "72h", "7d"
+}
+
+// SetMailer 设置发送邀请邮件的邮件服务，为 nil 时只返回邀请链接
+func (s *OrgService) SetMailer(mailer Mailer) {
+	s.mailerMu.Lock()
+	defer s.mailerMu.Unlock()
+	s.mailer = mailer
+}
+
+// InviteMember invites a user, by username or email, to an organization.
+// It returns the invitation and its token; only the hash of the token is
+// stored, so the token is only available here.
+func (s *OrgService) InviteMember(orgID int64, req *InviteRequest, requestorID int64) (*OrgInvitation, string, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, "", err
+	}
+	if org == nil {
+		return nil, "", errors.New("organization not found")
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return nil, "", errors.New("permission denied")
+	}
+
+	role := req.Role
+	if role == "" {
+		role = "member"
+	}
+	if role != "member" && role != "admin" {
+		return nil, "", errors.New("invalid role, must be member or admin")
+	}
+
+	ttl := DefaultInvitationTTL
+	if req.ExpiresIn != "" {
+		d, err := parseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			return nil, "", errors.New("invalid expires_in format")
+		}
+		ttl = d
+	}
+
+	inv := &dao.OrgInvitation{
+		OrgID:     orgID,
+		Role:      role,
+		InvitedBy: requestorID,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+
+	// 按用户名邀请已注册用户；按邮箱邀请时如果已有该邮箱的用户则直接关联
+	var invitee *dao.User
+	switch {
+	case req.Username != "":
+		invitee, err = dao.GetUserByUsername(req.Username)
+		if err != nil {
+			return nil, "", err
+		}
+		if invitee == nil {
+			return nil, "", errors.New("user not found")
+		}
+		inv.Email = invitee.Email.String
+	case req.Email != "":
+		if !strings.Contains(req.Email, "@") || strings.ContainsAny(req.Email, " \r\n") {
+			return nil, "", errors.New("invalid email address")
+		}
+		inv.Email = req.Email
+		invitee, err = dao.GetUserByEmail(req.Email)
+		if err != nil {
+			return nil, "", err
+		}
+	default:
+		return nil, "", errors.New("username or email is required")
+	}
+
+	if invitee != nil {
+		if org.OwnerID == invitee.ID || orgRole(orgID, invitee.ID) != "" {
+			return nil, "", errors.New("user is already a member of the organization")
+		}
+		inv.InviteeID = sql.NullInt64{Int64: invitee.ID, Valid: true}
+	}
+
+	// 同一对象只保留一个待处理的邀请
+	pending, err := dao.ListOrgInvitations(orgID)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, p := range pending {
+		sameUser := inv.InviteeID.Valid && p.InviteeID.Valid && p.InviteeID.Int64 == inv.InviteeID.Int64
+		sameEmail := inv.Email != "" && strings.EqualFold(p.Email, inv.Email)
+		if (sameUser || sameEmail) && time.Now().Before(p.ExpiresAt) {
+			return nil, "", errors.New("an invitation is already pending for this user")
+		}
+	}
+
+	token := generatePlainToken()
+	inv.TokenHash = hashToken(token)
+	if err := dao.CreateOrgInvitation(inv); err != nil {
+		return nil, "", err
+	}
+
+	created, err := dao.GetOrgInvitation(inv.ID)
+	if err != nil || created == nil {
+		return nil, "", fmt.Errorf("failed to load invitation: %v", err)
+	}
+	return convertInvitation(created), token, nil
+}
+
+// SendInvitationEmail emails the accept link of an invitation. It reports
+// whether a mail was sent; invitations without an email address or without
+// a configured mailer are skipped.
+func (s *OrgService) SendInvitationEmail(inv *OrgInvitation, acceptURL string) (bool, error) {
+	s.mailerMu.RLock()
+	mailer := s.mailer
+	s.mailerMu.RUnlock()
+	if mailer == nil || inv.Email == "" {
+		return false, nil
+	}
+
+	subject := fmt.Sprintf("邀请加入组织 %s", inv.OrgName)
+	body := fmt.Sprintf("%s 邀请你以 %s 身份加入 CYP-Docker-Registry 组织 %s。\n\n"+
+		"点击以下链接接受邀请（登录后生效）：\n%s\n\n"+
+		"邀请将于 %s 过期。如果你不认识邀请人，请忽略此邮件。\n",
+		inv.InviterName, inv.Role, inv.OrgName, acceptURL, inv.ExpiresAt.Format("2006-01-02 15:04"))
+
+	if err := mailer.Send([]string{inv.Email}, subject, body); err != nil {
+		if s.logger != nil {
+			s.logger.Warn("发送邀请邮件失败", zap.Int64("invitation", inv.ID), zap.Error(err))
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// ListInvitations lists the pending invitations of an organization.
+func (s *OrgService) ListInvitations(orgID, requestorID int64) ([]*OrgInvitation, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return nil, errors.New("permission denied")
+	}
+
+	records, err := dao.ListOrgInvitations(orgID)
+	if err != nil {
+		return nil, err
+	}
+	return convertInvitations(records), nil
+}
+
+// RevokeInvitation revokes a pending invitation of an organization.
+func (s *OrgService) RevokeInvitation(orgID, invitationID, requestorID int64) error {
+	inv, err := dao.GetOrgInvitation(invitationID)
+	if err != nil {
+		return err
+	}
+	if inv == nil || inv.OrgID != orgID {
+		return ErrInvitationNotFound
+	}
+
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return err
+	}
+	if org == nil || !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+
+	ok, err := dao.UpdateOrgInvitationStatus(invitationID, dao.InvitationRevoked)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+// ListUserInvitations lists the pending invitations addressed to a user.
+func (s *OrgService) ListUserInvitations(user *User) ([]*OrgInvitation, error) {
+	records, err := dao.ListUserInvitations(user.ID, userEmail(user))
+	if err != nil {
+		return nil, err
+	}
+	return convertInvitations(records), nil
+}
+
+// AcceptInvitationToken accepts the invitation of an emailed token on
+// behalf of the logged in user.
+func (s *OrgService) AcceptInvitationToken(token string, user *User) (*OrgInvitation, error) {
+	inv, err := dao.GetOrgInvitationByToken(hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, ErrInvitationNotFound
+	}
+	return s.respond(inv, user, true)
+}
+
+// RespondInvitation accepts or declines an invitation listed for the user.
+func (s *OrgService) RespondInvitation(invitationID int64, user *User, accept bool) (*OrgInvitation, error) {
+	inv, err := dao.GetOrgInvitation(invitationID)
+	if err != nil {
+		return nil, err
+	}
+	if inv == nil {
+		return nil, ErrInvitationNotFound
+	}
+	return s.respond(inv, user, accept)
+}
+
+// respond 检查邀请对象后接受或拒绝邀请
+func (s *OrgService) respond(inv *dao.OrgInvitation, user *User, accept bool) (*OrgInvitation, error) {
+	if inv.Status != dao.InvitationPending {
+		return nil, ErrInvitationNotFound
+	}
+	if time.Now().After(inv.ExpiresAt) {
+		return nil, ErrInvitationExpired
+	}
+
+	// 邀请只能由被邀请的用户或邮箱相同的用户接受
+	if inv.InviteeID.Valid {
+		if inv.InviteeID.Int64 != user.ID {
+			return nil, ErrInvitationNotYours
+		}
+	} else if inv.Email == "" || !strings.EqualFold(inv.Email, userEmail(user)) {
+		return nil, ErrInvitationNotYours
+	}
+
+	status := dao.InvitationDeclined
+	if accept {
+		status = dao.InvitationAccepted
+	}
+
+	// 先更新状态，保证同一邀请只会被处理一次
+	ok, err := dao.UpdateOrgInvitationStatus(inv.ID, status)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvitationNotFound
+	}
+	if accept {
+		if err := dao.AddOrgMember(inv.OrgID, user.ID, inv.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	result := convertInvitation(inv)
+	result.Status = status
+	return result, nil
+}
+
+// userEmail 返回用户邮箱，JWT 中不含邮箱时从数据库读取
+func userEmail(user *User) string {
+	if user.Email != "" {
+		return user.Email
+	}
+	if u, err := dao.GetUserByID(user.ID); err == nil && u != nil {
+		return u.Email.String
+	}
+	return ""
+}
+
+func convertInvitation(inv *dao.OrgInvitation) *OrgInvitation {
+	result := &OrgInvitation{
+		ID:          inv.ID,
+		OrgID:       inv.OrgID,
+		OrgName:     inv.OrgName,
+		Email:       inv.Email,
+		Role:        inv.Role,
+		InvitedBy:   inv.InvitedBy,
+		InviterName: inv.InviterName,
+		Status:      inv.Status,
+		Expired:     inv.Status == dao.InvitationPending && time.Now().After(inv.ExpiresAt),
+		ExpiresAt:   inv.ExpiresAt,
+		CreatedAt:   inv.CreatedAt,
+	}
+	if inv.InviteeID.Valid {
+		result.InviteeID = inv.InviteeID.Int64
+	}
+	return result
+}
+
+func convertInvitations(records []*dao.OrgInvitation) []*OrgInvitation {
+	list := make([]*OrgInvitation, len(records))
+	for i, r := range records {
+		list[i] = convertInvitation(r)
+	}
+	return list
+}
@@ -3,6 +3,7 @@ package service
 
 import (
 	"errors"
+	"sync"
 	"time"
 
 	"cyp-docker-registry/internal/dao"
@@ -13,6 +14,14 @@ import (
 // OrgService provides organization management services.
 type OrgService struct {
 	logger *zap.Logger
+
+	// 普通成员对组织内仓库的默认权限，见 SetMemberPermission
+	memberPermission string
+
+	// 发送邀请邮件，未配置时邀请只能通过链接或站内列表接受，
+	// 配置热加载时会被替换
+	mailerMu sync.RWMutex
+	mailer   Mailer
 }
 
 // Organization represents an organization.
@@ -46,7 +55,8 @@ type CreateOrgRequest struct {
 // NewOrgService creates a new OrgService instance.
 func NewOrgService(logger *zap.Logger) *OrgService {
 	return &OrgService{
-		logger: logger,
+		logger:           logger,
+		memberPermission: RepoPermissionWrite,
 	}
 }
 
@@ -60,6 +70,12 @@ func (s *OrgService) CreateOrganization(req *CreateOrgRequest, ownerID int64) (*
 	if existing != nil {
 		return nil, errors.New("organization name already exists")
 	}
+	// Users and organizations share the repository namespace
+	if ns, err := dao.GetNamespace(req.Name); err != nil {
+		return nil, err
+	} else if ns != nil {
+		return nil, errors.New("name is already used by a user or organization")
+	}
 
 	displayName := req.DisplayName
 	if displayName == "" {
@@ -73,6 +89,9 @@ func (s *OrgService) CreateOrganization(req *CreateOrgRequest, ownerID int64) (*
 	}
 
 	if err := dao.CreateOrganization(daoOrg); err != nil {
+		if errors.Is(err, dao.ErrNamespaceTaken) {
+			return nil, errors.New("name is already used by a user or organization")
+		}
 		return nil, err
 	}
 
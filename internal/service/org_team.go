@@ -0,0 +1,489 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// 仓库权限，由低到高
+const (
+	RepoPermissionNone  = "none"
+	RepoPermissionRead  = "read"  // 拉取
+	RepoPermissionWrite = "write" // 拉取和推送
+	RepoPermissionAdmin = "admin" // 还可以修改仓库设置
+)
+
+var repoPermissionRank = map[string]int{
+	RepoPermissionNone:  0,
+	RepoPermissionRead:  1,
+	RepoPermissionWrite: 2,
+	RepoPermissionAdmin: 3,
+}
+
+// ErrInvalidPermission 权限取值无效
+var ErrInvalidPermission = errors.New("invalid permission, must be read, write or admin")
+
+// Team represents a team of an organization.
+type Team struct {
+	ID          int64     `json:"id"`
+	OrgID       int64     `json:"org_id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TeamMember represents a member of a team.
+type TeamMember struct {
+	UserID    int64     `json:"user_id"`
+	Username  string    `json:"username"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// TeamRepository is a repository permission granted to a team.
+type TeamRepository struct {
+	TeamID     int64     `json:"team_id"`
+	TeamName   string    `json:"team_name"`
+	Repository string    `json:"repository"`
+	Permission string    `json:"permission"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// EffectivePermission is the permission a user ends up with on a repository
+// (or "<org>/*"), and where it comes from.
+type EffectivePermission struct {
+	Repository string   `json:"repository"`
+	Permission string   `json:"permission"`
+	Sources    []string `json:"sources"`
+}
+
+// TeamRequest represents a request to create or update a team.
+type TeamRequest struct {
+	Name        string `json:"name" binding:"required"`
+	Description string `json:"description"`
+}
+
+// ValidRepoPermission 检查团队可授予的权限
+func ValidRepoPermission(permission string) bool {
+	switch permission {
+	case RepoPermissionRead, RepoPermissionWrite, RepoPermissionAdmin:
+		return true
+	}
+	return false
+}
+
+// higherPermission 返回两个权限中较高的一个
+func higherPermission(a, b string) string {
+	if repoPermissionRank[b] > repoPermissionRank[a] {
+		return b
+	}
+	return a
+}
+
+// SetMemberPermission 设置普通组织成员对组织内所有仓库的默认权限
+// 设为 read 或 none 后，推送权限只能通过团队授予
+func (s *OrgService) SetMemberPermission(permission string) error {
+	if permission != RepoPermissionNone && permission != RepoPermissionRead && permission != RepoPermissionWrite {
+		return errors.New("invalid member permission, must be none, read or write")
+	}
+	s.memberPermission = permission
+	return nil
+}
+
+// canManageOrg 判断用户能否管理组织的团队：系统管理员、组织所有者或组织管理员
+func (s *OrgService) canManageOrg(org *dao.Organization, userID int64) bool {
+	if org.OwnerID == userID {
+		return true
+	}
+	if user, err := dao.GetUserByID(userID); err == nil && user != nil && user.Role == "admin" {
+		return true
+	}
+	role := orgRole(org.ID, userID)
+	return role == "owner" || role == "admin"
+}
+
+// CanManageOrganization 判断用户能否管理组织的团队和成员权限
+func (s *OrgService) CanManageOrganization(orgID, userID int64) bool {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil || org == nil {
+		return false
+	}
+	return s.canManageOrg(org, userID)
+}
+
+// orgRole 返回用户在组织中的角色，不是成员时返回空字符串
+func orgRole(orgID, userID int64) string {
+	members, err := dao.GetOrgMembers(orgID)
+	if err != nil {
+		return ""
+	}
+	for _, m := range members {
+		if m.UserID == userID {
+			return m.Role
+		}
+	}
+	return ""
+}
+
+// teamOf 加载组织下的团队，团队不属于该组织时视为不存在
+func (s *OrgService) teamOf(orgID, teamID int64) (*dao.Organization, *dao.Team, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if org == nil {
+		return nil, nil, errors.New("organization not found")
+	}
+	team, err := dao.GetTeam(teamID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if team == nil || team.OrgID != orgID {
+		return nil, nil, errors.New("team not found")
+	}
+	return org, team, nil
+}
+
+// CreateTeam creates a team in an organization.
+func (s *OrgService) CreateTeam(orgID int64, req *TeamRequest, requestorID int64) (*Team, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return nil, errors.New("permission denied")
+	}
+
+	teams, err := dao.ListTeams(orgID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range teams {
+		if strings.EqualFold(t.Name, req.Name) {
+			return nil, errors.New("team name already exists")
+		}
+	}
+
+	team := &dao.Team{OrgID: orgID, Name: req.Name, Description: req.Description}
+	if err := dao.CreateTeam(team); err != nil {
+		return nil, err
+	}
+	return convertTeam(team), nil
+}
+
+// ListTeams lists the teams of an organization.
+func (s *OrgService) ListTeams(orgID int64) ([]*Team, error) {
+	daoTeams, err := dao.ListTeams(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make([]*Team, len(daoTeams))
+	for i, t := range daoTeams {
+		teams[i] = convertTeam(t)
+	}
+	return teams, nil
+}
+
+// GetTeam retrieves a team of an organization.
+func (s *OrgService) GetTeam(orgID, teamID int64) (*Team, error) {
+	_, team, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return nil, err
+	}
+	return convertTeam(team), nil
+}
+
+// UpdateTeam updates the name and description of a team.
+func (s *OrgService) UpdateTeam(orgID, teamID int64, req *TeamRequest, requestorID int64) error {
+	org, team, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return err
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+
+	team.Name = req.Name
+	team.Description = req.Description
+	return dao.UpdateTeam(team)
+}
+
+// DeleteTeam deletes a team and the permissions granted through it.
+func (s *OrgService) DeleteTeam(orgID, teamID, requestorID int64) error {
+	org, _, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return err
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+	return dao.DeleteTeam(teamID)
+}
+
+// AddTeamMember adds an organization member to a team.
+func (s *OrgService) AddTeamMember(orgID, teamID, userID, requestorID int64) error {
+	org, _, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return err
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+
+	// 团队成员必须先是组织成员
+	if org.OwnerID != userID && orgRole(orgID, userID) == "" {
+		return errors.New("user is not a member of the organization")
+	}
+	return dao.AddTeamMember(teamID, userID)
+}
+
+// RemoveTeamMember removes a user from a team.
+func (s *OrgService) RemoveTeamMember(orgID, teamID, userID, requestorID int64) error {
+	org, _, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return err
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+	return dao.RemoveTeamMember(teamID, userID)
+}
+
+// GetTeamMembers retrieves the members of a team.
+func (s *OrgService) GetTeamMembers(orgID, teamID int64) ([]*TeamMember, error) {
+	if _, _, err := s.teamOf(orgID, teamID); err != nil {
+		return nil, err
+	}
+
+	daoMembers, err := dao.GetTeamMembers(teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]*TeamMember, len(daoMembers))
+	for i, m := range daoMembers {
+		members[i] = &TeamMember{
+			UserID:    m.UserID,
+			Username:  m.Username,
+			CreatedAt: m.CreatedAt,
+		}
+	}
+	return members, nil
+}
+
+// SetTeamRepository grants a team a permission on a repository of the
+// organization. "<org>/*" grants it on every repository of the org.
+func (s *OrgService) SetTeamRepository(orgID, teamID int64, repository, permission string, requestorID int64) error {
+	if !ValidRepoPermission(permission) {
+		return ErrInvalidPermission
+	}
+	org, _, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return err
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+
+	repository = strings.Trim(repository, "/")
+	if !strings.HasPrefix(repository, org.Name+"/") || repository == org.Name+"/" {
+		return errors.New("repository must be in the organization namespace")
+	}
+	return dao.SetTeamRepository(teamID, repository, permission)
+}
+
+// RemoveTeamRepository revokes a team's permission on a repository.
+func (s *OrgService) RemoveTeamRepository(orgID, teamID int64, repository string, requestorID int64) error {
+	org, _, err := s.teamOf(orgID, teamID)
+	if err != nil {
+		return err
+	}
+	if !s.canManageOrg(org, requestorID) {
+		return errors.New("permission denied")
+	}
+	return dao.RemoveTeamRepository(teamID, strings.Trim(repository, "/"))
+}
+
+// GetTeamRepositories lists the repository permissions of a team.
+func (s *OrgService) GetTeamRepositories(orgID, teamID int64) ([]*TeamRepository, error) {
+	if _, _, err := s.teamOf(orgID, teamID); err != nil {
+		return nil, err
+	}
+
+	grants, err := dao.GetTeamRepositories(teamID)
+	if err != nil {
+		return nil, err
+	}
+	return convertTeamRepositories(grants), nil
+}
+
+// RepositoryPermission 计算用户对仓库的权限
+// 仓库名第一段是用户名或组织名：管理员和命名空间所属用户拥有 admin，
+// 组织所有者和组织管理员拥有 admin，普通成员使用默认成员权限，
+// 团队授予的权限在此基础上取较高者
+// 命名空间归属以 namespaces 表为准，同名的组织或重新注册的同名用户不会获得权限
+func (s *OrgService) RepositoryPermission(user *User, name string) string {
+	if user == nil {
+		return RepoPermissionNone
+	}
+	if user.Role == "admin" {
+		return RepoPermissionAdmin
+	}
+
+	namespace, _, ok := strings.Cut(name, "/")
+	if !ok {
+		// 没有命名空间的仓库只有管理员能管理
+		return RepoPermissionNone
+	}
+	if dao.GetDB() == nil {
+		if namespace == user.Username {
+			return RepoPermissionAdmin
+		}
+		return RepoPermissionNone
+	}
+
+	ns, err := dao.GetNamespace(namespace)
+	if err != nil || ns == nil {
+		return RepoPermissionNone
+	}
+	if ns.Kind == dao.NamespaceUser {
+		if ns.OwnerID == user.ID && namespace == user.Username {
+			return RepoPermissionAdmin
+		}
+		return RepoPermissionNone
+	}
+
+	org, err := dao.GetOrganizationByName(namespace)
+	if err != nil || org == nil || org.ID != ns.OwnerID {
+		return RepoPermissionNone
+	}
+
+	permission := s.basePermission(org, user.ID)
+	if permission == RepoPermissionAdmin {
+		return permission
+	}
+
+	grants, err := dao.ListUserTeamRepositories(org.ID, user.ID)
+	if err != nil {
+		if s.logger != nil {
+			s.logger.Warn("查询团队权限失败", zap.String("repository", name), zap.Error(err))
+		}
+		return permission
+	}
+	for _, g := range grants {
+		if g.Repository == name || g.Repository == namespace+"/*" {
+			permission = higherPermission(permission, g.Permission)
+		}
+	}
+	return permission
+}
+
+// basePermission 返回组织角色带来的、对组织内所有仓库的权限
+func (s *OrgService) basePermission(org *dao.Organization, userID int64) string {
+	if org.OwnerID == userID {
+		return RepoPermissionAdmin
+	}
+	switch orgRole(org.ID, userID) {
+	case "owner", "admin":
+		return RepoPermissionAdmin
+	case "":
+		return RepoPermissionNone
+	default:
+		return s.memberPermission
+	}
+}
+
+// EffectivePermissions lists the repository permissions a user has in an
+// organization, merging the org role with every team the user is in.
+func (s *OrgService) EffectivePermissions(orgID, userID int64) ([]*EffectivePermission, error) {
+	org, err := dao.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+	if org == nil {
+		return nil, errors.New("organization not found")
+	}
+
+	byRepo := make(map[string]*EffectivePermission)
+	grant := func(repository, permission, source string) {
+		p, ok := byRepo[repository]
+		if !ok {
+			p = &EffectivePermission{Repository: repository, Permission: RepoPermissionNone}
+			byRepo[repository] = p
+		}
+		p.Permission = higherPermission(p.Permission, permission)
+		p.Sources = append(p.Sources, source)
+	}
+
+	wildcard := org.Name + "/*"
+	if base := s.basePermission(org, userID); base != RepoPermissionNone {
+		role := orgRole(orgID, userID)
+		if org.OwnerID == userID {
+			role = "owner"
+		}
+		grant(wildcard, base, "org:"+role)
+	}
+
+	grants, err := dao.ListUserTeamRepositories(orgID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range grants {
+		grant(g.Repository, g.Permission, "team:"+g.TeamName)
+	}
+
+	// 单个仓库的权限不低于组织级通配权限
+	if all, ok := byRepo[wildcard]; ok {
+		for repo, p := range byRepo {
+			if repo != wildcard && repoPermissionRank[all.Permission] > repoPermissionRank[p.Permission] {
+				p.Permission = all.Permission
+				p.Sources = append(p.Sources, all.Sources...)
+			}
+		}
+	}
+
+	permissions := make([]*EffectivePermission, 0, len(byRepo))
+	for _, p := range byRepo {
+		permissions = append(permissions, p)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].Repository < permissions[j].Repository
+	})
+	return permissions, nil
+}
+
+func convertTeam(t *dao.Team) *Team {
+	return &Team{
+		ID:          t.ID,
+		OrgID:       t.OrgID,
+		Name:        t.Name,
+		Description: t.Description,
+		MemberCount: t.MemberCount,
+		CreatedAt:   t.CreatedAt,
+	}
+}
+
+func convertTeamRepositories(grants []*dao.TeamRepository) []*TeamRepository {
+	list := make([]*TeamRepository, len(grants))
+	for i, g := range grants {
+		list[i] = &TeamRepository{
+			TeamID:     g.TeamID,
+			TeamName:   g.TeamName,
+			Repository: g.Repository,
+			Permission: g.Permission,
+			CreatedAt:  g.CreatedAt,
+		}
+	}
+	return list
+}
@@ -24,6 +24,11 @@ type P2PService struct {
 	logger       *zap.Logger
 	started      bool
 	mu           sync.RWMutex
+
+	shareRules   *P2PShareRules
+	resolver     BlobRepositoryResolver
+	auditService *AuditService
+	shareMu      sync.RWMutex
 }
 
 // P2PStatus P2P状态
@@ -32,6 +37,7 @@ type P2PStatus struct {
 	Running        bool           `json:"running"`
 	PeerID         string         `json:"peer_id"`
 	Addresses      []string       `json:"addresses"`
+	Multiaddrs     []string       `json:"multiaddrs"` // 含节点ID，可用作引导地址
 	PeerCount      int            `json:"peer_count"`
 	ConnectedPeers int            `json:"connected_peers"`
 	BytesSent      int64          `json:"bytes_sent"`
@@ -39,8 +45,13 @@ type P2PStatus struct {
 	BlobsShared    int64          `json:"blobs_shared"`
 	BlobsReceived  int64          `json:"blobs_received"`
 	Uptime         string         `json:"uptime"`
+	RateIn         float64        `json:"rate_in"`         // 字节/秒
+	RateOut        float64        `json:"rate_out"`        // 字节/秒
+	BandwidthLimit int64          `json:"bandwidth_limit"` // 字节/秒，0 表示不限
 	NATStatus      *p2p.NATStatus `json:"nat_status"`
 	ShareMode      string         `json:"share_mode"`
+	PrivateNetwork bool           `json:"private_network"`
+	SwarmKeyFP     string         `json:"swarm_key_fingerprint,omitempty"` // 用于核对各节点的私有网络密钥
 }
 
 // P2PPeerInfo P2P节点信息
@@ -52,6 +63,9 @@ type P2PPeerInfo struct {
 	BytesSent     int64     `json:"bytes_sent"`
 	BytesReceived int64     `json:"bytes_received"`
 	Latency       string    `json:"latency"`
+	RateIn        float64   `json:"rate_in"`  // 字节/秒
+	RateOut       float64   `json:"rate_out"` // 字节/秒
+	Score         float64   `json:"score"`    // 信誉分数 0-1
 }
 
 // NewP2PService 创建P2P服务
@@ -72,12 +86,17 @@ func NewP2PService(config *p2p.Config, blobPath string, logger *zap.Logger) (*P2
 		return nil, fmt.Errorf("创建P2P节点失败: %w", err)
 	}
 
-	return &P2PService{
+	s := &P2PService{
 		node:      node,
 		blobStore: blobStore,
 		config:    config,
 		logger:    logger,
-	}, nil
+	}
+	s.shareRules = s.loadShareRules()
+	node.SetShareFilter(s.IsShareable)
+	node.SetShareDeniedHandler(s.onShareDenied)
+
+	return s, nil
 }
 
 // Start 启动P2P服务
@@ -160,6 +179,7 @@ func (s *P2PService) GetStatus() *P2PStatus {
 	stats := s.node.GetStats()
 	status.PeerID = s.node.PeerID()
 	status.Addresses = s.node.Addresses()
+	status.Multiaddrs = s.node.Multiaddrs()
 	status.PeerCount = stats.PeerCount
 	status.ConnectedPeers = stats.ConnectedPeers
 	status.BytesSent = stats.TotalBytesSent
@@ -167,6 +187,11 @@ func (s *P2PService) GetStatus() *P2PStatus {
 	status.BlobsShared = stats.BlobsShared
 	status.BlobsReceived = stats.BlobsReceived
 	status.Uptime = stats.Uptime.String()
+	status.RateIn = stats.RateIn
+	status.RateOut = stats.RateOut
+	status.BandwidthLimit = stats.BandwidthLimit
+	status.PrivateNetwork = s.node.IsPrivate()
+	status.SwarmKeyFP = s.node.SwarmKeyFingerprint()
 
 	// 获取NAT状态
 	if s.natTraversal != nil {
@@ -202,6 +227,9 @@ func (s *P2PService) GetPeers() []*P2PPeerInfo {
 			BytesSent:     p.BytesSent,
 			BytesReceived: p.BytesReceived,
 			Latency:       p.Latency.String(),
+			RateIn:        p.RateIn,
+			RateOut:       p.RateOut,
+			Score:         p.Score,
 		})
 	}
 
@@ -297,6 +325,21 @@ func (s *P2PService) DisconnectPeer(peerID string) error {
 	return nil
 }
 
+// GetReputations 获取节点信誉列表
+func (s *P2PService) GetReputations() []*p2p.PeerReputation {
+	return s.node.Reputations()
+}
+
+// BanPeer 拉黑节点，duration 为 0 表示永久
+func (s *P2PService) BanPeer(peerID, reason string, duration time.Duration) error {
+	return s.node.BanPeer(peerID, reason, duration)
+}
+
+// UnbanPeer 解除节点拉黑
+func (s *P2PService) UnbanPeer(peerID string) error {
+	return s.node.UnbanPeer(peerID)
+}
+
 // UpdateConfig 更新配置
 func (s *P2PService) UpdateConfig(config *p2p.Config) error {
 	s.mu.Lock()
@@ -0,0 +1,181 @@
+// Package service 提供P2P选择性分享规则
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// P2PShareRules 选择性分享模式下允许分享的内容
+type P2PShareRules struct {
+	Repositories  []string `json:"repositories"`  // 仓库名，支持通配符，如 library/*
+	Organizations []string `json:"organizations"` // 组织名，匹配仓库路径的第一段
+	Digests       []string `json:"digests"`       // 单独允许的Blob摘要
+}
+
+// BlobRepositoryResolver 查询引用某个Blob的仓库
+type BlobRepositoryResolver interface {
+	RepositoriesForBlob(digest string) []string
+}
+
+// SetBlobResolver 设置Blob所属仓库的解析器
+func (s *P2PService) SetBlobResolver(resolver BlobRepositoryResolver) {
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+	s.resolver = resolver
+}
+
+// SetAuditService 设置审计服务，用于记录被拒绝的分享请求
+func (s *P2PService) SetAuditService(auditService *AuditService) {
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+	s.auditService = auditService
+}
+
+// GetShareRules 获取分享规则
+func (s *P2PService) GetShareRules() *P2PShareRules {
+	s.shareMu.RLock()
+	defer s.shareMu.RUnlock()
+
+	rules := *s.shareRules
+	return &rules
+}
+
+// UpdateShareRules 更新并保存分享规则
+func (s *P2PService) UpdateShareRules(rules *P2PShareRules) error {
+	if rules == nil {
+		return fmt.Errorf("分享规则不能为空")
+	}
+	for _, pattern := range rules.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("无效的仓库匹配规则: %s", pattern)
+		}
+	}
+	for _, digest := range rules.Digests {
+		if !strings.HasPrefix(digest, "sha256:") {
+			return fmt.Errorf("无效的摘要: %s", digest)
+		}
+	}
+
+	s.shareMu.Lock()
+	defer s.shareMu.Unlock()
+
+	if err := s.saveShareRules(rules); err != nil {
+		return err
+	}
+	s.shareRules = rules
+	return nil
+}
+
+// IsShareable 检查Blob是否在分享允许列表中
+func (s *P2PService) IsShareable(digest string) bool {
+	s.shareMu.RLock()
+	rules := s.shareRules
+	resolver := s.resolver
+	s.shareMu.RUnlock()
+
+	for _, d := range rules.Digests {
+		if d == digest {
+			return true
+		}
+	}
+
+	if resolver == nil || (len(rules.Repositories) == 0 && len(rules.Organizations) == 0) {
+		return false
+	}
+
+	for _, repo := range resolver.RepositoriesForBlob(digest) {
+		if rules.allowsRepository(repo) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsRepository 检查仓库是否被规则允许
+func (r *P2PShareRules) allowsRepository(repo string) bool {
+	org := repo
+	if i := strings.Index(repo, "/"); i >= 0 {
+		org = repo[:i]
+	}
+	for _, o := range r.Organizations {
+		if o == org {
+			return true
+		}
+	}
+	for _, pattern := range r.Repositories {
+		if ok, _ := path.Match(pattern, repo); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// onShareDenied 记录被拒绝的分享请求
+func (s *P2PService) onShareDenied(peerID, digest string) {
+	s.shareMu.RLock()
+	auditService := s.auditService
+	s.shareMu.RUnlock()
+
+	if auditService == nil {
+		return
+	}
+	auditService.LogAuditEvent(&AuditLog{
+		Level:     "warn",
+		Event:     "p2p_share_denied",
+		IPAddress: peerID,
+		Resource:  digest,
+		Action:    "p2p_pull",
+		Status:    "denied",
+		Details: map[string]interface{}{
+			"peer_id":    peerID,
+			"share_mode": s.config.ShareMode,
+		},
+	})
+}
+
+// shareRulesPath 分享规则文件路径
+func (s *P2PService) shareRulesPath() string {
+	dataDir := s.config.DataDir
+	if dataDir == "" {
+		dataDir = "./data/p2p"
+	}
+	return filepath.Join(dataDir, "share_rules.json")
+}
+
+// loadShareRules 加载分享规则，文件不存在时返回空规则
+func (s *P2PService) loadShareRules() *P2PShareRules {
+	rules := &P2PShareRules{}
+
+	data, err := os.ReadFile(s.shareRulesPath())
+	if err != nil {
+		return rules
+	}
+	if err := json.Unmarshal(data, rules); err != nil {
+		if s.logger != nil {
+			s.logger.Warn("解析P2P分享规则失败", zap.Error(err))
+		}
+		return &P2PShareRules{}
+	}
+	return rules
+}
+
+// saveShareRules 保存分享规则
+func (s *P2PService) saveShareRules(rules *P2PShareRules) error {
+	file := s.shareRulesPath()
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, data, 0644)
+}
@@ -0,0 +1,176 @@
+// Package service provides business logic services for the container registry.
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultPopularRefreshInterval 热门镜像排行的默认刷新间隔
+	defaultPopularRefreshInterval = 5 * time.Minute
+	// maxPopularImages 每个统计窗口缓存的最大条数
+	maxPopularImages = 100
+)
+
+// PopularWindows 热门镜像支持的统计窗口
+var PopularWindows = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+}
+
+// ErrInvalidWindow 统计窗口无效
+var ErrInvalidWindow = errors.New("invalid window, must be 24h, 7d or 30d")
+
+// PopularImage 统计窗口内的镜像拉取次数
+type PopularImage struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag,omitempty"`
+	Pulls      int64  `json:"pulls"`
+	Users      int64  `json:"users"`
+}
+
+// popularView 一个统计窗口的排行快照
+type popularView struct {
+	images    []*PopularImage
+	updatedAt time.Time
+}
+
+// PopularImages 根据事件日志中的拉取记录计算热门镜像
+// 排行定期刷新并缓存在内存中，查询时不必每次扫描事件表
+type PopularImages struct {
+	logger   *zap.Logger
+	events   *RegistryEventLog
+	interval time.Duration
+
+	views map[string]*popularView
+	mu    sync.RWMutex
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewPopularImages 创建热门镜像服务
+func NewPopularImages(events *RegistryEventLog, logger *zap.Logger) *PopularImages {
+	return &PopularImages{
+		logger:   logger,
+		events:   events,
+		interval: defaultPopularRefreshInterval,
+		views:    make(map[string]*popularView),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动定期刷新
+func (p *PopularImages) Start(interval time.Duration) {
+	if interval > 0 {
+		p.interval = interval
+	}
+
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			if err := p.Refresh(); err != nil && p.logger != nil {
+				p.logger.Warn("刷新热门镜像失败", zap.Error(err))
+			}
+			select {
+			case <-ticker.C:
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止定期刷新
+func (p *PopularImages) Stop() {
+	p.closeOnce.Do(func() {
+		close(p.stopCh)
+	})
+}
+
+// Refresh 重新计算所有统计窗口的排行
+func (p *PopularImages) Refresh() error {
+	if dao.GetDB() == nil {
+		return nil
+	}
+	// 先写入缓存的事件，保证排行包含最近的拉取
+	if p.events != nil {
+		if err := p.events.Flush(); err != nil {
+			return err
+		}
+	}
+
+	for window := range PopularWindows {
+		if err := p.refreshWindow(window); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshWindow 重新计算一个统计窗口的排行
+func (p *PopularImages) refreshWindow(window string) error {
+	now := time.Now()
+	records, err := dao.TopPulledImagesSince(now.Add(-PopularWindows[window]), maxPopularImages)
+	if err != nil {
+		return err
+	}
+
+	view := &popularView{images: make([]*PopularImage, 0, len(records)), updatedAt: now}
+	for _, r := range records {
+		view.images = append(view.images, &PopularImage{
+			Repository: r.Repository,
+			Tag:        r.Tag,
+			Pulls:      r.Pulls,
+			Users:      r.Users,
+		})
+	}
+
+	p.mu.Lock()
+	p.views[window] = view
+	p.mu.Unlock()
+	return nil
+}
+
+// Get 返回统计窗口内拉取最多的镜像及排行的计算时间，include 不为 nil 时
+// 只返回它接受的仓库。排行尚未计算时立即计算一次
+func (p *PopularImages) Get(window string, limit int, include func(repository string) bool) ([]*PopularImage, time.Time, error) {
+	if _, ok := PopularWindows[window]; !ok {
+		return nil, time.Time{}, ErrInvalidWindow
+	}
+	if dao.GetDB() == nil {
+		return []*PopularImage{}, time.Now(), nil
+	}
+
+	p.mu.RLock()
+	view := p.views[window]
+	p.mu.RUnlock()
+	if view == nil {
+		if err := p.Refresh(); err != nil {
+			return nil, time.Time{}, err
+		}
+		p.mu.RLock()
+		view = p.views[window]
+		p.mu.RUnlock()
+	}
+
+	images := make([]*PopularImage, 0, limit)
+	for _, img := range view.images {
+		if len(images) >= limit {
+			break
+		}
+		if include == nil || include(img.Repository) {
+			images = append(images, img)
+		}
+	}
+	return images, view.updatedAt, nil
+}
@@ -0,0 +1,474 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// 晋升请求状态
+const (
+	PromotionPending   = "pending"   // 等待审批
+	PromotionPromoted  = "promoted"  // 已打上下一通道的标签
+	PromotionRejected  = "rejected"  // 审批人拒绝
+	PromotionCancelled = "cancelled" // 发起人取消
+	PromotionFailed    = "failed"    // 门禁检查未通过或打标签失败
+)
+
+// 审批角色
+const (
+	ApproverAdmin      = "admin"      // 系统管理员
+	ApproverMaintainer = "maintainer" // 能管理该仓库的用户
+)
+
+var (
+	// ErrPromotionForbidden 无权发起或审批晋升
+	ErrPromotionForbidden = errors.New("not allowed to promote the image")
+	// ErrPromotionNotFound 晋升请求不存在或已处理
+	ErrPromotionNotFound = errors.New("promotion not found or no longer pending")
+	// ErrPromotionPending 仓库已有进入该通道的待审批请求
+	ErrPromotionPending = errors.New("repository already has a pending promotion into the channel")
+	// ErrChannelNotFound 通道未配置或没有下一通道
+	ErrChannelNotFound = errors.New("promotion channel not found")
+	// ErrSelfApproval 通道不允许发起人批准自己的请求
+	ErrSelfApproval = errors.New("the requester cannot approve their own promotion")
+	// ErrAlreadyApproved 用户已批准过该请求
+	ErrAlreadyApproved = errors.New("promotion already approved by the user")
+	// ErrGateFailed 镜像未通过通道的扫描或签名检查
+	ErrGateFailed = errors.New("promotion gate failed")
+)
+
+// ImageRetagger 读取通道标签并重新打标签，由镜像仓库服务实现
+type ImageRetagger interface {
+	ImageDigest(name, tag string) (string, error)
+	RetagDigest(name, digest, tag, retaggedBy string) error
+}
+
+// PromotionChannel 晋升通道及进入该通道的门禁
+type PromotionChannel struct {
+	Name              string `json:"name"`
+	Tag               string `json:"tag"`
+	ApproverRole      string `json:"approver_role"`
+	Approvals         int    `json:"approvals"`
+	AllowSelfApproval bool   `json:"allow_self_approval"`
+	RequireScan       string `json:"require_scan,omitempty"`
+	RequireSignature  bool   `json:"require_signature"`
+}
+
+// Promotion 晋升请求
+type Promotion struct {
+	ID                int64                `json:"id"`
+	Repository        string               `json:"repository"`
+	Digest            string               `json:"digest"`
+	FromChannel       string               `json:"from_channel"`
+	ToChannel         string               `json:"to_channel"`
+	RequestedBy       string               `json:"requested_by"`
+	Status            string               `json:"status"`
+	Reason            string               `json:"reason,omitempty"`
+	ResolvedBy        string               `json:"resolved_by,omitempty"`
+	RequiredApprovals int                  `json:"required_approvals"`
+	Approvals         []*PromotionApproval `json:"approvals"`
+	CreatedAt         time.Time            `json:"created_at"`
+	ResolvedAt        *time.Time           `json:"resolved_at,omitempty"`
+}
+
+// PromotionApproval 一次批准
+type PromotionApproval struct {
+	Username  string    `json:"username"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PromotionService 管理镜像在通道间的晋升：发起请求、审批、门禁检查，
+// 审批通过后把摘要打上下一通道的标签
+type PromotionService struct {
+	mu         sync.RWMutex
+	channels   []PromotionChannel
+	resolveMu  sync.Mutex // 串行处理审批，同一请求只晋升一次
+	repos      *RepositoryService
+	images     ImageRetagger
+	sbom       *SBOMService
+	signatures *SignatureService
+	logger     *zap.Logger
+}
+
+// NewPromotionService creates a new PromotionService instance.
+func NewPromotionService(repos *RepositoryService, images ImageRetagger, logger *zap.Logger) *PromotionService {
+	return &PromotionService{
+		repos:  repos,
+		images: images,
+		logger: logger,
+	}
+}
+
+// SetChannels 设置通道，按晋升顺序排列
+func (s *PromotionService) SetChannels(channels []PromotionChannel) {
+	for i := range channels {
+		if channels[i].Tag == "" {
+			channels[i].Tag = channels[i].Name
+		}
+		if channels[i].ApproverRole == "" {
+			channels[i].ApproverRole = ApproverAdmin
+		}
+		if channels[i].Approvals <= 0 {
+			channels[i].Approvals = 1
+		}
+	}
+	s.mu.Lock()
+	s.channels = channels
+	s.mu.Unlock()
+}
+
+// Channels 返回通道，按晋升顺序排列
+func (s *PromotionService) Channels() []PromotionChannel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]PromotionChannel{}, s.channels...)
+}
+
+// SetSBOMService 设置门禁使用的漏洞扫描服务
+func (s *PromotionService) SetSBOMService(sbom *SBOMService) {
+	s.sbom = sbom
+}
+
+// SetSignatureService 设置门禁使用的签名服务
+func (s *PromotionService) SetSignatureService(signatures *SignatureService) {
+	s.signatures = signatures
+}
+
+// channel 查找通道，next 为 true 时返回它的下一通道
+func (s *PromotionService) channel(name string, next bool) (PromotionChannel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i, ch := range s.channels {
+		if ch.Name != name {
+			continue
+		}
+		if !next {
+			return ch, nil
+		}
+		if i+1 == len(s.channels) {
+			return PromotionChannel{}, fmt.Errorf("%w: %s is the last channel", ErrChannelNotFound, name)
+		}
+		return s.channels[i+1], nil
+	}
+	return PromotionChannel{}, fmt.Errorf("%w: %s", ErrChannelNotFound, name)
+}
+
+// Request 发起晋升：把仓库在 fromChannel 当前的摘要晋升到下一通道
+// 发起人须有仓库的推送权限；门禁检查未通过时不创建请求
+func (s *PromotionService) Request(user *User, repository, fromChannel string) (*Promotion, error) {
+	if dao.GetDB() == nil || s.images == nil {
+		return nil, errors.New("promotion is not available")
+	}
+	if user == nil || !s.repos.CanPush(user, repository) {
+		return nil, ErrPromotionForbidden
+	}
+	from, err := s.channel(fromChannel, false)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.channel(fromChannel, true)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := s.images.ImageDigest(repository, from.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	pending, err := dao.GetPendingPromotion(repository, to.Name)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, ErrPromotionPending
+	}
+
+	// 提前检查门禁，审批人不必审批注定失败的请求
+	if err := s.checkGate(to, repository, digest); err != nil {
+		return nil, err
+	}
+
+	record := &dao.PromotionRecord{
+		Repository:    repository,
+		Digest:        digest,
+		FromChannel:   from.Name,
+		ToChannel:     to.Name,
+		RequestedBy:   user.Username,
+		RequestedByID: user.ID,
+		Status:        PromotionPending,
+		CreatedAt:     time.Now(),
+	}
+	if err := dao.CreatePromotion(record); err != nil {
+		return nil, err
+	}
+	return s.promotionFromRecord(record, nil), nil
+}
+
+// Approve 批准晋升，批准数达到通道要求时检查门禁并打标签
+// 门禁检查或打标签失败时请求变为 failed，同时返回请求和错误
+func (s *PromotionService) Approve(user *User, id int64, comment string) (*Promotion, error) {
+	s.resolveMu.Lock()
+	defer s.resolveMu.Unlock()
+
+	record, err := s.pendingPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+	to, err := s.channel(record.ToChannel, false)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canApprove(user, to, record.Repository) {
+		return nil, ErrPromotionForbidden
+	}
+	if user.ID == record.RequestedByID && !to.AllowSelfApproval {
+		return nil, ErrSelfApproval
+	}
+
+	added, err := dao.AddPromotionApproval(&dao.PromotionApprovalRecord{
+		PromotionID: id,
+		UserID:      user.ID,
+		Username:    user.Username,
+		Comment:     strings.TrimSpace(comment),
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !added {
+		return nil, ErrAlreadyApproved
+	}
+
+	approvals, err := dao.ListPromotionApprovals(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(approvals) < to.Approvals {
+		return s.promotionFromRecord(record, approvals), nil
+	}
+	return s.complete(record, approvals, to, user.Username)
+}
+
+// complete 再次检查门禁后把摘要打上目标通道的标签
+func (s *PromotionService) complete(record *dao.PromotionRecord, approvals []*dao.PromotionApprovalRecord, to PromotionChannel, resolvedBy string) (*Promotion, error) {
+	err := s.checkGate(to, record.Repository, record.Digest)
+	if err == nil {
+		err = s.images.RetagDigest(record.Repository, record.Digest, to.Tag, resolvedBy)
+	}
+
+	status, reason := PromotionPromoted, ""
+	if err != nil {
+		status, reason = PromotionFailed, err.Error()
+	}
+	if _, rerr := dao.ResolvePromotion(record.ID, status, resolvedBy, reason); rerr != nil && s.logger != nil {
+		s.logger.Warn("更新晋升请求状态失败", zap.Int64("id", record.ID), zap.Error(rerr))
+	}
+
+	record.Status = status
+	record.Reason = reason
+	record.ResolvedBy = resolvedBy
+	now := time.Now()
+	record.ResolvedAt = &now
+	return s.promotionFromRecord(record, approvals), err
+}
+
+// Reject 审批人拒绝或发起人取消待审批的晋升
+func (s *PromotionService) Reject(user *User, id int64, reason string) (*Promotion, error) {
+	s.resolveMu.Lock()
+	defer s.resolveMu.Unlock()
+
+	record, err := s.pendingPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	to, err := s.channel(record.ToChannel, false)
+	switch {
+	case user == nil:
+		return nil, ErrPromotionForbidden
+	case err == nil && user.ID != record.RequestedByID && s.canApprove(user, to, record.Repository):
+		status = PromotionRejected
+	case user.ID == record.RequestedByID || user.Role == "admin":
+		// 通道已从配置中删除时只能取消
+		status = PromotionCancelled
+	default:
+		return nil, ErrPromotionForbidden
+	}
+
+	reason = strings.TrimSpace(reason)
+	ok, err := dao.ResolvePromotion(id, status, user.Username, reason)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrPromotionNotFound
+	}
+	record.Status = status
+	record.Reason = reason
+	record.ResolvedBy = user.Username
+	now := time.Now()
+	record.ResolvedAt = &now
+
+	approvals, err := dao.ListPromotionApprovals(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.promotionFromRecord(record, approvals), nil
+}
+
+// Get 返回晋升请求，用户须能拉取该仓库
+func (s *PromotionService) Get(user *User, id int64) (*Promotion, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	record, err := dao.GetPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || !s.repos.CanPull(user, record.Repository) {
+		return nil, ErrPromotionNotFound
+	}
+	approvals, err := dao.ListPromotionApprovals(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.promotionFromRecord(record, approvals), nil
+}
+
+// List 列出用户能拉取的仓库的晋升请求，status 和 repository 为空时不过滤
+func (s *PromotionService) List(user *User, status, repository string, limit int) ([]*Promotion, error) {
+	list := []*Promotion{}
+	if dao.GetDB() == nil {
+		return list, nil
+	}
+
+	records, err := dao.ListPromotions(status, repository, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		if !s.repos.CanPull(user, r.Repository) {
+			continue
+		}
+		approvals, err := dao.ListPromotionApprovals(r.ID)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s.promotionFromRecord(r, approvals))
+	}
+	return list, nil
+}
+
+// canApprove 判断用户能否审批进入通道的请求，管理员总是可以
+func (s *PromotionService) canApprove(user *User, to PromotionChannel, repository string) bool {
+	if user == nil || !user.IsActive {
+		return false
+	}
+	if user.Role == "admin" {
+		return true
+	}
+	return to.ApproverRole == ApproverMaintainer && s.repos.CanManage(user, repository)
+}
+
+// checkGate 检查镜像摘要是否满足进入通道的扫描和签名要求
+// 签名按 repository@digest 查找
+func (s *PromotionService) checkGate(to PromotionChannel, repository, digest string) error {
+	ref := repository + "@" + digest
+
+	if to.RequireSignature {
+		if s.signatures == nil {
+			return fmt.Errorf("%w: signature service not configured", ErrGateFailed)
+		}
+		result, err := s.signatures.VerifyImage(&VerifyRequest{ImageRef: ref})
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrGateFailed, err)
+		}
+		if !result.Verified {
+			return fmt.Errorf("%w: %s is not signed: %s", ErrGateFailed, ref, result.Error)
+		}
+	}
+
+	if to.RequireScan != "" {
+		if s.sbom == nil {
+			return fmt.Errorf("%w: SBOM service not configured", ErrGateFailed)
+		}
+		result, err := s.sbom.ScanVulnerabilities(&ScanVulnRequest{ImageRef: ref})
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrGateFailed, err)
+		}
+		if n := blockingVulnerabilities(result.Summary, to.RequireScan); n > 0 {
+			return fmt.Errorf("%w: found %d vulnerabilities at or above %s severity", ErrGateFailed, n, to.RequireScan)
+		}
+	}
+	return nil
+}
+
+// blockingVulnerabilities 统计 level 及以上级别的漏洞数
+func blockingVulnerabilities(sum VulnSummary, level string) int {
+	switch strings.ToLower(level) {
+	case "critical":
+		return sum.Critical
+	case "high":
+		return sum.Critical + sum.High
+	case "medium":
+		return sum.Critical + sum.High + sum.Medium
+	case "low":
+		return sum.Total
+	}
+	return 0
+}
+
+// pendingPromotion 读取待审批的晋升请求
+func (s *PromotionService) pendingPromotion(id int64) (*dao.PromotionRecord, error) {
+	if dao.GetDB() == nil || s.images == nil {
+		return nil, errors.New("promotion is not available")
+	}
+	record, err := dao.GetPromotion(id)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.Status != PromotionPending {
+		return nil, ErrPromotionNotFound
+	}
+	return record, nil
+}
+
+// promotionFromRecord 转换数据库记录，所需批准数取自当前配置
+func (s *PromotionService) promotionFromRecord(r *dao.PromotionRecord, approvals []*dao.PromotionApprovalRecord) *Promotion {
+	p := &Promotion{
+		ID:          r.ID,
+		Repository:  r.Repository,
+		Digest:      r.Digest,
+		FromChannel: r.FromChannel,
+		ToChannel:   r.ToChannel,
+		RequestedBy: r.RequestedBy,
+		Status:      r.Status,
+		Reason:      r.Reason,
+		ResolvedBy:  r.ResolvedBy,
+		Approvals:   []*PromotionApproval{},
+		CreatedAt:   r.CreatedAt,
+		ResolvedAt:  r.ResolvedAt,
+	}
+	if to, err := s.channel(r.ToChannel, false); err == nil {
+		p.RequiredApprovals = to.Approvals
+	}
+	for _, a := range approvals {
+		p.Approvals = append(p.Approvals, &PromotionApproval{
+			Username:  a.Username,
+			Comment:   a.Comment,
+			CreatedAt: a.CreatedAt,
+		})
+	}
+	return p
+}
@@ -0,0 +1,426 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/pkg/signature"
+
+	"go.uber.org/zap"
+)
+
+// 来源证明的验证状态
+const (
+	ProvenanceVerified = "verified" // 可信构建者的签名有效
+	ProvenanceFailed   = "failed"   // 未签名、构建者不可信或签名无效
+	ProvenanceNone     = "none"     // 没有来源证明
+)
+
+// slsaPredicatePrefix is the prefix of the SLSA provenance predicate types,
+// v0.2 and v1.
+const slsaPredicatePrefix = "https://slsa.dev/provenance/"
+
+// maxAttestationSize limits manifests and attestation layers read to verify.
+const maxAttestationSize = 4 << 20
+
+var (
+	// ErrProvenanceForbidden 无权查看或验证该镜像的来源证明
+	ErrProvenanceForbidden = errors.New("not allowed to access the provenance of the image")
+)
+
+// cosignAttestationTag is the tag cosign attaches attestations of a
+// manifest under when the registry is used without the referrers API.
+var cosignAttestationTag = regexp.MustCompile(`^sha256-([a-f0-9]{64})\.att$`)
+
+// AttestationSource 读取镜像及其引用者，由镜像仓库服务实现
+type AttestationSource interface {
+	ImageDigest(name, tag string) (string, error)
+	ReferrerDigests(name, digest string) ([]string, error)
+	ReadBlob(digest string, limit int64) ([]byte, error)
+}
+
+// TrustedBuilder 可信构建者：证明中的 builder.id 及签名公钥
+type TrustedBuilder struct {
+	Name      string
+	ID        string // 以 * 结尾时按前缀匹配
+	PublicKey string // PEM 公钥或公钥文件路径
+}
+
+type trustedBuilder struct {
+	TrustedBuilder
+	key crypto.PublicKey
+}
+
+// matches reports whether the builder ID of an attestation is the one of
+// the trusted builder.
+func (b *trustedBuilder) matches(builderID string) bool {
+	if prefix, ok := strings.CutSuffix(b.ID, "*"); ok {
+		return strings.HasPrefix(builderID, prefix)
+	}
+	return builderID == b.ID
+}
+
+// ProvenanceAttestation 一个来源证明的验证结果
+type ProvenanceAttestation struct {
+	Digest         string    `json:"digest"`
+	PredicateType  string    `json:"predicate_type,omitempty"`
+	BuilderID      string    `json:"builder_id,omitempty"`
+	TrustedBuilder string    `json:"trusted_builder,omitempty"`
+	Status         string    `json:"status"`
+	Reason         string    `json:"reason,omitempty"`
+	VerifiedAt     time.Time `json:"verified_at"`
+}
+
+// ProvenanceBadge 标签的来源证明徽章
+type ProvenanceBadge struct {
+	Repository     string                   `json:"repository"`
+	Tag            string                   `json:"tag"`
+	Digest         string                   `json:"digest"`
+	Status         string                   `json:"status"`
+	TrustedBuilder string                   `json:"trusted_builder,omitempty"`
+	BuilderID      string                   `json:"builder_id,omitempty"`
+	Attestations   []*ProvenanceAttestation `json:"attestations"`
+}
+
+// ProvenanceService 验证以 OCI 引用者推送的 in-toto/SLSA 来源证明，
+// 检查其签名来自配置的可信构建者，并保存验证结果
+type ProvenanceService struct {
+	mu       sync.RWMutex
+	builders []*trustedBuilder
+	repos    *RepositoryService
+	images   AttestationSource
+	logger   *zap.Logger
+}
+
+// NewProvenanceService creates a new ProvenanceService instance.
+func NewProvenanceService(repos *RepositoryService, images AttestationSource, logger *zap.Logger) *ProvenanceService {
+	return &ProvenanceService{
+		repos:  repos,
+		images: images,
+		logger: logger,
+	}
+}
+
+// SetTrustedBuilders 设置可信构建者，公钥无效的构建者被忽略
+func (s *ProvenanceService) SetTrustedBuilders(builders []TrustedBuilder) {
+	parsed := make([]*trustedBuilder, 0, len(builders))
+	for _, b := range builders {
+		key, err := signature.ParsePublicKey(b.PublicKey)
+		if err != nil {
+			if s.logger != nil {
+				s.logger.Warn("可信构建者的公钥无效", zap.String("builder", b.Name), zap.Error(err))
+			}
+			continue
+		}
+		parsed = append(parsed, &trustedBuilder{TrustedBuilder: b, key: key})
+	}
+	s.mu.Lock()
+	s.builders = parsed
+	s.mu.Unlock()
+}
+
+// HandleEvent 验证推送的来源证明，其他事件和清单被忽略
+func (s *ProvenanceService) HandleEvent(event *RegistryEvent) {
+	if event == nil || event.Type != RegistryEventPush || event.Digest == "" {
+		return
+	}
+	if _, err := s.HandlePush(event.Repository, event.Tag, event.Digest); err != nil && s.logger != nil {
+		s.logger.Warn("验证来源证明失败",
+			zap.String("repository", event.Repository),
+			zap.String("digest", event.Digest),
+			zap.Error(err))
+	}
+}
+
+// HandlePush 验证推送到仓库的清单，当它是 SLSA 来源证明时保存结果。
+// 证明的主体取自清单的 subject，或 cosign 的 sha256-<hex>.att 标签；
+// 不是来源证明时返回 nil
+func (s *ProvenanceService) HandlePush(repository, tag, digest string) (*ProvenanceAttestation, error) {
+	if dao.GetDB() == nil || s.images == nil {
+		return nil, nil
+	}
+	data, err := s.images.ReadBlob(digest, maxAttestationSize)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Subject *struct {
+			Digest string `json:"digest"`
+		} `json:"subject"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, nil
+	}
+
+	var subject string
+	if manifest.Subject != nil {
+		subject = manifest.Subject.Digest
+	} else if m := cosignAttestationTag.FindStringSubmatch(tag); m != nil {
+		subject = "sha256:" + m[1]
+	}
+	if subject == "" {
+		return nil, nil
+	}
+	return s.verify(repository, digest, subject, data)
+}
+
+// Reverify 重新验证标签的全部来源证明，例如更换可信构建者后
+func (s *ProvenanceService) Reverify(user *User, repository, tag string) (*ProvenanceBadge, error) {
+	if user == nil || !s.repos.CanPush(user, repository) {
+		return nil, ErrProvenanceForbidden
+	}
+	if dao.GetDB() == nil || s.images == nil {
+		return nil, errors.New("provenance is not available")
+	}
+	digest, err := s.images.ImageDigest(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	attestations, err := s.images.ReferrerDigests(repository, digest)
+	if err != nil {
+		return nil, err
+	}
+	if att, err := s.images.ImageDigest(repository, "sha256-"+strings.TrimPrefix(digest, "sha256:")+".att"); err == nil {
+		attestations = append(attestations, att)
+	}
+	for _, att := range attestations {
+		data, err := s.images.ReadBlob(att, maxAttestationSize)
+		if err != nil {
+			continue
+		}
+		if _, err := s.verify(repository, att, digest, data); err != nil {
+			return nil, err
+		}
+	}
+	return s.badge(repository, tag, digest)
+}
+
+// Badge 返回标签当前摘要的来源证明徽章
+func (s *ProvenanceService) Badge(user *User, repository, tag string) (*ProvenanceBadge, error) {
+	if !s.repos.CanPull(user, repository) {
+		return nil, ErrProvenanceForbidden
+	}
+	if s.images == nil {
+		return nil, errors.New("provenance is not available")
+	}
+	digest, err := s.images.ImageDigest(repository, tag)
+	if err != nil {
+		return nil, err
+	}
+	return s.badge(repository, tag, digest)
+}
+
+// badge summarizes the stored results: verified when any attestation of
+// the digest is verified, failed when there are only failed ones.
+func (s *ProvenanceService) badge(repository, tag, digest string) (*ProvenanceBadge, error) {
+	badge := &ProvenanceBadge{
+		Repository:   repository,
+		Tag:          tag,
+		Digest:       digest,
+		Status:       ProvenanceNone,
+		Attestations: []*ProvenanceAttestation{},
+	}
+	if dao.GetDB() == nil {
+		return badge, nil
+	}
+
+	records, err := dao.ListProvenance(repository, digest)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		att := &ProvenanceAttestation{
+			Digest:         r.AttestationDigest,
+			PredicateType:  r.PredicateType,
+			BuilderID:      r.BuilderID,
+			TrustedBuilder: r.TrustedBuilder,
+			Status:         r.Status,
+			Reason:         r.Reason,
+			VerifiedAt:     r.VerifiedAt,
+		}
+		badge.Attestations = append(badge.Attestations, att)
+		if att.Status == ProvenanceVerified && badge.Status != ProvenanceVerified {
+			badge.Status = ProvenanceVerified
+			badge.TrustedBuilder = att.TrustedBuilder
+			badge.BuilderID = att.BuilderID
+		} else if badge.Status == ProvenanceNone {
+			badge.Status = ProvenanceFailed
+		}
+	}
+	return badge, nil
+}
+
+// inTotoStatement is the part of an in-toto statement the verification
+// reads.
+type inTotoStatement struct {
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+	Predicate     struct {
+		// SLSA v0.2
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		// SLSA v1
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+// builderID returns the builder of the provenance, in either SLSA version.
+func (st *inTotoStatement) builderID() string {
+	if st.Predicate.RunDetails.Builder.ID != "" {
+		return st.Predicate.RunDetails.Builder.ID
+	}
+	return st.Predicate.Builder.ID
+}
+
+// covers reports whether the statement is about the manifest digest.
+func (st *inTotoStatement) covers(digest string) bool {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	for _, sub := range st.Subject {
+		if sub.Digest["sha256"] == hex {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the SLSA provenance statements in the layers of the
+// attestation manifest and stores the result. A manifest with several
+// statements is verified when one of them is.
+func (s *ProvenanceService) verify(repository, attestation, subject string, manifestData []byte) (*ProvenanceAttestation, error) {
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, nil
+	}
+
+	var result *ProvenanceAttestation
+	for _, layer := range manifest.Layers {
+		if !strings.Contains(layer.MediaType, "dsse") && !strings.Contains(layer.MediaType, "in-toto") {
+			continue
+		}
+		data, err := s.images.ReadBlob(layer.Digest, maxAttestationSize)
+		if err != nil {
+			continue
+		}
+		att := s.verifyLayer(data, subject)
+		if att == nil {
+			continue
+		}
+		result = att
+		if att.Status == ProvenanceVerified {
+			break
+		}
+	}
+	if result == nil {
+		return nil, nil
+	}
+
+	result.Digest = attestation
+	result.VerifiedAt = time.Now()
+	err := dao.SaveProvenance(&dao.ProvenanceRecord{
+		Repository:        repository,
+		AttestationDigest: attestation,
+		SubjectDigest:     subject,
+		PredicateType:     result.PredicateType,
+		BuilderID:         result.BuilderID,
+		TrustedBuilder:    result.TrustedBuilder,
+		Status:            result.Status,
+		Reason:            result.Reason,
+		VerifiedAt:        result.VerifiedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.logger != nil {
+		s.logger.Info("来源证明已验证",
+			zap.String("repository", repository),
+			zap.String("subject", subject),
+			zap.String("status", result.Status),
+			zap.String("builder_id", result.BuilderID))
+	}
+	return result, nil
+}
+
+// verifyLayer verifies a DSSE envelope, or an unsigned in-toto statement,
+// returning nil when it is not SLSA provenance about subject.
+func (s *ProvenanceService) verifyLayer(data []byte, subject string) *ProvenanceAttestation {
+	var envelope signature.Envelope
+	var payload []byte
+	signed := false
+	if err := json.Unmarshal(data, &envelope); err == nil && envelope.PayloadType != "" {
+		if envelope.PayloadType != signature.PayloadTypeInToto {
+			return nil
+		}
+		p, err := envelope.DecodePayload()
+		if err != nil {
+			return nil
+		}
+		payload, signed = p, true
+	} else {
+		payload = data
+	}
+
+	var statement inTotoStatement
+	if err := json.Unmarshal(payload, &statement); err != nil {
+		return nil
+	}
+	if !strings.HasPrefix(statement.PredicateType, slsaPredicatePrefix) || !statement.covers(subject) {
+		return nil
+	}
+
+	att := &ProvenanceAttestation{
+		PredicateType: statement.PredicateType,
+		BuilderID:     statement.builderID(),
+		Status:        ProvenanceFailed,
+	}
+	if !signed {
+		att.Reason = "attestation is not signed"
+		return att
+	}
+	if att.BuilderID == "" {
+		att.Reason = "provenance has no builder id"
+		return att
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	trusted := false
+	for _, b := range s.builders {
+		if !b.matches(att.BuilderID) {
+			continue
+		}
+		trusted = true
+		if ok, _ := envelope.Verify(b.key); ok {
+			att.Status = ProvenanceVerified
+			att.TrustedBuilder = b.Name
+			return att
+		}
+	}
+	if trusted {
+		att.Reason = "signature does not match the trusted builder key"
+	} else {
+		att.Reason = fmt.Sprintf("builder %s is not trusted", att.BuilderID)
+	}
+	return att
+}
@@ -0,0 +1,219 @@
+// Package service provides business logic services for the container registry.
+package service
+
+import (
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultEventRetention 推送/拉取事件默认保留时长
+	defaultEventRetention = 90 * 24 * time.Hour
+	// maxPendingEvents 写库失败时内存中最多保留的事件数
+	maxPendingEvents = 10000
+)
+
+// RegistryEventEntry 镜像推送、拉取、删除事件记录
+type RegistryEventEntry struct {
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag,omitempty"`
+	Digest     string    `json:"digest,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	ClientIP   string    `json:"client_ip,omitempty"`
+	Username   string    `json:"username,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RegistryEventQuery 事件查询条件，空字段不过滤
+type RegistryEventQuery struct {
+	Type       string
+	Repository string
+	Tag        string
+	Username   string
+	ClientIP   string
+	Since      time.Time
+	Until      time.Time
+	Page       int
+	PageSize   int
+}
+
+// RegistryEventLog 记录镜像推送、拉取、删除事件，与安全审计日志分开存放
+// 事件先在内存中缓存，定期批量写入数据库，避免每次拉取都写库
+type RegistryEventLog struct {
+	logger    *zap.Logger
+	retention time.Duration
+
+	pending []*dao.RegistryEventRecord
+	mu      sync.Mutex
+
+	stopCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRegistryEventLog 创建事件日志
+func NewRegistryEventLog(logger *zap.Logger) *RegistryEventLog {
+	return &RegistryEventLog{
+		logger:    logger,
+		retention: defaultEventRetention,
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// SetRetention 设置事件保留时长
+func (l *RegistryEventLog) SetRetention(retention time.Duration) {
+	if retention > 0 {
+		l.retention = retention
+	}
+}
+
+// HandleEvent 记录镜像事件
+func (l *RegistryEventLog) HandleEvent(event *RegistryEvent) {
+	at := event.Timestamp
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = append(l.pending, &dao.RegistryEventRecord{
+		Type:       event.Type,
+		Repository: event.Repository,
+		Tag:        event.Tag,
+		Digest:     event.Digest,
+		Size:       event.Size,
+		ClientIP:   event.IPAddress,
+		Username:   event.Actor,
+		DurationMs: event.DurationMs,
+		CreatedAt:  at.UTC(),
+	})
+}
+
+// Flush 将缓存的事件写入数据库，失败时保留事件等待下次写入
+func (l *RegistryEventLog) Flush() error {
+	if dao.GetDB() == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	pending := l.pending
+	l.pending = nil
+	l.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	if err := dao.AddRegistryEvents(pending); err != nil {
+		l.mu.Lock()
+		l.pending = append(pending, l.pending...)
+		// 数据库长时间不可用时丢弃最早的事件，避免内存无限增长
+		if over := len(l.pending) - maxPendingEvents; over > 0 {
+			l.pending = l.pending[over:]
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start 启动定期写库和过期事件清理
+func (l *RegistryEventLog) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultStatsFlushInterval
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastPrune := time.Time{}
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-l.stopCh:
+				l.flushAndLog()
+				return
+			}
+
+			l.flushAndLog()
+			if time.Since(lastPrune) >= 24*time.Hour && dao.GetDB() != nil {
+				if err := dao.PruneRegistryEvents(time.Now().Add(-l.retention)); err != nil && l.logger != nil {
+					l.logger.Warn("清理过期镜像事件失败", zap.Error(err))
+				}
+				lastPrune = time.Now()
+			}
+		}
+	}()
+}
+
+// Stop 停止后台任务并写入剩余事件
+func (l *RegistryEventLog) Stop() {
+	l.closeOnce.Do(func() {
+		close(l.stopCh)
+	})
+}
+
+func (l *RegistryEventLog) flushAndLog() {
+	if err := l.Flush(); err != nil && l.logger != nil {
+		l.logger.Warn("写入镜像事件失败", zap.Error(err))
+	}
+}
+
+// List 按条件分页查询事件，最新的在前
+func (l *RegistryEventLog) List(query RegistryEventQuery) ([]*RegistryEventEntry, int, error) {
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = 50
+	}
+
+	// 先写入缓存的事件，保证数据是最新的
+	if err := l.Flush(); err != nil {
+		return nil, 0, err
+	}
+	if dao.GetDB() == nil {
+		return []*RegistryEventEntry{}, 0, nil
+	}
+
+	records, total, err := dao.ListRegistryEvents(dao.RegistryEventFilter{
+		Type:       query.Type,
+		Repository: query.Repository,
+		Tag:        query.Tag,
+		Username:   query.Username,
+		ClientIP:   query.ClientIP,
+		Since:      query.Since,
+		Until:      query.Until,
+	}, query.Page, query.PageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]*RegistryEventEntry, 0, len(records))
+	for _, r := range records {
+		entries = append(entries, registryEventEntry(r))
+	}
+	return entries, total, nil
+}
+
+// registryEventEntry 转换数据库记录
+func registryEventEntry(r *dao.RegistryEventRecord) *RegistryEventEntry {
+	return &RegistryEventEntry{
+		ID:         r.ID,
+		Type:       r.Type,
+		Repository: r.Repository,
+		Tag:        r.Tag,
+		Digest:     r.Digest,
+		Size:       r.Size,
+		ClientIP:   r.ClientIP,
+		Username:   r.Username,
+		DurationMs: r.DurationMs,
+		CreatedAt:  r.CreatedAt,
+	}
+}
@@ -0,0 +1,138 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+)
+
+const (
+	// maxDescriptionLength 仓库说明（Markdown）的最大长度
+	maxDescriptionLength = 64 * 1024
+	// maxIconLength 图标地址的最大长度，允许较小的 data:image 内联图片
+	maxIconLength = 128 * 1024
+	// maxRepositoryLinks 每个仓库最多的链接数
+	maxRepositoryLinks = 20
+)
+
+// ErrInvalidMetadata 仓库元数据无效
+var ErrInvalidMetadata = errors.New("invalid repository metadata")
+
+// RepositoryLink 仓库相关链接，如源码、文档、主页
+type RepositoryLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// RepositoryMetadata 仓库的目录展示信息
+type RepositoryMetadata struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Icon        string           `json:"icon,omitempty"`
+	Links       []RepositoryLink `json:"links"`
+	UpdatedBy   string           `json:"updated_by,omitempty"`
+	UpdatedAt   *time.Time       `json:"updated_at,omitempty"`
+}
+
+// GetMetadata 获取仓库元数据，未设置时返回空元数据
+func (s *RepositoryService) GetMetadata(name string) (*RepositoryMetadata, error) {
+	meta := &RepositoryMetadata{Name: name, Links: []RepositoryLink{}}
+	if dao.GetDB() == nil {
+		return meta, nil
+	}
+
+	record, err := dao.GetRepositoryMetadata(name)
+	if err != nil || record == nil {
+		return meta, err
+	}
+
+	meta.Description = record.Description
+	meta.Icon = record.Icon
+	meta.UpdatedBy = record.UpdatedBy
+	meta.UpdatedAt = &record.UpdatedAt
+	if record.Links != "" {
+		if err := json.Unmarshal([]byte(record.Links), &meta.Links); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
+
+// SetMetadata 设置仓库元数据，整体替换原有内容
+func (s *RepositoryService) SetMetadata(name string, meta *RepositoryMetadata, updatedBy string) (*RepositoryMetadata, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	if err := validateRepositoryMetadata(meta); err != nil {
+		return nil, err
+	}
+
+	links := meta.Links
+	if links == nil {
+		links = []RepositoryLink{}
+	}
+	data, err := json.Marshal(links)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := dao.SetRepositoryMetadata(&dao.RepositoryMetadataRecord{
+		Name:        name,
+		Description: meta.Description,
+		Icon:        meta.Icon,
+		Links:       string(data),
+		UpdatedBy:   updatedBy,
+		UpdatedAt:   now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &RepositoryMetadata{
+		Name:        name,
+		Description: meta.Description,
+		Icon:        meta.Icon,
+		Links:       links,
+		UpdatedBy:   updatedBy,
+		UpdatedAt:   &now,
+	}, nil
+}
+
+// validateRepositoryMetadata 检查长度和链接地址，页面会直接渲染图标和链接，
+// 只允许 http(s) 地址和 data:image 图标
+func validateRepositoryMetadata(meta *RepositoryMetadata) error {
+	if len(meta.Description) > maxDescriptionLength {
+		return ErrInvalidMetadata
+	}
+	if meta.Icon != "" {
+		if len(meta.Icon) > maxIconLength {
+			return ErrInvalidMetadata
+		}
+		if !strings.HasPrefix(meta.Icon, "data:image/") && !isHTTPURL(meta.Icon) {
+			return ErrInvalidMetadata
+		}
+	}
+	if len(meta.Links) > maxRepositoryLinks {
+		return ErrInvalidMetadata
+	}
+	for _, link := range meta.Links {
+		if strings.TrimSpace(link.Title) == "" || len(link.Title) > 100 || !isHTTPURL(link.URL) {
+			return ErrInvalidMetadata
+		}
+	}
+	return nil
+}
+
+// isHTTPURL 判断是否为 http 或 https 绝对地址
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
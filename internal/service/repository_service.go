@@ -0,0 +1,212 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// 仓库可见性
+const (
+	VisibilityPrivate  = "private"  // 仅管理员和命名空间成员可访问
+	VisibilityInternal = "internal" // 所有登录用户可拉取
+	VisibilityPublic   = "public"   // 匿名用户也可拉取
+)
+
+// ErrInvalidVisibility 可见性取值无效
+var ErrInvalidVisibility = errors.New("invalid visibility, must be private, internal or public")
+
+// RepositorySettings 仓库设置
+type RepositorySettings struct {
+	Name       string    `json:"name"`
+	Visibility string    `json:"visibility"`
+	UpdatedBy  string    `json:"updated_by,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// RepositoryService 管理仓库可见性并判断访问权限
+// 设置缓存在内存中，拉取时不必每个请求都查询数据库
+type RepositoryService struct {
+	logger            *zap.Logger
+	defaultVisibility string
+
+	settings map[string]*RepositorySettings
+	loaded   bool
+	mu       sync.RWMutex
+
+	orgs  *OrgService
+	mover RepositoryMover
+}
+
+// NewRepositoryService 创建仓库服务
+func NewRepositoryService(logger *zap.Logger) *RepositoryService {
+	return &RepositoryService{
+		logger:            logger,
+		defaultVisibility: VisibilityInternal,
+		settings:          make(map[string]*RepositorySettings),
+		orgs:              NewOrgService(logger),
+	}
+}
+
+// ValidVisibility 检查可见性取值
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPrivate, VisibilityInternal, VisibilityPublic:
+		return true
+	}
+	return false
+}
+
+// SetDefaultVisibility 设置未单独配置的仓库使用的可见性
+func (s *RepositoryService) SetDefaultVisibility(visibility string) error {
+	if !ValidVisibility(visibility) {
+		return ErrInvalidVisibility
+	}
+	s.mu.Lock()
+	s.defaultVisibility = visibility
+	s.mu.Unlock()
+	return nil
+}
+
+// DefaultVisibility 返回默认可见性
+func (s *RepositoryService) DefaultVisibility() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.defaultVisibility
+}
+
+// load 首次使用时从数据库加载仓库设置
+func (s *RepositoryService) load() {
+	s.mu.RLock()
+	loaded := s.loaded
+	s.mu.RUnlock()
+	if loaded || dao.GetDB() == nil {
+		return
+	}
+
+	records, err := dao.ListRepositories()
+	if err != nil {
+		if s.logger != nil {
+			s.logger.Warn("加载仓库设置失败", zap.Error(err))
+		}
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range records {
+		s.settings[r.Name] = &RepositorySettings{
+			Name:       r.Name,
+			Visibility: r.Visibility,
+			UpdatedBy:  r.UpdatedBy,
+			UpdatedAt:  r.UpdatedAt,
+		}
+	}
+	s.loaded = true
+}
+
+// GetVisibility 获取仓库可见性，未配置时返回默认值
+func (s *RepositoryService) GetVisibility(name string) string {
+	s.load()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if settings, ok := s.settings[name]; ok {
+		return settings.Visibility
+	}
+	return s.defaultVisibility
+}
+
+// SetVisibility 设置仓库可见性
+func (s *RepositoryService) SetVisibility(name, visibility, updatedBy string) (*RepositorySettings, error) {
+	if !ValidVisibility(visibility) {
+		return nil, ErrInvalidVisibility
+	}
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	s.load()
+
+	if err := dao.SetRepositoryVisibility(name, visibility, updatedBy); err != nil {
+		return nil, err
+	}
+
+	settings := &RepositorySettings{
+		Name:       name,
+		Visibility: visibility,
+		UpdatedBy:  updatedBy,
+		UpdatedAt:  time.Now(),
+	}
+	s.mu.Lock()
+	s.settings[name] = settings
+	s.mu.Unlock()
+	return settings, nil
+}
+
+// ListSettings 列出单独配置过的仓库
+func (s *RepositoryService) ListSettings() []*RepositorySettings {
+	s.load()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]*RepositorySettings, 0, len(s.settings))
+	for _, settings := range s.settings {
+		list = append(list, settings)
+	}
+	return list
+}
+
+// SetOrgService 设置用于计算组织和团队权限的组织服务
+func (s *RepositoryService) SetOrgService(orgs *OrgService) {
+	if orgs != nil {
+		s.orgs = orgs
+	}
+}
+
+// Permission 返回用户对仓库的权限，不考虑可见性
+func (s *RepositoryService) Permission(user *User, name string) string {
+	return s.orgs.RepositoryPermission(user, name)
+}
+
+// CanPull 判断用户能否拉取仓库，user 为 nil 表示匿名访问
+func (s *RepositoryService) CanPull(user *User, name string) bool {
+	switch s.GetVisibility(name) {
+	case VisibilityPublic:
+		return true
+	case VisibilityInternal:
+		return user != nil
+	default:
+		return s.hasPermission(user, name, RepoPermissionRead)
+	}
+}
+
+// CanPush 判断用户能否推送到仓库，不论可见性都需要 write 权限：管理员、
+// 同名用户、组织成员或被团队授予 write 的成员
+func (s *RepositoryService) CanPush(user *User, name string) bool {
+	return s.hasPermission(user, name, RepoPermissionWrite)
+}
+
+// CanDelete 判断用户能否删除仓库中的镜像，同样需要 write 权限，
+// 可见性只影响拉取
+func (s *RepositoryService) CanDelete(user *User, name string) bool {
+	return s.hasPermission(user, name, RepoPermissionWrite)
+}
+
+// CanManage 判断用户能否修改仓库设置，需要 admin 权限：管理员、同名用户、
+// 组织的所有者/管理员或被团队授予 admin 的成员
+func (s *RepositoryService) CanManage(user *User, name string) bool {
+	return s.hasPermission(user, name, RepoPermissionAdmin)
+}
+
+// hasPermission 判断用户对仓库的权限是否达到 required
+func (s *RepositoryService) hasPermission(user *User, name, required string) bool {
+	if user == nil {
+		return false
+	}
+	return repoPermissionRank[s.Permission(user, name)] >= repoPermissionRank[required]
+}
@@ -0,0 +1,102 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+)
+
+// StarredRepository 用户收藏的仓库
+type StarredRepository struct {
+	Name      string    `json:"name"`
+	StarCount int64     `json:"star_count"`
+	StarredAt time.Time `json:"starred_at"`
+}
+
+// RepositoryStars 仓库的收藏数及当前用户是否收藏
+type RepositoryStars struct {
+	Name      string `json:"name"`
+	StarCount int64  `json:"star_count"`
+	Starred   bool   `json:"starred"`
+}
+
+// Star 收藏仓库，重复收藏不报错
+func (s *RepositoryService) Star(user *User, name string) (*RepositoryStars, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	if err := dao.StarRepository(user.ID, name); err != nil {
+		return nil, err
+	}
+	return s.Stars(user, name)
+}
+
+// Unstar 取消收藏仓库
+func (s *RepositoryService) Unstar(user *User, name string) (*RepositoryStars, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	if err := dao.UnstarRepository(user.ID, name); err != nil {
+		return nil, err
+	}
+	return s.Stars(user, name)
+}
+
+// Stars 返回仓库的收藏数，user 不为 nil 时同时返回是否已收藏
+func (s *RepositoryService) Stars(user *User, name string) (*RepositoryStars, error) {
+	stars := &RepositoryStars{Name: name}
+	if dao.GetDB() == nil {
+		return stars, nil
+	}
+
+	counts, err := dao.CountRepositoryStars([]string{name})
+	if err != nil {
+		return nil, err
+	}
+	stars.StarCount = counts[name]
+
+	if user != nil {
+		if stars.Starred, err = dao.IsRepositoryStarred(user.ID, name); err != nil {
+			return nil, err
+		}
+	}
+	return stars, nil
+}
+
+// Starred 列出用户收藏的仓库，最近收藏的在前
+// 已无权访问的仓库不返回，收藏记录保留以便恢复权限后重新显示
+func (s *RepositoryService) Starred(user *User) ([]*StarredRepository, error) {
+	list := []*StarredRepository{}
+	if dao.GetDB() == nil {
+		return list, nil
+	}
+
+	records, err := dao.ListStarredRepositories(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var visible []*dao.RepositoryStarRecord
+	var names []string
+	for _, r := range records {
+		if s.CanPull(user, r.Repository) {
+			visible = append(visible, r)
+			names = append(names, r.Repository)
+		}
+	}
+	counts, err := dao.CountRepositoryStars(names)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range visible {
+		list = append(list, &StarredRepository{
+			Name:      r.Repository,
+			StarCount: counts[r.Repository],
+			StarredAt: r.CreatedAt,
+		})
+	}
+	return list, nil
+}
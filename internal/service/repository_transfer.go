@@ -0,0 +1,292 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// 仓库转移状态
+const (
+	TransferPending   = "pending"   // 等待接收方确认
+	TransferCompleted = "completed" // 已转移
+	TransferRejected  = "rejected"  // 接收方拒绝
+	TransferCancelled = "cancelled" // 发起方取消
+)
+
+var (
+	// ErrTransferForbidden 无权转移仓库或处理转移请求
+	ErrTransferForbidden = errors.New("not allowed to transfer the repository")
+	// ErrTransferNotFound 转移请求不存在或已处理
+	ErrTransferNotFound = errors.New("transfer not found or no longer pending")
+	// ErrTransferPending 仓库已有待确认的转移请求
+	ErrTransferPending = errors.New("repository already has a pending transfer")
+	// ErrNamespaceNotFound 目标命名空间不是已有的用户或组织
+	ErrNamespaceNotFound = errors.New("target namespace is neither a user nor an organization")
+)
+
+// RepositoryMover 移动镜像仓库的全部标签，由镜像仓库服务实现
+type RepositoryMover interface {
+	RepositoryExists(name string) bool
+	RenameRepository(from, to string) error
+}
+
+// RepositoryTransfer 仓库转移请求
+type RepositoryTransfer struct {
+	ID              int64      `json:"id"`
+	Repository      string     `json:"repository"`
+	TargetNamespace string     `json:"target_namespace"`
+	NewName         string     `json:"new_name"`
+	RequestedBy     string     `json:"requested_by"`
+	Status          string     `json:"status"`
+	ResolvedBy      string     `json:"resolved_by,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	ResolvedAt      *time.Time `json:"resolved_at,omitempty"`
+}
+
+// SetRepositoryMover 设置仓库转移时移动镜像的实现
+func (s *RepositoryService) SetRepositoryMover(mover RepositoryMover) {
+	s.mover = mover
+}
+
+// TransferName 返回仓库转移到 namespace 后的名称，仓库名的第一段替换为
+// 目标命名空间，没有命名空间的仓库加上前缀
+func TransferName(name, namespace string) string {
+	if _, rest, ok := strings.Cut(name, "/"); ok {
+		return namespace + "/" + rest
+	}
+	return namespace + "/" + name
+}
+
+// RequestTransfer 发起仓库转移，需要仓库的管理权限
+// 转移到组织时发起人须能管理组织中的新仓库，立即完成；转移到其他用户时
+// 需要接收方确认，返回待确认的请求
+func (s *RepositoryService) RequestTransfer(user *User, name, namespace string) (*RepositoryTransfer, error) {
+	if dao.GetDB() == nil || s.mover == nil {
+		return nil, errors.New("repository transfer is not available")
+	}
+	if !s.CanManage(user, name) {
+		return nil, ErrTransferForbidden
+	}
+	if !s.mover.RepositoryExists(name) {
+		return nil, fmt.Errorf("image not found: %s", name)
+	}
+
+	namespace = strings.TrimSpace(namespace)
+	if namespace == "" || strings.Contains(namespace, "/") {
+		return nil, fmt.Errorf("invalid namespace: %q", namespace)
+	}
+	newName := TransferName(name, namespace)
+	if newName == name {
+		return nil, fmt.Errorf("repository is already in namespace %s", namespace)
+	}
+	if s.mover.RepositoryExists(newName) {
+		return nil, fmt.Errorf("repository already exists: %s", newName)
+	}
+
+	pending, err := dao.GetPendingRepositoryTransfer(name)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, ErrTransferPending
+	}
+
+	record := &dao.RepositoryTransferRecord{
+		Repository:      name,
+		TargetNamespace: namespace,
+		NewName:         newName,
+		RequestedBy:     user.Username,
+		RequestedByID:   user.ID,
+		Status:          TransferPending,
+		CreatedAt:       time.Now(),
+	}
+
+	// 目标为组织时由组织的管理权限代替接收方确认
+	org, err := dao.GetOrganizationByName(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if org != nil {
+		if !s.CanManage(user, newName) {
+			return nil, ErrTransferForbidden
+		}
+		if err := dao.CreateRepositoryTransfer(record); err != nil {
+			return nil, err
+		}
+		return s.completeTransfer(record, user.Username)
+	}
+
+	target, err := dao.GetUserByUsername(namespace)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil || !target.IsActive {
+		return nil, ErrNamespaceNotFound
+	}
+	if err := dao.CreateRepositoryTransfer(record); err != nil {
+		return nil, err
+	}
+	if target.ID == user.ID {
+		return s.completeTransfer(record, user.Username)
+	}
+	return transferFromRecord(record), nil
+}
+
+// AcceptTransfer 接收方确认转移，发起人须仍有仓库的管理权限
+func (s *RepositoryService) AcceptTransfer(user *User, id int64) (*RepositoryTransfer, error) {
+	if s.mover == nil {
+		return nil, errors.New("repository transfer is not available")
+	}
+	record, err := s.pendingTransfer(id)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.Username != record.TargetNamespace {
+		return nil, ErrTransferForbidden
+	}
+
+	requester, err := dao.GetUserByID(record.RequestedByID)
+	if err != nil {
+		return nil, err
+	}
+	if requester == nil || !requester.IsActive || !s.CanManage(&User{
+		ID:       requester.ID,
+		Username: requester.Username,
+		Role:     requester.Role,
+		IsActive: requester.IsActive,
+	}, record.Repository) {
+		return nil, ErrTransferForbidden
+	}
+	if s.mover.RepositoryExists(record.NewName) {
+		return nil, fmt.Errorf("repository already exists: %s", record.NewName)
+	}
+
+	return s.completeTransfer(record, user.Username)
+}
+
+// RejectTransfer 接收方拒绝或发起方取消待确认的转移
+func (s *RepositoryService) RejectTransfer(user *User, id int64) (*RepositoryTransfer, error) {
+	record, err := s.pendingTransfer(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	switch {
+	case user == nil:
+		return nil, ErrTransferForbidden
+	case user.Username == record.TargetNamespace:
+		status = TransferRejected
+	case user.ID == record.RequestedByID || user.Role == "admin":
+		status = TransferCancelled
+	default:
+		return nil, ErrTransferForbidden
+	}
+
+	ok, err := dao.ResolveRepositoryTransfer(id, status, user.Username)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTransferNotFound
+	}
+	record.Status = status
+	record.ResolvedBy = user.Username
+	now := time.Now()
+	record.ResolvedAt = &now
+	return transferFromRecord(record), nil
+}
+
+// ListTransfers 列出用户发起或转移给用户的请求，all 为 false 时只列出待确认的
+func (s *RepositoryService) ListTransfers(user *User, all bool) ([]*RepositoryTransfer, error) {
+	list := []*RepositoryTransfer{}
+	if dao.GetDB() == nil {
+		return list, nil
+	}
+
+	records, err := dao.ListRepositoryTransfers(user.Username, user.Username, all)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range records {
+		list = append(list, transferFromRecord(r))
+	}
+	return list, nil
+}
+
+// pendingTransfer 读取待确认的转移请求
+func (s *RepositoryService) pendingTransfer(id int64) (*dao.RepositoryTransferRecord, error) {
+	if dao.GetDB() == nil {
+		return nil, errors.New("database not initialized")
+	}
+	record, err := dao.GetRepositoryTransfer(id)
+	if err != nil {
+		return nil, err
+	}
+	if record == nil || record.Status != TransferPending {
+		return nil, ErrTransferNotFound
+	}
+	return record, nil
+}
+
+// completeTransfer 移动镜像并更新数据库中对仓库的引用
+// 数据库更新失败时把镜像移回原仓库，两边保持一致
+func (s *RepositoryService) completeTransfer(record *dao.RepositoryTransferRecord, resolvedBy string) (*RepositoryTransfer, error) {
+	if err := s.mover.RenameRepository(record.Repository, record.NewName); err != nil {
+		return nil, err
+	}
+
+	if err := dao.RenameRepositoryReferences(record.Repository, record.NewName); err != nil {
+		if rerr := s.mover.RenameRepository(record.NewName, record.Repository); rerr != nil && s.logger != nil {
+			s.logger.Error("仓库转移回滚失败",
+				zap.String("from", record.Repository),
+				zap.String("to", record.NewName),
+				zap.Error(rerr),
+			)
+		}
+		return nil, err
+	}
+
+	if _, err := dao.ResolveRepositoryTransfer(record.ID, TransferCompleted, resolvedBy); err != nil && s.logger != nil {
+		s.logger.Warn("更新仓库转移状态失败", zap.Int64("id", record.ID), zap.Error(err))
+	}
+
+	// 可见性缓存按仓库名索引
+	s.mu.Lock()
+	if settings, ok := s.settings[record.Repository]; ok {
+		delete(s.settings, record.Repository)
+		settings.Name = record.NewName
+		s.settings[record.NewName] = settings
+	} else {
+		delete(s.settings, record.NewName)
+	}
+	s.mu.Unlock()
+
+	record.Status = TransferCompleted
+	record.ResolvedBy = resolvedBy
+	now := time.Now()
+	record.ResolvedAt = &now
+	return transferFromRecord(record), nil
+}
+
+// transferFromRecord 转换数据库记录
+func transferFromRecord(r *dao.RepositoryTransferRecord) *RepositoryTransfer {
+	return &RepositoryTransfer{
+		ID:              r.ID,
+		Repository:      r.Repository,
+		TargetNamespace: r.TargetNamespace,
+		NewName:         r.NewName,
+		RequestedBy:     r.RequestedBy,
+		Status:          r.Status,
+		ResolvedBy:      r.ResolvedBy,
+		CreatedAt:       r.CreatedAt,
+		ResolvedAt:      r.ResolvedAt,
+	}
+}
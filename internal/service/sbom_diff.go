@@ -0,0 +1,290 @@
+package service
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SBOMDiff compares the SBOMs of two images, e.g. two releases.
+type SBOMDiff struct {
+	From       string          `json:"from"`
+	To         string          `json:"to"`
+	FromDigest string          `json:"from_digest,omitempty"`
+	ToDigest   string          `json:"to_digest,omitempty"`
+	ComparedAt time.Time       `json:"compared_at"`
+	Added      []SBOMPackage   `json:"added"`
+	Removed    []SBOMPackage   `json:"removed"`
+	Upgraded   []PackageChange `json:"upgraded"`
+	Downgraded []PackageChange `json:"downgraded"`
+	Summary    SBOMDiffSummary `json:"summary"`
+
+	// NewVulnerabilities are found in to but not in from, FixedVulnerabilities
+	// the other way round.
+	NewVulnerabilities   []Vulnerability `json:"new_vulnerabilities"`
+	FixedVulnerabilities []Vulnerability `json:"fixed_vulnerabilities"`
+	NewVulnSummary       VulnSummary     `json:"new_vulnerability_summary"`
+}
+
+// PackageChange is a package whose version differs between two SBOMs.
+type PackageChange struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	FromVersion string `json:"from_version"`
+	ToVersion   string `json:"to_version"`
+	License     string `json:"license,omitempty"`
+	PURL        string `json:"purl,omitempty"`
+}
+
+// SBOMDiffSummary counts the package changes.
+type SBOMDiffSummary struct {
+	Added      int `json:"added"`
+	Removed    int `json:"removed"`
+	Upgraded   int `json:"upgraded"`
+	Downgraded int `json:"downgraded"`
+	Unchanged  int `json:"unchanged"`
+}
+
+// SBOMRepository returns the repository of an SBOM image reference, e.g.
+// "team/app" for "team/app:1.0" or "team/app@sha256:...".
+func SBOMRepository(imageRef string) string {
+	name, _ := splitImageRef(strings.SplitN(imageRef, "@", 2)[0])
+	return name
+}
+
+// DiffSBOMs compares the stored SBOMs of two image references. Packages are
+// matched by type and name; a package present in one version on each side
+// is a version change, other versions count as added or removed.
+func (s *SBOMService) DiffSBOMs(from, to string) (*SBOMDiff, error) {
+	fromSBOM, err := s.GetSBOM(from)
+	if err != nil {
+		return nil, err
+	}
+	toSBOM, err := s.GetSBOM(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &SBOMDiff{
+		From:                 from,
+		To:                   to,
+		FromDigest:           fromSBOM.Digest,
+		ToDigest:             toSBOM.Digest,
+		ComparedAt:           time.Now(),
+		Added:                []SBOMPackage{},
+		Removed:              []SBOMPackage{},
+		Upgraded:             []PackageChange{},
+		Downgraded:           []PackageChange{},
+		NewVulnerabilities:   []Vulnerability{},
+		FixedVulnerabilities: []Vulnerability{},
+	}
+
+	fromPkgs := packagesByName(fromSBOM.Packages)
+	toPkgs := packagesByName(toSBOM.Packages)
+	for key, before := range fromPkgs {
+		after := toPkgs[key]
+		before, after = dropCommonVersions(before, after)
+		diff.Summary.Unchanged += len(fromPkgs[key]) - len(before)
+		if len(before) == 1 && len(after) == 1 {
+			change := PackageChange{
+				Name:        after[0].Name,
+				Type:        after[0].Type,
+				FromVersion: before[0].Version,
+				ToVersion:   after[0].Version,
+				License:     after[0].License,
+				PURL:        after[0].PURL,
+			}
+			if comparePackageVersions(change.FromVersion, change.ToVersion) > 0 {
+				diff.Downgraded = append(diff.Downgraded, change)
+			} else {
+				diff.Upgraded = append(diff.Upgraded, change)
+			}
+			continue
+		}
+		diff.Removed = append(diff.Removed, before...)
+		diff.Added = append(diff.Added, after...)
+	}
+	for key, after := range toPkgs {
+		if _, ok := fromPkgs[key]; !ok {
+			diff.Added = append(diff.Added, after...)
+		}
+	}
+
+	diff.NewVulnerabilities = vulnerabilitiesNotIn(toSBOM.Vulnerabilities, fromSBOM.Vulnerabilities)
+	diff.FixedVulnerabilities = vulnerabilitiesNotIn(fromSBOM.Vulnerabilities, toSBOM.Vulnerabilities)
+	for _, v := range diff.NewVulnerabilities {
+		countSeverity(&diff.NewVulnSummary, v.Severity)
+	}
+
+	sortPackages(diff.Added)
+	sortPackages(diff.Removed)
+	sortPackageChanges(diff.Upgraded)
+	sortPackageChanges(diff.Downgraded)
+	diff.Summary.Added = len(diff.Added)
+	diff.Summary.Removed = len(diff.Removed)
+	diff.Summary.Upgraded = len(diff.Upgraded)
+	diff.Summary.Downgraded = len(diff.Downgraded)
+	return diff, nil
+}
+
+// packagesByName groups packages by type and name.
+func packagesByName(pkgs []SBOMPackage) map[string][]SBOMPackage {
+	byName := make(map[string][]SBOMPackage)
+	for _, p := range pkgs {
+		key := p.Type + "/" + p.Name
+		byName[key] = append(byName[key], p)
+	}
+	return byName
+}
+
+// dropCommonVersions removes the versions present on both sides.
+func dropCommonVersions(before, after []SBOMPackage) ([]SBOMPackage, []SBOMPackage) {
+	remaining := make(map[string]int)
+	for _, p := range after {
+		remaining[p.Version]++
+	}
+	var keptBefore []SBOMPackage
+	common := make(map[string]int)
+	for _, p := range before {
+		if remaining[p.Version] > 0 {
+			remaining[p.Version]--
+			common[p.Version]++
+			continue
+		}
+		keptBefore = append(keptBefore, p)
+	}
+	var keptAfter []SBOMPackage
+	for _, p := range after {
+		if common[p.Version] > 0 {
+			common[p.Version]--
+			continue
+		}
+		keptAfter = append(keptAfter, p)
+	}
+	return keptBefore, keptAfter
+}
+
+// vulnerabilitiesNotIn returns the vulnerabilities of vulns, by ID and
+// package, that are not in other.
+func vulnerabilitiesNotIn(vulns, other []Vulnerability) []Vulnerability {
+	known := make(map[string]bool, len(other))
+	for _, v := range other {
+		known[v.ID+"|"+v.Package] = true
+	}
+	result := []Vulnerability{}
+	for _, v := range vulns {
+		if !known[v.ID+"|"+v.Package] {
+			result = append(result, v)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if a, b := severityRank(result[i].Severity), severityRank(result[j].Severity); a != b {
+			return a > b
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// countSeverity adds a vulnerability of severity to summary.
+func countSeverity(summary *VulnSummary, severity string) {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL":
+		summary.Critical++
+	case "HIGH":
+		summary.High++
+	case "MEDIUM":
+		summary.Medium++
+	case "LOW":
+		summary.Low++
+	}
+	summary.Total++
+}
+
+// severityRank orders severities, CRITICAL highest.
+func severityRank(severity string) int {
+	switch strings.ToUpper(severity) {
+	case "CRITICAL":
+		return 4
+	case "HIGH":
+		return 3
+	case "MEDIUM":
+		return 2
+	case "LOW":
+		return 1
+	}
+	return 0
+}
+
+func sortPackages(pkgs []SBOMPackage) {
+	sort.Slice(pkgs, func(i, j int) bool {
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		return pkgs[i].Version < pkgs[j].Version
+	})
+}
+
+func sortPackageChanges(changes []PackageChange) {
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Name != changes[j].Name {
+			return changes[i].Name < changes[j].Name
+		}
+		return changes[i].Type < changes[j].Type
+	})
+}
+
+// comparePackageVersions compares package versions of any ecosystem by
+// their runs of digits and other characters: digits numerically, others
+// as strings, so 1.10.0 > 1.9.2 and 2.36-r1 > 2.36.
+func comparePackageVersions(a, b string) int {
+	ra, rb := versionRuns(strings.TrimPrefix(a, "v")), versionRuns(strings.TrimPrefix(b, "v"))
+	for i := 0; i < len(ra) && i < len(rb); i++ {
+		x, y := ra[i], rb[i]
+		nx, errX := strconv.ParseUint(x, 10, 64)
+		ny, errY := strconv.ParseUint(y, 10, 64)
+		switch {
+		case errX == nil && errY == nil:
+			if nx != ny {
+				if nx < ny {
+					return -1
+				}
+				return 1
+			}
+		case x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(ra) < len(rb):
+		return -1
+	case len(ra) > len(rb):
+		return 1
+	}
+	return 0
+}
+
+// versionRuns splits a version into runs of digits and of other
+// characters.
+func versionRuns(v string) []string {
+	var runs []string
+	start := 0
+	for i := 1; i <= len(v); i++ {
+		if i == len(v) || isDigit(v[i]) != isDigit(v[start]) {
+			runs = append(runs, v[start:i])
+			start = i
+		}
+	}
+	return runs
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
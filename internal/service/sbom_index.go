@@ -0,0 +1,279 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 包搜索的返回数量
+const (
+	DefaultPackageSearchLimit = 100
+	MaxPackageSearchLimit     = 1000
+)
+
+// ErrInvalidVersionConstraint is returned for a version filter that does
+// not parse.
+var ErrInvalidVersionConstraint = errors.New("invalid version constraint")
+
+// PackageSearchQuery searches the packages of all stored SBOMs.
+type PackageSearchQuery struct {
+	Package string // 包名，默认不区分大小写的子串匹配
+	Version string // 版本约束，如 <2.17、>=2.0,<2.17.1 或 2.14.1
+	Type    string // 包类型，如 maven、npm
+	Exact   bool   // 包名完全匹配
+	Limit   int
+}
+
+// PackageMatch is a package found in the SBOM of an image.
+type PackageMatch struct {
+	ImageRef        string      `json:"image_ref"`
+	Digest          string      `json:"digest,omitempty"`
+	Package         SBOMPackage `json:"package"`
+	Vulnerabilities []string    `json:"vulnerabilities,omitempty"` // 该包在镜像中的已知漏洞
+}
+
+// PackageSearchResult lists the images containing the searched packages.
+type PackageSearchResult struct {
+	Package string          `json:"package"`
+	Version string          `json:"version,omitempty"`
+	Total   int             `json:"total"`
+	Images  int             `json:"images"` // 命中的不同镜像数
+	Results []*PackageMatch `json:"results"`
+}
+
+// indexedPackage is a package of an SBOM in the index.
+type indexedPackage struct {
+	sbom *SBOM
+	pkg  *SBOMPackage
+}
+
+// packageIndex maps lowercased package names to the SBOMs containing them,
+// so searches do not read every SBOM.
+type packageIndex struct {
+	mu      sync.RWMutex
+	byName  map[string][]indexedPackage
+	byImage map[string]*SBOM // 已索引的 SBOM，替换和删除时用于移除旧条目
+}
+
+func newPackageIndex() *packageIndex {
+	return &packageIndex{
+		byName:  make(map[string][]indexedPackage),
+		byImage: make(map[string]*SBOM),
+	}
+}
+
+// add indexes sbom, replacing the SBOM indexed for the same image.
+func (x *packageIndex) add(sbom *SBOM) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeUnsafe(sbom.ImageRef)
+	x.byImage[sbom.ImageRef] = sbom
+	for i := range sbom.Packages {
+		name := strings.ToLower(sbom.Packages[i].Name)
+		x.byName[name] = append(x.byName[name], indexedPackage{sbom: sbom, pkg: &sbom.Packages[i]})
+	}
+}
+
+// remove drops the SBOM of an image from the index.
+func (x *packageIndex) remove(imageRef string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeUnsafe(imageRef)
+}
+
+func (x *packageIndex) removeUnsafe(imageRef string) {
+	old, ok := x.byImage[imageRef]
+	if !ok {
+		return
+	}
+	delete(x.byImage, imageRef)
+	for _, p := range old.Packages {
+		name := strings.ToLower(p.Name)
+		entries := x.byName[name]
+		kept := entries[:0]
+		for _, e := range entries {
+			if e.sbom != old {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(x.byName, name)
+		} else {
+			x.byName[name] = kept
+		}
+	}
+}
+
+// imagesWith returns the indexed SBOMs containing a package of names,
+// ordered by image.
+func (x *packageIndex) imagesWith(names map[string]bool) []*SBOM {
+	x.mu.RLock()
+	seen := make(map[*SBOM]bool)
+	var sboms []*SBOM
+	for name := range names {
+		for _, e := range x.byName[name] {
+			if !seen[e.sbom] {
+				seen[e.sbom] = true
+				sboms = append(sboms, e.sbom)
+			}
+		}
+	}
+	x.mu.RUnlock()
+	sort.Slice(sboms, func(i, j int) bool {
+		return sboms[i].ImageRef < sboms[j].ImageRef
+	})
+	return sboms
+}
+
+// SearchPackages returns the images whose SBOM contains a package matching
+// the query, e.g. every image with log4j-core below 2.17, ordered by image.
+// Only images whose repository passes include are searched.
+func (s *SBOMService) SearchPackages(q *PackageSearchQuery, include func(repository string) bool) (*PackageSearchResult, error) {
+	name := strings.ToLower(strings.TrimSpace(q.Package))
+	if name == "" {
+		return nil, errors.New("package is required")
+	}
+	constraint, err := parseVersionConstraint(q.Version)
+	if err != nil {
+		return nil, err
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPackageSearchLimit
+	}
+	if limit > MaxPackageSearchLimit {
+		limit = MaxPackageSearchLimit
+	}
+
+	var matches []*PackageMatch
+	s.index.mu.RLock()
+	for indexed, entries := range s.index.byName {
+		if indexed != name && (q.Exact || !strings.Contains(indexed, name)) {
+			continue
+		}
+		for _, e := range entries {
+			if include != nil && !include(SBOMRepository(e.sbom.ImageRef)) {
+				continue
+			}
+			if q.Type != "" && !strings.EqualFold(e.pkg.Type, q.Type) {
+				continue
+			}
+			if !constraint.matches(e.pkg.Version) {
+				continue
+			}
+			matches = append(matches, &PackageMatch{
+				ImageRef:        e.sbom.ImageRef,
+				Digest:          e.sbom.Digest,
+				Package:         *e.pkg,
+				Vulnerabilities: packageVulnerabilities(e.sbom, e.pkg),
+			})
+		}
+	}
+	s.index.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].ImageRef != matches[j].ImageRef {
+			return matches[i].ImageRef < matches[j].ImageRef
+		}
+		if matches[i].Package.Name != matches[j].Package.Name {
+			return matches[i].Package.Name < matches[j].Package.Name
+		}
+		return comparePackageVersions(matches[i].Package.Version, matches[j].Package.Version) < 0
+	})
+
+	images := make(map[string]bool)
+	for _, m := range matches {
+		images[m.ImageRef] = true
+	}
+	result := &PackageSearchResult{
+		Package: q.Package,
+		Version: q.Version,
+		Total:   len(matches),
+		Images:  len(images),
+		Results: matches,
+	}
+	if len(result.Results) > limit {
+		result.Results = result.Results[:limit]
+	}
+	if result.Results == nil {
+		result.Results = []*PackageMatch{}
+	}
+	return result, nil
+}
+
+// packageVulnerabilities returns the IDs of the vulnerabilities the SBOM
+// records for a package version.
+func packageVulnerabilities(sbom *SBOM, pkg *SBOMPackage) []string {
+	var ids []string
+	for _, v := range sbom.Vulnerabilities {
+		if v.Package == pkg.Name && (v.Version == "" || v.Version == pkg.Version) {
+			ids = append(ids, v.ID)
+		}
+	}
+	return ids
+}
+
+// versionConstraint is a list of conditions a version must all meet.
+type versionConstraint []versionCondition
+
+type versionCondition struct {
+	op      string
+	version string
+}
+
+// parseVersionConstraint parses comma-separated conditions like
+// ">=2.0,<2.17"; a version without operator must match exactly.
+func parseVersionConstraint(expr string) (versionConstraint, error) {
+	var constraint versionConstraint
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op := "="
+		for _, candidate := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(part, candidate) {
+				op = candidate
+				part = strings.TrimSpace(part[len(candidate):])
+				break
+			}
+		}
+		if op == "==" {
+			op = "="
+		}
+		if part == "" || strings.ContainsAny(part, "<>=! ") {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidVersionConstraint, expr)
+		}
+		constraint = append(constraint, versionCondition{op: op, version: part})
+	}
+	return constraint, nil
+}
+
+// matches reports whether version meets every condition.
+func (c versionConstraint) matches(version string) bool {
+	for _, cond := range c {
+		cmp := comparePackageVersions(version, cond.version)
+		var ok bool
+		switch cond.op {
+		case "=":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -4,8 +4,10 @@ package service
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -16,8 +18,13 @@ import (
 type SBOMService struct {
 	storagePath string
 	sboms       sync.Map // map[imageRef]*SBOM
+	index       *packageIndex
 	logger      *zap.Logger
 	config      *SBOMConfig
+	generatorMu sync.RWMutex // 保护 config.Generator 和 commandEnv，可通过设置接口修改
+	onEvent     func(event string, data map[string]interface{})
+	commandEnv  []string // 生成器和扫描器进程的环境变量，nil 时继承
+	vulnDB      *VulnDB  // 配置后扫描时匹配漏洞库
 }
 
 // SBOMConfig holds SBOM configuration.
@@ -118,16 +125,114 @@ func NewSBOMService(config *SBOMConfig, logger *zap.Logger) *SBOMService {
 		storagePath: config.StoragePath,
 		logger:      logger,
 		config:      config,
+		index:       newPackageIndex(),
 	}
 
 	// Ensure storage directory exists
 	if config.StoragePath != "" {
 		os.MkdirAll(config.StoragePath, 0755)
+		s.loadStoredSBOMs()
 	}
 
 	return s
 }
 
+// loadStoredSBOMs caches the SBOMs persisted by earlier runs, so listings
+// and reports cover them without being asked for each image first.
+func (s *SBOMService) loadStoredSBOMs() {
+	files, err := filepath.Glob(filepath.Join(s.storagePath, "*.sbom.json"))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var sbom SBOM
+		if err := json.Unmarshal(data, &sbom); err != nil || sbom.ImageRef == "" {
+			continue
+		}
+		s.store(&sbom)
+	}
+}
+
+// store caches a SBOM and indexes its packages for search.
+func (s *SBOMService) store(sbom *SBOM) {
+	s.sboms.Store(sbom.ImageRef, sbom)
+	s.index.add(sbom)
+}
+
+// replace swaps the cached SBOM of an image for updated, unless it was
+// replaced since old was read.
+func (s *SBOMService) replace(old, updated *SBOM) bool {
+	if !s.sboms.CompareAndSwap(old.ImageRef, old, updated) {
+		return false
+	}
+	s.index.add(updated)
+	return true
+}
+
+// SetVulnDB sets the vulnerability database matched by scans.
+func (s *SBOMService) SetVulnDB(db *VulnDB) {
+	s.vulnDB = db
+}
+
+// AllSBOMs returns every stored SBOM, ordered by image reference.
+func (s *SBOMService) AllSBOMs() []*SBOM {
+	var sboms []*SBOM
+	s.sboms.Range(func(key, value interface{}) bool {
+		sboms = append(sboms, value.(*SBOM))
+		return true
+	})
+	sort.Slice(sboms, func(i, j int) bool {
+		return sboms[i].ImageRef < sboms[j].ImageRef
+	})
+	return sboms
+}
+
+// SetGenerator sets the SBOM generator: syft or trivy.
+func (s *SBOMService) SetGenerator(generator string) error {
+	switch generator {
+	case "syft", "trivy":
+	default:
+		return fmt.Errorf("invalid sbom generator: %s", generator)
+	}
+	s.generatorMu.Lock()
+	s.config.Generator = generator
+	s.generatorMu.Unlock()
+	return nil
+}
+
+// Generator returns the SBOM generator.
+func (s *SBOMService) Generator() string {
+	s.generatorMu.RLock()
+	defer s.generatorMu.RUnlock()
+	return s.config.Generator
+}
+
+// SetCommandEnv sets the environment of the generator and scanner
+// processes, which carries the scanner proxy for vulnerability database
+// downloads. nil inherits the environment.
+func (s *SBOMService) SetCommandEnv(env []string) {
+	s.generatorMu.Lock()
+	s.commandEnv = env
+	s.generatorMu.Unlock()
+}
+
+// CommandEnv returns the environment of the generator and scanner processes.
+func (s *SBOMService) CommandEnv() []string {
+	s.generatorMu.RLock()
+	defer s.generatorMu.RUnlock()
+	return s.commandEnv
+}
+
+// SetEventHandler sets the function that receives SBOM generation and scan
+// completion events.
+func (s *SBOMService) SetEventHandler(fn func(event string, data map[string]interface{})) {
+	s.onEvent = fn
+}
+
 // GenerateSBOM generates a SBOM for an image.
 func (s *SBOMService) GenerateSBOM(req *GenerateSBOMRequest) (*SBOM, error) {
 	if !s.config.Enabled {
@@ -141,20 +246,21 @@ func (s *SBOMService) GenerateSBOM(req *GenerateSBOMRequest) (*SBOM, error) {
 
 	// In production, this would call syft/trivy to generate actual SBOM
 	// For now, create a placeholder SBOM
+	generator := s.Generator()
 	sbom := &SBOM{
 		ImageRef:    req.ImageRef,
 		Format:      format,
-		Generator:   s.config.Generator,
+		Generator:   generator,
 		GeneratedAt: time.Now(),
 		Packages:    []SBOMPackage{},
 		Metadata: map[string]string{
-			"tool":    s.config.Generator,
+			"tool":    generator,
 			"version": "1.0.0",
 		},
 	}
 
 	// Store SBOM
-	s.sboms.Store(req.ImageRef, sbom)
+	s.store(sbom)
 
 	// Persist to disk
 	s.persistSBOM(sbom)
@@ -165,6 +271,13 @@ func (s *SBOMService) GenerateSBOM(req *GenerateSBOMRequest) (*SBOM, error) {
 			zap.String("format", format),
 		)
 	}
+	if s.onEvent != nil {
+		s.onEvent("sbom_generated", map[string]interface{}{
+			"image":    req.ImageRef,
+			"format":   format,
+			"packages": len(sbom.Packages),
+		})
+	}
 
 	return sbom, nil
 }
@@ -210,6 +323,7 @@ func (s *SBOMService) ListSBOMs(page, pageSize int) ([]*SBOM, int, error) {
 // DeleteSBOM deletes a SBOM.
 func (s *SBOMService) DeleteSBOM(imageRef string) error {
 	s.sboms.Delete(imageRef)
+	s.index.remove(imageRef)
 
 	// Remove from disk
 	filename := s.getSBOMFilename(imageRef)
@@ -243,7 +357,17 @@ func (s *SBOMService) ScanVulnerabilities(req *ScanVulnRequest) (*VulnScanResult
 	// Update SBOM with vulnerabilities
 	if sbom, ok := s.sboms.Load(req.ImageRef); ok {
 		sbomData := sbom.(*SBOM)
+		if s.vulnDB != nil {
+			result.Vulnerabilities = s.vulnDB.Match(sbomData)
+			for _, v := range result.Vulnerabilities {
+				countSeverity(&result.Summary, v.Severity)
+			}
+		}
 		sbomData.Vulnerabilities = result.Vulnerabilities
+		if sbomData.Metadata == nil {
+			sbomData.Metadata = make(map[string]string)
+		}
+		sbomData.Metadata["scanned_at"] = result.ScannedAt.UTC().Format(time.RFC3339)
 		s.persistSBOM(sbomData)
 	}
 
@@ -253,6 +377,13 @@ func (s *SBOMService) ScanVulnerabilities(req *ScanVulnRequest) (*VulnScanResult
 			zap.Int("total", result.Summary.Total),
 		)
 	}
+	if s.onEvent != nil {
+		s.onEvent("scan_completed", map[string]interface{}{
+			"image":   req.ImageRef,
+			"scanner": result.Scanner,
+			"summary": result.Summary,
+		})
+	}
 
 	return result, nil
 }
@@ -308,7 +439,10 @@ func (s *SBOMService) loadSBOM(imageRef string) *SBOM {
 	}
 
 	// Cache it
-	s.sboms.Store(imageRef, &sbom)
+	if sbom.ImageRef == "" {
+		sbom.ImageRef = imageRef
+	}
+	s.store(&sbom)
 
 	return &sbom
 }
@@ -0,0 +1,379 @@
+package service
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+	"cyp-docker-registry/pkg/signature"
+
+	"go.uber.org/zap"
+)
+
+// 安全概览的入侵事件时间窗口和列表长度
+const (
+	DefaultSecurityWindow = 24 * time.Hour
+	MaxSecurityWindow     = 30 * 24 * time.Hour
+	securityListLimit     = 50
+	recentIntrusionLimit  = 10
+)
+
+// TaggedImage is a tag of a repository.
+type TaggedImage struct {
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest"`
+	PushedAt   time.Time `json:"pushed_at"`
+}
+
+// QuarantinedBlob is a corrupted blob in quarantine that was not repaired
+// yet; images referencing it cannot be pulled.
+type QuarantinedBlob struct {
+	Digest       string    `json:"digest"`
+	Problem      string    `json:"problem"`
+	Repositories []string  `json:"repositories,omitempty"`
+	FoundAt      time.Time `json:"found_at"`
+	RepairError  string    `json:"repair_error,omitempty"`
+}
+
+// SecurityImageSource lists the images and quarantined blobs of the
+// registry.
+type SecurityImageSource interface {
+	TaggedImages() ([]TaggedImage, error)
+	QuarantinedBlobs() ([]QuarantinedBlob, error)
+}
+
+// TUFStatusSource reports the TUF metadata of the repository.
+type TUFStatusSource interface {
+	GetStatus() *signature.TUFStatus
+}
+
+// SecurityOverview summarizes the security state for the dashboard.
+type SecurityOverview struct {
+	GeneratedAt     time.Time          `json:"generated_at"`
+	Window          string             `json:"window"` // 入侵事件的统计窗口
+	Vulnerabilities VulnOverview       `json:"vulnerabilities"`
+	Signatures      SignatureOverview  `json:"signatures"`
+	Quarantine      QuarantineOverview `json:"quarantine"`
+	TUF             TUFOverview        `json:"tuf"`
+	Intrusions      IntrusionOverview  `json:"intrusions"`
+	Lock            *LockStatus        `json:"lock,omitempty"`
+	Errors          map[string]string  `json:"errors,omitempty"` // 未能汇总的部分及原因
+}
+
+// VulnOverview counts the open critical and high vulnerabilities recorded
+// in the SBOMs of the images.
+type VulnOverview struct {
+	Critical     int               `json:"critical"` // 去重后的漏洞编号数
+	High         int               `json:"high"`
+	Repositories int               `json:"repositories"` // 有严重或高危漏洞的仓库数
+	ByRepository []RepositoryVulns `json:"by_repository"`
+}
+
+// RepositoryVulns counts the critical and high vulnerabilities of the
+// images of a repository.
+type RepositoryVulns struct {
+	Repository string   `json:"repository"`
+	Critical   int      `json:"critical"`
+	High       int      `json:"high"`
+	Images     []string `json:"images"` // 有严重或高危漏洞的镜像
+}
+
+// SignatureOverview lists the images without a valid signature.
+type SignatureOverview struct {
+	Mode           string   `json:"mode"`
+	Images         int      `json:"images"`
+	Unsigned       int      `json:"unsigned"`
+	UnsignedImages []string `json:"unsigned_images"` // 最多 50 个
+}
+
+// QuarantineOverview lists the quarantined blobs and the repositories
+// whose images reference them.
+type QuarantineOverview struct {
+	Blobs        int               `json:"blobs"`
+	Repositories []string          `json:"repositories"`
+	Items        []QuarantinedBlob `json:"items"`
+}
+
+// TUFOverview reports the expiry of the TUF metadata.
+type TUFOverview struct {
+	Enabled     bool            `json:"enabled"`
+	Initialized bool            `json:"initialized"`
+	Expired     []string        `json:"expired"` // 已过期的角色
+	Roles       []TUFRoleExpiry `json:"roles,omitempty"`
+}
+
+// TUFRoleExpiry is the expiry of the metadata of a TUF role.
+type TUFRoleExpiry struct {
+	Role    string    `json:"role"`
+	Version int       `json:"version"`
+	Expires time.Time `json:"expires"`
+	Expired bool      `json:"expired"`
+}
+
+// IntrusionOverview counts the intrusion rules triggered in the window.
+type IntrusionOverview struct {
+	Total  int               `json:"total"`
+	Recent []*IntrusionEvent `json:"recent"` // 最近 10 条
+}
+
+// IntrusionEvent is a triggered intrusion rule.
+type IntrusionEvent struct {
+	Timestamp time.Time `json:"timestamp"`
+	Rule      string    `json:"rule"`
+	IPAddress string    `json:"ip_address"`
+	Actions   string    `json:"actions"`
+	Evidence  string    `json:"evidence,omitempty"`
+}
+
+// SecurityOverviewService aggregates the security state of the registry
+// from the services that hold it.
+type SecurityOverviewService struct {
+	sbom       *SBOMService
+	signatures *SignatureService
+	lock       *LockService
+	images     SecurityImageSource
+	tuf        TUFStatusSource
+	logger     *zap.Logger
+}
+
+// NewSecurityOverviewService creates a new SecurityOverviewService instance.
+func NewSecurityOverviewService(sbom *SBOMService, signatures *SignatureService, lock *LockService, logger *zap.Logger) *SecurityOverviewService {
+	return &SecurityOverviewService{
+		sbom:       sbom,
+		signatures: signatures,
+		lock:       lock,
+		logger:     logger,
+	}
+}
+
+// SetImageSource sets the source of images and quarantined blobs.
+func (s *SecurityOverviewService) SetImageSource(images SecurityImageSource) {
+	s.images = images
+}
+
+// SetTUFSource sets the source of the TUF metadata status; without one the
+// TUF section is reported as not enabled.
+func (s *SecurityOverviewService) SetTUFSource(tuf TUFStatusSource) {
+	s.tuf = tuf
+}
+
+// Overview returns the security state, with the intrusion events of the
+// last window. A section that fails is reported in Errors and the others
+// are still returned.
+func (s *SecurityOverviewService) Overview(window time.Duration) *SecurityOverview {
+	if window <= 0 {
+		window = DefaultSecurityWindow
+	}
+	if window > MaxSecurityWindow {
+		window = MaxSecurityWindow
+	}
+
+	overview := &SecurityOverview{
+		GeneratedAt: time.Now(),
+		Window:      window.String(),
+		Errors:      make(map[string]string),
+	}
+	overview.Vulnerabilities = s.vulnOverview()
+	overview.Signatures = s.signatureOverview(overview.Errors)
+	overview.Quarantine = s.quarantineOverview(overview.Errors)
+	overview.TUF = s.tufOverview()
+	overview.Intrusions = s.intrusionOverview(overview.GeneratedAt.Add(-window), overview.Errors)
+	if s.lock != nil {
+		overview.Lock = s.lock.GetLockStatus()
+	}
+	if len(overview.Errors) == 0 {
+		overview.Errors = nil
+	}
+	return overview
+}
+
+// vulnOverview counts the distinct critical and high vulnerabilities of
+// each repository, most critical first.
+func (s *SecurityOverviewService) vulnOverview() VulnOverview {
+	result := VulnOverview{ByRepository: []RepositoryVulns{}}
+	if s.sbom == nil {
+		return result
+	}
+
+	type repoVulns struct {
+		critical, high map[string]bool
+		images         []string
+	}
+	repos := make(map[string]*repoVulns)
+	critical := make(map[string]bool)
+	high := make(map[string]bool)
+	for _, sbom := range s.sbom.AllSBOMs() {
+		var found bool
+		name := SBOMRepository(sbom.ImageRef)
+		for _, v := range sbom.Vulnerabilities {
+			severity := strings.ToUpper(v.Severity)
+			if severity != "CRITICAL" && severity != "HIGH" {
+				continue
+			}
+			repo := repos[name]
+			if repo == nil {
+				repo = &repoVulns{critical: make(map[string]bool), high: make(map[string]bool)}
+				repos[name] = repo
+			}
+			if severity == "CRITICAL" {
+				repo.critical[v.ID] = true
+				critical[v.ID] = true
+			} else {
+				repo.high[v.ID] = true
+				high[v.ID] = true
+			}
+			if !found {
+				repo.images = append(repo.images, sbom.ImageRef)
+				found = true
+			}
+		}
+	}
+
+	for name, repo := range repos {
+		result.ByRepository = append(result.ByRepository, RepositoryVulns{
+			Repository: name,
+			Critical:   len(repo.critical),
+			High:       len(repo.high),
+			Images:     repo.images,
+		})
+	}
+	sort.Slice(result.ByRepository, func(i, j int) bool {
+		a, b := result.ByRepository[i], result.ByRepository[j]
+		if a.Critical != b.Critical {
+			return a.Critical > b.Critical
+		}
+		if a.High != b.High {
+			return a.High > b.High
+		}
+		return a.Repository < b.Repository
+	})
+	result.Critical = len(critical)
+	result.High = len(high)
+	result.Repositories = len(result.ByRepository)
+	return result
+}
+
+// signatureOverview lists the tagged images without a valid signature for
+// either the tag or the digest.
+func (s *SecurityOverviewService) signatureOverview(errs map[string]string) SignatureOverview {
+	result := SignatureOverview{UnsignedImages: []string{}}
+	if s.signatures == nil || s.images == nil {
+		return result
+	}
+	result.Mode = s.signatures.Mode()
+
+	images, err := s.images.TaggedImages()
+	if err != nil {
+		errs["signatures"] = err.Error()
+		return result
+	}
+	result.Images = len(images)
+	for _, image := range images {
+		if imageSigned(s.signatures, image) {
+			continue
+		}
+		result.Unsigned++
+		if len(result.UnsignedImages) < securityListLimit {
+			result.UnsignedImages = append(result.UnsignedImages, image.Repository+":"+image.Tag)
+		}
+	}
+	return result
+}
+
+// imageSigned reports whether the image has a valid signature for either
+// the tag or the digest.
+func imageSigned(signatures *SignatureService, image TaggedImage) bool {
+	for _, ref := range []string{image.Repository + ":" + image.Tag, image.Repository + "@" + image.Digest} {
+		result, err := signatures.VerifyImage(&VerifyRequest{ImageRef: ref})
+		if err == nil && result.Verified {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineOverview lists the quarantined blobs that were not repaired.
+func (s *SecurityOverviewService) quarantineOverview(errs map[string]string) QuarantineOverview {
+	result := QuarantineOverview{Repositories: []string{}, Items: []QuarantinedBlob{}}
+	if s.images == nil {
+		return result
+	}
+
+	blobs, err := s.images.QuarantinedBlobs()
+	if err != nil {
+		errs["quarantine"] = err.Error()
+		return result
+	}
+	repos := make(map[string]bool)
+	for _, b := range blobs {
+		for _, r := range b.Repositories {
+			repos[r] = true
+		}
+	}
+	for r := range repos {
+		result.Repositories = append(result.Repositories, r)
+	}
+	sort.Strings(result.Repositories)
+	result.Blobs = len(blobs)
+	result.Items = append(result.Items, blobs...)
+	return result
+}
+
+// tufOverview reports the TUF roles whose metadata expired.
+func (s *SecurityOverviewService) tufOverview() TUFOverview {
+	result := TUFOverview{Expired: []string{}}
+	if s.tuf == nil {
+		return result
+	}
+	result.Enabled = true
+	status := s.tuf.GetStatus()
+	if status == nil || !status.Initialized {
+		return result
+	}
+	result.Initialized = true
+
+	now := time.Now()
+	for _, role := range []TUFRoleExpiry{
+		{Role: "root", Version: status.RootVersion, Expires: status.RootExpires},
+		{Role: "targets", Version: status.TargetsVersion, Expires: status.TargetsExpires},
+		{Role: "snapshot", Version: status.SnapshotVersion, Expires: status.SnapshotExpires},
+		{Role: "timestamp", Version: status.TimestampVersion, Expires: status.TimestampExpires},
+	} {
+		role.Expired = now.After(role.Expires)
+		if role.Expired {
+			result.Expired = append(result.Expired, role.Role)
+		}
+		result.Roles = append(result.Roles, role)
+	}
+	return result
+}
+
+// intrusionOverview returns the intrusion rules triggered since.
+func (s *SecurityOverviewService) intrusionOverview(since time.Time, errs map[string]string) IntrusionOverview {
+	result := IntrusionOverview{Recent: []*IntrusionEvent{}}
+	if dao.GetDB() == nil {
+		return result
+	}
+
+	logs, total, err := dao.GetAuditLogs(1, recentIntrusionLimit, "intrusion_detected", "", since, time.Time{})
+	if err != nil {
+		errs["intrusions"] = err.Error()
+		return result
+	}
+	result.Total = total
+	for _, log := range logs {
+		event := &IntrusionEvent{
+			Timestamp: log.Timestamp,
+			Rule:      log.Resource,
+			IPAddress: log.IPAddress,
+			Actions:   log.Action,
+		}
+		if evidence, ok := log.Details["evidence"].(string); ok {
+			event.Evidence = evidence
+		}
+		result.Recent = append(result.Recent, event)
+	}
+	return result
+}
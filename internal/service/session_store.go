@@ -0,0 +1,127 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// redisTimeout bounds a single Redis operation so a slow server degrades
+// to local state instead of stalling requests.
+const redisTimeout = 500 * time.Millisecond
+
+// SessionStore keeps the login session of each user.
+type SessionStore interface {
+	Get(userID int64) (*Session, error)
+	Put(session *Session) error
+	Delete(userID int64) error
+}
+
+// localSessionStore keeps sessions in memory of this instance.
+type localSessionStore struct {
+	sessions sync.Map // map[int64]*Session
+}
+
+func (s *localSessionStore) Get(userID int64) (*Session, error) {
+	if session, ok := s.sessions.Load(userID); ok {
+		return session.(*Session), nil
+	}
+	return nil, nil
+}
+
+func (s *localSessionStore) Put(session *Session) error {
+	s.sessions.Store(session.UserID, session)
+	return nil
+}
+
+func (s *localSessionStore) Delete(userID int64) error {
+	s.sessions.Delete(userID)
+	return nil
+}
+
+// RedisSessionStore shares sessions between instances through Redis.
+// Sessions are also kept locally and served from there while Redis fails.
+type RedisSessionStore struct {
+	client *redis.Client
+	prefix string
+	logger *zap.Logger
+	local  localSessionStore
+}
+
+// NewRedisSessionStore creates a new RedisSessionStore. Keys are prefix +
+// "session:" + user ID.
+func NewRedisSessionStore(client *redis.Client, prefix string, logger *zap.Logger) *RedisSessionStore {
+	return &RedisSessionStore{
+		client: client,
+		prefix: prefix + "session:",
+		logger: logger,
+	}
+}
+
+// Get returns the session of a user, or nil if there is none.
+func (s *RedisSessionStore) Get(userID int64) (*Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+
+	data, err := s.client.Get(ctx, s.key(userID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		s.warn("读取会话失败，使用本地会话", err)
+		return s.local.Get(userID)
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Put stores a session until it expires.
+func (s *RedisSessionStore) Put(session *Session) error {
+	s.local.Put(session)
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := s.client.Set(ctx, s.key(session.UserID), data, time.Until(session.ExpiresAt)).Err(); err != nil {
+		s.warn("保存会话失败，仅保存在本实例", err)
+		return err
+	}
+	return nil
+}
+
+// Delete removes the session of a user.
+func (s *RedisSessionStore) Delete(userID int64) error {
+	s.local.Delete(userID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
+	defer cancel()
+	if err := s.client.Del(ctx, s.key(userID)).Err(); err != nil {
+		s.warn("删除会话失败", err)
+		return err
+	}
+	return nil
+}
+
+func (s *RedisSessionStore) key(userID int64) string {
+	return s.prefix + strconv.FormatInt(userID, 10)
+}
+
+func (s *RedisSessionStore) warn(msg string, err error) {
+	if s.logger != nil {
+		s.logger.Warn(msg, zap.Error(err))
+	}
+}
@@ -0,0 +1,363 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// Setting value types.
+const (
+	SettingString   = "string"
+	SettingBool     = "bool"
+	SettingInt      = "int"
+	SettingDuration = "duration"
+	SettingEnum     = "enum"
+)
+
+// Setting sources: a value set through the API overrides the value of the
+// configuration file.
+const (
+	SettingSourceConfig   = "config"
+	SettingSourceOverride = "override"
+)
+
+// Setting errors.
+var (
+	ErrSettingNotFound = errors.New("setting not found")
+	ErrInvalidSetting  = errors.New("invalid setting value")
+)
+
+// SettingDefinition describes a setting that can be changed at runtime.
+type SettingDefinition struct {
+	Key         string
+	Type        string
+	Description string
+	Options     []string // 枚举类型的可选值
+
+	// ConfigValue is the value from the configuration file, used when no
+	// override is stored.
+	ConfigValue string
+
+	// Apply makes a validated value take effect. It is called at startup
+	// for stored overrides and on every change.
+	Apply func(value string) error
+
+	// RestartRequired marks settings that are only read at startup; they
+	// have no Apply function.
+	RestartRequired bool
+}
+
+// Setting is the effective value of a setting.
+type Setting struct {
+	Key         string      `json:"key"`
+	Type        string      `json:"type"`
+	Description string      `json:"description"`
+	Options     []string    `json:"options,omitempty"`
+	Value       interface{} `json:"value"`
+	ConfigValue interface{} `json:"config_value"`
+	Source      string      `json:"source"`
+	Restart     bool        `json:"restart_required,omitempty"`
+	UpdatedBy   string      `json:"updated_by,omitempty"`
+	UpdatedAt   *time.Time  `json:"updated_at,omitempty"`
+}
+
+// SettingsService manages settings that can be changed from the web UI.
+// Overrides are stored in the settings table and take precedence over the
+// configuration file.
+type SettingsService struct {
+	logger *zap.Logger
+
+	mu          sync.RWMutex
+	definitions map[string]*SettingDefinition
+	overrides   map[string]*dao.Setting
+}
+
+// NewSettingsService creates a new SettingsService instance.
+func NewSettingsService(logger *zap.Logger) *SettingsService {
+	return &SettingsService{
+		logger:      logger,
+		definitions: make(map[string]*SettingDefinition),
+		overrides:   make(map[string]*dao.Setting),
+	}
+}
+
+// Register adds a setting. Overrides of settings registered after Load are
+// applied by the next Load.
+func (s *SettingsService) Register(def SettingDefinition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.definitions[def.Key] = &def
+}
+
+// Load reads the stored overrides and applies those not loaded yet.
+// Overrides that no longer validate are ignored, so the configuration file
+// value stays in effect.
+func (s *SettingsService) Load() error {
+	stored, err := dao.ListSettings()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, setting := range stored {
+		def, ok := s.definitions[setting.Key]
+		if !ok {
+			continue
+		}
+		if _, loaded := s.overrides[setting.Key]; loaded {
+			continue
+		}
+		value, err := normalizeSetting(def, setting.Value)
+		if err == nil && def.Apply != nil {
+			err = def.Apply(value)
+		}
+		if err != nil {
+			s.logWarn("忽略无效的设置", zap.String("key", setting.Key), zap.Error(err))
+			continue
+		}
+		setting.Value = value
+		s.overrides[setting.Key] = setting
+	}
+	return nil
+}
+
+// List returns all settings ordered by key.
+func (s *SettingsService) List() []*Setting {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	settings := make([]*Setting, 0, len(s.definitions))
+	for key := range s.definitions {
+		settings = append(settings, s.settingLocked(key))
+	}
+	sort.Slice(settings, func(i, j int) bool { return settings[i].Key < settings[j].Key })
+	return settings
+}
+
+// Get returns a setting.
+func (s *SettingsService) Get(key string) (*Setting, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.definitions[key]; !ok {
+		return nil, ErrSettingNotFound
+	}
+	return s.settingLocked(key), nil
+}
+
+// Value returns the effective value of a setting in its stored form.
+func (s *SettingsService) Value(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	def, ok := s.definitions[key]
+	if !ok {
+		return "", false
+	}
+	if override, ok := s.overrides[key]; ok {
+		return override.Value, true
+	}
+	return def.ConfigValue, true
+}
+
+// SetConfigValue replaces the configuration file value of a setting, e.g.
+// after the file was reloaded. The value is applied unless an override is
+// stored.
+func (s *SettingsService) SetConfigValue(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	def, ok := s.definitions[key]
+	if !ok {
+		return ErrSettingNotFound
+	}
+	normalized, err := normalizeSetting(def, value)
+	if err != nil {
+		return err
+	}
+	if _, overridden := s.overrides[key]; !overridden && def.Apply != nil {
+		if err := def.Apply(normalized); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSetting, err)
+		}
+	}
+	def.ConfigValue = normalized
+	return nil
+}
+
+// Set validates and applies a value and stores it as an override. value
+// may be a string or a JSON bool or number. It returns the new setting and
+// the previous effective value.
+func (s *SettingsService) Set(key string, value interface{}, username string) (*Setting, interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	def, ok := s.definitions[key]
+	if !ok {
+		return nil, nil, ErrSettingNotFound
+	}
+	normalized, err := normalizeSetting(def, settingString(value))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous := s.settingLocked(key).Value
+	if def.Apply != nil {
+		if err := def.Apply(normalized); err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSetting, err)
+		}
+	}
+
+	override := &dao.Setting{Key: key, Value: normalized, UpdatedBy: username, UpdatedAt: time.Now()}
+	if err := dao.UpsertSetting(override); err != nil {
+		return nil, nil, err
+	}
+	s.overrides[key] = override
+
+	s.logInfo("设置已更新", zap.String("key", key), zap.String("value", normalized), zap.String("by", username))
+	return s.settingLocked(key), previous, nil
+}
+
+// Reset removes the override of a setting, so the configuration file value
+// applies again. It returns the new setting and the previous effective
+// value.
+func (s *SettingsService) Reset(key, username string) (*Setting, interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	def, ok := s.definitions[key]
+	if !ok {
+		return nil, nil, ErrSettingNotFound
+	}
+
+	previous := s.settingLocked(key).Value
+	if _, overridden := s.overrides[key]; overridden {
+		if def.Apply != nil {
+			if err := def.Apply(def.ConfigValue); err != nil {
+				return nil, nil, fmt.Errorf("%w: %v", ErrInvalidSetting, err)
+			}
+		}
+		if err := dao.DeleteSetting(key); err != nil {
+			return nil, nil, err
+		}
+		delete(s.overrides, key)
+		s.logInfo("设置已恢复为配置文件的值", zap.String("key", key), zap.String("by", username))
+	}
+	return s.settingLocked(key), previous, nil
+}
+
+// settingLocked builds the effective setting, the caller must hold the lock.
+func (s *SettingsService) settingLocked(key string) *Setting {
+	def := s.definitions[key]
+	setting := &Setting{
+		Key:         def.Key,
+		Type:        def.Type,
+		Description: def.Description,
+		Options:     def.Options,
+		Value:       typedSetting(def.Type, def.ConfigValue),
+		ConfigValue: typedSetting(def.Type, def.ConfigValue),
+		Source:      SettingSourceConfig,
+		Restart:     def.RestartRequired,
+	}
+	if override, ok := s.overrides[key]; ok {
+		updatedAt := override.UpdatedAt
+		setting.Value = typedSetting(def.Type, override.Value)
+		setting.Source = SettingSourceOverride
+		setting.UpdatedBy = override.UpdatedBy
+		setting.UpdatedAt = &updatedAt
+	}
+	return setting
+}
+
+// normalizeSetting validates a value against the setting type and returns
+// its canonical form.
+func normalizeSetting(def *SettingDefinition, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch def.Type {
+	case SettingBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s must be true or false", ErrInvalidSetting, def.Key)
+		}
+		return strconv.FormatBool(b), nil
+	case SettingInt:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s must be an integer", ErrInvalidSetting, def.Key)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case SettingDuration:
+		if value == "0" {
+			return value, nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return "", fmt.Errorf("%w: %s must be a duration such as 24h", ErrInvalidSetting, def.Key)
+		}
+		return d.String(), nil
+	case SettingEnum:
+		for _, option := range def.Options {
+			if strings.EqualFold(value, option) {
+				return option, nil
+			}
+		}
+		return "", fmt.Errorf("%w: %s must be one of %s", ErrInvalidSetting, def.Key, strings.Join(def.Options, ", "))
+	}
+	if value == "" {
+		return "", fmt.Errorf("%w: %s must not be empty", ErrInvalidSetting, def.Key)
+	}
+	return value, nil
+}
+
+// typedSetting converts a stored value to its JSON type.
+func typedSetting(typ, value string) interface{} {
+	switch typ {
+	case SettingBool:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case SettingInt:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return value
+}
+
+// settingString converts a JSON request value to the stored string form.
+func settingString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+func (s *SettingsService) logInfo(msg string, fields ...zap.Field) {
+	if s.logger != nil {
+		s.logger.Info(msg, fields...)
+	}
+}
+
+func (s *SettingsService) logWarn(msg string, fields ...zap.Field) {
+	if s.logger != nil {
+		s.logger.Warn(msg, fields...)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"cyp-docker-registry/internal/dao"
@@ -13,9 +16,69 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// SharePullTokenPrefix marks pull tokens issued for share links.
+const SharePullTokenPrefix = "shr_"
+
+// sharePullTokenTTL is the lifetime of a share pull token.
+const sharePullTokenTTL = 30 * time.Minute
+
+// Share link errors.
+var (
+	ErrShareNotFound         = errors.New("share link not found")
+	ErrShareExpired          = errors.New("share link expired")
+	ErrShareExhausted        = errors.New("share link usage limit exceeded")
+	ErrShareInvalidSecret    = errors.New("invalid password")
+	ErrSharePermissionDenied = errors.New("permission denied")
+)
+
 // ShareService provides share link management services.
 type ShareService struct {
 	logger *zap.Logger
+
+	// 分享链接被使用时通知创建者，仅对开启 notify_on_use 的链接生效
+	notify  ShareNotifyFunc
+	onEvent ShareEventFunc
+
+	images       ShareImageSource
+	repositories *RepositoryService
+}
+
+// ShareImageSource resolves the shared image when a pull token is issued.
+type ShareImageSource interface {
+	ManifestDigests(name, tag string) ([]string, error)
+}
+
+// SharePullGrant is what a share pull token allows: pulling one image.
+type SharePullGrant struct {
+	Code       string    `json:"code"`
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digests    []string  `json:"digests,omitempty"` // 兑换时标签指向的清单，只能按这些摘要拉取
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// SharePullToken is returned when a share code is exchanged for registry
+// credentials. Username and Token are used with docker login.
+type SharePullToken struct {
+	SharePullGrant
+	Username string `json:"username"`
+	Token    string `json:"token"`
+}
+
+// ShareEventFunc receives share link events: share_redeemed and
+// share_exhausted. They concern the creator of the link only.
+type ShareEventFunc func(event string, creatorID int64, data map[string]interface{})
+
+// ShareNotifyFunc delivers a notification to the creator of a share link.
+type ShareNotifyFunc func(creatorID int64, level, title, message string)
+
+// ShareUsage is one redemption of a share link.
+type ShareUsage struct {
+	ID        int64     `json:"id"`
+	ImageRef  string    `json:"image_ref"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // ShareLink represents a share link.
@@ -29,6 +92,7 @@ type ShareLink struct {
 	MaxUsage        int       `json:"max_usage"`
 	UsageCount      int       `json:"usage_count"`
 	ExpiresAt       time.Time `json:"expires_at,omitempty"`
+	NotifyOnUse     bool      `json:"notify_on_use"`
 	CreatedAt       time.Time `json:"created_at"`
 }
 
@@ -38,6 +102,8 @@ type CreateShareRequest struct {
 	Password  string `json:"password,omitempty"`
 	MaxUsage  int    `json:"max_usage,omitempty"`
 	ExpiresIn string `json:"expires_in,omitempty"` // e.g., "24h", "7d"
+	// NotifyOnUse notifies the creator each time the link is redeemed
+	NotifyOnUse bool `json:"notify_on_use,omitempty"`
 }
 
 // NewShareService creates a new ShareService instance.
@@ -47,8 +113,60 @@ func NewShareService(logger *zap.Logger) *ShareService {
 	}
 }
 
-// CreateShareLink creates a new share link.
-func (s *ShareService) CreateShareLink(req *CreateShareRequest, userID int64) (*ShareLink, string, error) {
+// SetNotifier sets the function used to notify creators about redemptions.
+func (s *ShareService) SetNotifier(fn ShareNotifyFunc) {
+	s.notify = fn
+}
+
+// SetEventHandler sets the function that receives share link events.
+func (s *ShareService) SetEventHandler(fn ShareEventFunc) {
+	s.onEvent = fn
+}
+
+// SetImageSource sets the registry used to pin pull tokens to the digest
+// the shared tag points at.
+func (s *ShareService) SetImageSource(images ShareImageSource) {
+	s.images = images
+}
+
+// SetRepositoryService sets the repository permissions checked when a link
+// is created and redeemed. Without it, no link can be created or redeemed.
+func (s *ShareService) SetRepositoryService(repositories *RepositoryService) {
+	s.repositories = repositories
+}
+
+// canShare reports whether a user, authenticated with token if it is not
+// nil, may pull the repository of a shared image reference.
+func (s *ShareService) canShare(user *User, token *Token, imageRef string) bool {
+	if s.repositories == nil || user == nil || !user.IsActive {
+		return false
+	}
+	repository, _ := parseShareImageRef(imageRef)
+	if !s.repositories.CanPull(user, repository) {
+		return false
+	}
+	return token == nil || ScopeAllowsRepository(token.Scopes, repository, "pull")
+}
+
+// creatorCanPull reports whether the creator of a link can still pull the
+// shared image, so a link stops working when the creator loses access.
+func (s *ShareService) creatorCanPull(link *ShareLink) bool {
+	u, err := dao.GetUserByID(link.CreatedBy)
+	if err != nil || u == nil {
+		return false
+	}
+	creator := &User{ID: u.ID, Username: u.Username, Role: u.Role, IsActive: u.IsActive}
+	return s.canShare(creator, nil, link.ImageRef)
+}
+
+// CreateShareLink creates a new share link. The user, and the personal
+// access token when the request used one, must be allowed to pull the image.
+func (s *ShareService) CreateShareLink(req *CreateShareRequest, user *User, token *Token) (*ShareLink, string, error) {
+	if !s.canShare(user, token, req.ImageRef) {
+		return nil, "", ErrSharePermissionDenied
+	}
+	userID := user.ID
+
 	// Generate unique code
 	code := generateShareCode()
 
@@ -86,10 +204,11 @@ func (s *ShareService) CreateShareLink(req *CreateShareRequest, userID int64) (*
 	}
 
 	daoLink := &dao.ShareLink{
-		Code:      code,
-		ImageRef:  req.ImageRef,
-		CreatedBy: userID,
-		MaxUsage:  req.MaxUsage,
+		Code:        code,
+		ImageRef:    req.ImageRef,
+		CreatedBy:   userID,
+		MaxUsage:    req.MaxUsage,
+		NotifyOnUse: req.NotifyOnUse,
 	}
 
 	if passwordHash != "" {
@@ -115,6 +234,7 @@ func (s *ShareService) CreateShareLink(req *CreateShareRequest, userID int64) (*
 		MaxUsage:        daoLink.MaxUsage,
 		UsageCount:      0,
 		ExpiresAt:       expiresAt,
+		NotifyOnUse:     daoLink.NotifyOnUse,
 		CreatedAt:       daoLink.CreatedAt,
 	}
 
@@ -128,17 +248,17 @@ func (s *ShareService) GetShareLink(code string) (*ShareLink, error) {
 		return nil, err
 	}
 	if daoLink == nil {
-		return nil, errors.New("share link not found")
+		return nil, ErrShareNotFound
 	}
 
 	// Check expiration
 	if daoLink.ExpiresAt.Valid && time.Now().After(daoLink.ExpiresAt.Time) {
-		return nil, errors.New("share link expired")
+		return nil, ErrShareExpired
 	}
 
 	// Check usage limit
 	if daoLink.MaxUsage > 0 && daoLink.UsageCount >= daoLink.MaxUsage {
-		return nil, errors.New("share link usage limit exceeded")
+		return nil, ErrShareExhausted
 	}
 
 	return s.convertLink(daoLink), nil
@@ -159,7 +279,7 @@ func (s *ShareService) VerifySharePassword(code, password string) error {
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(daoLink.PasswordHash.String), []byte(password)); err != nil {
-		return errors.New("invalid password")
+		return ErrShareInvalidSecret
 	}
 
 	return nil
@@ -205,7 +325,254 @@ func (s *ShareService) RevokeShareLink(code string, userID int64) error {
 		return errors.New("permission denied")
 	}
 
-	return dao.DeleteShareLink(daoLink.ID)
+	if err := dao.DeleteShareLink(daoLink.ID); err != nil {
+		return err
+	}
+	if err := dao.DeleteShareLinkUsages(code); err != nil && s.logger != nil {
+		s.logger.Warn("删除分享链接使用记录失败", zap.String("code", code), zap.Error(err))
+	}
+	if err := dao.DeleteSharePullTokens(code); err != nil && s.logger != nil {
+		s.logger.Warn("删除分享拉取令牌失败", zap.String("code", code), zap.Error(err))
+	}
+	return nil
+}
+
+// ExchangePullToken trades a share code, and its password if it has one,
+// for a short-lived token that can pull the shared image. Each exchange
+// counts as one use of the link and is recorded with the client's IP and
+// user agent.
+func (s *ShareService) ExchangePullToken(code, password, ipAddress, userAgent string) (*SharePullToken, error) {
+	link, err := s.GetShareLink(code)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.VerifySharePassword(code, password); err != nil {
+		return nil, err
+	}
+	if !s.creatorCanPull(link) {
+		return nil, ErrSharePermissionDenied
+	}
+
+	// Expiry and max_usage are checked again atomically by the update
+	ok, err := dao.ConsumeShareLink(code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if !link.ExpiresAt.IsZero() && time.Now().After(link.ExpiresAt) {
+			return nil, ErrShareExpired
+		}
+		return nil, ErrShareExhausted
+	}
+	s.recordRedemption(link, ipAddress, userAgent)
+
+	expiresAt := time.Now().Add(sharePullTokenTTL)
+	if !link.ExpiresAt.IsZero() && link.ExpiresAt.Before(expiresAt) {
+		expiresAt = link.ExpiresAt
+	}
+
+	repository, tag := parseShareImageRef(link.ImageRef)
+	grant := &SharePullGrant{
+		Code:       code,
+		Repository: repository,
+		Tag:        tag,
+		ExpiresAt:  expiresAt,
+	}
+	// 按摘要拉取只允许兑换时标签指向的清单，无法解析时只能按标签拉取
+	if s.images != nil {
+		if digests, err := s.images.ManifestDigests(repository, tag); err == nil {
+			grant.Digests = digests
+		} else if s.logger != nil {
+			s.logger.Warn("解析分享镜像摘要失败", zap.String("image_ref", link.ImageRef), zap.Error(err))
+		}
+	}
+	token := SharePullTokenPrefix + generatePlainToken()
+
+	// 令牌存入数据库，重启后和集群中的其他实例上仍然有效
+	err = dao.CreateSharePullToken(&dao.SharePullToken{
+		TokenHash:  hashToken(token),
+		Code:       code,
+		Repository: repository,
+		Tag:        tag,
+		Digests:    strings.Join(grant.Digests, ","),
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &SharePullToken{
+		SharePullGrant: *grant,
+		Username:       code,
+		Token:          token,
+	}, nil
+}
+
+// recordRedemption stores the usage record of a redeemed link and notifies
+// its creator. Failures are only logged, the redemption itself succeeded.
+func (s *ShareService) recordRedemption(link *ShareLink, ipAddress, userAgent string) {
+	usage := &dao.ShareLinkUsage{
+		LinkID:    link.ID,
+		Code:      link.Code,
+		ImageRef:  link.ImageRef,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+	}
+	if err := dao.CreateShareLinkUsage(usage); err != nil && s.logger != nil {
+		s.logger.Warn("记录分享链接使用失败", zap.String("code", link.Code), zap.Error(err))
+	}
+
+	if !link.NotifyOnUse || (s.notify == nil && s.onEvent == nil) {
+		return
+	}
+
+	// 重新读取使用次数，link 是扣减前的快照
+	usageCount := link.UsageCount + 1
+	if daoLink, err := dao.GetShareLink(link.Code); err == nil && daoLink != nil {
+		usageCount = daoLink.UsageCount
+	}
+	exhausted := link.MaxUsage > 0 && usageCount >= link.MaxUsage
+
+	creator := ""
+	if user, err := dao.GetUserByID(link.CreatedBy); err == nil && user != nil {
+		creator = user.Username
+	}
+
+	// 不包含分享码，事件只用于提示，分享码仍可用于兑换
+	data := map[string]interface{}{
+		"id":          link.ID,
+		"image_ref":   link.ImageRef,
+		"created_by":  link.CreatedBy,
+		"creator":     creator,
+		"ip_address":  ipAddress,
+		"user_agent":  userAgent,
+		"usage_count": usageCount,
+		"max_usage":   link.MaxUsage,
+	}
+	if s.onEvent != nil {
+		s.onEvent("share_redeemed", link.CreatedBy, data)
+		if exhausted {
+			s.onEvent("share_exhausted", link.CreatedBy, data)
+		}
+	}
+
+	if s.notify != nil {
+		usageText := strconv.Itoa(usageCount)
+		if link.MaxUsage > 0 {
+			usageText += "/" + strconv.Itoa(link.MaxUsage)
+		}
+		s.notify(link.CreatedBy, "info", "分享链接已被使用",
+			fmt.Sprintf("%s 分享的 %s 被 %s 使用（第 %s 次）", creator, link.ImageRef, ipAddress, usageText))
+		if exhausted {
+			s.notify(link.CreatedBy, "warning", "分享链接次数已用完",
+				fmt.Sprintf("%s 分享的 %s 已达到使用上限，链接不再可用", creator, link.ImageRef))
+		}
+	}
+}
+
+// GetShareUsage returns a share link and its redemption history. Only the
+// creator and administrators can read it. Expired and exhausted links are
+// still readable.
+func (s *ShareService) GetShareUsage(code string, userID int64, isAdmin bool, page, pageSize int) (*ShareLink, []*ShareUsage, int, error) {
+	daoLink, err := dao.GetShareLink(code)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if daoLink == nil {
+		return nil, nil, 0, ErrShareNotFound
+	}
+	if daoLink.CreatedBy != userID && !isAdmin {
+		return nil, nil, 0, ErrSharePermissionDenied
+	}
+
+	records, total, err := dao.ListShareLinkUsages(code, page, pageSize)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	usages := make([]*ShareUsage, len(records))
+	for i, r := range records {
+		usages[i] = &ShareUsage{
+			ID:        r.ID,
+			ImageRef:  r.ImageRef,
+			IPAddress: r.IPAddress,
+			UserAgent: r.UserAgent,
+			CreatedAt: r.CreatedAt,
+		}
+	}
+	return s.convertLink(daoLink), usages, total, nil
+}
+
+// ValidatePullToken returns the grant of a share pull token.
+func (s *ShareService) ValidatePullToken(token string) (*SharePullGrant, error) {
+	t, err := dao.GetSharePullToken(hashToken(token), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, errors.New("invalid or expired share token")
+	}
+	grant := &SharePullGrant{
+		Code:       t.Code,
+		Repository: t.Repository,
+		Tag:        t.Tag,
+		ExpiresAt:  t.ExpiresAt,
+	}
+	if t.Digests != "" {
+		grant.Digests = strings.Split(t.Digests, ",")
+	}
+	return grant, nil
+}
+
+// AllowsManifest reports whether the grant allows pulling the manifest
+// reference: the shared tag, or a digest it pointed at when the token was
+// issued.
+func (g *SharePullGrant) AllowsManifest(reference string) bool {
+	if reference == g.Tag {
+		return true
+	}
+	for _, digest := range g.Digests {
+		if reference == digest {
+			return true
+		}
+	}
+	return false
+}
+
+// PurgeStaleLinks deletes the links that expired, or were used up, before
+// the given time along with their usage records, and returns how many were
+// deleted. Until then their usage history stays readable.
+func (s *ShareService) PurgeStaleLinks(before time.Time) (int, error) {
+	codes, err := dao.DeleteStaleShareLinks(before)
+	if err != nil {
+		return 0, err
+	}
+	return len(codes), nil
+}
+
+// PurgeExpiredPullTokens deletes expired pull tokens and returns how many
+// were deleted.
+func (s *ShareService) PurgeExpiredPullTokens() (int64, error) {
+	return dao.DeleteExpiredSharePullTokens(time.Now())
+}
+
+// parseShareImageRef splits a shared image reference such as
+// "registry.local:5000/team/app:1.0" into repository and tag. A digest
+// reference keeps the digest as the tag.
+func parseShareImageRef(ref string) (repository, tag string) {
+	repository, tag = ref, "latest"
+	if i := strings.Index(repository, "@"); i >= 0 {
+		repository, tag = repository[:i], repository[i+1:]
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, tag = repository[:i], repository[i+1:]
+	}
+
+	// Drop the registry host
+	if first, rest, ok := strings.Cut(repository, "/"); ok &&
+		(strings.ContainsAny(first, ".:") || first == "localhost") {
+		repository = rest
+	}
+	return repository, tag
 }
 
 func (s *ShareService) convertLink(daoLink *dao.ShareLink) *ShareLink {
@@ -217,6 +584,7 @@ func (s *ShareService) convertLink(daoLink *dao.ShareLink) *ShareLink {
 		RequirePassword: daoLink.PasswordHash.Valid && daoLink.PasswordHash.String != "",
 		MaxUsage:        daoLink.MaxUsage,
 		UsageCount:      daoLink.UsageCount,
+		NotifyOnUse:     daoLink.NotifyOnUse,
 		CreatedAt:       daoLink.CreatedAt,
 	}
 
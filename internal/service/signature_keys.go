@@ -0,0 +1,119 @@
+package service
+
+import (
+	"errors"
+	"sort"
+
+	"cyp-docker-registry/pkg/signature"
+
+	"go.uber.org/zap"
+)
+
+// 密钥管理错误
+var (
+	ErrNoKeyProvider            = errors.New("no signing key provider is configured")
+	ErrSigningKeyNotFound       = signature.ErrKeyNotFound
+	ErrSigningKeyExists         = signature.ErrKeyExists
+	ErrInvalidSigningKeyID      = signature.ErrInvalidKeyID
+	ErrKeyGenerationUnsupported = signature.ErrKeyOperationUnsupported
+)
+
+// SigningKey is a key of the key provider, without its private part.
+type SigningKey struct {
+	signature.KeyInfo
+	Default bool `json:"default"`
+}
+
+// ensureDefaultKey generates the default key of the file provider on first
+// start, as the signer did before key providers. Keys of devices and cloud
+// services are created with their own tools.
+func (s *SignatureService) ensureDefaultKey() {
+	keys := s.keys
+	if keys == nil || keys.Name() != signature.KeyProviderFile {
+		return
+	}
+	if _, err := keys.Signer(s.keyID("")); !errors.Is(err, signature.ErrKeyNotFound) {
+		return
+	}
+	if _, err := keys.GenerateKey(s.keyID("")); err != nil {
+		if s.logger != nil {
+			s.logger.Error("生成默认签名密钥失败", zap.Error(err))
+		}
+		return
+	}
+	if s.logger != nil {
+		s.logger.Info("已生成默认签名密钥", zap.String("key_id", s.keyID("")))
+	}
+}
+
+// keyID returns id, or the default key when it is empty.
+func (s *SignatureService) keyID(id string) string {
+	if id == "" {
+		return s.config.KeyID
+	}
+	return id
+}
+
+// KeyProvider returns the key provider, also used for TUF signing.
+func (s *SignatureService) KeyProvider() signature.KeyProvider {
+	return s.keys
+}
+
+// ListKeys returns the keys of the provider. Providers that cannot list
+// their keys, like cloud KMS, return the default key only.
+func (s *SignatureService) ListKeys() ([]*SigningKey, error) {
+	if s.keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+	ids, err := s.keys.ListKeys()
+	if err != nil && !errors.Is(err, signature.ErrKeyOperationUnsupported) {
+		return nil, err
+	}
+	found := false
+	for _, id := range ids {
+		found = found || id == s.config.KeyID
+	}
+	if !found {
+		ids = append(ids, s.config.KeyID)
+	}
+	sort.Strings(ids)
+
+	keys := make([]*SigningKey, 0, len(ids))
+	for _, id := range ids {
+		key, err := s.GetKey(id)
+		if err != nil {
+			if s.logger != nil {
+				s.logger.Warn("读取签名密钥失败", zap.String("key_id", id), zap.Error(err))
+			}
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GetKey returns the public information of the key id.
+func (s *SignatureService) GetKey(id string) (*SigningKey, error) {
+	if s.keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+	info, err := signature.DescribeKey(s.keys, id)
+	if err != nil {
+		return nil, err
+	}
+	return &SigningKey{KeyInfo: *info, Default: id == s.config.KeyID}, nil
+}
+
+// GenerateKey creates the key id, for providers that hold exportable keys.
+func (s *SignatureService) GenerateKey(id string) (*SigningKey, error) {
+	if s.keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+	if _, err := s.keys.GenerateKey(id); err != nil {
+		return nil, err
+	}
+	if s.logger != nil {
+		s.logger.Info("已生成签名密钥", zap.String("key_id", id), zap.String("provider", s.keys.Name()))
+	}
+	return s.GetKey(id)
+}
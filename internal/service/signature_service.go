@@ -3,23 +3,40 @@ package service
 
 import (
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"sync"
 	"time"
 
+	"cyp-docker-registry/pkg/signature"
+
 	"go.uber.org/zap"
 )
 
+// 签名验证后端
+const (
+	SignatureBackendBuiltin  = "builtin"  // 通过签名接口生成的签名
+	SignatureBackendNotation = "notation" // 以 OCI 引用者推送的 notation 签名，按信任策略验证
+)
+
+// ErrNotationSigning 使用 notation 后端时签名由客户端完成
+var ErrNotationSigning = errors.New("images are signed with the notation CLI when the notation backend is used")
+
 // SignatureService provides image signature management services.
 type SignatureService struct {
 	keyPath    string
 	signatures sync.Map // map[imageRef]*SignatureInfo
 	logger     *zap.Logger
 	config     *SignatureConfig
+	modeMu     sync.RWMutex // 保护 config.Mode，可通过设置接口修改
+	notation   *signature.NotationVerifier
+	images     AttestationSource // 读取 notation 签名，见 notation.go
+	keys       signature.KeyProvider
 }
 
 // SignatureConfig holds signature configuration.
@@ -31,6 +48,14 @@ type SignatureConfig struct {
 	RequireSignature bool
 	KeyPath          string
 	TrustedKeys      []string
+	Backend          string // builtin（默认）或 notation
+	// notation 信任策略文档和证书目录
+	NotationTrustPolicy string
+	NotationTrustStore  string
+	// Keys selects the key provider holding the signing keys, KeyID is
+	// the default key
+	Keys  signature.KeyProviderConfig
+	KeyID string
 }
 
 // SignatureInfo represents signature information for an image.
@@ -41,6 +66,7 @@ type SignatureInfo struct {
 	SignedBy     string            `json:"signed_by"`
 	SignedAt     time.Time         `json:"signed_at"`
 	KeyID        string            `json:"key_id"`
+	Algorithm    string            `json:"algorithm,omitempty"` // 为空时为早期版本的签名
 	Verified     bool              `json:"verified"`
 	Attestations []string          `json:"attestations,omitempty"`
 	Metadata     map[string]string `json:"metadata,omitempty"`
@@ -63,6 +89,9 @@ type VerifyResult struct {
 	Verified  bool           `json:"verified"`
 	Signature *SignatureInfo `json:"signature,omitempty"`
 	Error     string         `json:"error,omitempty"`
+	Backend   string         `json:"backend"`
+	// Notation is the result of the notation backend
+	Notation *signature.NotationResult `json:"notation,omitempty"`
 }
 
 // NewSignatureService creates a new SignatureService instance.
@@ -85,6 +114,20 @@ func NewSignatureService(config *SignatureConfig, logger *zap.Logger) *Signature
 		os.MkdirAll(config.KeyPath, 0700)
 	}
 
+	if config.Backend == SignatureBackendNotation {
+		verifier, err := signature.NewNotationVerifier(config.NotationTrustPolicy, config.NotationTrustStore)
+		if err != nil && logger != nil {
+			logger.Error("加载 notation 信任策略失败", zap.Error(err))
+		}
+		s.notation = verifier
+	}
+	keys, err := signature.NewKeyProvider(config.Keys)
+	if err != nil && logger != nil {
+		logger.Error("初始化签名密钥提供者失败", zap.String("provider", config.Keys.Provider), zap.Error(err))
+	}
+	s.keys = keys
+	s.ensureDefaultKey()
+
 	return s
 }
 
@@ -93,18 +136,26 @@ func (s *SignatureService) SignImage(req *SignRequest, userID int64, username st
 	if !s.config.Enabled {
 		return nil, errors.New("signature service is disabled")
 	}
+	if s.Backend() == SignatureBackendNotation {
+		return nil, ErrNotationSigning
+	}
 
 	// Generate signature
+	keyID := s.keyID(req.KeyID)
 	digest := s.calculateDigest(req.ImageRef)
-	signature := s.generateSignature(digest, req.KeyID)
+	sig, algorithm, err := s.generateSignature(digest, keyID)
+	if err != nil {
+		return nil, err
+	}
 
 	info := &SignatureInfo{
 		ImageRef:  req.ImageRef,
 		Digest:    digest,
-		Signature: signature,
+		Signature: sig,
 		SignedBy:  username,
 		SignedAt:  time.Now(),
-		KeyID:     req.KeyID,
+		KeyID:     keyID,
+		Algorithm: algorithm,
 		Verified:  true,
 		Metadata: map[string]string{
 			"user_id": string(rune(userID)),
@@ -132,12 +183,16 @@ func (s *SignatureService) VerifyImage(req *VerifyRequest) (*VerifyResult, error
 	result := &VerifyResult{
 		ImageRef: req.ImageRef,
 		Verified: false,
+		Backend:  s.Backend(),
 	}
 
 	if !s.config.Enabled {
 		result.Error = "signature service is disabled"
 		return result, nil
 	}
+	if result.Backend == SignatureBackendNotation {
+		return s.verifyNotation(result)
+	}
 
 	// Look up signature
 	info, ok := s.signatures.Load(req.ImageRef)
@@ -162,7 +217,7 @@ func (s *SignatureService) VerifyImage(req *VerifyRequest) (*VerifyResult, error
 	}
 
 	// Verify signature value
-	if !s.verifySignature(sigInfo.Digest, sigInfo.Signature, sigInfo.KeyID) {
+	if !s.verifySignature(sigInfo) {
 		result.Error = "invalid signature"
 		return result, nil
 	}
@@ -226,7 +281,35 @@ func (s *SignatureService) IsSignatureRequired(imageRef string) bool {
 	if !s.config.Enabled {
 		return false
 	}
-	return s.config.RequireSignature && s.config.Mode == "enforce"
+	return s.config.RequireSignature && s.Mode() == "enforce"
+}
+
+// SetMode sets the signature mode: enforce, warn or disabled.
+func (s *SignatureService) SetMode(mode string) error {
+	switch mode {
+	case "enforce", "warn", "disabled":
+	default:
+		return fmt.Errorf("invalid signature mode: %s", mode)
+	}
+	s.modeMu.Lock()
+	s.config.Mode = mode
+	s.modeMu.Unlock()
+	return nil
+}
+
+// Mode returns the signature mode.
+func (s *SignatureService) Mode() string {
+	s.modeMu.RLock()
+	defer s.modeMu.RUnlock()
+	return s.config.Mode
+}
+
+// Backend returns the verification backend: builtin or notation.
+func (s *SignatureService) Backend() string {
+	if s.config.Backend == "" {
+		return SignatureBackendBuiltin
+	}
+	return s.config.Backend
 }
 
 // calculateDigest calculates the digest of an image reference.
@@ -235,20 +318,41 @@ func (s *SignatureService) calculateDigest(imageRef string) string {
 	return "sha256:" + hex.EncodeToString(hash[:])
 }
 
-// generateSignature generates a signature for a digest.
-func (s *SignatureService) generateSignature(digest, keyID string) string {
-	// Simplified signature generation
-	// In production, use proper cryptographic signing (cosign, etc.)
-	data := digest + ":" + keyID + ":" + time.Now().String()
-	hash := sha256.Sum256([]byte(data))
-	return hex.EncodeToString(hash[:])
+// generateSignature signs a digest with the key keyID of the key provider,
+// returning the base64 signature and its algorithm.
+func (s *SignatureService) generateSignature(digest, keyID string) (string, string, error) {
+	if s.keys == nil {
+		return "", "", ErrNoKeyProvider
+	}
+	signer, err := s.keys.Signer(keyID)
+	if err != nil {
+		return "", "", err
+	}
+	sig, err := signature.SignMessage(signer, []byte(digest))
+	if err != nil {
+		return "", "", fmt.Errorf("sign with key %s: %w", keyID, err)
+	}
+	return base64.StdEncoding.EncodeToString(sig), signature.KeyAlgorithm(signer.Public()), nil
 }
 
-// verifySignature verifies a signature.
-func (s *SignatureService) verifySignature(_, signature, _ string) bool {
-	// Simplified verification
-	// In production, use proper cryptographic verification
-	return len(signature) == 64 // SHA256 hex length
+// verifySignature verifies a signature with the public key of its key.
+func (s *SignatureService) verifySignature(info *SignatureInfo) bool {
+	if info.Algorithm == "" {
+		// 早期版本的签名只是摘要的哈希
+		return len(info.Signature) == 64 // SHA256 hex length
+	}
+	if s.keys == nil {
+		return false
+	}
+	signer, err := s.keys.Signer(info.KeyID)
+	if err != nil {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(info.Signature)
+	if err != nil {
+		return false
+	}
+	return signature.VerifyMessage(signer.Public(), []byte(info.Digest), sig)
 }
 
 // persistSignature saves a signature to disk.
@@ -0,0 +1,153 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"cyp-docker-registry/internal/dao"
+)
+
+// Personal access token scopes. Organization scopes have the form
+// org:<name>:read and org:<name>:write and are limited to the repositories
+// and settings of that organization.
+const (
+	ScopeAll            = "*" // 旧令牌使用的全部权限
+	ScopeRegistryRead   = "registry:read"
+	ScopeRegistryWrite  = "registry:write"
+	ScopeRegistryDelete = "registry:delete"
+	ScopeAuditRead      = "audit:read"
+	ScopeAdminRead      = "admin:read"
+	ScopeAdminWrite     = "admin:write"
+	ScopeAdminAll       = "admin:*"
+)
+
+// ScopeInfo describes a scope for the token creation form.
+type ScopeInfo struct {
+	Scope       string `json:"scope"`
+	Description string `json:"description"`
+	AdminOnly   bool   `json:"admin_only,omitempty"`
+}
+
+// TokenScopes lists the fixed scopes that can be granted to a token.
+var TokenScopes = []ScopeInfo{
+	{ScopeRegistryRead, "拉取镜像、查看仓库信息", false},
+	{ScopeRegistryWrite, "推送镜像、修改仓库设置", false},
+	{ScopeRegistryDelete, "删除镜像", false},
+	{ScopeAuditRead, "读取审计日志", false},
+	{ScopeAdminRead, "读取系统管理接口", true},
+	{ScopeAdminWrite, "调用系统管理接口", true},
+	{ScopeAdminAll, "全部权限", true},
+}
+
+// ErrInvalidScope is returned when a token is created with an unknown or
+// unauthorized scope.
+var ErrInvalidScope = errors.New("invalid scope")
+
+var orgScopePattern = regexp.MustCompile(`^org:([^:\s]+):(read|write)$`)
+
+// scopeImplies 列出高权限范围隐含的低权限范围
+var scopeImplies = map[string][]string{
+	ScopeRegistryWrite:  {ScopeRegistryRead},
+	ScopeRegistryDelete: {ScopeRegistryRead},
+	ScopeAdminWrite:     {ScopeAdminRead, ScopeAuditRead},
+	ScopeAdminRead:      {ScopeAuditRead},
+}
+
+// OrgScope returns the scope granting access to an organization.
+func OrgScope(org string, write bool) string {
+	if write {
+		return "org:" + org + ":write"
+	}
+	return "org:" + org + ":read"
+}
+
+// ScopeAllows reports whether the granted scopes include required.
+func ScopeAllows(granted []string, required string) bool {
+	for _, g := range granted {
+		if scopeCovers(g, required) {
+			return true
+		}
+	}
+	return false
+}
+
+func scopeCovers(granted, required string) bool {
+	if granted == required || granted == ScopeAll || granted == ScopeAdminAll {
+		return true
+	}
+	for _, implied := range scopeImplies[granted] {
+		if implied == required {
+			return true
+		}
+	}
+	// org:<name>:write 隐含 org:<name>:read
+	if m := orgScopePattern.FindStringSubmatch(granted); m != nil && m[2] == "write" {
+		return required == OrgScope(m[1], false)
+	}
+	return false
+}
+
+// ScopeAllowsRepository reports whether the granted scopes allow an action
+// on a repository. action is "pull", "push" or "delete"; organization scopes
+// cover the repositories of the organization namespace.
+func ScopeAllowsRepository(granted []string, repository, action string) bool {
+	required := ScopeRegistryRead
+	switch action {
+	case "push":
+		required = ScopeRegistryWrite
+	case "delete":
+		required = ScopeRegistryDelete
+	}
+	if ScopeAllows(granted, required) {
+		return true
+	}
+
+	namespace, _, ok := strings.Cut(repository, "/")
+	if !ok {
+		return false
+	}
+	return ScopeAllows(granted, OrgScope(namespace, action != "pull"))
+}
+
+// ValidateScopes checks the scopes requested for a new token of a user.
+// Admin scopes require the admin role and organization scopes require
+// membership of the organization.
+func ValidateScopes(scopes []string, user *dao.User) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+
+	for _, scope := range scopes {
+		if m := orgScopePattern.FindStringSubmatch(scope); m != nil {
+			org, err := dao.GetOrganizationByName(m[1])
+			if err != nil {
+				return err
+			}
+			if org == nil {
+				return fmt.Errorf("%w: organization %s not found", ErrInvalidScope, m[1])
+			}
+			if user.Role != RoleAdmin && org.OwnerID != user.ID && orgRole(org.ID, user.ID) == "" {
+				return fmt.Errorf("%w: not a member of organization %s", ErrInvalidScope, m[1])
+			}
+			continue
+		}
+
+		known := false
+		for _, info := range TokenScopes {
+			if info.Scope == scope {
+				if info.AdminOnly && user.Role != RoleAdmin {
+					return fmt.Errorf("%w: %s requires the admin role", ErrInvalidScope, scope)
+				}
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+		}
+	}
+	return nil
+}
@@ -51,6 +51,17 @@ func NewTokenService(logger *zap.Logger) *TokenService {
 
 // CreateToken creates a new personal access token.
 func (s *TokenService) CreateToken(req *CreateTokenRequest, userID int64) (*CreateTokenResponse, error) {
+	user, err := dao.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if err := ValidateScopes(req.Scopes, user); err != nil {
+		return nil, err
+	}
+
 	// Generate token
 	plainToken := generatePlainToken()
 	tokenHash := hashToken(plainToken)
@@ -96,8 +107,19 @@ func (s *TokenService) CreateToken(req *CreateTokenRequest, userID int64) (*Crea
 	}, nil
 }
 
-// ValidateToken validates a personal access token.
+// ValidateToken validates a personal access token and records its use.
 func (s *TokenService) ValidateToken(plainToken string) (*Token, error) {
+	token, err := s.LookupToken(plainToken)
+	if err != nil {
+		return nil, err
+	}
+	dao.UpdateTokenLastUsed(token.ID)
+	return token, nil
+}
+
+// LookupToken validates a personal access token without recording its use,
+// for callers that only identify the token such as rate limiting.
+func (s *TokenService) LookupToken(plainToken string) (*Token, error) {
 	// Remove prefix if present
 	if len(plainToken) > 4 && plainToken[:4] == "pat_" {
 		plainToken = plainToken[4:]
@@ -117,9 +139,6 @@ func (s *TokenService) ValidateToken(plainToken string) (*Token, error) {
 		return nil, errors.New("token expired")
 	}
 
-	// Update last used
-	dao.UpdateTokenLastUsed(daoToken.ID)
-
 	token := &Token{
 		ID:        daoToken.ID,
 		UserID:    daoToken.UserID,
@@ -172,14 +191,42 @@ func (s *TokenService) DeleteToken(id int64, userID int64) error {
 	return dao.DeleteToken(id)
 }
 
-// HasScope checks if a token has a specific scope.
+// HasScope checks if a token has a specific scope, directly or through a
+// broader scope.
 func (s *TokenService) HasScope(token *Token, scope string) bool {
-	for _, s := range token.Scopes {
-		if s == scope || s == "*" {
-			return true
+	return ScopeAllows(token.Scopes, scope)
+}
+
+// AvailableScopes lists the scopes a user can grant to a new token: the
+// fixed scopes allowed for the user's role and the scopes of the user's
+// organizations.
+func (s *TokenService) AvailableScopes(userID int64) ([]ScopeInfo, error) {
+	user, err := dao.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	var scopes []ScopeInfo
+	for _, info := range TokenScopes {
+		if !info.AdminOnly || user.Role == RoleAdmin {
+			scopes = append(scopes, info)
 		}
 	}
-	return false
+
+	orgs, err := dao.ListUserOrganizations(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		scopes = append(scopes,
+			ScopeInfo{Scope: OrgScope(org.Name, false), Description: "读取组织 " + org.Name + " 的仓库和设置"},
+			ScopeInfo{Scope: OrgScope(org.Name, true), Description: "管理组织 " + org.Name + " 的仓库和设置"},
+		)
+	}
+	return scopes, nil
 }
 
 func generatePlainToken() string {
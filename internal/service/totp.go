@@ -0,0 +1,74 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// totpStep is the time step of TOTP codes (RFC 6238).
+const totpStep = 30 * time.Second
+
+// TOTPVerifier checks time-based one-time passwords as generated by
+// authenticator apps: HMAC-SHA1, 6 digits, 30 second steps, accepting one
+// step of clock skew. A code is accepted only once.
+type TOTPVerifier struct {
+	mu       sync.Mutex
+	key      []byte
+	lastUsed int64 // 最近一次通过验证的时间步，防止重放
+}
+
+// NewTOTPVerifier creates a verifier from a base32 secret.
+func NewTOTPVerifier(secret string) (*TOTPVerifier, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+	if len(key) < 10 {
+		return nil, fmt.Errorf("TOTP secret must be at least 80 bits")
+	}
+	return &TOTPVerifier{key: key, lastUsed: -1}, nil
+}
+
+// Verify reports whether code is valid at t and not used before.
+func (v *TOTPVerifier) Verify(code string, t time.Time) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	counter := t.Unix() / int64(totpStep/time.Second)
+	for _, step := range []int64{counter, counter - 1, counter + 1} {
+		if step <= v.lastUsed {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(v.key, step)), []byte(code)) {
+			v.lastUsed = step
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the 6 digit code of a time step (RFC 4226 truncation).
+func totpCode(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
@@ -0,0 +1,187 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// UnlockGuardConfig holds the brute-force limits of the unlock endpoint.
+type UnlockGuardConfig struct {
+	MaxAttempts    int           // 每个 IP 在 Window 内的失败次数上限，达到后到窗口结束前拒绝
+	Window         time.Duration // 失败次数的统计窗口
+	BaseDelay      time.Duration // 第 n 次失败后须等待 BaseDelay*2^(n-1)
+	MaxDelay       time.Duration // 等待时间上限
+	PermanentAfter int           // 所有 IP 累计失败达到该次数后锁定升级为永久锁定，0 不升级
+}
+
+// unlockAttempts tracks the failures of one client IP.
+type unlockAttempts struct {
+	failures int // 当前窗口内的失败次数
+	first    time.Time
+	until    time.Time // 在此之前拒绝该 IP 的解锁请求
+	pending  bool      // 已有一次尝试正在校验
+}
+
+// UnlockGuard limits unlock attempts per client IP with escalating delays
+// and counts the failures of all IPs towards a permanent lock.
+type UnlockGuard struct {
+	mu       sync.Mutex
+	config   UnlockGuardConfig
+	attempts map[string]*unlockAttempts
+	total    int       // 上次解锁以来所有 IP 的失败次数，IP 记录过期后仍保留
+	pruned   time.Time // 上次清理过期 IP 记录的时间
+	now      func() time.Time
+}
+
+// NewUnlockGuard creates a new UnlockGuard instance.
+func NewUnlockGuard(config UnlockGuardConfig) *UnlockGuard {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 5
+	}
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+	if config.BaseDelay <= 0 {
+		config.BaseDelay = 2 * time.Second
+	}
+	if config.MaxDelay < config.BaseDelay {
+		config.MaxDelay = config.BaseDelay
+	}
+	return &UnlockGuard{
+		config:   config,
+		attempts: make(map[string]*unlockAttempts),
+		now:      time.Now,
+	}
+}
+
+// Reserve takes the attempt slot of ip before its credentials are
+// verified, so parallel requests cannot all pass before a failure is
+// counted. It returns how long ip has to wait, 0 when the slot was taken;
+// the slot must then be given back with Failure or Release.
+func (g *UnlockGuard) Reserve(ip string) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	g.prune(now)
+	a := g.current(ip)
+	if a == nil {
+		a = &unlockAttempts{first: now}
+		g.attempts[ip] = a
+	}
+	if wait := a.until.Sub(now); wait > 0 {
+		return wait
+	}
+	if a.pending {
+		// 上一次尝试尚未完成
+		return g.config.BaseDelay
+	}
+	a.pending = true
+	return 0
+}
+
+// Release gives back the slot of ip without counting a failure, after a
+// successful unlock or an attempt that was not checked.
+func (g *UnlockGuard) Release(ip string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if a, ok := g.attempts[ip]; ok {
+		a.pending = false
+		if a.failures == 0 {
+			delete(g.attempts, ip)
+		}
+	}
+}
+
+// Failure records a failed attempt of ip and gives back its slot. It
+// returns how long ip has to wait before the next attempt, the attempts
+// left in the window, and whether the failures of all IPs reached the
+// permanent lock threshold.
+func (g *UnlockGuard) Failure(ip string) (wait time.Duration, remaining int, permanent bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	a := g.current(ip)
+	if a == nil {
+		a = &unlockAttempts{first: now}
+		g.attempts[ip] = a
+	}
+	a.pending = false
+	a.failures++
+	g.total++
+
+	wait = g.config.MaxDelay
+	if shift := a.failures - 1; shift < 20 {
+		if d := g.config.BaseDelay << shift; d < wait {
+			wait = d
+		}
+	}
+	remaining = g.config.MaxAttempts - a.failures
+	if remaining <= 0 {
+		remaining = 0
+		// 窗口结束前拒绝该 IP
+		if end := a.first.Add(g.config.Window).Sub(now); end > wait {
+			wait = end
+		}
+	}
+	a.until = now.Add(wait)
+
+	permanent = g.config.PermanentAfter > 0 && g.total >= g.config.PermanentAfter
+	return wait, remaining, permanent
+}
+
+// Reset clears the failures of all IPs after a successful unlock. Slots
+// reserved by attempts still being checked are kept.
+func (g *UnlockGuard) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.total = 0
+	for ip, a := range g.attempts {
+		if !a.pending {
+			delete(g.attempts, ip)
+			continue
+		}
+		*a = unlockAttempts{first: g.now(), pending: true}
+	}
+}
+
+// current returns the attempts of ip, starting a new window when the last
+// one expired. Must be called with g.mu held.
+func (g *UnlockGuard) current(ip string) *unlockAttempts {
+	a, ok := g.attempts[ip]
+	if !ok {
+		return nil
+	}
+	now := g.now()
+	if g.expired(a, now) {
+		if !a.pending {
+			delete(g.attempts, ip)
+			return nil
+		}
+		a.failures, a.first = 0, now
+	}
+	return a
+}
+
+// expired reports whether the window and the wait of a are over.
+func (g *UnlockGuard) expired(a *unlockAttempts, now time.Time) bool {
+	return now.Sub(a.first) > g.config.Window && !now.Before(a.until)
+}
+
+// prune drops the expired attempts of IPs that did not come back, at most
+// once a minute. Must be called with g.mu held.
+func (g *UnlockGuard) prune(now time.Time) {
+	if now.Sub(g.pruned) < time.Minute {
+		return
+	}
+	g.pruned = now
+	for ip, a := range g.attempts {
+		if !a.pending && g.expired(a, now) {
+			delete(g.attempts, ip)
+		}
+	}
+}
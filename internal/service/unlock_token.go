@@ -0,0 +1,108 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// UnlockTokens issues one-time unlock tokens. Each lock writes a new token
+// to a file readable only on the host, so presenting it proves access to
+// the host without sending a password over the network.
+type UnlockTokens struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	hash    [sha256.Size]byte
+	expires time.Time
+	issued  bool
+	now     func() time.Time
+}
+
+// NewUnlockTokens creates a new UnlockTokens instance writing tokens to
+// path, valid for ttl.
+func NewUnlockTokens(path string, ttl time.Duration) *UnlockTokens {
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	return &UnlockTokens{
+		path: path,
+		ttl:  ttl,
+		now:  time.Now,
+	}
+}
+
+// Path returns the file the token is written to.
+func (t *UnlockTokens) Path() string {
+	return t.path
+}
+
+// Issue generates a new token, replacing the previous one, and writes it
+// to the token file with mode 0600. Only its hash is kept in memory.
+func (t *UnlockTokens) Issue() error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return err
+	}
+	// 先删除旧文件，确保新文件以 0600 创建
+	os.Remove(t.path)
+	if err := os.WriteFile(t.path, []byte(token+"\n"), 0600); err != nil {
+		return err
+	}
+	t.hash = sha256.Sum256([]byte(token))
+	t.expires = t.now().Add(t.ttl)
+	t.issued = true
+	return nil
+}
+
+// Consume reports whether token is the current unexpired token. A valid
+// token is used up, so it works only once.
+func (t *UnlockTokens) Consume(token string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.issued || !t.now().Before(t.expires) {
+		return false
+	}
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], t.hash[:]) != 1 {
+		return false
+	}
+	t.revokeUnsafe()
+	return true
+}
+
+// Expired reports whether a token was issued and has expired.
+func (t *UnlockTokens) Expired() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.issued && !t.now().Before(t.expires)
+}
+
+// Revoke invalidates the current token and removes the token file.
+func (t *UnlockTokens) Revoke() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.revokeUnsafe()
+}
+
+func (t *UnlockTokens) revokeUnsafe() {
+	t.issued = false
+	t.hash = [sha256.Size]byte{}
+	t.expires = time.Time{}
+	os.Remove(t.path)
+}
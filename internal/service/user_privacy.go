@@ -0,0 +1,220 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// maxUserDataRecords bounds each list of a personal data export.
+const maxUserDataRecords = 10000
+
+// UserDataExport is the personal data stored about a user, returned for
+// data access requests.
+type UserDataExport struct {
+	ExportedAt     time.Time             `json:"exported_at"`
+	User           *ManagedUser          `json:"user"`
+	Organizations  []UserOrgMembership   `json:"organizations"`
+	Tokens         []*Token              `json:"tokens"`
+	Sessions       []UserSession         `json:"sessions"`
+	Stars          []UserStar            `json:"stars"`
+	AuditLogs      []*AuditLog           `json:"audit_logs"`
+	AccessAttempts []*AccessAttempt      `json:"access_attempts"`
+	RegistryEvents []*RegistryEventEntry `json:"registry_events"`
+	Truncated      bool                  `json:"truncated"` // 有列表超过 10000 条，只导出了最新的记录
+}
+
+// UserOrgMembership is an organization the user belongs to.
+type UserOrgMembership struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+// UserSession is an active login session.
+type UserSession struct {
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UserStar is a repository starred by the user.
+type UserStar struct {
+	Repository string    `json:"repository"`
+	StarredAt  time.Time `json:"starred_at"`
+}
+
+// UserErasure reports the records changed by an erasure request.
+type UserErasure struct {
+	UserID         int64  `json:"user_id"`
+	Pseudonym      string `json:"pseudonym"`
+	AccountDeleted bool   `json:"account_deleted"`
+	AuditLogs      int64  `json:"audit_logs"`
+	AccessAttempts int64  `json:"access_attempts"`
+	RegistryEvents int64  `json:"registry_events"`
+	OtherRecords   int64  `json:"other_records"`
+}
+
+// ExportUserData collects the personal data stored about a user.
+func (s *UserService) ExportUserData(id int64) (*UserDataExport, error) {
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+
+	export := &UserDataExport{
+		ExportedAt:     time.Now(),
+		User:           convertManagedUser(u),
+		Organizations:  []UserOrgMembership{},
+		Tokens:         []*Token{},
+		Sessions:       []UserSession{},
+		Stars:          []UserStar{},
+		AuditLogs:      []*AuditLog{},
+		AccessAttempts: []*AccessAttempt{},
+		RegistryEvents: []*RegistryEventEntry{},
+	}
+
+	orgs, err := dao.ListUserOrganizations(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, org := range orgs {
+		role := orgRole(org.ID, id)
+		if org.OwnerID == id {
+			role = "owner"
+		}
+		export.Organizations = append(export.Organizations, UserOrgMembership{ID: org.ID, Name: org.Name, Role: role})
+	}
+
+	tokens, err := NewTokenService(s.logger).ListTokens(id)
+	if err != nil {
+		return nil, err
+	}
+	export.Tokens = append(export.Tokens, tokens...)
+
+	sessions, err := dao.ListUserSessions(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range sessions {
+		export.Sessions = append(export.Sessions, UserSession{
+			IPAddress: session.IP,
+			UserAgent: session.UserAgent,
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+		})
+	}
+
+	stars, err := dao.ListStarredRepositories(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, star := range stars {
+		export.Stars = append(export.Stars, UserStar{Repository: star.Repository, StarredAt: star.CreatedAt})
+	}
+
+	logs, err := dao.ListUserAuditLogs(id, u.Username, maxUserDataRecords+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(logs) > maxUserDataRecords {
+		logs, export.Truncated = logs[:maxUserDataRecords], true
+	}
+	for _, log := range logs {
+		export.AuditLogs = append(export.AuditLogs, &AuditLog{
+			ID:             log.ID,
+			Timestamp:      log.Timestamp,
+			Level:          log.Level,
+			Event:          log.Event,
+			UserID:         log.UserID.Int64,
+			Username:       log.Username.String,
+			IPAddress:      log.IPAddress,
+			Resource:       log.Resource,
+			Action:         log.Action,
+			Status:         log.Status,
+			Details:        log.Details,
+			BlockchainHash: log.BlockchainHash,
+			RequestID:      log.RequestID.String,
+		})
+	}
+
+	attempts, err := dao.ListUserAccessAttempts(id, u.Username, maxUserDataRecords+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(attempts) > maxUserDataRecords {
+		attempts, export.Truncated = attempts[:maxUserDataRecords], true
+	}
+	for _, a := range attempts {
+		export.AccessAttempts = append(export.AccessAttempts, &AccessAttempt{
+			ID:             a.ID,
+			IPAddress:      a.IPAddress,
+			UserAgent:      a.UserAgent,
+			UserID:         a.UserID.Int64,
+			Action:         a.Action,
+			Resource:       a.Resource,
+			Status:         a.Status,
+			ErrorMsg:       a.ErrorMsg,
+			BlockchainHash: a.BlockchainHash,
+			CreatedAt:      a.CreatedAt,
+		})
+	}
+
+	events, total, err := dao.ListRegistryEvents(dao.RegistryEventFilter{Username: u.Username}, 1, maxUserDataRecords)
+	if err != nil {
+		return nil, err
+	}
+	if total > maxUserDataRecords {
+		export.Truncated = true
+	}
+	for _, e := range events {
+		export.RegistryEvents = append(export.RegistryEvents, registryEventEntry(e))
+	}
+	return export, nil
+}
+
+// EraseUserData handles an erasure request: the account is deleted, with
+// the same checks as DeleteUser, and the name and IP addresses of the user
+// are removed from the audit logs, access attempts and image events. The
+// records are kept for the audit trail under the pseudonym erased-<id>,
+// with IP addresses truncated. For an account deleted before, username
+// also matches the records written under that name.
+func (s *UserService) EraseUserData(id, actorID int64, username string) (*UserErasure, error) {
+	result := &UserErasure{UserID: id, Pseudonym: fmt.Sprintf("erased-%d", id)}
+
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u != nil {
+		if err := s.DeleteUser(id, actorID); err != nil {
+			return nil, err
+		}
+		username = u.Username
+		result.AccountDeleted = true
+	}
+
+	erased, err := dao.EraseUserRecords(id, username, result.Pseudonym, truncateIP)
+	if err != nil {
+		return nil, err
+	}
+	result.AuditLogs = erased.AuditLogs
+	result.AccessAttempts = erased.AccessAttempts
+	result.RegistryEvents = erased.RegistryEvents
+	result.OtherRecords = erased.Other
+
+	s.logInfo("用户个人数据已清除",
+		zap.Int64("user_id", id),
+		zap.Int64("audit_logs", erased.AuditLogs),
+		zap.Int64("access_attempts", erased.AccessAttempts),
+		zap.Int64("registry_events", erased.RegistryEvents),
+	)
+	return result, nil
+}
@@ -0,0 +1,335 @@
+// Package service provides business logic services for CYP-Docker-Registry.
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/dao"
+
+	"go.uber.org/zap"
+)
+
+// User roles.
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// MinPasswordLength 与注册接口的密码长度要求一致
+const MinPasswordLength = 6
+
+// User management errors.
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("username already exists")
+	ErrInvalidRole      = errors.New("invalid role, must be admin or user")
+	ErrWeakPassword     = errors.New("password must be at least 6 characters")
+	ErrWrongPassword    = errors.New("current password is incorrect")
+	ErrCannotModifySelf = errors.New("administrators cannot demote, deactivate or delete themselves")
+	ErrLastAdmin        = errors.New("cannot remove the last active administrator")
+	ErrUserOwnsOrgs     = errors.New("user owns organizations, transfer or delete them first")
+)
+
+// UserService provides user administration services.
+type UserService struct {
+	logger *zap.Logger
+}
+
+// ManagedUser is a user as seen by administrators.
+type ManagedUser struct {
+	ID                 int64      `json:"id"`
+	Username           string     `json:"username"`
+	Email              string     `json:"email,omitempty"`
+	Role               string     `json:"role"`
+	IsActive           bool       `json:"is_active"`
+	MustChangePassword bool       `json:"must_change_password"`
+	CreatedAt          time.Time  `json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+	LastLoginAt        *time.Time `json:"last_login_at,omitempty"`
+}
+
+// CreateUserRequest represents a request to create a user.
+type CreateUserRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=20"`
+	Password string `json:"password" binding:"required"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// NewUserService creates a new UserService instance.
+func NewUserService(logger *zap.Logger) *UserService {
+	return &UserService{
+		logger: logger,
+	}
+}
+
+// ListUsers lists users, optionally filtered by username or email.
+func (s *UserService) ListUsers(search string, page, pageSize int) ([]*ManagedUser, int, error) {
+	records, total, err := dao.SearchUsers(strings.TrimSpace(search), page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]*ManagedUser, len(records))
+	for i, r := range records {
+		users[i] = convertManagedUser(r)
+	}
+	return users, total, nil
+}
+
+// GetUser retrieves a user by ID.
+func (s *UserService) GetUser(id int64) (*ManagedUser, error) {
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	return convertManagedUser(u), nil
+}
+
+// CreateUser creates a user on behalf of an administrator.
+func (s *UserService) CreateUser(req *CreateUserRequest) (*ManagedUser, error) {
+	role := req.Role
+	if role == "" {
+		role = RoleUser
+	}
+	if role != RoleAdmin && role != RoleUser {
+		return nil, ErrInvalidRole
+	}
+	if len(req.Password) < MinPasswordLength {
+		return nil, ErrWeakPassword
+	}
+
+	existing, err := dao.GetUserByUsername(req.Username)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrUserExists
+	}
+	// Organizations and deleted users keep their names
+	if ns, err := dao.GetNamespace(req.Username); err != nil {
+		return nil, err
+	} else if ns != nil {
+		return nil, ErrUserExists
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &dao.User{
+		Username:     req.Username,
+		PasswordHash: hash,
+		Role:         role,
+		IsActive:     true,
+	}
+	if req.Email != "" {
+		u.Email.String, u.Email.Valid = req.Email, true
+	}
+	if err := dao.CreateUser(u); err != nil {
+		if errors.Is(err, dao.ErrNamespaceTaken) {
+			return nil, ErrUserExists
+		}
+		return nil, err
+	}
+
+	s.logInfo("用户已创建", zap.String("username", u.Username), zap.String("role", role))
+	return s.GetUser(u.ID)
+}
+
+// SetRole changes the role of a user.
+func (s *UserService) SetRole(id int64, role string, actorID int64) (*ManagedUser, error) {
+	if role != RoleAdmin && role != RoleUser {
+		return nil, ErrInvalidRole
+	}
+
+	u, err := s.loadForChange(id, actorID, role != RoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	u.Role = role
+	if err := dao.UpdateUser(u); err != nil {
+		return nil, err
+	}
+	return convertManagedUser(u), nil
+}
+
+// SetActive activates or deactivates a user. Deactivated users can no
+// longer log in, and their existing tokens are rejected.
+func (s *UserService) SetActive(id int64, active bool, actorID int64) (*ManagedUser, error) {
+	u, err := s.loadForChange(id, actorID, !active)
+	if err != nil {
+		return nil, err
+	}
+	u.IsActive = active
+	if err := dao.UpdateUser(u); err != nil {
+		return nil, err
+	}
+	if !active {
+		dao.DeleteUserSessions(id)
+	}
+	return convertManagedUser(u), nil
+}
+
+// SetEmail changes the email address of a user.
+func (s *UserService) SetEmail(id int64, email string) (*ManagedUser, error) {
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	u.Email.String, u.Email.Valid = email, email != ""
+	if err := dao.UpdateUser(u); err != nil {
+		return nil, err
+	}
+	return convertManagedUser(u), nil
+}
+
+// ResetPassword sets a new password that the user must change at the next
+// login. A random password is generated when password is empty; it is
+// returned so the administrator can hand it over.
+func (s *UserService) ResetPassword(id int64, password string) (string, error) {
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return "", err
+	}
+	if u == nil {
+		return "", ErrUserNotFound
+	}
+
+	if password == "" {
+		password = generateTempPassword()
+	} else if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	if err := dao.UpdateUserPassword(id, hash, true); err != nil {
+		return "", err
+	}
+	dao.DeleteUserSessions(id)
+
+	s.logInfo("用户密码已重置", zap.String("username", u.Username))
+	return password, nil
+}
+
+// ChangePassword changes the password of the user itself after checking the
+// current one, and clears a pending forced reset.
+func (s *UserService) ChangePassword(id int64, oldPassword, newPassword string) error {
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return err
+	}
+	if u == nil {
+		return ErrUserNotFound
+	}
+	if !CheckPassword(oldPassword, u.PasswordHash) {
+		return ErrWrongPassword
+	}
+	if len(newPassword) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+
+	hash, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	return dao.UpdateUserPassword(id, hash, false)
+}
+
+// DeleteUser deletes a user. Users that still own organizations cannot be
+// deleted.
+func (s *UserService) DeleteUser(id, actorID int64) error {
+	u, err := s.loadForChange(id, actorID, true)
+	if err != nil {
+		return err
+	}
+
+	orgs, err := dao.ListUserOrganizations(id)
+	if err != nil {
+		return err
+	}
+	for _, org := range orgs {
+		if org.OwnerID == id {
+			return ErrUserOwnsOrgs
+		}
+	}
+
+	if err := dao.DeleteUser(id); err != nil {
+		return err
+	}
+	s.logInfo("用户已删除", zap.String("username", u.Username))
+	return nil
+}
+
+// loadForChange 加载要修改的用户；removesAdmin 表示此次修改会使其失去管理员身份，
+// 此时不允许修改自己，也不允许移除最后一个启用的管理员
+func (s *UserService) loadForChange(id, actorID int64, removesAdmin bool) (*dao.User, error) {
+	u, err := dao.GetUserByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, ErrUserNotFound
+	}
+	if !removesAdmin {
+		return u, nil
+	}
+
+	if id == actorID {
+		return nil, ErrCannotModifySelf
+	}
+	if u.Role == RoleAdmin && u.IsActive {
+		n, err := dao.CountActiveAdmins()
+		if err != nil {
+			return nil, err
+		}
+		if n <= 1 {
+			return nil, ErrLastAdmin
+		}
+	}
+	return u, nil
+}
+
+func (s *UserService) logInfo(msg string, fields ...zap.Field) {
+	if s.logger != nil {
+		s.logger.Info(msg, fields...)
+	}
+}
+
+// generateTempPassword 生成临时密码
+func generateTempPassword() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func convertManagedUser(u *dao.User) *ManagedUser {
+	m := &ManagedUser{
+		ID:                 u.ID,
+		Username:           u.Username,
+		Email:              u.Email.String,
+		Role:               u.Role,
+		IsActive:           u.IsActive,
+		MustChangePassword: u.MustChangePassword,
+		CreatedAt:          u.CreatedAt,
+		UpdatedAt:          u.UpdatedAt,
+	}
+	if u.LastLoginAt.Valid {
+		m.LastLoginAt = &u.LastLoginAt.Time
+	}
+	return m
+}
@@ -0,0 +1,482 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// 漏洞库下载
+const (
+	vulnDBFetchTimeout = 5 * time.Minute
+	maxVulnDBSize      = 512 << 20
+	vulnDBStateFile    = "vulndb.json" // 最近一次应用的漏洞库，重启后据此计算增量
+	maxNotifiedImages  = 20
+)
+
+// ErrVulnDBRefreshing is returned when a refresh is already running.
+var ErrVulnDBRefreshing = errors.New("vulnerability database refresh already running")
+
+// VulnAdvisory is an advisory of the vulnerability database: the versions
+// of a package affected by a vulnerability.
+type VulnAdvisory struct {
+	ID          string   `json:"id"`
+	Package     string   `json:"package"`
+	Type        string   `json:"type,omitempty"`     // 包类型，为空时匹配所有类型
+	Affected    string   `json:"affected,omitempty"` // 受影响版本，如 >=2.0,<2.17.1，为空时所有版本受影响
+	FixedIn     string   `json:"fixed_in,omitempty"`
+	Severity    string   `json:"severity"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	References  []string `json:"references,omitempty"`
+
+	constraint versionConstraint
+}
+
+// vulnDBFile is the format of the vulnerability database source.
+type vulnDBFile struct {
+	Version    string          `json:"version"`
+	UpdatedAt  time.Time       `json:"updated_at,omitempty"`
+	Advisories []*VulnAdvisory `json:"advisories"`
+}
+
+// VulnDBConfig holds vulnerability database configuration.
+type VulnDBConfig struct {
+	Source      string // 本地文件或 http(s) URL
+	StoragePath string // 保存已应用的漏洞库
+}
+
+// VulnDBStatus reports the loaded vulnerability database and the last
+// re-scan.
+type VulnDBStatus struct {
+	Source     string        `json:"source"`
+	Version    string        `json:"version,omitempty"`
+	Advisories int           `json:"advisories"`
+	UpdatedAt  *time.Time    `json:"updated_at,omitempty"` // 应用当前版本的时间
+	CheckedAt  *time.Time    `json:"checked_at,omitempty"`
+	LastError  string        `json:"last_error,omitempty"`
+	LastRescan *RescanResult `json:"last_rescan,omitempty"`
+}
+
+// RescanResult describes the re-scan after a vulnerability database update.
+type RescanResult struct {
+	PreviousVersion   string        `json:"previous_version,omitempty"`
+	Version           string        `json:"version"`
+	Updated           bool          `json:"updated"` // 漏洞库版本有变化
+	ChangedAdvisories int           `json:"changed_advisories"`
+	AffectedPackages  []string      `json:"affected_packages"`
+	RescannedImages   []string      `json:"rescanned_images"`
+	NewlyVulnerable   []string      `json:"newly_vulnerable"`  // 原本没有漏洞的镜像
+	NewFindings       int           `json:"new_findings"`      // 新发现的漏洞数
+	ResolvedFindings  int           `json:"resolved_findings"` // 不再适用的漏洞数
+	StartedAt         time.Time     `json:"started_at"`
+	Duration          time.Duration `json:"duration"`
+}
+
+// VulnDB matches the packages of stored SBOMs against a vulnerability
+// database. When the database changes, only the images containing packages
+// of changed advisories are re-scanned.
+type VulnDB struct {
+	config  VulnDBConfig
+	sbom    *SBOMService
+	logger  *zap.Logger
+	proxy   func(*http.Request) (*url.URL, error)
+	notify  func(level, title, message string)
+	mu      sync.RWMutex
+	db      *vulnDBFile
+	byName  map[string][]*VulnAdvisory
+	status  VulnDBStatus
+	refresh sync.Mutex
+}
+
+// NewVulnDB creates a VulnDB and loads the database applied by the last
+// run, so the first refresh re-scans only for what changed since.
+func NewVulnDB(config VulnDBConfig, sbom *SBOMService, logger *zap.Logger) *VulnDB {
+	v := &VulnDB{
+		config: config,
+		sbom:   sbom,
+		logger: logger,
+		proxy:  http.ProxyFromEnvironment,
+		status: VulnDBStatus{Source: config.Source},
+	}
+	if config.StoragePath != "" {
+		if data, err := os.ReadFile(filepath.Join(config.StoragePath, vulnDBStateFile)); err == nil {
+			if db, err := parseVulnDB(data); err == nil {
+				v.apply(db)
+			} else if logger != nil {
+				logger.Warn("无法读取已保存的漏洞库", zap.Error(err))
+			}
+		}
+	}
+	sbom.SetVulnDB(v)
+	return v
+}
+
+// SetOutboundProxy sets the proxy selection used to download the database.
+func (v *VulnDB) SetOutboundProxy(proxy func(*http.Request) (*url.URL, error)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.proxy = proxy
+}
+
+// SetNotifier sets the function told about images that were clean and
+// became vulnerable.
+func (v *VulnDB) SetNotifier(fn func(level, title, message string)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.notify = fn
+}
+
+// Status returns the loaded database and the last re-scan.
+func (v *VulnDB) Status() VulnDBStatus {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	status := v.status
+	if v.db != nil {
+		status.Version = v.db.Version
+		status.Advisories = len(v.db.Advisories)
+	}
+	return status
+}
+
+// RefreshVulnDB downloads the database and re-scans the affected images
+// when its version changed.
+func (v *VulnDB) RefreshVulnDB(ctx context.Context) error {
+	_, err := v.Refresh(ctx)
+	return err
+}
+
+// Refresh downloads the database; when its version changed, the images
+// containing packages of added, changed or withdrawn advisories are
+// re-scanned. Only one refresh may run at a time.
+func (v *VulnDB) Refresh(ctx context.Context) (*RescanResult, error) {
+	if !v.refresh.TryLock() {
+		return nil, ErrVulnDBRefreshing
+	}
+	defer v.refresh.Unlock()
+
+	data, err := v.fetch(ctx)
+	var db *vulnDBFile
+	if err == nil {
+		db, err = parseVulnDB(data)
+	}
+	checkedAt := time.Now()
+	v.mu.Lock()
+	v.status.CheckedAt = &checkedAt
+	v.status.LastError = ""
+	if err != nil {
+		v.status.LastError = err.Error()
+	}
+	previous := v.db
+	v.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RescanResult{
+		Version:          db.Version,
+		AffectedPackages: []string{},
+		RescannedImages:  []string{},
+		NewlyVulnerable:  []string{},
+		StartedAt:        time.Now(),
+	}
+	if previous != nil {
+		result.PreviousVersion = previous.Version
+		if previous.Version == db.Version {
+			return result, nil
+		}
+	}
+	result.Updated = true
+
+	changed := changedAdvisories(previous, db)
+	result.ChangedAdvisories = len(changed)
+	affected := make(map[string]bool)
+	for _, a := range changed {
+		affected[strings.ToLower(a.Package)] = true
+	}
+	for name := range affected {
+		result.AffectedPackages = append(result.AffectedPackages, name)
+	}
+	sort.Strings(result.AffectedPackages)
+
+	v.apply(db)
+	for _, sbom := range v.sbom.index.imagesWith(affected) {
+		v.rescan(sbom, affected, changed, result)
+	}
+	result.Duration = time.Since(result.StartedAt)
+
+	// 重新扫描完成后才保存，中断时下次启动会再次应用同样的增量
+	if err := v.persist(data); err != nil && v.logger != nil {
+		v.logger.Warn("保存漏洞库失败", zap.Error(err))
+	}
+
+	v.mu.Lock()
+	v.status.UpdatedAt = &result.StartedAt
+	v.status.LastRescan = result
+	notify := v.notify
+	v.mu.Unlock()
+
+	if v.logger != nil {
+		v.logger.Info("漏洞库已更新，已重新扫描受影响的镜像",
+			zap.String("previous_version", result.PreviousVersion),
+			zap.String("version", result.Version),
+			zap.Int("changed_advisories", result.ChangedAdvisories),
+			zap.Int("images", len(result.RescannedImages)),
+			zap.Int("newly_vulnerable", len(result.NewlyVulnerable)),
+		)
+	}
+	if v.sbom.onEvent != nil {
+		v.sbom.onEvent("rescan_completed", map[string]interface{}{
+			"version":          result.Version,
+			"images":           result.RescannedImages,
+			"newly_vulnerable": result.NewlyVulnerable,
+			"new_findings":     result.NewFindings,
+		})
+	}
+	if notify != nil && len(result.NewlyVulnerable) > 0 {
+		message := fmt.Sprintf("漏洞库更新到 %s 后，%d 个原本没有漏洞的镜像发现了漏洞：", result.Version, len(result.NewlyVulnerable))
+		for i, image := range result.NewlyVulnerable {
+			if i == maxNotifiedImages {
+				message += fmt.Sprintf("\n… 另有 %d 个镜像", len(result.NewlyVulnerable)-i)
+				break
+			}
+			message += "\n" + image
+		}
+		notify("warning", "镜像发现新漏洞", message)
+	}
+	return result, nil
+}
+
+// Match returns the vulnerabilities of the database affecting the packages
+// of sbom.
+func (v *VulnDB) Match(sbom *SBOM) []Vulnerability {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	vulns := []Vulnerability{}
+	for i := range sbom.Packages {
+		vulns = append(vulns, v.matchPackage(&sbom.Packages[i])...)
+	}
+	return vulns
+}
+
+// matchPackage returns the vulnerabilities affecting a package; v.mu must
+// be held.
+func (v *VulnDB) matchPackage(pkg *SBOMPackage) []Vulnerability {
+	var vulns []Vulnerability
+	for _, a := range v.byName[strings.ToLower(pkg.Name)] {
+		if a.Type != "" && !strings.EqualFold(a.Type, pkg.Type) {
+			continue
+		}
+		if !a.constraint.matches(pkg.Version) {
+			continue
+		}
+		vulns = append(vulns, Vulnerability{
+			ID:          a.ID,
+			Package:     pkg.Name,
+			Version:     pkg.Version,
+			Severity:    strings.ToUpper(a.Severity),
+			Title:       a.Title,
+			Description: a.Description,
+			FixedIn:     a.FixedIn,
+			References:  a.References,
+		})
+	}
+	return vulns
+}
+
+// rescan replaces the vulnerabilities an image has from the changed
+// advisories with those of the current database. Vulnerabilities of other
+// packages, or found by other scanners, are kept.
+func (v *VulnDB) rescan(sbom *SBOM, affected map[string]bool, changed []*VulnAdvisory, result *RescanResult) {
+	advisoryIDs := make(map[string]bool, len(changed))
+	for _, a := range changed {
+		advisoryIDs[a.ID+"|"+strings.ToLower(a.Package)] = true
+	}
+
+	var vulns []Vulnerability
+	for _, vuln := range sbom.Vulnerabilities {
+		if !advisoryIDs[vuln.ID+"|"+strings.ToLower(vuln.Package)] {
+			vulns = append(vulns, vuln)
+		}
+	}
+
+	v.mu.RLock()
+	for i := range sbom.Packages {
+		if affected[strings.ToLower(sbom.Packages[i].Name)] {
+			for _, vuln := range v.matchPackage(&sbom.Packages[i]) {
+				if advisoryIDs[vuln.ID+"|"+strings.ToLower(vuln.Package)] {
+					vulns = append(vulns, vuln)
+				}
+			}
+		}
+	}
+	version := v.db.Version
+	v.mu.RUnlock()
+
+	added := vulnerabilitiesNotIn(vulns, sbom.Vulnerabilities)
+	resolved := vulnerabilitiesNotIn(sbom.Vulnerabilities, vulns)
+	result.RescannedImages = append(result.RescannedImages, sbom.ImageRef)
+	result.NewFindings += len(added)
+	result.ResolvedFindings += len(resolved)
+	if len(sbom.Vulnerabilities) == 0 && len(vulns) > 0 {
+		result.NewlyVulnerable = append(result.NewlyVulnerable, sbom.ImageRef)
+	}
+
+	// 替换缓存中的 SBOM，不修改可能正在被读取的旧对象
+	updated := *sbom
+	updated.Vulnerabilities = vulns
+	updated.Metadata = make(map[string]string, len(sbom.Metadata)+1)
+	for k, val := range sbom.Metadata {
+		updated.Metadata[k] = val
+	}
+	updated.Metadata["vuln_db_version"] = version
+	updated.Metadata["scanned_at"] = time.Now().UTC().Format(time.RFC3339)
+	if !v.sbom.replace(sbom, &updated) {
+		return
+	}
+	if err := v.sbom.persistSBOM(&updated); err != nil && v.logger != nil {
+		v.logger.Warn("保存重新扫描结果失败", zap.String("image", sbom.ImageRef), zap.Error(err))
+	}
+}
+
+// apply makes db the current database.
+func (v *VulnDB) apply(db *vulnDBFile) {
+	byName := make(map[string][]*VulnAdvisory)
+	for _, a := range db.Advisories {
+		name := strings.ToLower(a.Package)
+		byName[name] = append(byName[name], a)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.db = db
+	v.byName = byName
+}
+
+// persist saves the applied database.
+func (v *VulnDB) persist(data []byte) error {
+	if v.config.StoragePath == "" {
+		return nil
+	}
+	path := filepath.Join(v.config.StoragePath, vulnDBStateFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fetch reads the database from a file or downloads it.
+func (v *VulnDB) fetch(ctx context.Context) ([]byte, error) {
+	source := v.config.Source
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	v.mu.RLock()
+	proxy := v.proxy
+	v.mu.RUnlock()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: vulnDBFetchTimeout}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download vulnerability database: unexpected status %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxVulnDBSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxVulnDBSize {
+		return nil, errors.New("vulnerability database too large")
+	}
+	return data, nil
+}
+
+// parseVulnDB parses a database. Without a version, the content hash is
+// its version.
+func parseVulnDB(data []byte) (*vulnDBFile, error) {
+	var db vulnDBFile
+	if err := json.Unmarshal(data, &db); err != nil {
+		return nil, fmt.Errorf("invalid vulnerability database: %w", err)
+	}
+	if db.Version == "" {
+		sum := sha256.Sum256(data)
+		db.Version = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	advisories := db.Advisories[:0]
+	for _, a := range db.Advisories {
+		if a == nil || a.ID == "" || a.Package == "" {
+			continue
+		}
+		constraint, err := parseVersionConstraint(a.Affected)
+		if err != nil {
+			return nil, fmt.Errorf("advisory %s: %w", a.ID, err)
+		}
+		a.constraint = constraint
+		advisories = append(advisories, a)
+	}
+	db.Advisories = advisories
+	return &db, nil
+}
+
+// changedAdvisories returns the advisories added to, changed in or
+// withdrawn from the database since previous.
+func changedAdvisories(previous, current *vulnDBFile) []*VulnAdvisory {
+	old := make(map[string]*VulnAdvisory)
+	if previous != nil {
+		for _, a := range previous.Advisories {
+			old[advisoryKey(a)] = a
+		}
+	}
+	var changed []*VulnAdvisory
+	for _, a := range current.Advisories {
+		key := advisoryKey(a)
+		if before, ok := old[key]; !ok || !sameAdvisory(before, a) {
+			changed = append(changed, a)
+		}
+		delete(old, key)
+	}
+	for _, a := range old {
+		changed = append(changed, a)
+	}
+	return changed
+}
+
+func advisoryKey(a *VulnAdvisory) string {
+	return a.ID + "|" + strings.ToLower(a.Package) + "|" + strings.ToLower(a.Type)
+}
+
+// sameAdvisory reports whether an advisory is unchanged in what it adds to
+// a scan result.
+func sameAdvisory(a, b *VulnAdvisory) bool {
+	return a.Affected == b.Affected &&
+		a.FixedIn == b.FixedIn &&
+		strings.EqualFold(a.Severity, b.Severity) &&
+		a.Title == b.Title &&
+		a.Description == b.Description &&
+		strings.Join(a.References, "\n") == strings.Join(b.References, "\n")
+}
@@ -2,13 +2,31 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"cyp-docker-registry/internal/dao"
+
 	"go.uber.org/zap"
 )
 
+const (
+	// maxJobLogLines limits the log lines kept per job.
+	maxJobLogLines = 1000
+	// jobLoadLimit limits the jobs restored from the database on startup.
+	jobLoadLimit = 1000
+)
+
 // WorkflowService provides workflow management services.
 type WorkflowService struct {
 	workflows sync.Map // map[string]*Workflow
@@ -16,6 +34,51 @@ type WorkflowService struct {
 	logger    *zap.Logger
 	isPaused  bool
 	mu        sync.RWMutex
+
+	jobMu     sync.Mutex // 保护作业状态与日志的并发读写
+	cancels   sync.Map   // map[string]context.CancelFunc
+	persist   bool
+	onJob     JobEventFunc
+	stopCh    chan struct{}
+	closeOnce sync.Once
+
+	signatureService *SignatureService
+	sbomService      *SBOMService
+	syncer           ImageSyncer
+	cleaner          ImageCleaner
+	notifier         NotifyFunc
+}
+
+// ImageSyncer pushes a local image to a remote registry.
+type ImageSyncer interface {
+	// SyncImageTo blocks until the sync finishes and returns the sync record ID.
+	SyncImageTo(ctx context.Context, image, tag, targetRegistry, targetImage, targetTag string) (string, error)
+}
+
+// ImageCleaner removes image tags according to a retention policy.
+type ImageCleaner interface {
+	CleanupImages(ctx context.Context, policy *RetentionPolicy) (*CleanupResult, error)
+}
+
+// NotifyFunc delivers a notification to users.
+type NotifyFunc func(level, title, message string)
+
+// JobEventFunc receives job status changes and live log lines.
+type JobEventFunc func(event string, data map[string]interface{})
+
+// RetentionPolicy describes which image tags to keep.
+type RetentionPolicy struct {
+	Repository string `json:"repository"` // 仓库名匹配模式，支持 * 通配符，空表示全部
+	KeepCount  int    `json:"keep_count"` // 每个仓库保留的最新标签数
+	KeepDays   int    `json:"keep_days"`  // 保留最近 N 天内推送的标签
+	DryRun     bool   `json:"dry_run"`
+}
+
+// CleanupResult represents the result of a retention cleanup.
+type CleanupResult struct {
+	Deleted []string `json:"deleted"`
+	Kept    int      `json:"kept"`
+	DryRun  bool     `json:"dry_run"`
 }
 
 // Workflow represents an automated workflow.
@@ -51,14 +114,16 @@ type WorkflowStep struct {
 
 // Job represents a running workflow job.
 type Job struct {
-	ID          string    `json:"id"`
-	WorkflowID  string    `json:"workflow_id"`
-	Status      string    `json:"status"` // pending, running, completed, failed, cancelled
-	StartedAt   time.Time `json:"started_at"`
-	CompletedAt time.Time `json:"completed_at,omitempty"`
-	Steps       []JobStep `json:"steps"`
-	Error       string    `json:"error,omitempty"`
-	Logs        []string  `json:"logs,omitempty"`
+	ID          string            `json:"id"`
+	WorkflowID  string            `json:"workflow_id"`
+	Trigger     string            `json:"trigger,omitempty"` // manual, schedule, event
+	Params      map[string]string `json:"params,omitempty"`
+	Status      string            `json:"status"` // pending, running, completed, failed, cancelled
+	StartedAt   time.Time         `json:"started_at"`
+	CompletedAt time.Time         `json:"completed_at,omitempty"`
+	Steps       []JobStep         `json:"steps"`
+	Error       string            `json:"error,omitempty"`
+	Logs        []string          `json:"logs,omitempty"`
 }
 
 // JobStep represents a step execution in a job.
@@ -71,6 +136,29 @@ type JobStep struct {
 	Error       string    `json:"error,omitempty"`
 }
 
+// Registry event types.
+const (
+	RegistryEventPush   = "push"
+	RegistryEventPull   = "pull"
+	RegistryEventDelete = "delete"
+)
+
+// RegistryEvent represents an image operation in the registry.
+type RegistryEvent struct {
+	Type       string    `json:"type"` // push, pull, delete
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	IPAddress  string    `json:"ip_address,omitempty"`
+	DurationMs int64     `json:"duration_ms,omitempty"` // 处理请求的耗时
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// RegistryEventFunc receives registry events.
+type RegistryEventFunc func(event *RegistryEvent)
+
 // CreateWorkflowRequest represents a request to create a workflow.
 type CreateWorkflowRequest struct {
 	Name        string          `json:"name" binding:"required"`
@@ -79,11 +167,51 @@ type CreateWorkflowRequest struct {
 	Steps       []WorkflowStep  `json:"steps" binding:"required"`
 }
 
-// NewWorkflowService creates a new WorkflowService instance.
+// NewWorkflowService creates a new WorkflowService instance. When the
+// database is initialized, workflows and recent jobs are restored from it.
 func NewWorkflowService(logger *zap.Logger) *WorkflowService {
-	return &WorkflowService{
+	s := &WorkflowService{
 		logger: logger,
+		stopCh: make(chan struct{}),
 	}
+
+	if dao.GetDB() != nil {
+		s.persist = true
+		s.loadFromDB()
+	}
+
+	return s
+}
+
+// SetSignatureService sets the service used by sign steps.
+func (s *WorkflowService) SetSignatureService(svc *SignatureService) {
+	s.signatureService = svc
+}
+
+// SetSBOMService sets the service used by scan steps.
+func (s *WorkflowService) SetSBOMService(svc *SBOMService) {
+	s.sbomService = svc
+}
+
+// SetImageSyncer sets the syncer used by sync steps.
+func (s *WorkflowService) SetImageSyncer(syncer ImageSyncer) {
+	s.syncer = syncer
+}
+
+// SetImageCleaner sets the cleaner used by cleanup steps.
+func (s *WorkflowService) SetImageCleaner(cleaner ImageCleaner) {
+	s.cleaner = cleaner
+}
+
+// SetNotifier sets the notification callback used by notify steps.
+func (s *WorkflowService) SetNotifier(fn NotifyFunc) {
+	s.notifier = fn
+}
+
+// SetJobEventHandler sets the callback that receives job status changes
+// ("job_status") and log lines ("job_log") as they happen.
+func (s *WorkflowService) SetJobEventHandler(fn JobEventFunc) {
+	s.onJob = fn
 }
 
 // CreateWorkflow creates a new workflow.
@@ -100,6 +228,7 @@ func (s *WorkflowService) CreateWorkflow(req *CreateWorkflowRequest) (*Workflow,
 	}
 
 	s.workflows.Store(workflow.ID, workflow)
+	s.saveWorkflow(workflow)
 
 	if s.logger != nil {
 		s.logger.Info("Workflow created",
@@ -147,13 +276,22 @@ func (s *WorkflowService) UpdateWorkflow(id string, req *CreateWorkflowRequest)
 	workflow.UpdatedAt = time.Now()
 
 	s.workflows.Store(id, workflow)
+	s.saveWorkflow(workflow)
 
 	return workflow, nil
 }
 
-// DeleteWorkflow deletes a workflow.
+// DeleteWorkflow deletes a workflow. Its jobs remain available as history.
 func (s *WorkflowService) DeleteWorkflow(id string) error {
-	s.workflows.Delete(id)
+	if _, ok := s.workflows.LoadAndDelete(id); !ok {
+		return errors.New("workflow not found")
+	}
+
+	if s.persist {
+		if err := dao.DeleteWorkflowRecord(id); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -167,6 +305,7 @@ func (s *WorkflowService) EnableWorkflow(id string) error {
 	w := workflow.(*Workflow)
 	w.Enabled = true
 	w.UpdatedAt = time.Now()
+	s.saveWorkflow(w)
 
 	return nil
 }
@@ -181,25 +320,97 @@ func (s *WorkflowService) DisableWorkflow(id string) error {
 	w := workflow.(*Workflow)
 	w.Enabled = false
 	w.UpdatedAt = time.Now()
+	s.saveWorkflow(w)
 
 	return nil
 }
 
 // TriggerWorkflow manually triggers a workflow.
 func (s *WorkflowService) TriggerWorkflow(id string) (*Job, error) {
-	s.mu.RLock()
-	if s.isPaused {
-		s.mu.RUnlock()
-		return nil, errors.New("workflow service is paused")
-	}
-	s.mu.RUnlock()
-
 	workflow, ok := s.workflows.Load(id)
 	if !ok {
 		return nil, errors.New("workflow not found")
 	}
 
-	w := workflow.(*Workflow)
+	return s.startJob(workflow.(*Workflow), "manual", nil)
+}
+
+// HandleEvent starts every enabled workflow whose event trigger matches
+// the registry event. It returns the jobs that were started.
+func (s *WorkflowService) HandleEvent(event *RegistryEvent) []*Job {
+	if event == nil || s.IsPaused() {
+		return nil
+	}
+
+	params := map[string]string{
+		"event":      event.Type,
+		"repository": event.Repository,
+		"tag":        event.Tag,
+		"image":      event.Repository + ":" + event.Tag,
+		"digest":     event.Digest,
+		"actor":      event.Actor,
+	}
+
+	var jobs []*Job
+	s.workflows.Range(func(_, value interface{}) bool {
+		w := value.(*Workflow)
+		if !w.Enabled || !w.Trigger.matchesEvent(event) {
+			return true
+		}
+
+		job, err := s.startJob(w, "event", params)
+		if err != nil {
+			if s.logger != nil {
+				s.logger.Warn("Failed to start event workflow",
+					zap.String("workflow", w.ID),
+					zap.String("event", event.Type),
+					zap.Error(err),
+				)
+			}
+			return true
+		}
+
+		if s.logger != nil {
+			s.logger.Info("Workflow triggered by event",
+				zap.String("workflow", w.ID),
+				zap.String("job", job.ID),
+				zap.String("event", event.Type),
+				zap.String("image", params["image"]),
+			)
+		}
+		jobs = append(jobs, job)
+		return true
+	})
+
+	return jobs
+}
+
+// matchesEvent reports whether the trigger fires for event. Filters
+// "repository" and "tag" accept glob patterns such as "prod/*" or "v*".
+func (t *WorkflowTrigger) matchesEvent(event *RegistryEvent) bool {
+	if t.Type != "event" || t.Event != event.Type {
+		return false
+	}
+
+	if pattern := t.Filter["repository"]; pattern != "" {
+		if ok, _ := path.Match(pattern, event.Repository); !ok {
+			return false
+		}
+	}
+	if pattern := t.Filter["tag"]; pattern != "" {
+		if ok, _ := path.Match(pattern, event.Tag); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// startJob creates a job for workflow and executes it asynchronously.
+func (s *WorkflowService) startJob(w *Workflow, trigger string, params map[string]string) (*Job, error) {
+	if s.IsPaused() {
+		return nil, errors.New("workflow service is paused")
+	}
 	if !w.Enabled {
 		return nil, errors.New("workflow is disabled")
 	}
@@ -207,7 +418,9 @@ func (s *WorkflowService) TriggerWorkflow(id string) (*Job, error) {
 	// Create job
 	job := &Job{
 		ID:         generateID(),
-		WorkflowID: id,
+		WorkflowID: w.ID,
+		Trigger:    trigger,
+		Params:     params,
 		Status:     "pending",
 		StartedAt:  time.Now(),
 		Steps:      make([]JobStep, len(w.Steps)),
@@ -220,12 +433,16 @@ func (s *WorkflowService) TriggerWorkflow(id string) (*Job, error) {
 		}
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancels.Store(job.ID, cancel)
 	s.jobs.Store(job.ID, job)
+	s.saveJob(job)
+	s.jobLog(job, "job created by %s trigger for workflow %q", trigger, w.Name)
 
 	// Execute job asynchronously
-	go s.executeJob(job, w)
+	go s.executeJob(ctx, job, w)
 
-	return job, nil
+	return s.snapshotJob(job), nil
 }
 
 // GetJob retrieves a job by ID.
@@ -234,25 +451,29 @@ func (s *WorkflowService) GetJob(id string) (*Job, error) {
 	if !ok {
 		return nil, errors.New("job not found")
 	}
-	return job.(*Job), nil
+	return s.snapshotJob(job.(*Job)), nil
 }
 
-// ListJobs lists all jobs.
+// ListJobs lists jobs, newest first.
 func (s *WorkflowService) ListJobs(workflowID string) ([]*Job, error) {
 	var jobs []*Job
 
 	s.jobs.Range(func(key, value interface{}) bool {
 		job := value.(*Job)
 		if workflowID == "" || job.WorkflowID == workflowID {
-			jobs = append(jobs, job)
+			jobs = append(jobs, s.snapshotJob(job))
 		}
 		return true
 	})
 
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].StartedAt.After(jobs[j].StartedAt)
+	})
+
 	return jobs, nil
 }
 
-// CancelJob cancels a running job.
+// CancelJob cancels a running job and aborts its current step.
 func (s *WorkflowService) CancelJob(id string) error {
 	job, ok := s.jobs.Load(id)
 	if !ok {
@@ -260,16 +481,108 @@ func (s *WorkflowService) CancelJob(id string) error {
 	}
 
 	j := job.(*Job)
+	s.jobMu.Lock()
 	if j.Status != "running" && j.Status != "pending" {
+		s.jobMu.Unlock()
 		return errors.New("job is not running")
 	}
-
 	j.Status = "cancelled"
+	j.Error = "cancelled by user"
 	j.CompletedAt = time.Now()
+	s.jobMu.Unlock()
 
+	if cancel, ok := s.cancels.LoadAndDelete(id); ok {
+		cancel.(context.CancelFunc)()
+	}
+
+	s.jobLog(j, "job cancelled")
+	s.finishJob(j)
 	return nil
 }
 
+// CleanupJobs removes finished jobs that started more than maxAge ago and
+// finished jobs beyond the newest keep jobs of each workflow. A zero maxAge
+// or keep disables that limit. It returns the number of removed jobs.
+func (s *WorkflowService) CleanupJobs(maxAge time.Duration, keep int) (int, error) {
+	var cutoff time.Time
+	if maxAge > 0 {
+		cutoff = time.Now().Add(-maxAge)
+	}
+
+	byWorkflow := make(map[string][]*Job)
+	s.jobs.Range(func(_, value interface{}) bool {
+		job := value.(*Job)
+		s.jobMu.Lock()
+		finished := job.Status != "running" && job.Status != "pending"
+		s.jobMu.Unlock()
+		if finished {
+			byWorkflow[job.WorkflowID] = append(byWorkflow[job.WorkflowID], job)
+		}
+		return true
+	})
+
+	removed := make(map[string]bool)
+	for _, jobs := range byWorkflow {
+		sort.Slice(jobs, func(i, j int) bool {
+			return jobs[i].StartedAt.After(jobs[j].StartedAt)
+		})
+		for i, job := range jobs {
+			if job.StartedAt.Before(cutoff) || (keep > 0 && i >= keep) {
+				s.jobs.Delete(job.ID)
+				removed[job.ID] = true
+			}
+		}
+	}
+
+	// 数据库中可能还有未加载到内存的历史作业
+	if s.persist {
+		ids, err := dao.DeleteWorkflowJobs(cutoff, keep)
+		if err != nil {
+			return len(removed), err
+		}
+		for _, id := range ids {
+			removed[id] = true
+		}
+	}
+
+	if len(removed) > 0 && s.logger != nil {
+		s.logger.Info("Workflow jobs cleaned up", zap.Int("removed", len(removed)))
+	}
+
+	return len(removed), nil
+}
+
+// StartJobCleanup runs CleanupJobs every interval until Stop is called.
+func (s *WorkflowService) StartJobCleanup(interval, maxAge time.Duration, keep int) {
+	if interval <= 0 {
+		interval = time.Hour
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := s.CleanupJobs(maxAge, keep); err != nil && s.logger != nil {
+				s.logger.Warn("Failed to clean up workflow jobs", zap.Error(err))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-s.stopCh:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops background job cleanup.
+func (s *WorkflowService) Stop() {
+	s.closeOnce.Do(func() {
+		close(s.stopCh)
+	})
+}
+
 // PauseAll pauses all workflows.
 func (s *WorkflowService) PauseAll() {
 	s.mu.Lock()
@@ -300,59 +613,162 @@ func (s *WorkflowService) IsPaused() bool {
 }
 
 // executeJob executes a workflow job.
-func (s *WorkflowService) executeJob(job *Job, workflow *Workflow) {
-	job.Status = "running"
+func (s *WorkflowService) executeJob(ctx context.Context, job *Job, workflow *Workflow) {
+	defer func() {
+		if cancel, ok := s.cancels.LoadAndDelete(job.ID); ok {
+			cancel.(context.CancelFunc)()
+		}
+	}()
+
+	if !s.setJobStatus(job, "running") {
+		return
+	}
+	s.saveJob(job)
+	s.emitJobEvent("job_status", job, nil)
 
 	for i, step := range workflow.Steps {
 		// Check if paused
-		s.mu.RLock()
-		if s.isPaused {
-			s.mu.RUnlock()
-			job.Status = "cancelled"
-			job.Error = "workflow service paused"
-			job.CompletedAt = time.Now()
+		if s.IsPaused() {
+			if s.setJobStatus(job, "cancelled") {
+				s.jobMu.Lock()
+				job.Error = "workflow service paused"
+				job.CompletedAt = time.Now()
+				s.jobMu.Unlock()
+				s.jobLog(job, "job cancelled: workflow service paused")
+				s.finishJob(job)
+			}
 			return
 		}
-		s.mu.RUnlock()
 
 		// Check if cancelled
-		if job.Status == "cancelled" {
+		if ctx.Err() != nil {
 			return
 		}
 
 		// Execute step
+		s.jobMu.Lock()
 		job.Steps[i].Status = "running"
 		job.Steps[i].StartedAt = time.Now()
+		s.jobMu.Unlock()
+		s.saveJob(job)
+		s.jobLog(job, "[%s] step started (action %s)", step.Name, step.Action)
 
-		err := s.executeStep(&step)
+		// 展开步骤参数中的 ${image}、${tag} 等作业变量
+		step.Parameters = expandStepParams(step.Parameters, job.Params)
 
-		job.Steps[i].CompletedAt = time.Now()
+		output, err := s.runStep(ctx, &step, func(format string, args ...interface{}) {
+			s.jobLog(job, "[%s] "+format, append([]interface{}{step.Name}, args...)...)
+		})
 
+		s.jobMu.Lock()
+		job.Steps[i].CompletedAt = time.Now()
+		job.Steps[i].Output = output
 		if err != nil {
 			job.Steps[i].Status = "failed"
 			job.Steps[i].Error = err.Error()
+		} else {
+			job.Steps[i].Status = "completed"
+		}
+		cancelled := job.Status == "cancelled"
+		s.jobMu.Unlock()
+
+		if output != "" {
+			s.jobLog(job, "[%s] %s", step.Name, output)
+		}
+
+		if cancelled {
+			s.saveJob(job)
+			return
+		}
+
+		if err != nil {
+			s.jobLog(job, "[%s] step failed: %v", step.Name, err)
 
 			if step.OnFailure != "continue" {
+				s.jobMu.Lock()
 				job.Status = "failed"
 				job.Error = err.Error()
 				job.CompletedAt = time.Now()
+				s.jobMu.Unlock()
+				s.finishWorkflowRun(workflow, job)
 				return
 			}
+			s.saveJob(job)
 		} else {
-			job.Steps[i].Status = "completed"
+			s.jobLog(job, "[%s] step completed", step.Name)
+			s.saveJob(job)
 		}
 	}
 
-	job.Status = "completed"
+	if !s.setJobStatus(job, "completed") {
+		return
+	}
+	s.jobMu.Lock()
 	job.CompletedAt = time.Now()
+	s.jobMu.Unlock()
+
+	s.finishWorkflowRun(workflow, job)
+}
+
+// finishWorkflowRun records the final job state and the workflow's last run.
+func (s *WorkflowService) finishWorkflowRun(workflow *Workflow, job *Job) {
+	s.jobLog(job, "job %s", job.Status)
+	s.finishJob(job)
 
-	// Update workflow last run
 	workflow.LastRunAt = time.Now()
 	workflow.LastStatus = job.Status
+	s.saveWorkflow(workflow)
+}
+
+// runStep executes a step, applying its timeout and retry policy.
+func (s *WorkflowService) runStep(ctx context.Context, step *WorkflowStep, logf func(string, ...interface{})) (string, error) {
+	timeout := 30 * time.Minute
+	if step.Timeout != "" {
+		if d, err := time.ParseDuration(step.Timeout); err == nil && d > 0 {
+			timeout = d
+		}
+	}
+
+	attempts := 1
+	if step.OnFailure == "retry" {
+		attempts = 3
+		if n, err := strconv.Atoi(step.Parameters["retries"]); err == nil && n > 0 {
+			attempts = n + 1
+		}
+	}
+
+	var output string
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		stepCtx, cancel := context.WithTimeout(ctx, timeout)
+		output, err = s.executeStep(stepCtx, step)
+		cancel()
+		if err == nil {
+			return output, nil
+		}
+
+		if s.logger != nil {
+			s.logger.Warn("Step failed",
+				zap.String("name", step.Name),
+				zap.Int("attempt", attempt),
+				zap.Error(err),
+			)
+		}
+		if attempt < attempts {
+			logf("attempt %d/%d failed: %v, retrying", attempt, attempts, err)
+			select {
+			case <-time.After(time.Duration(attempt) * 5 * time.Second):
+			case <-ctx.Done():
+				return output, ctx.Err()
+			}
+		}
+	}
+
+	return output, err
 }
 
 // executeStep executes a single workflow step.
-func (s *WorkflowService) executeStep(step *WorkflowStep) error {
+func (s *WorkflowService) executeStep(ctx context.Context, step *WorkflowStep) (string, error) {
 	if s.logger != nil {
 		s.logger.Info("Executing step",
 			zap.String("name", step.Name),
@@ -360,28 +776,360 @@ func (s *WorkflowService) executeStep(step *WorkflowStep) error {
 		)
 	}
 
-	// Simulate step execution
-	time.Sleep(100 * time.Millisecond)
+	type stepResult struct {
+		output string
+		err    error
+	}
+	done := make(chan stepResult, 1)
+
+	go func() {
+		var r stepResult
+		switch step.Action {
+		case "sign":
+			r.output, r.err = s.stepSign(step.Parameters)
+		case "scan":
+			r.output, r.err = s.stepScan(step.Parameters)
+		case "notify":
+			r.output, r.err = s.stepNotify(step.Parameters)
+		case "cleanup":
+			r.output, r.err = s.stepCleanup(ctx, step.Parameters)
+		case "sync":
+			r.output, r.err = s.stepSync(ctx, step.Parameters)
+		default:
+			r.err = errors.New("unknown action: " + step.Action)
+		}
+		done <- r
+	}()
+
+	select {
+	case r := <-done:
+		return r.output, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("step timed out: %w", ctx.Err())
+	}
+}
 
-	switch step.Action {
-	case "sign":
-		// Sign image
-		return nil
-	case "scan":
-		// Scan for vulnerabilities
-		return nil
-	case "notify":
-		// Send notification
-		return nil
-	case "cleanup":
-		// Cleanup old images
-		return nil
-	case "sync":
-		// Sync images
-		return nil
-	default:
-		return errors.New("unknown action: " + step.Action)
+// stepSign signs the image given by the "image" parameter.
+func (s *WorkflowService) stepSign(params map[string]string) (string, error) {
+	if s.signatureService == nil {
+		return "", errors.New("signature service not configured")
+	}
+	image := params["image"]
+	if image == "" {
+		return "", errors.New("sign step requires parameter: image")
+	}
+
+	keyID := params["key_id"]
+	if keyID == "" {
+		keyID = "default"
+	}
+
+	info, err := s.signatureService.SignImage(&SignRequest{ImageRef: image, KeyID: keyID}, 0, "workflow")
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("signed %s (digest %s, key %s)", info.ImageRef, info.Digest, info.KeyID), nil
+}
+
+// stepScan scans the image given by the "image" parameter. The step fails
+// when vulnerabilities at or above "fail_on" severity are found.
+func (s *WorkflowService) stepScan(params map[string]string) (string, error) {
+	if s.sbomService == nil {
+		return "", errors.New("SBOM service not configured")
+	}
+	image := params["image"]
+	if image == "" {
+		return "", errors.New("scan step requires parameter: image")
+	}
+
+	if params["generate_sbom"] == "true" {
+		if _, err := s.sbomService.GenerateSBOM(&GenerateSBOMRequest{ImageRef: image}); err != nil {
+			return "", fmt.Errorf("生成 SBOM 失败: %w", err)
+		}
+	}
+
+	result, err := s.sbomService.ScanVulnerabilities(&ScanVulnRequest{ImageRef: image})
+	if err != nil {
+		return "", err
+	}
+
+	sum := result.Summary
+	output := fmt.Sprintf("scanned %s: critical=%d high=%d medium=%d low=%d",
+		image, sum.Critical, sum.High, sum.Medium, sum.Low)
+
+	if blocking := blockingVulnerabilities(sum, params["fail_on"]); blocking > 0 {
+		return output, fmt.Errorf("found %d vulnerabilities at or above %s severity", blocking, params["fail_on"])
+	}
+
+	return output, nil
+}
+
+// stepNotify sends a notification.
+func (s *WorkflowService) stepNotify(params map[string]string) (string, error) {
+	if s.notifier == nil {
+		return "", errors.New("notifier not configured")
+	}
+
+	level := params["level"]
+	if level == "" {
+		level = "info"
+	}
+	title := params["title"]
+	if title == "" {
+		title = "Workflow"
+	}
+	message := params["message"]
+	if message == "" {
+		return "", errors.New("notify step requires parameter: message")
+	}
+
+	s.notifier(level, title, message)
+	return "notification sent: " + title, nil
+}
+
+// stepCleanup removes old image tags according to the step parameters.
+func (s *WorkflowService) stepCleanup(ctx context.Context, params map[string]string) (string, error) {
+	if s.cleaner == nil {
+		return "", errors.New("image cleaner not configured")
+	}
+
+	policy := &RetentionPolicy{
+		Repository: params["repository"],
+		DryRun:     params["dry_run"] == "true",
+	}
+	policy.KeepCount, _ = strconv.Atoi(params["keep_count"])
+	policy.KeepDays, _ = strconv.Atoi(params["keep_days"])
+	if policy.KeepCount <= 0 && policy.KeepDays <= 0 {
+		return "", errors.New("cleanup step requires keep_count or keep_days")
+	}
+
+	result, err := s.cleaner.CleanupImages(ctx, policy)
+	if err != nil {
+		return "", err
+	}
+
+	output := fmt.Sprintf("deleted %d tags, kept %d", len(result.Deleted), result.Kept)
+	if result.DryRun {
+		output = "[dry-run] " + output
+	}
+	if len(result.Deleted) > 0 {
+		output += ": " + strings.Join(result.Deleted, ", ")
+	}
+	return output, nil
+}
+
+// stepSync pushes an image to a remote registry.
+func (s *WorkflowService) stepSync(ctx context.Context, params map[string]string) (string, error) {
+	if s.syncer == nil {
+		return "", errors.New("sync service not configured")
+	}
+
+	image := params["image"]
+	target := params["target_registry"]
+	if image == "" || target == "" {
+		return "", errors.New("sync step requires parameters: image, target_registry")
+	}
+
+	name, tag := splitImageRef(image)
+	id, err := s.syncer.SyncImageTo(ctx, name, tag, target, params["target_image"], params["target_tag"])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("synced %s to %s (sync id %s)", image, target, id), nil
+}
+
+// setJobStatus moves a job to status unless it has already been cancelled.
+func (s *WorkflowService) setJobStatus(job *Job, status string) bool {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+
+	if job.Status == "cancelled" {
+		return false
+	}
+	job.Status = status
+	return true
+}
+
+// finishJob persists a job that reached a final state and announces it.
+func (s *WorkflowService) finishJob(job *Job) {
+	s.saveJob(job)
+	s.emitJobEvent("job_status", job, nil)
+}
+
+// jobLog appends a timestamped line to the job log and streams it.
+func (s *WorkflowService) jobLog(job *Job, format string, args ...interface{}) {
+	line := time.Now().Format(time.RFC3339) + " " + fmt.Sprintf(format, args...)
+
+	s.jobMu.Lock()
+	job.Logs = append(job.Logs, line)
+	if len(job.Logs) > maxJobLogLines {
+		job.Logs = job.Logs[len(job.Logs)-maxJobLogLines:]
+	}
+	s.jobMu.Unlock()
+
+	s.emitJobEvent("job_log", job, map[string]interface{}{"line": line})
+}
+
+// emitJobEvent sends a job event to the registered handler.
+func (s *WorkflowService) emitJobEvent(event string, job *Job, extra map[string]interface{}) {
+	if s.onJob == nil {
+		return
+	}
+
+	s.jobMu.Lock()
+	data := map[string]interface{}{
+		"job_id":      job.ID,
+		"workflow_id": job.WorkflowID,
+		"status":      job.Status,
+	}
+	s.jobMu.Unlock()
+	for k, v := range extra {
+		data[k] = v
+	}
+
+	s.onJob(event, data)
+}
+
+// snapshotJob returns a copy of job that is safe to read while it runs.
+func (s *WorkflowService) snapshotJob(job *Job) *Job {
+	s.jobMu.Lock()
+	defer s.jobMu.Unlock()
+
+	cp := *job
+	cp.Steps = append([]JobStep(nil), job.Steps...)
+	cp.Logs = append([]string(nil), job.Logs...)
+	return &cp
+}
+
+// saveWorkflow persists a workflow when the database is available.
+func (s *WorkflowService) saveWorkflow(w *Workflow) {
+	if !s.persist {
+		return
+	}
+
+	data, err := json.Marshal(w)
+	if err == nil {
+		err = dao.SaveWorkflow(&dao.WorkflowRecord{
+			ID:        w.ID,
+			Name:      w.Name,
+			Enabled:   w.Enabled,
+			Data:      string(data),
+			CreatedAt: w.CreatedAt,
+			UpdatedAt: w.UpdatedAt,
+		})
+	}
+	if err != nil && s.logger != nil {
+		s.logger.Warn("Failed to save workflow", zap.String("id", w.ID), zap.Error(err))
+	}
+}
+
+// saveJob persists a job when the database is available.
+func (s *WorkflowService) saveJob(job *Job) {
+	if !s.persist {
+		return
+	}
+
+	s.jobMu.Lock()
+	data, err := json.Marshal(job)
+	record := &dao.WorkflowJobRecord{
+		ID:          job.ID,
+		WorkflowID:  job.WorkflowID,
+		Status:      job.Status,
+		Data:        string(data),
+		StartedAt:   job.StartedAt,
+		CompletedAt: sql.NullTime{Time: job.CompletedAt, Valid: !job.CompletedAt.IsZero()},
+	}
+	s.jobMu.Unlock()
+
+	if err == nil {
+		err = dao.SaveWorkflowJob(record)
+	}
+	if err != nil && s.logger != nil {
+		s.logger.Warn("Failed to save workflow job", zap.String("id", job.ID), zap.Error(err))
+	}
+}
+
+// loadFromDB restores workflows and recent jobs. Jobs that were still
+// running when the service stopped are marked as failed.
+func (s *WorkflowService) loadFromDB() {
+	workflows, err := dao.ListWorkflowRecords()
+	if err != nil {
+		if s.logger != nil {
+			s.logger.Warn("Failed to load workflows", zap.Error(err))
+		}
+		return
+	}
+	for _, record := range workflows {
+		var w Workflow
+		if err := json.Unmarshal([]byte(record.Data), &w); err != nil {
+			continue
+		}
+		s.workflows.Store(w.ID, &w)
+	}
+
+	jobs, err := dao.ListWorkflowJobRecords(jobLoadLimit)
+	if err != nil {
+		if s.logger != nil {
+			s.logger.Warn("Failed to load workflow jobs", zap.Error(err))
+		}
+		return
+	}
+	for _, record := range jobs {
+		var job Job
+		if err := json.Unmarshal([]byte(record.Data), &job); err != nil {
+			continue
+		}
+		s.jobs.Store(job.ID, &job)
+
+		if job.Status == "running" || job.Status == "pending" {
+			job.Status = "failed"
+			job.Error = "interrupted by restart"
+			job.CompletedAt = time.Now()
+			for i := range job.Steps {
+				if job.Steps[i].Status == "running" || job.Steps[i].Status == "pending" {
+					job.Steps[i].Status = "cancelled"
+				}
+			}
+			s.jobLog(&job, "job interrupted by service restart")
+			s.saveJob(&job)
+		}
+	}
+
+	if s.logger != nil {
+		s.logger.Info("Workflows restored",
+			zap.Int("workflows", len(workflows)),
+			zap.Int("jobs", len(jobs)),
+		)
+	}
+}
+
+// expandStepParams substitutes ${name} references in step parameters with
+// job parameters. Job parameters are also used as defaults for missing
+// "image" parameters so event workflows need not repeat them.
+func expandStepParams(stepParams, jobParams map[string]string) map[string]string {
+	if len(jobParams) == 0 {
+		return stepParams
+	}
+
+	expanded := make(map[string]string, len(stepParams)+1)
+	for k, v := range stepParams {
+		expanded[k] = os.Expand(v, func(name string) string {
+			return jobParams[name]
+		})
+	}
+	if expanded["image"] == "" && jobParams["image"] != "" {
+		expanded["image"] = jobParams["image"]
+	}
+	return expanded
+}
+
+// splitImageRef splits "name:tag" into name and tag, defaulting tag to latest.
+func splitImageRef(ref string) (string, string) {
+	// 标签分隔符必须位于最后一个 "/" 之后，避免误判带端口的仓库地址
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
 	}
+	return ref, "latest"
 }
 
 // generateID generates a unique ID.
@@ -0,0 +1,259 @@
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// BundlePublicKey is a minisign public key embedded at build time with
+// -ldflags "-X cyp-docker-registry/internal/updater.BundlePublicKey=<key>".
+// Offline update bundles signed with it, or with update.public_key, are
+// accepted.
+var BundlePublicKey = ""
+
+// Offline update bundles hold the release files for hosts without access
+// to GitHub: the binaries of one or more platforms, a VERSION file, a
+// checksums file listing both, and a signature of the checksums file. The
+// tar may be gzip-compressed.
+const (
+	bundleVersionFile = "VERSION"
+	maxBundleSize     = 1 << 30
+	bundleDir         = "bundle"
+)
+
+var (
+	embeddedOnce     sync.Once
+	embeddedVerifier *ReleaseVerifier
+)
+
+// bundleVerifiers returns the keys bundles are verified against.
+func (u *UpdaterService) bundleVerifiers() []*ReleaseVerifier {
+	embeddedOnce.Do(func() {
+		if BundlePublicKey != "" {
+			embeddedVerifier, _ = NewReleaseVerifier(BundlePublicKey)
+		}
+	})
+
+	var verifiers []*ReleaseVerifier
+	if u.verifier != nil {
+		verifiers = append(verifiers, u.verifier)
+	}
+	if embeddedVerifier != nil {
+		verifiers = append(verifiers, embeddedVerifier)
+	}
+	return verifiers
+}
+
+// ImportBundle verifies an offline update bundle and stages its binary for
+// the current platform, to be applied by ApplyUpdate like a download.
+// Bundles always need a valid signature, whatever require_signature says.
+func (u *UpdaterService) ImportBundle(r io.Reader) (string, error) {
+	if u.isDocker {
+		return "", fmt.Errorf("Docker 容器请导入新的镜像")
+	}
+
+	u.mu.Lock()
+	u.status.State = "importing"
+	u.status.Progress = 0
+	u.status.Message = "正在导入离线更新包..."
+	u.status.Error = ""
+	u.downloaded = nil
+	u.mu.Unlock()
+
+	defer func() {
+		u.mu.Lock()
+		if u.status.State == "importing" {
+			u.status.State = "idle"
+		}
+		u.mu.Unlock()
+	}()
+
+	// Start from an empty download directory, like a download
+	os.RemoveAll(u.downloadPath)
+	dir := filepath.Join(u.downloadPath, bundleDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		u.setError("创建下载目录失败: " + err.Error())
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := extractBundle(io.LimitReader(r, maxBundleSize), dir)
+	if err != nil {
+		u.setError(err.Error())
+		return "", err
+	}
+
+	u.mu.Lock()
+	u.status.Message = "正在校验离线更新包..."
+	u.mu.Unlock()
+
+	ver, binary, sum, err := u.verifyBundle(dir, files)
+	if err != nil {
+		u.setError(err.Error())
+		return "", err
+	}
+
+	destPath := filepath.Join(u.downloadPath, binary)
+	if err := os.Rename(filepath.Join(dir, binary), destPath); err != nil {
+		u.setError("保存更新文件失败: " + err.Error())
+		return "", err
+	}
+
+	u.mu.Lock()
+	u.downloaded = &downloadedUpdate{path: destPath, sha256: sum, version: ver}
+	u.status.Progress = 100
+	u.status.Message = fmt.Sprintf("离线更新包版本 %s 导入完成，校验通过", ver)
+	u.mu.Unlock()
+
+	return ver, nil
+}
+
+// extractBundle extracts the regular files at the root of the bundle into
+// dir and returns their names.
+func extractBundle(r io.Reader, dir string) ([]string, error) {
+	buffered := bufio.NewReader(r)
+	var src io.Reader = buffered
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("%w: 无效的离线更新包: %v", ErrVerification, err)
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	var files []string
+	tr := tar.NewReader(src)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: 无效的离线更新包: %v", ErrVerification, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		// Bundles are flat; a leading directory is ignored
+		name := path.Base(path.Clean("/" + header.Name))
+		if name == "/" || name == "." || strings.HasPrefix(name, ".") {
+			continue
+		}
+		file, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(file, tr)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("解压 %s 失败: %w", name, err)
+		}
+		files = append(files, name)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%w: 离线更新包为空", ErrVerification)
+	}
+	return files, nil
+}
+
+// verifyBundle checks the signature of the checksums file of an extracted
+// bundle, then the VERSION file and the binary of the current platform
+// against it. It returns the version, the binary name and its SHA-256.
+func (u *UpdaterService) verifyBundle(dir string, files []string) (string, string, string, error) {
+	verifiers := u.bundleVerifiers()
+	if len(verifiers) == 0 {
+		return "", "", "", fmt.Errorf("%w: 未配置有效的更新签名公钥 (update.public_key)", ErrVerification)
+	}
+
+	assets := make([]GitHubAsset, 0, len(files))
+	for _, name := range files {
+		assets = append(assets, GitHubAsset{Name: name, BrowserDownloadURL: name})
+	}
+	found := u.findAssets(assets)
+	if found.binary == "" {
+		return "", "", "", fmt.Errorf("%w: 离线更新包中没有 %s-%s 平台的程序", ErrVerification, runtime.GOOS, runtime.GOARCH)
+	}
+	if found.checksums == "" {
+		return "", "", "", fmt.Errorf("%w: 离线更新包中没有校验和文件", ErrVerification)
+	}
+	checksums, err := os.ReadFile(filepath.Join(dir, found.checksums))
+	if err != nil {
+		return "", "", "", err
+	}
+
+	// The signature must cover the checksums file, so it also vouches
+	// for the VERSION file
+	signed := false
+	var lastErr error
+	for _, verifier := range verifiers {
+		signature, err := os.ReadFile(filepath.Join(dir, found.checksums+verifier.signatureSuffix()))
+		if err != nil {
+			continue
+		}
+		if lastErr = verifier.Verify(bytes.NewReader(checksums), signature); lastErr == nil {
+			signed = true
+			break
+		}
+	}
+	if !signed {
+		if lastErr != nil {
+			return "", "", "", fmt.Errorf("%w: 签名验证失败: %v", ErrVerification, lastErr)
+		}
+		return "", "", "", fmt.Errorf("%w: 离线更新包中没有 %s 的签名", ErrVerification, found.checksums)
+	}
+
+	if err := checkBundleFile(dir, bundleVersionFile, checksums); err != nil {
+		return "", "", "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, bundleVersionFile))
+	if err != nil {
+		return "", "", "", err
+	}
+	ver := strings.TrimPrefix(strings.TrimSpace(string(data)), "v")
+	if ver == "" {
+		return "", "", "", fmt.Errorf("%w: 离线更新包的 VERSION 为空", ErrVerification)
+	}
+
+	if err := checkBundleFile(dir, found.binary, checksums); err != nil {
+		return "", "", "", err
+	}
+	sum, err := fileSHA256(filepath.Join(dir, found.binary))
+	if err != nil {
+		return "", "", "", err
+	}
+	return ver, found.binary, sum, nil
+}
+
+// checkBundleFile checks a file of the bundle against the checksums file.
+func checkBundleFile(dir, name string, checksums []byte) error {
+	want, ok := parseChecksums(checksums, name)
+	if !ok {
+		return fmt.Errorf("%w: 校验和文件中没有 %s", ErrVerification, name)
+	}
+	sum, err := fileSHA256(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: 离线更新包中没有 %s", ErrVerification, name)
+	}
+	if err != nil {
+		return err
+	}
+	if want != sum {
+		return fmt.Errorf("%w: %s 的 SHA-256 不匹配，期望 %s，实际 %s", ErrVerification, name, want, sum)
+	}
+	return nil
+}
@@ -2,12 +2,15 @@
 package updater
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"cyp-docker-registry/internal/version"
-	"encoding/json"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -20,20 +23,24 @@ import (
 
 // VersionInfo represents version and update information.
 type VersionInfo struct {
-	Current     string    `json:"current"`
-	Latest      string    `json:"latest"`
-	HasUpdate   bool      `json:"has_update"`
-	ReleaseAt   time.Time `json:"release_at"`
-	Changelog   string    `json:"changelog"`
-	DownloadURL string    `json:"download_url,omitempty"`
-	DockerImage string    `json:"docker_image,omitempty"`
-	IsDocker    bool      `json:"is_docker"`
-	AutoUpdate  bool      `json:"auto_update_enabled"`
+	Current      string    `json:"current"`
+	Latest       string    `json:"latest"`
+	Channel      string    `json:"channel"`
+	HasUpdate    bool      `json:"has_update"`
+	ReleaseAt    time.Time `json:"release_at"`
+	Changelog    string    `json:"changelog"`
+	DownloadURL  string    `json:"download_url,omitempty"`
+	ChecksumURL  string    `json:"checksum_url,omitempty"`
+	PatchURL     string    `json:"patch_url,omitempty"` // 从当前版本的增量补丁
+	SignatureURL string    `json:"signature_url,omitempty"`
+	DockerImage  string    `json:"docker_image,omitempty"`
+	IsDocker     bool      `json:"is_docker"`
+	AutoUpdate   bool      `json:"auto_update_enabled"`
 }
 
 // UpdateStatus represents the current update status.
 type UpdateStatus struct {
-	State       string    `json:"state"` // idle, checking, downloading, applying, restarting, error
+	State       string    `json:"state"` // idle, checking, downloading, importing, applying, restarting, verifying, error
 	Progress    int       `json:"progress"`
 	Message     string    `json:"message"`
 	LastChecked time.Time `json:"last_checked"`
@@ -50,20 +57,45 @@ type UpdateConfig struct {
 	NotifyOnUpdate     bool          `json:"notify_on_update"`
 	DockerImage        string        `json:"docker_image"`
 	GitHubRepo         string        `json:"github_repo"`
+	Delta              bool          `json:"delta"`         // 优先下载 bsdiff 增量补丁
+	AutoRestart        bool          `json:"auto_restart"`  // 应用更新后自动重启
+	HealthWindow       time.Duration `json:"health_window"` // 重启后通过健康检查的期限，超时自动回滚
 }
 
 // GitHubRelease represents a GitHub release response.
 type GitHubRelease struct {
-	TagName     string    `json:"tag_name"`
-	Name        string    `json:"name"`
-	Body        string    `json:"body"`
-	Prerelease  bool      `json:"prerelease"`
-	PublishedAt time.Time `json:"published_at"`
-	Assets      []struct {
-		Name               string `json:"name"`
-		BrowserDownloadURL string `json:"browser_download_url"`
-		Size               int64  `json:"size"`
-	} `json:"assets"`
+	TagName     string        `json:"tag_name"`
+	Name        string        `json:"name"`
+	Body        string        `json:"body"`
+	Prerelease  bool          `json:"prerelease"`
+	Draft       bool          `json:"draft"`
+	PublishedAt time.Time     `json:"published_at"`
+	Assets      []GitHubAsset `json:"assets"`
+}
+
+// GitHubAsset represents a file attached to a GitHub release.
+type GitHubAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Size               int64  `json:"size"`
+}
+
+// releaseAssets are the files of a release needed to update: the binary
+// of the current platform, the checksums file, the detached signature of
+// the binary or, failing that, of the checksums file, and a patch from the
+// running version.
+type releaseAssets struct {
+	binary    string
+	checksums string
+	signature string
+	patch     string
+}
+
+// downloadedUpdate is an update downloaded and verified, ready to apply.
+type downloadedUpdate struct {
+	path    string
+	sha256  string
+	version string
 }
 
 // UpdaterService provides update checking and management functionality.
@@ -74,8 +106,21 @@ type UpdaterService struct {
 	status       UpdateStatus
 	lastVersion  *VersionInfo
 	httpClient   *http.Client
+	apiBase      string
 	stopChan     chan struct{}
 	isDocker     bool
+	isLeader     func() bool
+
+	verifier         *ReleaseVerifier
+	requireSignature bool
+	downloaded       *downloadedUpdate
+
+	restartChan chan struct{}
+	healthCheck func(ctx context.Context) error
+	trial       *UpdateTrial
+
+	proxyMu       sync.RWMutex
+	outboundProxy func(*http.Request) (*url.URL, error)
 }
 
 // DefaultConfig returns the default update configuration.
@@ -89,6 +134,9 @@ func DefaultConfig() UpdateConfig {
 		NotifyOnUpdate:     true,
 		DockerImage:        "cyp/docker-registry",
 		GitHubRepo:         "CYP/cyp-docker-registry",
+		Delta:              true,
+		AutoRestart:        true,
+		HealthWindow:       2 * time.Minute,
 	}
 }
 
@@ -104,16 +152,42 @@ func NewUpdaterService(config UpdateConfig, downloadPath string) *UpdaterService
 		status: UpdateStatus{
 			State: "idle",
 		},
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-		stopChan: make(chan struct{}),
-		isDocker: isRunningInDocker(),
+		apiBase:       "https://api.github.com",
+		stopChan:      make(chan struct{}),
+		restartChan:   make(chan struct{}, 1),
+		isDocker:      isRunningInDocker(),
+		outboundProxy: http.ProxyFromEnvironment,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = u.proxy
+	u.httpClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: transport,
 	}
 
 	return u
 }
 
+// SetOutboundProxy sets the HTTP proxy used to check and download updates;
+// nil connects directly.
+func (u *UpdaterService) SetOutboundProxy(proxy func(*http.Request) (*url.URL, error)) {
+	u.proxyMu.Lock()
+	u.outboundProxy = proxy
+	u.proxyMu.Unlock()
+	u.httpClient.CloseIdleConnections()
+}
+
+// proxy selects the proxy of a request with the current settings.
+func (u *UpdaterService) proxy(req *http.Request) (*url.URL, error) {
+	u.proxyMu.RLock()
+	proxy := u.outboundProxy
+	u.proxyMu.RUnlock()
+	if proxy == nil {
+		return nil, nil
+	}
+	return proxy(req)
+}
+
 // isRunningInDocker checks if the application is running inside a Docker container.
 func isRunningInDocker() bool {
 	// Check for .dockerenv file
@@ -131,8 +205,27 @@ func isRunningInDocker() bool {
 	return false
 }
 
-// Start starts the background update checker.
+// SetLeaderCheck makes the background checker run only while isLeader
+// returns true, so one instance of a cluster checks for updates. It must be
+// called before Start.
+func (u *UpdaterService) SetLeaderCheck(isLeader func() bool) {
+	u.isLeader = isLeader
+}
+
+// SetVerifier sets the key release signatures are checked with. With
+// requireSignature, updates without a valid signature are refused, also
+// when verifier is nil. It must be called before Start.
+func (u *UpdaterService) SetVerifier(verifier *ReleaseVerifier, requireSignature bool) {
+	u.verifier = verifier
+	u.requireSignature = requireSignature
+}
+
+// Start verifies a freshly applied update and starts the background update
+// checker.
 func (u *UpdaterService) Start() {
+	if !u.isDocker {
+		u.resumeTrial()
+	}
 	if !u.config.Enabled {
 		return
 	}
@@ -158,6 +251,9 @@ func (u *UpdaterService) backgroundChecker() {
 		case <-u.stopChan:
 			return
 		case <-ticker.C:
+			if u.isLeader != nil && !u.isLeader() {
+				continue
+			}
 			info, err := u.CheckUpdate()
 			if err == nil && info.HasUpdate && u.config.AutoUpdate {
 				// Auto update if enabled
@@ -184,28 +280,13 @@ func (u *UpdaterService) CheckUpdate() (*VersionInfo, error) {
 		u.mu.Unlock()
 	}()
 
-	currentVersion := version.GetVersion()
-
-	// Fetch latest release from GitHub
-	latestVersion, releaseAt, changelog, downloadURL, err := u.fetchLatestRelease()
+	// Fetch the newest release of the channel from GitHub
+	release, err := u.latestRelease()
 	if err != nil {
 		u.setError(err.Error())
 		return nil, err
 	}
-
-	hasUpdate := CompareVersions(latestVersion, currentVersion) > 0
-
-	info := &VersionInfo{
-		Current:     currentVersion,
-		Latest:      latestVersion,
-		HasUpdate:   hasUpdate,
-		ReleaseAt:   releaseAt,
-		Changelog:   changelog,
-		DownloadURL: downloadURL,
-		DockerImage: fmt.Sprintf("%s:v%s", u.config.DockerImage, latestVersion),
-		IsDocker:    u.isDocker,
-		AutoUpdate:  u.config.AutoUpdate,
-	}
+	info := u.versionInfo(release)
 
 	u.mu.Lock()
 	u.lastVersion = info
@@ -215,72 +296,84 @@ func (u *UpdaterService) CheckUpdate() (*VersionInfo, error) {
 	return info, nil
 }
 
-// fetchLatestRelease fetches the latest release information from GitHub.
-func (u *UpdaterService) fetchLatestRelease() (ver string, releaseAt time.Time, changelog, downloadURL string, err error) {
-	if u.config.GitHubRepo == "" {
-		return "", time.Time{}, "", "", fmt.Errorf("GitHub 仓库未配置")
-	}
-
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", u.config.GitHubRepo)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", time.Time{}, "", "", err
+// versionInfo describes updating to a release.
+func (u *UpdaterService) versionInfo(release *GitHubRelease) *VersionInfo {
+	currentVersion := version.GetVersion()
+	latestVersion := strings.TrimPrefix(release.TagName, "v")
+	assets := u.findAssets(release.Assets)
+
+	return &VersionInfo{
+		Current:      currentVersion,
+		Latest:       latestVersion,
+		Channel:      releaseChannel(release),
+		HasUpdate:    CompareVersions(latestVersion, currentVersion) > 0,
+		ReleaseAt:    release.PublishedAt,
+		Changelog:    release.Body,
+		DownloadURL:  assets.binary,
+		ChecksumURL:  assets.checksums,
+		SignatureURL: assets.signature,
+		PatchURL:     assets.patch,
+		DockerImage:  fmt.Sprintf("%s:v%s", u.config.DockerImage, latestVersion),
+		IsDocker:     u.isDocker,
+		AutoUpdate:   u.config.AutoUpdate,
 	}
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "CYP-Docker-Registry-Updater")
+}
 
-	resp, err := u.httpClient.Do(req)
-	if err != nil {
-		return "", time.Time{}, "", "", fmt.Errorf("无法连接 GitHub: %w", err)
-	}
-	defer resp.Body.Close()
+// metadataSuffixes are the suffixes of release files describing another
+// file, never the binary itself.
+var metadataSuffixes = []string{".sha256", ".sha256sum", ".sig", ".minisig", ".pem", ".asc", ".txt", ".sbom", ".json", ".bsdiff"}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return "", time.Time{}, "", "", fmt.Errorf("未找到发布版本")
+// findAssets finds the binary of the current platform, the checksums file
+// and the signature matching the configured key.
+func (u *UpdaterService) findAssets(assets []GitHubAsset) releaseAssets {
+	platform := fmt.Sprintf("%s-%s", runtime.GOOS, runtime.GOARCH)
+	byName := make(map[string]string, len(assets))
+	for _, asset := range assets {
+		byName[strings.ToLower(asset.Name)] = asset.BrowserDownloadURL
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", time.Time{}, "", "", fmt.Errorf("GitHub API 返回错误: %d", resp.StatusCode)
+	var found releaseAssets
+	var binaryName, checksumsName string
+	for _, asset := range assets {
+		name := strings.ToLower(asset.Name)
+		isMetadata := false
+		for _, suffix := range metadataSuffixes {
+			isMetadata = isMetadata || strings.HasSuffix(name, suffix)
+		}
+		if !isMetadata && found.binary == "" && strings.Contains(name, platform) {
+			found.binary, binaryName = asset.BrowserDownloadURL, name
+		}
 	}
-
-	var release GitHubRelease
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return "", time.Time{}, "", "", fmt.Errorf("解析发布信息失败: %w", err)
+	for _, asset := range assets {
+		name := strings.ToLower(asset.Name)
+		if name == binaryName+".sha256" || name == "sha256sums" || name == "sha256sums.txt" ||
+			strings.HasSuffix(name, "checksums.txt") {
+			found.checksums, checksumsName = asset.BrowserDownloadURL, name
+			if name == binaryName+".sha256" {
+				break
+			}
+		}
 	}
 
-	// Skip pre-release if channel is stable
-	if u.config.UpdateChannel == "stable" && release.Prerelease {
-		return "", time.Time{}, "", "", fmt.Errorf("最新版本为预发布版本")
+	if url, ok := byName[patchName(binaryName, version.GetVersion())]; ok && binaryName != "" {
+		found.patch = url
 	}
 
-	// Remove 'v' prefix if present
-	ver = strings.TrimPrefix(release.TagName, "v")
-
-	// Find download URL for current platform
-	downloadURL = u.findAssetURL(release.Assets)
-
-	return ver, release.PublishedAt, release.Body, downloadURL, nil
-}
-
-// findAssetURL finds the appropriate download URL for the current platform.
-func (u *UpdaterService) findAssetURL(assets []struct {
-	Name               string `json:"name"`
-	BrowserDownloadURL string `json:"browser_download_url"`
-	Size               int64  `json:"size"`
-}) string {
-	platform := fmt.Sprintf("%s-%s", runtime.GOOS, runtime.GOARCH)
-
-	for _, asset := range assets {
-		if strings.Contains(strings.ToLower(asset.Name), platform) {
-			return asset.BrowserDownloadURL
+	if u.verifier != nil && binaryName != "" {
+		suffix := u.verifier.signatureSuffix()
+		if url, ok := byName[binaryName+suffix]; ok {
+			found.signature = url
+		} else if url, ok := byName[checksumsName+suffix]; ok && checksumsName != "" {
+			found.signature = url
 		}
 	}
-
-	return ""
+	return found
 }
 
-// CompareVersions compares two semantic version strings.
+// CompareVersions compares two semantic version strings. A pre-release
+// is older than the release of the same version, and pre-releases compare
+// by their dot-separated identifiers: 1.2.0-beta.2 < 1.2.0-beta.10 <
+// 1.2.0-rc.1 < 1.2.0.
 func CompareVersions(v1, v2 string) int {
 	v1 = strings.TrimPrefix(v1, "v")
 	v2 = strings.TrimPrefix(v2, "v")
@@ -297,7 +390,7 @@ func CompareVersions(v1, v2 string) int {
 		}
 	}
 
-	return 0
+	return comparePrerelease(prerelease(v1), prerelease(v2))
 }
 
 func parseVersion(v string) [3]int {
@@ -312,6 +405,56 @@ func parseVersion(v string) [3]int {
 	return parts
 }
 
+// prerelease returns the pre-release part of a version, without build
+// metadata.
+func prerelease(v string) string {
+	v, _, _ = strings.Cut(v, "+")
+	_, pre, _ := strings.Cut(v, "-")
+	return pre
+}
+
+// comparePrerelease compares pre-release parts; "" is a release.
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	ids1, ids2 := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(ids1) && i < len(ids2); i++ {
+		n1, err1 := strconv.Atoi(ids1[i])
+		n2, err2 := strconv.Atoi(ids2[i])
+		switch {
+		case err1 == nil && err2 == nil:
+			if n1 != n2 {
+				if n1 > n2 {
+					return 1
+				}
+				return -1
+			}
+		case err1 == nil:
+			return -1 // numeric identifiers sort first
+		case err2 == nil:
+			return 1
+		default:
+			if c := strings.Compare(ids1[i], ids2[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	switch {
+	case len(ids1) > len(ids2):
+		return 1
+	case len(ids1) < len(ids2):
+		return -1
+	}
+	return 0
+}
+
 // performAutoUpdate performs automatic update.
 func (u *UpdaterService) performAutoUpdate(info *VersionInfo) {
 	if u.isDocker {
@@ -334,7 +477,10 @@ func (u *UpdaterService) performAutoUpdate(info *VersionInfo) {
 	}
 }
 
-// DownloadUpdate downloads the update package.
+// DownloadUpdate downloads and verifies the update package of
+// targetVersion, the last checked version when empty. When the release has
+// a patch from the running version it is downloaded instead of the whole
+// binary; if it cannot be applied the binary is downloaded.
 func (u *UpdaterService) DownloadUpdate(targetVersion string) error {
 	u.mu.Lock()
 	u.status.State = "downloading"
@@ -352,36 +498,155 @@ func (u *UpdaterService) DownloadUpdate(targetVersion string) error {
 	}()
 
 	// Get download URL
-	info := u.GetLastVersionInfo()
-	if info == nil || info.DownloadURL == "" {
-		err := fmt.Errorf("未找到下载链接，请先检查更新")
+	info, err := u.targetInfo(targetVersion)
+	if err != nil {
+		u.setError(err.Error())
+		return err
+	}
+	if info.DownloadURL == "" {
+		err := fmt.Errorf("版本 %s 没有 %s-%s 平台的程序", info.Latest, runtime.GOOS, runtime.GOARCH)
 		u.setError(err.Error())
 		return err
 	}
 
-	// Create download directory
+	// Start from an empty download directory, so only a verified file
+	// can be applied
+	u.mu.Lock()
+	u.downloaded = nil
+	u.mu.Unlock()
+	os.RemoveAll(u.downloadPath)
 	if err := os.MkdirAll(u.downloadPath, 0755); err != nil {
 		u.setError("创建下载目录失败: " + err.Error())
 		return err
 	}
 
-	// Download the file
-	resp, err := u.httpClient.Get(info.DownloadURL)
-	if err != nil {
-		u.setError("下载失败: " + err.Error())
+	// The file is renamed once verified
+	filename := filepath.Base(info.DownloadURL)
+	destPath := filepath.Join(u.downloadPath, filename)
+	partPath := destPath + ".part"
+	defer os.Remove(partPath)
+
+	var sum string
+	patched := false
+	if u.GetConfig().Delta && info.PatchURL != "" {
+		sum, err = u.downloadPatched(info.PatchURL, partPath)
+		if err == nil {
+			err = u.verifyDownload(info, filename, partPath, sum)
+		}
+		if patched = err == nil; !patched {
+			u.mu.Lock()
+			u.status.Progress = 0
+			u.status.Message = "增量更新失败，改为下载完整程序: " + err.Error()
+			u.mu.Unlock()
+		}
+	}
+	if !patched {
+		if sum, err = u.downloadFile(info.DownloadURL, partPath); err != nil {
+			u.setError("下载失败: " + err.Error())
+			return err
+		}
+
+		u.mu.Lock()
+		u.status.Message = "正在校验更新文件..."
+		u.mu.Unlock()
+
+		if err := u.verifyDownload(info, filename, partPath, sum); err != nil {
+			u.setError(err.Error())
+			return err
+		}
+	}
+	if err := os.Rename(partPath, destPath); err != nil {
+		u.setError("保存更新文件失败: " + err.Error())
 		return err
 	}
-	defer resp.Body.Close()
 
-	// Create destination file
-	filename := filepath.Base(info.DownloadURL)
-	destPath := filepath.Join(u.downloadPath, filename)
-	destFile, err := os.Create(destPath)
+	u.mu.Lock()
+	u.downloaded = &downloadedUpdate{path: destPath, sha256: sum, version: info.Latest}
+	u.status.Progress = 100
+	u.status.Message = fmt.Sprintf("版本 %s 下载完成，校验通过", info.Latest)
+	if patched {
+		u.status.Message = fmt.Sprintf("版本 %s 增量更新下载完成，校验通过", info.Latest)
+	}
+	u.mu.Unlock()
+
+	return nil
+}
+
+// targetInfo returns the update information of a version, the last
+// checked one when ver is empty.
+func (u *UpdaterService) targetInfo(ver string) (*VersionInfo, error) {
+	info := u.GetLastVersionInfo()
+	ver = strings.TrimPrefix(ver, "v")
+	if ver == "" || (info != nil && info.Latest == ver) {
+		if info == nil {
+			return nil, fmt.Errorf("未找到下载链接，请先检查更新")
+		}
+		return info, nil
+	}
+
+	release, err := u.fetchRelease(ver)
+	if err != nil {
+		return nil, err
+	}
+	return u.versionInfo(release), nil
+}
+
+// downloadFile downloads url to path and returns its SHA-256.
+func (u *UpdaterService) downloadFile(url, path string) (string, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	writer := newHashingWriter(f)
+	if err := u.fetch(url, writer); err != nil {
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	return writer.sum(), nil
+}
+
+// downloadPatched downloads a bsdiff patch, applies it to the running
+// executable and writes the result to path. It returns the SHA-256 of the
+// result.
+func (u *UpdaterService) downloadPatched(url, path string) (string, error) {
+	var patch bytes.Buffer
+	if err := u.fetch(url, &patch); err != nil {
+		return "", err
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	old, err := os.ReadFile(execPath)
+	if err != nil {
+		return "", err
+	}
+	data, err := bspatch(old, patch.Bytes())
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, data, 0755); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fetch downloads url into w, reporting the progress in the status.
+func (u *UpdaterService) fetch(url string, w io.Writer) error {
+	resp, err := u.httpClient.Get(url)
 	if err != nil {
-		u.setError("创建文件失败: " + err.Error())
 		return err
 	}
-	defer destFile.Close()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("服务器返回 %d", resp.StatusCode)
+	}
 
 	// Copy with progress
 	totalSize := resp.ContentLength
@@ -391,9 +656,8 @@ func (u *UpdaterService) DownloadUpdate(targetVersion string) error {
 	for {
 		n, err := resp.Body.Read(buf)
 		if n > 0 {
-			if _, writeErr := destFile.Write(buf[:n]); writeErr != nil {
-				u.setError("写入文件失败")
-				return writeErr
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return fmt.Errorf("写入文件失败: %w", writeErr)
 			}
 			downloaded += int64(n)
 
@@ -405,22 +669,80 @@ func (u *UpdaterService) DownloadUpdate(targetVersion string) error {
 			}
 		}
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			u.setError("下载中断: " + err.Error())
-			return err
+			return fmt.Errorf("下载中断: %w", err)
 		}
 	}
+}
 
-	u.mu.Lock()
-	u.status.Progress = 100
-	u.status.Message = "下载完成"
-	u.mu.Unlock()
+// verifyDownload checks the downloaded file at path against the checksums
+// file of the release, and the signature of the file or of the checksums
+// file. Updates without checksums are refused, and without a signature
+// when signatures are required.
+func (u *UpdaterService) verifyDownload(info *VersionInfo, filename, path, sum string) error {
+	if info.ChecksumURL == "" {
+		return fmt.Errorf("%w: 发布版本中没有校验和文件", ErrVerification)
+	}
+	checksums, err := u.fetchSmall(info.ChecksumURL)
+	if err != nil {
+		return fmt.Errorf("%w: 下载校验和文件失败: %v", ErrVerification, err)
+	}
+	want, ok := parseChecksums(checksums, filename)
+	if !ok {
+		return fmt.Errorf("%w: 校验和文件中没有 %s", ErrVerification, filename)
+	}
+	if want != sum {
+		return fmt.Errorf("%w: %s 的 SHA-256 不匹配，期望 %s，实际 %s", ErrVerification, filename, want, sum)
+	}
 
+	if u.verifier == nil {
+		if u.requireSignature {
+			return fmt.Errorf("%w: 未配置有效的更新签名公钥 (update.public_key)", ErrVerification)
+		}
+		return nil
+	}
+	if info.SignatureURL == "" {
+		if u.requireSignature {
+			return fmt.Errorf("%w: 发布版本中没有 %s 签名文件", ErrVerification, u.verifier.Kind())
+		}
+		return nil
+	}
+	signature, err := u.fetchSmall(info.SignatureURL)
+	if err != nil {
+		return fmt.Errorf("%w: 下载签名文件失败: %v", ErrVerification, err)
+	}
+
+	// The signature covers the binary itself or the checksums file
+	signed := strings.TrimSuffix(filepath.Base(info.SignatureURL), u.verifier.signatureSuffix())
+	if strings.EqualFold(signed, filename) {
+		err = u.verifier.VerifyFile(path, signature)
+	} else {
+		err = u.verifier.Verify(bytes.NewReader(checksums), signature)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: 签名验证失败: %v", ErrVerification, err)
+	}
 	return nil
 }
 
+// maxMetadataSize bounds the checksums and signature files.
+const maxMetadataSize = 1 << 20
+
+// fetchSmall downloads a checksums or signature file.
+func (u *UpdaterService) fetchSmall(url string) ([]byte, error) {
+	resp, err := u.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务器返回 %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxMetadataSize))
+}
+
 // ApplyUpdate applies the downloaded update.
 func (u *UpdaterService) ApplyUpdate() error {
 	u.mu.Lock()
@@ -441,7 +763,22 @@ func (u *UpdaterService) ApplyUpdate() error {
 	}
 
 	// For binary deployment
-	// 1. Backup current binary
+	// 1. Check the verified update file was not changed since
+	u.mu.RLock()
+	downloaded := u.downloaded
+	u.mu.RUnlock()
+	if downloaded == nil {
+		u.setError("没有已校验的更新文件，请先下载更新")
+		return fmt.Errorf("没有已校验的更新文件，请先下载更新")
+	}
+	if sum, err := fileSHA256(downloaded.path); err != nil || sum != downloaded.sha256 {
+		err := fmt.Errorf("%w: 更新文件在下载后被修改或删除", ErrVerification)
+		u.setError(err.Error())
+		return err
+	}
+
+	// 2. Backup current binary
+	config := u.GetConfig()
 	execPath, err := os.Executable()
 	if err != nil {
 		u.setError("获取程序路径失败")
@@ -449,26 +786,17 @@ func (u *UpdaterService) ApplyUpdate() error {
 	}
 
 	backupPath := execPath + ".backup"
-	if u.config.BackupBeforeUpdate {
+	if config.BackupBeforeUpdate {
 		if err := copyFile(execPath, backupPath); err != nil {
 			u.setError("备份失败: " + err.Error())
 			return err
 		}
 	}
 
-	// 2. Find downloaded update file
-	files, err := filepath.Glob(filepath.Join(u.downloadPath, "*"))
-	if err != nil || len(files) == 0 {
-		u.setError("未找到更新文件")
-		return fmt.Errorf("未找到更新文件")
-	}
-
-	updateFile := files[0]
-
 	// 3. Replace binary
-	if err := os.Rename(updateFile, execPath); err != nil {
+	if err := os.Rename(downloaded.path, execPath); err != nil {
 		// Try copy instead
-		if err := copyFile(updateFile, execPath); err != nil {
+		if err := copyFile(downloaded.path, execPath); err != nil {
 			u.setError("替换程序失败: " + err.Error())
 			return err
 		}
@@ -480,11 +808,27 @@ func (u *UpdaterService) ApplyUpdate() error {
 		return err
 	}
 
+	// 5. With a backup, the new version is verified after the restart and
+	// rolled back if it does not become healthy
+	if config.BackupBeforeUpdate {
+		if err := u.startTrial(version.GetVersion(), downloaded.version, backupPath); err != nil {
+			u.setError("保存更新记录失败: " + err.Error())
+			return err
+		}
+	}
+
 	u.mu.Lock()
+	u.downloaded = nil
 	u.status.State = "idle"
 	u.status.Message = "更新已应用，请重启服务"
+	if config.AutoRestart {
+		u.status.Message = "更新已应用，即将重启服务"
+	}
 	u.mu.Unlock()
 
+	if config.AutoRestart {
+		u.scheduleRestart()
+	}
 	return nil
 }
 
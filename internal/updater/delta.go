@@ -0,0 +1,96 @@
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// patchName returns the name of the release asset patching the binary
+// named binary of version from into the binary of the release, e.g.
+// cyp-registry-linux-amd64.from-1.2.0.bsdiff.
+func patchName(binary, from string) string {
+	return binary + ".from-" + strings.TrimPrefix(from, "v") + ".bsdiff"
+}
+
+// errCorruptPatch is returned for a patch that is not a valid BSDIFF40
+// patch or does not fit the old file.
+var errCorruptPatch = errors.New("corrupt bsdiff patch")
+
+// maxPatchedSize bounds the size of a patched binary.
+const maxPatchedSize = 1 << 30
+
+// bspatch applies a BSDIFF40 patch, as produced by bsdiff, to old and
+// returns the new file. The patch is a 32 byte header followed by three
+// bzip2 streams: control triples, diff bytes added to the old file, and
+// extra bytes copied as is.
+func bspatch(old, patch []byte) ([]byte, error) {
+	if len(patch) < 32 || string(patch[:8]) != "BSDIFF40" {
+		return nil, errCorruptPatch
+	}
+	ctrlLen := offtin(patch[8:16])
+	diffLen := offtin(patch[16:24])
+	newSize := offtin(patch[24:32])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || newSize > maxPatchedSize {
+		return nil, errCorruptPatch
+	}
+	// Compare against the remaining length so that huge lengths cannot
+	// overflow the sum
+	if ctrlLen > int64(len(patch))-32 || diffLen > int64(len(patch))-32-ctrlLen {
+		return nil, errCorruptPatch
+	}
+
+	ctrl := bzip2.NewReader(bytes.NewReader(patch[32 : 32+ctrlLen]))
+	diff := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen : 32+ctrlLen+diffLen]))
+	extra := bzip2.NewReader(bytes.NewReader(patch[32+ctrlLen+diffLen:]))
+
+	out := make([]byte, newSize)
+	var oldPos, newPos int64
+	var triple [24]byte
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple[:]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errCorruptPatch, err)
+		}
+		add, copyLen, seek := offtin(triple[0:8]), offtin(triple[8:16]), offtin(triple[16:24])
+
+		// Add the diff bytes to the old bytes at the same offset
+		if add < 0 || add > newSize-newPos {
+			return nil, errCorruptPatch
+		}
+		if _, err := io.ReadFull(diff, out[newPos:newPos+add]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errCorruptPatch, err)
+		}
+		for i := int64(0); i < add; i++ {
+			if p := oldPos + i; p >= 0 && p < int64(len(old)) {
+				out[newPos+i] += old[p]
+			}
+		}
+		newPos += add
+		oldPos += add
+
+		// Copy the extra bytes
+		if copyLen < 0 || copyLen > newSize-newPos {
+			return nil, errCorruptPatch
+		}
+		if _, err := io.ReadFull(extra, out[newPos:newPos+copyLen]); err != nil {
+			return nil, fmt.Errorf("%w: %v", errCorruptPatch, err)
+		}
+		newPos += copyLen
+		oldPos += seek
+	}
+	return out, nil
+}
+
+// offtin decodes the sign-magnitude little-endian integers of bsdiff.
+func offtin(b []byte) int64 {
+	v := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"os"
+	"syscall"
+)
+
+// Exec replaces the process with the binary on disk, keeping the arguments
+// and environment. It only returns on error.
+func Exec() error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(execPath, os.Args, os.Environ())
+}
@@ -0,0 +1,26 @@
+//go:build windows
+
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Exec starts the binary on disk with the same arguments and environment
+// and exits, as Windows cannot replace a running process.
+func Exec() error {
+	execPath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(execPath, os.Args[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
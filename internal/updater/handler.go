@@ -2,7 +2,10 @@
 package updater
 
 import (
+	"errors"
+
 	"cyp-docker-registry/internal/common"
+	"cyp-docker-registry/internal/service"
 
 	"github.com/gin-gonic/gin"
 )
@@ -23,11 +26,14 @@ func NewHandler(service *UpdaterService) *Handler {
 func (h *Handler) RegisterRoutes(group *gin.RouterGroup) {
 	group.GET("/check", h.checkUpdate)
 	group.GET("/status", h.getStatus)
+	group.GET("/versions", h.listVersions)
 	group.GET("/config", h.getConfig)
 	group.PUT("/config", h.updateConfig)
 	group.POST("/download", h.downloadUpdate)
+	group.POST("/import", h.importBundle)
 	group.POST("/apply", h.applyUpdate)
 	group.POST("/rollback", h.rollback)
+	group.POST("/restart", h.restart)
 	group.GET("/docker-command", h.getDockerCommand)
 	group.GET("/watchtower-config", h.getWatchtowerConfig)
 }
@@ -58,10 +64,29 @@ func (h *Handler) getStatus(c *gin.Context) {
 	if lastVersion != nil {
 		response["version_info"] = lastVersion
 	}
+	if trial := h.service.GetTrial(); trial != nil {
+		response["trial"] = trial
+	}
 
 	common.SuccessResponse(c, response)
 }
 
+// listVersions handles GET /api/update/versions
+func (h *Handler) listVersions(c *gin.Context) {
+	versions, err := h.service.ListVersions()
+	if err != nil {
+		common.ErrorResponse(c, common.ErrInternalError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	common.SuccessResponse(c, gin.H{
+		"channel":  h.service.GetConfig().UpdateChannel,
+		"versions": versions,
+	})
+}
+
 // getConfig handles GET /api/update/config
 func (h *Handler) getConfig(c *gin.Context) {
 	config := h.service.GetConfig()
@@ -80,6 +105,15 @@ func (h *Handler) updateConfig(c *gin.Context) {
 		})
 		return
 	}
+	if config.UpdateChannel == "" {
+		config.UpdateChannel = ChannelStable
+	}
+	if !validChannel(config.UpdateChannel) {
+		common.ErrorResponse(c, common.ErrInvalidRequest, gin.H{
+			"error": "无效的更新通道: " + config.UpdateChannel,
+		})
+		return
+	}
 
 	h.service.SetConfig(config)
 
@@ -132,6 +166,61 @@ func (h *Handler) downloadUpdate(c *gin.Context) {
 	})
 }
 
+// importBundle handles POST /api/update/import?apply=true
+// The body is a signed offline update bundle, a tar optionally
+// gzip-compressed. With apply=true the update is applied once verified.
+func (h *Handler) importBundle(c *gin.Context) {
+	if h.service.IsDocker() {
+		common.SuccessResponse(c, gin.H{
+			"message":        "Docker 容器无法导入离线更新包",
+			"is_docker":      true,
+			"docker_command": h.service.GetDockerUpdateCommand(),
+			"tip":            "请使用 docker load 导入新的镜像",
+		})
+		return
+	}
+
+	ver, err := h.service.ImportBundle(c.Request.Body)
+	if err != nil {
+		code := common.ErrInternalError
+		if errors.Is(err, ErrVerification) {
+			code = common.ErrInvalidRequest
+		}
+		common.ErrorResponse(c, code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if c.Query("apply") != "true" {
+		common.SuccessResponse(c, gin.H{
+			"message": "离线更新包已导入，校验通过",
+			"version": ver,
+			"applied": false,
+		})
+		return
+	}
+
+	if err := h.service.ApplyUpdate(); err != nil {
+		common.ErrorResponse(c, common.ErrInternalError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	restart := h.service.GetConfig().AutoRestart
+	message := "离线更新已应用，请重启服务"
+	if restart {
+		message = "离线更新已应用，服务即将重启，重启后未通过健康检查将自动回滚"
+	}
+	common.SuccessResponse(c, gin.H{
+		"message": message,
+		"version": ver,
+		"applied": true,
+		"restart": restart,
+	})
+}
+
 // applyUpdate handles POST /api/update/apply
 func (h *Handler) applyUpdate(c *gin.Context) {
 	// Check if running in Docker
@@ -161,8 +250,17 @@ func (h *Handler) applyUpdate(c *gin.Context) {
 		return
 	}
 
+	if h.service.GetConfig().AutoRestart {
+		common.SuccessResponse(c, gin.H{
+			"message": "更新已应用，服务即将重启，重启后未通过健康检查将自动回滚",
+			"restart": true,
+		})
+		return
+	}
+
 	common.SuccessResponse(c, gin.H{
 		"message": "更新已应用，请重启服务",
+		"restart": false,
 	})
 }
 
@@ -189,8 +287,42 @@ func (h *Handler) rollback(c *gin.Context) {
 		return
 	}
 
+	if h.service.GetConfig().AutoRestart {
+		h.service.scheduleRestart()
+		common.SuccessResponse(c, gin.H{
+			"message": "已回滚到之前版本，服务即将重启",
+			"restart": true,
+		})
+		return
+	}
+
 	common.SuccessResponse(c, gin.H{
 		"message": "已回滚到之前版本，请重启服务",
+		"restart": false,
+	})
+}
+
+// restart handles POST /api/update/restart
+func (h *Handler) restart(c *gin.Context) {
+	// 重启会中断所有连接，除路由外在此再检查一次管理员权限
+	if !isAdmin(c) {
+		common.ErrorResponse(c, common.ErrForbidden, gin.H{
+			"message": "需要管理员权限",
+		})
+		return
+	}
+
+	if h.service.IsDocker() {
+		common.SuccessResponse(c, gin.H{
+			"message":   "Docker 容器请使用 docker-compose restart",
+			"is_docker": true,
+		})
+		return
+	}
+
+	h.service.scheduleRestart()
+	common.SuccessResponse(c, gin.H{
+		"message": "服务即将重启",
 	})
 }
 
@@ -217,3 +349,10 @@ func (h *Handler) getWatchtowerConfig(c *gin.Context) {
 		"description": "将此配置添加到 docker-compose.yaml 以启用自动更新",
 	})
 }
+
+// isAdmin reports whether the request was authenticated by an administrator.
+func isAdmin(c *gin.Context) bool {
+	v, _ := c.Get("currentUser")
+	user, ok := v.(*service.User)
+	return ok && user != nil && user.Role == service.RoleAdmin
+}
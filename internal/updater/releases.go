@@ -0,0 +1,204 @@
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"cyp-docker-registry/internal/version"
+)
+
+// Update channels. Each channel also receives the releases of the more
+// stable ones: beta gets stable releases, dev gets everything.
+const (
+	ChannelStable = "stable" // 正式版本
+	ChannelBeta   = "beta"   // 预发布版本，如 v1.3.0-beta.1、v1.3.0-rc.1
+	ChannelDev    = "dev"    // 开发版本，如 v1.3.0-dev.20240120、v1.3.0-alpha.1
+)
+
+// Channels lists the update channels, most stable first.
+var Channels = []string{ChannelStable, ChannelBeta, ChannelDev}
+
+// validChannel reports whether channel is an update channel.
+func validChannel(channel string) bool {
+	for _, c := range Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// channelRank orders channels by stability.
+func channelRank(channel string) int {
+	for i, c := range Channels {
+		if c == channel {
+			return i
+		}
+	}
+	return 0
+}
+
+// releaseChannel returns the channel of a release: pre-releases tagged
+// dev, alpha, nightly or snapshot are dev builds, other pre-releases beta.
+func releaseChannel(release *GitHubRelease) string {
+	if !release.Prerelease {
+		return ChannelStable
+	}
+	tag := strings.ToLower(release.TagName)
+	for _, marker := range []string{"dev", "alpha", "nightly", "snapshot"} {
+		if strings.Contains(tag, marker) {
+			return ChannelDev
+		}
+	}
+	return ChannelBeta
+}
+
+// ReleaseInfo describes a release available for installation.
+type ReleaseInfo struct {
+	Version      string    `json:"version"`
+	Channel      string    `json:"channel"`
+	ReleaseAt    time.Time `json:"release_at"`
+	Changelog    string    `json:"changelog"`
+	Current      bool      `json:"current"`
+	Downloadable bool      `json:"downloadable"` // 有当前平台的程序
+	Delta        bool      `json:"delta"`        // 有从当前版本的增量补丁
+}
+
+// maxReleases is the number of recent releases listed.
+const maxReleases = 30
+
+// repoPath returns the owner/name of the GitHub repository. The setting
+// may also be a GitHub API URL of the repository or of its releases.
+func (u *UpdaterService) repoPath() (string, error) {
+	repo := strings.TrimSpace(u.GetConfig().GitHubRepo)
+	if strings.Contains(repo, "://") {
+		parsed, err := url.Parse(repo)
+		if err != nil {
+			return "", fmt.Errorf("无效的 GitHub 仓库: %s", repo)
+		}
+		repo = strings.TrimPrefix(parsed.Path, "/repos/")
+	}
+	parts := strings.Split(strings.Trim(repo, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("GitHub 仓库未配置")
+	}
+	return parts[0] + "/" + parts[1], nil
+}
+
+// githubGet requests a path of the repository from the GitHub API and
+// decodes the response into v.
+func (u *UpdaterService) githubGet(path string, v interface{}) error {
+	repo, err := u.repoPath()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", u.apiBase+"/repos/"+repo+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	req.Header.Set("User-Agent", "CYP-Docker-Registry-Updater")
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("无法连接 GitHub: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("未找到发布版本")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API 返回错误: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("解析发布信息失败: %w", err)
+	}
+	return nil
+}
+
+// fetchReleases fetches the recent releases of the configured channel,
+// newest version first. Drafts are skipped.
+func (u *UpdaterService) fetchReleases() ([]*GitHubRelease, error) {
+	var releases []*GitHubRelease
+	if err := u.githubGet(fmt.Sprintf("/releases?per_page=%d", maxReleases), &releases); err != nil {
+		return nil, err
+	}
+
+	rank := channelRank(u.GetConfig().UpdateChannel)
+	var out []*GitHubRelease
+	for _, release := range releases {
+		if release.Draft || channelRank(releaseChannel(release)) > rank {
+			continue
+		}
+		out = append(out, release)
+	}
+	sortReleases(out)
+	return out, nil
+}
+
+// latestRelease returns the newest release of the configured channel.
+func (u *UpdaterService) latestRelease() (*GitHubRelease, error) {
+	releases, err := u.fetchReleases()
+	if err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("%s 通道中没有发布版本", u.GetConfig().UpdateChannel)
+	}
+	return releases[0], nil
+}
+
+// fetchRelease fetches the release of a version, tagged with or without
+// the v prefix. Any channel can be installed explicitly.
+func (u *UpdaterService) fetchRelease(ver string) (*GitHubRelease, error) {
+	ver = strings.TrimPrefix(ver, "v")
+	var release GitHubRelease
+	err := u.githubGet("/releases/tags/v"+url.PathEscape(ver), &release)
+	if err != nil {
+		err = u.githubGet("/releases/tags/"+url.PathEscape(ver), &release)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("版本 %s: %w", ver, err)
+	}
+	return &release, nil
+}
+
+// ListVersions lists the recent releases of the configured channel,
+// newest first.
+func (u *UpdaterService) ListVersions() ([]ReleaseInfo, error) {
+	releases, err := u.fetchReleases()
+	if err != nil {
+		return nil, err
+	}
+
+	current := version.GetVersion()
+	list := make([]ReleaseInfo, 0, len(releases))
+	for _, release := range releases {
+		info := u.versionInfo(release)
+		list = append(list, ReleaseInfo{
+			Version:      info.Latest,
+			Channel:      info.Channel,
+			ReleaseAt:    info.ReleaseAt,
+			Changelog:    info.Changelog,
+			Current:      CompareVersions(info.Latest, current) == 0,
+			Downloadable: info.DownloadURL != "",
+			Delta:        info.PatchURL != "",
+		})
+	}
+	return list, nil
+}
+
+// sortReleases sorts releases by version, newest first.
+func sortReleases(releases []*GitHubRelease) {
+	sort.SliceStable(releases, func(i, j int) bool {
+		return CompareVersions(releases[i].TagName, releases[j].TagName) > 0
+	})
+}
@@ -0,0 +1,253 @@
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cyp-docker-registry/internal/version"
+)
+
+// Health verification of an applied update. After a restart the new
+// version must pass the health check healthPasses times in a row within
+// the health window, or the backup binary is restored and the service
+// restarted again. A version that crashes before it is confirmed is rolled
+// back on its maxTrialBoots+1'th start, when a supervisor restarts it.
+const (
+	healthPasses        = 3
+	healthInterval      = 10 * time.Second
+	maxTrialBoots       = 3
+	trialFile           = "update-trial.json"
+	restartDelay        = time.Second // 让当前请求的响应先返回
+	defaultHealthWindow = 2 * time.Minute
+)
+
+// UpdateTrial records an applied update until the new version is
+// confirmed healthy or rolled back.
+type UpdateTrial struct {
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	Backup     string    `json:"backup"`
+	AppliedAt  time.Time `json:"applied_at"`
+	Boots      int       `json:"boots"`                 // 新版本已启动的次数
+	Deadline   time.Time `json:"deadline,omitempty"`    // 本次启动的健康检查截止时间
+	RolledBack bool      `json:"rolled_back,omitempty"` // 已恢复备份，等待旧版本启动
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// SetHealthCheck sets the check the new version must pass after an update.
+// It must be called before Start.
+func (u *UpdaterService) SetHealthCheck(check func(ctx context.Context) error) {
+	u.healthCheck = check
+}
+
+// Restarts returns the channel receiving restart requests. The owner of the
+// process shuts down and calls Exec.
+func (u *UpdaterService) Restarts() <-chan struct{} {
+	return u.restartChan
+}
+
+// RequestRestart asks the process owner to restart the service on the
+// binary on disk. Docker containers are restarted by their runtime.
+func (u *UpdaterService) RequestRestart() error {
+	if u.isDocker {
+		return fmt.Errorf("Docker 容器请使用 docker-compose restart")
+	}
+
+	u.mu.Lock()
+	u.status.State = "restarting"
+	u.status.Message = "正在重启服务..."
+	u.mu.Unlock()
+
+	select {
+	case u.restartChan <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// scheduleRestart requests a restart after restartDelay.
+func (u *UpdaterService) scheduleRestart() {
+	time.AfterFunc(restartDelay, func() { u.RequestRestart() })
+}
+
+// GetTrial returns the applied update awaiting health verification, or nil.
+func (u *UpdaterService) GetTrial() *UpdateTrial {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	if u.trial == nil {
+		return nil
+	}
+	trial := *u.trial
+	return &trial
+}
+
+// trialPath returns the path of the trial file, next to the download
+// directory so a new download does not remove it.
+func (u *UpdaterService) trialPath() string {
+	return filepath.Join(filepath.Dir(u.downloadPath), trialFile)
+}
+
+// loadTrial reads the trial file, nil when there is none.
+func (u *UpdaterService) loadTrial() (*UpdateTrial, error) {
+	data, err := os.ReadFile(u.trialPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var trial UpdateTrial
+	if err := json.Unmarshal(data, &trial); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", u.trialPath(), err)
+	}
+	return &trial, nil
+}
+
+// saveTrial writes the trial file.
+func (u *UpdaterService) saveTrial(trial *UpdateTrial) error {
+	data, err := json.MarshalIndent(trial, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(u.trialPath()), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(u.trialPath(), data, 0644)
+}
+
+// startTrial records an applied update, verified on the next start.
+func (u *UpdaterService) startTrial(from, to, backup string) error {
+	return u.saveTrial(&UpdateTrial{
+		From:      from,
+		To:        to,
+		Backup:    backup,
+		AppliedAt: time.Now(),
+	})
+}
+
+// resumeTrial runs on start: it verifies the health of a freshly updated
+// version, or reports the outcome of a rolled back one.
+func (u *UpdaterService) resumeTrial() {
+	trial, err := u.loadTrial()
+	if err != nil {
+		u.setError("读取更新记录失败: " + err.Error())
+		return
+	}
+	if trial == nil {
+		return
+	}
+
+	// Not running the new version: it was rolled back, or never started
+	if current := version.GetVersion(); CompareVersions(current, trial.To) != 0 {
+		os.Remove(u.trialPath())
+		u.mu.Lock()
+		if trial.RolledBack {
+			u.status.Message = fmt.Sprintf("版本 %s 未通过健康检查（%s），已自动回滚到 %s", trial.To, trial.Reason, current)
+		} else {
+			u.status.Message = fmt.Sprintf("未运行更新后的版本 %s，当前版本 %s", trial.To, current)
+		}
+		u.mu.Unlock()
+		return
+	}
+
+	trial.Boots++
+	if trial.Boots > maxTrialBoots {
+		u.rollbackTrial(trial, fmt.Sprintf("连续 %d 次启动未通过健康检查", maxTrialBoots))
+		return
+	}
+
+	window := u.GetConfig().HealthWindow
+	if window <= 0 {
+		window = defaultHealthWindow
+	}
+	trial.Deadline = time.Now().Add(window)
+	if err := u.saveTrial(trial); err != nil {
+		u.setError("保存更新记录失败: " + err.Error())
+		return
+	}
+
+	u.mu.Lock()
+	u.trial = trial
+	u.status.State = "verifying"
+	u.status.Message = fmt.Sprintf("正在验证版本 %s 的运行状态...", trial.To)
+	u.mu.Unlock()
+
+	go u.verifyTrial(trial)
+}
+
+// verifyTrial runs the health check until it passes healthPasses times in
+// a row, confirming the update, or the deadline passes, rolling it back.
+func (u *UpdaterService) verifyTrial(trial *UpdateTrial) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+
+	passes := 0
+	lastErr := fmt.Errorf("健康检查未执行")
+	for {
+		select {
+		case <-u.stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		if err := u.runHealthCheck(); err != nil {
+			passes = 0
+			lastErr = err
+		} else if passes++; passes >= healthPasses {
+			u.confirmTrial(trial)
+			return
+		}
+
+		if time.Now().After(trial.Deadline) {
+			u.rollbackTrial(trial, lastErr.Error())
+			return
+		}
+	}
+}
+
+// runHealthCheck runs the health check, bounded by healthInterval.
+func (u *UpdaterService) runHealthCheck() error {
+	if u.healthCheck == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthInterval)
+	defer cancel()
+	return u.healthCheck(ctx)
+}
+
+// confirmTrial keeps the new version.
+func (u *UpdaterService) confirmTrial(trial *UpdateTrial) {
+	os.Remove(u.trialPath())
+
+	u.mu.Lock()
+	u.trial = nil
+	u.status.State = "idle"
+	u.status.Message = fmt.Sprintf("已更新到版本 %s，运行正常", trial.To)
+	u.mu.Unlock()
+}
+
+// rollbackTrial restores the backup binary and restarts on it.
+func (u *UpdaterService) rollbackTrial(trial *UpdateTrial, reason string) {
+	trial.RolledBack = true
+	trial.Reason = reason
+	if err := u.saveTrial(trial); err != nil {
+		u.setError("保存更新记录失败: " + err.Error())
+		return
+	}
+
+	if err := u.Rollback(); err != nil {
+		os.Remove(u.trialPath())
+		u.setError(fmt.Sprintf("版本 %s 未通过健康检查（%s），回滚失败: %s", trial.To, reason, err))
+		return
+	}
+
+	u.mu.Lock()
+	u.trial = nil
+	u.mu.Unlock()
+	u.RequestRestart()
+}
@@ -0,0 +1,257 @@
+// Package updater provides auto-update functionality for CYP-Docker-Registry.
+package updater
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// ErrVerification is returned when a downloaded update does not match the
+// checksums or signature of its release. The file is deleted.
+var ErrVerification = errors.New("更新文件校验失败")
+
+// Kinds of release signatures.
+const (
+	SignatureMinisign = "minisign" // .minisig，Ed25519
+	SignatureCosign   = "cosign"   // .sig，cosign sign-blob 生成的 base64 签名
+)
+
+// ReleaseVerifier checks release artifacts against a detached signature
+// made with the release key. The key is a minisign public key, or a PEM
+// public key (ECDSA or Ed25519) for cosign signatures.
+type ReleaseVerifier struct {
+	kind     string
+	keyID    []byte // minisign
+	minisign ed25519.PublicKey
+	cosign   interface{}
+}
+
+// NewReleaseVerifier parses a public key, given inline or as the path of a
+// key file.
+func NewReleaseVerifier(key string) (*ReleaseVerifier, error) {
+	key = strings.TrimSpace(key)
+	if data, err := os.ReadFile(key); err == nil {
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return nil, fmt.Errorf("public key is empty")
+	}
+
+	if block, _ := pem.Decode([]byte(key)); block != nil {
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PEM public key: %w", err)
+		}
+		switch pub.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T", pub)
+		}
+		return &ReleaseVerifier{kind: SignatureCosign, cosign: pub}, nil
+	}
+
+	// A minisign .pub file holds an untrusted comment, then the key
+	raw, err := base64.StdEncoding.DecodeString(lastLine(key))
+	if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+		return nil, fmt.Errorf("invalid minisign public key")
+	}
+	return &ReleaseVerifier{kind: SignatureMinisign, keyID: raw[2:10], minisign: ed25519.PublicKey(raw[10:])}, nil
+}
+
+// Kind returns the kind of signatures the verifier checks.
+func (v *ReleaseVerifier) Kind() string {
+	return v.kind
+}
+
+// signatureSuffix returns the suffix of signature assets.
+func (v *ReleaseVerifier) signatureSuffix() string {
+	if v.kind == SignatureMinisign {
+		return ".minisig"
+	}
+	return ".sig"
+}
+
+// VerifyFile checks the signature of the file at path.
+func (v *ReleaseVerifier) VerifyFile(path string, signature []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return v.Verify(f, signature)
+}
+
+// Verify checks the signature of the content of r.
+func (v *ReleaseVerifier) Verify(r io.Reader, signature []byte) error {
+	if v.kind == SignatureMinisign {
+		return v.verifyMinisign(r, signature)
+	}
+	return v.verifyCosign(r, signature)
+}
+
+// verifyMinisign checks a minisign signature: the signature of the file,
+// prehashed with BLAKE2b-512 for the ED algorithm, and the global
+// signature binding the trusted comment to it.
+func (v *ReleaseVerifier) verifyMinisign(r io.Reader, signature []byte) error {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(signature))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if len(lines) < 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 74 {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid minisign signature")
+	}
+	if !bytes.Equal(sig[2:10], v.keyID) {
+		return fmt.Errorf("signature was made with key %X, not the configured key %X", reverse(sig[2:10]), reverse(v.keyID))
+	}
+
+	var message []byte
+	switch string(sig[:2]) {
+	case "ED":
+		h, _ := blake2b.New512(nil)
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		message = h.Sum(nil)
+	case "Ed":
+		if message, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported minisign algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(v.minisign, message, sig[10:]) {
+		return fmt.Errorf("signature does not match")
+	}
+
+	comment := strings.TrimPrefix(lines[2], "trusted comment: ")
+	if !ed25519.Verify(v.minisign, append(sig[10:], comment...), global) {
+		return fmt.Errorf("trusted comment signature does not match")
+	}
+	return nil
+}
+
+// verifyCosign checks a base64 signature from cosign sign-blob: ECDSA over
+// the SHA-256 of the file, or Ed25519 over the file.
+func (v *ReleaseVerifier) verifyCosign(r io.Reader, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("invalid cosign signature: %w", err)
+	}
+	switch pub := v.cosign.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		if !ecdsa.VerifyASN1(pub, h.Sum(nil), sig) {
+			return fmt.Errorf("signature does not match")
+		}
+	case ed25519.PublicKey:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("signature does not match")
+		}
+	}
+	return nil
+}
+
+// lastLine returns the last non-empty line of s.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// reverse returns b reversed; minisign prints key IDs little-endian.
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+// ============================================================================
+// Checksums
+// ============================================================================
+
+// parseChecksums reads a checksums file in the format of sha256sum, one
+// "<hex>  <name>" per line, and returns the checksum of name.
+func parseChecksums(data []byte, name string) (string, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		// sha256sum marks binary mode with a leading *
+		file := strings.TrimPrefix(fields[len(fields)-1], "*")
+		if file == name || strings.HasSuffix(file, "/"+name) {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+	// A <name>.sha256 file holds only the checksum
+	if fields := strings.Fields(string(data)); len(fields) == 1 && len(fields[0]) == 64 {
+		return strings.ToLower(fields[0]), true
+	}
+	return "", false
+}
+
+// hashingWriter computes the SHA-256 of what is written through it.
+type hashingWriter struct {
+	w io.Writer
+	h hash.Hash
+}
+
+func newHashingWriter(w io.Writer) *hashingWriter {
+	return &hashingWriter{w: w, h: sha256.New()}
+}
+
+func (hw *hashingWriter) Write(p []byte) (int, error) {
+	hw.h.Write(p)
+	return hw.w.Write(p)
+}
+
+func (hw *hashingWriter) sum() string {
+	return hex.EncodeToString(hw.h.Sum(nil))
+}
+
+// fileSHA256 returns the SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,269 @@
+// Package compression provides compression utilities for container layers.
+package compression
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Stored blob files keep the digest-derived path and add an extension for
+// the on-disk encoding. Readers always get the original bytes back, so the
+// content digest never changes.
+const (
+	zstdExt = ".zst"
+	gzipExt = ".gz"
+)
+
+// gzipSizeField is the gzip extra subfield ID carrying the original size.
+var gzipSizeField = [2]byte{'S', 'Z'}
+
+// Extension returns the file extension used for blobs stored with alg.
+func Extension(alg Algorithm) string {
+	switch alg {
+	case AlgorithmZstd:
+		return zstdExt
+	case AlgorithmGzip:
+		return gzipExt
+	default:
+		return ""
+	}
+}
+
+// BlobFileInfo describes a stored blob file.
+type BlobFileInfo struct {
+	Path       string    // actual file path including extension
+	Algorithm  Algorithm // on-disk encoding
+	Size       int64     // original (logical) size
+	StoredSize int64     // bytes used on disk
+	ModTime    time.Time // last write of the stored file
+}
+
+// StatBlobFile finds the stored variant of the blob at path.
+func StatBlobFile(path string) (*BlobFileInfo, error) {
+	for _, alg := range []Algorithm{AlgorithmNone, AlgorithmZstd, AlgorithmGzip} {
+		file := path + Extension(alg)
+		fi, err := os.Stat(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		info := &BlobFileInfo{Path: file, Algorithm: alg, Size: fi.Size(), StoredSize: fi.Size(), ModTime: fi.ModTime()}
+		if alg != AlgorithmNone {
+			size, err := readOriginalSize(file, alg)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read blob header %s: %w", file, err)
+			}
+			info.Size = size
+		}
+		return info, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// OpenBlobFile opens the blob at path, transparently decompressing it.
+// It returns the reader and the original size.
+func OpenBlobFile(path string) (io.ReadCloser, int64, error) {
+	info, err := StatBlobFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := os.Open(info.Path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch info.Algorithm {
+	case AlgorithmZstd:
+		dec, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+		return &decodeReader{Reader: dec, file: file, close: dec.Close}, info.Size, nil
+	case AlgorithmGzip:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, 0, err
+		}
+		return &decodeReader{Reader: gz, file: file, close: func() { gz.Close() }}, info.Size, nil
+	default:
+		return file, info.Size, nil
+	}
+}
+
+// WriteBlobFile compresses size bytes from src and writes them to path
+// plus the extension for alg. The file is written to a temp file first and
+// renamed into place. It returns the stored size.
+func WriteBlobFile(path string, src io.Reader, size int64, alg Algorithm, level int) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "transcode-*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	buffered := bufio.NewWriter(tmp)
+	var w io.WriteCloser
+	switch alg {
+	case AlgorithmZstd:
+		encLevel := zstd.SpeedDefault
+		if level != 0 {
+			encLevel = zstd.EncoderLevelFromZstd(level)
+		}
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(encLevel))
+		if err != nil {
+			return 0, err
+		}
+		// Record the original size in the frame header so it can be read without decoding.
+		enc.ResetContentSize(buffered, size)
+		w = enc
+	case AlgorithmGzip:
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gz, err := gzip.NewWriterLevel(buffered, level)
+		if err != nil {
+			return 0, err
+		}
+		gz.Header.Extra = gzipSizeExtra(size)
+		w = gz
+	default:
+		return 0, fmt.Errorf("unsupported storage compression: %s", alg)
+	}
+
+	written, err := io.Copy(w, io.LimitReader(src, size))
+	if err != nil {
+		w.Close()
+		return 0, err
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if written != size {
+		return 0, fmt.Errorf("size mismatch: expected %d, got %d", size, written)
+	}
+	if err := buffered.Flush(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	fi, err := os.Stat(tmp.Name())
+	if err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), path+Extension(alg)); err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// RemoveBlobFile removes every stored variant of the blob at path.
+func RemoveBlobFile(path string) error {
+	for _, alg := range []Algorithm{AlgorithmNone, AlgorithmZstd, AlgorithmGzip} {
+		if err := os.Remove(path + Extension(alg)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// BlobFileExists reports whether any variant of the blob at path exists.
+func BlobFileExists(path string) bool {
+	_, err := StatBlobFile(path)
+	return err == nil
+}
+
+// readOriginalSize reads the uncompressed size from a stored file header.
+func readOriginalSize(path string, alg Algorithm) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	switch alg {
+	case AlgorithmZstd:
+		buf := make([]byte, zstd.HeaderMaxSize)
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return 0, err
+		}
+		var h zstd.Header
+		if err := h.Decode(buf[:n]); err != nil {
+			return 0, err
+		}
+		if !h.HasFCS {
+			return 0, fmt.Errorf("zstd frame has no content size")
+		}
+		return int64(h.FrameContentSize), nil
+	case AlgorithmGzip:
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		size, ok := parseGzipSizeExtra(gz.Header.Extra)
+		if !ok {
+			return 0, fmt.Errorf("gzip header has no size field")
+		}
+		return size, nil
+	}
+	return 0, fmt.Errorf("unsupported storage compression: %s", alg)
+}
+
+// gzipSizeExtra encodes size as a gzip extra subfield (RFC 1952 2.3.1.1).
+func gzipSizeExtra(size int64) []byte {
+	extra := make([]byte, 12)
+	extra[0], extra[1] = gzipSizeField[0], gzipSizeField[1]
+	binary.LittleEndian.PutUint16(extra[2:], 8)
+	binary.LittleEndian.PutUint64(extra[4:], uint64(size))
+	return extra
+}
+
+// parseGzipSizeExtra finds the size subfield in gzip extra data.
+func parseGzipSizeExtra(extra []byte) (int64, bool) {
+	for len(extra) >= 4 {
+		length := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+length {
+			return 0, false
+		}
+		if extra[0] == gzipSizeField[0] && extra[1] == gzipSizeField[1] && length == 8 {
+			return int64(binary.LittleEndian.Uint64(extra[4:])), true
+		}
+		extra = extra[4+length:]
+	}
+	return 0, false
+}
+
+// decodeReader closes both the decoder and the underlying file.
+type decodeReader struct {
+	io.Reader
+	file  *os.File
+	close func()
+}
+
+func (r *decodeReader) Close() error {
+	r.close()
+	return r.file.Close()
+}
@@ -6,6 +6,8 @@ import (
 	"compress/gzip"
 	"io"
 	"sync"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 // Algorithm represents a compression algorithm.
@@ -152,14 +154,22 @@ func (c *Compressor) decompressGzip(data []byte) ([]byte, error) {
 
 // compressZstd compresses data using zstd.
 func (c *Compressor) compressZstd(data []byte) ([]byte, error) {
-	// Zstd requires external library - fallback to gzip for now
-	return c.compressGzip(data)
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+	if err != nil {
+		return nil, err
+	}
+	defer enc.Close()
+	return enc.EncodeAll(data, nil), nil
 }
 
 // decompressZstd decompresses zstd data.
 func (c *Compressor) decompressZstd(data []byte) ([]byte, error) {
-	// Zstd requires external library - fallback to gzip for now
-	return c.decompressGzip(data)
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+	return dec.DecodeAll(data, nil)
 }
 
 // GetAlgorithm returns the compression algorithm.
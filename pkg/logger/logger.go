@@ -3,6 +3,7 @@
 package logger
 
 import (
+	"io"
 	"os"
 	"sync"
 
@@ -62,29 +63,8 @@ func newLogger(config *Config) (*zap.Logger, error) {
 		level = zapcore.ErrorLevel
 	}
 
-	// Create encoder config
-	encoderConfig := zapcore.EncoderConfig{
-		TimeKey:        "timestamp",
-		LevelKey:       "level",
-		NameKey:        "logger",
-		CallerKey:      "caller",
-		FunctionKey:    zapcore.OmitKey,
-		MessageKey:     "message",
-		StacktraceKey:  "stacktrace",
-		LineEnding:     zapcore.DefaultLineEnding,
-		EncodeLevel:    zapcore.LowercaseLevelEncoder,
-		EncodeTime:     zapcore.ISO8601TimeEncoder,
-		EncodeDuration: zapcore.SecondsDurationEncoder,
-		EncodeCaller:   zapcore.ShortCallerEncoder,
-	}
-
 	// Create encoder
-	var encoder zapcore.Encoder
-	if config.Format == "console" {
-		encoder = zapcore.NewConsoleEncoder(encoderConfig)
-	} else {
-		encoder = zapcore.NewJSONEncoder(encoderConfig)
-	}
+	encoder := newEncoder(config.Format)
 
 	// Create output writers
 	var writers []zapcore.WriteSyncer
@@ -110,6 +90,55 @@ func newLogger(config *Config) (*zap.Logger, error) {
 	return logger, nil
 }
 
+// Output is a log destination with its own encoding.
+type Output struct {
+	Path     string // stdout、stderr 或文件路径
+	Format   string // json or console
+	Rotation Rotation
+}
+
+// NewCore creates a core writing to out. For files the returned closer
+// closes the file; it is nil for stdout and stderr.
+func NewCore(out Output, level zapcore.LevelEnabler) (zapcore.Core, io.Closer, error) {
+	var ws zapcore.WriteSyncer
+	var closer io.Closer
+	switch out.Path {
+	case "", "stdout":
+		ws = zapcore.Lock(os.Stdout)
+	case "stderr":
+		ws = zapcore.Lock(os.Stderr)
+	default:
+		file, err := OpenRotatingFile(out.Path, out.Rotation)
+		if err != nil {
+			return nil, nil, err
+		}
+		ws, closer = file, file
+	}
+	return zapcore.NewCore(newEncoder(out.Format), ws, level), closer, nil
+}
+
+// newEncoder creates a JSON encoder, or a console encoder for "console".
+func newEncoder(format string) zapcore.Encoder {
+	encoderConfig := zapcore.EncoderConfig{
+		TimeKey:        "timestamp",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		FunctionKey:    zapcore.OmitKey,
+		MessageKey:     "message",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.SecondsDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	if format == "console" {
+		return zapcore.NewConsoleEncoder(encoderConfig)
+	}
+	return zapcore.NewJSONEncoder(encoderConfig)
+}
+
 // Debug logs a debug message.
 func Debug(msg string, fields ...zap.Field) {
 	Get().Debug(msg, fields...)
@@ -0,0 +1,186 @@
+// Package p2p 提供P2P带宽限制
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// limitChunkSize 限速写入时每次发送的最大字节数，避免一次占用过多令牌
+const limitChunkSize = 32 * 1024
+
+// ParseBandwidth 解析带宽配置，返回每秒字节数。支持比特单位
+// (bps/Kbps/Mbps/Gbps) 和字节单位 (B/s/KB/s/MB/s/GB/s)；
+// 空字符串、"0" 或 "unlimited" 表示不限速，返回 0。
+func ParseBandwidth(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	if lower == "" || lower == "0" || lower == "unlimited" {
+		return 0, nil
+	}
+
+	type unit struct {
+		suffix string
+		factor float64
+	}
+	units := []unit{
+		{"gbps", 1e9 / 8}, {"mbps", 1e6 / 8}, {"kbps", 1e3 / 8}, {"bps", 1.0 / 8},
+		{"gb/s", 1 << 30}, {"mb/s", 1 << 20}, {"kb/s", 1 << 10}, {"b/s", 1},
+	}
+	for _, u := range units {
+		if !strings.HasSuffix(lower, u.suffix) {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(lower[:len(lower)-len(u.suffix)]), 64)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("无效的带宽配置: %s", s)
+		}
+		return int64(value * u.factor), nil
+	}
+
+	return 0, fmt.Errorf("无效的带宽单位: %s", s)
+}
+
+// tokenBucket 令牌桶，令牌数允许为负，借用的令牌通过等待偿还
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒字节数
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket 创建令牌桶，突发容量为一秒的流量
+func newTokenBucket(rate int64) *tokenBucket {
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(rate),
+		tokens: float64(rate),
+		last:   time.Now(),
+	}
+}
+
+// wait 取出 n 个令牌，令牌不足时等待
+func (b *tokenBucket) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bandwidthLimiter 全局及单节点带宽限制
+type bandwidthLimiter struct {
+	global   *tokenBucket
+	peerRate int64
+	mu       sync.Mutex
+	peers    map[peer.ID]*tokenBucket
+}
+
+// newBandwidthLimiter 创建带宽限制器，两个限制都为 0 时返回 nil
+func newBandwidthLimiter(globalRate, peerRate int64) *bandwidthLimiter {
+	if globalRate <= 0 && peerRate <= 0 {
+		return nil
+	}
+
+	l := &bandwidthLimiter{
+		peerRate: peerRate,
+		peers:    make(map[peer.ID]*tokenBucket),
+	}
+	if globalRate > 0 {
+		l.global = newTokenBucket(globalRate)
+	}
+	return l
+}
+
+// wait 等待直到可以向 p 传输 n 个字节
+func (l *bandwidthLimiter) wait(ctx context.Context, p peer.ID, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	if l.peerRate > 0 {
+		l.mu.Lock()
+		bucket, ok := l.peers[p]
+		if !ok {
+			bucket = newTokenBucket(l.peerRate)
+			l.peers[p] = bucket
+		}
+		l.mu.Unlock()
+
+		if err := bucket.wait(ctx, n); err != nil {
+			return err
+		}
+	}
+
+	if l.global != nil {
+		return l.global.wait(ctx, n)
+	}
+	return nil
+}
+
+// forget 移除节点的令牌桶
+func (l *bandwidthLimiter) forget(p peer.ID) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	delete(l.peers, p)
+	l.mu.Unlock()
+}
+
+// limitedWriter 限速写入器
+type limitedWriter struct {
+	ctx     context.Context
+	w       io.Writer
+	limiter *bandwidthLimiter
+	peer    peer.ID
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > limitChunkSize {
+			chunk = chunk[:limitChunkSize]
+		}
+		if err := w.limiter.wait(w.ctx, w.peer, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,491 @@
+// Package p2p 提供分块并行下载
+package p2p
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"cyp-docker-registry/pkg/utils"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+const (
+	// defaultChunkSize 默认分块大小
+	defaultChunkSize = 4 << 20
+	// minChunkSize 最小分块大小
+	minChunkSize = 256 << 10
+	// maxChunkSize 最大分块大小
+	maxChunkSize = 64 << 20
+	// maxParallelPeers 同时下载的最大节点数
+	maxParallelPeers = 8
+	// maxChunkRetries 单个分块的最大重试次数
+	maxChunkRetries = 5
+	// maxPeerFailures 节点连续失败次数达到后不再使用
+	maxPeerFailures = 3
+	// maxChunkCacheEntries 分块摘要缓存的最大条目数
+	maxChunkCacheEntries = 64
+	// minStragglerDelay 分块下载超过该时间才会被其他节点重复请求
+	minStragglerDelay = 2 * time.Second
+)
+
+// chunkSize 返回配置的分块大小
+func (n *Node) chunkSize() int64 {
+	return clampChunkSize(utils.ParseSize(n.config.ChunkSize))
+}
+
+// clampChunkSize 将分块大小限制在允许范围内，0 表示使用默认值
+func clampChunkSize(size int64) int64 {
+	switch {
+	case size <= 0:
+		return defaultChunkSize
+	case size < minChunkSize:
+		return minChunkSize
+	case size > maxChunkSize:
+		return maxChunkSize
+	}
+	return size
+}
+
+// skipTo 将读取位置移动到 offset
+func skipTo(r io.Reader, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, offset)
+	return err
+}
+
+// chunkHashes 计算Blob每个分块的摘要，结果按摘要和分块大小缓存
+func (n *Node) chunkHashes(digest string, chunkSize int64) ([]string, int64, error) {
+	chunkSize = clampChunkSize(chunkSize)
+	key := fmt.Sprintf("%s@%d", digest, chunkSize)
+
+	n.chunkMu.Lock()
+	if cached, ok := n.chunkCache[key]; ok {
+		n.chunkMu.Unlock()
+		return cached.hashes, cached.size, nil
+	}
+	n.chunkMu.Unlock()
+
+	reader, size, err := n.blobStore.Get(digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+
+	hashes := make([]string, 0, (size+chunkSize-1)/chunkSize)
+	for read := int64(0); read < size; read += chunkSize {
+		hash := sha256.New()
+		if _, err := io.CopyN(hash, reader, min(chunkSize, size-read)); err != nil {
+			return nil, 0, err
+		}
+		hashes = append(hashes, "sha256:"+hex.EncodeToString(hash.Sum(nil)))
+	}
+
+	n.chunkMu.Lock()
+	if len(n.chunkCache) >= maxChunkCacheEntries {
+		for k := range n.chunkCache {
+			delete(n.chunkCache, k)
+			break
+		}
+	}
+	n.chunkCache[key] = chunkManifest{hashes: hashes, size: size}
+	n.chunkMu.Unlock()
+
+	return hashes, size, nil
+}
+
+// chunkManifest Blob的分块摘要
+type chunkManifest struct {
+	hashes []string
+	size   int64
+}
+
+// queryChunkHashes 向节点查询Blob的分块摘要
+func (n *Node) queryChunkHashes(ctx context.Context, peerID peer.ID, digest string, chunkSize int64) (*chunkManifest, error) {
+	stream, err := n.host.NewStream(ctx, peerID, MetaProtocolID)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	reader := bufio.NewReader(stream)
+	writer := bufio.NewWriter(stream)
+
+	req := &Message{
+		Type:      MsgTypeChunks,
+		ID:        generateMessageID(),
+		Digest:    digest,
+		Length:    chunkSize,
+		Timestamp: time.Now().Unix(),
+	}
+	if err := n.writeMessage(writer, req); err != nil {
+		return nil, err
+	}
+	writer.Flush()
+
+	resp, err := n.readMessage(reader)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("peer返回错误: %s", resp.Error)
+	}
+
+	var hashes []string
+	if err := json.Unmarshal(resp.Data, &hashes); err != nil {
+		return nil, fmt.Errorf("解析分块摘要失败: %w", err)
+	}
+	if resp.Size <= 0 || int64(len(hashes)) != (resp.Size+chunkSize-1)/chunkSize {
+		return nil, fmt.Errorf("分块摘要与大小不符")
+	}
+
+	return &chunkManifest{hashes: hashes, size: resp.Size}, nil
+}
+
+// fetchChunked 从多个节点并行下载Blob的各个分块，每个分块单独校验，
+// 慢分块会被空闲节点重复请求，最后校验整体摘要并写入本地存储。
+func (n *Node) fetchChunked(ctx context.Context, providers []peer.ID, digest string) error {
+	chunkSize := n.chunkSize()
+
+	// 从第一个能应答的节点获取分块摘要
+	var manifest *chunkManifest
+	for _, peerID := range providers {
+		m, err := n.queryChunkHashes(ctx, peerID, digest, chunkSize)
+		if err == nil {
+			manifest = m
+			break
+		}
+		n.logger.Debug("获取分块摘要失败", zap.String("peer", peerID.String()), zap.Error(err))
+	}
+	if manifest == nil {
+		return fmt.Errorf("无法获取分块摘要")
+	}
+	if len(manifest.hashes) <= 1 {
+		return n.fetchFromPeer(ctx, providers[0], digest)
+	}
+
+	tmp, err := os.CreateTemp("", "p2p-blob-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if err := tmp.Truncate(manifest.size); err != nil {
+		return err
+	}
+
+	f := newChunkFetch(digest, chunkSize, manifest, tmp)
+
+	if len(providers) > maxParallelPeers {
+		providers = providers[:maxParallelPeers]
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, peerID := range providers {
+		wg.Add(1)
+		go func(peerID peer.ID) {
+			defer wg.Done()
+			n.host.ConnManager().Protect(peerID, "blob-transfer")
+			defer n.host.ConnManager().Unprotect(peerID, "blob-transfer")
+			n.chunkWorker(fetchCtx, f, peerID)
+		}(peerID)
+	}
+	wg.Wait()
+
+	if err := f.result(); err != nil {
+		return err
+	}
+
+	// 分块都已校验，再次校验整体摘要
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, tmp); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return fmt.Errorf("摘要校验失败: 期望 %s, 实际 %s", digest, actual)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := n.blobStore.Put(digest, tmp, manifest.size); err != nil {
+		return err
+	}
+
+	n.statsMu.Lock()
+	n.stats.BlobsReceived++
+	n.statsMu.Unlock()
+
+	n.logger.Debug("分块下载完成",
+		zap.String("digest", digest),
+		zap.Int("chunks", len(manifest.hashes)),
+		zap.Int("peers", len(providers)),
+	)
+	return nil
+}
+
+// chunkWorker 从单个节点循环领取并下载分块
+func (n *Node) chunkWorker(ctx context.Context, f *chunkFetch, peerID peer.ID) {
+	failures := 0
+	for {
+		index, attempt, finished := f.next(ctx, peerID)
+		if finished {
+			return
+		}
+		if attempt == nil {
+			// 没有可领取的分块，等待其他节点完成或出现慢分块
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+			continue
+		}
+
+		data, latency, err := n.fetchChunk(attempt.ctx, peerID, f, index)
+		if err != nil {
+			// 被其他节点抢先完成而取消的请求不算失败
+			if attempt.ctx.Err() == nil {
+				failures++
+				n.recordTransfer(peerID, err, latency)
+			}
+			f.fail(index, attempt, err)
+			n.logger.Debug("下载分块失败",
+				zap.String("peer", peerID.String()),
+				zap.Int("chunk", index),
+				zap.Error(err),
+			)
+			if failures >= maxPeerFailures || ctx.Err() != nil {
+				return
+			}
+			continue
+		}
+
+		failures = 0
+		n.recordTransfer(peerID, nil, latency)
+		if err := f.complete(index, attempt, data); err != nil {
+			return
+		}
+	}
+}
+
+// fetchChunk 下载并校验单个分块，返回数据和节点的响应延迟
+func (n *Node) fetchChunk(ctx context.Context, peerID peer.ID, f *chunkFetch, index int) ([]byte, time.Duration, error) {
+	offset, length := f.bounds(index)
+
+	start := time.Now()
+	reader, size, err := n.requestRangeFromPeer(ctx, peerID, f.digest, offset, length)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer reader.Close()
+	latency := time.Since(start)
+	if size != length {
+		return nil, latency, fmt.Errorf("分块大小不符: 期望 %d, 实际 %d", length, size)
+	}
+
+	// 请求被取消时中断读取
+	stop := context.AfterFunc(ctx, func() { reader.stream.Reset() })
+	defer stop()
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, latency, fmt.Errorf("接收分块失败: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	if actual := "sha256:" + hex.EncodeToString(sum[:]); actual != f.hashes[index] {
+		return nil, latency, fmt.Errorf("%w: 分块 %d", errCorrupted, index)
+	}
+	return data, latency, nil
+}
+
+// chunkAttempt 一次分块下载
+type chunkAttempt struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	peer    peer.ID
+	started time.Time
+}
+
+// chunkFetch 分块下载的调度状态
+type chunkFetch struct {
+	digest    string
+	chunkSize int64
+	size      int64
+	hashes    []string
+	file      *os.File
+
+	mu        sync.Mutex
+	pending   []int
+	inflight  map[int][]*chunkAttempt
+	done      []bool
+	retries   []int
+	remaining int
+	avg       time.Duration // 分块下载耗时的移动平均
+	err       error
+}
+
+// newChunkFetch 创建分块下载调度
+func newChunkFetch(digest string, chunkSize int64, manifest *chunkManifest, file *os.File) *chunkFetch {
+	count := len(manifest.hashes)
+	f := &chunkFetch{
+		digest:    digest,
+		chunkSize: chunkSize,
+		size:      manifest.size,
+		hashes:    manifest.hashes,
+		file:      file,
+		pending:   make([]int, count),
+		inflight:  make(map[int][]*chunkAttempt),
+		done:      make([]bool, count),
+		retries:   make([]int, count),
+		remaining: count,
+	}
+	for i := range f.pending {
+		f.pending[i] = i
+	}
+	return f
+}
+
+// bounds 返回分块的起始位置和长度
+func (f *chunkFetch) bounds(index int) (int64, int64) {
+	offset := int64(index) * f.chunkSize
+	return offset, min(f.chunkSize, f.size-offset)
+}
+
+// next 领取下一个分块。没有待下载分块时，重复请求其他节点上耗时过长的分块；
+// attempt 为 nil 且 finished 为 false 表示需要稍后再试。
+func (f *chunkFetch) next(ctx context.Context, peerID peer.ID) (int, *chunkAttempt, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.remaining == 0 || f.err != nil || ctx.Err() != nil {
+		return 0, nil, true
+	}
+
+	index := -1
+	if len(f.pending) > 0 {
+		index = f.pending[0]
+		f.pending = f.pending[1:]
+	} else {
+		delay := minStragglerDelay
+		if 3*f.avg > delay {
+			delay = 3 * f.avg
+		}
+		for i, attempts := range f.inflight {
+			if len(attempts) == 1 && attempts[0].peer != peerID && time.Since(attempts[0].started) > delay {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return 0, nil, false
+		}
+	}
+
+	attemptCtx, cancel := context.WithCancel(ctx)
+	attempt := &chunkAttempt{ctx: attemptCtx, cancel: cancel, peer: peerID, started: time.Now()}
+	f.inflight[index] = append(f.inflight[index], attempt)
+	return index, attempt, false
+}
+
+// complete 写入已校验的分块，并取消该分块的其他请求
+func (f *chunkFetch) complete(index int, attempt *chunkAttempt, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attempt.cancel()
+	if f.done[index] {
+		return nil
+	}
+
+	offset, _ := f.bounds(index)
+	if _, err := f.file.WriteAt(data, offset); err != nil {
+		f.err = fmt.Errorf("写入分块失败: %w", err)
+		return f.err
+	}
+
+	for _, other := range f.inflight[index] {
+		other.cancel()
+	}
+	delete(f.inflight, index)
+	f.done[index] = true
+	f.remaining--
+
+	elapsed := time.Since(attempt.started)
+	if f.avg == 0 {
+		f.avg = elapsed
+	} else {
+		f.avg = (f.avg*4 + elapsed) / 5
+	}
+	return nil
+}
+
+// fail 记录分块下载失败，没有其他请求进行中时重新排队
+func (f *chunkFetch) fail(index int, attempt *chunkAttempt, cause error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	attempt.cancel()
+	attempts := f.inflight[index]
+	for i, a := range attempts {
+		if a == attempt {
+			attempts = append(attempts[:i], attempts[i+1:]...)
+			break
+		}
+	}
+	if len(attempts) > 0 {
+		f.inflight[index] = attempts
+	} else {
+		delete(f.inflight, index)
+	}
+
+	if f.done[index] || len(attempts) > 0 {
+		return
+	}
+
+	f.retries[index]++
+	if f.retries[index] > maxChunkRetries {
+		f.err = fmt.Errorf("分块 %d 下载失败: %w", index, cause)
+		return
+	}
+	// 优先重试失败的分块
+	f.pending = append([]int{index}, f.pending...)
+}
+
+// result 返回下载结果
+func (f *chunkFetch) result() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	if f.remaining > 0 {
+		return fmt.Errorf("仍有 %d 个分块未下载", f.remaining)
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -13,10 +15,13 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	"github.com/multiformats/go-multiaddr"
 	"go.uber.org/zap"
 )
@@ -34,17 +39,21 @@ const (
 
 // Config P2P节点配置
 type Config struct {
-	Enabled          bool     `yaml:"enabled" json:"enabled"`
-	ListenPort       int      `yaml:"listen_port" json:"listen_port"`
-	BootstrapPeers   []string `yaml:"bootstrap_peers" json:"bootstrap_peers"`
-	MaxConnections   int      `yaml:"max_connections" json:"max_connections"`
-	EnableRelay      bool     `yaml:"enable_relay" json:"enable_relay"`
-	EnableNATPortMap bool     `yaml:"enable_nat_port_map" json:"enable_nat_port_map"`
-	DataDir          string   `yaml:"data_dir" json:"data_dir"`
-	ShareMode        string   `yaml:"share_mode" json:"share_mode"` // all/selective/none
-	BandwidthLimit   string   `yaml:"bandwidth_limit" json:"bandwidth_limit"`
-	EnableMDNS       bool     `yaml:"enable_mdns" json:"enable_mdns"`
-	PrivateKeyPath   string   `yaml:"private_key_path" json:"private_key_path"`
+	Enabled          bool     `yaml:"enabled" mapstructure:"enabled" json:"enabled"`
+	ListenPort       int      `yaml:"listen_port" mapstructure:"listen_port" json:"listen_port"`
+	BootstrapPeers   []string `yaml:"bootstrap_peers" mapstructure:"bootstrap_peers" json:"bootstrap_peers"`
+	MaxConnections   int      `yaml:"max_connections" mapstructure:"max_connections" json:"max_connections"`
+	EnableRelay      bool     `yaml:"enable_relay" mapstructure:"enable_relay" json:"enable_relay"`
+	EnableNATPortMap bool     `yaml:"enable_nat_port_map" mapstructure:"enable_nat_port_map" json:"enable_nat_port_map"`
+	DataDir          string   `yaml:"data_dir" mapstructure:"data_dir" json:"data_dir"`
+	ShareMode        string   `yaml:"share_mode" mapstructure:"share_mode" json:"share_mode"` // all/selective/none
+	BandwidthLimit   string   `yaml:"bandwidth_limit" mapstructure:"bandwidth_limit" json:"bandwidth_limit"`
+	PeerBandwidth    string   `yaml:"peer_bandwidth_limit" mapstructure:"peer_bandwidth_limit" json:"peer_bandwidth_limit"` // 单节点限速
+	EnableMDNS       bool     `yaml:"enable_mdns" mapstructure:"enable_mdns" json:"enable_mdns"`
+	PrivateKeyPath   string   `yaml:"private_key_path" mapstructure:"private_key_path" json:"private_key_path"`
+	ChunkSize        string   `yaml:"chunk_size" mapstructure:"chunk_size" json:"chunk_size"`             // 分块并行下载的分块大小，如 4MB
+	SwarmKeyPath     string   `yaml:"swarm_key_path" mapstructure:"swarm_key_path" json:"swarm_key_path"` // 私有网络密钥文件
+	SwarmKey         string   `yaml:"swarm_key" mapstructure:"swarm_key" json:"-"`                        // 内联的私有网络密钥
 }
 
 // DefaultConfig 返回默认配置
@@ -60,6 +69,7 @@ func DefaultConfig() *Config {
 		ShareMode:        "selective",
 		BandwidthLimit:   "100Mbps",
 		EnableMDNS:       true,
+		ChunkSize:        "4MB",
 	}
 }
 
@@ -78,6 +88,19 @@ type Node struct {
 	handlersMu sync.RWMutex
 	stats      *NodeStats
 	statsMu    sync.RWMutex
+	bwCounter  *metrics.BandwidthCounter
+	connMgr    *connmgr.BasicConnMgr
+	limiter    *bandwidthLimiter
+
+	shareFilter   ShareFilter
+	onShareDenied ShareDeniedFunc
+	shareMu       sync.RWMutex
+
+	chunkCache map[string]chunkManifest
+	chunkMu    sync.Mutex
+
+	reputation *reputationBook
+	swarmKeyFP string
 }
 
 // PeerInfo 对等节点信息
@@ -90,6 +113,9 @@ type PeerInfo struct {
 	BytesReceived int64
 	Latency       time.Duration
 	Version       string
+	RateIn        float64 // 当前接收速率，字节/秒
+	RateOut       float64 // 当前发送速率，字节/秒
+	Score         float64 // 信誉分数
 }
 
 // NodeStats 节点统计信息
@@ -104,6 +130,9 @@ type NodeStats struct {
 	StartTime       time.Time     `json:"start_time"`
 	NATStatus       string        `json:"nat_status"`
 	PublicAddresses []string      `json:"public_addresses"`
+	RateIn          float64       `json:"rate_in"`         // 当前接收速率，字节/秒
+	RateOut         float64       `json:"rate_out"`        // 当前发送速率，字节/秒
+	BandwidthLimit  int64         `json:"bandwidth_limit"` // 全局限速，字节/秒，0 表示不限
 }
 
 // BlobStore Blob存储接口
@@ -134,7 +163,10 @@ func NewNode(config *Config, blobStore BlobStore, logger *zap.Logger) (*Node, er
 		stats: &NodeStats{
 			StartTime: time.Now(),
 		},
+		bwCounter:  metrics.NewBandwidthCounter(),
+		chunkCache: make(map[string]chunkManifest),
 	}
+	node.reputation = newReputationBook(node.reputationPath())
 
 	return node, nil
 }
@@ -154,18 +186,74 @@ func (n *Node) Start() error {
 		return fmt.Errorf("加载密钥失败: %w", err)
 	}
 
+	// 连接管理器，连接数超过上限时裁剪到低水位
+	maxConns := n.config.MaxConnections
+	if maxConns <= 0 {
+		maxConns = DefaultConfig().MaxConnections
+	}
+	lowWater := maxConns * 4 / 5
+	if lowWater < 1 {
+		lowWater = 1
+	}
+	cm, err := connmgr.NewConnManager(lowWater, maxConns, connmgr.WithGracePeriod(time.Minute))
+	if err != nil {
+		return fmt.Errorf("创建连接管理器失败: %w", err)
+	}
+	n.connMgr = cm
+
+	// 带宽限制
+	globalRate, err := ParseBandwidth(n.config.BandwidthLimit)
+	if err != nil {
+		n.logger.Warn("带宽限制配置无效，不限速", zap.Error(err))
+	}
+	peerRate, err := ParseBandwidth(n.config.PeerBandwidth)
+	if err != nil {
+		n.logger.Warn("单节点带宽限制配置无效，不限速", zap.Error(err))
+	}
+	n.limiter = newBandwidthLimiter(globalRate, peerRate)
+	n.statsMu.Lock()
+	n.stats.BandwidthLimit = globalRate
+	n.statsMu.Unlock()
+
+	// 加载节点信誉，被拉黑的节点由连接过滤器拒绝
+	if err := n.reputation.load(); err != nil {
+		n.logger.Warn("加载节点信誉失败", zap.Error(err))
+	}
+
+	// 私有网络密钥
+	psk, err := n.loadSwarmKey()
+	if err != nil {
+		return err
+	}
+
+	listenAddrs := []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", n.config.ListenPort),
+		fmt.Sprintf("/ip6/::/tcp/%d", n.config.ListenPort),
+	}
+	transports := libp2p.DefaultTransports
+	n.swarmKeyFP = ""
+	if psk != nil {
+		// QUIC 不支持私有网络，只使用 TCP
+		transports = libp2p.Transport(tcp.NewTCPTransport)
+		n.swarmKeyFP = swarmKeyFingerprint(psk)
+		n.logger.Info("已启用P2P私有网络", zap.String("fingerprint", n.swarmKeyFP))
+	} else {
+		listenAddrs = append(listenAddrs, fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", n.config.ListenPort))
+	}
+
 	// 构建libp2p选项
 	opts := []libp2p.Option{
 		libp2p.Identity(priv),
-		libp2p.ListenAddrStrings(
-			fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", n.config.ListenPort),
-			fmt.Sprintf("/ip6/::/tcp/%d", n.config.ListenPort),
-			fmt.Sprintf("/ip4/0.0.0.0/udp/%d/quic-v1", n.config.ListenPort),
-		),
-		libp2p.DefaultTransports,
+		libp2p.ListenAddrStrings(listenAddrs...),
+		transports,
 		libp2p.DefaultMuxers,
 		libp2p.DefaultSecurity,
-		libp2p.ConnectionManager(nil), // 使用默认连接管理器
+		libp2p.ConnectionManager(cm),
+		libp2p.BandwidthReporter(n.bwCounter),
+		libp2p.ConnectionGater(&reputationGater{book: n.reputation}),
+	}
+	if psk != nil {
+		opts = append(opts, libp2p.PrivateNetwork(psk))
 	}
 
 	// NAT穿透
@@ -227,6 +315,7 @@ func (n *Node) Start() error {
 func (n *Node) Stop() error {
 	n.logger.Info("正在停止P2P节点...")
 	n.cancel()
+	n.saveReputation()
 
 	if n.dht != nil {
 		if err := n.dht.Close(); err != nil {
@@ -240,17 +329,61 @@ func (n *Node) Stop() error {
 		}
 	}
 
+	if n.connMgr != nil {
+		n.connMgr.Close()
+	}
+
 	n.logger.Info("P2P节点已停止")
 	return nil
 }
 
-// loadOrGenerateKey 加载或生成密钥
+// keyPath 返回节点身份密钥的保存路径
+func (n *Node) keyPath() string {
+	if n.config.PrivateKeyPath != "" {
+		return n.config.PrivateKeyPath
+	}
+	dataDir := n.config.DataDir
+	if dataDir == "" {
+		dataDir = "./data/p2p"
+	}
+	return filepath.Join(dataDir, "identity.key")
+}
+
+// loadOrGenerateKey 加载或生成密钥。密钥保存后节点ID在重启后保持不变，
+// 其他节点的引导列表才能持续有效。
 func (n *Node) loadOrGenerateKey() (crypto.PrivKey, error) {
+	path := n.keyPath()
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		priv, err := crypto.UnmarshalPrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("解析密钥文件失败 %s: %w", path, err)
+		}
+		return priv, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+
 	// 生成新密钥
 	priv, _, err := crypto.GenerateKeyPairWithReader(crypto.Ed25519, -1, rand.Reader)
 	if err != nil {
 		return nil, err
 	}
+
+	data, err = crypto.MarshalPrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("创建密钥目录失败: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("保存密钥失败: %w", err)
+	}
+
+	n.logger.Info("已生成P2P节点密钥", zap.String("path", path))
 	return priv, nil
 }
 
@@ -346,6 +479,7 @@ func (n *Node) removePeer(id peer.ID) {
 		delete(n.peers, id)
 		n.stats.PeerCount--
 	}
+	n.limiter.forget(id)
 }
 
 // backgroundTasks 后台任务
@@ -360,6 +494,7 @@ func (n *Node) backgroundTasks() {
 		case <-ticker.C:
 			n.updateStats()
 			n.cleanupStaleConnections()
+			n.saveReputation()
 		}
 	}
 }
@@ -383,6 +518,12 @@ func (n *Node) updateStats() {
 	n.stats.NATStatus = n.detectNATStatus()
 }
 
+// bandwidthTotals 返回当前收发速率
+func (n *Node) bandwidthTotals() (rateIn, rateOut float64) {
+	totals := n.bwCounter.GetBandwidthTotals()
+	return totals.RateIn, totals.RateOut
+}
+
 // detectNATStatus 检测NAT状态
 func (n *Node) detectNATStatus() string {
 	// 简化的NAT检测
@@ -421,6 +562,7 @@ func (n *Node) cleanupStaleConnections() {
 			if n.host.Network().Connectedness(id) != network.Connected {
 				delete(n.peers, id)
 				n.stats.PeerCount--
+				n.limiter.forget(id)
 			}
 		}
 	}
@@ -432,6 +574,7 @@ func (n *Node) GetStats() *NodeStats {
 	defer n.statsMu.RUnlock()
 
 	stats := *n.stats
+	stats.RateIn, stats.RateOut = n.bandwidthTotals()
 	return &stats
 }
 
@@ -443,6 +586,12 @@ func (n *Node) GetPeers() []*PeerInfo {
 	peers := make([]*PeerInfo, 0, len(n.peers))
 	for _, p := range n.peers {
 		info := *p
+		bw := n.bwCounter.GetBandwidthForPeer(p.ID)
+		info.RateIn, info.RateOut = bw.RateIn, bw.RateOut
+		if n.host != nil {
+			info.Latency = n.host.Peerstore().LatencyEWMA(p.ID)
+		}
+		info.Score = n.reputation.scoreOf(p.ID)
 		peers = append(peers, &info)
 	}
 	return peers
@@ -470,6 +619,21 @@ func (n *Node) Addresses() []string {
 	return result
 }
 
+// Multiaddrs 获取包含节点ID的完整地址，可直接用作其他节点的引导地址
+func (n *Node) Multiaddrs() []string {
+	if n.host == nil {
+		return nil
+	}
+
+	id := n.host.ID().String()
+	addrs := n.host.Addrs()
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.String()+"/p2p/"+id)
+	}
+	return result
+}
+
 // IsEnabled 检查P2P是否启用
 func (n *Node) IsEnabled() bool {
 	return n.config.Enabled && n.host != nil
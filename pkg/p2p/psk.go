@@ -0,0 +1,53 @@
+// Package p2p 提供私有网络预共享密钥
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/libp2p/go-libp2p/core/pnet"
+)
+
+// loadSwarmKey 加载私有网络密钥，优先使用密钥文件，其次使用内联配置；
+// 都未配置时返回 nil，节点加入公共网络。
+func (n *Node) loadSwarmKey() (pnet.PSK, error) {
+	var data []byte
+	switch {
+	case n.config.SwarmKeyPath != "":
+		content, err := os.ReadFile(n.config.SwarmKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("读取私有网络密钥失败: %w", err)
+		}
+		data = content
+	case strings.TrimSpace(n.config.SwarmKey) != "":
+		data = []byte(strings.TrimSpace(n.config.SwarmKey) + "\n")
+	default:
+		return nil, nil
+	}
+
+	psk, err := pnet.DecodeV1PSK(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("解析私有网络密钥失败: %w", err)
+	}
+	return psk, nil
+}
+
+// swarmKeyFingerprint 返回密钥指纹，用于核对各节点是否使用同一密钥
+func swarmKeyFingerprint(psk pnet.PSK) string {
+	sum := sha256.Sum256(psk)
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsPrivate 检查节点是否运行在私有网络中
+func (n *Node) IsPrivate() bool {
+	return n.swarmKeyFP != ""
+}
+
+// SwarmKeyFingerprint 返回私有网络密钥指纹，公共网络时为空
+func (n *Node) SwarmKeyFingerprint() string {
+	return n.swarmKeyFP
+}
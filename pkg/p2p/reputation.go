@@ -0,0 +1,368 @@
+// Package p2p 提供节点信誉和黑名单
+package p2p
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"go.uber.org/zap"
+)
+
+const (
+	// corruptionBanThreshold 数据损坏次数达到后自动拉黑
+	corruptionBanThreshold = 3
+	// corruptionBanDuration 数据损坏导致的拉黑时长
+	corruptionBanDuration = 24 * time.Hour
+	// failureBanMinTransfers 按成功率拉黑前至少需要的传输次数
+	failureBanMinTransfers = 10
+	// failureBanRate 成功率低于该值时自动拉黑
+	failureBanRate = 0.2
+	// failureBanDuration 成功率过低导致的拉黑时长
+	failureBanDuration = time.Hour
+)
+
+// errCorrupted 节点返回的数据摘要校验失败
+var errCorrupted = errors.New("数据摘要校验失败")
+
+// PeerReputation 节点信誉记录
+type PeerReputation struct {
+	PeerID      string    `json:"peer_id"`
+	Successes   int64     `json:"successes"`
+	Failures    int64     `json:"failures"`
+	Corruptions int64     `json:"corruptions"`
+	LatencyMs   float64   `json:"latency_ms"` // 响应延迟的移动平均
+	Score       float64   `json:"score"`      // 0-1，越高越优先
+	Banned      bool      `json:"banned"`
+	BanReason   string    `json:"ban_reason,omitempty"`
+	BannedUntil time.Time `json:"banned_until,omitempty"` // 零值表示永久
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// score 按成功率和延迟计算分数，损坏记录额外扣分
+func (r *PeerReputation) score() float64 {
+	// 平滑处理，新节点从 0.5 开始
+	rate := float64(r.Successes+1) / float64(r.Successes+r.Failures+2)
+	latencyFactor := 1.0
+	if r.LatencyMs > 0 {
+		latencyFactor = 1 / (1 + r.LatencyMs/1000)
+	}
+	penalty := 1 / float64(1+r.Corruptions)
+	return rate * (0.5 + 0.5*latencyFactor) * penalty
+}
+
+// isBanned 检查拉黑是否仍然有效
+func (r *PeerReputation) isBanned(now time.Time) bool {
+	return r.Banned && (r.BannedUntil.IsZero() || now.Before(r.BannedUntil))
+}
+
+// reputationBook 节点信誉表，定期保存到数据目录
+type reputationBook struct {
+	path    string
+	records map[peer.ID]*PeerReputation
+	dirty   bool
+	mu      sync.RWMutex
+}
+
+// newReputationBook 创建信誉表
+func newReputationBook(path string) *reputationBook {
+	return &reputationBook{
+		path:    path,
+		records: make(map[peer.ID]*PeerReputation),
+	}
+}
+
+// load 从文件加载信誉记录，文件不存在时忽略
+func (b *reputationBook) load() error {
+	data, err := os.ReadFile(b.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var records []*PeerReputation
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("解析信誉文件失败: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range records {
+		id, err := peer.Decode(r.PeerID)
+		if err != nil {
+			continue
+		}
+		b.records[id] = r
+	}
+	return nil
+}
+
+// save 有改动时保存信誉记录
+func (b *reputationBook) save() error {
+	b.mu.Lock()
+	if !b.dirty {
+		b.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(b.listLocked(), "", "  ")
+	b.dirty = false
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(b.path, data, 0600)
+}
+
+// get 获取或创建节点记录，调用方需持有写锁
+func (b *reputationBook) get(id peer.ID) *PeerReputation {
+	r, ok := b.records[id]
+	if !ok {
+		r = &PeerReputation{PeerID: id.String()}
+		b.records[id] = r
+	}
+	return r
+}
+
+// record 记录一次传输结果，返回本次是否触发自动拉黑
+func (b *reputationBook) record(id peer.ID, err error, latency time.Duration) (bool, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.get(id)
+	now := time.Now()
+	switch {
+	case err == nil:
+		r.Successes++
+	case errors.Is(err, errCorrupted):
+		r.Failures++
+		r.Corruptions++
+	default:
+		r.Failures++
+	}
+	if latency > 0 {
+		ms := float64(latency) / float64(time.Millisecond)
+		if r.LatencyMs == 0 {
+			r.LatencyMs = ms
+		} else {
+			r.LatencyMs = r.LatencyMs*0.8 + ms*0.2
+		}
+	}
+	r.Score = r.score()
+	r.UpdatedAt = now
+	b.dirty = true
+
+	if r.isBanned(now) {
+		return false, ""
+	}
+
+	var reason string
+	var duration time.Duration
+	total := r.Successes + r.Failures
+	switch {
+	case r.Corruptions >= corruptionBanThreshold:
+		reason, duration = fmt.Sprintf("数据损坏 %d 次", r.Corruptions), corruptionBanDuration
+	case total >= failureBanMinTransfers && float64(r.Successes)/float64(total) < failureBanRate:
+		reason, duration = fmt.Sprintf("传输成功率过低 (%d/%d)", r.Successes, total), failureBanDuration
+	default:
+		return false, ""
+	}
+
+	r.Banned = true
+	r.BanReason = reason
+	r.BannedUntil = now.Add(duration)
+	return true, reason
+}
+
+// ban 拉黑节点，duration 为 0 表示永久
+func (b *reputationBook) ban(id peer.ID, reason string, duration time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r := b.get(id)
+	r.Banned = true
+	r.BanReason = reason
+	r.BannedUntil = time.Time{}
+	if duration > 0 {
+		r.BannedUntil = time.Now().Add(duration)
+	}
+	r.UpdatedAt = time.Now()
+	b.dirty = true
+}
+
+// unban 解除拉黑并清零损坏计数，避免立即再次被自动拉黑
+func (b *reputationBook) unban(id peer.ID) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	r, ok := b.records[id]
+	if !ok || !r.Banned {
+		return false
+	}
+	r.Banned = false
+	r.BanReason = ""
+	r.BannedUntil = time.Time{}
+	r.Corruptions = 0
+	r.Successes, r.Failures = 0, 0
+	r.Score = r.score()
+	r.UpdatedAt = time.Now()
+	b.dirty = true
+	return true
+}
+
+// banned 检查节点是否被拉黑
+func (b *reputationBook) banned(id peer.ID) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	r, ok := b.records[id]
+	return ok && r.isBanned(time.Now())
+}
+
+// scoreOf 返回节点分数，没有记录时为新节点的默认分数
+func (b *reputationBook) scoreOf(id peer.ID) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if r, ok := b.records[id]; ok {
+		return r.Score
+	}
+	return (&PeerReputation{}).score()
+}
+
+// list 返回所有记录，按分数从高到低排序
+func (b *reputationBook) list() []*PeerReputation {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.listLocked()
+}
+
+func (b *reputationBook) listLocked() []*PeerReputation {
+	now := time.Now()
+	result := make([]*PeerReputation, 0, len(b.records))
+	for _, r := range b.records {
+		copied := *r
+		copied.Banned = r.isBanned(now)
+		result = append(result, &copied)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Score > result[j].Score
+	})
+	return result
+}
+
+// reputationPath 信誉文件路径
+func (n *Node) reputationPath() string {
+	dataDir := n.config.DataDir
+	if dataDir == "" {
+		dataDir = "./data/p2p"
+	}
+	return filepath.Join(dataDir, "reputation.json")
+}
+
+// recordTransfer 记录与节点的传输结果，触发自动拉黑时断开连接
+func (n *Node) recordTransfer(id peer.ID, err error, latency time.Duration) {
+	banned, reason := n.reputation.record(id, err, latency)
+	if !banned {
+		return
+	}
+
+	n.logger.Warn("节点已被自动拉黑", zap.String("peer", id.String()), zap.String("reason", reason))
+	n.disconnectBanned(id)
+}
+
+// disconnectBanned 断开被拉黑节点的连接
+func (n *Node) disconnectBanned(id peer.ID) {
+	if n.host == nil {
+		return
+	}
+	n.host.Network().ClosePeer(id)
+	n.removePeer(id)
+}
+
+// BanPeer 手动拉黑节点，duration 为 0 表示永久
+func (n *Node) BanPeer(id string, reason string, duration time.Duration) error {
+	peerID, err := peer.Decode(id)
+	if err != nil {
+		return fmt.Errorf("无效的节点ID: %w", err)
+	}
+	if n.host != nil && peerID == n.host.ID() {
+		return fmt.Errorf("不能拉黑本节点")
+	}
+	if reason == "" {
+		reason = "手动拉黑"
+	}
+
+	n.reputation.ban(peerID, reason, duration)
+	n.disconnectBanned(peerID)
+	n.saveReputation()
+
+	n.logger.Info("已拉黑节点", zap.String("peer", id), zap.String("reason", reason))
+	return nil
+}
+
+// UnbanPeer 解除节点拉黑
+func (n *Node) UnbanPeer(id string) error {
+	peerID, err := peer.Decode(id)
+	if err != nil {
+		return fmt.Errorf("无效的节点ID: %w", err)
+	}
+	if !n.reputation.unban(peerID) {
+		return fmt.Errorf("节点未被拉黑: %s", id)
+	}
+	n.saveReputation()
+
+	n.logger.Info("已解除节点拉黑", zap.String("peer", id))
+	return nil
+}
+
+// Reputations 获取所有节点的信誉记录
+func (n *Node) Reputations() []*PeerReputation {
+	return n.reputation.list()
+}
+
+// saveReputation 保存信誉记录
+func (n *Node) saveReputation() {
+	if err := n.reputation.save(); err != nil {
+		n.logger.Warn("保存节点信誉失败", zap.Error(err))
+	}
+}
+
+// reputationGater 拒绝与被拉黑节点建立连接
+type reputationGater struct {
+	book *reputationBook
+}
+
+func (g *reputationGater) InterceptPeerDial(p peer.ID) bool {
+	return !g.book.banned(p)
+}
+
+func (g *reputationGater) InterceptAddrDial(p peer.ID, _ multiaddr.Multiaddr) bool {
+	return !g.book.banned(p)
+}
+
+func (g *reputationGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *reputationGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return !g.book.banned(p)
+}
+
+func (g *reputationGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
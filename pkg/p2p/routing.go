@@ -0,0 +1,240 @@
+// Package p2p 提供基于DHT的内容路由
+package p2p
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mh "github.com/multiformats/go-multihash"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxProviders 每次查找的最大提供者数量
+	maxProviders = 10
+	// findProvidersTimeout DHT查找超时
+	findProvidersTimeout = 10 * time.Second
+)
+
+// digestCID 将 sha256:<hex> 摘要转换为DHT使用的CID
+func digestCID(digest string) (cid.Cid, error) {
+	hexHash := strings.TrimPrefix(digest, "sha256:")
+	if hexHash == digest {
+		return cid.Undef, fmt.Errorf("不支持的摘要算法: %s", digest)
+	}
+
+	raw, err := hex.DecodeString(hexHash)
+	if err != nil || len(raw) != sha256.Size {
+		return cid.Undef, fmt.Errorf("无效的摘要: %s", digest)
+	}
+
+	hash, err := mh.Encode(raw, mh.SHA2_256)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, hash), nil
+}
+
+// AnnounceBlob 通过DHT宣布本节点可以提供某个Blob
+func (n *Node) AnnounceBlob(ctx context.Context, digest string) error {
+	if !n.IsEnabled() || n.dht == nil {
+		return nil
+	}
+	// 不可分享的Blob不在DHT中宣布
+	if !n.shareable(digest) {
+		return nil
+	}
+
+	key, err := digestCID(digest)
+	if err != nil {
+		return err
+	}
+
+	if err := n.dht.Provide(ctx, key, true); err != nil {
+		return fmt.Errorf("宣布Blob失败: %w", err)
+	}
+
+	n.logger.Debug("已宣布Blob", zap.String("digest", digest))
+	return nil
+}
+
+// FindProviders 查找可以提供某个Blob的节点，按信誉分数从高到低排序，
+// 分数相同时按延迟排序，被拉黑的节点不会返回。先查询DHT，未找到时询问已连接的节点。
+func (n *Node) FindProviders(ctx context.Context, digest string) []peer.ID {
+	if !n.IsEnabled() {
+		return nil
+	}
+
+	seen := make(map[peer.ID]bool)
+	var providers []peer.ID
+
+	if key, err := digestCID(digest); err == nil && n.dht != nil {
+		findCtx, cancel := context.WithTimeout(ctx, findProvidersTimeout)
+		for info := range n.dht.FindProvidersAsync(findCtx, key, maxProviders) {
+			if info.ID == n.host.ID() || seen[info.ID] || n.reputation.banned(info.ID) {
+				continue
+			}
+			seen[info.ID] = true
+			if len(info.Addrs) > 0 {
+				n.host.Peerstore().AddAddrs(info.ID, info.Addrs, time.Hour)
+			}
+			providers = append(providers, info.ID)
+		}
+		cancel()
+	}
+
+	if len(providers) == 0 {
+		for _, peerID := range n.host.Network().Peers() {
+			if seen[peerID] || n.reputation.banned(peerID) {
+				continue
+			}
+			if has, err := n.queryBlobFromPeer(ctx, peerID, digest); err == nil && has {
+				providers = append(providers, peerID)
+			}
+		}
+	}
+
+	// 延迟未知的节点排在最后
+	latency := func(id peer.ID) time.Duration {
+		if l := n.host.Peerstore().LatencyEWMA(id); l > 0 {
+			return l
+		}
+		return time.Hour
+	}
+	sort.SliceStable(providers, func(i, j int) bool {
+		si, sj := n.reputation.scoreOf(providers[i]), n.reputation.scoreOf(providers[j])
+		if si != sj {
+			return si > sj
+		}
+		return latency(providers[i]) < latency(providers[j])
+	})
+
+	return providers
+}
+
+// FetchBlob 从最快的提供者节点下载Blob，校验摘要后存入本地Blob存储，
+// 并宣布本节点也可提供该Blob。
+func (n *Node) FetchBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
+	if !n.IsEnabled() {
+		return nil, 0, fmt.Errorf("P2P未启用")
+	}
+	if _, err := digestCID(digest); err != nil {
+		return nil, 0, err
+	}
+
+	providers := n.FindProviders(ctx, digest)
+	if len(providers) == 0 {
+		return nil, 0, fmt.Errorf("没有节点提供Blob: %s", digest)
+	}
+
+	if err := n.fetchFromProviders(ctx, providers, digest); err != nil {
+		return nil, 0, err
+	}
+
+	go func() {
+		announceCtx, cancel := context.WithTimeout(n.ctx, time.Minute)
+		defer cancel()
+		if err := n.AnnounceBlob(announceCtx, digest); err != nil {
+			n.logger.Debug("宣布Blob失败", zap.String("digest", digest), zap.Error(err))
+		}
+	}()
+
+	return n.blobStore.Get(digest)
+}
+
+// fetchFromProviders 多个提供者时分块并行下载，失败后退回逐个节点完整下载
+func (n *Node) fetchFromProviders(ctx context.Context, providers []peer.ID, digest string) error {
+	if len(providers) > 1 {
+		err := n.fetchChunked(ctx, providers, digest)
+		if err == nil {
+			return nil
+		}
+		n.logger.Debug("分块下载失败", zap.String("digest", digest), zap.Error(err))
+	}
+
+	for _, peerID := range providers {
+		if err := n.fetchFromPeer(ctx, peerID, digest); err != nil {
+			n.logger.Debug("从peer获取Blob失败",
+				zap.String("peer", peerID.String()),
+				zap.String("digest", digest),
+				zap.Error(err),
+			)
+			continue
+		}
+		return nil
+	}
+
+	return fmt.Errorf("无法从P2P网络获取Blob: %s", digest)
+}
+
+// fetchFromPeer 从指定节点下载Blob到临时文件，摘要校验通过后写入本地存储，
+// 下载结果计入节点信誉
+func (n *Node) fetchFromPeer(ctx context.Context, peerID peer.ID, digest string) error {
+	tmp, err := os.CreateTemp("", "p2p-blob-*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	latency, size, err := n.downloadFromPeer(ctx, peerID, digest, tmp)
+	if ctx.Err() == nil {
+		n.recordTransfer(peerID, err, latency)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return n.blobStore.Put(digest, tmp, size)
+}
+
+// downloadFromPeer 下载Blob到 dst 并校验摘要，返回节点的响应延迟和Blob大小
+func (n *Node) downloadFromPeer(ctx context.Context, peerID peer.ID, digest string, dst io.Writer) (time.Duration, int64, error) {
+	if n.host.Network().Connectedness(peerID) != network.Connected {
+		if err := n.host.Connect(ctx, n.host.Peerstore().PeerInfo(peerID)); err != nil {
+			return 0, 0, fmt.Errorf("连接失败: %w", err)
+		}
+		n.addPeer(peerID, n.host.Peerstore().Addrs(peerID))
+	}
+
+	// 传输期间保护连接不被连接管理器裁剪
+	n.host.ConnManager().Protect(peerID, "blob-transfer")
+	defer n.host.ConnManager().Unprotect(peerID, "blob-transfer")
+
+	start := time.Now()
+	reader, size, err := n.requestBlobFromPeer(ctx, peerID, digest)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer reader.Close()
+	latency := time.Since(start)
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hash), io.LimitReader(reader, size))
+	if err != nil {
+		return latency, 0, fmt.Errorf("接收数据失败: %w", err)
+	}
+	if written != size {
+		return latency, 0, fmt.Errorf("数据不完整: 期望 %d, 实际 %d", size, written)
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != digest {
+		return latency, 0, fmt.Errorf("%w: 期望 %s, 实际 %s", errCorrupted, digest, actual)
+	}
+
+	return latency, size, nil
+}
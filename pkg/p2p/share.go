@@ -0,0 +1,62 @@
+// Package p2p 提供P2P分享控制
+package p2p
+
+import (
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// ShareModeAll 分享所有Blob
+	ShareModeAll = "all"
+	// ShareModeSelective 仅分享允许列表中的Blob
+	ShareModeSelective = "selective"
+	// ShareModeNone 不向其他节点分享
+	ShareModeNone = "none"
+)
+
+// ShareFilter 判断Blob是否允许分享给其他节点
+type ShareFilter func(digest string) bool
+
+// ShareDeniedFunc 在拒绝其他节点的Blob请求时调用
+type ShareDeniedFunc func(peerID, digest string)
+
+// SetShareFilter 设置选择性分享模式下的过滤器
+func (n *Node) SetShareFilter(f ShareFilter) {
+	n.shareMu.Lock()
+	defer n.shareMu.Unlock()
+	n.shareFilter = f
+}
+
+// SetShareDeniedHandler 设置拒绝请求时的回调
+func (n *Node) SetShareDeniedHandler(f ShareDeniedFunc) {
+	n.shareMu.Lock()
+	defer n.shareMu.Unlock()
+	n.onShareDenied = f
+}
+
+// shareable 按分享模式判断Blob是否可分享，未知模式按选择性分享处理
+func (n *Node) shareable(digest string) bool {
+	switch n.config.ShareMode {
+	case ShareModeAll:
+		return true
+	case ShareModeNone:
+		return false
+	}
+
+	n.shareMu.RLock()
+	filter := n.shareFilter
+	n.shareMu.RUnlock()
+
+	return filter != nil && filter(digest)
+}
+
+// shareDenied 记录被拒绝的请求
+func (n *Node) shareDenied(p peer.ID, digest string) {
+	n.shareMu.RLock()
+	fn := n.onShareDenied
+	n.shareMu.RUnlock()
+
+	if fn != nil {
+		fn(p.String(), digest)
+	}
+}
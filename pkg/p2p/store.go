@@ -6,8 +6,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
+	"cyp-docker-registry/pkg/compression"
+
 	"go.uber.org/zap"
 )
 
@@ -36,8 +39,7 @@ func (s *FileBlobStore) Has(digest string) (bool, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	path := s.blobPath(digest)
-	_, err := os.Stat(path)
+	_, err := compression.StatBlobFile(s.blobPath(digest))
 	if err == nil {
 		return true, nil
 	}
@@ -47,13 +49,12 @@ func (s *FileBlobStore) Has(digest string) (bool, error) {
 	return false, err
 }
 
-// Get 获取Blob
+// Get 获取Blob，仓库压缩存储的Blob会透明解压
 func (s *FileBlobStore) Get(digest string) (io.ReadCloser, int64, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	path := s.blobPath(digest)
-	info, err := os.Stat(path)
+	reader, size, err := compression.OpenBlobFile(s.blobPath(digest))
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, 0, fmt.Errorf("blob不存在: %s", digest)
@@ -61,12 +62,7 @@ func (s *FileBlobStore) Get(digest string) (io.ReadCloser, int64, error) {
 		return nil, 0, err
 	}
 
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	return file, info.Size(), nil
+	return reader, size, nil
 }
 
 // Put 存储Blob
@@ -116,8 +112,7 @@ func (s *FileBlobStore) Delete(digest string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	path := s.blobPath(digest)
-	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+	if err := compression.RemoveBlobFile(s.blobPath(digest)); err != nil {
 		return fmt.Errorf("删除Blob失败: %w", err)
 	}
 
@@ -131,6 +126,7 @@ func (s *FileBlobStore) List() ([]string, error) {
 	defer s.mu.RUnlock()
 
 	var digests []string
+	seen := make(map[string]bool)
 
 	err := filepath.Walk(s.basePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -151,7 +147,16 @@ func (s *FileBlobStore) List() ([]string, error) {
 			return nil
 		}
 
-		digests = append(digests, rel)
+		// 压缩存储的Blob去掉扩展名
+		name := filepath.Base(rel)
+		name = strings.TrimSuffix(strings.TrimSuffix(name, ".zst"), ".gz")
+		if !strings.Contains(name, ":") {
+			name = "sha256:" + name
+		}
+		if !seen[name] {
+			seen[name] = true
+			digests = append(digests, name)
+		}
 		return nil
 	})
 
@@ -187,13 +192,15 @@ func (s *FileBlobStore) Count() (int, error) {
 	return len(digests), nil
 }
 
-// blobPath 获取Blob文件路径
+// blobPath 获取Blob文件路径，与镜像仓库存储的目录布局一致，
+// 使节点可以直接共享仓库中的Blob
 func (s *FileBlobStore) blobPath(digest string) string {
-	// 使用digest的前两个字符作为子目录，避免单目录文件过多
-	if len(digest) > 2 {
-		return filepath.Join(s.basePath, digest[:2], digest)
+	// 使用哈希的前两个字符作为子目录，避免单目录文件过多
+	hash := strings.TrimPrefix(digest, "sha256:")
+	if len(hash) > 2 {
+		return filepath.Join(s.basePath, hash[:2], hash)
 	}
-	return filepath.Join(s.basePath, digest)
+	return filepath.Join(s.basePath, hash)
 }
 
 // MemoryBlobStore 内存Blob存储（用于测试）
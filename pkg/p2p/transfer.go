@@ -35,6 +35,8 @@ const (
 	MsgTypePing
 	// MsgTypePong Pong消息
 	MsgTypePong
+	// MsgTypeChunks 分块摘要查询
+	MsgTypeChunks
 )
 
 // Message P2P消息
@@ -43,6 +45,8 @@ type Message struct {
 	ID        string      `json:"id"`
 	Digest    string      `json:"digest,omitempty"`
 	Size      int64       `json:"size,omitempty"`
+	Offset    int64       `json:"offset,omitempty"` // 分块请求的起始位置
+	Length    int64       `json:"length,omitempty"` // 分块请求的长度，0 表示整个Blob
 	Data      []byte      `json:"data,omitempty"`
 	Error     string      `json:"error,omitempty"`
 	Timestamp int64       `json:"timestamp"`
@@ -85,6 +89,22 @@ func (n *Node) handleBlobStream(stream network.Stream) {
 		return
 	}
 
+	// 检查分享权限
+	if !n.shareable(msg.Digest) {
+		n.logger.Info("拒绝Blob请求", zap.String("from", remotePeer.String()), zap.String("digest", msg.Digest))
+		n.shareDenied(remotePeer, msg.Digest)
+		resp := &Message{
+			Type:      MsgTypeResponse,
+			ID:        msg.ID,
+			Digest:    msg.Digest,
+			Error:     "access denied",
+			Timestamp: time.Now().Unix(),
+		}
+		n.writeMessage(writer, resp)
+		writer.Flush()
+		return
+	}
+
 	// 检查是否有该Blob
 	has, err := n.blobStore.Has(msg.Digest)
 	if err != nil || !has {
@@ -117,12 +137,39 @@ func (n *Node) handleBlobStream(stream network.Stream) {
 	}
 	defer blobReader.Close()
 
-	// 发送成功响应
+	// 分块请求只发送指定范围
+	ranged := msg.Length > 0
+	if ranged {
+		if msg.Offset < 0 || msg.Offset >= size {
+			resp := &Message{
+				Type:      MsgTypeResponse,
+				ID:        msg.ID,
+				Digest:    msg.Digest,
+				Error:     "invalid range",
+				Timestamp: time.Now().Unix(),
+			}
+			n.writeMessage(writer, resp)
+			writer.Flush()
+			return
+		}
+		if err := skipTo(blobReader, msg.Offset); err != nil {
+			n.logger.Warn("定位Blob数据失败", zap.Error(err))
+			return
+		}
+		size -= msg.Offset
+		if msg.Length < size {
+			size = msg.Length
+		}
+	}
+
+	// 发送成功响应，Size为随后发送的字节数
 	resp := &Message{
 		Type:      MsgTypeResponse,
 		ID:        msg.ID,
 		Digest:    msg.Digest,
 		Size:      size,
+		Offset:    msg.Offset,
+		Length:    msg.Length,
 		Timestamp: time.Now().Unix(),
 	}
 	if err := n.writeMessage(writer, resp); err != nil {
@@ -131,18 +178,21 @@ func (n *Node) handleBlobStream(stream network.Stream) {
 	}
 	writer.Flush()
 
-	// 发送Blob数据
-	written, err := io.Copy(writer, blobReader)
+	// 发送Blob数据，受全局和单节点带宽限制
+	limited := &limitedWriter{ctx: n.ctx, w: writer, limiter: n.limiter, peer: remotePeer}
+	written, err := io.Copy(limited, io.LimitReader(blobReader, size))
 	if err != nil {
 		n.logger.Warn("发送Blob数据失败", zap.Error(err))
 		return
 	}
 	writer.Flush()
 
-	// 更新统计
+	// 更新统计，分块只计流量
 	n.statsMu.Lock()
 	n.stats.TotalBytesSent += written
-	n.stats.BlobsShared++
+	if !ranged {
+		n.stats.BlobsShared++
+	}
 	n.statsMu.Unlock()
 
 	// 更新peer统计
@@ -178,8 +228,9 @@ func (n *Node) handleMetaStream(stream network.Stream) {
 
 	switch msg.Type {
 	case MsgTypeHave:
-		// 查询是否有某个Blob
+		// 查询是否有某个Blob，不可分享的Blob按不存在处理
 		has, _ := n.blobStore.Has(msg.Digest)
+		has = has && n.shareable(msg.Digest)
 		resp := &Message{
 			Type:      MsgTypeResponse,
 			ID:        msg.ID,
@@ -193,6 +244,24 @@ func (n *Node) handleMetaStream(stream network.Stream) {
 		}
 		n.writeMessage(writer, resp)
 
+	case MsgTypeChunks:
+		// 查询Blob的分块摘要，Length为请求方的分块大小
+		resp := &Message{
+			Type:      MsgTypeResponse,
+			ID:        msg.ID,
+			Digest:    msg.Digest,
+			Timestamp: time.Now().Unix(),
+		}
+		if !n.shareable(msg.Digest) {
+			resp.Error = "access denied"
+		} else if hashes, size, err := n.chunkHashes(msg.Digest, msg.Length); err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Size = size
+			resp.Data, _ = json.Marshal(hashes)
+		}
+		n.writeMessage(writer, resp)
+
 	case MsgTypePing:
 		// Ping响应
 		resp := &Message{
@@ -224,36 +293,18 @@ func (n *Node) handleGeneralStream(stream network.Stream) {
 	n.peersMu.Unlock()
 }
 
-// RequestBlob 从P2P网络请求Blob
+// RequestBlob 从P2P网络请求Blob，数据经摘要校验并存入本地存储
 func (n *Node) RequestBlob(ctx context.Context, digest string) (io.ReadCloser, int64, error) {
-	if !n.IsEnabled() {
-		return nil, 0, fmt.Errorf("P2P未启用")
-	}
-
-	// 获取连接的peers
-	peers := n.host.Network().Peers()
-	if len(peers) == 0 {
-		return nil, 0, fmt.Errorf("没有可用的P2P节点")
-	}
-
-	// 尝试从每个peer获取
-	for _, peerID := range peers {
-		reader, size, err := n.requestBlobFromPeer(ctx, peerID, digest)
-		if err == nil {
-			return reader, size, nil
-		}
-		n.logger.Debug("从peer获取Blob失败",
-			zap.String("peer", peerID.String()),
-			zap.String("digest", digest),
-			zap.Error(err),
-		)
-	}
-
-	return nil, 0, fmt.Errorf("无法从P2P网络获取Blob: %s", digest)
+	return n.FetchBlob(ctx, digest)
 }
 
 // requestBlobFromPeer 从指定peer请求Blob
 func (n *Node) requestBlobFromPeer(ctx context.Context, peerID peer.ID, digest string) (io.ReadCloser, int64, error) {
+	return n.requestRangeFromPeer(ctx, peerID, digest, 0, 0)
+}
+
+// requestRangeFromPeer 从指定peer请求Blob的一段数据，length为0时请求整个Blob
+func (n *Node) requestRangeFromPeer(ctx context.Context, peerID peer.ID, digest string, offset, length int64) (*streamReader, int64, error) {
 	// 打开流
 	stream, err := n.host.NewStream(ctx, peerID, BlobProtocolID)
 	if err != nil {
@@ -268,6 +319,8 @@ func (n *Node) requestBlobFromPeer(ctx context.Context, peerID peer.ID, digest s
 		Type:      MsgTypeBlobRequest,
 		ID:        generateMessageID(),
 		Digest:    digest,
+		Offset:    offset,
+		Length:    length,
 		Timestamp: time.Now().Unix(),
 	}
 	if err := n.writeMessage(writer, req); err != nil {
@@ -295,6 +348,7 @@ func (n *Node) requestBlobFromPeer(ctx context.Context, peerID peer.ID, digest s
 		size:   resp.Size,
 		node:   n,
 		peer:   peerID,
+		ranged: length > 0,
 	}, resp.Size, nil
 }
 
@@ -306,12 +360,23 @@ type streamReader struct {
 	read   int64
 	node   *Node
 	peer   peer.ID
+	ranged bool // 分块读取不计入接收Blob数
 }
 
 func (r *streamReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunkSize {
+		p = p[:limitChunkSize]
+	}
 	n, err := r.reader.Read(p)
 	r.read += int64(n)
 
+	// 接收方向同样限速
+	if n > 0 {
+		if werr := r.node.limiter.wait(r.node.ctx, r.peer, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+
 	// 更新统计
 	if n > 0 {
 		r.node.statsMu.Lock()
@@ -329,7 +394,7 @@ func (r *streamReader) Read(p []byte) (int, error) {
 }
 
 func (r *streamReader) Close() error {
-	if r.read > 0 {
+	if r.read > 0 && !r.ranged {
 		r.node.statsMu.Lock()
 		r.node.stats.BlobsReceived++
 		r.node.statsMu.Unlock()
@@ -384,18 +449,6 @@ func (n *Node) queryBlobFromPeer(ctx context.Context, peerID peer.ID, digest str
 	return string(resp.Data) == "true", nil
 }
 
-// AnnounceBlob 向P2P网络宣布拥有某个Blob
-func (n *Node) AnnounceBlob(ctx context.Context, digest string) error {
-	if !n.IsEnabled() {
-		return nil
-	}
-
-	// 使用DHT提供内容
-	// 这里简化实现，实际应该使用CID
-	n.logger.Debug("宣布Blob", zap.String("digest", digest))
-	return nil
-}
-
 // readMessage 读取消息
 func (n *Node) readMessage(reader *bufio.Reader) (*Message, error) {
 	// 读取长度前缀
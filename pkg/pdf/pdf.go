@@ -0,0 +1,237 @@
+// Package pdf writes simple text documents as PDF.
+//
+// Documents use the standard Type 1 fonts every reader provides, so nothing
+// is embedded. Text is encoded as WinAnsi: characters outside Latin-1 are
+// written as '?'.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A4 page size and margins, in points.
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	margin       = 50.0
+	footerMargin = 30.0
+)
+
+// Font is one of the standard fonts of a document.
+type Font int
+
+const (
+	Helvetica Font = iota
+	HelveticaBold
+	Courier
+)
+
+var fontNames = []string{"Helvetica", "Helvetica-Bold", "Courier"}
+
+// Document is a PDF document being written page by page.
+type Document struct {
+	title   string
+	created time.Time
+	pages   []*bytes.Buffer
+	y       float64 // 当前行的基线位置
+}
+
+// New creates an empty document.
+func New(title string) *Document {
+	return &Document{title: title, created: time.Now()}
+}
+
+// Heading writes a line of bold text of the given size.
+func (d *Document) Heading(text string, size float64) {
+	d.Space(size * 0.4)
+	d.Paragraph(HelveticaBold, size, text)
+	d.Space(size * 0.2)
+}
+
+// Paragraph writes text wrapped to the page width.
+func (d *Document) Paragraph(font Font, size float64, text string) {
+	for _, line := range strings.Split(text, "\n") {
+		for _, wrapped := range wrap(font, size, line, pageWidth-2*margin) {
+			d.Line(font, size, wrapped)
+		}
+	}
+}
+
+// Line writes a single line, starting a new page when the current one is
+// full. Text that does not fit the page width is cut.
+func (d *Document) Line(font Font, size float64, text string) {
+	leading := size * 1.35
+	if len(d.pages) == 0 || d.y-leading < margin {
+		d.newPage()
+	}
+	d.y -= leading
+	fmt.Fprintf(d.pages[len(d.pages)-1], "BT /F%d %.1f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font+1, size, margin, d.y, encode(text))
+}
+
+// Space moves down by the given number of points.
+func (d *Document) Space(points float64) {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+	d.y -= points
+}
+
+// Rule draws a horizontal line across the page.
+func (d *Document) Rule() {
+	d.Space(4)
+	if d.y < margin {
+		d.newPage()
+	}
+	fmt.Fprintf(d.pages[len(d.pages)-1], "0.5 w %.2f %.2f m %.2f %.2f l S\n",
+		margin, d.y, pageWidth-margin, d.y)
+	d.Space(4)
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+	d.y = pageHeight - margin
+}
+
+// WriteTo writes the document, with a page number on every page.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	// 对象编号：1 目录，2 页面树，3 文档信息，4-6 字体，之后每页两个对象
+	const firstPage = 7
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object(fmt.Sprintf("<< /Title (%s) /Producer (CYP-Docker-Registry) /CreationDate (D:%s) >>",
+		encode(d.title), d.created.UTC().Format("20060102150405Z")))
+	for _, name := range fontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+	}
+	resources := "<< /Font << /F1 4 0 R /F2 5 0 R /F3 6 0 R >> >>"
+	for i, page := range d.pages {
+		footer := fmt.Sprintf("Page %d / %d", i+1, len(d.pages))
+		content := page.String() + fmt.Sprintf("BT /F1 8.0 Tf %.2f %.2f Td (%s) Tj ET\n",
+			pageWidth-margin-textWidth(Helvetica, 8, footer), footerMargin, footer)
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources %s /Contents %d 0 R >>",
+			pageWidth, pageHeight, resources, firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 3 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	n, err := w.Write(buf.Bytes())
+	return int64(n), err
+}
+
+// Bytes returns the written document.
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	d.WriteTo(&buf)
+	return buf.Bytes()
+}
+
+// encode converts text to a WinAnsi string literal body.
+func encode(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteByte(' ')
+		case r < 0x20:
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// wrap splits text into lines no wider than width, breaking at spaces.
+// Words wider than a line are split.
+func wrap(font Font, size float64, text string, width float64) []string {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return []string{""}
+	}
+	var lines []string
+	line := ""
+	for _, word := range words {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if textWidth(font, size, candidate) <= width {
+			line = candidate
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+		line = word
+		for textWidth(font, size, line) > width {
+			runes := []rune(line)
+			n := len(runes) - 1
+			for n > 1 && textWidth(font, size, string(runes[:n])) > width {
+				n--
+			}
+			lines = append(lines, string(runes[:n]))
+			line = string(runes[n:])
+		}
+	}
+	return append(lines, line)
+}
+
+// textWidth estimates the width of text. Courier is exact; Helvetica uses
+// the widths of character classes, which is close enough for wrapping.
+func textWidth(font Font, size float64, text string) float64 {
+	if font == Courier {
+		return float64(len([]rune(text))) * 0.6 * size
+	}
+	var units float64
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(" .,;:!|'ijlI", r):
+			units += 0.278
+		case strings.ContainsRune("frt()-[]", r):
+			units += 0.333
+		case strings.ContainsRune("mwMW@", r):
+			units += 0.889
+		case r >= 'A' && r <= 'Z':
+			units += 0.722
+		default:
+			units += 0.556
+		}
+	}
+	if font == HelveticaBold {
+		units *= 1.06
+	}
+	return units * size
+}
@@ -0,0 +1,116 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// PayloadTypeInToto is the DSSE payload type of in-toto statements.
+const PayloadTypeInToto = "application/vnd.in-toto+json"
+
+// Envelope is a DSSE envelope, the signed wrapper of in-toto attestations
+// (https://github.com/secure-systems-lab/dsse).
+type Envelope struct {
+	PayloadType string              `json:"payloadType"`
+	Payload     string              `json:"payload"` // base64
+	Signatures  []EnvelopeSignature `json:"signatures"`
+}
+
+// EnvelopeSignature is one signature of an envelope.
+type EnvelopeSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"` // base64
+}
+
+// DecodePayload returns the decoded payload of the envelope.
+func (e *Envelope) DecodePayload() ([]byte, error) {
+	if payload, err := base64.StdEncoding.DecodeString(e.Payload); err == nil {
+		return payload, nil
+	}
+	payload, err := base64.URLEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envelope payload: %w", err)
+	}
+	return payload, nil
+}
+
+// Verify reports whether a signature of the envelope was made with pub.
+func (e *Envelope) Verify(pub crypto.PublicKey) (bool, error) {
+	payload, err := e.DecodePayload()
+	if err != nil {
+		return false, err
+	}
+	message := PAE(e.PayloadType, payload)
+	for _, s := range e.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			if sig, err = base64.URLEncoding.DecodeString(s.Sig); err != nil {
+				continue
+			}
+		}
+		if verifyMessage(pub, message, sig) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// PAE returns the pre-authentication encoding of a payload, which DSSE
+// signatures cover.
+func PAE(payloadType string, payload []byte) []byte {
+	prefix := fmt.Sprintf("DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	return append([]byte(prefix), payload...)
+}
+
+// verifyMessage checks sig over message: ECDSA (ASN.1) and RSA
+// (PKCS #1 v1.5 or PSS) over its SHA-256, Ed25519 over the message.
+func verifyMessage(pub crypto.PublicKey, message, sig []byte) bool {
+	digest := sha256.Sum256(message)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil ||
+			rsa.VerifyPSS(key, crypto.SHA256, digest[:], sig, nil) == nil
+	}
+	return false
+}
+
+// ParsePublicKey parses a PEM public key (ECDSA, Ed25519 or RSA), given
+// inline or as the path of a key file.
+func ParsePublicKey(key string) (crypto.PublicKey, error) {
+	key = strings.TrimSpace(key)
+	if !strings.HasPrefix(key, "-----BEGIN") {
+		data, err := os.ReadFile(key)
+		if err != nil {
+			return nil, fmt.Errorf("read public key: %w", err)
+		}
+		key = string(data)
+	}
+
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("public key is not PEM encoded")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PEM public key: %w", err)
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey, *rsa.PublicKey:
+		return pub, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", pub)
+}
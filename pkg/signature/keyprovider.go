@@ -0,0 +1,322 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 密钥提供者类型
+const (
+	KeyProviderFile   = "file"   // 本地 PEM 文件
+	KeyProviderPKCS11 = "pkcs11" // HSM、YubiKey 等 PKCS#11 设备，密钥不可导出
+	KeyProviderAWSKMS = "awskms" // AWS KMS 非对称密钥
+	KeyProviderGCPKMS = "gcpkms" // Google Cloud KMS 非对称密钥
+)
+
+var (
+	// ErrKeyNotFound is returned for keys the provider does not hold.
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrKeyExists is returned when generating a key whose ID is taken.
+	ErrKeyExists = errors.New("key already exists")
+	// ErrInvalidKeyID is returned for key IDs the provider cannot hold.
+	ErrInvalidKeyID = errors.New("invalid key id")
+	// ErrKeyOperationUnsupported is returned for operations a provider
+	// cannot do, e.g. generating keys in a cloud KMS, which are created
+	// with the tools of the KMS instead.
+	ErrKeyOperationUnsupported = errors.New("key operation is not supported by the provider")
+)
+
+// KeyProvider holds signing keys. Signers of the hardware and cloud
+// providers sign inside the device or service, the private key never
+// leaves it.
+type KeyProvider interface {
+	// Name returns the provider type, e.g. file or pkcs11.
+	Name() string
+	// Signer returns the signer of the key keyID.
+	Signer(keyID string) (crypto.Signer, error)
+	// ListKeys returns the IDs of the keys the provider holds.
+	ListKeys() ([]string, error)
+	// GenerateKey creates the key keyID.
+	GenerateKey(keyID string) (crypto.Signer, error)
+}
+
+// KeyProviderConfig selects and configures a key provider.
+type KeyProviderConfig struct {
+	Provider string // file（默认）、pkcs11、awskms、gcpkms
+	KeysDir  string // file 提供者的密钥目录
+	PKCS11   PKCS11Config
+	AWSKMS   AWSKMSConfig
+	GCPKMS   GCPKMSConfig
+}
+
+// PKCS11Config locates the token of a PKCS#11 module. Keys are the private
+// key objects of the token, identified by their label.
+type PKCS11Config struct {
+	Module     string // 模块路径，如 /usr/lib/softhsm/libsofthsm2.so
+	TokenLabel string // 令牌标签，为空时使用 Slot
+	Slot       int
+	PIN        string
+}
+
+// AWSKMSConfig configures the AWS KMS provider. Credentials are read from
+// the standard AWS_* environment variables when not set here.
+type AWSKMSConfig struct {
+	Region          string
+	Endpoint        string // 默认 https://kms.<region>.amazonaws.com
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// GCPKMSConfig configures the Google Cloud KMS provider. Without a
+// credentials file the token of the metadata server is used.
+type GCPKMSConfig struct {
+	CredentialsFile string // 服务账号 JSON 密钥文件
+	Endpoint        string // 默认 https://cloudkms.googleapis.com
+}
+
+// NewKeyProvider creates the key provider selected by cfg.
+func NewKeyProvider(cfg KeyProviderConfig) (KeyProvider, error) {
+	var (
+		p   KeyProvider
+		err error
+	)
+	// 逐个赋值，避免出错时返回持有 nil 指针的接口
+	switch cfg.Provider {
+	case "", KeyProviderFile:
+		var f *FileKeyProvider
+		if f, err = NewFileKeyProvider(cfg.KeysDir); err == nil {
+			p = f
+		}
+	case KeyProviderPKCS11:
+		p, err = NewPKCS11KeyProvider(cfg.PKCS11)
+	case KeyProviderAWSKMS:
+		var a *AWSKMSKeyProvider
+		if a, err = NewAWSKMSKeyProvider(cfg.AWSKMS); err == nil {
+			p = a
+		}
+	case KeyProviderGCPKMS:
+		var g *GCPKMSKeyProvider
+		if g, err = NewGCPKMSKeyProvider(cfg.GCPKMS); err == nil {
+			p = g
+		}
+	default:
+		err = fmt.Errorf("unknown key provider %q", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// KeyInfo describes a key without its private part.
+type KeyInfo struct {
+	ID        string `json:"id"`
+	Provider  string `json:"provider"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"` // PEM
+}
+
+// DescribeKey returns the public information of the key keyID.
+func DescribeKey(p KeyProvider, keyID string) (*KeyInfo, error) {
+	signer, err := p.Signer(keyID)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &KeyInfo{
+		ID:        keyID,
+		Provider:  p.Name(),
+		Algorithm: KeyAlgorithm(signer.Public()),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+	}, nil
+}
+
+// KeyAlgorithm names the signature algorithm of a public key, e.g.
+// ECDSA-P256-SHA256.
+func KeyAlgorithm(pub crypto.PublicKey) string {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + strings.ReplaceAll(key.Curve.Params().Name, "-", "") + "-SHA256"
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d-SHA256", key.N.BitLen())
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return "unknown"
+}
+
+// SignMessage signs message with signer: Ed25519 over the message, other
+// keys over its SHA-256. The signature is checked by VerifyMessage.
+func SignMessage(signer crypto.Signer, message []byte) ([]byte, error) {
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		return signer.Sign(rand.Reader, message, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(message)
+	return signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// VerifyMessage reports whether sig is a signature of message by pub, as
+// made by SignMessage.
+func VerifyMessage(pub crypto.PublicKey, message, sig []byte) bool {
+	return verifyMessage(pub, message, sig)
+}
+
+// remoteSigner is a crypto.Signer whose key is held by a device or
+// service, signing digests through sign.
+type remoteSigner struct {
+	pub  crypto.PublicKey
+	sign func(digest []byte, opts crypto.SignerOpts) ([]byte, error)
+}
+
+func (s *remoteSigner) Public() crypto.PublicKey { return s.pub }
+
+func (s *remoteSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.sign(digest, opts)
+}
+
+// keyIDPattern restricts key IDs of the file provider to safe file names.
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// FileKeyProvider keeps PEM private keys as <dir>/<id>.pem.
+type FileKeyProvider struct {
+	dir     string
+	mu      sync.Mutex
+	signers map[string]crypto.Signer
+}
+
+// NewFileKeyProvider creates a provider of the keys in dir.
+func NewFileKeyProvider(dir string) (*FileKeyProvider, error) {
+	if dir == "" {
+		return nil, errors.New("keys directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create keys directory: %w", err)
+	}
+	return &FileKeyProvider{dir: dir, signers: make(map[string]crypto.Signer)}, nil
+}
+
+// Name implements KeyProvider.
+func (p *FileKeyProvider) Name() string { return KeyProviderFile }
+
+// Signer implements KeyProvider.
+func (p *FileKeyProvider) Signer(keyID string) (crypto.Signer, error) {
+	if !keyIDPattern.MatchString(keyID) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidKeyID, keyID)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if signer, ok := p.signers[keyID]; ok {
+		return signer, nil
+	}
+
+	data, err := os.ReadFile(p.keyPath(keyID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, keyID)
+		}
+		return nil, err
+	}
+	signer, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", keyID, err)
+	}
+	p.signers[keyID] = signer
+	return signer, nil
+}
+
+// ListKeys implements KeyProvider.
+func (p *FileKeyProvider) ListKeys() ([]string, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".pem")
+		if ok && !entry.IsDir() && keyIDPattern.MatchString(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// GenerateKey implements KeyProvider, creating an ECDSA P-256 key. An
+// existing key is never overwritten.
+func (p *FileKeyProvider) GenerateKey(keyID string) (crypto.Signer, error) {
+	if !keyIDPattern.MatchString(keyID) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidKeyID, keyID)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.keyPath(keyID), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrKeyExists, keyID)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return nil, err
+	}
+	p.signers[keyID] = key
+	return key, nil
+}
+
+func (p *FileKeyProvider) keyPath(keyID string) string {
+	return filepath.Join(p.dir, keyID+".pem")
+}
+
+// parsePrivateKey parses a PEM private key: PKCS #8, SEC 1 (EC) or
+// PKCS #1 (RSA).
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("private key is not PEM encoded")
+	}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,504 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// kmsTimeout bounds each request to a cloud KMS.
+const kmsTimeout = 30 * time.Second
+
+// hashBits returns the size in bits of the SHA-2 hashes KMS keys sign.
+func hashBits(h crypto.Hash) (int, error) {
+	switch h {
+	case crypto.SHA256:
+		return 256, nil
+	case crypto.SHA384:
+		return 384, nil
+	case crypto.SHA512:
+		return 512, nil
+	}
+	return 0, fmt.Errorf("unsupported hash %v", h)
+}
+
+// AWSKMSKeyProvider signs with asymmetric AWS KMS keys, identified by key
+// ID, ARN or alias. Requests are made to the KMS JSON API with Signature
+// Version 4, without the AWS SDK.
+type AWSKMSKeyProvider struct {
+	cfg      AWSKMSConfig
+	endpoint string
+	client   *http.Client
+	mu       sync.Mutex
+	signers  map[string]crypto.Signer
+}
+
+// NewAWSKMSKeyProvider creates an AWS KMS provider.
+func NewAWSKMSKeyProvider(cfg AWSKMSConfig) (*AWSKMSKeyProvider, error) {
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if cfg.Region == "" {
+		return nil, errors.New("aws kms region is not set")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("aws credentials are not set")
+	}
+
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://kms." + cfg.Region + ".amazonaws.com"
+	}
+	return &AWSKMSKeyProvider{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: kmsTimeout},
+		signers:  make(map[string]crypto.Signer),
+	}, nil
+}
+
+// Name implements KeyProvider.
+func (p *AWSKMSKeyProvider) Name() string { return KeyProviderAWSKMS }
+
+// Signer implements KeyProvider.
+func (p *AWSKMSKeyProvider) Signer(keyID string) (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if signer, ok := p.signers[keyID]; ok {
+		return signer, nil
+	}
+
+	var out struct {
+		PublicKey string `json:"PublicKey"`
+		KeyUsage  string `json:"KeyUsage"`
+	}
+	if err := p.call("GetPublicKey", map[string]string{"KeyId": keyID}, &out); err != nil {
+		return nil, err
+	}
+	if out.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("aws kms key %s is not a signing key", keyID)
+	}
+	der, err := base64.StdEncoding.DecodeString(out.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("aws kms key %s: %w", keyID, err)
+	}
+
+	signer := &remoteSigner{pub: pub}
+	signer.sign = func(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+		algorithm, err := awsSigningAlgorithm(pub, opts)
+		if err != nil {
+			return nil, err
+		}
+		var out struct {
+			Signature string `json:"Signature"`
+		}
+		in := map[string]string{
+			"KeyId":            keyID,
+			"Message":          base64.StdEncoding.EncodeToString(digest),
+			"MessageType":      "DIGEST",
+			"SigningAlgorithm": algorithm,
+		}
+		if err := p.call("Sign", in, &out); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(out.Signature)
+	}
+	p.signers[keyID] = signer
+	return signer, nil
+}
+
+// ListKeys implements KeyProvider. Keys of the account are not listed,
+// they are not all meant for the registry.
+func (p *AWSKMSKeyProvider) ListKeys() ([]string, error) {
+	return nil, ErrKeyOperationUnsupported
+}
+
+// GenerateKey implements KeyProvider. KMS keys are created with the AWS
+// tools, where their policy is managed too.
+func (p *AWSKMSKeyProvider) GenerateKey(string) (crypto.Signer, error) {
+	return nil, ErrKeyOperationUnsupported
+}
+
+// awsSigningAlgorithm maps a key and the signer options to a KMS signing
+// algorithm, e.g. ECDSA_SHA_256.
+func awsSigningAlgorithm(pub crypto.PublicKey, opts crypto.SignerOpts) (string, error) {
+	bits, err := hashBits(opts.HashFunc())
+	if err != nil {
+		return "", err
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA_SHA_%d", bits), nil
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return fmt.Sprintf("RSASSA_PSS_SHA_%d", bits), nil
+		}
+		return fmt.Sprintf("RSASSA_PKCS1_V1_5_SHA_%d", bits), nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
+
+// call invokes the KMS action with the JSON body in, decoding the response
+// into out.
+func (p *AWSKMSKeyProvider) call(action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, p.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	p.signRequest(req, body, time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("aws kms %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(data, &e)
+		if strings.HasSuffix(e.Type, "NotFoundException") {
+			return fmt.Errorf("%w: %s", ErrKeyNotFound, e.Message)
+		}
+		return fmt.Errorf("aws kms %s: %s %s: %s", action, resp.Status, e.Type, e.Message)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// signRequest adds the Signature Version 4 authorization of req.
+func (p *AWSKMSKeyProvider) signRequest(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if p.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", p.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(body)
+	canonical := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery,
+		canonicalHeaders.String(), signedHeaders, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+
+	scope := date + "/" + p.cfg.Region + "/kms/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+	key := hmacSHA256([]byte("AWS4"+p.cfg.SecretAccessKey), date)
+	for _, part := range []string{p.cfg.Region, "kms", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		p.cfg.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// GCPKMSKeyProvider signs with Google Cloud KMS key versions, identified
+// by their resource name:
+// projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v>
+type GCPKMSKeyProvider struct {
+	endpoint string
+	client   *http.Client
+	account  *gcpServiceAccount // 为空时使用元数据服务器
+
+	mu          sync.Mutex
+	signers     map[string]crypto.Signer
+	token       string
+	tokenExpiry time.Time
+}
+
+// gcpServiceAccount is the part of a service account key file used to get
+// access tokens.
+type gcpServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+const (
+	gcpKMSScope        = "https://www.googleapis.com/auth/cloudkms"
+	gcpMetadataToken   = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	gcpDefaultTokenURI = "https://oauth2.googleapis.com/token"
+)
+
+// NewGCPKMSKeyProvider creates a Google Cloud KMS provider.
+func NewGCPKMSKeyProvider(cfg GCPKMSConfig) (*GCPKMSKeyProvider, error) {
+	endpoint := strings.TrimSuffix(cfg.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com"
+	}
+	p := &GCPKMSKeyProvider{
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: kmsTimeout},
+		signers:  make(map[string]crypto.Signer),
+	}
+
+	credentials := cfg.CredentialsFile
+	if credentials == "" {
+		credentials = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if credentials != "" {
+		data, err := os.ReadFile(credentials)
+		if err != nil {
+			return nil, fmt.Errorf("read gcp credentials: %w", err)
+		}
+		var account gcpServiceAccount
+		if err := json.Unmarshal(data, &account); err != nil {
+			return nil, fmt.Errorf("parse gcp credentials: %w", err)
+		}
+		if account.ClientEmail == "" || account.PrivateKey == "" {
+			return nil, errors.New("gcp credentials are not a service account key")
+		}
+		if account.TokenURI == "" {
+			account.TokenURI = gcpDefaultTokenURI
+		}
+		p.account = &account
+	}
+	return p, nil
+}
+
+// Name implements KeyProvider.
+func (p *GCPKMSKeyProvider) Name() string { return KeyProviderGCPKMS }
+
+// Signer implements KeyProvider.
+func (p *GCPKMSKeyProvider) Signer(keyID string) (crypto.Signer, error) {
+	if !strings.HasPrefix(keyID, "projects/") || !strings.Contains(keyID, "/cryptoKeyVersions/") {
+		return nil, fmt.Errorf("%w: gcp kms key version %q", ErrInvalidKeyID, keyID)
+	}
+	p.mu.Lock()
+	if signer, ok := p.signers[keyID]; ok {
+		p.mu.Unlock()
+		return signer, nil
+	}
+	p.mu.Unlock()
+
+	var out struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := p.call(http.MethodGet, "/v1/"+keyID+"/publicKey", nil, &out); err != nil {
+		return nil, err
+	}
+	pub, err := ParsePublicKey(out.PEM)
+	if err != nil {
+		return nil, fmt.Errorf("gcp kms key %s: %w", keyID, err)
+	}
+
+	// The algorithm of a key version is fixed, e.g. EC_SIGN_P256_SHA256 or
+	// RSA_SIGN_PSS_2048_SHA256
+	signer := &remoteSigner{pub: pub}
+	signer.sign = func(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+		bits, err := hashBits(opts.HashFunc())
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(out.Algorithm, fmt.Sprintf("_SHA%d", bits)) {
+			return nil, fmt.Errorf("gcp kms key %s signs with %s", keyID, out.Algorithm)
+		}
+		if strings.HasPrefix(out.Algorithm, "RSA_") {
+			if _, pss := opts.(*rsa.PSSOptions); pss != strings.Contains(out.Algorithm, "_PSS_") {
+				return nil, fmt.Errorf("gcp kms key %s signs with %s", keyID, out.Algorithm)
+			}
+		}
+		var resp struct {
+			Signature string `json:"signature"`
+		}
+		in := map[string]interface{}{
+			"digest": map[string]string{fmt.Sprintf("sha%d", bits): base64.StdEncoding.EncodeToString(digest)},
+		}
+		if err := p.call(http.MethodPost, "/v1/"+keyID+":asymmetricSign", in, &resp); err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(resp.Signature)
+	}
+
+	p.mu.Lock()
+	p.signers[keyID] = signer
+	p.mu.Unlock()
+	return signer, nil
+}
+
+// ListKeys implements KeyProvider. Key versions are configured by name.
+func (p *GCPKMSKeyProvider) ListKeys() ([]string, error) {
+	return nil, ErrKeyOperationUnsupported
+}
+
+// GenerateKey implements KeyProvider. Key versions are created with the
+// Google Cloud tools.
+func (p *GCPKMSKeyProvider) GenerateKey(string) (crypto.Signer, error) {
+	return nil, ErrKeyOperationUnsupported
+}
+
+// call sends a request to the KMS API with an access token.
+func (p *GCPKMSKeyProvider) call(method, path string, in, out interface{}) error {
+	token, err := p.accessToken()
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, p.endpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gcp kms: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(data, &e)
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", ErrKeyNotFound, e.Error.Message)
+		}
+		return fmt.Errorf("gcp kms: %s: %s", resp.Status, e.Error.Message)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// accessToken returns a cached OAuth2 token, from the service account or
+// the metadata server.
+func (p *GCPKMSKeyProvider) accessToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	var req *http.Request
+	if p.account != nil {
+		key, err := parsePrivateKey([]byte(p.account.PrivateKey))
+		if err != nil {
+			return "", fmt.Errorf("gcp credentials: %w", err)
+		}
+		now := time.Now()
+		assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss":   p.account.ClientEmail,
+			"scope": gcpKMSScope,
+			"aud":   p.account.TokenURI,
+			"iat":   now.Unix(),
+			"exp":   now.Add(time.Hour).Unix(),
+		}).SignedString(key)
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		req, err = http.NewRequest(http.MethodPost, p.account.TokenURI, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		var err error
+		req, err = http.NewRequest(http.MethodGet, gcpMetadataToken, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("gcp access token: %w", err)
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gcp access token: %s", resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("gcp access token: %w", err)
+	}
+	p.token = token.AccessToken
+	// 提前一分钟刷新
+	p.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return p.token, nil
+}
@@ -0,0 +1,12 @@
+//go:build !pkcs11 || !cgo || windows
+
+package signature
+
+import "errors"
+
+// NewPKCS11KeyProvider is not available in the default build, which does
+// not use CGO. Build with -tags pkcs11 and CGO_ENABLED=1 to load PKCS#11
+// modules.
+func NewPKCS11KeyProvider(PKCS11Config) (KeyProvider, error) {
+	return nil, errors.New("pkcs11 support is not built in, rebuild with -tags pkcs11 and CGO_ENABLED=1")
+}
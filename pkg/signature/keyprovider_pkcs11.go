@@ -0,0 +1,459 @@
+//go:build pkcs11 && cgo && !windows
+
+package signature
+
+/*
+#cgo linux LDFLAGS: -ldl
+#include <dlfcn.h>
+#include <stdlib.h>
+#include <string.h>
+
+typedef unsigned long CK_ULONG;
+typedef CK_ULONG CK_RV;
+typedef unsigned char CK_BYTE;
+
+typedef struct { CK_BYTE major; CK_BYTE minor; } CK_VERSION;
+typedef struct { CK_ULONG type; void *pValue; CK_ULONG ulValueLen; } CK_ATTRIBUTE;
+typedef struct { CK_ULONG mechanism; void *pParameter; CK_ULONG ulParameterLen; } CK_MECHANISM;
+typedef struct { CK_ULONG hashAlg; CK_ULONG mgf; CK_ULONG sLen; } CK_RSA_PKCS_PSS_PARAMS;
+typedef struct {
+	void *CreateMutex, *DestroyMutex, *LockMutex, *UnlockMutex;
+	CK_ULONG flags;
+	void *pReserved;
+} CK_C_INITIALIZE_ARGS;
+
+// CK_FUNCTION_LIST: the version followed by the functions in the order of
+// the specification. Only the functions up to C_Sign are used.
+typedef struct { CK_VERSION version; void *fn[44]; } p11_functions;
+
+typedef CK_RV (*fn_get_function_list)(p11_functions **);
+typedef CK_RV (*fn_initialize)(void *);
+typedef CK_RV (*fn_get_slot_list)(CK_BYTE, CK_ULONG *, CK_ULONG *);
+typedef CK_RV (*fn_get_token_info)(CK_ULONG, void *);
+typedef CK_RV (*fn_open_session)(CK_ULONG, CK_ULONG, void *, void *, CK_ULONG *);
+typedef CK_RV (*fn_login)(CK_ULONG, CK_ULONG, CK_BYTE *, CK_ULONG);
+typedef CK_RV (*fn_get_attribute_value)(CK_ULONG, CK_ULONG, CK_ATTRIBUTE *, CK_ULONG);
+typedef CK_RV (*fn_find_objects_init)(CK_ULONG, CK_ATTRIBUTE *, CK_ULONG);
+typedef CK_RV (*fn_find_objects)(CK_ULONG, CK_ULONG *, CK_ULONG, CK_ULONG *);
+typedef CK_RV (*fn_find_objects_final)(CK_ULONG);
+typedef CK_RV (*fn_sign_init)(CK_ULONG, CK_MECHANISM *, CK_ULONG);
+typedef CK_RV (*fn_sign)(CK_ULONG, CK_BYTE *, CK_ULONG, CK_BYTE *, CK_ULONG *);
+
+static int p11_load(const char *path, p11_functions **fl) {
+	void *handle = dlopen(path, RTLD_NOW | RTLD_LOCAL);
+	if (handle == NULL) return -1;
+	fn_get_function_list get = (fn_get_function_list)dlsym(handle, "C_GetFunctionList");
+	if (get == NULL) { dlclose(handle); return -2; }
+	if (get(fl) != 0 || *fl == NULL) { dlclose(handle); return -3; }
+	return 0;
+}
+
+static CK_RV p11_initialize(p11_functions *fl) {
+	CK_C_INITIALIZE_ARGS args;
+	memset(&args, 0, sizeof(args));
+	args.flags = 0x2; // CKF_OS_LOCKING_OK
+	return ((fn_initialize)fl->fn[0])(&args);
+}
+
+static CK_RV p11_get_slot_list(p11_functions *fl, CK_ULONG *slots, CK_ULONG *count) {
+	return ((fn_get_slot_list)fl->fn[4])(1, slots, count);
+}
+
+// The label is the first field of CK_TOKEN_INFO, 32 blank padded bytes
+static CK_RV p11_get_token_label(p11_functions *fl, CK_ULONG slot, char *label) {
+	unsigned char info[512];
+	CK_RV rv = ((fn_get_token_info)fl->fn[6])(slot, info);
+	if (rv == 0) memcpy(label, info, 32);
+	return rv;
+}
+
+static CK_RV p11_open_session(p11_functions *fl, CK_ULONG slot, CK_ULONG *session) {
+	return ((fn_open_session)fl->fn[12])(slot, 0x4, NULL, NULL, session); // CKF_SERIAL_SESSION
+}
+
+static CK_RV p11_login(p11_functions *fl, CK_ULONG session, char *pin, CK_ULONG len) {
+	return ((fn_login)fl->fn[18])(session, 1, (CK_BYTE *)pin, len); // CKU_USER
+}
+
+static CK_RV p11_find(p11_functions *fl, CK_ULONG session, CK_ULONG cls, void *label, CK_ULONG labelLen,
+		CK_ULONG *objects, CK_ULONG max, CK_ULONG *count) {
+	CK_ATTRIBUTE tmpl[2];
+	tmpl[0].type = 0x0; // CKA_CLASS
+	tmpl[0].pValue = &cls;
+	tmpl[0].ulValueLen = sizeof(cls);
+	tmpl[1].type = 0x3; // CKA_LABEL
+	tmpl[1].pValue = label;
+	tmpl[1].ulValueLen = labelLen;
+	CK_RV rv = ((fn_find_objects_init)fl->fn[26])(session, tmpl, label != NULL ? 2 : 1);
+	if (rv != 0) return rv;
+	rv = ((fn_find_objects)fl->fn[27])(session, objects, max, count);
+	((fn_find_objects_final)fl->fn[28])(session);
+	return rv;
+}
+
+static CK_RV p11_get_attribute(p11_functions *fl, CK_ULONG session, CK_ULONG object, CK_ULONG type,
+		void *value, CK_ULONG *len) {
+	CK_ATTRIBUTE attr;
+	attr.type = type;
+	attr.pValue = value;
+	attr.ulValueLen = *len;
+	CK_RV rv = ((fn_get_attribute_value)fl->fn[24])(session, object, &attr, 1);
+	*len = attr.ulValueLen;
+	return rv;
+}
+
+static CK_RV p11_sign(p11_functions *fl, CK_ULONG session, CK_ULONG key, CK_ULONG mechanism,
+		CK_ULONG pssHash, CK_ULONG pssMGF, CK_ULONG pssSalt,
+		CK_BYTE *data, CK_ULONG dataLen, CK_BYTE *sig, CK_ULONG *sigLen) {
+	CK_RSA_PKCS_PSS_PARAMS params = { pssHash, pssMGF, pssSalt };
+	CK_MECHANISM mech = { mechanism, NULL, 0 };
+	if (mechanism == 0xd) { // CKM_RSA_PKCS_PSS
+		mech.pParameter = &params;
+		mech.ulParameterLen = sizeof(params);
+	}
+	CK_RV rv = ((fn_sign_init)fl->fn[42])(session, &mech, key);
+	if (rv != 0) return rv;
+	return ((fn_sign)fl->fn[43])(session, data, dataLen, sig, sigLen);
+}
+*/
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// PKCS#11 常量
+const (
+	ckrUserAlreadyLoggedIn       = 0x100
+	ckrCryptokiAlreadyInitialize = 0x191
+
+	ckoPublicKey  = 2
+	ckoPrivateKey = 3
+
+	ckaKeyType        = 0x100
+	ckaModulus        = 0x120
+	ckaPublicExponent = 0x122
+	ckaECParams       = 0x180
+	ckaECPoint        = 0x181
+
+	ckkRSA = 0x0
+	ckkEC  = 0x3
+
+	ckmRSAPKCS    = 0x1
+	ckmRSAPKCSPSS = 0xd
+	ckmECDSA      = 0x1041
+)
+
+// PKCS11KeyProvider signs with the private keys of a PKCS#11 token, such
+// as an HSM or a YubiKey. Keys are identified by their label and created
+// with the tools of the token, e.g. pkcs11-tool or ykman; they are never
+// exported. One session is shared, signing is serialized.
+type PKCS11KeyProvider struct {
+	mu      sync.Mutex
+	fl      *C.p11_functions
+	session C.CK_ULONG
+	signers map[string]crypto.Signer
+}
+
+// NewPKCS11KeyProvider loads the module of cfg and logs in to its token.
+func NewPKCS11KeyProvider(cfg PKCS11Config) (KeyProvider, error) {
+	if cfg.Module == "" {
+		return nil, errors.New("pkcs11 module is not set")
+	}
+	p := &PKCS11KeyProvider{signers: make(map[string]crypto.Signer)}
+
+	path := C.CString(cfg.Module)
+	defer C.free(unsafe.Pointer(path))
+	switch C.p11_load(path, &p.fl) {
+	case 0:
+	case -1:
+		return nil, fmt.Errorf("load pkcs11 module %s: %s", cfg.Module, C.GoString(C.dlerror()))
+	default:
+		return nil, fmt.Errorf("%s is not a pkcs11 module", cfg.Module)
+	}
+	if rv := C.p11_initialize(p.fl); rv != 0 && rv != ckrCryptokiAlreadyInitialize {
+		return nil, pkcs11Error("C_Initialize", rv)
+	}
+
+	slot, err := p.findSlot(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if rv := C.p11_open_session(p.fl, slot, &p.session); rv != 0 {
+		return nil, pkcs11Error("C_OpenSession", rv)
+	}
+	if cfg.PIN != "" {
+		pin := C.CString(cfg.PIN)
+		defer C.free(unsafe.Pointer(pin))
+		if rv := C.p11_login(p.fl, p.session, pin, C.CK_ULONG(len(cfg.PIN))); rv != 0 && rv != ckrUserAlreadyLoggedIn {
+			return nil, pkcs11Error("C_Login", rv)
+		}
+	}
+	return p, nil
+}
+
+// findSlot returns the slot of the token labelled cfg.TokenLabel, or
+// cfg.Slot when no label is set.
+func (p *PKCS11KeyProvider) findSlot(cfg PKCS11Config) (C.CK_ULONG, error) {
+	if cfg.TokenLabel == "" {
+		return C.CK_ULONG(cfg.Slot), nil
+	}
+	var count C.CK_ULONG
+	if rv := C.p11_get_slot_list(p.fl, nil, &count); rv != 0 {
+		return 0, pkcs11Error("C_GetSlotList", rv)
+	}
+	if count == 0 {
+		return 0, errors.New("no pkcs11 token present")
+	}
+	slots := make([]C.CK_ULONG, count)
+	if rv := C.p11_get_slot_list(p.fl, &slots[0], &count); rv != 0 {
+		return 0, pkcs11Error("C_GetSlotList", rv)
+	}
+	var label [32]C.char
+	for _, slot := range slots[:count] {
+		if C.p11_get_token_label(p.fl, slot, &label[0]) != 0 {
+			continue
+		}
+		if strings.TrimRight(C.GoStringN(&label[0], 32), " \x00") == cfg.TokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("pkcs11 token %q not found", cfg.TokenLabel)
+}
+
+// Name implements KeyProvider.
+func (p *PKCS11KeyProvider) Name() string { return KeyProviderPKCS11 }
+
+// Signer implements KeyProvider, finding the private key labelled keyID
+// and the public key of the same label.
+func (p *PKCS11KeyProvider) Signer(keyID string) (crypto.Signer, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if signer, ok := p.signers[keyID]; ok {
+		return signer, nil
+	}
+
+	private, err := p.findObject(ckoPrivateKey, keyID)
+	if err != nil {
+		return nil, err
+	}
+	public, err := p.findObject(ckoPublicKey, keyID)
+	if err != nil {
+		// RSA private keys carry the public part
+		public = private
+	}
+	pub, err := p.publicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11 key %s: %w", keyID, err)
+	}
+
+	signer := &remoteSigner{pub: pub}
+	signer.sign = func(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+		return p.sign(private, pub, digest, opts)
+	}
+	p.signers[keyID] = signer
+	return signer, nil
+}
+
+// ListKeys implements KeyProvider, returning the labels of the private
+// keys of the token.
+func (p *PKCS11KeyProvider) ListKeys() ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var objects [64]C.CK_ULONG
+	var count C.CK_ULONG
+	if rv := C.p11_find(p.fl, p.session, ckoPrivateKey, nil, 0, &objects[0], 64, &count); rv != 0 {
+		return nil, pkcs11Error("C_FindObjects", rv)
+	}
+	var ids []string
+	for _, object := range objects[:count] {
+		label, err := p.attribute(object, 0x3) // CKA_LABEL
+		if err == nil && len(label) > 0 {
+			ids = append(ids, string(label))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// GenerateKey implements KeyProvider. Keys are generated with the tools of
+// the token, which also set their policy.
+func (p *PKCS11KeyProvider) GenerateKey(string) (crypto.Signer, error) {
+	return nil, ErrKeyOperationUnsupported
+}
+
+func (p *PKCS11KeyProvider) findObject(class C.CK_ULONG, label string) (C.CK_ULONG, error) {
+	value := C.CBytes([]byte(label))
+	defer C.free(value)
+	var object, count C.CK_ULONG
+	if rv := C.p11_find(p.fl, p.session, class, value, C.CK_ULONG(len(label)), &object, 1, &count); rv != 0 {
+		return 0, pkcs11Error("C_FindObjects", rv)
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("%w: %s", ErrKeyNotFound, label)
+	}
+	return object, nil
+}
+
+// attribute reads an attribute of object, querying its length first.
+func (p *PKCS11KeyProvider) attribute(object, typ C.CK_ULONG) ([]byte, error) {
+	var length C.CK_ULONG
+	if rv := C.p11_get_attribute(p.fl, p.session, object, typ, nil, &length); rv != 0 {
+		return nil, pkcs11Error("C_GetAttributeValue", rv)
+	}
+	if length == 0 {
+		return nil, nil
+	}
+	value := C.malloc(C.size_t(length))
+	defer C.free(value)
+	if rv := C.p11_get_attribute(p.fl, p.session, object, typ, value, &length); rv != 0 {
+		return nil, pkcs11Error("C_GetAttributeValue", rv)
+	}
+	return C.GoBytes(value, C.int(length)), nil
+}
+
+// publicKey builds the public key of an RSA or EC key object.
+func (p *PKCS11KeyProvider) publicKey(object C.CK_ULONG) (crypto.PublicKey, error) {
+	keyType, err := p.attribute(object, ckaKeyType)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyType) != int(unsafe.Sizeof(C.CK_ULONG(0))) {
+		return nil, errors.New("invalid key type attribute")
+	}
+
+	switch *(*C.CK_ULONG)(unsafe.Pointer(&keyType[0])) {
+	case ckkRSA:
+		modulus, err := p.attribute(object, ckaModulus)
+		if err != nil {
+			return nil, err
+		}
+		exponent, err := p.attribute(object, ckaPublicExponent)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(modulus),
+			E: int(new(big.Int).SetBytes(exponent).Int64()),
+		}, nil
+	case ckkEC:
+		params, err := p.attribute(object, ckaECParams)
+		if err != nil {
+			return nil, err
+		}
+		var oid asn1.ObjectIdentifier
+		if _, err := asn1.Unmarshal(params, &oid); err != nil {
+			return nil, fmt.Errorf("invalid ec params: %w", err)
+		}
+		var curve elliptic.Curve
+		switch oid.String() {
+		case "1.2.840.10045.3.1.7":
+			curve = elliptic.P256()
+		case "1.3.132.0.34":
+			curve = elliptic.P384()
+		case "1.3.132.0.35":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", oid)
+		}
+		point, err := p.attribute(object, ckaECPoint)
+		if err != nil {
+			return nil, err
+		}
+		// The point is a DER OCTET STRING, some tokens return it raw
+		var raw []byte
+		if _, err := asn1.Unmarshal(point, &raw); err == nil {
+			point = raw
+		}
+		x, y := elliptic.Unmarshal(curve, point)
+		if x == nil {
+			return nil, errors.New("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// digestInfoPrefixes are the DER DigestInfo headers CKM_RSA_PKCS signs.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// pssHashes maps hashes to the CKM_SHA* and CKG_MGF1_SHA* of PSS params.
+var pssHashes = map[crypto.Hash][2]C.CK_ULONG{
+	crypto.SHA256: {0x250, 0x2},
+	crypto.SHA384: {0x260, 0x3},
+	crypto.SHA512: {0x270, 0x4},
+}
+
+// sign signs digest with the private key object, returning signatures
+// encoded as crypto.Signer does: ASN.1 for ECDSA.
+func (p *PKCS11KeyProvider) sign(key C.CK_ULONG, pub crypto.PublicKey, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+	mechanism := C.CK_ULONG(ckmECDSA)
+	var pssHash, pssMGF, pssSalt C.CK_ULONG
+	data := digest
+
+	if _, ok := pub.(*rsa.PublicKey); ok {
+		if pss, ok := opts.(*rsa.PSSOptions); ok {
+			params, ok := pssHashes[hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash %v", hash)
+			}
+			mechanism = ckmRSAPKCSPSS
+			pssHash, pssMGF = params[0], params[1]
+			pssSalt = C.CK_ULONG(hash.Size())
+			if pss.SaltLength > 0 {
+				pssSalt = C.CK_ULONG(pss.SaltLength)
+			}
+		} else {
+			prefix, ok := digestInfoPrefixes[hash]
+			if !ok {
+				return nil, fmt.Errorf("unsupported hash %v", hash)
+			}
+			mechanism = ckmRSAPKCS
+			data = append(append([]byte{}, prefix...), digest...)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	in := C.CBytes(data)
+	defer C.free(in)
+	out := C.malloc(1024)
+	defer C.free(out)
+	length := C.CK_ULONG(1024)
+	if rv := C.p11_sign(p.fl, p.session, key, mechanism, pssHash, pssMGF, pssSalt,
+		(*C.CK_BYTE)(in), C.CK_ULONG(len(data)), (*C.CK_BYTE)(out), &length); rv != 0 {
+		return nil, pkcs11Error("C_Sign", rv)
+	}
+	sig := C.GoBytes(out, C.int(length))
+
+	if mechanism == ckmECDSA {
+		// CKM_ECDSA returns r || s
+		half := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			new(big.Int).SetBytes(sig[:half]),
+			new(big.Int).SetBytes(sig[half:]),
+		})
+	}
+	return sig, nil
+}
+
+func pkcs11Error(function string, rv C.CK_RV) error {
+	return fmt.Errorf("pkcs11 %s: CKR 0x%x", function, uint64(rv))
+}
@@ -0,0 +1,556 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Media types of Notary Project (notation) signatures.
+const (
+	NotationArtifactType = "application/vnd.cncf.notary.signature"
+	NotationJWSMediaType = "application/jose+json"
+	NotationCOSEType     = "application/cose"
+	notationPayloadType  = "application/vnd.cncf.notary.payload.v1+json"
+)
+
+// Signature verification levels of a trust policy.
+const (
+	LevelStrict     = "strict"
+	LevelPermissive = "permissive"
+	LevelAudit      = "audit"
+	LevelSkip       = "skip"
+)
+
+// Validations a level enforces, logs or skips.
+const (
+	checkIntegrity          = "integrity"
+	checkAuthenticity       = "authenticity"
+	checkAuthenticTimestamp = "authenticTimestamp"
+	checkExpiry             = "expiry"
+	checkRevocation         = "revocation"
+)
+
+const (
+	actionEnforce = "enforce"
+	actionLog     = "log"
+	actionSkip    = "skip"
+)
+
+// levelActions are the actions of the checks at each level, per the
+// Notary Project trust policy specification.
+var levelActions = map[string]map[string]string{
+	LevelStrict:     {checkIntegrity: actionEnforce, checkAuthenticity: actionEnforce, checkAuthenticTimestamp: actionEnforce, checkExpiry: actionEnforce, checkRevocation: actionEnforce},
+	LevelPermissive: {checkIntegrity: actionEnforce, checkAuthenticity: actionEnforce, checkAuthenticTimestamp: actionLog, checkExpiry: actionLog, checkRevocation: actionLog},
+	LevelAudit:      {checkIntegrity: actionEnforce, checkAuthenticity: actionLog, checkAuthenticTimestamp: actionLog, checkExpiry: actionLog, checkRevocation: actionLog},
+	LevelSkip:       {checkIntegrity: actionSkip, checkAuthenticity: actionSkip, checkAuthenticTimestamp: actionSkip, checkExpiry: actionSkip, checkRevocation: actionSkip},
+}
+
+// TrustPolicyDocument is a notation trust policy document
+// (trustpolicy.json).
+type TrustPolicyDocument struct {
+	Version       string        `json:"version"`
+	TrustPolicies []TrustPolicy `json:"trustPolicies"`
+}
+
+// TrustPolicy is the verification of the repositories in its scopes.
+type TrustPolicy struct {
+	Name                  string                `json:"name"`
+	RegistryScopes        []string              `json:"registryScopes"`
+	SignatureVerification SignatureVerification `json:"signatureVerification"`
+	TrustStores           []string              `json:"trustStores"`       // <type>:<name>, e.g. ca:acme
+	TrustedIdentities     []string              `json:"trustedIdentities"` // "*" or "x509.subject: C=US, O=acme"
+}
+
+// SignatureVerification is the level of a trust policy, with checks it
+// overrides.
+type SignatureVerification struct {
+	Level    string            `json:"level"`
+	Override map[string]string `json:"override,omitempty"`
+}
+
+// LoadTrustPolicy reads and validates a trust policy document.
+func LoadTrustPolicy(path string) (*TrustPolicyDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read trust policy: %w", err)
+	}
+	var doc TrustPolicyDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid trust policy: %w", err)
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Validate checks the document: unique policy names, each scope in one
+// policy, at most one wildcard policy, known levels and trust stores.
+func (d *TrustPolicyDocument) Validate() error {
+	if d.Version != "1.0" {
+		return fmt.Errorf("unsupported trust policy version %q", d.Version)
+	}
+	if len(d.TrustPolicies) == 0 {
+		return errors.New("trust policy has no policies")
+	}
+	names := map[string]bool{}
+	scopes := map[string]string{}
+	for _, p := range d.TrustPolicies {
+		if p.Name == "" || names[p.Name] {
+			return fmt.Errorf("trust policy name %q is empty or duplicated", p.Name)
+		}
+		names[p.Name] = true
+		if len(p.RegistryScopes) == 0 {
+			return fmt.Errorf("trust policy %s has no registry scopes", p.Name)
+		}
+		for _, scope := range p.RegistryScopes {
+			if scope == "*" && len(p.RegistryScopes) > 1 {
+				return fmt.Errorf("trust policy %s: the wildcard scope must be the only scope", p.Name)
+			}
+			if other, ok := scopes[scope]; ok {
+				return fmt.Errorf("registry scope %s is in trust policies %s and %s", scope, other, p.Name)
+			}
+			scopes[scope] = p.Name
+		}
+
+		actions, ok := levelActions[p.SignatureVerification.Level]
+		if !ok {
+			return fmt.Errorf("trust policy %s: unknown level %q", p.Name, p.SignatureVerification.Level)
+		}
+		for check, action := range p.SignatureVerification.Override {
+			if _, ok := actions[check]; !ok || check == checkIntegrity {
+				return fmt.Errorf("trust policy %s: cannot override %q", p.Name, check)
+			}
+			if action != actionEnforce && action != actionLog && action != actionSkip {
+				return fmt.Errorf("trust policy %s: unknown action %q", p.Name, action)
+			}
+		}
+		if p.SignatureVerification.Level == LevelSkip {
+			continue
+		}
+
+		if len(p.TrustStores) == 0 || len(p.TrustedIdentities) == 0 {
+			return fmt.Errorf("trust policy %s needs trust stores and trusted identities", p.Name)
+		}
+		for _, store := range p.TrustStores {
+			storeType, name, ok := strings.Cut(store, ":")
+			if !ok || name == "" || (storeType != "ca" && storeType != "signingAuthority") {
+				return fmt.Errorf("trust policy %s: invalid trust store %q", p.Name, store)
+			}
+		}
+		for _, identity := range p.TrustedIdentities {
+			if identity == "*" {
+				if len(p.TrustedIdentities) > 1 {
+					return fmt.Errorf("trust policy %s: the wildcard identity must be the only identity", p.Name)
+				}
+				continue
+			}
+			dn, ok := strings.CutPrefix(identity, "x509.subject:")
+			if !ok {
+				return fmt.Errorf("trust policy %s: invalid trusted identity %q", p.Name, identity)
+			}
+			if _, err := parseDN(dn); err != nil {
+				return fmt.Errorf("trust policy %s: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns the policy whose scopes include the repository, or the
+// wildcard policy. Scopes are <registry>/<repository> as notation expects;
+// the registry host is not compared, it depends on how clients reach the
+// registry.
+func (d *TrustPolicyDocument) PolicyFor(repository string) *TrustPolicy {
+	var wildcard *TrustPolicy
+	for i := range d.TrustPolicies {
+		p := &d.TrustPolicies[i]
+		for _, scope := range p.RegistryScopes {
+			if scope == "*" {
+				wildcard = p
+			} else if scopeRepository(scope) == repository {
+				return p
+			}
+		}
+	}
+	return wildcard
+}
+
+// scopeRepository strips the registry host of a scope, following the
+// reference grammar: the first component is a host when it contains a dot
+// or a port, or is localhost.
+func scopeRepository(scope string) string {
+	host, rest, ok := strings.Cut(scope, "/")
+	if ok && (strings.ContainsAny(host, ".:") || host == "localhost") {
+		return rest
+	}
+	return scope
+}
+
+// action returns what the policy does with a check.
+func (p *TrustPolicy) action(check string) string {
+	if action, ok := p.SignatureVerification.Override[check]; ok {
+		return action
+	}
+	return levelActions[p.SignatureVerification.Level][check]
+}
+
+// NotationResult is the outcome of verifying a notation signature.
+type NotationResult struct {
+	Verified    bool       `json:"verified"`
+	Policy      string     `json:"policy"`
+	Level       string     `json:"level"`
+	Signer      string     `json:"signer,omitempty"` // subject of the signing certificate
+	SigningTime *time.Time `json:"signing_time,omitempty"`
+	Warnings    []string   `json:"warnings,omitempty"` // failed checks the policy only logs
+	Error       string     `json:"error,omitempty"`
+}
+
+// NotationVerifier verifies notation signatures against a trust policy and
+// the certificates of a trust store directory laid out as notation does,
+// x509/<type>/<name>/*.pem.
+type NotationVerifier struct {
+	policy     *TrustPolicyDocument
+	trustStore string
+}
+
+// NewNotationVerifier loads the trust policy document.
+func NewNotationVerifier(policyPath, trustStore string) (*NotationVerifier, error) {
+	policy, err := LoadTrustPolicy(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &NotationVerifier{policy: policy, trustStore: trustStore}, nil
+}
+
+// Policy returns the policy applied to a repository, nil if none applies.
+func (v *NotationVerifier) Policy(repository string) *TrustPolicy {
+	return v.policy.PolicyFor(repository)
+}
+
+// notationJWS is a notation JWS envelope in flattened JSON serialization.
+type notationJWS struct {
+	Payload   string `json:"payload"`
+	Protected string `json:"protected"`
+	Header    struct {
+		X5C []string `json:"x5c"`
+	} `json:"header"`
+	Signature string `json:"signature"`
+}
+
+// Verify checks a signature envelope of the manifest digest in repository
+// against the policy of the repository.
+func (v *NotationVerifier) Verify(repository, digest, mediaType string, envelope []byte) *NotationResult {
+	policy := v.policy.PolicyFor(repository)
+	if policy == nil {
+		return &NotationResult{Error: "no trust policy applies to " + repository}
+	}
+	result := &NotationResult{Policy: policy.Name, Level: policy.SignatureVerification.Level}
+	if policy.SignatureVerification.Level == LevelSkip {
+		result.Verified = true
+		return result
+	}
+
+	// fail applies the action of the policy to a failed check and reports
+	// whether verification stops.
+	fail := func(check, msg string) bool {
+		switch policy.action(check) {
+		case actionEnforce:
+			result.Error = msg
+			return true
+		case actionLog:
+			result.Warnings = append(result.Warnings, msg)
+		}
+		return false
+	}
+
+	if mediaType == NotationCOSEType {
+		result.Error = "COSE signature envelopes are not supported"
+		return result
+	}
+	var jws notationJWS
+	if err := json.Unmarshal(envelope, &jws); err != nil {
+		result.Error = "invalid signature envelope"
+		return result
+	}
+	var header struct {
+		Alg           string   `json:"alg"`
+		Cty           string   `json:"cty"`
+		Crit          []string `json:"crit"`
+		SigningScheme string   `json:"io.cncf.notary.signingScheme"`
+		SigningTime   string   `json:"io.cncf.notary.signingTime"`
+		Expiry        string   `json:"io.cncf.notary.expiry"`
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(jws.Protected)
+	if err != nil || json.Unmarshal(protected, &header) != nil || header.Cty != notationPayloadType {
+		result.Error = "invalid signature protected header"
+		return result
+	}
+
+	// Integrity: the envelope is signed by the leaf certificate and is
+	// about the manifest digest
+	certs, err := parseCertificates(jws.Header.X5C)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	leaf := certs[0]
+	result.Signer = leaf.Subject.String()
+	sig, err := base64.RawURLEncoding.DecodeString(jws.Signature)
+	if err != nil {
+		result.Error = "invalid signature encoding"
+		return result
+	}
+	if err := verifyJWS(header.Alg, leaf.PublicKey, []byte(jws.Protected+"."+jws.Payload), sig); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		result.Error = "invalid signature payload"
+		return result
+	}
+	var target struct {
+		TargetArtifact struct {
+			Digest string `json:"digest"`
+		} `json:"targetArtifact"`
+	}
+	if err := json.Unmarshal(payload, &target); err != nil || target.TargetArtifact.Digest != digest {
+		result.Error = "signature is not for " + digest
+		return result
+	}
+
+	signingTime, err := time.Parse(time.RFC3339, header.SigningTime)
+	if err != nil {
+		result.Error = "invalid signing time"
+		return result
+	}
+	result.SigningTime = &signingTime
+
+	// Authenticity: the chain ends at a trust store certificate and the
+	// signer is a trusted identity
+	if policy.action(checkAuthenticity) != actionSkip {
+		if msg := v.checkAuthenticity(policy, certs, signingTime, header.SigningScheme); msg != "" && fail(checkAuthenticity, msg) {
+			return result
+		}
+	}
+
+	// Expiry of the signature
+	if header.Expiry != "" && policy.action(checkExpiry) != actionSkip {
+		expiry, err := time.Parse(time.RFC3339, header.Expiry)
+		if err != nil || time.Now().After(expiry) {
+			if fail(checkExpiry, "signature expired at "+header.Expiry) {
+				return result
+			}
+		}
+	}
+
+	// Authentic timestamp: without a timestamp authority the certificates
+	// must be valid now, not only when signing
+	if policy.action(checkAuthenticTimestamp) != actionSkip {
+		now := time.Now()
+		for _, cert := range certs {
+			if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+				if fail(checkAuthenticTimestamp, "certificate "+cert.Subject.String()+" is not valid now") {
+					return result
+				}
+				break
+			}
+		}
+	}
+
+	// Revocation needs OCSP or CRL access, which is not checked
+	if policy.action(checkRevocation) != actionSkip {
+		result.Warnings = append(result.Warnings, "certificate revocation is not checked")
+	}
+
+	result.Verified = true
+	return result
+}
+
+// checkAuthenticity returns why the certificate chain or the signer is not
+// trusted by the policy, "" when it is.
+func (v *NotationVerifier) checkAuthenticity(policy *TrustPolicy, certs []*x509.Certificate, signingTime time.Time, scheme string) string {
+	roots := x509.NewCertPool()
+	found := false
+	for _, store := range policy.TrustStores {
+		storeType, name, _ := strings.Cut(store, ":")
+		if scheme == "notary.x509.signingAuthority" && storeType != "signingAuthority" {
+			continue
+		}
+		trusted, err := loadTrustStore(filepath.Join(v.trustStore, "x509", storeType, name))
+		if err != nil {
+			return err.Error()
+		}
+		for _, cert := range trusted {
+			roots.AddCert(cert)
+			found = true
+		}
+	}
+	if !found {
+		return "trust stores of policy " + policy.Name + " have no certificates"
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signingTime,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return "certificate chain is not trusted: " + err.Error()
+	}
+
+	for _, identity := range policy.TrustedIdentities {
+		if identity == "*" {
+			return ""
+		}
+		dn, _ := strings.CutPrefix(identity, "x509.subject:")
+		want, _ := parseDN(dn)
+		if matchesDN(certs[0].Subject, want) {
+			return ""
+		}
+	}
+	return "signer " + certs[0].Subject.String() + " is not a trusted identity"
+}
+
+// parseCertificates decodes the x5c chain, leaf first.
+func parseCertificates(x5c []string) ([]*x509.Certificate, error) {
+	if len(x5c) == 0 {
+		return nil, errors.New("signature has no certificate chain")
+	}
+	certs := make([]*x509.Certificate, 0, len(x5c))
+	for _, c := range x5c {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, errors.New("invalid certificate encoding")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// verifyJWS checks a JWS signature with the algorithms notation signs
+// with: RSASSA-PSS and ECDSA over SHA-256, SHA-384 or SHA-512.
+func verifyJWS(alg string, pub crypto.PublicKey, signingInput, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "PS256", "ES256":
+		hash = crypto.SHA256
+	case "PS384", "ES384":
+		hash = crypto.SHA384
+	case "PS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signingInput)
+	digest := h.Sum(nil)
+
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if alg[0] == 'P' && rsa.VerifyPSS(key, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		// JWS encodes ECDSA signatures as r || s
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if alg[0] == 'E' && len(sig) == 2*size {
+			r := new(big.Int).SetBytes(sig[:size])
+			s := new(big.Int).SetBytes(sig[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return errors.New("signature does not match the signing certificate")
+}
+
+// loadTrustStore reads the PEM or DER certificates of a trust store
+// directory.
+func loadTrustStore(dir string) ([]*x509.Certificate, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read trust store: %w", err)
+	}
+	var certs []*x509.Certificate
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !strings.Contains(string(data), "-----BEGIN") {
+			if cert, err := x509.ParseCertificate(data); err == nil {
+				certs = append(certs, cert)
+			}
+			continue
+		}
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in %s: %w", e.Name(), err)
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs, nil
+}
+
+// parseDN parses a distinguished name such as "C=US, O=acme, CN=signer".
+func parseDN(dn string) (map[string]string, error) {
+	attrs := map[string]string{}
+	for _, part := range strings.Split(dn, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid distinguished name %q", strings.TrimSpace(dn))
+		}
+		attrs[strings.ToUpper(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return attrs, nil
+}
+
+// matchesDN reports whether the subject has every attribute of want.
+func matchesDN(subject pkix.Name, want map[string]string) bool {
+	have, err := parseDN(subject.String())
+	if err != nil {
+		return false
+	}
+	for key, value := range want {
+		if have[key] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -2,10 +2,14 @@
 package signature
 
 import (
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
@@ -38,6 +42,9 @@ type TUFConfig struct {
 	SnapshotExpiry     time.Duration `yaml:"snapshot_expiry" json:"snapshot_expiry"`
 	TimestampExpiry    time.Duration `yaml:"timestamp_expiry" json:"timestamp_expiry"`
 	ConsistentSnapshot bool          `yaml:"consistent_snapshot" json:"consistent_snapshot"`
+	// RoleKeys 按角色指定密钥提供者中的密钥，未指定的角色使用 KeysPath 中生成的密钥
+	RoleKeys    map[string]string `yaml:"role_keys" json:"role_keys"`
+	KeyProvider KeyProvider       `yaml:"-" json:"-"`
 }
 
 // DefaultTUFConfig 返回默认TUF配置
@@ -63,6 +70,7 @@ type TUFKey struct {
 	Value      TUFKeyValue       `json:"keyval"`
 	Roles      []string          `json:"-"`
 	PrivateKey *ecdsa.PrivateKey `json:"-"`
+	Signer     crypto.Signer     `json:"-"` // 密钥提供者中的密钥
 }
 
 // TUFKeyValue 密钥值
@@ -207,6 +215,15 @@ func (m *TUFManager) Initialize() error {
 	// 生成各角色密钥
 	roles := []string{RoleRoot, RoleTargets, RoleSnapshot, RoleTimestamp}
 	for _, role := range roles {
+		if m.providerKeyID(role) != "" {
+			key, err := m.providerKey(role)
+			if err != nil {
+				return fmt.Errorf("加载%s密钥失败: %w", role, err)
+			}
+			m.keys[key.ID] = key
+			m.logger.Info("使用密钥提供者中的密钥", zap.String("role", role), zap.String("keyid", key.ID[:16]))
+			continue
+		}
 		key, err := m.generateKey(role)
 		if err != nil {
 			return fmt.Errorf("生成%s密钥失败: %w", role, err)
@@ -282,6 +299,78 @@ func (m *TUFManager) generateKey(role string) (*TUFKey, error) {
 	return key, nil
 }
 
+// providerKeyID 返回角色在密钥提供者中的密钥 ID，未配置时为空
+func (m *TUFManager) providerKeyID(role string) string {
+	if m.config.KeyProvider == nil {
+		return ""
+	}
+	return m.config.RoleKeys[role]
+}
+
+// providerKey 从密钥提供者加载角色密钥，私钥不离开 HSM 或 KMS
+func (m *TUFManager) providerKey(role string) (*TUFKey, error) {
+	signer, err := m.config.KeyProvider.Signer(m.providerKeyID(role))
+	if err != nil {
+		return nil, err
+	}
+	pubBytes, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: pubBytes,
+	})
+	keyType, scheme, err := tufScheme(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(pubBytes)
+	return &TUFKey{
+		ID:     hex.EncodeToString(hash[:]),
+		Type:   keyType,
+		Scheme: scheme,
+		Value:  TUFKeyValue{Public: string(pubPEM)},
+		Roles:  []string{role},
+		Signer: signer,
+	}, nil
+}
+
+// tufScheme 返回公钥的 TUF 密钥类型和签名方案
+func tufScheme(pub crypto.PublicKey) (string, string, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return "ecdsa", "ecdsa-sha2-nistp256", nil
+		case elliptic.P384():
+			return "ecdsa", "ecdsa-sha2-nistp384", nil
+		}
+	case *rsa.PublicKey:
+		return "rsa", "rsassa-pss-sha256", nil
+	case ed25519.PublicKey:
+		return "ed25519", "ed25519", nil
+	}
+	return "", "", fmt.Errorf("不支持的密钥类型 %T", pub)
+}
+
+// signWithSigner 按签名方案用密钥提供者中的密钥签名，ECDSA 签名为 DER 编码
+func signWithSigner(key *TUFKey, data []byte) ([]byte, error) {
+	switch key.Scheme {
+	case "ed25519":
+		return key.Signer.Sign(rand.Reader, data, crypto.Hash(0))
+	case "ecdsa-sha2-nistp384":
+		hash := sha512.Sum384(data)
+		return key.Signer.Sign(rand.Reader, hash[:], crypto.SHA384)
+	case "rsassa-pss-sha256":
+		hash := sha256.Sum256(data)
+		return key.Signer.Sign(rand.Reader, hash[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	}
+	hash := sha256.Sum256(data)
+	return key.Signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
 // savePrivateKey 保存私钥
 func (m *TUFManager) savePrivateKey(key *TUFKey, role string) error {
 	privBytes, err := x509.MarshalECPrivateKey(key.PrivateKey)
@@ -573,7 +662,16 @@ func (m *TUFManager) signMeta(role string, meta interface{}) (*TUFSigned, error)
 	var signatures []TUFSignature
 	for _, key := range m.keys {
 		for _, r := range key.Roles {
-			if r == role && key.PrivateKey != nil {
+			if r == role && key.Signer != nil {
+				sig, err := signWithSigner(key, signedData)
+				if err != nil {
+					return nil, err
+				}
+				signatures = append(signatures, TUFSignature{
+					KeyID: key.ID,
+					Sig:   hex.EncodeToString(sig),
+				})
+			} else if r == role && key.PrivateKey != nil {
 				// 计算签名
 				hash := sha256.Sum256(signedData)
 				r, s, err := ecdsa.Sign(rand.Reader, key.PrivateKey, hash[:])
@@ -661,6 +759,15 @@ func (m *TUFManager) loadRepository() error {
 	// 加载密钥
 	roles := []string{RoleRoot, RoleTargets, RoleSnapshot, RoleTimestamp}
 	for _, role := range roles {
+		if m.providerKeyID(role) != "" {
+			key, err := m.providerKey(role)
+			if err != nil {
+				m.logger.Warn("加载密钥提供者中的TUF密钥失败", zap.String("role", role), zap.Error(err))
+				continue
+			}
+			m.keys[key.ID] = key
+			continue
+		}
 		privKey, err := m.loadPrivateKey(role)
 		if err != nil {
 			continue
@@ -759,8 +866,14 @@ func (m *TUFManager) RotateKey(role string) error {
 
 	m.logger.Info("轮换密钥", zap.String("role", role))
 
-	// 生成新密钥
-	newKey, err := m.generateKey(role)
+	// 生成新密钥；密钥提供者中的密钥在 HSM 或 KMS 中轮换，这里重新加载
+	var newKey *TUFKey
+	var err error
+	if m.providerKeyID(role) != "" {
+		newKey, err = m.providerKey(role)
+	} else {
+		newKey, err = m.generateKey(role)
+	}
 	if err != nil {
 		return fmt.Errorf("生成新密钥失败: %w", err)
 	}
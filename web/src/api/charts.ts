@@ -0,0 +1,59 @@
+import request from '@/utils/request'
+
+export interface ChartMaintainer {
+  name: string
+  email?: string
+  url?: string
+}
+
+export interface ChartDependency {
+  name: string
+  version?: string
+  repository?: string
+}
+
+// Chart.yaml metadata stored in the chart's config blob
+export interface ChartMetadata {
+  apiVersion?: string
+  name: string
+  version: string
+  appVersion?: string
+  kubeVersion?: string
+  description?: string
+  type?: string
+  home?: string
+  icon?: string
+  keywords?: string[]
+  sources?: string[]
+  maintainers?: ChartMaintainer[]
+  dependencies?: ChartDependency[]
+  deprecated?: boolean
+}
+
+export interface ChartVersion {
+  tag: string
+  digest: string
+  size: number
+  created_at: string
+  pushed_by?: string
+  pull_count: number
+  metadata?: ChartMetadata
+}
+
+export interface Chart {
+  repository: string
+  latest?: ChartMetadata
+  versions: ChartVersion[]
+  pull_count: number
+  updated_at: string
+}
+
+// List Helm charts pushed as OCI artifacts
+export function listCharts(q?: string) {
+  return request.get<{ data: { charts: Chart[]; total: number } }>('/api/v1/charts', { params: { q } })
+}
+
+// Get one chart with all its versions
+export function getChart(repository: string) {
+  return request.get<{ data: { chart: Chart; pull_cmd: string } }>(`/api/v1/charts/${repository}`)
+}
@@ -10,8 +10,13 @@ export interface Image {
   architecture: string
   os: string
   labels: Record<string, string>
+  annotations?: Record<string, string>
+  artifact_type?: string
+  category: ArtifactCategory
 }
 
+export type ArtifactCategory = 'image' | 'helm' | 'wasm' | 'sbom' | 'attestation' | 'signature' | 'artifact'
+
 export interface Repository {
   name: string
   description: string
@@ -63,3 +68,117 @@ export function deleteTag(repo: string, tag: string) {
 export function getManifest(repo: string, reference: string) {
   return request.get(`/v2/${encodeURIComponent(repo)}/manifests/${reference}`)
 }
+
+export interface TrashEntry {
+  id: string
+  name: string
+  tag: string
+  image: { digest: string; size: number; created_at: string; pushed_by?: string }
+  deleted_by?: string
+  deleted_at: string
+  expires_at: string
+}
+
+// List deleted tags in the recycle bin
+export function listTrash() {
+  return request.get('/api/v1/images/trash')
+}
+
+// Restore a deleted tag
+export function restoreTrash(id: string) {
+  return request.post(`/api/v1/images/trash/${id}/restore`)
+}
+
+// Permanently delete a trash entry
+export function purgeTrash(id: string) {
+  return request.delete(`/api/v1/images/trash/${id}`)
+}
+
+export type Visibility = 'private' | 'internal' | 'public'
+
+// Get repository visibility
+export function getVisibility(repo: string) {
+  return request.get(`/api/v1/repositories/${repo}/visibility`)
+}
+
+// Set repository visibility
+export function setVisibility(repo: string, visibility: Visibility) {
+  return request.put(`/api/v1/repositories/${repo}/visibility`, { visibility })
+}
+
+export interface RepositoryLink {
+  title: string
+  url: string
+}
+
+export interface RepositoryMetadata {
+  name: string
+  description: string
+  icon?: string
+  links: RepositoryLink[]
+  updated_by?: string
+  updated_at?: string
+}
+
+// Get repository description, icon and links
+export function getRepositoryMetadata(repo: string) {
+  return request.get(`/api/v1/repositories/${repo}/metadata`)
+}
+
+// Set repository description, icon and links
+export function setRepositoryMetadata(repo: string, metadata: Omit<RepositoryMetadata, 'name' | 'updated_by' | 'updated_at'>) {
+  return request.put(`/api/v1/repositories/${repo}/metadata`, metadata)
+}
+
+export interface StarredRepository {
+  name: string
+  star_count: number
+  starred_at: string
+}
+
+// List repositories starred by the current user
+export function listStarredRepositories() {
+  return request.get('/api/v1/repositories/starred')
+}
+
+// Star a repository
+export function starRepository(repo: string) {
+  return request.put(`/api/v1/repositories/${repo}/star`)
+}
+
+// Unstar a repository
+export function unstarRepository(repo: string) {
+  return request.delete(`/api/v1/repositories/${repo}/star`)
+}
+
+export interface RepositoryTransfer {
+  id: number
+  repository: string
+  target_namespace: string
+  new_name: string
+  requested_by: string
+  status: 'pending' | 'completed' | 'rejected' | 'cancelled'
+  resolved_by?: string
+  created_at: string
+  resolved_at?: string
+}
+
+// Transfer a repository to another user or organization
+export function transferRepository(repo: string, namespace: string) {
+  return request.post(`/api/v1/repositories/${repo}/transfer`, { namespace })
+}
+
+// List transfers requested by or sent to the current user
+export function listRepositoryTransfers(all = false) {
+  return request.get('/api/v1/repositories/transfers', { params: { all } })
+}
+
+// Accept a transfer sent to the current user
+export function acceptRepositoryTransfer(id: number) {
+  return request.post(`/api/v1/repositories/transfers/${id}/accept`)
+}
+
+// Reject, or cancel as the requester, a pending transfer
+export function rejectRepositoryTransfer(id: number) {
+  return request.post(`/api/v1/repositories/transfers/${id}/reject`)
+}
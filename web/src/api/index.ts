@@ -4,6 +4,7 @@ export * from './audit'
 export * from './system'
 export * from './images'
 export * from './version'
+export * from './stats'
 
 // Re-export request utility
 import request from '@/utils/request'
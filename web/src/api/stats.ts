@@ -0,0 +1,95 @@
+import request from '@/utils/request'
+
+export interface ImageStatSummary {
+  repository: string
+  tag?: string
+  pulls: number
+  pushes: number
+  bytes_served?: number
+}
+
+export interface DailyImageStat {
+  day: string
+  pulls: number
+  pushes: number
+  bytes_served: number
+}
+
+export interface ImagePush {
+  repository: string
+  tag: string
+  digest?: string
+  pushed_by?: string
+  pushed_at: string
+}
+
+export interface UserActivity {
+  username: string
+  pulls: number
+  pushes: number
+  deletes: number
+}
+
+export interface RegistryEvent {
+  id: number
+  type: 'push' | 'pull' | 'delete'
+  repository: string
+  tag?: string
+  digest?: string
+  size?: number
+  client_ip?: string
+  username?: string
+  duration_ms: number
+  created_at: string
+}
+
+export interface ImageStats {
+  days: number
+  total_pulls: number
+  total_pushes: number
+  total_deletes: number
+  bytes_served: number
+  top_pulled: ImageStatSummary[]
+  top_bandwidth: ImageStatSummary[]
+  top_users: UserActivity[]
+  recent_pushes: ImagePush[]
+  recent_events: RegistryEvent[]
+  daily: DailyImageStat[]
+  updated_at: string
+}
+
+export interface PopularImage {
+  repository: string
+  tag?: string
+  pulls: number
+  users: number
+}
+
+export type PopularWindow = '24h' | '7d' | '30d'
+
+export interface RegistryEventQuery {
+  type?: string
+  repository?: string
+  tag?: string
+  user?: string
+  ip?: string
+  since?: string
+  until?: string
+  page?: number
+  page_size?: number
+}
+
+// Get image pull/push statistics
+export function getImageStats(params?: { days?: number; limit?: number }) {
+  return request.get<ImageStats>('/api/v1/stats/images', { params })
+}
+
+// List image push/pull/delete events (admin)
+export function getRegistryEvents(params?: RegistryEventQuery) {
+  return request.get<{ events: RegistryEvent[]; total: number; page: number; page_size: number }>('/api/v1/events', { params })
+}
+
+// Get the most pulled images over a window
+export function getPopularImages(params?: { window?: PopularWindow; limit?: number }) {
+  return request.get<{ data: { window: PopularWindow; images: PopularImage[]; updated_at: string } }>('/api/v1/images/popular', { params })
+}
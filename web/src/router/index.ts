@@ -37,6 +37,12 @@ const router = createRouter({
       component: () => import('@/views/Images.vue'),
       meta: { requiresAuth: true }
     },
+    {
+      path: '/charts',
+      name: 'charts',
+      component: () => import('@/views/Charts.vue'),
+      meta: { requiresAuth: true }
+    },
     {
       path: '/accelerator',
       name: 'accelerator',
@@ -73,6 +79,12 @@ const router = createRouter({
       component: () => import('@/views/Org.vue'),
       meta: { requiresAuth: true }
     },
+    {
+      path: '/invitations',
+      name: 'invitations',
+      component: () => import('@/views/Invitations.vue'),
+      meta: { requiresAuth: true }
+    },
     {
       path: '/share',
       name: 'share',
@@ -50,10 +50,10 @@ export const useLockStore = defineStore('lock', () => {
     }
   }
 
-  async function requestUnlock(password: string): Promise<boolean> {
+  async function requestUnlock(password: string, otp?: string): Promise<boolean> {
     loading.value = true
     try {
-      await request.post('/api/v1/system/lock/unlock', { password })
+      await request.post('/api/v1/system/lock/unlock', { password, otp: otp || undefined })
       lockStatus.value = null
       return true
     } catch {